@@ -0,0 +1,2 @@
+-- Modify "projects" table
+ALTER TABLE "projects" ADD COLUMN "healthcheck_path" text NULL;
@@ -0,0 +1,2 @@
+-- Modify "domains" table
+ALTER TABLE "domains" ADD COLUMN "container_id" text NULL;
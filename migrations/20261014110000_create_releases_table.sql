@@ -0,0 +1,2 @@
+-- Create "releases" table
+CREATE TABLE "releases" ("id" uuid NOT NULL, "project_id" uuid NOT NULL, "build_id" uuid NOT NULL, "image" text NOT NULL, "config" jsonb NOT NULL DEFAULT '{}', "created_at" timestamptz NOT NULL DEFAULT now(), PRIMARY KEY ("id"), CONSTRAINT "releases_build_id_fkey" FOREIGN KEY ("build_id") REFERENCES "builds" ("id") ON UPDATE CASCADE ON DELETE CASCADE, CONSTRAINT "releases_project_id_fkey" FOREIGN KEY ("project_id") REFERENCES "projects" ("id") ON UPDATE CASCADE ON DELETE CASCADE);
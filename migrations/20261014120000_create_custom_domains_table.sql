@@ -0,0 +1,4 @@
+-- Create "custom_domains" table
+CREATE TABLE "custom_domains" ("id" uuid NOT NULL, "project_id" uuid NOT NULL, "name" text NOT NULL, "verified_at" timestamptz NULL, "created_at" timestamptz NOT NULL DEFAULT now(), "updated_at" timestamptz NOT NULL DEFAULT now(), PRIMARY KEY ("id"), CONSTRAINT "custom_domains_project_id_fkey" FOREIGN KEY ("project_id") REFERENCES "projects" ("id") ON UPDATE CASCADE ON DELETE CASCADE);
+-- Create index "custom_domains_name_key" to table: "custom_domains"
+CREATE UNIQUE INDEX "custom_domains_name_key" ON "custom_domains" ("name");
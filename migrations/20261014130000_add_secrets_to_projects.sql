@@ -0,0 +1,4 @@
+-- Modify "projects" table
+ALTER TABLE "projects" ADD COLUMN "secrets" jsonb NOT NULL DEFAULT '{}';
+-- Modify "releases" table
+ALTER TABLE "releases" ADD COLUMN "description" text NOT NULL DEFAULT '';
@@ -0,0 +1,6 @@
+-- Create enum type "run_state"
+CREATE TYPE "run_state" AS ENUM ('running', 'successful', 'failed');
+-- Create "cron_jobs" table
+CREATE TABLE "cron_jobs" ("id" uuid NOT NULL, "project_id" uuid NOT NULL, "schedule" text NOT NULL, "command" text[] NOT NULL, "next_run_at" timestamptz NOT NULL, "created_at" timestamptz NOT NULL DEFAULT now(), "updated_at" timestamptz NOT NULL DEFAULT now(), PRIMARY KEY ("id"), CONSTRAINT "cron_jobs_project_id_fkey" FOREIGN KEY ("project_id") REFERENCES "projects" ("id") ON UPDATE CASCADE ON DELETE CASCADE);
+-- Create "cron_runs" table
+CREATE TABLE "cron_runs" ("id" uuid NOT NULL, "job_id" uuid NOT NULL, "status" "run_state" NOT NULL DEFAULT 'running', "exit_code" integer NULL, "log" text NOT NULL DEFAULT '', "started_at" timestamptz NOT NULL DEFAULT now(), "finished_at" timestamptz NULL, PRIMARY KEY ("id"), CONSTRAINT "cron_runs_job_id_fkey" FOREIGN KEY ("job_id") REFERENCES "cron_jobs" ("id") ON UPDATE CASCADE ON DELETE CASCADE);
@@ -0,0 +1,8 @@
+-- Create enum type "addon_kind"
+CREATE TYPE "addon_kind" AS ENUM ('postgres');
+-- Create "addons" table
+CREATE TABLE "addons" ("id" uuid NOT NULL, "project_id" uuid NOT NULL, "kind" "addon_kind" NOT NULL, "name" text NOT NULL, "url" text NOT NULL, "connection_limit" integer NOT NULL, "created_at" timestamptz NOT NULL DEFAULT now(), PRIMARY KEY ("id"), CONSTRAINT "addons_project_id_kind_key" UNIQUE ("project_id", "kind"), CONSTRAINT "addons_project_id_fkey" FOREIGN KEY ("project_id") REFERENCES "projects" ("id") ON UPDATE CASCADE ON DELETE CASCADE);
+-- Keep the databases of existing projects, they ran with the postgres default of 100 connections
+INSERT INTO "addons" ("id", "project_id", "kind", "name", "url", "connection_limit") SELECT gen_random_uuid(), "project_id", 'postgres', "name" || '-db', "db_url", 100 FROM "domains" WHERE "db_url" IS NOT NULL AND "db_url" <> '';
+-- Modify "domains" table
+ALTER TABLE "domains" DROP COLUMN "db_url";
@@ -0,0 +1,2 @@
+-- Create "backups" table
+CREATE TABLE "backups" ("id" uuid NOT NULL, "addon_id" uuid NOT NULL, "status" "run_state" NOT NULL DEFAULT 'running', "manual" boolean NOT NULL DEFAULT false, "size" bigint NULL, "error" text NOT NULL DEFAULT '', "started_at" timestamptz NOT NULL DEFAULT now(), "finished_at" timestamptz NULL, PRIMARY KEY ("id"), CONSTRAINT "backups_addon_id_fkey" FOREIGN KEY ("addon_id") REFERENCES "addons" ("id") ON UPDATE CASCADE ON DELETE CASCADE);
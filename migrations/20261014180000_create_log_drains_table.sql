@@ -0,0 +1,2 @@
+-- Create "log_drains" table
+CREATE TABLE "log_drains" ("id" uuid NOT NULL, "project_id" uuid NOT NULL, "url" text NOT NULL, "created_at" timestamptz NOT NULL DEFAULT now(), PRIMARY KEY ("id"), CONSTRAINT "log_drains_project_id_url_key" UNIQUE ("project_id", "url"), CONSTRAINT "log_drains_project_id_fkey" FOREIGN KEY ("project_id") REFERENCES "projects" ("id") ON UPDATE CASCADE ON DELETE CASCADE);
@@ -0,0 +1,4 @@
+-- Create "container_metrics" table
+CREATE TABLE "container_metrics" ("project_id" uuid NOT NULL, "container" text NOT NULL, "process" text NOT NULL, "cpu_percent" double precision NOT NULL, "memory_bytes" bigint NOT NULL, "memory_limit" bigint NOT NULL, "network_rx" double precision NOT NULL, "network_tx" double precision NOT NULL, "restarts" integer NOT NULL, "oom_killed" boolean NOT NULL, "recorded_at" timestamptz NOT NULL DEFAULT now(), CONSTRAINT "container_metrics_project_id_fkey" FOREIGN KEY ("project_id") REFERENCES "projects" ("id") ON UPDATE CASCADE ON DELETE CASCADE);
+-- Create index "container_metrics_project_id_recorded_at_idx" to table: "container_metrics"
+CREATE INDEX "container_metrics_project_id_recorded_at_idx" ON "container_metrics" ("project_id", "recorded_at");
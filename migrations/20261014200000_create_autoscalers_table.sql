@@ -0,0 +1,6 @@
+-- Create "autoscalers" table
+CREATE TABLE "autoscalers" ("id" uuid NOT NULL, "project_id" uuid NOT NULL, "process" text NOT NULL, "min_count" integer NOT NULL, "max_count" integer NOT NULL, "metric" text NOT NULL, "target" double precision NOT NULL, "last_scaled_at" timestamptz NULL, "created_at" timestamptz NOT NULL DEFAULT now(), PRIMARY KEY ("id"), CONSTRAINT "autoscalers_project_id_process_key" UNIQUE ("project_id", "process"), CONSTRAINT "autoscalers_project_id_fkey" FOREIGN KEY ("project_id") REFERENCES "projects" ("id") ON UPDATE CASCADE ON DELETE CASCADE);
+-- Create "activities" table
+CREATE TABLE "activities" ("id" uuid NOT NULL, "project_id" uuid NOT NULL, "kind" text NOT NULL, "message" text NOT NULL, "created_at" timestamptz NOT NULL DEFAULT now(), PRIMARY KEY ("id"), CONSTRAINT "activities_project_id_fkey" FOREIGN KEY ("project_id") REFERENCES "projects" ("id") ON UPDATE CASCADE ON DELETE CASCADE);
+-- Create index "activities_project_id_created_at_idx" to table: "activities"
+CREATE INDEX "activities_project_id_created_at_idx" ON "activities" ("project_id", "created_at");
@@ -0,0 +1,2 @@
+-- Create "canaries" table
+CREATE TABLE "canaries" ("project_id" uuid NOT NULL, "build_id" uuid NOT NULL, "container_id" text NOT NULL, "ip" text NOT NULL, "port" integer NOT NULL, "image" text NOT NULL, "config" jsonb NOT NULL, "weight" integer NOT NULL, "created_at" timestamptz NOT NULL DEFAULT now(), PRIMARY KEY ("project_id"), CONSTRAINT "canaries_project_id_fkey" FOREIGN KEY ("project_id") REFERENCES "projects" ("id") ON UPDATE CASCADE ON DELETE CASCADE);
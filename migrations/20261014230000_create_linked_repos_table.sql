@@ -0,0 +1,2 @@
+-- Create "linked_repos" table
+CREATE TABLE "linked_repos" ("project_id" uuid NOT NULL, "provider" text NOT NULL, "url" text NOT NULL, "branch" text NOT NULL, "token" text NULL, "webhook_secret" text NOT NULL, "last_commit" text NULL, "last_synced_at" timestamptz NULL, "created_at" timestamptz NOT NULL DEFAULT now(), PRIMARY KEY ("project_id"), CONSTRAINT "linked_repos_project_id_fkey" FOREIGN KEY ("project_id") REFERENCES "projects" ("id") ON UPDATE CASCADE ON DELETE CASCADE);
@@ -0,0 +1,2 @@
+-- Modify "builds" table
+ALTER TABLE "builds" ADD COLUMN "commit_sha" text NULL;
@@ -0,0 +1,2 @@
+-- Create "notification_hooks" table
+CREATE TABLE "notification_hooks" ("id" uuid NOT NULL, "project_id" uuid NOT NULL, "kind" text NOT NULL, "url" text NOT NULL, "secret" text NOT NULL, "events" text[] NOT NULL, "created_at" timestamptz NOT NULL DEFAULT now(), PRIMARY KEY ("id"), CONSTRAINT "notification_hooks_project_id_url_key" UNIQUE ("project_id", "url"), CONSTRAINT "notification_hooks_project_id_fkey" FOREIGN KEY ("project_id") REFERENCES "projects" ("id") ON UPDATE CASCADE ON DELETE CASCADE);
@@ -0,0 +1,2 @@
+-- Create "registry_credentials" table
+CREATE TABLE "registry_credentials" ("project_id" uuid NOT NULL, "registry" text NOT NULL, "username" text NOT NULL, "password" text NOT NULL, "created_at" timestamptz NOT NULL DEFAULT now(), PRIMARY KEY ("project_id", "registry"), CONSTRAINT "registry_credentials_project_id_fkey" FOREIGN KEY ("project_id") REFERENCES "projects" ("id") ON UPDATE CASCADE ON DELETE CASCADE);
@@ -0,0 +1,2 @@
+-- Add value to enum type: "build_state"
+ALTER TYPE "build_state" ADD VALUE 'cancelled';
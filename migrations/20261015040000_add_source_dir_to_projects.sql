@@ -0,0 +1,2 @@
+-- Modify "projects" table
+ALTER TABLE "projects" ADD COLUMN "source_dir" text NULL, ADD COLUMN "watch_paths" text[] NOT NULL DEFAULT '{}';
@@ -0,0 +1,2 @@
+-- Modify "projects" table
+ALTER TABLE "projects" ADD COLUMN "parent_id" uuid NULL, ADD COLUMN "service" text NULL, ADD COLUMN "internal" boolean NOT NULL DEFAULT false, ADD CONSTRAINT "projects_parent_id_service_key" UNIQUE ("parent_id", "service"), ADD CONSTRAINT "projects_parent_id_fkey" FOREIGN KEY ("parent_id") REFERENCES "projects" ("id") ON UPDATE CASCADE ON DELETE SET NULL;
@@ -0,0 +1,2 @@
+-- Create "volumes" table
+CREATE TABLE "volumes" ("id" uuid NOT NULL, "project_id" uuid NOT NULL, "name" text NOT NULL, "mount_path" text NOT NULL, "size_mb" integer NOT NULL, "created_at" timestamptz NOT NULL DEFAULT now(), PRIMARY KEY ("id"), CONSTRAINT "volumes_project_id_name_key" UNIQUE ("project_id", "name"), CONSTRAINT "volumes_project_id_mount_path_key" UNIQUE ("project_id", "mount_path"), CONSTRAINT "volumes_project_id_fkey" FOREIGN KEY ("project_id") REFERENCES "projects" ("id") ON UPDATE CASCADE ON DELETE CASCADE);
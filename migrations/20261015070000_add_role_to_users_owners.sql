@@ -0,0 +1,4 @@
+-- Create enum type "member_role"
+CREATE TYPE "member_role" AS ENUM ('owner', 'maintainer', 'viewer');
+-- Modify "users_owners" table
+ALTER TABLE "users_owners" ADD COLUMN "role" "member_role" NOT NULL DEFAULT 'owner';
@@ -0,0 +1,6 @@
+-- Create enum type "token_scope"
+CREATE TYPE "token_scope" AS ENUM ('read', 'deploy', 'admin');
+-- Create "access_tokens" table
+CREATE TABLE "access_tokens" ("id" uuid NOT NULL, "user_id" uuid NOT NULL, "project_id" uuid NULL, "name" text NOT NULL, "scope" "token_scope" NOT NULL, "token_hash" text NOT NULL, "prefix" text NOT NULL, "expires_at" timestamptz NULL, "last_used_at" timestamptz NULL, "revoked_at" timestamptz NULL, "created_at" timestamptz NOT NULL DEFAULT now(), PRIMARY KEY ("id"), CONSTRAINT "access_tokens_token_hash_key" UNIQUE ("token_hash"), CONSTRAINT "access_tokens_project_id_fkey" FOREIGN KEY ("project_id") REFERENCES "projects" ("id") ON UPDATE CASCADE ON DELETE CASCADE, CONSTRAINT "access_tokens_user_id_fkey" FOREIGN KEY ("user_id") REFERENCES "users" ("id") ON UPDATE CASCADE ON DELETE CASCADE);
+-- Create index "access_tokens_user_id_idx" to table: "access_tokens"
+CREATE INDEX "access_tokens_user_id_idx" ON "access_tokens" ("user_id");
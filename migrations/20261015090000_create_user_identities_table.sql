@@ -0,0 +1,4 @@
+-- Create "user_identities" table
+CREATE TABLE "user_identities" ("id" uuid NOT NULL, "user_id" uuid NOT NULL, "issuer" text NOT NULL, "subject" text NOT NULL, "created_at" timestamptz NOT NULL DEFAULT now(), "last_login_at" timestamptz NOT NULL DEFAULT now(), PRIMARY KEY ("id"), CONSTRAINT "user_identities_issuer_subject_key" UNIQUE ("issuer", "subject"), CONSTRAINT "user_identities_user_id_fkey" FOREIGN KEY ("user_id") REFERENCES "users" ("id") ON UPDATE CASCADE ON DELETE CASCADE);
+-- Modify "users_owners" table
+ALTER TABLE "users_owners" ADD COLUMN "idp_group" text NULL;
@@ -0,0 +1,6 @@
+-- Create "audit_log" table
+CREATE TABLE "audit_log" ("id" uuid NOT NULL, "actor_id" uuid NULL, "actor" text NOT NULL, "token_id" uuid NULL, "owner" text NULL, "project_id" uuid NULL, "project" text NULL, "action" text NOT NULL, "method" text NOT NULL, "path" text NOT NULL, "status" integer NOT NULL, "ip" text NULL, "before" jsonb NULL, "after" jsonb NULL, "created_at" timestamptz NOT NULL DEFAULT now(), PRIMARY KEY ("id"), CONSTRAINT "audit_log_actor_id_fkey" FOREIGN KEY ("actor_id") REFERENCES "users" ("id") ON UPDATE CASCADE ON DELETE SET NULL, CONSTRAINT "audit_log_project_id_fkey" FOREIGN KEY ("project_id") REFERENCES "projects" ("id") ON UPDATE CASCADE ON DELETE SET NULL, CONSTRAINT "audit_log_token_id_fkey" FOREIGN KEY ("token_id") REFERENCES "access_tokens" ("id") ON UPDATE CASCADE ON DELETE SET NULL);
+-- Create index "audit_log_created_at_idx" to table: "audit_log"
+CREATE INDEX "audit_log_created_at_idx" ON "audit_log" ("created_at");
+-- Create index "audit_log_project_id_created_at_idx" to table: "audit_log"
+CREATE INDEX "audit_log_project_id_created_at_idx" ON "audit_log" ("project_id", "created_at");
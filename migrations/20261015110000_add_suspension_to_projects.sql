@@ -0,0 +1,2 @@
+-- Modify "projects" table
+ALTER TABLE "projects" ADD COLUMN "suspended_at" timestamptz NULL, ADD COLUMN "suspended_reason" text NULL;
@@ -0,0 +1,4 @@
+-- Modify "projects" table
+ALTER TABLE "projects" ADD COLUMN "memory_limit" integer NULL, ADD COLUMN "cpu_limit" double precision NULL, ADD COLUMN "disk_limit" integer NULL;
+-- Modify "users" table
+ALTER TABLE "users" ADD COLUMN "memory_limit" integer NULL, ADD COLUMN "cpu_limit" double precision NULL, ADD COLUMN "disk_limit" integer NULL;
@@ -0,0 +1,2 @@
+-- Modify "users" table
+ALTER TABLE "users" ADD COLUMN "app_quota" integer NULL, ADD COLUMN "build_quota" integer NULL, ADD COLUMN "storage_quota" integer NULL, ADD COLUMN "database_quota" integer NULL;
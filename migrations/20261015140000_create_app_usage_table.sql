@@ -0,0 +1,4 @@
+-- Create "app_usage" table
+CREATE TABLE "app_usage" ("id" uuid NOT NULL, "project_id" uuid NULL, "owner" text NOT NULL, "project" text NOT NULL, "month" date NOT NULL, "cpu_seconds" double precision NOT NULL DEFAULT 0, "memory_gib_hours" double precision NOT NULL DEFAULT 0, "egress_bytes" bigint NOT NULL DEFAULT 0, "build_minutes" double precision NOT NULL DEFAULT 0, "updated_at" timestamptz NOT NULL DEFAULT now(), PRIMARY KEY ("id"), CONSTRAINT "app_usage_project_id_month_key" UNIQUE ("project_id", "month"), CONSTRAINT "app_usage_project_id_fkey" FOREIGN KEY ("project_id") REFERENCES "projects" ("id") ON UPDATE CASCADE ON DELETE SET NULL);
+-- Create index "app_usage_month_idx" to table: "app_usage"
+CREATE INDEX "app_usage_month_idx" ON "app_usage" ("month");
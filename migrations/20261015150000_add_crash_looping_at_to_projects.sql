@@ -0,0 +1,4 @@
+-- Modify "projects" table
+ALTER TABLE "projects" ADD COLUMN "crash_looping_at" timestamptz NULL;
+-- Hooks told about crashes are told about crash loops too
+UPDATE "notification_hooks" SET "events" = array_append("events", 'container.crash_looping') WHERE 'container.crashed' = ANY("events");
@@ -0,0 +1,4 @@
+-- Modify "projects" table
+ALTER TABLE "projects" ADD COLUMN "restart_policy" text NOT NULL DEFAULT 'on-failure', ADD COLUMN "restart_retries" integer NULL;
+-- Modify "releases" table
+ALTER TABLE "releases" ADD COLUMN "exit_code" integer NULL, ADD COLUMN "exit_signal" text NULL, ADD COLUMN "oom_killed" boolean NOT NULL DEFAULT false, ADD COLUMN "exited_at" timestamptz NULL;
@@ -0,0 +1,2 @@
+-- Modify "projects" table
+ALTER TABLE "projects" ADD COLUMN "maintenance_at" timestamptz NULL, ADD COLUMN "maintenance_page" text NULL, ADD COLUMN "maintenance_retry_after" integer NOT NULL DEFAULT 300;
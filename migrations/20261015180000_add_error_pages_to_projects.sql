@@ -0,0 +1,2 @@
+-- Modify "projects" table
+ALTER TABLE "projects" ADD COLUMN "error_page" text NULL, ADD COLUMN "error_redirect" text NULL;
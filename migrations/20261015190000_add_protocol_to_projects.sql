@@ -0,0 +1,2 @@
+-- Modify "projects" table
+ALTER TABLE "projects" ADD COLUMN "protocol" text NOT NULL DEFAULT 'http1';
@@ -0,0 +1,2 @@
+-- Modify "projects" table
+ALTER TABLE "projects" ADD COLUMN "response_buffering" boolean NOT NULL DEFAULT false, ADD COLUMN "response_timeout" integer NULL;
@@ -0,0 +1,2 @@
+-- Modify "projects" table
+ALTER TABLE "projects" ADD COLUMN "rate_limit" integer NULL, ADD COLUMN "ip_rate_limit" integer NULL, ADD COLUMN "rate_burst" integer NULL;
@@ -0,0 +1,2 @@
+-- Modify "projects" table
+ALTER TABLE "projects" ADD COLUMN "allowed_ips" text[] NOT NULL DEFAULT '{}', ADD COLUMN "denied_ips" text[] NOT NULL DEFAULT '{}';
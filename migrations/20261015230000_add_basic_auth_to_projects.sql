@@ -0,0 +1,2 @@
+-- Modify "projects" table
+ALTER TABLE "projects" ADD COLUMN "basic_auth_username" text NULL, ADD COLUMN "basic_auth_password" text NULL;
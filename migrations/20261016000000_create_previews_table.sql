@@ -0,0 +1,4 @@
+-- Modify "projects" table
+ALTER TABLE "projects" ADD COLUMN "previews" boolean NOT NULL DEFAULT false, ADD COLUMN "preview_environs" jsonb NOT NULL DEFAULT '{}';
+-- Create "previews" table
+CREATE TABLE "previews" ("name" text NOT NULL, "project_id" uuid NOT NULL, "branch" text NOT NULL, "source" text NOT NULL, "build_id" uuid NOT NULL, "container_id" text NOT NULL, "port" integer NOT NULL, "expires_at" timestamptz NOT NULL, "created_at" timestamptz NOT NULL DEFAULT now(), "updated_at" timestamptz NOT NULL DEFAULT now(), PRIMARY KEY ("name"), CONSTRAINT "previews_project_id_branch_key" UNIQUE ("project_id", "branch"), CONSTRAINT "previews_project_id_fkey" FOREIGN KEY ("project_id") REFERENCES "projects" ("id") ON UPDATE CASCADE ON DELETE CASCADE);
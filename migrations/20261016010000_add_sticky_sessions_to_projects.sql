@@ -0,0 +1,2 @@
+-- Modify "projects" table
+ALTER TABLE "projects" ADD COLUMN "sticky_sessions" boolean NOT NULL DEFAULT false;
@@ -0,0 +1,2 @@
+-- Modify "projects" table
+ALTER TABLE "projects" ADD COLUMN "edge_cache" boolean NOT NULL DEFAULT false;
@@ -0,0 +1,2 @@
+-- Modify "projects" table
+ALTER TABLE "projects" ADD COLUMN "https_redirect" boolean NOT NULL DEFAULT false, ADD COLUMN "hsts_max_age" integer NULL, ADD COLUMN "hsts_preload" boolean NOT NULL DEFAULT false;
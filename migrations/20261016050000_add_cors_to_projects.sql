@@ -0,0 +1,2 @@
+-- Modify "projects" table
+ALTER TABLE "projects" ADD COLUMN "cors_origins" text[] NOT NULL DEFAULT '{}', ADD COLUMN "cors_methods" text[] NOT NULL DEFAULT '{}', ADD COLUMN "cors_headers" text[] NOT NULL DEFAULT '{}', ADD COLUMN "cors_credentials" boolean NOT NULL DEFAULT false, ADD COLUMN "cors_max_age" integer NULL;
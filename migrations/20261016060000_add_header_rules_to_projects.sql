@@ -0,0 +1,2 @@
+-- Modify "projects" table
+ALTER TABLE "projects" ADD COLUMN "header_rules" jsonb NOT NULL DEFAULT '{}';
@@ -0,0 +1,6 @@
+-- Modify "projects" table
+ALTER TABLE "projects" ADD COLUMN "access_log_sample" integer NOT NULL DEFAULT 100, ADD COLUMN "access_log_retention" integer NULL;
+-- Create "access_logs" table
+CREATE TABLE "access_logs" ("id" bigserial NOT NULL, "project_id" uuid NOT NULL, "request_id" text NOT NULL, "client_ip" text NOT NULL, "method" text NOT NULL, "path" text NOT NULL, "status" integer NOT NULL, "duration_ms" integer NOT NULL, "bytes" bigint NULL, "upstream" text NOT NULL, "recorded_at" timestamptz NOT NULL DEFAULT now(), PRIMARY KEY ("id"), CONSTRAINT "access_logs_project_id_fkey" FOREIGN KEY ("project_id") REFERENCES "projects" ("id") ON UPDATE CASCADE ON DELETE CASCADE);
+-- Create index "access_logs_project_id_recorded_at_idx" to table: "access_logs"
+CREATE INDEX "access_logs_project_id_recorded_at_idx" ON "access_logs" ("project_id", "recorded_at");
@@ -0,0 +1,2 @@
+-- Modify "projects" table
+ALTER TABLE "projects" ADD COLUMN "push_branches" text[] NOT NULL DEFAULT '{}', ADD COLUMN "push_max_size" integer NULL, ADD COLUMN "push_secret_scan" boolean NOT NULL DEFAULT false;
@@ -0,0 +1,2 @@
+-- Create "deploy_keys" table
+CREATE TABLE "deploy_keys" ("id" uuid NOT NULL, "project_id" uuid NOT NULL, "name" text NOT NULL, "public_key" text NOT NULL, "fingerprint" text NOT NULL, "read_only" boolean NOT NULL DEFAULT true, "created_at" timestamptz NOT NULL DEFAULT now(), "rotated_at" timestamptz NULL, "last_used_at" timestamptz NULL, PRIMARY KEY ("id"), CONSTRAINT "deploy_keys_fingerprint_key" UNIQUE ("fingerprint"), CONSTRAINT "deploy_keys_project_id_name_key" UNIQUE ("project_id", "name"), CONSTRAINT "deploy_keys_project_id_fkey" FOREIGN KEY ("project_id") REFERENCES "projects" ("id") ON UPDATE CASCADE ON DELETE CASCADE);
@@ -0,0 +1,2 @@
+-- Modify "projects" table
+ALTER TABLE "projects" ADD COLUMN "deploy_branch" text NULL, ADD COLUMN "deploy_promote" boolean NOT NULL DEFAULT false;
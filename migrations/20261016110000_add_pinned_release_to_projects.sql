@@ -0,0 +1,2 @@
+-- Modify "projects" table
+ALTER TABLE "projects" ADD COLUMN "pinned_release_id" uuid NULL, ADD CONSTRAINT "projects_pinned_release_id_fkey" FOREIGN KEY ("pinned_release_id") REFERENCES "releases" ("id") ON UPDATE CASCADE ON DELETE SET NULL;
@@ -0,0 +1,2 @@
+-- Modify "projects" table
+ALTER TABLE "projects" ADD COLUMN "cloneable" boolean NOT NULL DEFAULT false, ADD COLUMN "uncopied_secrets" text[] NOT NULL DEFAULT '{}', ADD COLUMN "cloned_from_id" uuid NULL, ADD CONSTRAINT "projects_cloned_from_id_fkey" FOREIGN KEY ("cloned_from_id") REFERENCES "projects" ("id") ON UPDATE CASCADE ON DELETE SET NULL;
@@ -0,0 +1,2 @@
+-- Create "templates" table
+CREATE TABLE "templates" ("id" uuid NOT NULL, "owner_id" uuid NOT NULL, "project_id" uuid NOT NULL, "name" text NOT NULL, "description" text NOT NULL DEFAULT '', "created_at" timestamptz NOT NULL DEFAULT now(), "updated_at" timestamptz NOT NULL DEFAULT now(), PRIMARY KEY ("id"), CONSTRAINT "templates_owner_id_name_key" UNIQUE ("owner_id", "name"), CONSTRAINT "templates_owner_id_fkey" FOREIGN KEY ("owner_id") REFERENCES "project_owners" ("id") ON UPDATE CASCADE ON DELETE CASCADE, CONSTRAINT "templates_project_id_fkey" FOREIGN KEY ("project_id") REFERENCES "projects" ("id") ON UPDATE CASCADE ON DELETE CASCADE);
@@ -0,0 +1,4 @@
+-- Create "nodes" table
+CREATE TABLE "nodes" ("id" uuid NOT NULL, "name" text NOT NULL, "token_hash" text NOT NULL, "docker_url" text NULL, "cpus" integer NOT NULL DEFAULT 0, "memory" integer NOT NULL DEFAULT 0, "memory_available" integer NOT NULL DEFAULT 0, "load" double precision NOT NULL DEFAULT 0, "containers" integer NOT NULL DEFAULT 0, "draining" boolean NOT NULL DEFAULT false, "last_seen_at" timestamptz NULL, "created_at" timestamptz NOT NULL DEFAULT now(), PRIMARY KEY ("id"), CONSTRAINT "nodes_name_key" UNIQUE ("name"), CONSTRAINT "nodes_token_hash_key" UNIQUE ("token_hash"));
+-- Modify "projects" table
+ALTER TABLE "projects" ADD COLUMN "node_id" uuid NULL, ADD CONSTRAINT "projects_node_id_fkey" FOREIGN KEY ("node_id") REFERENCES "nodes" ("id") ON UPDATE CASCADE ON DELETE SET NULL;
@@ -0,0 +1,2 @@
+-- Modify "nodes" table
+ALTER TABLE "nodes" ADD COLUMN "runtime" text NOT NULL DEFAULT 'docker';
@@ -0,0 +1,4 @@
+-- Create "image_scans" table
+CREATE TABLE "image_scans" ("build_id" uuid NOT NULL, "critical" integer NOT NULL DEFAULT 0, "high" integer NOT NULL DEFAULT 0, "medium" integer NOT NULL DEFAULT 0, "low" integer NOT NULL DEFAULT 0, "unknown" integer NOT NULL DEFAULT 0, "findings" jsonb NOT NULL DEFAULT '[]', "error" text NULL, "created_at" timestamptz NOT NULL DEFAULT now(), PRIMARY KEY ("build_id"), CONSTRAINT "image_scans_build_id_fkey" FOREIGN KEY ("build_id") REFERENCES "builds" ("id") ON UPDATE CASCADE ON DELETE CASCADE);
+-- Modify "projects" table
+ALTER TABLE "projects" ADD COLUMN "block_severity" text NULL;
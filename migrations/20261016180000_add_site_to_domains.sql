@@ -0,0 +1,2 @@
+-- Modify "domains" table
+ALTER TABLE "domains" ADD COLUMN "site_root" text NULL, ADD COLUMN "site_spa" boolean NOT NULL DEFAULT false;
@@ -0,0 +1,8 @@
+-- Create "user_totp" table
+CREATE TABLE "user_totp" ("user_id" uuid NOT NULL, "secret" text NOT NULL, "recovery_codes" text[] NOT NULL DEFAULT '{}', "last_step" bigint NOT NULL DEFAULT 0, "failures" integer NOT NULL DEFAULT 0, "locked_until" timestamptz NULL, "confirmed_at" timestamptz NULL, "created_at" timestamptz NOT NULL DEFAULT now(), PRIMARY KEY ("user_id"), CONSTRAINT "user_totp_user_id_fkey" FOREIGN KEY ("user_id") REFERENCES "users" ("id") ON UPDATE CASCADE ON DELETE CASCADE);
+-- Create "user_sessions" table
+CREATE TABLE "user_sessions" ("id" uuid NOT NULL, "user_id" uuid NOT NULL, "ip" text NULL, "user_agent" text NULL, "created_at" timestamptz NOT NULL DEFAULT now(), "last_seen_at" timestamptz NOT NULL DEFAULT now(), "ended_at" timestamptz NULL, PRIMARY KEY ("id"), CONSTRAINT "user_sessions_user_id_fkey" FOREIGN KEY ("user_id") REFERENCES "users" ("id") ON UPDATE CASCADE ON DELETE CASCADE);
+-- Create index "user_sessions_user_id_idx" to table: "user_sessions"
+CREATE INDEX "user_sessions_user_id_idx" ON "user_sessions" ("user_id");
+-- Create "login_hooks" table
+CREATE TABLE "login_hooks" ("id" uuid NOT NULL, "user_id" uuid NOT NULL, "kind" text NOT NULL, "url" text NOT NULL, "secret" text NOT NULL, "created_at" timestamptz NOT NULL DEFAULT now(), PRIMARY KEY ("id"), CONSTRAINT "login_hooks_user_id_url_key" UNIQUE ("user_id", "url"), CONSTRAINT "login_hooks_user_id_fkey" FOREIGN KEY ("user_id") REFERENCES "users" ("id") ON UPDATE CASCADE ON DELETE CASCADE);
@@ -0,0 +1,6 @@
+-- Create "env_groups" table
+CREATE TABLE "env_groups" ("id" uuid NOT NULL, "owner_id" uuid NOT NULL, "name" text NOT NULL, "environs" jsonb NOT NULL DEFAULT '{}', "secrets" jsonb NOT NULL DEFAULT '{}', "created_at" timestamptz NOT NULL DEFAULT now(), "updated_at" timestamptz NOT NULL DEFAULT now(), PRIMARY KEY ("id"), CONSTRAINT "env_groups_owner_id_name_key" UNIQUE ("owner_id", "name"), CONSTRAINT "env_groups_owner_id_fkey" FOREIGN KEY ("owner_id") REFERENCES "project_owners" ("id") ON UPDATE CASCADE ON DELETE CASCADE);
+-- Create "project_env_groups" table
+CREATE TABLE "project_env_groups" ("project_id" uuid NOT NULL, "group_id" uuid NOT NULL, "created_at" timestamptz NOT NULL DEFAULT now(), PRIMARY KEY ("project_id", "group_id"), CONSTRAINT "project_env_groups_project_id_fkey" FOREIGN KEY ("project_id") REFERENCES "projects" ("id") ON UPDATE CASCADE ON DELETE CASCADE, CONSTRAINT "project_env_groups_group_id_fkey" FOREIGN KEY ("group_id") REFERENCES "env_groups" ("id") ON UPDATE CASCADE ON DELETE CASCADE);
+-- Create index "project_env_groups_group_id_idx" to table: "project_env_groups"
+CREATE INDEX "project_env_groups_group_id_idx" ON "project_env_groups" ("group_id");
@@ -0,0 +1,2 @@
+-- Modify "projects" table
+ALTER TABLE "projects" ADD COLUMN "last_request_at" timestamptz NULL, ADD COLUMN "cleanup_exempt" boolean NOT NULL DEFAULT false, ADD COLUMN "cleanup_kept_at" timestamptz NULL, ADD COLUMN "cleanup_flagged_at" timestamptz NULL, ADD COLUMN "cleanup_stopped_at" timestamptz NULL;
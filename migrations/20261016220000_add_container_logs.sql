@@ -0,0 +1,6 @@
+-- Modify "projects" table
+ALTER TABLE "projects" ADD COLUMN "container_log_retention" integer NULL, ADD COLUMN "container_log_size" integer NULL;
+-- Create "container_logs" table
+CREATE TABLE "container_logs" ("id" bigserial NOT NULL, "project_id" uuid NOT NULL, "container" text NOT NULL, "process" text NOT NULL, "stream" text NOT NULL, "level" text NULL, "message" text NOT NULL, "recorded_at" timestamptz NOT NULL DEFAULT now(), PRIMARY KEY ("id"), CONSTRAINT "container_logs_project_id_fkey" FOREIGN KEY ("project_id") REFERENCES "projects" ("id") ON UPDATE CASCADE ON DELETE CASCADE);
+-- Create index "container_logs_project_id_recorded_at_idx" to table: "container_logs"
+CREATE INDEX "container_logs_project_id_recorded_at_idx" ON "container_logs" ("project_id", "recorded_at");
@@ -0,0 +1,2 @@
+-- Modify "projects" table
+ALTER TABLE "projects" ADD COLUMN "egress_policy" text NULL, ADD COLUMN "egress_allow" text[] NOT NULL DEFAULT '{}';
@@ -0,0 +1,2 @@
+-- Modify "projects" table
+ALTER TABLE "projects" ADD COLUMN "proxy_secret" text NOT NULL DEFAULT replace(gen_random_uuid()::text || gen_random_uuid()::text, '-', ''), ADD COLUMN "proxy_secret_previous" text NULL, ADD COLUMN "proxy_secret_rotated_at" timestamptz NULL;
@@ -0,0 +1,4 @@
+-- Add value to enum type: "addon_kind"
+ALTER TYPE "addon_kind" ADD VALUE 's3';
+-- Modify "addons" table
+ALTER TABLE "addons" ADD COLUMN "quota" integer NULL, ADD COLUMN "lifecycle" jsonb NOT NULL DEFAULT '[]';
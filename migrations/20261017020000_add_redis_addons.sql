@@ -0,0 +1,2 @@
+-- Add value to enum type: "addon_kind"
+ALTER TYPE "addon_kind" ADD VALUE 'redis';
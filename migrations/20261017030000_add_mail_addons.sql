@@ -0,0 +1,8 @@
+-- Add value to enum type: "addon_kind"
+ALTER TYPE "addon_kind" ADD VALUE 'mail';
+-- Create "mail_messages" table
+CREATE TABLE "mail_messages" ("id" uuid NOT NULL, "project_id" uuid NOT NULL, "sender" text NOT NULL, "recipients" integer NOT NULL, "size" integer NOT NULL, "status" text NOT NULL, "error" text NULL, "created_at" timestamptz NOT NULL DEFAULT now(), PRIMARY KEY ("id"), CONSTRAINT "mail_messages_project_id_fkey" FOREIGN KEY ("project_id") REFERENCES "projects" ("id") ON UPDATE CASCADE ON DELETE CASCADE);
+-- Create index "mail_messages_project_id_created_at_idx" to table: "mail_messages"
+CREATE INDEX "mail_messages_project_id_created_at_idx" ON "mail_messages" ("project_id", "created_at");
+-- Create "mail_suppressions" table
+CREATE TABLE "mail_suppressions" ("project_id" uuid NOT NULL, "address" text NOT NULL, "reason" text NOT NULL, "created_at" timestamptz NOT NULL DEFAULT now(), PRIMARY KEY ("project_id", "address"), CONSTRAINT "mail_suppressions_project_id_fkey" FOREIGN KEY ("project_id") REFERENCES "projects" ("id") ON UPDATE CASCADE ON DELETE CASCADE);
@@ -0,0 +1,6 @@
+-- Modify "projects" table
+ALTER TABLE "projects" ADD COLUMN "pipeline_next_id" uuid NULL, ADD COLUMN "promotion_approval" boolean NOT NULL DEFAULT false, ADD CONSTRAINT "projects_pipeline_next_id_fkey" FOREIGN KEY ("pipeline_next_id") REFERENCES "projects" ("id") ON UPDATE CASCADE ON DELETE SET NULL;
+-- Create "promotions" table
+CREATE TABLE "promotions" ("id" uuid NOT NULL, "release_id" uuid NOT NULL, "source_id" uuid NOT NULL, "target_id" uuid NOT NULL, "requested_by" uuid NOT NULL, "status" text NOT NULL DEFAULT 'pending', "decided_by" uuid NULL, "decided_at" timestamptz NULL, "created_at" timestamptz NOT NULL DEFAULT now(), PRIMARY KEY ("id"), CONSTRAINT "promotions_release_id_fkey" FOREIGN KEY ("release_id") REFERENCES "releases" ("id") ON UPDATE CASCADE ON DELETE CASCADE, CONSTRAINT "promotions_source_id_fkey" FOREIGN KEY ("source_id") REFERENCES "projects" ("id") ON UPDATE CASCADE ON DELETE CASCADE, CONSTRAINT "promotions_target_id_fkey" FOREIGN KEY ("target_id") REFERENCES "projects" ("id") ON UPDATE CASCADE ON DELETE CASCADE, CONSTRAINT "promotions_requested_by_fkey" FOREIGN KEY ("requested_by") REFERENCES "users" ("id") ON UPDATE CASCADE ON DELETE CASCADE, CONSTRAINT "promotions_decided_by_fkey" FOREIGN KEY ("decided_by") REFERENCES "users" ("id") ON UPDATE CASCADE ON DELETE SET NULL);
+-- Create index "promotions_target_id_status_idx" to table: "promotions"
+CREATE INDEX "promotions_target_id_status_idx" ON "promotions" ("target_id", "status");
@@ -0,0 +1,8 @@
+-- Modify "projects" table
+ALTER TABLE "projects" ADD COLUMN "stopped_at" timestamptz NULL;
+-- Create index "projects_created_at_idx" to table: "projects"
+CREATE INDEX "projects_created_at_idx" ON "projects" ("created_at", "id");
+-- Create index "builds_project_id_created_at_idx" to table: "builds"
+CREATE INDEX "builds_project_id_created_at_idx" ON "builds" ("project_id", "created_at", "id");
+-- Create index "releases_project_id_created_at_idx" to table: "releases"
+CREATE INDEX "releases_project_id_created_at_idx" ON "releases" ("project_id", "created_at", "id");
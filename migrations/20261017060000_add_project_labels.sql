@@ -0,0 +1,4 @@
+-- Modify "projects" table
+ALTER TABLE "projects" ADD COLUMN "labels" jsonb NOT NULL DEFAULT '{}';
+-- Create index "projects_labels_idx" to table: "projects"
+CREATE INDEX "projects_labels_idx" ON "projects" USING gin ("labels");
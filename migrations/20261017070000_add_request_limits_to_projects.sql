@@ -0,0 +1,2 @@
+-- Modify "projects" table
+ALTER TABLE "projects" ADD COLUMN "body_limit" integer NULL, ADD COLUMN "body_timeout" integer NULL;
@@ -0,0 +1,8 @@
+-- Create "port_leases" table
+CREATE TABLE "port_leases" ("id" uuid NOT NULL, "project_id" uuid NOT NULL, "protocol" text NOT NULL, "port" integer NOT NULL, "target_port" integer NOT NULL, "idle_timeout" integer NULL, "created_at" timestamptz NOT NULL DEFAULT now(), PRIMARY KEY ("id"), CONSTRAINT "port_leases_project_id_fkey" FOREIGN KEY ("project_id") REFERENCES "projects" ("id") ON UPDATE CASCADE ON DELETE CASCADE);
+-- Create index "port_leases_protocol_port_key" to table: "port_leases"
+CREATE UNIQUE INDEX "port_leases_protocol_port_key" ON "port_leases" ("protocol", "port");
+-- Create index "port_leases_project_id_idx" to table: "port_leases"
+CREATE INDEX "port_leases_project_id_idx" ON "port_leases" ("project_id");
+-- Modify "users" table
+ALTER TABLE "users" ADD COLUMN "port_quota" integer NULL;
@@ -1,5 +1,5 @@
 CREATE TYPE role AS ENUM ('admin', 'asdos', 'user');
-CREATE TYPE build_state AS ENUM ('pending', 'building', 'successful', 'failed');
+CREATE TYPE build_state AS ENUM ('pending', 'building', 'successful', 'failed', 'cancelled');
 
 CREATE TABLE users (
   id          UUID          NOT NULL,
@@ -10,6 +10,17 @@ CREATE TABLE users (
   updated_at  TIMESTAMPTZ   NOT NULL default now(),
   deleted_at  TIMESTAMPTZ,
   role        role          NOT NULL default 'user',
+  -- set by a platform admin for users with heavier apps, in MiB and cpus. the apps of
+  -- their owners get these instead of the defaults
+  memory_limit INTEGER,
+  cpu_limit   DOUBLE PRECISION,
+  disk_limit  INTEGER,
+  -- set by a platform admin, over the quota settings. storage and database in MiB
+  app_quota   INTEGER,
+  build_quota INTEGER,
+  storage_quota INTEGER,
+  database_quota INTEGER,
+  port_quota  INTEGER,
 
   PRIMARY KEY (id),
   CONSTRAINT unique_username UNIQUE (username)
@@ -26,11 +37,18 @@ CREATE TABLE project_owners (
   PRIMARY KEY (id)
 );
 
+-- owners manage members and delete apps, maintainers deploy and configure, viewers only read
+CREATE TYPE member_role AS ENUM ('owner', 'maintainer', 'viewer');
+
 -- TODO: make a way to owners must have atleast one user. posibly with trigger or better constraint
 -- for many to many relationship
 CREATE TABLE users_owners (
   user_id     UUID          NOT NULL,
   owner_id    UUID          NOT NULL,
+  role        member_role   NOT NULL DEFAULT 'owner',
+  -- the identity provider group the membership comes from, kept in sync on every sso login.
+  -- null when it was added by hand
+  idp_group   TEXT,
   created_at  TIMESTAMPTZ   NOT NULL default now(),
   updated_at  TIMESTAMPTZ   NOT NULL default now(),
   deleted_at  TIMESTAMPTZ,
@@ -45,22 +63,187 @@ CREATE TABLE projects (
   owner_id    UUID          NOT NULL,
   name        TEXT          NOT NULL,
   environs    JSONB         NOT NULL default '{"PRODUCTION": "true"}'::jsonb,
+  -- encrypted with application.secretkey, only decrypted when a container starts
+  secrets     JSONB         NOT NULL default '{}'::jsonb,
+  -- number of containers per worker process type, unlisted types run one
+  formation   JSONB         NOT NULL default '{}'::jsonb,
+  -- path probed on the new container before a deploy is considered up
+  healthcheck_path TEXT,
+  -- minutes without traffic before the web containers are stopped, null never idles
+  idle_timeout INTEGER,
+  -- directory of the repository the app is built from, null is the root
+  source_dir  TEXT,
+  -- pushes that change nothing under these are not built, empty watches source_dir
+  watch_paths TEXT[]        NOT NULL default '{}',
+  -- the project whose pemasak.toml declares this one as a service, and its name there
+  parent_id   UUID,
+  service     TEXT,
+  -- services only reachable from the other services of their app, not through the proxy
+  internal    BOOLEAN       NOT NULL default false,
+  -- set by a platform admin, a suspended app serves nothing and can't be changed
+  suspended_at TIMESTAMPTZ,
+  suspended_reason TEXT,
+  -- stopped by a member, kept suspended until a member starts it again
+  stopped_at  TIMESTAMPTZ,
+  -- key/value pairs like course=COMP301 that lists and bulk actions select apps by
+  labels      JSONB         NOT NULL default '{}'::jsonb,
+  -- the last request the proxy forwarded, written down every hour. see src/cleanup.rs
+  last_request_at TIMESTAMPTZ,
+  -- set by a platform admin, the cleanup never flags the app
+  cleanup_exempt BOOLEAN NOT NULL DEFAULT false,
+  -- a member restored the app, it counts as activity
+  cleanup_kept_at TIMESTAMPTZ,
+  -- flagged as inactive and its members told, it is stopped later unless it gets used
+  cleanup_flagged_at TIMESTAMPTZ,
+  -- stopped as a suspension by the cleanup, it is deleted later unless a member restores it
+  cleanup_stopped_at TIMESTAMPTZ,
+  -- set by a platform admin, in MiB and cpus. over the limits of the users of the owner
+  memory_limit INTEGER,
+  cpu_limit   DOUBLE PRECISION,
+  disk_limit  INTEGER,
+  -- a container of the app keeps crashing, docker stopped restarting it and the crash watcher
+  -- restarts it with a growing delay
+  crash_looping_at TIMESTAMPTZ,
+  -- always, on-failure or never. on-failure gives up after restart_retries, null never does
+  restart_policy TEXT         NOT NULL default 'on-failure',
+  restart_retries INTEGER,
+  -- the proxy answers 503 with maintenance_page, or a default one, while the app keeps running
+  maintenance_at TIMESTAMPTZ,
+  maintenance_page TEXT,
+  -- seconds in the Retry-After of the maintenance page
+  maintenance_retry_after INTEGER NOT NULL default 300,
+  -- served when the proxy can't reach the app, instead of the default page. html, or a url
+  -- visitors are redirected to
+  error_page  TEXT,
+  error_redirect TEXT,
+  -- how the proxy and the probes talk to the app: http1, or h2c for http/2 without tls like
+  -- grpc servers speak
+  protocol    TEXT          NOT NULL default 'http1',
+  -- the proxy reads whole responses before sending them on, streams excepted
+  response_buffering BOOLEAN NOT NULL default false,
+  -- seconds the proxy waits for the headers of a response, null waits as long as it takes
+  response_timeout INTEGER,
+  -- requests per second the proxy lets through to the app and from one client ip, and how
+  -- many over it at once. null keeps the ones of the platform, which are also the most allowed
+  rate_limit  INTEGER,
+  ip_rate_limit INTEGER,
+  rate_burst  INTEGER,
+  -- MiB a request body may have and seconds the client gets to send it. null keeps the ones
+  -- of the platform, which are also the most allowed
+  body_limit  INTEGER,
+  body_timeout INTEGER,
+  -- address ranges in cidr notation. the proxy answers 403 to clients in denied_ips, and to
+  -- everyone outside allowed_ips once it isn't empty
+  allowed_ips TEXT[]        NOT NULL default '{}',
+  denied_ips  TEXT[]        NOT NULL default '{}',
+  -- visitors log in with these before the proxy lets them through, the password is an argon2
+  -- hash. null lets everyone through
+  basic_auth_username TEXT,
+  basic_auth_password TEXT,
+  -- pushes to other branches than the default one deploy a preview, with preview_environs over
+  -- the environs
+  previews    BOOLEAN       NOT NULL default false,
+  preview_environs JSONB    NOT NULL default '{}'::jsonb,
+  -- the proxy keeps a client on the replica it first got through a cookie, while that one is
+  -- healthy
+  sticky_sessions BOOLEAN   NOT NULL default false,
+  -- the proxy compresses text responses for clients accepting gzip or brotli
+  compression BOOLEAN       NOT NULL default true,
+  -- the proxy keeps responses in memory for as long as their Cache-Control allows
+  edge_cache  BOOLEAN       NOT NULL default false,
+  -- plain http requests are redirected to https
+  https_redirect BOOLEAN    NOT NULL default false,
+  -- seconds of Strict-Transport-Security sent over https, NULL sends none
+  hsts_max_age INTEGER,
+  -- adds includeSubDomains and preload to Strict-Transport-Security
+  hsts_preload BOOLEAN      NOT NULL default false,
+  -- origins allowed to call the app from a browser, the proxy answers preflights and sets the
+  -- cors headers once it isn't empty
+  cors_origins TEXT[]       NOT NULL default '{}',
+  cors_methods TEXT[]       NOT NULL default '{}',
+  cors_headers TEXT[]       NOT NULL default '{}',
+  cors_credentials BOOLEAN  NOT NULL default false,
+  -- seconds browsers keep a preflight
+  cors_max_age INTEGER,
+  -- request and response headers the proxy sets, adds and removes, see
+  -- src/header_rules.rs
+  header_rules JSONB        NOT NULL default '{}'::jsonb,
+  -- percent of the requests the proxy keeps in access_logs, server errors are always kept
+  access_log_sample INTEGER NOT NULL default 100,
+  -- days access logs are kept, NULL keeps them for the default of the platform
+  access_log_retention INTEGER,
+  -- days and MiB of container output kept in container_logs, NULL for the defaults of the
+  -- platform
+  container_log_retention INTEGER,
+  container_log_size INTEGER,
+  -- where the containers may connect to: allow, campus or deny, NULL for the default of
+  -- the platform. egress_allow are ranges and hostnames reachable besides, see src/egress.rs
+  egress_policy TEXT,
+  egress_allow TEXT[]       NOT NULL default '{}',
+  -- key the proxy signs requests to the app with, the app gets it as PEMASAK_PROXY_SECRET.
+  -- the previous one keeps signing for an hour after a rotation, see src/proxy_signature.rs
+  proxy_secret TEXT         NOT NULL default replace(gen_random_uuid()::text || gen_random_uuid()::text, '-', ''),
+  proxy_secret_previous TEXT,
+  proxy_secret_rotated_at TIMESTAMPTZ,
+  -- branches besides the default one a push may update, globs like feature/*. empty allows
+  -- any, see src/push_policy.rs
+  push_branches TEXT[]      NOT NULL default '{}',
+  -- MiB a push may send, NULL leaves it to the platform
+  push_max_size INTEGER,
+  -- pushes adding lines that look like credentials are rejected
+  push_secret_scan BOOLEAN  NOT NULL default false,
+  -- branch pushes deploy from, NULL is the one HEAD points at, see src/deploy_branch.rs
+  deploy_branch TEXT,
+  -- pushes to it only build, the build waits as a canary on no requests to be promoted
+  deploy_promote BOOLEAN    NOT NULL default false,
+  -- deploys are refused until it is unpinned, the release keeps running. The key is added
+  -- after releases, see below
+  pinned_release_id UUID,
+  -- anyone signed in may clone the app into an account of their own, members who may change
+  -- it always can
+  cloneable   BOOLEAN       NOT NULL default false,
+  -- secrets left out when the app is cloned
+  uncopied_secrets TEXT[]   NOT NULL default '{}',
+  -- the app this one was cloned from
+  cloned_from_id UUID,
+  -- node of src/nodes.rs the containers of the app run on, NULL is the host of the platform.
+  -- The key is added after nodes, see below
+  node_id UUID,
+  -- deploys of images with a vulnerability this severe or worse fail, NULL only reports
+  -- them. critical, high, medium or low, see src/scanning.rs
+  block_severity TEXT,
+  -- the next stage of the pipeline of the app, promoting a release runs its image there. see
+  -- src/pipelines.rs
+  pipeline_next_id UUID,
+  -- releases promoted to the app wait in promotions until another member approves them
+  promotion_approval BOOLEAN NOT NULL default false,
   created_at  TIMESTAMPTZ   NOT NULL default now(),
   updated_at  TIMESTAMPTZ   NOT NULL default now(),
   deleted_at  TIMESTAMPTZ,
 
   PRIMARY KEY (id),
-  FOREIGN KEY (owner_id) REFERENCES project_owners(id) ON DELETE CASCADE ON UPDATE CASCADE
+  UNIQUE (parent_id, service),
+  FOREIGN KEY (owner_id) REFERENCES project_owners(id) ON DELETE CASCADE ON UPDATE CASCADE,
+  FOREIGN KEY (parent_id) REFERENCES projects(id) ON DELETE SET NULL ON UPDATE CASCADE,
+  FOREIGN KEY (cloned_from_id) REFERENCES projects(id) ON DELETE SET NULL ON UPDATE CASCADE,
+  FOREIGN KEY (pipeline_next_id) REFERENCES projects(id) ON DELETE SET NULL ON UPDATE CASCADE
 );
 
+CREATE INDEX projects_created_at_idx ON projects (created_at, id);
+CREATE INDEX projects_labels_idx ON projects USING gin (labels);
+
 CREATE TABLE domains (
   id          UUID          NOT NULL,
   project_id  UUID          NOT NULL,
   name        TEXT          NOT NULL,
   port        INTEGER       NOT NULL,
   docker_ip   TEXT          NOT NULL,
-  -- TODO: rethink if we need this on a seperate table
-  db_url      TEXT,
+  -- the container the proxy routes to, swapped when a new deploy is ready
+  container_id TEXT,
+  -- the files of a static site the proxy serves instead, no container runs then
+  site_root   TEXT,
+  -- paths that aren't a file of the site get its index.html
+  site_spa    BOOLEAN       NOT NULL DEFAULT false,
   created_at  TIMESTAMPTZ   NOT NULL default now(),
   updated_at  TIMESTAMPTZ   NOT NULL default now(),
   deleted_at  TIMESTAMPTZ,
@@ -69,6 +252,28 @@ CREATE TABLE domains (
   FOREIGN KEY (project_id) REFERENCES projects(id) ON DELETE CASCADE ON UPDATE CASCADE
 );
 
+-- an app built from a branch next to the live release, served at <branch>--<app>
+CREATE TABLE previews (
+  -- subdomain and container name
+  name TEXT NOT NULL PRIMARY KEY,
+  project_id UUID NOT NULL,
+  branch TEXT NOT NULL,
+  -- checkout of the branch the build read
+  source TEXT NOT NULL,
+  build_id UUID NOT NULL,
+
+  container_id TEXT NOT NULL,
+  port INTEGER NOT NULL,
+
+  -- torn down then, every deploy of the branch pushes it back
+  expires_at TIMESTAMPTZ NOT NULL,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+
+  UNIQUE (project_id, branch),
+  FOREIGN KEY (project_id) REFERENCES projects(id) ON DELETE CASCADE ON UPDATE CASCADE
+);
+
 CREATE TABLE api_token (
   id          UUID          NOT NULL,
   project_id  UUID          NOT NULL,
@@ -101,10 +306,692 @@ CREATE TABLE builds (
   
   status build_state NOT NULL DEFAULT 'pending',
   log TEXT NOT NULL DEFAULT '',
+  -- commit the build deployed, for rollbacks and restarts the commit of the release
+  commit_sha TEXT,
+
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  finished_at TIMESTAMPTZ,
+
+  FOREIGN KEY (project_id) REFERENCES projects(id) ON DELETE CASCADE ON UPDATE CASCADE
+);
+
+CREATE INDEX builds_project_id_created_at_idx ON builds (project_id, created_at, id);
+
+-- vulnerabilities trivy found in the image of a build, every release of the build runs that
+-- image. see src/scanning.rs
+CREATE TABLE image_scans (
+  build_id UUID NOT NULL PRIMARY KEY,
+
+  critical INTEGER NOT NULL DEFAULT 0,
+  high INTEGER NOT NULL DEFAULT 0,
+  medium INTEGER NOT NULL DEFAULT 0,
+  low INTEGER NOT NULL DEFAULT 0,
+  unknown INTEGER NOT NULL DEFAULT 0,
+  -- the vulnerabilities, the most severe first
+  findings JSONB NOT NULL DEFAULT '[]',
+  -- why the scan didn't finish, nothing was counted then
+  error TEXT,
+
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+
+  FOREIGN KEY (build_id) REFERENCES builds(id) ON DELETE CASCADE ON UPDATE CASCADE
+);
+-- successful builds that can be rolled back to
+CREATE TABLE releases (
+  id UUID NOT NULL PRIMARY KEY,
+  project_id UUID NOT NULL,
+  build_id UUID NOT NULL,
+
+  -- docker image id, the image is also tagged {container}:{build_id} to keep it around
+  image TEXT NOT NULL,
+  -- environment and command the container was started with
+  config JSONB NOT NULL DEFAULT '{}',
+  -- what made this release, a build, a rollback or an environment change
+  description TEXT NOT NULL DEFAULT '',
+  -- the last time a container of the release exited on its own, 137 with oom_killed is out of
+  -- memory, a signal is from 128 + its number
+  exit_code INTEGER,
+  exit_signal TEXT,
+  oom_killed BOOLEAN NOT NULL DEFAULT false,
+  exited_at TIMESTAMPTZ,
+
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+
+  FOREIGN KEY (project_id) REFERENCES projects(id) ON DELETE CASCADE ON UPDATE CASCADE,
+  FOREIGN KEY (build_id) REFERENCES builds(id) ON DELETE CASCADE ON UPDATE CASCADE
+);
+
+CREATE INDEX releases_project_id_created_at_idx ON releases (project_id, created_at, id);
+
+ALTER TABLE projects ADD FOREIGN KEY (pinned_release_id) REFERENCES releases(id) ON DELETE SET NULL ON UPDATE CASCADE;
+
+-- releases promoted to an app with promotion_approval, run once a member other than the one who
+-- asked approves
+CREATE TABLE promotions (
+  id UUID NOT NULL PRIMARY KEY,
+  release_id UUID NOT NULL,
+  source_id UUID NOT NULL,
+  target_id UUID NOT NULL,
+  requested_by UUID NOT NULL,
+  -- pending, approved or rejected
+  status TEXT NOT NULL DEFAULT 'pending',
+  decided_by UUID,
+  decided_at TIMESTAMPTZ,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+
+  FOREIGN KEY (release_id) REFERENCES releases(id) ON DELETE CASCADE ON UPDATE CASCADE,
+  FOREIGN KEY (source_id) REFERENCES projects(id) ON DELETE CASCADE ON UPDATE CASCADE,
+  FOREIGN KEY (target_id) REFERENCES projects(id) ON DELETE CASCADE ON UPDATE CASCADE,
+  FOREIGN KEY (requested_by) REFERENCES users(id) ON DELETE CASCADE ON UPDATE CASCADE,
+  FOREIGN KEY (decided_by) REFERENCES users(id) ON DELETE SET NULL ON UPDATE CASCADE
+);
+
+CREATE INDEX promotions_target_id_status_idx ON promotions (target_id, status);
+
+-- domains owned by users that point at a project, tls is handled by caddy on demand
+CREATE TABLE custom_domains (
+  id UUID NOT NULL PRIMARY KEY,
+  project_id UUID NOT NULL,
+  name TEXT NOT NULL UNIQUE,
+
+  -- set once dns points at the platform, only verified domains get certificates
+  verified_at TIMESTAMPTZ,
 
   created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
   updated_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+
+  FOREIGN KEY (project_id) REFERENCES projects(id) ON DELETE CASCADE ON UPDATE CASCADE
+);
+
+CREATE TYPE run_state AS ENUM ('running', 'successful', 'failed');
+
+-- commands run on a schedule in a one-off container of the live release
+CREATE TABLE cron_jobs (
+  id UUID NOT NULL PRIMARY KEY,
+  project_id UUID NOT NULL,
+
+  -- five field cron expression, evaluated in UTC
+  schedule TEXT NOT NULL,
+  command TEXT[] NOT NULL,
+  next_run_at TIMESTAMPTZ NOT NULL,
+
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+
+  FOREIGN KEY (project_id) REFERENCES projects(id) ON DELETE CASCADE ON UPDATE CASCADE
+);
+
+CREATE TABLE cron_runs (
+  id UUID NOT NULL PRIMARY KEY,
+  job_id UUID NOT NULL,
+
+  -- a job doesn't start while one of its runs is still running
+  status run_state NOT NULL DEFAULT 'running',
+  -- null while running, or when the container was killed or never started
+  exit_code INTEGER,
+  log TEXT NOT NULL DEFAULT '',
+
+  started_at TIMESTAMPTZ NOT NULL DEFAULT now(),
   finished_at TIMESTAMPTZ,
 
+  FOREIGN KEY (job_id) REFERENCES cron_jobs(id) ON DELETE CASCADE ON UPDATE CASCADE
+);
+
+CREATE TYPE addon_kind AS ENUM ('postgres', 's3', 'redis', 'mail');
+
+-- services provisioned for a project, destroyed together with it
+CREATE TABLE addons (
+  id UUID NOT NULL PRIMARY KEY,
+  project_id UUID NOT NULL,
+  kind addon_kind NOT NULL,
+
+  -- container running the service on the project network
+  name TEXT NOT NULL,
+  -- injected into the app, DATABASE_URL for postgres and REDIS_URL for redis. For s3 the
+  -- endpoint with the credentials of the app, the name is its bucket. For mail the relay
+  -- with them, the name is the address the app sends from
+  url TEXT NOT NULL,
+  -- connections the service accepts from the app
+  connection_limit INTEGER NOT NULL,
+  -- in MiB, what an s3 bucket may hold or the keys of a redis. For mail the recipients a
+  -- day, NULL for the default of the platform
+  quota INTEGER,
+  -- of an s3 bucket, [{"prefix": "tmp/", "expire_days": 7}]
+  lifecycle JSONB NOT NULL DEFAULT '[]',
+
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+
+  UNIQUE (project_id, kind),
+  FOREIGN KEY (project_id) REFERENCES projects(id) ON DELETE CASCADE ON UPDATE CASCADE
+);
+
+-- dumps of a postgres addon, the data itself lives in the backup bucket
+CREATE TABLE backups (
+  id UUID NOT NULL PRIMARY KEY,
+  addon_id UUID NOT NULL,
+
+  status run_state NOT NULL DEFAULT 'running',
+  -- captured with `pmk pg:backups capture` instead of the nightly schedule
+  manual BOOLEAN NOT NULL DEFAULT false,
+  -- of the dump in bytes, null until it is uploaded
+  size BIGINT,
+  error TEXT NOT NULL DEFAULT '',
+
+  started_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  finished_at TIMESTAMPTZ,
+
+  FOREIGN KEY (addon_id) REFERENCES addons(id) ON DELETE CASCADE ON UPDATE CASCADE
+);
+
+-- mail an app handed to the relay of its mail addon, the daily quota counts the recipients
+-- of the sent ones. see src/mail.rs
+CREATE TABLE mail_messages (
+  id UUID NOT NULL PRIMARY KEY,
+  project_id UUID NOT NULL,
+  -- envelope sender, the address of the addon
+  sender TEXT NOT NULL,
+  recipients INTEGER NOT NULL,
+  -- bytes
+  size INTEGER NOT NULL,
+  -- sent or failed
+  status TEXT NOT NULL,
+  error TEXT,
+
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+
+  FOREIGN KEY (project_id) REFERENCES projects(id) ON DELETE CASCADE ON UPDATE CASCADE
+);
+CREATE INDEX mail_messages_project_id_created_at_idx ON mail_messages (project_id, created_at);
+
+-- recipients the relay refuses for an app, added by hand or when the upstream rejected them
+CREATE TABLE mail_suppressions (
+  project_id UUID NOT NULL,
+  -- lowercase
+  address TEXT NOT NULL,
+  -- manual or the rejection of the upstream
+  reason TEXT NOT NULL,
+
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+
+  PRIMARY KEY (project_id, address),
+  FOREIGN KEY (project_id) REFERENCES projects(id) ON DELETE CASCADE ON UPDATE CASCADE
+);
+
+-- external sinks the output of app containers is forwarded to
+CREATE TABLE log_drains (
+  id UUID NOT NULL PRIMARY KEY,
+  project_id UUID NOT NULL,
+
+  -- the scheme picks the protocol: http(s), loki+http(s), syslog or syslog+udp
+  url TEXT NOT NULL,
+
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+
+  UNIQUE (project_id, url),
+  FOREIGN KEY (project_id) REFERENCES projects(id) ON DELETE CASCADE ON UPDATE CASCADE
+);
+
+-- samples of the resource usage of web and worker containers
+CREATE TABLE container_metrics (
+  project_id UUID NOT NULL,
+  container TEXT NOT NULL,
+  process TEXT NOT NULL,
+
+  cpu_percent DOUBLE PRECISION NOT NULL,
+  memory_bytes BIGINT NOT NULL,
+  memory_limit BIGINT NOT NULL,
+  -- bytes per second since the previous sample of the same container
+  network_rx DOUBLE PRECISION NOT NULL,
+  network_tx DOUBLE PRECISION NOT NULL,
+  -- restarts by the restart policy, and whether the last one was the kernel killing it
+  restarts INTEGER NOT NULL,
+  oom_killed BOOLEAN NOT NULL,
+
+  recorded_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+
+  FOREIGN KEY (project_id) REFERENCES projects(id) ON DELETE CASCADE ON UPDATE CASCADE
+);
+
+CREATE INDEX container_metrics_project_id_recorded_at_idx ON container_metrics (project_id, recorded_at);
+
+-- requests the proxy answered for an app, for `pmk logs --source router`
+CREATE TABLE access_logs (
+  id BIGSERIAL NOT NULL PRIMARY KEY,
+  project_id UUID NOT NULL,
+  request_id TEXT NOT NULL,
+  client_ip TEXT NOT NULL,
+  method TEXT NOT NULL,
+  path TEXT NOT NULL,
+  status INTEGER NOT NULL,
+  -- until the headers of the response, a stream may go on after
+  duration_ms INTEGER NOT NULL,
+  -- of the body sent to the client, NULL when it was cut short or not counted
+  bytes BIGINT,
+  -- address of the container that answered, 'cache' for the edge cache and empty when the
+  -- proxy answered itself
+  upstream TEXT NOT NULL,
+
+  recorded_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+
+  FOREIGN KEY (project_id) REFERENCES projects(id) ON DELETE CASCADE ON UPDATE CASCADE
+);
+
+CREATE INDEX access_logs_project_id_recorded_at_idx ON access_logs (project_id, recorded_at);
+
+-- output of the web and worker containers of an app, kept after the containers are gone for
+-- `pmk logs --since`. see src/container_logs.rs
+CREATE TABLE container_logs (
+  id BIGSERIAL NOT NULL PRIMARY KEY,
+  project_id UUID NOT NULL,
+  container TEXT NOT NULL,
+  -- web or the Procfile entry of a worker
+  process TEXT NOT NULL,
+  -- stdout or stderr
+  stream TEXT NOT NULL,
+  -- read from lines logged as json or logfmt, see crate::drains::LogLine
+  level TEXT,
+  message TEXT NOT NULL,
+
+  -- when the container wrote it
+  recorded_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+
+  FOREIGN KEY (project_id) REFERENCES projects(id) ON DELETE CASCADE ON UPDATE CASCADE
+);
+
+CREATE INDEX container_logs_project_id_recorded_at_idx ON container_logs (project_id, recorded_at);
+
+-- keeps the container count of a worker process between min_count and max_count
+CREATE TABLE autoscalers (
+  id UUID NOT NULL PRIMARY KEY,
+  project_id UUID NOT NULL,
+  process TEXT NOT NULL,
+
+  min_count INTEGER NOT NULL,
+  max_count INTEGER NOT NULL,
+  -- cpu in percent of one cpu per container, or latency as the p95 of the app in milliseconds
+  metric TEXT NOT NULL,
+  target DOUBLE PRECISION NOT NULL,
+
+  -- cooldowns count from here
+  last_scaled_at TIMESTAMPTZ,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+
+  UNIQUE (project_id, process),
+  FOREIGN KEY (project_id) REFERENCES projects(id) ON DELETE CASCADE ON UPDATE CASCADE
+);
+
+-- what happened to an app besides builds, like scaling
+CREATE TABLE activities (
+  id UUID NOT NULL PRIMARY KEY,
+  project_id UUID NOT NULL,
+
+  kind TEXT NOT NULL,
+  message TEXT NOT NULL,
+
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+
+  FOREIGN KEY (project_id) REFERENCES projects(id) ON DELETE CASCADE ON UPDATE CASCADE
+);
+
+CREATE INDEX activities_project_id_created_at_idx ON activities (project_id, created_at);
+
+-- a build running next to the live release on a share of the traffic, until it is promoted
+-- or aborted. it becomes a release once promoted
+CREATE TABLE canaries (
+  project_id UUID NOT NULL PRIMARY KEY,
+  build_id UUID NOT NULL,
+
+  container_id TEXT NOT NULL,
+  ip TEXT NOT NULL,
+  port INTEGER NOT NULL,
+  image TEXT NOT NULL,
+  config JSONB NOT NULL,
+
+  -- percent of requests sent to the canary
+  weight INTEGER NOT NULL,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+
+  FOREIGN KEY (project_id) REFERENCES projects(id) ON DELETE CASCADE ON UPDATE CASCADE
+);
+
+-- a github or gitlab repository that deploys the project on every push to its branch,
+-- besides pushing to the platform remote
+CREATE TABLE linked_repos (
+  project_id UUID NOT NULL PRIMARY KEY,
+
+  -- github or gitlab, decides how webhooks are verified
+  provider TEXT NOT NULL,
+  -- https clone url
+  url TEXT NOT NULL,
+  branch TEXT NOT NULL,
+  -- access token for private repositories, encrypted like project secrets
+  token TEXT,
+  -- shared with the provider to sign or authenticate its webhooks
+  webhook_secret TEXT NOT NULL,
+
+  last_commit TEXT,
+  last_synced_at TIMESTAMPTZ,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+
+  FOREIGN KEY (project_id) REFERENCES projects(id) ON DELETE CASCADE ON UPDATE CASCADE
+);
+
+-- where a project sends deploy and crash notifications
+CREATE TABLE notification_hooks (
+  id UUID NOT NULL PRIMARY KEY,
+  project_id UUID NOT NULL,
+
+  -- webhook posts the json payload, slack and discord post a message to an incoming webhook
+  kind TEXT NOT NULL,
+  url TEXT NOT NULL,
+  -- signs webhook payloads, unused by slack and discord
+  secret TEXT NOT NULL,
+  -- build.started, build.succeeded, build.failed and container.crashed
+  events TEXT[] NOT NULL,
+
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+
+  UNIQUE (project_id, url),
+  FOREIGN KEY (project_id) REFERENCES projects(id) ON DELETE CASCADE ON UPDATE CASCADE
+);
+
+-- logins for pulling the images of a project from private registries
+CREATE TABLE registry_credentials (
+  project_id UUID NOT NULL,
+  -- host as it appears in image references, docker.io for docker hub
+  registry TEXT NOT NULL,
+  username TEXT NOT NULL,
+  -- encrypted like project secrets
+  password TEXT NOT NULL,
+
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+
+  PRIMARY KEY (project_id, registry),
+  FOREIGN KEY (project_id) REFERENCES projects(id) ON DELETE CASCADE ON UPDATE CASCADE
+);
+
+CREATE TABLE volumes (
+  id UUID NOT NULL PRIMARY KEY,
+  project_id UUID NOT NULL,
+  -- the docker volume is {container name}-data-{name}
+  name TEXT NOT NULL,
+  -- absolute path in every container of the app
+  mount_path TEXT NOT NULL,
+  -- usage over it blocks deploys, the sizes of an app count against container.volumequota
+  size_mb INTEGER NOT NULL,
+
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+
+  UNIQUE (project_id, name),
+  UNIQUE (project_id, mount_path),
+  FOREIGN KEY (project_id) REFERENCES projects(id) ON DELETE CASCADE ON UPDATE CASCADE
+);
+
+-- read tokens do what viewers do, deploy tokens also build, deploy and roll back
+CREATE TYPE token_scope AS ENUM ('read', 'deploy', 'admin');
+
+CREATE TABLE access_tokens (
+  id UUID NOT NULL PRIMARY KEY,
+  -- the token acts as this user, never with more than their role
+  user_id UUID NOT NULL,
+  -- the only app the token works for, every app of the user when null
+  project_id UUID,
+  name TEXT NOT NULL,
+  scope token_scope NOT NULL,
+  -- sha256 of the token, the token itself is only shown when it is created
+  token_hash TEXT NOT NULL UNIQUE,
+  -- start of the token, to tell tokens apart
+  prefix TEXT NOT NULL,
+  expires_at TIMESTAMPTZ,
+  last_used_at TIMESTAMPTZ,
+  revoked_at TIMESTAMPTZ,
+
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+
+  FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE ON UPDATE CASCADE,
   FOREIGN KEY (project_id) REFERENCES projects(id) ON DELETE CASCADE ON UPDATE CASCADE
-);
\ No newline at end of file
+);
+
+CREATE INDEX access_tokens_user_id_idx ON access_tokens (user_id);
+
+-- accounts at the sso identity provider, a user logs in with any of theirs
+CREATE TABLE user_identities (
+  id UUID NOT NULL PRIMARY KEY,
+  user_id UUID NOT NULL,
+  issuer TEXT NOT NULL,
+  -- the sub claim, stable for the account unlike its username or email
+  subject TEXT NOT NULL,
+
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  last_login_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+
+  UNIQUE (issuer, subject),
+  FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE ON UPDATE CASCADE
+);
+
+-- the authenticator app of a user, logins ask for its code once one was confirmed
+CREATE TABLE user_totp (
+  user_id UUID NOT NULL PRIMARY KEY,
+  -- base32 secret encrypted with the secret key, like project secrets
+  secret TEXT NOT NULL,
+  -- sha256 of the recovery codes not used yet, each works once instead of a code
+  recovery_codes TEXT[] NOT NULL DEFAULT '{}',
+  -- time step of the last accepted code, a code can't be used twice
+  last_step BIGINT NOT NULL DEFAULT 0,
+  -- wrong codes in a row, logins stop until locked_until after too many
+  failures INTEGER NOT NULL DEFAULT 0,
+  locked_until TIMESTAMPTZ,
+  -- null while being set up, until the first code of the app is entered
+  confirmed_at TIMESTAMPTZ,
+
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+
+  FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE ON UPDATE CASCADE
+);
+
+-- logins of a user. revoking one logs it out on its next request
+CREATE TABLE user_sessions (
+  id UUID NOT NULL PRIMARY KEY,
+  user_id UUID NOT NULL,
+  ip TEXT,
+  user_agent TEXT,
+
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  last_seen_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  -- logged out or revoked, kept for a while to tell known devices from new ones
+  ended_at TIMESTAMPTZ,
+
+  FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE ON UPDATE CASCADE
+);
+
+CREATE INDEX user_sessions_user_id_idx ON user_sessions (user_id);
+
+-- where a user is told about logins from a device they haven't logged in from before
+CREATE TABLE login_hooks (
+  id UUID NOT NULL PRIMARY KEY,
+  user_id UUID NOT NULL,
+  -- webhook, slack or discord like notification_hooks
+  kind TEXT NOT NULL,
+  url TEXT NOT NULL,
+  secret TEXT NOT NULL,
+
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+
+  UNIQUE (user_id, url),
+  FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE ON UPDATE CASCADE
+);
+
+-- every change made through the api, kept after the users and projects it's about are gone
+CREATE TABLE audit_log (
+  id UUID NOT NULL PRIMARY KEY,
+  actor_id UUID,
+  -- username at the time, or what acted when it wasn't a user
+  actor TEXT NOT NULL,
+  token_id UUID,
+  owner TEXT,
+  project_id UUID,
+  project TEXT,
+  -- the route without its parameters, like env.delete
+  action TEXT NOT NULL,
+  method TEXT NOT NULL,
+  path TEXT NOT NULL,
+  -- status code of the response, refused attempts are kept too
+  status INTEGER NOT NULL,
+  ip TEXT,
+  -- what the change replaced and what it became, when the handler knows
+  before JSONB,
+  after JSONB,
+
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+
+  FOREIGN KEY (actor_id) REFERENCES users(id) ON DELETE SET NULL ON UPDATE CASCADE,
+  FOREIGN KEY (project_id) REFERENCES projects(id) ON DELETE SET NULL ON UPDATE CASCADE,
+  FOREIGN KEY (token_id) REFERENCES access_tokens(id) ON DELETE SET NULL ON UPDATE CASCADE
+);
+
+CREATE INDEX audit_log_created_at_idx ON audit_log (created_at);
+CREATE INDEX audit_log_project_id_created_at_idx ON audit_log (project_id, created_at);
+
+-- what each app used in a month, metered by the metrics collector and the build queue. the
+-- names are kept so reports still show deleted apps
+CREATE TABLE app_usage (
+  id UUID NOT NULL PRIMARY KEY,
+  project_id UUID,
+  owner TEXT NOT NULL,
+  project TEXT NOT NULL,
+  -- first day of the month
+  month DATE NOT NULL,
+
+  -- seconds of one cpu
+  cpu_seconds DOUBLE PRECISION NOT NULL DEFAULT 0,
+  memory_gib_hours DOUBLE PRECISION NOT NULL DEFAULT 0,
+  -- bytes sent by the containers of the app
+  egress_bytes BIGINT NOT NULL DEFAULT 0,
+  build_minutes DOUBLE PRECISION NOT NULL DEFAULT 0,
+
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+
+  UNIQUE (project_id, month),
+  FOREIGN KEY (project_id) REFERENCES projects(id) ON DELETE SET NULL ON UPDATE CASCADE
+);
+
+CREATE INDEX app_usage_month_idx ON app_usage (month);
+
+-- ssh keys that push or fetch one app, for ci without a user. only the public half is kept,
+-- the private one is shown once when the key is made or rotated
+CREATE TABLE deploy_keys (
+  id UUID NOT NULL PRIMARY KEY,
+  project_id UUID NOT NULL,
+  name TEXT NOT NULL,
+
+  -- openssh format, like ssh-ed25519 AAAA...
+  public_key TEXT NOT NULL,
+  -- the sha256 of the key, how the ssh server finds it
+  fingerprint TEXT NOT NULL UNIQUE,
+  -- fetch only, pushes are refused
+  read_only BOOLEAN NOT NULL DEFAULT true,
+
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  rotated_at TIMESTAMPTZ,
+  last_used_at TIMESTAMPTZ,
+
+  UNIQUE (project_id, name),
+  FOREIGN KEY (project_id) REFERENCES projects(id) ON DELETE CASCADE ON UPDATE CASCADE
+);
+
+-- starting points for new apps that an owner registers, like a course handing out its
+-- assignment. new apps get the code of the app at the time, as a commit of their own
+CREATE TABLE templates (
+  id UUID NOT NULL PRIMARY KEY,
+  owner_id UUID NOT NULL,
+  -- the app whose code new apps start from
+  project_id UUID NOT NULL,
+  name TEXT NOT NULL,
+  description TEXT NOT NULL DEFAULT '',
+
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+
+  UNIQUE (owner_id, name),
+  FOREIGN KEY (owner_id) REFERENCES project_owners(id) ON DELETE CASCADE ON UPDATE CASCADE,
+  FOREIGN KEY (project_id) REFERENCES projects(id) ON DELETE CASCADE ON UPDATE CASCADE
+);
+
+-- variables an owner shares between its apps, like api keys every app needs. see src/env_groups.rs
+CREATE TABLE env_groups (
+  id UUID NOT NULL PRIMARY KEY,
+  owner_id UUID NOT NULL,
+  name TEXT NOT NULL,
+  environs JSONB NOT NULL DEFAULT '{}',
+  -- encrypted like the secrets of projects
+  secrets JSONB NOT NULL DEFAULT '{}',
+
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+
+  UNIQUE (owner_id, name),
+  FOREIGN KEY (owner_id) REFERENCES project_owners(id) ON DELETE CASCADE ON UPDATE CASCADE
+);
+
+-- the groups an app starts with, later ones win over earlier ones
+CREATE TABLE project_env_groups (
+  project_id UUID NOT NULL,
+  group_id UUID NOT NULL,
+
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+
+  PRIMARY KEY (project_id, group_id),
+  FOREIGN KEY (project_id) REFERENCES projects(id) ON DELETE CASCADE ON UPDATE CASCADE,
+  FOREIGN KEY (group_id) REFERENCES env_groups(id) ON DELETE CASCADE ON UPDATE CASCADE
+);
+CREATE INDEX project_env_groups_group_id_idx ON project_env_groups (group_id);
+
+-- hosts running apps besides the one of the platform, each with pemasak-agent reporting
+-- what it has left. see src/nodes.rs
+CREATE TABLE nodes (
+  id UUID NOT NULL PRIMARY KEY,
+  name TEXT NOT NULL UNIQUE,
+  -- of the token the agent reports with, see crate::auth::tokens::hash_token
+  token_hash TEXT NOT NULL UNIQUE,
+  -- docker api the agent exposes to the platform, NULL until it reported once
+  docker_url TEXT,
+  cpus INTEGER NOT NULL DEFAULT 0,
+  -- MiB
+  memory INTEGER NOT NULL DEFAULT 0,
+  memory_available INTEGER NOT NULL DEFAULT 0,
+  -- load average over a minute
+  load DOUBLE PRECISION NOT NULL DEFAULT 0,
+  containers INTEGER NOT NULL DEFAULT 0,
+  -- docker or podman, see src/runtime.rs
+  runtime TEXT NOT NULL DEFAULT 'docker',
+  -- like amd64 or arm64, see src/arch.rs. null until an agent reports it
+  arch TEXT,
+  -- no new apps are placed on it and its apps are moved off
+  draining BOOLEAN NOT NULL DEFAULT false,
+  last_seen_at TIMESTAMPTZ,
+
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+ALTER TABLE projects ADD FOREIGN KEY (node_id) REFERENCES nodes(id) ON DELETE SET NULL ON UPDATE CASCADE;
+
+-- raw tcp and udp ports of the platform leased to apps, see src/ports.rs
+CREATE TABLE port_leases (
+  id UUID NOT NULL PRIMARY KEY,
+  project_id UUID NOT NULL,
+  -- tcp or udp
+  protocol TEXT NOT NULL,
+  -- the port of the platform clients connect to, from the range of the ports settings
+  port INTEGER NOT NULL,
+  -- the port in the container it is forwarded to
+  target_port INTEGER NOT NULL,
+  -- seconds without traffic before a connection is closed, null keeps the one of the platform
+  idle_timeout INTEGER,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+
+  UNIQUE (protocol, port),
+  FOREIGN KEY (project_id) REFERENCES projects(id) ON DELETE CASCADE ON UPDATE CASCADE
+);
+
+CREATE INDEX port_leases_project_id_idx ON port_leases (project_id);
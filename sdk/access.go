@@ -0,0 +1,42 @@
+package pemasak
+
+import (
+	"context"
+	"net/http"
+)
+
+// IPAccess is who the proxy lets through to an app, by client address.
+// Ranges are in CIDR notation like 10.0.0.0/8, a single address is a range
+// of one. Clients in Deny get 403, and once Allow has a range so does
+// everyone outside it. Both empty let everyone through.
+type IPAccess struct {
+	Allow []string `json:"allow"`
+	Deny  []string `json:"deny"`
+}
+
+// GetIPAccess returns the address ranges of an app.
+func (c *Client) GetIPAccess(ctx context.Context, owner, project string) (*IPAccess, error) {
+	var res IPAccess
+	err := c.do(ctx, request{method: http.MethodGet, path: projectPath(owner, project, "access"), idempotent: true}, &res)
+	if err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// SetIPAccess replaces the address ranges of an app, at most 100 in each
+// list. The platform stores ranges by their network address.
+func (c *Client) SetIPAccess(ctx context.Context, owner, project string, access IPAccess) error {
+	if access.Allow == nil {
+		access.Allow = []string{}
+	}
+	if access.Deny == nil {
+		access.Deny = []string{}
+	}
+	return c.do(ctx, request{
+		method:     http.MethodPost,
+		path:       projectPath(owner, project, "access"),
+		body:       access,
+		idempotent: true,
+	}, nil)
+}
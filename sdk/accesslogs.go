@@ -0,0 +1,109 @@
+package pemasak
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// AccessLogLine is a request the platform answered for an app.
+type AccessLogLine struct {
+	ID         int64     `json:"id"`
+	RecordedAt time.Time `json:"recorded_at"`
+	RequestID  string    `json:"request_id"`
+	ClientIP   string    `json:"client_ip"`
+	Method     string    `json:"method"`
+	// Path is without the query, which may carry tokens.
+	Path   string `json:"path"`
+	Status int    `json:"status"`
+	// DurationMS is until the headers of the response, a stream may go on
+	// after.
+	DurationMS int `json:"duration_ms"`
+	// Bytes of the body sent to the client, nil when it was cut short or
+	// not counted.
+	Bytes *int64 `json:"bytes"`
+	// Upstream is the address of the container that answered, "cache" for
+	// the edge cache and empty when the platform answered itself, like for
+	// a rate limit.
+	Upstream string `json:"upstream"`
+}
+
+// AccessLogFilter narrows down access log lines. The zero value returns the
+// latest 100.
+type AccessLogFilter struct {
+	// After only returns lines after the one with this ID, for following
+	// the log.
+	After int64
+	// Status is a status like "404" or a class like "5xx".
+	Status string
+	// Limit is at most 1000.
+	Limit int
+}
+
+func (f AccessLogFilter) query() string {
+	q := url.Values{}
+	if f.After > 0 {
+		q.Set("after", strconv.FormatInt(f.After, 10))
+	}
+	if f.Status != "" {
+		q.Set("status", f.Status)
+	}
+	if f.Limit > 0 {
+		q.Set("limit", strconv.Itoa(f.Limit))
+	}
+	if len(q) == 0 {
+		return ""
+	}
+	return "?" + q.Encode()
+}
+
+// AccessLogSettings are how many requests of an app the platform logs and
+// for how long.
+type AccessLogSettings struct {
+	// Sample is the percent of requests logged, server errors are always
+	// logged.
+	Sample int `json:"sample"`
+	// Retention is how many days lines are kept, nil for the default of the
+	// platform.
+	Retention *int `json:"retention"`
+	// DefaultRetention and MaxRetention are set by the platform, they are
+	// ignored by SetAccessLogSettings.
+	DefaultRetention int `json:"default_retention,omitempty"`
+	MaxRetention     int `json:"max_retention,omitempty"`
+}
+
+// AccessLogs returns the latest requests the platform answered for an app,
+// oldest first.
+func (c *Client) AccessLogs(ctx context.Context, owner, project string, filter AccessLogFilter) ([]AccessLogLine, error) {
+	var res struct {
+		Lines []AccessLogLine `json:"lines"`
+	}
+	err := c.do(ctx, request{method: http.MethodGet, path: projectPath(owner, project, "logs", "router") + filter.query(), idempotent: true}, &res)
+	if err != nil {
+		return nil, err
+	}
+	return res.Lines, nil
+}
+
+// GetAccessLogSettings returns the access log settings of an app.
+func (c *Client) GetAccessLogSettings(ctx context.Context, owner, project string) (*AccessLogSettings, error) {
+	var res AccessLogSettings
+	err := c.do(ctx, request{method: http.MethodGet, path: projectPath(owner, project, "logs", "router", "settings"), idempotent: true}, &res)
+	if err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// SetAccessLogSettings changes how many requests of an app are logged and
+// for how long.
+func (c *Client) SetAccessLogSettings(ctx context.Context, owner, project string, settings AccessLogSettings) error {
+	return c.do(ctx, request{
+		method:     http.MethodPost,
+		path:       projectPath(owner, project, "logs", "router", "settings"),
+		body:       settings,
+		idempotent: true,
+	}, nil)
+}
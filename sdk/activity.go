@@ -0,0 +1,29 @@
+package pemasak
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// Activity is an entry in the activity log of a project, for changes besides
+// builds like scaling by hand or by the autoscaler.
+type Activity struct {
+	ID        string    `json:"id"`
+	Kind      string    `json:"kind"`
+	Message   string    `json:"message"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ListActivity returns the latest 100 entries of the activity log of a
+// project, newest first.
+func (c *Client) ListActivity(ctx context.Context, owner, project string) ([]Activity, error) {
+	var res struct {
+		Data []Activity `json:"data"`
+	}
+	err := c.do(ctx, request{method: http.MethodGet, path: projectPath(owner, project, "activity"), idempotent: true}, &res)
+	if err != nil {
+		return nil, err
+	}
+	return res.Data, nil
+}
@@ -0,0 +1,229 @@
+package pemasak
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// AddonKind is a service the platform can provision for an app.
+type AddonKind string
+
+const (
+	// AddonPostgres is a dedicated database, injected as DATABASE_URL.
+	AddonPostgres AddonKind = "postgres"
+	// AddonS3 is a bucket on the object storage of the platform, injected as
+	// S3_ENDPOINT, S3_BUCKET and the AWS_ variables the s3 sdks read.
+	AddonS3 AddonKind = "s3"
+	// AddonRedis is a dedicated redis, injected as REDIS_URL.
+	AddonRedis AddonKind = "redis"
+	// AddonMail is an address on the mail relay of the platform, injected as
+	// SMTP_URL, the SMTP_ variables it is made of and MAIL_FROM.
+	AddonMail AddonKind = "mail"
+)
+
+// Addon is a service provisioned for a project. It runs next to the app on
+// its private network and is destroyed together with the project.
+type Addon struct {
+	Kind AddonKind `json:"kind"`
+	// Name is the container the service runs in, and its hostname.
+	Name string `json:"name"`
+	// ConnectionLimit is how many connections the service accepts.
+	ConnectionLimit int `json:"connection_limit"`
+	// Quota is how many MiB a bucket or the keys of a redis hold, or how many
+	// recipients mail reaches a day. Nil for postgres, and for mail on the
+	// quota of the platform.
+	Quota     *int      `json:"quota,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ListAddons returns the addons of a project.
+func (c *Client) ListAddons(ctx context.Context, owner, project string) ([]Addon, error) {
+	var res struct {
+		Data []Addon `json:"data"`
+	}
+	err := c.do(ctx, request{method: http.MethodGet, path: projectPath(owner, project, "addons"), idempotent: true}, &res)
+	if err != nil {
+		return nil, err
+	}
+	return res.Data, nil
+}
+
+// CreateAddon provisions an addon. A deployed app is restarted with its
+// connection url, others get it on their first deploy.
+func (c *Client) CreateAddon(ctx context.Context, owner, project string, kind AddonKind) (*Addon, error) {
+	var res Addon
+	err := c.do(ctx, request{
+		method: http.MethodPost,
+		path:   projectPath(owner, project, "addons"),
+		body: struct {
+			Kind AddonKind `json:"kind"`
+		}{kind},
+	}, &res)
+	if err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// DestroyAddon removes an addon together with its data, which can't be
+// undone.
+func (c *Client) DestroyAddon(ctx context.Context, owner, project string, kind AddonKind) error {
+	return c.do(ctx, request{
+		method: http.MethodPost,
+		path:   projectPath(owner, project, "addons", "delete"),
+		body: struct {
+			Kind AddonKind `json:"kind"`
+		}{kind},
+	}, nil)
+}
+
+// LifecycleRule deletes the objects under Prefix ExpireDays after they were
+// written. An empty Prefix covers the whole bucket.
+type LifecycleRule struct {
+	Prefix     string `json:"prefix"`
+	ExpireDays int    `json:"expire_days"`
+}
+
+// Bucket is the s3 addon of a project. The credentials are only in the
+// variables of its containers.
+type Bucket struct {
+	Bucket   string `json:"bucket"`
+	Endpoint string `json:"endpoint"`
+	Region   string `json:"region"`
+	// Quota is in MiB, writes past it fail.
+	Quota int `json:"quota"`
+	// Usage is in bytes, nil when the storage couldn't tell.
+	Usage     *int64          `json:"usage"`
+	Lifecycle []LifecycleRule `json:"lifecycle"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+// GetBucket returns the s3 addon of a project.
+func (c *Client) GetBucket(ctx context.Context, owner, project string) (*Bucket, error) {
+	var res Bucket
+	err := c.do(ctx, request{method: http.MethodGet, path: projectPath(owner, project, "addons", "s3"), idempotent: true}, &res)
+	if err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// SetBucket changes the quota and replaces the lifecycle rules of the s3
+// addon of a project. They apply right away.
+func (c *Client) SetBucket(ctx context.Context, owner, project string, quota int, lifecycle []LifecycleRule) error {
+	if lifecycle == nil {
+		lifecycle = []LifecycleRule{}
+	}
+	return c.do(ctx, request{
+		method: http.MethodPost,
+		path:   projectPath(owner, project, "addons", "s3"),
+		body: struct {
+			Quota     int             `json:"quota"`
+			Lifecycle []LifecycleRule `json:"lifecycle"`
+		}{quota, lifecycle},
+	}, nil)
+}
+
+// RedisStats is what a redis says about itself.
+type RedisStats struct {
+	// UsedMemory is the bytes the keys take, past MaxMemory the least
+	// recently used ones are evicted.
+	UsedMemory       int64 `json:"used_memory"`
+	MaxMemory        int64 `json:"max_memory"`
+	OpsPerSec        int64 `json:"ops_per_sec"`
+	ConnectedClients int64 `json:"connected_clients"`
+	Keys             int64 `json:"keys"`
+	// EvictedKeys counts the keys dropped since the server started.
+	EvictedKeys   int64 `json:"evicted_keys"`
+	UptimeSeconds int64 `json:"uptime_seconds"`
+}
+
+// Redis is the redis addon of a project.
+type Redis struct {
+	Name string `json:"name"`
+	// Memory is how many MiB of keys it holds.
+	Memory int `json:"memory"`
+	// Stats is nil when the server didn't answer.
+	Stats     *RedisStats `json:"stats"`
+	CreatedAt time.Time   `json:"created_at"`
+}
+
+// GetRedis returns the redis addon of a project with its stats right now.
+func (c *Client) GetRedis(ctx context.Context, owner, project string) (*Redis, error) {
+	var res Redis
+	err := c.do(ctx, request{method: http.MethodGet, path: projectPath(owner, project, "addons", "redis"), idempotent: true}, &res)
+	if err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// MailMessage is a message an app sent through the relay, without its
+// content.
+type MailMessage struct {
+	Sender     string `json:"sender"`
+	Recipients int    `json:"recipients"`
+	Size       int    `json:"size"`
+	// Status is "sent" or "failed", with the reason of the upstream in Error.
+	Status    string    `json:"status"`
+	Error     string    `json:"error,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// MailSuppression is an address the app can't send to.
+type MailSuppression struct {
+	Address string `json:"address"`
+	// Reason is the rejection of the upstream, or "added by hand".
+	Reason    string    `json:"reason"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Mail is the mail addon of a project. The password is only in the
+// SMTP_PASSWORD of the app.
+type Mail struct {
+	// Address is the only sender the relay takes from the app.
+	Address  string `json:"address"`
+	Host     string `json:"host"`
+	Port     int    `json:"port"`
+	Username string `json:"username"`
+	// Quota is how many recipients the app reaches a day, in UTC.
+	Quota     int `json:"quota"`
+	SentToday int `json:"sent_today"`
+	// Messages are the last ones sent, newest first.
+	Messages     []MailMessage     `json:"messages"`
+	Suppressions []MailSuppression `json:"suppressions"`
+	CreatedAt    time.Time         `json:"created_at"`
+}
+
+// GetMail returns the mail addon of a project with what it sent.
+func (c *Client) GetMail(ctx context.Context, owner, project string) (*Mail, error) {
+	var res Mail
+	err := c.do(ctx, request{method: http.MethodGet, path: projectPath(owner, project, "addons", "mail"), idempotent: true}, &res)
+	if err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// SuppressMail stops the app from sending to address.
+func (c *Client) SuppressMail(ctx context.Context, owner, project, address string) error {
+	return c.do(ctx, request{
+		method: http.MethodPost,
+		path:   projectPath(owner, project, "addons", "mail", "suppressions"),
+		body: struct {
+			Address string `json:"address"`
+		}{address},
+	}, nil)
+}
+
+// UnsuppressMail lets the app send to address again.
+func (c *Client) UnsuppressMail(ctx context.Context, owner, project, address string) error {
+	return c.do(ctx, request{
+		method: http.MethodPost,
+		path:   projectPath(owner, project, "addons", "mail", "suppressions", "delete"),
+		body: struct {
+			Address string `json:"address"`
+		}{address},
+	}, nil)
+}
@@ -0,0 +1,167 @@
+package pemasak
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// The calls in this file are for platform admins, everyone else gets an
+// APIError with status 403.
+
+// AdminApp is an app anywhere on the platform with what it uses right now.
+type AdminApp struct {
+	ID      string            `json:"id"`
+	Owner   string            `json:"owner"`
+	Project string            `json:"project"`
+	Status  ProjectStatus     `json:"status"`
+	Labels  map[string]string `json:"labels"`
+	// Containers, CPUPercent and MemoryBytes add up the latest metric
+	// samples of its running containers. They are zero for an app that
+	// doesn't run.
+	Containers      int        `json:"containers"`
+	CPUPercent      float64    `json:"cpu_percent"`
+	MemoryBytes     int64      `json:"memory_bytes"`
+	SuspendedAt     *time.Time `json:"suspended_at"`
+	SuspendedReason string     `json:"suspended_reason"`
+	CreatedAt       time.Time  `json:"created_at"`
+}
+
+// AdminContainer is a running web or worker container as of its latest
+// metric sample.
+type AdminContainer struct {
+	Name        string    `json:"name"`
+	Owner       string    `json:"owner"`
+	Project     string    `json:"project"`
+	Process     string    `json:"process"`
+	CPUPercent  float64   `json:"cpu_percent"`
+	MemoryBytes int64     `json:"memory_bytes"`
+	MemoryLimit int64     `json:"memory_limit"`
+	NetworkRx   float64   `json:"network_rx"`
+	NetworkTx   float64   `json:"network_tx"`
+	Restarts    int       `json:"restarts"`
+	OOMKilled   bool      `json:"oom_killed"`
+	RecordedAt  time.Time `json:"recorded_at"`
+}
+
+// HostStatus says whether the host is draining for maintenance.
+type HostStatus struct {
+	Draining      bool `json:"draining"`
+	BuildsWaiting int  `json:"builds_waiting"`
+	BuildsRunning int  `json:"builds_running"`
+	// Drained is true once draining and no build runs anymore.
+	Drained bool `json:"drained"`
+}
+
+// ListAllApps returns every app on the platform that matches filter, newest
+// first. It fetches page after page, use ListAllAppsPage for one.
+func (c *Client) ListAllApps(ctx context.Context, filter ProjectFilter) ([]AdminApp, error) {
+	return collect(ctx, func(page ListOptions) (Page[AdminApp], error) {
+		return c.ListAllAppsPage(ctx, filter, page)
+	})
+}
+
+// ListAllAppsPage returns a page of the apps on the platform that match
+// filter, newest first.
+func (c *Client) ListAllAppsPage(ctx context.Context, filter ProjectFilter, page ListOptions) (Page[AdminApp], error) {
+	var res Page[AdminApp]
+	err := c.do(ctx, request{method: http.MethodGet, path: pathWithQuery("/api/admin/apps", filter.query(page)), idempotent: true}, &res)
+	return res, err
+}
+
+// ListAllContainers returns every running web and worker container on the
+// platform, the busiest first.
+func (c *Client) ListAllContainers(ctx context.Context) ([]AdminContainer, error) {
+	var res struct {
+		Data []AdminContainer `json:"data"`
+	}
+	err := c.do(ctx, request{method: http.MethodGet, path: "/api/admin/containers", idempotent: true}, &res)
+	if err != nil {
+		return nil, err
+	}
+	return res.Data, nil
+}
+
+func adminAppPath(owner, project, action string) string {
+	return "/api/admin/apps/" + url.PathEscape(owner) + "/" + url.PathEscape(project) + "/" + action
+}
+
+// SuspendApp stops every container of an app and keeps it stopped until
+// ResumeApp. The members see the reason, and can only look at the app.
+func (c *Client) SuspendApp(ctx context.Context, owner, project, reason string) error {
+	body := map[string]string{"reason": reason}
+	return c.do(ctx, request{method: http.MethodPost, path: adminAppPath(owner, project, "suspend"), body: body, untimed: true}, nil)
+}
+
+// ResumeApp lifts a suspension and starts the app again.
+func (c *Client) ResumeApp(ctx context.Context, owner, project string) error {
+	return c.do(ctx, request{method: http.MethodPost, path: adminAppPath(owner, project, "resume"), untimed: true}, nil)
+}
+
+// Impersonate logs the session in as another user, to see what they see.
+// The session is read-only until StopImpersonating. It doesn't work with
+// WithToken.
+func (c *Client) Impersonate(ctx context.Context, username string) error {
+	path := "/api/admin/users/" + url.PathEscape(username) + "/impersonate"
+	return c.do(ctx, request{method: http.MethodPost, path: path}, nil)
+}
+
+// StopImpersonating logs the admin back in.
+func (c *Client) StopImpersonating(ctx context.Context) error {
+	return c.do(ctx, request{method: http.MethodPost, path: "/api/admin/impersonate/stop", idempotent: true}, nil)
+}
+
+// Host returns whether the host is draining.
+func (c *Client) Host(ctx context.Context) (*HostStatus, error) {
+	return c.host(ctx, http.MethodGet, "/api/admin/host")
+}
+
+// DrainHost stops starting builds, so the host can go down for maintenance
+// once HostStatus.Drained. Apps keep serving and new builds wait.
+func (c *Client) DrainHost(ctx context.Context) (*HostStatus, error) {
+	return c.host(ctx, http.MethodPost, "/api/admin/host/drain")
+}
+
+// ResumeHost starts the builds that waited while the host drained.
+func (c *Client) ResumeHost(ctx context.Context) (*HostStatus, error) {
+	return c.host(ctx, http.MethodPost, "/api/admin/host/resume")
+}
+
+// Reconciled is what a reconcile pass found on the host and did about it.
+type Reconciled struct {
+	// FailedBuilds were pending or building when the platform stopped, they
+	// are marked failed.
+	FailedBuilds []string `json:"failed_builds"`
+	// RemovedContainers belonged to deleted apps or to deploys that stopped
+	// halfway.
+	RemovedContainers []string `json:"removed_containers"`
+	// KeptContainers belong to deleted apps but have volumes, remove them by
+	// hand once their data isn't needed.
+	KeptContainers []string `json:"kept_containers"`
+	// RestoredApps lost their container and are deployed again, as
+	// owner/project.
+	RestoredApps []string  `json:"restored_apps"`
+	FinishedAt   time.Time `json:"finished_at"`
+}
+
+// Reconcile checks builds and containers against the database now, like
+// right after the host came back up. The platform does it on its own every
+// few minutes too.
+func (c *Client) Reconcile(ctx context.Context) (*Reconciled, error) {
+	var res Reconciled
+	err := c.do(ctx, request{method: http.MethodPost, path: "/api/admin/host/reconcile", idempotent: true, untimed: true}, &res)
+	if err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+func (c *Client) host(ctx context.Context, method, path string) (*HostStatus, error) {
+	var res HostStatus
+	err := c.do(ctx, request{method: method, path: path, idempotent: true}, &res)
+	if err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
@@ -0,0 +1,165 @@
+package pemasak
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// AppMetricsBuckets are the upper bounds in seconds of the latency histogram
+// AppMetrics keeps, the same as the Prometheus client defaults.
+var AppMetricsBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// AppMetrics counts the requests an app serves and exposes them in the
+// Prometheus text format, so a deployed app can be scraped without pulling in
+// a metrics library:
+//
+//	metrics := pemasak.NewAppMetrics()
+//	mux.Handle("/metrics", metrics)
+//	http.ListenAndServe(":"+os.Getenv("PORT"), metrics.Middleware(mux))
+//
+// It serves http_requests_total by method and status code,
+// http_request_duration_seconds as a histogram by method and
+// http_requests_in_flight. Paths aren't labels, they would grow without bound.
+type AppMetrics struct {
+	inFlight atomic.Int64
+
+	mu       sync.Mutex
+	requests map[requestLabels]uint64
+	duration map[string]*histogram
+}
+
+type requestLabels struct {
+	method string
+	code   int
+}
+
+type histogram struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// NewAppMetrics returns an AppMetrics with nothing counted yet.
+func NewAppMetrics() *AppMetrics {
+	return &AppMetrics{
+		requests: map[requestLabels]uint64{},
+		duration: map[string]*histogram{},
+	}
+}
+
+// Middleware counts every request passed to next.
+func (m *AppMetrics) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		m.inFlight.Add(1)
+		defer m.inFlight.Add(-1)
+
+		start := time.Now()
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(sw, r)
+		m.observe(r.Method, sw.status, time.Since(start))
+	})
+}
+
+func (m *AppMetrics) observe(method string, code int, took time.Duration) {
+	// methods come from clients, anything unusual is counted as one
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+		http.MethodDelete, http.MethodOptions:
+	default:
+		method = "OTHER"
+	}
+	seconds := took.Seconds()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.requests[requestLabels{method, code}]++
+	h, ok := m.duration[method]
+	if !ok {
+		h = &histogram{counts: make([]uint64, len(AppMetricsBuckets))}
+		m.duration[method] = h
+	}
+	for i, bound := range AppMetricsBuckets {
+		if seconds <= bound {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += seconds
+}
+
+// ServeHTTP writes the metrics in the Prometheus text exposition format.
+func (m *AppMetrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	labels := make([]requestLabels, 0, len(m.requests))
+	for l := range m.requests {
+		labels = append(labels, l)
+	}
+	sort.Slice(labels, func(i, j int) bool {
+		if labels[i].method != labels[j].method {
+			return labels[i].method < labels[j].method
+		}
+		return labels[i].code < labels[j].code
+	})
+	fmt.Fprintln(w, "# HELP http_requests_total Requests served, by method and status code.")
+	fmt.Fprintln(w, "# TYPE http_requests_total counter")
+	for _, l := range labels {
+		fmt.Fprintf(w, "http_requests_total{method=%q,code=\"%d\"} %d\n", l.method, l.code, m.requests[l])
+	}
+
+	methods := make([]string, 0, len(m.duration))
+	for method := range m.duration {
+		methods = append(methods, method)
+	}
+	sort.Strings(methods)
+	fmt.Fprintln(w, "# HELP http_request_duration_seconds Time to serve a request, by method.")
+	fmt.Fprintln(w, "# TYPE http_request_duration_seconds histogram")
+	for _, method := range methods {
+		h := m.duration[method]
+		for i, bound := range AppMetricsBuckets {
+			le := strconv.FormatFloat(bound, 'g', -1, 64)
+			fmt.Fprintf(w, "http_request_duration_seconds_bucket{method=%q,le=%q} %d\n", method, le, h.counts[i])
+		}
+		fmt.Fprintf(w, "http_request_duration_seconds_bucket{method=%q,le=\"+Inf\"} %d\n", method, h.count)
+		fmt.Fprintf(w, "http_request_duration_seconds_sum{method=%q} %g\n", method, h.sum)
+		fmt.Fprintf(w, "http_request_duration_seconds_count{method=%q} %d\n", method, h.count)
+	}
+
+	fmt.Fprintln(w, "# HELP http_requests_in_flight Requests being served.")
+	fmt.Fprintln(w, "# TYPE http_requests_in_flight gauge")
+	fmt.Fprintf(w, "http_requests_in_flight %d\n", m.inFlight.Load())
+}
+
+// statusWriter remembers the status code a handler answered with.
+type statusWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	if !w.wroteHeader {
+		w.status = code
+		w.wroteHeader = true
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	w.wroteHeader = true
+	return w.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach Flush and Hijack of the
+// underlying writer.
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
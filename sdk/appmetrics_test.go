@@ -0,0 +1,65 @@
+package pemasak
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAppMetrics(t *testing.T) {
+	metrics := NewAppMetrics()
+	inFlight := ""
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics)
+	mux.HandleFunc("/ok", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})
+	mux.HandleFunc("/missing", func(w http.ResponseWriter, r *http.Request) {
+		http.NotFound(w, r)
+	})
+	mux.HandleFunc("/busy", func(w http.ResponseWriter, r *http.Request) {
+		rec := httptest.NewRecorder()
+		metrics.ServeHTTP(rec, r)
+		for _, line := range strings.Split(rec.Body.String(), "\n") {
+			if strings.HasPrefix(line, "http_requests_in_flight ") {
+				inFlight = line
+			}
+		}
+	})
+	handler := metrics.Middleware(mux)
+
+	for _, req := range []struct{ method, path string }{
+		{http.MethodGet, "/ok"},
+		{http.MethodGet, "/ok"},
+		{http.MethodPost, "/missing"},
+		{"PROPFIND", "/ok"},
+		{http.MethodGet, "/busy"},
+	} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(req.method, req.path, nil))
+	}
+	if inFlight != "http_requests_in_flight 1" {
+		t.Errorf("in flight while serving = %q, want 1", inFlight)
+	}
+
+	rec := httptest.NewRecorder()
+	metrics.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if got := rec.Header().Get("Content-Type"); !strings.HasPrefix(got, "text/plain; version=0.0.4") {
+		t.Errorf("Content-Type = %q", got)
+	}
+	body := rec.Body.String()
+	for _, want := range []string{
+		`http_requests_total{method="GET",code="200"} 3`,
+		`http_requests_total{method="OTHER",code="200"} 1`,
+		`http_requests_total{method="POST",code="404"} 1`,
+		`http_request_duration_seconds_bucket{method="GET",le="+Inf"} 3`,
+		`http_request_duration_seconds_count{method="GET"} 3`,
+		`http_request_duration_seconds_count{method="POST"} 1`,
+		"# TYPE http_request_duration_seconds histogram",
+		"http_requests_in_flight 0",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("metrics lack %q:\n%s", want, body)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package pemasak
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// AuditEntry is a change someone made: a deploy, a push, an env change,
+// scaling, a deleted app, a created token and so on.
+type AuditEntry struct {
+	ID string `json:"id"`
+	// Actor is the username of who made the change, or git:<owner> for a
+	// push with the git token of an app.
+	Actor string `json:"actor"`
+	// TokenID is the access token the change was made with, empty for a
+	// session.
+	TokenID string `json:"token_id"`
+	Owner   string `json:"owner"`
+	Project string `json:"project"`
+	// Action names what was done, like "env", "env.delete" or "git.push".
+	Action string `json:"action"`
+	Method string `json:"method"`
+	Path   string `json:"path"`
+	Status int    `json:"status"`
+	IP     string `json:"ip"`
+	// Before and After are what changed, when the platform knows. Secrets
+	// are masked.
+	Before    json.RawMessage `json:"before"`
+	After     json.RawMessage `json:"after"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+// AuditFilter narrows down audit entries. The zero value returns the latest
+// 100.
+type AuditFilter struct {
+	// Owner only applies to AuditLog, ProjectAudit is about one app anyway.
+	Owner string
+	Actor string
+	// Action matches actions starting with it, "env" also matches
+	// "env.delete".
+	Action string
+	Since  time.Time
+	Until  time.Time
+	// Limit is at most 10000.
+	Limit int
+}
+
+func (f AuditFilter) query(format string) string {
+	q := url.Values{}
+	if f.Owner != "" {
+		q.Set("owner", f.Owner)
+	}
+	if f.Actor != "" {
+		q.Set("actor", f.Actor)
+	}
+	if f.Action != "" {
+		q.Set("action", f.Action)
+	}
+	if !f.Since.IsZero() {
+		q.Set("since", f.Since.UTC().Format(time.RFC3339))
+	}
+	if !f.Until.IsZero() {
+		q.Set("until", f.Until.UTC().Format(time.RFC3339))
+	}
+	if f.Limit > 0 {
+		q.Set("limit", strconv.Itoa(f.Limit))
+	}
+	if format != "" {
+		q.Set("format", format)
+	}
+	if len(q) == 0 {
+		return ""
+	}
+	return "?" + q.Encode()
+}
+
+// ProjectAudit returns the audit log of a project, newest first. It takes a
+// maintainer.
+func (c *Client) ProjectAudit(ctx context.Context, owner, project string, filter AuditFilter) ([]AuditEntry, error) {
+	filter.Owner = ""
+	return c.audit(ctx, projectPath(owner, project, "audit")+filter.query(""))
+}
+
+// AuditLog returns the audit log of the whole platform, newest first. Only
+// platform admins can read it.
+func (c *Client) AuditLog(ctx context.Context, filter AuditFilter) ([]AuditEntry, error) {
+	return c.audit(ctx, "/api/admin/audit"+filter.query(""))
+}
+
+func (c *Client) audit(ctx context.Context, path string) ([]AuditEntry, error) {
+	var res struct {
+		Data []AuditEntry `json:"data"`
+	}
+	err := c.do(ctx, request{method: http.MethodGet, path: path, idempotent: true}, &res)
+	if err != nil {
+		return nil, err
+	}
+	return res.Data, nil
+}
+
+// ExportProjectAudit returns the audit log of a project as csv. The caller
+// closes it.
+func (c *Client) ExportProjectAudit(ctx context.Context, owner, project string, filter AuditFilter) (io.ReadCloser, error) {
+	filter.Owner = ""
+	return c.download(ctx, projectPath(owner, project, "audit")+filter.query("csv"))
+}
+
+// ExportAuditLog returns the audit log of the whole platform as csv, for
+// platform admins. The caller closes it.
+func (c *Client) ExportAuditLog(ctx context.Context, filter AuditFilter) (io.ReadCloser, error) {
+	return c.download(ctx, "/api/admin/audit"+filter.query("csv"))
+}
+
+// download returns the body of a GET that isn't json, like a csv export.
+func (c *Client) download(ctx context.Context, path string) (io.ReadCloser, error) {
+	resp, err := c.sendWith(ctx, c.httpClient, http.MethodGet, path, nil, "")
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		if err := decode(resp, nil); err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("pemasak: unexpected status %d", resp.StatusCode)
+	}
+	return resp.Body, nil
+}
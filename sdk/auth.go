@@ -0,0 +1,73 @@
+package pemasak
+
+import (
+	"context"
+	"errors"
+	"net/http"
+)
+
+// ErrTwoFactorRequired is returned by Login when the password was right but
+// the account has two-factor authentication on. Log in again with
+// LoginWithCode.
+var ErrTwoFactorRequired = errors.New("pemasak: two-factor code required")
+
+// User is the account the client is logged in as.
+type User struct {
+	ID       string `json:"id"`
+	Username string `json:"username"`
+	Name     string `json:"name"`
+}
+
+// Login starts a session. The session cookie is kept by the client and sent
+// with every later request.
+func (c *Client) Login(ctx context.Context, username, password string) error {
+	return c.LoginWithCode(ctx, username, password, "")
+}
+
+// LoginWithCode starts a session of an account with two-factor
+// authentication. Code is what the authenticator app shows or one of the
+// recovery codes, each recovery code works once.
+func (c *Client) LoginWithCode(ctx context.Context, username, password, code string) error {
+	body := map[string]string{
+		"username": username,
+		"password": password,
+	}
+	if code != "" {
+		body["code"] = code
+	}
+	resp, err := c.send(ctx, http.MethodPost, "/api/login", mustJSON(body))
+	if err != nil {
+		return err
+	}
+	// a successful login answers with a redirect to the dashboard
+	if resp.StatusCode == http.StatusFound {
+		resp.Body.Close()
+		return nil
+	}
+	err = decode(resp, nil)
+	var apiErr *APIError
+	if code == "" && errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusUnauthorized {
+		return ErrTwoFactorRequired
+	}
+	return err
+}
+
+// Logout ends the current session.
+func (c *Client) Logout(ctx context.Context) error {
+	resp, err := c.send(ctx, http.MethodPost, "/api/logout", nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// CurrentUser returns the logged in user, or ErrUnauthenticated.
+func (c *Client) CurrentUser(ctx context.Context) (*User, error) {
+	var user User
+	err := c.do(ctx, request{method: http.MethodGet, path: "/api/validate", idempotent: true}, &user)
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
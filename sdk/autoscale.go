@@ -0,0 +1,76 @@
+package pemasak
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// AutoscaleMetric is what an autoscaler scales on.
+type AutoscaleMetric string
+
+const (
+	// AutoscaleCPU is the average cpu of the containers of the process, in
+	// percent of one cpu.
+	AutoscaleCPU AutoscaleMetric = "cpu"
+	// AutoscaleLatency is the p95 latency of the requests of the app, in
+	// milliseconds.
+	AutoscaleLatency AutoscaleMetric = "latency"
+)
+
+// Autoscaler keeps the container count of a process between Min and
+// Max, scaling it in proportion to how far its metric is from Target. After a
+// change the platform waits for a cooldown before scaling again.
+type Autoscaler struct {
+	Process string          `json:"process"`
+	Min     int             `json:"min"`
+	Max     int             `json:"max"`
+	Metric  AutoscaleMetric `json:"metric"`
+	Target  float64         `json:"target"`
+	// LastScaledAt is nil until the autoscaler changed the count once.
+	LastScaledAt *time.Time `json:"last_scaled_at"`
+}
+
+// ListAutoscalers returns the autoscalers of a project.
+func (c *Client) ListAutoscalers(ctx context.Context, owner, project string) ([]Autoscaler, error) {
+	var res struct {
+		Data []Autoscaler `json:"data"`
+	}
+	err := c.do(ctx, request{method: http.MethodGet, path: projectPath(owner, project, "autoscale"), idempotent: true}, &res)
+	if err != nil {
+		return nil, err
+	}
+	return res.Data, nil
+}
+
+// SetAutoscaler creates or replaces the autoscaler of a process. An
+// autoscaled process can't be scaled by hand until its autoscaler is removed.
+func (c *Client) SetAutoscaler(ctx context.Context, owner, project string, a Autoscaler) (*Autoscaler, error) {
+	var res Autoscaler
+	err := c.do(ctx, request{
+		method: http.MethodPost,
+		path:   projectPath(owner, project, "autoscale"),
+		body: struct {
+			Process string          `json:"process"`
+			Min     int             `json:"min"`
+			Max     int             `json:"max"`
+			Metric  AutoscaleMetric `json:"metric"`
+			Target  float64         `json:"target"`
+		}{a.Process, a.Min, a.Max, a.Metric, a.Target},
+		idempotent: true,
+	}, &res)
+	if err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// RemoveAutoscaler stops autoscaling a process. It keeps the count it was
+// last scaled to.
+func (c *Client) RemoveAutoscaler(ctx context.Context, owner, project, process string) error {
+	return c.do(ctx, request{
+		method: http.MethodPost,
+		path:   projectPath(owner, project, "autoscale", url.PathEscape(process), "delete"),
+	}, nil)
+}
@@ -0,0 +1,78 @@
+package pemasak
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Backup is a dump of the postgres addon of a project, taken nightly or with
+// CaptureBackup. Only the newest successful backups are kept.
+type Backup struct {
+	ID     string    `json:"id"`
+	Status RunStatus `json:"status"`
+	// Manual is set for backups captured on request instead of the schedule.
+	Manual bool `json:"manual"`
+	// Size of the dump in bytes, nil until it is stored.
+	Size *int64 `json:"size"`
+	// Error says why a failed backup failed.
+	Error      string     `json:"error"`
+	StartedAt  time.Time  `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at"`
+}
+
+// RestoreOptions controls where RestoreBackup puts the data.
+type RestoreOptions struct {
+	// NewDatabase restores into a new database next to the current one
+	// instead of replacing the data the app uses.
+	NewDatabase bool
+}
+
+// ListBackups returns the backups of the postgres addon of a project, newest
+// first.
+func (c *Client) ListBackups(ctx context.Context, owner, project string) ([]Backup, error) {
+	var res struct {
+		Data []Backup `json:"data"`
+	}
+	err := c.do(ctx, request{method: http.MethodGet, path: projectPath(owner, project, "backups"), idempotent: true}, &res)
+	if err != nil {
+		return nil, err
+	}
+	return res.Data, nil
+}
+
+// CaptureBackup starts a backup of the postgres addon and returns its id. The
+// dump runs in the background, follow it with ListBackups.
+func (c *Client) CaptureBackup(ctx context.Context, owner, project string) (string, error) {
+	var res struct {
+		ID string `json:"id"`
+	}
+	err := c.do(ctx, request{method: http.MethodPost, path: projectPath(owner, project, "backups")}, &res)
+	if err != nil {
+		return "", err
+	}
+	return res.ID, nil
+}
+
+// RestoreBackup loads a successful backup into the postgres addon and returns
+// the name of the database it went into. It returns once the restore is done,
+// which takes a while for large databases. A failed restore leaves the data
+// as it was.
+func (c *Client) RestoreBackup(ctx context.Context, owner, project, backupID string, opts RestoreOptions) (string, error) {
+	var res struct {
+		Database string `json:"database"`
+	}
+	err := c.do(ctx, request{
+		method: http.MethodPost,
+		path:   projectPath(owner, project, "backups", url.PathEscape(backupID), "restore"),
+		body: struct {
+			NewDatabase bool `json:"new_database"`
+		}{opts.NewDatabase},
+		untimed: true,
+	}, &res)
+	if err != nil {
+		return "", err
+	}
+	return res.Database, nil
+}
@@ -0,0 +1,44 @@
+package pemasak
+
+import (
+	"context"
+	"net/http"
+)
+
+// BasicAuth is whether visitors log in before the proxy lets them through
+// to an app, with HTTP basic auth. The password is only kept hashed.
+type BasicAuth struct {
+	Enabled  bool   `json:"enabled"`
+	Username string `json:"username"`
+}
+
+// GetBasicAuth returns whether an app needs a login.
+func (c *Client) GetBasicAuth(ctx context.Context, owner, project string) (*BasicAuth, error) {
+	var res BasicAuth
+	err := c.do(ctx, request{method: http.MethodGet, path: projectPath(owner, project, "basic-auth"), idempotent: true}, &res)
+	if err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// EnableBasicAuth makes visitors of an app log in with username and
+// password, replacing an earlier login. The password needs at least 8
+// characters and the username can't have a colon.
+func (c *Client) EnableBasicAuth(ctx context.Context, owner, project, username, password string) error {
+	return c.do(ctx, request{
+		method:     http.MethodPost,
+		path:       projectPath(owner, project, "basic-auth"),
+		body:       map[string]string{"username": username, "password": password},
+		idempotent: true,
+	}, nil)
+}
+
+// DisableBasicAuth lets everyone through to an app again.
+func (c *Client) DisableBasicAuth(ctx context.Context, owner, project string) error {
+	return c.do(ctx, request{
+		method:     http.MethodPost,
+		path:       projectPath(owner, project, "basic-auth", "delete"),
+		idempotent: true,
+	}, nil)
+}
@@ -0,0 +1,96 @@
+package pemasak
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// BuildStatus is the state of a build.
+type BuildStatus string
+
+const (
+	BuildPending    BuildStatus = "PENDING"
+	BuildBuilding   BuildStatus = "BUILDING"
+	BuildSuccessful BuildStatus = "SUCCESSFUL"
+	BuildFailed     BuildStatus = "FAILED"
+	BuildCancelled  BuildStatus = "CANCELLED"
+)
+
+// Build is one deploy attempt. Every successful build is a release of the
+// project.
+type Build struct {
+	ID         string      `json:"id"`
+	Status     BuildStatus `json:"status"`
+	CreatedAt  time.Time   `json:"created_at"`
+	FinishedAt *time.Time  `json:"finished_at"`
+}
+
+// BuildDetail is a build together with its build log.
+type BuildDetail struct {
+	Build
+	// QueuePosition is where a pending build waits in the build queue, 1
+	// starts next. Nil once it runs.
+	QueuePosition *int   `json:"queue_position"`
+	Logs          string `json:"logs"`
+}
+
+// ListBuilds returns every build of a project, newest first. It fetches page
+// after page, use ListBuildsPage for one.
+func (c *Client) ListBuilds(ctx context.Context, owner, project string) ([]Build, error) {
+	return collect(ctx, func(page ListOptions) (Page[Build], error) {
+		return c.ListBuildsPage(ctx, owner, project, "", page)
+	})
+}
+
+// ListBuildsPage returns a page of the builds of a project, newest first.
+// A status only returns the builds in it.
+func (c *Client) ListBuildsPage(ctx context.Context, owner, project string, status BuildStatus, page ListOptions) (Page[Build], error) {
+	q := url.Values{}
+	if status != "" {
+		q.Set("status", string(status))
+	}
+	page.set(q)
+
+	var res Page[Build]
+	err := c.do(ctx, request{method: http.MethodGet, path: pathWithQuery(projectPath(owner, project, "builds"), q), idempotent: true}, &res)
+	return res, err
+}
+
+// GetBuild returns a single build and its log.
+func (c *Client) GetBuild(ctx context.Context, owner, project, buildID string) (*BuildDetail, error) {
+	var res BuildDetail
+	err := c.do(ctx, request{
+		method:     http.MethodGet,
+		path:       projectPath(owner, project, "builds", url.PathEscape(buildID)),
+		idempotent: true,
+	}, &res)
+	if err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// CancelBuild takes a waiting build out of the queue, or stops a running one
+// while it builds. A build already starting its new container can't be
+// cancelled and returns an *APIError with status 409.
+func (c *Client) CancelBuild(ctx context.Context, owner, project, buildID string) error {
+	return c.do(ctx, request{
+		method:     http.MethodPost,
+		path:       projectPath(owner, project, "builds", url.PathEscape(buildID), "cancel"),
+		idempotent: true,
+	}, nil)
+}
+
+// Logs returns the most recent output of the running container.
+func (c *Client) Logs(ctx context.Context, owner, project string) (string, error) {
+	var res struct {
+		Logs string `json:"logs"`
+	}
+	err := c.do(ctx, request{method: http.MethodGet, path: projectPath(owner, project, "logs"), idempotent: true}, &res)
+	if err != nil {
+		return "", err
+	}
+	return res.Logs, nil
+}
@@ -0,0 +1,46 @@
+package pemasak
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+)
+
+// ImportReport is what ImportProject did to a project.
+type ImportReport struct {
+	// Changes are what the manifest of the bundle changed, like "added
+	// postgres", and the autoscalers it set.
+	Changes []string `json:"changes"`
+	// Domains were added unverified, SkippedDomains belong to another
+	// project of the platform.
+	Domains        []string `json:"domains"`
+	SkippedDomains []string `json:"skipped_domains"`
+	// MissingEnv and MissingSecrets are variables the bundle names that the
+	// project doesn't have yet, set them with SetEnv and SetSecret.
+	MissingEnv     []string `json:"missing_env"`
+	MissingSecrets []string `json:"missing_secrets"`
+}
+
+// ExportProject returns the bundle of a project, its health check, header
+// rules, addons, formation, autoscalers, domains and the names of its
+// variables, as "json" or "yaml". Values of variables are never in it. The
+// caller closes it.
+func (c *Client) ExportProject(ctx context.Context, owner, project, format string) (io.ReadCloser, error) {
+	path := projectPath(owner, project, "export")
+	if format != "" {
+		path += "?" + url.Values{"format": {format}}.Encode()
+	}
+	return c.download(ctx, path)
+}
+
+// ImportProject applies a bundle made by ExportProject, as json or yaml, to a
+// project, usually a new one on another platform.
+func (c *Client) ImportProject(ctx context.Context, owner, project string, bundle []byte) (*ImportReport, error) {
+	var res ImportReport
+	err := c.do(ctx, request{method: http.MethodPost, path: projectPath(owner, project, "import"), raw: bundle}, &res)
+	if err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
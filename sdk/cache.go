@@ -0,0 +1,51 @@
+package pemasak
+
+import (
+	"context"
+	"net/http"
+)
+
+// CacheUsage is what the platform holds of an app in its edge cache.
+type CacheUsage struct {
+	// Enabled is Settings.EdgeCache.
+	Enabled bool `json:"enabled"`
+	// Entries is how many responses are cached, each variant of a URL
+	// counting on its own.
+	Entries int `json:"entries"`
+	// Bytes is their size together.
+	Bytes int64 `json:"bytes"`
+}
+
+// GetCache returns whether the edge cache of an app is on and how much of
+// it the app uses.
+func (c *Client) GetCache(ctx context.Context, owner, project string) (*CacheUsage, error) {
+	var res CacheUsage
+	err := c.do(ctx, request{method: http.MethodGet, path: projectPath(owner, project, "cache"), idempotent: true}, &res)
+	if err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// PurgeCache drops cached responses of an app so the next requests for them
+// reach it again, and returns how many were dropped. Paths are matched
+// without the query string; one ending in * is a prefix, like /assets/*. No
+// paths purges everything.
+func (c *Client) PurgeCache(ctx context.Context, owner, project string, paths []string) (int, error) {
+	if paths == nil {
+		paths = []string{}
+	}
+	var res struct {
+		Purged int `json:"purged"`
+	}
+	err := c.do(ctx, request{
+		method:     http.MethodPost,
+		path:       projectPath(owner, project, "cache", "purge"),
+		body:       map[string]any{"paths": paths},
+		idempotent: true,
+	}, &res)
+	if err != nil {
+		return 0, err
+	}
+	return res.Purged, nil
+}
@@ -0,0 +1,94 @@
+package pemasak
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// Canary is a build running next to the live release on Weight percent of
+// the requests, until it is promoted or aborted.
+type Canary struct {
+	BuildID string `json:"build_id"`
+	// Weight is the percent of requests sent to the canary.
+	Weight    int       `json:"weight"`
+	CreatedAt time.Time `json:"created_at"`
+	// Stable and Canary count the requests each side answered since the
+	// canary started.
+	Stable ReleaseStats `json:"stable"`
+	Canary ReleaseStats `json:"canary"`
+}
+
+// ReleaseStats are the requests one side of a canary answered.
+type ReleaseStats struct {
+	Requests int `json:"requests"`
+	// Errors are 5xx responses, requests the container failed to answer
+	// included.
+	Errors int `json:"errors"`
+	// P95 is the p95 latency in milliseconds, nil without requests.
+	P95 *float64 `json:"p95_ms"`
+}
+
+// ErrorRate is the fraction of requests that failed, 0 without requests.
+func (s ReleaseStats) ErrorRate() float64 {
+	if s.Requests == 0 {
+		return 0
+	}
+	return float64(s.Errors) / float64(s.Requests)
+}
+
+// GetCanary returns the canary of a project, nil when it has none.
+func (c *Client) GetCanary(ctx context.Context, owner, project string) (*Canary, error) {
+	var res struct {
+		Data *Canary `json:"data"`
+	}
+	err := c.do(ctx, request{method: http.MethodGet, path: projectPath(owner, project, "canary"), idempotent: true}, &res)
+	if err != nil {
+		return nil, err
+	}
+	return res.Data, nil
+}
+
+// DeployCanary queues a build of the pushed code that runs next to the live
+// release on weight percent of the requests instead of replacing it. An
+// earlier canary is replaced.
+func (c *Client) DeployCanary(ctx context.Context, owner, project string, weight int) error {
+	return c.do(ctx, request{
+		method: http.MethodPost,
+		path:   projectPath(owner, project, "canary"),
+		body: struct {
+			Weight int `json:"weight"`
+		}{weight},
+	}, nil)
+}
+
+// SetCanaryWeight sends weight percent of the requests to the canary.
+func (c *Client) SetCanaryWeight(ctx context.Context, owner, project string, weight int) error {
+	return c.do(ctx, request{
+		method: http.MethodPost,
+		path:   projectPath(owner, project, "canary", "promote"),
+		body: struct {
+			Weight int `json:"weight"`
+		}{weight},
+		idempotent: true,
+	}, nil)
+}
+
+// PromoteCanary queues making the canary the live release. Nothing is
+// rebuilt, the canary container takes over every request.
+func (c *Client) PromoteCanary(ctx context.Context, owner, project string) error {
+	return c.do(ctx, request{
+		method: http.MethodPost,
+		path:   projectPath(owner, project, "canary", "promote"),
+		body:   struct{}{},
+	}, nil)
+}
+
+// AbortCanary sends every request back to the live release and removes the
+// canary.
+func (c *Client) AbortCanary(ctx context.Context, owner, project string) error {
+	return c.do(ctx, request{
+		method: http.MethodPost,
+		path:   projectPath(owner, project, "canary", "abort"),
+	}, nil)
+}
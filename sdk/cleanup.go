@@ -0,0 +1,108 @@
+package pemasak
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// CleanupStatus is where an app is in the cleanup of inactive apps. An app
+// without requests or deploys for a while is flagged, stopped some days later
+// and deleted some days after that, unless a member restores it.
+type CleanupStatus struct {
+	// Enabled is whether the platform cleans up inactive apps at all.
+	Enabled bool `json:"enabled"`
+	// LastActiveAt is the latest of its creation, last request, build,
+	// release and a member keeping it.
+	LastActiveAt time.Time  `json:"last_active_at"`
+	Exempt       bool       `json:"exempt"`
+	FlaggedAt    *time.Time `json:"flagged_at"`
+	StoppedAt    *time.Time `json:"stopped_at"`
+	// FlagAt, StopAt and DeleteAt are when the next step happens if the app
+	// stays unused, only the one coming next is set.
+	FlagAt   *time.Time `json:"flag_at"`
+	StopAt   *time.Time `json:"stop_at"`
+	DeleteAt *time.Time `json:"delete_at"`
+}
+
+// CleanupApp is an app the cleanup flagged, stopped or is about to, or one
+// exempt from it.
+type CleanupApp struct {
+	Owner        string     `json:"owner"`
+	Project      string     `json:"project"`
+	LastActiveAt time.Time  `json:"last_active_at"`
+	Exempt       bool       `json:"exempt"`
+	Suspended    bool       `json:"suspended"`
+	FlaggedAt    *time.Time `json:"flagged_at"`
+	StoppedAt    *time.Time `json:"stopped_at"`
+	// NextStep is what the next sweep does: nothing, flag, unflag, stop or
+	// delete.
+	NextStep string     `json:"next_step"`
+	StopAt   *time.Time `json:"stop_at"`
+	DeleteAt *time.Time `json:"delete_at"`
+}
+
+// Cleanup returns where a project is in the cleanup of inactive apps.
+func (c *Client) Cleanup(ctx context.Context, owner, project string) (*CleanupStatus, error) {
+	var res CleanupStatus
+	err := c.do(ctx, request{method: http.MethodGet, path: projectPath(owner, project, "cleanup"), idempotent: true}, &res)
+	if err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// RestoreApp keeps a project the cleanup flagged or stopped, and starts it
+// again if it was stopped. It counts as used, so it gets the full time before
+// it is flagged again.
+func (c *Client) RestoreApp(ctx context.Context, owner, project string) error {
+	return c.do(ctx, request{method: http.MethodPost, path: projectPath(owner, project, "cleanup", "restore"), untimed: true}, nil)
+}
+
+// ListCleanup returns the apps the cleanup flagged, stopped or is about to,
+// and the exempt ones. Only platform admins can list them.
+func (c *Client) ListCleanup(ctx context.Context) ([]CleanupApp, bool, error) {
+	var res struct {
+		Enabled bool         `json:"enabled"`
+		Data    []CleanupApp `json:"data"`
+	}
+	err := c.do(ctx, request{method: http.MethodGet, path: "/api/admin/cleanup", idempotent: true}, &res)
+	if err != nil {
+		return nil, false, err
+	}
+	return res.Data, res.Enabled, nil
+}
+
+// SetCleanupExempt exempts an app from the cleanup, or puts it back with
+// exempt false. Exempting a flagged app unflags it, a stopped one stays
+// stopped until ResumeApp. Only platform admins can exempt apps.
+func (c *Client) SetCleanupExempt(ctx context.Context, owner, project string, exempt bool) error {
+	return c.do(ctx, request{
+		method:     http.MethodPost,
+		path:       adminAppPath(owner, project, "cleanup"),
+		body:       map[string]bool{"exempt": exempt},
+		idempotent: true,
+	}, nil)
+}
+
+// SetSelectedCleanupExempt exempts every app with the labels of selector from
+// the cleanup like SetCleanupExempt, and returns the apps as owner/project.
+// Only platform admins can exempt apps.
+func (c *Client) SetSelectedCleanupExempt(ctx context.Context, selector string, exempt bool) ([]string, error) {
+	var res struct {
+		Apps []string `json:"apps"`
+	}
+	err := c.do(ctx, request{
+		method: http.MethodPost,
+		path:   "/api/admin/apps/cleanup",
+		body: struct {
+			Selector string `json:"selector"`
+			Exempt   bool   `json:"exempt"`
+		}{selector, exempt},
+		idempotent: true,
+	}, &res)
+	if err != nil {
+		return nil, err
+	}
+	return res.Apps, nil
+}
@@ -0,0 +1,300 @@
+package pemasak
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// ErrUnauthenticated is returned when the session is missing or has expired.
+var ErrUnauthenticated = errors.New("pemasak: not logged in")
+
+// APIError is returned for any non-2xx response from the platform.
+type APIError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("pemasak: %d %s", e.StatusCode, http.StatusText(e.StatusCode))
+	}
+	return fmt.Sprintf("pemasak: %d %s", e.StatusCode, e.Message)
+}
+
+// Client talks to a single platform instance.
+type Client struct {
+	baseURL    *url.URL
+	httpClient *http.Client
+	maxRetries int
+	minBackoff time.Duration
+	maxBackoff time.Duration
+	cookies    []*http.Cookie
+	token      string
+}
+
+// Option configures a Client.
+type Option func(*Client)
+
+// WithHTTPClient replaces the underlying http.Client. The client must have a
+// cookie jar for Login to work, and must not follow redirects.
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Client) { c.httpClient = hc }
+}
+
+// WithRetries sets how many times an idempotent request is retried and the
+// bounds of the backoff between attempts.
+func WithRetries(max int, minBackoff, maxBackoff time.Duration) Option {
+	return func(c *Client) {
+		c.maxRetries = max
+		c.minBackoff = minBackoff
+		c.maxBackoff = maxBackoff
+	}
+}
+
+// New returns a Client for the platform at baseURL, e.g.
+// "https://pemasak.example.com".
+func New(baseURL string, opts ...Option) (*Client, error) {
+	u, err := url.Parse(strings.TrimSuffix(baseURL, "/"))
+	if err != nil {
+		return nil, fmt.Errorf("pemasak: invalid base url: %w", err)
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("pemasak: invalid base url %q", baseURL)
+	}
+
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Client{
+		baseURL: u,
+		httpClient: &http.Client{
+			Jar:     jar,
+			Timeout: 60 * time.Second,
+			// the platform answers unauthenticated requests with a redirect
+			// to the login page, which we want to see as-is
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		maxRetries: 3,
+		minBackoff: 500 * time.Millisecond,
+		maxBackoff: 8 * time.Second,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if c.cookies != nil && c.httpClient.Jar != nil {
+		c.httpClient.Jar.SetCookies(c.baseURL, c.cookies)
+	}
+	return c, nil
+}
+
+// WithCookies seeds the session with cookies saved from an earlier
+// Client.Cookies call.
+func WithCookies(cookies []*http.Cookie) Option {
+	return func(c *Client) { c.cookies = cookies }
+}
+
+// WithToken authenticates every request with an access token instead of a
+// session, e.g. in CI. Tokens start with "pmk_" and are created with
+// CreateToken or in the dashboard.
+func WithToken(token string) Option {
+	return func(c *Client) { c.token = token }
+}
+
+// Cookies returns the session cookies so callers can persist a login.
+func (c *Client) Cookies() []*http.Cookie {
+	if c.httpClient.Jar == nil {
+		return nil
+	}
+	return c.httpClient.Jar.Cookies(c.baseURL)
+}
+
+// BaseURL returns the platform url the client talks to.
+func (c *Client) BaseURL() string {
+	return c.baseURL.String()
+}
+
+type request struct {
+	method string
+	path   string
+	body   any
+	// raw is sent as is instead of body, as application/octet-stream.
+	raw        []byte
+	idempotent bool
+	// untimed requests wait on slow work like restores and skip the client
+	// timeout, ctx still applies.
+	untimed bool
+}
+
+// do sends req and decodes a JSON response body into out when out is non-nil.
+func (c *Client) do(ctx context.Context, req request, out any) error {
+	payload, contentType := req.raw, "application/octet-stream"
+	if req.body != nil {
+		var err error
+		payload, err = json.Marshal(req.body)
+		if err != nil {
+			return fmt.Errorf("pemasak: encode request: %w", err)
+		}
+		contentType = "application/json"
+	}
+
+	hc := c.httpClient
+	if req.untimed {
+		untimed := *c.httpClient
+		untimed.Timeout = 0
+		hc = &untimed
+	}
+
+	attempts := 1
+	if req.idempotent {
+		attempts += c.maxRetries
+	}
+
+	var lastErr error
+	for attempt := 0; attempt < attempts; attempt++ {
+		if attempt > 0 {
+			if err := sleep(ctx, c.backoff(attempt)); err != nil {
+				return err
+			}
+		}
+
+		resp, err := c.sendWith(ctx, hc, req.method, req.path, payload, contentType)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			lastErr = err
+			continue
+		}
+
+		err = decode(resp, out)
+		if retryable(resp.StatusCode) {
+			lastErr = err
+			continue
+		}
+		return err
+	}
+	return lastErr
+}
+
+func (c *Client) send(ctx context.Context, method, path string, payload []byte) (*http.Response, error) {
+	return c.sendWith(ctx, c.httpClient, method, path, payload, "application/json")
+}
+
+func (c *Client) sendWith(ctx context.Context, hc *http.Client, method, path string, payload []byte, contentType string) (*http.Response, error) {
+	var body io.Reader
+	if payload != nil {
+		body = bytes.NewReader(payload)
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, method, c.baseURL.String()+path, body)
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Accept", "application/json")
+	c.setAuth(httpReq.Header)
+	if payload != nil {
+		httpReq.Header.Set("Content-Type", contentType)
+	}
+	return hc.Do(httpReq)
+}
+
+// setAuth adds the access token, if any, to the headers of a request. The
+// session goes along in the cookie jar.
+func (c *Client) setAuth(h http.Header) {
+	if c.token != "" {
+		h.Set("Authorization", "Bearer "+c.token)
+	}
+}
+
+func decode(resp *http.Response, out any) error {
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusFound:
+		return ErrUnauthenticated
+	case resp.StatusCode == http.StatusForbidden:
+		// a role that may not do this explains itself, a missing session doesn't
+		var msg struct {
+			Message string `json:"message"`
+		}
+		if json.Unmarshal(data, &msg) != nil || msg.Message == "" {
+			return ErrUnauthenticated
+		}
+		return &APIError{StatusCode: resp.StatusCode, Message: msg.Message}
+	case resp.StatusCode < 200 || resp.StatusCode > 299:
+		apiErr := &APIError{StatusCode: resp.StatusCode}
+		var msg struct {
+			Message string `json:"message"`
+		}
+		if json.Unmarshal(data, &msg) == nil {
+			apiErr.Message = msg.Message
+		}
+		return apiErr
+	}
+
+	if out == nil || len(data) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(data, out); err != nil {
+		return fmt.Errorf("pemasak: decode response: %w", err)
+	}
+	return nil
+}
+
+func retryable(status int) bool {
+	return status == http.StatusTooManyRequests || status >= 500
+}
+
+func (c *Client) backoff(attempt int) time.Duration {
+	d := c.minBackoff << (attempt - 1)
+	if d <= 0 || d > c.maxBackoff {
+		d = c.maxBackoff
+	}
+	// full jitter so concurrent clients don't retry in lockstep
+	return time.Duration(rand.Int63n(int64(d) + 1))
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+func ownerPath(owner string, parts ...string) string {
+	p := "/api/owner/" + url.PathEscape(owner)
+	for _, part := range parts {
+		p += "/" + part
+	}
+	return p
+}
+
+func projectPath(owner, project string, parts ...string) string {
+	p := "/api/project/" + url.PathEscape(owner) + "/" + url.PathEscape(project)
+	for _, part := range parts {
+		p += "/" + part
+	}
+	return p
+}
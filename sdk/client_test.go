@@ -0,0 +1,189 @@
+package pemasak
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newTestClient returns a client for a server answering with handler, with
+// retries fast enough for tests.
+func newTestClient(t *testing.T, handler http.HandlerFunc, opts ...Option) *Client {
+	t.Helper()
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+	c, err := New(srv.URL, append([]Option{WithRetries(2, time.Millisecond, time.Millisecond)}, opts...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestErrorDecoding(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		body   string
+		want   error
+	}{
+		{name: "redirect to the login page", status: http.StatusFound, want: ErrUnauthenticated},
+		{name: "forbidden without a message", status: http.StatusForbidden, want: ErrUnauthenticated},
+		{name: "forbidden with a message", status: http.StatusForbidden, body: `{"message":"only maintainers may do this"}`,
+			want: &APIError{StatusCode: http.StatusForbidden, Message: "only maintainers may do this"}},
+		{name: "not found", status: http.StatusNotFound, body: `{"message":"project not found"}`,
+			want: &APIError{StatusCode: http.StatusNotFound, Message: "project not found"}},
+		{name: "not json", status: http.StatusBadRequest, body: "bad request",
+			want: &APIError{StatusCode: http.StatusBadRequest}},
+		{name: "server error", status: http.StatusInternalServerError,
+			want: &APIError{StatusCode: http.StatusInternalServerError}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+				if tt.status == http.StatusFound {
+					w.Header().Set("Location", "/login")
+				}
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.body))
+			})
+			_, _, err := c.ListPorts(context.Background(), "owner", "project")
+
+			var want *APIError
+			if !errors.As(tt.want, &want) {
+				if !errors.Is(err, tt.want) {
+					t.Fatalf("err = %v, want %v", err, tt.want)
+				}
+				return
+			}
+			var got *APIError
+			if !errors.As(err, &got) {
+				t.Fatalf("err = %v, want an APIError", err)
+			}
+			if *got != *want {
+				t.Fatalf("err = %+v, want %+v", got, want)
+			}
+		})
+	}
+}
+
+func TestDecodeResponse(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet || r.URL.EscapedPath() != "/api/project/some%20one/app/ports" {
+			t.Errorf("request = %s %s", r.Method, r.URL.EscapedPath())
+		}
+		if got := r.Header.Get("Accept"); got != "application/json" {
+			t.Errorf("Accept = %q", got)
+		}
+		w.Write([]byte(`{"data":[{"id":"p1","protocol":"tcp","port":40001,"target_port":1883}],"enabled":true}`))
+	})
+	ports, enabled, err := c.ListPorts(context.Background(), "some one", "app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !enabled || len(ports) != 1 || ports[0].ID != "p1" || ports[0].Port != 40001 || ports[0].TargetPort != 1883 {
+		t.Fatalf("ListPorts() = %+v, %v", ports, enabled)
+	}
+}
+
+func TestRetries(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		call   func(c *Client) error
+		want   int32
+	}{
+		{
+			name:   "idempotent on a server error",
+			status: http.StatusBadGateway,
+			call: func(c *Client) error {
+				_, _, err := c.ListPorts(context.Background(), "owner", "project")
+				return err
+			},
+			want: 3,
+		},
+		{
+			name:   "idempotent when rate limited",
+			status: http.StatusTooManyRequests,
+			call: func(c *Client) error {
+				_, _, err := c.ListPorts(context.Background(), "owner", "project")
+				return err
+			},
+			want: 3,
+		},
+		{
+			name:   "idempotent on a client error",
+			status: http.StatusNotFound,
+			call: func(c *Client) error {
+				_, _, err := c.ListPorts(context.Background(), "owner", "project")
+				return err
+			},
+			want: 1,
+		},
+		{
+			name:   "not idempotent",
+			status: http.StatusBadGateway,
+			call: func(c *Client) error {
+				_, err := c.LeasePort(context.Background(), "owner", "project", PortLease{Protocol: "tcp", TargetPort: 1883})
+				return err
+			},
+			want: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var attempts atomic.Int32
+			c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+				attempts.Add(1)
+				w.WriteHeader(tt.status)
+			})
+			var apiErr *APIError
+			if err := tt.call(c); !errors.As(err, &apiErr) || apiErr.StatusCode != tt.status {
+				t.Fatalf("err = %v, want a %d APIError", err, tt.status)
+			}
+			if got := attempts.Load(); got != tt.want {
+				t.Fatalf("attempts = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAuthHeaders(t *testing.T) {
+	tests := []struct {
+		name       string
+		opts       []Option
+		wantAuth   string
+		wantCookie string
+	}{
+		{name: "anonymous"},
+		{name: "access token", opts: []Option{WithToken("pmk_abc")}, wantAuth: "Bearer pmk_abc"},
+		{name: "saved session", opts: []Option{WithCookies([]*http.Cookie{{Name: "sid", Value: "s3ss10n", Path: "/"}})},
+			wantCookie: "s3ss10n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+				if got := r.Header.Get("Authorization"); got != tt.wantAuth {
+					t.Errorf("Authorization = %q, want %q", got, tt.wantAuth)
+				}
+				cookie := ""
+				if ck, err := r.Cookie("sid"); err == nil {
+					cookie = ck.Value
+				}
+				if cookie != tt.wantCookie {
+					t.Errorf("session cookie = %q, want %q", cookie, tt.wantCookie)
+				}
+				w.Write([]byte(`{"data":[]}`))
+			}, tt.opts...)
+			if _, _, err := c.ListPorts(context.Background(), "owner", "project"); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
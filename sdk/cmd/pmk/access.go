@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	pemasak "github.com/mustafasegf/pemasak-infra/sdk"
+)
+
+func newAccessCmd(opts *rootOptions) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "access",
+		Short: "Choose which client addresses can reach an app",
+		Long: `Choose which client addresses can reach an app.
+
+Ranges are in CIDR notation like 152.118.0.0/16, or a single address. Once
+a range is allowed the proxy answers 403 Forbidden to everyone outside the
+allowed ranges, like to keep an app on the campus network. Denied ranges
+get 403 even when they are allowed, to block an abusive client. The
+request never reaches the app. Without a subcommand the ranges are shown.
+Use --app or PMK_APP to pick the app.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			owner, project, err := opts.target(nil)
+			if err != nil {
+				return err
+			}
+			c, err := opts.client()
+			if err != nil {
+				return err
+			}
+			access, err := c.GetIPAccess(cmd.Context(), owner, project)
+			if err != nil {
+				return wrapAuth(err)
+			}
+
+			out := cmd.OutOrStdout()
+			if len(access.Allow) == 0 {
+				fmt.Fprintln(out, "allow: everyone")
+			} else {
+				fmt.Fprintf(out, "allow: %s\n", strings.Join(access.Allow, ", "))
+			}
+			if len(access.Deny) == 0 {
+				fmt.Fprintln(out, "deny: nobody")
+			} else {
+				fmt.Fprintf(out, "deny: %s\n", strings.Join(access.Deny, ", "))
+			}
+			return nil
+		},
+	}
+
+	// update changes the ranges of the app the way change says
+	update := func(cmd *cobra.Command, change func(*pemasak.IPAccess)) error {
+		owner, project, err := opts.target(nil)
+		if err != nil {
+			return err
+		}
+		c, err := opts.client()
+		if err != nil {
+			return err
+		}
+		access, err := c.GetIPAccess(cmd.Context(), owner, project)
+		if err != nil {
+			return wrapAuth(err)
+		}
+		change(access)
+		if err := c.SetIPAccess(cmd.Context(), owner, project, *access); err != nil {
+			return wrapAuth(err)
+		}
+		return nil
+	}
+	without := func(ranges, remove []string) []string {
+		return slices.DeleteFunc(ranges, func(r string) bool { return slices.Contains(remove, r) })
+	}
+
+	allow := &cobra.Command{
+		Use:   "allow RANGE...",
+		Short: "Only let these ranges and the other allowed ones through",
+		Example: `  pmk access allow 152.118.0.0/16
+  pmk access allow 10.0.0.0/8 2001:db8::/32`,
+		Args: cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return update(cmd, func(access *pemasak.IPAccess) {
+				access.Allow = append(without(access.Allow, args), args...)
+			})
+		},
+	}
+
+	deny := &cobra.Command{
+		Use:     "deny RANGE...",
+		Short:   "Block these ranges",
+		Example: `  pmk access deny 203.0.113.7`,
+		Args:    cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return update(cmd, func(access *pemasak.IPAccess) {
+				access.Deny = append(without(access.Deny, args), args...)
+			})
+		},
+	}
+
+	remove := &cobra.Command{
+		Use:   "remove RANGE...",
+		Short: "Take ranges off both lists",
+		Long: `Take ranges off both lists. A range is matched as the platform stored it,
+pmk access shows them.`,
+		Example: `  pmk access remove 203.0.113.7/32`,
+		Args:    cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return update(cmd, func(access *pemasak.IPAccess) {
+				access.Allow = without(access.Allow, args)
+				access.Deny = without(access.Deny, args)
+			})
+		},
+	}
+
+	reset := &cobra.Command{
+		Use:   "clear",
+		Short: "Let everyone through again",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return update(cmd, func(access *pemasak.IPAccess) {
+				*access = pemasak.IPAccess{}
+			})
+		},
+	}
+
+	cmd.AddCommand(allow, deny, remove, reset)
+	return cmd
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	pemasak "github.com/mustafasegf/pemasak-infra/sdk"
+)
+
+func newAddonsCmd(opts *rootOptions) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "addons",
+		Short: "Manage the services provisioned for an app",
+		Long: `Manage the services provisioned for an app.
+
+postgres is a dedicated database whose url the app gets as DATABASE_URL.
+s3 is a bucket on the object storage of the platform, the app gets it and
+credentials that only reach it as S3_ENDPOINT, S3_BUCKET and the AWS_
+variables the s3 sdks read. redis is a dedicated redis whose url the app
+gets as REDIS_URL, past its memory the least recently used keys are
+evicted. mail is an address on the mail relay of the platform, the app gets
+its credentials as SMTP_URL and sends up to a daily quota of recipients from
+MAIL_FROM. Addons are destroyed together with the app.
+Use --app or PMK_APP to pick the app.`,
+	}
+
+	cmd.AddCommand(
+		&cobra.Command{
+			Use:   "list",
+			Short: "List the addons",
+			Args:  cobra.NoArgs,
+			RunE: func(cmd *cobra.Command, args []string) error {
+				owner, project, err := opts.target(nil)
+				if err != nil {
+					return err
+				}
+				c, err := opts.client()
+				if err != nil {
+					return err
+				}
+				addons, err := c.ListAddons(cmd.Context(), owner, project)
+				if err != nil {
+					return wrapAuth(err)
+				}
+				w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+				fmt.Fprintln(w, "KIND\tNAME\tLIMIT\tCREATED")
+				for _, a := range addons {
+					limit := fmt.Sprintf("%d connections", a.ConnectionLimit)
+					switch {
+					case a.Kind == pemasak.AddonMail && a.Quota != nil:
+						limit = fmt.Sprintf("%d recipients/day", *a.Quota)
+					case a.Kind == pemasak.AddonMail:
+						limit = "platform quota"
+					case a.Quota != nil:
+						limit = fmt.Sprintf("%d MiB", *a.Quota)
+					}
+					fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", a.Kind, a.Name, limit, a.CreatedAt.Local().Format(time.DateTime))
+				}
+				return w.Flush()
+			},
+		},
+		&cobra.Command{
+			Use:       "create KIND",
+			Short:     "Provision an addon and restart the app with it",
+			Args:      cobra.ExactArgs(1),
+			ValidArgs: []string{string(pemasak.AddonPostgres), string(pemasak.AddonS3), string(pemasak.AddonRedis), string(pemasak.AddonMail)},
+			RunE: func(cmd *cobra.Command, args []string) error {
+				owner, project, err := opts.target(nil)
+				if err != nil {
+					return err
+				}
+				c, err := opts.client()
+				if err != nil {
+					return err
+				}
+				addon, err := c.CreateAddon(cmd.Context(), owner, project, pemasak.AddonKind(args[0]))
+				if err != nil {
+					return wrapAuth(err)
+				}
+				if addon.Kind == pemasak.AddonMail {
+					fmt.Fprintf(cmd.OutOrStdout(), "created %s, the app sends from it through SMTP_URL\n", addon.Name)
+					return nil
+				}
+				if addon.Quota != nil && addon.Kind == pemasak.AddonS3 {
+					fmt.Fprintf(cmd.OutOrStdout(), "created bucket %s, it holds %d MiB\n", addon.Name, *addon.Quota)
+					return nil
+				}
+				if addon.Quota != nil {
+					fmt.Fprintf(cmd.OutOrStdout(), "created %s, it holds %d MiB of keys\n", addon.Name, *addon.Quota)
+					return nil
+				}
+				fmt.Fprintf(cmd.OutOrStdout(), "created %s, it accepts %d connections\n", addon.Name, addon.ConnectionLimit)
+				return nil
+			},
+		},
+		&cobra.Command{
+			Use:       "destroy KIND",
+			Short:     "Remove an addon and all of its data",
+			Args:      cobra.ExactArgs(1),
+			ValidArgs: []string{string(pemasak.AddonPostgres), string(pemasak.AddonS3), string(pemasak.AddonRedis), string(pemasak.AddonMail)},
+			RunE: func(cmd *cobra.Command, args []string) error {
+				owner, project, err := opts.target(nil)
+				if err != nil {
+					return err
+				}
+				c, err := opts.client()
+				if err != nil {
+					return err
+				}
+				return wrapAuth(c.DestroyAddon(cmd.Context(), owner, project, pemasak.AddonKind(args[0])))
+			},
+		},
+		newBucketCmd(opts),
+		newRedisCmd(opts),
+		newMailCmd(opts),
+	)
+	return cmd
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	pemasak "github.com/mustafasegf/pemasak-infra/sdk"
+)
+
+func newAdminCmd(opts *rootOptions) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "admin",
+		Short: "Operate the platform, for platform admins",
+	}
+
+	var reason string
+	suspend := &cobra.Command{
+		Use:   "suspend [owner/project]",
+		Short: "Stop an app and keep it stopped",
+		Long: `Stop every container of an app and keep it stopped. Its domain answers 503,
+nothing is built, scaled or run on a schedule, and its members can only look
+at it. They see the reason.`,
+		Example: `  pmk admin suspend kelompok-3/api --reason "mining crypto"`,
+		Args:    cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			owner, project, err := opts.target(args)
+			if err != nil {
+				return err
+			}
+			c, err := opts.client()
+			if err != nil {
+				return err
+			}
+			if err := c.SuspendApp(cmd.Context(), owner, project, reason); err != nil {
+				return wrapAuth(err)
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "suspended %s/%s\n", owner, project)
+			return nil
+		},
+	}
+	suspend.Flags().StringVar(&reason, "reason", "", "why, shown to the members of the app")
+	_ = suspend.MarkFlagRequired("reason")
+
+	var stop bool
+	impersonate := &cobra.Command{
+		Use:   "impersonate [USERNAME]",
+		Short: "Act as a user, read-only, to see what they see",
+		Long: `Log your session in as another user to debug what they see. Every change is
+refused until you run pmk admin impersonate --stop. It takes a login, not
+PMK_TOKEN.`,
+		Example: `  pmk admin impersonate budi
+  pmk admin impersonate --stop`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if stop == (len(args) == 1) {
+				return fmt.Errorf("give a username, or --stop")
+			}
+			c, err := opts.client()
+			if err != nil {
+				return err
+			}
+			if stop {
+				if err := c.StopImpersonating(cmd.Context()); err != nil {
+					return wrapAuth(err)
+				}
+				fmt.Fprintln(cmd.OutOrStdout(), "back to yourself")
+				return nil
+			}
+			if err := c.Impersonate(cmd.Context(), args[0]); err != nil {
+				return wrapAuth(err)
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "acting as %s, read-only\n", args[0])
+			return nil
+		},
+	}
+	impersonate.Flags().BoolVar(&stop, "stop", false, "go back to yourself")
+
+	printHost := func(cmd *cobra.Command, host *pemasak.HostStatus) {
+		state := "serving"
+		switch {
+		case host.Drained:
+			state = "drained"
+		case host.Draining:
+			state = "draining"
+		}
+		fmt.Fprintf(cmd.OutOrStdout(), "%s, %d builds running, %d waiting\n", state, host.BuildsRunning, host.BuildsWaiting)
+	}
+
+	var appsFilter pemasak.ProjectFilter
+	var appsStatus string
+	apps := &cobra.Command{
+		Use:   "apps",
+		Short: "List every app with what it uses, the busiest first",
+		Example: `  pmk admin apps --status crashing
+  pmk admin apps --selector course=COMP301`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			appsFilter.Status = pemasak.ProjectStatus(appsStatus)
+			c, err := opts.client()
+			if err != nil {
+				return err
+			}
+			apps, err := c.ListAllApps(cmd.Context(), appsFilter)
+			if err != nil {
+				return wrapAuth(err)
+			}
+			sort.SliceStable(apps, func(i, j int) bool { return apps[i].CPUPercent > apps[j].CPUPercent })
+			w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "APP\tSTATUS\tCONTAINERS\tCPU\tMEMORY\tSUSPENDED")
+			for _, a := range apps {
+				suspended := "-"
+				if a.SuspendedAt != nil {
+					suspended = a.SuspendedReason
+				}
+				fmt.Fprintf(w, "%s/%s\t%s\t%d\t%.1f%%\t%s\t%s\n",
+					a.Owner, a.Project, a.Status, a.Containers, a.CPUPercent, formatSize(a.MemoryBytes), suspended)
+			}
+			return w.Flush()
+		},
+	}
+	apps.Flags().StringVar(&appsFilter.Owner, "owner", "", "only list the apps of this owner")
+	apps.Flags().StringVar(&appsStatus, "status", "", "only list apps in this status, see pmk apps list")
+	apps.Flags().StringVarP(&appsFilter.Selector, "selector", "l", "", "only list apps with these labels, see pmk labels")
+
+	cmd.AddCommand(
+		apps,
+		&cobra.Command{
+			Use:   "containers",
+			Short: "List every running container, the busiest first",
+			Args:  cobra.NoArgs,
+			RunE: func(cmd *cobra.Command, args []string) error {
+				c, err := opts.client()
+				if err != nil {
+					return err
+				}
+				containers, err := c.ListAllContainers(cmd.Context())
+				if err != nil {
+					return wrapAuth(err)
+				}
+				w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+				fmt.Fprintln(w, "CONTAINER\tAPP\tPROCESS\tCPU\tMEMORY\tRESTARTS\tSAMPLED")
+				for _, ct := range containers {
+					fmt.Fprintf(w, "%s\t%s/%s\t%s\t%.1f%%\t%s / %s\t%d\t%s\n",
+						ct.Name, ct.Owner, ct.Project, ct.Process, ct.CPUPercent,
+						formatSize(ct.MemoryBytes), formatSize(ct.MemoryLimit), ct.Restarts,
+						ct.RecordedAt.Local().Format(time.DateTime))
+				}
+				return w.Flush()
+			},
+		},
+		suspend,
+		&cobra.Command{
+			Use:   "resume [owner/project]",
+			Short: "Lift a suspension and start the app again",
+			Args:  cobra.MaximumNArgs(1),
+			RunE: func(cmd *cobra.Command, args []string) error {
+				owner, project, err := opts.target(args)
+				if err != nil {
+					return err
+				}
+				c, err := opts.client()
+				if err != nil {
+					return err
+				}
+				if err := c.ResumeApp(cmd.Context(), owner, project); err != nil {
+					return wrapAuth(err)
+				}
+				fmt.Fprintf(cmd.OutOrStdout(), "resumed %s/%s\n", owner, project)
+				return nil
+			},
+		},
+		newAdminCleanupCmd(opts),
+		newAdminLimitsCmd(opts),
+		newAdminNodesCmd(opts),
+		newAdminQuotasCmd(opts),
+		newAdminUsageCmd(opts),
+		impersonate,
+		&cobra.Command{
+			Use:   "host",
+			Short: "Show whether the host is draining",
+			Args:  cobra.NoArgs,
+			RunE: func(cmd *cobra.Command, args []string) error {
+				c, err := opts.client()
+				if err != nil {
+					return err
+				}
+				host, err := c.Host(cmd.Context())
+				if err != nil {
+					return wrapAuth(err)
+				}
+				printHost(cmd, host)
+				return nil
+			},
+		},
+		&cobra.Command{
+			Use:   "drain",
+			Short: "Stop starting builds before maintenance",
+			Long: `Stop starting builds so the host can go down once the running ones finish.
+Apps keep serving and new builds wait. Check progress with pmk admin host.
+Draining ends with pmk admin undrain, or when the platform restarts.`,
+			Args: cobra.NoArgs,
+			RunE: func(cmd *cobra.Command, args []string) error {
+				c, err := opts.client()
+				if err != nil {
+					return err
+				}
+				host, err := c.DrainHost(cmd.Context())
+				if err != nil {
+					return wrapAuth(err)
+				}
+				printHost(cmd, host)
+				return nil
+			},
+		},
+		&cobra.Command{
+			Use:   "reconcile",
+			Short: "Repair builds and containers that drifted from the database",
+			Long: `Repair builds and containers that drifted from the database, like after a
+reboot of the host. Builds the platform lost are marked failed, containers of
+deleted apps and of deploys that stopped halfway are removed and apps whose
+container is gone are deployed again. Containers of deleted apps with volumes
+are only listed, remove them by hand. The platform does this on its own every
+container.reconcileinterval seconds.`,
+			Args: cobra.NoArgs,
+			RunE: func(cmd *cobra.Command, args []string) error {
+				c, err := opts.client()
+				if err != nil {
+					return err
+				}
+				r, err := c.Reconcile(cmd.Context())
+				if err != nil {
+					return wrapAuth(err)
+				}
+				out := cmd.OutOrStdout()
+				for _, id := range r.FailedBuilds {
+					fmt.Fprintf(out, "failed lost build %s\n", id)
+				}
+				for _, name := range r.RemovedContainers {
+					fmt.Fprintf(out, "removed container %s\n", name)
+				}
+				for _, app := range r.RestoredApps {
+					fmt.Fprintf(out, "deploying %s again, its container was gone\n", app)
+				}
+				for _, name := range r.KeptContainers {
+					fmt.Fprintf(out, "kept container %s, it has volumes but no app\n", name)
+				}
+				if len(r.FailedBuilds)+len(r.RemovedContainers)+len(r.RestoredApps)+len(r.KeptContainers) == 0 {
+					fmt.Fprintln(out, "nothing to reconcile")
+				}
+				return nil
+			},
+		},
+		&cobra.Command{
+			Use:   "undrain",
+			Short: "Start the builds that waited while draining",
+			Args:  cobra.NoArgs,
+			RunE: func(cmd *cobra.Command, args []string) error {
+				c, err := opts.client()
+				if err != nil {
+					return err
+				}
+				host, err := c.ResumeHost(cmd.Context())
+				if err != nil {
+					return wrapAuth(err)
+				}
+				printHost(cmd, host)
+				return nil
+			},
+		},
+	)
+	return cmd
+}
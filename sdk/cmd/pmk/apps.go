@@ -0,0 +1,434 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+
+	pemasak "github.com/mustafasegf/pemasak-infra/sdk"
+)
+
+func newAppsCmd(opts *rootOptions) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "apps",
+		Aliases: []string{"app"},
+		Short:   "List, create, clone, export and delete apps",
+	}
+
+	var cloneImage bool
+	clone := &cobra.Command{
+		Use:   "clone owner/project [owner/project]",
+		Short: "Copy an app into a new one and print its git remote",
+		Long: `Copy an app into a new one and print its git remote.
+
+The clone gets the settings, environment variables, secrets, cron jobs and
+repository of the app. Secrets set with pmk env set --no-copy stay behind,
+and so do add-ons, volumes, domains and everything else holding data of the
+app. Without a second argument the clone goes to your account under the same
+name.
+
+You can clone the apps you maintain, and any app made cloneable with
+pmk cloneable on. With --image the clone also starts running the live
+release of the app, otherwise it waits for its first push.`,
+		Example: `  pmk apps clone course/reference
+  pmk apps clone course/reference budi/tugas-1 --image`,
+		Args: cobra.RangeArgs(1, 2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			owner, project, err := splitApp(args[0])
+			if err != nil {
+				return err
+			}
+			var to pemasak.CloneOptions
+			if len(args) == 2 {
+				if to.Owner, to.Project, err = splitApp(args[1]); err != nil {
+					return err
+				}
+			}
+			to.Image = cloneImage
+			c, err := opts.client()
+			if err != nil {
+				return err
+			}
+			cloned, err := c.CloneProject(cmd.Context(), owner, project, to)
+			if err != nil {
+				return wrapAuth(err)
+			}
+			out := cmd.OutOrStdout()
+			fmt.Fprintf(out, "Cloned %s to %s/%s\n\n", cloned.ClonedFrom, cloned.OwnerName, cloned.ProjectName)
+			fmt.Fprintf(out, "Git remote:   %s\n", cloned.Domain)
+			fmt.Fprintf(out, "Git username: %s\n", cloned.GitUsername)
+			fmt.Fprintf(out, "Git password: %s\n\n", cloned.GitPassword)
+			fmt.Fprintln(out, "The password is only shown once.")
+			if cloned.Deploying {
+				fmt.Fprintf(out, "The live release of %s is being deployed to the clone.\n", cloned.ClonedFrom)
+			}
+			return nil
+		},
+	}
+	clone.Flags().BoolVar(&cloneImage, "image", false, "also deploy the live release of the app to the clone")
+
+	var exportFormat string
+	export := &cobra.Command{
+		Use:   "export [owner/project]",
+		Short: "Print the bundle of an app, to import it on another platform",
+		Long: `Print the bundle of an app, to import it on another platform.
+
+The bundle has the health check, header rules, add-ons, process counts,
+autoscalers and domains of the app, and the names of its environment
+variables and secrets. Values of variables are never in it, so it can be kept
+in the repository of the app. Data of the app, like its database and volumes,
+isn't either, use pmk backups and pmk volumes download for that.`,
+		Example: `  pmk apps export course/reference > reference.yaml
+  pmk apps export --format json > app.json`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			owner, project, err := opts.target(args)
+			if err != nil {
+				return err
+			}
+			c, err := opts.client()
+			if err != nil {
+				return err
+			}
+			bundle, err := c.ExportProject(cmd.Context(), owner, project, exportFormat)
+			if err != nil {
+				return wrapAuth(err)
+			}
+			defer bundle.Close()
+			_, err = io.Copy(cmd.OutOrStdout(), bundle)
+			return err
+		},
+	}
+	export.Flags().StringVar(&exportFormat, "format", "yaml", "yaml or json")
+
+	var importExisting bool
+	imp := &cobra.Command{
+		Use:   "import FILE owner/project",
+		Short: "Create an app from a bundle made by pmk apps export",
+		Long: `Create an app from a bundle made by pmk apps export and print its git remote.
+
+Add-ons the bundle lists are created, autoscalers and process counts are set,
+and its domains are added unverified, they get certificates once they point
+at this platform. Set the variables listed as missing with pmk env set, then
+push the code. With --existing the bundle is applied to an app that already
+exists instead, over what it has. FILE is - to read the bundle from stdin.`,
+		Example: `  pmk apps import reference.yaml course/reference
+  pmk apps import app.json budi/tugas-1 --existing`,
+		Args: cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var bundle []byte
+			var err error
+			if args[0] == "-" {
+				bundle, err = io.ReadAll(cmd.InOrStdin())
+			} else {
+				bundle, err = os.ReadFile(args[0])
+			}
+			if err != nil {
+				return err
+			}
+			owner, project, err := splitApp(args[1])
+			if err != nil {
+				return err
+			}
+			c, err := opts.client()
+			if err != nil {
+				return err
+			}
+			out := cmd.OutOrStdout()
+			if !importExisting {
+				created, err := c.CreateProject(cmd.Context(), owner, project)
+				if err != nil {
+					return wrapAuth(err)
+				}
+				fmt.Fprintf(out, "Created %s/%s\n\n", created.OwnerName, created.ProjectName)
+				fmt.Fprintf(out, "Git remote:   %s\n", created.Domain)
+				fmt.Fprintf(out, "Git username: %s\n", created.GitUsername)
+				fmt.Fprintf(out, "Git password: %s\n\n", created.GitPassword)
+				fmt.Fprintln(out, "The password is only shown once.")
+			}
+			report, err := c.ImportProject(cmd.Context(), owner, project, bundle)
+			if err != nil {
+				return wrapAuth(err)
+			}
+			if len(report.Changes) > 0 {
+				fmt.Fprintf(out, "Applied: %s\n", strings.Join(report.Changes, ", "))
+			}
+			if len(report.Domains) > 0 {
+				fmt.Fprintf(out, "Domains added unverified: %s\n", strings.Join(report.Domains, ", "))
+			}
+			if len(report.SkippedDomains) > 0 {
+				fmt.Fprintf(out, "Domains used by another app: %s\n", strings.Join(report.SkippedDomains, ", "))
+			}
+			if len(report.MissingEnv) > 0 {
+				fmt.Fprintf(out, "Set with pmk env set: %s\n", strings.Join(report.MissingEnv, ", "))
+			}
+			if len(report.MissingSecrets) > 0 {
+				fmt.Fprintf(out, "Set with pmk env set --secret: %s\n", strings.Join(report.MissingSecrets, ", "))
+			}
+			return nil
+		},
+	}
+	imp.Flags().BoolVar(&importExisting, "existing", false, "apply the bundle to an app that already exists")
+
+	var filter pemasak.ProjectFilter
+	var status string
+	list := &cobra.Command{
+		Use:   "list",
+		Short: "List your apps, the newest first",
+		Example: `  pmk apps list
+  pmk apps list --owner kelas-ppl --status crashing
+  pmk apps list --selector course=COMP301,assignment=3`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			filter.Status = pemasak.ProjectStatus(status)
+			c, err := opts.client()
+			if err != nil {
+				return err
+			}
+			projects, err := c.ListProjects(cmd.Context(), filter)
+			if err != nil {
+				return wrapAuth(err)
+			}
+			w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "APP\tSTATUS\tLABELS\tID")
+			for _, p := range projects {
+				fmt.Fprintf(w, "%s/%s\t%s\t%s\t%s\n", p.OwnerName, p.Name, p.Status, formatLabels(p.Labels), p.ID)
+			}
+			return w.Flush()
+		},
+	}
+	list.Flags().StringVar(&filter.Owner, "owner", "", "only list the apps of this owner")
+	list.Flags().StringVar(&status, "status", "", "only list apps that are running, new, stopped, suspended, crashing or in maintenance")
+	list.Flags().StringVarP(&filter.Selector, "selector", "l", "", "only list apps with these labels, see pmk labels")
+
+	cmd.AddCommand(
+		list,
+		newCreateCmd(opts),
+		clone,
+		export,
+		imp,
+		newBulkCmd(opts, pemasak.BulkRestart, "Restart apps, stopping every container and starting it again", ""),
+		newBulkCmd(opts, pemasak.BulkStop, "Stop apps until pmk apps start", `Stop apps until pmk apps start.
+
+A stopped app keeps its releases and data, its requests get a 503 and its
+cron jobs don't run. Stopping and starting needs the maintainer role.`),
+		newBulkCmd(opts, pemasak.BulkStart, "Start apps stopped with pmk apps stop", ""),
+		newBulkCmd(opts, pemasak.BulkDelete, "Delete apps, their containers and their databases", ""),
+	)
+	return cmd
+}
+
+// newBulkCmd is pmk apps restart, stop, start and delete, on the apps given or
+// the ones --owner and --status select.
+func newBulkCmd(opts *rootOptions, action pemasak.BulkAction, short, long string) *cobra.Command {
+	var filter pemasak.ProjectFilter
+	var status string
+	var yes bool
+	cmd := &cobra.Command{
+		Use:   string(action) + " [owner/project...]",
+		Short: short,
+		Long:  long,
+		Example: fmt.Sprintf(`  pmk apps %[1]s budi/tugas-1 budi/tugas-2
+  pmk apps %[1]s --owner kelas-ppl --status crashing
+  pmk apps %[1]s --selector course=COMP301,assignment=3`, action),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			filter.Status = pemasak.ProjectStatus(status)
+			selecting := filter.Owner != "" || filter.Status != "" || filter.Selector != ""
+			if selecting == (len(args) > 0) {
+				return fmt.Errorf("give the apps or select them with --owner, --status and --selector, not both")
+			}
+			c, err := opts.client()
+			if err != nil {
+				return err
+			}
+			apps := args
+			for _, app := range apps {
+				if _, _, err := splitApp(app); err != nil {
+					return err
+				}
+			}
+			if selecting {
+				projects, err := c.ListProjects(cmd.Context(), filter)
+				if err != nil {
+					return wrapAuth(err)
+				}
+				for _, p := range projects {
+					apps = append(apps, p.OwnerName+"/"+p.Name)
+				}
+				if len(apps) == 0 {
+					fmt.Fprintln(cmd.OutOrStdout(), "No apps match.")
+					return nil
+				}
+				if action == pemasak.BulkDelete && !yes {
+					return fmt.Errorf("this deletes %d apps: %s, run it again with --yes", len(apps), strings.Join(apps, ", "))
+				}
+			}
+
+			results, err := c.BulkProjects(cmd.Context(), action, apps)
+			w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+			failed := 0
+			for _, r := range results {
+				result := "ok"
+				if !r.OK {
+					result = "failed"
+					failed++
+				}
+				fmt.Fprintf(w, "%s\t%s\t%s\n", r.App, result, r.Message)
+			}
+			if err := w.Flush(); err != nil {
+				return err
+			}
+			if err != nil {
+				return wrapAuth(err)
+			}
+			if failed > 0 {
+				return fmt.Errorf("%s failed for %d of %d apps", action, failed, len(results))
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&filter.Owner, "owner", "", "act on the apps of this owner")
+	cmd.Flags().StringVar(&status, "status", "", "act on the apps in this status, see pmk apps list")
+	cmd.Flags().StringVarP(&filter.Selector, "selector", "l", "", "act on the apps with these labels, see pmk labels")
+	if action == pemasak.BulkDelete {
+		cmd.Flags().BoolVar(&yes, "yes", false, "delete the apps --owner, --status and --selector select without asking")
+	}
+	return cmd
+}
+
+// newCreateCmd is pmk apps create, and pmk create for starting from a template.
+func newCreateCmd(opts *rootOptions) *cobra.Command {
+	var template string
+	cmd := &cobra.Command{
+		Use:   "create owner/project",
+		Short: "Create an app and print its git remote",
+		Long: `Create an app and print its git remote.
+
+With --template the app starts from a template and is deployed right away:
+its files become the first commit of the repository, clone it to keep
+working. See pmk templates list for the ones there are, like go-http,
+go-postgres, nextjs, flask and rust-axum, and the ones courses registered as
+owner/name.`,
+		Example: `  pmk create budi/tugas-1 --template go-http
+  pmk create budi/tugas-2 --template kelas-ppl/django-starter
+  pmk apps create budi/api`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			owner, project, err := splitApp(args[0])
+			if err != nil {
+				return err
+			}
+			c, err := opts.client()
+			if err != nil {
+				return err
+			}
+			created, err := c.CreateProject(cmd.Context(), owner, project)
+			if err != nil {
+				return wrapAuth(err)
+			}
+			out := cmd.OutOrStdout()
+			fmt.Fprintf(out, "Created %s/%s\n\n", created.OwnerName, created.ProjectName)
+			fmt.Fprintf(out, "Git remote:   %s\n", created.Domain)
+			fmt.Fprintf(out, "Git username: %s\n", created.GitUsername)
+			fmt.Fprintf(out, "Git password: %s\n\n", created.GitPassword)
+			fmt.Fprintln(out, "The password is only shown once.")
+			if template == "" {
+				return nil
+			}
+			scaffolded, err := c.Scaffold(cmd.Context(), owner, project, template)
+			if err != nil {
+				return fmt.Errorf("created %s/%s but couldn't start it from %s: %w", owner, project, template, wrapAuth(err))
+			}
+			fmt.Fprintf(out, "Started from %s (commit %.7s), deploying. Follow it with pmk builds %s/%s.\n",
+				scaffolded.Template, scaffolded.Commit, owner, project)
+			return nil
+		},
+	}
+	cmd.Flags().StringVarP(&template, "template", "t", "", "start from a template and deploy it, see pmk templates list")
+	return cmd
+}
+
+func newDeployCmd(opts *rootOptions) *cobra.Command {
+	var canary, image, source, message string
+
+	cmd := &cobra.Command{
+		Use:   "deploy [owner/project]",
+		Short: "Rebuild and redeploy the last pushed commit",
+		Long: `Rebuild and redeploy the last pushed commit.
+
+With --canary the build runs next to the live release on that share of the
+requests instead of replacing it, see pmk releases canary.
+
+With --image nothing is built, the image is pulled from its registry and
+deployed. Logins for private registries are set with pmk registries.
+
+With --source a directory or a .tar.gz, .tar or .zip archive is uploaded
+instead of pushing with git. It is committed to the repository of the app
+and built like a push, --message sets the commit message.`,
+		Example: `  pmk deploy owner/myapp
+  pmk deploy owner/myapp --canary 10%
+  pmk deploy owner/myapp --image ghcr.io/owner/myapp:v1
+  pmk deploy owner/myapp --source ./dist --message "CI build 42"`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			owner, project, err := opts.target(args)
+			if err != nil {
+				return err
+			}
+			c, err := opts.client()
+			if err != nil {
+				return err
+			}
+			if source != "" {
+				if image != "" || canary != "" {
+					return fmt.Errorf("--source can't be combined with --image or --canary")
+				}
+				archive, err := sourceArchive(source)
+				if err != nil {
+					return err
+				}
+				commit, err := c.DeployArchive(cmd.Context(), owner, project, archive, message)
+				if err != nil {
+					return wrapAuth(err)
+				}
+				fmt.Fprintf(cmd.OutOrStdout(), "Build of %s queued for %s/%s\n", commit[:min(len(commit), 7)], owner, project)
+				return nil
+			}
+			if image != "" {
+				if canary != "" {
+					return fmt.Errorf("--image can't be deployed as a canary")
+				}
+				if err := c.DeployImage(cmd.Context(), owner, project, image); err != nil {
+					return wrapAuth(err)
+				}
+				fmt.Fprintf(cmd.OutOrStdout(), "Deploy of %s queued for %s/%s\n", image, owner, project)
+				return nil
+			}
+			if canary != "" {
+				weight, err := parsePercent(canary)
+				if err != nil {
+					return err
+				}
+				if err := c.DeployCanary(cmd.Context(), owner, project, weight); err != nil {
+					return wrapAuth(err)
+				}
+				fmt.Fprintf(cmd.OutOrStdout(), "Canary build on %d%% of requests queued for %s/%s\n", weight, owner, project)
+				return nil
+			}
+			if err := c.Deploy(cmd.Context(), owner, project); err != nil {
+				return wrapAuth(err)
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "Build queued for %s/%s\n", owner, project)
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&canary, "canary", "", "deploy as a canary on this share of requests, like 10%")
+	cmd.Flags().StringVar(&image, "image", "", "deploy this image from a registry instead of building")
+	cmd.Flags().StringVar(&source, "source", "", "upload this directory or archive instead of pushing with git")
+	cmd.Flags().StringVarP(&message, "message", "m", "", "commit message of the --source upload")
+	return cmd
+}
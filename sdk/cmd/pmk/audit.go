@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	pemasak "github.com/mustafasegf/pemasak-infra/sdk"
+)
+
+func newAuditCmd(opts *rootOptions) *cobra.Command {
+	var (
+		all    bool
+		csv    bool
+		filter pemasak.AuditFilter
+		since  time.Duration
+	)
+	cmd := &cobra.Command{
+		Use:   "audit [owner/project]",
+		Short: "Show who changed what on an app, or on the platform",
+		Long: `Show who changed what on an app: deploys, pushes, env changes, scaling,
+deleted apps, created tokens and so on, newest first. It takes a maintainer.
+Platform admins see every app with --all.`,
+		Example: `  pmk audit kelompok-3/api --action env --since 168h
+  pmk audit --all --actor budi --csv > audit.csv`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var owner, project string
+			if !all {
+				var err error
+				owner, project, err = opts.target(args)
+				if err != nil {
+					return err
+				}
+			}
+			if since > 0 {
+				filter.Since = time.Now().Add(-since)
+			}
+			c, err := opts.client()
+			if err != nil {
+				return err
+			}
+
+			if csv {
+				var export io.ReadCloser
+				if all {
+					export, err = c.ExportAuditLog(cmd.Context(), filter)
+				} else {
+					export, err = c.ExportProjectAudit(cmd.Context(), owner, project, filter)
+				}
+				if err != nil {
+					return wrapAuth(err)
+				}
+				defer export.Close()
+				_, err = io.Copy(cmd.OutOrStdout(), export)
+				return err
+			}
+
+			var entries []pemasak.AuditEntry
+			if all {
+				entries, err = c.AuditLog(cmd.Context(), filter)
+			} else {
+				entries, err = c.ProjectAudit(cmd.Context(), owner, project, filter)
+			}
+			if err != nil {
+				return wrapAuth(err)
+			}
+			w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "TIME\tACTOR\tAPP\tACTION\tSTATUS\tIP")
+			for _, e := range entries {
+				app := "-"
+				if e.Project != "" {
+					app = e.Owner + "/" + e.Project
+				}
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%s\n",
+					e.CreatedAt.Local().Format(time.DateTime), e.Actor, app, e.Action, e.Status, e.IP)
+			}
+			return w.Flush()
+		},
+	}
+	cmd.Flags().BoolVar(&all, "all", false, "show every app, for platform admins")
+	cmd.Flags().BoolVar(&csv, "csv", false, "print the entries as csv, with what changed")
+	cmd.Flags().StringVar(&filter.Owner, "owner", "", "only apps of this owner, with --all")
+	cmd.Flags().StringVar(&filter.Actor, "actor", "", "only changes by this user")
+	cmd.Flags().StringVar(&filter.Action, "action", "", "only actions starting with this, like env or git.push")
+	cmd.Flags().DurationVar(&since, "since", 0, "only changes in this last stretch of time, like 24h")
+	cmd.Flags().IntVar(&filter.Limit, "limit", 0, "at most this many entries (default 100, at most 10000)")
+	return cmd
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	pemasak "github.com/mustafasegf/pemasak-infra/sdk"
+)
+
+func newAutoscaleCmd(opts *rootOptions) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "autoscale",
+		Short: "Scale processes automatically",
+		Long: `Scale processes automatically.
+
+The autoscaler keeps a process between --min and --max containers, scaling
+it in proportion to how far the average cpu of its containers or the p95
+latency of the app is from the target. Use --app or PMK_APP to pick the app.`,
+	}
+
+	var minCount, maxCount int
+	var cpu, latency float64
+	set := &cobra.Command{
+		Use:   "set PROCESS",
+		Short: "Autoscale a process",
+		Example: `  pmk autoscale set worker --min 1 --max 5 --cpu 70
+  pmk autoscale set web --min 2 --max 8 --latency 300`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			a := pemasak.Autoscaler{Process: args[0], Min: minCount, Max: maxCount}
+			switch {
+			case cpu > 0 && latency > 0:
+				return errors.New("use either --cpu or --latency")
+			case cpu > 0:
+				a.Metric, a.Target = pemasak.AutoscaleCPU, cpu
+			case latency > 0:
+				a.Metric, a.Target = pemasak.AutoscaleLatency, latency
+			default:
+				return errors.New("set a target with --cpu or --latency")
+			}
+			owner, project, err := opts.target(nil)
+			if err != nil {
+				return err
+			}
+			c, err := opts.client()
+			if err != nil {
+				return err
+			}
+			if _, err := c.SetAutoscaler(cmd.Context(), owner, project, a); err != nil {
+				return wrapAuth(err)
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "autoscaling %s between %d and %d\n", a.Process, a.Min, a.Max)
+			return nil
+		},
+	}
+	set.Flags().IntVar(&minCount, "min", 1, "fewest containers")
+	set.Flags().IntVar(&maxCount, "max", 3, "most containers")
+	set.Flags().Float64Var(&cpu, "cpu", 0, "target cpu per container, in percent of one cpu")
+	set.Flags().Float64Var(&latency, "latency", 0, "target p95 latency of the app, in milliseconds")
+
+	cmd.AddCommand(
+		&cobra.Command{
+			Use:   "list",
+			Short: "List the autoscalers",
+			Args:  cobra.NoArgs,
+			RunE: func(cmd *cobra.Command, args []string) error {
+				owner, project, err := opts.target(nil)
+				if err != nil {
+					return err
+				}
+				c, err := opts.client()
+				if err != nil {
+					return err
+				}
+				scalers, err := c.ListAutoscalers(cmd.Context(), owner, project)
+				if err != nil {
+					return wrapAuth(err)
+				}
+				w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+				fmt.Fprintln(w, "PROCESS\tMIN\tMAX\tTARGET\tLAST SCALED")
+				for _, a := range scalers {
+					target := fmt.Sprintf("cpu %.0f%%", a.Target)
+					if a.Metric == pemasak.AutoscaleLatency {
+						target = fmt.Sprintf("p95 %.0fms", a.Target)
+					}
+					last := "-"
+					if a.LastScaledAt != nil {
+						last = a.LastScaledAt.Local().Format(time.DateTime)
+					}
+					fmt.Fprintf(w, "%s\t%d\t%d\t%s\t%s\n", a.Process, a.Min, a.Max, target, last)
+				}
+				return w.Flush()
+			},
+		},
+		set,
+		&cobra.Command{
+			Use:   "remove PROCESS",
+			Short: "Stop autoscaling a process, it keeps its current count",
+			Args:  cobra.ExactArgs(1),
+			RunE: func(cmd *cobra.Command, args []string) error {
+				owner, project, err := opts.target(nil)
+				if err != nil {
+					return err
+				}
+				c, err := opts.client()
+				if err != nil {
+					return err
+				}
+				return wrapAuth(c.RemoveAutoscaler(cmd.Context(), owner, project, args[0]))
+			},
+		},
+	)
+	return cmd
+}
+
+func newActivityCmd(opts *rootOptions) *cobra.Command {
+	return &cobra.Command{
+		Use:   "activity [owner/project]",
+		Short: "Show what happened to an app besides builds",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			owner, project, err := opts.target(args)
+			if err != nil {
+				return err
+			}
+			c, err := opts.client()
+			if err != nil {
+				return err
+			}
+			activity, err := c.ListActivity(cmd.Context(), owner, project)
+			if err != nil {
+				return wrapAuth(err)
+			}
+			w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "TIME\tKIND\tMESSAGE")
+			for _, a := range activity {
+				fmt.Fprintf(w, "%s\t%s\t%s\n", a.CreatedAt.Local().Format(time.DateTime), a.Kind, a.Message)
+			}
+			return w.Flush()
+		},
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	pemasak "github.com/mustafasegf/pemasak-infra/sdk"
+)
+
+func newBackupsCmd(opts *rootOptions) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "pg:backups",
+		Short: "Manage the backups of an app's database",
+		Long: `Manage the backups of an app's database.
+
+The postgres addon is dumped every night and on capture. Only the newest
+backups are kept, and they are deleted together with the addon. Use --app
+or PMK_APP to pick the app.`,
+	}
+
+	var newDatabase bool
+	restore := &cobra.Command{
+		Use:   "restore ID",
+		Short: "Restore a backup over the database, or into a new one with --new",
+		Long: `Restore a backup over the database the app uses.
+
+With --new the backup goes into a new database in the same postgres
+container instead, so the current data stays around. Reach it from the app
+with the name that is printed, for example:
+
+  pmk run -- sh -c 'psql "${DATABASE_URL%/*}/restore_20261014020000"'`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			owner, project, err := opts.target(nil)
+			if err != nil {
+				return err
+			}
+			c, err := opts.client()
+			if err != nil {
+				return err
+			}
+			database, err := c.RestoreBackup(cmd.Context(), owner, project, args[0], pemasak.RestoreOptions{NewDatabase: newDatabase})
+			if err != nil {
+				return wrapAuth(err)
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "restored %s into database %s\n", args[0], database)
+			return nil
+		},
+	}
+	restore.Flags().BoolVar(&newDatabase, "new", false, "restore into a new database instead of replacing the data")
+
+	cmd.AddCommand(
+		&cobra.Command{
+			Use:   "list",
+			Short: "List the backups, newest first",
+			Args:  cobra.NoArgs,
+			RunE: func(cmd *cobra.Command, args []string) error {
+				owner, project, err := opts.target(nil)
+				if err != nil {
+					return err
+				}
+				c, err := opts.client()
+				if err != nil {
+					return err
+				}
+				backups, err := c.ListBackups(cmd.Context(), owner, project)
+				if err != nil {
+					return wrapAuth(err)
+				}
+				w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+				fmt.Fprintln(w, "ID\tSTATUS\tTRIGGER\tSIZE\tSTARTED\tERROR")
+				for _, b := range backups {
+					trigger := "schedule"
+					if b.Manual {
+						trigger = "manual"
+					}
+					size := "-"
+					if b.Size != nil {
+						size = formatSize(*b.Size)
+					}
+					fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", b.ID, b.Status, trigger, size, b.StartedAt.Local().Format(time.DateTime), b.Error)
+				}
+				return w.Flush()
+			},
+		},
+		&cobra.Command{
+			Use:   "capture",
+			Short: "Back up the database now",
+			Args:  cobra.NoArgs,
+			RunE: func(cmd *cobra.Command, args []string) error {
+				owner, project, err := opts.target(nil)
+				if err != nil {
+					return err
+				}
+				c, err := opts.client()
+				if err != nil {
+					return err
+				}
+				id, err := c.CaptureBackup(cmd.Context(), owner, project)
+				if err != nil {
+					return wrapAuth(err)
+				}
+				fmt.Fprintf(cmd.OutOrStdout(), "capturing backup %s, follow it with pmk pg:backups list\n", id)
+				return nil
+			},
+		},
+		restore,
+	)
+	return cmd
+}
+
+// formatSize prints a byte count with a binary unit.
+func formatSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+)
+
+func newBasicAuthCmd(opts *rootOptions) *cobra.Command {
+	var username string
+	cmd := &cobra.Command{
+		Use:   "basic-auth [on|off]",
+		Short: "Make visitors log in before they reach an app",
+		Long: `Make visitors log in before they reach an app.
+
+While it is on the proxy answers 401 with a login prompt of the browser
+until the visitor gives the username and password, so an unfinished app
+isn't public and search engines don't crawl it. The login is checked by the
+platform and the Authorization header isn't passed on, so an app with logins
+of its own over that header won't get them. The password is read from
+PMK_BASIC_AUTH_PASSWORD or prompted for. Running on again replaces the
+login. Without arguments the current state is shown. Use --app or PMK_APP
+to pick the app.`,
+		Example: `  pmk basic-auth on --username reviewer
+  PMK_BASIC_AUTH_PASSWORD=$PASSWORD pmk basic-auth on -u demo
+  pmk basic-auth off`,
+		Args:      cobra.MaximumNArgs(1),
+		ValidArgs: []string{"on", "off"},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			owner, project, err := opts.target(nil)
+			if err != nil {
+				return err
+			}
+			c, err := opts.client()
+			if err != nil {
+				return err
+			}
+
+			if len(args) == 0 {
+				auth, err := c.GetBasicAuth(cmd.Context(), owner, project)
+				if err != nil {
+					return wrapAuth(err)
+				}
+				if !auth.Enabled {
+					fmt.Fprintln(cmd.OutOrStdout(), "off")
+					return nil
+				}
+				fmt.Fprintf(cmd.OutOrStdout(), "on, username %s\n", auth.Username)
+				return nil
+			}
+
+			switch args[0] {
+			case "on":
+				if username == "" {
+					return fmt.Errorf("--username is required to turn basic auth on")
+				}
+				password := os.Getenv("PMK_BASIC_AUTH_PASSWORD")
+				if password == "" {
+					fmt.Fprint(cmd.ErrOrStderr(), "Password: ")
+					b, err := term.ReadPassword(int(os.Stdin.Fd()))
+					fmt.Fprintln(cmd.ErrOrStderr())
+					if err != nil {
+						return err
+					}
+					password = string(b)
+				}
+				if err := c.EnableBasicAuth(cmd.Context(), owner, project, username, password); err != nil {
+					return wrapAuth(err)
+				}
+			case "off":
+				if err := c.DisableBasicAuth(cmd.Context(), owner, project); err != nil {
+					return wrapAuth(err)
+				}
+			default:
+				return fmt.Errorf("invalid argument %q, expected on or off", args[0])
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringVarP(&username, "username", "u", "", "username visitors log in with")
+	return cmd
+}
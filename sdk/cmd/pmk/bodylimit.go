@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/spf13/cobra"
+)
+
+func newBodyLimitCmd(opts *rootOptions) *cobra.Command {
+	var timeout int
+	cmd := &cobra.Command{
+		Use:   "body-limit [MIB|off]",
+		Short: "Limit how big and how slow request bodies to an app may be",
+		Long: `Limit how big and how slow request bodies to an app may be.
+
+The platform passes request bodies of at most MIB MiB on to the app, bigger
+ones get 413 Payload Too Large without reaching it. With --timeout a client
+gets that many seconds to send the whole body, a slower one gets 408 Request
+Timeout. gRPC calls stream for as long as they go on, the timeout doesn't
+apply to them. The platform has limits of its own, an app can only set lower
+ones. off, and 0 for --timeout, go back to the limits of the platform.
+Without arguments or flags the current limits are shown. Use --app or
+PMK_APP to pick the app.`,
+		Example: `  pmk body-limit 10
+  pmk body-limit 10 --timeout 60
+  pmk body-limit off --timeout 0`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			owner, project, err := opts.target(nil)
+			if err != nil {
+				return err
+			}
+			c, err := opts.client()
+			if err != nil {
+				return err
+			}
+			settings, err := c.GetSettings(cmd.Context(), owner, project)
+			if err != nil {
+				return wrapAuth(err)
+			}
+
+			flags := cmd.Flags()
+			if len(args) == 0 && !flags.Changed("timeout") {
+				limit := "platform limit"
+				if settings.BodyLimit != 0 {
+					limit = fmt.Sprintf("%d MiB", settings.BodyLimit)
+				}
+				timeoutText := "platform timeout"
+				if settings.BodyTimeout != 0 {
+					timeoutText = fmt.Sprintf("%d seconds", settings.BodyTimeout)
+				}
+				fmt.Fprintf(cmd.OutOrStdout(), "body: %s, timeout: %s\n", limit, timeoutText)
+				return nil
+			}
+
+			if len(args) == 1 {
+				settings.BodyLimit = 0
+				if args[0] != "off" {
+					mib, err := strconv.Atoi(args[0])
+					if err != nil || mib <= 0 || mib > 1048576 {
+						return fmt.Errorf("invalid limit %q, expected MiB or off", args[0])
+					}
+					settings.BodyLimit = mib
+				}
+			}
+			if flags.Changed("timeout") {
+				if timeout < 0 || timeout > 86400 {
+					return fmt.Errorf("--timeout must be between 0 and 86400 seconds")
+				}
+				settings.BodyTimeout = timeout
+			}
+			if err := c.UpdateSettings(cmd.Context(), owner, project, *settings); err != nil {
+				return wrapAuth(err)
+			}
+			return nil
+		},
+	}
+	cmd.Flags().IntVar(&timeout, "timeout", 0, "seconds a client gets to send a body, 0 keeps the platform timeout")
+	return cmd
+}
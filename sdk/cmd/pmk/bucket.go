@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	pemasak "github.com/mustafasegf/pemasak-infra/sdk"
+)
+
+func newBucketCmd(opts *rootOptions) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "bucket",
+		Short: "Show and change the s3 addon of an app",
+		Long: `Show and change the s3 addon of an app.
+
+The quota is how many MiB the bucket holds, writes past it fail. Lifecycle
+rules delete the objects under a prefix some days after they were written,
+without --prefix the rule covers the whole bucket. Changes apply to the
+bucket right away. Without a subcommand the bucket is shown.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			owner, project, err := opts.target(nil)
+			if err != nil {
+				return err
+			}
+			c, err := opts.client()
+			if err != nil {
+				return err
+			}
+			bucket, err := c.GetBucket(cmd.Context(), owner, project)
+			if err != nil {
+				return wrapAuth(err)
+			}
+
+			out := cmd.OutOrStdout()
+			fmt.Fprintf(out, "bucket: %s\n", bucket.Bucket)
+			fmt.Fprintf(out, "endpoint: %s (%s)\n", bucket.Endpoint, bucket.Region)
+			if bucket.Usage != nil {
+				fmt.Fprintf(out, "usage: %.1f of %d MiB\n", float64(*bucket.Usage)/(1<<20), bucket.Quota)
+			} else {
+				fmt.Fprintf(out, "usage: unknown of %d MiB\n", bucket.Quota)
+			}
+			if len(bucket.Lifecycle) == 0 {
+				fmt.Fprintln(out, "lifecycle: objects are kept")
+			}
+			for _, rule := range bucket.Lifecycle {
+				prefix := rule.Prefix
+				if prefix == "" {
+					prefix = "everything"
+				}
+				fmt.Fprintf(out, "lifecycle: %s expires after %d days\n", prefix, rule.ExpireDays)
+			}
+			fmt.Fprintf(out, "created: %s\n", bucket.CreatedAt.Local().Format(time.DateTime))
+			return nil
+		},
+	}
+
+	// update changes the bucket of the app the way change says
+	update := func(cmd *cobra.Command, change func(*pemasak.Bucket)) error {
+		owner, project, err := opts.target(nil)
+		if err != nil {
+			return err
+		}
+		c, err := opts.client()
+		if err != nil {
+			return err
+		}
+		bucket, err := c.GetBucket(cmd.Context(), owner, project)
+		if err != nil {
+			return wrapAuth(err)
+		}
+		change(bucket)
+		return wrapAuth(c.SetBucket(cmd.Context(), owner, project, bucket.Quota, bucket.Lifecycle))
+	}
+
+	quota := &cobra.Command{
+		Use:   "quota MIB",
+		Short: "Set how many MiB the bucket holds",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			mib, err := strconv.Atoi(args[0])
+			if err != nil || mib < 1 {
+				return fmt.Errorf("invalid quota %q, expected MiB like 2048", args[0])
+			}
+			return update(cmd, func(bucket *pemasak.Bucket) {
+				bucket.Quota = mib
+			})
+		},
+	}
+
+	var prefix string
+	expire := &cobra.Command{
+		Use:     "expire DAYS",
+		Short:   "Delete objects some days after they were written",
+		Example: `  pmk addons bucket expire 7 --prefix tmp/`,
+		Args:    cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			days, err := strconv.Atoi(args[0])
+			if err != nil || days < 1 {
+				return fmt.Errorf("invalid days %q, expected a number like 30", args[0])
+			}
+			return update(cmd, func(bucket *pemasak.Bucket) {
+				bucket.Lifecycle = slices.DeleteFunc(bucket.Lifecycle, func(rule pemasak.LifecycleRule) bool {
+					return rule.Prefix == prefix
+				})
+				bucket.Lifecycle = append(bucket.Lifecycle, pemasak.LifecycleRule{Prefix: prefix, ExpireDays: days})
+			})
+		},
+	}
+	expire.Flags().StringVar(&prefix, "prefix", "", "only objects under it")
+
+	keep := &cobra.Command{
+		Use:   "keep",
+		Short: "Remove the lifecycle rule of a prefix",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return update(cmd, func(bucket *pemasak.Bucket) {
+				bucket.Lifecycle = slices.DeleteFunc(bucket.Lifecycle, func(rule pemasak.LifecycleRule) bool {
+					return rule.Prefix == prefix
+				})
+			})
+		},
+	}
+	keep.Flags().StringVar(&prefix, "prefix", "", "the rule of it, the whole bucket without")
+
+	cmd.AddCommand(quota, expire, keep)
+	return cmd
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+func newBufferingCmd(opts *rootOptions) *cobra.Command {
+	return &cobra.Command{
+		Use:   "buffering [on|off]",
+		Short: "Read whole responses of an app before sending them on",
+		Long: `Read whole responses of an app before sending them on.
+
+By default every chunk of a response goes to the visitor as soon as the app
+writes it. With buffering on the platform reads the response first, up to
+8 MiB, so the app is done with it even when the visitor downloads slowly.
+Server-sent events (text/event-stream), gRPC and NDJSON streams are never
+buffered. The change applies on the next request. Without arguments the
+current setting is shown. Use --app or PMK_APP to pick the app.`,
+		Example: `  pmk buffering on
+  pmk buffering off`,
+		Args:      cobra.MaximumNArgs(1),
+		ValidArgs: []string{"on", "off"},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			owner, project, err := opts.target(nil)
+			if err != nil {
+				return err
+			}
+			c, err := opts.client()
+			if err != nil {
+				return err
+			}
+			settings, err := c.GetSettings(cmd.Context(), owner, project)
+			if err != nil {
+				return wrapAuth(err)
+			}
+
+			if len(args) == 0 {
+				if settings.ResponseBuffering {
+					fmt.Fprintln(cmd.OutOrStdout(), "on, responses are read whole before they are sent")
+				} else {
+					fmt.Fprintln(cmd.OutOrStdout(), "off, responses stream as the app writes them")
+				}
+				return nil
+			}
+
+			switch args[0] {
+			case "on":
+				settings.ResponseBuffering = true
+			case "off":
+				settings.ResponseBuffering = false
+			default:
+				return fmt.Errorf("invalid setting %q, expected on or off", args[0])
+			}
+			if err := c.UpdateSettings(cmd.Context(), owner, project, *settings); err != nil {
+				return wrapAuth(err)
+			}
+			return nil
+		},
+	}
+}
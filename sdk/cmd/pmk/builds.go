@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"text/tabwriter"
+	"time"
+
+	"github.com/mustafasegf/pemasak-infra/sdk"
+	"github.com/spf13/cobra"
+)
+
+func newBuildsCmd(opts *rootOptions) *cobra.Command {
+	var status string
+	var page pemasak.ListOptions
+	cmd := &cobra.Command{
+		Use:   "builds [owner/project]",
+		Short: "List the builds of an app, the newest first",
+		Example: `  pmk builds owner/myapp --status failed
+  pmk builds owner/myapp --limit 200 --cursor 2026-10-01T08:00:00.000000Z_0190...`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			owner, project, err := opts.target(args)
+			if err != nil {
+				return err
+			}
+			c, err := opts.client()
+			if err != nil {
+				return err
+			}
+			builds, err := c.ListBuildsPage(cmd.Context(), owner, project, pemasak.BuildStatus(status), page)
+			if err != nil {
+				return wrapAuth(err)
+			}
+			w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "ID\tSTATUS\tCREATED\tFINISHED")
+			for _, b := range builds.Data {
+				finished := "-"
+				if b.FinishedAt != nil {
+					finished = b.FinishedAt.Local().Format(time.DateTime)
+				}
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", b.ID, b.Status, b.CreatedAt.Local().Format(time.DateTime), finished)
+			}
+			if err := w.Flush(); err != nil {
+				return err
+			}
+			if builds.NextCursor != "" {
+				fmt.Fprintf(cmd.ErrOrStderr(), "Older builds with --cursor %s\n", builds.NextCursor)
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&status, "status", "", "only list builds that are pending, building, successful or failed")
+	cmd.Flags().IntVar(&page.Limit, "limit", 50, "list at most this many builds, up to 200")
+	cmd.Flags().StringVar(&page.Cursor, "cursor", "", "list the builds older than the last page")
+
+	var follow bool
+	logsCmd := &cobra.Command{
+		Use:     "logs build-id",
+		Aliases: []string{"show"},
+		Short:   "Print the log of a build",
+		Args:    cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			owner, project, err := opts.target(nil)
+			if err != nil {
+				return err
+			}
+			c, err := opts.client()
+			if err != nil {
+				return err
+			}
+
+			if !follow {
+				build, err := c.GetBuild(cmd.Context(), owner, project, args[0])
+				if err != nil {
+					return wrapAuth(err)
+				}
+				if build.QueuePosition != nil {
+					fmt.Fprintf(cmd.ErrOrStderr(), "Build %s: %s, #%d in the queue\n", build.ID, build.Status, *build.QueuePosition)
+				} else {
+					fmt.Fprintf(cmd.ErrOrStderr(), "Build %s: %s\n", build.ID, build.Status)
+				}
+				fmt.Fprint(cmd.OutOrStdout(), build.Logs)
+				return nil
+			}
+
+			status, err := c.StreamBuildLog(cmd.Context(), owner, project, args[0], 0, func(chunk pemasak.BuildLogChunk) error {
+				if chunk.Reset {
+					fmt.Fprintln(cmd.ErrOrStderr(), "--- log replaced by the final build output ---")
+				}
+				_, err := fmt.Fprint(cmd.OutOrStdout(), chunk.Log)
+				return err
+			})
+			if err != nil {
+				return wrapAuth(err)
+			}
+			fmt.Fprintf(cmd.ErrOrStderr(), "Build %s: %s\n", args[0], status)
+			if status == pemasak.BuildFailed {
+				return fmt.Errorf("build failed")
+			}
+			return nil
+		},
+	}
+	logsCmd.Flags().BoolVarP(&follow, "follow", "f", false, "stream the log until the build finishes")
+	cmd.AddCommand(logsCmd, &cobra.Command{
+		Use:   "cancel build-id",
+		Short: "Cancel a waiting or running build",
+		Long: `Cancel a waiting or running build.
+
+A waiting build never runs. A running build stops building or pulling its
+image, one that is already starting its new container finishes. A push
+cancels the earlier builds of the app by itself.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			owner, project, err := opts.target(nil)
+			if err != nil {
+				return err
+			}
+			c, err := opts.client()
+			if err != nil {
+				return err
+			}
+			return wrapAuth(c.CancelBuild(cmd.Context(), owner, project, args[0]))
+		},
+	})
+	return cmd
+}
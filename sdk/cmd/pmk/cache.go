@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+func newCacheCmd(opts *rootOptions) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "cache",
+		Short: "Cache responses of an app at the platform",
+		Long: `Cache responses of an app at the platform.
+
+While the cache is on, the platform keeps GET responses of the app for as
+long as their Cache-Control max-age or s-maxage, or their Expires, allows
+and answers later requests for them without reaching the app. Responses
+marked private or no-store, ones setting cookies and requests sending their
+own Authorization are never cached. A stale response with an ETag or
+Last-Modified is checked with the app before it is served again. Responses
+carry X-Cache: HIT, MISS or REVALIDATED. A deploy starts with an empty
+cache, and pmk cache purge drops responses that changed before then.
+Without a subcommand the current setting and usage are shown. Use --app or
+PMK_APP to pick the app.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			owner, project, err := opts.target(nil)
+			if err != nil {
+				return err
+			}
+			c, err := opts.client()
+			if err != nil {
+				return err
+			}
+			usage, err := c.GetCache(cmd.Context(), owner, project)
+			if err != nil {
+				return wrapAuth(err)
+			}
+
+			if !usage.Enabled {
+				fmt.Fprintln(cmd.OutOrStdout(), "off, every request reaches the app")
+				return nil
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "on, %d responses cached (%s)\n", usage.Entries, formatSize(usage.Bytes))
+			return nil
+		},
+	}
+
+	// set turns the cache of the app on or off
+	set := func(cmd *cobra.Command, on bool) error {
+		owner, project, err := opts.target(nil)
+		if err != nil {
+			return err
+		}
+		c, err := opts.client()
+		if err != nil {
+			return err
+		}
+		settings, err := c.GetSettings(cmd.Context(), owner, project)
+		if err != nil {
+			return wrapAuth(err)
+		}
+		settings.EdgeCache = on
+		if err := c.UpdateSettings(cmd.Context(), owner, project, *settings); err != nil {
+			return wrapAuth(err)
+		}
+		return nil
+	}
+
+	on := &cobra.Command{
+		Use:   "on",
+		Short: "Cache responses of the app by their Cache-Control",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return set(cmd, true)
+		},
+	}
+
+	off := &cobra.Command{
+		Use:   "off",
+		Short: "Send every request to the app and drop what is cached",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return set(cmd, false)
+		},
+	}
+
+	purge := &cobra.Command{
+		Use:   "purge [PATH...]",
+		Short: "Drop cached responses so the app is asked again",
+		Long: `Drop cached responses so the next requests for them reach the app again.
+Paths are matched without the query string, and a path ending in * drops
+everything under it. Without paths the whole cache of the app is dropped.`,
+		Example: `  pmk cache purge /index.html
+  pmk cache purge '/assets/*'
+  pmk cache purge`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			owner, project, err := opts.target(nil)
+			if err != nil {
+				return err
+			}
+			c, err := opts.client()
+			if err != nil {
+				return err
+			}
+			purged, err := c.PurgeCache(cmd.Context(), owner, project, args)
+			if err != nil {
+				return wrapAuth(err)
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "purged %d responses\n", purged)
+			return nil
+		},
+	}
+
+	cmd.AddCommand(on, off, purge)
+	return cmd
+}
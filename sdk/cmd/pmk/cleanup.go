@@ -0,0 +1,172 @@
+package main
+
+import (
+	"fmt"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+func newCleanupCmd(opts *rootOptions) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "cleanup",
+		Short: "Show when the platform cleans up the app for being unused",
+		Long: `Show when the platform cleans up the app for being unused.
+
+An app without requests or deploys for a while is flagged as inactive and its
+notification hooks get app.inactive. Still unused some days later it is
+stopped and they get app.stopped, and some days after that it is deleted.
+A request or a deploy unflags a flagged app; a stopped one only comes back
+with pmk cleanup restore. The platform admins pick the days, and can exempt
+apps. Use --app or PMK_APP to pick the app.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			owner, project, err := opts.target(nil)
+			if err != nil {
+				return err
+			}
+			c, err := opts.client()
+			if err != nil {
+				return err
+			}
+			s, err := c.Cleanup(cmd.Context(), owner, project)
+			if err != nil {
+				return wrapAuth(err)
+			}
+			out := cmd.OutOrStdout()
+			fmt.Fprintf(out, "last active  %s\n", s.LastActiveAt.Local().Format(time.DateTime))
+			switch {
+			case !s.Enabled:
+				fmt.Fprintln(out, "the platform doesn't clean up inactive apps")
+			case s.Exempt:
+				fmt.Fprintln(out, "exempt from the cleanup")
+			case s.StoppedAt != nil:
+				fmt.Fprintf(out, "stopped      %s\n", formatTime(s.StoppedAt, "-"))
+				fmt.Fprintf(out, "deleted on   %s, unless restored with pmk cleanup restore\n", formatTime(s.DeleteAt, "-"))
+			case s.FlaggedAt != nil:
+				fmt.Fprintf(out, "flagged      %s\n", formatTime(s.FlaggedAt, "-"))
+				fmt.Fprintf(out, "stopped on   %s, unless it gets a request or a deploy\n", formatTime(s.StopAt, "-"))
+			case s.FlagAt != nil:
+				fmt.Fprintf(out, "flagged on   %s, if it stays unused\n", formatTime(s.FlagAt, "-"))
+			}
+			return nil
+		},
+	}
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "restore",
+		Short: "Keep a flagged app, or start a stopped one again",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			owner, project, err := opts.target(nil)
+			if err != nil {
+				return err
+			}
+			c, err := opts.client()
+			if err != nil {
+				return err
+			}
+			if err := c.RestoreApp(cmd.Context(), owner, project); err != nil {
+				return wrapAuth(err)
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "restored %s/%s\n", owner, project)
+			return nil
+		},
+	})
+	return cmd
+}
+
+func newAdminCleanupCmd(opts *rootOptions) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "cleanup",
+		Short: "List the apps the cleanup of inactive apps is after, and exempt apps",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c, err := opts.client()
+			if err != nil {
+				return err
+			}
+			apps, enabled, err := c.ListCleanup(cmd.Context())
+			if err != nil {
+				return wrapAuth(err)
+			}
+			if !enabled {
+				fmt.Fprintln(cmd.ErrOrStderr(), "the cleanup is off, set cleanup.inactivedays to turn it on")
+			}
+			w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "APP\tLAST ACTIVE\tFLAGGED\tSTOPPED\tNEXT")
+			for _, a := range apps {
+				next := a.NextStep
+				switch {
+				case a.Exempt:
+					next = "exempt"
+				case a.StopAt != nil && a.NextStep == "nothing":
+					next = "stop on " + formatTime(a.StopAt, "-")
+				case a.DeleteAt != nil && a.NextStep == "nothing":
+					next = "delete on " + formatTime(a.DeleteAt, "-")
+				}
+				fmt.Fprintf(w, "%s/%s\t%s\t%s\t%s\t%s\n", a.Owner, a.Project,
+					a.LastActiveAt.Local().Format(time.DateTime), formatTime(a.FlaggedAt, "-"), formatTime(a.StoppedAt, "-"), next)
+			}
+			return w.Flush()
+		},
+	}
+
+	var off bool
+	var selector string
+	exempt := &cobra.Command{
+		Use:   "exempt [owner/project]",
+		Short: "Keep an app out of the cleanup, like a course site that is only used once a semester",
+		Example: `  pmk admin cleanup exempt course/site
+  pmk admin cleanup exempt --selector course=COMP301`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if selector != "" {
+				if len(args) > 0 {
+					return fmt.Errorf("give an app or --selector, not both")
+				}
+				c, err := opts.client()
+				if err != nil {
+					return err
+				}
+				apps, err := c.SetSelectedCleanupExempt(cmd.Context(), selector, !off)
+				if err != nil {
+					return wrapAuth(err)
+				}
+				state := "exempt from the cleanup"
+				if off {
+					state = "cleaned up again when unused"
+				}
+				fmt.Fprintf(cmd.OutOrStdout(), "%d apps are %s\n", len(apps), state)
+				for _, app := range apps {
+					fmt.Fprintln(cmd.OutOrStdout(), app)
+				}
+				return nil
+			}
+
+			owner, project, err := opts.target(args)
+			if err != nil {
+				return err
+			}
+			c, err := opts.client()
+			if err != nil {
+				return err
+			}
+			if err := c.SetCleanupExempt(cmd.Context(), owner, project, !off); err != nil {
+				return wrapAuth(err)
+			}
+			if off {
+				fmt.Fprintf(cmd.OutOrStdout(), "%s/%s is cleaned up again when unused\n", owner, project)
+			} else {
+				fmt.Fprintf(cmd.OutOrStdout(), "%s/%s is exempt from the cleanup\n", owner, project)
+			}
+			return nil
+		},
+	}
+	exempt.Flags().BoolVar(&off, "off", false, "put the app back in the cleanup")
+	exempt.Flags().StringVarP(&selector, "selector", "l", "", "exempt every app with these labels, see pmk labels")
+
+	cmd.AddCommand(exempt)
+	return cmd
+}
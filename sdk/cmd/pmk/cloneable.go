@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+func newCloneableCmd(opts *rootOptions) *cobra.Command {
+	return &cobra.Command{
+		Use:   "cloneable [on|off]",
+		Short: "Let anyone clone an app into their own account",
+		Long: `Let anyone clone an app into their own account.
+
+A cloneable app can be copied by anyone signed in with pmk apps clone, like a
+reference app students start from. The clone gets its settings, environment
+variables and repository but none of its secrets. Maintainers of the app can
+clone it either way, with the secrets not set with pmk env set --secret
+--no-copy. Without arguments the current setting is shown. Use --app or PMK_APP to
+pick the app.`,
+		Example: `  pmk cloneable on
+  pmk cloneable off`,
+		Args:      cobra.MaximumNArgs(1),
+		ValidArgs: []string{"on", "off"},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			owner, project, err := opts.target(nil)
+			if err != nil {
+				return err
+			}
+			c, err := opts.client()
+			if err != nil {
+				return err
+			}
+			settings, err := c.GetSettings(cmd.Context(), owner, project)
+			if err != nil {
+				return wrapAuth(err)
+			}
+
+			if len(args) == 0 {
+				if settings.Cloneable {
+					fmt.Fprintf(cmd.OutOrStdout(), "cloneable, anyone can run pmk apps clone %s/%s\n", owner, project)
+				} else {
+					fmt.Fprintln(cmd.OutOrStdout(), "not cloneable, only maintainers can clone it")
+				}
+				return nil
+			}
+
+			switch args[0] {
+			case "on":
+				settings.Cloneable = true
+			case "off":
+				settings.Cloneable = false
+			default:
+				return fmt.Errorf("invalid setting %q, expected on or off", args[0])
+			}
+			if err := c.UpdateSettings(cmd.Context(), owner, project, *settings); err != nil {
+				return wrapAuth(err)
+			}
+			return nil
+		},
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+func newCompressionCmd(opts *rootOptions) *cobra.Command {
+	return &cobra.Command{
+		Use:   "compression [on|off]",
+		Short: "Compress text responses of an app at the platform",
+		Long: `Compress text responses of an app at the platform.
+
+HTML, CSS, JavaScript, JSON, SVG and other text responses of at least 1 KiB
+are compressed with brotli or gzip, whichever the browser prefers, and
+Vary: Accept-Encoding is added so caches keep them apart. Responses the app
+compressed itself, ranges, streams and responses marked Cache-Control:
+no-transform are passed on as they are. Compression is on for new apps;
+turn it off for an app that needs the exact bytes it sent. Without
+arguments the current setting is shown. Use --app or PMK_APP to pick the
+app.`,
+		Example: `  pmk compression off
+  pmk compression on`,
+		Args:      cobra.MaximumNArgs(1),
+		ValidArgs: []string{"on", "off"},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			owner, project, err := opts.target(nil)
+			if err != nil {
+				return err
+			}
+			c, err := opts.client()
+			if err != nil {
+				return err
+			}
+			settings, err := c.GetSettings(cmd.Context(), owner, project)
+			if err != nil {
+				return wrapAuth(err)
+			}
+
+			if len(args) == 0 {
+				if settings.Compression == nil || *settings.Compression {
+					fmt.Fprintln(cmd.OutOrStdout(), "on, text responses are compressed for clients accepting it")
+				} else {
+					fmt.Fprintln(cmd.OutOrStdout(), "off, responses are sent as the app writes them")
+				}
+				return nil
+			}
+
+			var on bool
+			switch args[0] {
+			case "on":
+				on = true
+			case "off":
+				on = false
+			default:
+				return fmt.Errorf("invalid setting %q, expected on or off", args[0])
+			}
+			settings.Compression = &on
+			if err := c.UpdateSettings(cmd.Context(), owner, project, *settings); err != nil {
+				return wrapAuth(err)
+			}
+			return nil
+		},
+	}
+}
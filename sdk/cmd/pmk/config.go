@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/mustafasegf/pemasak-infra/sdk"
+)
+
+// config is what `pmk login` saves between invocations.
+type config struct {
+	URL     string         `json:"url"`
+	Cookies []*savedCookie `json:"cookies"`
+}
+
+type savedCookie struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+func configPath() (string, error) {
+	if p := os.Getenv("PMK_CONFIG"); p != "" {
+		return p, nil
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "pmk", "config.json"), nil
+}
+
+func loadConfig() (*config, error) {
+	p, err := configPath()
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(p)
+	if errors.Is(err, os.ErrNotExist) {
+		return &config{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var cfg config
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("invalid config %s: %w", p, err)
+	}
+	return &cfg, nil
+}
+
+func saveConfig(cfg *config) error {
+	p, err := configPath()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0o700); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(cfg, "", "  ")
+	if err != nil {
+		return err
+	}
+	// the file holds a live session cookie
+	return os.WriteFile(p, data, 0o600)
+}
+
+func (cfg *config) saveSession(c *pemasak.Client) {
+	cfg.URL = c.BaseURL()
+	cfg.Cookies = nil
+	for _, ck := range c.Cookies() {
+		cfg.Cookies = append(cfg.Cookies, &savedCookie{Name: ck.Name, Value: ck.Value})
+	}
+}
+
+func (cfg *config) cookies() []*http.Cookie {
+	var out []*http.Cookie
+	for _, ck := range cfg.Cookies {
+		out = append(out, &http.Cookie{Name: ck.Name, Value: ck.Value, Path: "/"})
+	}
+	return out
+}
+
+// splitApp parses an "owner/project" reference.
+func splitApp(app string) (owner, project string, err error) {
+	owner, project, ok := strings.Cut(app, "/")
+	if !ok || owner == "" || project == "" || strings.Contains(project, "/") {
+		return "", "", fmt.Errorf("app must be in the form owner/project, got %q", app)
+	}
+	return owner, project, nil
+}
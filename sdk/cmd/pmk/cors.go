@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	pemasak "github.com/mustafasegf/pemasak-infra/sdk"
+)
+
+func newCORSCmd(opts *rootOptions) *cobra.Command {
+	var f struct {
+		methods     []string
+		headers     []string
+		credentials bool
+		maxAge      int
+	}
+	cmd := &cobra.Command{
+		Use:   "cors",
+		Short: "Let other sites call an app from the browser",
+		Long: `Let other sites call an app from the browser.
+
+Once an origin is allowed, the platform answers CORS preflight requests
+itself and sets Access-Control-Allow-Origin and the other CORS headers on
+every response of the app, in place of the ones the app sends, so a
+frontend on another subdomain can call an API without changing its code.
+Origins are like https://web.example.com, https://*.example.com for its
+subdomains, or * for any site. Without a subcommand the policy is shown.
+Use --app or PMK_APP to pick the app.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			owner, project, err := opts.target(nil)
+			if err != nil {
+				return err
+			}
+			c, err := opts.client()
+			if err != nil {
+				return err
+			}
+			cors, err := c.GetCORS(cmd.Context(), owner, project)
+			if err != nil {
+				return wrapAuth(err)
+			}
+
+			out := cmd.OutOrStdout()
+			if len(cors.Origins) == 0 {
+				fmt.Fprintln(out, "off, the app sends its own CORS headers")
+				return nil
+			}
+			list := func(values []string, none string) string {
+				if len(values) == 0 {
+					return none
+				}
+				return strings.Join(values, ", ")
+			}
+			fmt.Fprintf(out, "origins: %s\n", strings.Join(cors.Origins, ", "))
+			fmt.Fprintf(out, "methods: %s\n", list(cors.Methods, "GET, HEAD, POST"))
+			fmt.Fprintf(out, "headers: %s\n", list(cors.Headers, "safelisted only"))
+			fmt.Fprintf(out, "credentials: %t\n", cors.Credentials)
+			if cors.MaxAge > 0 {
+				fmt.Fprintf(out, "max age: %ds\n", cors.MaxAge)
+			}
+			return nil
+		},
+	}
+
+	// update changes the policy of the app the way change says
+	update := func(cmd *cobra.Command, change func(*pemasak.CORS)) error {
+		owner, project, err := opts.target(nil)
+		if err != nil {
+			return err
+		}
+		c, err := opts.client()
+		if err != nil {
+			return err
+		}
+		cors, err := c.GetCORS(cmd.Context(), owner, project)
+		if err != nil {
+			return wrapAuth(err)
+		}
+		change(cors)
+		if err := c.SetCORS(cmd.Context(), owner, project, *cors); err != nil {
+			return wrapAuth(err)
+		}
+		return nil
+	}
+
+	set := &cobra.Command{
+		Use:   "set ORIGIN...",
+		Short: "Replace the policy of the app",
+		Long: `Replace the policy of the app with these origins and flags. Flags that
+aren't given go back to their defaults.`,
+		Example: `  pmk cors set https://kelompok-3-web.stndar.dev
+  pmk cors set 'https://*.example.com' --methods GET,POST,PUT,DELETE --headers Authorization,Content-Type
+  pmk cors set https://web.example.com --credentials --max-age 600`,
+		Args: cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if f.maxAge < 0 {
+				return fmt.Errorf("--max-age must be positive")
+			}
+			return update(cmd, func(cors *pemasak.CORS) {
+				*cors = pemasak.CORS{
+					Origins:     args,
+					Methods:     f.methods,
+					Headers:     f.headers,
+					Credentials: f.credentials,
+					MaxAge:      f.maxAge,
+				}
+			})
+		},
+	}
+	set.Flags().StringSliceVar(&f.methods, "methods", nil, "methods besides simple requests, GET, HEAD and POST when not given")
+	set.Flags().StringSliceVar(&f.headers, "headers", nil, "request headers besides the safelisted ones, * for any")
+	set.Flags().BoolVar(&f.credentials, "credentials", false, "let the browser send cookies and logins")
+	set.Flags().IntVar(&f.maxAge, "max-age", 0, "seconds browsers keep a preflight")
+
+	reset := &cobra.Command{
+		Use:   "clear",
+		Short: "Leave CORS to the app again",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return update(cmd, func(cors *pemasak.CORS) {
+				*cors = pemasak.CORS{}
+			})
+		},
+	}
+
+	cmd.AddCommand(set, reset)
+	return cmd
+}
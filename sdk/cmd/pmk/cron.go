@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+func newCronCmd(opts *rootOptions) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "cron",
+		Short: "Manage the scheduled jobs of an app",
+		Long: `Manage the scheduled jobs of an app.
+
+Jobs run in a one-off container of the live release with the app's
+environment. Schedules are five field cron expressions in UTC. Use --app or
+PMK_APP to pick the app.`,
+	}
+
+	cmd.AddCommand(
+		&cobra.Command{
+			Use:   "list",
+			Short: "List the scheduled jobs",
+			Args:  cobra.NoArgs,
+			RunE: func(cmd *cobra.Command, args []string) error {
+				owner, project, err := opts.target(nil)
+				if err != nil {
+					return err
+				}
+				c, err := opts.client()
+				if err != nil {
+					return err
+				}
+				jobs, err := c.ListCronJobs(cmd.Context(), owner, project)
+				if err != nil {
+					return wrapAuth(err)
+				}
+				w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+				fmt.Fprintln(w, "ID\tSCHEDULE\tNEXT RUN\tLAST RUN\tCOMMAND")
+				for _, j := range jobs {
+					last := "-"
+					if j.LastStatus != nil {
+						last = string(*j.LastStatus)
+					}
+					if j.LastExitCode != nil {
+						last += fmt.Sprintf(" (%d)", *j.LastExitCode)
+					}
+					fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", j.ID, j.Schedule,
+						j.NextRunAt.Local().Format(time.DateTime), last, strings.Join(j.Command, " "))
+				}
+				return w.Flush()
+			},
+		},
+		&cobra.Command{
+			Use:     "add SCHEDULE -- COMMAND...",
+			Short:   "Schedule a command",
+			Example: `  pmk cron add "0 3 * * *" -- ./manage.py clearsessions`,
+			Args:    cobra.MinimumNArgs(2),
+			RunE: func(cmd *cobra.Command, args []string) error {
+				owner, project, err := opts.target(nil)
+				if err != nil {
+					return err
+				}
+				c, err := opts.client()
+				if err != nil {
+					return err
+				}
+				id, next, err := c.AddCronJob(cmd.Context(), owner, project, args[0], args[1:])
+				if err != nil {
+					return wrapAuth(err)
+				}
+				fmt.Fprintf(cmd.OutOrStdout(), "scheduled %s, first run at %s\n", id, next.Local().Format(time.DateTime))
+				return nil
+			},
+		},
+		&cobra.Command{
+			Use:   "remove ID",
+			Short: "Remove a scheduled job and its history",
+			Args:  cobra.ExactArgs(1),
+			RunE: func(cmd *cobra.Command, args []string) error {
+				owner, project, err := opts.target(nil)
+				if err != nil {
+					return err
+				}
+				c, err := opts.client()
+				if err != nil {
+					return err
+				}
+				return wrapAuth(c.DeleteCronJob(cmd.Context(), owner, project, args[0]))
+			},
+		},
+		&cobra.Command{
+			Use:   "runs ID",
+			Short: "List the recent runs of a job",
+			Args:  cobra.ExactArgs(1),
+			RunE: func(cmd *cobra.Command, args []string) error {
+				owner, project, err := opts.target(nil)
+				if err != nil {
+					return err
+				}
+				c, err := opts.client()
+				if err != nil {
+					return err
+				}
+				runs, err := c.ListCronRuns(cmd.Context(), owner, project, args[0])
+				if err != nil {
+					return wrapAuth(err)
+				}
+				w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+				fmt.Fprintln(w, "RUN\tSTATUS\tEXIT\tSTARTED\tDURATION")
+				for _, r := range runs {
+					code, duration := "-", "-"
+					if r.ExitCode != nil {
+						code = fmt.Sprint(*r.ExitCode)
+					}
+					if r.FinishedAt != nil {
+						duration = r.FinishedAt.Sub(r.StartedAt).Round(time.Second).String()
+					}
+					fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", r.ID, r.Status, code,
+						r.StartedAt.Local().Format(time.DateTime), duration)
+				}
+				return w.Flush()
+			},
+		},
+		&cobra.Command{
+			Use:   "logs ID RUN",
+			Short: "Print the output of a run",
+			Args:  cobra.ExactArgs(2),
+			RunE: func(cmd *cobra.Command, args []string) error {
+				owner, project, err := opts.target(nil)
+				if err != nil {
+					return err
+				}
+				c, err := opts.client()
+				if err != nil {
+					return err
+				}
+				run, err := c.GetCronRun(cmd.Context(), owner, project, args[0], args[1])
+				if err != nil {
+					return wrapAuth(err)
+				}
+				_, err = fmt.Fprint(cmd.OutOrStdout(), run.Logs)
+				return err
+			},
+		},
+	)
+	return cmd
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	pemasak "github.com/mustafasegf/pemasak-infra/sdk"
+)
+
+func newDeployBranchCmd(opts *rootOptions) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "deploy-branch",
+		Short: "Pick which branch of an app deploys",
+		Long: `Pick which branch of an app deploys.
+
+Pushes to the deploy branch deploy the app, pushes to other branches build
+previews. Without a branch set the app deploys the branch HEAD of the
+repository points at, the first one pushed. With --promote pushes to the
+deploy branch only build: the build waits next to the live release on no
+requests until pmk releases promote makes it live. Without a subcommand the
+setting is shown. Use --app or PMK_APP to pick the app.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			owner, project, err := opts.target(nil)
+			if err != nil {
+				return err
+			}
+			c, err := opts.client()
+			if err != nil {
+				return err
+			}
+			deploy, err := c.GetDeployBranch(cmd.Context(), owner, project)
+			if err != nil {
+				return wrapAuth(err)
+			}
+
+			out := cmd.OutOrStdout()
+			switch {
+			case deploy.Branch != "":
+				fmt.Fprintf(out, "branch: %s\n", deploy.Branch)
+			case deploy.Deploys != "":
+				fmt.Fprintf(out, "branch: %s, the default one\n", deploy.Deploys)
+			default:
+				fmt.Fprintln(out, "branch: the first one pushed")
+			}
+			promote := "off, pushes deploy right away"
+			if deploy.Promote {
+				promote = "on, pushes wait for pmk releases promote"
+			}
+			fmt.Fprintf(out, "promote: %s\n", promote)
+			return nil
+		},
+	}
+
+	// update changes the setting of the app the way change says
+	update := func(cmd *cobra.Command, change func(*pemasak.DeployBranch)) error {
+		owner, project, err := opts.target(nil)
+		if err != nil {
+			return err
+		}
+		c, err := opts.client()
+		if err != nil {
+			return err
+		}
+		deploy, err := c.GetDeployBranch(cmd.Context(), owner, project)
+		if err != nil {
+			return wrapAuth(err)
+		}
+		change(deploy)
+		if err := c.SetDeployBranch(cmd.Context(), owner, project, *deploy); err != nil {
+			return wrapAuth(err)
+		}
+		return nil
+	}
+
+	var promote bool
+	set := &cobra.Command{
+		Use:   "set [BRANCH]",
+		Short: "Change the deploy branch of the app",
+		Long: `Change the deploy branch of the app, or with --promote whether pushes to it
+wait to be promoted. The next push to the branch deploys it.`,
+		Example: `  pmk deploy-branch set main
+  pmk deploy-branch set production --promote
+  pmk deploy-branch set --promote=false`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) == 0 && !cmd.Flags().Changed("promote") {
+				return fmt.Errorf("nothing to change, give a branch or --promote")
+			}
+			return update(cmd, func(deploy *pemasak.DeployBranch) {
+				if len(args) == 1 {
+					deploy.Branch = args[0]
+				}
+				if cmd.Flags().Changed("promote") {
+					deploy.Promote = promote
+				}
+			})
+		},
+	}
+	set.Flags().BoolVar(&promote, "promote", false, "pushes only build until pmk releases promote")
+
+	reset := &cobra.Command{
+		Use:   "clear",
+		Short: "Deploy the default branch on every push again",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return update(cmd, func(deploy *pemasak.DeployBranch) {
+				*deploy = pemasak.DeployBranch{}
+			})
+		},
+	}
+
+	cmd.AddCommand(set, reset)
+	return cmd
+}
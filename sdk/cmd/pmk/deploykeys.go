@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	pemasak "github.com/mustafasegf/pemasak-infra/sdk"
+	"github.com/spf13/cobra"
+)
+
+func newDeployKeysCmd(opts *rootOptions) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "deploy-keys",
+		Short: "Manage the SSH keys CI fetches and pushes an app with",
+		Long: `Manage the SSH keys CI fetches and pushes an app with.
+
+A deploy key works for one app only, without the credentials of a user.
+Keys only fetch unless they are created with --write. The private key is
+shown once, when the key is created or rotated; store it as a secret of
+the pipeline and use the remote printed with it.
+
+Use --app or PMK_APP to pick the app.`,
+	}
+
+	// the private key goes to stdout or --out, everything else to stderr so it
+	// can be piped into a secret store
+	showKey := func(cmd *cobra.Command, key *pemasak.NewDeployKey, out string) error {
+		if out == "" {
+			fmt.Fprint(cmd.OutOrStdout(), key.PrivateKey)
+		} else if err := os.WriteFile(out, []byte(key.PrivateKey), 0o600); err != nil {
+			return err
+		}
+		stderr := cmd.ErrOrStderr()
+		fmt.Fprintf(stderr, "deploy key %s (%s) SHA256:%s\n", key.Name, key.ID, key.Fingerprint)
+		if key.Remote != "" {
+			fmt.Fprintf(stderr, "remote: %s\n", key.Remote)
+		}
+		fmt.Fprintln(stderr, "store the private key now, it can't be shown again")
+		return nil
+	}
+
+	var write bool
+	var createOut string
+	create := &cobra.Command{
+		Use:   "create NAME",
+		Short: "Create a deploy key, the private key is only shown once",
+		Example: `  pmk deploy-keys create github-actions --write
+  pmk deploy-keys create mirror --out mirror_ed25519`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			owner, project, err := opts.target(nil)
+			if err != nil {
+				return err
+			}
+			c, err := opts.client()
+			if err != nil {
+				return err
+			}
+			key, err := c.CreateDeployKey(cmd.Context(), owner, project, args[0], write)
+			if err != nil {
+				return wrapAuth(err)
+			}
+			return showKey(cmd, key, createOut)
+		},
+	}
+	create.Flags().BoolVar(&write, "write", false, "let the key push, it only fetches otherwise")
+	create.Flags().StringVar(&createOut, "out", "", "write the private key to this file instead of stdout")
+
+	var rotateOut string
+	rotate := &cobra.Command{
+		Use:   "rotate ID",
+		Short: "Replace a deploy key with a new one, the old one stops working",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			owner, project, err := opts.target(nil)
+			if err != nil {
+				return err
+			}
+			c, err := opts.client()
+			if err != nil {
+				return err
+			}
+			key, err := c.RotateDeployKey(cmd.Context(), owner, project, args[0])
+			if err != nil {
+				return wrapAuth(err)
+			}
+			return showKey(cmd, key, rotateOut)
+		},
+	}
+	rotate.Flags().StringVar(&rotateOut, "out", "", "write the private key to this file instead of stdout")
+
+	cmd.AddCommand(
+		&cobra.Command{
+			Use:   "list",
+			Short: "List the deploy keys",
+			Args:  cobra.NoArgs,
+			RunE: func(cmd *cobra.Command, args []string) error {
+				owner, project, err := opts.target(nil)
+				if err != nil {
+					return err
+				}
+				c, err := opts.client()
+				if err != nil {
+					return err
+				}
+				keys, remote, err := c.ListDeployKeys(cmd.Context(), owner, project)
+				if err != nil {
+					return wrapAuth(err)
+				}
+				if remote != "" {
+					fmt.Fprintf(cmd.OutOrStdout(), "remote: %s\n\n", remote)
+				}
+				w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+				fmt.Fprintln(w, "ID\tNAME\tACCESS\tFINGERPRINT\tLAST USED\tCREATED")
+				for _, k := range keys {
+					access := "read-write"
+					if k.ReadOnly {
+						access = "read-only"
+					}
+					fmt.Fprintf(w, "%s\t%s\t%s\tSHA256:%s\t%s\t%s\n", k.ID, k.Name, access, k.Fingerprint, formatTime(k.LastUsedAt, "never"), k.CreatedAt.Local().Format(time.DateTime))
+				}
+				return w.Flush()
+			},
+		},
+		create,
+		rotate,
+		&cobra.Command{
+			Use:   "revoke ID",
+			Short: "Delete a deploy key, the server refuses it from then on",
+			Args:  cobra.ExactArgs(1),
+			RunE: func(cmd *cobra.Command, args []string) error {
+				owner, project, err := opts.target(nil)
+				if err != nil {
+					return err
+				}
+				c, err := opts.client()
+				if err != nil {
+					return err
+				}
+				if err := c.RevokeDeployKey(cmd.Context(), owner, project, args[0]); err != nil {
+					return wrapAuth(err)
+				}
+				fmt.Fprintf(cmd.OutOrStdout(), "revoked deploy key %s\n", args[0])
+				return nil
+			},
+		},
+	)
+	return cmd
+}
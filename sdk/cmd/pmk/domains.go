@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+func newDomainsCmd(opts *rootOptions) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "domains",
+		Short: "Manage the custom domains of an app",
+		Long: `Manage the custom domains of an app.
+
+A domain is served once it has a CNAME record pointing at the app. Use --app
+or PMK_APP to pick the app.`,
+	}
+
+	cmd.AddCommand(
+		&cobra.Command{
+			Use:   "list",
+			Short: "List the custom domains",
+			Args:  cobra.NoArgs,
+			RunE: func(cmd *cobra.Command, args []string) error {
+				owner, project, err := opts.target(nil)
+				if err != nil {
+					return err
+				}
+				c, err := opts.client()
+				if err != nil {
+					return err
+				}
+				domains, target, err := c.ListDomains(cmd.Context(), owner, project)
+				if err != nil {
+					return wrapAuth(err)
+				}
+				w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+				fmt.Fprintln(w, "DOMAIN\tVERIFIED\tCREATED")
+				for _, d := range domains {
+					fmt.Fprintf(w, "%s\t%t\t%s\n", d.Name, d.Verified, d.CreatedAt.Local().Format(time.DateTime))
+				}
+				if err := w.Flush(); err != nil {
+					return err
+				}
+				fmt.Fprintf(cmd.ErrOrStderr(), "\npoint domains at %s with a CNAME record\n", target)
+				return nil
+			},
+		},
+		&cobra.Command{
+			Use:   "add DOMAIN",
+			Short: "Attach a domain, or re-check the DNS of an attached one",
+			Args:  cobra.ExactArgs(1),
+			RunE: func(cmd *cobra.Command, args []string) error {
+				owner, project, err := opts.target(nil)
+				if err != nil {
+					return err
+				}
+				c, err := opts.client()
+				if err != nil {
+					return err
+				}
+				domain, target, err := c.AddDomain(cmd.Context(), owner, project, args[0])
+				if err != nil {
+					return wrapAuth(err)
+				}
+				if domain.Verified {
+					fmt.Fprintf(cmd.OutOrStdout(), "%s is verified\n", domain.Name)
+					return nil
+				}
+				fmt.Fprintf(cmd.OutOrStdout(), "create a CNAME record from %s to %s, then run this command again\n", domain.Name, target)
+				return nil
+			},
+		},
+		&cobra.Command{
+			Use:   "remove DOMAIN",
+			Short: "Detach a domain",
+			Args:  cobra.ExactArgs(1),
+			RunE: func(cmd *cobra.Command, args []string) error {
+				owner, project, err := opts.target(nil)
+				if err != nil {
+					return err
+				}
+				c, err := opts.client()
+				if err != nil {
+					return err
+				}
+				return wrapAuth(c.RemoveDomain(cmd.Context(), owner, project, args[0]))
+			},
+		},
+	)
+	return cmd
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	pemasak "github.com/mustafasegf/pemasak-infra/sdk"
+)
+
+func newEgressCmd(opts *rootOptions) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "egress",
+		Short: "Choose where the containers of an app may connect to",
+		Long: `Choose where the containers of an app may connect to.
+
+allow lets them connect anywhere, campus only to the campus network, and
+deny nowhere. The allowlist adds ranges like 10.0.0.0/8, addresses and
+hostnames on top, like the API an app calls. Addons, services and the
+other processes of the app stay reachable whatever the policy, only
+connections leaving them are checked. Connections that aren't allowed are
+refused. Without a subcommand the policy is shown. Use --app or PMK_APP to
+pick the app.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			owner, project, err := opts.target(nil)
+			if err != nil {
+				return err
+			}
+			c, err := opts.client()
+			if err != nil {
+				return err
+			}
+			egress, err := c.GetEgress(cmd.Context(), owner, project)
+			if err != nil {
+				return wrapAuth(err)
+			}
+
+			out := cmd.OutOrStdout()
+			if egress.Policy == "" {
+				fmt.Fprintf(out, "policy: %s (default)\n", egress.Effective)
+			} else {
+				fmt.Fprintf(out, "policy: %s\n", egress.Effective)
+			}
+			if egress.Effective == pemasak.EgressCampus {
+				fmt.Fprintf(out, "campus: %s\n", strings.Join(egress.Campus, ", "))
+			}
+			if len(egress.Allow) == 0 {
+				fmt.Fprintln(out, "allow: nothing else")
+			} else {
+				fmt.Fprintf(out, "allow: %s\n", strings.Join(egress.Allow, ", "))
+			}
+			return nil
+		},
+	}
+
+	// update changes the policy of the app the way change says
+	update := func(cmd *cobra.Command, change func(*pemasak.Egress)) error {
+		owner, project, err := opts.target(nil)
+		if err != nil {
+			return err
+		}
+		c, err := opts.client()
+		if err != nil {
+			return err
+		}
+		egress, err := c.GetEgress(cmd.Context(), owner, project)
+		if err != nil {
+			return wrapAuth(err)
+		}
+		change(egress)
+		return wrapAuth(c.SetEgress(cmd.Context(), owner, project, egress.Policy, egress.Allow))
+	}
+
+	policy := &cobra.Command{
+		Use:   "policy allow|campus|deny|default",
+		Short: "Set the policy, default follows the one of the platform",
+		Example: `  pmk egress policy deny
+  pmk egress policy default`,
+		Args:      cobra.ExactArgs(1),
+		ValidArgs: []string{pemasak.EgressAllow, pemasak.EgressCampus, pemasak.EgressDeny, "default"},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			policy := args[0]
+			switch policy {
+			case pemasak.EgressAllow, pemasak.EgressCampus, pemasak.EgressDeny:
+			case "default":
+				policy = ""
+			default:
+				return fmt.Errorf("policy is allow, campus, deny or default, got %q", policy)
+			}
+			return update(cmd, func(egress *pemasak.Egress) {
+				egress.Policy = policy
+			})
+		},
+	}
+
+	allow := &cobra.Command{
+		Use:   "allow DESTINATION...",
+		Short: "Let the containers connect to these ranges, addresses or hostnames",
+		Long: `Let the containers connect to these ranges, addresses or hostnames. A
+hostname is resolved when the rules are applied and again every few
+minutes, so an address it moves to is allowed soon after.`,
+		Example: `  pmk egress allow api.github.com 152.118.24.0/24`,
+		Args:    cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return update(cmd, func(egress *pemasak.Egress) {
+				egress.Allow = append(slices.DeleteFunc(egress.Allow, func(d string) bool {
+					return slices.Contains(args, d)
+				}), args...)
+			})
+		},
+	}
+
+	remove := &cobra.Command{
+		Use:   "remove DESTINATION...",
+		Short: "Take destinations off the allowlist",
+		Long: `Take destinations off the allowlist. A range is matched as the platform
+stored it, pmk egress shows them.`,
+		Args: cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return update(cmd, func(egress *pemasak.Egress) {
+				egress.Allow = slices.DeleteFunc(egress.Allow, func(d string) bool {
+					return slices.Contains(args, d)
+				})
+			})
+		},
+	}
+
+	cmd.AddCommand(policy, allow, remove)
+	return cmd
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+func newEnvCmd(opts *rootOptions) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "env",
+		Short: "Manage the environment variables of an app",
+		Long: `Manage the environment variables and secrets of an app.
+
+Every change restarts the running app as a new release, so it can be rolled
+back. Use --app or PMK_APP to pick the app.`,
+	}
+
+	var secret, noCopy bool
+	set := &cobra.Command{
+		Use:   "set KEY=VALUE...",
+		Short: "Set one or more variables",
+		Args:  cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			owner, project, err := opts.target(nil)
+			if err != nil {
+				return err
+			}
+			if noCopy && !secret {
+				return fmt.Errorf("--no-copy only applies to secrets, use it with --secret")
+			}
+			// validate everything before changing anything
+			for _, arg := range args {
+				if k, _, ok := strings.Cut(arg, "="); !ok || k == "" {
+					return fmt.Errorf("expected KEY=VALUE, got %q", arg)
+				}
+			}
+			c, err := opts.client()
+			if err != nil {
+				return err
+			}
+			setEnv := c.SetEnv
+			if secret {
+				setEnv = c.SetSecret
+			}
+			if noCopy {
+				setEnv = c.SetUncopiedSecret
+			}
+			for _, arg := range args {
+				k, v, _ := strings.Cut(arg, "=")
+				if err := setEnv(cmd.Context(), owner, project, k, v); err != nil {
+					return wrapAuth(err)
+				}
+			}
+			return nil
+		},
+	}
+	set.Flags().BoolVarP(&secret, "secret", "s", false, "store the values encrypted, they can't be read back")
+	set.Flags().BoolVar(&noCopy, "no-copy", false, "leave the secrets out when the app is cloned")
+
+	cmd.AddCommand(
+		&cobra.Command{
+			Use:   "list",
+			Short: "Print the variables as KEY=VALUE, secrets without their value",
+			Args:  cobra.NoArgs,
+			RunE: func(cmd *cobra.Command, args []string) error {
+				owner, project, err := opts.target(nil)
+				if err != nil {
+					return err
+				}
+				c, err := opts.client()
+				if err != nil {
+					return err
+				}
+				env, err := c.Env(cmd.Context(), owner, project)
+				if err != nil {
+					return wrapAuth(err)
+				}
+				keys := make([]string, 0, len(env))
+				for k := range env {
+					keys = append(keys, k)
+				}
+				sort.Strings(keys)
+				for _, k := range keys {
+					fmt.Fprintf(cmd.OutOrStdout(), "%s=%s\n", k, env[k])
+				}
+				secrets, err := c.Secrets(cmd.Context(), owner, project)
+				if err != nil {
+					return wrapAuth(err)
+				}
+				uncopied, err := c.UncopiedSecrets(cmd.Context(), owner, project)
+				if err != nil {
+					return wrapAuth(err)
+				}
+				sort.Strings(secrets)
+				for _, k := range secrets {
+					if slices.Contains(uncopied, k) {
+						fmt.Fprintf(cmd.OutOrStdout(), "%s (secret, not copied to clones)\n", k)
+					} else {
+						fmt.Fprintf(cmd.OutOrStdout(), "%s (secret)\n", k)
+					}
+				}
+				return nil
+			},
+		},
+		set,
+		&cobra.Command{
+			Use:   "unset KEY...",
+			Short: "Remove one or more variables or secrets",
+			Args:  cobra.MinimumNArgs(1),
+			RunE: func(cmd *cobra.Command, args []string) error {
+				owner, project, err := opts.target(nil)
+				if err != nil {
+					return err
+				}
+				c, err := opts.client()
+				if err != nil {
+					return err
+				}
+				for _, k := range args {
+					if err := c.DeleteEnv(cmd.Context(), owner, project, k); err != nil {
+						return wrapAuth(err)
+					}
+				}
+				return nil
+			},
+		},
+	)
+	return cmd
+}
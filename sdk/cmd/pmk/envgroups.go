@@ -0,0 +1,252 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+)
+
+func newEnvGroupsCmd(opts *rootOptions) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "env-groups",
+		Short: "Share environment variables between the apps of an owner",
+		Long: `Share environment variables between the apps of an owner.
+
+An env group holds variables and secrets of an owner, like an API key every
+app of a team needs. Attach it to apps and they start with its variables;
+change it once and every attached app gets the change with its next release.
+A variable the app sets itself wins over the group, and a group attached
+later wins over an earlier one.
+
+Groups are named owner/group. Maintainers of the owner manage them, every
+member can list them.`,
+	}
+
+	splitGroup := func(arg string) (owner, group string, err error) {
+		owner, group, err = splitApp(arg)
+		if err != nil {
+			return "", "", fmt.Errorf("group must be in the form owner/group, got %q", arg)
+		}
+		return owner, group, nil
+	}
+
+	var secret bool
+	set := &cobra.Command{
+		Use:     "set owner/group KEY=VALUE...",
+		Short:   "Set one or more variables of a group",
+		Example: `  pmk env-groups set kelas-ppl/shared SENTRY_DSN=https://... --secret`,
+		Args:    cobra.MinimumNArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			owner, group, err := splitGroup(args[0])
+			if err != nil {
+				return err
+			}
+			// validate everything before changing anything
+			for _, arg := range args[1:] {
+				if k, _, ok := strings.Cut(arg, "="); !ok || k == "" {
+					return fmt.Errorf("expected KEY=VALUE, got %q", arg)
+				}
+			}
+			c, err := opts.client()
+			if err != nil {
+				return err
+			}
+			for _, arg := range args[1:] {
+				k, v, _ := strings.Cut(arg, "=")
+				if err := c.SetEnvGroupVar(cmd.Context(), owner, group, k, v, secret); err != nil {
+					return wrapAuth(err)
+				}
+			}
+			fmt.Fprintln(cmd.ErrOrStderr(), "attached apps get the change with their next release")
+			return nil
+		},
+	}
+	set.Flags().BoolVarP(&secret, "secret", "s", false, "store the values encrypted, they can't be read back")
+
+	cmd.AddCommand(
+		&cobra.Command{
+			Use:   "list OWNER",
+			Short: "List the groups of an owner and the apps they are attached to",
+			Args:  cobra.ExactArgs(1),
+			RunE: func(cmd *cobra.Command, args []string) error {
+				c, err := opts.client()
+				if err != nil {
+					return err
+				}
+				groups, err := c.EnvGroups(cmd.Context(), args[0])
+				if err != nil {
+					return wrapAuth(err)
+				}
+				w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+				fmt.Fprintln(w, "NAME\tVARIABLES\tSECRETS\tAPPS")
+				for _, g := range groups {
+					fmt.Fprintf(w, "%s\t%d\t%d\t%s\n", g.Name, len(g.Env), len(g.Secrets), strings.Join(g.Apps, ","))
+				}
+				return w.Flush()
+			},
+		},
+		&cobra.Command{
+			Use:   "show owner/group",
+			Short: "Print the variables of a group as KEY=VALUE, secrets without their value",
+			Args:  cobra.ExactArgs(1),
+			RunE: func(cmd *cobra.Command, args []string) error {
+				owner, name, err := splitGroup(args[0])
+				if err != nil {
+					return err
+				}
+				c, err := opts.client()
+				if err != nil {
+					return err
+				}
+				groups, err := c.EnvGroups(cmd.Context(), owner)
+				if err != nil {
+					return wrapAuth(err)
+				}
+				for _, g := range groups {
+					if g.Name != name {
+						continue
+					}
+					keys := make([]string, 0, len(g.Env))
+					for k := range g.Env {
+						keys = append(keys, k)
+					}
+					sort.Strings(keys)
+					for _, k := range keys {
+						fmt.Fprintf(cmd.OutOrStdout(), "%s=%s\n", k, g.Env[k])
+					}
+					sort.Strings(g.Secrets)
+					for _, k := range g.Secrets {
+						fmt.Fprintf(cmd.OutOrStdout(), "%s (secret)\n", k)
+					}
+					return nil
+				}
+				return fmt.Errorf("%s has no env group named %s", owner, name)
+			},
+		},
+		&cobra.Command{
+			Use:   "create owner/group",
+			Short: "Create an empty group",
+			Args:  cobra.ExactArgs(1),
+			RunE: func(cmd *cobra.Command, args []string) error {
+				owner, group, err := splitGroup(args[0])
+				if err != nil {
+					return err
+				}
+				c, err := opts.client()
+				if err != nil {
+					return err
+				}
+				if _, err := c.CreateEnvGroup(cmd.Context(), owner, group); err != nil {
+					return wrapAuth(err)
+				}
+				fmt.Fprintf(cmd.OutOrStdout(), "Created %s/%s, set its variables with pmk env-groups set\n", owner, group)
+				return nil
+			},
+		},
+		set,
+		&cobra.Command{
+			Use:   "unset owner/group KEY...",
+			Short: "Remove one or more variables or secrets of a group",
+			Args:  cobra.MinimumNArgs(2),
+			RunE: func(cmd *cobra.Command, args []string) error {
+				owner, group, err := splitGroup(args[0])
+				if err != nil {
+					return err
+				}
+				c, err := opts.client()
+				if err != nil {
+					return err
+				}
+				for _, k := range args[1:] {
+					if err := c.DeleteEnvGroupVar(cmd.Context(), owner, group, k); err != nil {
+						return wrapAuth(err)
+					}
+				}
+				return nil
+			},
+		},
+		&cobra.Command{
+			Use:   "delete owner/group",
+			Short: "Delete a group, its apps lose its variables with their next release",
+			Args:  cobra.ExactArgs(1),
+			RunE: func(cmd *cobra.Command, args []string) error {
+				owner, group, err := splitGroup(args[0])
+				if err != nil {
+					return err
+				}
+				c, err := opts.client()
+				if err != nil {
+					return err
+				}
+				return wrapAuth(c.DeleteEnvGroup(cmd.Context(), owner, group))
+			},
+		},
+		&cobra.Command{
+			Use:   "attached",
+			Short: "List the groups the app starts with, in the order they apply",
+			Args:  cobra.NoArgs,
+			RunE: func(cmd *cobra.Command, args []string) error {
+				owner, project, err := opts.target(nil)
+				if err != nil {
+					return err
+				}
+				c, err := opts.client()
+				if err != nil {
+					return err
+				}
+				groups, err := c.ProjectEnvGroups(cmd.Context(), owner, project)
+				if err != nil {
+					return wrapAuth(err)
+				}
+				w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+				fmt.Fprintln(w, "NAME\tSETS\tOVERRIDDEN BY THE APP")
+				for _, g := range groups {
+					sets := append(append([]string{}, g.Env...), g.Secrets...)
+					sort.Strings(sets)
+					overridden := strings.Join(g.Overridden, ",")
+					if overridden == "" {
+						overridden = "-"
+					}
+					fmt.Fprintf(w, "%s\t%s\t%s\n", g.Name, strings.Join(sets, ","), overridden)
+				}
+				return w.Flush()
+			},
+		},
+		&cobra.Command{
+			Use:   "attach GROUP",
+			Short: "Attach a group of the owner to the app and release it again",
+			Args:  cobra.ExactArgs(1),
+			RunE: func(cmd *cobra.Command, args []string) error {
+				owner, project, err := opts.target(nil)
+				if err != nil {
+					return err
+				}
+				c, err := opts.client()
+				if err != nil {
+					return err
+				}
+				return wrapAuth(c.AttachEnvGroup(cmd.Context(), owner, project, args[0]))
+			},
+		},
+		&cobra.Command{
+			Use:   "detach GROUP",
+			Short: "Detach a group from the app and release it again",
+			Args:  cobra.ExactArgs(1),
+			RunE: func(cmd *cobra.Command, args []string) error {
+				owner, project, err := opts.target(nil)
+				if err != nil {
+					return err
+				}
+				c, err := opts.client()
+				if err != nil {
+					return err
+				}
+				return wrapAuth(c.DetachEnvGroup(cmd.Context(), owner, project, args[0]))
+			},
+		},
+	)
+	return cmd
+}
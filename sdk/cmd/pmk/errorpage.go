@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	pemasak "github.com/mustafasegf/pemasak-infra/sdk"
+)
+
+func newErrorPageCmd(opts *rootOptions) *cobra.Command {
+	var html, redirect string
+	var reset bool
+	cmd := &cobra.Command{
+		Use:   "error-page",
+		Short: "Choose what visitors see when an app can't be reached",
+		Long: `Choose what visitors see when an app can't be reached.
+
+When the app is down the proxy answers 502, when it didn't start in time
+503, when it didn't answer within its response timeout (pmk responses) 504.
+Visitors get a page of the platform with a correlation id, tell it to the
+platform admins and they find what went wrong. --html serves a file of your
+own instead, {{status}} and {{correlation_id}} in it are filled in.
+--redirect sends visitors to a url, like a status page, with status and
+correlation_id query parameters. --reset goes back to the platform page.
+Errors your app returns itself are passed on as they are. Without flags the
+current setting is shown. Use --app or PMK_APP to pick the app.`,
+		Example: `  pmk error-page --html down.html
+  pmk error-page --redirect https://status.example.com
+  pmk error-page --reset`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			owner, project, err := opts.target(nil)
+			if err != nil {
+				return err
+			}
+			c, err := opts.client()
+			if err != nil {
+				return err
+			}
+
+			switch {
+			case reset:
+				if err := c.ResetErrorPage(cmd.Context(), owner, project); err != nil {
+					return wrapAuth(err)
+				}
+			case html != "":
+				page, err := os.ReadFile(html)
+				if err != nil {
+					return err
+				}
+				if err := c.SetErrorPage(cmd.Context(), owner, project, pemasak.ErrorPage{HTML: string(page)}); err != nil {
+					return wrapAuth(err)
+				}
+			case redirect != "":
+				if err := c.SetErrorPage(cmd.Context(), owner, project, pemasak.ErrorPage{Redirect: redirect}); err != nil {
+					return wrapAuth(err)
+				}
+			default:
+				page, err := c.GetErrorPage(cmd.Context(), owner, project)
+				if err != nil {
+					return wrapAuth(err)
+				}
+				switch {
+				case page.Redirect != "":
+					fmt.Fprintf(cmd.OutOrStdout(), "redirects to %s\n", page.Redirect)
+				case page.HTML != "":
+					fmt.Fprintf(cmd.OutOrStdout(), "own page, %d bytes\n", len(page.HTML))
+				default:
+					fmt.Fprintln(cmd.OutOrStdout(), "platform page")
+				}
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&html, "html", "", "HTML file served instead of the platform page")
+	cmd.Flags().StringVar(&redirect, "redirect", "", "url visitors are sent to instead")
+	cmd.Flags().BoolVar(&reset, "reset", false, "go back to the platform page")
+	cmd.MarkFlagsMutuallyExclusive("html", "redirect", "reset")
+	return cmd
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	pemasak "github.com/mustafasegf/pemasak-infra/sdk"
+)
+
+func newEventsCmd(opts *rootOptions) *cobra.Command {
+	var (
+		filter pemasak.EventFilter
+		all    bool
+	)
+	cmd := &cobra.Command{
+		Use:   "events [owner/project]",
+		Short: "Show the timeline of an app: builds, releases, scaling, crashes and changes",
+		Long: `Show what happened to an app in one timeline, newest first: builds,
+releases, scaling, crashes, changes of its settings and of its addons.
+
+Changes only name what was changed, pmk audit shows the values to maintainers.`,
+		Example: `  pmk events kelompok-3/api --type deploy,crash
+  pmk events --limit 200 --all`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			owner, project, err := opts.target(args)
+			if err != nil {
+				return err
+			}
+			c, err := opts.client()
+			if err != nil {
+				return err
+			}
+			w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "TIME\tTYPE\tKIND\tACTOR\tMESSAGE")
+			for {
+				page, err := c.ListEvents(cmd.Context(), owner, project, filter)
+				if err != nil {
+					return wrapAuth(err)
+				}
+				for _, e := range page.Events {
+					actor := e.Actor
+					if actor == "" {
+						actor = "-"
+					}
+					fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n",
+						e.CreatedAt.Local().Format(time.DateTime), e.Type, e.Kind, actor, e.Message)
+				}
+				if !all || page.NextCursor == "" {
+					break
+				}
+				filter.Cursor = page.NextCursor
+			}
+			return w.Flush()
+		},
+	}
+	cmd.Flags().StringSliceVar(&filter.Types, "type", nil, "only these types: build, deploy, scale, crash, config, addon")
+	cmd.Flags().IntVar(&filter.Limit, "limit", 0, "events per page, 50 by default and 200 at most")
+	cmd.Flags().BoolVar(&all, "all", false, "page through every event instead of the latest")
+	return cmd
+}
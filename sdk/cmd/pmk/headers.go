@@ -0,0 +1,218 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	pemasak "github.com/mustafasegf/pemasak-infra/sdk"
+)
+
+func newHeadersCmd(opts *rootOptions) *cobra.Command {
+	var request bool
+	cmd := &cobra.Command{
+		Use:   "headers",
+		Short: "Change the headers of an app at the platform",
+		Long: `Change the headers of an app at the platform.
+
+Rules set, add or remove headers of the responses of the app on their way
+to the visitor, like adding X-Frame-Options or removing Server, or with
+--request of the requests on their way to the app. Headers are removed
+first, then set, then added. Values may have {client_ip}, {request_id},
+{host} and {scheme} in them. The app always gets X-Forwarded-For,
+X-Forwarded-Proto and X-Forwarded-Host from the platform, rules can change
+them too. A [headers] section in pemasak.toml replaces these rules on every
+deploy. Without a subcommand the rules are shown. Use --app or PMK_APP to
+pick the app.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			owner, project, err := opts.target(nil)
+			if err != nil {
+				return err
+			}
+			c, err := opts.client()
+			if err != nil {
+				return err
+			}
+			rules, err := c.GetHeaderRules(cmd.Context(), owner, project)
+			if err != nil {
+				return wrapAuth(err)
+			}
+
+			out := cmd.OutOrStdout()
+			print := func(direction string, actions pemasak.HeaderActions) {
+				for _, kind := range []struct {
+					name   string
+					values map[string]string
+				}{{"set", actions.Set}, {"add", actions.Add}} {
+					names := make([]string, 0, len(kind.values))
+					for name := range kind.values {
+						names = append(names, name)
+					}
+					sort.Strings(names)
+					for _, name := range names {
+						fmt.Fprintf(out, "%s %s %s: %s\n", direction, kind.name, name, kind.values[name])
+					}
+				}
+				for _, name := range actions.Remove {
+					fmt.Fprintf(out, "%s remove %s\n", direction, name)
+				}
+			}
+			print("request", rules.Request)
+			print("response", rules.Response)
+			return nil
+		},
+	}
+	cmd.PersistentFlags().BoolVar(&request, "request", false, "change the requests to the app instead of its responses")
+
+	// update changes the rules of the app the way change says, on the side --request picks
+	update := func(cmd *cobra.Command, change func(*pemasak.HeaderActions)) error {
+		owner, project, err := opts.target(nil)
+		if err != nil {
+			return err
+		}
+		c, err := opts.client()
+		if err != nil {
+			return err
+		}
+		rules, err := c.GetHeaderRules(cmd.Context(), owner, project)
+		if err != nil {
+			return wrapAuth(err)
+		}
+		actions := &rules.Response
+		if request {
+			actions = &rules.Request
+		}
+		change(actions)
+		if err := c.SetHeaderRules(cmd.Context(), owner, project, *rules); err != nil {
+			return wrapAuth(err)
+		}
+		return nil
+	}
+	// pairs parses NAME=VALUE arguments
+	pairs := func(args []string) (map[string]string, error) {
+		headers := make(map[string]string, len(args))
+		for _, arg := range args {
+			name, value, ok := strings.Cut(arg, "=")
+			if !ok || name == "" {
+				return nil, fmt.Errorf("%q is not NAME=VALUE", arg)
+			}
+			headers[name] = value
+		}
+		return headers, nil
+	}
+	// forget drops the rules about the header name
+	forget := func(actions *pemasak.HeaderActions, name string) {
+		for header := range actions.Set {
+			if strings.EqualFold(header, name) {
+				delete(actions.Set, header)
+			}
+		}
+		for header := range actions.Add {
+			if strings.EqualFold(header, name) {
+				delete(actions.Add, header)
+			}
+		}
+		actions.Remove = slices.DeleteFunc(actions.Remove, func(header string) bool { return strings.EqualFold(header, name) })
+	}
+
+	set := &cobra.Command{
+		Use:   "set NAME=VALUE...",
+		Short: "Replace headers, or add them when they are missing",
+		Example: `  pmk headers set X-Frame-Options=DENY Referrer-Policy=strict-origin-when-cross-origin
+  pmk headers set --request X-Real-IP={client_ip}`,
+		Args: cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			headers, err := pairs(args)
+			if err != nil {
+				return err
+			}
+			return update(cmd, func(actions *pemasak.HeaderActions) {
+				if actions.Set == nil {
+					actions.Set = map[string]string{}
+				}
+				for name, value := range headers {
+					forget(actions, name)
+					actions.Set[name] = value
+				}
+			})
+		},
+	}
+
+	add := &cobra.Command{
+		Use:     "add NAME=VALUE...",
+		Short:   "Add headers next to the ones already there",
+		Example: `  pmk headers add Link='</app.css>; rel=preload; as=style'`,
+		Args:    cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			headers, err := pairs(args)
+			if err != nil {
+				return err
+			}
+			return update(cmd, func(actions *pemasak.HeaderActions) {
+				if actions.Add == nil {
+					actions.Add = map[string]string{}
+				}
+				for name, value := range headers {
+					forget(actions, name)
+					actions.Add[name] = value
+				}
+			})
+		},
+	}
+
+	remove := &cobra.Command{
+		Use:   "remove NAME...",
+		Short: "Remove headers",
+		Example: `  pmk headers remove Server X-Powered-By
+  pmk headers remove --request Cookie`,
+		Args: cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return update(cmd, func(actions *pemasak.HeaderActions) {
+				for _, name := range args {
+					forget(actions, name)
+					actions.Remove = append(actions.Remove, name)
+				}
+			})
+		},
+	}
+
+	unset := &cobra.Command{
+		Use:   "unset NAME...",
+		Short: "Drop the rules about headers, they pass through as they are again",
+		Args:  cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return update(cmd, func(actions *pemasak.HeaderActions) {
+				for _, name := range args {
+					forget(actions, name)
+				}
+			})
+		},
+	}
+
+	clear := &cobra.Command{
+		Use:   "clear",
+		Short: "Drop all the rules, on both sides",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			owner, project, err := opts.target(nil)
+			if err != nil {
+				return err
+			}
+			c, err := opts.client()
+			if err != nil {
+				return err
+			}
+			if err := c.SetHeaderRules(cmd.Context(), owner, project, pemasak.HeaderRules{}); err != nil {
+				return wrapAuth(err)
+			}
+			return nil
+		},
+	}
+
+	cmd.AddCommand(set, add, remove, unset, clear)
+	return cmd
+}
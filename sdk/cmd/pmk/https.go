@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/spf13/cobra"
+
+	pemasak "github.com/mustafasegf/pemasak-infra/sdk"
+)
+
+func newHTTPSCmd(opts *rootOptions) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "https",
+		Short: "Redirect an app to https and send HSTS",
+		Long: `Redirect an app to https and send HSTS.
+
+pmk https redirect on sends visitors coming over plain http to the same URL
+over https, with a 308 so forms keep their method and body. pmk https hsts
+makes every https response carry Strict-Transport-Security, so browsers
+that saw it once only use https for the app until the max-age runs out,
+even when a link says http. Start with a short max-age, a browser can't be
+told to forget it early. Without a subcommand the current settings are
+shown. Use --app or PMK_APP to pick the app.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			owner, project, err := opts.target(nil)
+			if err != nil {
+				return err
+			}
+			c, err := opts.client()
+			if err != nil {
+				return err
+			}
+			settings, err := c.GetSettings(cmd.Context(), owner, project)
+			if err != nil {
+				return wrapAuth(err)
+			}
+
+			redirect := "off"
+			if settings.HTTPSRedirect {
+				redirect = "on"
+			}
+			hsts := "off"
+			if settings.HSTSMaxAge > 0 {
+				hsts = fmt.Sprintf("max-age %d", settings.HSTSMaxAge)
+				if settings.HSTSPreload {
+					hsts += ", preload"
+				}
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "redirect: %s, hsts: %s\n", redirect, hsts)
+			return nil
+		},
+	}
+
+	// update changes the settings of the app the way change says
+	update := func(cmd *cobra.Command, change func(*pemasak.Settings)) error {
+		owner, project, err := opts.target(nil)
+		if err != nil {
+			return err
+		}
+		c, err := opts.client()
+		if err != nil {
+			return err
+		}
+		settings, err := c.GetSettings(cmd.Context(), owner, project)
+		if err != nil {
+			return wrapAuth(err)
+		}
+		change(settings)
+		if err := c.UpdateSettings(cmd.Context(), owner, project, *settings); err != nil {
+			return wrapAuth(err)
+		}
+		return nil
+	}
+
+	redirect := &cobra.Command{
+		Use:       "redirect on|off",
+		Short:     "Redirect plain http requests to https",
+		Example:   `  pmk https redirect on`,
+		Args:      cobra.ExactArgs(1),
+		ValidArgs: []string{"on", "off"},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var on bool
+			switch args[0] {
+			case "on":
+				on = true
+			case "off":
+				on = false
+			default:
+				return fmt.Errorf("invalid setting %q, expected on or off", args[0])
+			}
+			return update(cmd, func(settings *pemasak.Settings) { settings.HTTPSRedirect = on })
+		},
+	}
+
+	var preload bool
+	hsts := &cobra.Command{
+		Use:   "hsts MAX-AGE|off",
+		Short: "Tell browsers to only use https for the app",
+		Long: `Tell browsers to only use https for the app for MAX-AGE seconds after an
+https response. --preload also covers every subdomain and asks to be put on
+the preload list browsers ship with, which needs a MAX-AGE of at least
+31536000, a year. Only use it on a custom domain you submit to
+hstspreload.org, getting off the list again takes months. off stops
+sending the header; browsers keep what they got until it expires.`,
+		Example: `  pmk https hsts 300
+  pmk https hsts 31536000 --preload
+  pmk https hsts off`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			maxAge := 0
+			if args[0] != "off" {
+				var err error
+				maxAge, err = strconv.Atoi(args[0])
+				if err != nil || maxAge <= 0 {
+					return fmt.Errorf("invalid max-age %q, expected seconds or off", args[0])
+				}
+			}
+			if preload && maxAge < 31536000 {
+				return fmt.Errorf("--preload needs a max-age of at least 31536000 seconds")
+			}
+			return update(cmd, func(settings *pemasak.Settings) {
+				settings.HSTSMaxAge = maxAge
+				settings.HSTSPreload = preload
+			})
+		},
+	}
+	hsts.Flags().BoolVar(&preload, "preload", false, "add includeSubDomains and preload for the browser preload list")
+
+	cmd.AddCommand(redirect, hsts)
+	return cmd
+}
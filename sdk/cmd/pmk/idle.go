@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/spf13/cobra"
+)
+
+func newIdleCmd(opts *rootOptions) *cobra.Command {
+	return &cobra.Command{
+		Use:   "idle [MINUTES|off]",
+		Short: "Stop an app while it gets no traffic",
+		Long: `Stop an app while it gets no traffic.
+
+After MINUTES without a request the web containers of the app are stopped,
+the next request starts them again and waits until the app is ready. Workers
+keep running. Without arguments the current timeout is shown. Use --app or
+PMK_APP to pick the app.`,
+		Example: `  pmk idle 30
+  pmk idle off`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			owner, project, err := opts.target(nil)
+			if err != nil {
+				return err
+			}
+			c, err := opts.client()
+			if err != nil {
+				return err
+			}
+			settings, err := c.GetSettings(cmd.Context(), owner, project)
+			if err != nil {
+				return wrapAuth(err)
+			}
+
+			if len(args) == 0 {
+				if settings.IdleTimeout == 0 {
+					fmt.Fprintln(cmd.OutOrStdout(), "never idles")
+				} else {
+					fmt.Fprintf(cmd.OutOrStdout(), "idles after %d minutes\n", settings.IdleTimeout)
+				}
+				return nil
+			}
+
+			settings.IdleTimeout = 0
+			if args[0] != "off" {
+				minutes, err := strconv.Atoi(args[0])
+				if err != nil || minutes <= 0 {
+					return fmt.Errorf("invalid timeout %q, expected minutes or off", args[0])
+				}
+				settings.IdleTimeout = minutes
+			}
+			if err := c.UpdateSettings(cmd.Context(), owner, project, *settings); err != nil {
+				return wrapAuth(err)
+			}
+			return nil
+		},
+	}
+}
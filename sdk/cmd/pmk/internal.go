@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+func newInternalCmd(opts *rootOptions) *cobra.Command {
+	return &cobra.Command{
+		Use:   "internal [on|off]",
+		Short: "Keep an app off the internet",
+		Long: `Keep an app off the internet.
+
+Every app joins the private network of its owner, where the other apps of the
+owner reach it at http://APP.internal without going through the public
+proxy. An internal app is only reachable there, its subdomain and custom
+domains answer 404. The change applies on the next request. Without arguments
+the current setting is shown. Use --app or PMK_APP to pick the app.`,
+		Example: `  pmk internal on
+  pmk internal off`,
+		Args:      cobra.MaximumNArgs(1),
+		ValidArgs: []string{"on", "off"},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			owner, project, err := opts.target(nil)
+			if err != nil {
+				return err
+			}
+			c, err := opts.client()
+			if err != nil {
+				return err
+			}
+			settings, err := c.GetSettings(cmd.Context(), owner, project)
+			if err != nil {
+				return wrapAuth(err)
+			}
+
+			if len(args) == 0 {
+				if settings.Internal {
+					fmt.Fprintf(cmd.OutOrStdout(), "internal, reachable at http://%s.internal\n", project)
+				} else {
+					fmt.Fprintf(cmd.OutOrStdout(), "public, also reachable at http://%s.internal\n", project)
+				}
+				return nil
+			}
+
+			switch args[0] {
+			case "on":
+				settings.Internal = true
+			case "off":
+				settings.Internal = false
+			default:
+				return fmt.Errorf("invalid setting %q, expected on or off", args[0])
+			}
+			if err := c.UpdateSettings(cmd.Context(), owner, project, *settings); err != nil {
+				return wrapAuth(err)
+			}
+			return nil
+		},
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+)
+
+// printLabels prints labels as KEY VALUE, sorted by key.
+func printLabels(cmd *cobra.Command, labels map[string]string) error {
+	if len(labels) == 0 {
+		fmt.Fprintln(cmd.OutOrStdout(), "No labels, set them with pmk labels set key=value")
+		return nil
+	}
+	keys := make([]string, 0, len(labels))
+	for key := range labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "KEY\tVALUE")
+	for _, key := range keys {
+		fmt.Fprintf(w, "%s\t%s\n", key, labels[key])
+	}
+	return w.Flush()
+}
+
+// formatLabels is labels as key=value,key=value, sorted by key.
+func formatLabels(labels map[string]string) string {
+	pairs := make([]string, 0, len(labels))
+	for key, value := range labels {
+		pairs = append(pairs, key+"="+value)
+	}
+	sort.Strings(pairs)
+	if len(pairs) == 0 {
+		return "-"
+	}
+	return strings.Join(pairs, ",")
+}
+
+func newLabelsCmd(opts *rootOptions) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "labels [owner/project]",
+		Short: "Show, set and remove the labels of an app",
+		Long: `Show, set and remove the labels of an app.
+
+Labels are key=value pairs like course=COMP301 or team=blue. Lists and bulk
+actions take a selector of them with --selector, like
+pmk apps restart --selector course=COMP301,assignment=3. A selector is a
+comma separated list of key=value, key!=value, key for having the label and
+!key for not having it, an app has to meet all of them.`,
+		Example: `  pmk labels budi/tugas-3
+  pmk labels set course=COMP301 assignment=3 --app budi/tugas-3
+  pmk labels unset team --app budi/tugas-3`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			owner, project, err := opts.target(args)
+			if err != nil {
+				return err
+			}
+			c, err := opts.client()
+			if err != nil {
+				return err
+			}
+			labels, err := c.Labels(cmd.Context(), owner, project)
+			if err != nil {
+				return wrapAuth(err)
+			}
+			return printLabels(cmd, labels)
+		},
+	}
+
+	cmd.AddCommand(
+		&cobra.Command{
+			Use:   "set key=value...",
+			Short: "Set labels of an app, changing the ones it has",
+			Args:  cobra.MinimumNArgs(1),
+			RunE: func(cmd *cobra.Command, args []string) error {
+				set := make(map[string]string, len(args))
+				for _, arg := range args {
+					key, value, ok := strings.Cut(arg, "=")
+					if !ok || key == "" {
+						return fmt.Errorf("expected key=value, not %q", arg)
+					}
+					set[key] = value
+				}
+				owner, project, err := opts.target(nil)
+				if err != nil {
+					return err
+				}
+				c, err := opts.client()
+				if err != nil {
+					return err
+				}
+				labels, err := c.SetLabels(cmd.Context(), owner, project, set, nil)
+				if err != nil {
+					return wrapAuth(err)
+				}
+				return printLabels(cmd, labels)
+			},
+		},
+		&cobra.Command{
+			Use:   "unset key...",
+			Short: "Remove labels of an app",
+			Args:  cobra.MinimumNArgs(1),
+			RunE: func(cmd *cobra.Command, args []string) error {
+				owner, project, err := opts.target(nil)
+				if err != nil {
+					return err
+				}
+				c, err := opts.client()
+				if err != nil {
+					return err
+				}
+				labels, err := c.SetLabels(cmd.Context(), owner, project, nil, args)
+				if err != nil {
+					return wrapAuth(err)
+				}
+				return printLabels(cmd, labels)
+			},
+		},
+	)
+	return cmd
+}
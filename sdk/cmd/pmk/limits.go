@@ -0,0 +1,207 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+
+	pemasak "github.com/mustafasegf/pemasak-infra/sdk"
+)
+
+func newLimitsCmd(opts *rootOptions) *cobra.Command {
+	return &cobra.Command{
+		Use:   "limits [owner/project]",
+		Short: "Show the memory, cpus and disk an app may use",
+		Long: `Show the memory, cpus and disk an app may use. A container that uses more
+memory than its limit is killed, pmk activity says so. Platform admins set
+limits for heavier apps, ask them when yours needs more.`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			owner, project, err := opts.target(args)
+			if err != nil {
+				return err
+			}
+			c, err := opts.client()
+			if err != nil {
+				return err
+			}
+			limits, err := c.Limits(cmd.Context(), owner, project)
+			if err != nil {
+				return wrapAuth(err)
+			}
+			return printLimits(cmd, limits)
+		},
+	}
+}
+
+func printLimits(cmd *cobra.Command, limits *pemasak.AppLimits) error {
+	w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "LIMIT\tVALUE\tFROM")
+	fmt.Fprintf(w, "memory\t%d MiB\t%s\n", limits.MemoryMB, limits.Sources["memory"])
+	fmt.Fprintf(w, "cpus\t%g\t%s\n", limits.CPUs, limits.Sources["cpus"])
+	fmt.Fprintf(w, "disk\t%d MiB\t%s\n", limits.DiskMB, limits.Sources["disk"])
+	return w.Flush()
+}
+
+func printOverrides(cmd *cobra.Command, limits *pemasak.LimitOverrides) error {
+	memory, cpus, disk := "default", "default", "default"
+	if limits.MemoryMB != nil {
+		memory = fmt.Sprintf("%d MiB", *limits.MemoryMB)
+	}
+	if limits.CPUs != nil {
+		cpus = fmt.Sprintf("%g", *limits.CPUs)
+	}
+	if limits.DiskMB != nil {
+		disk = fmt.Sprintf("%d MiB", *limits.DiskMB)
+	}
+	w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "LIMIT\tVALUE")
+	fmt.Fprintf(w, "memory\t%s\ncpus\t%s\ndisk\t%s\n", memory, cpus, disk)
+	return w.Flush()
+}
+
+// limitFlags are the --memory, --cpus and --disk flags of pmk admin limits.
+// "default" clears a limit, a flag that isn't given keeps it.
+type limitFlags struct {
+	memory, cpus, disk string
+}
+
+func (f *limitFlags) register(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&f.memory, "memory", "", `memory of every container in MiB, or "default"`)
+	cmd.Flags().StringVar(&f.cpus, "cpus", "", `cpus of every container like 0.5, or "default"`)
+	cmd.Flags().StringVar(&f.disk, "disk", "", `size of all volumes together in MiB, or "default"`)
+}
+
+func (f *limitFlags) changed(cmd *cobra.Command) bool {
+	return cmd.Flags().Changed("memory") || cmd.Flags().Changed("cpus") || cmd.Flags().Changed("disk")
+}
+
+// apply changes the given flags in limits.
+func (f *limitFlags) apply(cmd *cobra.Command, limits *pemasak.LimitOverrides) error {
+	if cmd.Flags().Changed("memory") {
+		v, err := parseLimit(f.memory, "memory", strconv.Atoi)
+		if err != nil {
+			return err
+		}
+		limits.MemoryMB = v
+	}
+	if cmd.Flags().Changed("cpus") {
+		v, err := parseLimit(f.cpus, "cpus", func(s string) (float64, error) { return strconv.ParseFloat(s, 64) })
+		if err != nil {
+			return err
+		}
+		limits.CPUs = v
+	}
+	if cmd.Flags().Changed("disk") {
+		v, err := parseLimit(f.disk, "disk", strconv.Atoi)
+		if err != nil {
+			return err
+		}
+		limits.DiskMB = v
+	}
+	return nil
+}
+
+func parseLimit[T any](s, name string, parse func(string) (T, error)) (*T, error) {
+	if s == "default" {
+		return nil, nil
+	}
+	v, err := parse(s)
+	if err != nil {
+		return nil, fmt.Errorf("--%s takes a number or \"default\", not %q", name, s)
+	}
+	return &v, nil
+}
+
+func newAdminLimitsCmd(opts *rootOptions) *cobra.Command {
+	var flags limitFlags
+	var user, selector string
+	cmd := &cobra.Command{
+		Use:   "limits [owner/project]",
+		Short: "Show or set the limits of an app or a user",
+		Long: `Show or set the memory, cpus and disk of an app, or with --user of every app
+a user owns. Limits of an app win over those of its owners, and those win over
+the defaults of the platform. Running containers get new limits right away.
+Give "default" to clear a limit.
+
+With --selector the limits are set on every app with those labels, like
+course=COMP301. The limits not given are cleared on them, and apps labeled
+later don't get them.`,
+		Example: `  pmk admin limits kelompok-3/api --memory 1024 --cpus 2
+  pmk admin limits kelompok-3/api --memory default
+  pmk admin limits --user budi --disk 4096
+  pmk admin limits --selector course=COMP301 --memory 256`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c, err := opts.client()
+			if err != nil {
+				return err
+			}
+
+			if selector != "" {
+				if len(args) > 0 || user != "" {
+					return fmt.Errorf("give an app, --user or --selector, not more")
+				}
+				if !flags.changed(cmd) {
+					return fmt.Errorf("--selector needs the limits to set")
+				}
+				var limits pemasak.LimitOverrides
+				if err := flags.apply(cmd, &limits); err != nil {
+					return err
+				}
+				apps, err := c.SetSelectedLimits(cmd.Context(), selector, limits)
+				if err != nil {
+					return wrapAuth(err)
+				}
+				fmt.Fprintf(cmd.OutOrStdout(), "Set the limits of %d apps\n", len(apps))
+				for _, app := range apps {
+					fmt.Fprintln(cmd.OutOrStdout(), app)
+				}
+				return nil
+			}
+
+			if user != "" {
+				if len(args) > 0 {
+					return fmt.Errorf("give an app or --user, not both")
+				}
+				limits, err := c.UserLimits(cmd.Context(), user)
+				if err != nil {
+					return wrapAuth(err)
+				}
+				if flags.changed(cmd) {
+					if err := flags.apply(cmd, limits); err != nil {
+						return err
+					}
+					if limits, err = c.SetUserLimits(cmd.Context(), user, *limits); err != nil {
+						return wrapAuth(err)
+					}
+				}
+				return printOverrides(cmd, limits)
+			}
+
+			owner, project, err := opts.target(args)
+			if err != nil {
+				return err
+			}
+			limits, err := c.AppLimits(cmd.Context(), owner, project)
+			if err != nil {
+				return wrapAuth(err)
+			}
+			if flags.changed(cmd) {
+				if err := flags.apply(cmd, &limits.App); err != nil {
+					return err
+				}
+				if limits, err = c.SetAppLimits(cmd.Context(), owner, project, limits.App); err != nil {
+					return wrapAuth(err)
+				}
+			}
+			return printLimits(cmd, limits)
+		},
+	}
+	flags.register(cmd)
+	cmd.Flags().StringVar(&user, "user", "", "show or set the limits of a user instead, for every app they own")
+	cmd.Flags().StringVarP(&selector, "selector", "l", "", "set the limits of every app with these labels, see pmk labels")
+	return cmd
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+
+	pemasak "github.com/mustafasegf/pemasak-infra/sdk"
+)
+
+func newLoginCmd(opts *rootOptions) *cobra.Command {
+	var username string
+
+	cmd := &cobra.Command{
+		Use:   "login",
+		Short: "Log in and save the session",
+		Long: `Log in and save the session for later commands.
+
+For scripts and CI, set PMK_USERNAME and PMK_PASSWORD instead of typing them.
+Accounts with two-factor authentication are asked for the code of their
+authenticator app, or a recovery code; PMK_OTP sets it. CI is better off with
+an access token from pmk tokens.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c, err := opts.client()
+			if err != nil {
+				return err
+			}
+
+			if username == "" {
+				username = os.Getenv("PMK_USERNAME")
+			}
+			if username == "" {
+				fmt.Fprint(cmd.ErrOrStderr(), "Username: ")
+				line, err := bufio.NewReader(cmd.InOrStdin()).ReadString('\n')
+				if err != nil {
+					return err
+				}
+				username = strings.TrimSpace(line)
+			}
+
+			password := os.Getenv("PMK_PASSWORD")
+			if password == "" {
+				fmt.Fprint(cmd.ErrOrStderr(), "Password: ")
+				b, err := term.ReadPassword(int(os.Stdin.Fd()))
+				fmt.Fprintln(cmd.ErrOrStderr())
+				if err != nil {
+					return err
+				}
+				password = string(b)
+			}
+
+			err = c.Login(cmd.Context(), username, password)
+			if errors.Is(err, pemasak.ErrTwoFactorRequired) {
+				code := os.Getenv("PMK_OTP")
+				if code == "" {
+					fmt.Fprint(cmd.ErrOrStderr(), "Code: ")
+					line, err := bufio.NewReader(cmd.InOrStdin()).ReadString('\n')
+					if err != nil {
+						return err
+					}
+					code = strings.TrimSpace(line)
+				}
+				err = c.LoginWithCode(cmd.Context(), username, password, code)
+			}
+			if err != nil {
+				return err
+			}
+
+			cfg, err := loadConfig()
+			if err != nil {
+				return err
+			}
+			cfg.saveSession(c)
+			if err := saveConfig(cfg); err != nil {
+				return err
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "Logged in to %s as %s\n", c.BaseURL(), username)
+			return nil
+		},
+	}
+	cmd.Flags().StringVarP(&username, "username", "u", "", "username (env PMK_USERNAME)")
+	return cmd
+}
+
+func newLogoutCmd(opts *rootOptions) *cobra.Command {
+	return &cobra.Command{
+		Use:   "logout",
+		Short: "End the saved session",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c, err := opts.client()
+			if err != nil {
+				return err
+			}
+			if err := c.Logout(cmd.Context()); err != nil {
+				return err
+			}
+			cfg, err := loadConfig()
+			if err != nil {
+				return err
+			}
+			cfg.Cookies = nil
+			return saveConfig(cfg)
+		},
+	}
+}
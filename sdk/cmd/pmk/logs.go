@@ -0,0 +1,362 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	pemasak "github.com/mustafasegf/pemasak-infra/sdk"
+)
+
+func newLogsCmd(opts *rootOptions) *cobra.Command {
+	var (
+		follow   bool
+		interval time.Duration
+		source   string
+		status   string
+		search   pemasak.ContainerLogFilter
+	)
+
+	cmd := &cobra.Command{
+		Use:   "logs [owner/project]",
+		Short: "Print the output of the running container",
+		Long: `Print the output of the running container.
+
+With --since, --until, --grep, --process or --stream the output the
+platform kept is searched instead. It goes back days and includes
+containers that crashed or were replaced by a deploy, one line each with
+the time, the process, the container and what it wrote. How long and how
+much output is kept is set with pmk logs retention.
+
+With --source router the requests the platform answered for the app are
+printed instead, one line each with the time, the client, the request, the
+status, the bytes sent, how long the app took to answer, the container
+that answered and the request id. --status narrows them down, like
+--status 5xx. How many requests are logged and for how long is set with
+pmk logs settings.`,
+		Example: `  pmk logs -f
+  pmk logs --since 2h --grep panic
+  pmk logs --since 2026-10-15T08:00:00+07:00 --until 30m --stream stderr
+  pmk logs --source router --status 5xx`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			owner, project, err := opts.target(args)
+			if err != nil {
+				return err
+			}
+			c, err := opts.client()
+			if err != nil {
+				return err
+			}
+
+			searching := false
+			for _, name := range []string{"since", "until", "grep", "ignore-case", "process", "stream"} {
+				searching = searching || cmd.Flags().Changed(name)
+			}
+
+			switch source {
+			case "app":
+				if status != "" {
+					return fmt.Errorf("--status only works with --source router")
+				}
+				if searching {
+					return searchLogs(cmd.Context(), cmd.OutOrStdout(), c, owner, project, search, follow, interval)
+				}
+			case "router":
+				if searching {
+					return fmt.Errorf("--since, --until, --grep, --process and --stream only work with --source app")
+				}
+				return routerLogs(cmd.Context(), cmd.OutOrStdout(), c, owner, project, status, follow, interval)
+			default:
+				return fmt.Errorf("invalid source %q, expected app or router", source)
+			}
+
+			// prints what the container wrote that wasn't printed yet
+			var seen []string
+			printNew := func() error {
+				logs, err := c.Logs(cmd.Context(), owner, project)
+				if err != nil {
+					return err
+				}
+				lines := strings.SplitAfter(logs, "\n")
+				if len(lines) > 0 && lines[len(lines)-1] == "" {
+					lines = lines[:len(lines)-1]
+				}
+				for _, line := range lines[overlap(seen, lines):] {
+					fmt.Fprint(cmd.OutOrStdout(), line)
+				}
+				seen = lines
+				return nil
+			}
+
+			err = printNew()
+			if err == nil && follow {
+				// new output is pushed as the containers write it, the last
+				// lines are read again only when some were missed
+				err = c.Watch(cmd.Context(), owner, project, []pemasak.LiveTopic{pemasak.LiveLog}, func(update pemasak.LiveUpdate) error {
+					if update.Topic == pemasak.LiveResync {
+						return printNew()
+					}
+					for _, line := range update.Lines {
+						fmt.Fprintln(cmd.OutOrStdout(), line.Message)
+						seen = append(seen, line.Message+"\n")
+					}
+					return nil
+				})
+			}
+			if cmd.Context().Err() != nil {
+				return nil
+			}
+			return wrapAuth(err)
+		},
+	}
+	cmd.Flags().BoolVarP(&follow, "follow", "f", false, "keep printing new output")
+	cmd.Flags().DurationVar(&interval, "interval", 2*time.Second, "how often to poll with --follow and --source router or a search")
+	cmd.Flags().StringVar(&source, "source", "app", "app for the output of the container, router for the requests the platform answered")
+	cmd.Flags().StringVar(&status, "status", "", "with --source router, only requests answered with this status, like 404 or 5xx")
+	cmd.Flags().StringVar(&search.Since, "since", "", "only output after this time, like 2h, 7d or an RFC 3339 time")
+	cmd.Flags().StringVar(&search.Until, "until", "", "only output before this time, like 30m or an RFC 3339 time")
+	cmd.Flags().StringVar(&search.Grep, "grep", "", "only lines matching this regular expression")
+	cmd.Flags().BoolVarP(&search.IgnoreCase, "ignore-case", "i", false, "match --grep regardless of case")
+	cmd.Flags().StringVar(&search.Process, "process", "", "only output of this process, web or a worker")
+	cmd.Flags().StringVar(&search.Stream, "stream", "", "only stdout or stderr")
+	cmd.AddCommand(newLogsSettingsCmd(opts), newLogsRetentionCmd(opts))
+	return cmd
+}
+
+// searchLogs prints the container output the platform kept, following it with follow
+func searchLogs(ctx context.Context, out io.Writer, c *pemasak.Client, owner, project string, filter pemasak.ContainerLogFilter, follow bool, interval time.Duration) error {
+	for {
+		lines, err := c.SearchLogs(ctx, owner, project, filter)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return wrapAuth(err)
+		}
+		for _, line := range lines {
+			fmt.Fprintf(out, "%s %s %s %s\n",
+				line.RecordedAt.Local().Format(time.RFC3339), line.Process, line.Container, line.Message)
+			filter.After = line.ID
+		}
+
+		if !follow {
+			return nil
+		}
+		// after the first poll everything since the last line is fetched, as much as one poll returns
+		filter.Limit = 1000
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(interval):
+		}
+	}
+}
+
+func newLogsRetentionCmd(opts *rootOptions) *cobra.Command {
+	var days, size int
+	cmd := &cobra.Command{
+		Use:   "retention",
+		Short: "Set for how long and how much container output the platform keeps",
+		Long: `Set for how long and how much container output the platform keeps for
+pmk logs --since and --grep.
+
+--days is how many days lines are kept and --size how many MiB, the oldest
+lines go first past it. 0 goes back to the default of the platform. Without
+flags the settings are shown. Use --app or PMK_APP to pick the app.`,
+		Example: `  pmk logs retention --days 14
+  pmk logs retention --size 200`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			owner, project, err := opts.target(nil)
+			if err != nil {
+				return err
+			}
+			c, err := opts.client()
+			if err != nil {
+				return err
+			}
+			settings, err := c.GetContainerLogSettings(cmd.Context(), owner, project)
+			if err != nil {
+				return wrapAuth(err)
+			}
+
+			if !cmd.Flags().Changed("days") && !cmd.Flags().Changed("size") {
+				out := cmd.OutOrStdout()
+				if settings.Retention != nil {
+					fmt.Fprintf(out, "retention: %d days, at most %d\n", *settings.Retention, settings.MaxRetention)
+				} else {
+					fmt.Fprintf(out, "retention: %d days, the default, at most %d\n", settings.DefaultRetention, settings.MaxRetention)
+				}
+				if settings.Size != nil {
+					fmt.Fprintf(out, "size: %d MiB, at most %d\n", *settings.Size, settings.MaxSize)
+				} else {
+					fmt.Fprintf(out, "size: %d MiB, the default, at most %d\n", settings.DefaultSize, settings.MaxSize)
+				}
+				return nil
+			}
+
+			if cmd.Flags().Changed("days") {
+				switch {
+				case days < 0:
+					return fmt.Errorf("--days can't be negative")
+				case days == 0:
+					settings.Retention = nil
+				default:
+					settings.Retention = &days
+				}
+			}
+			if cmd.Flags().Changed("size") {
+				switch {
+				case size < 0:
+					return fmt.Errorf("--size can't be negative")
+				case size == 0:
+					settings.Size = nil
+				default:
+					settings.Size = &size
+				}
+			}
+			if err := c.SetContainerLogSettings(cmd.Context(), owner, project, *settings); err != nil {
+				return wrapAuth(err)
+			}
+			return nil
+		},
+	}
+	cmd.Flags().IntVar(&days, "days", 0, "days lines are kept, 0 for the default of the platform")
+	cmd.Flags().IntVar(&size, "size", 0, "MiB of lines kept, 0 for the default of the platform")
+	return cmd
+}
+
+// routerLogs prints the access log of an app, following it with follow
+func routerLogs(ctx context.Context, out io.Writer, c *pemasak.Client, owner, project, status string, follow bool, interval time.Duration) error {
+	filter := pemasak.AccessLogFilter{Status: status}
+	for {
+		lines, err := c.AccessLogs(ctx, owner, project, filter)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return wrapAuth(err)
+		}
+		for _, line := range lines {
+			bytes := "-"
+			if line.Bytes != nil {
+				bytes = formatSize(*line.Bytes)
+			}
+			upstream := line.Upstream
+			if upstream == "" {
+				upstream = "platform"
+			}
+			fmt.Fprintf(out, "%s %s \"%s %s\" %d %s %dms %s %s\n",
+				line.RecordedAt.Local().Format(time.RFC3339), line.ClientIP, line.Method, line.Path,
+				line.Status, bytes, line.DurationMS, upstream, line.RequestID)
+			filter.After = line.ID
+		}
+
+		if !follow {
+			return nil
+		}
+		// after the first poll everything since the last line is fetched, as much as one poll returns
+		filter.Limit = 1000
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(interval):
+		}
+	}
+}
+
+func newLogsSettingsCmd(opts *rootOptions) *cobra.Command {
+	var (
+		sample    int
+		retention int
+	)
+	cmd := &cobra.Command{
+		Use:   "settings",
+		Short: "Set how many requests of an app are logged and for how long",
+		Long: `Set how many requests of an app are logged and for how long.
+
+--sample is the percent of requests in pmk logs --source router, for apps
+with too many of them to read; requests the app answered with a server
+error are always logged. --retention is how many days lines are kept, 0
+goes back to the default of the platform. Without flags the settings are
+shown. Use --app or PMK_APP to pick the app.`,
+		Example: `  pmk logs settings --sample 10
+  pmk logs settings --retention 14`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			owner, project, err := opts.target(nil)
+			if err != nil {
+				return err
+			}
+			c, err := opts.client()
+			if err != nil {
+				return err
+			}
+			settings, err := c.GetAccessLogSettings(cmd.Context(), owner, project)
+			if err != nil {
+				return wrapAuth(err)
+			}
+
+			if !cmd.Flags().Changed("sample") && !cmd.Flags().Changed("retention") {
+				out := cmd.OutOrStdout()
+				fmt.Fprintf(out, "sample: %d%% of requests, all server errors\n", settings.Sample)
+				if settings.Retention != nil {
+					fmt.Fprintf(out, "retention: %d days, at most %d\n", *settings.Retention, settings.MaxRetention)
+				} else {
+					fmt.Fprintf(out, "retention: %d days, the default, at most %d\n", settings.DefaultRetention, settings.MaxRetention)
+				}
+				return nil
+			}
+
+			if cmd.Flags().Changed("sample") {
+				if sample < 0 || sample > 100 {
+					return fmt.Errorf("--sample must be between 0 and 100")
+				}
+				settings.Sample = sample
+			}
+			if cmd.Flags().Changed("retention") {
+				switch {
+				case retention < 0:
+					return fmt.Errorf("--retention can't be negative")
+				case retention == 0:
+					settings.Retention = nil
+				default:
+					settings.Retention = &retention
+				}
+			}
+			if err := c.SetAccessLogSettings(cmd.Context(), owner, project, *settings); err != nil {
+				return wrapAuth(err)
+			}
+			return nil
+		},
+	}
+	cmd.Flags().IntVar(&sample, "sample", 100, "percent of requests logged")
+	cmd.Flags().IntVar(&retention, "retention", 0, "days lines are kept, 0 for the default of the platform")
+	return cmd
+}
+
+// overlap returns how many leading lines of next were already printed as the
+// tail of prev. The platform only returns the last lines of the log, so the
+// window slides between polls.
+func overlap(prev, next []string) int {
+	for n := min(len(prev), len(next)); n > 0; n-- {
+		if equal(prev[len(prev)-n:], next[:n]) {
+			return n
+		}
+	}
+	return 0
+}
+
+func equal(a, b []string) bool {
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
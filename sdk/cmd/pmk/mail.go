@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+func newMailCmd(opts *rootOptions) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "mail",
+		Short: "Show the mail addon of an app and its suppression list",
+		Long: `Show the mail addon of an app and its suppression list.
+
+The app sends through the relay of the platform with the credentials in
+SMTP_URL, only from MAIL_FROM and to at most its quota of recipients a day.
+Recipients the upstream rejects for good are put on the suppression list,
+the relay refuses to send to them again until they are unsuppressed.
+Without a subcommand the addon and its last messages are shown.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			owner, project, err := opts.target(nil)
+			if err != nil {
+				return err
+			}
+			c, err := opts.client()
+			if err != nil {
+				return err
+			}
+			mail, err := c.GetMail(cmd.Context(), owner, project)
+			if err != nil {
+				return wrapAuth(err)
+			}
+
+			out := cmd.OutOrStdout()
+			fmt.Fprintf(out, "address: %s\n", mail.Address)
+			fmt.Fprintf(out, "relay: %s:%d as %s\n", mail.Host, mail.Port, mail.Username)
+			fmt.Fprintf(out, "sent today: %d of %d recipients\n", mail.SentToday, mail.Quota)
+			fmt.Fprintf(out, "created: %s\n", mail.CreatedAt.Local().Format(time.DateTime))
+
+			if len(mail.Messages) > 0 {
+				fmt.Fprintln(out)
+				w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+				fmt.Fprintln(w, "SENT\tRECIPIENTS\tSIZE\tSTATUS")
+				for _, m := range mail.Messages {
+					status := m.Status
+					if m.Error != "" {
+						status = fmt.Sprintf("%s: %s", m.Status, m.Error)
+					}
+					fmt.Fprintf(w, "%s\t%d\t%d B\t%s\n", m.CreatedAt.Local().Format(time.DateTime), m.Recipients, m.Size, status)
+				}
+				if err := w.Flush(); err != nil {
+					return err
+				}
+			}
+
+			if len(mail.Suppressions) > 0 {
+				fmt.Fprintln(out)
+				w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+				fmt.Fprintln(w, "SUPPRESSED\tREASON\tSINCE")
+				for _, s := range mail.Suppressions {
+					fmt.Fprintf(w, "%s\t%s\t%s\n", s.Address, s.Reason, s.CreatedAt.Local().Format(time.DateTime))
+				}
+				return w.Flush()
+			}
+			return nil
+		},
+	}
+
+	cmd.AddCommand(
+		&cobra.Command{
+			Use:   "suppress ADDRESS",
+			Short: "Stop the app from sending to an address",
+			Args:  cobra.ExactArgs(1),
+			RunE: func(cmd *cobra.Command, args []string) error {
+				owner, project, err := opts.target(nil)
+				if err != nil {
+					return err
+				}
+				c, err := opts.client()
+				if err != nil {
+					return err
+				}
+				return wrapAuth(c.SuppressMail(cmd.Context(), owner, project, args[0]))
+			},
+		},
+		&cobra.Command{
+			Use:   "unsuppress ADDRESS",
+			Short: "Let the app send to an address again",
+			Args:  cobra.ExactArgs(1),
+			RunE: func(cmd *cobra.Command, args []string) error {
+				owner, project, err := opts.target(nil)
+				if err != nil {
+					return err
+				}
+				c, err := opts.client()
+				if err != nil {
+					return err
+				}
+				return wrapAuth(c.UnsuppressMail(cmd.Context(), owner, project, args[0]))
+			},
+		},
+	)
+	return cmd
+}
@@ -0,0 +1,24 @@
+// Command pmk is a command line client for the pemasak-infra platform.
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+)
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	if err := newRootCmd().ExecuteContext(ctx); err != nil {
+		// the remote command already printed why it failed
+		if code, ok := isExitError(err); ok {
+			stop()
+			os.Exit(code)
+		}
+		fmt.Fprintln(os.Stderr, "error:", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	pemasak "github.com/mustafasegf/pemasak-infra/sdk"
+)
+
+func newMaintenanceCmd(opts *rootOptions) *cobra.Command {
+	var page string
+	var retryAfter int
+	cmd := &cobra.Command{
+		Use:   "maintenance [on|off]",
+		Short: "Serve a maintenance page instead of an app",
+		Long: `Serve a maintenance page instead of an app.
+
+While maintenance is on the proxy answers every request with status 503, a
+Retry-After header and the maintenance page, without stopping the app. Its
+containers keep running, so pmk run and pmk shell still work, which is handy
+during migrations. --page serves an HTML file of your own instead of the
+default page. Running on again replaces the page. Without arguments the
+current state is shown. Use --app or PMK_APP to pick the app.`,
+		Example: `  pmk maintenance on
+  pmk maintenance on --page maintenance.html --retry-after 600
+  pmk maintenance off`,
+		Args:      cobra.MaximumNArgs(1),
+		ValidArgs: []string{"on", "off"},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			owner, project, err := opts.target(nil)
+			if err != nil {
+				return err
+			}
+			c, err := opts.client()
+			if err != nil {
+				return err
+			}
+
+			if len(args) == 0 {
+				m, err := c.GetMaintenance(cmd.Context(), owner, project)
+				if err != nil {
+					return wrapAuth(err)
+				}
+				if !m.Enabled {
+					fmt.Fprintln(cmd.OutOrStdout(), "off")
+					return nil
+				}
+				kind := "default page"
+				if m.Page != "" {
+					kind = "own page"
+				}
+				fmt.Fprintf(cmd.OutOrStdout(), "on since %s, %s, retry after %d seconds\n", m.Since.Local().Format(time.DateTime), kind, m.RetryAfter)
+				return nil
+			}
+
+			switch args[0] {
+			case "on":
+				req := pemasak.MaintenanceRequest{RetryAfter: retryAfter}
+				if page != "" {
+					html, err := os.ReadFile(page)
+					if err != nil {
+						return err
+					}
+					req.Page = string(html)
+				}
+				if err := c.StartMaintenance(cmd.Context(), owner, project, req); err != nil {
+					return wrapAuth(err)
+				}
+			case "off":
+				if err := c.StopMaintenance(cmd.Context(), owner, project); err != nil {
+					return wrapAuth(err)
+				}
+			default:
+				return fmt.Errorf("invalid setting %q, expected on or off", args[0])
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&page, "page", "", "HTML file served instead of the default page")
+	cmd.Flags().IntVar(&retryAfter, "retry-after", 0, "seconds visitors are told to wait, 300 when not given")
+	return cmd
+}
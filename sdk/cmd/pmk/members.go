@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	pemasak "github.com/mustafasegf/pemasak-infra/sdk"
+)
+
+func newMembersCmd(opts *rootOptions) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "members",
+		Short: "Manage who has access to the apps of an owner",
+		Long: `Manage who has access to the apps of an owner.
+
+Every member of an owner has a role: viewers see the apps, their builds, logs
+and metrics, maintainers also create, deploy and configure them and open
+shells, owners also manage the members and delete apps. Share a group project
+by adding the others to its owner instead of sharing a password.`,
+	}
+
+	cmd.AddCommand(
+		&cobra.Command{
+			Use:   "list OWNER",
+			Short: "List the members with their roles",
+			Args:  cobra.ExactArgs(1),
+			RunE: func(cmd *cobra.Command, args []string) error {
+				c, err := opts.client()
+				if err != nil {
+					return err
+				}
+				members, err := c.ListMembers(cmd.Context(), args[0])
+				if err != nil {
+					return wrapAuth(err)
+				}
+				w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+				fmt.Fprintln(w, "USERNAME\tNAME\tROLE\tJOINED")
+				for _, m := range members {
+					fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", m.Username, m.Name, m.Role, m.CreatedAt.Local().Format(time.DateTime))
+				}
+				return w.Flush()
+			},
+		},
+		&cobra.Command{
+			Use:   "set OWNER USERNAME ROLE",
+			Short: "Add a member or change their role (viewer, maintainer or owner)",
+			Example: `  pmk members set kelompok-3 budi maintainer
+  pmk members set kelompok-3 asdos viewer`,
+			Args: cobra.ExactArgs(3),
+			RunE: func(cmd *cobra.Command, args []string) error {
+				role := pemasak.Role(args[2])
+				switch role {
+				case pemasak.RoleViewer, pemasak.RoleMaintainer, pemasak.RoleOwner:
+				default:
+					return fmt.Errorf("unknown role %q, expected viewer, maintainer or owner", args[2])
+				}
+				c, err := opts.client()
+				if err != nil {
+					return err
+				}
+				member, err := c.SetMember(cmd.Context(), args[0], args[1], role)
+				if err != nil {
+					return wrapAuth(err)
+				}
+				fmt.Fprintf(cmd.OutOrStdout(), "%s is a %s of %s\n", member.Username, member.Role, args[0])
+				return nil
+			},
+		},
+		&cobra.Command{
+			Use:   "remove OWNER USERNAME",
+			Short: "Take away the access of a member",
+			Args:  cobra.ExactArgs(2),
+			RunE: func(cmd *cobra.Command, args []string) error {
+				c, err := opts.client()
+				if err != nil {
+					return err
+				}
+				if err := c.RemoveMember(cmd.Context(), args[0], args[1]); err != nil {
+					return wrapAuth(err)
+				}
+				return nil
+			},
+		},
+	)
+	return cmd
+}
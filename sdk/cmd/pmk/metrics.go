@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+
+	pemasak "github.com/mustafasegf/pemasak-infra/sdk"
+)
+
+func newMetricsCmd(opts *rootOptions) *cobra.Command {
+	var rng string
+	cmd := &cobra.Command{
+		Use:   "metrics [owner/project]",
+		Short: "Show the resource usage of an app's containers",
+		Long: `Show the resource usage of an app's containers.
+
+Prints the latest point of every web and worker container, with the peak
+memory and whether the kernel killed it for running out of memory within
+--range (15m, 1h, 6h, 24h or 7d).`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			owner, project, err := opts.target(args)
+			if err != nil {
+				return err
+			}
+			c, err := opts.client()
+			if err != nil {
+				return err
+			}
+			metrics, err := c.Metrics(cmd.Context(), owner, project, pemasak.MetricsRange(rng))
+			if err != nil {
+				return wrapAuth(err)
+			}
+			w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "CONTAINER\tPROCESS\tCPU\tMEMORY\tPEAK\tLIMIT\tRX/S\tTX/S\tRESTARTS\tOOM KILLED")
+			for _, s := range metrics.Series {
+				if len(s.Points) == 0 {
+					continue
+				}
+				last := s.Points[len(s.Points)-1]
+				var peak int64
+				oom := false
+				for _, p := range s.Points {
+					peak = max(peak, p.MemoryBytes)
+					oom = oom || p.OOMKilled
+				}
+				fmt.Fprintf(w, "%s\t%s\t%.1f%%\t%s\t%s\t%s\t%s\t%s\t%d\t%t\n",
+					s.Container, s.Process, last.CPUPercent,
+					formatSize(last.MemoryBytes), formatSize(peak), formatSize(last.MemoryLimit),
+					formatSize(int64(last.NetworkRx)), formatSize(int64(last.NetworkTx)),
+					last.Restarts, oom)
+			}
+			return w.Flush()
+		},
+	}
+	cmd.Flags().StringVarP(&rng, "range", "r", string(pemasak.Range1h), "how far back to look: 15m, 1h, 6h, 24h or 7d")
+	return cmd
+}
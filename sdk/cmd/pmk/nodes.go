@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+func newAdminNodesCmd(opts *rootOptions) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "nodes",
+		Short: "Run apps on more hosts",
+		Long: `Run apps on more hosts than the one of the platform. Every node runs
+pemasak-agent with the token pmk admin nodes add gives out. New apps go to the
+least loaded node, the apps of an owner stay on one host.`,
+	}
+
+	list := &cobra.Command{
+		Use:   "list",
+		Short: "List the nodes and what they have left",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c, err := opts.client()
+			if err != nil {
+				return err
+			}
+			nodes, err := c.ListNodes(cmd.Context())
+			if err != nil {
+				return wrapAuth(err)
+			}
+			w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "NAME\tSTATUS\tRUNTIME\tARCH\tAPPS\tCONTAINERS\tLOAD\tMEMORY FREE\tLAST SEEN")
+			for _, n := range nodes {
+				seen := "never"
+				if n.LastSeenAt != nil {
+					seen = time.Since(*n.LastSeenAt).Round(time.Second).String() + " ago"
+				}
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%d\t%.2f/%d\t%d/%d MiB\t%s\n",
+					n.Name, n.Status, n.Runtime, n.Arch, n.Apps, n.Containers, n.Load, n.CPUs, n.MemoryAvailableMB, n.MemoryMB, seen)
+			}
+			return w.Flush()
+		},
+	}
+
+	add := &cobra.Command{
+		Use:   "add NAME",
+		Short: "Add a node and get the token of its agent",
+		Long: `Add a node and get the token its agent reports with. Keep it, it isn't shown
+again. The node gets apps once the agent first reports.`,
+		Example: `  pmk admin nodes add worker-1`,
+		Args:    cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c, err := opts.client()
+			if err != nil {
+				return err
+			}
+			node, err := c.AddNode(cmd.Context(), args[0])
+			if err != nil {
+				return wrapAuth(err)
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "Added %s, start its agent with AGENT_TOKEN=%s\n", node.Name, node.Token)
+			return nil
+		},
+	}
+
+	drain := &cobra.Command{
+		Use:   "drain NAME",
+		Short: "Move the apps off a node before it goes down",
+		Long: `Stop placing apps on a node and move its apps to the least loaded other node,
+or to the host of the platform. Moved apps are deployed again where they went,
+the node serves them until then. Owners with a database or volume on the node
+stay, move their data by hand. Apps only move to hosts of the architecture of
+the node. Draining ends with pmk admin nodes undrain.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c, err := opts.client()
+			if err != nil {
+				return err
+			}
+			drained, err := c.DrainNode(cmd.Context(), args[0])
+			if err != nil {
+				return wrapAuth(err)
+			}
+			for _, app := range drained.Moved {
+				to := app.Node
+				if to == "" {
+					to = "the platform host"
+				}
+				fmt.Fprintf(cmd.OutOrStdout(), "moved %s/%s to %s\n", app.Owner, app.Project, to)
+			}
+			for _, app := range drained.Staying {
+				fmt.Fprintf(cmd.OutOrStdout(), "%s stays, it has data on the node\n", app)
+			}
+			for _, app := range drained.Stranded {
+				fmt.Fprintf(cmd.OutOrStdout(), "%s stays, no other host has the architecture of the node\n", app)
+			}
+			return nil
+		},
+	}
+
+	undrain := &cobra.Command{
+		Use:   "undrain NAME",
+		Short: "Place new apps on a drained node again",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c, err := opts.client()
+			if err != nil {
+				return err
+			}
+			if err := c.ResumeNode(cmd.Context(), args[0]); err != nil {
+				return wrapAuth(err)
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "resumed %s\n", args[0])
+			return nil
+		},
+	}
+
+	remove := &cobra.Command{
+		Use:   "remove NAME",
+		Short: "Remove a node without apps, drain it first",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c, err := opts.client()
+			if err != nil {
+				return err
+			}
+			return wrapAuth(c.RemoveNode(cmd.Context(), args[0]))
+		},
+	}
+
+	cmd.AddCommand(list, add, drain, undrain, remove)
+	return cmd
+}
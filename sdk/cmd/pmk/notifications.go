@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+func newNotificationsCmd(opts *rootOptions) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "notifications",
+		Short: "Notify webhooks, Slack or Discord about builds and crashes",
+		Long: `Notify webhooks, Slack or Discord about builds and crashes.
+
+The kind of a hook picks what is sent:
+
+  webhook   the JSON payload, signed in the X-Pemasak-Signature-256 header
+  slack     a message to a Slack incoming webhook
+  discord   a message to a Discord webhook
+
+Events are build.started, build.succeeded, build.failed, container.crashed
+and container.crash_looping. Every hook also gets app.inactive and
+app.stopped when the platform is about to clean up the app for being unused.
+Use --app or PMK_APP to pick the app.`,
+	}
+
+	var events []string
+	add := &cobra.Command{
+		Use:   "add webhook|slack|discord URL",
+		Short: "Start notifying a hook",
+		Example: `  pmk notifications add slack https://hooks.slack.com/services/T000/B000/XXXX
+  pmk notifications add webhook https://ci.example.com/pemasak --events build.failed,container.crashed`,
+		Args: cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			owner, project, err := opts.target(nil)
+			if err != nil {
+				return err
+			}
+			c, err := opts.client()
+			if err != nil {
+				return err
+			}
+			hook, err := c.AddNotificationHook(cmd.Context(), owner, project, args[0], args[1], events)
+			if err != nil {
+				return wrapAuth(err)
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "added hook %s\n", hook.ID)
+			if hook.Secret != "" {
+				fmt.Fprintf(cmd.ErrOrStderr(), "payloads are signed with %s\n", hook.Secret)
+			}
+			return nil
+		},
+	}
+	add.Flags().StringSliceVar(&events, "events", nil, "events to notify about, every event when empty")
+
+	cmd.AddCommand(
+		&cobra.Command{
+			Use:   "list",
+			Short: "List the notification hooks",
+			Args:  cobra.NoArgs,
+			RunE: func(cmd *cobra.Command, args []string) error {
+				owner, project, err := opts.target(nil)
+				if err != nil {
+					return err
+				}
+				c, err := opts.client()
+				if err != nil {
+					return err
+				}
+				hooks, err := c.ListNotificationHooks(cmd.Context(), owner, project)
+				if err != nil {
+					return wrapAuth(err)
+				}
+				w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+				fmt.Fprintln(w, "ID\tKIND\tURL\tEVENTS\tCREATED")
+				for _, h := range hooks {
+					fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", h.ID, h.Kind, h.URL, strings.Join(h.Events, ","), h.CreatedAt.Local().Format(time.DateTime))
+				}
+				return w.Flush()
+			},
+		},
+		add,
+		&cobra.Command{
+			Use:   "remove ID",
+			Short: "Stop notifying a hook",
+			Args:  cobra.ExactArgs(1),
+			RunE: func(cmd *cobra.Command, args []string) error {
+				owner, project, err := opts.target(nil)
+				if err != nil {
+					return err
+				}
+				c, err := opts.client()
+				if err != nil {
+					return err
+				}
+				return wrapAuth(c.RemoveNotificationHook(cmd.Context(), owner, project, args[0]))
+			},
+		},
+	)
+	return cmd
+}
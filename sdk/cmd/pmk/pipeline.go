@@ -0,0 +1,214 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	pemasak "github.com/mustafasegf/pemasak-infra/sdk"
+)
+
+// printPromotion tells whether a promoted release runs on app or waits for an
+// approval.
+func printPromotion(cmd *cobra.Command, res *pemasak.PromotionResult, release, app string) {
+	if res.PromotionID != "" {
+		fmt.Fprintf(cmd.OutOrStdout(), "Promotion %s to %s waits for another member to approve it with pmk pipeline approve\n", res.PromotionID, app)
+		return
+	}
+	fmt.Fprintf(cmd.OutOrStdout(), "Promotion of release %s to %s queued\n", release, app)
+}
+
+func newPipelineCmd(opts *rootOptions) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "pipeline",
+		Short: "Chain apps into stages and promote releases along them",
+		Long: `Chain apps into stages and promote releases along them.
+
+Every app points at the stage after it, like dev to staging to production.
+Promoting a release runs the exact image it built on the next stage, which
+keeps its own variables and addons. A stage with approval only runs promoted
+releases once a member other than the one who promoted approves them.
+Without a subcommand the pipeline of the app and its last promotions are
+shown. Use --app or PMK_APP to pick the app.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			owner, project, err := opts.target(nil)
+			if err != nil {
+				return err
+			}
+			c, err := opts.client()
+			if err != nil {
+				return err
+			}
+			pipeline, err := c.GetPipeline(cmd.Context(), owner, project)
+			if err != nil {
+				return wrapAuth(err)
+			}
+
+			out := cmd.OutOrStdout()
+			stages := make([]string, 0, len(pipeline.Stages))
+			for _, s := range pipeline.Stages {
+				stage := s.App
+				if s.PromotionApproval {
+					stage += " (approval)"
+				}
+				stages = append(stages, stage)
+			}
+			fmt.Fprintf(out, "stages: %s\n", strings.Join(stages, " -> "))
+			next := "none, the last stage"
+			if pipeline.Next != "" {
+				next = pipeline.Next
+			}
+			fmt.Fprintf(out, "next: %s\n", next)
+			approval := "off, promoted releases run right away"
+			if pipeline.PromotionApproval {
+				approval = "on, promoted releases wait for another member"
+			}
+			fmt.Fprintf(out, "approval: %s\n", approval)
+
+			if len(pipeline.Promotions) == 0 {
+				return nil
+			}
+			fmt.Fprintln(out)
+			w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "ID\tRELEASE\tFROM\tTO\tBY\tSTATUS\tCREATED")
+			for _, p := range pipeline.Promotions {
+				status := p.Status
+				if p.DecidedBy != "" {
+					status = fmt.Sprintf("%s by %s", p.Status, p.DecidedBy)
+				}
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", p.ID, p.ReleaseID, p.Source, p.Target, p.RequestedBy, status, p.CreatedAt.Local().Format(time.DateTime))
+			}
+			return w.Flush()
+		},
+	}
+
+	var (
+		next     string
+		last     bool
+		approval bool
+	)
+	set := &cobra.Command{
+		Use:   "set",
+		Short: "Change the next stage of the app or its approval",
+		Long: `Change the stage after the app with --next, or make it the last one with
+--last, and whether releases promoted to it need an approval. Only owners of
+the app can change it, and they have to maintain the next stage too.`,
+		Example: `  pmk pipeline set --next acme/shop-staging
+  pmk pipeline set --app acme/shop-prod --approval
+  pmk pipeline set --last`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if next != "" && last {
+				return fmt.Errorf("--next and --last don't go together")
+			}
+			if next == "" && !last && !cmd.Flags().Changed("approval") {
+				return fmt.Errorf("nothing to change, give --next, --last or --approval")
+			}
+			owner, project, err := opts.target(nil)
+			if err != nil {
+				return err
+			}
+			c, err := opts.client()
+			if err != nil {
+				return err
+			}
+			pipeline, err := c.GetPipeline(cmd.Context(), owner, project)
+			if err != nil {
+				return wrapAuth(err)
+			}
+			switch {
+			case last:
+				pipeline.Next = ""
+			case next != "":
+				pipeline.Next = next
+			}
+			if cmd.Flags().Changed("approval") {
+				pipeline.PromotionApproval = approval
+			}
+			if err := c.SetPipeline(cmd.Context(), owner, project, pipeline.Next, pipeline.PromotionApproval); err != nil {
+				return wrapAuth(err)
+			}
+			return nil
+		},
+	}
+	set.Flags().StringVar(&next, "next", "", "owner/project of the stage after the app")
+	set.Flags().BoolVar(&last, "last", false, "make the app the last stage")
+	set.Flags().BoolVar(&approval, "approval", false, "releases promoted to the app wait for another member")
+
+	var release string
+	promote := &cobra.Command{
+		Use:   "promote",
+		Short: "Promote a release of the app to the next stage",
+		Long: `Promote a release of the app to the next stage, the live one without
+--release. The next stage runs the image without building it again, or keeps
+it waiting for an approval.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			owner, project, err := opts.target(nil)
+			if err != nil {
+				return err
+			}
+			c, err := opts.client()
+			if err != nil {
+				return err
+			}
+			res, err := c.PromoteNext(cmd.Context(), owner, project, release)
+			if err != nil {
+				return wrapAuth(err)
+			}
+			printPromotion(cmd, res, res.ReleaseID, res.Target)
+			return nil
+		},
+	}
+	promote.Flags().StringVar(&release, "release", "", "ID of the release, the live one by default")
+
+	// decide approves or rejects a promotion to the app
+	decide := func(approve bool) func(cmd *cobra.Command, args []string) error {
+		return func(cmd *cobra.Command, args []string) error {
+			owner, project, err := opts.target(nil)
+			if err != nil {
+				return err
+			}
+			c, err := opts.client()
+			if err != nil {
+				return err
+			}
+			if approve {
+				if err := c.ApprovePromotion(cmd.Context(), owner, project, args[0]); err != nil {
+					return wrapAuth(err)
+				}
+				fmt.Fprintf(cmd.OutOrStdout(), "Promotion %s approved, the release is queued\n", args[0])
+				return nil
+			}
+			if err := c.RejectPromotion(cmd.Context(), owner, project, args[0]); err != nil {
+				return wrapAuth(err)
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "Promotion %s rejected\n", args[0])
+			return nil
+		}
+	}
+
+	cmd.AddCommand(
+		set,
+		promote,
+		&cobra.Command{
+			Use:   "approve PROMOTION",
+			Short: "Run a release waiting to be promoted to the app",
+			Long: `Run a release waiting to be promoted to the app. The member who promoted it
+can't approve it, --app picks the stage it waits on.`,
+			Args: cobra.ExactArgs(1),
+			RunE: decide(true),
+		},
+		&cobra.Command{
+			Use:   "reject PROMOTION",
+			Short: "Drop a release waiting to be promoted to the app",
+			Args:  cobra.ExactArgs(1),
+			RunE:  decide(false),
+		},
+	)
+	return cmd
+}
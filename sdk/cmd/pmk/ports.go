@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+
+	pemasak "github.com/mustafasegf/pemasak-infra/sdk"
+)
+
+func newPortsCmd(opts *rootOptions) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "ports",
+		Short: "Lease tcp and udp ports to an app",
+		Long: `Lease tcp and udp ports to an app, for services that don't speak http like
+game servers, mqtt brokers or databases.
+
+Clients connect to the leased port on the host of the platform and every
+connection is forwarded to the target port of the container. A connection
+that sends nothing either way for the idle timeout is closed. Leased ports
+count against the port quota of your account, see pmk quotas. Use --app or
+PMK_APP to pick the app.`,
+	}
+
+	var port, idleTimeout int
+	add := &cobra.Command{
+		Use:   "add tcp|udp TARGET_PORT",
+		Short: "Lease a port forwarded to a port of the container",
+		Example: `  pmk ports add tcp 1883
+  pmk ports add udp 27015 --port 40015 --idle-timeout 60`,
+		Args: cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			target, err := strconv.Atoi(args[1])
+			if err != nil {
+				return fmt.Errorf("target port must be a number, got %q", args[1])
+			}
+			owner, project, err := opts.target(nil)
+			if err != nil {
+				return err
+			}
+			c, err := opts.client()
+			if err != nil {
+				return err
+			}
+			lease, err := c.LeasePort(cmd.Context(), owner, project, pemasak.PortLease{
+				Protocol:    args[0],
+				TargetPort:  target,
+				Port:        port,
+				IdleTimeout: idleTimeout,
+			})
+			if err != nil {
+				return wrapAuth(err)
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "leased %s:%d/%s to port %d, id %s\n",
+				lease.Host, lease.Port, lease.Protocol, lease.TargetPort, lease.ID)
+			return nil
+		},
+	}
+	add.Flags().IntVar(&port, "port", 0, "port of the platform to lease, the lowest free one when not given")
+	add.Flags().IntVar(&idleTimeout, "idle-timeout", 0, "seconds a connection may be quiet, the platform default when not given")
+
+	cmd.AddCommand(
+		&cobra.Command{
+			Use:   "list",
+			Short: "List the leased ports",
+			Args:  cobra.NoArgs,
+			RunE: func(cmd *cobra.Command, args []string) error {
+				owner, project, err := opts.target(nil)
+				if err != nil {
+					return err
+				}
+				c, err := opts.client()
+				if err != nil {
+					return err
+				}
+				ports, enabled, err := c.ListPorts(cmd.Context(), owner, project)
+				if err != nil {
+					return wrapAuth(err)
+				}
+				if !enabled {
+					fmt.Fprintln(cmd.ErrOrStderr(), "this platform doesn't lease ports")
+				}
+				w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+				fmt.Fprintln(w, "ID\tADDRESS\tPROTOCOL\tTARGET\tIDLE TIMEOUT")
+				for _, p := range ports {
+					fmt.Fprintf(w, "%s\t%s:%d\t%s\t%d\t%ds\n", p.ID, p.Host, p.Port, p.Protocol, p.TargetPort, p.IdleTimeout)
+				}
+				return w.Flush()
+			},
+		},
+		add,
+		&cobra.Command{
+			Use:   "remove ID",
+			Short: "Release a leased port, open connections run until they end",
+			Args:  cobra.ExactArgs(1),
+			RunE: func(cmd *cobra.Command, args []string) error {
+				owner, project, err := opts.target(nil)
+				if err != nil {
+					return err
+				}
+				c, err := opts.client()
+				if err != nil {
+					return err
+				}
+				return wrapAuth(c.ReleasePort(cmd.Context(), owner, project, args[0]))
+			},
+		},
+	)
+	return cmd
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	pemasak "github.com/mustafasegf/pemasak-infra/sdk"
+)
+
+func newPprofCmd(opts *rootOptions) *cobra.Command {
+	var (
+		seconds int
+		debug   int
+		gc      bool
+		output  string
+	)
+	cmd := &cobra.Command{
+		Use:   "pprof PROFILE [owner/project]",
+		Short: "Save a profile of a Go app that serves net/http/pprof",
+		Long: `Save a profile of a Go app that serves net/http/pprof.
+
+PROFILE is one of profile (CPU), trace, heap, allocs, goroutine, block, mutex
+or threadcreate. The app has to serve /debug/pprof/ on its port, the proxy
+never lets visitors reach it. Only maintainers of the app can fetch profiles.
+
+Profiles are saved to PROFILE.pprof for go tool pprof, traces to trace.out for
+go tool trace. With --debug they are text and printed instead.`,
+		Example: `  pmk pprof profile --seconds 20
+  go tool pprof -http :8000 profile.pprof
+  pmk pprof goroutine --debug 2 | less`,
+		Args: cobra.RangeArgs(1, 2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			owner, project, err := opts.target(args[1:])
+			if err != nil {
+				return err
+			}
+			c, err := opts.client()
+			if err != nil {
+				return err
+			}
+			profile, err := c.Profile(cmd.Context(), owner, project, args[0], pemasak.ProfileOptions{
+				Seconds: seconds,
+				Debug:   debug,
+				GC:      gc,
+			})
+			if err != nil {
+				return wrapAuth(err)
+			}
+			defer profile.Close()
+
+			if output == "" && debug == 0 {
+				output = args[0] + ".pprof"
+				if args[0] == "trace" {
+					output = "trace.out"
+				}
+			}
+			out := cmd.OutOrStdout()
+			if output != "" && output != "-" {
+				f, err := os.Create(output)
+				if err != nil {
+					return err
+				}
+				defer f.Close()
+				out = f
+			}
+			if _, err := io.Copy(out, profile); err != nil {
+				return err
+			}
+			if out != cmd.OutOrStdout() {
+				fmt.Fprintf(cmd.ErrOrStderr(), "Saved %s to %s\n", args[0], output)
+			}
+			return nil
+		},
+	}
+	cmd.Flags().IntVar(&seconds, "seconds", 30, "how long a CPU profile or trace records, up to 120")
+	cmd.Flags().IntVar(&debug, "debug", 0, "1 or 2 for a text profile, like goroutine stacks")
+	cmd.Flags().BoolVar(&gc, "gc", false, "run the garbage collector before a heap profile")
+	cmd.Flags().StringVarP(&output, "output", "o", "", "write the profile to this file, - for stdout")
+	return cmd
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	pemasak "github.com/mustafasegf/pemasak-infra/sdk"
+)
+
+func newPreviewsCmd(opts *rootOptions) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "previews",
+		Short: "Deploy pushes to other branches as previews of an app",
+		Long: `Deploy pushes to other branches as previews of an app.
+
+While previews are on, pushing a branch besides the one the app deploys
+from builds it and serves it at <branch>--<app> on the platform domain, so a
+pull request can be tried before it is merged. Pushing the branch again
+redeploys its preview, deleting the branch removes it, and a preview nobody
+pushed to for a while expires. Previews get the variables of the app and
+PREVIEW_BRANCH, but no addons, volumes or workers, so they can't touch live
+data. Give them a database of their own with pmk previews env. Without a
+subcommand the running previews are shown. Use --app or PMK_APP to pick the
+app.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			owner, project, err := opts.target(nil)
+			if err != nil {
+				return err
+			}
+			c, err := opts.client()
+			if err != nil {
+				return err
+			}
+			previews, err := c.GetPreviews(cmd.Context(), owner, project)
+			if err != nil {
+				return wrapAuth(err)
+			}
+
+			if !previews.Enabled {
+				fmt.Fprintln(cmd.ErrOrStderr(), "previews are off, turn them on with pmk previews on")
+			}
+			w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "BRANCH\tURL\tPUSHED\tEXPIRES")
+			for _, p := range previews.Previews {
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", p.Branch, p.URL, p.UpdatedAt.Local().Format(time.DateTime), p.ExpiresAt.Local().Format(time.DateTime))
+			}
+			return w.Flush()
+		},
+	}
+
+	// update changes the preview settings of the app the way change says
+	update := func(cmd *cobra.Command, change func(*pemasak.Previews)) error {
+		owner, project, err := opts.target(nil)
+		if err != nil {
+			return err
+		}
+		c, err := opts.client()
+		if err != nil {
+			return err
+		}
+		previews, err := c.GetPreviews(cmd.Context(), owner, project)
+		if err != nil {
+			return wrapAuth(err)
+		}
+		change(previews)
+		if err := c.SetPreviews(cmd.Context(), owner, project, previews.Enabled, previews.Env); err != nil {
+			return wrapAuth(err)
+		}
+		return nil
+	}
+
+	on := &cobra.Command{
+		Use:   "on",
+		Short: "Deploy previews of the branches pushed from now on",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return update(cmd, func(previews *pemasak.Previews) { previews.Enabled = true })
+		},
+	}
+
+	off := &cobra.Command{
+		Use:   "off",
+		Short: "Stop deploying previews and remove the running ones",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return update(cmd, func(previews *pemasak.Previews) { previews.Enabled = false })
+		},
+	}
+
+	env := &cobra.Command{
+		Use:   "env",
+		Short: "Show the variables previews get on top of the ones of the app",
+		Long: `Show the variables previews get on top of the ones of the app. They replace
+variables and secrets of the app with the same name, and are used from the
+next push of a branch.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			owner, project, err := opts.target(nil)
+			if err != nil {
+				return err
+			}
+			c, err := opts.client()
+			if err != nil {
+				return err
+			}
+			previews, err := c.GetPreviews(cmd.Context(), owner, project)
+			if err != nil {
+				return wrapAuth(err)
+			}
+			keys := make([]string, 0, len(previews.Env))
+			for key := range previews.Env {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+			for _, key := range keys {
+				fmt.Fprintf(cmd.OutOrStdout(), "%s=%s\n", key, previews.Env[key])
+			}
+			return nil
+		},
+	}
+	env.AddCommand(
+		&cobra.Command{
+			Use:     "set KEY=VALUE...",
+			Short:   "Set variables of previews",
+			Example: `  pmk previews env set DATABASE_URL=postgres://preview@db.example.com/app_preview`,
+			Args:    cobra.MinimumNArgs(1),
+			RunE: func(cmd *cobra.Command, args []string) error {
+				vars := make(map[string]string, len(args))
+				for _, arg := range args {
+					key, value, ok := strings.Cut(arg, "=")
+					if !ok || key == "" {
+						return fmt.Errorf("%q is not KEY=VALUE", arg)
+					}
+					vars[key] = value
+				}
+				return update(cmd, func(previews *pemasak.Previews) {
+					if previews.Env == nil {
+						previews.Env = map[string]string{}
+					}
+					for key, value := range vars {
+						previews.Env[key] = value
+					}
+				})
+			},
+		},
+		&cobra.Command{
+			Use:   "unset KEY...",
+			Short: "Remove variables of previews",
+			Args:  cobra.MinimumNArgs(1),
+			RunE: func(cmd *cobra.Command, args []string) error {
+				return update(cmd, func(previews *pemasak.Previews) {
+					for _, key := range args {
+						delete(previews.Env, key)
+					}
+				})
+			},
+		},
+	)
+
+	remove := &cobra.Command{
+		Use:   "delete BRANCH",
+		Short: "Remove the preview of a branch before it expires",
+		Long: `Remove the preview of a branch before it expires. Pushing the branch again
+deploys it again.`,
+		Example: `  pmk previews delete feature/login`,
+		Args:    cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			owner, project, err := opts.target(nil)
+			if err != nil {
+				return err
+			}
+			c, err := opts.client()
+			if err != nil {
+				return err
+			}
+			previews, err := c.GetPreviews(cmd.Context(), owner, project)
+			if err != nil {
+				return wrapAuth(err)
+			}
+			for _, p := range previews.Previews {
+				if p.Branch == args[0] || p.Name == args[0] {
+					if err := c.DeletePreview(cmd.Context(), owner, project, p.Name); err != nil {
+						return wrapAuth(err)
+					}
+					return nil
+				}
+			}
+			return fmt.Errorf("%s has no preview of %s", project, args[0])
+		},
+	}
+
+	cmd.AddCommand(on, off, env, remove)
+	return cmd
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+)
+
+func newPsCmd(opts *rootOptions) *cobra.Command {
+	return &cobra.Command{
+		Use:   "ps [owner/project]",
+		Short: "List the processes of an app",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			owner, project, err := opts.target(args)
+			if err != nil {
+				return err
+			}
+			c, err := opts.client()
+			if err != nil {
+				return err
+			}
+			processes, err := c.ListProcesses(cmd.Context(), owner, project)
+			if err != nil {
+				return wrapAuth(err)
+			}
+			w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "PROCESS\tRUNNING\tCOMMAND")
+			for _, p := range processes {
+				fmt.Fprintf(w, "%s\t%d/%d\t%s\n", p.Name, p.Running, p.Count, p.Command)
+			}
+			return w.Flush()
+		},
+	}
+}
+
+func newScaleCmd(opts *rootOptions) *cobra.Command {
+	return &cobra.Command{
+		Use:   "scale PROCESS=COUNT...",
+		Short: "Set how many containers a process runs",
+		Long: `Set how many containers a process runs.
+
+web is spread over its containers by the proxy, which only sends requests to
+containers that pass their health probe. Worker processes are the Procfile
+entries besides web and release. Use --app or PMK_APP to pick the app.`,
+		Args: cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			owner, project, err := opts.target(nil)
+			if err != nil {
+				return err
+			}
+			counts := make([]int, len(args))
+			names := make([]string, len(args))
+			for i, arg := range args {
+				name, count, ok := strings.Cut(arg, "=")
+				n, err := strconv.Atoi(count)
+				if !ok || name == "" || err != nil || n < 0 {
+					return fmt.Errorf("expected PROCESS=COUNT, got %q", arg)
+				}
+				names[i], counts[i] = name, n
+			}
+			c, err := opts.client()
+			if err != nil {
+				return err
+			}
+			for i := range names {
+				if err := c.Scale(cmd.Context(), owner, project, names[i], counts[i]); err != nil {
+					return wrapAuth(err)
+				}
+			}
+			return nil
+		},
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	pemasak "github.com/mustafasegf/pemasak-infra/sdk"
+)
+
+func newProtocolCmd(opts *rootOptions) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "protocol [http1|h2c]",
+		Short: "Choose how the platform talks to an app",
+		Long: `Choose how the platform talks to an app.
+
+http1, the default, forwards requests over HTTP/1.1. h2c forwards them over
+HTTP/2 without TLS, which gRPC servers need: requests and responses stream
+both ways and trailers like grpc-status reach the client. Readiness and
+replica probes use the same protocol, so an h2c app must answer HTTP/2 on
+$PORT. Websockets only work with http1. Without arguments the current
+protocol is shown. Use --app or PMK_APP to pick the app.`,
+		Example: `  pmk protocol h2c
+  pmk protocol http1`,
+		Args:      cobra.MaximumNArgs(1),
+		ValidArgs: []string{pemasak.ProtocolHTTP1, pemasak.ProtocolH2C},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			owner, project, err := opts.target(nil)
+			if err != nil {
+				return err
+			}
+			c, err := opts.client()
+			if err != nil {
+				return err
+			}
+			settings, err := c.GetSettings(cmd.Context(), owner, project)
+			if err != nil {
+				return wrapAuth(err)
+			}
+
+			if len(args) == 0 {
+				fmt.Fprintln(cmd.OutOrStdout(), settings.Protocol)
+				return nil
+			}
+
+			switch args[0] {
+			case pemasak.ProtocolHTTP1, pemasak.ProtocolH2C:
+			default:
+				return fmt.Errorf("invalid protocol %q, expected http1 or h2c", args[0])
+			}
+			settings.Protocol = args[0]
+			if err := c.UpdateSettings(cmd.Context(), owner, project, *settings); err != nil {
+				return wrapAuth(err)
+			}
+			return nil
+		},
+	}
+	return cmd
+}
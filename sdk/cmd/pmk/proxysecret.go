@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+func newProxySecretCmd(opts *rootOptions) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "proxy-secret",
+		Short: "Manage the key the proxy signs requests to an app with",
+		Long: `Manage the key the proxy signs requests to an app with.
+
+Every request the platform proxy forwards carries X-Pemasak-Proxy-Timestamp
+and X-Pemasak-Proxy-Signature, an HMAC with a key only the proxy and the
+app know. The app gets the key in PEMASAK_PROXY_SECRET and checks the
+signature, like with pemasak.ProxyMiddleware of the Go SDK, to refuse
+requests that reached its containers some other way.
+
+Use --app or PMK_APP to pick the app.`,
+	}
+
+	rotate := &cobra.Command{
+		Use:   "rotate",
+		Short: "Give the app a new key and release it again with it",
+		Long: `Give the app a new key and release it again with it.
+
+The proxy signs with both the new and the previous key for an hour, so
+requests keep being accepted while the new release goes live.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			owner, project, err := opts.target(nil)
+			if err != nil {
+				return err
+			}
+			c, err := opts.client()
+			if err != nil {
+				return err
+			}
+			rotation, err := c.RotateProxySecret(cmd.Context(), owner, project)
+			if err != nil {
+				return wrapAuth(err)
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "rotated the proxy secret of %s/%s, the previous one signs until %s\n",
+				owner, project, rotation.PreviousValidUntil.Local().Format(time.DateTime))
+			return nil
+		},
+	}
+
+	cmd.AddCommand(rotate)
+	return cmd
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	pemasak "github.com/mustafasegf/pemasak-infra/sdk"
+)
+
+func newPushPolicyCmd(opts *rootOptions) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "push-policy",
+		Short: "Check pushes to an app before git takes them",
+		Long: `Check pushes to an app before git takes them.
+
+A push can be limited in size, to the default branch and branches matching
+patterns like feature/*, and scanned for lines that look like credentials,
+like private keys or cloud access keys. A push that fails is rejected whole
+and git push shows why. Without a subcommand the policy is shown. Use --app
+or PMK_APP to pick the app.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			owner, project, err := opts.target(nil)
+			if err != nil {
+				return err
+			}
+			c, err := opts.client()
+			if err != nil {
+				return err
+			}
+			policy, err := c.GetPushPolicy(cmd.Context(), owner, project)
+			if err != nil {
+				return wrapAuth(err)
+			}
+
+			out := cmd.OutOrStdout()
+			branches := "any"
+			if len(policy.Branches) > 0 {
+				branches = "the default one, " + strings.Join(policy.Branches, ", ")
+			}
+			fmt.Fprintf(out, "branches: %s\n", branches)
+			switch {
+			case policy.MaxSize > 0 && policy.PlatformMaxSize > 0 && policy.PlatformMaxSize < policy.MaxSize:
+				fmt.Fprintf(out, "max size: %d MiB, the platform allows %d MiB\n", policy.MaxSize, policy.PlatformMaxSize)
+			case policy.MaxSize > 0:
+				fmt.Fprintf(out, "max size: %d MiB\n", policy.MaxSize)
+			case policy.PlatformMaxSize > 0:
+				fmt.Fprintf(out, "max size: %d MiB, of the platform\n", policy.PlatformMaxSize)
+			default:
+				fmt.Fprintln(out, "max size: none")
+			}
+			switch {
+			case policy.PlatformScanSecrets:
+				fmt.Fprintln(out, "secret scan: on, for every app")
+			case policy.ScanSecrets:
+				fmt.Fprintln(out, "secret scan: on")
+			default:
+				fmt.Fprintln(out, "secret scan: off")
+			}
+			return nil
+		},
+	}
+
+	// update changes the policy of the app the way change says
+	update := func(cmd *cobra.Command, change func(*pemasak.PushPolicy)) error {
+		owner, project, err := opts.target(nil)
+		if err != nil {
+			return err
+		}
+		c, err := opts.client()
+		if err != nil {
+			return err
+		}
+		policy, err := c.GetPushPolicy(cmd.Context(), owner, project)
+		if err != nil {
+			return wrapAuth(err)
+		}
+		change(policy)
+		if err := c.SetPushPolicy(cmd.Context(), owner, project, *policy); err != nil {
+			return wrapAuth(err)
+		}
+		return nil
+	}
+
+	var f struct {
+		branches    []string
+		maxSize     int
+		scanSecrets bool
+	}
+	set := &cobra.Command{
+		Use:   "set",
+		Short: "Change the policy of the app",
+		Long: `Change the policy of the app. Only the flags that are given change, --branches
+with nothing allows any branch again and --max-size 0 leaves the size to the
+platform.`,
+		Example: `  pmk push-policy set --branches 'feature/*,release/*'
+  pmk push-policy set --max-size 50 --scan-secrets`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			flags := cmd.Flags()
+			if !flags.Changed("branches") && !flags.Changed("max-size") && !flags.Changed("scan-secrets") {
+				return fmt.Errorf("nothing to change, give --branches, --max-size or --scan-secrets")
+			}
+			if f.maxSize < 0 {
+				return fmt.Errorf("--max-size must be positive")
+			}
+			return update(cmd, func(policy *pemasak.PushPolicy) {
+				if flags.Changed("branches") {
+					policy.Branches = f.branches
+				}
+				if flags.Changed("max-size") {
+					policy.MaxSize = f.maxSize
+				}
+				if flags.Changed("scan-secrets") {
+					policy.ScanSecrets = f.scanSecrets
+				}
+			})
+		},
+	}
+	set.Flags().StringSliceVar(&f.branches, "branches", nil, "branches besides the default one that may be pushed, like feature/*")
+	set.Flags().IntVar(&f.maxSize, "max-size", 0, "biggest push in MiB")
+	set.Flags().BoolVar(&f.scanSecrets, "scan-secrets", false, "reject pushes adding lines that look like credentials")
+
+	reset := &cobra.Command{
+		Use:   "clear",
+		Short: "Let every push through again",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return update(cmd, func(policy *pemasak.PushPolicy) {
+				*policy = pemasak.PushPolicy{}
+			})
+		},
+	}
+
+	cmd.AddCommand(set, reset)
+	return cmd
+}
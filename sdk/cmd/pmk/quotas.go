@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+
+	pemasak "github.com/mustafasegf/pemasak-infra/sdk"
+)
+
+func newQuotasCmd(opts *rootOptions) *cobra.Command {
+	return &cobra.Command{
+		Use:   "quotas",
+		Short: "Show how many apps, builds and storage your account may have",
+		Long: `Show the quotas of your account next to what it uses. An app counts against
+every user who owns its owner. An account at its quota can't create apps,
+volumes or databases or lease ports, and one over its storage quota doesn't
+deploy until it frees space. Ask the platform admins when you need more.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c, err := opts.client()
+			if err != nil {
+				return err
+			}
+			quotas, err := c.Quotas(cmd.Context())
+			if err != nil {
+				return wrapAuth(err)
+			}
+			return printQuotas(cmd, quotas)
+		},
+	}
+}
+
+func printQuotas(cmd *cobra.Command, q *pemasak.AccountQuotas) error {
+	w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "QUOTA\tUSED\tOF")
+	fmt.Fprintf(w, "apps\t%d\t%d\n", q.Usage.Apps, q.Quotas.Apps)
+	fmt.Fprintf(w, "builds\t%d\t%d\n", q.Usage.Builds, q.Quotas.Builds)
+	fmt.Fprintf(w, "storage\t%d MiB (%d images, %d volumes)\t%d MiB\n",
+		q.Usage.StorageMB, q.Usage.ImagesMB, q.Usage.VolumesMB, q.Quotas.StorageMB)
+	fmt.Fprintf(w, "database\t%d MiB\t%d MiB\n", q.Usage.DatabaseMB, q.Quotas.DatabaseMB)
+	fmt.Fprintf(w, "ports\t%d\t%d\n", q.Usage.Ports, q.Quotas.Ports)
+	return w.Flush()
+}
+
+func newAdminQuotasCmd(opts *rootOptions) *cobra.Command {
+	var apps, builds, storage, database, ports string
+	cmd := &cobra.Command{
+		Use:   "quotas <username>",
+		Short: "Show or set the quotas of a user",
+		Long: `Show or set how many apps, builds and leased ports a user may have and how
+much storage and database their account may use, in MiB. Give "default" to
+clear a quota. A quota lower than what the account has takes nothing away, it
+only stops it from growing.`,
+		Example: `  pmk admin quotas budi --apps 20 --storage 20480
+  pmk admin quotas budi --builds default`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c, err := opts.client()
+			if err != nil {
+				return err
+			}
+			quotas, err := c.UserQuotas(cmd.Context(), args[0])
+			if err != nil {
+				return wrapAuth(err)
+			}
+
+			overrides := quotas.Overrides
+			changed := false
+			for _, f := range []struct {
+				name  string
+				value string
+				field **int
+			}{
+				{"apps", apps, &overrides.Apps},
+				{"builds", builds, &overrides.Builds},
+				{"storage", storage, &overrides.StorageMB},
+				{"database", database, &overrides.DatabaseMB},
+				{"ports", ports, &overrides.Ports},
+			} {
+				if !cmd.Flags().Changed(f.name) {
+					continue
+				}
+				v, err := parseLimit(f.value, f.name, strconv.Atoi)
+				if err != nil {
+					return err
+				}
+				*f.field = v
+				changed = true
+			}
+			if changed {
+				if quotas, err = c.SetUserQuotas(cmd.Context(), args[0], overrides); err != nil {
+					return wrapAuth(err)
+				}
+			}
+			return printQuotas(cmd, quotas)
+		},
+	}
+	cmd.Flags().StringVar(&apps, "apps", "", `apps of the account, or "default"`)
+	cmd.Flags().StringVar(&builds, "builds", "", `builds running at once, or "default"`)
+	cmd.Flags().StringVar(&storage, "storage", "", `images and volumes in MiB, or "default"`)
+	cmd.Flags().StringVar(&database, "database", "", `data of postgres addons in MiB, or "default"`)
+	cmd.Flags().StringVar(&ports, "ports", "", `tcp and udp ports leased to the apps, or "default"`)
+	return cmd
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/spf13/cobra"
+)
+
+func newRateLimitCmd(opts *rootOptions) *cobra.Command {
+	var perIP, burst int
+	cmd := &cobra.Command{
+		Use:   "ratelimit [RPS|off]",
+		Short: "Limit the requests per second an app gets",
+		Long: `Limit the requests per second an app gets.
+
+The platform lets RPS requests per second through to the app, and with
+--per-ip that many from one client IP. Requests over the limit get 429 Too
+Many Requests with a Retry-After header, without reaching the app. --burst
+lets that many requests more through at once after a quiet while. The
+platform has limits of its own, an app can only set lower ones. off, and 0
+for the flags, go back to the limits of the platform. Without arguments or
+flags the current limits are shown. Use --app or PMK_APP to pick the app.`,
+		Example: `  pmk ratelimit 50
+  pmk ratelimit 50 --per-ip 5 --burst 20
+  pmk ratelimit off --per-ip 0`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			owner, project, err := opts.target(nil)
+			if err != nil {
+				return err
+			}
+			c, err := opts.client()
+			if err != nil {
+				return err
+			}
+			settings, err := c.GetSettings(cmd.Context(), owner, project)
+			if err != nil {
+				return wrapAuth(err)
+			}
+
+			flags := cmd.Flags()
+			if len(args) == 0 && !flags.Changed("per-ip") && !flags.Changed("burst") {
+				limit := func(rps int) string {
+					if rps == 0 {
+						return "platform limit"
+					}
+					return fmt.Sprintf("%d/s", rps)
+				}
+				burstText := "platform burst"
+				if settings.RateBurst != nil {
+					burstText = strconv.Itoa(*settings.RateBurst)
+				}
+				fmt.Fprintf(cmd.OutOrStdout(), "app: %s, per ip: %s, burst: %s\n",
+					limit(settings.RateLimit), limit(settings.IPRateLimit), burstText)
+				return nil
+			}
+
+			if len(args) == 1 {
+				settings.RateLimit = 0
+				if args[0] != "off" {
+					rps, err := strconv.Atoi(args[0])
+					if err != nil || rps <= 0 {
+						return fmt.Errorf("invalid limit %q, expected requests per second or off", args[0])
+					}
+					settings.RateLimit = rps
+				}
+			}
+			if flags.Changed("per-ip") {
+				if perIP < 0 {
+					return fmt.Errorf("--per-ip must be positive")
+				}
+				settings.IPRateLimit = perIP
+			}
+			if flags.Changed("burst") {
+				if burst < 0 {
+					return fmt.Errorf("--burst must be positive")
+				}
+				settings.RateBurst = &burst
+			}
+			if err := c.UpdateSettings(cmd.Context(), owner, project, *settings); err != nil {
+				return wrapAuth(err)
+			}
+			return nil
+		},
+	}
+	cmd.Flags().IntVar(&perIP, "per-ip", 0, "requests per second from one client ip, 0 keeps the platform limit")
+	cmd.Flags().IntVar(&burst, "burst", 0, "requests over the rate let through at once")
+	return cmd
+}
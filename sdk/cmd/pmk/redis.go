@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+func newRedisCmd(opts *rootOptions) *cobra.Command {
+	return &cobra.Command{
+		Use:   "redis",
+		Short: "Show the memory, load and keys of the redis addon of an app",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			owner, project, err := opts.target(nil)
+			if err != nil {
+				return err
+			}
+			c, err := opts.client()
+			if err != nil {
+				return err
+			}
+			redis, err := c.GetRedis(cmd.Context(), owner, project)
+			if err != nil {
+				return wrapAuth(err)
+			}
+
+			out := cmd.OutOrStdout()
+			fmt.Fprintf(out, "name: %s\n", redis.Name)
+			if redis.Stats == nil {
+				fmt.Fprintf(out, "memory: unknown of %d MiB, the server isn't answering\n", redis.Memory)
+			} else {
+				stats := redis.Stats
+				fmt.Fprintf(out, "memory: %.1f of %d MiB\n", float64(stats.UsedMemory)/(1<<20), redis.Memory)
+				fmt.Fprintf(out, "ops/sec: %d\n", stats.OpsPerSec)
+				fmt.Fprintf(out, "keys: %d, %d evicted\n", stats.Keys, stats.EvictedKeys)
+				fmt.Fprintf(out, "clients: %d\n", stats.ConnectedClients)
+				fmt.Fprintf(out, "uptime: %s\n", time.Duration(stats.UptimeSeconds)*time.Second)
+			}
+			fmt.Fprintf(out, "created: %s\n", redis.CreatedAt.Local().Format(time.DateTime))
+			return nil
+		},
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+)
+
+func newRegistriesCmd(opts *rootOptions) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "registries",
+		Short: "Manage logins for pulling images from private registries",
+		Long: `Manage logins for pulling images from private registries.
+
+Images deployed with pmk deploy --image are pulled with the login for their
+registry, the host at the start of the image like ghcr.io, or docker.io for
+Docker Hub. Images without a login are pulled anonymously. Use --app or
+PMK_APP to pick the app.`,
+	}
+
+	var username string
+	set := &cobra.Command{
+		Use:   "set REGISTRY",
+		Short: "Set the login for a registry",
+		Long: `Set the login for a registry, replacing an earlier one.
+
+The password, usually an access token, is read from PMK_REGISTRY_PASSWORD or
+prompted for.`,
+		Example: `  pmk registries set ghcr.io --username owner
+  PMK_REGISTRY_PASSWORD=$TOKEN pmk registries set registry.gitlab.com --username deploy`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			owner, project, err := opts.target(nil)
+			if err != nil {
+				return err
+			}
+			c, err := opts.client()
+			if err != nil {
+				return err
+			}
+
+			password := os.Getenv("PMK_REGISTRY_PASSWORD")
+			if password == "" {
+				fmt.Fprint(cmd.ErrOrStderr(), "Password: ")
+				b, err := term.ReadPassword(int(os.Stdin.Fd()))
+				fmt.Fprintln(cmd.ErrOrStderr())
+				if err != nil {
+					return err
+				}
+				password = string(b)
+			}
+
+			if err := c.SetRegistryCredential(cmd.Context(), owner, project, args[0], username, password); err != nil {
+				return wrapAuth(err)
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "set login for %s\n", args[0])
+			return nil
+		},
+	}
+	set.Flags().StringVarP(&username, "username", "u", "", "username on the registry")
+	set.MarkFlagRequired("username")
+
+	cmd.AddCommand(
+		&cobra.Command{
+			Use:   "list",
+			Short: "List the registry logins",
+			Args:  cobra.NoArgs,
+			RunE: func(cmd *cobra.Command, args []string) error {
+				owner, project, err := opts.target(nil)
+				if err != nil {
+					return err
+				}
+				c, err := opts.client()
+				if err != nil {
+					return err
+				}
+				credentials, err := c.ListRegistryCredentials(cmd.Context(), owner, project)
+				if err != nil {
+					return wrapAuth(err)
+				}
+				w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+				fmt.Fprintln(w, "REGISTRY\tUSERNAME\tCREATED")
+				for _, r := range credentials {
+					fmt.Fprintf(w, "%s\t%s\t%s\n", r.Registry, r.Username, r.CreatedAt.Local().Format(time.DateTime))
+				}
+				return w.Flush()
+			},
+		},
+		set,
+		&cobra.Command{
+			Use:   "remove REGISTRY",
+			Short: "Remove the login for a registry",
+			Args:  cobra.ExactArgs(1),
+			RunE: func(cmd *cobra.Command, args []string) error {
+				owner, project, err := opts.target(nil)
+				if err != nil {
+					return err
+				}
+				c, err := opts.client()
+				if err != nil {
+					return err
+				}
+				return wrapAuth(c.RemoveRegistryCredential(cmd.Context(), owner, project, args[0]))
+			},
+		},
+	)
+	return cmd
+}
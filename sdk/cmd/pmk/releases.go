@@ -0,0 +1,472 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	pemasak "github.com/mustafasegf/pemasak-infra/sdk"
+)
+
+// lastExit tells out of memory kills from signals and plain exit codes.
+func lastExit(r pemasak.Release) string {
+	switch {
+	case r.ExitCode == nil:
+		return "-"
+	case r.OOMKilled:
+		return fmt.Sprintf("%d out of memory", *r.ExitCode)
+	case r.ExitSignal != "":
+		return fmt.Sprintf("%d %s", *r.ExitCode, r.ExitSignal)
+	default:
+		return strconv.Itoa(*r.ExitCode)
+	}
+}
+
+// releaseState is LIVE, PINNED or both, empty for the releases before them.
+func releaseState(r pemasak.Release) string {
+	var state []string
+	if r.Live {
+		state = append(state, "live")
+	}
+	if r.Pinned {
+		state = append(state, "pinned")
+	}
+	if len(state) == 0 {
+		return "-"
+	}
+	return strings.Join(state, ",")
+}
+
+func shortCommit(sha string) string {
+	if sha == "" {
+		return "-"
+	}
+	return sha[:min(len(sha), 7)]
+}
+
+// shortDigest keeps 12 hex digits of a config digest, like docker does for
+// image ids.
+func shortDigest(digest string) string {
+	digest = strings.TrimPrefix(digest, "sha256:")
+	return digest[:min(len(digest), 12)]
+}
+
+func newReleasesCmd(opts *rootOptions) *cobra.Command {
+	var page pemasak.ListOptions
+	cmd := &cobra.Command{
+		Use:   "releases [owner/project]",
+		Short: "List the releases of an app that can be rolled back to",
+		Long: `List the releases of an app that can be rolled back to.
+
+LIVE marks the release serving requests now and PINNED the one the app is
+pinned to with pmk releases pin. DIGEST is the start of the sha256 of the
+config of the release, two releases with the same digest run the same way.
+
+LAST EXIT is how a container of the release last exited on its own: out of
+memory when it hit its memory limit, the signal that killed it like SIGSEGV,
+or its exit code. A panic usually exits with 101.`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			owner, project, err := opts.target(args)
+			if err != nil {
+				return err
+			}
+			c, err := opts.client()
+			if err != nil {
+				return err
+			}
+			releases, err := c.ListReleasesPage(cmd.Context(), owner, project, page)
+			if err != nil {
+				return wrapAuth(err)
+			}
+			w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "ID\tBUILD\tCOMMIT\tDIGEST\tCREATED\tSTATE\tLAST EXIT\tDESCRIPTION")
+			for _, r := range releases.Data {
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", r.ID, r.BuildID, shortCommit(r.CommitSHA),
+					shortDigest(r.ConfigDigest), r.CreatedAt.Local().Format(time.DateTime), releaseState(r), lastExit(r), r.Description)
+			}
+			if err := w.Flush(); err != nil {
+				return err
+			}
+			if releases.NextCursor != "" {
+				fmt.Fprintf(cmd.ErrOrStderr(), "Older releases with --cursor %s\n", releases.NextCursor)
+			}
+			return nil
+		},
+	}
+	cmd.Flags().IntVar(&page.Limit, "limit", 50, "list at most this many releases, up to 200")
+	cmd.Flags().StringVar(&page.Cursor, "cursor", "", "list the releases older than the last page")
+
+	var canary, to, release string
+	promote := &cobra.Command{
+		Use:   "promote [owner/project]",
+		Short: "Shift requests to the canary, make it live, or promote a release to another app",
+		Long: `Shift requests to the canary, or make it the live release.
+
+With --canary the canary gets that share of the requests. Without it the
+canary becomes the live release: nothing is rebuilt, it takes over every
+request and the workers are restarted on its release.
+
+With --to the live release, or the one given with --release, is deployed to
+another app, like staging to production. The image is not rebuilt, it starts
+with the environment, secrets and services of the app it is promoted to. You
+need to be a maintainer of both apps.`,
+		Example: `  pmk releases promote --canary 50% owner/myapp
+  pmk releases promote owner/myapp
+  pmk releases promote --to owner/myapp owner/myapp-staging`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			owner, project, err := opts.target(args)
+			if err != nil {
+				return err
+			}
+			c, err := opts.client()
+			if err != nil {
+				return err
+			}
+			if to != "" {
+				if canary != "" {
+					return errors.New("--canary and --to can't be used together")
+				}
+				if release == "" {
+					if release, err = liveRelease(cmd, c, owner, project); err != nil {
+						return err
+					}
+				}
+				res, err := c.PromoteRelease(cmd.Context(), owner, project, release, to)
+				if err != nil {
+					return wrapAuth(err)
+				}
+				printPromotion(cmd, res, release, to)
+				return nil
+			}
+			if release != "" {
+				return errors.New("--release needs --to, use pmk rollback --to to restore a release of this app")
+			}
+			if canary != "" {
+				weight, err := parsePercent(canary)
+				if err != nil {
+					return err
+				}
+				if err := c.SetCanaryWeight(cmd.Context(), owner, project, weight); err != nil {
+					return wrapAuth(err)
+				}
+				fmt.Fprintf(cmd.OutOrStdout(), "canary of %s/%s on %d%% of requests\n", owner, project, weight)
+				return nil
+			}
+			if err := c.PromoteCanary(cmd.Context(), owner, project); err != nil {
+				return wrapAuth(err)
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "Promotion of the canary queued for %s/%s\n", owner, project)
+			return nil
+		},
+	}
+	promote.Flags().StringVar(&canary, "canary", "", "share of requests for the canary, like 25%")
+	promote.Flags().StringVar(&to, "to", "", "app to deploy the release to, like owner/myapp")
+	promote.Flags().StringVar(&release, "release", "", "release id to promote with --to, the live release by default")
+
+	cmd.AddCommand(
+		&cobra.Command{
+			Use:   "canary [owner/project]",
+			Short: "Compare the canary with the live release",
+			Args:  cobra.MaximumNArgs(1),
+			RunE: func(cmd *cobra.Command, args []string) error {
+				owner, project, err := opts.target(args)
+				if err != nil {
+					return err
+				}
+				c, err := opts.client()
+				if err != nil {
+					return err
+				}
+				canary, err := c.GetCanary(cmd.Context(), owner, project)
+				if err != nil {
+					return wrapAuth(err)
+				}
+				if canary == nil {
+					return errors.New("no canary running, start one with pmk deploy --canary")
+				}
+				fmt.Fprintf(cmd.OutOrStdout(), "build %s on %d%% of requests since %s\n\n",
+					canary.BuildID, canary.Weight, canary.CreatedAt.Local().Format(time.DateTime))
+				w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+				fmt.Fprintln(w, "RELEASE\tREQUESTS\tERRORS\tP95")
+				for _, r := range []struct {
+					name  string
+					stats pemasak.ReleaseStats
+				}{{"stable", canary.Stable}, {"canary", canary.Canary}} {
+					p95 := "-"
+					if r.stats.P95 != nil {
+						p95 = fmt.Sprintf("%.0fms", *r.stats.P95)
+					}
+					fmt.Fprintf(w, "%s\t%d\t%.1f%%\t%s\n", r.name, r.stats.Requests, r.stats.ErrorRate()*100, p95)
+				}
+				return w.Flush()
+			},
+		},
+		promote,
+		&cobra.Command{
+			Use:   "diff FROM TO [owner/project]",
+			Short: "Show what changed from one release to another",
+			Long: `Show what changed from one release to another: the image and commit, the
+environment, which secrets changed and the rest of the config like the
+command, the limits or the volumes. Values of secrets are never shown.`,
+			Example: `  pmk releases diff 2c1f... 9a7e... owner/myapp`,
+			Args:    cobra.RangeArgs(2, 3),
+			RunE: func(cmd *cobra.Command, args []string) error {
+				owner, project, err := opts.target(args[2:])
+				if err != nil {
+					return err
+				}
+				c, err := opts.client()
+				if err != nil {
+					return err
+				}
+				diff, err := c.DiffReleases(cmd.Context(), owner, project, args[0], args[1])
+				if err != nil {
+					return wrapAuth(err)
+				}
+				printReleaseDiff(cmd, diff)
+				return nil
+			},
+		},
+		&cobra.Command{
+			Use:   "info RELEASE [owner/project]",
+			Short: "Show a release and the vulnerabilities in its image",
+			Long: `Show a release and the vulnerabilities the scan of its image found, the most
+severe first. Block deploys of images with vulnerabilities with
+pmk scan-policy.`,
+			Args: cobra.RangeArgs(1, 2),
+			RunE: func(cmd *cobra.Command, args []string) error {
+				owner, project, err := opts.target(args[1:])
+				if err != nil {
+					return err
+				}
+				c, err := opts.client()
+				if err != nil {
+					return err
+				}
+				r, err := c.GetRelease(cmd.Context(), owner, project, args[0])
+				if err != nil {
+					return wrapAuth(err)
+				}
+				printRelease(cmd, r)
+				return nil
+			},
+		},
+		&cobra.Command{
+			Use:   "pin RELEASE [owner/project]",
+			Short: "Keep an app on a release until it is unpinned",
+			Long: `Keep an app on a release until it is unpinned.
+
+A release that isn't live is rolled back to first. While the app is pinned
+pushes and rollbacks to other releases fail, environment changes still
+restart the pinned release. It is never removed by release retention.`,
+			Args: cobra.RangeArgs(1, 2),
+			RunE: func(cmd *cobra.Command, args []string) error {
+				owner, project, err := opts.target(args[1:])
+				if err != nil {
+					return err
+				}
+				c, err := opts.client()
+				if err != nil {
+					return err
+				}
+				if err := c.PinRelease(cmd.Context(), owner, project, args[0]); err != nil {
+					return wrapAuth(err)
+				}
+				fmt.Fprintf(cmd.OutOrStdout(), "%s/%s pinned to release %s\n", owner, project, args[0])
+				return nil
+			},
+		},
+		&cobra.Command{
+			Use:   "unpin [owner/project]",
+			Short: "Let a pinned app deploy again",
+			Args:  cobra.MaximumNArgs(1),
+			RunE: func(cmd *cobra.Command, args []string) error {
+				owner, project, err := opts.target(args)
+				if err != nil {
+					return err
+				}
+				c, err := opts.client()
+				if err != nil {
+					return err
+				}
+				releases, err := c.ListReleases(cmd.Context(), owner, project)
+				if err != nil {
+					return wrapAuth(err)
+				}
+				for _, r := range releases {
+					if r.Pinned {
+						if err := c.UnpinRelease(cmd.Context(), owner, project, r.ID); err != nil {
+							return wrapAuth(err)
+						}
+						fmt.Fprintf(cmd.OutOrStdout(), "%s/%s unpinned from release %s\n", owner, project, r.ID)
+						return nil
+					}
+				}
+				return fmt.Errorf("%s/%s is not pinned", owner, project)
+			},
+		},
+		&cobra.Command{
+			Use:   "abort [owner/project]",
+			Short: "Send every request back to the live release and remove the canary",
+			Args:  cobra.MaximumNArgs(1),
+			RunE: func(cmd *cobra.Command, args []string) error {
+				owner, project, err := opts.target(args)
+				if err != nil {
+					return err
+				}
+				c, err := opts.client()
+				if err != nil {
+					return err
+				}
+				return wrapAuth(c.AbortCanary(cmd.Context(), owner, project))
+			},
+		},
+	)
+	return cmd
+}
+
+func liveRelease(cmd *cobra.Command, c *pemasak.Client, owner, project string) (string, error) {
+	releases, err := c.ListReleases(cmd.Context(), owner, project)
+	if err != nil {
+		return "", wrapAuth(err)
+	}
+	for _, r := range releases {
+		if r.Live {
+			return r.ID, nil
+		}
+	}
+	return "", fmt.Errorf("%s/%s has no release yet", owner, project)
+}
+
+func printRelease(cmd *cobra.Command, r *pemasak.ReleaseInfo) {
+	out := cmd.OutOrStdout()
+	fmt.Fprintf(out, "release %s (%s)\n", r.ID, releaseState(r.Release))
+	fmt.Fprintf(out, "image:   %s\n", r.Image)
+	fmt.Fprintf(out, "commit:  %s\n", shortCommit(r.CommitSHA))
+	fmt.Fprintf(out, "digest:  %s\n", shortDigest(r.ConfigDigest))
+	fmt.Fprintf(out, "created: %s, %s\n", r.CreatedAt.Local().Format(time.DateTime), r.Description)
+	fmt.Fprintf(out, "exit:    %s\n\n", lastExit(r.Release))
+
+	switch {
+	case r.Scan == nil:
+		fmt.Fprintln(out, "image not scanned")
+		return
+	case r.Scan.Error != "":
+		fmt.Fprintf(out, "scan failed: %s\n", r.Scan.Error)
+		return
+	}
+	fmt.Fprintf(out, "scanned %s: %d critical, %d high, %d medium, %d low, %d unknown\n",
+		r.Scan.ScannedAt.Local().Format(time.DateTime), r.Scan.Critical, r.Scan.High, r.Scan.Medium, r.Scan.Low, r.Scan.Unknown)
+	if len(r.Scan.Findings) == 0 {
+		return
+	}
+	fmt.Fprintln(out)
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tSEVERITY\tPACKAGE\tINSTALLED\tFIXED")
+	for _, v := range r.Scan.Findings {
+		fixed := v.Fixed
+		if fixed == "" {
+			fixed = "-"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", v.ID, v.Severity, v.Package, v.Installed, fixed)
+	}
+	w.Flush()
+}
+
+func printReleaseDiff(cmd *cobra.Command, diff *pemasak.ReleaseDiff) {
+	out := cmd.OutOrStdout()
+	for _, side := range []struct {
+		name string
+		r    pemasak.ReleaseSummary
+	}{{"from", diff.From}, {"to", diff.To}} {
+		fmt.Fprintf(out, "%-4s %s  commit %s  config %s  %s\n", side.name, side.r.ID, shortCommit(side.r.CommitSHA),
+			shortDigest(side.r.ConfigDigest), side.r.CreatedAt.Local().Format(time.DateTime))
+	}
+	if diff.From.Image != diff.To.Image {
+		fmt.Fprintf(out, "\nimage\n  - %s\n  + %s\n", diff.From.Image, diff.To.Image)
+	}
+	if len(diff.Env) > 0 {
+		fmt.Fprintln(out, "\nenvironment")
+		for _, e := range diff.Env {
+			if e.From != nil {
+				fmt.Fprintf(out, "  - %s=%s\n", e.Name, *e.From)
+			}
+			if e.To != nil {
+				fmt.Fprintf(out, "  + %s=%s\n", e.Name, *e.To)
+			}
+		}
+	}
+	if len(diff.Secrets) > 0 {
+		fmt.Fprintln(out, "\nsecrets")
+		for _, s := range diff.Secrets {
+			fmt.Fprintf(out, "  %s %s\n", s.Name, s.Change)
+		}
+	}
+	if len(diff.Config) > 0 {
+		fmt.Fprintln(out, "\nconfig")
+		for _, f := range diff.Config {
+			fmt.Fprintf(out, "  %s\n  - %s\n  + %s\n", f.Field, f.From, f.To)
+		}
+	}
+	if diff.From.ConfigDigest == diff.To.ConfigDigest && diff.From.Image == diff.To.Image {
+		fmt.Fprintln(out, "\nno changes")
+	}
+}
+
+// parsePercent reads a canary share like 10% or 10.
+func parsePercent(s string) (int, error) {
+	n, err := strconv.Atoi(strings.TrimSuffix(s, "%"))
+	if err != nil || n < 1 || n > 99 {
+		return 0, fmt.Errorf("invalid share %q, expected a percent between 1%% and 99%%", s)
+	}
+	return n, nil
+}
+
+func newRollbackCmd(opts *rootOptions) *cobra.Command {
+	var to string
+
+	cmd := &cobra.Command{
+		Use:   "rollback [owner/project]",
+		Short: "Restore an earlier release without rebuilding",
+		Long: `Restore an earlier release without rebuilding.
+
+Without --to, the release before the newest one is restored.`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			owner, project, err := opts.target(args)
+			if err != nil {
+				return err
+			}
+			c, err := opts.client()
+			if err != nil {
+				return err
+			}
+
+			if to == "" {
+				releases, err := c.ListReleases(cmd.Context(), owner, project)
+				if err != nil {
+					return wrapAuth(err)
+				}
+				if len(releases) < 2 {
+					return errors.New("no earlier release to roll back to")
+				}
+				to = releases[1].ID
+			}
+
+			if err := c.Rollback(cmd.Context(), owner, project, to); err != nil {
+				return wrapAuth(err)
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "Rollback to release %s queued for %s/%s\n", to, owner, project)
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&to, "to", "", "release id to restore")
+	return cmd
+}
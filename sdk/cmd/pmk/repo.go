@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	pemasak "github.com/mustafasegf/pemasak-infra/sdk"
+	"github.com/spf13/cobra"
+)
+
+func newRepoCmd(opts *rootOptions) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "repo",
+		Short: "Deploy an app from a GitHub or GitLab repository",
+		Long: `Deploy an app from a GitHub or GitLab repository.
+
+Once linked, add a push webhook to the repository with the url and secret
+shown by pmk repo show. Every push to the branch is fetched and deployed. Use
+--app or PMK_APP to pick the app.`,
+	}
+
+	var branch, token string
+	link := &cobra.Command{
+		Use:   "link github|gitlab URL",
+		Short: "Deploy pushes to a branch of a repository",
+		Example: `  pmk repo link github https://github.com/acme/shop.git --branch main
+  pmk repo link gitlab https://gitlab.com/acme/shop.git --token glpat-xxxx`,
+		Args: cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			owner, project, err := opts.target(nil)
+			if err != nil {
+				return err
+			}
+			c, err := opts.client()
+			if err != nil {
+				return err
+			}
+			err = c.LinkRepo(cmd.Context(), owner, project, pemasak.LinkRepoOptions{
+				Provider: args[0],
+				URL:      args[1],
+				Branch:   branch,
+				Token:    token,
+			})
+			if err != nil {
+				return wrapAuth(err)
+			}
+			repo, err := c.GetLinkedRepo(cmd.Context(), owner, project)
+			if err != nil {
+				return wrapAuth(err)
+			}
+			printWebhook(cmd, repo)
+			return nil
+		},
+	}
+	link.Flags().StringVar(&branch, "branch", "main", "branch whose pushes are deployed")
+	link.Flags().StringVar(&token, "token", "", "access token for a private repository")
+
+	cmd.AddCommand(
+		&cobra.Command{
+			Use:   "show",
+			Short: "Show the linked repository and its webhook",
+			Args:  cobra.NoArgs,
+			RunE: func(cmd *cobra.Command, args []string) error {
+				owner, project, err := opts.target(nil)
+				if err != nil {
+					return err
+				}
+				c, err := opts.client()
+				if err != nil {
+					return err
+				}
+				repo, err := c.GetLinkedRepo(cmd.Context(), owner, project)
+				if err != nil {
+					return wrapAuth(err)
+				}
+				if repo == nil {
+					fmt.Fprintln(cmd.OutOrStdout(), "no linked repository")
+					return nil
+				}
+				fmt.Fprintf(cmd.OutOrStdout(), "%s on %s, deploying %s\n", repo.URL, repo.Provider, repo.Branch)
+				if repo.LastSyncedAt != nil {
+					fmt.Fprintf(cmd.OutOrStdout(), "last push %.7s at %s\n", repo.LastCommit, repo.LastSyncedAt.Local().Format(time.DateTime))
+				}
+				printWebhook(cmd, repo)
+				return nil
+			},
+		},
+		link,
+		&cobra.Command{
+			Use:   "unlink",
+			Short: "Stop deploying pushes from the linked repository",
+			Args:  cobra.NoArgs,
+			RunE: func(cmd *cobra.Command, args []string) error {
+				owner, project, err := opts.target(nil)
+				if err != nil {
+					return err
+				}
+				c, err := opts.client()
+				if err != nil {
+					return err
+				}
+				return wrapAuth(c.UnlinkRepo(cmd.Context(), owner, project))
+			},
+		},
+	)
+	return cmd
+}
+
+func printWebhook(cmd *cobra.Command, repo *pemasak.LinkedRepo) {
+	fmt.Fprintf(cmd.ErrOrStderr(), "\nadd a push webhook to the repository\n  url:          %s\n  content type: application/json\n  secret:       %s\n", repo.WebhookURL, repo.WebhookSecret)
+}
@@ -0,0 +1,42 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+
+	"golang.org/x/term"
+
+	pemasak "github.com/mustafasegf/pemasak-infra/sdk"
+)
+
+// watchResize reports the new size of the terminal on fd whenever it changes.
+func watchResize(fd int) (<-chan pemasak.TerminalSize, func()) {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGWINCH)
+
+	sizes := make(chan pemasak.TerminalSize, 1)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-sigs:
+				if w, h, err := term.GetSize(fd); err == nil {
+					select {
+					case sizes <- pemasak.TerminalSize{Width: w, Height: h}:
+					default:
+					}
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return sizes, func() {
+		signal.Stop(sigs)
+		close(done)
+	}
+}
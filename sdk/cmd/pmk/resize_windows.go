@@ -0,0 +1,9 @@
+package main
+
+import pemasak "github.com/mustafasegf/pemasak-infra/sdk"
+
+// watchResize is a no-op on windows, which has no SIGWINCH. The terminal
+// keeps the size it had when the command started.
+func watchResize(fd int) (<-chan pemasak.TerminalSize, func()) {
+	return nil, func() {}
+}
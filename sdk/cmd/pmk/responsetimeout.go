@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/spf13/cobra"
+)
+
+func newResponseTimeoutCmd(opts *rootOptions) *cobra.Command {
+	return &cobra.Command{
+		Use:   "response-timeout [SECONDS|off]",
+		Short: "Limit how long an app may take to answer",
+		Long: `Limit how long an app may take to answer.
+
+When the app doesn't send the headers of a response within SECONDS (at most
+3600) the visitor gets a 504 error page. A response that started in time may
+stream for as long as it likes, so server-sent events aren't cut off. By
+default the platform waits as long as it takes. Without arguments the
+current timeout is shown. Use --app or PMK_APP to pick the app.`,
+		Example: `  pmk response-timeout 30
+  pmk response-timeout off`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			owner, project, err := opts.target(nil)
+			if err != nil {
+				return err
+			}
+			c, err := opts.client()
+			if err != nil {
+				return err
+			}
+			settings, err := c.GetSettings(cmd.Context(), owner, project)
+			if err != nil {
+				return wrapAuth(err)
+			}
+
+			if len(args) == 0 {
+				if settings.ResponseTimeout == 0 {
+					fmt.Fprintln(cmd.OutOrStdout(), "waits as long as it takes")
+				} else {
+					fmt.Fprintf(cmd.OutOrStdout(), "answers within %d seconds\n", settings.ResponseTimeout)
+				}
+				return nil
+			}
+
+			settings.ResponseTimeout = 0
+			if args[0] != "off" {
+				seconds, err := strconv.Atoi(args[0])
+				if err != nil || seconds <= 0 || seconds > 3600 {
+					return fmt.Errorf("invalid timeout %q, expected seconds up to 3600 or off", args[0])
+				}
+				settings.ResponseTimeout = seconds
+			}
+			if err := c.UpdateSettings(cmd.Context(), owner, project, *settings); err != nil {
+				return wrapAuth(err)
+			}
+			return nil
+		},
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	pemasak "github.com/mustafasegf/pemasak-infra/sdk"
+)
+
+func newRestartsCmd(opts *rootOptions) *cobra.Command {
+	var retries int
+	cmd := &cobra.Command{
+		Use:   "restarts [always|on-failure|never]",
+		Short: "Choose when a stopped container of an app is started again",
+		Long: `Choose when a stopped container of an app is started again.
+
+always restarts it whenever it exits, also with exit code 0. on-failure, the
+default, restarts it when it exits with another code, with --retries only
+that many times. never leaves it stopped. Running containers get the policy
+right away. A container that keeps crashing while restarts don't end is
+restarted with a growing wait, see pmk notifications. pmk releases shows how a
+container of each release last exited. Without arguments the current policy
+is shown. Use --app or PMK_APP to pick the app.`,
+		Example: `  pmk restarts always
+  pmk restarts on-failure --retries 3
+  pmk restarts never`,
+		Args:      cobra.MaximumNArgs(1),
+		ValidArgs: []string{pemasak.RestartAlways, pemasak.RestartOnFailure, pemasak.RestartNever},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			owner, project, err := opts.target(nil)
+			if err != nil {
+				return err
+			}
+			c, err := opts.client()
+			if err != nil {
+				return err
+			}
+			settings, err := c.GetSettings(cmd.Context(), owner, project)
+			if err != nil {
+				return wrapAuth(err)
+			}
+
+			if len(args) == 0 {
+				if settings.RestartRetries > 0 {
+					fmt.Fprintf(cmd.OutOrStdout(), "%s, %d retries\n", settings.RestartPolicy, settings.RestartRetries)
+				} else {
+					fmt.Fprintln(cmd.OutOrStdout(), settings.RestartPolicy)
+				}
+				return nil
+			}
+
+			switch args[0] {
+			case pemasak.RestartAlways, pemasak.RestartOnFailure, pemasak.RestartNever:
+			default:
+				return fmt.Errorf("invalid restart policy %q, expected always, on-failure or never", args[0])
+			}
+			if retries < 0 {
+				return fmt.Errorf("--retries must be positive")
+			}
+			if retries != 0 && args[0] != pemasak.RestartOnFailure {
+				return fmt.Errorf("--retries only applies to on-failure")
+			}
+			settings.RestartPolicy = args[0]
+			settings.RestartRetries = retries
+			if err := c.UpdateSettings(cmd.Context(), owner, project, *settings); err != nil {
+				return wrapAuth(err)
+			}
+			return nil
+		},
+	}
+	cmd.Flags().IntVar(&retries, "retries", 0, "restarts of a crashing container before it is left stopped, 0 keeps restarting")
+	return cmd
+}
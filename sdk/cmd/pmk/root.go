@@ -0,0 +1,147 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/mustafasegf/pemasak-infra/sdk"
+	"github.com/spf13/cobra"
+)
+
+type rootOptions struct {
+	url string
+	app string
+}
+
+func newRootCmd() *cobra.Command {
+	opts := &rootOptions{}
+
+	cmd := &cobra.Command{
+		Use:           "pmk",
+		Short:         "Manage apps on pemasak-infra from the command line",
+		SilenceUsage:  true,
+		SilenceErrors: true,
+	}
+	cmd.PersistentFlags().StringVar(&opts.url, "url", os.Getenv("PMK_URL"), "platform url (env PMK_URL)")
+	cmd.PersistentFlags().StringVarP(&opts.app, "app", "a", os.Getenv("PMK_APP"), "app as owner/project (env PMK_APP)")
+
+	cmd.AddCommand(
+		newLoginCmd(opts),
+		newLogoutCmd(opts),
+		newAppsCmd(opts),
+		newCreateCmd(opts),
+		newTemplatesCmd(opts),
+		newMembersCmd(opts),
+		newTokensCmd(opts),
+		newTwoFactorCmd(opts),
+		newSessionsCmd(opts),
+		newLoginHooksCmd(opts),
+		newDeployCmd(opts),
+		newBuildsCmd(opts),
+		newReleasesCmd(opts),
+		newRollbackCmd(opts),
+		newPipelineCmd(opts),
+		newLabelsCmd(opts),
+		newLogsCmd(opts),
+		newDrainsCmd(opts),
+		newNotificationsCmd(opts),
+		newRegistriesCmd(opts),
+		newEnvCmd(opts),
+		newEnvGroupsCmd(opts),
+		newDomainsCmd(opts),
+		newRepoCmd(opts),
+		newPsCmd(opts),
+		newScaleCmd(opts),
+		newAutoscaleCmd(opts),
+		newIdleCmd(opts),
+		newCleanupCmd(opts),
+		newSourceCmd(opts),
+		newPushPolicyCmd(opts),
+		newDeployKeysCmd(opts),
+		newDeployBranchCmd(opts),
+		newInternalCmd(opts),
+		newCloneableCmd(opts),
+		newScanPolicyCmd(opts),
+		newRestartsCmd(opts),
+		newProtocolCmd(opts),
+		newBufferingCmd(opts),
+		newStickyCmd(opts),
+		newCompressionCmd(opts),
+		newCacheCmd(opts),
+		newHTTPSCmd(opts),
+		newResponseTimeoutCmd(opts),
+		newRateLimitCmd(opts),
+		newBodyLimitCmd(opts),
+		newAccessCmd(opts),
+		newEgressCmd(opts),
+		newProxySecretCmd(opts),
+		newCORSCmd(opts),
+		newHeadersCmd(opts),
+		newBasicAuthCmd(opts),
+		newPreviewsCmd(opts),
+		newMaintenanceCmd(opts),
+		newErrorPageCmd(opts),
+		newActivityCmd(opts),
+		newEventsCmd(opts),
+		newWatchCmd(opts),
+		newAuditCmd(opts),
+		newAdminCmd(opts),
+		newMetricsCmd(opts),
+		newPprofCmd(opts),
+		newAddonsCmd(opts),
+		newVolumesCmd(opts),
+		newLimitsCmd(opts),
+		newQuotasCmd(opts),
+		newUsageCmd(opts),
+		newBackupsCmd(opts),
+		newCronCmd(opts),
+		newPortsCmd(opts),
+		newRunCmd(opts),
+		newShellCmd(opts),
+	)
+	return cmd
+}
+
+// client builds an authenticated client from PMK_TOKEN, or else the saved
+// session.
+func (o *rootOptions) client() (*pemasak.Client, error) {
+	cfg, err := loadConfig()
+	if err != nil {
+		return nil, err
+	}
+	url := o.url
+	if url == "" {
+		url = cfg.URL
+	}
+	if url == "" {
+		return nil, errors.New("no platform url, run `pmk login --url <url>` first")
+	}
+	var opts []pemasak.Option
+	// a session saved for another platform is useless here
+	if token := os.Getenv("PMK_TOKEN"); token != "" {
+		opts = append(opts, pemasak.WithToken(token))
+	} else if cfg.URL == url {
+		opts = append(opts, pemasak.WithCookies(cfg.cookies()))
+	}
+	return pemasak.New(url, opts...)
+}
+
+// target resolves the app from the positional argument or --app.
+func (o *rootOptions) target(args []string) (owner, project string, err error) {
+	app := o.app
+	if len(args) > 0 {
+		app = args[0]
+	}
+	if app == "" {
+		return "", "", fmt.Errorf("no app given, pass owner/project or --app")
+	}
+	return splitApp(app)
+}
+
+func wrapAuth(err error) error {
+	if errors.Is(err, pemasak.ErrUnauthenticated) {
+		return errors.New("not logged in, run `pmk login`")
+	}
+	return err
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// sentRequest is what the platform got from a command.
+type sentRequest struct {
+	method string
+	path   string
+	body   string
+}
+
+// runPmk runs pmk with args against a test platform that answers GETs with
+// the body in gets by path and everything else with 204. It returns the
+// requests the command sent and its error.
+func runPmk(t *testing.T, gets map[string]string, args ...string) ([]sentRequest, error) {
+	t.Helper()
+	var (
+		mu   sync.Mutex
+		sent []sentRequest
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get("Authorization"); got != "Bearer pmk_test" {
+			t.Errorf("Authorization = %q, want the token of PMK_TOKEN", got)
+		}
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		sent = append(sent, sentRequest{r.Method, r.URL.Path, strings.TrimSpace(string(body))})
+		mu.Unlock()
+
+		if r.Method == http.MethodGet {
+			w.Write([]byte(gets[r.URL.Path]))
+			return
+		}
+		if r.URL.Path == "/api/project/owner/app/ports" {
+			w.Write([]byte(`{"id":"p1","protocol":"tcp","port":40015,"target_port":1883,"host":"pemasak.test"}`))
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	t.Cleanup(srv.Close)
+
+	t.Setenv("PMK_CONFIG", filepath.Join(t.TempDir(), "config.json"))
+	t.Setenv("PMK_TOKEN", "pmk_test")
+	t.Setenv("PMK_URL", "")
+	t.Setenv("PMK_APP", "")
+
+	cmd := newRootCmd()
+	cmd.SetArgs(append([]string{"--url", srv.URL}, args...))
+	cmd.SetOut(io.Discard)
+	cmd.SetErr(io.Discard)
+	err := cmd.ExecuteContext(context.Background())
+	return sent, err
+}
+
+func TestFlagsToRequests(t *testing.T) {
+	deployBranch := map[string]string{
+		"/api/project/owner/app/deploy-branch": `{"branch":"staging","deploys":"staging","promote":true}`,
+	}
+
+	tests := []struct {
+		name string
+		args []string
+		gets map[string]string
+		want []sentRequest
+	}{
+		{
+			name: "lease a port",
+			args: []string{"ports", "add", "tcp", "1883", "--app", "owner/app", "--port", "40015", "--idle-timeout", "60"},
+			want: []sentRequest{{"POST", "/api/project/owner/app/ports",
+				`{"protocol":"tcp","target_port":1883,"port":40015,"idle_timeout":60}`}},
+		},
+		{
+			name: "lease any port",
+			args: []string{"ports", "add", "udp", "27015", "-a", "owner/app"},
+			want: []sentRequest{{"POST", "/api/project/owner/app/ports", `{"protocol":"udp","target_port":27015}`}},
+		},
+		{
+			name: "release a port",
+			args: []string{"ports", "remove", "p1", "-a", "owner/app"},
+			want: []sentRequest{{"POST", "/api/project/owner/app/ports/p1/delete", ""}},
+		},
+		{
+			name: "scale processes",
+			args: []string{"scale", "web=2", "worker=0", "-a", "owner/app"},
+			want: []sentRequest{
+				{"POST", "/api/project/owner/app/processes", `{"name":"web","count":2}`},
+				{"POST", "/api/project/owner/app/processes", `{"name":"worker","count":0}`},
+			},
+		},
+		{
+			name: "set the deploy branch keeps promote",
+			args: []string{"deploy-branch", "set", "main", "-a", "owner/app"},
+			gets: deployBranch,
+			want: []sentRequest{
+				{"GET", "/api/project/owner/app/deploy-branch", ""},
+				{"POST", "/api/project/owner/app/deploy-branch", `{"branch":"main","promote":true}`},
+			},
+		},
+		{
+			name: "turn promote off keeps the branch",
+			args: []string{"deploy-branch", "set", "--promote=false", "-a", "owner/app"},
+			gets: deployBranch,
+			want: []sentRequest{
+				{"GET", "/api/project/owner/app/deploy-branch", ""},
+				{"POST", "/api/project/owner/app/deploy-branch", `{"branch":"staging","promote":false}`},
+			},
+		},
+		{
+			name: "clear the deploy branch",
+			args: []string{"deploy-branch", "clear", "-a", "owner/app"},
+			gets: deployBranch,
+			want: []sentRequest{
+				{"GET", "/api/project/owner/app/deploy-branch", ""},
+				{"POST", "/api/project/owner/app/deploy-branch", `{"branch":null,"promote":false}`},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sent, err := runPmk(t, tt.gets, tt.args...)
+			if err != nil {
+				t.Fatalf("pmk %s: %v", strings.Join(tt.args, " "), err)
+			}
+			if !reflect.DeepEqual(sent, tt.want) {
+				t.Fatalf("sent %+v\nwant %+v", sent, tt.want)
+			}
+		})
+	}
+}
+
+func TestInvalidFlagsSendNothing(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{name: "target port not a number", args: []string{"ports", "add", "tcp", "http", "-a", "owner/app"}, want: "target port must be a number"},
+		{name: "scale without a count", args: []string{"scale", "web", "-a", "owner/app"}, want: "expected PROCESS=COUNT"},
+		{name: "negative scale", args: []string{"scale", "web=-1", "-a", "owner/app"}, want: "expected PROCESS=COUNT"},
+		{name: "deploy branch without a change", args: []string{"deploy-branch", "set", "-a", "owner/app"}, want: "nothing to change"},
+		{name: "no app", args: []string{"ports", "list"}, want: "no app given"},
+		{name: "app without a project", args: []string{"ports", "list", "-a", "owner"}, want: "owner/project"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sent, err := runPmk(t, nil, tt.args...)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("err = %v, want one containing %q", err, tt.want)
+			}
+			if len(sent) != 0 {
+				t.Fatalf("sent %+v, want nothing", sent)
+			}
+		})
+	}
+}
+
+func TestNotLoggedIn(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/login", http.StatusFound)
+	}))
+	defer srv.Close()
+	t.Setenv("PMK_CONFIG", filepath.Join(t.TempDir(), "config.json"))
+	t.Setenv("PMK_TOKEN", "")
+
+	cmd := newRootCmd()
+	cmd.SetArgs([]string{"--url", srv.URL, "ports", "list", "-a", "owner/app"})
+	cmd.SetOut(io.Discard)
+	cmd.SetErr(io.Discard)
+	err := cmd.ExecuteContext(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "pmk login") {
+		t.Fatalf("err = %v, want the hint to log in", err)
+	}
+}
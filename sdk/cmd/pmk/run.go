@@ -0,0 +1,147 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+
+	pemasak "github.com/mustafasegf/pemasak-infra/sdk"
+)
+
+func newRunCmd(opts *rootOptions) *cobra.Command {
+	var tty, noTTY bool
+	cmd := &cobra.Command{
+		Use:   "run -- COMMAND...",
+		Short: "Run a one-off command in the app's environment",
+		Long: `Run a one-off command in the app's environment.
+
+The command runs in a new container of the live release with the app's
+environment variables and database, and is removed once it exits. pmk exits
+with the exit code of the command. A terminal is allocated when stdin and
+stdout are terminals, use -t or -T to force it on or off. Use --app or
+PMK_APP to pick the app.`,
+		Example: `  pmk run -a owner/myapp -- python manage.py migrate
+  pmk run -a owner/myapp -- sh -c 'echo $DATABASE_URL'`,
+		Args: cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			owner, project, err := opts.target(nil)
+			if err != nil {
+				return err
+			}
+			c, err := opts.client()
+			if err != nil {
+				return err
+			}
+			return runSession(cmd, args, tty, noTTY, func(run pemasak.RunOptions) (int, error) {
+				return c.Run(cmd.Context(), owner, project, run)
+			})
+		},
+	}
+	cmd.Flags().BoolVarP(&tty, "tty", "t", false, "allocate a terminal")
+	cmd.Flags().BoolVarP(&noTTY, "no-tty", "T", false, "don't allocate a terminal")
+	cmd.MarkFlagsMutuallyExclusive("tty", "no-tty")
+	// flags after the command belong to the command
+	cmd.Flags().SetInterspersed(false)
+	return cmd
+}
+
+// shellCommand starts bash when the image has it and falls back to sh.
+var shellCommand = []string{"sh", "-c", "if command -v bash >/dev/null; then exec bash; else exec sh; fi"}
+
+func newShellCmd(opts *rootOptions) *cobra.Command {
+	var tty, noTTY bool
+	cmd := &cobra.Command{
+		Use:   "shell [owner/project] [-- COMMAND...]",
+		Short: "Open a shell inside the running app container",
+		Long: `Open a shell inside the running app container.
+
+Unlike run, the shell shares the container with the live app, so it sees its
+files and processes. Changes are lost on the next deploy. Pass a command after
+-- to run it instead of a shell.`,
+		Example: `  pmk shell owner/myapp
+  pmk shell owner/myapp -- ps aux`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var command []string
+			if n := cmd.ArgsLenAtDash(); n >= 0 {
+				args, command = args[:n], args[n:]
+			}
+			if len(args) > 1 {
+				return fmt.Errorf("expected at most one app, got %d", len(args))
+			}
+			if len(command) == 0 {
+				command = shellCommand
+			}
+			owner, project, err := opts.target(args)
+			if err != nil {
+				return err
+			}
+			c, err := opts.client()
+			if err != nil {
+				return err
+			}
+			return runSession(cmd, command, tty, noTTY, func(run pemasak.RunOptions) (int, error) {
+				return c.Exec(cmd.Context(), owner, project, run)
+			})
+		},
+	}
+	cmd.Flags().BoolVarP(&tty, "tty", "t", false, "allocate a terminal")
+	cmd.Flags().BoolVarP(&noTTY, "no-tty", "T", false, "don't allocate a terminal")
+	cmd.MarkFlagsMutuallyExclusive("tty", "no-tty")
+	return cmd
+}
+
+// runSession wires the local terminal to a remote command started by start and
+// turns its exit code into pmk's.
+func runSession(cmd *cobra.Command, command []string, tty, noTTY bool, start func(pemasak.RunOptions) (int, error)) error {
+	stdin, stdout := int(os.Stdin.Fd()), int(os.Stdout.Fd())
+	if !tty && !noTTY {
+		tty = term.IsTerminal(stdin) && term.IsTerminal(stdout)
+	}
+
+	run := pemasak.RunOptions{
+		Command: command,
+		TTY:     tty,
+		Stdin:   cmd.InOrStdin(),
+		Stdout:  cmd.OutOrStdout(),
+		Stderr:  cmd.ErrOrStderr(),
+	}
+	if tty && term.IsTerminal(stdin) {
+		state, err := term.MakeRaw(stdin)
+		if err != nil {
+			return err
+		}
+		defer term.Restore(stdin, state)
+
+		if w, h, err := term.GetSize(stdout); err == nil {
+			run.Size = pemasak.TerminalSize{Width: w, Height: h}
+		}
+		resize, stop := watchResize(stdout)
+		defer stop()
+		run.Resize = resize
+	}
+
+	code, err := start(run)
+	if err != nil {
+		return wrapAuth(err)
+	}
+	if code != 0 {
+		return &exitError{code: code}
+	}
+	return nil
+}
+
+// exitError makes pmk exit with the exit code of a remote command.
+type exitError struct{ code int }
+
+func (e *exitError) Error() string { return "command exited with a non-zero code" }
+
+func isExitError(err error) (int, bool) {
+	var exit *exitError
+	if errors.As(err, &exit) {
+		return exit.code, true
+	}
+	return 0, false
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+func newScanPolicyCmd(opts *rootOptions) *cobra.Command {
+	return &cobra.Command{
+		Use:   "scan-policy [critical|high|medium|low|off]",
+		Short: "Block deploys of images with vulnerabilities",
+		Long: `Block deploys of images with vulnerabilities of a severity or worse.
+
+Every image is scanned before it runs, the build log lists what was found and
+pmk releases info shows all of it. With a policy a build or pmk deploy --image
+whose image has a vulnerability of that severity or worse fails and the live
+release keeps running; an image that couldn't be scanned fails too. Off only
+reports them. Without arguments the current policy is shown. Use --app or
+PMK_APP to pick the app.`,
+		Example: `  pmk scan-policy critical
+  pmk scan-policy off`,
+		Args:      cobra.MaximumNArgs(1),
+		ValidArgs: []string{"critical", "high", "medium", "low", "off"},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			owner, project, err := opts.target(nil)
+			if err != nil {
+				return err
+			}
+			c, err := opts.client()
+			if err != nil {
+				return err
+			}
+			settings, err := c.GetSettings(cmd.Context(), owner, project)
+			if err != nil {
+				return wrapAuth(err)
+			}
+
+			if len(args) == 0 {
+				if settings.BlockSeverity == "" {
+					fmt.Fprintln(cmd.OutOrStdout(), "off, vulnerabilities are only reported")
+				} else {
+					fmt.Fprintf(cmd.OutOrStdout(), "deploys with %s vulnerabilities or worse are blocked\n", settings.BlockSeverity)
+				}
+				return nil
+			}
+
+			switch args[0] {
+			case "critical", "high", "medium", "low":
+				settings.BlockSeverity = args[0]
+			case "off":
+				settings.BlockSeverity = ""
+			default:
+				return fmt.Errorf("invalid policy %q, expected critical, high, medium, low or off", args[0])
+			}
+			if err := c.UpdateSettings(cmd.Context(), owner, project, *settings); err != nil {
+				return wrapAuth(err)
+			}
+			return nil
+		},
+	}
+}
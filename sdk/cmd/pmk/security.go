@@ -0,0 +1,264 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+func newTwoFactorCmd(opts *rootOptions) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "2fa",
+		Short: "Ask for an authenticator app code when logging in",
+		Long: `Ask for an authenticator app code when logging in.
+
+Any TOTP app works, like Google Authenticator, Authy or 1Password. Enabling
+prints a secret to add to the app; confirming with the code the app shows
+turns it on and prints ten recovery codes. Each recovery code logs in once
+without the app, keep them somewhere safe.
+
+Logins through SSO leave two-factor authentication to the identity provider.
+Access tokens aren't affected.`,
+	}
+
+	prompt := func(cmd *cobra.Command, args []string) (string, error) {
+		if len(args) > 0 {
+			return args[0], nil
+		}
+		fmt.Fprint(cmd.ErrOrStderr(), "Code: ")
+		line, err := bufio.NewReader(cmd.InOrStdin()).ReadString('\n')
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimSpace(line), nil
+	}
+
+	cmd.AddCommand(
+		&cobra.Command{
+			Use:   "status",
+			Short: "Show whether two-factor authentication is on",
+			Args:  cobra.NoArgs,
+			RunE: func(cmd *cobra.Command, args []string) error {
+				c, err := opts.client()
+				if err != nil {
+					return err
+				}
+				status, err := c.TwoFactor(cmd.Context())
+				if err != nil {
+					return wrapAuth(err)
+				}
+				if !status.Enabled {
+					fmt.Fprintln(cmd.OutOrStdout(), "two-factor authentication is off")
+					return nil
+				}
+				fmt.Fprintf(cmd.OutOrStdout(), "two-factor authentication is on since %s, %d recovery codes left\n",
+					formatTime(status.ConfirmedAt, "-"), status.RecoveryCodesLeft)
+				return nil
+			},
+		},
+		&cobra.Command{
+			Use:   "enable",
+			Short: "Start setting up an authenticator app",
+			Args:  cobra.NoArgs,
+			RunE: func(cmd *cobra.Command, args []string) error {
+				c, err := opts.client()
+				if err != nil {
+					return err
+				}
+				setup, err := c.EnableTwoFactor(cmd.Context())
+				if err != nil {
+					return wrapAuth(err)
+				}
+				fmt.Fprintf(cmd.OutOrStdout(), "secret: %s\n%s\n", setup.Secret, setup.OtpauthURL)
+				fmt.Fprintln(cmd.ErrOrStderr(), "add it to your authenticator app, then run pmk 2fa confirm with the code it shows")
+				return nil
+			},
+		},
+		&cobra.Command{
+			Use:   "confirm [CODE]",
+			Short: "Turn two-factor authentication on with a code of the app",
+			Args:  cobra.MaximumNArgs(1),
+			RunE: func(cmd *cobra.Command, args []string) error {
+				code, err := prompt(cmd, args)
+				if err != nil {
+					return err
+				}
+				c, err := opts.client()
+				if err != nil {
+					return err
+				}
+				codes, err := c.ConfirmTwoFactor(cmd.Context(), code)
+				if err != nil {
+					return wrapAuth(err)
+				}
+				for _, code := range codes {
+					fmt.Fprintln(cmd.OutOrStdout(), code)
+				}
+				fmt.Fprintln(cmd.ErrOrStderr(), "two-factor authentication is on. store these recovery codes now, they can't be shown again")
+				return nil
+			},
+		},
+		&cobra.Command{
+			Use:   "disable [CODE]",
+			Short: "Turn two-factor authentication off",
+			Args:  cobra.MaximumNArgs(1),
+			RunE: func(cmd *cobra.Command, args []string) error {
+				code, err := prompt(cmd, args)
+				if err != nil {
+					return err
+				}
+				c, err := opts.client()
+				if err != nil {
+					return err
+				}
+				if err := c.DisableTwoFactor(cmd.Context(), code); err != nil {
+					return wrapAuth(err)
+				}
+				fmt.Fprintln(cmd.OutOrStdout(), "two-factor authentication is off")
+				return nil
+			},
+		},
+	)
+	return cmd
+}
+
+func newSessionsCmd(opts *rootOptions) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "sessions",
+		Short: "List where you are logged in and log out other devices",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c, err := opts.client()
+			if err != nil {
+				return err
+			}
+			sessions, err := c.ListSessions(cmd.Context())
+			if err != nil {
+				return wrapAuth(err)
+			}
+			w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "ID\tIP\tBROWSER\tLOGGED IN\tLAST SEEN")
+			for _, s := range sessions {
+				id := s.ID
+				if s.Current {
+					id += " (this one)"
+				}
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", id, s.IP, s.UserAgent, s.CreatedAt.Local().Format(time.DateTime), s.LastSeenAt.Local().Format(time.DateTime))
+			}
+			return w.Flush()
+		},
+	}
+
+	var others bool
+	revoke := &cobra.Command{
+		Use:   "revoke [ID]",
+		Short: "Log out a session on its next request",
+		Example: `  pmk sessions revoke 018f3c4e-...
+  pmk sessions revoke --others`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if others == (len(args) == 1) {
+				return fmt.Errorf("pass a session ID or --others")
+			}
+			c, err := opts.client()
+			if err != nil {
+				return err
+			}
+			if !others {
+				return wrapAuth(c.RevokeSession(cmd.Context(), args[0]))
+			}
+			sessions, err := c.ListSessions(cmd.Context())
+			if err != nil {
+				return wrapAuth(err)
+			}
+			for _, s := range sessions {
+				if s.Current {
+					continue
+				}
+				if err := c.RevokeSession(cmd.Context(), s.ID); err != nil {
+					return wrapAuth(err)
+				}
+				fmt.Fprintf(cmd.OutOrStdout(), "revoked %s\n", s.ID)
+			}
+			return nil
+		},
+	}
+	revoke.Flags().BoolVar(&others, "others", false, "revoke every session but this one")
+
+	cmd.AddCommand(revoke)
+	return cmd
+}
+
+func newLoginHooksCmd(opts *rootOptions) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "login-hooks",
+		Short: "Get told when you log in from a new device",
+		Long: `Get told when you log in from a new device.
+
+A device is new when you haven't logged in with the same browser from the
+same address in the last 90 days. Hooks have the kinds of pmk notifications,
+webhooks receive the user.new_login event with the session to revoke if it
+wasn't you.`,
+	}
+
+	cmd.AddCommand(
+		&cobra.Command{
+			Use:   "add webhook|slack|discord URL",
+			Short: "Start notifying a hook about new logins",
+			Args:  cobra.ExactArgs(2),
+			RunE: func(cmd *cobra.Command, args []string) error {
+				c, err := opts.client()
+				if err != nil {
+					return err
+				}
+				hook, err := c.AddLoginHook(cmd.Context(), args[0], args[1])
+				if err != nil {
+					return wrapAuth(err)
+				}
+				fmt.Fprintf(cmd.OutOrStdout(), "added hook %s\n", hook.ID)
+				if hook.Secret != "" {
+					fmt.Fprintf(cmd.ErrOrStderr(), "payloads are signed with %s\n", hook.Secret)
+				}
+				return nil
+			},
+		},
+		&cobra.Command{
+			Use:   "list",
+			Short: "List the login hooks",
+			Args:  cobra.NoArgs,
+			RunE: func(cmd *cobra.Command, args []string) error {
+				c, err := opts.client()
+				if err != nil {
+					return err
+				}
+				hooks, err := c.ListLoginHooks(cmd.Context())
+				if err != nil {
+					return wrapAuth(err)
+				}
+				w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+				fmt.Fprintln(w, "ID\tKIND\tURL")
+				for _, h := range hooks {
+					fmt.Fprintf(w, "%s\t%s\t%s\n", h.ID, h.Kind, h.URL)
+				}
+				return w.Flush()
+			},
+		},
+		&cobra.Command{
+			Use:   "delete ID",
+			Short: "Remove a login hook",
+			Args:  cobra.ExactArgs(1),
+			RunE: func(cmd *cobra.Command, args []string) error {
+				c, err := opts.client()
+				if err != nil {
+					return err
+				}
+				return wrapAuth(c.DeleteLoginHook(cmd.Context(), args[0]))
+			},
+		},
+	)
+	return cmd
+}
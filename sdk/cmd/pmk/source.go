@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+func newSourceCmd(opts *rootOptions) *cobra.Command {
+	var (
+		watch []string
+		root  bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "source [DIR]",
+		Short: "Build an app from a directory of its repository",
+		Long: `Build an app from a directory of its repository.
+
+In a monorepo every app can be built from its own directory: its Dockerfile,
+Procfile and build context come from DIR instead of the root. Pushes that
+change nothing under DIR are not built, use --watch to build on changes to
+other paths too, like a library the app shares with others. Without arguments
+the current settings are shown. Use --app or PMK_APP to pick the app.`,
+		Example: `  pmk source services/api
+  pmk source services/api --watch services/api --watch libs/shared
+  pmk source --root`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			owner, project, err := opts.target(nil)
+			if err != nil {
+				return err
+			}
+			c, err := opts.client()
+			if err != nil {
+				return err
+			}
+			settings, err := c.GetSettings(cmd.Context(), owner, project)
+			if err != nil {
+				return wrapAuth(err)
+			}
+
+			if len(args) == 0 && !root && !cmd.Flags().Changed("watch") {
+				out := cmd.OutOrStdout()
+				if settings.SourceDir == "" {
+					fmt.Fprintln(out, "builds from the root")
+				} else {
+					fmt.Fprintf(out, "builds from %s\n", settings.SourceDir)
+				}
+				if len(settings.WatchPaths) > 0 {
+					fmt.Fprintf(out, "builds pushes changing %s\n", strings.Join(settings.WatchPaths, ", "))
+				}
+				return nil
+			}
+
+			if root && len(args) > 0 {
+				return fmt.Errorf("pass either DIR or --root")
+			}
+			if root {
+				settings.SourceDir = ""
+			} else if len(args) > 0 {
+				settings.SourceDir = args[0]
+			}
+			settings.WatchPaths = watch
+
+			if err := c.UpdateSettings(cmd.Context(), owner, project, *settings); err != nil {
+				return wrapAuth(err)
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().StringArrayVar(&watch, "watch", nil, "only build pushes changing this path (repeatable)")
+	cmd.Flags().BoolVar(&root, "root", false, "build from the root of the repository again")
+	return cmd
+}
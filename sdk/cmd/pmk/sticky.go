@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+func newStickyCmd(opts *rootOptions) *cobra.Command {
+	return &cobra.Command{
+		Use:   "sticky [on|off]",
+		Short: "Keep each visitor on the same web replica",
+		Long: `Keep each visitor on the same web replica.
+
+Once an app is scaled to more than one web process, requests are spread
+over all of them, so an app keeping sessions in memory forgets a visitor
+whenever the next request lands elsewhere. With sticky sessions on the
+platform sets a pmk_affinity cookie on the first response and sends the
+visitor's later requests to the same replica. When that replica fails its
+health probe or a deploy replaces it, the visitor is moved to a healthy one
+and has to log in again. The cookie isn't passed on to the app. Without
+arguments the current setting is shown. Use --app or PMK_APP to pick the
+app.`,
+		Example: `  pmk sticky on
+  pmk sticky off`,
+		Args:      cobra.MaximumNArgs(1),
+		ValidArgs: []string{"on", "off"},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			owner, project, err := opts.target(nil)
+			if err != nil {
+				return err
+			}
+			c, err := opts.client()
+			if err != nil {
+				return err
+			}
+			settings, err := c.GetSettings(cmd.Context(), owner, project)
+			if err != nil {
+				return wrapAuth(err)
+			}
+
+			if len(args) == 0 {
+				if settings.StickySessions {
+					fmt.Fprintln(cmd.OutOrStdout(), "on, visitors stay on the replica they first got")
+				} else {
+					fmt.Fprintln(cmd.OutOrStdout(), "off, requests are spread over every replica")
+				}
+				return nil
+			}
+
+			switch args[0] {
+			case "on":
+				settings.StickySessions = true
+			case "off":
+				settings.StickySessions = false
+			default:
+				return fmt.Errorf("invalid setting %q, expected on or off", args[0])
+			}
+			if err := c.UpdateSettings(cmd.Context(), owner, project, *settings); err != nil {
+				return wrapAuth(err)
+			}
+			return nil
+		},
+	}
+}
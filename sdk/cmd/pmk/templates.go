@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+)
+
+func newTemplatesCmd(opts *rootOptions) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "templates",
+		Short: "List the templates new apps can start from, and register your own",
+		Long: `List the templates new apps can start from, and register your own.
+
+Start an app from one with pmk create owner/project --template NAME. The
+platform ships with go-http, go-postgres, nextjs, flask and rust-axum.
+Maintainers of an
+owner can register an app of theirs as a template, like a course handing out
+the starting code of an assignment: everyone signed in can start from it and
+gets the code of the app at that moment, never its settings or secrets.`,
+	}
+
+	list := &cobra.Command{
+		Use:   "list",
+		Short: "List the templates, the built in ones first",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c, err := opts.client()
+			if err != nil {
+				return err
+			}
+			templates, err := c.ListTemplates(cmd.Context())
+			if err != nil {
+				return wrapAuth(err)
+			}
+			w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "NAME\tDESCRIPTION")
+			for _, t := range templates {
+				fmt.Fprintf(w, "%s\t%s\n", t.Name, t.Description)
+			}
+			return w.Flush()
+		},
+	}
+
+	var description string
+	add := &cobra.Command{
+		Use:   "add NAME [owner/project]",
+		Short: "Register an app as the template owner/NAME",
+		Long: `Register an app as the template owner/NAME, under the owner of the app.
+
+Adding a name that exists points it at the app and description given. New
+apps get what the deploy branch of the app has when they start, so keep it
+at the starting code of the assignment.`,
+		Example: `  pmk templates add django-starter kelas-ppl/reference --description "Tugas 1 starter"`,
+		Args:    cobra.RangeArgs(1, 2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			owner, project, err := opts.target(args[1:])
+			if err != nil {
+				return err
+			}
+			c, err := opts.client()
+			if err != nil {
+				return err
+			}
+			template, err := c.SetTemplate(cmd.Context(), owner, args[0], project, description)
+			if err != nil {
+				return wrapAuth(err)
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "Registered %s from %s, start from it with pmk create owner/project --template %s\n",
+				template.Name, template.Project, template.Name)
+			return nil
+		},
+	}
+	add.Flags().StringVar(&description, "description", "", "what the template is for, shown in pmk templates list")
+
+	remove := &cobra.Command{
+		Use:   "remove owner/NAME",
+		Short: "Remove a template, apps started from it keep their code",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			owner, name, err := splitApp(args[0])
+			if err != nil {
+				return fmt.Errorf("template must be in the form owner/name, got %q", args[0])
+			}
+			c, err := opts.client()
+			if err != nil {
+				return err
+			}
+			return wrapAuth(c.DeleteTemplate(cmd.Context(), owner, name))
+		},
+	}
+
+	cmd.AddCommand(list, add, remove)
+	return cmd
+}
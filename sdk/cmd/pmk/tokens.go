@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	pemasak "github.com/mustafasegf/pemasak-infra/sdk"
+)
+
+func newTokensCmd(opts *rootOptions) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "tokens",
+		Short: "Manage access tokens for CI and scripts",
+		Long: `Manage access tokens for CI and scripts.
+
+A token acts as you, but only within its scope: read tokens see what a viewer
+sees, deploy tokens also build, deploy, promote canaries and roll back, admin
+tokens do everything you can. A token for one app works for nothing else.
+Export a token as PMK_TOKEN to use it with pmk, or use it as the password of
+git over https.`,
+	}
+
+	var (
+		scope  string
+		days   int
+		never  bool
+		forApp bool
+	)
+	create := &cobra.Command{
+		Use:   "create NAME",
+		Short: "Create a token, it is only shown once",
+		Example: `  pmk tokens create laptop
+  pmk tokens create github-actions --scope deploy --for-app --app kelompok-3/api --days 180`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			s := pemasak.TokenScope(scope)
+			switch s {
+			case pemasak.ScopeRead, pemasak.ScopeDeploy, pemasak.ScopeAdmin:
+			default:
+				return fmt.Errorf("unknown scope %q, expected read, deploy or admin", scope)
+			}
+			create := pemasak.CreateTokenOptions{Name: args[0], Scope: s, ExpiresInDays: days, NeverExpires: never}
+			if forApp {
+				owner, project, err := opts.target(nil)
+				if err != nil {
+					return err
+				}
+				create.Owner, create.Project = owner, project
+			}
+			c, err := opts.client()
+			if err != nil {
+				return err
+			}
+			token, err := c.CreateToken(cmd.Context(), create)
+			if err != nil {
+				return wrapAuth(err)
+			}
+			fmt.Fprintln(cmd.OutOrStdout(), token.Token)
+			fmt.Fprintln(cmd.ErrOrStderr(), "store this token now, it can't be shown again")
+			return nil
+		},
+	}
+	create.Flags().StringVar(&scope, "scope", string(pemasak.ScopeRead), "read, deploy or admin")
+	create.Flags().IntVar(&days, "days", 0, "days until the token expires (default 90, at most 365)")
+	create.Flags().BoolVar(&never, "never-expires", false, "create a token that doesn't expire")
+	create.Flags().BoolVar(&forApp, "for-app", false, "limit the token to the app from --app or PMK_APP")
+
+	cmd.AddCommand(
+		&cobra.Command{
+			Use:   "list",
+			Short: "List your tokens",
+			Args:  cobra.NoArgs,
+			RunE: func(cmd *cobra.Command, args []string) error {
+				c, err := opts.client()
+				if err != nil {
+					return err
+				}
+				tokens, err := c.ListTokens(cmd.Context())
+				if err != nil {
+					return wrapAuth(err)
+				}
+				w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+				fmt.Fprintln(w, "ID\tNAME\tSCOPE\tAPP\tTOKEN\tEXPIRES\tLAST USED")
+				for _, t := range tokens {
+					app := t.App
+					if app == "" {
+						app = "all"
+					}
+					fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s...\t%s\t%s\n", t.ID, t.Name, t.Scope, app, t.Prefix, formatTime(t.ExpiresAt, "never"), formatTime(t.LastUsedAt, "-"))
+				}
+				return w.Flush()
+			},
+		},
+		create,
+		&cobra.Command{
+			Use:   "revoke ID",
+			Short: "Revoke a token, it stops working right away",
+			Args:  cobra.ExactArgs(1),
+			RunE: func(cmd *cobra.Command, args []string) error {
+				c, err := opts.client()
+				if err != nil {
+					return err
+				}
+				if err := c.RevokeToken(cmd.Context(), args[0]); err != nil {
+					return wrapAuth(err)
+				}
+				return nil
+			},
+		},
+	)
+	return cmd
+}
+
+func formatTime(t *time.Time, unset string) string {
+	if t == nil {
+		return unset
+	}
+	return t.Local().Format(time.DateTime)
+}
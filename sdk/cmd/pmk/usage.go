@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+
+	pemasak "github.com/mustafasegf/pemasak-infra/sdk"
+)
+
+func newUsageCmd(opts *rootOptions) *cobra.Command {
+	var csv bool
+	cmd := &cobra.Command{
+		Use:   "usage [owner/project]",
+		Short: "Show the cpu, memory, egress and build minutes an app used per month",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			owner, project, err := opts.target(args)
+			if err != nil {
+				return err
+			}
+			c, err := opts.client()
+			if err != nil {
+				return err
+			}
+			if csv {
+				export, err := c.ExportAppUsage(cmd.Context(), owner, project)
+				if err != nil {
+					return wrapAuth(err)
+				}
+				defer export.Close()
+				_, err = io.Copy(cmd.OutOrStdout(), export)
+				return err
+			}
+			usage, err := c.AppUsage(cmd.Context(), owner, project)
+			if err != nil {
+				return wrapAuth(err)
+			}
+			return printUsage(cmd, usage)
+		},
+	}
+	cmd.Flags().BoolVar(&csv, "csv", false, "print the usage as csv")
+	return cmd
+}
+
+func printUsage(cmd *cobra.Command, usage []pemasak.AppUsage) error {
+	w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "MONTH\tAPP\tCPU HOURS\tMEMORY GIB-HOURS\tEGRESS\tBUILD MINUTES")
+	for _, u := range usage {
+		app := u.Owner
+		if u.Project != "" {
+			app += "/" + u.Project
+		}
+		month := u.Month
+		if len(month) >= 7 {
+			month = month[:7]
+		}
+		fmt.Fprintf(w, "%s\t%s\t%.1f\t%.1f\t%s\t%.0f\n",
+			month, app, u.CPUSeconds/3600, u.MemoryGiBHours, formatSize(u.EgressBytes), u.BuildMinutes)
+	}
+	return w.Flush()
+}
+
+func newAdminUsageCmd(opts *rootOptions) *cobra.Command {
+	var (
+		csv    bool
+		filter pemasak.UsageFilter
+	)
+	cmd := &cobra.Command{
+		Use:   "usage",
+		Short: "Report what every app used in a month, the most cpu first",
+		Example: `  pmk admin usage --month 2026-10 --by-owner
+  pmk admin usage --month 2026-10 --csv > usage.csv`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c, err := opts.client()
+			if err != nil {
+				return err
+			}
+			if csv {
+				export, err := c.ExportUsageReport(cmd.Context(), filter)
+				if err != nil {
+					return wrapAuth(err)
+				}
+				defer export.Close()
+				_, err = io.Copy(cmd.OutOrStdout(), export)
+				return err
+			}
+			usage, err := c.UsageReport(cmd.Context(), filter)
+			if err != nil {
+				return wrapAuth(err)
+			}
+			return printUsage(cmd, usage)
+		},
+	}
+	cmd.Flags().BoolVar(&csv, "csv", false, "print the report as csv")
+	cmd.Flags().StringVar(&filter.Month, "month", "", "month like 2026-10 (default this month)")
+	cmd.Flags().StringVar(&filter.Owner, "owner", "", "only apps of this owner")
+	cmd.Flags().BoolVar(&filter.ByOwner, "by-owner", false, "add up the apps of each owner")
+	return cmd
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+func newVolumesCmd(opts *rootOptions) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "volumes",
+		Short: "Manage the persistent disk of an app",
+		Long: `Manage the persistent disk of an app.
+
+A volume is mounted into every container of the app and keeps its files
+across deploys, for SQLite databases or uploads. Deploys fail while a volume
+holds more than its size. Use --app or PMK_APP to pick the app.`,
+	}
+
+	var size int
+	attach := &cobra.Command{
+		Use:   "attach NAME PATH",
+		Short: "Mount a volume at PATH, or change an existing one",
+		Example: `  pmk volumes attach data /data --size 512
+  pmk volumes attach data /data --size 1024`,
+		Args: cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			owner, project, err := opts.target(nil)
+			if err != nil {
+				return err
+			}
+			c, err := opts.client()
+			if err != nil {
+				return err
+			}
+			volume, err := c.AttachVolume(cmd.Context(), owner, project, args[0], args[1], size)
+			if err != nil {
+				return wrapAuth(err)
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "%s is mounted at %s with %d MiB\n", volume.Name, volume.MountPath, volume.SizeMB)
+			return nil
+		},
+	}
+	attach.Flags().IntVar(&size, "size", 256, "size in MiB")
+
+	var output string
+	download := &cobra.Command{
+		Use:   "download NAME [PATH]",
+		Short: "Save a tar archive of a volume or a path inside it",
+		Example: `  pmk volumes download data -o data.tar
+  pmk volumes download data uploads | tar -x`,
+		Args: cobra.RangeArgs(1, 2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			owner, project, err := opts.target(nil)
+			if err != nil {
+				return err
+			}
+			c, err := opts.client()
+			if err != nil {
+				return err
+			}
+			path := ""
+			if len(args) > 1 {
+				path = args[1]
+			}
+			archive, err := c.DownloadVolume(cmd.Context(), owner, project, args[0], path)
+			if err != nil {
+				return wrapAuth(err)
+			}
+			defer archive.Close()
+
+			out := cmd.OutOrStdout()
+			if output != "" {
+				f, err := os.Create(output)
+				if err != nil {
+					return err
+				}
+				defer f.Close()
+				out = f
+			}
+			_, err = io.Copy(out, archive)
+			return err
+		},
+	}
+	download.Flags().StringVarP(&output, "output", "o", "", "write the archive to this file instead of stdout")
+
+	cmd.AddCommand(
+		&cobra.Command{
+			Use:   "list",
+			Short: "List the volumes with their usage",
+			Args:  cobra.NoArgs,
+			RunE: func(cmd *cobra.Command, args []string) error {
+				owner, project, err := opts.target(nil)
+				if err != nil {
+					return err
+				}
+				c, err := opts.client()
+				if err != nil {
+					return err
+				}
+				volumes, quota, err := c.ListVolumes(cmd.Context(), owner, project)
+				if err != nil {
+					return wrapAuth(err)
+				}
+				w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+				fmt.Fprintln(w, "NAME\tPATH\tUSED\tSIZE\tCREATED")
+				reserved := 0
+				for _, v := range volumes {
+					used := "-"
+					if v.UsedBytes != nil {
+						used = formatSize(*v.UsedBytes)
+					}
+					reserved += v.SizeMB
+					fmt.Fprintf(w, "%s\t%s\t%s\t%d MiB\t%s\n", v.Name, v.MountPath, used, v.SizeMB, v.CreatedAt.Local().Format(time.DateTime))
+				}
+				if err := w.Flush(); err != nil {
+					return err
+				}
+				fmt.Fprintf(cmd.OutOrStdout(), "%d of %d MiB reserved\n", reserved, quota)
+				return nil
+			},
+		},
+		attach,
+		&cobra.Command{
+			Use:   "detach NAME",
+			Short: "Unmount a volume and delete its files",
+			Args:  cobra.ExactArgs(1),
+			RunE: func(cmd *cobra.Command, args []string) error {
+				owner, project, err := opts.target(nil)
+				if err != nil {
+					return err
+				}
+				c, err := opts.client()
+				if err != nil {
+					return err
+				}
+				return wrapAuth(c.DetachVolume(cmd.Context(), owner, project, args[0]))
+			},
+		},
+		&cobra.Command{
+			Use:   "files NAME [PATH]",
+			Short: "List the files in a volume",
+			Args:  cobra.RangeArgs(1, 2),
+			RunE: func(cmd *cobra.Command, args []string) error {
+				owner, project, err := opts.target(nil)
+				if err != nil {
+					return err
+				}
+				c, err := opts.client()
+				if err != nil {
+					return err
+				}
+				path := ""
+				if len(args) > 1 {
+					path = args[1]
+				}
+				entries, err := c.BrowseVolume(cmd.Context(), owner, project, args[0], path)
+				if err != nil {
+					return wrapAuth(err)
+				}
+				w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+				fmt.Fprintln(w, "NAME\tKIND\tSIZE\tMODIFIED")
+				for _, e := range entries {
+					modified := "-"
+					if e.Modified != nil {
+						modified = e.Modified.Local().Format(time.DateTime)
+					}
+					fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", e.Name, e.Kind, formatSize(e.Size), modified)
+				}
+				return w.Flush()
+			},
+		},
+		download,
+	)
+	return cmd
+}
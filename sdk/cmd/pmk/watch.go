@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	pemasak "github.com/mustafasegf/pemasak-infra/sdk"
+)
+
+func newWatchCmd(opts *rootOptions) *cobra.Command {
+	var topics []string
+	cmd := &cobra.Command{
+		Use:   "watch [owner/project]",
+		Short: "Print what happens to an app as it happens",
+		Long: `Print what happens to an app as it happens, until interrupted: builds
+being queued, starting and finishing, what happened to its containers, like
+deploys, crashes and scaling, and with --events build_log,log the output of
+builds and containers.
+
+The platform pushes every update, nothing is polled. Lines saying
+"missed updates" mean the connection dropped or fell behind, check
+pmk builds and pmk events for what happened in between.`,
+		Example: `  pmk watch kelompok-3/api
+  pmk watch --events build,build_log`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			owner, project, err := opts.target(args)
+			if err != nil {
+				return err
+			}
+			c, err := opts.client()
+			if err != nil {
+				return err
+			}
+
+			events := []pemasak.LiveTopic{pemasak.LiveBuild, pemasak.LiveContainer}
+			if len(topics) > 0 {
+				events = nil
+				for _, topic := range topics {
+					events = append(events, pemasak.LiveTopic(topic))
+				}
+			}
+
+			out := cmd.OutOrStdout()
+			err = c.Watch(cmd.Context(), owner, project, events, func(u pemasak.LiveUpdate) error {
+				now := time.Now().Format(time.DateTime)
+				switch u.Topic {
+				case pemasak.LiveBuild:
+					fmt.Fprintf(out, "%s  build      %s %s\n", now, u.BuildID, u.Status)
+				case pemasak.LiveBuildLog:
+					fmt.Fprint(out, u.Log)
+				case pemasak.LiveContainer:
+					fmt.Fprintf(out, "%s  %-10s %s\n", now, u.Kind, u.Message)
+				case pemasak.LiveLog:
+					for _, line := range u.Lines {
+						fmt.Fprintf(out, "%s  %s[%s] %s\n", line.Timestamp.Local().Format(time.DateTime), line.Process, line.Container, line.Message)
+					}
+				case pemasak.LiveResync:
+					fmt.Fprintf(out, "%s  missed updates\n", now)
+				}
+				return nil
+			})
+			if cmd.Context().Err() != nil {
+				return nil
+			}
+			return wrapAuth(err)
+		},
+	}
+	cmd.Flags().StringSliceVar(&topics, "events", nil, "only these updates: build, build_log, container, log (default build,container)")
+	return cmd
+}
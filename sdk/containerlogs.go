@@ -0,0 +1,131 @@
+package pemasak
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// ContainerLogLine is a line a web or worker container of an app wrote. The
+// platform keeps them after the container is gone, so the output of a
+// container that crashed can still be read.
+type ContainerLogLine struct {
+	ID         int64     `json:"id"`
+	RecordedAt time.Time `json:"recorded_at"`
+	Container  string    `json:"container"`
+	// Process is web or the Procfile entry of a worker.
+	Process string `json:"process"`
+	// Stream is stdout or stderr.
+	Stream string `json:"stream"`
+	// Level is debug, info, warn or error for lines logged as JSON or
+	// logfmt, empty for the others.
+	Level   string `json:"level"`
+	Message string `json:"message"`
+}
+
+// ContainerLogFilter narrows down container log lines. The zero value returns
+// the latest 100.
+type ContainerLogFilter struct {
+	// Since and Until are RFC 3339 times or durations back from now like
+	// "30m", "2h" or "7d".
+	Since string
+	Until string
+	// Grep is a regular expression the lines match.
+	Grep       string
+	IgnoreCase bool
+	Process    string
+	Stream     string
+	// After only returns lines after the one with this ID, for following
+	// the log.
+	After int64
+	// Before only returns lines before the one with this ID, the ID of the
+	// first line of a page goes back to the page before it.
+	Before int64
+	// Limit is at most 1000.
+	Limit int
+}
+
+func (f ContainerLogFilter) query() string {
+	q := url.Values{}
+	for k, v := range map[string]string{
+		"since":   f.Since,
+		"until":   f.Until,
+		"grep":    f.Grep,
+		"process": f.Process,
+		"stream":  f.Stream,
+	} {
+		if v != "" {
+			q.Set(k, v)
+		}
+	}
+	if f.IgnoreCase {
+		q.Set("ignore_case", "true")
+	}
+	if f.After > 0 {
+		q.Set("after", strconv.FormatInt(f.After, 10))
+	}
+	if f.Before > 0 {
+		q.Set("before", strconv.FormatInt(f.Before, 10))
+	}
+	if f.Limit > 0 {
+		q.Set("limit", strconv.Itoa(f.Limit))
+	}
+	if len(q) == 0 {
+		return ""
+	}
+	return "?" + q.Encode()
+}
+
+// ContainerLogSettings are for how long and how much of the output of its
+// containers an app keeps.
+type ContainerLogSettings struct {
+	// Retention is how many days lines are kept, nil for the default of the
+	// platform.
+	Retention *int `json:"retention"`
+	// Size is how many MiB of lines are kept, the oldest go first past it.
+	// Nil for the default of the platform.
+	Size *int `json:"size"`
+	// The defaults and maximums are set by the platform, they are ignored by
+	// SetContainerLogSettings.
+	DefaultRetention int `json:"default_retention,omitempty"`
+	MaxRetention     int `json:"max_retention,omitempty"`
+	DefaultSize      int `json:"default_size,omitempty"`
+	MaxSize          int `json:"max_size,omitempty"`
+}
+
+// SearchLogs returns the latest lines the containers of an app wrote that
+// match filter, oldest first. Unlike Logs it reads what the platform kept,
+// so it includes containers that crashed or were replaced by a deploy.
+func (c *Client) SearchLogs(ctx context.Context, owner, project string, filter ContainerLogFilter) ([]ContainerLogLine, error) {
+	var res struct {
+		Lines []ContainerLogLine `json:"lines"`
+	}
+	err := c.do(ctx, request{method: http.MethodGet, path: projectPath(owner, project, "logs", "search") + filter.query(), idempotent: true}, &res)
+	if err != nil {
+		return nil, err
+	}
+	return res.Lines, nil
+}
+
+// GetContainerLogSettings returns the container log settings of an app.
+func (c *Client) GetContainerLogSettings(ctx context.Context, owner, project string) (*ContainerLogSettings, error) {
+	var res ContainerLogSettings
+	err := c.do(ctx, request{method: http.MethodGet, path: projectPath(owner, project, "logs", "settings"), idempotent: true}, &res)
+	if err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// SetContainerLogSettings changes for how long and how much of its container
+// output an app keeps.
+func (c *Client) SetContainerLogSettings(ctx context.Context, owner, project string, settings ContainerLogSettings) error {
+	return c.do(ctx, request{
+		method:     http.MethodPost,
+		path:       projectPath(owner, project, "logs", "settings"),
+		body:       settings,
+		idempotent: true,
+	}, nil)
+}
@@ -0,0 +1,63 @@
+package pemasak
+
+import (
+	"context"
+	"net/http"
+)
+
+// CORS is which other sites may call an app from a browser. While Origins
+// has one, the platform answers preflight requests itself and sets the CORS
+// headers on every response, in place of the ones the app sends.
+type CORS struct {
+	// Origins are like https://web.example.com, https://*.example.com for
+	// its subdomains, or * for any site. Empty leaves CORS to the app.
+	Origins []string `json:"origins"`
+	// Methods allowed besides simple requests. Empty allows GET, HEAD and
+	// POST.
+	Methods []string `json:"methods"`
+	// Headers the browser may send besides the safelisted ones, like
+	// Authorization or Content-Type: application/json. * allows any.
+	Headers []string `json:"headers"`
+	// Credentials lets the browser send cookies and logins along. The
+	// origin is echoed back then, also for *.
+	Credentials bool `json:"credentials"`
+	// MaxAge is how many seconds browsers keep a preflight, zero leaves it
+	// to them.
+	MaxAge int `json:"max_age,omitempty"`
+}
+
+// GetCORS returns the CORS policy of an app.
+func (c *Client) GetCORS(ctx context.Context, owner, project string) (*CORS, error) {
+	var res struct {
+		CORS
+		MaxAge *int `json:"max_age"`
+	}
+	err := c.do(ctx, request{method: http.MethodGet, path: projectPath(owner, project, "cors"), idempotent: true}, &res)
+	if err != nil {
+		return nil, err
+	}
+	if res.MaxAge != nil {
+		res.CORS.MaxAge = *res.MaxAge
+	}
+	return &res.CORS, nil
+}
+
+// SetCORS replaces the CORS policy of an app. The platform stores origins
+// and headers in lower case and methods in upper case.
+func (c *Client) SetCORS(ctx context.Context, owner, project string, cors CORS) error {
+	if cors.Origins == nil {
+		cors.Origins = []string{}
+	}
+	if cors.Methods == nil {
+		cors.Methods = []string{}
+	}
+	if cors.Headers == nil {
+		cors.Headers = []string{}
+	}
+	return c.do(ctx, request{
+		method:     http.MethodPost,
+		path:       projectPath(owner, project, "cors"),
+		body:       cors,
+		idempotent: true,
+	}, nil)
+}
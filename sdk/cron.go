@@ -0,0 +1,121 @@
+package pemasak
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// RunStatus is the state of a cron job run.
+type RunStatus string
+
+const (
+	RunRunning    RunStatus = "RUNNING"
+	RunSuccessful RunStatus = "SUCCESSFUL"
+	RunFailed     RunStatus = "FAILED"
+)
+
+// CronJob runs a command on a schedule in a one-off container of the live
+// release. A run is skipped while the previous one is still going.
+type CronJob struct {
+	ID string `json:"id"`
+	// Schedule is a five field cron expression, evaluated in UTC.
+	Schedule  string    `json:"schedule"`
+	Command   []string  `json:"command"`
+	NextRunAt time.Time `json:"next_run_at"`
+	CreatedAt time.Time `json:"created_at"`
+	// LastStatus and LastExitCode describe the latest run, they are nil before
+	// the job ran once.
+	LastStatus   *RunStatus `json:"last_status"`
+	LastExitCode *int       `json:"last_exit_code"`
+}
+
+// CronRun is one run of a cron job.
+type CronRun struct {
+	ID     string    `json:"id"`
+	Status RunStatus `json:"status"`
+	// ExitCode is nil while running and when the container was killed or
+	// never started.
+	ExitCode   *int       `json:"exit_code"`
+	StartedAt  time.Time  `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at"`
+}
+
+// CronRunDetail is a run together with the tail of its output.
+type CronRunDetail struct {
+	CronRun
+	Logs string `json:"logs"`
+}
+
+// ListCronJobs returns the cron jobs of a project.
+func (c *Client) ListCronJobs(ctx context.Context, owner, project string) ([]CronJob, error) {
+	var res struct {
+		Data []CronJob `json:"data"`
+	}
+	err := c.do(ctx, request{method: http.MethodGet, path: projectPath(owner, project, "cron"), idempotent: true}, &res)
+	if err != nil {
+		return nil, err
+	}
+	return res.Data, nil
+}
+
+// AddCronJob schedules command, which is run without a shell. It returns the
+// id of the new job and when it runs first.
+func (c *Client) AddCronJob(ctx context.Context, owner, project, schedule string, command []string) (id string, next time.Time, err error) {
+	var res struct {
+		ID        string    `json:"id"`
+		NextRunAt time.Time `json:"next_run_at"`
+	}
+	err = c.do(ctx, request{
+		method: http.MethodPost,
+		path:   projectPath(owner, project, "cron"),
+		body: struct {
+			Schedule string   `json:"schedule"`
+			Command  []string `json:"command"`
+		}{schedule, command},
+	}, &res)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return res.ID, res.NextRunAt, nil
+}
+
+// DeleteCronJob removes a cron job and its run history.
+func (c *Client) DeleteCronJob(ctx context.Context, owner, project, id string) error {
+	return c.do(ctx, request{
+		method:     http.MethodPost,
+		path:       projectPath(owner, project, "cron", url.PathEscape(id), "delete"),
+		idempotent: true,
+	}, nil)
+}
+
+// ListCronRuns returns the recent runs of a cron job, newest first.
+func (c *Client) ListCronRuns(ctx context.Context, owner, project, jobID string) ([]CronRun, error) {
+	var res struct {
+		Data []CronRun `json:"data"`
+	}
+	err := c.do(ctx, request{
+		method:     http.MethodGet,
+		path:       projectPath(owner, project, "cron", url.PathEscape(jobID), "runs"),
+		idempotent: true,
+	}, &res)
+	if err != nil {
+		return nil, err
+	}
+	return res.Data, nil
+}
+
+// GetCronRun returns a run of a cron job with its output.
+func (c *Client) GetCronRun(ctx context.Context, owner, project, jobID, runID string) (*CronRunDetail, error) {
+	var res CronRunDetail
+	err := c.do(ctx, request{
+		method:     http.MethodGet,
+		path:       projectPath(owner, project, "cron", url.PathEscape(jobID), "runs", url.PathEscape(runID)),
+		idempotent: true,
+	}, &res)
+	if err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
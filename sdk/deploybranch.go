@@ -0,0 +1,50 @@
+package pemasak
+
+import (
+	"context"
+	"net/http"
+)
+
+// DeployBranch is which branch of an app deploys when it is pushed, and
+// whether those pushes go live right away.
+type DeployBranch struct {
+	// Branch pushes deploy from, empty for the one HEAD of the repository
+	// points at.
+	Branch string `json:"branch,omitempty"`
+	// Deploys is the branch pushes deploy from now, empty before the first
+	// push. Ignored when setting.
+	Deploys string `json:"deploys,omitempty"`
+	// Promote makes pushes to the branch only build. The build waits as a
+	// canary on no requests until it is promoted with PromoteCanary.
+	Promote bool `json:"promote"`
+}
+
+// GetDeployBranch returns which branch of an app deploys.
+func (c *Client) GetDeployBranch(ctx context.Context, owner, project string) (*DeployBranch, error) {
+	var res DeployBranch
+	err := c.do(ctx, request{method: http.MethodGet, path: projectPath(owner, project, "deploy-branch"), idempotent: true}, &res)
+	if err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// SetDeployBranch changes which branch of an app deploys. The next push to
+// the branch deploys it.
+func (c *Client) SetDeployBranch(ctx context.Context, owner, project string, deploy DeployBranch) error {
+	body := struct {
+		Branch  *string `json:"branch"`
+		Promote bool    `json:"promote"`
+	}{
+		Promote: deploy.Promote,
+	}
+	if deploy.Branch != "" {
+		body.Branch = &deploy.Branch
+	}
+	return c.do(ctx, request{
+		method:     http.MethodPost,
+		path:       projectPath(owner, project, "deploy-branch"),
+		body:       body,
+		idempotent: true,
+	}, nil)
+}
@@ -0,0 +1,97 @@
+package pemasak
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// DeployKey is an SSH key that fetches, and when it isn't read-only pushes,
+// the git remote of one project without the credentials of a user. Only the
+// public half is kept by the server.
+type DeployKey struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	// PublicKey is in OpenSSH format, like ssh-ed25519 AAAA...
+	PublicKey string `json:"public_key"`
+	// Fingerprint is the SHA256 one ssh-keygen -l shows, without the prefix.
+	Fingerprint string     `json:"fingerprint"`
+	ReadOnly    bool       `json:"read_only"`
+	CreatedAt   time.Time  `json:"created_at"`
+	RotatedAt   *time.Time `json:"rotated_at"`
+	LastUsedAt  *time.Time `json:"last_used_at"`
+}
+
+// NewDeployKey is a deploy key just created or rotated, with its private key.
+// The private key can't be shown again.
+type NewDeployKey struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	PublicKey   string `json:"public_key"`
+	Fingerprint string `json:"fingerprint"`
+	ReadOnly    bool   `json:"read_only"`
+	// PrivateKey is in OpenSSH format, what goes in ~/.ssh/id_ed25519.
+	PrivateKey string `json:"private_key"`
+	// Remote is the SSH URL to clone and push with the key, empty when the
+	// server doesn't serve git over SSH.
+	Remote    string    `json:"remote"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ListDeployKeys returns the deploy keys of a project and the SSH remote they
+// work with, empty when the server doesn't serve git over SSH.
+func (c *Client) ListDeployKeys(ctx context.Context, owner, project string) ([]DeployKey, string, error) {
+	var res struct {
+		Remote *string     `json:"remote"`
+		Data   []DeployKey `json:"data"`
+	}
+	err := c.do(ctx, request{method: http.MethodGet, path: projectPath(owner, project, "deploy-keys"), idempotent: true}, &res)
+	if err != nil {
+		return nil, "", err
+	}
+	remote := ""
+	if res.Remote != nil {
+		remote = *res.Remote
+	}
+	return res.Data, remote, nil
+}
+
+// CreateDeployKey makes an ed25519 deploy key for a project. Keys only fetch
+// unless write is true, then they push deploys too. Pushes with a key meet
+// the push policy of the project like any other.
+func (c *Client) CreateDeployKey(ctx context.Context, owner, project, name string, write bool) (*NewDeployKey, error) {
+	var res NewDeployKey
+	err := c.do(ctx, request{
+		method: http.MethodPost,
+		path:   projectPath(owner, project, "deploy-keys"),
+		body:   map[string]any{"name": name, "write": write},
+	}, &res)
+	if err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// RotateDeployKey replaces a deploy key with a new one of the same name and
+// access. The old key stops working right away.
+func (c *Client) RotateDeployKey(ctx context.Context, owner, project, id string) (*NewDeployKey, error) {
+	var res NewDeployKey
+	err := c.do(ctx, request{
+		method: http.MethodPost,
+		path:   projectPath(owner, project, "deploy-keys", url.PathEscape(id), "rotate"),
+	}, &res)
+	if err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// RevokeDeployKey deletes a deploy key, the server refuses it from the next
+// connection on.
+func (c *Client) RevokeDeployKey(ctx context.Context, owner, project, id string) error {
+	return c.do(ctx, request{
+		method: http.MethodPost,
+		path:   projectPath(owner, project, "deploy-keys", url.PathEscape(id), "delete"),
+	}, nil)
+}
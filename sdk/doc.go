@@ -0,0 +1,17 @@
+// Package pemasak is a client for the pemasak-infra HTTP API.
+//
+// A Client keeps the session cookie returned by Login, so the usual flow is
+//
+//	c, err := pemasak.New("https://pemasak.example.com")
+//	if err != nil {
+//		return err
+//	}
+//	if err := c.Login(ctx, "user", "password"); err != nil {
+//		return err
+//	}
+//	builds, err := c.ListBuilds(ctx, "owner", "project")
+//
+// Every call takes a context. Idempotent requests (GET, and the env and
+// settings writes) are retried with exponential backoff on network errors,
+// 429 and 5xx responses.
+package pemasak
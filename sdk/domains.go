@@ -0,0 +1,62 @@
+package pemasak
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// Domain is a custom domain attached to a project.
+type Domain struct {
+	Name string `json:"name"`
+	// Verified is true once the domain pointed at the platform. Unverified
+	// domains are not served and get no certificate.
+	Verified  bool      `json:"verified"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ListDomains returns the custom domains of a project together with the
+// hostname they have to point at with a CNAME record.
+func (c *Client) ListDomains(ctx context.Context, owner, project string) (domains []Domain, target string, err error) {
+	var res struct {
+		Data   []Domain `json:"data"`
+		Target string   `json:"target"`
+	}
+	err = c.do(ctx, request{method: http.MethodGet, path: projectPath(owner, project, "domains"), idempotent: true}, &res)
+	if err != nil {
+		return nil, "", err
+	}
+	return res.Data, res.Target, nil
+}
+
+// AddDomain attaches a custom domain to a project, or re-checks its DNS when
+// it is already attached. The returned domain is verified when its DNS points
+// at the platform; otherwise create a CNAME record to target and call AddDomain
+// again.
+func (c *Client) AddDomain(ctx context.Context, owner, project, name string) (domain Domain, target string, err error) {
+	var res struct {
+		Name     string `json:"name"`
+		Verified bool   `json:"verified"`
+		Target   string `json:"target"`
+	}
+	err = c.do(ctx, request{
+		method:     http.MethodPost,
+		path:       projectPath(owner, project, "domains"),
+		body:       map[string]string{"name": name},
+		idempotent: true,
+	}, &res)
+	if err != nil {
+		return Domain{}, "", err
+	}
+	return Domain{Name: res.Name, Verified: res.Verified}, res.Target, nil
+}
+
+// RemoveDomain detaches a custom domain from a project.
+func (c *Client) RemoveDomain(ctx context.Context, owner, project, name string) error {
+	return c.do(ctx, request{
+		method:     http.MethodPost,
+		path:       projectPath(owner, project, "domains", "delete"),
+		body:       map[string]string{"name": name},
+		idempotent: true,
+	}, nil)
+}
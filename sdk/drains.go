@@ -0,0 +1,56 @@
+package pemasak
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// LogDrain is an external sink the output of a project's web and worker
+// containers is forwarded to. The scheme of its URL picks the protocol:
+// http(s):// receives JSON arrays of lines, loki+http(s):// the Loki push
+// API, syslog:// RFC 5424 over TCP and syslog+udp:// over UDP.
+type LogDrain struct {
+	ID string `json:"id"`
+	// URL has its password masked.
+	URL       string    `json:"url"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ListLogDrains returns the log drains of a project.
+func (c *Client) ListLogDrains(ctx context.Context, owner, project string) ([]LogDrain, error) {
+	var res struct {
+		Data []LogDrain `json:"data"`
+	}
+	err := c.do(ctx, request{method: http.MethodGet, path: projectPath(owner, project, "drains"), idempotent: true}, &res)
+	if err != nil {
+		return nil, err
+	}
+	return res.Data, nil
+}
+
+// AddLogDrain starts forwarding the logs of a project to drainURL. Credentials
+// in http and Loki URLs are sent as basic auth. Lines are batched and retried
+// with backoff; when a drain can't keep up, lines are dropped and a notice
+// about how many is sent instead.
+func (c *Client) AddLogDrain(ctx context.Context, owner, project, drainURL string) (*LogDrain, error) {
+	var res LogDrain
+	err := c.do(ctx, request{
+		method: http.MethodPost,
+		path:   projectPath(owner, project, "drains"),
+		body:   map[string]string{"url": drainURL},
+	}, &res)
+	if err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// RemoveLogDrain stops forwarding logs to a drain.
+func (c *Client) RemoveLogDrain(ctx context.Context, owner, project, id string) error {
+	return c.do(ctx, request{
+		method: http.MethodPost,
+		path:   projectPath(owner, project, "drains", url.PathEscape(id), "delete"),
+	}, nil)
+}
@@ -0,0 +1,61 @@
+package pemasak
+
+import (
+	"context"
+	"net/http"
+)
+
+// Egress policies, where the containers of an app may connect to besides its
+// addons, services and the other containers on its networks.
+const (
+	EgressAllow = "allow"
+	// EgressCampus only allows the campus ranges of the platform.
+	EgressCampus = "campus"
+	// EgressDeny allows nothing but the allowlist.
+	EgressDeny = "deny"
+)
+
+// Egress is where the containers of an app may connect to.
+type Egress struct {
+	// Policy is empty for the default of the platform.
+	Policy string `json:"policy,omitempty"`
+	// Allow are ranges like 10.0.0.0/8, addresses or hostnames the containers
+	// reach besides what the policy allows.
+	Allow []string `json:"allow"`
+	// Effective is the policy the containers run with.
+	Effective string `json:"effective,omitempty"`
+	Default   string `json:"default,omitempty"`
+	// Campus are the ranges a campus only app reaches.
+	Campus []string `json:"campus,omitempty"`
+}
+
+// GetEgress returns the egress policy of an app.
+func (c *Client) GetEgress(ctx context.Context, owner, project string) (*Egress, error) {
+	var res Egress
+	err := c.do(ctx, request{method: http.MethodGet, path: projectPath(owner, project, "egress"), idempotent: true}, &res)
+	if err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// SetEgress replaces the egress policy of an app and its allowlist, at most
+// 100 entries. The rules apply to the running containers right away.
+func (c *Client) SetEgress(ctx context.Context, owner, project, policy string, allow []string) error {
+	if allow == nil {
+		allow = []string{}
+	}
+	body := struct {
+		Policy *string  `json:"policy"`
+		Allow  []string `json:"allow"`
+	}{Allow: allow}
+	if policy != "" {
+		body.Policy = &policy
+	}
+	return c.do(ctx, request{
+		method:     http.MethodPost,
+		path:       projectPath(owner, project, "egress"),
+		body:       body,
+		idempotent: true,
+	}, nil)
+}
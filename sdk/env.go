@@ -0,0 +1,93 @@
+package pemasak
+
+import (
+	"context"
+	"net/http"
+)
+
+// Env returns the plain environment variables of a project, secrets are listed
+// by Secrets. Changes to either are rolled out as a new release of the running
+// app.
+func (c *Client) Env(ctx context.Context, owner, project string) (map[string]string, error) {
+	var res struct {
+		Env map[string]string `json:"env"`
+	}
+	err := c.do(ctx, request{method: http.MethodGet, path: projectPath(owner, project, "env"), idempotent: true}, &res)
+	if err != nil {
+		return nil, err
+	}
+	if res.Env == nil {
+		res.Env = map[string]string{}
+	}
+	return res.Env, nil
+}
+
+// Secrets returns the names of the secrets of a project. Their values can't
+// be read back.
+func (c *Client) Secrets(ctx context.Context, owner, project string) ([]string, error) {
+	var res struct {
+		Secrets []string `json:"secrets"`
+	}
+	err := c.do(ctx, request{method: http.MethodGet, path: projectPath(owner, project, "env"), idempotent: true}, &res)
+	if err != nil {
+		return nil, err
+	}
+	return res.Secrets, nil
+}
+
+// UncopiedSecrets returns the names of the secrets of a project that are left
+// out when it is cloned, see SetUncopiedSecret.
+func (c *Client) UncopiedSecrets(ctx context.Context, owner, project string) ([]string, error) {
+	var res struct {
+		UncopiedSecrets []string `json:"uncopied_secrets"`
+	}
+	err := c.do(ctx, request{method: http.MethodGet, path: projectPath(owner, project, "env"), idempotent: true}, &res)
+	if err != nil {
+		return nil, err
+	}
+	return res.UncopiedSecrets, nil
+}
+
+// SetEnv creates or replaces a single environment variable. A secret with the
+// same name is removed.
+func (c *Client) SetEnv(ctx context.Context, owner, project, key, value string) error {
+	return c.setEnv(ctx, owner, project, key, value, false, false)
+}
+
+// SetSecret creates or replaces a single secret. It is stored encrypted and
+// only reaches the app as an environment variable. A plain variable with the
+// same name is removed.
+func (c *Client) SetSecret(ctx context.Context, owner, project, key, value string) error {
+	return c.setEnv(ctx, owner, project, key, value, true, false)
+}
+
+// SetUncopiedSecret is SetSecret for a secret that is left out when the
+// project is cloned, like the key of a payment provider that clones should
+// bring their own of.
+func (c *Client) SetUncopiedSecret(ctx context.Context, owner, project, key, value string) error {
+	return c.setEnv(ctx, owner, project, key, value, true, true)
+}
+
+func (c *Client) setEnv(ctx context.Context, owner, project, key, value string, secret, noCopy bool) error {
+	return c.do(ctx, request{
+		method: http.MethodPost,
+		path:   projectPath(owner, project, "env"),
+		body: struct {
+			Key    string `json:"key"`
+			Value  string `json:"value"`
+			Secret bool   `json:"secret"`
+			NoCopy bool   `json:"no_copy"`
+		}{key, value, secret, noCopy},
+		idempotent: true,
+	}, nil)
+}
+
+// DeleteEnv removes a single environment variable or secret.
+func (c *Client) DeleteEnv(ctx context.Context, owner, project, key string) error {
+	return c.do(ctx, request{
+		method:     http.MethodPost,
+		path:       projectPath(owner, project, "env", "delete"),
+		body:       map[string]string{"key": key},
+		idempotent: true,
+	}, nil)
+}
@@ -0,0 +1,126 @@
+package pemasak
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// EnvGroup is a set of variables an owner shares between its apps, like an
+// API key every app of a team needs.
+type EnvGroup struct {
+	Name string            `json:"name"`
+	Env  map[string]string `json:"env"`
+	// Secrets are only the names, their values can't be read back.
+	Secrets []string `json:"secrets"`
+	// Apps are the apps of the owner the group is attached to.
+	Apps      []string  `json:"apps"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// AttachedEnvGroup is an env group an app starts with.
+type AttachedEnvGroup struct {
+	Name string `json:"name"`
+	// Env and Secrets are the names the group sets.
+	Env     []string `json:"env"`
+	Secrets []string `json:"secrets"`
+	// Overridden are the names the app sets itself, the value of the app wins.
+	Overridden []string  `json:"overridden"`
+	AttachedAt time.Time `json:"attached_at"`
+}
+
+// EnvGroups returns the env groups of an owner. Every member can list them.
+func (c *Client) EnvGroups(ctx context.Context, owner string) ([]EnvGroup, error) {
+	var res struct {
+		Data []EnvGroup `json:"data"`
+	}
+	err := c.do(ctx, request{method: http.MethodGet, path: ownerPath(owner, "env-groups"), idempotent: true}, &res)
+	if err != nil {
+		return nil, err
+	}
+	return res.Data, nil
+}
+
+// CreateEnvGroup creates an empty env group of an owner. Maintainers of the
+// owner manage its groups.
+func (c *Client) CreateEnvGroup(ctx context.Context, owner, name string) (*EnvGroup, error) {
+	var res EnvGroup
+	err := c.do(ctx, request{
+		method: http.MethodPost,
+		path:   ownerPath(owner, "env-groups"),
+		body:   map[string]string{"name": name},
+	}, &res)
+	if err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// SetEnvGroupVar creates or replaces a variable of an env group, or a secret
+// when secret is set. Attached apps aren't restarted, they get the change
+// with their next release.
+func (c *Client) SetEnvGroupVar(ctx context.Context, owner, group, key, value string, secret bool) error {
+	return c.do(ctx, request{
+		method: http.MethodPost,
+		path:   ownerPath(owner, "env-groups", url.PathEscape(group), "env"),
+		body: struct {
+			Key    string `json:"key"`
+			Value  string `json:"value"`
+			Secret bool   `json:"secret"`
+		}{key, value, secret},
+		idempotent: true,
+	}, nil)
+}
+
+// DeleteEnvGroupVar removes a variable or secret of an env group.
+func (c *Client) DeleteEnvGroupVar(ctx context.Context, owner, group, key string) error {
+	return c.do(ctx, request{
+		method:     http.MethodPost,
+		path:       ownerPath(owner, "env-groups", url.PathEscape(group), "env", "delete"),
+		body:       map[string]string{"key": key},
+		idempotent: true,
+	}, nil)
+}
+
+// DeleteEnvGroup deletes an env group and detaches it from its apps.
+func (c *Client) DeleteEnvGroup(ctx context.Context, owner, group string) error {
+	return c.do(ctx, request{
+		method: http.MethodPost,
+		path:   ownerPath(owner, "env-groups", url.PathEscape(group), "delete"),
+	}, nil)
+}
+
+// ProjectEnvGroups returns the env groups a project starts with, in the order
+// they apply.
+func (c *Client) ProjectEnvGroups(ctx context.Context, owner, project string) ([]AttachedEnvGroup, error) {
+	var res struct {
+		Data []AttachedEnvGroup `json:"data"`
+	}
+	err := c.do(ctx, request{method: http.MethodGet, path: projectPath(owner, project, "env-groups"), idempotent: true}, &res)
+	if err != nil {
+		return nil, err
+	}
+	return res.Data, nil
+}
+
+// AttachEnvGroup attaches an env group of the owner to a project, after the
+// groups it has so it wins over them. The running app is released again with
+// its variables.
+func (c *Client) AttachEnvGroup(ctx context.Context, owner, project, group string) error {
+	return c.do(ctx, request{
+		method:     http.MethodPost,
+		path:       projectPath(owner, project, "env-groups"),
+		body:       map[string]string{"group": group},
+		idempotent: true,
+	}, nil)
+}
+
+// DetachEnvGroup detaches an env group from a project and releases the
+// running app again without its variables.
+func (c *Client) DetachEnvGroup(ctx context.Context, owner, project, group string) error {
+	return c.do(ctx, request{
+		method: http.MethodPost,
+		path:   projectPath(owner, project, "env-groups", url.PathEscape(group), "detach"),
+	}, nil)
+}
@@ -0,0 +1,50 @@
+package pemasak
+
+import (
+	"context"
+	"net/http"
+)
+
+// ErrorPage is what visitors get when the proxy can't reach an app, with
+// status 502 when it is down, 503 when it didn't start in time and 504 when
+// it didn't answer within its response timeout. Errors the app returns
+// itself are passed on. With neither field set the platform serves its own
+// page.
+type ErrorPage struct {
+	// HTML is served instead of the platform page. {{status}} and
+	// {{correlation_id}} in it are filled in.
+	HTML string `json:"html,omitempty"`
+	// Redirect sends visitors to this url instead, with status and
+	// correlation_id query parameters.
+	Redirect string `json:"redirect,omitempty"`
+}
+
+// GetErrorPage returns the error page of an app.
+func (c *Client) GetErrorPage(ctx context.Context, owner, project string) (*ErrorPage, error) {
+	var res ErrorPage
+	err := c.do(ctx, request{method: http.MethodGet, path: projectPath(owner, project, "error-page"), idempotent: true}, &res)
+	if err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// SetErrorPage replaces the error page of an app, set either HTML or
+// Redirect.
+func (c *Client) SetErrorPage(ctx context.Context, owner, project string, page ErrorPage) error {
+	return c.do(ctx, request{
+		method:     http.MethodPost,
+		path:       projectPath(owner, project, "error-page"),
+		body:       page,
+		idempotent: true,
+	}, nil)
+}
+
+// ResetErrorPage goes back to the error page of the platform.
+func (c *Client) ResetErrorPage(ctx context.Context, owner, project string) error {
+	return c.do(ctx, request{
+		method:     http.MethodPost,
+		path:       projectPath(owner, project, "error-page", "delete"),
+		idempotent: true,
+	}, nil)
+}
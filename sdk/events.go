@@ -0,0 +1,64 @@
+package pemasak
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Event is something that happened to an app: a build, a release, scaling,
+// a crash, a change of its settings or of its addons.
+type Event struct {
+	ID string `json:"id"`
+	// Type is one of build, deploy, scale, crash, config or addon.
+	Type string `json:"type"`
+	// Kind is what happened within the type, like the status of a build or
+	// the action of a change like "env.delete".
+	Kind    string `json:"kind"`
+	Message string `json:"message"`
+	// Actor is who made a change, empty for what the platform did.
+	Actor     string    `json:"actor"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// EventFilter narrows down the events of an app. The zero value returns the
+// latest 50 of every type.
+type EventFilter struct {
+	Types []string
+	// Cursor is the NextCursor of the page before.
+	Cursor string
+	// Limit is at most 200.
+	Limit int
+}
+
+// EventPage is a page of events, newest first.
+type EventPage struct {
+	Events []Event `json:"data"`
+	// NextCursor fetches the older events, empty on the last page.
+	NextCursor string `json:"next_cursor"`
+}
+
+// ListEvents returns a page of the events of a project, newest first.
+func (c *Client) ListEvents(ctx context.Context, owner, project string, filter EventFilter) (EventPage, error) {
+	q := url.Values{}
+	if len(filter.Types) > 0 {
+		q.Set("type", strings.Join(filter.Types, ","))
+	}
+	if filter.Cursor != "" {
+		q.Set("cursor", filter.Cursor)
+	}
+	if filter.Limit > 0 {
+		q.Set("limit", strconv.Itoa(filter.Limit))
+	}
+	path := projectPath(owner, project, "events")
+	if len(q) > 0 {
+		path += "?" + q.Encode()
+	}
+
+	var page EventPage
+	err := c.do(ctx, request{method: http.MethodGet, path: path, idempotent: true}, &page)
+	return page, err
+}
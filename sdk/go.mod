@@ -0,0 +1,16 @@
+module github.com/mustafasegf/pemasak-infra/sdk
+
+go 1.21.0
+
+require (
+	github.com/gorilla/websocket v1.5.1
+	github.com/spf13/cobra v1.8.0
+	golang.org/x/term v0.15.0
+)
+
+require (
+	github.com/inconshreveable/mousetrap v1.1.0 // indirect
+	github.com/spf13/pflag v1.0.5 // indirect
+	golang.org/x/net v0.17.0 // indirect
+	golang.org/x/sys v0.15.0 // indirect
+)
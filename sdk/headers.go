@@ -0,0 +1,47 @@
+package pemasak
+
+import (
+	"context"
+	"net/http"
+)
+
+// HeaderRules are headers the platform changes on requests on their way to
+// an app, and on its responses on their way back. A pemasak.toml with a
+// [headers] section replaces them on every deploy.
+type HeaderRules struct {
+	Request  HeaderActions `json:"request"`
+	Response HeaderActions `json:"response"`
+}
+
+// HeaderActions are applied in order: Remove, then Set, then Add. Values may
+// have {client_ip}, {request_id}, {host} and {scheme} in them. Headers the
+// platform needs to reach the app, like Host and Content-Length, can't be
+// changed.
+type HeaderActions struct {
+	// Set replaces the header, or adds it when it is missing.
+	Set map[string]string `json:"set,omitempty"`
+	// Add adds the header next to the ones already there.
+	Add    map[string]string `json:"add,omitempty"`
+	Remove []string          `json:"remove,omitempty"`
+}
+
+// GetHeaderRules returns the header rules of an app.
+func (c *Client) GetHeaderRules(ctx context.Context, owner, project string) (*HeaderRules, error) {
+	var res HeaderRules
+	err := c.do(ctx, request{method: http.MethodGet, path: projectPath(owner, project, "headers"), idempotent: true}, &res)
+	if err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// SetHeaderRules replaces the header rules of an app, at most 50 of each
+// kind in each direction.
+func (c *Client) SetHeaderRules(ctx context.Context, owner, project string, rules HeaderRules) error {
+	return c.do(ctx, request{
+		method:     http.MethodPost,
+		path:       projectPath(owner, project, "headers"),
+		body:       rules,
+		idempotent: true,
+	}, nil)
+}
@@ -0,0 +1,46 @@
+package pemasak
+
+import (
+	"context"
+	"net/http"
+)
+
+// Labels returns the labels of a project, like course=COMP301.
+func (c *Client) Labels(ctx context.Context, owner, project string) (map[string]string, error) {
+	var res struct {
+		Labels map[string]string `json:"labels"`
+	}
+	err := c.do(ctx, request{method: http.MethodGet, path: projectPath(owner, project, "labels"), idempotent: true}, &res)
+	if err != nil {
+		return nil, err
+	}
+	return res.Labels, nil
+}
+
+// SetLabels sets the labels in set, removes the ones keyed remove and returns
+// the labels the project has after. A project has at most 32 labels, keys and
+// values are letters, digits, -, _ and . and at most 63 long.
+func (c *Client) SetLabels(ctx context.Context, owner, project string, set map[string]string, remove []string) (map[string]string, error) {
+	if set == nil {
+		set = map[string]string{}
+	}
+	if remove == nil {
+		remove = []string{}
+	}
+	var res struct {
+		Labels map[string]string `json:"labels"`
+	}
+	err := c.do(ctx, request{
+		method: http.MethodPost,
+		path:   projectPath(owner, project, "labels"),
+		body: struct {
+			Set    map[string]string `json:"set"`
+			Remove []string          `json:"remove"`
+		}{set, remove},
+		idempotent: true,
+	}, &res)
+	if err != nil {
+		return nil, err
+	}
+	return res.Labels, nil
+}
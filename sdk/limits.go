@@ -0,0 +1,122 @@
+package pemasak
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+)
+
+// Sources of a limit in AppLimits.Sources.
+const (
+	LimitSourceApp     = "app"
+	LimitSourceUser    = "user"
+	LimitSourceDefault = "default"
+)
+
+// ResourceLimits is what every container of an app may use.
+type ResourceLimits struct {
+	// MemoryMB is the memory of each container, it is killed when it uses
+	// more.
+	MemoryMB int `json:"memory"`
+	// CPUs is how many cpus each container may use, 0.5 is half of one.
+	CPUs float64 `json:"cpus"`
+	// DiskMB is how big the volumes of the app may be together.
+	DiskMB int `json:"disk"`
+}
+
+// LimitOverrides are limits a platform admin set on an app or a user. A nil
+// field leaves the limit to the ones below it: the limits of the users who
+// own the app, then the defaults of the platform.
+type LimitOverrides struct {
+	MemoryMB *int     `json:"memory"`
+	CPUs     *float64 `json:"cpus"`
+	DiskMB   *int     `json:"disk"`
+}
+
+// AppLimits are the limits of an app and where they come from.
+type AppLimits struct {
+	ResourceLimits
+	// Sources maps memory, cpus and disk to one of the LimitSource
+	// constants.
+	Sources map[string]string `json:"sources"`
+	// App is what is set on the app itself.
+	App LimitOverrides `json:"app"`
+}
+
+// Limits returns the memory, cpus and disk an app may use.
+func (c *Client) Limits(ctx context.Context, owner, project string) (*AppLimits, error) {
+	var res AppLimits
+	err := c.do(ctx, request{method: http.MethodGet, path: projectPath(owner, project, "limits"), idempotent: true}, &res)
+	if err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// AppLimits returns the limits of any app, for platform admins.
+func (c *Client) AppLimits(ctx context.Context, owner, project string) (*AppLimits, error) {
+	var res AppLimits
+	err := c.do(ctx, request{method: http.MethodGet, path: adminAppPath(owner, project, "limits"), idempotent: true}, &res)
+	if err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// SetAppLimits replaces the limits set on an app, for platform admins.
+// Running containers get them right away.
+func (c *Client) SetAppLimits(ctx context.Context, owner, project string, limits LimitOverrides) (*AppLimits, error) {
+	var res AppLimits
+	err := c.do(ctx, request{method: http.MethodPost, path: adminAppPath(owner, project, "limits"), body: limits, idempotent: true}, &res)
+	if err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// SetSelectedLimits replaces the limits set on every app with the labels of
+// selector, for platform admins, and returns the apps as owner/project. Apps
+// labeled later don't get them.
+func (c *Client) SetSelectedLimits(ctx context.Context, selector string, limits LimitOverrides) ([]string, error) {
+	var res struct {
+		Apps []string `json:"apps"`
+	}
+	err := c.do(ctx, request{
+		method: http.MethodPost,
+		path:   "/api/admin/apps/limits",
+		body: struct {
+			Selector string `json:"selector"`
+			LimitOverrides
+		}{selector, limits},
+		idempotent: true,
+	}, &res)
+	if err != nil {
+		return nil, err
+	}
+	return res.Apps, nil
+}
+
+func userLimitsPath(username string) string {
+	return "/api/admin/users/" + url.PathEscape(username) + "/limits"
+}
+
+// UserLimits returns the limits set on a user, for platform admins.
+func (c *Client) UserLimits(ctx context.Context, username string) (*LimitOverrides, error) {
+	var res LimitOverrides
+	err := c.do(ctx, request{method: http.MethodGet, path: userLimitsPath(username), idempotent: true}, &res)
+	if err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// SetUserLimits replaces the limits set on a user, for platform admins. The
+// apps of every owner the user owns get them, unless an app has its own.
+func (c *Client) SetUserLimits(ctx context.Context, username string, limits LimitOverrides) (*LimitOverrides, error) {
+	var res LimitOverrides
+	err := c.do(ctx, request{method: http.MethodPost, path: userLimitsPath(username), body: limits, idempotent: true}, &res)
+	if err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
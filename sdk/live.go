@@ -0,0 +1,150 @@
+package pemasak
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// LiveTopic is a kind of live update of an app.
+type LiveTopic string
+
+const (
+	// LiveBuild is a build being queued, starting or finishing.
+	LiveBuild LiveTopic = "build"
+	// LiveBuildLog is output a build appended to its log.
+	LiveBuildLog LiveTopic = "build_log"
+	// LiveContainer is something that happened to the containers, like a
+	// deploy, a crash or scaling.
+	LiveContainer LiveTopic = "container"
+	// LiveLog is output the containers wrote.
+	LiveLog LiveTopic = "log"
+	// LiveResync means updates were missed, after falling behind or a
+	// reconnect. Whatever was read before has to be read again.
+	LiveResync LiveTopic = "resync"
+)
+
+// LiveLogLine is a line a container wrote, as it is written.
+type LiveLogLine struct {
+	Timestamp time.Time `json:"timestamp"`
+	Container string    `json:"container"`
+	Process   string    `json:"process"`
+	Stream    string    `json:"stream"`
+	Level     string    `json:"level,omitempty"`
+	Message   string    `json:"message"`
+}
+
+// LiveUpdate is one update of an app. Which fields are set depends on Topic.
+type LiveUpdate struct {
+	Topic LiveTopic `json:"-"`
+
+	// LiveBuild and LiveBuildLog
+	BuildID string      `json:"build_id,omitempty"`
+	Status  BuildStatus `json:"status,omitempty"`
+	// Offset is the byte of the build log a LiveBuildLog chunk ends at.
+	Offset int64  `json:"offset,omitempty"`
+	Log    string `json:"log,omitempty"`
+
+	// LiveContainer, the kind and message of its activity
+	Kind    string `json:"kind,omitempty"`
+	Message string `json:"message,omitempty"`
+
+	// LiveLog
+	Lines []LiveLogLine `json:"lines,omitempty"`
+}
+
+// Watch calls fn for every live update of an app on the given topics, all of
+// them when none are given, until ctx is done or fn returns an error. The
+// platform pushes them as they happen, so nothing has to be polled. A dropped
+// connection is opened again and reported to fn as LiveResync.
+func (c *Client) Watch(ctx context.Context, owner, project string, topics []LiveTopic, fn func(LiveUpdate) error) error {
+	path := projectPath(owner, project, "live")
+	if len(topics) > 0 {
+		names := make([]string, len(topics))
+		for i, topic := range topics {
+			names[i] = string(topic)
+		}
+		q := url.Values{}
+		q.Set("events", strings.Join(names, ","))
+		path = pathWithQuery(path, q)
+	}
+
+	// the stream outlives any sensible request timeout
+	hc := *c.httpClient
+	hc.Timeout = 0
+
+	failures := 0
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 {
+			if err := fn(LiveUpdate{Topic: LiveResync}); err != nil {
+				return err
+			}
+		}
+
+		received, err := c.watchOnce(ctx, &hc, path, fn)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		var cbErr *callbackError
+		if errors.As(err, &cbErr) {
+			return cbErr.err
+		}
+		var apiErr *APIError
+		if errors.Is(err, ErrUnauthenticated) || (errors.As(err, &apiErr) && !retryable(apiErr.StatusCode)) {
+			return err
+		}
+
+		if received {
+			failures = 0
+		}
+		failures++
+		if failures > c.maxRetries {
+			return err
+		}
+		if err := sleep(ctx, c.backoff(failures)); err != nil {
+			return err
+		}
+	}
+}
+
+func (c *Client) watchOnce(ctx context.Context, hc *http.Client, path string, fn func(LiveUpdate) error) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL.String()+path, nil)
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	c.setAuth(req.Header)
+
+	resp, err := hc.Do(req)
+	if err != nil {
+		return false, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		if err := decode(resp, nil); err != nil {
+			return false, err
+		}
+		return false, fmt.Errorf("pemasak: unexpected status %d", resp.StatusCode)
+	}
+	defer resp.Body.Close()
+
+	received := false
+	err = readEvents(resp.Body, func(name, payload string) (bool, error) {
+		received = true
+		update := LiveUpdate{Topic: LiveTopic(name)}
+		if name != string(LiveResync) {
+			if err := json.Unmarshal([]byte(payload), &update); err != nil {
+				return false, fmt.Errorf("pemasak: decode live update: %w", err)
+			}
+		}
+		if err := fn(update); err != nil {
+			return false, &callbackError{err}
+		}
+		return false, nil
+	})
+	return received, err
+}
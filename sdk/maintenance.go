@@ -0,0 +1,54 @@
+package pemasak
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// Maintenance is whether the proxy serves a maintenance page instead of an
+// app. The containers of the app keep running meanwhile.
+type Maintenance struct {
+	Enabled bool       `json:"enabled"`
+	Since   *time.Time `json:"since"`
+	// Page is the HTML served with status 503, empty for the default page.
+	Page string `json:"page"`
+	// RetryAfter is the Retry-After of the page in seconds.
+	RetryAfter int `json:"retry_after"`
+}
+
+// MaintenanceRequest starts maintenance. Zero fields take the defaults: the
+// default page and a Retry-After of 300 seconds.
+type MaintenanceRequest struct {
+	Page       string `json:"page,omitempty"`
+	RetryAfter int    `json:"retry_after,omitempty"`
+}
+
+// GetMaintenance returns whether an app is in maintenance.
+func (c *Client) GetMaintenance(ctx context.Context, owner, project string) (*Maintenance, error) {
+	var res Maintenance
+	err := c.do(ctx, request{method: http.MethodGet, path: projectPath(owner, project, "maintenance"), idempotent: true}, &res)
+	if err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// StartMaintenance makes the proxy answer every request for an app with the
+// maintenance page. Starting it again only replaces the page.
+func (c *Client) StartMaintenance(ctx context.Context, owner, project string, req MaintenanceRequest) error {
+	return c.do(ctx, request{
+		method:     http.MethodPost,
+		path:       projectPath(owner, project, "maintenance"),
+		body:       req,
+		idempotent: true,
+	}, nil)
+}
+
+// StopMaintenance sends requests to the app again.
+func (c *Client) StopMaintenance(ctx context.Context, owner, project string) error {
+	return c.do(ctx, request{
+		method: http.MethodPost,
+		path:   projectPath(owner, project, "maintenance", "delete"),
+	}, nil)
+}
@@ -0,0 +1,72 @@
+package pemasak
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Role is what a member can do with the apps of an owner. Each role can do
+// everything the ones before it can.
+type Role string
+
+const (
+	// RoleViewer sees apps, their builds, logs and metrics.
+	RoleViewer Role = "viewer"
+	// RoleMaintainer also creates, deploys and configures apps and opens
+	// shells into them.
+	RoleMaintainer Role = "maintainer"
+	// RoleOwner also manages the members and deletes apps.
+	RoleOwner Role = "owner"
+)
+
+// Member is a user with access to the apps of an owner.
+type Member struct {
+	Username string `json:"username"`
+	Name     string `json:"name"`
+	Role     Role   `json:"role"`
+	// CreatedAt is when they joined.
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ListMembers returns the members of an owner, owners first. Every member
+// can list them.
+func (c *Client) ListMembers(ctx context.Context, owner string) ([]Member, error) {
+	var res struct {
+		Data []Member `json:"data"`
+	}
+	err := c.do(ctx, request{method: http.MethodGet, path: ownerPath(owner, "members"), idempotent: true}, &res)
+	if err != nil {
+		return nil, err
+	}
+	return res.Data, nil
+}
+
+// SetMember adds a user to an owner, or changes the role of a member. Only
+// owners manage members, and the last owner can't step down.
+func (c *Client) SetMember(ctx context.Context, owner, username string, role Role) (*Member, error) {
+	var res Member
+	err := c.do(ctx, request{
+		method: http.MethodPost,
+		path:   ownerPath(owner, "members"),
+		body: struct {
+			Username string `json:"username"`
+			Role     Role   `json:"role"`
+		}{username, role},
+		idempotent: true,
+	}, &res)
+	if err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// RemoveMember takes away the access of a member. Owners remove anyone,
+// members can remove themselves, and the last owner stays.
+func (c *Client) RemoveMember(ctx context.Context, owner, username string) error {
+	return c.do(ctx, request{
+		method: http.MethodPost,
+		path:   ownerPath(owner, "members", url.PathEscape(username), "delete"),
+	}, nil)
+}
@@ -0,0 +1,128 @@
+package pemasak
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// MetricsRange is how far back Metrics looks.
+type MetricsRange string
+
+const (
+	Range15m MetricsRange = "15m"
+	Range1h  MetricsRange = "1h"
+	Range6h  MetricsRange = "6h"
+	Range24h MetricsRange = "24h"
+	Range7d  MetricsRange = "7d"
+)
+
+// MetricsPoint is the resource usage of a container over one step.
+type MetricsPoint struct {
+	Timestamp time.Time `json:"timestamp"`
+	// CPUPercent is the average over the step, 100 is one full CPU.
+	CPUPercent float64 `json:"cpu_percent"`
+	// MemoryBytes is the peak over the step.
+	MemoryBytes int64 `json:"memory_bytes"`
+	MemoryLimit int64 `json:"memory_limit"`
+	// NetworkRx and NetworkTx are in bytes per second.
+	NetworkRx float64 `json:"network_rx"`
+	NetworkTx float64 `json:"network_tx"`
+	// Restarts counts restarts of the container after it crashed, and
+	// OOMKilled is set when the last one was the kernel killing it for using
+	// more memory than its limit.
+	Restarts  int  `json:"restarts"`
+	OOMKilled bool `json:"oom_killed"`
+}
+
+// MetricsSeries is the usage of one web or worker container over time,
+// oldest point first.
+type MetricsSeries struct {
+	Container string         `json:"container"`
+	Process   string         `json:"process"`
+	Points    []MetricsPoint `json:"points"`
+}
+
+// Metrics is the resource usage of a project over a range.
+type Metrics struct {
+	Range MetricsRange
+	// Step is the time between points.
+	Step   time.Duration
+	Series []MetricsSeries
+}
+
+// Metrics returns the resource usage of the containers of a project over
+// rng, suitable for graphing. An empty rng means the last hour.
+func (c *Client) Metrics(ctx context.Context, owner, project string, rng MetricsRange) (*Metrics, error) {
+	path := projectPath(owner, project, "metrics")
+	if rng != "" {
+		path += "?" + url.Values{"range": {string(rng)}}.Encode()
+	}
+
+	var res struct {
+		Range MetricsRange    `json:"range"`
+		Step  int64           `json:"step"`
+		Data  []MetricsSeries `json:"data"`
+	}
+	err := c.do(ctx, request{method: http.MethodGet, path: path, idempotent: true}, &res)
+	if err != nil {
+		return nil, err
+	}
+	return &Metrics{
+		Range:  res.Range,
+		Step:   time.Duration(res.Step) * time.Second,
+		Series: res.Data,
+	}, nil
+}
+
+// ProfileOptions are the parameters of a profile, the zero value is a 30
+// second CPU profile or trace in the protobuf format.
+type ProfileOptions struct {
+	// Seconds is how long a CPU profile or trace records, up to 120.
+	Seconds int
+	// Debug is 1 or 2 for text, like goroutine stacks, instead of protobuf.
+	Debug int
+	// GC runs the garbage collector before a heap profile.
+	GC bool
+}
+
+// Profile fetches a profile of net/http/pprof, like profile, heap or
+// goroutine, from the web container of a Go app that serves /debug/pprof/.
+// Visitors of the app never reach it, only maintainers through the platform.
+// The caller closes it.
+func (c *Client) Profile(ctx context.Context, owner, project, profile string, opts ProfileOptions) (io.ReadCloser, error) {
+	q := url.Values{}
+	if opts.Seconds > 0 {
+		q.Set("seconds", strconv.Itoa(opts.Seconds))
+	}
+	if opts.Debug > 0 {
+		q.Set("debug", strconv.Itoa(opts.Debug))
+	}
+	if opts.GC {
+		q.Set("gc", "1")
+	}
+	path := projectPath(owner, project, "debug", url.PathEscape(profile))
+	if len(q) > 0 {
+		path += "?" + q.Encode()
+	}
+
+	// a CPU profile takes as long as it records
+	hc := *c.httpClient
+	hc.Timeout = 0
+
+	resp, err := c.sendWith(ctx, &hc, http.MethodGet, path, nil, "")
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		if err := decode(resp, nil); err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("pemasak: unexpected status %d", resp.StatusCode)
+	}
+	return resp.Body, nil
+}
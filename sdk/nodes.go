@@ -0,0 +1,114 @@
+package pemasak
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Node is a host besides the one of the platform that runs apps, through the
+// pemasak-agent on it. Nodes are for platform admins.
+type Node struct {
+	Name string `json:"name"`
+	// Status is "new" until the agent first reports, "ready", "draining", or
+	// "down" once the agent stopped reporting. Only ready nodes get new apps.
+	Status    string `json:"status"`
+	DockerURL string `json:"docker_url"`
+	CPUs      int    `json:"cpus"`
+	// MemoryMB and MemoryAvailableMB are what the host had when the agent
+	// last reported.
+	MemoryMB          int     `json:"memory"`
+	MemoryAvailableMB int     `json:"memory_available"`
+	Load              float64 `json:"load"`
+	Containers        int     `json:"containers"`
+	// Runtime is what runs the containers of the node, "docker" or "podman".
+	Runtime string `json:"runtime"`
+	// Arch is the CPU architecture of the node like "amd64" or "arm64", empty
+	// until an agent that reports it does.
+	Arch string `json:"arch"`
+	// Apps counts the apps placed on the node.
+	Apps       int        `json:"apps"`
+	LastSeenAt *time.Time `json:"last_seen_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// NodeToken is what the agent of a new node reports with. It is only
+// returned by AddNode.
+type NodeToken struct {
+	Name  string `json:"name"`
+	Token string `json:"token"`
+}
+
+// DrainReport is what DrainNode did to the apps on the node.
+type DrainReport struct {
+	Moved []MovedApp `json:"moved"`
+	// Staying are owner/project of apps whose owner has a database or volume
+	// on the node. They stay until the data is moved by hand.
+	Staying []string `json:"staying"`
+	// Stranded are owner/project of apps no other host can run, because no
+	// other node and not the host of the platform has the architecture of the
+	// node.
+	Stranded []string `json:"stranded"`
+}
+
+// MovedApp is an app DrainNode moved.
+type MovedApp struct {
+	Owner   string `json:"owner"`
+	Project string `json:"project"`
+	// Node is where it went, empty for the host of the platform.
+	Node string `json:"node"`
+}
+
+func nodePath(name, action string) string {
+	return "/api/admin/nodes/" + url.PathEscape(name) + "/" + action
+}
+
+// ListNodes returns every node by name.
+func (c *Client) ListNodes(ctx context.Context) ([]Node, error) {
+	var res struct {
+		Data []Node `json:"data"`
+	}
+	err := c.do(ctx, request{method: http.MethodGet, path: "/api/admin/nodes", idempotent: true}, &res)
+	if err != nil {
+		return nil, err
+	}
+	return res.Data, nil
+}
+
+// AddNode adds a node and returns the token its agent reports with. The
+// token isn't shown again.
+func (c *Client) AddNode(ctx context.Context, name string) (*NodeToken, error) {
+	var res NodeToken
+	err := c.do(ctx, request{
+		method: http.MethodPost,
+		path:   "/api/admin/nodes",
+		body:   map[string]string{"name": name},
+	}, &res)
+	if err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// DrainNode stops placing apps on a node and moves its apps to the least
+// loaded other node, or to the host of the platform. Moved apps are deployed
+// again where they went. Draining again moves the apps that came since.
+func (c *Client) DrainNode(ctx context.Context, name string) (*DrainReport, error) {
+	var res DrainReport
+	err := c.do(ctx, request{method: http.MethodPost, path: nodePath(name, "drain"), idempotent: true, untimed: true}, &res)
+	if err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// ResumeNode places new apps on a drained node again.
+func (c *Client) ResumeNode(ctx context.Context, name string) error {
+	return c.do(ctx, request{method: http.MethodPost, path: nodePath(name, "resume"), idempotent: true}, nil)
+}
+
+// RemoveNode removes a node without apps and revokes the token of its agent.
+func (c *Client) RemoveNode(ctx context.Context, name string) error {
+	return c.do(ctx, request{method: http.MethodPost, path: nodePath(name, "delete")}, nil)
+}
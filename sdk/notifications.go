@@ -0,0 +1,79 @@
+package pemasak
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Notification events a hook can subscribe to.
+const (
+	EventBuildStarted     = "build.started"
+	EventBuildSucceeded   = "build.succeeded"
+	EventBuildFailed      = "build.failed"
+	EventContainerCrashed = "container.crashed"
+	// EventCrashLooping is sent once when a container keeps crashing, its
+	// crashes aren't sent one by one then.
+	EventCrashLooping = "container.crash_looping"
+	// EventAppInactive and EventAppStopped are sent by the cleanup of inactive
+	// apps, to every hook of the app whatever it subscribed to.
+	EventAppInactive = "app.inactive"
+	EventAppStopped  = "app.stopped"
+)
+
+// NotificationHook is where a project sends notifications about its builds
+// and crashes. Kind webhook receives the JSON payload, signed with Secret in
+// the X-Pemasak-Signature-256 header like GitHub webhooks; slack and discord
+// post a message to an incoming webhook URL.
+type NotificationHook struct {
+	ID   string `json:"id"`
+	Kind string `json:"kind"`
+	// URL has its secret part masked.
+	URL    string   `json:"url"`
+	Events []string `json:"events"`
+	// Secret is empty for slack and discord.
+	Secret    string    `json:"secret"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ListNotificationHooks returns the notification hooks of a project.
+func (c *Client) ListNotificationHooks(ctx context.Context, owner, project string) ([]NotificationHook, error) {
+	var res struct {
+		Data []NotificationHook `json:"data"`
+	}
+	err := c.do(ctx, request{method: http.MethodGet, path: projectPath(owner, project, "notifications"), idempotent: true}, &res)
+	if err != nil {
+		return nil, err
+	}
+	return res.Data, nil
+}
+
+// AddNotificationHook starts notifying hookURL about events of a project, or
+// every event when events is empty. Kind is webhook, slack or discord.
+func (c *Client) AddNotificationHook(ctx context.Context, owner, project, kind, hookURL string, events []string) (*NotificationHook, error) {
+	body := struct {
+		Kind   string   `json:"kind"`
+		URL    string   `json:"url"`
+		Events []string `json:"events,omitempty"`
+	}{kind, hookURL, events}
+
+	var res NotificationHook
+	err := c.do(ctx, request{
+		method: http.MethodPost,
+		path:   projectPath(owner, project, "notifications"),
+		body:   body,
+	}, &res)
+	if err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// RemoveNotificationHook stops notifying a hook.
+func (c *Client) RemoveNotificationHook(ctx context.Context, owner, project, id string) error {
+	return c.do(ctx, request{
+		method: http.MethodPost,
+		path:   projectPath(owner, project, "notifications", url.PathEscape(id), "delete"),
+	}, nil)
+}
@@ -0,0 +1,57 @@
+package pemasak
+
+import (
+	"context"
+	"net/url"
+	"strconv"
+)
+
+// ListOptions picks a page of a list. The zero value is the first page of
+// 50.
+type ListOptions struct {
+	// Cursor is the NextCursor of the page before.
+	Cursor string
+	// Limit is at most 200.
+	Limit int
+}
+
+func (o ListOptions) set(q url.Values) {
+	if o.Cursor != "" {
+		q.Set("cursor", o.Cursor)
+	}
+	if o.Limit > 0 {
+		q.Set("limit", strconv.Itoa(o.Limit))
+	}
+}
+
+// Page is a page of a list, newest first.
+type Page[T any] struct {
+	Data []T `json:"data"`
+	// NextCursor fetches the older items, empty on the last page.
+	NextCursor string `json:"next_cursor"`
+}
+
+// pathWithQuery is path with q, without a ? when q is empty.
+func pathWithQuery(path string, q url.Values) string {
+	if len(q) == 0 {
+		return path
+	}
+	return path + "?" + q.Encode()
+}
+
+// collect fetches page after page with the largest pages until the last one.
+func collect[T any](ctx context.Context, fetch func(ListOptions) (Page[T], error)) ([]T, error) {
+	all := []T{}
+	opts := ListOptions{Limit: 200}
+	for {
+		page, err := fetch(opts)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, page.Data...)
+		if page.NextCursor == "" || ctx.Err() != nil {
+			return all, ctx.Err()
+		}
+		opts.Cursor = page.NextCursor
+	}
+}
@@ -0,0 +1,126 @@
+package pemasak
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Stage is an app in a pipeline.
+type Stage struct {
+	// App is owner/project.
+	App string `json:"app"`
+	// ReleaseID is the live release, empty before the first deploy.
+	ReleaseID string `json:"release_id,omitempty"`
+	// PromotionApproval makes releases promoted to the app wait for another
+	// member to approve them.
+	PromotionApproval bool `json:"promotion_approval"`
+}
+
+// Promotion is a release promoted to an app that needs an approval.
+type Promotion struct {
+	ID        string `json:"id"`
+	ReleaseID string `json:"release_id"`
+	// Source and Target are the apps the release comes from and goes to.
+	Source      string `json:"source"`
+	Target      string `json:"target"`
+	RequestedBy string `json:"requested_by"`
+	// Status is "pending", "approved" or "rejected".
+	Status    string     `json:"status"`
+	DecidedBy string     `json:"decided_by,omitempty"`
+	DecidedAt *time.Time `json:"decided_at,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// Pipeline is the chain of apps a project is in, like dev, staging and
+// production.
+type Pipeline struct {
+	// Stages go from the first to the last, the project included.
+	Stages []Stage `json:"stages"`
+	// Next is the stage after the project, empty for the last one.
+	Next              string `json:"next,omitempty"`
+	PromotionApproval bool   `json:"promotion_approval"`
+	// Promotions are the last ones from and to the project, newest first.
+	Promotions []Promotion `json:"promotions"`
+}
+
+// PromotionResult is what promoting a release did.
+type PromotionResult struct {
+	Message string `json:"message"`
+	// Target and ReleaseID are set by PromoteNext, the stage and release it
+	// picked.
+	Target    string `json:"target,omitempty"`
+	ReleaseID string `json:"release_id,omitempty"`
+	// PromotionID is set when the app only runs the release once another
+	// member approves it with ApprovePromotion.
+	PromotionID string `json:"promotion_id,omitempty"`
+}
+
+// GetPipeline returns the pipeline of a project and its last promotions.
+func (c *Client) GetPipeline(ctx context.Context, owner, project string) (*Pipeline, error) {
+	var res Pipeline
+	err := c.do(ctx, request{method: http.MethodGet, path: projectPath(owner, project, "pipeline"), idempotent: true}, &res)
+	if err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// SetPipeline sets the stage after a project, given as owner/project or
+// empty to make it the last one, and whether promotions to it need an
+// approval. The caller must be an owner of the project and a maintainer of
+// the next stage.
+func (c *Client) SetPipeline(ctx context.Context, owner, project, next string, approval bool) error {
+	body := struct {
+		Next              *string `json:"next"`
+		PromotionApproval bool    `json:"promotion_approval"`
+	}{PromotionApproval: approval}
+	if next != "" {
+		body.Next = &next
+	}
+	return c.do(ctx, request{
+		method:     http.MethodPost,
+		path:       projectPath(owner, project, "pipeline"),
+		body:       body,
+		idempotent: true,
+	}, nil)
+}
+
+// PromoteNext promotes a release of a project to the next stage of its
+// pipeline, the live release when releaseID is empty.
+func (c *Client) PromoteNext(ctx context.Context, owner, project, releaseID string) (*PromotionResult, error) {
+	body := struct {
+		ReleaseID *string `json:"release_id"`
+	}{}
+	if releaseID != "" {
+		body.ReleaseID = &releaseID
+	}
+	var res PromotionResult
+	err := c.do(ctx, request{
+		method: http.MethodPost,
+		path:   projectPath(owner, project, "pipeline", "promote"),
+		body:   body,
+	}, &res)
+	if err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// ApprovePromotion runs a release waiting to be promoted to a project. The
+// member who promoted it can't approve it.
+func (c *Client) ApprovePromotion(ctx context.Context, owner, project, promotionID string) error {
+	return c.do(ctx, request{
+		method: http.MethodPost,
+		path:   projectPath(owner, project, "promotions", url.PathEscape(promotionID), "approve"),
+	}, nil)
+}
+
+// RejectPromotion drops a release waiting to be promoted to a project.
+func (c *Client) RejectPromotion(ctx context.Context, owner, project, promotionID string) error {
+	return c.do(ctx, request{
+		method: http.MethodPost,
+		path:   projectPath(owner, project, "promotions", url.PathEscape(promotionID), "reject"),
+	}, nil)
+}
@@ -0,0 +1,67 @@
+package pemasak
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Port is a tcp or udp port of the platform leased to an app, for services
+// that don't speak http. Connections to Host:Port are forwarded to TargetPort
+// of the container.
+type Port struct {
+	ID       string `json:"id"`
+	Protocol string `json:"protocol"`
+	Port     int    `json:"port"`
+	// TargetPort is the port the app listens on in its container.
+	TargetPort int `json:"target_port"`
+	// IdleTimeout is the seconds a connection may be quiet before it is
+	// closed.
+	IdleTimeout int       `json:"idle_timeout"`
+	Host        string    `json:"host"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// PortLease asks for a port. A zero Port takes the lowest free one of the
+// platform range, a zero IdleTimeout the platform default.
+type PortLease struct {
+	Protocol    string `json:"protocol"`
+	TargetPort  int    `json:"target_port"`
+	Port        int    `json:"port,omitempty"`
+	IdleTimeout int    `json:"idle_timeout,omitempty"`
+}
+
+// ListPorts returns the ports leased to a project. enabled is false while the
+// platform has no port range, nothing can be leased then.
+func (c *Client) ListPorts(ctx context.Context, owner, project string) (ports []Port, enabled bool, err error) {
+	var res struct {
+		Data    []Port `json:"data"`
+		Enabled bool   `json:"enabled"`
+	}
+	err = c.do(ctx, request{method: http.MethodGet, path: projectPath(owner, project, "ports"), idempotent: true}, &res)
+	if err != nil {
+		return nil, false, err
+	}
+	return res.Data, res.Enabled, nil
+}
+
+// LeasePort leases a port to a project. It counts against the port quota of
+// every user owning the project.
+func (c *Client) LeasePort(ctx context.Context, owner, project string, lease PortLease) (*Port, error) {
+	var res Port
+	err := c.do(ctx, request{method: http.MethodPost, path: projectPath(owner, project, "ports"), body: lease}, &res)
+	if err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// ReleasePort gives a leased port back. Open connections run until they end.
+func (c *Client) ReleasePort(ctx context.Context, owner, project, id string) error {
+	return c.do(ctx, request{
+		method:     http.MethodPost,
+		path:       projectPath(owner, project, "ports", url.PathEscape(id), "delete"),
+		idempotent: true,
+	}, nil)
+}
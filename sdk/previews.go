@@ -0,0 +1,67 @@
+package pemasak
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Previews is whether pushes to the other branches of an app deploy
+// previews of them, each at its own <branch>--<app> subdomain.
+type Previews struct {
+	Enabled bool `json:"enabled"`
+	// Env is set in previews on top of the variables of the app, like a
+	// DATABASE_URL of a database that isn't the live one. Previews also get
+	// PREVIEW_BRANCH.
+	Env map[string]string `json:"env"`
+	// Previews that are running, the latest pushed first. Ignored by
+	// SetPreviews.
+	Previews []Preview `json:"data,omitempty"`
+}
+
+// Preview is a branch of an app deployed at its own subdomain.
+type Preview struct {
+	Name   string `json:"name"`
+	Branch string `json:"branch"`
+	URL    string `json:"url"`
+	// ExpiresAt is when the preview is torn down unless its branch is pushed
+	// again.
+	ExpiresAt time.Time `json:"expires_at"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// GetPreviews returns whether an app deploys previews and the ones running.
+func (c *Client) GetPreviews(ctx context.Context, owner, project string) (*Previews, error) {
+	var res Previews
+	err := c.do(ctx, request{method: http.MethodGet, path: projectPath(owner, project, "previews"), idempotent: true}, &res)
+	if err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// SetPreviews turns previews of an app on or off and replaces the variables
+// they get. Turning them off tears down the running previews.
+func (c *Client) SetPreviews(ctx context.Context, owner, project string, enabled bool, env map[string]string) error {
+	if env == nil {
+		env = map[string]string{}
+	}
+	return c.do(ctx, request{
+		method:     http.MethodPost,
+		path:       projectPath(owner, project, "previews"),
+		body:       map[string]any{"enabled": enabled, "env": env},
+		idempotent: true,
+	}, nil)
+}
+
+// DeletePreview tears down a preview by its name before it expires. Pushing
+// its branch again deploys it again.
+func (c *Client) DeletePreview(ctx context.Context, owner, project, name string) error {
+	return c.do(ctx, request{
+		method:     http.MethodPost,
+		path:       projectPath(owner, project, "previews", url.PathEscape(name), "delete"),
+		idempotent: true,
+	}, nil)
+}
@@ -0,0 +1,45 @@
+package pemasak
+
+import (
+	"context"
+	"net/http"
+)
+
+// Process is a process type of the live release: web, or a worker declared in
+// the Procfile. The proxy spreads HTTP traffic over the healthy containers of
+// web; workers run in their own containers and receive no HTTP traffic.
+type Process struct {
+	Name    string `json:"name"`
+	Command string `json:"command"`
+	// Count is the number of containers the process should run.
+	Count int `json:"count"`
+	// Running is the number of containers running right now.
+	Running int `json:"running"`
+}
+
+// ListProcesses returns the processes of a project, web first.
+func (c *Client) ListProcesses(ctx context.Context, owner, project string) ([]Process, error) {
+	var res struct {
+		Data []Process `json:"data"`
+	}
+	err := c.do(ctx, request{method: http.MethodGet, path: projectPath(owner, project, "processes"), idempotent: true}, &res)
+	if err != nil {
+		return nil, err
+	}
+	return res.Data, nil
+}
+
+// Scale sets the number of containers of a process. Running containers are
+// kept, only missing ones are started and extra ones stopped. A count of 0
+// stops a worker; web needs at least one container.
+func (c *Client) Scale(ctx context.Context, owner, project, process string, count int) error {
+	return c.do(ctx, request{
+		method: http.MethodPost,
+		path:   projectPath(owner, project, "processes"),
+		body: struct {
+			Name  string `json:"name"`
+			Count int    `json:"count"`
+		}{process, count},
+		idempotent: true,
+	}, nil)
+}
@@ -0,0 +1,267 @@
+package pemasak
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// ProjectStatus is what an app is doing.
+type ProjectStatus string
+
+const (
+	ProjectRunning ProjectStatus = "running"
+	// ProjectNew hasn't been deployed yet.
+	ProjectNew ProjectStatus = "new"
+	// ProjectStopped was stopped by a member with BulkProjects, or by the
+	// cleanup of inactive apps.
+	ProjectStopped ProjectStatus = "stopped"
+	// ProjectSuspended was suspended by the platform admins.
+	ProjectSuspended   ProjectStatus = "suspended"
+	ProjectCrashing    ProjectStatus = "crashing"
+	ProjectMaintenance ProjectStatus = "maintenance"
+)
+
+// Project is an app as listed on the dashboard.
+type Project struct {
+	ID        string            `json:"id"`
+	Name      string            `json:"name"`
+	OwnerName string            `json:"owner_name"`
+	Status    ProjectStatus     `json:"status"`
+	Labels    map[string]string `json:"labels"`
+	CreatedAt time.Time         `json:"created_at"`
+}
+
+// ProjectFilter narrows down a list of apps. The zero value lists them all.
+type ProjectFilter struct {
+	// Owner only lists the apps of this owner.
+	Owner  string
+	Status ProjectStatus
+	// Selector only lists the apps with these labels, like
+	// "course=COMP301,team!=blue". A key alone asks for the label whatever
+	// its value, !key for not having it.
+	Selector string
+}
+
+func (f ProjectFilter) query(page ListOptions) url.Values {
+	q := url.Values{}
+	if f.Owner != "" {
+		q.Set("owner", f.Owner)
+	}
+	if f.Status != "" {
+		q.Set("status", string(f.Status))
+	}
+	if f.Selector != "" {
+		q.Set("selector", f.Selector)
+	}
+	page.set(q)
+	return q
+}
+
+// CreatedProject is returned once when a project is created. Domain is the
+// git remote to push to; GitPassword is not retrievable afterwards.
+type CreatedProject struct {
+	ID          string `json:"id"`
+	OwnerName   string `json:"owner_name"`
+	ProjectName string `json:"project_name"`
+	Domain      string `json:"domain"`
+	GitUsername string `json:"git_username"`
+	GitPassword string `json:"git_password"`
+}
+
+// ListProjects returns every project the logged in user can see that
+// matches filter, newest first. It fetches page after page, use
+// ListProjectsPage for one.
+func (c *Client) ListProjects(ctx context.Context, filter ProjectFilter) ([]Project, error) {
+	return collect(ctx, func(page ListOptions) (Page[Project], error) {
+		return c.ListProjectsPage(ctx, filter, page)
+	})
+}
+
+// ListProjectsPage returns a page of the projects the logged in user can see
+// that match filter, newest first.
+func (c *Client) ListProjectsPage(ctx context.Context, filter ProjectFilter, page ListOptions) (Page[Project], error) {
+	var res Page[Project]
+	err := c.do(ctx, request{method: http.MethodGet, path: pathWithQuery("/api/dashboard/project", filter.query(page)), idempotent: true}, &res)
+	return res, err
+}
+
+// BulkAction is what BulkProjects does to every app.
+type BulkAction string
+
+const (
+	// BulkRestart stops every container of an app and starts it again.
+	BulkRestart BulkAction = "restart"
+	// BulkStop stops an app and keeps it stopped like a suspension until
+	// BulkStart: the proxy answers 503 and cron jobs don't run.
+	BulkStop  BulkAction = "stop"
+	BulkStart BulkAction = "start"
+	// BulkDelete deletes an app like DeleteProject, which can't be undone.
+	BulkDelete BulkAction = "delete"
+)
+
+// BulkResult is how an action went for one app.
+type BulkResult struct {
+	// App is owner/project.
+	App     string `json:"app"`
+	OK      bool   `json:"ok"`
+	Message string `json:"message"`
+}
+
+// bulkSize is the most apps one request acts on.
+const bulkSize = 100
+
+// BulkProjects runs action on every app, given as owner/project. Restarting,
+// stopping and starting needs the maintainer role and deleting the owner role
+// of the app. An app that fails doesn't stop the others, the results say how
+// each went in the order of apps.
+func (c *Client) BulkProjects(ctx context.Context, action BulkAction, apps []string) ([]BulkResult, error) {
+	results := make([]BulkResult, 0, len(apps))
+	for start := 0; start < len(apps); start += bulkSize {
+		chunk := apps[start:min(start+bulkSize, len(apps))]
+		var res struct {
+			Results []BulkResult `json:"results"`
+		}
+		err := c.do(ctx, request{
+			method: http.MethodPost,
+			path:   "/api/dashboard/project/bulk",
+			body: struct {
+				Action BulkAction `json:"action"`
+				Apps   []string   `json:"apps"`
+			}{action, chunk},
+		}, &res)
+		if err != nil {
+			return results, err
+		}
+		results = append(results, res.Results...)
+	}
+	return results, nil
+}
+
+// BulkProjectsSelected runs action like BulkProjects on every app with the
+// labels of selector, of the owners the logged in user is a member of. A
+// selector picks at most 500 apps.
+func (c *Client) BulkProjectsSelected(ctx context.Context, action BulkAction, selector string) ([]BulkResult, error) {
+	var res struct {
+		Results []BulkResult `json:"results"`
+	}
+	err := c.do(ctx, request{
+		method: http.MethodPost,
+		path:   "/api/dashboard/project/bulk",
+		body: struct {
+			Action   BulkAction `json:"action"`
+			Selector string     `json:"selector"`
+		}{action, selector},
+	}, &res)
+	if err != nil {
+		return nil, err
+	}
+	return res.Results, nil
+}
+
+// CreateProject creates a project under owner. Push to the returned git
+// remote to deploy it.
+func (c *Client) CreateProject(ctx context.Context, owner, project string) (*CreatedProject, error) {
+	var res CreatedProject
+	err := c.do(ctx, request{
+		method: http.MethodPost,
+		path:   "/api/project/new",
+		body:   map[string]string{"owner": owner, "project": project},
+	}, &res)
+	if err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// CloneOptions says where CloneProject puts the clone.
+type CloneOptions struct {
+	// Owner of the clone, empty for the account of the user.
+	Owner string `json:"owner,omitempty"`
+	// Project is the name of the clone, empty keeps the name of the app.
+	Project string `json:"project,omitempty"`
+	// Image also deploys the live release of the app to the clone. Without
+	// it the clone waits for its first push.
+	Image bool `json:"image"`
+}
+
+// ClonedProject is a CreatedProject made by CloneProject.
+type ClonedProject struct {
+	CreatedProject
+	// ClonedFrom is the app it was cloned from, as owner/project.
+	ClonedFrom string `json:"cloned_from"`
+	// Deploying is set when the live release of the app is being deployed to
+	// the clone.
+	Deploying bool `json:"deploying"`
+}
+
+// CloneProject copies a project into a new one: its settings, environment,
+// cron jobs and repository. Secrets set with SetUncopiedSecret stay behind,
+// and so do add-ons, volumes, domains and everything else holding data of
+// the app. Maintainers of the owner can clone a project, and anyone signed
+// in once it is cloneable, see Settings.Cloneable. Their clones get no
+// secrets at all.
+func (c *Client) CloneProject(ctx context.Context, owner, project string, opts CloneOptions) (*ClonedProject, error) {
+	var res ClonedProject
+	err := c.do(ctx, request{
+		method: http.MethodPost,
+		path:   projectPath(owner, project, "clone"),
+		body:   opts,
+	}, &res)
+	if err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// DeleteProject removes the project, its container, image and database.
+func (c *Client) DeleteProject(ctx context.Context, owner, project string) error {
+	return c.do(ctx, request{method: http.MethodPost, path: projectPath(owner, project, "delete")}, nil)
+}
+
+// Deploy queues a rebuild of the last pushed commit. The project must have
+// been pushed to at least once.
+func (c *Client) Deploy(ctx context.Context, owner, project string) error {
+	return c.do(ctx, request{method: http.MethodPost, path: projectPath(owner, project, "builds", "trigger")}, nil)
+}
+
+// DeployArchive commits a .tar.gz, .tar or .zip of the source to the
+// repository of a project and queues building it, like a push of that commit.
+// An archive of a single folder deploys what is in the folder. message is the
+// commit message, empty for a default one. Returns the commit.
+func (c *Client) DeployArchive(ctx context.Context, owner, project string, archive []byte, message string) (string, error) {
+	path := projectPath(owner, project, "deploys")
+	if message != "" {
+		path += "?" + url.Values{"message": {message}}.Encode()
+	}
+
+	var res struct {
+		Commit string `json:"commit"`
+	}
+	err := c.do(ctx, request{method: http.MethodPost, path: path, raw: archive, untimed: true}, &res)
+	if err != nil {
+		return "", err
+	}
+	return res.Commit, nil
+}
+
+// DeployImage queues pulling image, like ghcr.io/owner/app:tag, and deploying
+// it without building. Private registries need credentials, see
+// SetRegistryCredential.
+func (c *Client) DeployImage(ctx context.Context, owner, project, image string) error {
+	return c.do(ctx, request{
+		method: http.MethodPost,
+		path:   projectPath(owner, project, "builds", "image"),
+		body:   map[string]string{"image": image},
+	}, nil)
+}
+
+func mustJSON(v any) []byte {
+	b, err := json.Marshal(v)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
@@ -0,0 +1,140 @@
+package pemasak
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Headers the platform proxy signs the requests to an app with.
+const (
+	// ProxySecretEnv is the variable an app gets its key in.
+	ProxySecretEnv = "PEMASAK_PROXY_SECRET"
+	// ProxyTimestampHeader holds the unix seconds the proxy forwarded the
+	// request at.
+	ProxyTimestampHeader = "X-Pemasak-Proxy-Timestamp"
+	// ProxySignatureHeader holds "sha256=" and the hex HMAC, two of them
+	// separated by a comma while a rotated key still signs.
+	ProxySignatureHeader = "X-Pemasak-Proxy-Signature"
+)
+
+// DefaultProxyMaxAge is how old a signature ProxyMiddleware accepts.
+const DefaultProxyMaxAge = 5 * time.Minute
+
+var (
+	// ErrProxyUnsigned is returned for requests without the signature
+	// headers, they didn't come through the platform proxy.
+	ErrProxyUnsigned = errors.New("pemasak: request is not signed by the proxy")
+	// ErrProxySignature is returned when no signature matches the key.
+	ErrProxySignature = errors.New("pemasak: proxy signature does not match")
+	// ErrProxyExpired is returned for signatures older than the max age, or
+	// from further in the future than it.
+	ErrProxyExpired = errors.New("pemasak: proxy signature expired")
+	// ErrProxyNoSecret is returned when there is no key to check against, a
+	// signature made with an empty key proves nothing.
+	ErrProxyNoSecret = errors.New("pemasak: no proxy secret to check the signature with")
+)
+
+// VerifyProxyRequest checks that r came through the platform proxy, signed
+// with secret at most maxAge ago. The signature covers the method, host, path
+// with the query, X-Request-Id and X-Forwarded-For as the app gets them, after
+// the header rules of the app, so once it is checked the client address can be
+// trusted. The body isn't signed.
+func VerifyProxyRequest(r *http.Request, secret string, maxAge time.Duration) error {
+	if secret == "" {
+		return ErrProxyNoSecret
+	}
+	timestamp := r.Header.Get(ProxyTimestampHeader)
+	signatures := r.Header.Get(ProxySignatureHeader)
+	if timestamp == "" || signatures == "" {
+		return ErrProxyUnsigned
+	}
+
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return ErrProxyUnsigned
+	}
+	age := time.Since(time.Unix(seconds, 0))
+	if age > maxAge || age < -maxAge {
+		return ErrProxyExpired
+	}
+
+	host := r.Header.Get("X-Forwarded-Host")
+	if host == "" {
+		host = r.Host
+	}
+	path := r.RequestURI
+	if path == "" {
+		path = r.URL.RequestURI()
+	}
+	payload := strings.Join([]string{
+		timestamp,
+		r.Method,
+		host,
+		path,
+		r.Header.Get("X-Request-Id"),
+		r.Header.Get("X-Forwarded-For"),
+	}, "\n")
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(payload))
+	want := mac.Sum(nil)
+
+	// while a key is rotated the request carries a signature of each
+	for _, signature := range strings.Split(signatures, ",") {
+		hexSum, ok := strings.CutPrefix(strings.TrimSpace(signature), "sha256=")
+		if !ok {
+			continue
+		}
+		got, err := hex.DecodeString(hexSum)
+		if err == nil && hmac.Equal(got, want) {
+			return nil
+		}
+	}
+	return ErrProxySignature
+}
+
+// ProxyMiddleware answers 403 to requests that didn't come through the
+// platform proxy and passes the rest to next. An empty secret is read from
+// PEMASAK_PROXY_SECRET, which the platform sets in the containers of the app.
+// Without either every request gets 403.
+//
+//	http.ListenAndServe(":"+os.Getenv("PORT"), pemasak.ProxyMiddleware("", mux))
+func ProxyMiddleware(secret string, next http.Handler) http.Handler {
+	if secret == "" {
+		secret = os.Getenv(ProxySecretEnv)
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := VerifyProxyRequest(r, secret, DefaultProxyMaxAge); err != nil {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// ProxySecretRotation is when an app got a new key.
+type ProxySecretRotation struct {
+	RotatedAt time.Time `json:"rotated_at"`
+	// PreviousValidUntil is until when the proxy signs with the key before
+	// too, the new release of the app is live well before.
+	PreviousValidUntil time.Time `json:"previous_valid_until"`
+}
+
+// RotateProxySecret gives an app a new key for checking that requests came
+// through the proxy and releases it again with it.
+func (c *Client) RotateProxySecret(ctx context.Context, owner, project string) (*ProxySecretRotation, error) {
+	var res ProxySecretRotation
+	err := c.do(ctx, request{method: http.MethodPost, path: projectPath(owner, project, "proxy-secret", "rotate")}, &res)
+	if err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
@@ -0,0 +1,169 @@
+package pemasak
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testProxySecret = "0f1e2d3c4b5a69788796a5b4c3d2e1f0"
+
+// signProxyRequest signs r like the platform proxy does, from the headers it
+// forwards.
+func signProxyRequest(r *http.Request, secret string, at time.Time) {
+	timestamp := strconv.FormatInt(at.Unix(), 10)
+	host := r.Header.Get("X-Forwarded-Host")
+	if host == "" {
+		host = r.Host
+	}
+	payload := strings.Join([]string{
+		timestamp,
+		r.Method,
+		host,
+		r.URL.RequestURI(),
+		r.Header.Get("X-Request-Id"),
+		r.Header.Get("X-Forwarded-For"),
+	}, "\n")
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(payload))
+	r.Header.Set(ProxyTimestampHeader, timestamp)
+	r.Header.Set(ProxySignatureHeader, "sha256="+hex.EncodeToString(mac.Sum(nil)))
+}
+
+func newProxyRequest() *http.Request {
+	// the path as a server gets it, not the absolute url a client sends to a proxy
+	r := httptest.NewRequest(http.MethodGet, "/users?page=2", nil)
+	r.Host = "api.pemasak.test"
+	r.Header.Set("X-Forwarded-Host", "api.pemasak.test")
+	r.Header.Set("X-Forwarded-For", "203.0.113.7")
+	r.Header.Set("X-Request-Id", "01HF0000000000000000000000")
+	return r
+}
+
+func TestVerifyProxyRequest(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name    string
+		secret  string
+		prepare func(r *http.Request)
+		want    error
+	}{
+		{
+			name:    "valid signature",
+			secret:  testProxySecret,
+			prepare: func(r *http.Request) { signProxyRequest(r, testProxySecret, now) },
+		},
+		{
+			name:   "valid signature of a rotated key",
+			secret: testProxySecret,
+			prepare: func(r *http.Request) {
+				signProxyRequest(r, "previous", now)
+				previous := r.Header.Get(ProxySignatureHeader)
+				signProxyRequest(r, testProxySecret, now)
+				r.Header.Set(ProxySignatureHeader, previous+", "+r.Header.Get(ProxySignatureHeader))
+			},
+		},
+		{
+			name:   "tampered client address",
+			secret: testProxySecret,
+			prepare: func(r *http.Request) {
+				signProxyRequest(r, testProxySecret, now)
+				r.Header.Set("X-Forwarded-For", "198.51.100.1")
+			},
+			want: ErrProxySignature,
+		},
+		{
+			name:   "tampered request id",
+			secret: testProxySecret,
+			prepare: func(r *http.Request) {
+				signProxyRequest(r, testProxySecret, now)
+				r.Header.Set("X-Request-Id", "forged")
+			},
+			want: ErrProxySignature,
+		},
+		{
+			name:    "signed with another key",
+			secret:  testProxySecret,
+			prepare: func(r *http.Request) { signProxyRequest(r, "another", now) },
+			want:    ErrProxySignature,
+		},
+		{
+			name:    "stale timestamp",
+			secret:  testProxySecret,
+			prepare: func(r *http.Request) { signProxyRequest(r, testProxySecret, now.Add(-10*time.Minute)) },
+			want:    ErrProxyExpired,
+		},
+		{
+			name:    "timestamp in the future",
+			secret:  testProxySecret,
+			prepare: func(r *http.Request) { signProxyRequest(r, testProxySecret, now.Add(10*time.Minute)) },
+			want:    ErrProxyExpired,
+		},
+		{
+			name:    "unsigned",
+			secret:  testProxySecret,
+			prepare: func(r *http.Request) {},
+			want:    ErrProxyUnsigned,
+		},
+		{
+			name:    "empty secret",
+			secret:  "",
+			prepare: func(r *http.Request) { signProxyRequest(r, "", now) },
+			want:    ErrProxyNoSecret,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newProxyRequest()
+			tt.prepare(r)
+			err := VerifyProxyRequest(r, tt.secret, DefaultProxyMaxAge)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("VerifyProxyRequest() = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestProxyMiddleware(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	tests := []struct {
+		name     string
+		secret   string
+		env      string
+		unsigned bool
+		want     int
+	}{
+		{name: "signed", secret: testProxySecret, want: http.StatusNoContent},
+		{name: "secret from the environment", env: testProxySecret, want: http.StatusNoContent},
+		{name: "unsigned", secret: testProxySecret, unsigned: true, want: http.StatusForbidden},
+		// signed with the empty key too, which anyone could do
+		{name: "no secret at all", want: http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv(ProxySecretEnv, tt.env)
+			r := newProxyRequest()
+			if !tt.unsigned {
+				signProxyRequest(r, tt.secret+tt.env, time.Now())
+			}
+			w := httptest.NewRecorder()
+			ProxyMiddleware(tt.secret, next).ServeHTTP(w, r)
+			if w.Code != tt.want {
+				t.Fatalf("status = %d, want %d", w.Code, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,70 @@
+package pemasak
+
+import (
+	"context"
+	"net/http"
+)
+
+// PushPolicy is what a push to an app has to pass before git takes it. A
+// push that fails it is rejected whole, with the reason shown by git push.
+type PushPolicy struct {
+	// Branches besides the default one that may be pushed, like
+	// feature/*. Empty allows any.
+	Branches []string `json:"branches"`
+	// MaxSize is the biggest push in MiB, zero leaves it to the platform.
+	MaxSize int `json:"max_size,omitempty"`
+	// ScanSecrets rejects pushes adding lines that look like credentials,
+	// like private keys or cloud access keys. A line with pmk:allow-secret
+	// in it is let through.
+	ScanSecrets bool `json:"scan_secrets"`
+	// PlatformMaxSize is the limit of the platform in MiB, zero without
+	// one. The lower of the two counts.
+	PlatformMaxSize int `json:"platform_max_size,omitempty"`
+	// PlatformScanSecrets is whether the platform scans every push anyway.
+	PlatformScanSecrets bool `json:"platform_scan_secrets,omitempty"`
+}
+
+// GetPushPolicy returns the push policy of an app.
+func (c *Client) GetPushPolicy(ctx context.Context, owner, project string) (*PushPolicy, error) {
+	var res struct {
+		PushPolicy
+		MaxSize         *int `json:"max_size"`
+		PlatformMaxSize *int `json:"platform_max_size"`
+	}
+	err := c.do(ctx, request{method: http.MethodGet, path: projectPath(owner, project, "push-policy"), idempotent: true}, &res)
+	if err != nil {
+		return nil, err
+	}
+	if res.MaxSize != nil {
+		res.PushPolicy.MaxSize = *res.MaxSize
+	}
+	if res.PlatformMaxSize != nil {
+		res.PushPolicy.PlatformMaxSize = *res.PlatformMaxSize
+	}
+	return &res.PushPolicy, nil
+}
+
+// SetPushPolicy replaces the push policy of an app. The platform fields
+// are ignored.
+func (c *Client) SetPushPolicy(ctx context.Context, owner, project string, policy PushPolicy) error {
+	body := struct {
+		Branches    []string `json:"branches"`
+		MaxSize     *int     `json:"max_size"`
+		ScanSecrets bool     `json:"scan_secrets"`
+	}{
+		Branches:    policy.Branches,
+		ScanSecrets: policy.ScanSecrets,
+	}
+	if body.Branches == nil {
+		body.Branches = []string{}
+	}
+	if policy.MaxSize > 0 {
+		body.MaxSize = &policy.MaxSize
+	}
+	return c.do(ctx, request{
+		method:     http.MethodPost,
+		path:       projectPath(owner, project, "push-policy"),
+		body:       body,
+		idempotent: true,
+	}, nil)
+}
@@ -0,0 +1,90 @@
+package pemasak
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+)
+
+// Quotas are what one account may have. An app counts against every user
+// who owns its owner.
+type Quotas struct {
+	Apps int `json:"apps"`
+	// Builds is how many builds may run at once, more wait in the queue.
+	Builds int `json:"builds"`
+	// StorageMB is the release images and volumes together.
+	StorageMB int `json:"storage"`
+	// DatabaseMB is the data of the postgres addons together.
+	DatabaseMB int `json:"database"`
+	// Ports is the tcp and udp ports leased to the apps.
+	Ports int `json:"ports"`
+}
+
+// Usage is what an account has right now.
+type Usage struct {
+	Apps   int `json:"apps"`
+	Builds int `json:"builds"`
+	// ImagesMB is what the release images take on disk.
+	ImagesMB int `json:"images"`
+	// VolumesMB is the sizes of the volumes, whether they hold that much or
+	// not.
+	VolumesMB  int `json:"volumes"`
+	StorageMB  int `json:"storage"`
+	DatabaseMB int `json:"database"`
+	Ports      int `json:"ports"`
+}
+
+// QuotaOverrides are quotas a platform admin set on a user. A nil field
+// leaves the quota to the platform.
+type QuotaOverrides struct {
+	Apps       *int `json:"apps"`
+	Builds     *int `json:"builds"`
+	StorageMB  *int `json:"storage"`
+	DatabaseMB *int `json:"database"`
+	Ports      *int `json:"ports"`
+}
+
+// AccountQuotas are the quotas of an account next to what it uses.
+type AccountQuotas struct {
+	Username  string         `json:"username"`
+	Quotas    Quotas         `json:"quotas"`
+	Usage     Usage          `json:"usage"`
+	Overrides QuotaOverrides `json:"overrides"`
+}
+
+// Quotas returns the quotas of the logged in user and what their account
+// uses. Docker has to measure the images and volumes, so it takes a while.
+func (c *Client) Quotas(ctx context.Context) (*AccountQuotas, error) {
+	var res AccountQuotas
+	err := c.do(ctx, request{method: http.MethodGet, path: "/api/quotas", idempotent: true}, &res)
+	if err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+func userQuotasPath(username string) string {
+	return "/api/admin/users/" + url.PathEscape(username) + "/quotas"
+}
+
+// UserQuotas returns the quotas of any user, for platform admins.
+func (c *Client) UserQuotas(ctx context.Context, username string) (*AccountQuotas, error) {
+	var res AccountQuotas
+	err := c.do(ctx, request{method: http.MethodGet, path: userQuotasPath(username), idempotent: true}, &res)
+	if err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// SetUserQuotas replaces the quotas set on a user, for platform admins. A
+// quota lower than what the account has takes nothing away, it only stops
+// the account from growing.
+func (c *Client) SetUserQuotas(ctx context.Context, username string, quotas QuotaOverrides) (*AccountQuotas, error) {
+	var res AccountQuotas
+	err := c.do(ctx, request{method: http.MethodPost, path: userQuotasPath(username), body: quotas, idempotent: true}, &res)
+	if err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
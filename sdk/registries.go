@@ -0,0 +1,52 @@
+package pemasak
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// RegistryCredential is the login images of a project are pulled from a
+// private registry with. The password is never returned.
+type RegistryCredential struct {
+	// Registry is the host as it appears in image references, docker.io for
+	// Docker Hub.
+	Registry  string    `json:"registry"`
+	Username  string    `json:"username"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ListRegistryCredentials returns the registry logins of a project.
+func (c *Client) ListRegistryCredentials(ctx context.Context, owner, project string) ([]RegistryCredential, error) {
+	var res struct {
+		Data []RegistryCredential `json:"data"`
+	}
+	err := c.do(ctx, request{method: http.MethodGet, path: projectPath(owner, project, "registries"), idempotent: true}, &res)
+	if err != nil {
+		return nil, err
+	}
+	return res.Data, nil
+}
+
+// SetRegistryCredential stores the login for pulling images of a project from
+// registry, replacing an earlier one. The password can be an access token and
+// is stored encrypted.
+func (c *Client) SetRegistryCredential(ctx context.Context, owner, project, registry, username, password string) error {
+	return c.do(ctx, request{
+		method:     http.MethodPost,
+		path:       projectPath(owner, project, "registries"),
+		body:       map[string]string{"registry": registry, "username": username, "password": password},
+		idempotent: true,
+	}, nil)
+}
+
+// RemoveRegistryCredential forgets the login for registry, its images are
+// pulled anonymously again.
+func (c *Client) RemoveRegistryCredential(ctx context.Context, owner, project, registry string) error {
+	return c.do(ctx, request{
+		method:     http.MethodPost,
+		path:       projectPath(owner, project, "registries", "delete"),
+		body:       map[string]string{"registry": registry},
+		idempotent: true,
+	}, nil)
+}
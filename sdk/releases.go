@@ -0,0 +1,210 @@
+package pemasak
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Release is a successful deploy whose image is kept for rollbacks. Builds,
+// rollbacks and environment changes each make a new release.
+type Release struct {
+	ID      string `json:"id"`
+	BuildID string `json:"build_id"`
+	Image   string `json:"image"`
+	// CommitSHA is the commit the image was built from, empty for images
+	// pushed without a build.
+	CommitSHA string `json:"commit_sha"`
+	// ConfigDigest is the sha256 of the config the release starts with. Two
+	// releases with the same digest run the same way.
+	ConfigDigest string `json:"config_digest"`
+	// Live is the release serving requests now.
+	Live bool `json:"live"`
+	// Pinned is the release the app is pinned to, deploys are refused until
+	// it is unpinned.
+	Pinned bool `json:"pinned"`
+	// Description says what made the release, like "Build" or "Set KEY".
+	Description string    `json:"description"`
+	CreatedAt   time.Time `json:"created_at"`
+	// ExitCode is the last exit of a container of the release that wasn't
+	// stopped by the platform, nil when none exited. 137 with OOMKilled is
+	// the memory limit, a panic usually exits with 101.
+	ExitCode *int `json:"exit_code"`
+	// ExitSignal is the signal that ended it, like SIGSEGV or SIGABRT.
+	ExitSignal string     `json:"exit_signal"`
+	OOMKilled  bool       `json:"oom_killed"`
+	ExitedAt   *time.Time `json:"exited_at"`
+}
+
+// ListReleases returns every release of a project, newest first. It fetches
+// page after page, use ListReleasesPage for one.
+func (c *Client) ListReleases(ctx context.Context, owner, project string) ([]Release, error) {
+	return collect(ctx, func(page ListOptions) (Page[Release], error) {
+		return c.ListReleasesPage(ctx, owner, project, page)
+	})
+}
+
+// ListReleasesPage returns a page of the releases of a project, newest first.
+func (c *Client) ListReleasesPage(ctx context.Context, owner, project string, page ListOptions) (Page[Release], error) {
+	q := url.Values{}
+	page.set(q)
+
+	var res Page[Release]
+	err := c.do(ctx, request{method: http.MethodGet, path: pathWithQuery(projectPath(owner, project, "releases"), q), idempotent: true}, &res)
+	return res, err
+}
+
+// Vulnerability is a vulnerable package found in the image of a release.
+type Vulnerability struct {
+	// ID is the advisory, like CVE-2024-3094.
+	ID        string `json:"id"`
+	Package   string `json:"package"`
+	Installed string `json:"installed"`
+	// Fixed is the first version without it, empty while there is no fix.
+	Fixed string `json:"fixed"`
+	// Severity is critical, high, medium, low or unknown.
+	Severity string `json:"severity"`
+	Title    string `json:"title"`
+}
+
+// Scan is what the vulnerability scan of an image found, the most severe
+// findings first.
+type Scan struct {
+	Critical int             `json:"critical"`
+	High     int             `json:"high"`
+	Medium   int             `json:"medium"`
+	Low      int             `json:"low"`
+	Unknown  int             `json:"unknown"`
+	Findings []Vulnerability `json:"findings"`
+	// Error says why the scan didn't finish, the counts are empty then.
+	Error     string    `json:"error"`
+	ScannedAt time.Time `json:"scanned_at"`
+}
+
+// ReleaseInfo is a release with the scan of its image.
+type ReleaseInfo struct {
+	Release
+	// Scan is nil when the image wasn't scanned.
+	Scan *Scan `json:"scan"`
+}
+
+// GetRelease returns a release of a project and the vulnerabilities found
+// in its image.
+func (c *Client) GetRelease(ctx context.Context, owner, project, releaseID string) (*ReleaseInfo, error) {
+	var res ReleaseInfo
+	err := c.do(ctx, request{
+		method:     http.MethodGet,
+		path:       projectPath(owner, project, "releases", url.PathEscape(releaseID)),
+		idempotent: true,
+	}, &res)
+	if err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// Rollback queues a deploy of an earlier release. No build runs, the release
+// image is started with the environment it had when it was released.
+func (c *Client) Rollback(ctx context.Context, owner, project, releaseID string) error {
+	return c.do(ctx, request{
+		method: http.MethodPost,
+		path:   projectPath(owner, project, "releases", url.PathEscape(releaseID), "rollback"),
+	}, nil)
+}
+
+// ReleaseSummary is one side of a ReleaseDiff.
+type ReleaseSummary struct {
+	ID           string    `json:"id"`
+	BuildID      string    `json:"build_id"`
+	Image        string    `json:"image"`
+	CommitSHA    string    `json:"commit_sha"`
+	ConfigDigest string    `json:"config_digest"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// EnvChange is an environment variable that differs between two releases.
+// From or To is nil where the variable isn't set.
+type EnvChange struct {
+	Name string  `json:"name"`
+	From *string `json:"from"`
+	To   *string `json:"to"`
+}
+
+// SecretChange is a secret that differs between two releases. Values of
+// secrets are never returned.
+type SecretChange struct {
+	Name string `json:"name"`
+	// Change is added, removed or changed.
+	Change string `json:"change"`
+}
+
+// FieldChange is any other part of the config that differs, like the
+// command, the limits or the volumes.
+type FieldChange struct {
+	Field string          `json:"field"`
+	From  json.RawMessage `json:"from"`
+	To    json.RawMessage `json:"to"`
+}
+
+// ReleaseDiff is what changed from one release to another.
+type ReleaseDiff struct {
+	From    ReleaseSummary `json:"from"`
+	To      ReleaseSummary `json:"to"`
+	Env     []EnvChange    `json:"env"`
+	Secrets []SecretChange `json:"secrets"`
+	Config  []FieldChange  `json:"config"`
+}
+
+// DiffReleases compares the release fromID of a project with toID.
+func (c *Client) DiffReleases(ctx context.Context, owner, project, fromID, toID string) (*ReleaseDiff, error) {
+	var res ReleaseDiff
+	err := c.do(ctx, request{
+		method:     http.MethodGet,
+		path:       projectPath(owner, project, "releases", url.PathEscape(fromID), "diff", url.PathEscape(toID)),
+		idempotent: true,
+	}, &res)
+	if err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// PinRelease pins a project to a release. A release that isn't live is
+// rolled back to first. Until it is unpinned builds and rollbacks to other
+// releases fail, environment changes still restart the pinned release.
+func (c *Client) PinRelease(ctx context.Context, owner, project, releaseID string) error {
+	return c.do(ctx, request{
+		method:     http.MethodPost,
+		path:       projectPath(owner, project, "releases", url.PathEscape(releaseID), "pin"),
+		idempotent: true,
+	}, nil)
+}
+
+// UnpinRelease lets a project pinned to releaseID deploy again.
+func (c *Client) UnpinRelease(ctx context.Context, owner, project, releaseID string) error {
+	return c.do(ctx, request{
+		method:     http.MethodPost,
+		path:       projectPath(owner, project, "releases", url.PathEscape(releaseID), "unpin"),
+		idempotent: true,
+	}, nil)
+}
+
+// PromoteRelease queues a deploy of a release of one project to another app,
+// given as owner/project, like a staging release to production. The image
+// is not rebuilt, it starts with the environment, secrets and services of
+// the app it is promoted to. The caller must be a maintainer of both. An app
+// with promotion approval only runs it once another member approves.
+func (c *Client) PromoteRelease(ctx context.Context, owner, project, releaseID, app string) (*PromotionResult, error) {
+	var res PromotionResult
+	err := c.do(ctx, request{
+		method: http.MethodPost,
+		path:   projectPath(owner, project, "releases", url.PathEscape(releaseID), "promote"),
+		body:   map[string]string{"app": app},
+	}, &res)
+	if err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
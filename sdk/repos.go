@@ -0,0 +1,67 @@
+package pemasak
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// LinkedRepo is a GitHub or GitLab repository whose pushes to Branch are
+// deployed, next to pushes to the platform itself.
+type LinkedRepo struct {
+	// Provider is github or gitlab.
+	Provider string `json:"provider"`
+	URL      string `json:"url"`
+	Branch   string `json:"branch"`
+	// WebhookURL and WebhookSecret are what the push webhook of the
+	// repository is registered with.
+	WebhookURL    string `json:"webhook_url"`
+	WebhookSecret string `json:"webhook_secret"`
+	// LastCommit and LastSyncedAt are empty until the first push arrived.
+	LastCommit   string     `json:"last_commit"`
+	LastSyncedAt *time.Time `json:"last_synced_at"`
+}
+
+// LinkRepoOptions configure the repository a project deploys from.
+type LinkRepoOptions struct {
+	// Provider is github or gitlab.
+	Provider string `json:"provider"`
+	// URL is the https clone url of the repository.
+	URL    string `json:"url"`
+	Branch string `json:"branch"`
+	// Token is an access token for private repositories.
+	Token string `json:"token,omitempty"`
+}
+
+// GetLinkedRepo returns the repository a project deploys from, nil when it
+// has none.
+func (c *Client) GetLinkedRepo(ctx context.Context, owner, project string) (*LinkedRepo, error) {
+	var res struct {
+		Data *LinkedRepo `json:"data"`
+	}
+	err := c.do(ctx, request{method: http.MethodGet, path: projectPath(owner, project, "repo"), idempotent: true}, &res)
+	if err != nil {
+		return nil, err
+	}
+	return res.Data, nil
+}
+
+// LinkRepo deploys pushes to a branch of a GitHub or GitLab repository once
+// its webhook is registered, see GetLinkedRepo for the url and secret. Linking
+// again replaces the repository and keeps the webhook secret.
+func (c *Client) LinkRepo(ctx context.Context, owner, project string, opts LinkRepoOptions) error {
+	return c.do(ctx, request{
+		method:     http.MethodPost,
+		path:       projectPath(owner, project, "repo"),
+		body:       opts,
+		idempotent: true,
+	}, nil)
+}
+
+// UnlinkRepo stops deploying pushes from the linked repository.
+func (c *Client) UnlinkRepo(ctx context.Context, owner, project string) error {
+	return c.do(ctx, request{
+		method: http.MethodPost,
+		path:   projectPath(owner, project, "repo", "delete"),
+	}, nil)
+}
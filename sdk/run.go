@@ -0,0 +1,224 @@
+package pemasak
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// ErrKilled is returned by Run when the platform killed the command after
+// its run timeout, and by Exec when the exit code is unknown.
+var ErrKilled = errors.New("pemasak: command was killed")
+
+// TerminalSize is the size of a terminal in characters.
+type TerminalSize struct {
+	Width  int
+	Height int
+}
+
+// RunOptions describes a command for Run and Exec.
+type RunOptions struct {
+	// Command is run without a shell, wrap it in sh -c for pipes and
+	// variables.
+	Command []string
+	// TTY gives the command a terminal. Its output then all arrives on
+	// Stdout and Stdin should be in raw mode.
+	TTY bool
+	// Size is the initial terminal size when TTY is set.
+	Size TerminalSize
+	// Resize delivers terminal size changes when TTY is set.
+	Resize <-chan TerminalSize
+
+	// Stdin is copied to the command until it returns io.EOF, which closes
+	// the command's stdin. Nil means the command gets an empty stdin.
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+}
+
+const (
+	streamStdout = 1
+	streamStderr = 2
+)
+
+type runMessage struct {
+	Type     string   `json:"type"`
+	Command  []string `json:"command,omitempty"`
+	TTY      bool     `json:"tty,omitempty"`
+	Width    int      `json:"width,omitempty"`
+	Height   int      `json:"height,omitempty"`
+	ExitCode *int     `json:"exit_code,omitempty"`
+	Message  string   `json:"message,omitempty"`
+}
+
+// Run starts a one-off container from the live release of a project with the
+// app's environment, streams stdin and output until the command exits and
+// returns its exit code. Cancelling ctx removes the container.
+func (c *Client) Run(ctx context.Context, owner, project string, opts RunOptions) (int, error) {
+	return c.session(ctx, projectPath(owner, project, "run", "ws"), opts)
+}
+
+// Exec runs a command inside the running container of a project, like a shell
+// for debugging, and returns its exit code. Unlike Run it shares the
+// container with the app, and the command is hung up when ctx is cancelled.
+func (c *Client) Exec(ctx context.Context, owner, project string, opts RunOptions) (int, error) {
+	return c.session(ctx, projectPath(owner, project, "exec", "ws"), opts)
+}
+
+// session streams a command over the websocket at path. Run and Exec speak the
+// same messages, only where the command runs differs.
+func (c *Client) session(ctx context.Context, path string, opts RunOptions) (int, error) {
+	if len(opts.Command) == 0 {
+		return 0, errors.New("pemasak: no command given")
+	}
+
+	u := *c.baseURL
+	u.Scheme = strings.Replace(u.Scheme, "http", "ws", 1)
+	u.Path += path
+
+	dialer := websocket.Dialer{
+		Proxy:            http.ProxyFromEnvironment,
+		HandshakeTimeout: 30 * time.Second,
+		Jar:              c.httpClient.Jar,
+	}
+	header := http.Header{}
+	c.setAuth(header)
+	conn, resp, err := dialer.DialContext(ctx, u.String(), header)
+	if err != nil {
+		if resp != nil {
+			if err := decode(resp, nil); err != nil {
+				return 0, err
+			}
+		}
+		return 0, fmt.Errorf("pemasak: connect: %w", err)
+	}
+	defer conn.Close()
+
+	// gorilla allows a single concurrent writer
+	var mu sync.Mutex
+	write := func(kind int, data []byte) error {
+		mu.Lock()
+		defer mu.Unlock()
+		return conn.WriteMessage(kind, data)
+	}
+	writeJSON := func(msg runMessage) error {
+		data, err := json.Marshal(msg)
+		if err != nil {
+			return err
+		}
+		return write(websocket.TextMessage, data)
+	}
+
+	err = writeJSON(runMessage{
+		Type:    "start",
+		Command: opts.Command,
+		TTY:     opts.TTY,
+		Width:   opts.Size.Width,
+		Height:  opts.Size.Height,
+	})
+	if err != nil {
+		return 0, fmt.Errorf("pemasak: start command: %w", err)
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	go func() {
+		if opts.Stdin != nil {
+			buf := make([]byte, 32*1024)
+			for {
+				n, err := opts.Stdin.Read(buf)
+				if n > 0 {
+					if write(websocket.BinaryMessage, buf[:n]) != nil {
+						return
+					}
+				}
+				if err != nil {
+					break
+				}
+			}
+		}
+		writeJSON(runMessage{Type: "eof"})
+	}()
+
+	if opts.TTY && opts.Resize != nil {
+		go func() {
+			for {
+				select {
+				case size := <-opts.Resize:
+					if writeJSON(runMessage{Type: "resize", Width: size.Width, Height: size.Height}) != nil {
+						return
+					}
+				case <-done:
+					return
+				}
+			}
+		}()
+	}
+
+	stdout, stderr := opts.Stdout, opts.Stderr
+	if stdout == nil {
+		stdout = io.Discard
+	}
+	if stderr == nil {
+		stderr = io.Discard
+	}
+
+	for {
+		kind, data, err := conn.ReadMessage()
+		if err != nil {
+			if ctx.Err() != nil {
+				return 0, ctx.Err()
+			}
+			return 0, fmt.Errorf("pemasak: connection closed before the command exited: %w", err)
+		}
+
+		switch kind {
+		case websocket.BinaryMessage:
+			if len(data) == 0 {
+				continue
+			}
+			var out io.Writer
+			switch data[0] {
+			case streamStdout:
+				out = stdout
+			case streamStderr:
+				out = stderr
+			default:
+				continue
+			}
+			if _, err := out.Write(data[1:]); err != nil {
+				return 0, err
+			}
+		case websocket.TextMessage:
+			var msg runMessage
+			if err := json.Unmarshal(data, &msg); err != nil {
+				return 0, fmt.Errorf("pemasak: decode message: %w", err)
+			}
+			switch msg.Type {
+			case "exit":
+				if msg.ExitCode == nil {
+					return 0, ErrKilled
+				}
+				return *msg.ExitCode, nil
+			case "error":
+				return 0, fmt.Errorf("pemasak: %s", msg.Message)
+			}
+		}
+	}
+}
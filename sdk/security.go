@@ -0,0 +1,146 @@
+package pemasak
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// TwoFactor is whether the account asks for an authenticator app code on
+// login.
+type TwoFactor struct {
+	// Enabled is false until a setup was confirmed with a code.
+	Enabled           bool       `json:"enabled"`
+	RecoveryCodesLeft int        `json:"recovery_codes_left"`
+	ConfirmedAt       *time.Time `json:"confirmed_at"`
+}
+
+// TwoFactorSetup is the secret of an authenticator app being set up.
+type TwoFactorSetup struct {
+	// Secret is base32, for apps that take it typed in.
+	Secret string `json:"secret"`
+	// OtpauthURL is what apps scan from a QR code.
+	OtpauthURL string `json:"otpauth_url"`
+}
+
+// Session is a login of the user, in a browser or pmk.
+type Session struct {
+	ID        string `json:"id"`
+	IP        string `json:"ip"`
+	UserAgent string `json:"user_agent"`
+	// Current is the session the client uses.
+	Current    bool      `json:"current"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+}
+
+// EventNewLogin is what login hooks receive, when the user logs in from a
+// device they haven't logged in from before.
+const EventNewLogin = "user.new_login"
+
+// LoginHook is where the user is told about logins from new devices. Kinds
+// and signatures are the same as for NotificationHook.
+type LoginHook struct {
+	ID   string `json:"id"`
+	Kind string `json:"kind"`
+	// URL has its secret part masked.
+	URL string `json:"url"`
+	// Secret is empty for slack and discord.
+	Secret    string    `json:"secret"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// TwoFactor returns whether two-factor authentication is on.
+func (c *Client) TwoFactor(ctx context.Context) (*TwoFactor, error) {
+	var res TwoFactor
+	err := c.do(ctx, request{method: http.MethodGet, path: "/api/2fa", idempotent: true}, &res)
+	if err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// EnableTwoFactor starts setting up an authenticator app. Logins don't ask
+// for codes until ConfirmTwoFactor got one.
+func (c *Client) EnableTwoFactor(ctx context.Context) (*TwoFactorSetup, error) {
+	var res TwoFactorSetup
+	err := c.do(ctx, request{method: http.MethodPost, path: "/api/2fa"}, &res)
+	if err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// ConfirmTwoFactor turns two-factor authentication on with a code of the
+// app and returns the recovery codes, they are only shown once.
+func (c *Client) ConfirmTwoFactor(ctx context.Context, code string) ([]string, error) {
+	var res struct {
+		RecoveryCodes []string `json:"recovery_codes"`
+	}
+	err := c.do(ctx, request{method: http.MethodPost, path: "/api/2fa/confirm", body: map[string]string{"code": code}}, &res)
+	if err != nil {
+		return nil, err
+	}
+	return res.RecoveryCodes, nil
+}
+
+// DisableTwoFactor turns two-factor authentication off, with a code of the
+// app or a recovery code.
+func (c *Client) DisableTwoFactor(ctx context.Context, code string) error {
+	return c.do(ctx, request{method: http.MethodPost, path: "/api/2fa/delete", body: map[string]string{"code": code}}, nil)
+}
+
+// ListSessions returns the sessions of the user that are logged in, the
+// most recently used first.
+func (c *Client) ListSessions(ctx context.Context) ([]Session, error) {
+	var res struct {
+		Data []Session `json:"data"`
+	}
+	err := c.do(ctx, request{method: http.MethodGet, path: "/api/sessions", idempotent: true}, &res)
+	if err != nil {
+		return nil, err
+	}
+	return res.Data, nil
+}
+
+// RevokeSession logs a session out on its next request.
+func (c *Client) RevokeSession(ctx context.Context, id string) error {
+	return c.do(ctx, request{
+		method:     http.MethodPost,
+		path:       "/api/sessions/" + url.PathEscape(id) + "/revoke",
+		idempotent: true,
+	}, nil)
+}
+
+// ListLoginHooks returns the login hooks of the user.
+func (c *Client) ListLoginHooks(ctx context.Context) ([]LoginHook, error) {
+	var res struct {
+		Data []LoginHook `json:"data"`
+	}
+	err := c.do(ctx, request{method: http.MethodGet, path: "/api/login-hooks", idempotent: true}, &res)
+	if err != nil {
+		return nil, err
+	}
+	return res.Data, nil
+}
+
+// AddLoginHook tells hookURL about logins from new devices. Kind is webhook,
+// slack or discord.
+func (c *Client) AddLoginHook(ctx context.Context, kind, hookURL string) (*LoginHook, error) {
+	var res LoginHook
+	err := c.do(ctx, request{method: http.MethodPost, path: "/api/login-hooks", body: map[string]string{"kind": kind, "url": hookURL}}, &res)
+	if err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// DeleteLoginHook removes a login hook.
+func (c *Client) DeleteLoginHook(ctx context.Context, id string) error {
+	return c.do(ctx, request{
+		method:     http.MethodPost,
+		path:       "/api/login-hooks/" + url.PathEscape(id) + "/delete",
+		idempotent: true,
+	}, nil)
+}
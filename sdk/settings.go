@@ -0,0 +1,193 @@
+package pemasak
+
+import (
+	"context"
+	"net/http"
+)
+
+// Restart policies of Settings.RestartPolicy.
+const (
+	RestartAlways    = "always"
+	RestartOnFailure = "on-failure"
+	RestartNever     = "never"
+)
+
+// Protocols of Settings.Protocol.
+const (
+	ProtocolHTTP1 = "http1"
+	// ProtocolH2C is HTTP/2 without TLS, what gRPC servers speak.
+	ProtocolH2C = "h2c"
+)
+
+// Settings are the per-project deploy settings.
+type Settings struct {
+	// HealthcheckPath is polled after each deploy until it answers 2xx.
+	// Empty means any HTTP response counts as ready.
+	HealthcheckPath string `json:"healthcheck_path"`
+	// IdleTimeout stops the app after this many minutes without traffic,
+	// the next request starts it again. Zero means the app never idles.
+	IdleTimeout int `json:"idle_timeout,omitempty"`
+	// SourceDir is the directory of the repository the app is built from,
+	// like services/api in a monorepo. Empty means the root.
+	SourceDir string `json:"source_dir"`
+	// WatchPaths limit which pushes are built to the ones changing files
+	// under them. Empty watches SourceDir, or every push without one.
+	WatchPaths []string `json:"watch_paths"`
+	// Internal keeps the app off its public subdomain. The other apps of the
+	// owner still reach it at http://PROJECT.internal on the private network.
+	Internal bool `json:"internal"`
+	// RestartPolicy is one of the Restart constants, empty is
+	// RestartOnFailure.
+	RestartPolicy string `json:"restart_policy,omitempty"`
+	// RestartRetries is how often a crashing container is restarted before
+	// it is left stopped, only for RestartOnFailure. Zero keeps restarting.
+	RestartRetries int `json:"restart_retries,omitempty"`
+	// Protocol is how the platform talks to the app, one of the Protocol
+	// constants. Empty is ProtocolHTTP1.
+	Protocol string `json:"protocol,omitempty"`
+	// ResponseBuffering reads whole responses of the app before sending them
+	// on, so slow clients don't keep it busy. Server-sent events and other
+	// streams are never buffered.
+	ResponseBuffering bool `json:"response_buffering"`
+	// ResponseTimeout is how many seconds the app gets to send the headers
+	// of a response before visitors get a 504. Zero waits.
+	ResponseTimeout int `json:"response_timeout,omitempty"`
+	// RateLimit is how many requests per second the platform lets through to
+	// the app, the rest get a 429. Zero keeps the limit of the platform,
+	// which is also the most an app gets.
+	RateLimit int `json:"rate_limit,omitempty"`
+	// IPRateLimit is the same for the requests of one client IP.
+	IPRateLimit int `json:"ip_rate_limit,omitempty"`
+	// RateBurst is how many requests over the rate are let through at once.
+	// Nil keeps the burst of the platform.
+	RateBurst *int `json:"rate_burst,omitempty"`
+	// BodyLimit is how many MiB a request body may have, bigger ones get a
+	// 413 without reaching the app. Zero keeps the limit of the platform,
+	// which is also the most an app gets.
+	BodyLimit int `json:"body_limit,omitempty"`
+	// BodyTimeout is how many seconds a client gets to send the body of a
+	// request, slower ones get a 408. Zero keeps the timeout of the platform.
+	BodyTimeout int `json:"body_timeout,omitempty"`
+	// StickySessions keeps each visitor on the replica they first got, with
+	// a cookie, so apps keeping sessions in memory work with more than one
+	// web process. Visitors move on when their replica goes down.
+	StickySessions bool `json:"sticky_sessions"`
+	// Compression makes the platform compress text responses of the app
+	// with gzip or brotli for clients accepting it. Nil compresses, set it
+	// to false for an app that compresses its responses itself.
+	Compression *bool `json:"compression,omitempty"`
+	// EdgeCache makes the platform keep responses of the app in memory for
+	// as long as their Cache-Control or Expires allows and answer later
+	// requests for them itself. See PurgeCache.
+	EdgeCache bool `json:"edge_cache"`
+	// HTTPSRedirect sends visitors coming over plain http to the same URL
+	// over https with a 308.
+	HTTPSRedirect bool `json:"https_redirect"`
+	// HSTSMaxAge is how many seconds browsers that got an https response
+	// only use https for the app, sent as Strict-Transport-Security. Zero
+	// sends no header.
+	HSTSMaxAge int `json:"hsts_max_age,omitempty"`
+	// HSTSPreload adds includeSubDomains and preload to the header, to
+	// submit the domain to the preload list of browsers. It needs an
+	// HSTSMaxAge of at least a year.
+	HSTSPreload bool `json:"hsts_preload"`
+	// Cloneable lets anyone signed in clone the app into an account of their
+	// own with CloneProject, like a reference app of a course, without its
+	// secrets. Members who can change the app clone it either way.
+	Cloneable bool `json:"cloneable"`
+	// BlockSeverity fails deploys of images with a vulnerability of this
+	// severity or worse: "critical", "high", "medium" or "low". Empty only
+	// reports them, see GetRelease.
+	BlockSeverity string `json:"block_severity,omitempty"`
+}
+
+// GetSettings returns the settings of a project.
+func (c *Client) GetSettings(ctx context.Context, owner, project string) (*Settings, error) {
+	var res struct {
+		HealthcheckPath   *string  `json:"healthcheck_path"`
+		IdleTimeout       *int     `json:"idle_timeout"`
+		SourceDir         *string  `json:"source_dir"`
+		WatchPaths        []string `json:"watch_paths"`
+		Internal          bool     `json:"internal"`
+		RestartPolicy     string   `json:"restart_policy"`
+		RestartRetries    *int     `json:"restart_retries"`
+		Protocol          string   `json:"protocol"`
+		ResponseBuffering bool     `json:"response_buffering"`
+		ResponseTimeout   *int     `json:"response_timeout"`
+		RateLimit         *int     `json:"rate_limit"`
+		IPRateLimit       *int     `json:"ip_rate_limit"`
+		RateBurst         *int     `json:"rate_burst"`
+		BodyLimit         *int     `json:"body_limit"`
+		BodyTimeout       *int     `json:"body_timeout"`
+		StickySessions    bool     `json:"sticky_sessions"`
+		Compression       bool     `json:"compression"`
+		EdgeCache         bool     `json:"edge_cache"`
+		HTTPSRedirect     bool     `json:"https_redirect"`
+		HSTSMaxAge        *int     `json:"hsts_max_age"`
+		HSTSPreload       bool     `json:"hsts_preload"`
+		Cloneable         bool     `json:"cloneable"`
+		BlockSeverity     *string  `json:"block_severity"`
+	}
+	err := c.do(ctx, request{method: http.MethodGet, path: projectPath(owner, project, "settings"), idempotent: true}, &res)
+	if err != nil {
+		return nil, err
+	}
+
+	var s Settings
+	if res.HealthcheckPath != nil {
+		s.HealthcheckPath = *res.HealthcheckPath
+	}
+	if res.IdleTimeout != nil {
+		s.IdleTimeout = *res.IdleTimeout
+	}
+	if res.SourceDir != nil {
+		s.SourceDir = *res.SourceDir
+	}
+	s.WatchPaths = res.WatchPaths
+	s.Internal = res.Internal
+	s.RestartPolicy = res.RestartPolicy
+	if res.RestartRetries != nil {
+		s.RestartRetries = *res.RestartRetries
+	}
+	s.Protocol = res.Protocol
+	s.ResponseBuffering = res.ResponseBuffering
+	if res.ResponseTimeout != nil {
+		s.ResponseTimeout = *res.ResponseTimeout
+	}
+	if res.RateLimit != nil {
+		s.RateLimit = *res.RateLimit
+	}
+	if res.IPRateLimit != nil {
+		s.IPRateLimit = *res.IPRateLimit
+	}
+	s.RateBurst = res.RateBurst
+	if res.BodyLimit != nil {
+		s.BodyLimit = *res.BodyLimit
+	}
+	if res.BodyTimeout != nil {
+		s.BodyTimeout = *res.BodyTimeout
+	}
+	s.StickySessions = res.StickySessions
+	s.Compression = &res.Compression
+	s.EdgeCache = res.EdgeCache
+	s.HTTPSRedirect = res.HTTPSRedirect
+	if res.HSTSMaxAge != nil {
+		s.HSTSMaxAge = *res.HSTSMaxAge
+	}
+	s.HSTSPreload = res.HSTSPreload
+	s.Cloneable = res.Cloneable
+	if res.BlockSeverity != nil {
+		s.BlockSeverity = *res.BlockSeverity
+	}
+	return &s, nil
+}
+
+// UpdateSettings replaces the settings of a project.
+func (c *Client) UpdateSettings(ctx context.Context, owner, project string, s Settings) error {
+	return c.do(ctx, request{
+		method:     http.MethodPost,
+		path:       projectPath(owner, project, "settings"),
+		body:       s,
+		idempotent: true,
+	}, nil)
+}
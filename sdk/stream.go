@@ -0,0 +1,165 @@
+package pemasak
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// BuildLogChunk is a piece of build output received while streaming.
+type BuildLogChunk struct {
+	// Offset is the byte offset of the end of this chunk. Pass it to
+	// StreamBuildLog to resume after a disconnect.
+	Offset int64  `json:"offset"`
+	Log    string `json:"log"`
+	// Reset means the log was rewritten and Log replaces everything before.
+	Reset bool `json:"reset"`
+}
+
+// StreamBuildLog follows the log of a build starting at the given byte offset
+// and calls fn for every chunk until the build finishes, returning its final
+// status. Dropped connections are resumed from the last received offset.
+func (c *Client) StreamBuildLog(ctx context.Context, owner, project, buildID string, offset int64, fn func(BuildLogChunk) error) (BuildStatus, error) {
+	path := projectPath(owner, project, "builds", url.PathEscape(buildID), "stream")
+
+	// the stream outlives any sensible request timeout
+	hc := *c.httpClient
+	hc.Timeout = 0
+
+	failures := 0
+	for {
+		status, next, err := c.streamOnce(ctx, &hc, path, offset, fn)
+		if err == nil {
+			return status, nil
+		}
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+
+		var cbErr *callbackError
+		if errors.As(err, &cbErr) {
+			return "", cbErr.err
+		}
+		var apiErr *APIError
+		if errors.Is(err, ErrUnauthenticated) || (errors.As(err, &apiErr) && !retryable(apiErr.StatusCode)) {
+			return "", err
+		}
+
+		if next > offset {
+			failures = 0
+		}
+		offset = next
+		failures++
+		if failures > c.maxRetries {
+			return "", err
+		}
+		if err := sleep(ctx, c.backoff(failures)); err != nil {
+			return "", err
+		}
+	}
+}
+
+// callbackError carries an error returned by the caller's fn, which ends the
+// stream instead of triggering a reconnect.
+type callbackError struct{ err error }
+
+func (e *callbackError) Error() string { return e.err.Error() }
+
+func (c *Client) streamOnce(ctx context.Context, hc *http.Client, path string, offset int64, fn func(BuildLogChunk) error) (BuildStatus, int64, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL.String()+path, nil)
+	if err != nil {
+		return "", offset, err
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	req.Header.Set("Last-Event-ID", strconv.FormatInt(offset, 10))
+	c.setAuth(req.Header)
+
+	resp, err := hc.Do(req)
+	if err != nil {
+		return "", offset, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		if err := decode(resp, nil); err != nil {
+			return "", offset, err
+		}
+		return "", offset, fmt.Errorf("pemasak: unexpected status %d", resp.StatusCode)
+	}
+	defer resp.Body.Close()
+
+	var status BuildStatus
+	err = readEvents(resp.Body, func(name, payload string) (bool, error) {
+		switch name {
+		case "log":
+			var chunk BuildLogChunk
+			if err := json.Unmarshal([]byte(payload), &chunk); err != nil {
+				return false, fmt.Errorf("pemasak: decode log chunk: %w", err)
+			}
+			offset = chunk.Offset
+			if err := fn(chunk); err != nil {
+				return false, &callbackError{err}
+			}
+		case "done":
+			var done struct {
+				Status BuildStatus `json:"status"`
+			}
+			if err := json.Unmarshal([]byte(payload), &done); err != nil {
+				return false, fmt.Errorf("pemasak: decode build status: %w", err)
+			}
+			status = done.Status
+			return true, nil
+		case "error":
+			return false, &APIError{StatusCode: http.StatusNotFound, Message: payload}
+		}
+		return false, nil
+	})
+	return status, offset, err
+}
+
+// readEvents calls fn with the name and data of every server-sent event in
+// body until fn reports it is done or returns an error. A body that ends
+// before that is io.ErrUnexpectedEOF.
+func readEvents(body io.Reader, fn func(name, payload string) (bool, error)) error {
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+
+	var event string
+	var data []string
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case line == "":
+			payload := strings.Join(data, "\n")
+			name := event
+			event, data = "", nil
+			if name == "" && payload == "" {
+				continue
+			}
+			done, err := fn(name, payload)
+			if err != nil || done {
+				return err
+			}
+		case strings.HasPrefix(line, ":"):
+			// keep-alive comment
+		default:
+			field, value, _ := strings.Cut(line, ":")
+			value = strings.TrimPrefix(value, " ")
+			switch field {
+			case "event":
+				event = value
+			case "data":
+				data = append(data, value)
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return io.ErrUnexpectedEOF
+}
@@ -0,0 +1,93 @@
+package pemasak
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Template is a starting point for new apps, see Scaffold.
+type Template struct {
+	// Name is like "go-http" for the templates the platform ships with and
+	// "owner/name" for the ones an owner registered.
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Builtin     bool   `json:"builtin"`
+	// UpdatedAt is when a registered template last changed, nil for built in
+	// ones.
+	UpdatedAt *time.Time `json:"updated_at"`
+}
+
+// ScaffoldResult is the first commit Scaffold made.
+type ScaffoldResult struct {
+	Message  string `json:"message"`
+	Template string `json:"template"`
+	Commit   string `json:"commit"`
+}
+
+// RegisteredTemplate is a template SetTemplate registered.
+type RegisteredTemplate struct {
+	Name        string `json:"name"`
+	Project     string `json:"project"`
+	Description string `json:"description"`
+}
+
+// ListTemplates returns every template, the built in ones first.
+func (c *Client) ListTemplates(ctx context.Context) ([]Template, error) {
+	var res struct {
+		Data []Template `json:"data"`
+	}
+	err := c.do(ctx, request{method: http.MethodGet, path: "/api/templates", idempotent: true}, &res)
+	if err != nil {
+		return nil, err
+	}
+	return res.Data, nil
+}
+
+// Scaffold commits the files of a template as the first commit of a project
+// and deploys it. The project must not have been pushed to yet, so it is
+// usually called right after CreateProject.
+func (c *Client) Scaffold(ctx context.Context, owner, project, template string) (*ScaffoldResult, error) {
+	var res ScaffoldResult
+	err := c.do(ctx, request{
+		method: http.MethodPost,
+		path:   projectPath(owner, project, "scaffold"),
+		body:   map[string]string{"template": template},
+	}, &res)
+	if err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// SetTemplate registers project, an app of owner, as the template
+// owner/name, or points the template at another app. Anyone signed in can
+// start from it and gets the code of the app, never its settings or secrets.
+// Maintainers of the owner manage its templates.
+func (c *Client) SetTemplate(ctx context.Context, owner, name, project, description string) (*RegisteredTemplate, error) {
+	var res RegisteredTemplate
+	err := c.do(ctx, request{
+		method: http.MethodPost,
+		path:   ownerPath(owner, "templates"),
+		body: map[string]string{
+			"name":        name,
+			"project":     project,
+			"description": description,
+		},
+		idempotent: true,
+	}, &res)
+	if err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// DeleteTemplate removes the template owner/name. Apps started from it keep
+// their code.
+func (c *Client) DeleteTemplate(ctx context.Context, owner, name string) error {
+	return c.do(ctx, request{
+		method: http.MethodPost,
+		path:   ownerPath(owner, "templates", url.PathEscape(name), "delete"),
+	}, nil)
+}
@@ -0,0 +1,100 @@
+package pemasak
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// TokenScope is what an access token may do. A token never does more than
+// the role of its user allows.
+type TokenScope string
+
+const (
+	// ScopeRead only reads, like a viewer.
+	ScopeRead TokenScope = "read"
+	// ScopeDeploy also builds, deploys, promotes canaries and releases along
+	// a pipeline and rolls back, which is what a CI pipeline needs. It can't
+	// approve promotions.
+	ScopeDeploy TokenScope = "deploy"
+	// ScopeAdmin does everything its user can.
+	ScopeAdmin TokenScope = "admin"
+)
+
+// Token is an access token of the user. The token itself is only returned
+// by CreateToken.
+type Token struct {
+	ID    string     `json:"id"`
+	Name  string     `json:"name"`
+	Scope TokenScope `json:"scope"`
+	// App is the owner/project the token is limited to, empty for a
+	// personal token that works for every app of the user.
+	App string `json:"app"`
+	// Prefix is the start of the token, to tell tokens apart.
+	Prefix string `json:"prefix"`
+	// Token is only set right after CreateToken, it can't be shown again.
+	Token      string     `json:"token,omitempty"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// CreateTokenOptions describes a new access token.
+type CreateTokenOptions struct {
+	Name  string     `json:"name"`
+	Scope TokenScope `json:"scope"`
+	// Owner and Project limit the token to one app. Leave both empty for a
+	// personal token.
+	Owner   string `json:"owner,omitempty"`
+	Project string `json:"project,omitempty"`
+	// ExpiresInDays is how long the token works, at most 365. Zero uses the
+	// platform default of 90 days; NeverExpires makes a token that doesn't.
+	ExpiresInDays int  `json:"-"`
+	NeverExpires  bool `json:"-"`
+}
+
+// ListTokens returns the access tokens of the user that weren't revoked,
+// newest first.
+func (c *Client) ListTokens(ctx context.Context) ([]Token, error) {
+	var res struct {
+		Data []Token `json:"data"`
+	}
+	err := c.do(ctx, request{method: http.MethodGet, path: "/api/tokens", idempotent: true}, &res)
+	if err != nil {
+		return nil, err
+	}
+	return res.Data, nil
+}
+
+// CreateToken creates an access token. Keep Token.Token somewhere safe, the
+// platform only stores its hash.
+func (c *Client) CreateToken(ctx context.Context, opts CreateTokenOptions) (*Token, error) {
+	body := struct {
+		CreateTokenOptions
+		ExpiresInDays *int `json:"expires_in_days,omitempty"`
+	}{CreateTokenOptions: opts}
+	switch {
+	case opts.NeverExpires:
+		never := 0
+		body.ExpiresInDays = &never
+	case opts.ExpiresInDays > 0:
+		body.ExpiresInDays = &opts.ExpiresInDays
+	}
+
+	var res Token
+	err := c.do(ctx, request{method: http.MethodPost, path: "/api/tokens", body: body}, &res)
+	if err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// RevokeToken stops a token from working right away.
+func (c *Client) RevokeToken(ctx context.Context, id string) error {
+	return c.do(ctx, request{
+		method:     http.MethodPost,
+		path:       "/api/tokens/" + url.PathEscape(id) + "/revoke",
+		idempotent: true,
+	}, nil)
+}
@@ -0,0 +1,90 @@
+package pemasak
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+)
+
+// AppUsage is what an app, or every app of an owner, used in a month. Web
+// and worker containers are metered every metrics interval.
+type AppUsage struct {
+	Owner string `json:"owner"`
+	// Project is empty when the apps of an owner are added up.
+	Project string `json:"project"`
+	// Month is the first day of the month, like 2026-10-01.
+	Month string `json:"month"`
+	// CPUSeconds counts seconds of one cpu, two cpus busy for a second are
+	// two.
+	CPUSeconds     float64 `json:"cpu_seconds"`
+	MemoryGiBHours float64 `json:"memory_gib_hours"`
+	// EgressBytes is what the containers sent, to visitors and to anything
+	// else.
+	EgressBytes  int64   `json:"egress_bytes"`
+	BuildMinutes float64 `json:"build_minutes"`
+}
+
+// UsageFilter picks the month of a usage report. The zero value reports
+// every app this month.
+type UsageFilter struct {
+	// Month is like 2026-10.
+	Month string
+	Owner string
+	// ByOwner adds up the apps of each owner.
+	ByOwner bool
+}
+
+func (f UsageFilter) query(format string) string {
+	q := url.Values{}
+	if f.Month != "" {
+		q.Set("month", f.Month)
+	}
+	if f.Owner != "" {
+		q.Set("owner", f.Owner)
+	}
+	if f.ByOwner {
+		q.Set("by", "owner")
+	}
+	if format != "" {
+		q.Set("format", format)
+	}
+	if len(q) == 0 {
+		return ""
+	}
+	return "?" + q.Encode()
+}
+
+// AppUsage returns what an app used in each of its last 12 months, the
+// latest first.
+func (c *Client) AppUsage(ctx context.Context, owner, project string) ([]AppUsage, error) {
+	return c.usage(ctx, projectPath(owner, project, "usage"))
+}
+
+// UsageReport returns what every app used in a month, the most cpu first.
+// Only platform admins can read it.
+func (c *Client) UsageReport(ctx context.Context, filter UsageFilter) ([]AppUsage, error) {
+	return c.usage(ctx, "/api/admin/usage"+filter.query(""))
+}
+
+func (c *Client) usage(ctx context.Context, path string) ([]AppUsage, error) {
+	var res struct {
+		Data []AppUsage `json:"data"`
+	}
+	err := c.do(ctx, request{method: http.MethodGet, path: path, idempotent: true}, &res)
+	if err != nil {
+		return nil, err
+	}
+	return res.Data, nil
+}
+
+// ExportAppUsage returns the usage of an app as csv. The caller closes it.
+func (c *Client) ExportAppUsage(ctx context.Context, owner, project string) (io.ReadCloser, error) {
+	return c.download(ctx, projectPath(owner, project, "usage")+"?format=csv")
+}
+
+// ExportUsageReport returns the usage report of a month as csv, for
+// platform admins. The caller closes it.
+func (c *Client) ExportUsageReport(ctx context.Context, filter UsageFilter) (io.ReadCloser, error) {
+	return c.download(ctx, "/api/admin/usage"+filter.query("csv"))
+}
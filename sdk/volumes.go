@@ -0,0 +1,121 @@
+package pemasak
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Volume is persistent disk of an app. It is mounted into every container of
+// the app and keeps its files across deploys.
+type Volume struct {
+	Name string `json:"name"`
+	// MountPath is where the volume is in the containers, like /data.
+	MountPath string `json:"mount_path"`
+	// SizeMB is how much the volume may hold. Deploys fail while it holds
+	// more.
+	SizeMB int `json:"size_mb"`
+	// UsedBytes is how much it holds, nil when it couldn't be measured.
+	UsedBytes *int64    `json:"used_bytes"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// VolumeEntry is a file or directory inside a volume. The size of a
+// directory is the size of everything in it.
+type VolumeEntry struct {
+	Name     string     `json:"name"`
+	Kind     string     `json:"kind"`
+	Size     int64      `json:"size"`
+	Modified *time.Time `json:"modified"`
+}
+
+// ListVolumes returns the volumes of a project and how many MiB their sizes
+// may add up to.
+func (c *Client) ListVolumes(ctx context.Context, owner, project string) ([]Volume, int, error) {
+	var res struct {
+		Data    []Volume `json:"data"`
+		QuotaMB int      `json:"quota_mb"`
+	}
+	err := c.do(ctx, request{method: http.MethodGet, path: projectPath(owner, project, "volumes"), idempotent: true}, &res)
+	if err != nil {
+		return nil, 0, err
+	}
+	return res.Data, res.QuotaMB, nil
+}
+
+// AttachVolume creates a volume and mounts it at mountPath, or changes the
+// path or size of an existing one. A deployed app is restarted when a mount
+// changes, others get it on their first deploy.
+func (c *Client) AttachVolume(ctx context.Context, owner, project, name, mountPath string, sizeMB int) (*Volume, error) {
+	var res Volume
+	err := c.do(ctx, request{
+		method: http.MethodPost,
+		path:   projectPath(owner, project, "volumes"),
+		body: struct {
+			Name      string `json:"name"`
+			MountPath string `json:"mount_path"`
+			SizeMB    int    `json:"size_mb"`
+		}{name, mountPath, sizeMB},
+		idempotent: true,
+	}, &res)
+	if err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// DetachVolume unmounts a volume and deletes its files once the app runs
+// without it, which can't be undone.
+func (c *Client) DetachVolume(ctx context.Context, owner, project, name string) error {
+	return c.do(ctx, request{
+		method: http.MethodPost,
+		path:   projectPath(owner, project, "volumes", url.PathEscape(name), "delete"),
+	}, nil)
+}
+
+// BrowseVolume lists what is directly inside path of a volume, or the file
+// at path. An empty path is the root of the volume.
+func (c *Client) BrowseVolume(ctx context.Context, owner, project, name, path string) ([]VolumeEntry, error) {
+	p := projectPath(owner, project, "volumes", url.PathEscape(name), "files")
+	if path != "" {
+		p += "?" + url.Values{"path": {path}}.Encode()
+	}
+
+	var res struct {
+		Data []VolumeEntry `json:"data"`
+	}
+	// the whole directory is read to add up sizes
+	err := c.do(ctx, request{method: http.MethodGet, path: p, idempotent: true, untimed: true}, &res)
+	if err != nil {
+		return nil, err
+	}
+	return res.Data, nil
+}
+
+// DownloadVolume returns a tar archive of path inside a volume, the whole
+// volume when path is empty. The caller closes it.
+func (c *Client) DownloadVolume(ctx context.Context, owner, project, name, path string) (io.ReadCloser, error) {
+	p := projectPath(owner, project, "volumes", url.PathEscape(name), "download")
+	if path != "" {
+		p += "?" + url.Values{"path": {path}}.Encode()
+	}
+
+	// archives take as long as they take to send
+	hc := *c.httpClient
+	hc.Timeout = 0
+
+	resp, err := c.sendWith(ctx, &hc, http.MethodGet, p, nil, "")
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		if err := decode(resp, nil); err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("pemasak: unexpected status %d", resp.StatusCode)
+	}
+	return resp.Body, nil
+}
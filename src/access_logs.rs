@@ -0,0 +1,239 @@
+use std::pin::Pin;
+use std::task::{Context, Poll};
+use std::time::Duration;
+
+use bytes::Bytes;
+use chrono::{DateTime, Utc};
+use futures::Stream;
+use http_body::Body as _;
+use hyper::{Body, Response, StatusCode};
+use rand::Rng;
+use sqlx::PgPool;
+use tokio::sync::mpsc::{self, Receiver, Sender};
+use uuid::Uuid;
+
+use crate::configuration::ContainerSettings;
+
+/// lines waiting to be written, the proxy drops lines when it is full instead of slowing down
+const BUFFER: usize = 10_000;
+const BATCH_SIZE: usize = 500;
+/// how long a batch waits to fill up before it is written anyway
+const BATCH_INTERVAL: Duration = Duration::from_secs(1);
+/// how often logs past their retention are deleted
+const PRUNE_INTERVAL: Duration = Duration::from_secs(3600);
+
+/// One request the proxy answered for an app
+#[derive(Debug, Clone)]
+pub struct AccessLog {
+    pub project_id: Uuid,
+    pub recorded_at: DateTime<Utc>,
+    pub request_id: String,
+    pub client_ip: String,
+    pub method: String,
+    pub path: String,
+    pub status: i32,
+    /// until the headers of the response were there
+    pub duration_ms: i32,
+    /// None until the body is sent whole, or when it can't be counted
+    pub bytes: Option<i64>,
+    pub upstream: String,
+}
+
+/// Where a response came from, put on it by whatever answered: the address of the container
+/// or `cache`. Responses without one were answered by the proxy itself
+#[derive(Debug, Clone)]
+pub struct Upstream(pub String);
+
+/// Hands the requests the proxy answered to [`access_log_writer`]
+#[derive(Clone)]
+pub struct AccessLogger {
+    lines: Sender<AccessLog>,
+}
+
+impl AccessLogger {
+    pub fn new() -> (Self, Receiver<AccessLog>) {
+        let (lines, receiver) = mpsc::channel(BUFFER);
+        (Self { lines }, receiver)
+    }
+
+    /// Whether a request answered with `status` is kept by an app keeping `sample` percent of
+    /// them. Server errors are always kept, they are what the logs are read for
+    pub fn sampled(sample: i32, status: StatusCode) -> bool {
+        status.is_server_error() || sample >= 100 || (sample > 0 && rand::thread_rng().gen_range(0..100) < sample)
+    }
+
+    /// Logs `entry` once the body of `res` is sent, with the bytes that went out. A body of a
+    /// known size is logged right away, and so are bodies that could carry trailers, like the
+    /// ones of grpc, since counting them would lose the trailers
+    pub fn record(&self, mut entry: AccessLog, res: Response<Body>, trailers: bool) -> Response<Body> {
+        if let Some(size) = res.body().size_hint().exact() {
+            entry.bytes = Some(size as i64);
+            self.send(entry);
+            return res;
+        }
+        if trailers || res.status() == StatusCode::SWITCHING_PROTOCOLS {
+            self.send(entry);
+            return res;
+        }
+        let (parts, body) = res.into_parts();
+
+        let counted = Counted {
+            body,
+            logger: self.clone(),
+            entry: Some(entry),
+            bytes: 0,
+            done: false,
+        };
+        Response::from_parts(parts, Body::wrap_stream(counted))
+    }
+
+    fn send(&self, entry: AccessLog) {
+        if self.lines.try_send(entry).is_err() {
+            tracing::debug!("Access log buffer is full, dropping a line");
+        }
+    }
+}
+
+/// A body being sent with its line, logged when the body is dropped, that is when it was
+/// sent whole or the client went away
+struct Counted {
+    body: Body,
+    logger: AccessLogger,
+    entry: Option<AccessLog>,
+    bytes: i64,
+    done: bool,
+}
+
+impl Stream for Counted {
+    type Item = Result<Bytes, hyper::Error>;
+
+    fn poll_next(mut self: Pin<&mut Self>, cx: &mut Context<'_>) -> Poll<Option<Self::Item>> {
+        let next = Pin::new(&mut self.body).poll_data(cx);
+        match &next {
+            Poll::Ready(Some(Ok(chunk))) => self.bytes += chunk.len() as i64,
+            Poll::Ready(None) => self.done = true,
+            _ => {}
+        }
+        next
+    }
+}
+
+impl Drop for Counted {
+    fn drop(&mut self) {
+        if let Some(mut entry) = self.entry.take() {
+            entry.bytes = self.done.then_some(self.bytes);
+            self.logger.send(entry);
+        }
+    }
+}
+
+/// Writes what [`AccessLogger`] hands it in batches, a line is in the logs about a second
+/// after its request was answered
+pub async fn access_log_writer(pool: PgPool, mut lines: Receiver<AccessLog>) {
+    while let Some(first) = lines.recv().await {
+        let mut batch = vec![first];
+
+        let deadline = tokio::time::sleep(BATCH_INTERVAL);
+        tokio::pin!(deadline);
+        while batch.len() < BATCH_SIZE {
+            tokio::select! {
+                line = lines.recv() => match line {
+                    Some(line) => batch.push(line),
+                    None => break,
+                },
+                _ = &mut deadline => break,
+            }
+        }
+
+        let mut project_ids = Vec::with_capacity(batch.len());
+        let mut recorded_at = Vec::with_capacity(batch.len());
+        let mut request_ids = Vec::with_capacity(batch.len());
+        let mut client_ips = Vec::with_capacity(batch.len());
+        let mut methods = Vec::with_capacity(batch.len());
+        let mut paths = Vec::with_capacity(batch.len());
+        let mut statuses = Vec::with_capacity(batch.len());
+        let mut durations = Vec::with_capacity(batch.len());
+        let mut bytes = Vec::with_capacity(batch.len());
+        let mut upstreams = Vec::with_capacity(batch.len());
+        for line in batch {
+            project_ids.push(line.project_id);
+            recorded_at.push(line.recorded_at);
+            request_ids.push(line.request_id);
+            client_ips.push(line.client_ip);
+            methods.push(line.method);
+            paths.push(line.path);
+            statuses.push(line.status);
+            durations.push(line.duration_ms);
+            bytes.push(line.bytes);
+            upstreams.push(line.upstream);
+        }
+
+        // a project deleted since its requests were answered takes them along
+        if let Err(err) = sqlx::query!(
+            r#"INSERT INTO access_logs
+               (project_id, recorded_at, request_id, client_ip, method, path, status, duration_ms, bytes, upstream)
+               SELECT lines.* FROM UNNEST(
+                   $1::uuid[], $2::timestamptz[], $3::text[], $4::text[], $5::text[], $6::text[],
+                   $7::int4[], $8::int4[], $9::int8[], $10::text[]
+               ) AS lines (project_id, recorded_at, request_id, client_ip, method, path, status, duration_ms, bytes, upstream)
+               JOIN projects ON projects.id = lines.project_id
+            "#,
+            &project_ids,
+            &recorded_at,
+            &request_ids,
+            &client_ips,
+            &methods,
+            &paths,
+            &statuses,
+            &durations,
+            // nulls in the array don't type check
+            &bytes as _,
+            &upstreams
+        )
+        .execute(&pool)
+        .await
+        {
+            tracing::error!(?err, "Can't store access logs: Failed to query database");
+        }
+    }
+}
+
+/// Deletes access logs past the retention of their app, and the oldest ones of apps with
+/// more than the platform keeps
+pub async fn access_log_pruner(pool: PgPool, container_settings: ContainerSettings) {
+    let mut interval = tokio::time::interval(PRUNE_INTERVAL);
+    loop {
+        interval.tick().await;
+
+        if let Err(err) = sqlx::query!(
+            r#"DELETE FROM access_logs USING projects
+               WHERE projects.id = access_logs.project_id
+               AND access_logs.recorded_at < now() - make_interval(days => LEAST(COALESCE(projects.access_log_retention, $1), $2))
+            "#,
+            container_settings.accesslogretention,
+            container_settings.accesslogmaxretention
+        )
+        .execute(&pool)
+        .await
+        {
+            tracing::error!(?err, "Can't prune access logs: Failed to query database");
+        }
+
+        if let Err(err) = sqlx::query!(
+            r#"DELETE FROM access_logs WHERE id IN (
+                   SELECT id FROM (
+                       SELECT id, row_number() OVER (PARTITION BY project_id ORDER BY id DESC) AS n
+                       FROM access_logs
+                   ) AS ranked
+                   WHERE n > $1
+               )
+            "#,
+            container_settings.accesslogmax
+        )
+        .execute(&pool)
+        .await
+        {
+            tracing::error!(?err, "Can't prune access logs: Failed to query database");
+        }
+    }
+}
@@ -0,0 +1,29 @@
+use anyhow::Result;
+use sqlx::PgPool;
+use ulid::Ulid;
+use uuid::Uuid;
+
+use crate::live::{self, Update};
+
+/// Adds an entry to the activity log of a project, for changes that aren't builds. Whoever
+/// watches the app live hears about it
+pub async fn record_activity(project_id: Uuid, kind: &str, message: &str, pool: &PgPool) -> Result<()> {
+    sqlx::query!(
+        "INSERT INTO activities (id, project_id, kind, message) VALUES ($1, $2, $3, $4)",
+        Uuid::from(Ulid::new()),
+        project_id,
+        kind,
+        message
+    )
+    .execute(pool)
+    .await?;
+
+    live::publish(
+        project_id,
+        Update::Container {
+            kind: kind.to_string(),
+            message: message.to_string(),
+        },
+    );
+    Ok(())
+}
@@ -0,0 +1,107 @@
+use axum::extract::State;
+use axum::response::Response;
+use axum::Json;
+use garde::{Unvalidated, Validate};
+use hyper::{Body, StatusCode};
+use serde::{Deserialize, Serialize};
+use ulid::Ulid;
+use uuid::Uuid;
+
+use crate::audit::{with_change, AuditChange};
+use crate::nodes::generate_token;
+use crate::startup::AppState;
+
+#[derive(Deserialize, Validate, Debug)]
+pub struct AddNodeRequest {
+    #[garde(pattern("^[a-z0-9][a-z0-9-]{0,39}$"))]
+    name: String,
+}
+
+#[derive(Serialize, Debug)]
+struct AddNodeResponse {
+    name: String,
+    /// what the agent of the node reports with, it isn't shown again
+    token: String,
+}
+
+#[derive(Serialize, Debug)]
+struct ErrorResponse {
+    message: String,
+}
+
+/// Adds a node and hands out the token its agent reports with. The node gets apps once the
+/// agent first reports
+#[tracing::instrument(skip(pool))]
+pub async fn post(
+    State(AppState { pool, .. }): State<AppState>,
+    Json(req): Json<Unvalidated<AddNodeRequest>>,
+) -> Response<Body> {
+    let AddNodeRequest { name } = match req.validate(&()) {
+        Ok(valid) => valid.into_inner(),
+        Err(err) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: err.to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    let (token, token_hash) = generate_token();
+
+    match sqlx::query!(
+        r#"INSERT INTO nodes (id, name, token_hash)
+           VALUES ($1, $2, $3)
+           ON CONFLICT (name) DO NOTHING
+           RETURNING id
+        "#,
+        Uuid::from(Ulid::new()),
+        name,
+        token_hash,
+    )
+    .fetch_optional(&pool)
+    .await
+    {
+        Ok(Some(_)) => {}
+        Ok(None) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Node {name} already exists")
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::CONFLICT)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Err(err) => {
+            tracing::error!(?err, "Can't add node: Failed to insert into database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Failed to insert into database".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    }
+
+    tracing::info!(node = name, "Added node");
+
+    let json = serde_json::to_string(&AddNodeResponse {
+        name: name.clone(),
+        token,
+    }).unwrap();
+
+    with_change(
+        Response::builder()
+            .status(StatusCode::CREATED)
+            .body(Body::from(json))
+            .unwrap(),
+        AuditChange::new(None, Some(serde_json::json!({ "name": name }))),
+    )
+}
@@ -0,0 +1,92 @@
+use axum::extract::{Path, State};
+use axum::response::Response;
+use hyper::{Body, StatusCode};
+use serde::Serialize;
+
+use crate::audit::{with_change, AuditChange};
+use crate::startup::AppState;
+
+#[derive(Serialize, Debug)]
+struct ErrorResponse {
+    message: String,
+}
+
+/// Removes a node and revokes the token of its agent. Only a node without apps can go, drain
+/// it first
+#[tracing::instrument(skip(pool))]
+pub async fn post(
+    State(AppState { pool, .. }): State<AppState>,
+    Path(name): Path<String>,
+) -> Response<Body> {
+    let node = match sqlx::query!(
+        r#"SELECT nodes.id, (SELECT count(*) FROM projects WHERE projects.node_id = nodes.id) AS "apps!"
+           FROM nodes
+           WHERE nodes.name = $1
+        "#,
+        name
+    )
+    .fetch_optional(&pool)
+    .await
+    {
+        Ok(Some(node)) => node,
+        Ok(None) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Node {name} does not exist")
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::NOT_FOUND)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Err(err) => {
+            tracing::error!(?err, "Can't delete node: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    if node.apps > 0 {
+        let json = serde_json::to_string(&ErrorResponse {
+            message: format!("Node {name} still has {} apps, drain it first", node.apps)
+        }).unwrap();
+
+        return Response::builder()
+            .status(StatusCode::CONFLICT)
+            .body(Body::from(json))
+            .unwrap();
+    }
+
+    if let Err(err) = sqlx::query!("DELETE FROM nodes WHERE id = $1", node.id)
+        .execute(&pool)
+        .await
+    {
+        tracing::error!(?err, "Can't delete node: Failed to delete from database");
+
+        let json = serde_json::to_string(&ErrorResponse {
+            message: "Failed to delete from database".to_string()
+        }).unwrap();
+
+        return Response::builder()
+            .status(StatusCode::INTERNAL_SERVER_ERROR)
+            .body(Body::from(json))
+            .unwrap();
+    }
+
+    tracing::info!(node = name, "Deleted node");
+
+    with_change(
+        Response::builder()
+            .status(StatusCode::NO_CONTENT)
+            .body(Body::empty())
+            .unwrap(),
+        AuditChange::new(Some(serde_json::json!({ "name": name })), None),
+    )
+}
@@ -0,0 +1,16 @@
+use axum::extract::State;
+use axum::response::Response;
+use hyper::Body;
+
+use super::view_host::host_status;
+use crate::startup::AppState;
+
+/// Stops starting builds so the host can be taken down once the running ones finish. Apps
+/// keep serving and pushes still queue their builds. Draining ends when the platform restarts
+#[tracing::instrument(skip(build_queue))]
+pub async fn post(State(AppState { build_queue, .. }): State<AppState>) -> Response<Body> {
+    tracing::warn!("Draining host, no build starts until it resumes");
+    build_queue.drain(true);
+
+    host_status(&build_queue).await
+}
@@ -0,0 +1,101 @@
+use axum::extract::{Path, State};
+use axum::response::Response;
+use hyper::{Body, StatusCode};
+use serde::Serialize;
+
+use crate::audit::{with_change, AuditChange};
+use crate::nodes::drain;
+use crate::queue::{BuildKind, BuildQueueItem};
+use crate::startup::AppState;
+use crate::telemetry::current_context;
+
+#[derive(Serialize, Debug)]
+struct ErrorResponse {
+    message: String,
+}
+
+/// Takes a node out of placement and moves its apps to the other nodes, so it can go down.
+/// Moved apps with a release are deployed again where they went. The node keeps serving them
+/// until they are, then their containers on it are removed
+#[tracing::instrument(skip(pool, base, build_channel, container_settings))]
+pub async fn post(
+    State(AppState { pool, base, build_channel, container_settings, .. }): State<AppState>,
+    Path(name): Path<String>,
+) -> Response<Body> {
+    let node = match sqlx::query!("SELECT id, draining FROM nodes WHERE name = $1", name)
+        .fetch_optional(&pool)
+        .await
+    {
+        Ok(Some(node)) => node,
+        Ok(None) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Node {name} does not exist")
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::NOT_FOUND)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Err(err) => {
+            tracing::error!(?err, "Can't drain node: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    tracing::warn!(node = name, "Draining node, it gets no new apps until it resumes");
+    let drained = match drain(node.id, &container_settings, &pool).await {
+        Ok(drained) => drained,
+        Err(err) => {
+            tracing::error!(?err, "Can't drain node: Failed to update database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Failed to update database, some apps may have moved already. Try again".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    for app in drained.moved.iter().filter(|app| app.released) {
+        let repo = app.project.trim_end_matches(".git");
+
+        if let Err(err) = build_channel
+            .send(BuildQueueItem {
+                container_name: format!("{}-{repo}", app.owner).replace('.', "-"),
+                container_src: format!("{base}/{}/{repo}.git/master", app.owner),
+                owner: app.owner.clone(),
+                repo: repo.to_string(),
+                kind: BuildKind::Reconfigure(format!("Moved off node {name}")),
+                trace: current_context(),
+            })
+            .await
+        {
+            tracing::error!(?err, owner = app.owner, project = app.project, "Can't move app: Failed to send to build queue");
+        }
+    }
+
+    let json = serde_json::to_string(&drained).unwrap();
+
+    with_change(
+        Response::builder()
+            .status(StatusCode::OK)
+            .body(Body::from(json))
+            .unwrap(),
+        AuditChange::new(
+            Some(serde_json::json!({ "draining": node.draining })),
+            Some(serde_json::json!({ "draining": true })),
+        ),
+    )
+}
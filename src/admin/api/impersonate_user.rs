@@ -0,0 +1,102 @@
+use axum::extract::{Path, State};
+use axum::response::Response;
+use axum::Extension;
+use hyper::{Body, StatusCode};
+use serde::Serialize;
+
+use crate::admin::{Impersonation, IMPERSONATION_KEY};
+use crate::audit::{with_change, AuditChange};
+use crate::auth::{tokens::TokenAccess, Auth};
+use crate::startup::AppState;
+
+#[derive(Serialize, Debug)]
+struct ImpersonateResponse {
+    message: String,
+}
+
+#[derive(Serialize, Debug)]
+struct ErrorResponse {
+    message: String,
+}
+
+/// Logs the admin in as another user to debug what they see. The session stays read-only
+/// until `/api/admin/impersonate/stop` logs the admin back in
+#[tracing::instrument(skip(auth, pool, token))]
+pub async fn post(
+    auth: Auth,
+    State(AppState { pool, .. }): State<AppState>,
+    token: Option<Extension<TokenAccess>>,
+    Path(username): Path<String>,
+) -> Response<Body> {
+    let admin = auth.current_user.clone().unwrap();
+
+    if token.is_some() {
+        let json = serde_json::to_string(&ErrorResponse {
+            message: "Impersonating takes a session, not a token".to_string()
+        }).unwrap();
+
+        return Response::builder()
+            .status(StatusCode::BAD_REQUEST)
+            .body(Body::from(json))
+            .unwrap();
+    }
+
+    let user = match sqlx::query!(
+        r#"SELECT id, role = 'admin' AS "admin!" FROM users WHERE username = $1"#,
+        username
+    )
+    .fetch_optional(&pool)
+    .await
+    {
+        Ok(Some(user)) => user,
+        Ok(None) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "User does not exist".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Err(err) => {
+            tracing::error!(?err, "Can't impersonate user: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    // an impersonated admin could hand out their own impersonations
+    if user.admin {
+        let json = serde_json::to_string(&ErrorResponse {
+            message: "Platform admins can't be impersonated".to_string()
+        }).unwrap();
+
+        return Response::builder()
+            .status(StatusCode::FORBIDDEN)
+            .body(Body::from(json))
+            .unwrap();
+    }
+
+    auth.session.set(IMPERSONATION_KEY, Impersonation { admin_id: admin.id, user_id: user.id });
+    auth.login_user(user.id);
+
+    let json = serde_json::to_string(&ImpersonateResponse {
+        message: format!("Logged in as {username}, read-only until you stop impersonating")
+    }).unwrap();
+
+    with_change(
+        Response::builder()
+            .status(StatusCode::OK)
+            .body(Body::from(json))
+            .unwrap(),
+        AuditChange::new(None, Some(serde_json::json!({ "user": username }))),
+    )
+}
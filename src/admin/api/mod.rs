@@ -0,0 +1,75 @@
+use axum::{middleware, routing::{get, post}, Router};
+use axum_extra::routing::RouterExt;
+use hyper::Body;
+
+use crate::{admin::admin, audit::audit_trail, auth::auth, configuration::Settings, startup::AppState};
+
+mod add_node;
+mod delete_node;
+mod drain_host;
+mod drain_node;
+mod impersonate_user;
+mod reconcile_host;
+mod report_node;
+mod resume_app;
+mod resume_host;
+mod resume_node;
+mod set_app_limits;
+mod set_cleanup_exempt;
+mod set_selected_cleanup_exempt;
+mod set_selected_limits;
+mod set_user_limits;
+mod set_user_quotas;
+mod stop_impersonating;
+mod suspend_app;
+mod view_app_limits;
+mod view_apps;
+mod view_audit_log;
+mod view_cleanup;
+mod view_containers;
+mod view_host;
+mod view_nodes;
+mod view_usage;
+mod view_user_limits;
+mod view_user_quotas;
+
+pub async fn router(state: AppState, _config: &Settings) -> Router<AppState, Body> {
+    // the impersonated user isn't an admin, anyone logged in may try to stop
+    let impersonation = Router::new()
+        .route_with_tsr("/api/admin/impersonate/stop", post(stop_impersonating::post))
+        .route_layer(middleware::from_fn(auth))
+        .route_layer(middleware::from_fn_with_state(state.clone(), audit_trail));
+
+    // agents have the token of their node, no user
+    let agents = Router::new().route_with_tsr("/api/nodes/report", post(report_node::post));
+
+    Router::new()
+        .route_with_tsr("/api/admin/audit", get(view_audit_log::get))
+        .route_with_tsr("/api/admin/apps", get(view_apps::get))
+        .route_with_tsr("/api/admin/apps/limits", post(set_selected_limits::post))
+        .route_with_tsr("/api/admin/apps/cleanup", post(set_selected_cleanup_exempt::post))
+        .route_with_tsr("/api/admin/apps/:owner/:project/suspend", post(suspend_app::post))
+        .route_with_tsr("/api/admin/apps/:owner/:project/resume", post(resume_app::post))
+        .route_with_tsr("/api/admin/apps/:owner/:project/limits", get(view_app_limits::get).post(set_app_limits::post))
+        .route_with_tsr("/api/admin/apps/:owner/:project/cleanup", post(set_cleanup_exempt::post))
+        .route_with_tsr("/api/admin/cleanup", get(view_cleanup::get))
+        .route_with_tsr("/api/admin/containers", get(view_containers::get))
+        .route_with_tsr("/api/admin/usage", get(view_usage::get))
+        .route_with_tsr("/api/admin/users/:username/impersonate", post(impersonate_user::post))
+        .route_with_tsr("/api/admin/users/:username/limits", get(view_user_limits::get).post(set_user_limits::post))
+        .route_with_tsr("/api/admin/users/:username/quotas", get(view_user_quotas::get).post(set_user_quotas::post))
+        .route_with_tsr("/api/admin/host", get(view_host::get))
+        .route_with_tsr("/api/admin/host/drain", post(drain_host::post))
+        .route_with_tsr("/api/admin/host/resume", post(resume_host::post))
+        .route_with_tsr("/api/admin/host/reconcile", post(reconcile_host::post))
+        .route_with_tsr("/api/admin/nodes", get(view_nodes::get).post(add_node::post))
+        .route_with_tsr("/api/admin/nodes/:name/drain", post(drain_node::post))
+        .route_with_tsr("/api/admin/nodes/:name/resume", post(resume_node::post))
+        .route_with_tsr("/api/admin/nodes/:name/delete", post(delete_node::post))
+        // auth wraps admin, so only logged in users are checked
+        .route_layer(middleware::from_fn_with_state(state.clone(), admin))
+        .route_layer(middleware::from_fn(auth))
+        .route_layer(middleware::from_fn_with_state(state, audit_trail))
+        .merge(impersonation)
+        .merge(agents)
+}
@@ -0,0 +1,42 @@
+use axum::extract::State;
+use axum::response::Response;
+use hyper::{Body, StatusCode};
+use serde::Serialize;
+
+use crate::reconciler::reconcile;
+use crate::startup::AppState;
+
+#[derive(Serialize, Debug)]
+struct ErrorResponse {
+    message: String,
+}
+
+/// Reconciles builds and containers with the database now instead of waiting for the next
+/// pass, like right after the host came back up
+#[tracing::instrument(skip(pool, base, build_channel, build_queue))]
+pub async fn post(
+    State(AppState { pool, base, build_channel, build_queue, .. }): State<AppState>,
+) -> Response<Body> {
+    match reconcile(&pool, &build_queue, &build_channel, &base).await {
+        Ok(reconciled) => {
+            let json = serde_json::to_string(&reconciled).unwrap();
+
+            Response::builder()
+                .status(StatusCode::OK)
+                .body(Body::from(json))
+                .unwrap()
+        }
+        Err(err) => {
+            tracing::error!(?err, "Can't reconcile builds and containers");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to reconcile: {}", err.to_string())
+            }).unwrap();
+
+            Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap()
+        }
+    }
+}
@@ -0,0 +1,62 @@
+use axum::extract::State;
+use axum::headers::{authorization::Bearer, Authorization};
+use axum::response::Response;
+use axum::{Json, TypedHeader};
+use hyper::{Body, StatusCode};
+use serde::Serialize;
+
+use crate::nodes::{record_report, Report};
+use crate::startup::AppState;
+
+#[derive(Serialize, Debug)]
+struct ReportResponse {
+    name: String,
+    draining: bool,
+}
+
+#[derive(Serialize, Debug)]
+struct ErrorResponse {
+    message: String,
+}
+
+/// Where the agent of a node reports what its host has left. The token of the node is the
+/// only credential, agents have no user
+#[tracing::instrument(skip(pool, token, report))]
+pub async fn post(
+    State(AppState { pool, .. }): State<AppState>,
+    TypedHeader(Authorization(token)): TypedHeader<Authorization<Bearer>>,
+    Json(report): Json<Report>,
+) -> Response<Body> {
+    match record_report(token.token(), &report, &pool).await {
+        Ok(Some((name, draining))) => {
+            let json = serde_json::to_string(&ReportResponse { name, draining }).unwrap();
+
+            Response::builder()
+                .status(StatusCode::OK)
+                .body(Body::from(json))
+                .unwrap()
+        }
+        Ok(None) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Token belongs to no node".to_string()
+            }).unwrap();
+
+            Response::builder()
+                .status(StatusCode::UNAUTHORIZED)
+                .body(Body::from(json))
+                .unwrap()
+        }
+        Err(err) => {
+            tracing::error!(?err, "Can't record node report: Failed to update database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Failed to update database".to_string()
+            }).unwrap();
+
+            Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap()
+        }
+    }
+}
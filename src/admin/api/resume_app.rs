@@ -0,0 +1,93 @@
+use axum::extract::{Path, State};
+use axum::response::Response;
+use hyper::{Body, StatusCode};
+use serde::Serialize;
+
+use crate::activity::record_activity;
+use crate::audit::{with_change, AuditChange};
+use crate::orchestrator;
+use crate::startup::AppState;
+
+#[derive(Serialize, Debug)]
+struct ErrorResponse {
+    message: String,
+}
+
+/// Lifts a suspension and starts the containers it stopped. An app the cleanup stopped counts
+/// as kept
+#[tracing::instrument(skip(pool))]
+pub async fn post(
+    State(AppState { pool, .. }): State<AppState>,
+    Path((owner, project)): Path<(String, String)>,
+) -> Response<Body> {
+    // the old reason goes in the audit log
+    let project_record = match sqlx::query!(
+        r#"WITH suspended AS (
+             SELECT projects.id, projects.suspended_reason FROM projects
+             JOIN project_owners ON projects.owner_id = project_owners.id
+             WHERE project_owners.name = $1 AND projects.name = $2
+             AND projects.suspended_at IS NOT NULL
+           )
+           UPDATE projects SET suspended_at = NULL, suspended_reason = NULL,
+             cleanup_flagged_at = NULL, cleanup_stopped_at = NULL, cleanup_kept_at = now(), stopped_at = NULL
+           FROM suspended
+           WHERE projects.id = suspended.id
+           RETURNING projects.id, suspended.suspended_reason AS reason
+        "#,
+        owner,
+        project
+    )
+    .fetch_optional(&pool)
+    .await
+    {
+        Ok(Some(record)) => record,
+        Ok(None) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Project does not exist or isn't suspended".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Err(err) => {
+            tracing::error!(?err, "Can't resume app: Failed to update database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Failed to update database".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    let container_name = format!("{owner}-{project}").replace('.', "-");
+    if let Err(err) = orchestrator::driver().resume(&container_name).await {
+        tracing::error!(?err, "Can't resume app: Failed to start containers");
+
+        let json = serde_json::to_string(&ErrorResponse {
+            message: "App is no longer suspended, but some containers failed to start. Deploy it again".to_string()
+        }).unwrap();
+
+        return Response::builder()
+            .status(StatusCode::INTERNAL_SERVER_ERROR)
+            .body(Body::from(json))
+            .unwrap();
+    }
+
+    if let Err(err) = record_activity(project_record.id, "suspend", "Suspension lifted by the platform admins", &pool).await {
+        tracing::error!(?err, "Can't record activity: Failed to insert into database");
+    }
+
+    with_change(
+        Response::builder()
+            .status(StatusCode::NO_CONTENT)
+            .body(Body::empty())
+            .unwrap(),
+        AuditChange::new(Some(serde_json::json!({ "reason": project_record.reason })), None),
+    )
+}
@@ -0,0 +1,15 @@
+use axum::extract::State;
+use axum::response::Response;
+use hyper::Body;
+
+use super::view_host::host_status;
+use crate::startup::AppState;
+
+/// Starts the builds that queued up while the host drained
+#[tracing::instrument(skip(build_queue))]
+pub async fn post(State(AppState { build_queue, .. }): State<AppState>) -> Response<Body> {
+    tracing::info!("Host resumed, starting queued builds");
+    build_queue.drain(false);
+
+    host_status(&build_queue).await
+}
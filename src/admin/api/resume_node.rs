@@ -0,0 +1,61 @@
+use axum::extract::{Path, State};
+use axum::response::Response;
+use hyper::{Body, StatusCode};
+use serde::Serialize;
+
+use crate::audit::{with_change, AuditChange};
+use crate::startup::AppState;
+
+#[derive(Serialize, Debug)]
+struct ErrorResponse {
+    message: String,
+}
+
+/// Places apps on a drained node again. Apps that moved off it stay where they went
+#[tracing::instrument(skip(pool))]
+pub async fn post(
+    State(AppState { pool, .. }): State<AppState>,
+    Path(name): Path<String>,
+) -> Response<Body> {
+    match sqlx::query!("UPDATE nodes SET draining = false WHERE name = $1 RETURNING id", name)
+        .fetch_optional(&pool)
+        .await
+    {
+        Ok(Some(_)) => {}
+        Ok(None) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Node {name} does not exist")
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::NOT_FOUND)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Err(err) => {
+            tracing::error!(?err, "Can't resume node: Failed to update database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Failed to update database".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    }
+
+    tracing::info!(node = name, "Resumed node, it gets new apps again");
+
+    with_change(
+        Response::builder()
+            .status(StatusCode::NO_CONTENT)
+            .body(Body::empty())
+            .unwrap(),
+        AuditChange::new(
+            Some(serde_json::json!({ "draining": true })),
+            Some(serde_json::json!({ "draining": false })),
+        ),
+    )
+}
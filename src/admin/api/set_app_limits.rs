@@ -0,0 +1,135 @@
+use axum::extract::{Path, State};
+use axum::response::Response;
+use axum::Json;
+use garde::{Unvalidated, Validate};
+use hyper::{Body, StatusCode};
+use serde::Serialize;
+
+use crate::activity::record_activity;
+use crate::audit::{with_change, AuditChange};
+use crate::limits::{apply_limits, resolve_limits, LimitOverrides};
+use crate::startup::AppState;
+
+#[derive(Serialize, Debug)]
+struct ErrorResponse {
+    message: String,
+}
+
+/// Sets the limits of an app, null gives it those of its owners again. Running containers
+/// get the new limits right away
+#[tracing::instrument(skip(pool, container_settings))]
+pub async fn post(
+    State(AppState { pool, container_settings, .. }): State<AppState>,
+    Path((owner, project)): Path<(String, String)>,
+    Json(req): Json<Unvalidated<LimitOverrides>>,
+) -> Response<Body> {
+    let overrides = match req.validate(&()) {
+        Ok(valid) => valid.into_inner(),
+        Err(err) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: err.to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    let project_record = match sqlx::query!(
+        r#"WITH old AS (
+             SELECT projects.id, projects.memory_limit, projects.cpu_limit, projects.disk_limit
+             FROM projects
+             JOIN project_owners ON projects.owner_id = project_owners.id
+             WHERE project_owners.name = $4 AND projects.name = $5
+           )
+           UPDATE projects SET memory_limit = $1, cpu_limit = $2, disk_limit = $3
+           FROM old
+           WHERE projects.id = old.id
+           RETURNING projects.id, old.memory_limit AS old_memory, old.cpu_limit AS old_cpus,
+             old.disk_limit AS old_disk
+        "#,
+        overrides.memory,
+        overrides.cpus,
+        overrides.disk,
+        owner,
+        project
+    )
+    .fetch_optional(&pool)
+    .await
+    {
+        Ok(Some(record)) => record,
+        Ok(None) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Project does not exist".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Err(err) => {
+            tracing::error!(?err, "Can't set limits: Failed to update database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Failed to update database".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    if let Err(err) = apply_limits(project_record.id, &container_settings, &pool).await {
+        tracing::error!(?err, "Can't set limits: Failed to apply them");
+
+        let json = serde_json::to_string(&ErrorResponse {
+            message: format!("Limits are set, but failed to apply them, they apply from the next deploy: {err}")
+        }).unwrap();
+
+        return Response::builder()
+            .status(StatusCode::INTERNAL_SERVER_ERROR)
+            .body(Body::from(json))
+            .unwrap();
+    }
+
+    let limits = match resolve_limits(project_record.id, &container_settings, &pool).await {
+        Ok(limits) => limits,
+        Err(err) => {
+            tracing::error!(?err, "Can't get limits: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    let message = format!("Resource limits changed by the platform admins to {}", limits.limits);
+    if let Err(err) = record_activity(project_record.id, "limits", &message, &pool).await {
+        tracing::error!(?err, "Can't record activity: Failed to insert into database");
+    }
+
+    let before = LimitOverrides {
+        memory: project_record.old_memory,
+        cpus: project_record.old_cpus,
+        disk: project_record.old_disk,
+    };
+    let json = serde_json::to_string(&limits).unwrap();
+
+    with_change(
+        Response::builder()
+            .status(StatusCode::OK)
+            .body(Body::from(json))
+            .unwrap(),
+        AuditChange::new(serde_json::to_value(before).ok(), serde_json::to_value(&overrides).ok()),
+    )
+}
@@ -0,0 +1,93 @@
+use axum::extract::{Path, State};
+use axum::response::Response;
+use axum::Json;
+use hyper::{Body, StatusCode};
+use serde::{Deserialize, Serialize};
+
+use crate::activity::record_activity;
+use crate::audit::{with_change, AuditChange};
+use crate::startup::AppState;
+
+#[derive(Deserialize, Debug)]
+pub struct SetCleanupExemptRequest {
+    exempt: bool,
+}
+
+#[derive(Serialize, Debug)]
+struct ErrorResponse {
+    message: String,
+}
+
+/// Exempts an app from the cleanup of inactive apps, or puts it back. Exempting a flagged app
+/// unflags it, one the cleanup already stopped stays stopped until it is resumed
+#[tracing::instrument(skip(pool))]
+pub async fn post(
+    State(AppState { pool, .. }): State<AppState>,
+    Path((owner, project)): Path<(String, String)>,
+    Json(SetCleanupExemptRequest { exempt }): Json<SetCleanupExemptRequest>,
+) -> Response<Body> {
+    let project_record = match sqlx::query!(
+        r#"WITH app AS (
+             SELECT projects.id, projects.cleanup_exempt FROM projects
+             JOIN project_owners ON projects.owner_id = project_owners.id
+             WHERE project_owners.name = $2 AND projects.name = $3
+           )
+           UPDATE projects SET cleanup_exempt = $1,
+             cleanup_flagged_at = CASE WHEN $1 AND projects.cleanup_stopped_at IS NULL THEN NULL ELSE projects.cleanup_flagged_at END
+           FROM app
+           WHERE projects.id = app.id
+           RETURNING projects.id, app.cleanup_exempt AS was_exempt
+        "#,
+        exempt,
+        owner,
+        project
+    )
+    .fetch_optional(&pool)
+    .await
+    {
+        Ok(Some(record)) => record,
+        Ok(None) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Project does not exist".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Err(err) => {
+            tracing::error!(?err, "Can't set cleanup exemption: Failed to update database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Failed to update database".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    if project_record.was_exempt != exempt {
+        let message = match exempt {
+            true => "Exempted from the cleanup of inactive apps by the platform admins",
+            false => "No longer exempt from the cleanup of inactive apps",
+        };
+        if let Err(err) = record_activity(project_record.id, "cleanup", message, &pool).await {
+            tracing::error!(?err, "Can't record activity: Failed to insert into database");
+        }
+    }
+
+    with_change(
+        Response::builder()
+            .status(StatusCode::NO_CONTENT)
+            .body(Body::empty())
+            .unwrap(),
+        AuditChange::new(
+            Some(serde_json::json!({ "exempt": project_record.was_exempt })),
+            Some(serde_json::json!({ "exempt": exempt })),
+        ),
+    )
+}
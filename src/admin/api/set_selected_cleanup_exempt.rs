@@ -0,0 +1,131 @@
+use axum::extract::State;
+use axum::response::Response;
+use axum::Json;
+use garde::{Unvalidated, Validate};
+use hyper::{Body, StatusCode};
+use serde::{Deserialize, Serialize};
+
+use crate::activity::record_activity;
+use crate::audit::{with_change, AuditChange};
+use crate::labels::Selector;
+use crate::startup::AppState;
+
+#[derive(Deserialize, Validate, Debug)]
+pub struct SetSelectedCleanupExemptRequest {
+    /// the apps to exempt or put back, by their labels
+    #[garde(custom(selector_check))]
+    selector: String,
+    #[garde(skip)]
+    exempt: bool,
+}
+
+#[derive(Serialize, Debug)]
+struct SelectedAppsResponse {
+    /// owner/project of every app the selector picked
+    apps: Vec<String>,
+}
+
+#[derive(Serialize, Debug)]
+struct ErrorResponse {
+    message: String,
+}
+
+fn selector_check(value: &String, _ctx: &()) -> garde::Result {
+    match value.parse::<Selector>() {
+        Err(err) => Err(garde::Error::new(err)),
+        Ok(selector) if selector.is_empty() => Err(garde::Error::new("The selector picks every app, name at least one label")),
+        Ok(_) => Ok(()),
+    }
+}
+
+/// Exempts every app the selector picks from the cleanup of inactive apps, or puts them
+/// back, like [`super::set_cleanup_exempt`] does for one
+#[tracing::instrument(skip(pool))]
+pub async fn post(
+    State(AppState { pool, .. }): State<AppState>,
+    Json(req): Json<Unvalidated<SetSelectedCleanupExemptRequest>>,
+) -> Response<Body> {
+    let SetSelectedCleanupExemptRequest { selector, exempt } = match req.validate(&()) {
+        Ok(valid) => valid.into_inner(),
+        Err(err) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: err.to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+    let (equal, not_equal, present, absent) = selector.parse::<Selector>().unwrap_or_default().params();
+
+    let projects = match sqlx::query!(
+        r#"WITH app AS (
+             SELECT projects.id, project_owners.name AS owner, projects.name AS project, projects.cleanup_exempt
+             FROM projects
+             JOIN project_owners ON projects.owner_id = project_owners.id
+             WHERE projects.deleted_at IS NULL
+             AND projects.labels @> $2::jsonb AND NOT projects.labels @> ANY($3::jsonb[])
+             AND projects.labels ?& $4::text[] AND NOT projects.labels ?| $5::text[]
+           )
+           UPDATE projects SET cleanup_exempt = $1,
+             cleanup_flagged_at = CASE WHEN $1 AND projects.cleanup_stopped_at IS NULL THEN NULL ELSE projects.cleanup_flagged_at END
+           FROM app
+           WHERE projects.id = app.id
+           RETURNING projects.id, app.owner, app.project, app.cleanup_exempt AS was_exempt
+        "#,
+        exempt,
+        equal,
+        &not_equal,
+        &present,
+        &absent
+    )
+    .fetch_all(&pool)
+    .await
+    {
+        Ok(projects) => projects,
+        Err(err) => {
+            tracing::error!(?err, "Can't set cleanup exemption: Failed to update database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Failed to update database".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    let message = match exempt {
+        true => format!("Exempted from the cleanup of inactive apps by the platform admins, for the apps labeled {selector}"),
+        false => format!("No longer exempt from the cleanup of inactive apps, for the apps labeled {selector}"),
+    };
+    let mut apps = Vec::with_capacity(projects.len());
+    let mut changed = Vec::new();
+    for project in projects {
+        let app = format!("{}/{}", project.owner, project.project);
+        if project.was_exempt != exempt {
+            if let Err(err) = record_activity(project.id, "cleanup", &message, &pool).await {
+                tracing::error!(?err, "Can't record activity: Failed to insert into database");
+            }
+            changed.push(app.clone());
+        }
+        apps.push(app);
+    }
+
+    let json = serde_json::to_string(&SelectedAppsResponse { apps }).unwrap();
+
+    with_change(
+        Response::builder()
+            .status(StatusCode::OK)
+            .body(Body::from(json))
+            .unwrap(),
+        AuditChange::new(
+            Some(serde_json::json!({ "selector": selector, "exempt": !exempt, "apps": changed })),
+            Some(serde_json::json!({ "selector": selector, "exempt": exempt, "apps": changed })),
+        ),
+    )
+}
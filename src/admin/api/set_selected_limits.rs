@@ -0,0 +1,163 @@
+use axum::extract::State;
+use axum::response::Response;
+use axum::Json;
+use garde::{Unvalidated, Validate};
+use hyper::{Body, StatusCode};
+use serde::{Deserialize, Serialize};
+
+use crate::activity::record_activity;
+use crate::audit::{with_change, AuditChange};
+use crate::labels::Selector;
+use crate::limits::{apply_limits, project_limits, LimitOverrides};
+use crate::startup::AppState;
+
+#[derive(Deserialize, Validate, Debug)]
+pub struct SetSelectedLimitsRequest {
+    /// the apps to set the limits of, by their labels
+    #[garde(custom(selector_check))]
+    selector: String,
+    #[serde(flatten)]
+    #[garde(dive)]
+    limits: LimitOverrides,
+}
+
+#[derive(Serialize, Debug)]
+struct SelectedAppsResponse {
+    /// owner/project of every app the selector picked
+    apps: Vec<String>,
+}
+
+#[derive(Serialize, Debug)]
+struct ErrorResponse {
+    message: String,
+}
+
+fn selector_check(value: &String, _ctx: &()) -> garde::Result {
+    match value.parse::<Selector>() {
+        Err(err) => Err(garde::Error::new(err)),
+        Ok(selector) if selector.is_empty() => Err(garde::Error::new("The selector picks every app, name at least one label")),
+        Ok(_) => Ok(()),
+    }
+}
+
+/// Sets the limits of every app the selector picks, like they were set on each of them.
+/// Apps labeled later don't get them, the limits stay on the apps picked now
+#[tracing::instrument(skip(pool, container_settings))]
+pub async fn post(
+    State(AppState { pool, container_settings, .. }): State<AppState>,
+    Json(req): Json<Unvalidated<SetSelectedLimitsRequest>>,
+) -> Response<Body> {
+    let SetSelectedLimitsRequest { selector, limits: overrides } = match req.validate(&()) {
+        Ok(valid) => valid.into_inner(),
+        Err(err) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: err.to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+    let (equal, not_equal, present, absent) = selector.parse::<Selector>().unwrap_or_default().params();
+
+    let projects = match sqlx::query!(
+        r#"WITH old AS (
+             SELECT projects.id, project_owners.name AS owner, projects.name AS project,
+               projects.memory_limit, projects.cpu_limit, projects.disk_limit
+             FROM projects
+             JOIN project_owners ON projects.owner_id = project_owners.id
+             WHERE projects.deleted_at IS NULL
+             AND projects.labels @> $4::jsonb AND NOT projects.labels @> ANY($5::jsonb[])
+             AND projects.labels ?& $6::text[] AND NOT projects.labels ?| $7::text[]
+           )
+           UPDATE projects SET memory_limit = $1, cpu_limit = $2, disk_limit = $3
+           FROM old
+           WHERE projects.id = old.id
+           RETURNING projects.id, old.owner, old.project, old.memory_limit AS old_memory,
+             old.cpu_limit AS old_cpus, old.disk_limit AS old_disk
+        "#,
+        overrides.memory,
+        overrides.cpus,
+        overrides.disk,
+        equal,
+        &not_equal,
+        &present,
+        &absent
+    )
+    .fetch_all(&pool)
+    .await
+    {
+        Ok(projects) => projects,
+        Err(err) => {
+            tracing::error!(?err, "Can't set limits: Failed to update database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Failed to update database".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    let mut apps = Vec::with_capacity(projects.len());
+    let mut before = serde_json::Map::new();
+    let mut failed = Vec::new();
+    for project in projects {
+        let app = format!("{}/{}", project.owner, project.project);
+        before.insert(app.clone(), serde_json::to_value(LimitOverrides {
+            memory: project.old_memory,
+            cpus: project.old_cpus,
+            disk: project.old_disk,
+        }).unwrap_or_default());
+
+        match apply_limits(project.id, &container_settings, &pool).await {
+            Ok(()) => {
+                if let Ok(limits) = project_limits(project.id, &container_settings, &pool).await {
+                    let message = format!("Resource limits changed by the platform admins to {limits}, for the apps labeled {selector}");
+                    if let Err(err) = record_activity(project.id, "limits", &message, &pool).await {
+                        tracing::error!(?err, "Can't record activity: Failed to insert into database");
+                    }
+                }
+            }
+            Err(err) => {
+                tracing::error!(?err, app, "Can't set limits: Failed to apply them");
+                failed.push(app.clone());
+            }
+        }
+        apps.push(app);
+    }
+
+    let change = AuditChange::new(
+        Some(serde_json::Value::Object(before)),
+        Some(serde_json::json!({ "selector": selector, "limits": overrides })),
+    );
+
+    if !failed.is_empty() {
+        let json = serde_json::to_string(&ErrorResponse {
+            message: format!("Limits are set, but failed to apply them on {}, they apply from the next deploy", failed.join(", "))
+        }).unwrap();
+
+        return with_change(
+            Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap(),
+            change,
+        );
+    }
+
+    let json = serde_json::to_string(&SelectedAppsResponse { apps }).unwrap();
+
+    with_change(
+        Response::builder()
+            .status(StatusCode::OK)
+            .body(Body::from(json))
+            .unwrap(),
+        change,
+    )
+}
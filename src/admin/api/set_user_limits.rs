@@ -0,0 +1,109 @@
+use axum::extract::{Path, State};
+use axum::response::Response;
+use axum::Json;
+use garde::{Unvalidated, Validate};
+use hyper::{Body, StatusCode};
+use serde::Serialize;
+
+use crate::audit::{with_change, AuditChange};
+use crate::limits::{apply_user_limits, LimitOverrides};
+use crate::startup::AppState;
+
+#[derive(Serialize, Debug)]
+struct ErrorResponse {
+    message: String,
+}
+
+/// Sets the limits of a user with heavier apps, every app of an owner they own gets them
+/// unless the app has its own. Null gives them the defaults again
+#[tracing::instrument(skip(pool, container_settings))]
+pub async fn post(
+    State(AppState { pool, container_settings, .. }): State<AppState>,
+    Path(username): Path<String>,
+    Json(req): Json<Unvalidated<LimitOverrides>>,
+) -> Response<Body> {
+    let overrides = match req.validate(&()) {
+        Ok(valid) => valid.into_inner(),
+        Err(err) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: err.to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    let user = match sqlx::query!(
+        r#"WITH old AS (
+             SELECT id, memory_limit, cpu_limit, disk_limit FROM users WHERE username = $4
+           )
+           UPDATE users SET memory_limit = $1, cpu_limit = $2, disk_limit = $3
+           FROM old
+           WHERE users.id = old.id
+           RETURNING users.id, old.memory_limit AS old_memory, old.cpu_limit AS old_cpus,
+             old.disk_limit AS old_disk
+        "#,
+        overrides.memory,
+        overrides.cpus,
+        overrides.disk,
+        username
+    )
+    .fetch_optional(&pool)
+    .await
+    {
+        Ok(Some(user)) => user,
+        Ok(None) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "User does not exist".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Err(err) => {
+            tracing::error!(?err, "Can't set limits: Failed to update database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Failed to update database".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    if let Err(err) = apply_user_limits(user.id, &container_settings, &pool).await {
+        tracing::error!(?err, "Can't set limits: Failed to apply them");
+
+        let json = serde_json::to_string(&ErrorResponse {
+            message: format!("Limits are set, but failed to apply them, they apply from the next deploy: {err}")
+        }).unwrap();
+
+        return Response::builder()
+            .status(StatusCode::INTERNAL_SERVER_ERROR)
+            .body(Body::from(json))
+            .unwrap();
+    }
+
+    let before = LimitOverrides {
+        memory: user.old_memory,
+        cpus: user.old_cpus,
+        disk: user.old_disk,
+    };
+    let json = serde_json::to_string(&overrides).unwrap();
+
+    with_change(
+        Response::builder()
+            .status(StatusCode::OK)
+            .body(Body::from(json))
+            .unwrap(),
+        AuditChange::new(serde_json::to_value(before).ok(), serde_json::to_value(&overrides).ok()),
+    )
+}
@@ -0,0 +1,119 @@
+use axum::extract::{Path, State};
+use axum::response::Response;
+use axum::Json;
+use garde::{Unvalidated, Validate};
+use hyper::{Body, StatusCode};
+use serde::Serialize;
+
+use crate::audit::{with_change, AuditChange};
+use crate::quotas::{account_quotas, QuotaOverrides};
+use crate::startup::AppState;
+
+#[derive(Serialize, Debug)]
+struct ErrorResponse {
+    message: String,
+}
+
+/// Sets the quotas of a user, null gives them the one of the platform again. Lowering a
+/// quota below what the account has takes nothing away, it only stops it from growing
+#[tracing::instrument(skip(pool, quota_settings))]
+pub async fn post(
+    State(AppState { pool, quota_settings, .. }): State<AppState>,
+    Path(username): Path<String>,
+    Json(req): Json<Unvalidated<QuotaOverrides>>,
+) -> Response<Body> {
+    let overrides = match req.validate(&()) {
+        Ok(valid) => valid.into_inner(),
+        Err(err) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: err.to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    let user = match sqlx::query!(
+        r#"WITH old AS (
+             SELECT id, app_quota, build_quota, storage_quota, database_quota, port_quota
+             FROM users WHERE username = $6
+           )
+           UPDATE users SET app_quota = $1, build_quota = $2, storage_quota = $3, database_quota = $4,
+             port_quota = $5
+           FROM old
+           WHERE users.id = old.id
+           RETURNING users.id, old.app_quota AS old_apps, old.build_quota AS old_builds,
+             old.storage_quota AS old_storage, old.database_quota AS old_database,
+             old.port_quota AS old_ports
+        "#,
+        overrides.apps,
+        overrides.builds,
+        overrides.storage,
+        overrides.database,
+        overrides.ports,
+        username
+    )
+    .fetch_optional(&pool)
+    .await
+    {
+        Ok(Some(user)) => user,
+        Ok(None) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "User does not exist".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Err(err) => {
+            tracing::error!(?err, "Can't set quotas: Failed to update database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Failed to update database".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    let quotas = match account_quotas(user.id, &quota_settings, &pool).await {
+        Ok(quotas) => quotas,
+        Err(err) => {
+            tracing::error!(?err, "Can't get quotas: Failed to query usage");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Quotas are set, but failed to query usage: {err}")
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    let before = QuotaOverrides {
+        apps: user.old_apps,
+        builds: user.old_builds,
+        storage: user.old_storage,
+        database: user.old_database,
+        ports: user.old_ports,
+    };
+    let json = serde_json::to_string(&quotas).unwrap();
+
+    with_change(
+        Response::builder()
+            .status(StatusCode::OK)
+            .body(Body::from(json))
+            .unwrap(),
+        AuditChange::new(serde_json::to_value(before).ok(), serde_json::to_value(&overrides).ok()),
+    )
+}
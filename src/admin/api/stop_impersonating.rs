@@ -0,0 +1,34 @@
+use axum::response::Response;
+use hyper::{Body, StatusCode};
+use serde::Serialize;
+
+use crate::admin::{impersonation, IMPERSONATION_KEY};
+use crate::auth::Auth;
+
+#[derive(Serialize, Debug)]
+struct ErrorResponse {
+    message: String,
+}
+
+/// Logs the admin who impersonated the current user back in
+#[tracing::instrument(skip(auth))]
+pub async fn post(auth: Auth) -> Response<Body> {
+    let Some(impersonation) = impersonation(&auth) else {
+        let json = serde_json::to_string(&ErrorResponse {
+            message: "Not impersonating anyone".to_string()
+        }).unwrap();
+
+        return Response::builder()
+            .status(StatusCode::BAD_REQUEST)
+            .body(Body::from(json))
+            .unwrap();
+    };
+
+    auth.session.remove(IMPERSONATION_KEY);
+    auth.login_user(impersonation.admin_id);
+
+    Response::builder()
+        .status(StatusCode::NO_CONTENT)
+        .body(Body::empty())
+        .unwrap()
+}
@@ -0,0 +1,131 @@
+use axum::extract::{Path, State};
+use axum::response::Response;
+use axum::Json;
+use garde::{Unvalidated, Validate};
+use hyper::{Body, StatusCode};
+use serde::{Deserialize, Serialize};
+
+use crate::activity::record_activity;
+use crate::audit::{with_change, AuditChange};
+use crate::orchestrator;
+use crate::startup::AppState;
+
+#[derive(Deserialize, Validate, Debug)]
+pub struct SuspendAppRequest {
+    /// shown to the members of the app, and in their activity log
+    #[garde(length(min = 1, max = 1000))]
+    reason: String,
+}
+
+#[derive(Serialize, Debug)]
+struct ErrorResponse {
+    message: String,
+}
+
+/// Stops every container of an app and keeps it stopped: the proxy answers 503, nothing is
+/// built or scaled, cron jobs don't run and members can only look at it
+#[tracing::instrument(skip(pool, build_queue, container_settings))]
+pub async fn post(
+    State(AppState { pool, build_queue, container_settings, .. }): State<AppState>,
+    Path((owner, project)): Path<(String, String)>,
+    Json(req): Json<Unvalidated<SuspendAppRequest>>,
+) -> Response<Body> {
+    let SuspendAppRequest { reason } = match req.validate(&()) {
+        Ok(valid) => valid.into_inner(),
+        Err(err) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: err.to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    // it takes over from the cleanup and from a member stopping the app, an admin suspension
+    // isn't deleted and members can't start it
+    let project_record = match sqlx::query!(
+        r#"UPDATE projects SET suspended_at = now(), suspended_reason = $1,
+             cleanup_flagged_at = NULL, cleanup_stopped_at = NULL, stopped_at = NULL
+           FROM project_owners
+           WHERE projects.owner_id = project_owners.id
+           AND project_owners.name = $2 AND projects.name = $3
+           RETURNING projects.id
+        "#,
+        reason,
+        owner,
+        project
+    )
+    .fetch_optional(&pool)
+    .await
+    {
+        Ok(Some(record)) => record,
+        Ok(None) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Project does not exist".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Err(err) => {
+            tracing::error!(?err, "Can't suspend app: Failed to update database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Failed to update database".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    // a build that finishes would start the app again
+    match sqlx::query!(
+        r#"SELECT id FROM builds WHERE project_id = $1 AND status IN ('pending', 'building')"#,
+        project_record.id
+    )
+    .fetch_all(&pool)
+    .await
+    {
+        Ok(builds) => {
+            for build in builds {
+                build_queue.cancel(build.id, "Cancelled, the app was suspended", &pool).await;
+            }
+        }
+        Err(err) => tracing::error!(?err, "Can't cancel builds: Failed to query database"),
+    }
+
+    let container_name = format!("{owner}-{project}").replace('.', "-");
+    if let Err(err) = orchestrator::driver().suspend(&container_name, &container_settings).await {
+        tracing::error!(?err, "Can't suspend app: Failed to stop containers");
+
+        let json = serde_json::to_string(&ErrorResponse {
+            message: "App is suspended, but some containers failed to stop. Try again".to_string()
+        }).unwrap();
+
+        return Response::builder()
+            .status(StatusCode::INTERNAL_SERVER_ERROR)
+            .body(Body::from(json))
+            .unwrap();
+    }
+
+    let message = format!("Suspended by the platform admins: {reason}");
+    if let Err(err) = record_activity(project_record.id, "suspend", &message, &pool).await {
+        tracing::error!(?err, "Can't record activity: Failed to insert into database");
+    }
+
+    with_change(
+        Response::builder()
+            .status(StatusCode::NO_CONTENT)
+            .body(Body::empty())
+            .unwrap(),
+        AuditChange::new(None, Some(serde_json::json!({ "reason": reason }))),
+    )
+}
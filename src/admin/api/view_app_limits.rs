@@ -0,0 +1,75 @@
+use axum::extract::{Path, State};
+use axum::response::Response;
+use hyper::{Body, StatusCode};
+use serde::Serialize;
+
+use crate::limits::resolve_limits;
+use crate::startup::AppState;
+
+#[derive(Serialize, Debug)]
+struct ErrorResponse {
+    message: String,
+}
+
+/// The limits of any app, without being a member of its owner
+#[tracing::instrument(skip(pool, container_settings))]
+pub async fn get(
+    State(AppState { pool, container_settings, .. }): State<AppState>,
+    Path((owner, project)): Path<(String, String)>,
+) -> Response<Body> {
+    let project_record = match sqlx::query!(
+        r#"SELECT projects.id AS id
+           FROM projects
+           JOIN project_owners ON projects.owner_id = project_owners.id
+           WHERE project_owners.name = $1 AND projects.name = $2
+        "#,
+        owner,
+        project
+    )
+    .fetch_optional(&pool)
+    .await
+    {
+        Ok(Some(record)) => record,
+        Ok(None) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Project does not exist".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Err(err) => {
+            tracing::error!(?err, "Can't get projects: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    match resolve_limits(project_record.id, &container_settings, &pool).await {
+        Ok(limits) => Response::builder()
+            .status(StatusCode::OK)
+            .body(Body::from(serde_json::to_string(&limits).unwrap()))
+            .unwrap(),
+        Err(err) => {
+            tracing::error!(?err, "Can't get limits: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
+            Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap()
+        }
+    }
+}
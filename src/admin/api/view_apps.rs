@@ -0,0 +1,194 @@
+use axum::extract::{Query, State};
+use axum::response::Response;
+use chrono::{DateTime, Utc};
+use hyper::{Body, StatusCode};
+use serde::{Deserialize, Serialize};
+use uuid::Uuid;
+
+use crate::labels::{from_json, Labels, Selector};
+use crate::pagination::{self, INVALID_CURSOR};
+use crate::projects::APP_STATUSES;
+use crate::startup::AppState;
+
+#[derive(Deserialize, Debug)]
+pub struct AppListQuery {
+    /// only the apps of this owner
+    owner: Option<String>,
+    /// only the apps doing this, one of [`APP_STATUSES`]
+    status: Option<String>,
+    /// only the apps with these labels, see [`Selector`]
+    selector: Option<String>,
+    /// `next_cursor` of the page before
+    cursor: Option<String>,
+    limit: Option<i64>,
+}
+
+#[derive(Serialize, Debug)]
+struct App {
+    id: Uuid,
+    owner: String,
+    project: String,
+    status: String,
+    labels: Labels,
+    /// running containers, counted from the latest metric samples
+    containers: i64,
+    cpu_percent: Option<f64>,
+    memory_bytes: Option<i64>,
+    suspended_at: Option<DateTime<Utc>>,
+    suspended_reason: Option<String>,
+    created_at: DateTime<Utc>,
+}
+
+#[derive(Serialize, Debug)]
+struct AppListResponse {
+    data: Vec<App>,
+    /// missing once there are no older apps
+    next_cursor: Option<String>,
+}
+
+#[derive(Serialize, Debug)]
+struct ErrorResponse {
+    message: String,
+}
+
+/// Every app on the platform with what its containers use right now, newest first. Sorting by
+/// usage is up to the caller, it changes between pages
+#[tracing::instrument(skip(pool, container_settings))]
+pub async fn get(
+    State(AppState { pool, container_settings, .. }): State<AppState>,
+    Query(AppListQuery { owner, status, selector, cursor, limit }): Query<AppListQuery>,
+) -> Response<Body> {
+    if let Some(status) = status.as_deref().filter(|status| !APP_STATUSES.contains(status)) {
+        let json = serde_json::to_string(&ErrorResponse {
+            message: format!("Unknown status {status}, it must be one of {}", APP_STATUSES.join(", "))
+        }).unwrap();
+
+        return Response::builder()
+            .status(StatusCode::BAD_REQUEST)
+            .body(Body::from(json))
+            .unwrap();
+    }
+
+    let selector = match selector.as_deref().unwrap_or_default().parse::<Selector>() {
+        Ok(selector) => selector,
+        Err(err) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: err
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+    let (equal, not_equal, present, absent) = selector.params();
+
+    let (before, before_id) = match cursor.as_deref().map(pagination::parse_cursor) {
+        Some(Some((before, before_id))) => (Some(before), Some(before_id)),
+        Some(None) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: INVALID_CURSOR.to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        None => (None, None),
+    };
+    let limit = pagination::limit(limit);
+
+    // a container missing from the last few samples isn't running anymore
+    let recent = (container_settings.metricsinterval * 3) as f64;
+
+    let apps = match sqlx::query!(
+        r#"SELECT apps.id AS "id!", apps.owner AS "owner!", apps.project AS "project!", apps.status AS "status!", apps.labels AS "labels!",
+           apps.suspended_at, apps.suspended_reason, apps.created_at AS "created_at!",
+           usage.containers AS "containers!", usage.cpu_percent, usage.memory_bytes
+           FROM (
+             SELECT projects.id, project_owners.name AS owner, projects.name AS project,
+                    projects.suspended_at, projects.suspended_reason, projects.labels, projects.created_at,
+                    CASE WHEN projects.stopped_at IS NOT NULL OR projects.cleanup_stopped_at IS NOT NULL THEN 'stopped'
+                         WHEN projects.suspended_at IS NOT NULL THEN 'suspended'
+                         WHEN projects.crash_looping_at IS NOT NULL THEN 'crashing'
+                         WHEN projects.maintenance_at IS NOT NULL THEN 'maintenance'
+                         WHEN NOT EXISTS (SELECT 1 FROM releases WHERE releases.project_id = projects.id) THEN 'new'
+                         ELSE 'running'
+                    END AS status
+             FROM projects
+             JOIN project_owners ON projects.owner_id = project_owners.id
+           ) AS apps
+           CROSS JOIN LATERAL (
+             SELECT count(*) AS containers, sum(latest.cpu_percent) AS cpu_percent,
+             sum(latest.memory_bytes)::bigint AS memory_bytes
+             FROM (
+               SELECT DISTINCT ON (container) cpu_percent, memory_bytes FROM container_metrics
+               WHERE container_metrics.project_id = apps.id
+               AND recorded_at > now() - make_interval(secs => $1)
+               ORDER BY container, recorded_at DESC
+             ) latest
+           ) usage
+           WHERE ($2::text IS NULL OR apps.owner = $2)
+           AND ($3::text IS NULL OR apps.status = $3)
+           AND ($4::timestamptz IS NULL OR (apps.created_at, apps.id) < ($4, $5::uuid))
+           AND apps.labels @> $7::jsonb AND NOT apps.labels @> ANY($8::jsonb[])
+           AND apps.labels ?& $9::text[] AND NOT apps.labels ?| $10::text[]
+           ORDER BY apps.created_at DESC, apps.id DESC
+           LIMIT $6
+        "#,
+        recent,
+        owner,
+        status,
+        before,
+        before_id,
+        limit,
+        equal,
+        &not_equal,
+        &present,
+        &absent
+    )
+    .fetch_all(&pool)
+    .await
+    {
+        Ok(apps) => apps,
+        Err(err) => {
+            tracing::error!(?err, "Can't get apps: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    let next_cursor = pagination::next_cursor(&apps, limit, |app| (app.created_at, app.id));
+    let data = apps
+        .into_iter()
+        .map(|app| App {
+            id: app.id,
+            owner: app.owner,
+            project: app.project,
+            status: app.status,
+            labels: from_json(app.labels),
+            containers: app.containers,
+            cpu_percent: app.cpu_percent,
+            memory_bytes: app.memory_bytes,
+            suspended_at: app.suspended_at,
+            suspended_reason: app.suspended_reason,
+            created_at: app.created_at,
+        })
+        .collect();
+
+    let json = serde_json::to_string(&AppListResponse { data, next_cursor }).unwrap();
+
+    Response::builder()
+        .status(StatusCode::OK)
+        .body(Body::from(json))
+        .unwrap()
+}
@@ -0,0 +1,36 @@
+use axum::extract::{Query, State};
+use axum::response::Response;
+use hyper::{Body, StatusCode};
+use serde::Serialize;
+
+use crate::audit::{entries, export, AuditFilter};
+use crate::startup::AppState;
+
+#[derive(Serialize, Debug)]
+struct ErrorResponse {
+    message: String,
+}
+
+/// Changes made anywhere on the platform, newest first. Filter by owner, actor, action and
+/// time, `format=csv` downloads them
+#[tracing::instrument(skip(pool))]
+pub async fn get(
+    State(AppState { pool, .. }): State<AppState>,
+    Query(filter): Query<AuditFilter>,
+) -> Response<Body> {
+    match entries(&filter, &pool).await {
+        Ok(entries) => export(&entries, filter.format.as_deref(), "audit"),
+        Err(err) => {
+            tracing::error!(?err, "Can't get audit log: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
+            Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap()
+        }
+    }
+}
@@ -0,0 +1,72 @@
+use axum::extract::State;
+use axum::response::Response;
+use chrono::{DateTime, Utc};
+use hyper::{Body, StatusCode};
+use serde::Serialize;
+
+use crate::cleanup::{cleanup_apps, CleanupApp, Step};
+use crate::startup::AppState;
+
+#[derive(Serialize, Debug)]
+struct App {
+    #[serde(flatten)]
+    app: CleanupApp,
+    /// what the next sweep does to it
+    next_step: &'static str,
+    stop_at: Option<DateTime<Utc>>,
+    delete_at: Option<DateTime<Utc>>,
+}
+
+#[derive(Serialize, Debug)]
+struct CleanupListResponse {
+    enabled: bool,
+    data: Vec<App>,
+}
+
+#[derive(Serialize, Debug)]
+struct ErrorResponse {
+    message: String,
+}
+
+/// Apps the cleanup flagged, stopped or is about to, and the ones exempt from it
+#[tracing::instrument(skip(pool, cleanup_settings))]
+pub async fn get(State(AppState { pool, cleanup_settings, .. }): State<AppState>) -> Response<Body> {
+    let apps = match cleanup_apps(None, &pool).await {
+        Ok(apps) => apps,
+        Err(err) => {
+            tracing::error!(?err, "Can't get cleanup: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    let now = Utc::now();
+    let data = apps
+        .into_iter()
+        .map(|app| (app.next_step(&cleanup_settings, now), app))
+        .filter(|(step, app)| *step != Step::Nothing || app.exempt || app.flagged_at.is_some() || app.stopped_at.is_some())
+        .map(|(step, app)| App {
+            next_step: step.name(),
+            stop_at: app.stop_at(&cleanup_settings),
+            delete_at: app.delete_at(&cleanup_settings),
+            app,
+        })
+        .collect();
+
+    let json = serde_json::to_string(&CleanupListResponse {
+        enabled: cleanup_settings.inactivedays > 0,
+        data,
+    }).unwrap();
+
+    Response::builder()
+        .status(StatusCode::OK)
+        .body(Body::from(json))
+        .unwrap()
+}
@@ -0,0 +1,98 @@
+use axum::extract::State;
+use axum::response::Response;
+use chrono::{DateTime, Utc};
+use hyper::{Body, StatusCode};
+use serde::Serialize;
+
+use crate::startup::AppState;
+
+#[derive(Serialize, Debug)]
+struct Container {
+    name: String,
+    owner: String,
+    project: String,
+    process: String,
+    cpu_percent: f64,
+    memory_bytes: i64,
+    memory_limit: i64,
+    network_rx: f64,
+    network_tx: f64,
+    restarts: i32,
+    oom_killed: bool,
+    recorded_at: DateTime<Utc>,
+}
+
+#[derive(Serialize, Debug)]
+struct ContainerListResponse {
+    data: Vec<Container>,
+}
+
+#[derive(Serialize, Debug)]
+struct ErrorResponse {
+    message: String,
+}
+
+/// Web and worker containers of every app as of their latest metric sample, the busiest first
+#[tracing::instrument(skip(pool, container_settings))]
+pub async fn get(State(AppState { pool, container_settings, .. }): State<AppState>) -> Response<Body> {
+    // a container missing from the last few samples isn't running anymore
+    let recent = (container_settings.metricsinterval * 3) as f64;
+
+    let containers = match sqlx::query!(
+        r#"SELECT latest.container, latest.process, latest.cpu_percent, latest.memory_bytes,
+           latest.memory_limit, latest.network_rx, latest.network_tx, latest.restarts,
+           latest.oom_killed, latest.recorded_at, projects.name AS project, project_owners.name AS owner
+           FROM (
+             SELECT DISTINCT ON (container) * FROM container_metrics
+             WHERE recorded_at > now() - make_interval(secs => $1)
+             ORDER BY container, recorded_at DESC
+           ) latest
+           JOIN projects ON projects.id = latest.project_id
+           JOIN project_owners ON projects.owner_id = project_owners.id
+           ORDER BY latest.cpu_percent DESC
+        "#,
+        recent
+    )
+    .fetch_all(&pool)
+    .await
+    {
+        Ok(containers) => containers,
+        Err(err) => {
+            tracing::error!(?err, "Can't get containers: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    let data = containers
+        .into_iter()
+        .map(|container| Container {
+            name: container.container,
+            owner: container.owner,
+            project: container.project,
+            process: container.process,
+            cpu_percent: container.cpu_percent,
+            memory_bytes: container.memory_bytes,
+            memory_limit: container.memory_limit,
+            network_rx: container.network_rx,
+            network_tx: container.network_tx,
+            restarts: container.restarts,
+            oom_killed: container.oom_killed,
+            recorded_at: container.recorded_at,
+        })
+        .collect();
+
+    let json = serde_json::to_string(&ContainerListResponse { data }).unwrap();
+
+    Response::builder()
+        .status(StatusCode::OK)
+        .body(Body::from(json))
+        .unwrap()
+}
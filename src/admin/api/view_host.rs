@@ -0,0 +1,40 @@
+use axum::extract::State;
+use axum::response::Response;
+use hyper::{Body, StatusCode};
+use serde::Serialize;
+
+use crate::queue::BuildQueueState;
+use crate::startup::AppState;
+
+#[derive(Serialize, Debug)]
+struct HostResponse {
+    /// no build starts while draining
+    draining: bool,
+    builds_waiting: usize,
+    builds_running: usize,
+    /// draining and nothing builds anymore, the host can go down for maintenance
+    drained: bool,
+}
+
+pub(super) async fn host_status(build_queue: &BuildQueueState) -> Response<Body> {
+    let draining = build_queue.draining();
+    let (builds_waiting, builds_running) = build_queue.counts().await;
+
+    let json = serde_json::to_string(&HostResponse {
+        draining,
+        builds_waiting,
+        builds_running,
+        drained: draining && builds_running == 0,
+    }).unwrap();
+
+    Response::builder()
+        .status(StatusCode::OK)
+        .body(Body::from(json))
+        .unwrap()
+}
+
+/// Whether the host is draining and how far along it is
+#[tracing::instrument(skip(build_queue))]
+pub async fn get(State(AppState { build_queue, .. }): State<AppState>) -> Response<Body> {
+    host_status(&build_queue).await
+}
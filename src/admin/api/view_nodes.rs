@@ -0,0 +1,102 @@
+use axum::extract::State;
+use axum::response::Response;
+use chrono::{DateTime, Utc};
+use hyper::{Body, StatusCode};
+use serde::Serialize;
+
+use crate::startup::AppState;
+
+#[derive(Serialize, Debug)]
+struct Node {
+    name: String,
+    /// new until its agent first reports, down once it stops reporting for the node timeout
+    status: &'static str,
+    docker_url: Option<String>,
+    cpus: i32,
+    /// MiB
+    memory: i32,
+    memory_available: i32,
+    load: f64,
+    containers: i32,
+    /// what runs the containers, docker or podman
+    runtime: String,
+    /// like amd64 or arm64, None until an agent that reports it does
+    arch: Option<String>,
+    /// apps placed on the node
+    apps: i64,
+    last_seen_at: Option<DateTime<Utc>>,
+    created_at: DateTime<Utc>,
+}
+
+#[derive(Serialize, Debug)]
+struct NodeListResponse {
+    data: Vec<Node>,
+}
+
+#[derive(Serialize, Debug)]
+struct ErrorResponse {
+    message: String,
+}
+
+/// Every node with what its host had left when it last reported
+#[tracing::instrument(skip(pool, container_settings))]
+pub async fn get(State(AppState { pool, container_settings, .. }): State<AppState>) -> Response<Body> {
+    let nodes = match sqlx::query!(
+        r#"SELECT nodes.name, nodes.docker_url, nodes.cpus, nodes.memory, nodes.memory_available,
+           nodes.load, nodes.containers, nodes.runtime, nodes.arch, nodes.draining, nodes.last_seen_at, nodes.created_at,
+           COALESCE(nodes.last_seen_at > now() - make_interval(secs => $1), false) AS "fresh!",
+           (SELECT count(*) FROM projects WHERE projects.node_id = nodes.id) AS "apps!"
+           FROM nodes
+           ORDER BY nodes.name
+        "#,
+        container_settings.nodetimeout as f64,
+    )
+    .fetch_all(&pool)
+    .await
+    {
+        Ok(nodes) => nodes,
+        Err(err) => {
+            tracing::error!(?err, "Can't get nodes: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    let data = nodes
+        .into_iter()
+        .map(|node| Node {
+            status: match (node.last_seen_at, node.fresh, node.draining) {
+                (None, _, _) => "new",
+                (_, false, _) => "down",
+                (_, true, true) => "draining",
+                (_, true, false) => "ready",
+            },
+            name: node.name,
+            docker_url: node.docker_url,
+            cpus: node.cpus,
+            memory: node.memory,
+            memory_available: node.memory_available,
+            load: node.load,
+            containers: node.containers,
+            runtime: node.runtime,
+            arch: node.arch,
+            apps: node.apps,
+            last_seen_at: node.last_seen_at,
+            created_at: node.created_at,
+        })
+        .collect();
+
+    let json = serde_json::to_string(&NodeListResponse { data }).unwrap();
+
+    Response::builder()
+        .status(StatusCode::OK)
+        .body(Body::from(json))
+        .unwrap()
+}
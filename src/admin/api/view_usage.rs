@@ -0,0 +1,51 @@
+use axum::extract::{Query, State};
+use axum::response::Response;
+use hyper::{Body, StatusCode};
+use serde::Serialize;
+
+use crate::startup::AppState;
+use crate::usage::{export, report, UsageFilter};
+
+#[derive(Serialize, Debug)]
+struct ErrorResponse {
+    message: String,
+}
+
+/// What every app used in a month, the most cpu first. `by=owner` adds up the apps of each
+/// owner, `format=csv` downloads the report
+#[tracing::instrument(skip(pool))]
+pub async fn get(
+    State(AppState { pool, .. }): State<AppState>,
+    Query(filter): Query<UsageFilter>,
+) -> Response<Body> {
+    let month = match filter.month() {
+        Some(month) => month,
+        None => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Month must be like 2026-10".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+    let by_owner = filter.by.as_deref() == Some("owner");
+
+    match report(month, filter.owner.as_deref(), by_owner, &pool).await {
+        Ok(rows) => export(&rows, filter.format.as_deref(), &format!("usage-{}", month.format("%Y-%m"))),
+        Err(err) => {
+            tracing::error!(?err, "Can't get usage: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
+            Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap()
+        }
+    }
+}
@@ -0,0 +1,62 @@
+use axum::extract::{Path, State};
+use axum::response::Response;
+use hyper::{Body, StatusCode};
+use serde::Serialize;
+
+use crate::limits::LimitOverrides;
+use crate::startup::AppState;
+
+#[derive(Serialize, Debug)]
+struct ErrorResponse {
+    message: String,
+}
+
+/// The limits set on a user, null for those the platform defaults decide
+#[tracing::instrument(skip(pool))]
+pub async fn get(
+    State(AppState { pool, .. }): State<AppState>,
+    Path(username): Path<String>,
+) -> Response<Body> {
+    match sqlx::query!(
+        "SELECT memory_limit, cpu_limit, disk_limit FROM users WHERE username = $1",
+        username
+    )
+    .fetch_optional(&pool)
+    .await
+    {
+        Ok(Some(user)) => {
+            let json = serde_json::to_string(&LimitOverrides {
+                memory: user.memory_limit,
+                cpus: user.cpu_limit,
+                disk: user.disk_limit,
+            }).unwrap();
+
+            Response::builder()
+                .status(StatusCode::OK)
+                .body(Body::from(json))
+                .unwrap()
+        }
+        Ok(None) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "User does not exist".to_string()
+            }).unwrap();
+
+            Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap()
+        }
+        Err(err) => {
+            tracing::error!(?err, "Can't get limits: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
+            Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap()
+        }
+    }
+}
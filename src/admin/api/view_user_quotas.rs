@@ -0,0 +1,71 @@
+use axum::extract::{Path, State};
+use axum::response::Response;
+use hyper::{Body, StatusCode};
+use serde::Serialize;
+
+use crate::quotas::account_quotas;
+use crate::startup::AppState;
+
+#[derive(Serialize, Debug)]
+struct ErrorResponse {
+    message: String,
+}
+
+/// The quotas of a user next to what their account uses
+#[tracing::instrument(skip(pool, quota_settings))]
+pub async fn get(
+    State(AppState { pool, quota_settings, .. }): State<AppState>,
+    Path(username): Path<String>,
+) -> Response<Body> {
+    let user = match sqlx::query!("SELECT id FROM users WHERE username = $1", username)
+        .fetch_optional(&pool)
+        .await
+    {
+        Ok(Some(user)) => user,
+        Ok(None) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "User does not exist".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Err(err) => {
+            tracing::error!(?err, "Can't get users: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    match account_quotas(user.id, &quota_settings, &pool).await {
+        Ok(quotas) => {
+            let json = serde_json::to_string(&quotas).unwrap();
+
+            Response::builder()
+                .status(StatusCode::OK)
+                .body(Body::from(json))
+                .unwrap()
+        }
+        Err(err) => {
+            tracing::error!(?err, "Can't get quotas: Failed to query usage");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query usage: {err}")
+            }).unwrap();
+
+            Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap()
+        }
+    }
+}
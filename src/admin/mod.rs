@@ -0,0 +1,93 @@
+use axum::{extract::State, middleware::Next, response::Response};
+use bytes::Bytes;
+use http_body::combinators::UnsyncBoxBody;
+use hyper::{Body, Method, Request, StatusCode};
+use serde::{Deserialize, Serialize};
+use sqlx::PgPool;
+use uuid::Uuid;
+
+use crate::{auth::{tokens::TokenAccess, Auth}, startup::AppState};
+
+pub mod api;
+
+/// Whether the user runs the platform, `users.role` is admin
+pub async fn is_admin(user_id: Uuid, pool: &PgPool) -> Result<bool, sqlx::Error> {
+    let user = sqlx::query!(
+        r#"SELECT role = 'admin' AS "admin!" FROM users WHERE id = $1"#,
+        user_id
+    )
+    .fetch_optional(pool)
+    .await?;
+
+    Ok(user.is_some_and(|user| user.admin))
+}
+
+/// Lets only platform admins through. Runs after [`crate::auth::auth`]
+pub async fn admin<B>(
+    State(AppState { pool, .. }): State<AppState>,
+    auth: Auth,
+    request: Request<B>,
+    next: Next<B>,
+) -> Result<Response<UnsyncBoxBody<Bytes, axum::Error>>, Response<Body>> {
+    let Some(user) = auth.current_user else {
+        return Ok(next.run(request).await);
+    };
+
+    match is_admin(user.id, &pool).await {
+        Ok(true) => Ok(next.run(request).await),
+        Ok(false) => Err(Response::builder()
+            .status(StatusCode::FORBIDDEN)
+            .body(Body::from(r#"{"message":"Only platform admins can do this"}"#))
+            .unwrap()),
+        Err(err) => {
+            tracing::error!(?err, "Can't check admin: Failed to query database");
+            Err(Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(r#"{"message":"Failed to query database"}"#))
+                .unwrap())
+        }
+    }
+}
+
+/// Session key of an [`Impersonation`]
+pub const IMPERSONATION_KEY: &str = "impersonation";
+
+/// An admin logged in as another user to see what they see
+#[derive(Serialize, Deserialize, Debug, Clone)]
+pub struct Impersonation {
+    pub admin_id: Uuid,
+    pub user_id: Uuid,
+}
+
+/// The impersonation of the session, if the user it is about is still the one logged in.
+/// Logging out and in again leaves a stale one behind that means nothing
+pub fn impersonation(auth: &Auth) -> Option<Impersonation> {
+    let impersonation = auth.session.get::<Impersonation>(IMPERSONATION_KEY)?;
+    let user = auth.current_user.as_ref()?;
+    (user.id == impersonation.user_id).then_some(impersonation)
+}
+
+/// Keeps impersonated sessions to reading, an admin debugging a user must not change their
+/// apps as them. Shells count as changes, going back to the admin is the way out
+pub async fn read_only_impersonation<B>(
+    auth: Auth,
+    request: Request<B>,
+    next: Next<B>,
+) -> Result<Response<UnsyncBoxBody<Bytes, axum::Error>>, Response<Body>> {
+    // a token acts as its own user, whatever the session is doing
+    if request.extensions().get::<TokenAccess>().is_some() || impersonation(&auth).is_none() {
+        return Ok(next.run(request).await);
+    }
+
+    let path = request.uri().path().trim_end_matches('/');
+    let reads = matches!(*request.method(), Method::GET | Method::HEAD | Method::OPTIONS);
+    let allowed = (reads && !path.ends_with("/ws")) || path == "/api/admin/impersonate/stop" || path == "/api/logout";
+    if !allowed {
+        return Err(Response::builder()
+            .status(StatusCode::FORBIDDEN)
+            .body(Body::from(r#"{"message":"Impersonated sessions are read-only, stop impersonating first"}"#))
+            .unwrap());
+    }
+
+    Ok(next.run(request).await)
+}
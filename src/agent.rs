@@ -0,0 +1,187 @@
+//! What `pemasak-agent` does on a node of [`crate::nodes`]. It reports the capacity of its host
+//! to the platform and lets the platform, and only it, reach the docker of the host. The
+//! platform runs the containers of the apps placed on the node through it and follows their
+//! logs back for `pmk logs` and the log drains, the agent itself knows nothing about apps.
+//! Docker may be podman on a node, see [`crate::runtime`]
+
+use std::collections::HashSet;
+use std::net::IpAddr;
+use std::thread::available_parallelism;
+use std::time::Duration;
+
+use anyhow::{anyhow, Result};
+use bollard::{Docker, API_DEFAULT_VERSION};
+use secrecy::ExposeSecret;
+use tokio::net::{TcpListener, TcpStream, UnixStream};
+
+use crate::arch;
+use crate::configuration::AgentSettings;
+use crate::nodes::Report;
+use crate::runtime::{socket_path, Runtime};
+
+const REPORT_TIMEOUT: Duration = Duration::from_secs(10);
+/// in seconds, like the local defaults of bollard
+const DOCKER_TIMEOUT: u64 = 120;
+
+/// Reports on every interval and forwards connections from the platform to docker until it
+/// is stopped
+pub async fn run(settings: AgentSettings) -> Result<()> {
+    // the platform dials the containers of a node directly, rootless docker keeps them in a
+    // network namespace nothing outside of the node reaches
+    if settings.runtime == Runtime::Rootless {
+        return Err(anyhow!("Rootless docker only runs apps on the host of the platform, run docker or podman"));
+    }
+    let socket = socket_path(settings.runtime, settings.socket.as_deref())?;
+    tokio::fs::metadata(&socket)
+        .await
+        .map_err(|err| anyhow!("Failed to access {} socket {socket}: {err}", settings.runtime.as_str()))?;
+
+    let allowed = allowed_addresses(&settings).await?;
+    let listener = TcpListener::bind(("0.0.0.0", settings.port)).await?;
+    tracing::info!(port = settings.port, ?allowed, runtime = settings.runtime.as_str(), "Exposing docker to the platform");
+
+    tokio::spawn(report(settings, socket.clone()));
+
+    loop {
+        let (stream, peer) = match listener.accept().await {
+            Ok(accepted) => accepted,
+            Err(err) => {
+                tracing::error!(?err, "Can't forward to docker: Failed to accept connection");
+                continue;
+            }
+        };
+
+        // docker answers to anyone who reaches it, so nobody but the platform may
+        if !allowed.contains(&peer.ip()) {
+            tracing::warn!(%peer, "Refused connection from outside the platform");
+            continue;
+        }
+
+        let socket = socket.clone();
+        tokio::spawn(async move {
+            if let Err(err) = forward(stream, &socket).await {
+                tracing::debug!(?err, %peer, "Docker connection ended");
+            }
+        });
+    }
+}
+
+/// Passes bytes both ways, so the attached terminals and followed logs of the platform work
+/// like on its own host
+async fn forward(mut stream: TcpStream, socket: &str) -> Result<()> {
+    let mut docker = UnixStream::connect(socket).await?;
+    tokio::io::copy_bidirectional(&mut stream, &mut docker).await?;
+    Ok(())
+}
+
+/// Where the url of the platform resolves to, and the addresses `allow` adds
+async fn allowed_addresses(settings: &AgentSettings) -> Result<HashSet<IpAddr>> {
+    let url = url::Url::parse(&settings.controlplane)?;
+    let host = url
+        .host_str()
+        .ok_or_else(|| anyhow!("controlplane {} has no host", settings.controlplane))?;
+    let port = url.port_or_known_default().unwrap_or(443);
+
+    let mut allowed = tokio::net::lookup_host((host, port))
+        .await?
+        .map(|addr| addr.ip())
+        .collect::<HashSet<_>>();
+    for address in settings.allow.iter().flat_map(|allow| allow.split(',')) {
+        let address = address.trim();
+        if address.is_empty() {
+            continue;
+        }
+        allowed.insert(address.parse().map_err(|err| anyhow!("Address {address} to allow is invalid: {err}"))?);
+    }
+
+    Ok(allowed)
+}
+
+async fn report(settings: AgentSettings, socket: String) {
+    let client = match reqwest::Client::builder().timeout(REPORT_TIMEOUT).build() {
+        Ok(client) => client,
+        Err(err) => {
+            tracing::error!(?err, "Can't report capacity: Failed to build http client");
+            return;
+        }
+    };
+    let docker = match Docker::connect_with_socket(&socket, DOCKER_TIMEOUT, API_DEFAULT_VERSION) {
+        Ok(docker) => docker,
+        Err(err) => {
+            tracing::error!(?err, "Can't report capacity: Failed to connect to docker");
+            return;
+        }
+    };
+
+    let url = format!("{}/api/nodes/report", settings.controlplane.trim_end_matches('/'));
+    let docker_url = format!("http://{}:{}", settings.address, settings.port);
+    let mut draining = false;
+
+    let mut interval = tokio::time::interval(Duration::from_secs(settings.interval));
+    loop {
+        interval.tick().await;
+
+        let report = match capacity(&docker, &docker_url, settings.runtime).await {
+            Ok(report) => report,
+            Err(err) => {
+                tracing::error!(?err, "Can't report capacity: Failed to read the host");
+                continue;
+            }
+        };
+
+        let res = client
+            .post(&url)
+            .bearer_auth(settings.token.expose_secret())
+            .json(&report)
+            .send()
+            .await;
+        match res {
+            Ok(res) if res.status().is_success() => {
+                let Ok(node) = res.json::<serde_json::Value>().await else {
+                    continue;
+                };
+                let now_draining = node["draining"].as_bool().unwrap_or(false);
+                if now_draining != draining {
+                    tracing::info!(node = ?node["name"], draining = now_draining, "Draining changed");
+                    draining = now_draining;
+                }
+            }
+            Ok(res) => tracing::error!(status = %res.status(), "Can't report capacity: The platform refused the report"),
+            Err(err) => tracing::error!(?err, "Can't report capacity: Failed to reach the platform"),
+        }
+    }
+}
+
+/// What is left of the host right now
+async fn capacity(docker: &Docker, docker_url: &str, runtime: Runtime) -> Result<Report> {
+    let meminfo = tokio::fs::read_to_string("/proc/meminfo").await?;
+    let mebibytes = |field: &str| {
+        meminfo
+            .lines()
+            .find_map(|line| line.strip_prefix(field))
+            .and_then(|value| value.trim().trim_end_matches("kB").trim().parse::<i64>().ok())
+            .map(|kib| (kib / 1024) as i32)
+            .ok_or_else(|| anyhow!("/proc/meminfo has no {field}"))
+    };
+
+    let loadavg = tokio::fs::read_to_string("/proc/loadavg").await?;
+    let load = loadavg
+        .split_whitespace()
+        .next()
+        .and_then(|load| load.parse::<f64>().ok())
+        .ok_or_else(|| anyhow!("/proc/loadavg is unreadable"))?;
+
+    let containers = docker.list_containers::<String>(None).await?.len() as i32;
+    let arch = docker.info().await?.architecture.map(|name| arch::normalize(&name));
+
+    Ok(Report {
+        docker_url: docker_url.to_string(),
+        cpus: available_parallelism().map(|cpus| cpus.get() as i32).unwrap_or(1),
+        memory: mebibytes("MemTotal:")?,
+        memory_available: mebibytes("MemAvailable:")?,
+        load,
+        containers,
+        runtime,
+        arch,
+    })
+}
@@ -0,0 +1,57 @@
+//! CPU architectures of the hosts apps run on. Builds stay on the host of the platform and
+//! target the architecture of the host their app runs on, buildkit runs the steps of other
+//! architectures under QEMU once `build.emulate` installed it, see
+//! [`crate::docker::setup_emulation`]. An image only runs on the architecture it was built
+//! for, so apps only move between hosts of the same one, see [`crate::nodes::drain`]
+
+use std::sync::RwLock;
+
+use lazy_static::lazy_static;
+
+lazy_static! {
+    /// Architectures builds emulate, the ones [`crate::docker::setup_emulation`] installed
+    static ref EMULATED: RwLock<Vec<String>> = RwLock::new(Vec::new());
+}
+
+/// Name of an architecture like docker platforms and kubernetes use it, out of the one of
+/// `uname -m` or docker info
+pub fn normalize(arch: &str) -> String {
+    match arch {
+        "x86_64" | "x86-64" | "amd64" => "amd64",
+        "aarch64" | "arm64" | "armv8" => "arm64",
+        "armv7l" | "armv7" | "armhf" => "arm",
+        other => other,
+    }
+    .to_string()
+}
+
+/// Architecture of the host of the platform, builds run on it without emulation
+pub fn host() -> String {
+    normalize(std::env::consts::ARCH)
+}
+
+/// Architectures the host of the platform can build for, apps are only placed on nodes of
+/// these
+pub fn buildable() -> Vec<String> {
+    let mut buildable = vec![host()];
+    buildable.extend(EMULATED.read().unwrap().iter().cloned());
+    buildable
+}
+
+pub fn set_emulated(emulated: Vec<String>) {
+    *EMULATED.write().unwrap() = emulated;
+}
+
+/// Platform docker build takes to build for `arch`
+pub fn platform(arch: &str) -> String {
+    format!("linux/{arch}")
+}
+
+/// A step of a foreign architecture fails with `exec format error` when QEMU isn't installed
+/// for it, buildkit reports it in plain progress
+pub fn emulation_note(build_log: &str, arch: &str) -> String {
+    match arch != host() && build_log.contains("exec format error") {
+        true => format!("Build failed: this host can't run {arch} binaries, add {arch} to build.emulate\n"),
+        false => String::new(),
+    }
+}
@@ -0,0 +1,336 @@
+use std::collections::HashMap;
+use std::net::SocketAddr;
+
+use anyhow::Result;
+use axum::{
+    extract::{ConnectInfo, MatchedPath, Path, State},
+    middleware::Next,
+    response::Response,
+};
+use bytes::Bytes;
+use chrono::{DateTime, Utc};
+use http_body::combinators::UnsyncBoxBody;
+use hyper::{Body, HeaderMap, Method, Request, StatusCode};
+use serde::{Deserialize, Serialize};
+use serde_json::Value;
+use sqlx::PgPool;
+use ulid::Ulid;
+use uuid::Uuid;
+
+use crate::auth::{tokens::TokenAccess, Auth};
+use crate::startup::AppState;
+
+/// Exports stop here, narrow the filter for more
+pub const MAX_ENTRIES: i64 = 10000;
+
+/// What a request changed, for handlers that know. Put in the extensions of the response,
+/// [`audit_trail`] stores it with the entry
+#[derive(Debug, Clone)]
+pub struct AuditChange {
+    pub before: Option<Value>,
+    pub after: Option<Value>,
+}
+
+impl AuditChange {
+    pub fn new(before: Option<Value>, after: Option<Value>) -> Self {
+        Self { before, after }
+    }
+}
+
+/// Secrets are logged as this, their values never leave the database
+pub const MASKED: &str = "********";
+
+/// A variable of an app as `{"KEY": "value"}` for a change, None when it isn't set
+pub fn environ_change(environs: &Value, secrets: &Value, key: &str) -> Option<Value> {
+    let value = match (environs.get(key), secrets.get(key)) {
+        (Some(value), _) => value.clone(),
+        (None, Some(_)) => Value::String(MASKED.to_string()),
+        (None, None) => return None,
+    };
+
+    Some(serde_json::json!({ key: value }))
+}
+
+/// Attaches what a handler changed to its response
+pub fn with_change<B>(mut response: hyper::Response<B>, change: AuditChange) -> hyper::Response<B> {
+    response.extensions_mut().insert(change);
+    response
+}
+
+/// An entry about to be stored
+#[derive(Debug, Clone)]
+pub struct NewAuditEntry {
+    pub actor_id: Option<Uuid>,
+    /// username, or what acted when it wasn't a user
+    pub actor: String,
+    pub token_id: Option<Uuid>,
+    pub owner: Option<String>,
+    pub project: Option<String>,
+    pub action: String,
+    pub method: String,
+    pub path: String,
+    pub status: i32,
+    pub ip: Option<String>,
+    pub change: Option<AuditChange>,
+}
+
+#[derive(Serialize, Debug)]
+pub struct AuditEntry {
+    pub id: Uuid,
+    pub actor: String,
+    pub token_id: Option<Uuid>,
+    pub owner: Option<String>,
+    pub project: Option<String>,
+    pub action: String,
+    pub method: String,
+    pub path: String,
+    pub status: i32,
+    pub ip: Option<String>,
+    pub before: Option<Value>,
+    pub after: Option<Value>,
+    pub created_at: DateTime<Utc>,
+}
+
+/// Narrows down the entries that are read. Entries come newest first
+#[derive(Deserialize, Debug, Default)]
+pub struct AuditFilter {
+    #[serde(skip)]
+    pub project_id: Option<Uuid>,
+    pub owner: Option<String>,
+    /// username of who acted
+    pub actor: Option<String>,
+    /// prefix of the action, `env` matches `env` and `env.delete`
+    pub action: Option<String>,
+    pub since: Option<DateTime<Utc>>,
+    pub until: Option<DateTime<Utc>>,
+    pub limit: Option<i64>,
+    /// `json` (the default) or `csv`
+    pub format: Option<String>,
+}
+
+/// Stores an entry. The project is looked up by name, entries outlive the projects they're about
+pub async fn record(entry: NewAuditEntry, pool: &PgPool) -> Result<()> {
+    let (before, after) = match entry.change {
+        Some(change) => (change.before, change.after),
+        None => (None, None),
+    };
+
+    sqlx::query!(
+        r#"INSERT INTO audit_log
+           (id, actor_id, actor, token_id, owner, project_id, project, action, method, path, status, ip, before, after)
+           VALUES ($1, $2, COALESCE((SELECT username FROM users WHERE id = $2), $3), $4, $5,
+             (SELECT projects.id FROM projects
+              JOIN project_owners ON projects.owner_id = project_owners.id
+              WHERE project_owners.name = $5 AND projects.name = $6),
+             $6, $7, $8, $9, $10, $11, $12, $13)
+        "#,
+        Uuid::from(Ulid::new()),
+        entry.actor_id,
+        entry.actor,
+        entry.token_id,
+        entry.owner,
+        entry.project,
+        entry.action,
+        entry.method,
+        entry.path,
+        entry.status,
+        entry.ip,
+        before,
+        after
+    )
+    .execute(pool)
+    .await?;
+
+    Ok(())
+}
+
+pub async fn entries(filter: &AuditFilter, pool: &PgPool) -> Result<Vec<AuditEntry>> {
+    let limit = filter.limit.unwrap_or(100).clamp(1, MAX_ENTRIES);
+    let action = filter.action.as_ref().map(|action| format!("{action}%"));
+
+    let entries = sqlx::query!(
+        r#"SELECT id, actor, token_id, owner, project, action, method, path, status, ip, before, after, created_at
+           FROM audit_log
+           WHERE ($1::uuid IS NULL OR project_id = $1)
+           AND ($2::text IS NULL OR owner = $2)
+           AND ($3::text IS NULL OR actor = $3)
+           AND ($4::text IS NULL OR action LIKE $4)
+           AND ($5::timestamptz IS NULL OR created_at >= $5)
+           AND ($6::timestamptz IS NULL OR created_at < $6)
+           ORDER BY created_at DESC
+           LIMIT $7
+        "#,
+        filter.project_id,
+        filter.owner,
+        filter.actor,
+        action,
+        filter.since,
+        filter.until,
+        limit
+    )
+    .fetch_all(pool)
+    .await?;
+
+    Ok(entries
+        .into_iter()
+        .map(|entry| AuditEntry {
+            id: entry.id,
+            actor: entry.actor,
+            token_id: entry.token_id,
+            owner: entry.owner,
+            project: entry.project,
+            action: entry.action,
+            method: entry.method,
+            path: entry.path,
+            status: entry.status,
+            ip: entry.ip,
+            before: entry.before,
+            after: entry.after,
+            created_at: entry.created_at,
+        })
+        .collect())
+}
+
+pub fn csv_field(field: &str) -> String {
+    if field.contains([',', '"', '\n', '\r']) {
+        format!("\"{}\"", field.replace('"', "\"\""))
+    } else {
+        field.to_string()
+    }
+}
+
+/// Entries as csv with a header row, before and after are json
+pub fn to_csv(entries: &[AuditEntry]) -> String {
+    let mut csv = String::from("id,created_at,actor,token_id,owner,project,action,method,path,status,ip,before,after\n");
+
+    for entry in entries {
+        let json = |value: &Option<Value>| value.as_ref().map(Value::to_string).unwrap_or_default();
+        let fields = [
+            entry.id.to_string(),
+            entry.created_at.to_rfc3339(),
+            entry.actor.clone(),
+            entry.token_id.map(|id| id.to_string()).unwrap_or_default(),
+            entry.owner.clone().unwrap_or_default(),
+            entry.project.clone().unwrap_or_default(),
+            entry.action.clone(),
+            entry.method.clone(),
+            entry.path.clone(),
+            entry.status.to_string(),
+            entry.ip.clone().unwrap_or_default(),
+            json(&entry.before),
+            json(&entry.after),
+        ];
+
+        csv.push_str(&fields.iter().map(|field| csv_field(field)).collect::<Vec<_>>().join(","));
+        csv.push('\n');
+    }
+
+    csv
+}
+
+#[derive(Serialize, Debug)]
+struct AuditLogResponse<'a> {
+    data: &'a [AuditEntry],
+}
+
+/// Entries as `{"data": [...]}`, or as a csv download for `format=csv`
+pub fn export(entries: &[AuditEntry], format: Option<&str>, filename: &str) -> hyper::Response<Body> {
+    match format {
+        Some("csv") => hyper::Response::builder()
+            .status(StatusCode::OK)
+            .header("Content-Type", "text/csv; charset=utf-8")
+            .header("Content-Disposition", format!("attachment; filename=\"{filename}.csv\""))
+            .body(Body::from(to_csv(entries)))
+            .unwrap(),
+        _ => hyper::Response::builder()
+            .status(StatusCode::OK)
+            .header("Content-Type", "application/json")
+            .body(Body::from(serde_json::to_string(&AuditLogResponse { data: entries }).unwrap()))
+            .unwrap(),
+    }
+}
+
+/// Names a route for the log: `/api/project/:owner/:project/env/delete` is `env.delete`,
+/// `/api/tokens/:token_id/revoke` is `tokens.revoke`
+fn action(route: &str) -> String {
+    let route = route.trim_start_matches("/api/").trim_start_matches('/');
+    let route = route
+        .strip_prefix("project/:owner/:project")
+        .or(route.strip_prefix("owner/:owner_id"))
+        .or(route.strip_prefix("owner/:owner"))
+        .unwrap_or(route);
+
+    let action = route
+        .split('/')
+        .filter(|part| !part.is_empty() && !part.starts_with(':'))
+        .collect::<Vec<_>>()
+        .join(".");
+
+    match action.is_empty() {
+        true => "update".to_string(),
+        false => action,
+    }
+}
+
+/// Where a request came from. Proxies on the same host, like the one terminating tls, are
+/// trusted to say who they forward for
+pub fn client_ip(addr: &SocketAddr, headers: &HeaderMap) -> String {
+    let forwarded = headers
+        .get("X-Forwarded-For")
+        .and_then(|value| value.to_str().ok())
+        .and_then(|value| value.split(',').next())
+        .map(|value| value.trim().to_string())
+        .filter(|value| !value.is_empty());
+
+    match forwarded {
+        Some(forwarded) if addr.ip().is_loopback() => forwarded,
+        _ => addr.ip().to_string(),
+    }
+}
+
+/// Records every request of a logged in user that changes something, with what the handler
+/// says it changed. Shells count as changes. Runs after [`crate::auth::auth`], so requests
+/// without a user are left out
+pub async fn audit_trail<B>(
+    State(AppState { pool, .. }): State<AppState>,
+    ConnectInfo(addr): ConnectInfo<SocketAddr>,
+    auth: Auth,
+    matched: Option<MatchedPath>,
+    path: Option<Path<HashMap<String, String>>>,
+    request: Request<B>,
+    next: Next<B>,
+) -> Response<UnsyncBoxBody<Bytes, axum::Error>> {
+    let route = matched.map(|matched| matched.as_str().to_string()).unwrap_or_default();
+    let changes = !matches!(*request.method(), Method::GET | Method::HEAD | Method::OPTIONS)
+        || route.ends_with("/ws");
+    let Some(user) = auth.current_user.filter(|_| changes) else {
+        return next.run(request).await;
+    };
+
+    let params = path.map(|Path(params)| params).unwrap_or_default();
+    let token_id = request.extensions().get::<TokenAccess>().map(|access| access.token_id);
+    let method = request.method().to_string();
+    let uri_path = request.uri().path().to_string();
+    let ip = client_ip(&addr, request.headers());
+
+    let response = next.run(request).await;
+
+    let entry = NewAuditEntry {
+        actor_id: Some(user.id),
+        actor: user.username,
+        token_id,
+        owner: params.get("owner").cloned(),
+        project: params.get("project").map(|project| project.trim_end_matches(".git").to_string()),
+        action: action(&route),
+        method,
+        path: uri_path,
+        status: response.status().as_u16() as i32,
+        ip: Some(ip),
+        change: response.extensions().get::<AuditChange>().cloned(),
+    };
+    if let Err(err) = record(entry, &pool).await {
+        tracing::error!(?err, "Can't record audit entry: Failed to insert into database");
+    }
+
+    response
+}
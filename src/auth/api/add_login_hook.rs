@@ -0,0 +1,149 @@
+use axum::extract::State;
+use axum::response::Response;
+use axum::Json;
+use garde::{Unvalidated, Validate};
+use hyper::{Body, StatusCode};
+use serde::{Deserialize, Serialize};
+use ulid::Ulid;
+use uuid::Uuid;
+
+use super::view_login_hooks::LoginHook;
+use crate::linked_repos::webhook_secret;
+use crate::notifications::{redact, KINDS};
+use crate::{auth::Auth, startup::AppState};
+
+/// one login can't fan out to an unbounded number of requests
+const MAX_HOOKS: i64 = 3;
+
+#[derive(Deserialize, Validate, Debug)]
+pub struct AddLoginHookRequest {
+    /// webhook, slack or discord
+    #[garde(custom(kind_check))]
+    pub kind: String,
+    /// the incoming webhook url for slack and discord
+    #[garde(length(max = 2048), pattern("^https?://[^\\s]+$"))]
+    pub url: String,
+}
+
+#[derive(Serialize, Debug)]
+struct ErrorResponse {
+    message: String,
+}
+
+fn kind_check(value: &str, _ctx: &()) -> garde::Result {
+    match KINDS.contains(&value) {
+        true => Ok(()),
+        false => Err(garde::Error::new(format!("kind must be one of {}", KINDS.join(", ")))),
+    }
+}
+
+/// Adds a hook that is told when the user logs in from a device they haven't logged in from before
+#[tracing::instrument(skip(auth, pool, req))]
+pub async fn post(
+    auth: Auth,
+    State(AppState { pool, .. }): State<AppState>,
+    Json(req): Json<Unvalidated<AddLoginHookRequest>>
+) -> Response<Body> {
+    let user = auth.current_user.unwrap();
+
+    let AddLoginHookRequest { kind, url } = match req.validate(&()) {
+        Ok(valid) => valid.into_inner(),
+        Err(err) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: err.to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    match sqlx::query!(
+        r#"SELECT COUNT(*) AS "count!" FROM login_hooks WHERE user_id = $1"#,
+        user.id
+    )
+    .fetch_one(&pool)
+    .await
+    {
+        Ok(record) if record.count >= MAX_HOOKS => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("A user can have at most {MAX_HOOKS} login hooks")
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Ok(_) => {}
+        Err(err) => {
+            tracing::error!(?err, "Can't get login hooks: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    }
+
+    let id = Uuid::from(Ulid::new());
+    let secret = webhook_secret();
+    let created_at = match sqlx::query!(
+        r#"INSERT INTO login_hooks (id, user_id, kind, url, secret)
+           VALUES ($1, $2, $3, $4, $5)
+           ON CONFLICT (user_id, url) DO NOTHING
+           RETURNING created_at
+        "#,
+        id,
+        user.id,
+        kind,
+        url,
+        secret
+    )
+    .fetch_optional(&pool)
+    .await
+    {
+        Ok(Some(record)) => record.created_at,
+        Ok(None) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Login hook already exists".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Err(err) => {
+            tracing::error!(?err, "Can't add login hook: Failed to insert into database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Failed to insert into database".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    let json = serde_json::to_string(&LoginHook {
+        id,
+        url: redact(&kind, &url),
+        secret: (kind == "webhook").then_some(secret),
+        kind,
+        created_at,
+    }).unwrap();
+
+    Response::builder()
+        .status(StatusCode::CREATED)
+        .body(Body::from(json))
+        .unwrap()
+}
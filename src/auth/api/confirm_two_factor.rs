@@ -0,0 +1,141 @@
+use axum::extract::State;
+use axum::response::Response;
+use axum::Json;
+use chrono::Utc;
+use hyper::{Body, StatusCode};
+use secrecy::{ExposeSecret, Secret};
+use serde::{Deserialize, Serialize};
+
+use crate::auth::two_factor::{generate_recovery_codes, verify};
+use crate::{auth::Auth, startup::AppState};
+
+#[derive(Deserialize)]
+pub struct ConfirmTwoFactorRequest {
+    /// the code the authenticator app shows right now
+    pub code: Secret<String>,
+}
+
+#[derive(Serialize, Debug)]
+struct ConfirmTwoFactorResponse {
+    /// each logs in once instead of a code, they are only shown now
+    recovery_codes: Vec<String>,
+}
+
+#[derive(Serialize, Debug)]
+struct ErrorResponse {
+    message: String,
+}
+
+/// Turns on two-factor authentication once the authenticator app shows the right code, so
+/// a secret that didn't make it into the app can't lock the user out
+#[tracing::instrument(skip(auth, pool, secrets, req))]
+pub async fn post(
+    auth: Auth,
+    State(AppState { pool, secrets, .. }): State<AppState>,
+    Json(req): Json<ConfirmTwoFactorRequest>,
+) -> Response<Body> {
+    let user = auth.current_user.unwrap();
+
+    let totp = match sqlx::query!(
+        "SELECT secret FROM user_totp WHERE user_id = $1 AND confirmed_at IS NULL",
+        user.id
+    )
+    .fetch_optional(&pool)
+    .await
+    {
+        Ok(Some(totp)) => totp,
+        Ok(None) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Two-factor authentication is already on or wasn't started, start it with POST /api/2fa".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Err(err) => {
+            tracing::error!(?err, "Can't confirm two-factor authentication: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    let secret = match secrets.decrypt(&totp.secret) {
+        Ok(secret) => secret,
+        Err(err) => {
+            tracing::error!(?err, "Can't confirm two-factor authentication: Failed to decrypt secret");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Failed to decrypt secret".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    let Some(step) = verify(&secret, req.code.expose_secret(), Utc::now()) else {
+        let json = serde_json::to_string(&ErrorResponse {
+            message: "Wrong code, check the clock of the device with the authenticator app".to_string()
+        }).unwrap();
+
+        return Response::builder()
+            .status(StatusCode::BAD_REQUEST)
+            .body(Body::from(json))
+            .unwrap();
+    };
+
+    let (recovery_codes, hashes) = generate_recovery_codes();
+    match sqlx::query!(
+        r#"UPDATE user_totp SET confirmed_at = now(), last_step = $2, recovery_codes = $3, failures = 0
+           WHERE user_id = $1 AND confirmed_at IS NULL
+        "#,
+        user.id,
+        step,
+        &hashes
+    )
+    .execute(&pool)
+    .await
+    {
+        Ok(confirmed) if confirmed.rows_affected() == 0 => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Two-factor authentication is already on".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::CONFLICT)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Ok(_) => {}
+        Err(err) => {
+            tracing::error!(?err, "Can't confirm two-factor authentication: Failed to update database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Failed to update database".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    }
+
+    let json = serde_json::to_string(&ConfirmTwoFactorResponse { recovery_codes }).unwrap();
+
+    Response::builder()
+        .status(StatusCode::OK)
+        .body(Body::from(json))
+        .unwrap()
+}
@@ -0,0 +1,204 @@
+use axum::extract::State;
+use axum::response::Response;
+use axum::Json;
+use chrono::{DateTime, Duration, Utc};
+use garde::{Unvalidated, Validate};
+use hyper::{Body, StatusCode};
+use serde::{Deserialize, Serialize};
+use ulid::Ulid;
+use uuid::Uuid;
+
+use crate::audit::{with_change, AuditChange};
+use crate::auth::tokens::{generate_token, TokenScope};
+use crate::owner::member_role;
+use crate::{auth::Auth, startup::AppState};
+
+/// How long a token works when the request doesn't say
+const DEFAULT_LIFESPAN_DAYS: i64 = 90;
+
+#[derive(Deserialize, Validate, Debug)]
+pub struct CreateTokenRequest {
+    #[garde(length(min = 1, max = 255))]
+    pub name: String,
+    #[garde(skip)]
+    pub scope: TokenScope,
+    /// owner of the only app the token works for, together with `project`
+    #[garde(length(min = 1, max = 255))]
+    pub owner: Option<String>,
+    #[garde(length(min = 1, max = 255))]
+    pub project: Option<String>,
+    /// 0 for a token that never expires
+    #[garde(range(min = 0, max = 365))]
+    pub expires_in_days: Option<i64>,
+}
+
+#[derive(Serialize, Debug)]
+struct CreateTokenResponse {
+    id: Uuid,
+    name: String,
+    scope: TokenScope,
+    app: Option<String>,
+    /// the token itself, it can't be shown again
+    token: String,
+    expires_at: Option<DateTime<Utc>>,
+    created_at: DateTime<Utc>,
+}
+
+#[derive(Serialize, Debug)]
+struct ErrorResponse {
+    message: String,
+}
+
+#[tracing::instrument(skip(auth, pool))]
+pub async fn post(
+    auth: Auth,
+    State(AppState { pool, .. }): State<AppState>,
+    Json(req): Json<Unvalidated<CreateTokenRequest>>,
+) -> Response<Body> {
+    let user = auth.current_user.unwrap();
+
+    let CreateTokenRequest { name, scope, owner, project, expires_in_days } = match req.validate(&()) {
+        Ok(valid) => valid.into_inner(),
+        Err(err) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: err.to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    let app = match (owner, project) {
+        (Some(owner), Some(project)) => Some((owner, project.trim_end_matches(".git").to_string())),
+        (None, None) => None,
+        _ => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "A token for one app needs both its owner and project".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    // the token is checked against the role of the user on every request, membership is
+    // only checked here so nobody makes tokens for apps they can't see
+    let project_id = match &app {
+        Some((owner, project)) => {
+            let project = match member_role(user.id, owner, &pool).await {
+                Ok(Some(_)) => sqlx::query!(
+                    r#"SELECT projects.id FROM projects
+                       JOIN project_owners ON projects.owner_id = project_owners.id
+                       WHERE project_owners.name = $1 AND projects.name = $2
+                    "#,
+                    owner,
+                    project
+                )
+                .fetch_optional(&pool)
+                .await
+                .map(|project| project.map(|project| project.id)),
+                Ok(None) => Ok(None),
+                Err(err) => Err(err),
+            };
+
+            match project {
+                Ok(Some(project_id)) => Some(project_id),
+                Ok(None) => {
+                    let json = serde_json::to_string(&ErrorResponse {
+                        message: "Project does not exist".to_string()
+                    }).unwrap();
+
+                    return Response::builder()
+                        .status(StatusCode::BAD_REQUEST)
+                        .body(Body::from(json))
+                        .unwrap();
+                }
+                Err(err) => {
+                    tracing::error!(?err, "Can't create token: Failed to query database");
+
+                    let json = serde_json::to_string(&ErrorResponse {
+                        message: format!("Failed to query database: {}", err.to_string())
+                    }).unwrap();
+
+                    return Response::builder()
+                        .status(StatusCode::INTERNAL_SERVER_ERROR)
+                        .body(Body::from(json))
+                        .unwrap();
+                }
+            }
+        }
+        None => None,
+    };
+
+    let expires_at = match expires_in_days.unwrap_or(DEFAULT_LIFESPAN_DAYS) {
+        0 => None,
+        days => Some(Utc::now() + Duration::days(days)),
+    };
+
+    let (token, hash) = generate_token();
+    let prefix = token.chars().take(12).collect::<String>();
+
+    let created = match sqlx::query!(
+        r#"INSERT INTO access_tokens (id, user_id, project_id, name, scope, token_hash, prefix, expires_at)
+           VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+           RETURNING id, created_at
+        "#,
+        Uuid::from(Ulid::new()),
+        user.id,
+        project_id,
+        name,
+        scope as TokenScope,
+        hash,
+        prefix,
+        expires_at
+    )
+    .fetch_one(&pool)
+    .await
+    {
+        Ok(created) => created,
+        Err(err) => {
+            tracing::error!(?err, "Can't create token: Failed to insert into database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Failed to insert into database".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    let app = app.map(|(owner, project)| format!("{owner}/{project}"));
+    // the token itself stays out of the log
+    let change = AuditChange::new(None, Some(serde_json::json!({
+        "name": name,
+        "scope": scope,
+        "app": app,
+        "expires_at": expires_at,
+    })));
+
+    let json = serde_json::to_string(&CreateTokenResponse {
+        id: created.id,
+        name,
+        scope,
+        app,
+        token,
+        expires_at,
+        created_at: created.created_at,
+    }).unwrap();
+
+    with_change(
+        Response::builder()
+            .status(StatusCode::CREATED)
+            .body(Body::from(json))
+            .unwrap(),
+        change,
+    )
+}
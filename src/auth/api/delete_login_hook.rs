@@ -0,0 +1,59 @@
+use axum::extract::{Path, State};
+use axum::response::Response;
+use hyper::{Body, StatusCode};
+use serde::Serialize;
+use uuid::Uuid;
+
+use crate::{auth::Auth, startup::AppState};
+
+#[derive(Serialize, Debug)]
+struct ErrorResponse {
+    message: String,
+}
+
+#[tracing::instrument(skip(auth, pool))]
+pub async fn post(
+    auth: Auth,
+    State(AppState { pool, .. }): State<AppState>,
+    Path(hook_id): Path<Uuid>,
+) -> Response<Body> {
+    let user = auth.current_user.unwrap();
+
+    match sqlx::query!(
+        "DELETE FROM login_hooks WHERE id = $1 AND user_id = $2",
+        hook_id,
+        user.id
+    )
+    .execute(&pool)
+    .await
+    {
+        Ok(deleted) if deleted.rows_affected() == 0 => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Login hook does not exist".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Ok(_) => {}
+        Err(err) => {
+            tracing::error!(?err, "Can't delete login hook: Failed to delete from database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Failed to delete from database".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    Response::builder()
+        .status(StatusCode::NO_CONTENT)
+        .body(Body::empty())
+        .unwrap()
+}
@@ -0,0 +1,113 @@
+use axum::extract::State;
+use axum::response::Response;
+use axum::Json;
+use hyper::{Body, StatusCode};
+use secrecy::{ExposeSecret, Secret};
+use serde::{Deserialize, Serialize};
+
+use crate::auth::two_factor::{self, CodeCheck};
+use crate::{auth::Auth, startup::AppState};
+
+#[derive(Deserialize)]
+pub struct DisableTwoFactorRequest {
+    /// a code of the authenticator app or a recovery code
+    pub code: Secret<String>,
+}
+
+#[derive(Serialize, Debug)]
+struct ErrorResponse {
+    message: String,
+}
+
+/// Turns off two-factor authentication. It takes a code, a stolen session alone can't do it
+#[tracing::instrument(skip(auth, pool, secrets, req))]
+pub async fn post(
+    auth: Auth,
+    State(AppState { pool, secrets, .. }): State<AppState>,
+    Json(req): Json<DisableTwoFactorRequest>,
+) -> Response<Body> {
+    let user = auth.current_user.unwrap();
+
+    match two_factor::enabled(user.id, &pool).await {
+        Ok(true) => {}
+        Ok(false) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Two-factor authentication is not on".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Err(err) => {
+            tracing::error!(?err, "Can't disable two-factor authentication: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    }
+
+    match two_factor::check_code(user.id, req.code.expose_secret(), &secrets, &pool).await {
+        Ok(CodeCheck::Accepted | CodeCheck::RecoveryCode(_)) => {}
+        Ok(CodeCheck::Wrong) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Wrong code, or one that was already used".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Ok(CodeCheck::Locked(_)) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Too many wrong codes, try again later".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::TOO_MANY_REQUESTS)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Err(err) => {
+            tracing::error!(?err, "Can't disable two-factor authentication: Failed to check code");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Failed to check code".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    }
+
+    if let Err(err) = sqlx::query!("DELETE FROM user_totp WHERE user_id = $1", user.id)
+        .execute(&pool)
+        .await
+    {
+        tracing::error!(?err, "Can't disable two-factor authentication: Failed to delete from database");
+
+        let json = serde_json::to_string(&ErrorResponse {
+            message: "Failed to delete from database".to_string()
+        }).unwrap();
+
+        return Response::builder()
+            .status(StatusCode::INTERNAL_SERVER_ERROR)
+            .body(Body::from(json))
+            .unwrap();
+    }
+
+    Response::builder()
+        .status(StatusCode::NO_CONTENT)
+        .body(Body::empty())
+        .unwrap()
+}
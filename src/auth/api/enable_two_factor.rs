@@ -0,0 +1,105 @@
+use axum::extract::State;
+use axum::response::Response;
+use hyper::{Body, StatusCode};
+use serde::Serialize;
+
+use crate::auth::two_factor::{generate_secret, otpauth_url};
+use crate::{auth::Auth, startup::AppState};
+
+#[derive(Serialize, Debug)]
+struct EnableTwoFactorResponse {
+    /// base32, for apps that take the secret typed in
+    secret: String,
+    /// for a qr code
+    otpauth_url: String,
+}
+
+#[derive(Serialize, Debug)]
+struct ErrorResponse {
+    message: String,
+}
+
+/// Starts setting up two-factor authentication with a new secret for an authenticator app.
+/// Logins only ask for codes once one is confirmed, starting over replaces an unconfirmed secret
+#[tracing::instrument(skip(auth, pool, secrets, domain))]
+pub async fn post(
+    auth: Auth,
+    State(AppState { pool, secrets, domain, .. }): State<AppState>,
+) -> Response<Body> {
+    let user = auth.current_user.unwrap();
+
+    // the secret is as good as a password, it isn't stored in plain text
+    if !secrets.enabled() {
+        let json = serde_json::to_string(&ErrorResponse {
+            message: "Two-factor authentication needs a secret key, ask the admins of the platform to set one".to_string()
+        }).unwrap();
+
+        return Response::builder()
+            .status(StatusCode::BAD_REQUEST)
+            .body(Body::from(json))
+            .unwrap();
+    }
+
+    let secret = generate_secret();
+    let encrypted = match secrets.encrypt(&secret) {
+        Ok(encrypted) => encrypted,
+        Err(err) => {
+            tracing::error!(?err, "Can't enable two-factor authentication: Failed to encrypt secret");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Failed to encrypt secret".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    match sqlx::query!(
+        r#"INSERT INTO user_totp (user_id, secret) VALUES ($1, $2)
+           ON CONFLICT (user_id) DO UPDATE SET secret = $2, last_step = 0, failures = 0, created_at = now()
+           WHERE user_totp.confirmed_at IS NULL
+        "#,
+        user.id,
+        encrypted
+    )
+    .execute(&pool)
+    .await
+    {
+        Ok(set) if set.rows_affected() == 0 => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Two-factor authentication is already on, turn it off first to use another app".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::CONFLICT)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Ok(_) => {}
+        Err(err) => {
+            tracing::error!(?err, "Can't enable two-factor authentication: Failed to insert into database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Failed to insert into database".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    }
+
+    let json = serde_json::to_string(&EnableTwoFactorResponse {
+        otpauth_url: otpauth_url(&domain, &user.username, &secret),
+        secret,
+    }).unwrap();
+
+    Response::builder()
+        .status(StatusCode::CREATED)
+        .body(Body::from(json))
+        .unwrap()
+}
@@ -1,24 +1,43 @@
+use std::net::SocketAddr;
+
 use argon2::{Argon2, PasswordHash, PasswordVerifier};
 use axum::{
-    extract::State, response::Response, Json
+    extract::{ConnectInfo, State}, response::Response, Json
 };
-use hyper::{Body, StatusCode};
+use hyper::{Body, HeaderMap, StatusCode};
 use secrecy::ExposeSecret;
 use serde::Deserialize;
+use crate::auth::sessions::{self, Device};
+use crate::auth::two_factor::{self, CodeCheck};
 use crate::{startup::AppState, auth::{Auth, User, RegisterUserErrorType, ErrorResponse, Secret}};
 
 #[derive(Deserialize)]
 pub struct LoginRequest {
     pub username: String,
     pub password: Secret<String>,
+    /// code of the authenticator app or a recovery code, once two-factor authentication is on
+    pub code: Option<Secret<String>>,
 }
 
-#[tracing::instrument(skip(auth, pool, password))]
+#[tracing::instrument(skip(auth, pool, oidc, secrets, notifier, headers, password, code))]
 pub async fn login_user(
     auth: Auth,
-    State(AppState { pool, .. }): State<AppState>,
-    Json(LoginRequest { username, password }): Json<LoginRequest>,
+    State(AppState { pool, oidc, secrets, notifier, .. }): State<AppState>,
+    ConnectInfo(addr): ConnectInfo<SocketAddr>,
+    headers: HeaderMap,
+    Json(LoginRequest { username, password, code }): Json<LoginRequest>,
 ) -> Response<Body> {
+    if oidc.is_some_and(|oidc| !oidc.passwords()) {
+        let json = serde_json::to_string(&ErrorResponse {
+            message: "Passwords are disabled, log in with SSO".to_string(),
+            error_type: RegisterUserErrorType::SSOError,
+        }).unwrap();
+        return Response::builder()
+            .status(StatusCode::BAD_REQUEST)
+            .body(Body::from(json))
+            .unwrap();
+    }
+
     // get user
     let user = match User::get_from_username(&username, &pool).await {
         Ok(user) => user,
@@ -50,7 +69,77 @@ pub async fn login_user(
             .unwrap();
     };
 
+    // the password was right, so saying a code is needed tells nothing new
+    match two_factor::enabled(user.id, &pool).await {
+        Ok(false) => {}
+        Ok(true) => {
+            let Some(code) = code else {
+                let json = serde_json::to_string(&ErrorResponse {
+                    message: "Enter the code of your authenticator app or a recovery code".to_string(),
+                    error_type: RegisterUserErrorType::TwoFactorRequired,
+                }).unwrap();
+                return Response::builder()
+                    .status(StatusCode::UNAUTHORIZED)
+                    .body(Body::from(json))
+                    .unwrap();
+            };
+
+            match two_factor::check_code(user.id, code.expose_secret(), &secrets, &pool).await {
+                Ok(CodeCheck::Accepted) => {}
+                Ok(CodeCheck::RecoveryCode(left)) => {
+                    tracing::info!(left, "Recovery code used to log in");
+                }
+                Ok(CodeCheck::Wrong) => {
+                    let json = serde_json::to_string(&ErrorResponse {
+                        message: "Wrong code, or one that was already used".to_string(),
+                        error_type: RegisterUserErrorType::TwoFactorRequired,
+                    }).unwrap();
+                    return Response::builder()
+                        .status(StatusCode::UNAUTHORIZED)
+                        .body(Body::from(json))
+                        .unwrap();
+                }
+                Ok(CodeCheck::Locked(locked_until)) => {
+                    let minutes = (locked_until - chrono::Utc::now()).num_minutes() + 1;
+                    let json = serde_json::to_string(&ErrorResponse {
+                        message: format!("Too many wrong codes, try again in {minutes} minutes"),
+                        error_type: RegisterUserErrorType::TwoFactorRequired,
+                    }).unwrap();
+                    return Response::builder()
+                        .status(StatusCode::TOO_MANY_REQUESTS)
+                        .body(Body::from(json))
+                        .unwrap();
+                }
+                Err(err) => {
+                    tracing::error!(?err, "Can't login: Failed to check code");
+                    let json = serde_json::to_string(&ErrorResponse {
+                        message: "Failed to check code".to_string(),
+                        error_type: RegisterUserErrorType::InternalServerError,
+                    }).unwrap();
+                    return Response::builder()
+                        .status(StatusCode::INTERNAL_SERVER_ERROR)
+                        .body(Body::from(json))
+                        .unwrap();
+                }
+            }
+        }
+        Err(err) => {
+            tracing::error!(?err, "Can't login: Failed to query database");
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Failed to query database".to_string(),
+                error_type: RegisterUserErrorType::InternalServerError,
+            }).unwrap();
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    }
+
     auth.login_user(user.id);
+    if let Err(err) = sessions::start(&auth, user.id, &user.username, Device::new(&addr, &headers), &notifier, &pool).await {
+        tracing::error!(?err, "Can't record session: Failed to query database");
+    }
     Response::builder()
         .status(StatusCode::FOUND)
         .header("HX-Location", "/api/dashboard")
@@ -1,13 +1,23 @@
+use axum::extract::State;
 use axum::response::Response;
 use hyper::{Body, StatusCode};
-use crate::auth::Auth;
+use crate::admin::IMPERSONATION_KEY;
+use crate::auth::{sessions, Auth};
+use crate::startup::AppState;
 
-#[tracing::instrument(skip(auth))]
-pub async fn logout_user(auth: Auth) -> Response<Body> {
+#[tracing::instrument(skip(auth, pool))]
+pub async fn logout_user(
+    auth: Auth,
+    State(AppState { pool, .. }): State<AppState>,
+) -> Response<Body> {
+    if let Err(err) = sessions::end(&auth, &pool).await {
+        tracing::error!(?err, "Can't end session: Failed to update database");
+    }
+    auth.session.remove(IMPERSONATION_KEY);
     auth.logout_user();
     Response::builder()
         .status(StatusCode::FOUND)
         .header("Location", "/api/login")
         .body(Body::empty())
         .unwrap()
-}
\ No newline at end of file
+}
@@ -1,16 +1,44 @@
-use axum::{routing::{get, post}, Router};
+use axum::{middleware, routing::{get, post}, Router};
 use axum_extra::routing::RouterExt;
 use hyper::Body;
 
-use crate::{configuration::Settings, startup::AppState};
+use crate::{audit::audit_trail, auth::auth, configuration::Settings, startup::AppState};
 
 mod validate;
 mod login;
 mod logout;
 mod register;
+mod view_tokens;
+mod create_token;
+mod revoke_token;
+mod view_quotas;
+mod oidc_info;
+mod oidc_login;
+mod oidc_callback;
+mod view_two_factor;
+mod enable_two_factor;
+mod confirm_two_factor;
+mod disable_two_factor;
+mod view_sessions;
+mod revoke_session;
+mod view_login_hooks;
+mod add_login_hook;
+mod delete_login_hook;
 
-pub async fn router(_state: AppState, _config: &Settings) -> Router<AppState, Body> {
+pub async fn router(state: AppState, _config: &Settings) -> Router<AppState, Body> {
     Router::new()
+        .route_with_tsr("/api/tokens", get(view_tokens::get).post(create_token::post))
+        .route_with_tsr("/api/tokens/:token_id/revoke", post(revoke_token::post))
+        .route_with_tsr("/api/quotas", get(view_quotas::get))
+        .route_with_tsr("/api/2fa", get(view_two_factor::get).post(enable_two_factor::post))
+        .route_with_tsr("/api/2fa/confirm", post(confirm_two_factor::post))
+        .route_with_tsr("/api/2fa/delete", post(disable_two_factor::post))
+        .route_with_tsr("/api/sessions", get(view_sessions::get))
+        .route_with_tsr("/api/sessions/:session_id/revoke", post(revoke_session::post))
+        .route_with_tsr("/api/login-hooks", get(view_login_hooks::get).post(add_login_hook::post))
+        .route_with_tsr("/api/login-hooks/:hook_id/delete", post(delete_login_hook::post))
+        .route_layer(middleware::from_fn(auth))
+        .route_layer(middleware::from_fn_with_state(state, audit_trail))
         .route_with_tsr("/api/register", post(register::register_user))
         .route_with_tsr("/api/login", post(login::login_user))
         .route_with_tsr(
@@ -18,4 +46,7 @@ pub async fn router(_state: AppState, _config: &Settings) -> Router<AppState, Bo
             get(logout::logout_user).post(logout::logout_user),
         )
         .route_with_tsr("/api/validate", get(validate::validate_auth))
+        .route_with_tsr("/api/oidc", get(oidc_info::get))
+        .route_with_tsr("/api/oidc/login", get(oidc_login::get))
+        .route_with_tsr("/api/oidc/callback", get(oidc_callback::get))
 }
@@ -0,0 +1,89 @@
+use std::net::SocketAddr;
+
+use axum::extract::{ConnectInfo, Query, State};
+use axum::response::Response;
+use hyper::{Body, HeaderMap, StatusCode};
+use serde::Deserialize;
+
+use crate::auth::oidc::{OidcError, PendingLogin, SESSION_KEY};
+use crate::auth::sessions::{self, Device};
+use crate::{auth::Auth, startup::AppState};
+
+#[derive(Deserialize, Debug)]
+pub struct OidcCallbackQuery {
+    pub code: Option<String>,
+    pub state: Option<String>,
+    pub error: Option<String>,
+    pub error_description: Option<String>,
+}
+
+/// Back to the login page, which shows the error
+pub fn login_error(message: &str) -> Response<Body> {
+    let message = url::form_urlencoded::byte_serialize(message.as_bytes()).collect::<String>();
+
+    Response::builder()
+        .status(StatusCode::FOUND)
+        .header("Location", format!("/web/login?error={message}"))
+        .body(Body::empty())
+        .unwrap()
+}
+
+/// Where the identity provider sends the user back to. Logs them in, creating their account
+/// on the first login. Two-factor authentication is up to the identity provider here
+#[tracing::instrument(skip(auth, pool, oidc, notifier, headers, code))]
+pub async fn get(
+    auth: Auth,
+    State(AppState { pool, oidc, notifier, .. }): State<AppState>,
+    ConnectInfo(addr): ConnectInfo<SocketAddr>,
+    headers: HeaderMap,
+    Query(OidcCallbackQuery { code, state, error, error_description }): Query<OidcCallbackQuery>,
+) -> Response<Body> {
+    let Some(oidc) = oidc else {
+        return login_error("SSO is not enabled");
+    };
+
+    // a login is only finished once
+    let pending = auth.session.get::<PendingLogin>(SESSION_KEY);
+    auth.session.remove(SESSION_KEY);
+
+    let pending = match pending {
+        Some(pending) if state.as_deref() == Some(pending.state.as_str()) => pending,
+        _ => return login_error("SSO login expired, try again"),
+    };
+
+    if let Some(error) = error {
+        tracing::warn!(%error, ?error_description, "Can't finish sso login: Identity provider refused");
+        return login_error(&error_description.unwrap_or(error));
+    }
+    let Some(code) = code else {
+        return login_error("Identity provider sent no code");
+    };
+
+    let identity = match oidc.finish(&code, &pending).await {
+        Ok(identity) => identity,
+        Err(err) => {
+            tracing::error!(?err, "Can't finish sso login: Failed to get identity");
+            return login_error(&OidcError::Provider(err).to_string());
+        }
+    };
+
+    let user_id = match oidc.sign_in(&identity, pending.link_to, &pool).await {
+        Ok(user_id) => user_id,
+        Err(OidcError::Denied(message)) => return login_error(&message),
+        Err(err) => {
+            tracing::error!(?err, username = %identity.username, "Can't finish sso login: Failed to sign in");
+            return login_error(&err.to_string());
+        }
+    };
+
+    auth.login_user(user_id);
+    if let Err(err) = sessions::start(&auth, user_id, &identity.username, Device::new(&addr, &headers), &notifier, &pool).await {
+        tracing::error!(?err, "Can't record session: Failed to query database");
+    }
+
+    Response::builder()
+        .status(StatusCode::FOUND)
+        .header("Location", format!("/web{}", pending.redirect.unwrap_or("/".to_string())))
+        .body(Body::empty())
+        .unwrap()
+}
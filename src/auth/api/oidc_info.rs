@@ -0,0 +1,44 @@
+use axum::extract::State;
+use axum::response::Response;
+use hyper::{Body, StatusCode};
+use serde::Serialize;
+
+use crate::startup::AppState;
+
+#[derive(Serialize, Debug)]
+struct OidcInfoResponse {
+    /// shown on the login button
+    name: String,
+    /// whether the username and password form still works
+    passwords: bool,
+}
+
+#[derive(Serialize, Debug)]
+struct ErrorResponse {
+    message: String,
+}
+
+/// Tells the login page whether to offer sso
+#[tracing::instrument(skip(oidc))]
+pub async fn get(State(AppState { oidc, .. }): State<AppState>) -> Response<Body> {
+    let Some(oidc) = oidc else {
+        let json = serde_json::to_string(&ErrorResponse {
+            message: "SSO is not enabled".to_string()
+        }).unwrap();
+
+        return Response::builder()
+            .status(StatusCode::NOT_FOUND)
+            .body(Body::from(json))
+            .unwrap();
+    };
+
+    let json = serde_json::to_string(&OidcInfoResponse {
+        name: oidc.name().to_string(),
+        passwords: oidc.passwords(),
+    }).unwrap();
+
+    Response::builder()
+        .status(StatusCode::OK)
+        .body(Body::from(json))
+        .unwrap()
+}
@@ -0,0 +1,58 @@
+use axum::extract::{Query, State};
+use axum::response::Response;
+use axum::Extension;
+use hyper::{Body, StatusCode};
+use serde::Deserialize;
+
+use super::oidc_callback::login_error;
+use crate::auth::oidc::SESSION_KEY;
+use crate::auth::tokens::TokenAccess;
+use crate::{auth::Auth, startup::AppState};
+
+#[derive(Deserialize, Debug)]
+pub struct OidcLoginQuery {
+    /// path under /web to go to after logging in
+    pub redirect: Option<String>,
+}
+
+/// Sends the user to the identity provider. Somebody who is logged in already gets the
+/// account there linked to theirs instead
+#[tracing::instrument(skip(auth, oidc, token))]
+pub async fn get(
+    auth: Auth,
+    State(AppState { oidc, .. }): State<AppState>,
+    token: Option<Extension<TokenAccess>>,
+    Query(OidcLoginQuery { redirect }): Query<OidcLoginQuery>,
+) -> Response<Body> {
+    let Some(oidc) = oidc else {
+        return login_error("SSO is not enabled");
+    };
+
+    // linking would let a token log in as its user with any sso account
+    if token.is_some() {
+        return Response::builder()
+            .status(StatusCode::FORBIDDEN)
+            .body(Body::from(r#"{"message":"Tokens can't log in with sso"}"#))
+            .unwrap();
+    }
+
+    // only paths, so the login can't be used to send users to other sites
+    let redirect = redirect.filter(|path| path.starts_with('/') && !path.starts_with("//"));
+    let link_to = auth.current_user.as_ref().map(|user| user.id);
+
+    match oidc.start(link_to, redirect).await {
+        Ok((url, pending)) => {
+            auth.session.set(SESSION_KEY, pending);
+
+            Response::builder()
+                .status(StatusCode::FOUND)
+                .header("Location", url)
+                .body(Body::empty())
+                .unwrap()
+        }
+        Err(err) => {
+            tracing::error!(?err, "Can't start sso login: Failed to reach identity provider");
+            login_error("Failed to reach the identity provider, try again later")
+        }
+    }
+}
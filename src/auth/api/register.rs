@@ -70,12 +70,26 @@ struct RegisterUserSuccessResponse {
     message: String,
 }
 
-#[tracing::instrument(skip(auth, pool))]
+#[tracing::instrument(skip(auth, pool, oidc))]
 pub async fn register_user(
     auth: Auth,
-    State(AppState { pool, sso, .. }): State<AppState>,
+    State(AppState { pool, sso, oidc, .. }): State<AppState>,
     Json(req): Json<Unvalidated<UserRequest>>,
 ) -> Response<Body> {
+    // sso creates accounts on the first login
+    if oidc.is_some_and(|oidc| !oidc.passwords()) {
+        return Response::builder()
+            .status(StatusCode::BAD_REQUEST)
+            .body(Body::from(
+                serde_json::to_string(&ErrorResponse {
+                    message: "Registering is disabled, log in with SSO".to_string(),
+                    error_type: RegisterUserErrorType::SSOError,
+                })
+                .unwrap(),
+            ))
+            .unwrap();
+    }
+
     let UserRequest {
         username,
         name,
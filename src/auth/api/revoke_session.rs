@@ -0,0 +1,62 @@
+use axum::extract::{Path, State};
+use axum::response::Response;
+use hyper::{Body, StatusCode};
+use serde::Serialize;
+use uuid::Uuid;
+
+use crate::{auth::Auth, startup::AppState};
+
+#[derive(Serialize, Debug)]
+struct ErrorResponse {
+    message: String,
+}
+
+/// Revokes a session of the user, it is logged out on its next request
+#[tracing::instrument(skip(auth, pool))]
+pub async fn post(
+    auth: Auth,
+    State(AppState { pool, .. }): State<AppState>,
+    Path(session_id): Path<Uuid>,
+) -> Response<Body> {
+    let user = auth.current_user.unwrap();
+
+    match sqlx::query!(
+        r#"UPDATE user_sessions SET ended_at = now()
+           WHERE id = $1 AND user_id = $2 AND ended_at IS NULL
+        "#,
+        session_id,
+        user.id
+    )
+    .execute(&pool)
+    .await
+    {
+        Ok(revoked) if revoked.rows_affected() == 0 => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Session does not exist".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Ok(_) => {}
+        Err(err) => {
+            tracing::error!(?err, "Can't revoke session: Failed to update database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Failed to update database".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    Response::builder()
+        .status(StatusCode::NO_CONTENT)
+        .body(Body::empty())
+        .unwrap()
+}
@@ -0,0 +1,62 @@
+use axum::extract::{Path, State};
+use axum::response::Response;
+use hyper::{Body, StatusCode};
+use serde::Serialize;
+use uuid::Uuid;
+
+use crate::{auth::Auth, startup::AppState};
+
+#[derive(Serialize, Debug)]
+struct ErrorResponse {
+    message: String,
+}
+
+/// Revokes a token of the user, it stops working right away
+#[tracing::instrument(skip(auth, pool))]
+pub async fn post(
+    auth: Auth,
+    State(AppState { pool, .. }): State<AppState>,
+    Path(token_id): Path<Uuid>,
+) -> Response<Body> {
+    let user = auth.current_user.unwrap();
+
+    match sqlx::query!(
+        r#"UPDATE access_tokens SET revoked_at = now()
+           WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL
+        "#,
+        token_id,
+        user.id
+    )
+    .execute(&pool)
+    .await
+    {
+        Ok(revoked) if revoked.rows_affected() == 0 => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Token does not exist".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Ok(_) => {}
+        Err(err) => {
+            tracing::error!(?err, "Can't revoke token: Failed to update database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Failed to update database".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    Response::builder()
+        .status(StatusCode::NO_CONTENT)
+        .body(Body::empty())
+        .unwrap()
+}
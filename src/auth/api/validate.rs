@@ -4,13 +4,15 @@ use axum::{
 use hyper::{Body, StatusCode};
 use serde::Serialize;
 use uuid::Uuid;
-use crate::{startup::AppState, auth::{Auth, User, RegisterUserErrorType, ErrorResponse}};
+use crate::{startup::AppState, admin::impersonation, auth::{Auth, User, RegisterUserErrorType, ErrorResponse}};
 
 #[derive(Serialize, Debug)]
 pub struct ValidateAuthResponse {
     id: Uuid,
     username: String,
     name: String,
+    /// a platform admin is logged in as this user, read-only
+    impersonated: bool,
 }
 
 #[tracing::instrument(skip(auth))]
@@ -25,6 +27,7 @@ pub async fn validate_auth(
             .unwrap()
     }
 
+    let impersonated = impersonation(&auth).is_some();
     let current_user = auth.current_user.unwrap();
     let user = match User::get_from_username(&current_user.username, &pool).await {
         Ok(user) => user,
@@ -49,6 +52,7 @@ pub async fn validate_auth(
                     id: user.id,
                     username: user.username,
                     name: user.name,
+                    impersonated,
                 }
             ).unwrap()
         ))
@@ -0,0 +1,82 @@
+use axum::extract::State;
+use axum::response::Response;
+use chrono::{DateTime, Utc};
+use hyper::{Body, StatusCode};
+use serde::Serialize;
+use uuid::Uuid;
+
+use crate::notifications::redact;
+use crate::{auth::Auth, startup::AppState};
+
+#[derive(Serialize, Debug)]
+pub struct LoginHook {
+    pub id: Uuid,
+    pub kind: String,
+    /// with the secret part masked
+    pub url: String,
+    /// signs webhook payloads, null for slack and discord
+    pub secret: Option<String>,
+    pub created_at: DateTime<Utc>,
+}
+
+#[derive(Serialize, Debug)]
+struct LoginHookListResponse {
+    data: Vec<LoginHook>,
+}
+
+#[derive(Serialize, Debug)]
+struct ErrorResponse {
+    message: String,
+}
+
+/// Hooks told about logins of the user from new devices
+#[tracing::instrument(skip(auth, pool))]
+pub async fn get(
+    auth: Auth,
+    State(AppState { pool, .. }): State<AppState>,
+) -> Response<Body> {
+    let user = auth.current_user.unwrap();
+
+    let hooks = match sqlx::query!(
+        r#"SELECT id, kind, url, secret, created_at FROM login_hooks
+           WHERE user_id = $1
+           ORDER BY created_at
+        "#,
+        user.id
+    )
+    .fetch_all(&pool)
+    .await
+    {
+        Ok(hooks) => hooks,
+        Err(err) => {
+            tracing::error!(?err, "Can't get login hooks: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    let data = hooks
+        .into_iter()
+        .map(|hook| LoginHook {
+            id: hook.id,
+            url: redact(&hook.kind, &hook.url),
+            secret: (hook.kind == "webhook").then_some(hook.secret),
+            kind: hook.kind,
+            created_at: hook.created_at,
+        })
+        .collect();
+
+    let json = serde_json::to_string(&LoginHookListResponse { data }).unwrap();
+
+    Response::builder()
+        .status(StatusCode::OK)
+        .body(Body::from(json))
+        .unwrap()
+}
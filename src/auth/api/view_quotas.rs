@@ -0,0 +1,44 @@
+use axum::extract::State;
+use axum::response::Response;
+use hyper::{Body, StatusCode};
+use serde::Serialize;
+
+use crate::quotas::account_quotas;
+use crate::{auth::Auth, startup::AppState};
+
+#[derive(Serialize, Debug)]
+struct ErrorResponse {
+    message: String,
+}
+
+/// The quotas of the user next to what their account uses
+#[tracing::instrument(skip(auth, pool, quota_settings))]
+pub async fn get(
+    auth: Auth,
+    State(AppState { pool, quota_settings, .. }): State<AppState>,
+) -> Response<Body> {
+    let user = auth.current_user.unwrap();
+
+    match account_quotas(user.id, &quota_settings, &pool).await {
+        Ok(quotas) => {
+            let json = serde_json::to_string(&quotas).unwrap();
+
+            Response::builder()
+                .status(StatusCode::OK)
+                .body(Body::from(json))
+                .unwrap()
+        }
+        Err(err) => {
+            tracing::error!(?err, "Can't get quotas: Failed to query usage");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query usage: {err}")
+            }).unwrap();
+
+            Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap()
+        }
+    }
+}
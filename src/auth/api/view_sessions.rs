@@ -0,0 +1,86 @@
+use axum::extract::State;
+use axum::response::Response;
+use chrono::{DateTime, Utc};
+use hyper::{Body, StatusCode};
+use serde::Serialize;
+use uuid::Uuid;
+
+use crate::auth::sessions::SESSION_KEY;
+use crate::{auth::Auth, startup::AppState};
+
+#[derive(Serialize, Debug)]
+struct Session {
+    id: Uuid,
+    ip: Option<String>,
+    user_agent: Option<String>,
+    /// the session of this request
+    current: bool,
+    created_at: DateTime<Utc>,
+    last_seen_at: DateTime<Utc>,
+}
+
+#[derive(Serialize, Debug)]
+struct ViewSessionsResponse {
+    data: Vec<Session>,
+}
+
+#[derive(Serialize, Debug)]
+struct ErrorResponse {
+    message: String,
+}
+
+/// Sessions of the user that are still logged in, newest first. Sessions that weren't used
+/// for as long as a session lasts have expired and aren't listed
+#[tracing::instrument(skip(auth, pool, auth_settings))]
+pub async fn get(
+    auth: Auth,
+    State(AppState { pool, auth_settings, .. }): State<AppState>,
+) -> Response<Body> {
+    let user = auth.current_user.as_ref().unwrap();
+    let current = auth.session.get::<Uuid>(SESSION_KEY);
+
+    let sessions = match sqlx::query!(
+        r#"SELECT id, ip, user_agent, created_at, last_seen_at FROM user_sessions
+           WHERE user_id = $1 AND ended_at IS NULL AND last_seen_at > now() - make_interval(hours => $2)
+           ORDER BY last_seen_at DESC
+        "#,
+        user.id,
+        auth_settings.lifespan as i32
+    )
+    .fetch_all(&pool)
+    .await
+    {
+        Ok(sessions) => sessions,
+        Err(err) => {
+            tracing::error!(?err, "Can't get sessions: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    let data = sessions
+        .into_iter()
+        .map(|session| Session {
+            current: current == Some(session.id),
+            id: session.id,
+            ip: session.ip,
+            user_agent: session.user_agent,
+            created_at: session.created_at,
+            last_seen_at: session.last_seen_at,
+        })
+        .collect();
+
+    let json = serde_json::to_string(&ViewSessionsResponse { data }).unwrap();
+
+    Response::builder()
+        .status(StatusCode::OK)
+        .body(Body::from(json))
+        .unwrap()
+}
@@ -0,0 +1,93 @@
+use axum::extract::State;
+use axum::response::Response;
+use chrono::{DateTime, Utc};
+use hyper::{Body, StatusCode};
+use serde::Serialize;
+use uuid::Uuid;
+
+use crate::auth::tokens::TokenScope;
+use crate::{auth::Auth, startup::AppState};
+
+#[derive(Serialize, Debug)]
+pub struct Token {
+    pub id: Uuid,
+    pub name: String,
+    pub scope: TokenScope,
+    /// `owner/project` of the only app the token works for
+    pub app: Option<String>,
+    /// start of the token, to tell it apart from the others
+    pub prefix: String,
+    pub expires_at: Option<DateTime<Utc>>,
+    pub last_used_at: Option<DateTime<Utc>>,
+    pub created_at: DateTime<Utc>,
+}
+
+#[derive(Serialize, Debug)]
+struct ViewTokensResponse {
+    data: Vec<Token>,
+}
+
+#[derive(Serialize, Debug)]
+struct ErrorResponse {
+    message: String,
+}
+
+/// Tokens of the user that weren't revoked. Expired ones are listed until they're revoked
+#[tracing::instrument(skip(auth, pool))]
+pub async fn get(
+    auth: Auth,
+    State(AppState { pool, .. }): State<AppState>,
+) -> Response<Body> {
+    let user = auth.current_user.unwrap();
+
+    let tokens = match sqlx::query!(
+        r#"SELECT access_tokens.id, access_tokens.name, access_tokens.scope AS "scope: TokenScope",
+           project_owners.name AS "owner?", projects.name AS "project?", access_tokens.prefix,
+           access_tokens.expires_at, access_tokens.last_used_at, access_tokens.created_at
+           FROM access_tokens
+           LEFT JOIN projects ON projects.id = access_tokens.project_id
+           LEFT JOIN project_owners ON project_owners.id = projects.owner_id
+           WHERE access_tokens.user_id = $1 AND access_tokens.revoked_at IS NULL
+           ORDER BY access_tokens.created_at DESC
+        "#,
+        user.id
+    )
+    .fetch_all(&pool)
+    .await
+    {
+        Ok(tokens) => tokens,
+        Err(err) => {
+            tracing::error!(?err, "Can't get tokens: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    let data = tokens
+        .into_iter()
+        .map(|token| Token {
+            id: token.id,
+            name: token.name,
+            scope: token.scope,
+            app: token.owner.zip(token.project).map(|(owner, project)| format!("{owner}/{project}")),
+            prefix: token.prefix,
+            expires_at: token.expires_at,
+            last_used_at: token.last_used_at,
+            created_at: token.created_at,
+        })
+        .collect();
+
+    let json = serde_json::to_string(&ViewTokensResponse { data }).unwrap();
+
+    Response::builder()
+        .status(StatusCode::OK)
+        .body(Body::from(json))
+        .unwrap()
+}
@@ -0,0 +1,69 @@
+use axum::extract::State;
+use axum::response::Response;
+use chrono::{DateTime, Utc};
+use hyper::{Body, StatusCode};
+use serde::Serialize;
+
+use crate::{auth::Auth, startup::AppState};
+
+#[derive(Serialize, Debug)]
+struct TwoFactorResponse {
+    /// logins ask for a code, false while setup wasn't confirmed yet
+    enabled: bool,
+    recovery_codes_left: usize,
+    confirmed_at: Option<DateTime<Utc>>,
+}
+
+#[derive(Serialize, Debug)]
+struct ErrorResponse {
+    message: String,
+}
+
+/// Whether the user has two-factor authentication turned on
+#[tracing::instrument(skip(auth, pool))]
+pub async fn get(
+    auth: Auth,
+    State(AppState { pool, .. }): State<AppState>,
+) -> Response<Body> {
+    let user = auth.current_user.unwrap();
+
+    let totp = match sqlx::query!(
+        "SELECT recovery_codes, confirmed_at FROM user_totp WHERE user_id = $1",
+        user.id
+    )
+    .fetch_optional(&pool)
+    .await
+    {
+        Ok(totp) => totp,
+        Err(err) => {
+            tracing::error!(?err, "Can't get two-factor authentication: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    let json = serde_json::to_string(&match totp {
+        Some(totp) => TwoFactorResponse {
+            enabled: totp.confirmed_at.is_some(),
+            recovery_codes_left: totp.recovery_codes.len(),
+            confirmed_at: totp.confirmed_at,
+        },
+        None => TwoFactorResponse {
+            enabled: false,
+            recovery_codes_left: 0,
+            confirmed_at: None,
+        },
+    }).unwrap();
+
+    Response::builder()
+        .status(StatusCode::OK)
+        .body(Body::from(json))
+        .unwrap()
+}
@@ -27,6 +27,10 @@ lazy_static! {
 }
 
 pub mod api;
+pub mod oidc;
+pub mod sessions;
+pub mod tokens;
+pub mod two_factor;
 
 pub type Auth = AuthSession<User, Uuid, SessionPgPool, PgPool>;
 
@@ -183,6 +187,8 @@ enum RegisterUserErrorType {
     BadRequestError,
     InternalServerError,
     SSOError,
+    /// the password was right, the code of the authenticator app is missing or wrong
+    TwoFactorRequired,
 }
 
 #[derive(Serialize, Debug)]
@@ -0,0 +1,510 @@
+use std::collections::BTreeMap;
+use std::sync::Arc;
+use std::time::Duration;
+
+use anyhow::{anyhow, bail, Result};
+use argon2::{
+    password_hash::{rand_core::OsRng, PasswordHasher, SaltString},
+    Argon2,
+};
+use data_encoding::BASE64URL_NOPAD;
+use rand::RngCore;
+use secrecy::ExposeSecret;
+use serde::{Deserialize, Serialize};
+use serde_json::{Map, Value};
+use sha2::{Digest, Sha256};
+use sqlx::PgPool;
+use thiserror::Error;
+use tokio::sync::OnceCell;
+use ulid::Ulid;
+use uuid::Uuid;
+
+use crate::configuration::OidcSettings;
+use crate::owner::Role;
+
+/// Requests to the provider happen while the user waits on the login
+const PROVIDER_TIMEOUT: Duration = Duration::from_secs(10);
+
+/// Key of the [`PendingLogin`] in the session
+pub const SESSION_KEY: &str = "oidc_login";
+
+/// Endpoints from the discovery document of the provider
+#[derive(Deserialize, Debug)]
+struct Provider {
+    issuer: String,
+    authorization_endpoint: String,
+    token_endpoint: String,
+    userinfo_endpoint: Option<String>,
+}
+
+/// Logs users in with an openid connect provider using the authorization code flow. The
+/// provider is discovered on the first login, so it being down doesn't stop the platform
+#[derive(Clone)]
+pub struct Oidc {
+    settings: Arc<OidcSettings>,
+    redirect_url: String,
+    provider: Arc<OnceCell<Provider>>,
+    client: reqwest::Client,
+}
+
+impl std::fmt::Debug for Oidc {
+    fn fmt(&self, f: &mut std::fmt::Formatter<'_>) -> std::fmt::Result {
+        f.debug_struct("Oidc")
+            .field("issuer", &self.settings.issuer)
+            .field("redirect_url", &self.redirect_url)
+            .finish()
+    }
+}
+
+/// What a login started with, kept in the session until the provider sends the user back
+#[derive(Serialize, Deserialize, Debug, Clone)]
+pub struct PendingLogin {
+    pub state: String,
+    pub nonce: String,
+    /// pkce code verifier
+    pub verifier: String,
+    /// user the identity gets linked to, when somebody was logged in already
+    pub link_to: Option<Uuid>,
+    /// path under /web to go to afterwards
+    pub redirect: Option<String>,
+}
+
+/// The account at the provider a login came from
+#[derive(Debug, Clone)]
+pub struct Identity {
+    pub issuer: String,
+    pub subject: String,
+    pub username: String,
+    pub name: String,
+    pub groups: Vec<String>,
+}
+
+#[derive(Error, Debug)]
+pub enum OidcError {
+    /// the user can't log in, the message tells them why
+    #[error("{0}")]
+    Denied(String),
+    #[error("Failed to log in with the identity provider: {0}")]
+    Provider(anyhow::Error),
+    #[error("Failed to query database: {0}")]
+    Database(#[from] sqlx::Error),
+}
+
+fn random() -> String {
+    let mut bytes = [0u8; 32];
+    rand::thread_rng().fill_bytes(&mut bytes);
+    BASE64URL_NOPAD.encode(&bytes)
+}
+
+/// The claims of an id token. It came straight from the token endpoint over tls, so its
+/// signature doesn't have to be checked (OpenID Connect Core 3.1.3.7)
+fn id_token_claims(id_token: &str) -> Result<Map<String, Value>> {
+    let payload = id_token
+        .split('.')
+        .nth(1)
+        .ok_or(anyhow!("Id token is not a jwt"))?;
+    let payload = BASE64URL_NOPAD
+        .decode(payload.trim_end_matches('=').as_bytes())
+        .map_err(|err| anyhow!("Id token is not valid base64: {err}"))?;
+
+    Ok(serde_json::from_slice(&payload)?)
+}
+
+impl Oidc {
+    /// None when no issuer is configured, sso is off then
+    pub fn new(settings: &OidcSettings, domain: &str, secure: bool) -> Result<Option<Self>> {
+        if settings.issuer.is_none() {
+            return Ok(None);
+        }
+        let scheme = match secure {
+            true => "https",
+            false => "http",
+        };
+
+        Ok(Some(Self {
+            settings: Arc::new(settings.clone()),
+            redirect_url: format!("{scheme}://{domain}/api/oidc/callback"),
+            provider: Arc::new(OnceCell::new()),
+            client: reqwest::Client::builder().timeout(PROVIDER_TIMEOUT).build()?,
+        }))
+    }
+
+    /// Shown on the login button
+    pub fn name(&self) -> &str {
+        &self.settings.name
+    }
+
+    /// Whether local passwords still work next to sso
+    pub fn passwords(&self) -> bool {
+        self.settings.passwords
+    }
+
+    async fn provider(&self) -> Result<&Provider> {
+        self.provider
+            .get_or_try_init(|| async {
+                let issuer = self.settings.issuer.as_deref().unwrap_or_default().trim_end_matches('/');
+                let provider = self
+                    .client
+                    .get(format!("{issuer}/.well-known/openid-configuration"))
+                    .send()
+                    .await?
+                    .error_for_status()?
+                    .json::<Provider>()
+                    .await?;
+
+                if provider.issuer.trim_end_matches('/') != issuer {
+                    bail!("Provider says its issuer is {}, not {issuer}", provider.issuer);
+                }
+                Ok(provider)
+            })
+            .await
+    }
+
+    /// Where to send the user to log in, and what to keep in their session until they're back
+    pub async fn start(&self, link_to: Option<Uuid>, redirect: Option<String>) -> Result<(String, PendingLogin)> {
+        let provider = self.provider().await?;
+        let pending = PendingLogin {
+            state: random(),
+            nonce: random(),
+            verifier: random(),
+            link_to,
+            redirect,
+        };
+        let challenge = BASE64URL_NOPAD.encode(&Sha256::digest(pending.verifier.as_bytes()));
+
+        let mut scopes = self.settings.scopes.split_whitespace().collect::<Vec<_>>();
+        if !scopes.contains(&"openid") {
+            scopes.insert(0, "openid");
+        }
+
+        let mut url = url::Url::parse(&provider.authorization_endpoint)?;
+        url.query_pairs_mut()
+            .append_pair("response_type", "code")
+            .append_pair("client_id", &self.settings.clientid)
+            .append_pair("redirect_uri", &self.redirect_url)
+            .append_pair("scope", &scopes.join(" "))
+            .append_pair("state", &pending.state)
+            .append_pair("nonce", &pending.nonce)
+            .append_pair("code_challenge", &challenge)
+            .append_pair("code_challenge_method", "S256");
+
+        Ok((url.to_string(), pending))
+    }
+
+    /// Trades the code the provider sent the user back with for who they are
+    pub async fn finish(&self, code: &str, pending: &PendingLogin) -> Result<Identity> {
+        #[derive(Deserialize)]
+        struct TokenResponse {
+            access_token: String,
+            id_token: String,
+        }
+
+        let provider = self.provider().await?;
+
+        let secret = self
+            .settings
+            .clientsecret
+            .as_ref()
+            .map(|secret| secret.expose_secret().clone());
+        let mut form = vec![
+            ("grant_type", "authorization_code"),
+            ("code", code),
+            ("redirect_uri", self.redirect_url.as_str()),
+            ("client_id", self.settings.clientid.as_str()),
+            ("code_verifier", pending.verifier.as_str()),
+        ];
+        if let Some(secret) = &secret {
+            form.push(("client_secret", secret.as_str()));
+        }
+
+        let tokens = self
+            .client
+            .post(&provider.token_endpoint)
+            .form(&form)
+            .send()
+            .await?
+            .error_for_status()?
+            .json::<TokenResponse>()
+            .await?;
+
+        let mut claims = id_token_claims(&tokens.id_token)?;
+
+        if claims.get("iss").and_then(Value::as_str) != Some(provider.issuer.as_str()) {
+            bail!("Id token is from another issuer");
+        }
+        let audience = match claims.get("aud") {
+            Some(Value::String(aud)) => aud == &self.settings.clientid,
+            Some(Value::Array(auds)) => auds.iter().any(|aud| aud.as_str() == Some(&self.settings.clientid)),
+            _ => false,
+        };
+        if !audience {
+            bail!("Id token is for another client");
+        }
+        let expires = claims.get("exp").and_then(Value::as_i64).unwrap_or_default();
+        if expires < chrono::Utc::now().timestamp() {
+            bail!("Id token expired");
+        }
+        if claims.get("nonce").and_then(Value::as_str) != Some(pending.nonce.as_str()) {
+            bail!("Id token is for another login");
+        }
+
+        // providers often only put the profile and groups in userinfo
+        if let Some(userinfo) = &provider.userinfo_endpoint {
+            let info = self
+                .client
+                .get(userinfo)
+                .bearer_auth(&tokens.access_token)
+                .send()
+                .await?
+                .error_for_status()?
+                .json::<Map<String, Value>>()
+                .await?;
+
+            if info.get("sub") != claims.get("sub") {
+                bail!("Userinfo is for another user");
+            }
+            claims.extend(info);
+        }
+
+        self.identity(&provider.issuer, &claims)
+    }
+
+    fn identity(&self, issuer: &str, claims: &Map<String, Value>) -> Result<Identity> {
+        let subject = claims
+            .get("sub")
+            .and_then(Value::as_str)
+            .ok_or(anyhow!("Id token has no subject"))?
+            .to_string();
+
+        // usernames can only have alphanumeric characters and dots
+        let username = claims
+            .get(&self.settings.usernameclaim)
+            .and_then(Value::as_str)
+            .unwrap_or_default();
+        let username = username
+            .split('@')
+            .next()
+            .unwrap_or_default()
+            .chars()
+            .filter(|c| c.is_ascii_alphanumeric() || *c == '.')
+            .collect::<String>()
+            .to_lowercase();
+        if username.is_empty() {
+            bail!("Provider sent no {} to name the account after", self.settings.usernameclaim);
+        }
+
+        let name = claims
+            .get("name")
+            .and_then(Value::as_str)
+            .map(str::to_string)
+            .unwrap_or(username.clone());
+
+        // keycloak sends groups as paths like /cs/students
+        let groups = match claims.get(&self.settings.groupsclaim) {
+            Some(Value::Array(groups)) => groups
+                .iter()
+                .filter_map(Value::as_str)
+                .map(|group| group.trim_start_matches('/').to_string())
+                .collect(),
+            Some(Value::String(group)) => vec![group.trim_start_matches('/').to_string()],
+            _ => Vec::new(),
+        };
+
+        Ok(Identity {
+            issuer: issuer.to_string(),
+            subject,
+            username,
+            name,
+            groups,
+        })
+    }
+
+    fn in_group(identity: &Identity, group: &str) -> bool {
+        let group = group.trim_start_matches('/');
+        identity.groups.iter().any(|g| g == group)
+    }
+
+    /// The user an identity logs in as. The first login links it to `link_to`, or creates an
+    /// account named after it with its own owner like registering does. Memberships from
+    /// the groups of the identity are brought up to date every time
+    pub async fn sign_in(&self, identity: &Identity, link_to: Option<Uuid>, pool: &PgPool) -> Result<Uuid, OidcError> {
+        let allowed = &self.settings.allowedgroups;
+        if !allowed.is_empty() && !allowed.iter().any(|group| Self::in_group(identity, group)) {
+            return Err(OidcError::Denied(format!(
+                "{} is not in a group that may use the platform",
+                identity.username
+            )));
+        }
+
+        let linked = sqlx::query!(
+            r#"UPDATE user_identities SET last_login_at = now()
+               WHERE issuer = $1 AND subject = $2
+               RETURNING user_id
+            "#,
+            identity.issuer,
+            identity.subject
+        )
+        .fetch_optional(pool)
+        .await?;
+
+        let user_id = match (linked, link_to) {
+            (Some(linked), Some(link_to)) if linked.user_id != link_to => {
+                return Err(OidcError::Denied(
+                    "This sso account is linked to another user already".to_string(),
+                ));
+            }
+            (Some(linked), _) => linked.user_id,
+            (None, Some(link_to)) => {
+                sqlx::query!(
+                    r#"INSERT INTO user_identities (id, user_id, issuer, subject) VALUES ($1, $2, $3, $4)"#,
+                    Uuid::from(Ulid::new()),
+                    link_to,
+                    identity.issuer,
+                    identity.subject
+                )
+                .execute(pool)
+                .await?;
+                link_to
+            }
+            (None, None) => provision(identity, pool).await?,
+        };
+
+        self.sync_groups(user_id, identity, pool).await?;
+        Ok(user_id)
+    }
+
+    /// Gives the user the memberships their groups map to, with the highest role when
+    /// several groups map to one owner, and takes away the ones of groups they left.
+    /// Memberships added by hand are left alone
+    async fn sync_groups(&self, user_id: Uuid, identity: &Identity, pool: &PgPool) -> Result<(), sqlx::Error> {
+        let mut memberships = BTreeMap::<&str, (Role, &str)>::new();
+        for mapping in self.settings.groups.iter().filter(|mapping| Self::in_group(identity, &mapping.group)) {
+            let current = memberships.entry(mapping.owner.as_str()).or_insert((mapping.role, mapping.group.as_str()));
+            if mapping.role > current.0 {
+                *current = (mapping.role, mapping.group.as_str());
+            }
+        }
+
+        let mut tx = pool.begin().await?;
+
+        for (owner, (role, group)) in &memberships {
+            let existing = sqlx::query!(r#"SELECT id FROM project_owners WHERE name = $1"#, owner)
+                .fetch_optional(&mut *tx)
+                .await?;
+            let owner_id = match existing {
+                Some(existing) => existing.id,
+                None => {
+                    let owner_id = Uuid::from(Ulid::new());
+                    sqlx::query!(
+                        r#"INSERT INTO project_owners (id, name) VALUES ($1, $2)"#,
+                        owner_id,
+                        owner
+                    )
+                    .execute(&mut *tx)
+                    .await?;
+                    owner_id
+                }
+            };
+
+            sqlx::query!(
+                r#"INSERT INTO users_owners (user_id, owner_id, role, idp_group)
+                   VALUES ($1, $2, $3, $4)
+                   ON CONFLICT (user_id, owner_id) DO UPDATE SET role = $3, idp_group = $4, updated_at = now()
+                   WHERE users_owners.idp_group IS NOT NULL
+                "#,
+                user_id,
+                owner_id,
+                *role as Role,
+                *group
+            )
+            .execute(&mut *tx)
+            .await?;
+        }
+
+        let kept = memberships.keys().map(|owner| owner.to_string()).collect::<Vec<_>>();
+        sqlx::query!(
+            r#"DELETE FROM users_owners
+               USING project_owners
+               WHERE users_owners.owner_id = project_owners.id
+               AND users_owners.user_id = $1 AND users_owners.idp_group IS NOT NULL
+               AND NOT (project_owners.name = ANY($2))
+            "#,
+            user_id,
+            &kept
+        )
+        .execute(&mut *tx)
+        .await?;
+
+        tx.commit().await
+    }
+}
+
+/// Creates the account of an identity that logs in for the first time. It gets a random
+/// password nobody knows, so it only logs in with sso
+async fn provision(identity: &Identity, pool: &PgPool) -> Result<Uuid, OidcError> {
+    let taken = sqlx::query!(
+        r#"SELECT
+           EXISTS (SELECT 1 FROM users WHERE username = $1) AS "user!",
+           EXISTS (SELECT 1 FROM project_owners WHERE name = $1) AS "owner!"
+        "#,
+        identity.username
+    )
+    .fetch_one(pool)
+    .await?;
+    if taken.user || taken.owner {
+        return Err(OidcError::Denied(format!(
+            "{} is taken. Log in with its password, then log in with sso to link the two",
+            identity.username
+        )));
+    }
+
+    let password = Argon2::default()
+        .hash_password(random().as_bytes(), &SaltString::generate(&mut OsRng))
+        .map_err(|err| OidcError::Provider(anyhow!("Failed to hash password: {err}")))?
+        .to_string();
+
+    let user_id = Uuid::from(Ulid::new());
+    let owner_id = Uuid::from(Ulid::new());
+    let mut tx = pool.begin().await?;
+
+    sqlx::query!(
+        r#"INSERT INTO users (id, username, password, name) VALUES ($1, $2, $3, $4)"#,
+        user_id,
+        identity.username,
+        password,
+        identity.name
+    )
+    .execute(&mut *tx)
+    .await?;
+
+    sqlx::query!(
+        r#"INSERT INTO project_owners (id, name) VALUES ($1, $2)"#,
+        owner_id,
+        identity.username
+    )
+    .execute(&mut *tx)
+    .await?;
+
+    sqlx::query!(
+        r#"INSERT INTO users_owners (user_id, owner_id) VALUES ($1, $2)"#,
+        user_id,
+        owner_id,
+    )
+    .execute(&mut *tx)
+    .await?;
+
+    sqlx::query!(
+        r#"INSERT INTO user_identities (id, user_id, issuer, subject) VALUES ($1, $2, $3, $4)"#,
+        Uuid::from(Ulid::new()),
+        user_id,
+        identity.issuer,
+        identity.subject
+    )
+    .execute(&mut *tx)
+    .await?;
+
+    tx.commit().await?;
+    tracing::info!(username = %identity.username, "Created account for sso login");
+
+    Ok(user_id)
+}
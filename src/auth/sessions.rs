@@ -0,0 +1,213 @@
+use std::net::SocketAddr;
+
+use axum::{
+    extract::{ConnectInfo, State},
+    middleware::Next,
+    response::Response,
+};
+use bytes::Bytes;
+use chrono::{Duration, Utc};
+use http_body::combinators::UnsyncBoxBody;
+use hyper::{header::USER_AGENT, Body, HeaderMap, Request, StatusCode};
+use sqlx::PgPool;
+use ulid::Ulid;
+use uuid::Uuid;
+
+use crate::admin::{impersonation, IMPERSONATION_KEY};
+use crate::audit::client_ip;
+use crate::auth::{tokens::TokenAccess, Auth};
+use crate::notifications::{LoginPayload, Notifier, LOGIN_EVENT};
+use crate::startup::AppState;
+
+/// Session key of the id of the `user_sessions` row of a login
+pub const SESSION_KEY: &str = "user_session";
+
+/// how stale `last_seen_at` gets before a request updates it, not every request writes
+const SEEN_INTERVAL_MINUTES: i64 = 5;
+
+/// ended sessions stay this long to tell known devices from new ones
+const KEEP_DAYS: i32 = 90;
+
+/// Where a login came from. A device is known when the user logged in before with the same
+/// browser from the same address
+#[derive(Debug, Clone)]
+pub struct Device {
+    pub ip: String,
+    pub user_agent: Option<String>,
+}
+
+impl Device {
+    pub fn new(addr: &SocketAddr, headers: &HeaderMap) -> Self {
+        Self {
+            ip: client_ip(addr, headers),
+            user_agent: headers
+                .get(USER_AGENT)
+                .and_then(|value| value.to_str().ok())
+                .map(|value| value.chars().take(512).collect()),
+        }
+    }
+}
+
+/// Records a login that just happened in the session. The login hooks of the user hear
+/// about it when it comes from a device they haven't logged in from before
+pub async fn start(
+    auth: &Auth,
+    user_id: Uuid,
+    username: &str,
+    device: Device,
+    notifier: &Notifier,
+    pool: &PgPool,
+) -> Result<(), sqlx::Error> {
+    let known = sqlx::query!(
+        r#"SELECT EXISTS(
+             SELECT 1 FROM user_sessions
+             WHERE user_id = $1 AND ip = $2 AND user_agent IS NOT DISTINCT FROM $3
+           ) AS "known!"
+        "#,
+        user_id,
+        device.ip,
+        device.user_agent
+    )
+    .fetch_one(pool)
+    .await?
+    .known;
+
+    // logging in again on top of a session replaces it
+    end(auth, pool).await?;
+    let session_id = record(user_id, &device, pool).await?;
+    auth.session.set(SESSION_KEY, session_id);
+
+    if let Err(err) = sqlx::query!(
+        r#"DELETE FROM user_sessions
+           WHERE user_id = $1 AND COALESCE(ended_at, last_seen_at) < now() - make_interval(days => $2)
+        "#,
+        user_id,
+        KEEP_DAYS
+    )
+    .execute(pool)
+    .await
+    {
+        tracing::error!(?err, "Can't prune sessions: Failed to delete from database");
+    }
+
+    if !known {
+        let message = format!(
+            "logged in from a new device: {} at {}. If it wasn't you, revoke it with `pmk sessions revoke {session_id}` and change your password",
+            device.user_agent.as_deref().unwrap_or("unknown browser"),
+            device.ip
+        );
+        let payload = LoginPayload {
+            event: LOGIN_EVENT,
+            username: username.to_string(),
+            message,
+            session_id,
+            ip: Some(device.ip),
+            user_agent: device.user_agent,
+            timestamp: Utc::now(),
+        };
+        notifier.notify_login(user_id, payload, pool);
+    }
+
+    Ok(())
+}
+
+async fn record(user_id: Uuid, device: &Device, pool: &PgPool) -> Result<Uuid, sqlx::Error> {
+    let session_id = Uuid::from(Ulid::new());
+    sqlx::query!(
+        "INSERT INTO user_sessions (id, user_id, ip, user_agent) VALUES ($1, $2, $3, $4)",
+        session_id,
+        user_id,
+        device.ip,
+        device.user_agent
+    )
+    .execute(pool)
+    .await?;
+
+    Ok(session_id)
+}
+
+/// Ends the session of a logout, it stays listed as a known device
+pub async fn end(auth: &Auth, pool: &PgPool) -> Result<(), sqlx::Error> {
+    let Some(session_id) = auth.session.get::<Uuid>(SESSION_KEY) else {
+        return Ok(());
+    };
+    auth.session.remove(SESSION_KEY);
+
+    sqlx::query!(
+        "UPDATE user_sessions SET ended_at = now() WHERE id = $1 AND ended_at IS NULL",
+        session_id
+    )
+    .execute(pool)
+    .await?;
+
+    Ok(())
+}
+
+/// Logs out sessions that were revoked and keeps `last_seen_at` of the others current.
+/// Sessions from before sessions were listed get their row on their next request. Tokens
+/// and impersonations aren't sessions of the user, they are left alone
+pub async fn track<B>(
+    State(AppState { pool, .. }): State<AppState>,
+    ConnectInfo(addr): ConnectInfo<SocketAddr>,
+    auth: Auth,
+    request: Request<B>,
+    next: Next<B>,
+) -> Result<Response<UnsyncBoxBody<Bytes, axum::Error>>, Response<Body>> {
+    if request.extensions().get::<TokenAccess>().is_some() || impersonation(&auth).is_some() {
+        return Ok(next.run(request).await);
+    }
+    let Some(user_id) = auth.current_user.as_ref().map(|user| user.id) else {
+        return Ok(next.run(request).await);
+    };
+
+    let Some(session_id) = auth.session.get::<Uuid>(SESSION_KEY) else {
+        let device = Device::new(&addr, request.headers());
+        match record(user_id, &device, &pool).await {
+            Ok(session_id) => auth.session.set(SESSION_KEY, session_id),
+            Err(err) => tracing::error!(?err, "Can't record session: Failed to insert into database"),
+        }
+        return Ok(next.run(request).await);
+    };
+
+    let session = match sqlx::query!(
+        "SELECT user_id, last_seen_at, ended_at FROM user_sessions WHERE id = $1",
+        session_id
+    )
+    .fetch_optional(&pool)
+    .await
+    {
+        Ok(session) => session,
+        Err(err) => {
+            tracing::error!(?err, "Can't check session: Failed to query database");
+            return Ok(next.run(request).await);
+        }
+    };
+
+    match session {
+        Some(session) if session.user_id == user_id && session.ended_at.is_none() => {
+            if session.last_seen_at < Utc::now() - Duration::minutes(SEEN_INTERVAL_MINUTES) {
+                if let Err(err) = sqlx::query!(
+                    "UPDATE user_sessions SET last_seen_at = now() WHERE id = $1",
+                    session_id
+                )
+                .execute(&pool)
+                .await
+                {
+                    tracing::error!(?err, "Can't update session: Failed to update database");
+                }
+            }
+            Ok(next.run(request).await)
+        }
+        // revoked, or pruned long after it ended
+        _ => {
+            auth.session.remove(SESSION_KEY);
+            auth.session.remove(IMPERSONATION_KEY);
+            auth.logout_user();
+            Err(Response::builder()
+                .status(StatusCode::FOUND)
+                .header("Location", "/api/login")
+                .body(Body::empty())
+                .unwrap())
+        }
+    }
+}
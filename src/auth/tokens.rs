@@ -0,0 +1,233 @@
+use axum::{
+    extract::State,
+    middleware::Next,
+    response::Response,
+};
+use bytes::Bytes;
+use http_body::combinators::UnsyncBoxBody;
+use hyper::{Body, Method, Request, StatusCode};
+use rand::{Rng, SeedableRng};
+use serde::{Deserialize, Serialize};
+use sha2::{Digest, Sha256};
+use sqlx::PgPool;
+use uuid::Uuid;
+
+use crate::auth::{Auth, User};
+use crate::owner::{member_role, Role};
+use crate::startup::AppState;
+
+/// Every token starts with it, so a leaked one is easy to find in logs and code
+pub const TOKEN_PREFIX: &str = "pmk_";
+
+// Base64 url safe, the same alphabet as the git tokens of projects
+const CHARSET: &[u8] = b"ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789-_";
+const TOKEN_LENGTH: usize = 40;
+
+/// What a token may do besides reading. A token never gets more than the role of its user
+#[derive(Serialize, Deserialize, Debug, Clone, Copy, PartialEq, Eq, sqlx::Type)]
+#[sqlx(type_name = "token_scope", rename_all = "lowercase")]
+#[serde(rename_all = "lowercase")]
+pub enum TokenScope {
+    /// only what a viewer can do
+    Read,
+    /// reading, building, deploying and rolling back, what a CI pipeline needs
+    Deploy,
+    /// everything the user can do
+    Admin,
+}
+
+impl std::fmt::Display for TokenScope {
+    fn fmt(&self, f: &mut std::fmt::Formatter) -> std::fmt::Result {
+        match self {
+            TokenScope::Read => write!(f, "read"),
+            TokenScope::Deploy => write!(f, "deploy"),
+            TokenScope::Admin => write!(f, "admin"),
+        }
+    }
+}
+
+impl TokenScope {
+    /// Whether the scope covers a request to `/api/project/:owner/:project{rest}` that
+    /// needs the `needed` role
+    pub fn allows(self, needed: Role, rest: &str) -> bool {
+        match self {
+            TokenScope::Read => needed == Role::Viewer,
+            TokenScope::Deploy => needed == Role::Viewer || is_deploy(rest),
+            TokenScope::Admin => true,
+        }
+    }
+}
+
+fn is_deploy(rest: &str) -> bool {
+    let rest = rest.trim_end_matches('/');
+    matches!(
+        rest,
+        "/builds/trigger" | "/builds/image" | "/deploys" | "/canary" | "/canary/promote" | "/canary/abort"
+            | "/pipeline/promote"
+    ) || (rest.starts_with("/releases/") && ["/rollback", "/pin", "/unpin", "/promote"].iter().any(|action| rest.ends_with(action)))
+        || (rest.starts_with("/builds/") && rest.ends_with("/cancel"))
+}
+
+/// A request authenticated with a token instead of a session, kept in the extensions of
+/// the request for [`crate::owner::authorize`]
+#[derive(Debug, Clone)]
+pub struct TokenAccess {
+    pub token_id: Uuid,
+    pub scope: TokenScope,
+    /// the only app the token works for, every app of the user when None
+    pub project_id: Option<Uuid>,
+}
+
+/// A new token and the hash it is stored as. The token itself is only shown once
+pub fn generate_token() -> (String, String) {
+    let mut rng = rand::rngs::StdRng::from_entropy();
+    let token = (0..TOKEN_LENGTH)
+        .map(|_| CHARSET[rng.gen_range(0..CHARSET.len())] as char)
+        .collect::<String>();
+    let token = format!("{TOKEN_PREFIX}{token}");
+    let hash = hash_token(&token);
+    (token, hash)
+}
+
+/// Tokens are long and random, so a plain hash is enough and lets them be looked up by it
+pub fn hash_token(token: &str) -> String {
+    format!("{:x}", Sha256::digest(token.as_bytes()))
+}
+
+/// The token with this value, unless it was revoked or expired. Marks it used
+pub async fn find_token(token: &str, pool: &PgPool) -> Result<Option<(Uuid, TokenAccess)>, sqlx::Error> {
+    let Some(record) = sqlx::query!(
+        r#"UPDATE access_tokens SET last_used_at = now()
+           WHERE token_hash = $1 AND revoked_at IS NULL AND (expires_at IS NULL OR expires_at > now())
+           RETURNING id, user_id, project_id, scope AS "scope: TokenScope"
+        "#,
+        hash_token(token)
+    )
+    .fetch_optional(pool)
+    .await?
+    else {
+        return Ok(None);
+    };
+
+    let access = TokenAccess {
+        token_id: record.id,
+        scope: record.scope,
+        project_id: record.project_id,
+    };
+    Ok(Some((record.user_id, access)))
+}
+
+/// The user and token when a token lets git fetch from, or push to, a repository. Pushing
+/// deploys, so it takes a deploy token and a maintainer
+pub async fn git_access(
+    token: &str,
+    owner: &str,
+    repo: &str,
+    push: bool,
+    pool: &PgPool,
+) -> Result<Option<(Uuid, TokenAccess)>, sqlx::Error> {
+    let Some((user_id, access)) = find_token(token, pool).await? else {
+        return Ok(None);
+    };
+
+    let project = sqlx::query!(
+        r#"SELECT projects.id FROM projects
+           JOIN project_owners ON projects.owner_id = project_owners.id
+           WHERE project_owners.name = $1 AND projects.name = $2
+        "#,
+        owner,
+        repo
+    )
+    .fetch_optional(pool)
+    .await?;
+    let Some(project) = project else {
+        return Ok(None);
+    };
+    if access.project_id.is_some_and(|id| id != project.id) {
+        return Ok(None);
+    }
+
+    let needed = match push {
+        true => Role::Maintainer,
+        false => Role::Viewer,
+    };
+    let scoped = !push || access.scope != TokenScope::Read;
+    let role = member_role(user_id, owner, pool).await?;
+    match scoped && role.is_some_and(|role| role >= needed) {
+        true => Ok(Some((user_id, access))),
+        false => Ok(None),
+    }
+}
+
+#[derive(Serialize, Debug)]
+struct ErrorResponse {
+    message: String,
+}
+
+/// Logs in requests that carry `Authorization: Bearer pmk_...` as the user of the token, for
+/// this request only. Requests to apps are checked against the scope of the token by
+/// [`crate::owner::authorize`]; everything else takes a token for every app, and one with
+/// the admin scope unless it only reads
+pub async fn token_auth<B>(
+    State(AppState { pool, .. }): State<AppState>,
+    mut request: Request<B>,
+    next: Next<B>,
+) -> Result<Response<UnsyncBoxBody<Bytes, axum::Error>>, Response<Body>> {
+    let token = request
+        .headers()
+        .get("Authorization")
+        .and_then(|value| value.to_str().ok())
+        .and_then(|value| value.strip_prefix("Bearer "))
+        .filter(|token| token.starts_with(TOKEN_PREFIX))
+        .map(|token| token.trim().to_string());
+    let Some(token) = token else {
+        return Ok(next.run(request).await);
+    };
+
+    let error = |status: StatusCode, message: &str| {
+        let json = serde_json::to_string(&ErrorResponse { message: message.to_string() }).unwrap();
+        Response::builder().status(status).body(Body::from(json)).unwrap()
+    };
+
+    let (user_id, access) = match find_token(&token, &pool).await {
+        Ok(Some(found)) => found,
+        Ok(None) => return Err(error(StatusCode::UNAUTHORIZED, "Token is invalid, expired or revoked")),
+        Err(err) => {
+            tracing::error!(?err, "Can't authenticate token: Failed to query database");
+            return Err(error(StatusCode::INTERNAL_SERVER_ERROR, "Failed to query database"));
+        }
+    };
+
+    let path = request.uri().path().trim_end_matches('/').to_string();
+    let app_route = path.starts_with("/api/project/") && path != "/api/project/new";
+    let reads = matches!(*request.method(), Method::GET | Method::HEAD);
+    let allowed = app_route
+        || path == "/api/validate"
+        || (access.project_id.is_none() && (reads || access.scope == TokenScope::Admin));
+    if !allowed {
+        return Err(error(
+            StatusCode::FORBIDDEN,
+            &format!("A {} token{} can't do this", access.scope, match access.project_id {
+                Some(_) => " of one app",
+                None => "",
+            }),
+        ));
+    }
+
+    let user = match User::get(&user_id, &pool).await {
+        Ok(user) => user,
+        Err(err) => {
+            tracing::error!(?err, "Can't authenticate token: Failed to query database");
+            return Err(error(StatusCode::INTERNAL_SERVER_ERROR, "Failed to query database"));
+        }
+    };
+
+    // the session layer already ran, handlers read the user from its extension
+    if let Some(auth) = request.extensions_mut().get_mut::<Auth>() {
+        auth.id = user.id;
+        auth.current_user = Some(user);
+    }
+    request.extensions_mut().insert(access);
+
+    Ok(next.run(request).await)
+}
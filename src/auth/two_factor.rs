@@ -0,0 +1,192 @@
+use anyhow::Result;
+use chrono::{DateTime, Duration, Utc};
+use data_encoding::BASE32_NOPAD;
+use hmac::{Hmac, Mac};
+use rand::RngCore;
+use sha1::Sha1;
+use sqlx::PgPool;
+use url::Url;
+use uuid::Uuid;
+
+use crate::auth::tokens::hash_token;
+use crate::secrets::SecretCipher;
+
+/// seconds a code is valid for, what every authenticator app uses
+const STEP: i64 = 30;
+const DIGITS: u32 = 6;
+/// steps before and after now that are accepted too, phones with a clock a bit off still work
+const WINDOW: i64 = 1;
+
+const RECOVERY_CODES: usize = 10;
+
+/// wrong codes in a row before logins of the user stop for [`LOCKOUT_MINUTES`]
+pub const MAX_FAILURES: i32 = 5;
+pub const LOCKOUT_MINUTES: i64 = 15;
+
+/// A new secret for an authenticator app, base32 like apps expect it
+pub fn generate_secret() -> String {
+    let mut secret = [0u8; 20];
+    rand::thread_rng().fill_bytes(&mut secret);
+    BASE32_NOPAD.encode(&secret)
+}
+
+/// What authenticator apps scan from a qr code, or take pasted
+pub fn otpauth_url(issuer: &str, username: &str, secret: &str) -> String {
+    // the label is issuer:account, a port would read as another colon
+    let issuer = issuer.split(':').next().unwrap_or(issuer);
+    let mut url = Url::parse("otpauth://totp/").unwrap();
+    url.set_path(&format!("{issuer}:{username}"));
+    url.query_pairs_mut()
+        .append_pair("secret", secret)
+        .append_pair("issuer", issuer)
+        .append_pair("algorithm", "SHA1")
+        .append_pair("digits", &DIGITS.to_string())
+        .append_pair("period", &STEP.to_string());
+    url.to_string()
+}
+
+/// The code of a time step, RFC 6238 with the defaults of RFC 4226
+fn code_at(secret: &[u8], step: i64) -> u32 {
+    let mut mac = Hmac::<Sha1>::new_from_slice(secret).expect("hmac takes keys of any length");
+    mac.update(&step.to_be_bytes());
+    let hash = mac.finalize().into_bytes();
+
+    let offset = (hash[hash.len() - 1] & 0x0f) as usize;
+    let binary = u32::from_be_bytes([hash[offset], hash[offset + 1], hash[offset + 2], hash[offset + 3]]) & 0x7fff_ffff;
+    binary % 10u32.pow(DIGITS)
+}
+
+/// The time step `code` is the code of, if it is one around `now`
+pub fn verify(secret: &str, code: &str, now: DateTime<Utc>) -> Option<i64> {
+    let code = code.trim();
+    if code.len() != DIGITS as usize || !code.bytes().all(|c| c.is_ascii_digit()) {
+        return None;
+    }
+    let code = code.parse::<u32>().ok()?;
+    let secret = BASE32_NOPAD.decode(secret.as_bytes()).ok()?;
+
+    let current = now.timestamp() / STEP;
+    (current - WINDOW..=current + WINDOW).find(|step| code_at(&secret, *step) == code)
+}
+
+/// Recovery codes to show once and the hashes they are stored as
+pub fn generate_recovery_codes() -> (Vec<String>, Vec<String>) {
+    let codes = (0..RECOVERY_CODES)
+        .map(|_| {
+            let mut code = [0u8; 5];
+            rand::thread_rng().fill_bytes(&mut code);
+            let code = BASE32_NOPAD.encode(&code).to_lowercase();
+            format!("{}-{}", &code[..4], &code[4..])
+        })
+        .collect::<Vec<_>>();
+    let hashes = codes.iter().map(|code| hash_recovery_code(code)).collect();
+    (codes, hashes)
+}
+
+/// Recovery codes are typed in by hand, case and dashes don't matter
+fn hash_recovery_code(code: &str) -> String {
+    let code = code
+        .chars()
+        .filter(|c| c.is_ascii_alphanumeric())
+        .collect::<String>()
+        .to_lowercase();
+    hash_token(&code)
+}
+
+/// What a code entered for a user with two-factor authentication turned on was
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub enum CodeCheck {
+    Accepted,
+    /// a recovery code was used up, the user has this many left
+    RecoveryCode(usize),
+    /// wrong, or a code that was already used
+    Wrong,
+    /// too many wrong codes, no code is checked until then
+    Locked(DateTime<Utc>),
+}
+
+/// Whether the user has confirmed two-factor authentication, logins need a code then
+pub async fn enabled(user_id: Uuid, pool: &PgPool) -> Result<bool, sqlx::Error> {
+    let record = sqlx::query!(
+        r#"SELECT confirmed_at IS NOT NULL AS "enabled!" FROM user_totp WHERE user_id = $1"#,
+        user_id
+    )
+    .fetch_optional(pool)
+    .await?;
+
+    Ok(record.is_some_and(|record| record.enabled))
+}
+
+/// Checks a code of the authenticator app, or a recovery code, of a user with two-factor
+/// authentication turned on. A code is only accepted once, wrong ones count towards the lockout
+pub async fn check_code(user_id: Uuid, code: &str, secrets: &SecretCipher, pool: &PgPool) -> Result<CodeCheck> {
+    let totp = sqlx::query!(
+        r#"SELECT secret, recovery_codes, last_step, locked_until
+           FROM user_totp WHERE user_id = $1 AND confirmed_at IS NOT NULL
+        "#,
+        user_id
+    )
+    .fetch_one(pool)
+    .await?;
+
+    let now = Utc::now();
+    if let Some(locked_until) = totp.locked_until.filter(|locked_until| *locked_until > now) {
+        return Ok(CodeCheck::Locked(locked_until));
+    }
+
+    let secret = secrets.decrypt(&totp.secret)?;
+    if let Some(step) = verify(&secret, code, now).filter(|step| *step > totp.last_step) {
+        // two logins racing with the same code, only one moves the step
+        let accepted = sqlx::query!(
+            r#"UPDATE user_totp SET last_step = $2, failures = 0, locked_until = NULL
+               WHERE user_id = $1 AND last_step < $2
+            "#,
+            user_id,
+            step
+        )
+        .execute(pool)
+        .await?;
+
+        if accepted.rows_affected() == 1 {
+            return Ok(CodeCheck::Accepted);
+        }
+    }
+
+    let hash = hash_recovery_code(code);
+    if totp.recovery_codes.contains(&hash) {
+        let used = sqlx::query!(
+            r#"UPDATE user_totp SET recovery_codes = array_remove(recovery_codes, $2), failures = 0, locked_until = NULL
+               WHERE user_id = $1 AND $2 = ANY(recovery_codes)
+               RETURNING cardinality(recovery_codes) AS "left!"
+            "#,
+            user_id,
+            hash
+        )
+        .fetch_optional(pool)
+        .await?;
+
+        if let Some(used) = used {
+            return Ok(CodeCheck::RecoveryCode(used.left as usize));
+        }
+    }
+
+    let locked_until = now + Duration::minutes(LOCKOUT_MINUTES);
+    let failed = sqlx::query!(
+        r#"UPDATE user_totp
+           SET failures = CASE WHEN failures + 1 >= $2 THEN 0 ELSE failures + 1 END,
+               locked_until = CASE WHEN failures + 1 >= $2 THEN $3 ELSE locked_until END
+           WHERE user_id = $1
+           RETURNING locked_until
+        "#,
+        user_id,
+        MAX_FAILURES,
+        locked_until
+    )
+    .fetch_one(pool)
+    .await?;
+
+    match failed.locked_until {
+        Some(locked_until) if locked_until > now => Ok(CodeCheck::Locked(locked_until)),
+        _ => Ok(CodeCheck::Wrong),
+    }
+}
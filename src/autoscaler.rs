@@ -0,0 +1,234 @@
+use std::collections::{BTreeMap, HashMap};
+use std::time::Duration;
+
+use anyhow::Result;
+use chrono::Utc;
+use sqlx::PgPool;
+use uuid::Uuid;
+
+use crate::activity::record_activity;
+use crate::configuration::ContainerSettings;
+use crate::docker::{database_url, ReleaseConfig};
+use crate::monitoring::{proxy_latency_buckets, quantile};
+use crate::orchestrator;
+use crate::secrets::SecretCipher;
+
+/// changes smaller than this fraction of the target are ignored, so a process sitting right at
+/// its target doesn't flap between two counts
+const TOLERANCE: f64 = 0.1;
+
+/// the latency target is the p95 of proxied requests
+const LATENCY_QUANTILE: f64 = 0.95;
+
+/// Scales autoscaled processes toward their target every metrics interval. Cpu comes
+/// from the samples of the metrics collector, latency from the requests the proxy answered
+/// since the previous tick
+pub async fn autoscaler(pool: PgPool, container_settings: ContainerSettings, secrets: SecretCipher) {
+    // latency buckets of every app at the previous tick, the p95 is taken between ticks
+    let mut previous: HashMap<String, Vec<(f64, u64)>> = HashMap::new();
+
+    // cpu is averaged over a couple of samples so a single spike doesn't scale
+    let window = (container_settings.metricsinterval * 2).max(60) as f64;
+
+    let mut interval = tokio::time::interval(Duration::from_secs(container_settings.metricsinterval));
+    loop {
+        interval.tick().await;
+
+        let scalers = match sqlx::query!(
+            r#"SELECT autoscalers.id, autoscalers.project_id, autoscalers.process,
+               autoscalers.min_count, autoscalers.max_count, autoscalers.metric, autoscalers.target,
+               autoscalers.last_scaled_at, projects.formation,
+               projects.name AS project, project_owners.name AS owner
+               FROM autoscalers
+               JOIN projects ON projects.id = autoscalers.project_id
+               JOIN project_owners ON projects.owner_id = project_owners.id
+               WHERE projects.suspended_at IS NULL
+            "#
+        )
+        .fetch_all(&pool)
+        .await
+        {
+            Ok(scalers) => scalers,
+            Err(err) => {
+                tracing::error!(?err, "Can't get autoscalers: Failed to query database");
+                continue;
+            }
+        };
+
+        // several processes of one app can scale on its latency, they all see the same p95
+        let mut latencies: HashMap<String, Option<f64>> = HashMap::new();
+
+        for scaler in scalers {
+            let repo = scaler.project.trim_end_matches(".git");
+            let container_name = format!("{}-{}", scaler.owner, repo).replace('.', "-");
+
+            let observed = match scaler.metric.as_str() {
+                "cpu" => match sqlx::query!(
+                    r#"SELECT avg(cpu_percent) AS cpu FROM container_metrics
+                       WHERE project_id = $1 AND process = $2
+                       AND recorded_at > now() - make_interval(secs => $3)
+                    "#,
+                    scaler.project_id,
+                    scaler.process,
+                    window
+                )
+                .fetch_one(&pool)
+                .await
+                {
+                    Ok(record) => record.cpu,
+                    Err(err) => {
+                        tracing::error!(?err, "Can't get cpu usage: Failed to query database");
+                        continue;
+                    }
+                },
+                "latency" => *latencies.entry(container_name.clone()).or_insert_with(|| {
+                    let current = proxy_latency_buckets(&container_name);
+                    previous
+                        .insert(container_name.clone(), current.clone())
+                        .and_then(|previous| quantile(LATENCY_QUANTILE, &previous, &current))
+                        .map(|seconds| seconds * 1000.0)
+                }),
+                metric => {
+                    tracing::error!(autoscaler_id = ?scaler.id, "Unknown autoscaler metric {metric}");
+                    continue;
+                }
+            };
+
+            // no samples or no requests since the last tick, nothing to go by
+            let observed = match observed {
+                Some(observed) => observed,
+                None => continue,
+            };
+
+            let formation: BTreeMap<String, i64> =
+                serde_json::from_value(scaler.formation).unwrap_or_default();
+            let current = formation.get(&scaler.process).copied().unwrap_or(1);
+            let desired = desired_count(
+                current,
+                observed,
+                scaler.target,
+                scaler.min_count as i64,
+                scaler.max_count as i64,
+            );
+
+            if desired == current {
+                continue;
+            }
+
+            let cooldown = match desired > current {
+                true => container_settings.scaleupcooldown,
+                false => container_settings.scaledowncooldown,
+            };
+            if let Some(last_scaled_at) = scaler.last_scaled_at {
+                if (Utc::now() - last_scaled_at).num_seconds() < cooldown {
+                    continue;
+                }
+            }
+
+            if let Err(err) = scale_process(
+                scaler.project_id,
+                &container_name,
+                &scaler.process,
+                desired,
+                &pool,
+                &container_settings,
+                &secrets,
+            )
+            .await
+            {
+                tracing::error!(?err, autoscaler_id = ?scaler.id, "Can't autoscale process");
+                continue;
+            }
+
+            if let Err(err) = sqlx::query!(
+                "UPDATE autoscalers SET last_scaled_at = now() WHERE id = $1",
+                scaler.id
+            )
+            .execute(&pool)
+            .await
+            {
+                tracing::error!(?err, "Can't update autoscaler: Failed to query database");
+            }
+
+            let (metric, unit) = match scaler.metric.as_str() {
+                "cpu" => ("cpu", "%"),
+                _ => ("p95 latency", "ms"),
+            };
+            let message = format!(
+                "Autoscaled {} from {current} to {desired}, {metric} at {observed:.0}{unit} against a target of {:.0}{unit}",
+                scaler.process, scaler.target
+            );
+            tracing::info!(project_id = ?scaler.project_id, message);
+
+            if let Err(err) = record_activity(scaler.project_id, "autoscale", &message, &pool).await {
+                tracing::error!(?err, "Can't record activity: Failed to query database");
+            }
+        }
+    }
+}
+
+/// Containers needed to bring `observed` to `target` assuming the load spreads evenly, like
+/// the kubernetes autoscaler. A stopped process starts at `min`
+fn desired_count(current: i64, observed: f64, target: f64, min: i64, max: i64) -> i64 {
+    let ratio = observed / target;
+
+    let desired = match (ratio - 1.0).abs() <= TOLERANCE {
+        true => current,
+        false => (current as f64 * ratio).ceil() as i64,
+    };
+
+    desired.clamp(min, max)
+}
+
+/// Sets the count of a process in the formation and starts or stops its containers
+async fn scale_process(
+    project_id: Uuid,
+    container_name: &str,
+    process: &str,
+    count: i64,
+    pool: &PgPool,
+    container_settings: &ContainerSettings,
+    secrets: &SecretCipher,
+) -> Result<()> {
+    let release = sqlx::query!(
+        r#"SELECT image, config FROM releases WHERE project_id = $1 ORDER BY created_at DESC LIMIT 1"#,
+        project_id
+    )
+    .fetch_optional(pool)
+    .await?
+    .ok_or(anyhow::anyhow!("Project has not been deployed yet"))?;
+
+    let config: ReleaseConfig = serde_json::from_value(release.config)?;
+    if process != "web" && !config.workers.contains_key(process) {
+        return Err(anyhow::anyhow!("Process {process} is not in the live release"));
+    }
+
+    let project = sqlx::query!(
+        r#"UPDATE projects
+            SET formation = jsonb_set(projects.formation, $1, $2, true)
+            WHERE id = $3
+            RETURNING formation
+        "#,
+        &[process.to_string()],
+        serde_json::Value::from(count),
+        project_id
+    )
+    .fetch_one(pool)
+    .await?;
+    let formation = serde_json::from_value(project.formation)?;
+
+    let db_url = database_url(project_id, pool).await?;
+
+    orchestrator::driver()
+        .run_processes(
+            container_name,
+            &release.image,
+            &config,
+            &db_url,
+            &formation,
+            false,
+            container_settings,
+            secrets,
+        )
+        .await
+}
@@ -0,0 +1,317 @@
+use std::path::{Path, PathBuf};
+use std::sync::Arc;
+
+use anyhow::Result;
+use chrono::Utc;
+use s3::{creds::Credentials, Bucket, Region};
+use secrecy::ExposeSecret;
+use sqlx::PgPool;
+use ulid::Ulid;
+use uuid::Uuid;
+
+use crate::configuration::BackupSettings;
+use crate::cron::next_run;
+use crate::docker::{dump_postgres, restore_postgres};
+
+/// Keeps dumps of postgres addons in an s3 compatible bucket, under the name of the addon
+/// container so everything of one database shares a prefix
+#[derive(Clone)]
+pub struct BackupStorage {
+    bucket: Option<Arc<Bucket>>,
+    retention: i64,
+}
+
+impl std::fmt::Debug for BackupStorage {
+    fn fmt(&self, f: &mut std::fmt::Formatter<'_>) -> std::fmt::Result {
+        f.debug_struct("BackupStorage")
+            .field("enabled", &self.enabled())
+            .field("retention", &self.retention)
+            .finish()
+    }
+}
+
+impl BackupStorage {
+    /// Without a bucket backups can't be captured, the databases themselves still work
+    pub fn new(settings: &BackupSettings) -> Result<Self> {
+        let bucket = match &settings.bucket {
+            Some(name) => {
+                let region = Region::Custom {
+                    region: settings.region.clone(),
+                    endpoint: settings.endpoint.clone(),
+                };
+
+                let credentials = Credentials::new(
+                    settings.accesskey.as_deref(),
+                    settings.secretkey.as_ref().map(|key| key.expose_secret().as_str()),
+                    None,
+                    None,
+                    None,
+                )
+                .map_err(|err| anyhow::anyhow!("Invalid backup credentials: {err}"))?;
+
+                let bucket = Bucket::new(name, region, credentials)
+                    .map_err(|err| anyhow::anyhow!("Invalid backup bucket: {err}"))?
+                    .with_path_style();
+
+                Some(Arc::new(bucket))
+            }
+            None => None,
+        };
+
+        Ok(Self {
+            bucket,
+            retention: settings.retention,
+        })
+    }
+
+    pub fn enabled(&self) -> bool {
+        self.bucket.is_some()
+    }
+
+    pub(crate) fn bucket(&self) -> Result<&Bucket> {
+        self.bucket
+            .as_deref()
+            .ok_or(anyhow::anyhow!("No backup bucket configured"))
+    }
+
+    async fn upload(&self, key: &str, path: &Path) -> Result<()> {
+        let mut file = tokio::fs::File::open(path).await?;
+        self.bucket()?.put_object_stream(&mut file, key).await?;
+        Ok(())
+    }
+
+    async fn download(&self, key: &str, path: &Path) -> Result<()> {
+        let mut file = tokio::fs::File::create(path).await?;
+        self.bucket()?.get_object_to_writer(key, &mut file).await?;
+        Ok(())
+    }
+
+    async fn delete(&self, key: &str) -> Result<()> {
+        self.bucket()?.delete_object(key).await?;
+        Ok(())
+    }
+
+    /// Deletes every backup of the postgres addon running in `db_name`
+    pub async fn delete_all(&self, db_name: &str) -> Result<()> {
+        let bucket = self.bucket()?;
+        for page in bucket.list(format!("{db_name}/"), None).await? {
+            for object in page.contents {
+                bucket.delete_object(&object.key).await?;
+            }
+        }
+        Ok(())
+    }
+}
+
+fn backup_key(db_name: &str, backup_id: Uuid) -> String {
+    format!("{db_name}/{backup_id}.dump")
+}
+
+fn temp_path(backup_id: Uuid) -> PathBuf {
+    std::env::temp_dir().join(format!("pemasak-backup-{backup_id}.dump"))
+}
+
+/// Records a new running backup of an addon, `run_backup` does the actual work
+pub async fn start_backup(addon_id: Uuid, manual: bool, pool: &PgPool) -> Result<Uuid> {
+    let backup_id = Uuid::from(Ulid::new());
+
+    sqlx::query!(
+        "INSERT INTO backups (id, addon_id, manual) VALUES ($1, $2, $3)",
+        backup_id,
+        addon_id,
+        manual
+    )
+    .execute(pool)
+    .await?;
+
+    Ok(backup_id)
+}
+
+/// Dumps the database of an addon into the bucket and finishes the backup row. Successful
+/// backups past the retention are deleted afterwards, failed ones are kept until then so
+/// the error can be read
+#[tracing::instrument(skip(pool, storage))]
+pub async fn run_backup(
+    backup_id: Uuid,
+    addon_id: Uuid,
+    db_name: &str,
+    database: &str,
+    pool: &PgPool,
+    storage: &BackupStorage,
+) {
+    let path = temp_path(backup_id);
+
+    let result = async {
+        let size = dump_postgres(db_name, database, &path).await?;
+        storage.upload(&backup_key(db_name, backup_id), &path).await?;
+        Ok::<_, anyhow::Error>(size)
+    }
+    .await;
+
+    if let Err(err) = tokio::fs::remove_file(&path).await {
+        tracing::debug!(?err, "Can't remove backup dump");
+    }
+
+    let (status, size, error) = match result {
+        Ok(size) => ("successful", Some(size as i64), String::new()),
+        Err(err) => {
+            tracing::error!(?err, "Can't capture backup");
+            ("failed", None, format!("{err}"))
+        }
+    };
+
+    if let Err(err) = sqlx::query!(
+        r#"UPDATE backups
+           SET status = $1::text::run_state, size = $2, error = $3, finished_at = now()
+           WHERE id = $4
+        "#,
+        status,
+        size,
+        error,
+        backup_id
+    )
+    .execute(pool)
+    .await
+    {
+        tracing::error!(?err, "Can't finish backup: Failed to query database");
+        return;
+    }
+
+    if status != "successful" {
+        return;
+    }
+
+    let expired = match sqlx::query!(
+        r#"DELETE FROM backups
+           WHERE addon_id = $1 AND status <> 'running' AND id NOT IN (
+             SELECT id FROM backups WHERE addon_id = $1 AND status = 'successful'
+             ORDER BY started_at DESC LIMIT $2
+           )
+           RETURNING id
+        "#,
+        addon_id,
+        storage.retention
+    )
+    .fetch_all(pool)
+    .await
+    {
+        Ok(expired) => expired,
+        Err(err) => {
+            tracing::error!(?err, "Can't prune backups: Failed to query database");
+            return;
+        }
+    };
+
+    for backup in expired {
+        // failed backups never uploaded anything, deleting a missing key is fine
+        if let Err(err) = storage.delete(&backup_key(db_name, backup.id)).await {
+            tracing::error!(?err, backup_id = ?backup.id, "Can't delete expired backup");
+        }
+    }
+}
+
+/// Loads a successful backup into the database of its addon, or into a new `database` next
+/// to it when `create` is set
+#[tracing::instrument(skip(storage))]
+pub async fn restore_backup(
+    backup_id: Uuid,
+    db_name: &str,
+    database: &str,
+    create: bool,
+    storage: &BackupStorage,
+) -> Result<()> {
+    let path = temp_path(backup_id);
+
+    let result = async {
+        storage.download(&backup_key(db_name, backup_id), &path).await?;
+        restore_postgres(db_name, database, &path, create).await
+    }
+    .await;
+
+    if let Err(err) = tokio::fs::remove_file(&path).await {
+        tracing::debug!(?err, "Can't remove backup dump");
+    }
+
+    result
+}
+
+/// Backs up every postgres addon on the configured schedule, one database at a time so the
+/// dumps don't compete for the host
+pub async fn backup_scheduler(pool: PgPool, storage: BackupStorage, schedule: String) {
+    // backups that were going when the platform stopped will never finish
+    if let Err(err) = sqlx::query!(
+        r#"UPDATE backups SET status = 'failed', finished_at = now(), error = $1
+           WHERE status = 'running'
+        "#,
+        "Interrupted by a platform restart"
+    )
+    .execute(&pool)
+    .await
+    {
+        tracing::error!(?err, "Can't clean up backups: Failed to query database");
+    }
+
+    loop {
+        let next = match next_run(&schedule, &Utc::now()) {
+            Ok(next) => next,
+            Err(err) => {
+                tracing::error!(?err, "Can't schedule backups, nightly backups are disabled");
+                return;
+            }
+        };
+
+        let wait = (next - Utc::now()).to_std().unwrap_or_default();
+        tokio::time::sleep(wait).await;
+
+        let addons = match sqlx::query!(
+            r#"SELECT addons.id, addons.name, addons.url FROM addons
+               WHERE addons.kind = 'postgres' AND NOT EXISTS (
+                 SELECT 1 FROM backups WHERE backups.addon_id = addons.id AND backups.status = 'running'
+               )
+            "#
+        )
+        .fetch_all(&pool)
+        .await
+        {
+            Ok(addons) => addons,
+            Err(err) => {
+                tracing::error!(?err, "Can't get addons: Failed to query database");
+                continue;
+            }
+        };
+
+        tracing::info!(count = addons.len(), "Starting nightly backups");
+
+        for addon in addons {
+            let database = match database_name(&addon.url) {
+                Ok(database) => database,
+                Err(err) => {
+                    tracing::error!(?err, addon_id = ?addon.id, "Can't back up addon");
+                    continue;
+                }
+            };
+
+            let backup_id = match start_backup(addon.id, false, &pool).await {
+                Ok(backup_id) => backup_id,
+                Err(err) => {
+                    tracing::error!(?err, "Can't start backup: Failed to query database");
+                    continue;
+                }
+            };
+
+            run_backup(backup_id, addon.id, &addon.name, &database, &pool, &storage).await;
+        }
+    }
+}
+
+/// Database name in the connection url of a postgres addon
+pub fn database_name(url: &str) -> Result<String> {
+    let url = url::Url::parse(url)?;
+    let name = url.path().trim_start_matches('/');
+
+    if name.is_empty() {
+        return Err(anyhow::anyhow!("Database url has no database name"));
+    }
+
+    Ok(name.to_string())
+}
@@ -0,0 +1,287 @@
+use std::collections::HashMap;
+use std::sync::atomic::{AtomicBool, AtomicUsize, Ordering};
+use std::sync::{Arc, RwLock};
+use std::time::Duration;
+
+use data_encoding::HEXLOWER;
+use hyper::header::{HeaderMap, HeaderValue, COOKIE};
+use sha2::{Digest, Sha256};
+use sqlx::PgPool;
+
+use crate::configuration::ContainerSettings;
+use crate::docker::web_replicas;
+use crate::in_flight::draining;
+
+/// how often every web replica is probed
+const PROBE_INTERVAL: Duration = Duration::from_secs(5);
+/// a probe that takes longer than this fails
+const PROBE_TIMEOUT: Duration = Duration::from_secs(2);
+
+/// cookie naming the upstream a client of an app with sticky sessions is kept on
+pub const AFFINITY_COOKIE: &str = "pmk_affinity";
+
+#[derive(Debug)]
+struct Replica {
+    id: String,
+    ip: String,
+    healthy: AtomicBool,
+}
+
+#[derive(Debug, Default)]
+struct AppReplicas {
+    replicas: Vec<Replica>,
+    /// round robin position, shared by every request to the app
+    next: AtomicUsize,
+}
+
+/// The replica a request is sent to, None from `pick` means the app container
+#[derive(Debug, Clone)]
+pub struct Upstream {
+    pub id: String,
+    pub ip: String,
+}
+
+/// Spreads the requests of an app over its app container and the extra web replicas that
+/// passed their last health probe. The app container always takes part, the proxy resolves
+/// it through the domains row so deploys keep swapping it atomically
+#[derive(Debug, Clone, Default)]
+pub struct Balancer {
+    apps: Arc<RwLock<HashMap<String, AppReplicas>>>,
+}
+
+impl Balancer {
+    pub fn new() -> Self {
+        Self::default()
+    }
+
+    /// Next upstream of `app` in round robin order, None for the app container
+    pub fn pick(&self, app: &str) -> Option<Upstream> {
+        let apps = self.apps.read().unwrap();
+        let app = apps.get(app)?;
+
+        let healthy = app
+            .replicas
+            .iter()
+            .filter(|replica| replica.healthy.load(Ordering::Relaxed) && !draining(&replica.id))
+            .collect::<Vec<_>>();
+
+        match app.next.fetch_add(1, Ordering::Relaxed) % (healthy.len() + 1) {
+            0 => None,
+            index => Some(Upstream {
+                id: healthy[index - 1].id.clone(),
+                ip: healthy[index - 1].ip.clone(),
+            }),
+        }
+    }
+
+    /// Takes a replica out of rotation after a failed request, until it passes a probe again
+    pub fn mark_unhealthy(&self, app: &str, id: &str) {
+        let apps = self.apps.read().unwrap();
+        let replica = apps
+            .get(app)
+            .and_then(|app| app.replicas.iter().find(|replica| replica.id == id));
+
+        if let Some(replica) = replica {
+            if replica.healthy.swap(false, Ordering::Relaxed) {
+                tracing::warn!(app, replica = id, "Web replica failed a request, taking it out of rotation");
+            }
+        }
+    }
+
+    /// Like [`Balancer::pick`] for apps with sticky sessions. `pinned` is the affinity cookie
+    /// of the client, it stays on that upstream while it is healthy. A client that wasn't
+    /// pinned yet or whose upstream went away is picked a new one, the second value is the
+    /// cookie to pin it there. Apps without replicas pin nobody
+    pub fn pick_pinned(&self, app: &str, app_container: &str, pinned: Option<&str>) -> (Option<Upstream>, Option<String>) {
+        {
+            let apps = self.apps.read().unwrap();
+            let Some(replicas) = apps.get(app).filter(|replicas| !replicas.replicas.is_empty()) else {
+                return (None, None);
+            };
+
+            if let Some(pinned) = pinned {
+                if pinned == affinity(app_container) {
+                    return (None, None);
+                }
+                let replica = replicas
+                    .replicas
+                    .iter()
+                    .find(|replica| {
+                        replica.healthy.load(Ordering::Relaxed) && !draining(&replica.id) && affinity(&replica.id) == pinned
+                    });
+                if let Some(replica) = replica {
+                    let upstream = Upstream {
+                        id: replica.id.clone(),
+                        ip: replica.ip.clone(),
+                    };
+                    return (Some(upstream), None);
+                }
+            }
+        }
+
+        let upstream = self.pick(app);
+        let cookie = match &upstream {
+            Some(replica) => affinity(&replica.id),
+            None => affinity(app_container),
+        };
+        (upstream, Some(cookie))
+    }
+
+    fn healthy(&self, app: &str, id: &str) -> Option<bool> {
+        let apps = self.apps.read().unwrap();
+        let replica = apps.get(app)?.replicas.iter().find(|replica| replica.id == id)?;
+        Some(replica.healthy.load(Ordering::Relaxed))
+    }
+}
+
+/// What the affinity cookie holds for a container, so clients don't learn its id. A deploy
+/// replaces the containers, which moves their clients along like a replica that died
+fn affinity(id: &str) -> String {
+    HEXLOWER.encode(&Sha256::digest(id.as_bytes())[..8])
+}
+
+/// The affinity cookie the client sent
+pub fn affinity_cookie(headers: &HeaderMap) -> Option<String> {
+    headers
+        .get_all(COOKIE)
+        .iter()
+        .filter_map(|value| value.to_str().ok())
+        .flat_map(|value| value.split(';'))
+        .filter_map(|pair| pair.trim().split_once('='))
+        .find(|(name, _)| *name == AFFINITY_COOKIE)
+        .map(|(_, value)| value.to_string())
+}
+
+/// Takes the affinity cookie out of the request, the app has no use for it
+pub fn strip_affinity_cookie(headers: &mut HeaderMap) {
+    let cookies = headers
+        .get_all(COOKIE)
+        .iter()
+        .filter_map(|value| value.to_str().ok())
+        .flat_map(|value| value.split(';'))
+        .map(str::trim)
+        .filter(|pair| !pair.is_empty() && pair.split_once('=').map(|(name, _)| name) != Some(AFFINITY_COOKIE))
+        .collect::<Vec<_>>()
+        .join("; ");
+
+    headers.remove(COOKIE);
+    if let Ok(value) = HeaderValue::from_str(&cookies) {
+        if !cookies.is_empty() {
+            headers.insert(COOKIE, value);
+        }
+    }
+}
+
+/// Set-Cookie pinning a client to the upstream `value` names. It only lasts the browser
+/// session, like the in-memory sessions it is there for
+pub fn affinity_set_cookie(value: &str) -> HeaderValue {
+    HeaderValue::from_str(&format!("{AFFINITY_COOKIE}={value}; Path=/; HttpOnly; SameSite=Lax")).unwrap()
+}
+
+/// Probes the extra web replicas of every app and swaps the result into the balancer. A
+/// replica gets traffic once it answers the healthcheck path of its project, or anything at
+/// all without one, like the readiness probe of a deploy
+pub async fn health_checker(pool: PgPool, balancer: Balancer, container_settings: ContainerSettings) {
+    let builder = || {
+        reqwest::Client::builder()
+            .timeout(PROBE_TIMEOUT)
+            .redirect(reqwest::redirect::Policy::none())
+    };
+    let (client, h2c_client) = match (builder().build(), builder().http2_prior_knowledge().build()) {
+        (Ok(client), Ok(h2c_client)) => (client, h2c_client),
+        (Err(err), _) | (_, Err(err)) => {
+            tracing::error!(?err, "Can't check web replicas: Failed to build http client");
+            return;
+        }
+    };
+
+    let mut interval = tokio::time::interval(PROBE_INTERVAL);
+    loop {
+        interval.tick().await;
+
+        let apps = match sqlx::query!(
+            r#"SELECT domains.name, projects.healthcheck_path, projects.protocol
+               FROM domains
+               JOIN projects ON projects.id = domains.project_id
+            "#
+        )
+        .fetch_all(&pool)
+        .await
+        {
+            Ok(apps) => apps,
+            Err(err) => {
+                tracing::error!(?err, "Can't check web replicas: Failed to query database");
+                continue;
+            }
+        };
+
+        let mut probed = HashMap::new();
+        for app in apps {
+            let replicas = match web_replicas(&app.name).await {
+                Ok(replicas) => replicas,
+                Err(err) => {
+                    tracing::error!(?err, "Can't check web replicas: Failed to list containers");
+                    continue;
+                }
+            };
+
+            if replicas.is_empty() {
+                continue;
+            }
+
+            let client = match app.protocol.as_str() {
+                "h2c" => &h2c_client,
+                _ => &client,
+            };
+            let probes = replicas.iter().map(|replica| {
+                probe(client, &replica.ip, container_settings.port, app.healthcheck_path.as_deref())
+            });
+            let results = futures::future::join_all(probes).await;
+
+            let replicas = replicas
+                .into_iter()
+                .zip(results)
+                .map(|(replica, healthy)| {
+                    match (balancer.healthy(&app.name, &replica.id), healthy) {
+                        (Some(true), false) => {
+                            tracing::warn!(app = %app.name, replica = %replica.id, "Web replica failed its health probe")
+                        }
+                        (Some(false), true) => {
+                            tracing::info!(app = %app.name, replica = %replica.id, "Web replica is healthy again")
+                        }
+                        _ => {}
+                    }
+
+                    Replica {
+                        id: replica.id,
+                        ip: replica.ip,
+                        healthy: AtomicBool::new(healthy),
+                    }
+                })
+                .collect();
+
+            probed.insert(
+                app.name,
+                AppReplicas {
+                    replicas,
+                    next: AtomicUsize::new(0),
+                },
+            );
+        }
+
+        *balancer.apps.write().unwrap() = probed;
+    }
+}
+
+async fn probe(client: &reqwest::Client, ip: &str, port: i32, healthcheck_path: Option<&str>) -> bool {
+    let host = match ip.contains(':') {
+        true => format!("[{ip}]"),
+        false => ip.to_string(),
+    };
+    let url = format!("http://{host}:{port}{}", healthcheck_path.unwrap_or("/"));
+
+    match client.get(&url).send().await {
+        Ok(res) => healthcheck_path.is_none() || res.status().is_success(),
+        Err(_) => false,
+    }
+}
@@ -0,0 +1,110 @@
+use std::collections::HashMap;
+use std::sync::{Arc, Mutex};
+use std::time::Duration;
+
+use argon2::{Argon2, PasswordHash, PasswordVerifier};
+use data_encoding::BASE64;
+use hyper::header::{HeaderMap, AUTHORIZATION};
+use hyper::{Body, Response, StatusCode};
+use sha2::{Digest, Sha256};
+use tokio::time::Instant;
+
+/// how long a checked login is let through without hashing the password again
+const REMEMBERED: Duration = Duration::from_secs(600);
+
+/// logins remembered before the expired ones are dropped
+const MAX_REMEMBERED: usize = 10_000;
+
+/// The login visitors need before the proxy lets them through to an app, set with
+/// `pmk basic-auth on`
+#[derive(Debug, Clone)]
+pub struct BasicAuth {
+    pub username: String,
+    /// argon2 hash of the password
+    pub password_hash: String,
+}
+
+/// Logins that were checked lately. Hashing a password takes long enough to be felt on every
+/// asset of a page, so a login that matched is remembered for [`REMEMBERED`]. Only hashes are
+/// kept, a changed password doesn't match the remembered ones
+#[derive(Debug, Clone, Default)]
+pub struct BasicAuthCache {
+    /// by sha256 of the password hash and the Authorization header
+    logins: Arc<Mutex<HashMap<[u8; 32], Instant>>>,
+}
+
+impl BasicAuthCache {
+    /// Whether the Authorization header in `headers` has the login of `auth`
+    pub async fn check(&self, auth: &BasicAuth, headers: &HeaderMap) -> bool {
+        let Some(header) = headers.get(AUTHORIZATION).and_then(|value| value.to_str().ok()) else {
+            return false;
+        };
+
+        let key: [u8; 32] = Sha256::new()
+            .chain_update(auth.password_hash.as_bytes())
+            .chain_update(b"\n")
+            .chain_update(header.as_bytes())
+            .finalize()
+            .into();
+        let now = Instant::now();
+        if self
+            .logins
+            .lock()
+            .unwrap()
+            .get(&key)
+            .is_some_and(|checked| now.duration_since(*checked) < REMEMBERED)
+        {
+            return true;
+        }
+
+        let Some((username, password)) = credentials(header) else {
+            return false;
+        };
+        if username != auth.username {
+            return false;
+        }
+
+        // hashing blocks for a while, the other requests keep going meanwhile
+        let password_hash = auth.password_hash.clone();
+        let matched = tokio::task::spawn_blocking(move || {
+            PasswordHash::new(&password_hash)
+                .and_then(|hash| Argon2::default().verify_password(password.as_bytes(), &hash))
+                .is_ok()
+        })
+        .await
+        .unwrap_or(false);
+
+        if matched {
+            let mut logins = self.logins.lock().unwrap();
+            if logins.len() > MAX_REMEMBERED {
+                logins.retain(|_, checked| now.duration_since(*checked) < REMEMBERED);
+            }
+            logins.insert(key, now);
+        }
+        matched
+    }
+}
+
+/// The username and password of a `Basic` Authorization header
+fn credentials(header: &str) -> Option<(String, String)> {
+    let (scheme, encoded) = header.trim().split_once(' ')?;
+    if !scheme.eq_ignore_ascii_case("basic") {
+        return None;
+    }
+    let decoded = BASE64.decode(encoded.trim().as_bytes()).ok()?;
+    let decoded = String::from_utf8(decoded).ok()?;
+    let (username, password) = decoded.split_once(':')?;
+    Some((username.to_string(), password.to_string()))
+}
+
+/// What visitors without the login get, browsers ask for it
+pub fn challenge(app: &str) -> Response<Body> {
+    Response::builder()
+        .status(StatusCode::UNAUTHORIZED)
+        .header("WWW-Authenticate", format!("Basic realm=\"{app}\", charset=\"UTF-8\""))
+        .header("Content-Type", "text/plain; charset=utf-8")
+        .header("X-Robots-Tag", "noindex, nofollow")
+        .header("Cache-Control", "no-store")
+        .body(Body::from("This app needs a login"))
+        .unwrap()
+}
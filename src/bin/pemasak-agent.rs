@@ -0,0 +1,21 @@
+//! Runs on every node besides the host of the platform, see pemasak_infra::agent
+
+use pemasak_infra::{agent, configuration, telemetry};
+use std::process;
+
+#[tokio::main]
+async fn main() {
+    telemetry::init_tracing();
+    let settings = match configuration::get_agent_configuration() {
+        Ok(settings) => settings,
+        Err(err) => {
+            tracing::error!(?err, "Failed to read agent configuration");
+            process::exit(1);
+        }
+    };
+
+    if let Err(err) = agent::run(settings).await {
+        tracing::error!(?err, "Failed to run agent");
+        process::exit(1);
+    }
+}
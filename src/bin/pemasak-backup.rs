@@ -0,0 +1,122 @@
+//! Takes and restores snapshots of the whole platform, see pemasak_infra::snapshots. Reads the
+//! configuration of the platform, run it where the platform runs
+
+use clap::{Arg, ArgAction, Command};
+use pemasak_infra::{backups::BackupStorage, configuration, snapshots, telemetry};
+use std::process;
+
+fn cli() -> Command {
+    Command::new("pemasak-backup")
+        .about("Snapshots of the platform in the backup bucket")
+        .subcommand_required(true)
+        .subcommand(
+            Command::new("create").about("Take a snapshot").arg(
+                Arg::new("skip-images")
+                    .long("skip-images")
+                    .action(ArgAction::SetTrue)
+                    .help("Leave out the registry, apps are built again after a restore"),
+            ),
+        )
+        .subcommand(Command::new("list").about("List the snapshots, the newest first"))
+        .subcommand(
+            Command::new("restore")
+                .about("Restore a snapshot onto this host, with the platform stopped")
+                .arg(Arg::new("id").required(true))
+                .arg(
+                    Arg::new("yes")
+                        .long("yes")
+                        .action(ArgAction::SetTrue)
+                        .help("Replace the database, repos and volumes of this host"),
+                ),
+        )
+        .subcommand(Command::new("prune").about("Delete snapshots past backup.platformretention"))
+}
+
+fn size(bytes: u64) -> String {
+    byte_unit::Byte::from_bytes(bytes as u128)
+        .get_appropriate_unit(true)
+        .to_string()
+}
+
+#[tokio::main]
+async fn main() {
+    telemetry::init_tracing();
+    let matches = cli().get_matches();
+
+    let settings = match configuration::get_configuration() {
+        Ok(settings) => settings,
+        Err(err) => {
+            tracing::error!(?err, "Failed to read configuration");
+            process::exit(1);
+        }
+    };
+    let storage = match BackupStorage::new(&settings.backup) {
+        Ok(storage) if storage.enabled() => storage,
+        Ok(_) => {
+            tracing::error!("No backup bucket configured, set backup.bucket");
+            process::exit(1);
+        }
+        Err(err) => {
+            tracing::error!(?err, "Failed to read backup settings");
+            process::exit(1);
+        }
+    };
+
+    let result = match matches.subcommand() {
+        Some(("create", args)) => snapshots::create_snapshot(&settings, &storage, !args.get_flag("skip-images"))
+            .await
+            .map(|manifest| {
+                println!("Took snapshot {} with {} parts", manifest.id, manifest.parts.len());
+                for error in manifest.errors {
+                    println!("  missing {error}");
+                }
+            }),
+        Some(("list", _)) => snapshots::list_snapshots(&storage).await.map(|list| {
+            println!("{:<28} {:<26} {:>10}  PARTS", "ID", "CREATED", "SIZE");
+            for snapshot in list {
+                match snapshot.manifest {
+                    Some(manifest) => println!(
+                        "{:<28} {:<26} {:>10}  {}{}",
+                        snapshot.id,
+                        manifest.created_at.format("%Y-%m-%d %H:%M:%S UTC"),
+                        size(snapshot.size),
+                        manifest.parts.len(),
+                        match manifest.errors.len() {
+                            0 => String::new(),
+                            errors => format!(", {errors} missing"),
+                        }
+                    ),
+                    None => println!("{:<28} {:<26} {:>10}  unfinished", snapshot.id, "-", size(snapshot.size)),
+                }
+            }
+        }),
+        Some(("restore", args)) => {
+            let id = args.get_one::<String>("id").expect("id is required");
+            if !args.get_flag("yes") {
+                eprintln!(
+                    "Restoring {id} replaces the database {} on {}, unpacks the repos into {} and overwrites the volumes it has. Stop the platform and run it again with --yes",
+                    settings.database.name, settings.database.host, settings.git.base
+                );
+                process::exit(1);
+            }
+            snapshots::restore_snapshot(&settings, &storage, id).await.map(|manifest| {
+                println!(
+                    "Restored snapshot {} from {}, start the platform to deploy the apps again",
+                    manifest.id, manifest.created_at
+                );
+                for error in manifest.errors {
+                    println!("  it was taken without {error}");
+                }
+            })
+        }
+        Some(("prune", _)) => snapshots::prune_snapshots(&storage, settings.backup.platformretention)
+            .await
+            .map(|deleted| println!("Deleted {} snapshots", deleted.len())),
+        _ => unreachable!("a subcommand is required"),
+    };
+
+    if let Err(err) = result {
+        tracing::error!(?err, "Failed to run pemasak-backup");
+        process::exit(1);
+    }
+}
@@ -0,0 +1,303 @@
+use std::fmt;
+use std::fs;
+use std::path::Path;
+
+use anyhow::{anyhow, Result};
+use serde::Deserialize;
+
+/// Languages built with a generated Dockerfile when the source has none. Anything else is
+/// left to nixpacks
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub enum Buildpack {
+    Go,
+    Rust,
+    Node,
+    Python,
+}
+
+impl fmt::Display for Buildpack {
+    fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+        let name = match self {
+            Buildpack::Go => "Go",
+            Buildpack::Rust => "Rust",
+            Buildpack::Node => "Node.js",
+            Buildpack::Python => "Python",
+        };
+        write!(f, "{name}")
+    }
+}
+
+impl Buildpack {
+    /// Picks the buildpack from the files at the root of the source. Compiled languages go
+    /// first, their repos often carry a package.json for frontend tooling
+    pub fn detect(src: &Path) -> Option<Self> {
+        [
+            ("go.mod", Buildpack::Go),
+            ("Cargo.toml", Buildpack::Rust),
+            ("package.json", Buildpack::Node),
+            ("requirements.txt", Buildpack::Python),
+        ]
+        .into_iter()
+        .find(|(file, _)| src.join(file).is_file())
+        .map(|(_, buildpack)| buildpack)
+    }
+
+    /// Dockerfile that builds the source of the app `app` and runs it. Compiled languages
+    /// build in a full toolchain image and run from a slim one. A web process in the
+    /// Procfile replaces the command, the same as with a Dockerfile of the source
+    pub fn dockerfile(&self, src: &Path, app: &str) -> Result<String> {
+        match self {
+            Buildpack::Go => go(src, app),
+            Buildpack::Rust => rust(src),
+            Buildpack::Node => node(src),
+            Buildpack::Python => python(src),
+        }
+    }
+}
+
+/// Modules and the build cache live in buildkit cache mounts that outlast the build, so a
+/// push only downloads and compiles what changed. They are kept per app, go trusts what is
+/// in them and one app could otherwise plant code in the builds of another
+fn go(src: &Path, app: &str) -> Result<String> {
+    let go_mod = fs::read_to_string(src.join("go.mod"))?;
+
+    // `go 1.21.0` builds with golang:1.21, toolchains of the same minor are compatible
+    let version = go_mod
+        .lines()
+        .find_map(|line| line.trim().strip_prefix("go "))
+        .map(|version| version.split('.').take(2).collect::<Vec<_>>().join("."))
+        .unwrap_or_else(|| "1".to_string());
+
+    // a main package at the root, or the only command under cmd/
+    let package = match has_main_package(src) {
+        true => ".".to_string(),
+        false => match only_dir(&src.join("cmd")) {
+            Some(dir) => format!("./cmd/{dir}"),
+            None => {
+                return Err(anyhow!(
+                    "No main package in the root or a single cmd/ directory, add a Dockerfile"
+                ))
+            }
+        },
+    };
+
+    Ok(format!(
+        r#"# syntax=docker/dockerfile:1
+FROM golang:{version}-alpine AS build
+WORKDIR /src
+COPY go.mod go.sum* ./
+RUN --mount=type=cache,id={app}-gomod,target=/go/pkg/mod \
+    go mod download
+COPY . .
+RUN --mount=type=cache,id={app}-gomod,target=/go/pkg/mod \
+    --mount=type=cache,id={app}-gobuild,target=/root/.cache/go-build \
+    CGO_ENABLED=0 go build -trimpath -ldflags="-s -w" -o /out/app {package}
+
+FROM alpine:3.18
+RUN apk add --no-cache ca-certificates tzdata
+WORKDIR /app
+COPY --from=build /out/app /app/app
+CMD ["/app/app"]
+"#
+    ))
+}
+
+fn has_main_package(src: &Path) -> bool {
+    let Ok(entries) = fs::read_dir(src) else {
+        return false;
+    };
+
+    entries.flatten().any(|entry| {
+        let path = entry.path();
+        path.extension().is_some_and(|ext| ext == "go")
+            && !path.to_string_lossy().ends_with("_test.go")
+            && fs::read_to_string(&path)
+                .is_ok_and(|code| code.lines().any(|line| line.trim() == "package main"))
+    })
+}
+
+fn only_dir(dir: &Path) -> Option<String> {
+    let dirs = fs::read_dir(dir)
+        .ok()?
+        .flatten()
+        .filter(|entry| entry.file_type().is_ok_and(|kind| kind.is_dir()))
+        .collect::<Vec<_>>();
+
+    match dirs.as_slice() {
+        [dir] => dir.file_name().into_string().ok(),
+        _ => None,
+    }
+}
+
+#[derive(Deserialize, Debug)]
+struct CargoManifest {
+    package: Option<CargoPackage>,
+    #[serde(default)]
+    bin: Vec<CargoPackage>,
+}
+
+#[derive(Deserialize, Debug)]
+struct CargoPackage {
+    name: String,
+}
+
+fn rust(src: &Path) -> Result<String> {
+    let manifest: CargoManifest = toml::from_str(&fs::read_to_string(src.join("Cargo.toml"))?)
+        .map_err(|err| anyhow!("Failed to parse Cargo.toml: {err}"))?;
+
+    // the first [[bin]] is what `cargo run` would pick when there is more than one
+    let binary = manifest
+        .bin
+        .first()
+        .or(manifest.package.as_ref())
+        .map(|package| package.name.clone())
+        .ok_or_else(|| anyhow!("Cargo.toml has no package, workspaces need a Dockerfile"))?;
+
+    // a pinned toolchain like `1.72` or `1.72.0` is honored, channels like nightly aren't
+    let version = fs::read_to_string(src.join("rust-toolchain"))
+        .ok()
+        .map(|toolchain| toolchain.trim().to_string())
+        .filter(|toolchain| toolchain.chars().all(|c| c.is_ascii_digit() || c == '.'))
+        .unwrap_or_else(|| "1".to_string());
+
+    Ok(format!(
+        r#"FROM rust:{version}-slim-bookworm AS build
+RUN apt-get update && apt-get install -y --no-install-recommends pkg-config libssl-dev && rm -rf /var/lib/apt/lists/*
+WORKDIR /src
+COPY . .
+RUN cargo build --release --bin {binary} && cp target/release/{binary} /out-app
+
+FROM debian:bookworm-slim
+RUN apt-get update && apt-get install -y --no-install-recommends ca-certificates libssl3 && rm -rf /var/lib/apt/lists/*
+WORKDIR /app
+COPY --from=build /out-app /app/{binary}
+CMD ["/app/{binary}"]
+"#
+    ))
+}
+
+#[derive(Deserialize, Debug, Default)]
+struct PackageJson {
+    main: Option<String>,
+    #[serde(default)]
+    scripts: std::collections::HashMap<String, String>,
+    engines: Option<Engines>,
+}
+
+#[derive(Deserialize, Debug)]
+struct Engines {
+    node: Option<String>,
+}
+
+fn node(src: &Path) -> Result<String> {
+    let package: PackageJson = serde_json::from_str(&fs::read_to_string(src.join("package.json"))?)
+        .map_err(|err| anyhow!("Failed to parse package.json: {err}"))?;
+
+    // the first major version in engines.node, like 18 in `>=18.0.0`
+    let version = package
+        .engines
+        .and_then(|engines| engines.node)
+        .and_then(|range| {
+            range
+                .split(|c: char| !c.is_ascii_digit())
+                .find(|part| !part.is_empty())
+                .map(|major| major.to_string())
+        })
+        .unwrap_or_else(|| "20".to_string());
+
+    let (install, run) = if src.join("pnpm-lock.yaml").is_file() {
+        ("corepack enable && pnpm install --frozen-lockfile", "pnpm")
+    } else if src.join("yarn.lock").is_file() {
+        ("yarn install --frozen-lockfile", "yarn")
+    } else if src.join("package-lock.json").is_file() {
+        ("npm ci", "npm")
+    } else {
+        ("npm install", "npm")
+    };
+
+    let build = match package.scripts.contains_key("build") {
+        true => format!("RUN {run} run build\n"),
+        false => String::new(),
+    };
+
+    let cmd = match (package.scripts.contains_key("start"), package.main) {
+        (true, _) => format!(r#"["{run}", "start"]"#),
+        (false, Some(main)) => format!(r#"["node", "{main}"]"#),
+        (false, None) => r#"["node", "index.js"]"#.to_string(),
+    };
+
+    // dev dependencies stay installed, build tools are often only listed there and
+    // start scripts may use them too
+    Ok(format!(
+        r#"FROM node:{version}-alpine
+WORKDIR /app
+COPY . .
+RUN {install}
+{build}ENV NODE_ENV=production
+CMD {cmd}
+"#
+    ))
+}
+
+fn python(src: &Path) -> Result<String> {
+    // .python-version like pyenv writes it, or runtime.txt like `python-3.11.4`
+    let version = fs::read_to_string(src.join(".python-version"))
+        .or_else(|_| fs::read_to_string(src.join("runtime.txt")))
+        .ok()
+        .map(|version| version.trim().trim_start_matches("python-").to_string())
+        .map(|version| version.split('.').take(2).collect::<Vec<_>>().join("."))
+        .filter(|version| !version.is_empty() && version.chars().all(|c| c.is_ascii_digit() || c == '.'))
+        .unwrap_or_else(|| "3.11".to_string());
+
+    let requirements = fs::read_to_string(src.join("requirements.txt"))?.to_lowercase();
+    // the name before any version specifier or extras, like gunicorn in `gunicorn==21.2`
+    let uses = |package: &str| {
+        requirements.lines().any(|line| {
+            line.trim()
+                .split(|c: char| !(c.is_alphanumeric() || c == '-' || c == '_'))
+                .next()
+                == Some(package)
+        })
+    };
+
+    // $PORT needs a shell to expand
+    let cmd = if src.join("manage.py").is_file() {
+        match (uses("gunicorn"), django_project(src)) {
+            (true, Some(project)) => format!("gunicorn {project}.wsgi --bind 0.0.0.0:$PORT"),
+            _ => "python manage.py runserver 0.0.0.0:$PORT".to_string(),
+        }
+    } else if let Some(module) = ["main.py", "app.py"].into_iter().find(|file| src.join(file).is_file()) {
+        let module = module.trim_end_matches(".py");
+        if uses("uvicorn") {
+            format!("uvicorn {module}:app --host 0.0.0.0 --port $PORT")
+        } else if uses("gunicorn") {
+            format!("gunicorn {module}:app --bind 0.0.0.0:$PORT")
+        } else {
+            format!("python {module}.py")
+        }
+    } else {
+        return Err(anyhow!(
+            "No manage.py, main.py or app.py to start, add a web process to the Procfile"
+        ));
+    };
+
+    Ok(format!(
+        r#"FROM python:{version}-slim
+ENV PYTHONDONTWRITEBYTECODE=1 PYTHONUNBUFFERED=1
+WORKDIR /app
+COPY requirements.txt .
+RUN pip install --no-cache-dir -r requirements.txt
+COPY . .
+CMD ["sh", "-c", "{cmd}"]
+"#
+    ))
+}
+
+/// the directory next to manage.py with the wsgi.py django generated
+fn django_project(src: &Path) -> Option<String> {
+    fs::read_dir(src)
+        .ok()?
+        .flatten()
+        .find(|entry| entry.path().join("wsgi.py").is_file())
+        .and_then(|entry| entry.file_name().into_string().ok())
+}
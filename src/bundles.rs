@@ -0,0 +1,261 @@
+use std::collections::BTreeMap;
+
+use anyhow::{anyhow, Result};
+use chrono::{DateTime, Utc};
+use serde::{Deserialize, Serialize};
+use sqlx::PgPool;
+use ulid::Ulid;
+use uuid::Uuid;
+
+use crate::configuration::ContainerSettings;
+use crate::header_rules::HeaderRules;
+use crate::manifest::{Manifest, MAX_SCALE};
+
+/// Bumped when a field of [`Bundle`] changes meaning, older platforms refuse newer bundles
+pub const BUNDLE_VERSION: u32 = 1;
+
+/// The definition of an app without its data, to recreate it on another platform: what its
+/// pemasak.toml would say, the names of its variables, its domains and its autoscalers.
+/// Values of variables never go in, a bundle is meant to be kept and passed around
+#[derive(Serialize, Deserialize, Debug)]
+#[serde(deny_unknown_fields)]
+pub struct Bundle {
+    pub version: u32,
+    /// owner/project it was exported from, for whoever reads it
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub app: Option<String>,
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub exported_at: Option<DateTime<Utc>>,
+    /// health check, header rules, addons and the formation. Processes and services come
+    /// with the code
+    #[serde(default)]
+    pub manifest: Manifest,
+    /// names of the plain variables and of the secrets, they have to be set again
+    #[serde(default)]
+    pub env: Vec<String>,
+    #[serde(default)]
+    pub secrets: Vec<String>,
+    /// custom domains, verified again once they point at the new platform
+    #[serde(default)]
+    pub domains: Vec<String>,
+    #[serde(default)]
+    pub autoscalers: Vec<BundleAutoscaler>,
+}
+
+#[derive(Serialize, Deserialize, Debug)]
+#[serde(deny_unknown_fields)]
+pub struct BundleAutoscaler {
+    pub process: String,
+    pub min: i32,
+    pub max: i32,
+    /// cpu or latency
+    pub metric: String,
+    pub target: f64,
+}
+
+/// What [`import`] did to the app
+#[derive(Serialize, Debug, Default)]
+pub struct ImportReport {
+    /// what the manifest changed, like the build log says it
+    pub changes: Vec<String>,
+    pub domains: Vec<String>,
+    /// domains another app of the platform already has
+    pub skipped_domains: Vec<String>,
+    /// variables of the bundle the app doesn't have yet
+    pub missing_env: Vec<String>,
+    pub missing_secrets: Vec<String>,
+}
+
+/// The bundle of an app, see [`Bundle`]
+pub async fn export(project_id: Uuid, app: &str, pool: &PgPool) -> Result<Bundle> {
+    let project = sqlx::query!(
+        r#"SELECT healthcheck_path, formation, header_rules, environs, secrets
+           FROM projects
+           WHERE id = $1
+        "#,
+        project_id
+    )
+    .fetch_one(pool)
+    .await?;
+
+    let addons = sqlx::query!(
+        r#"SELECT kind::text AS "kind!" FROM addons WHERE project_id = $1 ORDER BY created_at"#,
+        project_id
+    )
+    .fetch_all(pool)
+    .await?;
+
+    let domains = sqlx::query!(
+        "SELECT name FROM custom_domains WHERE project_id = $1 ORDER BY name",
+        project_id
+    )
+    .fetch_all(pool)
+    .await?;
+
+    let autoscalers = sqlx::query!(
+        r#"SELECT process, min_count, max_count, metric, target
+           FROM autoscalers
+           WHERE project_id = $1
+           ORDER BY process
+        "#,
+        project_id
+    )
+    .fetch_all(pool)
+    .await?;
+
+    let headers: HeaderRules = serde_json::from_value(project.header_rules).unwrap_or_default();
+    let names = |value: &serde_json::Value| {
+        value
+            .as_object()
+            .map(|vars| vars.keys().cloned().collect::<Vec<_>>())
+            .unwrap_or_default()
+    };
+
+    Ok(Bundle {
+        version: BUNDLE_VERSION,
+        app: Some(app.to_string()),
+        exported_at: Some(Utc::now()),
+        manifest: Manifest {
+            healthcheck: project.healthcheck_path,
+            addons: addons.into_iter().map(|addon| addon.kind).collect(),
+            scale: serde_json::from_value::<BTreeMap<String, i64>>(project.formation).unwrap_or_default(),
+            headers: (!headers.is_empty()).then_some(headers),
+            ..Default::default()
+        },
+        env: names(&project.environs),
+        secrets: names(&project.secrets),
+        domains: domains.into_iter().map(|domain| domain.name).collect(),
+        autoscalers: autoscalers
+            .into_iter()
+            .map(|autoscaler| BundleAutoscaler {
+                process: autoscaler.process,
+                min: autoscaler.min_count,
+                max: autoscaler.max_count,
+                metric: autoscaler.metric,
+                target: autoscaler.target,
+            })
+            .collect(),
+    })
+}
+
+impl Bundle {
+    /// Everything [`import`] can check before changing anything. Domains are checked by the
+    /// handler, the same way adding one is
+    pub fn validate(&self) -> Result<()> {
+        if self.version > BUNDLE_VERSION {
+            return Err(anyhow!(
+                "Bundle version {} is newer than this platform reads, export it from one as new",
+                self.version
+            ));
+        }
+        if !self.manifest.processes.is_empty() || !self.manifest.services.is_empty() {
+            return Err(anyhow!("Processes and services come with the code, leave them out of the bundle"));
+        }
+        self.manifest.validate()?;
+
+        for autoscaler in &self.autoscalers {
+            let process = &autoscaler.process;
+            // the same bounds as pmk autoscale
+            let min = match process.as_str() {
+                "web" => 1,
+                _ => 0,
+            };
+            if process.is_empty()
+                || !(min..=MAX_SCALE).contains(&i64::from(autoscaler.min))
+                || !(1..=MAX_SCALE).contains(&i64::from(autoscaler.max))
+                || autoscaler.min > autoscaler.max
+            {
+                return Err(anyhow!(
+                    "Autoscaler of {process} has to keep between {min} and {MAX_SCALE} containers, min at most max"
+                ));
+            }
+            if autoscaler.metric != "cpu" && autoscaler.metric != "latency" {
+                return Err(anyhow!("Autoscaler of {process} has to scale on cpu or latency"));
+            }
+            if !(1.0..=60000.0).contains(&autoscaler.target) {
+                return Err(anyhow!("Target of the autoscaler of {process} has to be between 1 and 60000"));
+            }
+        }
+
+        Ok(())
+    }
+}
+
+/// Applies a validated bundle to an app, over what it has. The manifest goes through
+/// [`Manifest::reconcile`] like on a deploy, so addons it lists are created now
+pub async fn import(
+    bundle: &Bundle,
+    project_id: Uuid,
+    container_name: &str,
+    container_settings: &ContainerSettings,
+    pool: &PgPool,
+) -> Result<ImportReport> {
+    let mut report = ImportReport {
+        changes: bundle
+            .manifest
+            .reconcile(project_id, container_name, container_settings, pool)
+            .await?,
+        ..Default::default()
+    };
+
+    for autoscaler in &bundle.autoscalers {
+        sqlx::query!(
+            r#"INSERT INTO autoscalers (id, project_id, process, min_count, max_count, metric, target)
+               VALUES ($1, $2, $3, $4, $5, $6, $7)
+               ON CONFLICT (project_id, process) DO UPDATE
+               SET min_count = $4, max_count = $5, metric = $6, target = $7
+            "#,
+            Uuid::from(Ulid::new()),
+            project_id,
+            autoscaler.process,
+            autoscaler.min,
+            autoscaler.max,
+            autoscaler.metric,
+            autoscaler.target,
+        )
+        .execute(pool)
+        .await?;
+        report.changes.push(format!("autoscaler of {}", autoscaler.process));
+    }
+
+    // unverified until they point here, the old platform keeps serving them until then
+    for name in &bundle.domains {
+        let added = sqlx::query!(
+            r#"INSERT INTO custom_domains (id, project_id, name)
+               VALUES ($1, $2, $3)
+               ON CONFLICT (name) DO UPDATE SET updated_at = now()
+               WHERE custom_domains.project_id = EXCLUDED.project_id
+               RETURNING id
+            "#,
+            Uuid::from(Ulid::new()),
+            project_id,
+            name,
+        )
+        .fetch_optional(pool)
+        .await?
+        .is_some();
+        match added {
+            true => report.domains.push(name.clone()),
+            false => report.skipped_domains.push(name.clone()),
+        }
+    }
+
+    let project = sqlx::query!("SELECT environs, secrets FROM projects WHERE id = $1", project_id)
+        .fetch_one(pool)
+        .await?;
+    let set = |value: &serde_json::Value, name: &str| value.get(name).is_some();
+    report.missing_env = bundle
+        .env
+        .iter()
+        .filter(|name| !set(&project.environs, name) && !set(&project.secrets, name))
+        .cloned()
+        .collect();
+    report.missing_secrets = bundle
+        .secrets
+        .iter()
+        .filter(|name| !set(&project.secrets, name))
+        .cloned()
+        .collect();
+
+    Ok(report)
+}
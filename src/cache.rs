@@ -0,0 +1,480 @@
+use std::collections::HashMap;
+use std::sync::{Arc, Mutex};
+use std::time::Duration;
+
+use bytes::Bytes;
+use chrono::{DateTime, Utc};
+use hyper::body::HttpBody;
+use hyper::header::{
+    HeaderMap, HeaderName, HeaderValue, AGE, AUTHORIZATION, CACHE_CONTROL, CONNECTION, CONTENT_LENGTH, DATE, ETAG,
+    EXPIRES, HOST, IF_MODIFIED_SINCE, IF_NONE_MATCH, LAST_MODIFIED, SET_COOKIE, TRANSFER_ENCODING, VARY,
+};
+use hyper::{Body, Method, Request, Response, StatusCode};
+use tokio::time::Instant;
+
+use crate::streaming::is_streaming;
+
+/// biggest response that is cached, bigger ones are passed on as they come
+const MAX_ENTRY: usize = 4 * 1024 * 1024;
+
+/// variants of one url kept for different values of the headers it varies on
+const MAX_VARIANTS: usize = 8;
+
+/// statuses that stay the same until the app says otherwise
+const CACHEABLE_STATUSES: [StatusCode; 6] = [
+    StatusCode::OK,
+    StatusCode::NON_AUTHORITATIVE_INFORMATION,
+    StatusCode::MOVED_PERMANENTLY,
+    StatusCode::PERMANENT_REDIRECT,
+    StatusCode::NOT_FOUND,
+    StatusCode::GONE,
+];
+
+#[derive(Debug)]
+struct Entry {
+    /// the container that answered, a deploy swaps it and leaves the entry behind
+    container: String,
+    path: String,
+    /// the headers the response varies on and what the request sent in them
+    vary: Vec<(HeaderName, Option<HeaderValue>)>,
+    status: StatusCode,
+    headers: HeaderMap,
+    body: Bytes,
+    stored: Instant,
+    fresh_until: Instant,
+    /// seconds the app said the response was already old
+    age: u64,
+}
+
+impl Entry {
+    fn size(&self) -> usize {
+        self.body.len() + self.path.len() + self.headers.iter().map(|(name, value)| name.as_str().len() + value.len()).sum::<usize>()
+    }
+
+    fn matches(&self, container: &str, headers: &HeaderMap) -> bool {
+        self.container == container && self.vary.iter().all(|(name, value)| headers.get(name) == value.as_ref())
+    }
+
+    fn validators(&self) -> Validators {
+        Validators {
+            etag: self.headers.get(ETAG).cloned(),
+            last_modified: self.headers.get(LAST_MODIFIED).cloned(),
+        }
+    }
+
+    fn response(&self, status: &'static str) -> Response<Body> {
+        let mut res = Response::builder().status(self.status).body(Body::from(self.body.clone())).unwrap();
+        *res.headers_mut() = self.headers.clone();
+        let age = self.age + self.stored.elapsed().as_secs();
+        res.headers_mut().insert(AGE, HeaderValue::from(age));
+        res.headers_mut().insert("X-Cache", HeaderValue::from_static(status));
+        res
+    }
+}
+
+#[derive(Debug, Default)]
+struct Entries {
+    /// by app, then by host and url
+    apps: HashMap<String, HashMap<String, Vec<Entry>>>,
+    size: usize,
+}
+
+/// What the app sent to tell whether a cached response is still current
+#[derive(Debug, Clone, Default)]
+pub struct Validators {
+    etag: Option<HeaderValue>,
+    last_modified: Option<HeaderValue>,
+}
+
+impl Validators {
+    /// Asks the app whether the cached response is still current, unless the client asks that
+    /// itself
+    pub fn apply(&self, headers: &mut HeaderMap) -> bool {
+        if headers.contains_key(IF_NONE_MATCH) || headers.contains_key(IF_MODIFIED_SINCE) {
+            return false;
+        }
+        if let Some(etag) = &self.etag {
+            headers.insert(IF_NONE_MATCH, etag.clone());
+        }
+        if let Some(last_modified) = &self.last_modified {
+            headers.insert(IF_MODIFIED_SINCE, last_modified.clone());
+        }
+        self.etag.is_some() || self.last_modified.is_some()
+    }
+}
+
+pub enum Lookup {
+    /// answer the client from the cache
+    Hit(Response<Body>),
+    /// the cached response is too old, the app is asked whether it changed
+    Stale(Validators),
+    Miss,
+}
+
+/// Responses of apps with the edge cache on, set with `pmk cache on`. They are kept in memory
+/// for as long as their Cache-Control or Expires allows, up to `container.cachesize` for all
+/// apps together, and gone when the platform restarts
+#[derive(Debug, Clone)]
+pub struct ResponseCache {
+    entries: Arc<Mutex<Entries>>,
+    capacity: usize,
+}
+
+impl ResponseCache {
+    /// A cache holding `capacity` bytes of responses
+    pub fn new(capacity: usize) -> Self {
+        Self {
+            entries: Arc::new(Mutex::new(Entries::default())),
+            capacity,
+        }
+    }
+
+    /// Looks up the response to a request with `headers` the container `container` of `app`
+    /// sent earlier
+    pub fn lookup(&self, app: &str, container: &str, key: &str, headers: &HeaderMap) -> Lookup {
+        let entries = self.entries.lock().unwrap();
+        let entry = entries
+            .apps
+            .get(app)
+            .and_then(|urls| urls.get(key))
+            .and_then(|variants| variants.iter().find(|entry| entry.matches(container, headers)));
+        let Some(entry) = entry else {
+            return Lookup::Miss;
+        };
+
+        // a reload asks for a response the app confirmed
+        let revalidate = has_directive(headers, "no-cache") || Instant::now() >= entry.fresh_until;
+        if revalidate {
+            let validators = entry.validators();
+            return match validators.etag.is_some() || validators.last_modified.is_some() {
+                true => Lookup::Stale(validators),
+                false => Lookup::Miss,
+            };
+        }
+
+        if not_modified(headers, &entry.headers) {
+            let mut res = entry.response("HIT");
+            *res.status_mut() = StatusCode::NOT_MODIFIED;
+            *res.body_mut() = Body::empty();
+            res.headers_mut().remove(CONTENT_LENGTH);
+            return Lookup::Hit(res);
+        }
+        Lookup::Hit(entry.response("HIT"))
+    }
+
+    /// The cached response once the app answered 304 to [`Validators::apply`], fresh again
+    /// for as long as the 304 says
+    pub fn revalidated(&self, app: &str, container: &str, key: &str, headers: &HeaderMap, res: &Response<Body>) -> Option<Response<Body>> {
+        let mut entries = self.entries.lock().unwrap();
+        let entry = entries
+            .apps
+            .get_mut(app)?
+            .get_mut(key)?
+            .iter_mut()
+            .find(|entry| entry.matches(container, headers))?;
+
+        let before = entry.size();
+        for name in [CACHE_CONTROL, EXPIRES, DATE, ETAG, LAST_MODIFIED] {
+            if let Some(value) = res.headers().get(&name) {
+                entry.headers.insert(name, value.clone());
+            }
+        }
+        let (lifetime, age) = freshness(&entry.headers).unwrap_or((Duration::ZERO, 0));
+        entry.stored = Instant::now();
+        entry.fresh_until = entry.stored + lifetime;
+        entry.age = age;
+
+        let (res, after) = (entry.response("REVALIDATED"), entry.size());
+        entries.size = entries.size + after - before;
+        Some(res)
+    }
+
+    /// Keeps the response of the app to the request for `path` with `headers` when the headers
+    /// of the response allow it. Only the body of a response that may be cached is read before
+    /// it is sent on
+    pub async fn store(&self, app: &str, container: &str, key: &str, path: &str, headers: &HeaderMap, res: Response<Body>) -> Response<Body> {
+        if !cacheable(&res) {
+            return res;
+        }
+        let Some((lifetime, age)) = freshness(res.headers()) else {
+            return res;
+        };
+
+        let vary = res
+            .headers()
+            .get_all(VARY)
+            .iter()
+            .filter_map(|value| value.to_str().ok())
+            .flat_map(|value| value.split(','))
+            .filter_map(|name| HeaderName::from_bytes(name.trim().as_bytes()).ok())
+            .map(|name| {
+                let value = headers.get(&name).cloned();
+                (name, value)
+            })
+            .collect::<Vec<_>>();
+
+        let (mut parts, mut body) = res.into_parts();
+        let mut buffered = Vec::new();
+        while let Some(chunk) = body.data().await {
+            match chunk {
+                Ok(chunk) => buffered.extend_from_slice(&chunk),
+                Err(err) => {
+                    tracing::warn!(?err, app, "Can't cache response: Failed to read body");
+                    let chunks = [Ok(Bytes::from(buffered)), Err(err)];
+                    return Response::from_parts(parts, Body::wrap_stream(futures::stream::iter(chunks)));
+                }
+            }
+
+            // too big to keep, the rest streams like it would uncached
+            if buffered.len() > MAX_ENTRY {
+                let head = futures::stream::once(async move { Ok(Bytes::from(buffered)) });
+                return Response::from_parts(parts, Body::wrap_stream(futures::StreamExt::chain(head, body)));
+            }
+        }
+
+        let body = Bytes::from(buffered);
+        let mut headers = parts.headers.clone();
+        for name in [CONNECTION, TRANSFER_ENCODING, HeaderName::from_static("keep-alive"), HeaderName::from_static("x-request-id")] {
+            headers.remove(name);
+        }
+        headers.insert(CONTENT_LENGTH, HeaderValue::from(body.len()));
+
+        let stored = Instant::now();
+        let entry = Entry {
+            container: container.to_string(),
+            path: path.to_string(),
+            vary,
+            status: parts.status,
+            headers,
+            body: body.clone(),
+            stored,
+            fresh_until: stored + lifetime,
+            age,
+        };
+        self.insert(app, key, entry);
+
+        parts.headers.insert("X-Cache", HeaderValue::from_static("MISS"));
+        Response::from_parts(parts, Body::from(body))
+    }
+
+    fn insert(&self, app: &str, key: &str, entry: Entry) {
+        let size = entry.size();
+        if size > self.capacity {
+            return;
+        }
+
+        let mut entries = self.entries.lock().unwrap();
+        let variants = entries.apps.entry(app.to_string()).or_default().entry(key.to_string()).or_default();
+        let mut freed = 0;
+        variants.retain(|old| {
+            // the same variant, or one of a container that was replaced since
+            let replaced = old.vary == entry.vary || old.container != entry.container;
+            if replaced {
+                freed += old.size();
+            }
+            !replaced
+        });
+        if variants.len() >= MAX_VARIANTS {
+            freed += variants.remove(0).size();
+        }
+        variants.push(entry);
+        entries.size = entries.size + size - freed;
+
+        if entries.size > self.capacity {
+            evict(&mut entries, self.capacity);
+        }
+    }
+
+    /// Drops the cached responses of `app` under `paths`, a path ending in `*` is a prefix.
+    /// No paths drops all of them. Returns how many were dropped
+    pub fn purge(&self, app: &str, paths: &[String]) -> usize {
+        let mut entries = self.entries.lock().unwrap();
+        let Some(urls) = entries.apps.get_mut(app) else {
+            return 0;
+        };
+
+        let matches = |path: &str| {
+            paths.is_empty()
+                || paths.iter().any(|purged| match purged.strip_suffix('*') {
+                    Some(prefix) => path.starts_with(prefix),
+                    None => path == purged,
+                })
+        };
+        let (mut purged, mut freed) = (0, 0);
+        for variants in urls.values_mut() {
+            variants.retain(|entry| {
+                let matched = matches(&entry.path);
+                if matched {
+                    purged += 1;
+                    freed += entry.size();
+                }
+                !matched
+            });
+        }
+        urls.retain(|_, variants| !variants.is_empty());
+        if urls.is_empty() {
+            entries.apps.remove(app);
+        }
+        entries.size -= freed;
+        purged
+    }
+
+    /// How many responses of `app` are cached and their size in bytes
+    pub fn usage(&self, app: &str) -> (usize, usize) {
+        let entries = self.entries.lock().unwrap();
+        entries
+            .apps
+            .get(app)
+            .map(|urls| {
+                urls.values()
+                    .flatten()
+                    .fold((0, 0), |(count, size), entry| (count + 1, size + entry.size()))
+            })
+            .unwrap_or((0, 0))
+    }
+}
+
+/// Makes room by dropping expired responses, then the ones stored longest ago
+fn evict(entries: &mut Entries, capacity: usize) {
+    let now = Instant::now();
+    let mut freed = 0;
+    for urls in entries.apps.values_mut() {
+        for variants in urls.values_mut() {
+            variants.retain(|entry| {
+                // stale ones with validators are worth keeping while there is room
+                let expired = entry.fresh_until <= now && entry.validators().etag.is_none();
+                if expired {
+                    freed += entry.size();
+                }
+                !expired
+            });
+        }
+    }
+    entries.size -= freed;
+
+    while entries.size > capacity {
+        let oldest = entries
+            .apps
+            .iter()
+            .flat_map(|(app, urls)| {
+                urls.iter()
+                    .flat_map(move |(key, variants)| variants.iter().enumerate().map(move |(index, entry)| (app, key, index, entry.stored)))
+            })
+            .min_by_key(|(_, _, _, stored)| *stored)
+            .map(|(app, key, index, _)| (app.clone(), key.clone(), index));
+        let Some((app, key, index)) = oldest else {
+            break;
+        };
+
+        let urls = entries.apps.get_mut(&app).unwrap();
+        let variants = urls.get_mut(&key).unwrap();
+        let size = variants.remove(index).size();
+        if variants.is_empty() {
+            urls.remove(&key);
+        }
+        if urls.is_empty() {
+            entries.apps.remove(&app);
+        }
+        entries.size -= size;
+    }
+}
+
+/// The url a request is cached under, None for requests that bypass the cache. Only GET
+/// requests without credentials of their own are, a login checked by the proxy was removed
+/// before
+pub fn cache_key<B>(req: &Request<B>, uri: &axum::http::Uri) -> Option<String> {
+    if req.method() != Method::GET || req.headers().contains_key(AUTHORIZATION) || has_directive(req.headers(), "no-store") {
+        return None;
+    }
+    let host = req
+        .headers()
+        .get(HOST)
+        .and_then(|host| host.to_str().ok())
+        .or_else(|| uri.authority().map(|authority| authority.as_str()))
+        .unwrap_or("");
+    let path = uri.path_and_query().map(|path| path.as_str()).unwrap_or("/");
+    Some(format!("{host}{path}"))
+}
+
+fn cacheable(res: &Response<Body>) -> bool {
+    let headers = res.headers();
+    CACHEABLE_STATUSES.contains(&res.status())
+        && !headers.contains_key(SET_COOKIE)
+        && !is_streaming(headers)
+        && !has_directive(headers, "no-store")
+        && !has_directive(headers, "private")
+        && !headers
+            .get_all(VARY)
+            .iter()
+            .filter_map(|value| value.to_str().ok())
+            .any(|value| value.split(',').any(|name| name.trim() == "*"))
+}
+
+/// How long a response stays fresh and how old it already was, from s-maxage, max-age or
+/// Expires. None when it can't be cached at all, a response that is stale right away is
+/// still kept when the app can say whether it changed
+fn freshness(headers: &HeaderMap) -> Option<(Duration, u64)> {
+    let age = headers
+        .get(AGE)
+        .and_then(|age| age.to_str().ok())
+        .and_then(|age| age.trim().parse::<u64>().ok())
+        .unwrap_or(0);
+
+    let lifetime = match directive(headers, "s-maxage").or_else(|| directive(headers, "max-age")) {
+        Some(seconds) => seconds.parse::<u64>().ok().map(|seconds| seconds.saturating_sub(age)),
+        None => headers
+            .get(EXPIRES)
+            .and_then(|expires| expires.to_str().ok())
+            .map(|expires| match DateTime::parse_from_rfc2822(expires) {
+                Ok(expires) => (expires.with_timezone(&Utc) - Utc::now()).num_seconds().max(0) as u64,
+                // an invalid date means it already expired
+                Err(_) => 0,
+            }),
+    };
+    let lifetime = match has_directive(headers, "no-cache") {
+        true => Some(0),
+        false => lifetime,
+    };
+
+    let validated = headers.contains_key(ETAG) || headers.contains_key(LAST_MODIFIED);
+    match lifetime {
+        Some(0) | None if !validated => None,
+        lifetime => Some((Duration::from_secs(lifetime.unwrap_or(0)), age)),
+    }
+}
+
+/// Whether the If-None-Match of the client names the cached response
+fn not_modified(req: &HeaderMap, cached: &HeaderMap) -> bool {
+    let (Some(wanted), Some(etag)) = (req.get(IF_NONE_MATCH), cached.get(ETAG)) else {
+        return false;
+    };
+    let etag = etag.to_str().unwrap_or("").trim_start_matches("W/");
+    wanted
+        .to_str()
+        .unwrap_or("")
+        .split(',')
+        .map(|wanted| wanted.trim().trim_start_matches("W/"))
+        .any(|wanted| wanted == "*" || wanted == etag)
+}
+
+fn directives(headers: &HeaderMap) -> impl Iterator<Item = String> + '_ {
+    headers
+        .get_all(CACHE_CONTROL)
+        .iter()
+        .filter_map(|value| value.to_str().ok())
+        .flat_map(|value| value.split(','))
+        .map(|directive| directive.trim().to_ascii_lowercase())
+}
+
+fn has_directive(headers: &HeaderMap, name: &str) -> bool {
+    directives(headers).any(|directive| directive == name || directive.starts_with(&format!("{name}=")))
+}
+
+fn directive(headers: &HeaderMap, name: &str) -> Option<String> {
+    directives(headers).find_map(|directive| {
+        directive
+            .strip_prefix(name)
+            .and_then(|value| value.strip_prefix('='))
+            .map(|value| value.trim_matches('"').to_string())
+    })
+}
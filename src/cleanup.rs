@@ -0,0 +1,357 @@
+use std::time::Duration;
+
+use chrono::{DateTime, Utc};
+use serde::Serialize;
+use sqlx::PgPool;
+use uuid::Uuid;
+
+use crate::activity::record_activity;
+use crate::audit::{record, AuditChange, NewAuditEntry};
+use crate::backups::BackupStorage;
+use crate::configuration::{CleanupSettings, ContainerSettings};
+use crate::idle::IdleTracker;
+use crate::notifications::{Event, Notifier, Payload};
+use crate::orchestrator;
+use crate::projects::remove_project;
+
+/// how often requests are written down and apps are checked, days are what counts
+const CHECK_INTERVAL: Duration = Duration::from_secs(60 * 60);
+
+/// Where an app is in the cleanup. Activity is the latest of its creation, its last request,
+/// build or release, and a member keeping it
+#[derive(Serialize, Debug, Clone)]
+pub struct CleanupApp {
+    #[serde(skip)]
+    pub id: Uuid,
+    pub owner: String,
+    pub project: String,
+    pub last_active_at: DateTime<Utc>,
+    pub exempt: bool,
+    /// by a platform admin, the cleanup leaves it to them
+    pub suspended: bool,
+    pub flagged_at: Option<DateTime<Utc>>,
+    pub stopped_at: Option<DateTime<Utc>>,
+}
+
+/// What the next sweep does to an app
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub enum Step {
+    Nothing,
+    Flag,
+    /// it got used after it was flagged
+    Unflag,
+    Stop,
+    Delete,
+}
+
+impl Step {
+    pub fn name(&self) -> &'static str {
+        match self {
+            Step::Nothing => "nothing",
+            Step::Flag => "flag",
+            Step::Unflag => "unflag",
+            Step::Stop => "stop",
+            Step::Delete => "delete",
+        }
+    }
+}
+
+impl CleanupApp {
+    pub fn next_step(&self, settings: &CleanupSettings, now: DateTime<Utc>) -> Step {
+        if self.exempt || self.suspended || settings.inactivedays == 0 {
+            return Step::Nothing;
+        }
+
+        match (self.flagged_at, self.stopped_at) {
+            (_, Some(_)) if self.delete_at(settings) <= Some(now) => Step::Delete,
+            (_, Some(_)) => Step::Nothing,
+            (Some(flagged_at), None) if self.last_active_at > flagged_at => Step::Unflag,
+            (Some(_), None) if self.stop_at(settings) <= Some(now) => Step::Stop,
+            (Some(_), None) => Step::Nothing,
+            (None, None) if self.last_active_at + days(settings.inactivedays) <= now => Step::Flag,
+            (None, None) => Step::Nothing,
+        }
+    }
+
+    /// When a flagged app is stopped unless it gets used
+    pub fn stop_at(&self, settings: &CleanupSettings) -> Option<DateTime<Utc>> {
+        self.flagged_at
+            .filter(|_| self.stopped_at.is_none())
+            .map(|flagged_at| flagged_at + days(settings.stopdays))
+    }
+
+    /// When a stopped app is deleted unless a member restores it
+    pub fn delete_at(&self, settings: &CleanupSettings) -> Option<DateTime<Utc>> {
+        self.stopped_at.map(|stopped_at| stopped_at + days(settings.deletedays))
+    }
+
+    fn container_name(&self) -> String {
+        format!("{}-{}", self.owner, self.project.trim_end_matches(".git")).replace('.', "-")
+    }
+}
+
+fn days(days: i32) -> chrono::Duration {
+    chrono::Duration::days(days as i64)
+}
+
+/// Where every app is in the cleanup, or only the one with `project_id`
+pub async fn cleanup_apps(project_id: Option<Uuid>, pool: &PgPool) -> Result<Vec<CleanupApp>, sqlx::Error> {
+    let apps = sqlx::query!(
+        r#"SELECT projects.id, project_owners.name AS owner, projects.name AS project,
+                  GREATEST(projects.created_at, projects.last_request_at, projects.cleanup_kept_at,
+                    (SELECT max(builds.created_at) FROM builds WHERE builds.project_id = projects.id),
+                    (SELECT max(releases.created_at) FROM releases WHERE releases.project_id = projects.id)
+                  ) AS "last_active_at!",
+                  projects.cleanup_exempt,
+                  projects.suspended_at IS NOT NULL AND projects.cleanup_stopped_at IS NULL AS "suspended!",
+                  projects.cleanup_flagged_at, projects.cleanup_stopped_at
+           FROM projects
+           JOIN project_owners ON project_owners.id = projects.owner_id
+           WHERE $1::uuid IS NULL OR projects.id = $1
+           ORDER BY project_owners.name, projects.name
+        "#,
+        project_id
+    )
+    .fetch_all(pool)
+    .await?;
+
+    Ok(apps
+        .into_iter()
+        .map(|app| CleanupApp {
+            id: app.id,
+            owner: app.owner,
+            project: app.project.trim_end_matches(".git").to_string(),
+            last_active_at: app.last_active_at,
+            exempt: app.cleanup_exempt,
+            suspended: app.suspended,
+            flagged_at: app.cleanup_flagged_at,
+            stopped_at: app.cleanup_stopped_at,
+        })
+        .collect())
+}
+
+/// Writes down when the proxy last forwarded a request to each app, the idle tracker only
+/// keeps it in memory
+async fn record_requests(idle: &IdleTracker, pool: &PgPool) -> Result<(), sqlx::Error> {
+    let (apps, seen): (Vec<String>, Vec<DateTime<Utc>>) = idle.seen().into_iter().unzip();
+    if apps.is_empty() {
+        return Ok(());
+    }
+
+    sqlx::query!(
+        r#"UPDATE projects SET last_request_at = seen.at
+           FROM domains, unnest($1::text[], $2::timestamptz[]) AS seen(name, at)
+           WHERE domains.name = seen.name AND projects.id = domains.project_id
+           AND (projects.last_request_at IS NULL OR projects.last_request_at < seen.at)
+        "#,
+        &apps,
+        &seen
+    )
+    .execute(pool)
+    .await?;
+
+    Ok(())
+}
+
+/// The end of semester cleanup. An app without requests or deploys for `inactivedays` is
+/// flagged and its notification hooks hear about it. Still unused `stopdays` later it is
+/// stopped like a suspension, and `deletedays` after that it is deleted unless a member
+/// restored it. A request or deploy unflags an app, admins exempt the ones to keep
+pub async fn sweeper(
+    pool: PgPool,
+    idle: IdleTracker,
+    notifier: Notifier,
+    backups: BackupStorage,
+    base: String,
+    settings: CleanupSettings,
+    container_settings: ContainerSettings,
+) {
+    let mut interval = tokio::time::interval(CHECK_INTERVAL);
+    loop {
+        interval.tick().await;
+
+        // kept even while the cleanup is off, so turning it on doesn't flag apps in use
+        if let Err(err) = record_requests(&idle, &pool).await {
+            tracing::error!(?err, "Can't record requests: Failed to update database");
+        }
+        if settings.inactivedays == 0 {
+            continue;
+        }
+
+        let apps = match cleanup_apps(None, &pool).await {
+            Ok(apps) => apps,
+            Err(err) => {
+                tracing::error!(?err, "Can't clean up apps: Failed to query database");
+                continue;
+            }
+        };
+
+        let now = Utc::now();
+        for app in apps {
+            let result = match app.next_step(&settings, now) {
+                Step::Nothing => continue,
+                Step::Flag => flag(&app, &settings, &notifier, &pool).await,
+                Step::Unflag => unflag(&app, &pool).await,
+                Step::Stop => stop(&app, &settings, &notifier, &container_settings, &pool).await,
+                Step::Delete => delete(&app, &settings, &backups, &base, &pool).await,
+            };
+            if let Err(err) = result {
+                tracing::error!(?err, app = %format!("{}/{}", app.owner, app.project), "Can't clean up app");
+            }
+        }
+    }
+}
+
+fn notify(app: &CleanupApp, event: Event, message: String, notifier: &Notifier, pool: &PgPool) {
+    let payload = Payload {
+        event: event.name(),
+        app: format!("{}/{}", app.owner, app.project),
+        message,
+        build_id: None,
+        commit_sha: None,
+        release_id: None,
+        logs_url: notifier.logs_url(&app.owner, &app.project),
+        timestamp: Utc::now(),
+    };
+    notifier.notify(app.id, event, payload, pool);
+}
+
+async fn flag(app: &CleanupApp, settings: &CleanupSettings, notifier: &Notifier, pool: &PgPool) -> anyhow::Result<()> {
+    let flagged = sqlx::query!(
+        r#"UPDATE projects SET cleanup_flagged_at = now()
+           WHERE id = $1 AND cleanup_flagged_at IS NULL
+           RETURNING cleanup_flagged_at AS "flagged_at!"
+        "#,
+        app.id
+    )
+    .fetch_optional(pool)
+    .await?;
+    let Some(flagged) = flagged else {
+        return Ok(());
+    };
+
+    let message = format!(
+        "No requests or deploys since {}. It is stopped on {} unless it gets a request or a deploy, and deleted {} days after",
+        app.last_active_at.format("%Y-%m-%d"),
+        (flagged.flagged_at + days(settings.stopdays)).format("%Y-%m-%d"),
+        settings.deletedays
+    );
+    tracing::info!(project_id = ?app.id, message);
+    record_activity(app.id, "cleanup", &message, pool).await?;
+    notify(app, Event::AppInactive, message, notifier, pool);
+
+    Ok(())
+}
+
+async fn unflag(app: &CleanupApp, pool: &PgPool) -> anyhow::Result<()> {
+    sqlx::query!(
+        "UPDATE projects SET cleanup_flagged_at = NULL WHERE id = $1 AND cleanup_stopped_at IS NULL",
+        app.id
+    )
+    .execute(pool)
+    .await?;
+
+    record_activity(app.id, "cleanup", "Used again, no longer flagged as inactive", pool).await?;
+
+    Ok(())
+}
+
+async fn stop(
+    app: &CleanupApp,
+    settings: &CleanupSettings,
+    notifier: &Notifier,
+    container_settings: &ContainerSettings,
+    pool: &PgPool,
+) -> anyhow::Result<()> {
+    let delete_at = Utc::now() + days(settings.deletedays);
+    let message = format!(
+        "Stopped after {} days without requests or deploys. It is deleted on {} unless a member restores it with `pmk cleanup restore`",
+        settings.inactivedays + settings.stopdays,
+        delete_at.format("%Y-%m-%d")
+    );
+
+    // a suspension keeps it stopped: the proxy answers 503, cron jobs don't run and the
+    // reconciler leaves it be
+    let stopped = sqlx::query!(
+        r#"UPDATE projects SET suspended_at = now(), suspended_reason = $2, cleanup_stopped_at = now()
+           WHERE id = $1 AND suspended_at IS NULL AND cleanup_flagged_at IS NOT NULL
+        "#,
+        app.id,
+        message
+    )
+    .execute(pool)
+    .await?;
+    if stopped.rows_affected() == 0 {
+        return Ok(());
+    }
+
+    orchestrator::driver().suspend(&app.container_name(), container_settings).await?;
+
+    tracing::info!(project_id = ?app.id, message);
+    record_activity(app.id, "cleanup", &message, pool).await?;
+    notify(app, Event::AppStopped, message, notifier, pool);
+
+    Ok(())
+}
+
+async fn delete(
+    app: &CleanupApp,
+    settings: &CleanupSettings,
+    backups: &BackupStorage,
+    base: &str,
+    pool: &PgPool,
+) -> anyhow::Result<()> {
+    // the sweep looked at the app before the slow removals of the apps ahead of it, a member
+    // may have restored it or an admin exempted it since. Checked again where the row is locked,
+    // an update waits for a restore or exemption under way
+    let due = sqlx::query!(
+        r#"UPDATE projects SET suspended_reason = 'Being deleted by the cleanup'
+           WHERE id = $1 AND cleanup_stopped_at IS NOT NULL AND NOT cleanup_exempt
+           AND cleanup_stopped_at + make_interval(days => $2) <= now()
+           RETURNING id
+        "#,
+        app.id,
+        settings.deletedays
+    )
+    .fetch_optional(pool)
+    .await?;
+    if due.is_none() {
+        return Ok(());
+    }
+
+    // the entry outlives the app, it is all that tells what happened to it
+    let entry = NewAuditEntry {
+        actor_id: None,
+        actor: "cleanup".to_string(),
+        token_id: None,
+        owner: Some(app.owner.clone()),
+        project: Some(app.project.clone()),
+        action: "cleanup.delete".to_string(),
+        method: "POST".to_string(),
+        path: format!("/api/project/{}/{}/delete", app.owner, app.project),
+        status: 200,
+        ip: None,
+        change: Some(AuditChange::new(
+            Some(serde_json::json!({
+                "last_active_at": app.last_active_at,
+                "stopped_at": app.stopped_at,
+                "deletedays": settings.deletedays,
+            })),
+            None,
+        )),
+    };
+    record(entry, pool).await?;
+
+    let status = remove_project(&app.owner, &app.project, base, backups, pool).await;
+    let failed = status
+        .iter()
+        .filter(|(_, result)| result.starts_with("failed") && !result.ends_with("does not exist"))
+        .map(|(part, result)| format!("{part}: {result}"))
+        .collect::<Vec<_>>();
+    match failed.is_empty() {
+        true => tracing::info!(project_id = ?app.id, "Deleted after being stopped for {} days", settings.deletedays),
+        false => tracing::error!(project_id = ?app.id, ?failed, "Deleted, but some of the app is left behind"),
+    }
+
+    Ok(())
+}
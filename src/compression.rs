@@ -0,0 +1,262 @@
+use std::io::{self, Write};
+use std::sync::{Arc, Mutex};
+
+use bytes::Bytes;
+use flate2::write::GzEncoder;
+use hyper::body::HttpBody;
+use hyper::header::{
+    HeaderMap, HeaderValue, ACCEPT_ENCODING, ACCEPT_RANGES, CACHE_CONTROL, CONTENT_ENCODING, CONTENT_LENGTH,
+    CONTENT_RANGE, CONTENT_TYPE, ETAG, VARY,
+};
+use hyper::{Body, Method, Response, StatusCode};
+
+use crate::streaming::is_streaming;
+
+/// responses known to be smaller than this are sent as they are, the encoding would only
+/// add to them
+const MIN_SIZE: u64 = 1024;
+
+/// brotli quality, 11 is too slow to do on every request
+const BROTLI_QUALITY: u32 = 5;
+
+/// content types that are text underneath, the others are compressed already or don't shrink
+const COMPRESSIBLE_TYPES: [&str; 9] = [
+    "text/",
+    "application/json",
+    "application/javascript",
+    "application/x-javascript",
+    "application/xml",
+    "application/wasm",
+    "image/svg+xml",
+    "font/ttf",
+    "font/otf",
+];
+
+#[derive(Debug, Clone, Copy, PartialEq)]
+enum Encoding {
+    Brotli,
+    Gzip,
+}
+
+impl Encoding {
+    fn name(self) -> &'static str {
+        match self {
+            Encoding::Brotli => "br",
+            Encoding::Gzip => "gzip",
+        }
+    }
+}
+
+/// Compresses the response of the app for a client accepting it, set with `pmk compression`.
+/// `accept_encoding` is the header of the request. Responses the app encoded itself, ranges
+/// and streams are left alone, and chunks are still passed on as they come
+pub fn compress(accept_encoding: Option<&HeaderValue>, method: &Method, res: Response<Body>) -> Response<Body> {
+    if !compressible(method, &res) {
+        return res;
+    }
+
+    let (mut parts, body) = res.into_parts();
+    // the response depends on the header whether this client gets it compressed or not
+    vary_on_accept_encoding(&mut parts.headers);
+
+    let Some(encoding) = accepted(accept_encoding) else {
+        return Response::from_parts(parts, body);
+    };
+
+    parts.headers.remove(CONTENT_LENGTH);
+    parts.headers.remove(ACCEPT_RANGES);
+    parts.headers.insert(CONTENT_ENCODING, HeaderValue::from_static(encoding.name()));
+    // the bytes aren't the ones of the app anymore, only a weak validator still holds
+    if let Some(etag) = parts.headers.get(ETAG).and_then(|etag| etag.to_str().ok()) {
+        if !etag.starts_with("W/") {
+            let weak = HeaderValue::from_str(&format!("W/{etag}")).unwrap();
+            parts.headers.insert(ETAG, weak);
+        }
+    }
+
+    Response::from_parts(parts, encode(encoding, body))
+}
+
+fn compressible(method: &Method, res: &Response<Body>) -> bool {
+    let headers = res.headers();
+    let no_body = matches!(
+        res.status(),
+        StatusCode::SWITCHING_PROTOCOLS | StatusCode::NO_CONTENT | StatusCode::NOT_MODIFIED | StatusCode::PARTIAL_CONTENT
+    );
+    if *method == Method::HEAD || no_body || headers.contains_key(CONTENT_ENCODING) || headers.contains_key(CONTENT_RANGE) {
+        return false;
+    }
+    if is_streaming(headers) {
+        return false;
+    }
+
+    let no_transform = headers
+        .get_all(CACHE_CONTROL)
+        .iter()
+        .filter_map(|value| value.to_str().ok())
+        .any(|value| value.to_ascii_lowercase().contains("no-transform"));
+    if no_transform {
+        return false;
+    }
+
+    let small = headers
+        .get(CONTENT_LENGTH)
+        .and_then(|length| length.to_str().ok())
+        .and_then(|length| length.parse::<u64>().ok())
+        .is_some_and(|length| length < MIN_SIZE);
+    if small {
+        return false;
+    }
+
+    let content_type = headers
+        .get(CONTENT_TYPE)
+        .and_then(|value| value.to_str().ok())
+        .map(|value| value.split(';').next().unwrap_or("").trim().to_ascii_lowercase());
+    match content_type {
+        Some(content_type) => {
+            COMPRESSIBLE_TYPES.iter().any(|compressible| content_type.starts_with(compressible))
+                || content_type.ends_with("+json")
+                || content_type.ends_with("+xml")
+        }
+        None => false,
+    }
+}
+
+fn vary_on_accept_encoding(headers: &mut HeaderMap) {
+    let varies = headers
+        .get_all(VARY)
+        .iter()
+        .filter_map(|value| value.to_str().ok())
+        .flat_map(|value| value.split(','))
+        .map(str::trim)
+        .any(|name| name == "*" || name.eq_ignore_ascii_case(ACCEPT_ENCODING.as_str()));
+    if !varies {
+        headers.append(VARY, HeaderValue::from_static("Accept-Encoding"));
+    }
+}
+
+/// The encoding the client prefers of the ones the proxy speaks, brotli when it takes both
+/// alike
+fn accepted(accept_encoding: Option<&HeaderValue>) -> Option<Encoding> {
+    let header = accept_encoding?.to_str().ok()?;
+
+    let mut best: Option<(Encoding, f32)> = None;
+    let mut wildcard = None;
+    let mut named = Vec::new();
+    for coding in header.split(',') {
+        let mut params = coding.split(';');
+        let name = params.next().unwrap_or("").trim().to_ascii_lowercase();
+        let q = params
+            .filter_map(|param| param.trim().strip_prefix("q="))
+            .find_map(|q| q.trim().parse::<f32>().ok())
+            .unwrap_or(1.0);
+
+        let encoding = match name.as_str() {
+            "br" => Encoding::Brotli,
+            "gzip" | "x-gzip" => Encoding::Gzip,
+            "*" => {
+                wildcard = Some(q);
+                continue;
+            }
+            _ => continue,
+        };
+        named.push(encoding);
+        if q > 0.0 && best.map_or(true, |(_, best)| q > best) {
+            best = Some((encoding, q));
+        }
+    }
+
+    // `*` stands for the encodings the client didn't name
+    if let Some(q) = wildcard.filter(|q| *q > 0.0) {
+        for encoding in [Encoding::Brotli, Encoding::Gzip] {
+            if !named.contains(&encoding) && best.map_or(true, |(_, best)| q > best) {
+                best = Some((encoding, q));
+            }
+        }
+    }
+    best.map(|(encoding, _)| encoding)
+}
+
+/// Where an encoder writes, drained after every chunk
+#[derive(Clone, Default)]
+struct Sink(Arc<Mutex<Vec<u8>>>);
+
+impl Sink {
+    fn take(&self) -> Bytes {
+        Bytes::from(std::mem::take(&mut *self.0.lock().unwrap()))
+    }
+}
+
+impl Write for Sink {
+    fn write(&mut self, buf: &[u8]) -> io::Result<usize> {
+        self.0.lock().unwrap().extend_from_slice(buf);
+        Ok(buf.len())
+    }
+
+    fn flush(&mut self) -> io::Result<()> {
+        Ok(())
+    }
+}
+
+enum Encoder {
+    Brotli(Box<brotli::CompressorWriter<Sink>>),
+    Gzip(GzEncoder<Sink>),
+}
+
+impl Encoder {
+    fn new(encoding: Encoding, sink: Sink) -> Self {
+        match encoding {
+            Encoding::Brotli => Encoder::Brotli(Box::new(brotli::CompressorWriter::new(sink, 4096, BROTLI_QUALITY, 22))),
+            Encoding::Gzip => Encoder::Gzip(GzEncoder::new(sink, flate2::Compression::default())),
+        }
+    }
+
+    /// Compresses a chunk and flushes it, a client waiting on it gets it now
+    fn write(&mut self, chunk: &[u8]) -> io::Result<()> {
+        match self {
+            Encoder::Brotli(encoder) => {
+                encoder.write_all(chunk)?;
+                encoder.flush()
+            }
+            Encoder::Gzip(encoder) => {
+                encoder.write_all(chunk)?;
+                encoder.flush()
+            }
+        }
+    }
+
+    fn finish(self) -> io::Result<()> {
+        match self {
+            // the stream is finished when the writer lets go of the sink
+            Encoder::Brotli(encoder) => drop((*encoder).into_inner()),
+            Encoder::Gzip(encoder) => drop(encoder.finish()?),
+        }
+        Ok(())
+    }
+}
+
+fn encode(encoding: Encoding, body: Body) -> Body {
+    let sink = Sink::default();
+    let encoder = Encoder::new(encoding, sink.clone());
+
+    let chunks = futures::stream::unfold(Some((body, encoder, sink)), |state| async move {
+        let (mut body, mut encoder, sink) = state?;
+        loop {
+            match body.data().await {
+                Some(Ok(chunk)) => {
+                    if let Err(err) = encoder.write(&chunk) {
+                        return Some((Err(err), None));
+                    }
+                    let compressed = sink.take();
+                    if !compressed.is_empty() {
+                        return Some((Ok(compressed), Some((body, encoder, sink))));
+                    }
+                }
+                // the client gets the response cut off like it would uncompressed
+                Some(Err(err)) => return Some((Err(io::Error::new(io::ErrorKind::Other, err)), None)),
+                None => return Some((encoder.finish().map(|_| sink.take()), None)),
+            }
+        }
+    });
+    Body::wrap_stream(chunks)
+}
@@ -0,0 +1,98 @@
+use std::time::Duration;
+
+use sqlx::PgPool;
+use uuid::Uuid;
+
+use crate::configuration::ContainerSettings;
+use crate::drains::LogLine;
+
+/// how often output past its retention or size is deleted
+const PRUNE_INTERVAL: Duration = Duration::from_secs(3600);
+
+/// Keeps a batch of output of the containers of a project, so it can be searched after the
+/// containers are gone
+pub async fn store(project_id: Uuid, batch: &[LogLine], pool: &PgPool) -> Result<(), sqlx::Error> {
+    let mut recorded_at = Vec::with_capacity(batch.len());
+    let mut containers = Vec::with_capacity(batch.len());
+    let mut processes = Vec::with_capacity(batch.len());
+    let mut streams = Vec::with_capacity(batch.len());
+    let mut levels = Vec::with_capacity(batch.len());
+    let mut messages = Vec::with_capacity(batch.len());
+    for line in batch {
+        recorded_at.push(line.timestamp);
+        containers.push(line.container.clone());
+        processes.push(line.process.clone());
+        streams.push(line.stream.to_string());
+        levels.push(line.level.map(|level| level.to_string()));
+        // postgres text can't hold nul bytes
+        messages.push(line.message.replace('\0', ""));
+    }
+
+    // a project deleted since its containers wrote the lines takes them along
+    sqlx::query!(
+        r#"INSERT INTO container_logs (project_id, recorded_at, container, process, stream, level, message)
+           SELECT projects.id, lines.* FROM UNNEST(
+               $2::timestamptz[], $3::text[], $4::text[], $5::text[], $6::text[], $7::text[]
+           ) AS lines (recorded_at, container, process, stream, level, message)
+           JOIN projects ON projects.id = $1
+        "#,
+        project_id,
+        &recorded_at,
+        &containers,
+        &processes,
+        &streams,
+        // nulls in the array don't type check
+        &levels as _,
+        &messages
+    )
+    .execute(pool)
+    .await?;
+
+    Ok(())
+}
+
+/// Deletes container output past the retention of its app, and the oldest lines of apps
+/// keeping more than their size
+pub async fn container_log_pruner(pool: PgPool, container_settings: ContainerSettings) {
+    let mut interval = tokio::time::interval(PRUNE_INTERVAL);
+    loop {
+        interval.tick().await;
+
+        if let Err(err) = sqlx::query!(
+            r#"DELETE FROM container_logs USING projects
+               WHERE projects.id = container_logs.project_id
+               AND container_logs.recorded_at < now() - make_interval(days => LEAST(COALESCE(projects.container_log_retention, $1), $2))
+            "#,
+            container_settings.containerlogretention,
+            container_settings.containerlogmaxretention
+        )
+        .execute(&pool)
+        .await
+        {
+            tracing::error!(?err, "Can't prune container logs: Failed to query database");
+        }
+
+        if let Err(err) = sqlx::query!(
+            r#"DELETE FROM container_logs WHERE id IN (
+                   SELECT id FROM (
+                       SELECT container_logs.id,
+                              sum(octet_length(container_logs.message)) OVER (
+                                  PARTITION BY container_logs.project_id ORDER BY container_logs.id DESC
+                              ) AS size,
+                              LEAST(COALESCE(projects.container_log_size, $1), $2)::bigint * 1024 * 1024 AS max_size
+                       FROM container_logs
+                       JOIN projects ON projects.id = container_logs.project_id
+                   ) AS ranked
+                   WHERE size > max_size
+               )
+            "#,
+            container_settings.containerlogsize,
+            container_settings.containerlogmaxsize
+        )
+        .execute(&pool)
+        .await
+        {
+            tracing::error!(?err, "Can't prune container logs: Failed to query database");
+        }
+    }
+}
@@ -0,0 +1,168 @@
+use hyper::header::{
+    HeaderMap, HeaderName, HeaderValue, ACCESS_CONTROL_ALLOW_CREDENTIALS, ACCESS_CONTROL_ALLOW_HEADERS,
+    ACCESS_CONTROL_ALLOW_METHODS, ACCESS_CONTROL_ALLOW_ORIGIN, ACCESS_CONTROL_EXPOSE_HEADERS, ACCESS_CONTROL_MAX_AGE,
+    ACCESS_CONTROL_REQUEST_HEADERS, ACCESS_CONTROL_REQUEST_METHOD, ORIGIN, VARY,
+};
+use hyper::{Body, Method, Response, StatusCode};
+
+/// methods allowed when the owners don't name any, the ones a form can send anyway
+const DEFAULT_METHODS: [&str; 3] = ["GET", "HEAD", "POST"];
+
+/// Which other sites may call an app from the browser, set with `pmk cors`. The proxy answers
+/// preflights itself and sets the headers on every response, the ones of the app are replaced
+#[derive(Debug, Clone, Default)]
+pub struct CorsPolicy {
+    /// like `https://web.example.com`, `https://*.example.com` for its subdomains or `*` for
+    /// any. Empty leaves cors to the app
+    pub origins: Vec<String>,
+    pub methods: Vec<String>,
+    /// request headers besides the safelisted ones, `*` allows whatever the browser asks for
+    pub headers: Vec<String>,
+    /// cookies and logins are sent along, the origin is echoed then even for `*`
+    pub credentials: bool,
+    /// seconds browsers keep a preflight, None leaves it to them
+    pub max_age: Option<i32>,
+}
+
+impl CorsPolicy {
+    pub fn enabled(&self) -> bool {
+        !self.origins.is_empty()
+    }
+
+    /// Whether `origin` may call the app
+    pub fn allows(&self, origin: &str) -> bool {
+        self.origins.iter().any(|allowed| origin_matches(allowed, origin))
+    }
+
+    /// The answer to a preflight, None for requests that aren't one. A preflight of an origin
+    /// that isn't allowed gets an answer without the headers, which the browser takes as no
+    pub fn preflight(&self, method: &Method, headers: &HeaderMap) -> Option<Response<Body>> {
+        if !self.enabled() || method != Method::OPTIONS || !headers.contains_key(ACCESS_CONTROL_REQUEST_METHOD) {
+            return None;
+        }
+        let origin = headers.get(ORIGIN)?;
+
+        let mut res = Response::builder()
+            .status(StatusCode::NO_CONTENT)
+            .body(Body::empty())
+            .unwrap();
+        let out = res.headers_mut();
+        out.append(VARY, HeaderValue::from_static("Origin, Access-Control-Request-Method, Access-Control-Request-Headers"));
+        if !self.allow_origin(origin, out) {
+            return Some(res);
+        }
+
+        let methods = match self.methods.is_empty() {
+            true => DEFAULT_METHODS.join(", "),
+            false => self.methods.join(", "),
+        };
+        if let Ok(methods) = HeaderValue::from_str(&methods) {
+            out.insert(ACCESS_CONTROL_ALLOW_METHODS, methods);
+        }
+
+        let allowed_headers = match self.headers.iter().any(|header| header == "*") {
+            // `*` on its own doesn't cover credentialed requests, what was asked for does
+            true => headers.get(ACCESS_CONTROL_REQUEST_HEADERS).cloned(),
+            false if self.headers.is_empty() => None,
+            false => HeaderValue::from_str(&self.headers.join(", ")).ok(),
+        };
+        if let Some(allowed_headers) = allowed_headers {
+            out.insert(ACCESS_CONTROL_ALLOW_HEADERS, allowed_headers);
+        }
+        if let Some(max_age) = self.max_age {
+            out.insert(ACCESS_CONTROL_MAX_AGE, HeaderValue::from(max_age));
+        }
+        Some(res)
+    }
+
+    /// Puts the headers of the policy on the response to a request from `origin`, in place of
+    /// the ones the app sent. Headers the app exposes stay exposed
+    pub fn apply(&self, origin: Option<&HeaderValue>, headers: &mut HeaderMap) {
+        if !self.enabled() {
+            return;
+        }
+        for name in [ACCESS_CONTROL_ALLOW_ORIGIN, ACCESS_CONTROL_ALLOW_CREDENTIALS, ACCESS_CONTROL_ALLOW_METHODS, ACCESS_CONTROL_ALLOW_HEADERS, ACCESS_CONTROL_MAX_AGE] {
+            headers.remove(name);
+        }
+        // caches in front keep the answers for each origin apart
+        let varies = headers
+            .get_all(VARY)
+            .iter()
+            .filter_map(|value| value.to_str().ok())
+            .flat_map(|value| value.split(','))
+            .any(|name| name.trim() == "*" || name.trim().eq_ignore_ascii_case("origin"));
+        if !varies {
+            headers.append(VARY, HeaderValue::from_static("Origin"));
+        }
+
+        let allowed = origin.is_some_and(|origin| self.allow_origin(origin, headers));
+        if !allowed {
+            headers.remove(ACCESS_CONTROL_EXPOSE_HEADERS);
+        }
+    }
+
+    fn allow_origin(&self, origin: &HeaderValue, headers: &mut HeaderMap) -> bool {
+        let Some(origin) = origin.to_str().ok().filter(|origin| self.allows(origin)) else {
+            return false;
+        };
+        let any = !self.credentials && self.origins.iter().any(|allowed| allowed == "*");
+        match any {
+            true => headers.insert(ACCESS_CONTROL_ALLOW_ORIGIN, HeaderValue::from_static("*")),
+            false => headers.insert(ACCESS_CONTROL_ALLOW_ORIGIN, HeaderValue::from_str(origin).unwrap()),
+        };
+        if self.credentials {
+            headers.insert(ACCESS_CONTROL_ALLOW_CREDENTIALS, HeaderValue::from_static("true"));
+        }
+        true
+    }
+}
+
+/// Checks an origin the owners allow, `scheme://host[:port]`, `scheme://*.host` or `*`
+pub fn origin_check(origin: &str) -> Result<(), String> {
+    if origin == "*" {
+        return Ok(());
+    }
+    let Some((scheme, host)) = origin.split_once("://") else {
+        return Err(format!("{origin} is not an origin like https://web.example.com"));
+    };
+    let host = host.strip_prefix("*.").unwrap_or(host);
+    let valid = matches!(scheme, "http" | "https")
+        && !host.is_empty()
+        && !host.contains('*')
+        && host.chars().all(|c| c.is_ascii_alphanumeric() || "-.:[]".contains(c));
+    match valid {
+        true => Ok(()),
+        false => Err(format!("{origin} is not an origin like https://web.example.com, without a path")),
+    }
+}
+
+/// Checks a method the owners allow, like `PUT`
+pub fn method_check(method: &str) -> Result<(), String> {
+    match Method::from_bytes(method.as_bytes()) {
+        Ok(_) => Ok(()),
+        Err(_) => Err(format!("{method} is not a method")),
+    }
+}
+
+/// Checks a request header the owners allow, like `Content-Type`, or `*`
+pub fn header_check(header: &str) -> Result<(), String> {
+    match header == "*" || HeaderName::from_bytes(header.as_bytes()).is_ok() {
+        true => Ok(()),
+        false => Err(format!("{header} is not a header name")),
+    }
+}
+
+fn origin_matches(allowed: &str, origin: &str) -> bool {
+    if allowed == "*" {
+        return true;
+    }
+    let origin = origin.to_ascii_lowercase();
+    match allowed.split_once("://*.") {
+        // the subdomains, not the domain itself
+        Some((scheme, domain)) => origin
+            .strip_prefix(&format!("{scheme}://"))
+            .and_then(|host| host.strip_suffix(&format!(".{domain}")))
+            .is_some_and(|sub| !sub.is_empty() && !sub.contains('/')),
+        None => allowed.eq_ignore_ascii_case(&origin),
+    }
+}
@@ -0,0 +1,292 @@
+use std::collections::{HashMap, VecDeque};
+use std::sync::{Arc, Mutex};
+use std::time::Duration;
+
+use anyhow::Result;
+use bollard::container::{ListContainersOptions, UpdateContainerOptions};
+use bollard::service::{RestartPolicy, RestartPolicyNameEnum};
+use bollard::Docker;
+use sqlx::PgPool;
+use tokio::time::Instant;
+use uuid::Uuid;
+
+use crate::activity::record_activity;
+use crate::configuration::ContainerSettings;
+use crate::docker::WORKER_LABEL;
+use crate::restarts::project_restarts;
+
+/// wait before the first restart of a crash looping container, it doubles every crash
+const FIRST_BACKOFF: Duration = Duration::from_secs(10);
+
+/// What to make of a crash
+#[derive(Debug, Clone, Copy, PartialEq)]
+pub enum Crash {
+    /// the container crashes now and then, docker restarts it
+    Single,
+    /// the container just crashed often enough to be crash looping, it is restarted after
+    /// `delay`. Its owners are told once
+    LoopStarted { crashes: usize, delay: Duration },
+    /// the container was crash looping already, it is restarted after `delay`
+    Looping { delay: Duration },
+}
+
+struct Container {
+    project_id: Uuid,
+    name: String,
+    /// crashes within the window
+    crashes: VecDeque<Instant>,
+    /// restarts since it started looping, None while docker restarts it
+    backoffs: Option<u32>,
+}
+
+/// Tells containers that keep crashing from ones that crash now and then. A container
+/// crashing `crashrestarts` times within `crashwindow` seconds marks its app crash looping:
+/// docker stops restarting it and it is started again after a wait that doubles every crash,
+/// up to `crashbackoff` seconds. Staying up for a window ends the loop
+#[derive(Clone)]
+pub struct CrashLoops {
+    containers: Arc<Mutex<HashMap<String, Container>>>,
+    pool: PgPool,
+    restarts: usize,
+    window: Duration,
+    max_backoff: Duration,
+}
+
+impl CrashLoops {
+    pub fn new(pool: PgPool, container_settings: &ContainerSettings) -> Self {
+        Self {
+            containers: Arc::default(),
+            pool,
+            restarts: container_settings.crashrestarts.max(1),
+            window: Duration::from_secs(container_settings.crashwindow),
+            max_backoff: Duration::from_secs(container_settings.crashbackoff),
+        }
+    }
+
+    /// Loops are only known in memory. The containers of apps that were crash looping when
+    /// the platform stopped get their restarts back, and a fresh count
+    pub async fn resume(&self) -> Result<()> {
+        let apps = sqlx::query!(
+            r#"SELECT projects.id, domains.name
+               FROM projects
+               JOIN domains ON domains.project_id = projects.id
+               WHERE projects.crash_looping_at IS NOT NULL
+            "#
+        )
+        .fetch_all(&self.pool)
+        .await?;
+
+        let docker = Docker::connect_with_local_defaults()?;
+        for app in apps {
+            let restarts = project_restarts(app.id, &self.pool).await?;
+            let filters = [
+                ("name", format!("^/{}$", app.name)),
+                ("label", format!("{WORKER_LABEL}={}", app.name)),
+            ];
+            for (filter, value) in filters {
+                let containers = docker
+                    .list_containers(Some(ListContainersOptions::<String> {
+                        all: true,
+                        filters: HashMap::from([(filter.to_string(), vec![value])]),
+                        ..Default::default()
+                    }))
+                    .await?;
+
+                for container in containers {
+                    let Some(id) = container.id else {
+                        continue;
+                    };
+                    restart_policy(&docker, &id, restarts.docker()).await?;
+                    if container.state.as_deref() != Some("running") {
+                        self.restart(&id).await?;
+                    }
+                }
+            }
+
+            sqlx::query!("UPDATE projects SET crash_looping_at = NULL WHERE id = $1", app.id)
+                .execute(&self.pool)
+                .await?;
+        }
+
+        Ok(())
+    }
+
+    /// Records a crash of the container with docker id `id`
+    pub async fn crashed(&self, id: &str, name: &str, project_id: Uuid) -> Crash {
+        let crash = {
+            let mut containers = self.containers.lock().unwrap();
+            let container = containers.entry(id.to_string()).or_insert_with(|| Container {
+                project_id,
+                name: name.to_string(),
+                crashes: VecDeque::new(),
+                backoffs: None,
+            });
+
+            let now = Instant::now();
+            container.crashes.push_back(now);
+            while container
+                .crashes
+                .front()
+                .is_some_and(|crashed| now.duration_since(*crashed) > self.window)
+            {
+                container.crashes.pop_front();
+            }
+
+            match container.backoffs {
+                Some(backoffs) => {
+                    container.backoffs = Some(backoffs + 1);
+                    Crash::Looping { delay: self.backoff(backoffs + 1) }
+                }
+                None if container.crashes.len() >= self.restarts => {
+                    container.backoffs = Some(0);
+                    Crash::LoopStarted {
+                        crashes: container.crashes.len(),
+                        delay: self.backoff(0),
+                    }
+                }
+                None => Crash::Single,
+            }
+        };
+
+        match crash {
+            Crash::Single => {}
+            Crash::LoopStarted { delay, .. } => {
+                if let Err(err) = self.start_loop(id, project_id).await {
+                    tracing::error!(?err, container = name, "Can't back off crash loop: Failed to stop restarts");
+                }
+                self.restart_later(id, delay);
+            }
+            Crash::Looping { delay } => self.restart_later(id, delay),
+        }
+        crash
+    }
+
+    fn backoff(&self, backoffs: u32) -> Duration {
+        FIRST_BACKOFF
+            .saturating_mul(2u32.saturating_pow(backoffs))
+            .min(self.max_backoff)
+    }
+
+    async fn start_loop(&self, id: &str, project_id: Uuid) -> Result<()> {
+        sqlx::query!(
+            "UPDATE projects SET crash_looping_at = now() WHERE id = $1 AND crash_looping_at IS NULL",
+            project_id
+        )
+        .execute(&self.pool)
+        .await?;
+
+        let docker = Docker::connect_with_local_defaults()?;
+        let never = RestartPolicy {
+            name: Some(RestartPolicyNameEnum::NO),
+            maximum_retry_count: None,
+        };
+        restart_policy(&docker, id, never).await
+    }
+
+    /// Starts the container after `delay`, then waits a window to see whether it stays up
+    fn restart_later(&self, id: &str, delay: Duration) {
+        let crash_loops = self.clone();
+        let id = id.to_string();
+
+        tokio::spawn(async move {
+            tokio::time::sleep(delay).await;
+            if let Err(err) = crash_loops.restart(&id).await {
+                // deploys and deletes remove containers, there is nothing to restart then
+                tracing::debug!(?err, container = id, "Can't restart crash looping container");
+                crash_loops.forget(&id).await;
+                return;
+            }
+
+            let backoffs = crash_loops.backoffs(&id);
+            tokio::time::sleep(crash_loops.window).await;
+            if backoffs.is_some() && crash_loops.backoffs(&id) == backoffs {
+                crash_loops.recovered(&id).await;
+            }
+        });
+    }
+
+    fn backoffs(&self, id: &str) -> Option<u32> {
+        let containers = self.containers.lock().unwrap();
+        containers.get(id).and_then(|container| container.backoffs)
+    }
+
+    async fn restart(&self, id: &str) -> Result<()> {
+        let docker = Docker::connect_with_local_defaults()?;
+        let inspect = docker.inspect_container(id, None).await?;
+        // docker may have raced us to it before it stopped restarting
+        if inspect.state.and_then(|state| state.running) == Some(true) {
+            return Ok(());
+        }
+        docker.start_container::<String>(id, None).await?;
+        Ok(())
+    }
+
+    /// The container stayed up for a window, docker restarts it again the way the app says
+    async fn recovered(&self, id: &str) {
+        let container = self.containers.lock().unwrap().remove(id);
+        let Some(container) = container else {
+            return;
+        };
+
+        let restored = async {
+            let restarts = project_restarts(container.project_id, &self.pool).await?;
+            let docker = Docker::connect_with_local_defaults()?;
+            restart_policy(&docker, id, restarts.docker()).await
+        };
+        if let Err(err) = restored.await {
+            tracing::error!(?err, container = container.name, "Can't end crash loop: Failed to restore restarts");
+        }
+
+        if self.end_loop(container.project_id).await {
+            let message = format!("Container {} stays up again, it is no longer crash looping", container.name);
+            if let Err(err) = record_activity(container.project_id, "crashloop", &message, &self.pool).await {
+                tracing::error!(?err, "Can't record activity: Failed to query database");
+            }
+        }
+    }
+
+    /// Drops a container that is gone
+    async fn forget(&self, id: &str) {
+        let container = self.containers.lock().unwrap().remove(id);
+        if let Some(container) = container {
+            self.end_loop(container.project_id).await;
+        }
+    }
+
+    /// Clears the mark on the app unless another of its containers still loops, true when it
+    /// was cleared
+    async fn end_loop(&self, project_id: Uuid) -> bool {
+        let looping = self
+            .containers
+            .lock()
+            .unwrap()
+            .values()
+            .any(|container| container.project_id == project_id && container.backoffs.is_some());
+        if looping {
+            return false;
+        }
+
+        match sqlx::query!(
+            "UPDATE projects SET crash_looping_at = NULL WHERE id = $1 AND crash_looping_at IS NOT NULL",
+            project_id
+        )
+        .execute(&self.pool)
+        .await
+        {
+            Ok(result) => result.rows_affected() > 0,
+            Err(err) => {
+                tracing::error!(?err, "Can't end crash loop: Failed to query database");
+                false
+            }
+        }
+    }
+}
+
+async fn restart_policy(docker: &Docker, id: &str, policy: RestartPolicy) -> Result<()> {
+    let update = UpdateContainerOptions::<String> {
+        restart_policy: Some(policy),
+        ..Default::default()
+    };
+    docker.update_container(id, update).await?;
+    Ok(())
+}
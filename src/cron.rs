@@ -0,0 +1,240 @@
+use std::fmt;
+
+use anyhow::Result;
+use chrono::{DateTime, Utc};
+use croner::Cron;
+use serde::{Deserialize, Serialize};
+use sqlx::PgPool;
+use ulid::Ulid;
+use uuid::Uuid;
+
+use crate::configuration::ContainerSettings;
+use crate::docker::{database_url, run_once, ReleaseConfig};
+use crate::secrets::SecretCipher;
+
+/// how often the scheduler looks for due jobs, schedules have minute precision
+const TICK: std::time::Duration = std::time::Duration::from_secs(5);
+
+#[derive(Serialize, Deserialize, Debug, sqlx::Type)]
+#[sqlx(type_name = "run_state", rename_all = "lowercase")]
+pub enum RunState {
+    RUNNING,
+    SUCCESSFUL,
+    FAILED,
+}
+
+impl fmt::Display for RunState {
+    fn fmt(&self, f: &mut fmt::Formatter) -> fmt::Result {
+        match self {
+            RunState::RUNNING => write!(f, "Running"),
+            RunState::SUCCESSFUL => write!(f, "Successful"),
+            RunState::FAILED => write!(f, "Failed"),
+        }
+    }
+}
+
+/// Next time a five field cron expression fires after `after`, in UTC
+pub fn next_run(schedule: &str, after: &DateTime<Utc>) -> Result<DateTime<Utc>> {
+    let cron = Cron::new(schedule)
+        .parse()
+        .map_err(|err| anyhow::anyhow!("Invalid schedule {schedule}: {err}"))?;
+
+    cron.find_next_occurrence(after, false)
+        .map_err(|err| anyhow::anyhow!("Schedule {schedule} never fires: {err}"))
+}
+
+/// Starts due cron jobs in one-off containers of their project's live release. A job is
+/// skipped while its previous run is still going, so slow jobs never pile up.
+pub async fn cron_scheduler(
+    pool: PgPool,
+    container_settings: ContainerSettings,
+    secrets: SecretCipher,
+) {
+    // runs that were going when the platform stopped will never finish, don't let them
+    // block their job forever
+    if let Err(err) = sqlx::query!(
+        r#"UPDATE cron_runs SET status = 'failed', finished_at = now(), log = log || $1
+           WHERE status = 'running'
+        "#,
+        "\nInterrupted by a platform restart\n"
+    )
+    .execute(&pool)
+    .await
+    {
+        tracing::error!(?err, "Can't clean up cron runs: Failed to query database");
+    }
+
+    let mut interval = tokio::time::interval(TICK);
+    loop {
+        interval.tick().await;
+
+        let jobs = match sqlx::query!(
+            r#"SELECT cron_jobs.id, cron_jobs.schedule, cron_jobs.command, cron_jobs.project_id,
+               projects.name AS project, project_owners.name AS owner
+               FROM cron_jobs
+               JOIN projects ON projects.id = cron_jobs.project_id
+               JOIN project_owners ON projects.owner_id = project_owners.id
+               WHERE cron_jobs.next_run_at <= now() AND projects.suspended_at IS NULL
+            "#
+        )
+        .fetch_all(&pool)
+        .await
+        {
+            Ok(jobs) => jobs,
+            Err(err) => {
+                tracing::error!(?err, "Can't get cron jobs: Failed to query database");
+                continue;
+            }
+        };
+
+        for job in jobs {
+            // schedules are checked when a job is created, this only fails on a
+            // schedule that can't fire anymore
+            let next_run_at = match next_run(&job.schedule, &Utc::now()) {
+                Ok(next_run_at) => next_run_at,
+                Err(err) => {
+                    tracing::error!(?err, "Can't schedule cron job {}", job.id);
+                    DateTime::<Utc>::MAX_UTC
+                }
+            };
+
+            if let Err(err) = sqlx::query!(
+                "UPDATE cron_jobs SET next_run_at = $1 WHERE id = $2",
+                next_run_at,
+                job.id
+            )
+            .execute(&pool)
+            .await
+            {
+                tracing::error!(?err, "Can't schedule cron job: Failed to query database");
+                continue;
+            }
+
+            match sqlx::query!(
+                "SELECT id FROM cron_runs WHERE job_id = $1 AND status = 'running'",
+                job.id
+            )
+            .fetch_optional(&pool)
+            .await
+            {
+                Ok(None) => {}
+                Ok(Some(run)) => {
+                    tracing::info!(job_id = ?job.id, run_id = ?run.id, "Skipping cron job, previous run is still running");
+                    continue;
+                }
+                Err(err) => {
+                    tracing::error!(?err, "Can't get cron runs: Failed to query database");
+                    continue;
+                }
+            }
+
+            let run_id = Uuid::from(Ulid::new());
+            if let Err(err) = sqlx::query!(
+                "INSERT INTO cron_runs (id, job_id) VALUES ($1, $2)",
+                run_id,
+                job.id
+            )
+            .execute(&pool)
+            .await
+            {
+                tracing::error!(?err, "Can't start cron job: Failed to query database");
+                continue;
+            }
+
+            let repo = job.project.trim_end_matches(".git");
+            let container_name = format!("{}-{}", job.owner, repo).replace('.', "-");
+
+            let pool = pool.clone();
+            let container_settings = container_settings.clone();
+            let secrets = secrets.clone();
+            tokio::spawn(async move {
+                let (exit_code, log) = match run_job(
+                    run_id,
+                    job.project_id,
+                    &container_name,
+                    &job.command,
+                    &pool,
+                    &container_settings,
+                    &secrets,
+                )
+                .await
+                {
+                    Ok(result) => result,
+                    Err(err) => (None, format!("Failed to run job: {err}\n")),
+                };
+
+                let status = match exit_code {
+                    Some(0) => "successful",
+                    _ => "failed",
+                };
+
+                if let Err(err) = sqlx::query!(
+                    r#"UPDATE cron_runs
+                       SET status = $1::text::run_state, exit_code = $2, log = $3, finished_at = now()
+                       WHERE id = $4
+                    "#,
+                    status,
+                    exit_code.map(|code| code as i32),
+                    log,
+                    run_id
+                )
+                .execute(&pool)
+                .await
+                {
+                    tracing::error!(?err, "Can't finish cron run: Failed to query database");
+                }
+
+                // keep the run history short
+                if let Err(err) = sqlx::query!(
+                    r#"DELETE FROM cron_runs
+                       WHERE job_id = $1 AND id NOT IN (
+                         SELECT id FROM cron_runs WHERE job_id = $1 ORDER BY started_at DESC LIMIT $2
+                       )
+                    "#,
+                    job.id,
+                    container_settings.cronhistory
+                )
+                .execute(&pool)
+                .await
+                {
+                    tracing::error!(?err, "Can't prune cron runs: Failed to query database");
+                }
+            });
+        }
+    }
+}
+
+async fn run_job(
+    run_id: Uuid,
+    project_id: Uuid,
+    container_name: &str,
+    command: &[String],
+    pool: &PgPool,
+    container_settings: &ContainerSettings,
+    secrets: &SecretCipher,
+) -> Result<(Option<i64>, String)> {
+    let release = sqlx::query!(
+        r#"SELECT image, config FROM releases WHERE project_id = $1 ORDER BY created_at DESC LIMIT 1"#,
+        project_id
+    )
+    .fetch_optional(pool)
+    .await?
+    .ok_or(anyhow::anyhow!("Project has not been deployed yet"))?;
+
+    let config: ReleaseConfig = serde_json::from_value(release.config)?;
+
+    let db_url = database_url(project_id, pool).await?;
+
+    run_once(
+        container_name,
+        &format!("{}-cron-{}", container_name, run_id),
+        &release.image,
+        &config,
+        &db_url,
+        command,
+        container_settings.crontimeout,
+        container_settings,
+        secrets,
+    )
+    .await
+}
@@ -0,0 +1,430 @@
+use axum::extract::State;
+use axum::response::Response;
+use axum::{Extension, Json};
+use futures::StreamExt;
+use garde::{Unvalidated, Validate};
+use hyper::{Body, StatusCode};
+use serde::{Deserialize, Serialize};
+use sqlx::PgPool;
+
+use crate::activity::record_activity;
+use crate::audit::{record, AuditChange, NewAuditEntry};
+use crate::auth::{tokens::TokenAccess, Auth, User};
+use crate::backups::BackupStorage;
+use crate::configuration::ContainerSettings;
+use crate::labels::Selector;
+use crate::orchestrator;
+use crate::owner::{member_role, Role};
+use crate::projects::remove_project;
+use crate::queue::BuildQueueState;
+use crate::startup::AppState;
+
+/// apps acted on at once, every one of them stops containers
+const CONCURRENCY: usize = 4;
+/// apps a selector may pick, a course is a few hundred of them
+const MAX_SELECTED: i64 = 500;
+
+#[derive(Deserialize, Serialize, Debug, Clone, Copy, PartialEq)]
+#[serde(rename_all = "lowercase")]
+pub enum BulkAction {
+    /// stops every container of the app and starts it again
+    Restart,
+    /// keeps the app stopped like a suspension until it is started
+    Stop,
+    Start,
+    Delete,
+}
+
+impl BulkAction {
+    fn name(&self) -> &'static str {
+        match self {
+            BulkAction::Restart => "restart",
+            BulkAction::Stop => "stop",
+            BulkAction::Start => "start",
+            BulkAction::Delete => "delete",
+        }
+    }
+}
+
+#[derive(Deserialize, Validate, Debug)]
+pub struct BulkProjectsRequest {
+    #[garde(skip)]
+    action: BulkAction,
+    /// owner/project, at most 100
+    #[serde(default)]
+    #[garde(length(max = 100))]
+    apps: Vec<String>,
+    /// picks the apps of the owners the user is a member of instead, see [`Selector`]
+    #[garde(custom(selector_check))]
+    selector: Option<String>,
+}
+
+#[derive(Serialize, Debug)]
+struct BulkResult {
+    app: String,
+    ok: bool,
+    message: String,
+}
+
+#[derive(Serialize, Debug)]
+struct BulkProjectsResponse {
+    /// in the order of the request
+    results: Vec<BulkResult>,
+}
+
+#[derive(Serialize, Debug)]
+struct ErrorResponse {
+    message: String,
+}
+
+fn selector_check(value: &Option<String>, _ctx: &()) -> garde::Result {
+    match value.as_deref().map(str::parse::<Selector>) {
+        Some(Err(err)) => Err(garde::Error::new(err)),
+        Some(Ok(selector)) if selector.is_empty() => Err(garde::Error::new("The selector picks every app, name at least one label")),
+        _ => Ok(()),
+    }
+}
+
+/// Runs an action on every app of the request, each with the role it needs on its own:
+/// maintainers restart, stop and start, owners delete. An app that fails doesn't stop the
+/// others, the results tell how each went and every one is in the audit log of its app. A
+/// selector acts on every app it picks that the user is a member of, whatever their role
+#[tracing::instrument(skip(auth, token, pool, base, backups, build_queue, container_settings))]
+pub async fn post(
+    auth: Auth,
+    token: Option<Extension<TokenAccess>>,
+    State(AppState { pool, base, backups, build_queue, container_settings, .. }): State<AppState>,
+    Json(req): Json<Unvalidated<BulkProjectsRequest>>,
+) -> Response<Body> {
+    let user = auth.current_user.unwrap();
+
+    let BulkProjectsRequest { action, mut apps, selector } = match req.validate(&()) {
+        Ok(valid) => valid.into_inner(),
+        Err(err) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: err.to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+    if let Some(selector) = selector {
+        if !apps.is_empty() {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Give either apps or a selector, not both".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+
+        let (equal, not_equal, present, absent) = selector.parse::<Selector>().unwrap_or_default().params();
+        apps = match sqlx::query!(
+            r#"SELECT DISTINCT project_owners.name AS owner, projects.name AS project
+               FROM projects
+               JOIN project_owners ON projects.owner_id = project_owners.id
+               JOIN users_owners ON project_owners.id = users_owners.owner_id
+               WHERE users_owners.user_id = $1 AND projects.deleted_at IS NULL
+               AND projects.labels @> $2::jsonb AND NOT projects.labels @> ANY($3::jsonb[])
+               AND projects.labels ?& $4::text[] AND NOT projects.labels ?| $5::text[]
+               ORDER BY project_owners.name, projects.name
+               LIMIT $6
+            "#,
+            user.id,
+            equal,
+            &not_equal,
+            &present,
+            &absent,
+            MAX_SELECTED + 1
+        )
+        .fetch_all(&pool)
+        .await
+        {
+            Ok(selected) => selected.into_iter().map(|app| format!("{}/{}", app.owner, app.project)).collect(),
+            Err(err) => {
+                tracing::error!(?err, "Can't select apps: Failed to query database");
+
+                let json = serde_json::to_string(&ErrorResponse {
+                    message: format!("Failed to query database: {}", err.to_string())
+                }).unwrap();
+
+                return Response::builder()
+                    .status(StatusCode::INTERNAL_SERVER_ERROR)
+                    .body(Body::from(json))
+                    .unwrap();
+            }
+        };
+        if apps.len() as i64 > MAX_SELECTED {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("The selector picks more than {MAX_SELECTED} apps, narrow it down")
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    } else if apps.is_empty() {
+        let json = serde_json::to_string(&ErrorResponse {
+            message: "Give the apps to act on, or a selector".to_string()
+        }).unwrap();
+
+        return Response::builder()
+            .status(StatusCode::BAD_REQUEST)
+            .body(Body::from(json))
+            .unwrap();
+    }
+
+    // an app listed twice is acted on once
+    let mut seen = std::collections::HashSet::new();
+    apps.retain(|app| seen.insert(app.clone()));
+
+    let token = token.map(|Extension(token)| token);
+    let results = futures::stream::iter(apps)
+        .map(|app| {
+            let (user, token, pool, base, backups, build_queue, container_settings) =
+                (&user, token.as_ref(), &pool, &base, &backups, &build_queue, &container_settings);
+            async move {
+                let (status, result) = apply(action, &app, user, token, pool, base, backups, build_queue, container_settings).await;
+                if let Some((owner, project)) = app.split_once('/') {
+                    let entry = NewAuditEntry {
+                        actor_id: Some(user.id),
+                        actor: user.username.clone(),
+                        token_id: token.map(|token| token.token_id),
+                        owner: Some(owner.to_string()),
+                        project: Some(project.to_string()),
+                        action: format!("bulk.{}", action.name()),
+                        method: "POST".to_string(),
+                        path: "/api/dashboard/project/bulk".to_string(),
+                        status: status.as_u16() as i32,
+                        ip: None,
+                        change: Some(AuditChange::new(None, Some(serde_json::json!({ "action": action })))),
+                    };
+                    if let Err(err) = record(entry, pool).await {
+                        tracing::error!(?err, "Can't record audit entry: Failed to insert into database");
+                    }
+                }
+
+                let (ok, message) = match result {
+                    Ok(message) => (true, message),
+                    Err(message) => (false, message),
+                };
+                BulkResult { app, ok, message }
+            }
+        })
+        .buffered(CONCURRENCY)
+        .collect::<Vec<_>>()
+        .await;
+
+    let json = serde_json::to_string(&BulkProjectsResponse { results }).unwrap();
+
+    Response::builder()
+        .status(StatusCode::OK)
+        .body(Body::from(json))
+        .unwrap()
+}
+
+/// Runs the action on one app, with the status it is audited with and what happened
+#[allow(clippy::too_many_arguments)]
+async fn apply(
+    action: BulkAction,
+    app: &str,
+    user: &User,
+    token: Option<&TokenAccess>,
+    pool: &PgPool,
+    base: &str,
+    backups: &BackupStorage,
+    build_queue: &BuildQueueState,
+    container_settings: &ContainerSettings,
+) -> (StatusCode, Result<String, String>) {
+    let Some((owner, project)) = app.split_once('/') else {
+        return (StatusCode::BAD_REQUEST, Err("Expected owner/project".to_string()));
+    };
+
+    let project_record = match sqlx::query!(
+        r#"SELECT projects.id, projects.suspended_at, projects.stopped_at, projects.cleanup_stopped_at
+           FROM projects
+           JOIN project_owners ON projects.owner_id = project_owners.id
+           WHERE project_owners.name = $1 AND projects.name = $2 AND projects.deleted_at IS NULL
+        "#,
+        owner,
+        project
+    )
+    .fetch_optional(pool)
+    .await
+    {
+        Ok(Some(record)) => record,
+        Ok(None) => return (StatusCode::NOT_FOUND, Err("App does not exist".to_string())),
+        Err(err) => {
+            tracing::error!(?err, app, "Can't run bulk action: Failed to query database");
+            return (StatusCode::INTERNAL_SERVER_ERROR, Err(format!("Failed to query database: {err}")));
+        }
+    };
+
+    let needed = match action {
+        BulkAction::Delete => Role::Owner,
+        _ => Role::Maintainer,
+    };
+    if let Some(token) = token {
+        if token.project_id.is_some_and(|id| id != project_record.id) {
+            return (StatusCode::FORBIDDEN, Err("The token is for another app".to_string()));
+        }
+        if !token.scope.allows(needed, "") {
+            return (StatusCode::FORBIDDEN, Err(format!("The token can't {} apps, that needs the admin scope", action.name())));
+        }
+    }
+    match member_role(user.id, owner, pool).await {
+        Ok(Some(role)) if role >= needed => {}
+        // the app of an owner the user isn't in doesn't exist for them
+        Ok(None) => return (StatusCode::NOT_FOUND, Err("App does not exist".to_string())),
+        Ok(Some(role)) => {
+            return (StatusCode::FORBIDDEN, Err(format!("Only a {needed} of {owner} can {} it, you are a {role}", action.name())));
+        }
+        Err(err) => {
+            tracing::error!(?err, app, "Can't run bulk action: Failed to query database");
+            return (StatusCode::INTERNAL_SERVER_ERROR, Err(format!("Failed to query database: {err}")));
+        }
+    }
+
+    let container_name = format!("{owner}-{project}").replace('.', "-");
+    let suspended = match (project_record.stopped_at, project_record.cleanup_stopped_at, project_record.suspended_at) {
+        (Some(_), _, _) => Some("App is stopped, start it first"),
+        (_, Some(_), _) => Some("App was stopped by the cleanup, restore it with pmk cleanup restore"),
+        (_, _, Some(_)) => Some("App is suspended by the platform admins"),
+        _ => None,
+    };
+
+    match action {
+        BulkAction::Restart => {
+            if let Some(suspended) = suspended {
+                return (StatusCode::CONFLICT, Err(suspended.to_string()));
+            }
+            // a suspension without the rows, resume starts every container it stopped
+            let driver = orchestrator::driver();
+            if let Err(err) = driver.suspend(&container_name, container_settings).await {
+                tracing::error!(?err, app, "Can't restart app: Failed to stop containers");
+                return (StatusCode::INTERNAL_SERVER_ERROR, Err("Failed to stop the containers".to_string()));
+            }
+            if let Err(err) = driver.resume(&container_name).await {
+                tracing::error!(?err, app, "Can't restart app: Failed to start containers");
+                return (StatusCode::INTERNAL_SERVER_ERROR, Err("Some containers failed to start. Deploy it again".to_string()));
+            }
+
+            let message = format!("Restarted by {}", user.username);
+            if let Err(err) = record_activity(project_record.id, "restart", &message, pool).await {
+                tracing::error!(?err, "Can't record activity: Failed to insert into database");
+            }
+            (StatusCode::OK, Ok("Restarted".to_string()))
+        }
+        BulkAction::Stop => {
+            if project_record.suspended_at.is_some() {
+                return (StatusCode::CONFLICT, Err(suspended.unwrap_or("App is suspended").to_string()));
+            }
+
+            // a suspension keeps it stopped: the proxy answers 503, cron jobs don't run and the
+            // reconciler leaves it be
+            let reason = format!("Stopped by {}, a member starts it again with pmk apps start", user.username);
+            match sqlx::query!(
+                r#"UPDATE projects SET suspended_at = now(), suspended_reason = $2, stopped_at = now()
+                   WHERE id = $1 AND suspended_at IS NULL
+                "#,
+                project_record.id,
+                reason
+            )
+            .execute(pool)
+            .await
+            {
+                Ok(done) if done.rows_affected() == 0 => {
+                    return (StatusCode::CONFLICT, Err("App is already stopped".to_string()));
+                }
+                Ok(_) => {}
+                Err(err) => {
+                    tracing::error!(?err, app, "Can't stop app: Failed to update database");
+                    return (StatusCode::INTERNAL_SERVER_ERROR, Err("Failed to update database".to_string()));
+                }
+            }
+
+            // a build that finishes would start the app again
+            match sqlx::query!(
+                r#"SELECT id FROM builds WHERE project_id = $1 AND status IN ('pending', 'building')"#,
+                project_record.id
+            )
+            .fetch_all(pool)
+            .await
+            {
+                Ok(builds) => {
+                    for build in builds {
+                        build_queue.cancel(build.id, "Cancelled, the app was stopped", pool).await;
+                    }
+                }
+                Err(err) => tracing::error!(?err, "Can't cancel builds: Failed to query database"),
+            }
+
+            if let Err(err) = orchestrator::driver().suspend(&container_name, container_settings).await {
+                tracing::error!(?err, app, "Can't stop app: Failed to stop containers");
+                return (StatusCode::INTERNAL_SERVER_ERROR, Err("App is stopped, but some containers failed to stop. Try again".to_string()));
+            }
+
+            if let Err(err) = record_activity(project_record.id, "stop", &reason, pool).await {
+                tracing::error!(?err, "Can't record activity: Failed to insert into database");
+            }
+            (StatusCode::OK, Ok("Stopped".to_string()))
+        }
+        BulkAction::Start => {
+            if project_record.stopped_at.is_none() {
+                return match suspended {
+                    Some(suspended) => (StatusCode::CONFLICT, Err(suspended.to_string())),
+                    None => (StatusCode::CONFLICT, Err("App isn't stopped".to_string())),
+                };
+            }
+
+            // only lifts the stop of a member, an admin suspending the app since clears stopped_at
+            match sqlx::query!(
+                r#"UPDATE projects SET suspended_at = NULL, suspended_reason = NULL, stopped_at = NULL
+                   WHERE id = $1 AND stopped_at IS NOT NULL
+                "#,
+                project_record.id
+            )
+            .execute(pool)
+            .await
+            {
+                Ok(done) if done.rows_affected() == 0 => {
+                    return (StatusCode::CONFLICT, Err("App isn't stopped".to_string()));
+                }
+                Ok(_) => {}
+                Err(err) => {
+                    tracing::error!(?err, app, "Can't start app: Failed to update database");
+                    return (StatusCode::INTERNAL_SERVER_ERROR, Err("Failed to update database".to_string()));
+                }
+            }
+
+            if let Err(err) = orchestrator::driver().resume(&container_name).await {
+                tracing::error!(?err, app, "Can't start app: Failed to start containers");
+                return (StatusCode::INTERNAL_SERVER_ERROR, Err("App is started, but some containers failed to start. Deploy it again".to_string()));
+            }
+
+            let message = format!("Started by {}", user.username);
+            if let Err(err) = record_activity(project_record.id, "start", &message, pool).await {
+                tracing::error!(?err, "Can't record activity: Failed to insert into database");
+            }
+            (StatusCode::OK, Ok("Started".to_string()))
+        }
+        BulkAction::Delete => {
+            let status = remove_project(owner, project, base, backups, pool).await;
+            let failed = status
+                .iter()
+                .filter(|(_, result)| result.starts_with("failed") && !result.ends_with("does not exist"))
+                .map(|(part, result)| format!("{part}: {result}"))
+                .collect::<Vec<_>>();
+            match failed.is_empty() {
+                true => (StatusCode::OK, Ok("Deleted".to_string())),
+                false => (StatusCode::INTERNAL_SERVER_ERROR, Err(format!("Failed to delete: {}", failed.join(", ")))),
+            }
+        }
+    }
+}
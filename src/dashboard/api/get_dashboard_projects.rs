@@ -1,35 +1,135 @@
+use crate::labels::{from_json, Labels, Selector};
+use crate::pagination::{self, INVALID_CURSOR};
+use crate::projects::APP_STATUSES;
 use crate::{auth::Auth, startup::AppState};
-use axum::extract::State;
+use axum::extract::{Query, State};
 use axum::response::Response;
-use hyper::Body;
-use leptos::ssr::render_to_string;
-use leptos::{view, IntoView};
-use serde::Serialize;
+use chrono::{DateTime, Utc};
+use hyper::{Body, StatusCode};
+use serde::{Deserialize, Serialize};
 use uuid::Uuid;
 
+#[derive(Deserialize, Debug)]
+pub struct DashboardProjectQuery {
+    /// only the apps of this owner
+    owner: Option<String>,
+    /// only the apps doing this, one of [`APP_STATUSES`]
+    status: Option<String>,
+    /// only the apps with these labels, like `course=COMP301,team!=blue`, see [`Selector`]
+    selector: Option<String>,
+    /// `next_cursor` of the page before
+    cursor: Option<String>,
+    limit: Option<i64>,
+}
+
 #[derive(Serialize, Debug)]
 struct Project {
     id: Uuid,
     name: String,
     owner_name: String,
+    status: String,
+    labels: Labels,
+    created_at: DateTime<Utc>,
 }
 
 #[derive(Serialize, Debug)]
 struct DashboardProjectResponse {
-    data: Vec<Project>
+    data: Vec<Project>,
+    /// missing once there are no older apps
+    next_cursor: Option<String>,
+}
+
+#[derive(Serialize, Debug)]
+struct ErrorResponse {
+    message: String,
 }
-pub async fn get(auth: Auth, State(AppState { pool, .. }): State<AppState>) -> Response<Body> {
+
+/// The apps of every owner the user is a member of, newest first
+#[tracing::instrument(skip(auth, pool))]
+pub async fn get(
+    auth: Auth,
+    State(AppState { pool, .. }): State<AppState>,
+    Query(DashboardProjectQuery { owner, status, selector, cursor, limit }): Query<DashboardProjectQuery>,
+) -> Response<Body> {
     let user = auth.current_user.unwrap();
 
+    if let Some(status) = status.as_deref().filter(|status| !APP_STATUSES.contains(status)) {
+        let json = serde_json::to_string(&ErrorResponse {
+            message: format!("Unknown status {status}, it must be one of {}", APP_STATUSES.join(", "))
+        }).unwrap();
+
+        return Response::builder()
+            .status(StatusCode::BAD_REQUEST)
+            .body(Body::from(json))
+            .unwrap();
+    }
+
+    let selector = match selector.as_deref().unwrap_or_default().parse::<Selector>() {
+        Ok(selector) => selector,
+        Err(err) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: err
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+    let (equal, not_equal, present, absent) = selector.params();
+
+    let (before, before_id) = match cursor.as_deref().map(pagination::parse_cursor) {
+        Some(Some((before, before_id))) => (Some(before), Some(before_id)),
+        Some(None) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: INVALID_CURSOR.to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        None => (None, None),
+    };
+    let limit = pagination::limit(limit);
+
+    // a stop of the cleanup is a suspension too, it shows as stopped like one of a member
     let projects = match sqlx::query!(
-        r#"SELECT projects.id AS id, projects.name AS project, project_owners.name AS owner
-           FROM projects
-           JOIN project_owners ON projects.owner_id = project_owners.id
-           JOIN users_owners ON project_owners.id = users_owners.owner_id
-           JOIN users ON users_owners.user_id = users.id
-           WHERE users.id = $1
+        r#"SELECT id AS "id!", project AS "project!", owner AS "owner!", status AS "status!", labels AS "labels!", created_at AS "created_at!"
+           FROM (
+             SELECT projects.id, projects.name AS project, project_owners.name AS owner, projects.labels, projects.created_at,
+                    CASE WHEN projects.stopped_at IS NOT NULL OR projects.cleanup_stopped_at IS NOT NULL THEN 'stopped'
+                         WHEN projects.suspended_at IS NOT NULL THEN 'suspended'
+                         WHEN projects.crash_looping_at IS NOT NULL THEN 'crashing'
+                         WHEN projects.maintenance_at IS NOT NULL THEN 'maintenance'
+                         WHEN NOT EXISTS (SELECT 1 FROM releases WHERE releases.project_id = projects.id) THEN 'new'
+                         ELSE 'running'
+                    END AS status
+             FROM projects
+             JOIN project_owners ON projects.owner_id = project_owners.id
+             JOIN users_owners ON project_owners.id = users_owners.owner_id
+             WHERE users_owners.user_id = $1
+           ) AS apps
+           WHERE ($2::text IS NULL OR owner = $2)
+           AND ($3::text IS NULL OR status = $3)
+           AND ($4::timestamptz IS NULL OR (created_at, id) < ($4, $5::uuid))
+           AND labels @> $7::jsonb AND NOT labels @> ANY($8::jsonb[])
+           AND labels ?& $9::text[] AND NOT labels ?| $10::text[]
+           ORDER BY created_at DESC, id DESC
+           LIMIT $6
         "#,
-        user.id
+        user.id,
+        owner,
+        status,
+        before,
+        before_id,
+        limit,
+        equal,
+        &not_equal,
+        &present,
+        &absent
     )
     .fetch_all(&pool)
     .await
@@ -37,24 +137,27 @@ pub async fn get(auth: Auth, State(AppState { pool, .. }): State<AppState>) -> R
         Ok(data) => data,
         Err(err) => {
             tracing::error!(?err, "Can't get projects: Failed to query database");
-            let html = render_to_string(move || {
-                view! {
-                    <h1> "Failed to query database "{err.to_string() } </h1>
-                }
-            })
-            .into_owned();
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
             return Response::builder()
-                .status(500)
-                .body(Body::from(html))
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
                 .unwrap();
         }
     };
 
+    let next_cursor = pagination::next_cursor(&projects, limit, |record| (record.created_at, record.id));
     let projects = projects.into_iter().map(|record|{ 
         Project {
             id: record.id,
             name: record.project,
             owner_name: record.owner,
+            status: record.status,
+            labels: from_json(record.labels),
+            created_at: record.created_at,
         }
     }).collect::<Vec<_>>();
 
@@ -63,7 +166,8 @@ pub async fn get(auth: Auth, State(AppState { pool, .. }): State<AppState>) -> R
         .body(
             Body::from(serde_json::to_string(
                 &DashboardProjectResponse {
-                    data: projects
+                    data: projects,
+                    next_cursor,
                 }
             ).unwrap())
         )
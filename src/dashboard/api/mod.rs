@@ -1,14 +1,16 @@
 use crate::{auth::auth, startup::AppState};
 use crate::configuration::Settings;
-use axum::routing::get;
+use axum::routing::{get, post};
 use axum::{Router, middleware};
 use axum_extra::routing::RouterExt;
 use hyper::Body;
 
+mod bulk_projects;
 mod get_dashboard_projects;
 
 pub async fn router(_state: AppState, _config: &Settings) -> Router<AppState, Body> {
     Router::new()
         .route_with_tsr("/api/dashboard/project", get(get_dashboard_projects::get))
+        .route_with_tsr("/api/dashboard/project/bulk", post(bulk_projects::post))
         .route_layer(middleware::from_fn(auth))
 }
\ No newline at end of file
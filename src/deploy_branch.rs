@@ -0,0 +1,56 @@
+use sqlx::PgPool;
+
+use crate::queue::BuildKind;
+
+/// Which branch of an app deploys and how, set with `pmk deploy-branch`
+#[derive(Debug, Clone, Default)]
+pub struct DeployBranch {
+    /// None deploys the branch HEAD of the repository points at, see [`crate::git`]
+    pub branch: Option<String>,
+    /// pushes to the branch only build, the build waits on no requests until it is promoted
+    pub promote: bool,
+    /// the app has a live release, the first deploy never waits for a promote
+    pub deployed: bool,
+}
+
+impl DeployBranch {
+    /// What a push to the deploy branch queues. A canary on no requests is the build that
+    /// waits, `pmk releases promote` makes it live like any canary
+    pub fn build_kind(&self) -> BuildKind {
+        match self.promote && self.deployed {
+            true => BuildKind::Canary(0),
+            false => BuildKind::Build,
+        }
+    }
+}
+
+pub async fn deploy_branch(owner: &str, project: &str, pool: &PgPool) -> Result<DeployBranch, sqlx::Error> {
+    let project = sqlx::query!(
+        r#"SELECT projects.deploy_branch, projects.deploy_promote,
+               EXISTS(SELECT 1 FROM domains WHERE domains.project_id = projects.id) AS "deployed!"
+           FROM projects
+           JOIN project_owners ON projects.owner_id = project_owners.id
+           WHERE project_owners.name = $1 AND projects.name = $2
+        "#,
+        owner,
+        project.trim_end_matches(".git")
+    )
+    .fetch_optional(pool)
+    .await?;
+
+    Ok(project
+        .map(|project| DeployBranch {
+            branch: project.deploy_branch,
+            promote: project.deploy_promote,
+            deployed: project.deployed,
+        })
+        .unwrap_or_default())
+}
+
+/// Checks a branch name the way git does, so a deploy branch can always be pushed
+pub fn branch_check(branch: &str) -> Result<(), String> {
+    match git2::Branch::name_is_valid(branch) {
+        Ok(true) => Ok(()),
+        _ => Err(format!("{branch} is not a valid branch name")),
+    }
+}
@@ -1,47 +1,800 @@
 use std::process::Output;
-use std::{collections::HashMap, process::Stdio};
+use std::{
+    collections::{BTreeMap, HashMap, HashSet},
+    path::PathBuf,
+    process::Stdio,
+    sync::atomic::{AtomicU32, Ordering},
+};
 
 use anyhow::Result;
-use bollard::network::DisconnectNetworkOptions;
 use bollard::{
-    container::{Config, CreateContainerOptions, ListContainersOptions, StartContainerOptions},
-    image::{ListImagesOptions, TagImageOptions},
+    auth::DockerCredentials,
+    container::{
+        AttachContainerOptions, AttachContainerResults, Config, CreateContainerOptions,
+        KillContainerOptions, ListContainersOptions, LogOutput, LogsOptions,
+        RemoveContainerOptions, RenameContainerOptions, StartContainerOptions,
+        StopContainerOptions, WaitContainerOptions,
+    },
+    exec::{CreateExecOptions, StartExecResults},
+    image::{CreateImageOptions, ListImagesOptions, TagImageOptions},
     network::{ConnectNetworkOptions, InspectNetworkOptions, ListNetworksOptions},
-    service::{HostConfig, NetworkContainer, RestartPolicy, RestartPolicyNameEnum},
-    volume::{CreateVolumeOptions, ListVolumesOptions},
+    service::{
+        EndpointSettings, HostConfig, NetworkContainer, RestartPolicy,
+        RestartPolicyNameEnum,
+    },
+    volume::CreateVolumeOptions,
     Docker,
 };
+use futures::{StreamExt, TryStreamExt};
 use nixpacks::{
     create_docker_image,
     nixpacks::{builder::docker::DockerBuilderOptions, plan::generator::GeneratePlanOptions},
 };
 use procfile;
 use rand::{Rng, SeedableRng};
+use serde::{Deserialize, Serialize};
 use sqlx::PgPool;
+use tokio::io::{AsyncBufReadExt, AsyncWriteExt, BufReader};
 use tokio::process::Command;
+use tokio::time::Instant;
+use tokio_util::sync::CancellationToken;
+use uuid::Uuid;
+
+use crate::arch;
+use crate::buildpacks::Buildpack;
+use crate::configuration::{BuilderSettings, ContainerSettings};
+use crate::egress::apply_egress;
+use crate::env_groups::group_environment;
+use crate::in_flight;
+use crate::limits::{project_limits, ResourceLimits};
+use crate::live::{self, Update};
+use crate::mail::mail_environment;
+use crate::restarts::{project_restarts, Restarts};
+use crate::runtime::harden;
+use crate::previews::preview_environment;
+use crate::redis::redis_environment;
+use crate::manifest::{Manifest, MANIFEST_FILE};
+use crate::nodes;
+use crate::orchestrator;
+use crate::proxy_signature::SECRET_ENV;
+use crate::registry::pull_release_image;
+use crate::scanning::{check_image, Source};
+use crate::secrets::SecretCipher;
+use crate::services::{private_network, service_network};
+use crate::storage::storage_environment;
+use crate::sites::{publish, StaticSite};
+use crate::volumes::{check_volumes, project_mounts};
+
+/// error of a build stopped by [`CancellationToken`], the queue records it as cancelled
+/// instead of failed
+#[derive(thiserror::Error, Debug)]
+#[error("Build cancelled")]
+pub struct BuildCancelled;
+
+/// error of a build that ran past its time limit, it fails with the log it got so far
+#[derive(thiserror::Error, Debug)]
+#[error("Build killed: exceeded the build time limit of {} seconds", .0.as_secs())]
+pub struct BuildTimedOut(pub std::time::Duration);
+
+/// time a cancelled or timed out build gets after SIGTERM to stop its steps, it is killed after
+const STOP_GRACE: std::time::Duration = std::time::Duration::from_secs(10);
+
+/// buildkit builder every build runs on once [`setup_builder`] made it
+pub const BUILDER_NAME: &str = "pemasak-builder";
+/// registers the QEMU emulators of buildkit with binfmt_misc
+const BINFMT_IMAGE: &str = "tonistiigi/binfmt:latest";
+
+/// Creates the buildkit builder in a container of its own, capped at the cpu and memory of
+/// `max` builds. Buildkit runs the steps of every build inside its daemon and ignores the
+/// limits docker build takes, so the cap holds for the builds running together rather than
+/// each one. It is recreated on every start to pick up changed limits, its state with the
+/// cache mounts is kept
+pub async fn setup_builder(settings: &BuilderSettings) -> Result<()> {
+    let memory = settings.memory_bytes() * settings.max as u64;
+    let cpu_quota = settings.cpums * settings.max as i64;
+
+    // fails when there is no builder from an earlier start
+    let _ = Command::new("docker")
+        .args(["buildx", "rm", "--keep-state", BUILDER_NAME])
+        .output()
+        .await;
+
+    let output = Command::new("docker")
+        .args([
+            "buildx",
+            "create",
+            "--name",
+            BUILDER_NAME,
+            "--driver",
+            "docker-container",
+            "--driver-opt",
+            &format!("memory={memory}"),
+            "--driver-opt",
+            &format!("memory-swap={memory}"),
+            "--driver-opt",
+            "cpu-period=100000",
+            "--driver-opt",
+            &format!("cpu-quota={cpu_quota}"),
+            "--bootstrap",
+        ])
+        .output()
+        .await?;
+
+    if !output.status.success() {
+        return Err(anyhow::anyhow!(
+            "Failed to create builder: {}",
+            String::from_utf8_lossy(&output.stderr)
+        ));
+    }
+
+    // nixpacks runs docker build on its own, the variable points it at the builder too
+    std::env::set_var("BUILDX_BUILDER", BUILDER_NAME);
+    Ok(())
+}
+
+/// Registers QEMU for the architectures of `build.emulate` with the kernel, so builds for apps
+/// on nodes of another architecture run their steps emulated. Buildkit picks the emulators up
+/// when the builder starts, so this runs before [`setup_builder`]. The registration lasts until
+/// the host reboots
+pub async fn setup_emulation(settings: &BuilderSettings) -> Result<()> {
+    let host = arch::host();
+    let emulate = settings
+        .emulate
+        .iter()
+        .map(|emulated| arch::normalize(emulated))
+        .filter(|emulated| *emulated != host)
+        .collect::<Vec<_>>();
+    if emulate.is_empty() {
+        return Ok(());
+    }
+
+    let output = Command::new("docker")
+        .args([
+            "run",
+            "--privileged",
+            "--rm",
+            BINFMT_IMAGE,
+            "--install",
+            &emulate.join(","),
+        ])
+        .output()
+        .await?;
+
+    if !output.status.success() {
+        return Err(anyhow::anyhow!(
+            "Failed to install emulators: {}",
+            String::from_utf8_lossy(&output.stderr)
+        ));
+    }
+
+    tracing::info!(?emulate, "Builds emulate other architectures");
+    arch::set_emulated(emulate);
+    Ok(())
+}
+
+/// a step killed by the kernel exits with 137, buildkit reports it in plain progress like
+/// `did not complete successfully: exit code: 137`
+fn killed_note(build_log: &str) -> &'static str {
+    match build_log.contains("exit code: 137") {
+        true => "Build killed: exceeded the memory limit of the builder\n",
+        false => "",
+    }
+}
+
+/// Stops a Dockerfile build like docker stop does a container: SIGTERM makes the docker cli
+/// cancel the steps running in buildkit, what it prints meanwhile still goes to the log. The
+/// cli is killed with the build once [`STOP_GRACE`] is over. `pid` 0 is a build that has no
+/// cli of its own
+async fn stop_build<F: std::future::Future>(pid: u32, build: std::pin::Pin<&mut F>) {
+    if pid == 0 {
+        return;
+    }
+    if let Err(err) = Command::new("kill").args(["-TERM", &pid.to_string()]).status().await {
+        tracing::error!(?err, "Can't stop build: Failed to send SIGTERM");
+        return;
+    }
+    let _ = tokio::time::timeout(STOP_GRACE, build).await;
+}
 
 const CHARSET: &[u8] = b"ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789-_";
+const LOG_FLUSH_INTERVAL: std::time::Duration = std::time::Duration::from_millis(500);
+/// how long a new postgres addon gets to accept connections
+const POSTGRES_READY_TIMEOUT: std::time::Duration = std::time::Duration::from_secs(60);
+/// label on worker containers and extra web replicas, the value is the container name of the
+/// project
+pub const WORKER_LABEL: &str = "pemasak.worker";
+/// label on worker containers and extra web replicas, the value is the process type
+const PROCESS_LABEL: &str = "pemasak.process";
 
 pub struct DockerContainer {
+    pub id: String,
     pub ip: String,
     pub port: i32,
     pub build_log: String,
     pub db_url: String,
+    /// image id the container runs, kept with the release for rollbacks
+    pub image: String,
+    pub config: ReleaseConfig,
+    /// directory the proxy serves a static site from, no container runs for it
+    pub site: Option<String>,
+}
+
+/// Everything a container needs besides its image. Stored with every release so a rollback
+/// runs the old image the same way it ran before
+#[derive(Serialize, Deserialize, Debug, Clone, Default)]
+pub struct ReleaseConfig {
+    /// user environment, PORT and DATABASE_URL are added when the container starts
+    pub env: Vec<String>,
+    /// encrypted user secrets by name, decrypted only when the container starts
+    #[serde(default)]
+    pub secrets: BTreeMap<String, String>,
+    pub cmd: Option<Vec<String>>,
+    /// command of every Procfile process type besides web and release
+    #[serde(default)]
+    pub workers: BTreeMap<String, Vec<String>>,
+    /// set for the services of a multi-service app
+    #[serde(default)]
+    pub service: Option<ServiceNetwork>,
+    /// the network shared by every app of the owner
+    #[serde(default)]
+    pub private: Option<ServiceNetwork>,
+    /// volumes attached to the project when the release started, see [`crate::volumes`]
+    #[serde(default)]
+    pub volumes: Vec<VolumeMount>,
+    /// memory and cpus of every container, the defaults for releases from before limits.
+    /// Kept up to date by [`crate::limits::apply_limits`]
+    #[serde(default)]
+    pub limits: Option<ResourceLimits>,
+    /// how docker restarts the web and worker containers, on failure for releases from before
+    /// restart policies. Kept up to date by [`crate::restarts::apply_restarts`]
+    #[serde(default)]
+    pub restarts: Option<Restarts>,
+    /// the build output the proxy serves instead of running the image, see [`crate::sites`]
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub site: Option<StaticSite>,
+}
+
+#[derive(Serialize, Deserialize, Debug, Clone)]
+pub struct VolumeMount {
+    /// name of the docker volume
+    pub volume: String,
+    /// where it is in the container
+    pub path: String,
+}
+
+/// `volume:path` binds of the volumes of a release
+fn volume_binds(release_config: &ReleaseConfig) -> Option<Vec<String>> {
+    Some(
+        release_config
+            .volumes
+            .iter()
+            .map(|mount| format!("{}:{}", mount.volume, mount.path))
+            .collect(),
+    )
+}
+
+/// Container config limiting memory and cpus to those of the release. Swap counts against
+/// the memory limit, so the app is killed instead of slowing down the whole host. The
+/// capabilities are cut down too, see [`crate::runtime::harden`]
+fn limited_host_config(
+    release_config: &ReleaseConfig,
+    container_settings: &ContainerSettings,
+    host_config: HostConfig,
+) -> HostConfig {
+    let limits = release_config
+        .limits
+        .clone()
+        .unwrap_or_else(|| ResourceLimits::defaults(container_settings));
+
+    harden(container_settings, HostConfig {
+        memory: Some(limits.memory_bytes()),
+        memory_swap: Some(limits.memory_bytes()),
+        nano_cpus: Some(limits.nano_cpus()),
+        ..host_config
+    })
+}
+
+/// A network a container joins besides the one of its project, see [`crate::services`]
+#[derive(Serialize, Deserialize, Debug, Clone)]
+pub struct ServiceNetwork {
+    pub network: String,
+    /// hostname of the web containers of the project on the network
+    pub alias: String,
+}
+
+/// Connects a container to the service network of its app and the private network of its
+/// owner. Only web containers answer to the aliases, a worker behind one would get requests
+/// meant for the web process
+async fn join_service_network(
+    docker: &Docker,
+    release_config: &ReleaseConfig,
+    container: &str,
+    web: bool,
+) -> Result<()> {
+    for joined in [&release_config.service, &release_config.private].into_iter().flatten() {
+        ensure_network(docker, &joined.network, true).await?;
+        docker
+            .connect_network(
+                &joined.network,
+                ConnectNetworkOptions {
+                    container,
+                    endpoint_config: EndpointSettings {
+                        aliases: web.then(|| vec![joined.alias.clone()]),
+                        ..Default::default()
+                    },
+                },
+            )
+            .await
+            .map_err(|err| {
+                tracing::error!("Failed to connect {} network: {}", joined.network, err);
+                err
+            })?;
+    }
+    Ok(())
+}
+
+/// The environment variables and encrypted secrets a project's containers are started with.
+/// The variables of its bucket and redis come first, then its env groups, and the app's own
+/// variables win over them. The key the proxy signs requests with comes last, it replaces whatever the
+/// app set under its name
+pub async fn project_environment(
+    owner: &str,
+    project_name: &str,
+    pool: &PgPool,
+) -> Result<(Vec<String>, BTreeMap<String, String>)> {
+    let project = sqlx::query!(
+        r#"SELECT projects.id, environs, secrets, proxy_secret
+        FROM projects
+        JOIN project_owners ON projects.owner_id = project_owners.id
+        WHERE projects.name = $1 AND project_owners.name = $2"#,
+        project_name, owner,
+    )
+    .fetch_one(pool)
+    .await
+    .map_err(|err| {
+        tracing::error!(?err, "Failed to query database: {}", err);
+        err
+    })?;
+
+    let own_env = match project.environs.as_object() {
+        Some(map) => map
+            .into_iter()
+            .map(|(key, value)| format!("{}={}", key, value.as_str().unwrap()))
+            .collect::<Vec<_>>(),
+        None => {
+            tracing::error!("Non object value passed as environment variable {}/{}", owner, project_name);
+            return Err(anyhow::anyhow!("Non object value passed as environment variable {}/{}", owner, project_name));
+        }
+    };
+
+    let own_secrets = serde_json::from_value::<BTreeMap<String, String>>(project.secrets).map_err(|err| {
+        tracing::error!(?err, "Non string value stored as secret {}/{}", owner, project_name);
+        err
+    })?;
+
+    let (mut group_env, mut secrets) = group_environment(project.id, pool).await?;
+    let own = |key: &String| project.environs.get(key).is_some() || own_secrets.contains_key(key);
+    group_env.retain(|key, _| !own(key));
+    secrets.retain(|key, _| !own(key));
+
+    let proxy_secret = format!("{SECRET_ENV}=");
+    let env = storage_environment(project.id, pool)
+        .await?
+        .into_iter()
+        .chain(redis_environment(project.id, pool).await?)
+        .chain(mail_environment(project.id, pool).await?)
+        .chain(group_env.into_iter().map(|(key, value)| format!("{key}={value}")))
+        .chain(own_env)
+        .filter(|var| !var.starts_with(&proxy_secret))
+        .chain([format!("{proxy_secret}{}", project.proxy_secret)])
+        .collect();
+    secrets.extend(own_secrets);
+    secrets.remove(SECRET_ENV);
+
+    Ok((env, secrets))
+}
+
+/// Full environment of an app container. Secrets come last so they win over plain variables
+/// with the same name
+pub fn container_env(
+    release_config: &ReleaseConfig,
+    port: i32,
+    db_url: &str,
+    secrets: &SecretCipher,
+) -> Result<Vec<String>> {
+    let mut env = vec![format!("PORT={}", port)];
+    if !db_url.is_empty() {
+        env.push(format!("DATABASE_URL={}", db_url));
+    }
+    env.extend(release_config.env.iter().cloned());
+
+    for (key, value) in &release_config.secrets {
+        let value = secrets.decrypt(value).map_err(|err| {
+            tracing::error!(?err, "Failed to decrypt secret {}", key);
+            err
+        })?;
+        env.push(format!("{}={}", key, value));
+    }
+
+    Ok(env)
+}
+
+/// Creates the project network unless it exists. The app, its workers, one-off containers
+/// and addons all talk over it. Service and private networks are `internal`, without a way
+/// out the egress rules of the project network couldn't be bypassed over them, see
+/// [`crate::egress`]
+pub async fn ensure_network(docker: &Docker, network_name: &str, internal: bool) -> Result<()> {
+    let network = docker
+        .list_networks(Some(ListNetworksOptions {
+            filters: HashMap::from([("name".to_string(), vec![network_name.to_string()])]),
+        }))
+        .await
+        .map_err(|err| {
+            tracing::error!("Failed to list networks: {}", err);
+            err
+        })?
+        .into_iter()
+        // the name filter matches substrings
+        .find(|n| n.name.as_deref() == Some(network_name));
+
+    match network {
+        Some(n) => {
+            tracing::info!("Existing network id -> {:?}", n.id);
+            // ones from before egress policies are only internal once nothing uses them
+            if internal && n.internal != Some(true) {
+                tracing::warn!(network = network_name, "Network is not internal, apps on it can bypass egress policies");
+            }
+        }
+        None => {
+            let options = bollard::network::CreateNetworkOptions {
+                name: network_name.to_string(),
+                internal,
+                ..Default::default()
+            };
+            let res = docker.create_network(options).await.map_err(|err| {
+                tracing::error!("Failed to create network: {}", err);
+                err
+            })?;
+            tracing::info!("create network response-> {:#?}", res);
+        }
+    };
+
+    Ok(())
+}
+
+/// DATABASE_URL of the project's postgres addon, empty when it has none
+pub async fn database_url(project_id: Uuid, pool: &PgPool) -> Result<String> {
+    let addon = sqlx::query!(
+        "SELECT url FROM addons WHERE project_id = $1 AND kind = 'postgres'",
+        project_id
+    )
+    .fetch_optional(pool)
+    .await?;
+
+    Ok(addon.map(|addon| addon.url).unwrap_or_default())
+}
+
+/// Starts a dedicated postgres container for a project on its network, with its data in the
+/// project volume. `connection_limit` caps max_connections so one app can't exhaust the
+/// memory of the host. Returns the container name and the url for DATABASE_URL.
+#[tracing::instrument]
+pub async fn provision_postgres(container_name: &str, connection_limit: i32) -> Result<(String, String)> {
+    let network_name = format!("{}-network", container_name);
+    let db_name = format!("{}-db", container_name);
+    let volume_name = format!("{}-volume", container_name);
+
+    let docker = nodes::docker(container_name).map_err(|err| {
+        tracing::error!("Failed to connect to docker: {}", err);
+        err
+    })?;
+
+    ensure_network(&docker, &network_name, false).await?;
+
+    let res = docker
+        .create_volume(CreateVolumeOptions {
+            name: volume_name.clone(),
+            ..Default::default()
+        })
+        .await
+        .map_err(|err| {
+            tracing::error!("Failed to create volume: {}", err);
+            err
+        })?;
+    tracing::info!("create volume response-> {:#?}", res);
+
+    let mut rng = rand::rngs::StdRng::from_entropy();
+    let username = (0..10)
+        .map(|_| CHARSET[rng.gen_range(0..CHARSET.len())] as char)
+        .collect::<String>();
+
+    let password = (0..20)
+        .map(|_| CHARSET[rng.gen_range(0..CHARSET.len())] as char)
+        .collect::<String>();
+
+    let config = Config {
+        image: Some("postgres:16.0-alpine3.18".to_string()),
+        volumes: Some(HashMap::from([(
+            format!("{volume_name}:/var/lib/postgresql/data"),
+            HashMap::new(),
+        )])),
+        env: Some(vec![
+            format!("POSTGRES_USER={}", username),
+            format!("POSTGRES_PASSWORD={}", password),
+            format!("POSTGRES_DB={}", "postgres"),
+        ]),
+        cmd: Some(vec![
+            "postgres".to_string(),
+            "-c".to_string(),
+            format!("max_connections={}", connection_limit),
+        ]),
+        host_config: Some(HostConfig {
+            restart_policy: Some(RestartPolicy {
+                name: Some(RestartPolicyNameEnum::ON_FAILURE),
+                ..Default::default()
+            }),
+            network_mode: Some(network_name),
+            ..Default::default()
+        }),
+        ..Default::default()
+    };
+
+    docker
+        .create_container(
+            Some(CreateContainerOptions {
+                name: db_name.clone(),
+                platform: None,
+            }),
+            config,
+        )
+        .await
+        .map_err(|err| {
+            tracing::error!("Failed to create container: {}", err);
+            err
+        })?;
+
+    if let Err(err) = docker
+        .start_container(&db_name, None::<StartContainerOptions<&str>>)
+        .await
+    {
+        tracing::error!("Failed to start container: {}", err);
+        let _ = remove_postgres(container_name).await;
+        return Err(err.into());
+    }
+
+    if let Err(err) = wait_for_postgres(&docker, &db_name, &username).await {
+        let _ = remove_postgres(container_name).await;
+        return Err(err);
+    }
+
+    let url = format!(
+        "postgresql://{}:{}@{}:{}/{}",
+        username, password, db_name, 5432, "postgres"
+    );
+
+    Ok((db_name, url))
+}
+
+/// Polls pg_isready inside the container until the server accepts connections
+async fn wait_for_postgres(docker: &Docker, db_name: &str, username: &str) -> Result<()> {
+    let deadline = Instant::now() + POSTGRES_READY_TIMEOUT;
+
+    while Instant::now() < deadline {
+        let exec = docker
+            .create_exec(
+                db_name,
+                CreateExecOptions {
+                    attach_stdout: Some(true),
+                    attach_stderr: Some(true),
+                    cmd: Some(vec!["pg_isready", "-U", username, "-d", "postgres"]),
+                    ..Default::default()
+                },
+            )
+            .await?;
+
+        if let StartExecResults::Attached { mut output, .. } = docker.start_exec(&exec.id, None).await? {
+            while output.next().await.is_some() {}
+        }
+
+        if docker.inspect_exec(&exec.id).await?.exit_code == Some(0) {
+            return Ok(());
+        }
+
+        tokio::time::sleep(std::time::Duration::from_secs(1)).await;
+    }
+
+    Err(anyhow::anyhow!(
+        "Database did not become ready within {} seconds",
+        POSTGRES_READY_TIMEOUT.as_secs()
+    ))
+}
+
+/// Removes the postgres container of a project together with its data
+#[tracing::instrument]
+pub async fn remove_postgres(container_name: &str) -> Result<()> {
+    let db_name = format!("{}-db", container_name);
+    let volume_name = format!("{}-volume", container_name);
+
+    let docker = nodes::docker(container_name).map_err(|err| {
+        tracing::error!("Failed to connect to docker: {}", err);
+        err
+    })?;
+
+    match docker
+        .remove_container(
+            &db_name,
+            Some(RemoveContainerOptions {
+                force: true,
+                ..Default::default()
+            }),
+        )
+        .await
+    {
+        Ok(_) | Err(bollard::errors::Error::DockerResponseServerError { status_code: 404, .. }) => {}
+        Err(err) => {
+            tracing::error!("Failed to remove container: {}", err);
+            return Err(err.into());
+        }
+    }
+
+    match docker.remove_volume(&volume_name, None).await {
+        Ok(_) | Err(bollard::errors::Error::DockerResponseServerError { status_code: 404, .. }) => {}
+        Err(err) => {
+            tracing::error!("Failed to remove volume: {}", err);
+            return Err(err.into());
+        }
+    }
+
+    Ok(())
+}
+
+/// Writes a custom format dump of `database` to `path` and returns its size in bytes
+#[tracing::instrument]
+pub async fn dump_postgres(db_name: &str, database: &str, path: &std::path::Path) -> Result<u64> {
+    let docker = nodes::docker(db_name).map_err(|err| {
+        tracing::error!("Failed to connect to docker: {}", err);
+        err
+    })?;
+
+    let file = tokio::fs::File::create(path).await?;
+    postgres_exec(
+        &docker,
+        db_name,
+        r#"exec pg_dump --format=custom --username="$POSTGRES_USER" "$0""#,
+        database,
+        None,
+        Some(file),
+    )
+    .await?;
+
+    Ok(tokio::fs::metadata(path).await?.len())
+}
+
+/// Restores the dump at `path` into `database`, creating it first when `create` is set.
+/// The restore runs in a single transaction, a dump that fails halfway leaves the data as
+/// it was
+#[tracing::instrument]
+pub async fn restore_postgres(
+    db_name: &str,
+    database: &str,
+    path: &std::path::Path,
+    create: bool,
+) -> Result<()> {
+    let docker = nodes::docker(db_name).map_err(|err| {
+        tracing::error!("Failed to connect to docker: {}", err);
+        err
+    })?;
+
+    let script = match create {
+        true => {
+            r#"createdb --username="$POSTGRES_USER" "$0" || exit 1
+               pg_restore --no-owner --single-transaction --username="$POSTGRES_USER" --dbname="$0" || {
+                 status=$?
+                 dropdb --username="$POSTGRES_USER" --if-exists "$0"
+                 exit $status
+               }"#
+        }
+        false => {
+            r#"exec pg_restore --clean --if-exists --no-owner --single-transaction --username="$POSTGRES_USER" --dbname="$0""#
+        }
+    };
+
+    let file = tokio::fs::File::open(path).await?;
+    postgres_exec(&docker, db_name, script, database, Some(file), None).await
+}
+
+/// Runs a shell script in a postgres addon container, `database` is passed as `$0`. The
+/// script reads `input` and writes its stdout to `output`, stderr ends up in the error
+/// when it exits with anything but 0
+async fn postgres_exec(
+    docker: &Docker,
+    db_name: &str,
+    script: &str,
+    database: &str,
+    input: Option<tokio::fs::File>,
+    mut output: Option<tokio::fs::File>,
+) -> Result<()> {
+    let exec = docker
+        .create_exec(
+            db_name,
+            CreateExecOptions {
+                attach_stdin: Some(input.is_some()),
+                attach_stdout: Some(true),
+                attach_stderr: Some(true),
+                cmd: Some(vec!["sh", "-c", script, database]),
+                ..Default::default()
+            },
+        )
+        .await?;
+
+    let (mut stream, mut stdin) = match docker.start_exec(&exec.id, None).await? {
+        StartExecResults::Attached { output, input } => (output, input),
+        StartExecResults::Detached => return Err(anyhow::anyhow!("Exec started detached")),
+    };
+
+    // feed stdin while reading the output, pg tools stop reading when their output backs up
+    let feeder = tokio::spawn(async move {
+        if let Some(mut input) = input {
+            tokio::io::copy(&mut input, &mut stdin).await?;
+        }
+        stdin.shutdown().await
+    });
+
+    let mut stderr = String::new();
+    while let Some(msg) = stream.next().await {
+        match msg? {
+            LogOutput::StdOut { message } => {
+                if let Some(output) = output.as_mut() {
+                    output.write_all(&message).await?;
+                }
+            }
+            LogOutput::StdErr { message } => stderr.push_str(&String::from_utf8_lossy(&message)),
+            _ => {}
+        }
+    }
+
+    if let Some(output) = output.as_mut() {
+        output.flush().await?;
+    }
+
+    // a script that exits early stops reading, that error is in its stderr already
+    let _ = feeder.await;
+
+    // the output can end a moment before docker marks the exec as done
+    let mut exit_code = None;
+    for _ in 0..20 {
+        let inspect = docker.inspect_exec(&exec.id).await?;
+        if inspect.running != Some(true) {
+            exit_code = inspect.exit_code;
+            break;
+        }
+        tokio::time::sleep(std::time::Duration::from_millis(100)).await;
+    }
+
+    match exit_code {
+        Some(0) => Ok(()),
+        Some(code) => Err(anyhow::anyhow!("Exited with code {code}: {}", stderr.trim())),
+        None => Err(anyhow::anyhow!("Exited without a code: {}", stderr.trim())),
+    }
 }
 
 #[tracing::instrument(skip(pool))]
 pub async fn build_docker(
+    project_id: Uuid,
     owner: &str,
     project_name: &str,
     container_name: &str,
     container_src: &str,
+    build_id: Uuid,
+    preview: Option<&str>,
     pool: PgPool,
+    container_settings: &ContainerSettings,
+    secrets: &SecretCipher,
+    cancel: &CancellationToken,
+    timeout: std::time::Duration,
 ) -> Result<DockerContainer> {
     let image_name = format!("{}:latest", container_name);
-    let old_image_name = format!("{}:old", container_name);
     let network_name = format!("{}-network", container_name);
-    let db_name = format!("{}-db", container_name);
-    let volume_name = format!("{}-volume", container_name);
+    let release_name = format!("{}-release", container_name);
 
     let docker = Docker::connect_with_local_defaults().map_err(|err| {
         tracing::error!("Failed to connect to docker: {}", err);
@@ -85,84 +838,164 @@ pub async fn build_docker(
             })?;
     };
 
+    tracing::info!("BUILDING START");
+
+    // apps of a monorepo build from their directory of the checkout, it is the whole source
+    // of the build: Dockerfile, Procfile and build context. The image is built for the
+    // architecture of the node the app runs on, a node that never reported runs the one of
+    // the host
+    let project = sqlx::query!(
+        r#"SELECT projects.source_dir, nodes.arch AS "arch?"
+           FROM projects
+           LEFT JOIN nodes ON projects.node_id = nodes.id
+           WHERE projects.id = $1
+        "#,
+        project_id
+    )
+    .fetch_one(&pool)
+    .await
+    .map_err(|err| {
+        tracing::error!(?err, "Failed to query database: {}", err);
+        err
+    })?;
+    let target = project.arch.unwrap_or_else(arch::host);
+    let platform = arch::platform(&target);
+
     // build image
     let plan_options = GeneratePlanOptions::default();
     let build_options = DockerBuilderOptions {
         name: Some(container_name.to_string()),
         quiet: false,
         verbose: true,
+        platform: vec![platform.clone()],
         ..Default::default()
     };
     let envs = vec![];
+    let container_src = &match project.source_dir {
+        Some(dir) => {
+            let src = format!("{container_src}/{dir}");
+            if !std::path::Path::new(&src).is_dir() {
+                return Err(anyhow::anyhow!("Source directory {dir} is not in the repository"));
+            }
+            src
+        }
+        None => container_src.to_string(),
+    };
+    // a broken manifest fails before anything is built
+    let manifest = Manifest::load(std::path::Path::new(container_src))?;
+
+    // a Dockerfile of the source wins, then a buildpack for the language, then nixpacks
+    let source_dockerfile = std::path::Path::new(container_src).join("Dockerfile");
+    let (dockerfile, buildpack_log) = match source_dockerfile.exists() {
+        true => (Some(source_dockerfile), String::new()),
+        false => generate_dockerfile(container_src, container_name).await,
+    };
+    // the build log is replaced by the full output once the build is done, this stays in front
+    append_build_log(&pool, build_id, &buildpack_log).await;
+
+    // the docker cli of a Dockerfile build, it is stopped with SIGTERM when the build is
+    let pid = AtomicU32::new(0);
+    let build = async {
+        match dockerfile {
+            Some(dockerfile) => {
+                tracing::debug!(container_name, ?dockerfile, "Build using dockerfile");
+                // build from Dockerfile
+                let mut cmd = Command::new("docker");
+                // buildkit for cache mounts, plain progress keeps the log one step per line
+                cmd.env("DOCKER_BUILDKIT", "1").args(&["build", "--progress=plain"]);
+                // images stay inside a builder container unless they are loaded into docker
+                if std::env::var_os("BUILDX_BUILDER").is_some() {
+                    cmd.arg("--load");
+                }
+                cmd.args(&[
+                    "--platform",
+                    &platform,
+                    "-t",
+                    &image_name,
+                    "-f",
+                    dockerfile.to_str().unwrap(),
+                    container_src,
+                ])
+                .stdin(Stdio::piped())
+                .stdout(Stdio::null())
+                .stderr(Stdio::piped())
+                .kill_on_drop(true);
+
+                let mut child = cmd.spawn().map_err(|err| {
+                    tracing::error!("Failed to spawn docker build: {}", err);
+                    err
+                })?;
+                pid.store(child.id().unwrap_or_default(), Ordering::SeqCst);
 
-    // check if Dockerfile exists
+                // docker build reports progress on stderr, pass it on while it runs
+                let mut lines = BufReader::new(child.stderr.take().unwrap()).lines();
+                let mut build_log = buildpack_log;
+                let mut pending = String::new();
+                let mut last_flush = Instant::now();
 
-    tracing::info!("BUILDING START");
+                while let Some(line) = lines.next_line().await.map_err(|err| {
+                    tracing::error!("Failed to read docker build output: {}", err);
+                    err
+                })? {
+                    pending.push_str(&line);
+                    pending.push('\n');
+
+                    if last_flush.elapsed() >= LOG_FLUSH_INTERVAL {
+                        append_build_log(&pool, build_id, &pending).await;
+                        build_log.push_str(&pending);
+                        pending.clear();
+                        last_flush = Instant::now();
+                    }
+                }
+                append_build_log(&pool, build_id, &pending).await;
+                build_log.push_str(&pending);
 
-    let (build_log, nixpacks) = match std::path::Path::new(container_src)
-        .join("Dockerfile")
-        .exists()
-    {
-        true => {
-            tracing::debug!(container_name, "Build using dockerfile");
-            // build from Dockerfile
-            let mut cmd = Command::new("docker");
-            cmd.args(&[
-                "build",
-                "--cpu-period=100000",
-                "--cpu-quota=50000",
-                "-t",
-                &image_name,
-                "-f",
-                &std::path::Path::new(container_src)
-                    .join("Dockerfile")
-                    .to_str()
-                    .unwrap(),
-                container_src,
-            ])
-            .stdin(Stdio::piped())
-            .stdout(Stdio::piped())
-            .stderr(Stdio::piped());
-
-            let child = cmd.spawn().map_err(|err| {
-                tracing::error!("Failed to spawn docker build: {}", err);
-                err
-            })?;
+                let status = child.wait().await.map_err(|err| {
+                    tracing::error!("Failed to wait for docker build: {}", err);
+                    err
+                })?;
 
-            let output = child.wait_with_output().await.map_err(|err| {
-                tracing::error!("Failed to wait for docker build: {}", err);
-                err
-            })?;
-
-            if !output.status.success() {
-                return Err(anyhow::anyhow!(String::from_utf8(output.stderr).unwrap()));
-            }
-            match output.status.success() {
-                true => (String::from_utf8(output.stderr).unwrap(), false),
-                false => {
+                if !status.success() {
                     tracing::error!("Failed to build image");
-
-                    return Err(anyhow::anyhow!(
-                        "Failed to build image: {}",
-                        String::from_utf8(output.stderr).unwrap()
-                    ));
+                    let note = killed_note(&build_log).to_string() + &arch::emulation_note(&build_log, &target);
+                    return Err(anyhow::anyhow!(build_log + &note));
                 }
+                Ok::<_, anyhow::Error>((build_log, false))
             }
-        }
-        false => {
-            tracing::debug!(container_name, "Build using nixpacks");
-            let Output {
-                status,
-                stderr,
-                stdout: _,
-            } = create_docker_image(container_src, envs, &plan_options, &build_options).await?;
+            None => {
+                tracing::debug!(container_name, "Build using nixpacks");
+                let Output {
+                    status,
+                    stderr,
+                    stdout: _,
+                } = create_docker_image(container_src, envs, &plan_options, &build_options).await?;
+
+                // nixpacks only hands back its output once the build is done
 
-            let build_log = String::from_utf8(stderr).unwrap();
+                let build_log = buildpack_log + &String::from_utf8(stderr).unwrap();
 
-            if !status.success() {
-                return Err(anyhow::anyhow!(build_log));
+                if !status.success() {
+                    let note = killed_note(&build_log).to_string() + &arch::emulation_note(&build_log, &target);
+                    return Err(anyhow::anyhow!(build_log + &note));
+                }
+                Ok::<_, anyhow::Error>((build_log, true))
             }
-            (build_log, true)
+        }
+    };
+
+    // a Dockerfile build gets to stop its steps before its docker cli is killed, nixpacks' own
+    // docker build is left to finish on its own
+    tokio::pin!(build);
+    let stopped = tokio::select! {
+        built = &mut build => Ok(built?),
+        _ = cancel.cancelled() => Err(anyhow::Error::from(BuildCancelled)),
+        _ = tokio::time::sleep(timeout) => Err(anyhow::Error::from(BuildTimedOut(timeout))),
+    };
+    let (mut build_log, nixpacks) = match stopped {
+        Ok(built) => built,
+        Err(stop) => {
+            stop_build(pid.load(Ordering::SeqCst), build).await;
+            return Err(stop);
         }
     };
 
@@ -181,366 +1014,62 @@ pub async fn build_docker(
 
     let _image = images.first().ok_or(anyhow::anyhow!("No image found"))?;
 
-    // check if container exists
-    let containers = docker
-        .list_containers(Some(ListContainersOptions::<String> {
-            all: true,
-            filters: HashMap::from([("name".to_string(), vec![format!("^{container_name}$")])]),
-            ..Default::default()
-        }))
+    // nothing of the image runs before it is scanned
+    let scan_log = check_image(project_id, build_id, Source::Local(&image_name), &pool)
         .await
-        .map_err(|err| {
-            tracing::error!("Failed to list containers: {}", err);
-            err
-        })?
-        .into_iter()
-        .collect::<Vec<_>>();
-
-    // remove container if it exists
-    if !containers.is_empty() {
-        docker
-            .stop_container(container_name, None)
-            .await
-            .map_err(|err| {
-                tracing::error!("Failed to stop container: {}", err);
-                err
-            })?;
+        .map_err(|err| anyhow::anyhow!("{build_log}{err}"))?;
+    append_build_log(&pool, build_id, &scan_log).await;
+    build_log.push_str(&scan_log);
 
-        docker
-            .remove_container(containers.first().unwrap().id.as_ref().unwrap(), None)
-            .await
-            .map_err(|err| {
-                tracing::error!("Failed to remove container: {}", err);
-                err
-            })?;
-
-        docker
-            .remove_image(&old_image_name, None, None)
-            .await
-            .map_err(|err| {
-                tracing::error!("Failed to remove image: {}", err);
-                err
-            })?;
+    // the scan takes a while, a build cancelled meanwhile stops before anything of it runs
+    if cancel.is_cancelled() {
+        return Err(BuildCancelled.into());
     }
 
-    // check if database container exists
-    let db_containers = docker
-        .list_containers(Some(ListContainersOptions::<String> {
-            all: true,
-            filters: HashMap::from([("name".to_string(), vec![db_name.to_string()])]),
-            ..Default::default()
-        }))
-        .await
-        .map_err(|err| {
-            tracing::error!("Failed to list containers: {}", err);
-            err
-        })?
-        .into_iter()
-        .collect::<Vec<_>>();
-
-    let volumes = docker
-        .list_volumes(Some(ListVolumesOptions::<String> {
-            filters: HashMap::from([("name".to_string(), vec![volume_name.clone()])]),
-        }))
-        .await
-        .map_err(|err| {
-            tracing::error!("Failed to list containers: {}", err);
-            err
-        })?
-        .volumes
-        .unwrap_or_default();
-
-    // check if network exists
-    let network = docker
-        .list_networks(Some(ListNetworksOptions {
-            filters: HashMap::from([("name".to_string(), vec![network_name.to_string()])]),
-        }))
-        .await
-        .map_err(|err| {
-            tracing::error!("Failed to list networks: {}", err);
-            err
-        })?
-        .first()
-        .map(|n| n.to_owned());
-
-    // create network if it doesn't exist
-    let network = match network {
-        Some(n) => {
-            tracing::info!("Existing network id -> {:?}", n.id);
-            n
-        }
-        None => {
-            let options = bollard::network::CreateNetworkOptions {
-                name: network_name.clone(),
-                ..Default::default()
-            };
-            let res = docker.create_network(options).await.map_err(|err| {
-                tracing::error!("Failed to create network: {}", err);
-                err
-            })?;
-            tracing::info!("create network response-> {:#?}", res);
+    // the image is built on the host of the platform, an app on a node runs it there
+    nodes::ship_image(container_name, &image_name).await?;
+    let node = nodes::docker(container_name).map_err(|err| {
+        tracing::error!("Failed to connect to docker: {}", err);
+        err
+    })?;
 
-            docker
-                .list_networks(Some(ListNetworksOptions {
-                    filters: HashMap::from([("name".to_string(), vec![network_name.to_string()])]),
-                }))
-                .await?
-                .first()
-                .map(|n| n.to_owned())
-                .ok_or(anyhow::anyhow!("No network found after make one???"))?
-        }
-    };
+    ensure_network(&node, &network_name, false).await?;
+    apply_egress(project_id, container_name, container_settings, &pool).await?;
 
-    // create volume if it doesn't exist
-    if volumes.is_empty() {
-        let res = docker
-            .create_volume(CreateVolumeOptions {
-                name: volume_name.clone(),
-                ..Default::default()
-            })
+    // a preview runs on its own network without the volumes and addons of the app, a branch
+    // mustn't change the data of the live release. Its manifest only sets the environment
+    if preview.is_none() {
+        check_volumes(project_id, container_name, &pool)
             .await
-            .map_err(|err| {
-                tracing::error!("Failed to create volume: {}", err);
-                err
-            })?;
-        tracing::info!("create volume response-> {:#?}", res);
+            .map_err(|err| anyhow::anyhow!("{build_log}{err}"))?;
     }
 
-    // create database container if it doesn't exist
-    let db_url = match db_containers.is_empty() {
-        true => {
-            let mut rng = rand::rngs::StdRng::from_entropy();
-            let username = (0..10)
-                .map(|_| CHARSET[rng.gen_range(0..CHARSET.len())] as char)
-                .collect::<String>();
-
-            let password = (0..20)
-                .map(|_| CHARSET[rng.gen_range(0..CHARSET.len())] as char)
-                .collect::<String>();
-
-            // create database container
-            let config = Config {
-                image: Some("postgres:16.0-alpine3.18".to_string()),
-                volumes: Some(HashMap::from([(
-                    format!("{volume_name}:/var/lib/postgresql/data"),
-                    HashMap::new(),
-                )])),
-                env: Some(vec![
-                    format!("POSTGRES_USER={}", username),
-                    format!("POSTGRES_PASSWORD={}", password),
-                    format!("POSTGRES_DB={}", "postgres"),
-                ]),
-                host_config: Some(HostConfig {
-                    restart_policy: Some(RestartPolicy {
-                        name: Some(RestartPolicyNameEnum::ON_FAILURE),
-                        ..Default::default()
-                    }),
-                    ..Default::default()
-                }),
-                ..Default::default()
-            };
-
-            let _res = &docker
-                .create_container(
-                    Some(CreateContainerOptions {
-                        name: db_name.clone(),
-                        platform: None,
-                    }),
-                    config,
-                )
-                .await
-                .map_err(|err| {
-                    tracing::error!("Failed to create container: {}", err);
-                    err
-                })?;
-
-            docker
-                .start_container(&db_name, None::<StartContainerOptions<&str>>)
-                .await
-                .map_err(|err| {
-                    tracing::error!("Failed to start container: {}", err);
-                    err
-                })?;
-
-            // wait until postgres is ready
-            // TODO: change this into a psql command check
-            std::thread::sleep(std::time::Duration::from_secs(10));
-
-            let _ = docker
-                .disconnect_network(
-                    "bridge",
-                    DisconnectNetworkOptions {
-                        container: &db_name,
-                        force: true,
-                    },
-                )
-                .await
-                .map_err(|err| {
-                    tracing::error!("Failed to disconnect container from bridge: {}", err);
-                    err
-                });
-
-            // connect db container to network
-            docker
-                .connect_network(
-                    &network_name,
-                    ConnectNetworkOptions {
-                        container: db_name.clone(),
-                        ..Default::default()
-                    },
-                )
-                .await
-                .map_err(|err| {
-                    tracing::error!("Failed to connect network: {}", err);
-                    err
-                })?;
-
-            format!(
-                "postgresql://{}:{}@{}:{}/{}",
-                username, password, db_name, 5432, "postgres"
-            )
-        }
-        false => {
-            match sqlx::query!(
-                r#"SELECT db_url FROM domains
-                   JOIN projects ON projects.id = domains.project_id
-                   WHERE projects.name = $1
-                "#,
-                project_name
-            )
-            .fetch_optional(&pool)
+    // addons the manifest asks for have to exist before DATABASE_URL is read
+    if let Some(manifest) = manifest.as_ref().filter(|_| preview.is_none()) {
+        let changes = manifest
+            .reconcile(project_id, container_name, container_settings, &pool)
             .await
-            {
-                Ok(Some(row)) => row.db_url.unwrap(),
-                Ok(None) => {
-                    // delete database create again
-                    tracing::debug!("No database url found for project {}", project_name);
-
-                    let _ = docker.stop_container(&db_name, None).await.map_err(|err| {
-                        tracing::error!("Failed to remove container: {}", err);
-                        err
-                    });
-
-                    let _ = docker
-                        .remove_container(&db_name, None)
-                        .await
-                        .map_err(|err| {
-                            tracing::error!("Failed to remove container: {}", err);
-                            err
-                        });
-
-                    let _ = docker
-                        .remove_volume(&volume_name, None)
-                        .await
-                        .map_err(|err| {
-                            tracing::error!("Failed to remove volume: {}", err);
-                            err
-                        })?;
-
-                    let mut rng = rand::rngs::StdRng::from_entropy();
-                    let username = (0..10)
-                        .map(|_| CHARSET[rng.gen_range(0..CHARSET.len())] as char)
-                        .collect::<String>();
-
-                    let password = (0..20)
-                        .map(|_| CHARSET[rng.gen_range(0..CHARSET.len())] as char)
-                        .collect::<String>();
-
-                    // create database container
-                    let config = Config {
-                        image: Some("postgres:16.0-alpine3.18".to_string()),
-                        volumes: Some(HashMap::from([(
-                            format!("{volume_name}:/var/lib/postgresql/data"),
-                            HashMap::new(),
-                        )])),
-                        env: Some(vec![
-                            format!("POSTGRES_USER={}", username),
-                            format!("POSTGRES_PASSWORD={}", password),
-                            format!("POSTGRES_DB={}", "postgres"),
-                        ]),
-                        host_config: Some(HostConfig {
-                            restart_policy: Some(RestartPolicy {
-                                name: Some(RestartPolicyNameEnum::ON_FAILURE),
-                                ..Default::default()
-                            }),
-                            ..Default::default()
-                        }),
-                        ..Default::default()
-                    };
-
-                    let _res = &docker
-                        .create_container(
-                            Some(CreateContainerOptions {
-                                name: db_name.clone(),
-                                platform: None,
-                            }),
-                            config,
-                        )
-                        .await
-                        .map_err(|err| {
-                            tracing::error!("Failed to create container: {}", err);
-                            err
-                        })?;
-
-                    docker
-                        .start_container(&db_name, None::<StartContainerOptions<&str>>)
-                        .await
-                        .map_err(|err| {
-                            tracing::error!("Failed to start container: {}", err);
-                            err
-                        })?;
-
-                    // wait until postgres is ready
-                    // TODO: change this into a psql command check
-                    std::thread::sleep(std::time::Duration::from_secs(10));
-
-                    let _ = docker
-                        .disconnect_network(
-                            "bridge",
-                            DisconnectNetworkOptions {
-                                container: &db_name,
-                                force: true,
-                            },
-                        )
-                        .await
-                        .map_err(|err| {
-                            tracing::error!("Failed to disconnect container from bridge: {}", err);
-                            err
-                        });
-
-                    // connect db container to network
-                    docker
-                        .connect_network(
-                            &network_name,
-                            ConnectNetworkOptions {
-                                container: db_name.clone(),
-                                ..Default::default()
-                            },
-                        )
-                        .await
-                        .map_err(|err| {
-                            tracing::error!("Failed to connect network: {}", err);
-                            err
-                        })?;
-
-                    format!(
-                        "postgresql://{}:{}@{}:{}/{}",
-                        username, password, db_name, 5432, "postgres"
-                    )
-                }
-                Err(err) => {
-                    tracing::error!("Failed to query database: {}", err);
-                    return anyhow::Result::Err(err.into());
-                }
-            }
+            .map_err(|err| anyhow::anyhow!("{build_log}Failed to apply {MANIFEST_FILE}: {err}"))?;
+        if !changes.is_empty() {
+            build_log.push_str(&format!("Applied {MANIFEST_FILE}: {}\n", changes.join(", ")));
         }
+    }
+
+    // the database is an addon now, apps without one get an empty DATABASE_URL
+    let db_url = match preview {
+        Some(_) => String::new(),
+        None => database_url(project_id, &pool).await.map_err(|err| {
+            tracing::error!("Failed to query database: {}", err);
+            err
+        })?,
     };
 
-    // TODO: figure out if we need make this configurable
-    let port = 80;
+    // build contract: whatever built the image (Dockerfile, Procfile or nixpacks), the app has to
+    // listen on $PORT. both the readiness probe and the proxy only ever talk to this port
+    let port = container_settings.port;
 
-    let envs = sqlx::query!(
-        r#"SELECT environs 
+    let project = sqlx::query!(
+        r#"SELECT healthcheck_path, protocol
         FROM projects
         JOIN project_owners ON projects.owner_id = project_owners.id
         WHERE projects.name = $1 AND project_owners.name = $2"#,
@@ -553,230 +1082,638 @@ pub async fn build_docker(
         err
     })?;
 
-    let environment_strings = match envs.environs.as_object() {
-        Some(map) => {
-            let environment_strings = map.into_iter().map(|(key, value)| {
-                format!("{}={}", key, value.as_str().unwrap())
-            }).collect::<Vec<_>>();
-
-            Ok(environment_strings)
-        },
-        None => {
-            tracing::error!("Non object value passed as environment variable {}", container_name);
-            Err(anyhow::anyhow!("Non object value passed as environment variable {}", container_name))
-        }
-    }?;
-
-    let mut config: Config<String> = Config {
-        image: Some(image_name.clone()),
-        // TDDO: rethink if we need to make this configurable
-        env: Some([
-            vec![
-                format!("PORT={}", port),
-                format!("DATABASE_URL={}", db_url),
-            ],
-            environment_strings,
-        ].concat()),
-        host_config: Some(HostConfig {
-            restart_policy: Some(RestartPolicy {
-                name: Some(RestartPolicyNameEnum::ON_FAILURE),
-                ..Default::default()
-            }),
-            ..Default::default()
-        }),
-        ..Default::default()
+    let (env, project_secrets) = project_environment(owner, project_name, &pool).await?;
+    // the addresses of the other services come first, the app's own variables win over them
+    let (service, service_env) = service_network(project_id, port, &pool).await?;
+
+    let mut release_config = ReleaseConfig {
+        env: [service_env, env].concat(),
+        secrets: project_secrets,
+        cmd: None,
+        workers: BTreeMap::new(),
+        service,
+        private: Some(private_network(owner, project_name)),
+        volumes: project_mounts(project_id, container_name, &pool).await?,
+        limits: Some(project_limits(project_id, container_settings, &pool).await?),
+        restarts: Some(project_restarts(project_id, &pool).await?),
+        site: manifest.as_ref().and_then(|manifest| manifest.site.clone()),
     };
+    if let Some(manifest) = &manifest {
+        manifest
+            .apply_env(&mut release_config)
+            .map_err(|err| anyhow::anyhow!("{build_log}{err}"))?;
+    }
+    if let Some(branch) = preview {
+        let overrides = preview_environment(project_id, branch, &pool).await?;
+        // secrets go last when the container starts, an override has to replace them too
+        release_config.secrets.retain(|key, _| {
+            !overrides.iter().any(|var| var.split_once('=').is_some_and(|(name, _)| name == key))
+        });
+        // like the database, the bucket, the redis and the mail of the app are left out
+        let addons = [
+            storage_environment(project_id, &pool).await?,
+            redis_environment(project_id, &pool).await?,
+            mail_environment(project_id, &pool).await?,
+        ]
+        .concat();
+        release_config.env.retain(|var| !addons.contains(var));
+        release_config.env.extend(overrides);
+        release_config.service = None;
+        release_config.private = None;
+        release_config.volumes = Vec::new();
+    }
 
-    // if not nixpacks, we need to read from procfile and use release and web command
-    if !nixpacks {
-        // read procfile
-        let (release, web) =
-            std::fs::read_to_string(std::path::Path::new(container_src).join("Procfile"))
-                .map(|content| {
-                    procfile::parse(&content)
-                        .map_err(|err| {
-                            tracing::error!("Failed to parse Procfile: {}", err);
-                            err
-                        })
-                        .map(|map| {
-                            let web = map.get("web").map(|web| web.to_string());
-                            let release = map.get("release").map(|release| release.to_string());
-                            (release, web)
-                        })
-                        .unwrap_or_default()
+    // read procfile
+    let mut processes = std::fs::read_to_string(std::path::Path::new(container_src).join("Procfile"))
+        .map(|content| {
+            procfile::parse(&content)
+                .map_err(|err| {
+                    tracing::error!("Failed to parse Procfile: {}", err);
+                    err
+                })
+                .map(|map| {
+                    map.iter()
+                        .map(|process| (process.key().to_string(), process.value().to_string()))
+                        .collect::<BTreeMap<_, _>>()
                 })
-                .unwrap_or_default();
+                .unwrap_or_default()
+        })
+        .unwrap_or_default();
 
-        tracing::debug!(release = ?release, web = ?web, "Procfile");
+    if let Some(manifest) = &manifest {
+        processes.extend(manifest.processes.clone());
+    }
 
-        if let Some(release) = release {
-            let config = Config {
-                image: Some(image_name.clone()),
-                env: Some(vec![
-                    "PRODUCTION=true".to_string(),
-                    format!("PORT={}", port),
-                    format!("DATABASE_URL={}", db_url),
-                ]),
-                host_config: Some(HostConfig {
-                    restart_policy: Some(RestartPolicy {
-                        name: Some(RestartPolicyNameEnum::NO),
-                        ..Default::default()
-                    }),
+    tracing::debug!(processes = ?processes, "Procfile");
+
+    // every other process type runs as a worker, whatever built the image
+    release_config.workers = processes
+        .iter()
+        .filter(|(name, _)| *name != "web" && *name != "release")
+        .map(|(name, command)| {
+            (name.clone(), command.split(' ').map(|s| s.to_string()).collect())
+        })
+        .collect();
+
+    // if not nixpacks, we need to use release and web command from the procfile. nixpacks
+    // reads the Procfile itself, only the manifest overrides it
+    let (release, web) = match (nixpacks, &manifest) {
+        (false, _) => (processes.get("release").cloned(), processes.get("web").cloned()),
+        (true, Some(manifest)) => (
+            manifest.processes.get("release").cloned(),
+            manifest.processes.get("web").cloned(),
+        ),
+        (true, None) => (None, None),
+    };
+
+    if let Some(release) = release {
+        let config = Config {
+            image: Some(image_name.clone()),
+            env: Some([
+                vec!["PRODUCTION=true".to_string()],
+                container_env(&release_config, port, &db_url, secrets)?,
+            ].concat()),
+            host_config: Some(limited_host_config(&release_config, container_settings, HostConfig {
+                restart_policy: Some(RestartPolicy {
+                    name: Some(RestartPolicyNameEnum::NO),
                     ..Default::default()
                 }),
-                // cmd: Some(vec![release]),
-                cmd: Some(release.split(' ').map(|s| s.to_string()).collect()),
+                // only on the project network, the default bridge would be a way around the
+                // egress policy of the app
+                network_mode: Some(network_name.clone()),
                 ..Default::default()
-            };
-            if let Err(err) = docker
-                .create_container(
-                    Some(CreateContainerOptions {
-                        name: container_name,
-                        platform: None,
-                    }),
-                    config,
-                )
-                .await
-            {
-                tracing::error!("Failed to create container: {}", err);
-                if db_containers.is_empty() {
-                    let _ = docker.stop_container(&db_name, None).await.map_err(|err| {
-                        tracing::error!("Failed to remove container: {}", err);
-                        err
-                    });
-
-                    let _ = docker
-                        .remove_container(&db_name, None)
-                        .await
-                        .map_err(|err| {
-                            tracing::error!("Failed to remove container: {}", err);
-                            err
-                        });
-
-                    let _ = docker
-                        .remove_volume(&volume_name, None)
-                        .await
-                        .map_err(|err| {
-                            tracing::error!("Failed to remove volume: {}", err);
-                            err
-                        });
-                }
+            })),
+            // cmd: Some(vec![release]),
+            cmd: Some(release.split(' ').map(|s| s.to_string()).collect()),
+            ..Default::default()
+        };
+        if let Err(err) = node
+            .create_container(
+                Some(CreateContainerOptions {
+                    name: release_name.as_str(),
+                    platform: None,
+                }),
+                config,
+            )
+            .await
+        {
+            tracing::error!("Failed to create container: {}", err);
+            return Err(err.into());
+        }
 
-                return Err(err.into());
-            }
+        join_service_network(&node, &release_config, &release_name, false).await?;
 
-            docker
-                .connect_network(
-                    &network_name,
-                    ConnectNetworkOptions {
-                        container: container_name,
-                        ..Default::default()
-                    },
-                )
-                .await
-                .map_err(|err| {
-                    tracing::error!("Failed to connect network: {}", err);
-                    err
-                })?;
+        if let Err(err) = node
+            .start_container(&release_name, None::<StartContainerOptions<&str>>)
+            .await
+        {
+            tracing::error!("Failed to start container: {}", err);
+        }
 
-            if let Err(err) = docker
-                .start_container(container_name, None::<StartContainerOptions<&str>>)
+        // a cancelled build stops the release command like docker stop does, a release that
+        // stops halfway has to be safe to run again, like migrations in a transaction
+        let wait = node
+            .wait_container(&release_name, None::<WaitContainerOptions<&str>>)
+            .try_collect::<Vec<_>>();
+        let cancelled = tokio::select! {
+            _ = wait => false,
+            _ = cancel.cancelled() => true,
+        };
+        if cancelled {
+            append_build_log(&pool, build_id, "Stopping the release command\n").await;
+            if let Err(err) = node
+                .stop_container(&release_name, Some(StopContainerOptions { t: STOP_GRACE.as_secs() as i64 }))
                 .await
             {
-                tracing::error!("Failed to start container: {}", err);
-
-                if db_containers.is_empty() {
-                    let _ = docker.stop_container(&db_name, None).await.map_err(|err| {
-                        tracing::error!("Failed to remove container: {}", err);
-                        err
-                    });
-
-                    let _ = docker
-                        .remove_container(&db_name, None)
-                        .await
-                        .map_err(|err| {
-                            tracing::error!("Failed to remove container: {}", err);
-                            err
-                        });
-
-                    let _ = docker
-                        .remove_volume(&volume_name, None)
-                        .await
-                        .map_err(|err| {
-                            tracing::error!("Failed to remove volume: {}", err);
-                            err
-                        });
-                }
+                tracing::error!("Failed to stop container: {}", err);
             }
+        }
 
-            // wait until container is stopped
-            let mut i = 0;
-            loop {
-                std::thread::sleep(std::time::Duration::from_secs(2));
-
-                if let Err(err) = docker.remove_container(container_name, None).await {
-                    tracing::debug!("Failed to remove container. Will try again: {}", err);
-                    i += 1;
-                    if i > 10 {
-                        tracing::error!("Failed to remove container: {}", err);
-                        break;
-                    }
-
-                    continue;
+        // wait until container is stopped
+        let mut i = 0;
+        loop {
+            std::thread::sleep(std::time::Duration::from_secs(2));
+
+            if let Err(err) = node.remove_container(&release_name, None).await {
+                tracing::debug!("Failed to remove container. Will try again: {}", err);
+                i += 1;
+                if i > 10 {
+                    tracing::error!("Failed to remove container: {}", err);
+                    break;
                 }
-                break;
+
+                continue;
             }
+            break;
         }
-
-        if let Some(web) = web {
-            config.cmd = Some(web.split(' ').map(|s| s.to_string()).collect());
+        if cancelled {
+            return Err(BuildCancelled.into());
         }
     }
 
-    let res = docker
-        .create_container(
-            Some(CreateContainerOptions {
-                name: container_name,
-                platform: None,
-            }),
-            config,
-        )
+    if let Some(web) = web {
+        release_config.cmd = Some(web.split(' ').map(|s| s.to_string()).collect());
+    }
+
+    let image = docker
+        .inspect_image(&image_name)
         .await
         .map_err(|err| {
-            tracing::error!("Failed to create container: {}", err);
+            tracing::error!("Failed to inspect image: {}", err);
             err
-        })?;
+        })?
+        .id
+        .ok_or(anyhow::anyhow!("No image id found for {}", image_name))?;
 
-    tracing::info!("create response-> {:#?}", res);
+    // the release command ran like for any app, then the files are all there is to it
+    if let Some(site) = &release_config.site {
+        let root = publish(container_name, &image, site)
+            .await
+            .map_err(|err| anyhow::anyhow!("{build_log}Failed to publish site: {err}"))?;
+        build_log.push_str(&format!("Published the site in {} without starting a container\n", site.dir));
+
+        return Ok(DockerContainer {
+            id: String::new(),
+            ip: String::new(),
+            port,
+            build_log,
+            db_url,
+            image,
+            config: release_config,
+            site: Some(root),
+        });
+    }
 
-    // connect container to network
-    docker
-        .connect_network(
-            &network_name,
-            ConnectNetworkOptions {
-                container: container_name,
-                ..Default::default()
-            },
+    let (id, ip) = orchestrator::driver()
+        .run_web(
+            container_name,
+            &image,
+            &release_config,
+            &db_url,
+            project.healthcheck_path.as_deref(),
+            project.protocol == "h2c",
+            container_settings,
+            secrets,
         )
-        .await
-        .map_err(|err| {
-            tracing::error!("Failed to connect network: {}", err);
-            err
-        })?;
+        .await?;
 
-    docker
-        .start_container(container_name, None::<StartContainerOptions<&str>>)
-        .await
-        .map_err(|err| {
-            tracing::error!("Failed to start container: {}", err);
-            err
-        })?;
+    Ok(DockerContainer {
+        id,
+        ip,
+        port,
+        build_log,
+        db_url,
+        image,
+        config: release_config,
+        site: None,
+    })
+}
 
-    //inspect network
-    let network_inspect = docker
-        .inspect_network(
-            &network.id.unwrap(),
-            Some(InspectNetworkOptions::<&str> {
+/// Writes the Dockerfile of the buildpack the source is detected as next to the checkout,
+/// so it never ends up in the repository, and says so for the build log. No Dockerfile
+/// leaves the build to nixpacks
+async fn generate_dockerfile(container_src: &str, container_name: &str) -> (Option<PathBuf>, String) {
+    let src = std::path::Path::new(container_src);
+    let Some(buildpack) = Buildpack::detect(src) else {
+        return (None, String::new());
+    };
+
+    let dockerfile = match buildpack.dockerfile(src, container_name) {
+        Ok(dockerfile) => dockerfile,
+        Err(err) => {
+            return (None, format!("Can't build as a {buildpack} app: {err}. Building with nixpacks\n"));
+        }
+    };
+
+    let path = PathBuf::from(format!("{container_src}.Dockerfile"));
+    if let Err(err) = tokio::fs::write(&path, &dockerfile).await {
+        tracing::error!(?err, "Failed to write generated Dockerfile");
+        return (None, String::new());
+    }
+
+    (Some(path), format!("Detected a {buildpack} app, building with:\n{dockerfile}\n"))
+}
+
+/// Starts a release that was built before, without building anything. The database and
+/// network are left untouched, only the app container is replaced.
+#[tracing::instrument(skip(pool))]
+pub async fn rollback_docker(
+    project_id: Uuid,
+    owner: &str,
+    project_name: &str,
+    container_name: &str,
+    image: &str,
+    release_config: &ReleaseConfig,
+    pool: PgPool,
+    container_settings: &ContainerSettings,
+    secrets: &SecretCipher,
+) -> Result<DockerContainer> {
+    let docker = nodes::docker(container_name).map_err(|err| {
+        tracing::error!("Failed to connect to docker: {}", err);
+        err
+    })?;
+
+    let db_url = database_url(project_id, &pool).await.map_err(|err| {
+        tracing::error!("Failed to query database: {}", err);
+        err
+    })?;
+
+    let project = sqlx::query!(
+        r#"SELECT healthcheck_path, protocol
+        FROM projects
+        JOIN project_owners ON projects.owner_id = project_owners.id
+        WHERE projects.name = $1 AND project_owners.name = $2"#,
+        project_name, owner,
+    )
+    .fetch_one(&pool)
+    .await
+    .map_err(|err| {
+        tracing::error!(?err, "Failed to query database: {}", err);
+        err
+    })?;
+
+    // releases from before private networks don't have one, and volumes and limits stay
+    // with the project rather than the release
+    let release_config = &ReleaseConfig {
+        private: Some(private_network(owner, project_name)),
+        volumes: project_mounts(project_id, container_name, &pool).await?,
+        limits: Some(project_limits(project_id, container_settings, &pool).await?),
+        restarts: Some(project_restarts(project_id, &pool).await?),
+        ..release_config.clone()
+    };
+
+    // sites of earlier images are still on disk, for others the image is on the host
+    if let Some(site) = &release_config.site {
+        let root = publish(container_name, image, site).await?;
+
+        return Ok(DockerContainer {
+            id: String::new(),
+            ip: String::new(),
+            port: container_settings.port,
+            build_log: format!("Published the site of image {} without building\n", image),
+            db_url,
+            image: image.to_string(),
+            config: release_config.clone(),
+            site: Some(root),
+        });
+    }
+
+    // a node that never ran the release gets it from the host of the platform, the image
+    // collector or a new host may have dropped it there too but the registry still has it
+    nodes::ship_image(container_name, image).await?;
+    pull_release_image(&docker, image).await?;
+    apply_egress(project_id, container_name, container_settings, &pool).await?;
+
+    let (id, ip) = orchestrator::driver()
+        .run_web(
+            container_name,
+            image,
+            release_config,
+            &db_url,
+            project.healthcheck_path.as_deref(),
+            project.protocol == "h2c",
+            container_settings,
+            secrets,
+        )
+        .await?;
+
+    Ok(DockerContainer {
+        id,
+        ip,
+        port: container_settings.port,
+        build_log: format!("Started image {} without building\n", image),
+        db_url,
+        image: image.to_string(),
+        config: release_config.clone(),
+        site: None,
+    })
+}
+
+/// Login for pulling from a private registry
+#[derive(Debug, Clone)]
+pub struct RegistryCredentials {
+    pub registry: String,
+    pub username: String,
+    pub password: String,
+}
+
+/// Registry host of an image reference. Docker treats the first part as a host only when it
+/// looks like one, everything else is on docker hub
+pub fn image_registry(image: &str) -> &str {
+    match image.split_once('/') {
+        Some((host, _)) if host.contains('.') || host.contains(':') || host == "localhost" => host,
+        _ => "docker.io",
+    }
+}
+
+/// Splits an image reference into the name and the tag or digest to pull. Docker pulls every
+/// tag of an image when it gets none, so a bare name pulls latest
+pub fn image_tag(image: &str) -> (&str, &str) {
+    if let Some((name, digest)) = image.split_once('@') {
+        return (name, digest);
+    }
+
+    // a colon before the last slash is the port of the registry
+    let last_part = image.rfind('/').map(|i| i + 1).unwrap_or(0);
+    match image[last_part..].rfind(':') {
+        Some(i) => (&image[..last_part + i], &image[last_part + i + 1..]),
+        None => (image, "latest"),
+    }
+}
+
+/// Deploys an image from a registry instead of building one. The image runs with its own
+/// command and the project environment, there is no Procfile so there are no workers or
+/// release command
+#[tracing::instrument(skip(pool, credentials))]
+pub async fn image_docker(
+    project_id: Uuid,
+    owner: &str,
+    project_name: &str,
+    container_name: &str,
+    image: &str,
+    build_id: Uuid,
+    credentials: Option<RegistryCredentials>,
+    pool: PgPool,
+    container_settings: &ContainerSettings,
+    secrets: &SecretCipher,
+    cancel: &CancellationToken,
+) -> Result<DockerContainer> {
+    let network_name = format!("{}-network", container_name);
+
+    let docker = nodes::docker(container_name).map_err(|err| {
+        tracing::error!("Failed to connect to docker: {}", err);
+        err
+    })?;
+
+    let (name, tag) = image_tag(image);
+    let login = credentials.clone();
+    let credentials = credentials.map(|credentials| DockerCredentials {
+        username: Some(credentials.username),
+        password: Some(credentials.password),
+        serveraddress: Some(credentials.registry),
+        ..Default::default()
+    });
+
+    let mut build_log = format!("Pulling {name}:{tag}\n");
+    append_build_log(&pool, build_id, &build_log).await;
+
+    let mut pull = docker.create_image(
+        Some(CreateImageOptions {
+            from_image: name,
+            tag,
+            ..Default::default()
+        }),
+        None,
+        credentials,
+    );
+
+    // layers report progress many times a second, only finished steps go to the log
+    loop {
+        let info = tokio::select! {
+            info = pull.next() => info,
+            _ = cancel.cancelled() => return Err(BuildCancelled.into()),
+        };
+        let Some(info) = info else {
+            break;
+        };
+        let info = info.map_err(|err| {
+            tracing::error!("Failed to pull image: {}", err);
+            anyhow::anyhow!("{build_log}Failed to pull image: {err}")
+        })?;
+
+        if info.progress_detail.is_some() && info.progress.is_some() {
+            continue;
+        }
+
+        let line = match (info.id, info.status) {
+            (Some(id), Some(status)) => format!("{id}: {status}\n"),
+            (None, Some(status)) => format!("{status}\n"),
+            _ => continue,
+        };
+        append_build_log(&pool, build_id, &line).await;
+        build_log.push_str(&line);
+    }
+
+    let image_id = docker
+        .inspect_image(image)
+        .await
+        .map_err(|err| {
+            tracing::error!("Failed to inspect image: {}", err);
+            err
+        })?
+        .id
+        .ok_or(anyhow::anyhow!("No image id found for {}", image))?;
+
+    let scan_log = check_image(project_id, build_id, Source::Remote(image, login.as_ref()), &pool)
+        .await
+        .map_err(|err| anyhow::anyhow!("{build_log}{err}"))?;
+    append_build_log(&pool, build_id, &scan_log).await;
+    build_log.push_str(&scan_log);
+
+    ensure_network(&docker, &network_name, false).await?;
+    apply_egress(project_id, container_name, container_settings, &pool).await?;
+
+    check_volumes(project_id, container_name, &pool)
+        .await
+        .map_err(|err| anyhow::anyhow!("{build_log}{err}"))?;
+
+    let db_url = database_url(project_id, &pool).await.map_err(|err| {
+        tracing::error!("Failed to query database: {}", err);
+        err
+    })?;
+
+    let project = sqlx::query!(
+        r#"SELECT healthcheck_path, protocol
+        FROM projects
+        JOIN project_owners ON projects.owner_id = project_owners.id
+        WHERE projects.name = $1 AND project_owners.name = $2"#,
+        project_name, owner,
+    )
+    .fetch_one(&pool)
+    .await
+    .map_err(|err| {
+        tracing::error!(?err, "Failed to query database: {}", err);
+        err
+    })?;
+
+    let (env, project_secrets) = project_environment(owner, project_name, &pool).await?;
+    let (service, service_env) =
+        service_network(project_id, container_settings.port, &pool).await?;
+    let release_config = ReleaseConfig {
+        env: [service_env, env].concat(),
+        secrets: project_secrets,
+        cmd: None,
+        workers: BTreeMap::new(),
+        service,
+        private: Some(private_network(owner, project_name)),
+        volumes: project_mounts(project_id, container_name, &pool).await?,
+        limits: Some(project_limits(project_id, container_settings, &pool).await?),
+        restarts: Some(project_restarts(project_id, &pool).await?),
+        site: None,
+    };
+
+    let (id, ip) = orchestrator::driver()
+        .run_web(
+            container_name,
+            &image_id,
+            &release_config,
+            &db_url,
+            project.healthcheck_path.as_deref(),
+            project.protocol == "h2c",
+            container_settings,
+            secrets,
+        )
+        .await?;
+
+    Ok(DockerContainer {
+        id,
+        ip,
+        port: container_settings.port,
+        build_log,
+        db_url,
+        image: image_id,
+        config: release_config,
+        site: None,
+    })
+}
+
+/// Starts the app container next to the live one and waits until it is ready. Returns the
+/// container id and its ip on the project network; the proxy is not switched yet.
+pub async fn run_container(
+    docker: &Docker,
+    container_name: &str,
+    image: &str,
+    release_config: &ReleaseConfig,
+    db_url: &str,
+    healthcheck_path: Option<&str>,
+    h2c: bool,
+    container_settings: &ContainerSettings,
+    secrets: &SecretCipher,
+) -> Result<(String, String)> {
+    let network_name = format!("{}-network", container_name);
+    // the new container runs next to the old one until it is ready, see promote_container
+    let next_name = format!("{}-next", container_name);
+    let port = container_settings.port;
+
+    // a previous deploy that failed its readiness check can leave its container behind
+    let leftovers = docker
+        .list_containers(Some(ListContainersOptions::<String> {
+            all: true,
+            filters: HashMap::from([("name".to_string(), vec![format!("^{next_name}$")])]),
+            ..Default::default()
+        }))
+        .await
+        .map_err(|err| {
+            tracing::error!("Failed to list containers: {}", err);
+            err
+        })?;
+
+    if !leftovers.is_empty() {
+        docker
+            .remove_container(
+                &next_name,
+                Some(RemoveContainerOptions {
+                    force: true,
+                    ..Default::default()
+                }),
+            )
+            .await
+            .map_err(|err| {
+                tracing::error!("Failed to remove container: {}", err);
+                err
+            })?;
+    }
+
+    // an app that moved to another node starts there without a build, see crate::nodes::drain
+    ensure_network(docker, &network_name, false).await?;
+
+    let config: Config<String> = Config {
+        image: Some(image.to_string()),
+        // TDDO: rethink if we need to make this configurable
+        env: Some(container_env(release_config, port, db_url, secrets)?),
+        cmd: release_config.cmd.clone(),
+        host_config: Some(limited_host_config(release_config, container_settings, HostConfig {
+            restart_policy: Some(release_config.restarts.clone().unwrap_or_default().docker()),
+            // only on the project network like the workers, the default bridge would be a
+            // way around the egress policy of the app
+            network_mode: Some(network_name.clone()),
+            binds: volume_binds(release_config),
+            ..Default::default()
+        })),
+        ..Default::default()
+    };
+
+    let res = docker
+        .create_container(
+            Some(CreateContainerOptions {
+                name: next_name.as_str(),
+                platform: None,
+            }),
+            config,
+        )
+        .await
+        .map_err(|err| {
+            tracing::error!("Failed to create container: {}", err);
+            err
+        })?;
+
+    tracing::info!("create response-> {:#?}", res);
+
+    join_service_network(docker, release_config, &next_name, true).await?;
+
+    docker
+        .start_container(&next_name, None::<StartContainerOptions<&str>>)
+        .await
+        .map_err(|err| {
+            tracing::error!("Failed to start container: {}", err);
+            err
+        })?;
+
+    //inspect network
+    let network_inspect = docker
+        .inspect_network(
+            &network_name,
+            Some(InspectNetworkOptions::<&str> {
                 verbose: true,
                 ..Default::default()
             }),
@@ -816,24 +1753,1089 @@ pub async fn build_docker(
 
     tracing::info!(ip = ?ip, port = ?port, "Container {} ip address", container_name);
 
-    let _ = docker
-        .disconnect_network(
-            "bridge",
-            DisconnectNetworkOptions {
-                container: container_name,
-                force: true,
+    // the old container keeps serving until the new one is ready, so a broken deploy
+    // costs nothing but the attempt
+    if let Err(err) = wait_until_ready(
+        &ip,
+        port,
+        healthcheck_path,
+        h2c,
+        container_settings.healthtimeout,
+    )
+    .await
+    {
+        let _ = docker
+            .remove_container(
+                &next_name,
+                Some(RemoveContainerOptions {
+                    force: true,
+                    ..Default::default()
+                }),
+            )
+            .await
+            .map_err(|err| {
+                tracing::error!("Failed to remove container: {}", err);
+                err
+            });
+
+        return Err(err);
+    }
+
+    Ok((res.id, ip))
+}
+
+/// Retires the previous container once the proxy points at the new one. Requests in flight to
+/// it get up to the drain period to finish before it is stopped, then the new container
+/// takes over the canonical name so logs and the terminal find it.
+#[tracing::instrument]
+pub async fn promote_container(
+    container_name: &str,
+    container_id: &str,
+    container_settings: &ContainerSettings,
+) -> Result<()> {
+    let old_image_name = format!("{}:old", container_name);
+
+    let docker = nodes::docker(container_name).map_err(|err| {
+        tracing::error!("Failed to connect to docker: {}", err);
+        err
+    })?;
+
+    let containers = docker
+        .list_containers(Some(ListContainersOptions::<String> {
+            all: true,
+            filters: HashMap::from([("name".to_string(), vec![format!("^{container_name}$")])]),
+            ..Default::default()
+        }))
+        .await
+        .map_err(|err| {
+            tracing::error!("Failed to list containers: {}", err);
+            err
+        })?;
+
+    if let Some(old) = containers.first() {
+        let deadline = std::time::Duration::from_secs(container_settings.drainperiod);
+        let left = in_flight::drain(old.id.as_deref().unwrap_or_default(), deadline).await;
+        if left > 0 {
+            tracing::warn!(container_name, left, "Requests still in flight after the drain period, stopping anyway");
+        }
+
+        // an idle app has nothing to drain, docker refuses to stop a stopped container
+        if old.state.as_deref() == Some("running") {
+            // give the app a chance to drain in-flight requests before it gets killed
+            docker
+                .stop_container(
+                    container_name,
+                    Some(StopContainerOptions {
+                        t: container_settings.stoptimeout,
+                    }),
+                )
+                .await
+                .map_err(|err| {
+                    tracing::error!("Failed to stop container: {}", err);
+                    err
+                })?;
+        }
+
+        docker
+            .remove_container(old.id.as_ref().unwrap(), None)
+            .await
+            .map_err(|err| {
+                tracing::error!("Failed to remove container: {}", err);
+                err
+            })?;
+
+        let _ = docker
+            .remove_image(&old_image_name, None, None)
+            .await
+            .map_err(|err| {
+                tracing::error!("Failed to remove image: {}", err);
+                err
+            });
+    }
+
+    docker
+        .rename_container(
+            container_id,
+            RenameContainerOptions {
+                name: container_name,
             },
         )
         .await
         .map_err(|err| {
-            tracing::error!("Failed to disconnect container from bridge: {}", err);
+            tracing::error!("Failed to rename container: {}", err);
             err
-        });
+        })?;
 
-    Ok(DockerContainer {
-        ip,
-        port,
-        build_log,
-        db_url,
-    })
+    Ok(())
+}
+
+/// Keeps a freshly started container next to the live one as the canary of a project,
+/// replacing an earlier canary. The proxy sends it the share of requests in its canaries row
+#[tracing::instrument]
+pub async fn keep_canary(container_name: &str, container_id: &str) -> Result<()> {
+    let docker = nodes::docker(container_name).map_err(|err| {
+        tracing::error!("Failed to connect to docker: {}", err);
+        err
+    })?;
+
+    remove_canary(container_name).await?;
+
+    docker
+        .rename_container(
+            container_id,
+            RenameContainerOptions {
+                name: format!("{}-canary", container_name),
+            },
+        )
+        .await
+        .map_err(|err| {
+            tracing::error!("Failed to rename container: {}", err);
+            err
+        })?;
+
+    Ok(())
+}
+
+/// Force removes the canary container of a project, if it has one
+#[tracing::instrument]
+pub async fn remove_canary(container_name: &str) -> Result<()> {
+    let canary_name = format!("{}-canary", container_name);
+
+    let docker = nodes::docker(container_name).map_err(|err| {
+        tracing::error!("Failed to connect to docker: {}", err);
+        err
+    })?;
+
+    let canaries = docker
+        .list_containers(Some(ListContainersOptions::<String> {
+            all: true,
+            filters: HashMap::from([("name".to_string(), vec![format!("^/{canary_name}$")])]),
+            ..Default::default()
+        }))
+        .await
+        .map_err(|err| {
+            tracing::error!("Failed to list containers: {}", err);
+            err
+        })?;
+
+    if !canaries.is_empty() {
+        docker
+            .remove_container(
+                &canary_name,
+                Some(RemoveContainerOptions {
+                    force: true,
+                    ..Default::default()
+                }),
+            )
+            .await
+            .map_err(|err| {
+                tracing::error!("Failed to remove container: {}", err);
+                err
+            })?;
+    }
+
+    Ok(())
+}
+
+/// Name of a worker container, workers of a process type are numbered from 1
+fn worker_name(container_name: &str, process: &str, index: i64) -> String {
+    format!("{}-{}-{}", container_name, process, index)
+}
+
+/// Containers the formation asks for besides the app container, as name, process type and
+/// command. The app container is the first web replica, so extra web replicas start at 2
+fn formation_containers(
+    container_name: &str,
+    release_config: &ReleaseConfig,
+    formation: &BTreeMap<String, i64>,
+) -> Vec<(String, String, Option<Vec<String>>)> {
+    let web = formation.get("web").copied().unwrap_or(1);
+    let replicas = (2..=web).map(|index| {
+        (worker_name(container_name, "web", index), "web".to_string(), release_config.cmd.clone())
+    });
+
+    let workers = release_config.workers.iter().flat_map(|(process, command)| {
+        let count = formation.get(process).copied().unwrap_or(1);
+        (1..=count).map(move |index| {
+            (worker_name(container_name, process, index), process.clone(), Some(command.clone()))
+        })
+    });
+
+    replicas.chain(workers).collect()
+}
+
+/// Brings the worker containers and extra web replicas of a project in line with the release
+/// and the formation, the number of containers per process type. Process types missing from
+/// the formation run one container. With `restart` every container is replaced, like a deploy
+/// needs; otherwise running ones are left alone and only missing or extra ones are started or
+/// stopped. Workers join the project network to reach the database, but the proxy only sends
+/// http to web replicas, once the balancer has seen them pass a health probe.
+#[tracing::instrument(skip(release_config, db_url, secrets))]
+pub async fn run_workers(
+    container_name: &str,
+    image: &str,
+    release_config: &ReleaseConfig,
+    db_url: &str,
+    formation: &BTreeMap<String, i64>,
+    restart: bool,
+    container_settings: &ContainerSettings,
+    secrets: &SecretCipher,
+) -> Result<()> {
+    let network_name = format!("{}-network", container_name);
+
+    let docker = nodes::docker(container_name).map_err(|err| {
+        tracing::error!("Failed to connect to docker: {}", err);
+        err
+    })?;
+
+    let containers = formation_containers(container_name, release_config, formation);
+    let wanted = containers
+        .iter()
+        .map(|(name, _, _)| name.clone())
+        .collect::<HashSet<_>>();
+
+    let workers = docker
+        .list_containers(Some(ListContainersOptions::<String> {
+            all: true,
+            filters: HashMap::from([(
+                "label".to_string(),
+                vec![format!("{}={}", WORKER_LABEL, container_name)],
+            )]),
+            ..Default::default()
+        }))
+        .await
+        .map_err(|err| {
+            tracing::error!("Failed to list containers: {}", err);
+            err
+        })?;
+
+    let mut running = HashSet::new();
+    for worker in workers {
+        let name = worker
+            .names
+            .unwrap_or_default()
+            .first()
+            .map(|name| name.trim_start_matches('/').to_string())
+            .unwrap_or_default();
+
+        if !restart && wanted.contains(&name) && worker.state.as_deref() == Some("running") {
+            running.insert(name);
+            continue;
+        }
+
+        // web replicas finish their requests first, the balancer sends them no new ones
+        if let Some(id) = worker.id.as_deref().filter(|_| worker.state.as_deref() == Some("running")) {
+            let deadline = std::time::Duration::from_secs(container_settings.drainperiod);
+            in_flight::drain(id, deadline).await;
+        }
+
+        // workers get the same grace period as the web process to finish their job
+        let _ = docker
+            .stop_container(
+                &name,
+                Some(StopContainerOptions {
+                    t: container_settings.stoptimeout,
+                }),
+            )
+            .await;
+
+        docker
+            .remove_container(
+                &name,
+                Some(RemoveContainerOptions {
+                    force: true,
+                    ..Default::default()
+                }),
+            )
+            .await
+            .map_err(|err| {
+                tracing::error!("Failed to remove container: {}", err);
+                err
+            })?;
+    }
+
+    for (name, process, command) in containers {
+        if running.contains(&name) {
+            continue;
+        }
+        let web = process == "web";
+
+        let config: Config<String> = Config {
+            image: Some(image.to_string()),
+            env: Some(container_env(release_config, container_settings.port, db_url, secrets)?),
+            cmd: command,
+            labels: Some(HashMap::from([
+                (WORKER_LABEL.to_string(), container_name.to_string()),
+                (PROCESS_LABEL.to_string(), process),
+            ])),
+            host_config: Some(limited_host_config(release_config, container_settings, HostConfig {
+                restart_policy: Some(release_config.restarts.clone().unwrap_or_default().docker()),
+                network_mode: Some(network_name.clone()),
+                binds: volume_binds(release_config),
+                ..Default::default()
+            })),
+            ..Default::default()
+        };
+
+        docker
+            .create_container(
+                Some(CreateContainerOptions {
+                    name: name.as_str(),
+                    platform: None,
+                }),
+                config,
+            )
+            .await
+            .map_err(|err| {
+                tracing::error!("Failed to create container: {}", err);
+                err
+            })?;
+        join_service_network(&docker, release_config, &name, web).await?;
+
+        docker
+            .start_container(&name, None::<StartContainerOptions<&str>>)
+            .await
+            .map_err(|err| {
+                tracing::error!("Failed to start container: {}", err);
+                err
+            })?;
+    }
+
+    Ok(())
+}
+
+/// A running container of a project, the web container or one of its workers
+#[derive(Debug, Clone)]
+pub struct ProjectContainer {
+    pub id: String,
+    pub name: String,
+    /// web for the app container, the Procfile entry for workers
+    pub process: String,
+    /// unix seconds
+    pub created: i64,
+}
+
+/// Running web and worker containers of a project
+pub async fn project_containers(container_name: &str) -> Result<Vec<ProjectContainer>> {
+    let docker = nodes::docker(container_name).map_err(|err| {
+        tracing::error!("Failed to connect to docker: {}", err);
+        err
+    })?;
+
+    // docker matches names as a regex against every name of a container
+    let web = docker
+        .list_containers(Some(ListContainersOptions::<String> {
+            filters: HashMap::from([(
+                "name".to_string(),
+                vec![format!("^/{}$", container_name)],
+            )]),
+            ..Default::default()
+        }))
+        .await?;
+
+    let workers = docker
+        .list_containers(Some(ListContainersOptions::<String> {
+            filters: HashMap::from([(
+                "label".to_string(),
+                vec![format!("{}={}", WORKER_LABEL, container_name)],
+            )]),
+            ..Default::default()
+        }))
+        .await?;
+
+    let containers = web
+        .into_iter()
+        .map(|container| (container, "web".to_string()))
+        .chain(workers.into_iter().filter_map(|container| {
+            let process = container.labels.as_ref()?.get(PROCESS_LABEL)?.clone();
+            Some((container, process))
+        }))
+        .filter_map(|(container, process)| {
+            let name = container.names?.first()?.trim_start_matches('/').to_string();
+            Some(ProjectContainer {
+                id: container.id?,
+                name,
+                process,
+                created: container.created.unwrap_or_default(),
+            })
+        })
+        .collect();
+
+    Ok(containers)
+}
+
+/// Number of running containers per process type, web counts the app container too
+pub async fn running_processes(container_name: &str) -> Result<HashMap<String, i64>> {
+    let mut running = HashMap::new();
+    for container in project_containers(container_name).await? {
+        *running.entry(container.process).or_insert(0) += 1;
+    }
+
+    Ok(running)
+}
+
+/// A running extra web replica of a project
+#[derive(Debug, Clone)]
+pub struct WebReplica {
+    pub id: String,
+    /// ip on the project network
+    pub ip: String,
+}
+
+/// Running web replicas of a project besides the app container. The app container isn't
+/// included, the proxy finds it through the domains row so deploys can swap it
+pub async fn web_replicas(container_name: &str) -> Result<Vec<WebReplica>> {
+    let network_name = format!("{}-network", container_name);
+
+    let docker = nodes::docker(container_name).map_err(|err| {
+        tracing::error!("Failed to connect to docker: {}", err);
+        err
+    })?;
+
+    // label filters have to match all of them
+    let replicas = docker
+        .list_containers(Some(ListContainersOptions::<String> {
+            filters: HashMap::from([(
+                "label".to_string(),
+                vec![
+                    format!("{}={}", WORKER_LABEL, container_name),
+                    format!("{}=web", PROCESS_LABEL),
+                ],
+            )]),
+            ..Default::default()
+        }))
+        .await?;
+
+    let replicas = replicas
+        .into_iter()
+        .filter_map(|replica| {
+            let network = replica.network_settings?.networks?.remove(&network_name)?;
+            let ip = network
+                .ip_address
+                .filter(|ip| !ip.is_empty())
+                .or(network.global_ipv6_address.filter(|ip| !ip.is_empty()))?;
+            Some(WebReplica { id: replica.id?, ip })
+        })
+        .collect();
+
+    Ok(replicas)
+}
+
+/// Stops the app container and the extra web replicas of an idle project. Workers keep
+/// running, they don't get http and can't be woken by a request
+#[tracing::instrument(skip(container_settings))]
+pub async fn stop_web(
+    container_name: &str,
+    container_id: &str,
+    container_settings: &ContainerSettings,
+) -> Result<()> {
+    let docker = nodes::docker(container_name).map_err(|err| {
+        tracing::error!("Failed to connect to docker: {}", err);
+        err
+    })?;
+
+    for replica in web_replicas(container_name).await? {
+        docker
+            .stop_container(
+                &replica.id,
+                Some(StopContainerOptions {
+                    t: container_settings.stoptimeout,
+                }),
+            )
+            .await
+            .map_err(|err| {
+                tracing::error!("Failed to stop container: {}", err);
+                err
+            })?;
+    }
+
+    docker
+        .stop_container(
+            container_id,
+            Some(StopContainerOptions {
+                t: container_settings.stoptimeout,
+            }),
+        )
+        .await
+        .map_err(|err| {
+            tracing::error!("Failed to stop container: {}", err);
+            err
+        })?;
+
+    Ok(())
+}
+
+/// Starts the stopped app container of an idle project and waits until it is ready, then
+/// starts its stopped web replicas for the balancer to pick up. Returns the ip of the app
+/// container on the project network
+#[tracing::instrument]
+pub async fn start_web(
+    container_name: &str,
+    container_id: &str,
+    port: i32,
+    healthcheck_path: Option<&str>,
+    h2c: bool,
+    timeout: u64,
+) -> Result<String> {
+    let network_name = format!("{}-network", container_name);
+
+    let docker = nodes::docker(container_name).map_err(|err| {
+        tracing::error!("Failed to connect to docker: {}", err);
+        err
+    })?;
+
+    docker
+        .start_container(container_id, None::<StartContainerOptions<&str>>)
+        .await
+        .map_err(|err| {
+            tracing::error!("Failed to start container: {}", err);
+            err
+        })?;
+
+    let inspect = docker.inspect_container(container_id, None).await?;
+
+    let ip = inspect
+        .network_settings
+        .as_ref()
+        .and_then(|network| network.networks.as_ref()?.get(&network_name))
+        .and_then(|network| {
+            network
+                .ip_address
+                .clone()
+                .filter(|ip| !ip.is_empty())
+                .or(network.global_ipv6_address.clone().filter(|ip| !ip.is_empty()))
+        })
+        .ok_or_else(|| anyhow::anyhow!("No ip address found for container {}", container_name))?;
+
+    wait_until_ready(&ip, port, healthcheck_path, h2c, timeout).await?;
+
+    // the app container already answers, a replica that fails to start only costs capacity
+    let replicas = docker
+        .list_containers(Some(ListContainersOptions::<String> {
+            all: true,
+            filters: HashMap::from([
+                (
+                    "label".to_string(),
+                    vec![
+                        format!("{}={}", WORKER_LABEL, container_name),
+                        format!("{}=web", PROCESS_LABEL),
+                    ],
+                ),
+                ("status".to_string(), vec!["exited".to_string()]),
+            ]),
+            ..Default::default()
+        }))
+        .await?;
+
+    for replica in replicas.into_iter().filter_map(|replica| replica.id) {
+        if let Err(err) = docker
+            .start_container(&replica, None::<StartContainerOptions<&str>>)
+            .await
+        {
+            tracing::error!(?err, replica, "Failed to start web replica");
+        }
+    }
+
+    Ok(ip)
+}
+
+/// Stops every web and worker container of a suspended project. They are kept, so
+/// [`resume_containers`] brings the project back as it was
+#[tracing::instrument(skip(container_settings))]
+pub async fn suspend_containers(container_name: &str, container_settings: &ContainerSettings) -> Result<()> {
+    let docker = nodes::docker(container_name).map_err(|err| {
+        tracing::error!("Failed to connect to docker: {}", err);
+        err
+    })?;
+
+    for container in project_containers(container_name).await? {
+        docker
+            .stop_container(
+                &container.id,
+                Some(StopContainerOptions {
+                    t: container_settings.stoptimeout,
+                }),
+            )
+            .await
+            .map_err(|err| {
+                tracing::error!("Failed to stop container: {}", err);
+                err
+            })?;
+    }
+
+    Ok(())
+}
+
+/// Starts the containers [`suspend_containers`] stopped. Idle apps come back idle once the
+/// idler sees no traffic
+#[tracing::instrument]
+pub async fn resume_containers(container_name: &str) -> Result<()> {
+    let docker = nodes::docker(container_name).map_err(|err| {
+        tracing::error!("Failed to connect to docker: {}", err);
+        err
+    })?;
+
+    let mut stopped = Vec::new();
+    for filter in [
+        ("name".to_string(), format!("^/{}$", container_name)),
+        ("label".to_string(), format!("{}={}", WORKER_LABEL, container_name)),
+    ] {
+        let containers = docker
+            .list_containers(Some(ListContainersOptions::<String> {
+                all: true,
+                filters: HashMap::from([
+                    (filter.0, vec![filter.1]),
+                    ("status".to_string(), vec!["exited".to_string()]),
+                ]),
+                ..Default::default()
+            }))
+            .await?;
+        stopped.extend(containers.into_iter().filter_map(|container| container.id));
+    }
+
+    for container in stopped {
+        docker
+            .start_container(&container, None::<StartContainerOptions<&str>>)
+            .await
+            .map_err(|err| {
+                tracing::error!("Failed to start container: {}", err);
+                err
+            })?;
+    }
+
+    Ok(())
+}
+
+/// Force removes every worker container of a project
+pub async fn remove_workers(container_name: &str) -> Result<()> {
+    let docker = nodes::docker(container_name).map_err(|err| {
+        tracing::error!("Failed to connect to docker: {}", err);
+        err
+    })?;
+
+    let workers = docker
+        .list_containers(Some(ListContainersOptions::<String> {
+            all: true,
+            filters: HashMap::from([(
+                "label".to_string(),
+                vec![format!("{}={}", WORKER_LABEL, container_name)],
+            )]),
+            ..Default::default()
+        }))
+        .await
+        .map_err(|err| {
+            tracing::error!("Failed to list containers: {}", err);
+            err
+        })?;
+
+    for worker in workers {
+        if let Some(id) = worker.id {
+            docker
+                .remove_container(
+                    &id,
+                    Some(RemoveContainerOptions {
+                        force: true,
+                        ..Default::default()
+                    }),
+                )
+                .await
+                .map_err(|err| {
+                    tracing::error!("Failed to remove container: {}", err);
+                    err
+                })?;
+        }
+    }
+
+    Ok(())
+}
+
+/// Container config of a one-off command, it joins the project network like the app does
+/// and is never restarted
+fn one_off_config(
+    image: &str,
+    release_config: &ReleaseConfig,
+    db_url: &str,
+    command: &[String],
+    network_name: String,
+    container_settings: &ContainerSettings,
+    secrets: &SecretCipher,
+) -> Result<Config<String>> {
+    Ok(Config {
+        image: Some(image.to_string()),
+        env: Some(container_env(release_config, container_settings.port, db_url, secrets)?),
+        cmd: Some(command.to_vec()),
+        host_config: Some(limited_host_config(release_config, container_settings, HostConfig {
+            restart_policy: Some(RestartPolicy {
+                name: Some(RestartPolicyNameEnum::NO),
+                ..Default::default()
+            }),
+            network_mode: Some(network_name),
+            binds: volume_binds(release_config),
+            ..Default::default()
+        })),
+        ..Default::default()
+    })
+}
+
+/// Runs `command` once in a new container called `run_name` from the release image and waits
+/// for it to exit. The container joins the project network like the app does and is removed
+/// afterwards. Returns the exit code, none when it got killed after `timeout` seconds, and
+/// the tail of its output.
+#[tracing::instrument(skip(release_config, db_url, secrets))]
+pub async fn run_once(
+    container_name: &str,
+    run_name: &str,
+    image: &str,
+    release_config: &ReleaseConfig,
+    db_url: &str,
+    command: &[String],
+    timeout: u64,
+    container_settings: &ContainerSettings,
+    secrets: &SecretCipher,
+) -> Result<(Option<i64>, String)> {
+    let network_name = format!("{}-network", container_name);
+
+    let docker = nodes::docker(container_name).map_err(|err| {
+        tracing::error!("Failed to connect to docker: {}", err);
+        err
+    })?;
+
+    let config = one_off_config(
+        image,
+        release_config,
+        db_url,
+        command,
+        network_name,
+        container_settings,
+        secrets,
+    )?;
+
+    docker
+        .create_container(
+            Some(CreateContainerOptions {
+                name: run_name,
+                platform: None,
+            }),
+            config,
+        )
+        .await
+        .map_err(|err| {
+            tracing::error!("Failed to create container: {}", err);
+            err
+        })?;
+
+    if let Err(err) = join_service_network(&docker, release_config, run_name, false).await {
+        remove_once(run_name).await;
+        return Err(err);
+    }
+
+    if let Err(err) = docker
+        .start_container(run_name, None::<StartContainerOptions<&str>>)
+        .await
+    {
+        tracing::error!("Failed to start container: {}", err);
+        remove_once(run_name).await;
+        return Err(err.into());
+    }
+
+    let wait = docker
+        .wait_container(run_name, None::<WaitContainerOptions<&str>>)
+        .try_collect::<Vec<_>>();
+
+    let exit_code = match tokio::time::timeout(std::time::Duration::from_secs(timeout), wait).await {
+        Ok(Ok(responses)) => responses.last().map(|res| res.status_code),
+        // bollard reports a non zero exit code as an error
+        Ok(Err(bollard::errors::Error::DockerContainerWaitError { code, .. })) => Some(code),
+        Ok(Err(err)) => {
+            tracing::error!("Failed to wait for container: {}", err);
+            remove_once(run_name).await;
+            return Err(err.into());
+        }
+        Err(_) => {
+            let _ = docker
+                .kill_container(run_name, None::<KillContainerOptions<&str>>)
+                .await;
+            None
+        }
+    };
+
+    let mut output = String::new();
+    let mut logs = docker.logs(
+        run_name,
+        Some(LogsOptions::<&str> {
+            stdout: true,
+            stderr: true,
+            tail: "1000",
+            ..Default::default()
+        }),
+    );
+
+    while let Some(log) = logs.next().await {
+        match log {
+            Ok(LogOutput::StdOut { message }) | Ok(LogOutput::StdErr { message }) => {
+                output.push_str(&String::from_utf8_lossy(&message));
+            }
+            Ok(_) => {}
+            Err(err) => {
+                tracing::error!("Failed to read container logs: {}", err);
+                break;
+            }
+        }
+    }
+
+    if exit_code.is_none() {
+        output.push_str(&format!("\nKilled after {} seconds\n", timeout));
+    }
+
+    remove_once(run_name).await;
+
+    Ok((exit_code, output))
+}
+
+/// Starts `command` in a new container called `run_name` like `run_once` does, but attached
+/// so the caller streams its stdin and output. The caller waits for the container and has to
+/// remove it once it's done.
+#[tracing::instrument(skip(release_config, db_url, secrets))]
+pub async fn start_attached(
+    container_name: &str,
+    run_name: &str,
+    image: &str,
+    release_config: &ReleaseConfig,
+    db_url: &str,
+    command: &[String],
+    tty: bool,
+    container_settings: &ContainerSettings,
+    secrets: &SecretCipher,
+) -> Result<AttachContainerResults> {
+    let network_name = format!("{}-network", container_name);
+
+    let docker = nodes::docker(container_name).map_err(|err| {
+        tracing::error!("Failed to connect to docker: {}", err);
+        err
+    })?;
+
+    let config = Config {
+        tty: Some(tty),
+        open_stdin: Some(true),
+        // stdin closes once the client is done writing, so commands reading it can finish
+        stdin_once: Some(true),
+        attach_stdin: Some(true),
+        attach_stdout: Some(true),
+        attach_stderr: Some(true),
+        ..one_off_config(
+            image,
+            release_config,
+            db_url,
+            command,
+            network_name,
+            container_settings,
+            secrets,
+        )?
+    };
+
+    docker
+        .create_container(
+            Some(CreateContainerOptions {
+                name: run_name,
+                platform: None,
+            }),
+            config,
+        )
+        .await
+        .map_err(|err| {
+            tracing::error!("Failed to create container: {}", err);
+            err
+        })?;
+
+    // attach before starting, otherwise the first output of short commands is lost
+    let attached = match docker
+        .attach_container(
+            run_name,
+            Some(AttachContainerOptions::<String> {
+                stdin: Some(true),
+                stdout: Some(true),
+                stderr: Some(true),
+                stream: Some(true),
+                ..Default::default()
+            }),
+        )
+        .await
+    {
+        Ok(attached) => attached,
+        Err(err) => {
+            tracing::error!("Failed to attach to container: {}", err);
+            remove_once(run_name).await;
+            return Err(err.into());
+        }
+    };
+
+    if let Err(err) = join_service_network(&docker, release_config, run_name, false).await {
+        remove_once(run_name).await;
+        return Err(err);
+    }
+
+    if let Err(err) = docker
+        .start_container(run_name, None::<StartContainerOptions<&str>>)
+        .await
+    {
+        tracing::error!("Failed to start container: {}", err);
+        remove_once(run_name).await;
+        return Err(err.into());
+    }
+
+    Ok(attached)
+}
+
+/// Force removes a one-off container, errors are only logged since there's nothing left to do
+pub async fn remove_once(run_name: &str) {
+    let docker = match nodes::docker(run_name) {
+        Ok(docker) => docker,
+        Err(err) => {
+            tracing::error!("Failed to connect to docker: {}", err);
+            return;
+        }
+    };
+
+    let _ = docker
+        .remove_container(
+            run_name,
+            Some(RemoveContainerOptions {
+                force: true,
+                ..Default::default()
+            }),
+        )
+        .await
+        .map_err(|err| {
+            tracing::error!("Failed to remove container: {}", err);
+            err
+        });
+}
+
+/// Tags a released image as `{container_name}:{build_id}` so it survives the latest/old
+/// retagging of later deploys and stays available for rollbacks.
+pub async fn tag_release_image(container_name: &str, image: &str, build_id: Uuid) -> Result<()> {
+    let docker = nodes::docker(container_name).map_err(|err| {
+        tracing::error!("Failed to connect to docker: {}", err);
+        err
+    })?;
+
+    docker
+        .tag_image(
+            image,
+            Some(TagImageOptions {
+                repo: container_name.to_string(),
+                tag: build_id.to_string(),
+            }),
+        )
+        .await
+        .map_err(|err| {
+            tracing::error!("Failed to tag image: {}", err);
+            err
+        })?;
+
+    Ok(())
+}
+
+/// Drops the release tag again. The image itself is only deleted by docker once nothing else
+/// references it.
+pub async fn untag_release_image(container_name: &str, build_id: Uuid) -> Result<()> {
+    let docker = nodes::docker(container_name).map_err(|err| {
+        tracing::error!("Failed to connect to docker: {}", err);
+        err
+    })?;
+
+    docker
+        .remove_image(&format!("{}:{}", container_name, build_id), None, None)
+        .await
+        .map_err(|err| {
+            tracing::error!("Failed to remove image: {}", err);
+            err
+        })?;
+
+    Ok(())
+}
+
+/// Appends build output to the build row so it can be streamed while the build runs, and
+/// passes it on to whoever watches the app live. Failing to write is not fatal, the full log
+/// is stored again when the build finishes.
+async fn append_build_log(pool: &PgPool, build_id: Uuid, chunk: &str) {
+    if chunk.is_empty() {
+        return;
+    }
+
+    match sqlx::query!(
+        r#"UPDATE builds SET log = log || $1, updated_at = now() WHERE id = $2
+           RETURNING project_id, octet_length(log) AS "offset!"
+        "#,
+        chunk,
+        build_id
+    )
+    .fetch_optional(pool)
+    .await
+    {
+        Ok(Some(build)) => live::publish(
+            build.project_id,
+            Update::BuildLog {
+                build_id,
+                offset: build.offset as usize,
+                log: chunk.to_string(),
+            },
+        ),
+        Ok(None) => {}
+        Err(err) => tracing::error!(?err, "Can't append build log: Failed to query database"),
+    }
+}
+
+/// Polls the freshly started container until it answers on the given path. Without a
+/// healthcheck path any http response counts, since the app is at least listening by then.
+/// `h2c` apps are asked over http/2 without tls, grpc servers don't answer http/1.1
+#[tracing::instrument]
+pub async fn wait_until_ready(
+    ip: &str,
+    port: i32,
+    healthcheck_path: Option<&str>,
+    h2c: bool,
+    timeout: u64,
+) -> Result<()> {
+    let host = match ip.contains(':') {
+        true => format!("[{ip}]"),
+        false => ip.to_string(),
+    };
+    let path = healthcheck_path.unwrap_or("/");
+    let url = format!("http://{host}:{port}{path}");
+
+    let mut client = reqwest::Client::builder()
+        .timeout(std::time::Duration::from_secs(2))
+        .redirect(reqwest::redirect::Policy::none());
+    if h2c {
+        client = client.http2_prior_knowledge();
+    }
+    let client = client.build()?;
+
+    let deadline = std::time::Instant::now() + std::time::Duration::from_secs(timeout);
+    loop {
+        match client.get(&url).send().await {
+            Ok(res) if healthcheck_path.is_none() || res.status().is_success() => {
+                tracing::info!(url, status = ?res.status(), "Container is ready");
+                return Ok(());
+            }
+            Ok(res) => {
+                tracing::debug!(url, status = ?res.status(), "Container is not ready yet");
+            }
+            Err(err) => {
+                tracing::debug!(url, ?err, "Container is not reachable yet");
+            }
+        }
+
+        if std::time::Instant::now() >= deadline {
+            tracing::error!(url, "Container did not become ready in {} seconds", timeout);
+            return Err(anyhow::anyhow!(
+                "Container did not become ready in {} seconds. Make sure the app listens on $PORT and {} returns a 2xx status",
+                timeout,
+                path,
+            ));
+        }
+
+        tokio::time::sleep(std::time::Duration::from_secs(1)).await;
+    }
 }
@@ -0,0 +1,597 @@
+use std::collections::HashMap;
+use std::sync::atomic::{AtomicU64, Ordering};
+use std::sync::Arc;
+use std::time::Duration;
+
+use anyhow::Result;
+use bollard::container::{LogOutput, LogsOptions};
+use bollard::Docker;
+use chrono::{DateTime, Utc};
+use futures::{FutureExt, StreamExt};
+use serde::Serialize;
+use sqlx::PgPool;
+use tokio::io::AsyncWriteExt;
+use tokio::net::{TcpStream, UdpSocket};
+use tokio::sync::{mpsc, watch};
+use tokio::task::JoinHandle;
+use url::Url;
+use uuid::Uuid;
+
+use crate::container_logs;
+use crate::docker::{project_containers, ProjectContainer};
+use crate::live::{self, Update};
+use crate::nodes;
+
+/// how often drains and containers are looked up again
+const TICK: Duration = Duration::from_secs(10);
+/// how long the last line of a stopped container is remembered, so a restart of it doesn't
+/// read its output again
+const REMEMBER_STOPPED: Duration = Duration::from_secs(24 * 60 * 60);
+/// lines waiting per project, followers drop lines when it is full instead of slowing down
+const BUFFER: usize = 10_000;
+const BATCH_SIZE: usize = 500;
+/// how long a batch waits to fill up before it is sent anyway
+const BATCH_INTERVAL: Duration = Duration::from_secs(1);
+const SEND_TIMEOUT: Duration = Duration::from_secs(10);
+const RETRIES: u32 = 5;
+const INITIAL_BACKOFF: Duration = Duration::from_millis(500);
+const MAX_BACKOFF: Duration = Duration::from_secs(30);
+
+/// One line of container output together with where it came from
+#[derive(Serialize, Debug, Clone)]
+pub struct LogLine {
+    pub timestamp: DateTime<Utc>,
+    /// owner/project
+    pub app: String,
+    pub container: String,
+    pub process: String,
+    pub stream: &'static str,
+    pub message: String,
+    /// debug, info, warn or error, read from lines logged as json or logfmt
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub level: Option<&'static str>,
+}
+
+/// Level of a structured log line, like the ones of slog, zap, pino or logrus. JSON lines
+/// carry it as `level` or `severity`, pino as a number, logfmt lines as `level=`
+fn line_level(message: &str) -> Option<&'static str> {
+    let level = match message.trim_start().starts_with('{') {
+        true => {
+            let line = serde_json::from_str::<serde_json::Value>(message).ok()?;
+            let level = line.get("level").or_else(|| line.get("severity"))?;
+            match level.as_u64() {
+                // pino: 10 trace, 20 debug, 30 info, 40 warn, 50 error, 60 fatal
+                Some(number) => {
+                    return Some(match number {
+                        0..=29 => "debug",
+                        30..=39 => "info",
+                        40..=49 => "warn",
+                        _ => "error",
+                    })
+                }
+                None => level.as_str()?.to_lowercase(),
+            }
+        }
+        false => message
+            .split_whitespace()
+            .find_map(|field| field.strip_prefix("level="))?
+            .trim_matches('"')
+            .to_lowercase(),
+    };
+
+    // slog writes levels between the named ones as INFO+2 or DEBUG-4
+    match level.split(['+', '-']).next()? {
+        "trace" | "debug" => Some("debug"),
+        "info" | "notice" => Some("info"),
+        "warn" | "warning" => Some("warn"),
+        "error" | "err" | "fatal" | "panic" | "critical" | "crit" | "alert" | "emergency" => Some("error"),
+        _ => None,
+    }
+}
+
+#[derive(Debug, Clone, Copy, PartialEq)]
+enum DrainKind {
+    /// json array of lines posted to the url
+    Http,
+    /// the push api of grafana loki
+    Loki,
+    /// rfc 5424 with octet counting framing
+    SyslogTcp,
+    SyslogUdp,
+}
+
+/// Where the logs of a project are forwarded to, picked by the scheme of the drain url
+#[derive(Debug, Clone)]
+pub struct Drain {
+    kind: DrainKind,
+    /// without credentials
+    url: Url,
+    username: String,
+    password: Option<String>,
+}
+
+impl Drain {
+    /// Accepts `http(s)://`, `loki+http(s)://`, `syslog://` (tcp) and `syslog+udp://` urls.
+    /// Credentials in http and loki urls are sent as basic auth
+    pub fn parse(value: &str) -> Result<Self> {
+        let url = Url::parse(value).map_err(|err| anyhow::anyhow!("Invalid url: {err}"))?;
+
+        let kind = match url.scheme() {
+            "http" | "https" => DrainKind::Http,
+            "loki+http" | "loki+https" => DrainKind::Loki,
+            "syslog" | "syslog+tcp" => DrainKind::SyslogTcp,
+            "syslog+udp" => DrainKind::SyslogUdp,
+            scheme => {
+                return Err(anyhow::anyhow!(
+                    "Unsupported scheme {scheme}, use https://, loki+https://, syslog:// or syslog+udp://"
+                ))
+            }
+        };
+
+        if url.host_str().is_none() {
+            return Err(anyhow::anyhow!("Log drain url has no host"));
+        }
+
+        let username = url.username().to_string();
+        let password = url.password().map(|password| password.to_string());
+
+        // the url crate can't switch from a custom scheme to a special one in place
+        let mut url = match kind {
+            DrainKind::Loki => Url::parse(value.trim_start_matches("loki+"))?,
+            _ => url,
+        };
+        let _ = url.set_username("");
+        let _ = url.set_password(None);
+
+        match kind {
+            DrainKind::Loki if url.path() == "/" => url.set_path("/loki/api/v1/push"),
+            DrainKind::SyslogTcp | DrainKind::SyslogUdp if url.port().is_none() => {
+                return Err(anyhow::anyhow!("Syslog drains need a port"));
+            }
+            _ => {}
+        }
+
+        Ok(Self {
+            kind,
+            url,
+            username,
+            password,
+        })
+    }
+
+    async fn send(&self, client: &reqwest::Client, batch: &[LogLine]) -> Result<()> {
+        match self.kind {
+            DrainKind::Http => self.post(client, serde_json::to_vec(batch)?).await,
+            DrainKind::Loki => self.post(client, serde_json::to_vec(&loki_push(batch))?).await,
+            DrainKind::SyslogTcp => {
+                let mut stream = tokio::time::timeout(SEND_TIMEOUT, TcpStream::connect(self.address())).await??;
+                let mut frames = Vec::new();
+                for line in batch {
+                    let message = syslog_message(line);
+                    frames.extend(format!("{} {}", message.len(), message).into_bytes());
+                }
+                tokio::time::timeout(SEND_TIMEOUT, stream.write_all(&frames)).await??;
+                stream.shutdown().await?;
+                Ok(())
+            }
+            DrainKind::SyslogUdp => {
+                let target = tokio::net::lookup_host(self.address())
+                    .await?
+                    .next()
+                    .ok_or(anyhow::anyhow!("Syslog host does not resolve"))?;
+                let local = if target.is_ipv6() { "[::]:0" } else { "0.0.0.0:0" };
+                let socket = UdpSocket::bind(local).await?;
+                for line in batch {
+                    socket.send_to(syslog_message(line).as_bytes(), target).await?;
+                }
+                Ok(())
+            }
+        }
+    }
+
+    async fn post(&self, client: &reqwest::Client, body: Vec<u8>) -> Result<()> {
+        let mut req = client
+            .post(self.url.clone())
+            .header(reqwest::header::CONTENT_TYPE, "application/json")
+            .body(body);
+
+        if !self.username.is_empty() {
+            req = req.basic_auth(&self.username, self.password.as_ref());
+        }
+
+        req.send().await?.error_for_status()?;
+        Ok(())
+    }
+
+    fn address(&self) -> String {
+        format!(
+            "{}:{}",
+            self.url.host_str().unwrap_or_default(),
+            self.url.port().unwrap_or_default()
+        )
+    }
+
+    /// Sends a batch, backing off exponentially between attempts. The batch is dropped
+    /// after the last attempt so one broken drain can't hold up the logs forever
+    async fn send_with_retry(&self, client: &reqwest::Client, app: &str, batch: &[LogLine]) {
+        let mut backoff = INITIAL_BACKOFF;
+        for attempt in 1..=RETRIES {
+            match self.send(client, batch).await {
+                Ok(()) => return,
+                Err(err) if attempt == RETRIES => {
+                    tracing::warn!(?err, app, drain = %self.url, lines = batch.len(), "Dropping logs, log drain keeps failing");
+                }
+                Err(err) => {
+                    tracing::debug!(?err, app, drain = %self.url, attempt, "Can't send logs to log drain, retrying");
+                    tokio::time::sleep(backoff).await;
+                    backoff = (backoff * 2).min(MAX_BACKOFF);
+                }
+            }
+        }
+    }
+}
+
+/// Drain url to show back to its owner with the password masked
+pub fn redact(value: &str) -> String {
+    match Url::parse(value) {
+        Ok(mut url) if url.password().is_some() => {
+            let _ = url.set_password(Some("****"));
+            url.to_string()
+        }
+        _ => value.to_string(),
+    }
+}
+
+fn syslog_message(line: &LogLine) -> String {
+    // facility user, the level of structured lines or informational for stdout and error for
+    // stderr
+    let priority = match (line.level, line.stream) {
+        (Some("debug"), _) => 8 + 7,
+        (Some("info"), _) => 8 + 6,
+        (Some("warn"), _) => 8 + 4,
+        (Some(_), _) | (None, "stderr") => 8 + 3,
+        (None, _) => 8 + 6,
+    };
+
+    format!(
+        "<{priority}>1 {} {} {} {} - - {}",
+        line.timestamp.to_rfc3339_opts(chrono::SecondsFormat::Micros, true),
+        line.app.replace('/', "-"),
+        line.process,
+        line.container,
+        line.message
+    )
+}
+
+fn loki_push(batch: &[LogLine]) -> serde_json::Value {
+    let mut streams: Vec<((&str, &str, &str, Option<&str>), Vec<[String; 2]>)> = Vec::new();
+    for line in batch {
+        let labels = (line.container.as_str(), line.process.as_str(), line.stream, line.level);
+        let value = [
+            line.timestamp.timestamp_nanos_opt().unwrap_or_default().to_string(),
+            line.message.clone(),
+        ];
+        match streams.iter_mut().find(|(key, _)| *key == labels) {
+            Some((_, values)) => values.push(value),
+            None => streams.push((labels, vec![value])),
+        }
+    }
+
+    let app = batch.first().map(|line| line.app.as_str()).unwrap_or_default();
+    let streams = streams
+        .into_iter()
+        .map(|((container, process, stream, level), values)| {
+            let mut labels = serde_json::json!({
+                "app": app,
+                "container": container,
+                "process": process,
+                "stream": stream,
+            });
+            // grafana colors lines by the level label
+            if let Some(level) = level {
+                labels["level"] = level.into();
+            }
+            serde_json::json!({
+                "stream": labels,
+                "values": values,
+            })
+        })
+        .collect::<Vec<_>>();
+
+    serde_json::json!({ "streams": streams })
+}
+
+/// Follows the containers of one project, keeps their output and sends it to its drains
+struct Forwarder {
+    drains: watch::Sender<Vec<Drain>>,
+    lines: mpsc::Sender<LogLine>,
+    dropped: Arc<AtomicU64>,
+    /// each ends with the time of the last line it read
+    followers: HashMap<String, JoinHandle<Option<DateTime<Utc>>>>,
+    /// of containers that stopped, by id
+    last_lines: HashMap<String, DateTime<Utc>>,
+    sender: JoinHandle<()>,
+}
+
+impl Drop for Forwarder {
+    fn drop(&mut self) {
+        self.sender.abort();
+        for follower in self.followers.values() {
+            follower.abort();
+        }
+    }
+}
+
+/// Keeps the output of every web and worker container, see [`container_logs`], and forwards
+/// it to the log drains of its project. Drains and containers are picked up within a tick, so
+/// redeploys and new drains need no restart
+pub async fn drain_forwarder(pool: PgPool) {
+    let client = match reqwest::Client::builder().timeout(SEND_TIMEOUT).build() {
+        Ok(client) => client,
+        Err(err) => {
+            tracing::error!(?err, "Can't forward logs: Failed to build http client");
+            return;
+        }
+    };
+
+    // output from before the platform started was either forwarded already or is lost
+    let started = Utc::now().timestamp();
+    let mut forwarders: HashMap<Uuid, Forwarder> = HashMap::new();
+
+    let mut interval = tokio::time::interval(TICK);
+    loop {
+        interval.tick().await;
+
+        // suspended apps have no containers to follow
+        let rows = match sqlx::query!(
+            r#"SELECT projects.id AS project_id, log_drains.url AS "url?", projects.name AS project,
+                      project_owners.name AS owner
+               FROM projects
+               JOIN project_owners ON projects.owner_id = project_owners.id
+               LEFT JOIN log_drains ON log_drains.project_id = projects.id
+               WHERE projects.suspended_at IS NULL
+            "#
+        )
+        .fetch_all(&pool)
+        .await
+        {
+            Ok(rows) => rows,
+            Err(err) => {
+                tracing::error!(?err, "Can't get log drains: Failed to query database");
+                continue;
+            }
+        };
+
+        let mut projects: HashMap<Uuid, (String, String, Vec<Drain>)> = HashMap::new();
+        for row in rows {
+            let repo = row.project.trim_end_matches(".git");
+            let (_, _, drains) = projects.entry(row.project_id).or_insert_with(|| {
+                (
+                    format!("{}-{}", row.owner, repo).replace('.', "-"),
+                    format!("{}/{}", row.owner, repo),
+                    Vec::new(),
+                )
+            });
+
+            let Some(url) = row.url else {
+                continue;
+            };
+            // drains are checked when they are added
+            match Drain::parse(&url) {
+                Ok(drain) => drains.push(drain),
+                Err(err) => tracing::error!(?err, project_id = ?row.project_id, "Can't use log drain"),
+            }
+        }
+
+        // dropping a forwarder stops its followers
+        forwarders.retain(|project_id, _| projects.contains_key(project_id));
+
+        for (project_id, (container_name, app, drains)) in projects {
+            let forwarder = forwarders.entry(project_id).or_insert_with(|| {
+                let (lines, receiver) = mpsc::channel(BUFFER);
+                let (drains, drains_receiver) = watch::channel(Vec::new());
+                let dropped = Arc::new(AtomicU64::new(0));
+
+                let sender = tokio::spawn(send_batches(
+                    project_id,
+                    app.clone(),
+                    receiver,
+                    drains_receiver,
+                    dropped.clone(),
+                    client.clone(),
+                    pool.clone(),
+                ));
+
+                Forwarder {
+                    drains,
+                    lines,
+                    dropped,
+                    followers: HashMap::new(),
+                    last_lines: HashMap::new(),
+                    sender,
+                }
+            });
+
+            forwarder.drains.send_replace(drains);
+
+            let stopped = forwarder
+                .followers
+                .iter()
+                .filter(|(_, follower)| follower.is_finished())
+                .map(|(id, _)| id.clone())
+                .collect::<Vec<_>>();
+            for id in stopped {
+                let last_line = forwarder.followers.remove(&id).and_then(|follower| follower.now_or_never());
+                if let Some(Ok(Some(last_line))) = last_line {
+                    forwarder.last_lines.insert(id, last_line);
+                }
+            }
+            let remember = chrono::Duration::from_std(REMEMBER_STOPPED).unwrap_or_default();
+            forwarder.last_lines.retain(|_, last_line| Utc::now() - *last_line < remember);
+
+            let containers = match project_containers(&container_name).await {
+                Ok(containers) => containers,
+                Err(err) => {
+                    tracing::error!(?err, "Can't forward logs: Failed to list containers");
+                    continue;
+                }
+            };
+            let docker = match nodes::docker(&container_name) {
+                Ok(docker) => docker,
+                Err(err) => {
+                    tracing::error!(?err, "Can't forward logs: Failed to connect to docker");
+                    continue;
+                }
+            };
+
+            for container in containers {
+                if forwarder.followers.contains_key(&container.id) {
+                    continue;
+                }
+
+                // a container that restarted goes on after what was read of it before
+                let id = container.id.clone();
+                let after = forwarder.last_lines.remove(&id);
+                let since = after
+                    .map(|after| after.timestamp())
+                    .unwrap_or(container.created.max(started));
+                let follower = tokio::spawn(follow(
+                    docker.clone(),
+                    container.clone(),
+                    app.clone(),
+                    since,
+                    after,
+                    forwarder.lines.clone(),
+                    forwarder.dropped.clone(),
+                ));
+                forwarder.followers.insert(id, follower);
+            }
+        }
+    }
+}
+
+/// Reads the output of a container until it stops, skipping lines up to `after`. Returns
+/// the time of the last line it read
+async fn follow(
+    docker: Docker,
+    container: ProjectContainer,
+    app: String,
+    since: i64,
+    after: Option<DateTime<Utc>>,
+    lines: mpsc::Sender<LogLine>,
+    dropped: Arc<AtomicU64>,
+) -> Option<DateTime<Utc>> {
+    let mut last_line = after;
+    let mut logs = docker.logs(
+        &container.id,
+        Some(LogsOptions::<String> {
+            follow: true,
+            stdout: true,
+            stderr: true,
+            since,
+            timestamps: true,
+            ..Default::default()
+        }),
+    );
+
+    while let Some(output) = logs.next().await {
+        let (stream, message) = match output {
+            Ok(LogOutput::StdOut { message }) | Ok(LogOutput::Console { message }) => ("stdout", message),
+            Ok(LogOutput::StdErr { message }) => ("stderr", message),
+            Ok(LogOutput::StdIn { .. }) => continue,
+            Err(err) => {
+                tracing::debug!(?err, container = %container.name, "Stopped following container logs");
+                return last_line;
+            }
+        };
+
+        for line in String::from_utf8_lossy(&message).lines() {
+            // docker puts an rfc 3339 timestamp in front of every line
+            let (timestamp, message) = match line.split_once(' ') {
+                Some((timestamp, message)) => match DateTime::parse_from_rfc3339(timestamp) {
+                    Ok(timestamp) => (timestamp.with_timezone(&Utc), message),
+                    Err(_) => (Utc::now(), line),
+                },
+                None => (Utc::now(), line),
+            };
+            // docker only goes back to whole seconds
+            if after.is_some_and(|after| timestamp <= after) {
+                continue;
+            }
+            last_line = Some(timestamp);
+
+            let line = LogLine {
+                timestamp,
+                app: app.clone(),
+                container: container.name.clone(),
+                process: container.process.clone(),
+                stream,
+                message: message.to_string(),
+                level: line_level(message),
+            };
+
+            if lines.try_send(line).is_err() {
+                dropped.fetch_add(1, Ordering::Relaxed);
+            }
+        }
+    }
+
+    last_line
+}
+
+/// Groups lines into batches, keeps every batch and hands it to all drains of the project. A
+/// slow drain holds up the next batch, which is what makes followers drop lines instead of
+/// piling them up in memory
+async fn send_batches(
+    project_id: Uuid,
+    app: String,
+    mut lines: mpsc::Receiver<LogLine>,
+    drains: watch::Receiver<Vec<Drain>>,
+    dropped: Arc<AtomicU64>,
+    client: reqwest::Client,
+    pool: PgPool,
+) {
+    while let Some(first) = lines.recv().await {
+        let mut batch = vec![first];
+
+        let deadline = tokio::time::sleep(BATCH_INTERVAL);
+        tokio::pin!(deadline);
+        while batch.len() < BATCH_SIZE {
+            tokio::select! {
+                line = lines.recv() => match line {
+                    Some(line) => batch.push(line),
+                    None => break,
+                },
+                _ = &mut deadline => break,
+            }
+        }
+
+        let count = dropped.swap(0, Ordering::Relaxed);
+        if count > 0 {
+            batch.push(LogLine {
+                timestamp: Utc::now(),
+                app: app.clone(),
+                container: "pemasak".to_string(),
+                process: "platform".to_string(),
+                stream: "stderr",
+                message: format!("Dropped {count} log lines, they came in faster than they could be kept and sent"),
+                level: Some("warn"),
+            });
+        }
+
+        if let Err(err) = container_logs::store(project_id, &batch, &pool).await {
+            tracing::error!(?err, app, "Can't store container logs: Failed to query database");
+        }
+        if live::watched(project_id) {
+            live::publish(project_id, Update::Log { lines: batch.clone() });
+        }
+
+        let targets = drains.borrow().clone();
+        futures::future::join_all(
+            targets
+                .iter()
+                .map(|drain| drain.send_with_retry(&client, &app, &batch)),
+        )
+        .await;
+    }
+}
@@ -0,0 +1,395 @@
+//! Where the containers of an app may connect to, set with `pmk egress`. An app allows
+//! everything, only the campus ranges of `container.campusranges`, or nothing, each with its
+//! own allowlist on top. Apps that don't pick a policy get `container.egress`. Addons,
+//! including the storage of an s3 bucket and the mail relay, services and the other containers on the networks
+//! of the app stay reachable whatever the policy, only connections leaving them are checked
+//!
+//! On docker the policy is a chain of iptables rules for the bridge of the project network,
+//! applied from a container on the host network of the host the app runs on. Service and
+//! private networks are internal, so the project network is the only way out. On kubernetes
+//! it is a NetworkPolicy of the pods of the app, see [`crate::kubernetes`]
+
+use std::fmt;
+use std::str::FromStr;
+use std::time::Duration;
+
+use anyhow::{anyhow, Result};
+use bollard::{
+    container::{
+        Config, CreateContainerOptions, LogOutput, LogsOptions, RemoveContainerOptions,
+        StartContainerOptions, WaitContainerOptions,
+    },
+    network::InspectNetworkOptions,
+    service::HostConfig,
+    Docker,
+};
+use data_encoding::HEXLOWER;
+use futures::{StreamExt, TryStreamExt};
+use serde::{Deserialize, Serialize};
+use sha2::{Digest, Sha256};
+use sqlx::PgPool;
+use uuid::Uuid;
+
+use crate::configuration::ContainerSettings;
+use crate::docker::ensure_network;
+use crate::ip_access::Cidr;
+use crate::orchestrator;
+use crate::registry::pull_release_image;
+use crate::mail::mail_host;
+use crate::storage::BucketUrl;
+
+/// how often the rules of restricted apps are applied again, a rebooted host loses them and
+/// the addresses of allowed hostnames move
+const ENFORCE_INTERVAL: Duration = Duration::from_secs(5 * 60);
+/// in seconds. applying the rules takes a moment, it is stuck past this
+const APPLY_TIMEOUT: u64 = 60;
+
+#[derive(Serialize, Deserialize, Debug, Clone, Copy, PartialEq, Eq)]
+#[serde(rename_all = "lowercase")]
+pub enum Policy {
+    Allow,
+    /// only the campus ranges and the allowlist
+    Campus,
+    /// only the allowlist
+    Deny,
+}
+
+impl Policy {
+    pub fn as_str(&self) -> &'static str {
+        match self {
+            Policy::Allow => "allow",
+            Policy::Campus => "campus",
+            Policy::Deny => "deny",
+        }
+    }
+}
+
+impl fmt::Display for Policy {
+    fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+        f.write_str(self.as_str())
+    }
+}
+
+impl FromStr for Policy {
+    type Err = String;
+
+    fn from_str(value: &str) -> Result<Self, Self::Err> {
+        match value {
+            "allow" => Ok(Policy::Allow),
+            "campus" => Ok(Policy::Campus),
+            "deny" => Ok(Policy::Deny),
+            other => Err(format!("Unknown egress policy {other}, it is allow, campus or deny")),
+        }
+    }
+}
+
+/// Checks the egress settings when the platform starts, an app must never get a policy
+/// nobody meant
+pub fn check_settings(container_settings: &ContainerSettings) -> Result<()> {
+    container_settings.egress.parse::<Policy>().map_err(|err| anyhow!(err))?;
+    for range in &container_settings.campusranges {
+        range.parse::<Cidr>().map_err(|err| anyhow!("container.campusranges: {err}"))?;
+    }
+    Ok(())
+}
+
+/// Checks one entry of an allowlist: a range like `10.0.0.0/8`, an address or a hostname.
+/// Project networks are ipv4 only, so are the ranges
+pub fn check_destination(value: &str) -> Result<(), String> {
+    if value.parse::<Cidr>().is_ok() {
+        return match value.contains(':') {
+            true => Err(format!("{value} is an ipv6 range, apps only connect over ipv4")),
+            false => Ok(()),
+        };
+    }
+
+    let hostname = value.len() <= 253
+        && value.split('.').all(|label| {
+            !label.is_empty()
+                && label.len() <= 63
+                && !label.starts_with('-')
+                && !label.ends_with('-')
+                && label.chars().all(|c| c.is_ascii_alphanumeric() || c == '-')
+        });
+    match hostname && value.contains('.') {
+        true => Ok(()),
+        false => Err(format!("{value} is not an ip address, range or hostname")),
+    }
+}
+
+/// The policy of an app, the default of the platform when it picked none
+pub fn effective_policy(policy: Option<&str>, container_settings: &ContainerSettings) -> Policy {
+    policy
+        .unwrap_or(&container_settings.egress)
+        .parse()
+        // check_settings made sure the default parses
+        .unwrap_or(Policy::Deny)
+}
+
+/// Ranges the containers of an app may connect to, None for anywhere. Hostnames are
+/// resolved now, one that doesn't resolve is left out until the next time
+pub async fn allowed_ranges(
+    policy: Policy,
+    allow: &[String],
+    container_settings: &ContainerSettings,
+) -> Option<Vec<Cidr>> {
+    let mut ranges = match policy {
+        Policy::Allow => return None,
+        Policy::Campus => container_settings
+            .campusranges
+            .iter()
+            .filter_map(|range| range.parse::<Cidr>().ok())
+            .collect::<Vec<_>>(),
+        Policy::Deny => Vec::new(),
+    };
+
+    for destination in allow {
+        if let Ok(range) = destination.parse::<Cidr>() {
+            ranges.push(range);
+            continue;
+        }
+        match tokio::net::lookup_host((destination.as_str(), 0)).await {
+            Ok(addresses) => ranges.extend(
+                addresses
+                    .filter(|address| address.is_ipv4())
+                    .filter_map(|address| address.ip().to_string().parse::<Cidr>().ok()),
+            ),
+            Err(err) => tracing::warn!(?err, destination, "Can't allow egress: Failed to resolve hostname"),
+        }
+    }
+
+    let mut unique = Vec::with_capacity(ranges.len());
+    for range in ranges {
+        if !unique.contains(&range) {
+            unique.push(range);
+        }
+    }
+    Some(unique)
+}
+
+/// Applies the policy of a project to the containers of `container_name`, the app or one of
+/// its previews. A restricted app mustn't run before its rules are there, a deploy fails
+/// when they can't be applied
+#[tracing::instrument(skip(container_settings, pool))]
+pub async fn apply_egress(
+    project_id: Uuid,
+    container_name: &str,
+    container_settings: &ContainerSettings,
+    pool: &PgPool,
+) -> Result<()> {
+    let project = sqlx::query!(
+        "SELECT egress_policy, egress_allow FROM projects WHERE id = $1",
+        project_id
+    )
+    .fetch_one(pool)
+    .await?;
+
+    // the bucket of the app is on the storage and its mail goes to the relay, both outside of
+    // its networks. Like an addon they stay reachable
+    let mut allow = project.egress_allow;
+    let bucket = sqlx::query!("SELECT url FROM addons WHERE project_id = $1 AND kind = 's3'", project_id)
+        .fetch_optional(pool)
+        .await?;
+    if let Some(host) = bucket.and_then(|bucket| BucketUrl::parse(&bucket.url).ok()?.host()) {
+        allow.push(host);
+    }
+    let mail = sqlx::query!("SELECT url FROM addons WHERE project_id = $1 AND kind = 'mail'", project_id)
+        .fetch_optional(pool)
+        .await?;
+    if let Some(host) = mail.and_then(|mail| mail_host(&mail.url)) {
+        allow.push(host);
+    }
+
+    let policy = effective_policy(project.egress_policy.as_deref(), container_settings);
+    let ranges = allowed_ranges(policy, &allow, container_settings).await;
+
+    match orchestrator::driver()
+        .apply_egress(container_name, ranges.as_deref(), container_settings)
+        .await
+    {
+        Ok(()) => Ok(()),
+        // removing rules of an app that never had any isn't worth failing a deploy over,
+        // like on a rootless host that can't have them
+        Err(err) if policy == Policy::Allow => {
+            tracing::warn!(?err, "Can't remove egress rules");
+            Ok(())
+        }
+        Err(err) => Err(err),
+    }
+}
+
+/// Applies the rules of every restricted app again, see [`ENFORCE_INTERVAL`]
+pub async fn egress_enforcer(pool: PgPool, container_settings: ContainerSettings) {
+    let mut interval = tokio::time::interval(ENFORCE_INTERVAL);
+    loop {
+        interval.tick().await;
+
+        let apps = match sqlx::query!(
+            r#"SELECT projects.id, project_owners.name AS owner, projects.name AS project
+               FROM projects
+               JOIN project_owners ON project_owners.id = projects.owner_id
+               WHERE COALESCE(projects.egress_policy, $1) <> 'allow'
+            "#,
+            container_settings.egress
+        )
+        .fetch_all(&pool)
+        .await
+        {
+            Ok(apps) => apps,
+            Err(err) => {
+                tracing::error!(?err, "Can't enforce egress policies: Failed to query database");
+                continue;
+            }
+        };
+
+        for app in apps {
+            let container_name = format!("{}-{}", app.owner, app.project.trim_end_matches(".git")).replace('.', "-");
+            if let Err(err) = apply_egress(app.id, &container_name, &container_settings, &pool).await {
+                tracing::error!(?err, app = %container_name, "Can't enforce egress policy");
+            }
+        }
+    }
+}
+
+/// The iptables chain of an app, chain names are at most 28 characters
+fn chain_name(container_name: &str) -> String {
+    let digest = HEXLOWER.encode(&Sha256::digest(container_name.as_bytes()));
+    format!("PMK-EGRESS-{}", &digest[..16])
+}
+
+/// Interface of the bridge of a docker network, `br-` and the start of its id unless the
+/// network names its own
+async fn bridge_name(docker: &Docker, network_name: &str) -> Result<String> {
+    let network = docker
+        .inspect_network(network_name, None::<InspectNetworkOptions<&str>>)
+        .await?;
+    if let Some(name) = network
+        .options
+        .and_then(|options| options.get("com.docker.network.bridge.name").cloned())
+    {
+        return Ok(name);
+    }
+
+    let id = network.id.unwrap_or_default();
+    match id.get(..12) {
+        Some(id) => Ok(format!("br-{id}")),
+        None => Err(anyhow!("Network {network_name} has no id")),
+    }
+}
+
+/// Shell script putting the rules of an app in place. The chain of the app is replaced at
+/// once with iptables-restore, then the jump to it from DOCKER-USER follows the bridge of the
+/// project network. Without ranges the jump and the chain are removed
+fn rules_script(chain: &str, bridge: &str, ranges: Option<&[Cidr]>) -> String {
+    let stale_jumps = |keep: &str| {
+        format!(
+            "iptables -w -S DOCKER-USER | grep -- '-j {chain}$' {keep}| sed 's/^-A /-D /' | while read -r rule; do iptables -w $rule; done\n"
+        )
+    };
+
+    let Some(ranges) = ranges else {
+        return [
+            "set -e\n".to_string(),
+            stale_jumps(""),
+            format!("iptables -w -F {chain} 2>/dev/null || true\n"),
+            format!("iptables -w -X {chain} 2>/dev/null || true\n"),
+        ]
+        .concat();
+    };
+
+    let mut rules = format!("*filter\n:{chain} - [0:0]\n");
+    // answers to connections coming in, like requests from the proxy
+    rules.push_str(&format!("-A {chain} -m conntrack --ctstate ESTABLISHED,RELATED -j RETURN\n"));
+    for range in ranges {
+        rules.push_str(&format!("-A {chain} -d {range} -j RETURN\n"));
+    }
+    rules.push_str(&format!("-A {chain} -m limit --limit 6/min -j LOG --log-prefix \"{chain} \"\n"));
+    rules.push_str(&format!("-A {chain} -j REJECT\nCOMMIT\n"));
+
+    let jump = format!("DOCKER-USER -i {bridge} ! -o {bridge} -j {chain}");
+    [
+        "set -e\n".to_string(),
+        format!("iptables-restore -w --noflush <<'EOF'\n{rules}EOF\n"),
+        format!("iptables -w -C {jump} 2>/dev/null || iptables -w -I {jump}\n"),
+        // the network got another bridge when it was created again
+        stale_jumps(&format!("| grep -v -- '-i {bridge} ' ")),
+    ]
+    .concat()
+}
+
+/// Puts the rules of an app in place on the docker host it runs on, see [`rules_script`].
+/// Without ranges its containers may connect anywhere. Rootless docker can't change the
+/// firewall of the host, restricted apps can't run on it
+pub async fn apply_rules(
+    docker: &Docker,
+    container_name: &str,
+    ranges: Option<&[Cidr]>,
+    container_settings: &ContainerSettings,
+) -> Result<()> {
+    let network_name = format!("{container_name}-network");
+    let chain = chain_name(container_name);
+    let run_name = format!("{container_name}-egress");
+
+    // the rules need the bridge before the first container of the app is created
+    ensure_network(docker, &network_name, false).await?;
+    let bridge = bridge_name(docker, &network_name).await?;
+    let script = rules_script(&chain, &bridge, ranges);
+
+    pull_release_image(docker, &container_settings.egressimage).await?;
+    let _ = docker
+        .remove_container(&run_name, Some(RemoveContainerOptions { force: true, ..Default::default() }))
+        .await;
+
+    docker
+        .create_container(
+            Some(CreateContainerOptions { name: run_name.as_str(), platform: None }),
+            Config {
+                image: Some(container_settings.egressimage.clone()),
+                entrypoint: Some(vec!["sh".to_string(), "-c".to_string()]),
+                cmd: Some(vec![script]),
+                host_config: Some(HostConfig {
+                    network_mode: Some("host".to_string()),
+                    cap_add: Some(vec!["NET_ADMIN".to_string(), "NET_RAW".to_string()]),
+                    ..Default::default()
+                }),
+                ..Default::default()
+            },
+        )
+        .await?;
+
+    let result = run_rules(docker, &run_name).await;
+    let _ = docker
+        .remove_container(&run_name, Some(RemoveContainerOptions { force: true, ..Default::default() }))
+        .await;
+    result
+}
+
+async fn run_rules(docker: &Docker, run_name: &str) -> Result<()> {
+    docker
+        .start_container(run_name, None::<StartContainerOptions<&str>>)
+        .await?;
+
+    let wait = docker
+        .wait_container(run_name, None::<WaitContainerOptions<&str>>)
+        .try_collect::<Vec<_>>();
+    let exit_code = match tokio::time::timeout(Duration::from_secs(APPLY_TIMEOUT), wait).await {
+        Ok(Ok(_)) => return Ok(()),
+        // bollard reports a non zero exit code as an error
+        Ok(Err(bollard::errors::Error::DockerContainerWaitError { code, .. })) => code,
+        Ok(Err(err)) => return Err(err.into()),
+        Err(_) => return Err(anyhow!("Applying egress rules took longer than {APPLY_TIMEOUT} seconds")),
+    };
+
+    let mut output = String::new();
+    let mut logs = docker.logs(
+        run_name,
+        Some(LogsOptions::<&str> { stdout: true, stderr: true, tail: "20", ..Default::default() }),
+    );
+    while let Some(Ok(log)) = logs.next().await {
+        if let LogOutput::StdOut { message } | LogOutput::StdErr { message } = log {
+            output.push_str(&String::from_utf8_lossy(&message));
+        }
+    }
+
+    Err(anyhow!("Failed to apply egress rules, iptables exited with {exit_code}: {}", output.trim()))
+}
@@ -0,0 +1,60 @@
+use std::collections::BTreeMap;
+
+use anyhow::Result;
+use serde_json::Value;
+use sqlx::PgPool;
+use uuid::Uuid;
+
+/// The variables and encrypted secrets an app gets from its env groups. Groups attached later
+/// win over earlier ones, and a name is either a variable or a secret
+pub async fn group_environment(
+    project_id: Uuid,
+    pool: &PgPool,
+) -> Result<(BTreeMap<String, String>, BTreeMap<String, String>)> {
+    let groups = sqlx::query!(
+        r#"SELECT env_groups.environs, env_groups.secrets
+           FROM project_env_groups
+           JOIN env_groups ON env_groups.id = project_env_groups.group_id
+           WHERE project_env_groups.project_id = $1
+           ORDER BY project_env_groups.created_at
+        "#,
+        project_id
+    )
+    .fetch_all(pool)
+    .await?;
+
+    let mut env = BTreeMap::new();
+    let mut secrets = BTreeMap::new();
+    for group in groups {
+        for (key, value) in strings(group.environs) {
+            secrets.remove(&key);
+            env.insert(key, value);
+        }
+        for (key, value) in strings(group.secrets) {
+            env.remove(&key);
+            secrets.insert(key, value);
+        }
+    }
+
+    Ok((env, secrets))
+}
+
+fn strings(value: Value) -> BTreeMap<String, String> {
+    serde_json::from_value(value).unwrap_or_default()
+}
+
+/// The group of the owner named `name`
+pub async fn find_group(owner: &str, name: &str, pool: &PgPool) -> Result<Option<Uuid>, sqlx::Error> {
+    let group = sqlx::query!(
+        r#"SELECT env_groups.id FROM env_groups
+           JOIN project_owners ON project_owners.id = env_groups.owner_id
+           WHERE project_owners.name = $1 AND env_groups.name = $2
+        "#,
+        owner,
+        name
+    )
+    .fetch_optional(pool)
+    .await?;
+
+    Ok(group.map(|group| group.id))
+}
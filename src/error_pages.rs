@@ -0,0 +1,113 @@
+use hyper::header::{HeaderMap, CONTENT_TYPE};
+use hyper::{Body, Response, StatusCode};
+
+/// What visitors of an app get when the proxy can't reach it, set with `pmk error-page`.
+/// Errors returned by the app itself are passed on untouched
+#[derive(Debug, Clone, Default)]
+pub struct ErrorPage {
+    /// html of the owners, `{{status}}` and `{{correlation_id}}` (or `{{request_id}}`, the
+    /// same) in it are filled in
+    pub html: Option<String>,
+    /// sent there instead, with the status and correlation id as query parameters
+    pub redirect: Option<String>,
+}
+
+impl ErrorPage {
+    /// The response for `status`, a 502, 503 or 504 of the proxy. The request id is the
+    /// correlation id visitors are shown, it is logged with the cause so the platform admins
+    /// can find what went wrong for them
+    pub fn render(&self, status: StatusCode, app: &str, request_id: &str, cause: &str) -> Response<Body> {
+        tracing::error!(app, request_id, status = status.as_u16(), cause, "Can't reach app");
+
+        if let Some(redirect) = &self.redirect {
+            let separator = match redirect.contains('?') {
+                true => '&',
+                false => '?',
+            };
+            return Response::builder()
+                .status(StatusCode::FOUND)
+                .header("Location", format!("{redirect}{separator}status={}&correlation_id={request_id}", status.as_u16()))
+                .header("Cache-Control", "no-store")
+                .body(Body::empty())
+                .unwrap();
+        }
+
+        let html = match &self.html {
+            Some(html) => html
+                .replace("{{status}}", status.as_str())
+                .replace("{{correlation_id}}", request_id)
+                .replace("{{request_id}}", request_id),
+            None => default_page(status, request_id),
+        };
+
+        let mut res = Response::builder()
+            .status(status)
+            .header("Content-Type", "text/html; charset=utf-8")
+            .header("Cache-Control", "no-store");
+        if status == StatusCode::SERVICE_UNAVAILABLE {
+            res = res.header("Retry-After", "30");
+        }
+        res.body(Body::from(html)).unwrap()
+    }
+}
+
+/// Whether the request is a grpc call, those clients can't show an html page
+pub fn is_grpc(headers: &HeaderMap) -> bool {
+    headers
+        .get(CONTENT_TYPE)
+        .and_then(|value| value.to_str().ok())
+        .is_some_and(|value| value.starts_with("application/grpc"))
+}
+
+/// What a grpc client gets instead of an error page, a response without a body carrying
+/// UNAVAILABLE in grpc-status like a grpc server answers when it is down. The request id is
+/// in grpc-message and logged with the cause like for [`ErrorPage::render`]
+pub fn grpc_unavailable(status: StatusCode, app: &str, request_id: &str, cause: &str) -> Response<Body> {
+    tracing::error!(app, request_id, status = status.as_u16(), cause, "Can't reach app");
+
+    let reason = match status {
+        StatusCode::SERVICE_UNAVAILABLE => "app is starting",
+        StatusCode::GATEWAY_TIMEOUT => "app didn't answer in time",
+        _ => "app can't be reached",
+    };
+    Response::builder()
+        .status(StatusCode::OK)
+        .header("Content-Type", "application/grpc")
+        // 14 is UNAVAILABLE, clients may retry it
+        .header("grpc-status", "14")
+        .header("grpc-message", format!("{reason}, request id {request_id}"))
+        .body(Body::empty())
+        .unwrap()
+}
+
+fn default_page(status: StatusCode, request_id: &str) -> String {
+    let (title, explanation) = match status {
+        StatusCode::SERVICE_UNAVAILABLE => (
+            "This app is starting",
+            "It didn't come up in time. Reload the page in a moment.",
+        ),
+        StatusCode::GATEWAY_TIMEOUT => (
+            "This app is taking too long",
+            "It didn't answer in time. If it is yours, <code>pmk logs</code> shows what it was busy with.",
+        ),
+        _ => (
+            "This app can't be reached",
+            "It isn't running or didn't answer. If it is yours, <code>pmk ps</code> and <code>pmk logs</code> show why.",
+        ),
+    };
+
+    format!(
+        r#"<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>{title}</title></head>
+<body style="font-family: sans-serif; max-width: 40em; margin: 4em auto; padding: 0 1em; color: #1f2937">
+<p style="color: #6b7280">pemasak · {status}</p>
+<h1>{title}</h1>
+<p>{explanation}</p>
+<p style="color: #6b7280; font-size: 0.9em">Asking the platform admins about it? Tell them the request id <code>{request_id}</code>.</p>
+</body>
+</html>
+"#,
+        status = status.as_u16(),
+    )
+}
@@ -2,6 +2,7 @@ use std::{
     ffi::OsStr,
     fs::File,
     io::Read,
+    net::SocketAddr,
     path::Path as StdPath,
     process::{Output, Stdio},
 };
@@ -11,14 +12,14 @@ use argon2::{
     Argon2,
 };
 use axum::{
-    extract::{DefaultBodyLimit, Path, Query, State},
+    extract::{ConnectInfo, DefaultBodyLimit, Path, Query, State},
     middleware::{self, Next},
     response::Response,
     routing::{get, post},
     Router,
 };
 use axum_extra::routing::RouterExt;
-use git2::Repository;
+use git2::{BranchType, Repository};
 use http_body::combinators::UnsyncBoxBody;
 use hyper::{
     body::Bytes, http::response::Builder as ResponseBuilder, Body, HeaderMap, Request, StatusCode,
@@ -26,24 +27,77 @@ use hyper::{
 
 use anyhow::Result;
 use serde::Deserialize;
-use tokio::{io::AsyncWriteExt, process::Command};
+use sqlx::PgPool;
+use tokio::{io::AsyncWriteExt, process::Command, sync::mpsc::Sender};
 use tower_http::limit::RequestBodyLimitLayer;
-
-use crate::{configuration::Settings, queue::BuildQueueItem, startup::AppState};
+use tracing::Instrument;
+use uuid::Uuid;
+
+use crate::{
+    audit::{client_ip, record, NewAuditEntry},
+    auth::tokens::{git_access, TOKEN_PREFIX},
+    configuration::Settings,
+    deploy_branch::{deploy_branch, DeployBranch},
+    lfs::{self, check_lfs_token, LfsAccess},
+    monorepo::push_needs_build,
+    owner::suspension,
+    previews::push_previews,
+    push_policy::{check, push_policy, read_push, rejection},
+    queue::{BuildKind, BuildQueueItem},
+    startup::AppState,
+    telemetry::current_context,
+};
 
 use data_encoding::BASE64;
 
+/// Pushes deploy, so they go in the audit log like deploys through the api. Pushes with the
+/// git token of an app have no user, the owner pushed them
+async fn audit_push(
+    actor_id: Option<Uuid>,
+    token_id: Option<Uuid>,
+    owner: &str,
+    repo: &str,
+    path: String,
+    ip: String,
+    status: StatusCode,
+    pool: &PgPool,
+) {
+    let entry = NewAuditEntry {
+        actor_id,
+        actor: format!("git:{owner}"),
+        token_id,
+        owner: Some(owner.to_string()),
+        project: Some(repo.to_string()),
+        action: "git.push".to_string(),
+        method: "POST".to_string(),
+        path,
+        status: status.as_u16() as i32,
+        ip: Some(ip),
+        change: None,
+    };
+    if let Err(err) = record(entry, pool).await {
+        tracing::error!(?err, "Can't record audit entry: Failed to insert into database");
+    }
+}
+
 async fn basic_auth<B>(
     State(AppState { pool, git_auth, .. }): State<AppState>,
-    Path((_owner, repo)): Path<(String, String)>,
+    ConnectInfo(addr): ConnectInfo<SocketAddr>,
+    Path((owner, repo)): Path<(String, String)>,
     headers: HeaderMap,
-    request: Request<B>,
+    mut request: Request<B>,
     next: Next<B>,
 ) -> Result<Response<UnsyncBoxBody<Bytes, axum::Error>>, hyper::Response<Body>> {
     if !git_auth {
         return Ok(next.run(request).await);
     }
 
+    // the refs are only advertised before, the push itself is this request
+    let pushed = request.uri().path().ends_with("/git-receive-pack");
+    // the lfs api says what the credentials may do, whether it is an upload is in the body
+    let lfs = request.uri().path().contains("/info/lfs/");
+    let ip = client_ip(&addr, &headers);
+
     let auth_err = Response::builder()
         .status(StatusCode::UNAUTHORIZED)
         .header("WWW-Authenticate", "Basic realm=\"git\"")
@@ -70,6 +124,17 @@ async fn basic_auth<B>(
             let scheme = parts.next().unwrap_or("");
             let token = parts.next().unwrap_or("");
 
+            // what git-lfs-authenticate handed out over ssh
+            if scheme == "Bearer" && lfs {
+                return match check_lfs_token(token, &owner, &repo) {
+                    Some(access) => {
+                        request.extensions_mut().insert(access);
+                        Ok(next.run(request).await)
+                    }
+                    None => Err(auth_failed),
+                };
+            }
+
             if scheme != "Basic" {
                 return Err(auth_err);
             }
@@ -80,6 +145,49 @@ async fn basic_auth<B>(
             let owner_name = parts.next().unwrap_or("");
             let token = parts.next().unwrap_or("");
 
+            // access tokens work with any username, ci pipelines push with them
+            if token.starts_with(TOKEN_PREFIX) {
+                let push = request.uri().path().ends_with("/git-receive-pack")
+                    || request.uri().query().is_some_and(|query| query.contains("service=git-receive-pack"));
+
+                if lfs {
+                    let access = match git_access(token, &owner, &repo, true, &pool).await {
+                        Ok(Some(_)) => Ok(Some(true)),
+                        Ok(None) => git_access(token, &owner, &repo, false, &pool)
+                            .await
+                            .map(|access| access.map(|_| false)),
+                        Err(err) => Err(err),
+                    };
+                    return match access {
+                        Ok(Some(write)) => {
+                            request.extensions_mut().insert(LfsAccess { write });
+                            Ok(next.run(request).await)
+                        }
+                        Ok(None) => Err(auth_failed),
+                        Err(err) => {
+                            tracing::error!(?err, "Can't authenticate git token: Failed to query database");
+                            Err(auth_err)
+                        }
+                    };
+                }
+
+                return match git_access(token, &owner, &repo, push, &pool).await {
+                    Ok(Some((user_id, access))) if pushed => {
+                        let path = request.uri().path().to_string();
+                        let response = next.run(request).await;
+                        let status = response.status();
+                        audit_push(Some(user_id), Some(access.token_id), &owner, &repo, path, ip, status, &pool).await;
+                        Ok(response)
+                    }
+                    Ok(Some(_)) => Ok(next.run(request).await),
+                    Ok(None) => Err(auth_failed),
+                    Err(err) => {
+                        tracing::error!(?err, "Can't authenticate git token: Failed to query database");
+                        Err(auth_err)
+                    }
+                };
+            }
+
             let tokens = match sqlx::query!(
                 r#"SELECT projects.name AS project_name, api_token.token AS token, project_owners.name AS project_owner
                     FROM project_owners
@@ -112,7 +220,17 @@ async fn basic_auth<B>(
                 return Err(auth_failed);
             }
 
-            Ok(next.run(request).await)
+            if lfs {
+                request.extensions_mut().insert(LfsAccess { write: true });
+            }
+            if !pushed {
+                return Ok(next.run(request).await);
+            }
+
+            let path = request.uri().path().to_string();
+            let response = next.run(request).await;
+            audit_push(None, None, &owner, &repo, path, ip, response.status(), &pool).await;
+            Ok(response)
         }
     }
 }
@@ -164,6 +282,8 @@ pub fn router(state: AppState, config: &Settings) -> Router<AppState, Body> {
             "/:owner/:repo/objects/packs/:file",
             get(get_pack_or_idx_file),
         )
+        .route_with_tsr("/:owner/:repo/info/lfs/objects/batch", post(lfs::batch))
+        .route_with_tsr("/:owner/:repo/info/lfs/objects/verify", post(lfs::verify))
         .route_layer(middleware::from_fn_with_state(state, basic_auth))
         // not git server related
         .layer(DefaultBodyLimit::disable())
@@ -171,7 +291,7 @@ pub fn router(state: AppState, config: &Settings) -> Router<AppState, Body> {
     // .with_state(state)
 }
 
-async fn git_command<P, IA, S, IE, K, V>(dir: P, args: IA, envs: IE) -> Result<Output>
+pub(crate) async fn git_command<P, IA, S, IE, K, V>(dir: P, args: IA, envs: IE) -> Result<Output>
 where
     P: AsRef<StdPath>,
     IA: IntoIterator<Item = S>,
@@ -386,18 +506,83 @@ fn normal_merge(
         &result_tree,
         &[&local_commit, &remote_commit],
     )?;
-    // Set working tree to match head.
-    repo.checkout_head(None)?;
+    // Set working tree to match head. Files git lfs swapped in for their pointers look
+    // modified, the checkout only has what the platform put there so it's forced
+    repo.checkout_head(Some(git2::build::CheckoutBuilder::default().force()))?;
+    Ok(())
+}
+
+/// The branch the app deploys from, the one HEAD of the repository points at. Until that
+/// branch is pushed HEAD is pointed at the first branch there is, so an app pushing main
+/// instead of master deploys main
+fn default_branch(path: &str) -> Option<String> {
+    let repo = Repository::open_bare(path).ok()?;
+    let head = repo.find_reference("HEAD").ok()?;
+    let target = head.symbolic_target()?.to_string();
+    if repo.find_reference(&target).is_ok() {
+        return target.strip_prefix("refs/heads/").map(str::to_string);
+    }
+
+    let mut branches = repo.branches(Some(BranchType::Local)).ok()?.filter_map(|branch| branch.ok());
+    let branch = branches.find_map(|(branch, _)| branch.name().ok().flatten().map(str::to_string))?;
+    repo.set_head(&format!("refs/heads/{branch}")).ok()?;
+    Some(branch)
+}
+
+/// The branch pushes deploy from: the one the app set, or [`default_branch`]. HEAD is
+/// pointed at a set branch once it was pushed, clones get that branch then
+fn deploys_from(path: &str, configured: Option<&str>) -> Option<String> {
+    let Some(branch) = configured else {
+        return default_branch(path);
+    };
+    if let Ok(repo) = Repository::open_bare(path) {
+        let refname = format!("refs/heads/{branch}");
+        let head = repo.find_reference("HEAD").ok().and_then(|head| head.symbolic_target().map(str::to_string));
+        if head.as_deref() != Some(refname.as_str()) && repo.find_reference(&refname).is_ok() {
+            if let Err(err) = repo.set_head(&refname) {
+                tracing::error!(?err, "Can't point HEAD at the deploy branch");
+            }
+        }
+    }
+    Some(branch.to_string())
+}
+
+/// Makes `path` a bare repository with every branch and tag of the one at `source`, HEAD on
+/// the same branch. A source that was never pushed makes an empty repository
+pub fn fork_repository(source: &str, path: &str) -> Result<(), git2::Error> {
+    let origin = Repository::open_bare(source)?;
+    let repo = Repository::init_bare(path)?;
+    repo.remote_anonymous(source)?
+        .fetch(&["+refs/heads/*:refs/heads/*", "+refs/tags/*:refs/tags/*"], None, None)?;
+
+    if let Some(head) = origin.find_reference("HEAD")?.symbolic_target() {
+        repo.set_head(head)?;
+    }
     Ok(())
 }
 
 pub async fn receive_pack_rpc(
     Path((owner, repo)): Path<(String, String)>,
-    State(AppState {
+    State(state): State<AppState>,
+    headers: HeaderMap,
+    body: Bytes,
+) -> Response<Body> {
+    receive_pack(owner, repo, state, headers, body).await
+}
+
+/// Takes a push, over http or from [`crate::ssh`]: checks it against the push policy, hands
+/// it to git and deploys what it pushed. `body` is the whole request of the client, the
+/// commands and the pack
+pub async fn receive_pack(
+    owner: String,
+    repo: String,
+    AppState {
         base,
         build_channel,
+        pool,
+        git_settings,
         ..
-    }): State<AppState>,
+    }: AppState,
     headers: HeaderMap,
     body: Bytes,
 ) -> Response<Body> {
@@ -405,8 +590,82 @@ pub async fn receive_pack_rpc(
         true => format!("{base}/{owner}/{repo}"),
         false => format!("{base}/{owner}/{repo}.git"),
     };
-    let head_dir = format!("{path}/refs/heads");
 
+    // the push would only queue a build that never runs
+    match suspension(&owner, &repo, &pool).await {
+        Ok(Some(reason)) => {
+            return Response::builder()
+                .status(StatusCode::FORBIDDEN)
+                .body(Body::from(format!("{owner}/{repo} is suspended: {reason}")))
+                .unwrap();
+        }
+        Ok(None) => {}
+        Err(err) => {
+            tracing::error!(?err, "Can't receive push: Failed to query database");
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::empty())
+                .unwrap();
+        }
+    }
+
+    let deploy = match deploy_branch(&owner, &repo, &pool).await {
+        Ok(deploy) => deploy,
+        Err(err) => {
+            tracing::error!(?err, "Can't receive push: Failed to query database");
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::empty())
+                .unwrap();
+        }
+    };
+
+    // the pre-receive stage, refused pushes never reach git
+    let push = read_push(&headers, &body);
+    let policy = match push_policy(&owner, &repo, &git_settings, &pool).await {
+        Ok(policy) => policy,
+        Err(err) => {
+            tracing::error!(?err, "Can't receive push: Failed to query database");
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::empty())
+                .unwrap();
+        }
+    };
+    if let Err(refused) = check(&policy, &path, deploys_from(&path, deploy.branch.as_deref()).as_deref(), &push).await {
+        tracing::info!(owner, repo, reason = refused.reason, "Push refused by the push policy");
+        return rejection(&push, &refused);
+    }
+
+    // the pre-receive stage is dropped when the client hangs up, from here on the push runs
+    // to its end on its own task
+    let update = update_refs(owner, repo, path, deploy, push.refs(), headers, body, pool, build_channel);
+    match tokio::spawn(update.instrument(tracing::Span::current())).await {
+        Ok(res) => res,
+        Err(err) => {
+            tracing::error!(?err, "Can't receive push: Failed to update refs");
+            Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::empty())
+                .unwrap()
+        }
+    }
+}
+
+/// The post-receive stage of a push: hands it to git and deploys what it updated. Git isn't
+/// stopped halfway, so refs it updated always get their build
+#[allow(clippy::too_many_arguments)]
+async fn update_refs(
+    owner: String,
+    repo: String,
+    path: String,
+    deploy: DeployBranch,
+    pushed: Vec<(String, String)>,
+    headers: HeaderMap,
+    body: Bytes,
+    pool: PgPool,
+    build_channel: Sender<BuildQueueItem>,
+) -> Response<Body> {
     let res = service_rpc("receive-pack", &path, headers, body).await;
     if res.status() != StatusCode::OK {
         return res;
@@ -424,18 +683,7 @@ pub async fn receive_pack_rpc(
     let container_src = format!("{path}/master");
     let container_name = format!("{owner}-{}", repo.trim_end_matches(".git")).replace('.', "-");
 
-    // get first file in branch folder
-    let branch = match std::fs::read_dir(&head_dir) {
-        Ok(mut dir) => dir.find_map(|entry| {
-            entry.ok().and_then(|e| {
-                e.file_name().into_string().ok()
-                // .and_then(|s| s.strip_suffix(".lock").map(|s| s.to_string()))
-            })
-        }),
-        Err(_) => None,
-    };
-
-    let branch = match branch {
+    let branch = match deploys_from(&path, deploy.branch.as_deref()) {
         Some(branch) => branch,
         None => {
             tracing::error!("no branch found");
@@ -447,6 +695,33 @@ pub async fn receive_pack_rpc(
     };
     tracing::info!(branch, "git branch name");
 
+    // the other branches deploy previews, see crate::previews. A push without commands we
+    // could read deploys the app like it always did
+    let previews = pushed
+        .iter()
+        .filter_map(|(new, name)| Some((new.clone(), name.strip_prefix("refs/heads/")?.to_string())))
+        .filter(|(_, pushed)| *pushed != branch)
+        .collect::<Vec<_>>();
+    if !previews.is_empty() {
+        push_previews(&owner, &repo, &path, &container_name, previews, &pool, &build_channel).await;
+    }
+    let default_ref = format!("refs/heads/{branch}");
+    if !pushed.is_empty() && !pushed.iter().any(|(_, name)| *name == default_ref) {
+        return res;
+    }
+
+    // the checkout of the branch the app deployed from before is made again, the clone
+    // checks out the one HEAD points at now
+    let checked_out = git2::Repository::open(&container_src)
+        .ok()
+        .and_then(|checkout| checkout.head().ok()?.shorthand().map(str::to_string));
+    if checked_out.is_some_and(|checked_out| checked_out != branch) {
+        tracing::info!(branch, "Deploy branch changed, checking it out again");
+        if let Err(err) = std::fs::remove_dir_all(&container_src) {
+            tracing::error!(?err, "Can't check out deploy branch: Failed to remove checkout");
+        }
+    }
+
     // TODO: clean up this mess
     if let Err(_e) = git2::Repository::clone(&path, &container_src) {
         tracing::info!("repo already cloned");
@@ -515,6 +790,12 @@ pub async fn receive_pack_rpc(
         };
     };
 
+    if !push_needs_build(&owner, &repo, &container_src, &pool).await {
+        return res;
+    }
+
+    // the build belongs to the trace of the push
+    let trace = current_context();
     tokio::spawn(async move {
         build_channel
             .send(BuildQueueItem {
@@ -522,6 +803,8 @@ pub async fn receive_pack_rpc(
                 container_src,
                 owner,
                 repo,
+                kind: deploy.build_kind(),
+                trace,
             })
             .await
     });
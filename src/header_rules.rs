@@ -0,0 +1,144 @@
+use std::collections::BTreeMap;
+
+use hyper::header::{HeaderMap, HeaderName, HeaderValue};
+use serde::{Deserialize, Serialize};
+
+/// rules of one kind in one direction
+const MAX_RULES: usize = 50;
+
+/// headers the proxy needs to get the request to the app and the response back, rules can't
+/// touch them
+const PROTECTED: [&str; 13] = [
+    "host",
+    "connection",
+    "content-length",
+    "transfer-encoding",
+    "te",
+    "trailer",
+    "upgrade",
+    "keep-alive",
+    "proxy-connection",
+    "x-request-id",
+    "x-cache",
+    "x-pemasak-proxy-timestamp",
+    "x-pemasak-proxy-signature",
+];
+
+/// Headers the proxy adds, removes or replaces on the way to the app and back, set with `pmk
+/// headers` or `[headers]` in pemasak.toml
+#[derive(Serialize, Deserialize, Debug, Clone, Default, PartialEq)]
+#[serde(deny_unknown_fields)]
+pub struct HeaderRules {
+    #[serde(default)]
+    pub request: HeaderActions,
+    #[serde(default)]
+    pub response: HeaderActions,
+}
+
+/// Removed first, then set, then added. Values may have `{client_ip}`, `{request_id}`,
+/// `{host}` and `{scheme}` in them
+#[derive(Serialize, Deserialize, Debug, Clone, Default, PartialEq)]
+#[serde(deny_unknown_fields)]
+pub struct HeaderActions {
+    /// replace the header, or add it when it is missing
+    #[serde(default)]
+    pub set: BTreeMap<String, String>,
+    /// add the header next to the ones already there
+    #[serde(default)]
+    pub add: BTreeMap<String, String>,
+    #[serde(default)]
+    pub remove: Vec<String>,
+}
+
+/// What the placeholders of a value stand for
+pub struct Vars<'a> {
+    pub client_ip: &'a str,
+    pub request_id: &'a str,
+    pub host: &'a str,
+    /// empty when the proxy can't tell
+    pub scheme: &'a str,
+}
+
+/// Tells the app who asked and how, replacing what the client sent itself. Only what the
+/// proxy in front says is trusted, see [`crate::audit::client_ip`]
+pub fn set_forwarded(headers: &mut HeaderMap, vars: &Vars) {
+    let forwarded = [
+        ("x-forwarded-for", vars.client_ip),
+        ("x-forwarded-proto", vars.scheme),
+        ("x-forwarded-host", vars.host),
+    ];
+    for (name, value) in forwarded {
+        match HeaderValue::from_str(value).ok().filter(|_| !value.is_empty()) {
+            Some(value) => headers.insert(name, value),
+            None => headers.remove(name),
+        };
+    }
+}
+
+impl HeaderRules {
+    pub fn is_empty(&self) -> bool {
+        self.request.is_empty() && self.response.is_empty()
+    }
+
+    /// Checks the rules before they are stored, the proxy applies them as they are
+    pub fn validate(&self) -> Result<(), String> {
+        self.request.validate("request")?;
+        self.response.validate("response")
+    }
+}
+
+impl HeaderActions {
+    pub fn is_empty(&self) -> bool {
+        self.set.is_empty() && self.add.is_empty() && self.remove.is_empty()
+    }
+
+    pub fn apply(&self, headers: &mut HeaderMap, vars: &Vars) {
+        for name in &self.remove {
+            if let Ok(name) = HeaderName::from_bytes(name.as_bytes()) {
+                headers.remove(name);
+            }
+        }
+        for (name, value) in &self.set {
+            if let Some((name, value)) = header(name, value, vars) {
+                headers.insert(name, value);
+            }
+        }
+        for (name, value) in &self.add {
+            if let Some((name, value)) = header(name, value, vars) {
+                headers.append(name, value);
+            }
+        }
+    }
+
+    fn validate(&self, direction: &str) -> Result<(), String> {
+        if self.set.len() > MAX_RULES || self.add.len() > MAX_RULES || self.remove.len() > MAX_RULES {
+            return Err(format!("At most {MAX_RULES} {direction} headers can be set, added or removed"));
+        }
+        let names = self.set.keys().chain(self.add.keys()).chain(self.remove.iter());
+        for name in names {
+            if HeaderName::from_bytes(name.as_bytes()).is_err() {
+                return Err(format!("{name} is not a header name"));
+            }
+            if PROTECTED.contains(&name.to_ascii_lowercase().as_str()) {
+                return Err(format!("{name} is managed by the platform, it can't be changed"));
+            }
+        }
+        for (name, value) in self.set.iter().chain(self.add.iter()) {
+            if HeaderValue::from_str(value).is_err() {
+                return Err(format!("The value of {name} can only have visible ascii characters"));
+            }
+        }
+        Ok(())
+    }
+}
+
+fn header(name: &str, value: &str, vars: &Vars) -> Option<(HeaderName, HeaderValue)> {
+    let value = value
+        .replace("{client_ip}", vars.client_ip)
+        .replace("{request_id}", vars.request_id)
+        .replace("{host}", vars.host)
+        .replace("{scheme}", vars.scheme);
+    let name = HeaderName::from_bytes(name.as_bytes()).ok()?;
+    let value = HeaderValue::from_str(&value).ok()?;
+    Some((name, value))
+}
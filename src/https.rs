@@ -0,0 +1,79 @@
+use std::net::SocketAddr;
+
+use hyper::header::{HeaderMap, HeaderValue, HOST, LOCATION};
+use hyper::{Body, Response, StatusCode};
+
+/// max-age the hsts preload list asks for, one year
+pub const PRELOAD_MAX_AGE: i32 = 31536000;
+
+/// How an app wants browsers to reach it, set with `pmk https`
+#[derive(Debug, Clone, Default)]
+pub struct HttpsPolicy {
+    /// plain http requests are redirected to https
+    pub redirect: bool,
+    /// seconds browsers only use https for the app after an https response, None sends no
+    /// Strict-Transport-Security
+    pub hsts_max_age: Option<i32>,
+    /// asks browsers to ship the domain as https only, which also covers its subdomains
+    pub hsts_preload: bool,
+}
+
+impl HttpsPolicy {
+    /// The Strict-Transport-Security header for a response to a request that came over `https`.
+    /// Browsers ignore the header over plain http, it is only sent when it counts
+    pub fn hsts(&self, https: Option<bool>) -> Option<HeaderValue> {
+        let max_age = self.hsts_max_age.filter(|_| https == Some(true))?;
+        let value = match self.hsts_preload {
+            true => format!("max-age={max_age}; includeSubDomains; preload"),
+            false => format!("max-age={max_age}"),
+        };
+        HeaderValue::from_str(&value).ok()
+    }
+}
+
+/// Whether the client reached the platform over https, as the proxy terminating tls in front
+/// says in X-Forwarded-Proto. None for requests that didn't come through it, they can't be
+/// told apart
+pub fn forwarded_https(addr: &SocketAddr, headers: &HeaderMap) -> Option<bool> {
+    if !addr.ip().is_loopback() {
+        return None;
+    }
+    let proto = headers
+        .get("X-Forwarded-Proto")
+        .and_then(|value| value.to_str().ok())
+        .and_then(|value| value.split(',').next())
+        .map(|value| value.trim().to_ascii_lowercase())?;
+    match proto.as_str() {
+        "https" => Some(true),
+        "http" => Some(false),
+        _ => None,
+    }
+}
+
+/// Sends the client to the same url over https. 308 keeps the method and the body, so forms
+/// posted over http still arrive
+pub fn redirect(headers: &HeaderMap, uri: &axum::http::Uri) -> Response<Body> {
+    let host = headers
+        .get(HOST)
+        .and_then(|host| host.to_str().ok())
+        .or_else(|| uri.authority().map(|authority| authority.as_str()))
+        .unwrap_or("");
+    // the port of plain http doesn't serve https
+    let host = match host.rsplit_once(':') {
+        Some((name, port)) if port.chars().all(|c| c.is_ascii_digit()) => name,
+        _ => host,
+    };
+    let path = uri.path_and_query().map(|path| path.as_str()).unwrap_or("/");
+
+    match HeaderValue::from_str(&format!("https://{host}{path}")) {
+        Ok(location) => Response::builder()
+            .status(StatusCode::PERMANENT_REDIRECT)
+            .header(LOCATION, location)
+            .body(Body::empty())
+            .unwrap(),
+        Err(_) => Response::builder()
+            .status(StatusCode::BAD_REQUEST)
+            .body(Body::empty())
+            .unwrap(),
+    }
+}
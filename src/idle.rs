@@ -0,0 +1,160 @@
+use std::collections::HashMap;
+use std::sync::{Arc, Mutex, RwLock};
+use std::time::Duration;
+
+use anyhow::Result;
+use chrono::{DateTime, Utc};
+use sqlx::PgPool;
+
+use crate::activity::record_activity;
+use crate::configuration::ContainerSettings;
+use crate::orchestrator;
+
+/// how often apps are checked for traffic
+const CHECK_INTERVAL: Duration = Duration::from_secs(30);
+
+/// Remembers when the proxy last forwarded a request to each app and serializes stopping and
+/// waking an app, so requests arriving while it starts wait for the one wake in progress
+#[derive(Debug, Clone)]
+pub struct IdleTracker {
+    last_seen: Arc<RwLock<HashMap<String, DateTime<Utc>>>>,
+    locks: Arc<Mutex<HashMap<String, Arc<tokio::sync::Mutex<()>>>>>,
+    /// apps get a full timeout after the platform starts, requests from before are unknown
+    started_at: DateTime<Utc>,
+}
+
+impl IdleTracker {
+    pub fn new() -> Self {
+        Self {
+            last_seen: Arc::default(),
+            locks: Arc::default(),
+            started_at: Utc::now(),
+        }
+    }
+
+    /// Records a request to `app`
+    pub fn touch(&self, app: &str) {
+        self.last_seen
+            .write()
+            .unwrap()
+            .insert(app.to_string(), Utc::now());
+    }
+
+    /// Every app the proxy forwarded a request to since the platform started, with the last one
+    pub fn seen(&self) -> Vec<(String, DateTime<Utc>)> {
+        self.last_seen
+            .read()
+            .unwrap()
+            .iter()
+            .map(|(app, seen)| (app.clone(), *seen))
+            .collect()
+    }
+
+    fn last_seen(&self, app: &str) -> DateTime<Utc> {
+        let last_seen = self.last_seen.read().unwrap().get(app).copied();
+        last_seen.unwrap_or(self.started_at).max(self.started_at)
+    }
+
+    fn lock(&self, app: &str) -> Arc<tokio::sync::Mutex<()>> {
+        self.locks
+            .lock()
+            .unwrap()
+            .entry(app.to_string())
+            .or_default()
+            .clone()
+    }
+
+    /// Starts the stopped app container of `app` and returns its address once it is ready. Requests
+    /// that come in meanwhile queue behind the same wake instead of starting it again
+    pub async fn wake(
+        &self,
+        app: &str,
+        container: &str,
+        port: i32,
+        healthcheck_path: Option<&str>,
+        h2c: bool,
+        timeout: u64,
+    ) -> Result<String> {
+        let lock = self.lock(app);
+        let _guard = lock.lock().await;
+
+        // an earlier request may have woken it while this one waited
+        let driver = orchestrator::driver();
+        if let Some(address) = driver.address(app, container).await? {
+            return Ok(address);
+        }
+
+        tracing::info!(app, "Waking idle app");
+        let address = driver
+            .start_web(app, container, port, healthcheck_path, h2c, timeout)
+            .await?;
+        self.touch(app);
+
+        Ok(address)
+    }
+}
+
+/// Stops the web containers of apps with an idle timeout once the proxy hasn't seen a request
+/// for them in that long. The proxy starts them again on the next request
+pub async fn idler(pool: PgPool, idle: IdleTracker, container_settings: ContainerSettings) {
+    let mut interval = tokio::time::interval(CHECK_INTERVAL);
+    loop {
+        interval.tick().await;
+
+        let apps = match sqlx::query!(
+            r#"SELECT domains.name, domains.container_id, domains.project_id,
+               projects.idle_timeout AS "idle_timeout!"
+               FROM domains
+               JOIN projects ON projects.id = domains.project_id
+               WHERE projects.idle_timeout IS NOT NULL
+            "#
+        )
+        .fetch_all(&pool)
+        .await
+        {
+            Ok(apps) => apps,
+            Err(err) => {
+                tracing::error!(?err, "Can't idle apps: Failed to query database");
+                continue;
+            }
+        };
+
+        for app in apps {
+            let container = app.container_id.unwrap_or_else(|| app.name.clone());
+            let timeout = chrono::Duration::minutes(app.idle_timeout as i64);
+
+            let lock = idle.lock(&app.name);
+            let _guard = lock.lock().await;
+
+            // a deploy or restart counts as activity, the app gets a full timeout after it
+            let started_at = match orchestrator::driver().running_since(&app.name, &container).await {
+                Ok(Some(started_at)) => started_at,
+                Ok(None) => continue,
+                Err(err) => {
+                    tracing::debug!(?err, app = %app.name, "Can't idle app: Failed to inspect container");
+                    continue;
+                }
+            };
+            let last_active = idle.last_seen(&app.name).max(started_at);
+
+            if Utc::now() - last_active < timeout {
+                continue;
+            }
+
+            if let Err(err) = orchestrator::driver()
+                .stop_web(&app.name, &container, &container_settings)
+                .await
+            {
+                tracing::error!(?err, app = %app.name, "Can't idle app: Failed to stop containers");
+                continue;
+            }
+
+            let message = format!("Stopped after {} minutes without traffic", app.idle_timeout);
+            tracing::info!(project_id = ?app.project_id, message);
+
+            if let Err(err) = record_activity(app.project_id, "idle", &message, &pool).await {
+                tracing::error!(?err, "Can't record activity: Failed to query database");
+            }
+        }
+    }
+}
@@ -0,0 +1,141 @@
+use std::collections::{HashMap, HashSet};
+use std::pin::Pin;
+use std::sync::Mutex;
+use std::task::{Context, Poll};
+use std::time::Duration;
+
+use bytes::Bytes;
+use futures::Stream;
+use http_body::Body as _;
+use hyper::{Body, Response};
+use lazy_static::lazy_static;
+use tokio::sync::Notify;
+
+lazy_static! {
+    static ref IN_FLIGHT: InFlight = InFlight::default();
+}
+
+/// Requests the proxy has sent to each container and not finished answering, by container
+/// id. Deploys retire a container once it has none left, see [`drain`]
+#[derive(Default)]
+struct InFlight {
+    counts: Mutex<HashMap<String, usize>>,
+    draining: Mutex<HashSet<String>>,
+    /// woken whenever a container runs out of requests
+    idle: Notify,
+}
+
+/// One request in flight to a container, finished when dropped
+#[derive(Debug)]
+pub struct Guard {
+    container_id: String,
+}
+
+impl Drop for Guard {
+    fn drop(&mut self) {
+        let mut counts = IN_FLIGHT.counts.lock().unwrap();
+        if let Some(count) = counts.get_mut(&self.container_id) {
+            *count -= 1;
+            if *count == 0 {
+                counts.remove(&self.container_id);
+                IN_FLIGHT.idle.notify_waiters();
+            }
+        }
+    }
+}
+
+/// Counts a request to the container until the guard is dropped. The proxy takes it before
+/// it resolves the address, so a request that picked the container just before it started
+/// draining is still waited for
+pub fn track(container_id: &str) -> Guard {
+    *IN_FLIGHT
+        .counts
+        .lock()
+        .unwrap()
+        .entry(container_id.to_string())
+        .or_default() += 1;
+    Guard {
+        container_id: container_id.to_string(),
+    }
+}
+
+/// Whether the container is being retired, the balancer sends it no new requests then
+pub fn draining(container_id: &str) -> bool {
+    IN_FLIGHT.draining.lock().unwrap().contains(container_id)
+}
+
+fn in_flight(container_id: &str) -> usize {
+    IN_FLIGHT.counts.lock().unwrap().get(container_id).copied().unwrap_or(0)
+}
+
+/// Waits until the requests in flight to a container are answered, or `deadline` passes.
+/// Returns how many were left, they get the stop timeout of the container on top once it is
+/// sent SIGTERM
+pub async fn drain(container_id: &str, deadline: Duration) -> usize {
+    IN_FLIGHT.draining.lock().unwrap().insert(container_id.to_string());
+
+    let timeout = tokio::time::sleep(deadline);
+    tokio::pin!(timeout);
+    let left = loop {
+        // registered before the count is read, a request finishing in between still wakes it
+        let idle = IN_FLIGHT.idle.notified();
+        let left = in_flight(container_id);
+        if left == 0 {
+            break 0;
+        }
+        tokio::select! {
+            _ = idle => {}
+            _ = &mut timeout => break in_flight(container_id),
+        }
+    };
+
+    IN_FLIGHT.draining.lock().unwrap().remove(container_id);
+    left
+}
+
+/// Keeps the request counted until the body of `res` is sent whole or the client goes away.
+/// A body that could carry trailers, like the ones of grpc, is copied over as it comes so
+/// they still arrive
+pub fn hold(res: Response<Body>, guard: Guard, trailers: bool) -> Response<Body> {
+    if res.body().is_end_stream() {
+        return res;
+    }
+    let (parts, mut body) = res.into_parts();
+
+    if !trailers {
+        let held = Held { body, _guard: guard };
+        return Response::from_parts(parts, Body::wrap_stream(held));
+    }
+
+    let (mut sender, copy) = Body::channel();
+    tokio::spawn(async move {
+        let _guard = guard;
+        while let Some(chunk) = body.data().await {
+            let sent = match chunk {
+                Ok(chunk) => sender.send_data(chunk).await.is_ok(),
+                Err(_) => false,
+            };
+            if !sent {
+                sender.abort();
+                return;
+            }
+        }
+        if let Ok(Some(trailers)) = body.trailers().await {
+            let _ = sender.send_trailers(trailers).await;
+        }
+    });
+    Response::from_parts(parts, copy)
+}
+
+struct Held {
+    body: Body,
+    _guard: Guard,
+}
+
+impl Stream for Held {
+    type Item = Result<Bytes, hyper::Error>;
+
+    fn poll_next(mut self: Pin<&mut Self>, cx: &mut Context<'_>) -> Poll<Option<Self::Item>> {
+        Pin::new(&mut self.body).poll_data(cx)
+    }
+}
@@ -0,0 +1,118 @@
+use std::fmt;
+use std::net::IpAddr;
+use std::str::FromStr;
+
+/// A range of addresses like `10.0.0.0/8`, a bare address is a range of one
+#[derive(Debug, Clone, Copy, PartialEq)]
+pub struct Cidr {
+    network: IpAddr,
+    prefix: u8,
+}
+
+impl Cidr {
+    pub fn contains(&self, ip: &IpAddr) -> bool {
+        match (self.network, canonical(*ip)) {
+            (IpAddr::V4(network), IpAddr::V4(ip)) => {
+                let mask = u32::MAX.checked_shl(32 - u32::from(self.prefix)).unwrap_or(0);
+                u32::from(network) & mask == u32::from(ip) & mask
+            }
+            (IpAddr::V6(network), IpAddr::V6(ip)) => {
+                let mask = u128::MAX.checked_shl(128 - u32::from(self.prefix)).unwrap_or(0);
+                u128::from(network) & mask == u128::from(ip) & mask
+            }
+            _ => false,
+        }
+    }
+}
+
+impl FromStr for Cidr {
+    type Err = String;
+
+    fn from_str(value: &str) -> Result<Self, Self::Err> {
+        let (address, prefix) = match value.trim().split_once('/') {
+            Some((address, prefix)) => (address, Some(prefix)),
+            None => (value.trim(), None),
+        };
+        let network = address
+            .parse::<IpAddr>()
+            .map(canonical)
+            .map_err(|_| format!("{value} is not an ip address or range"))?;
+
+        let max = match network {
+            IpAddr::V4(_) => 32,
+            IpAddr::V6(_) => 128,
+        };
+        let prefix = match prefix {
+            Some(prefix) => prefix
+                .parse::<u8>()
+                .ok()
+                .filter(|prefix| *prefix <= max)
+                .ok_or_else(|| format!("{value} has a prefix length that isn't 0 to {max}"))?,
+            None => max,
+        };
+
+        // the address bits past the prefix are dropped, 10.1.2.3/8 is 10.0.0.0/8
+        let network = match network {
+            IpAddr::V4(ip) => {
+                let mask = u32::MAX.checked_shl(32 - u32::from(prefix)).unwrap_or(0);
+                IpAddr::from((u32::from(ip) & mask).to_be_bytes())
+            }
+            IpAddr::V6(ip) => {
+                let mask = u128::MAX.checked_shl(128 - u32::from(prefix)).unwrap_or(0);
+                IpAddr::from((u128::from(ip) & mask).to_be_bytes())
+            }
+        };
+        Ok(Self { network, prefix })
+    }
+}
+
+impl fmt::Display for Cidr {
+    fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+        write!(f, "{}/{}", self.network, self.prefix)
+    }
+}
+
+/// ipv4 clients of a dual stack listener show up as `::ffff:a.b.c.d`
+fn canonical(ip: IpAddr) -> IpAddr {
+    match ip {
+        IpAddr::V6(v6) => v6.to_ipv4_mapped().map(IpAddr::V4).unwrap_or(ip),
+        ip => ip,
+    }
+}
+
+/// Who may reach an app, set with `pmk access`. Denied ranges win over allowed ones, and once
+/// anything is allowed everyone else is denied
+#[derive(Debug, Clone, Default)]
+pub struct IpAccess {
+    pub allow: Vec<Cidr>,
+    pub deny: Vec<Cidr>,
+}
+
+impl IpAccess {
+    /// From the ranges stored on the project. They were checked when they were set, ones that
+    /// don't parse anymore are skipped
+    pub fn new(allow: &[String], deny: &[String]) -> Self {
+        let parse = |ranges: &[String]| {
+            ranges
+                .iter()
+                .filter_map(|range| range.parse().ok())
+                .collect::<Vec<Cidr>>()
+        };
+        Self {
+            allow: parse(allow),
+            deny: parse(deny),
+        }
+    }
+
+    /// Whether the client at `ip` gets through. A client the proxy can't tell the address of
+    /// only gets through while nothing is allowed explicitly
+    pub fn allows(&self, ip: &str) -> bool {
+        let Ok(ip) = ip.parse::<IpAddr>() else {
+            return self.allow.is_empty();
+        };
+        if self.deny.iter().any(|range| range.contains(&ip)) {
+            return false;
+        }
+        self.allow.is_empty() || self.allow.iter().any(|range| range.contains(&ip))
+    }
+}
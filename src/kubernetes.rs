@@ -0,0 +1,686 @@
+//! Runs apps in a kubernetes cluster, see [`crate::orchestrator`]. The web process of an app
+//! is a Deployment named after the container name of the app behind a Service of the same
+//! name, every worker process type another Deployment `{container_name}-{process}`. A new
+//! release rolls out in place, its pods replace the old ones once they pass their readiness
+//! probe. The cluster pulls images from container.registry, an image the platform built is
+//! pushed there before it runs
+
+use std::collections::BTreeMap;
+use std::time::Duration;
+
+use anyhow::{anyhow, Result};
+use async_trait::async_trait;
+use chrono::{DateTime, Utc};
+use data_encoding::BASE64;
+use reqwest::{Method, StatusCode};
+use serde_json::{json, Value};
+use tokio::time::Instant;
+
+use crate::arch;
+use crate::configuration::{ContainerSettings, KubernetesSettings};
+use crate::docker::{container_env, ReleaseConfig};
+use crate::ip_access::Cidr;
+use crate::limits::ResourceLimits;
+use crate::orchestrator::Driver;
+use crate::registry::{image_digest, push_image};
+use crate::secrets::SecretCipher;
+
+/// label on everything the driver creates, the value is the container name of the app
+const APP_LABEL: &str = "pemasak.app";
+/// label on Deployments and their pods, the value is the process type
+const PROCESS_LABEL: &str = "pemasak.process";
+/// annotation of a stopped Deployment, the value is the replicas it starts with again
+const REPLICAS_ANNOTATION: &str = "pemasak.replicas";
+/// annotation of pod templates, every deploy changes it so the pods are replaced
+const RESTARTED_ANNOTATION: &str = "pemasak.restarted";
+/// field manager of the applied objects
+const FIELD_MANAGER: &str = "pemasak";
+/// field manager of replicas. They aren't part of what is applied, so a deploy doesn't undo
+/// scaling or wake an idle app
+const SCALE_MANAGER: &str = "pemasak-scale";
+/// tag of the image of a release the registry doesn't have yet, it isn't a build id so the
+/// image collector keeps it
+const LIVE_TAG: &str = "live";
+const API_TIMEOUT: Duration = Duration::from_secs(30);
+const ROLLOUT_INTERVAL: Duration = Duration::from_secs(2);
+
+pub struct KubernetesDriver {
+    client: reqwest::Client,
+    apiserver: String,
+    namespace: String,
+    tokenfile: String,
+    /// where the platform pushes images
+    registry: String,
+    /// where the cluster pulls them from
+    cluster_registry: String,
+}
+
+impl KubernetesDriver {
+    pub fn new(settings: &KubernetesSettings, container_settings: &ContainerSettings) -> Result<Self> {
+        let registry = container_settings.registry.clone().ok_or_else(|| {
+            anyhow!("The kubernetes orchestrator needs container.registry, the cluster pulls the images of apps from it")
+        })?;
+
+        let mut client = reqwest::Client::builder().timeout(API_TIMEOUT);
+        // an api server with a certificate of a public ca has no bundle to mount
+        if let Ok(ca) = std::fs::read(&settings.cafile) {
+            client = client.add_root_certificate(reqwest::Certificate::from_pem(&ca)?);
+        }
+
+        Ok(Self {
+            client: client.build()?,
+            apiserver: settings.apiserver.trim_end_matches('/').to_string(),
+            namespace: settings.namespace.clone(),
+            tokenfile: settings.tokenfile.clone(),
+            cluster_registry: settings.registry.clone().unwrap_or_else(|| registry.clone()),
+            registry,
+        })
+    }
+
+    fn deployment_path(&self, name: &str) -> String {
+        format!("/apis/apps/v1/namespaces/{}/deployments/{name}", self.namespace)
+    }
+
+    fn service_path(&self, name: &str) -> String {
+        format!("/api/v1/namespaces/{}/services/{name}", self.namespace)
+    }
+
+    fn secret_path(&self, name: &str) -> String {
+        format!("/api/v1/namespaces/{}/secrets/{name}", self.namespace)
+    }
+
+    fn network_policy_path(&self, name: &str) -> String {
+        format!("/apis/networking.k8s.io/v1/namespaces/{}/networkpolicies/{name}", self.namespace)
+    }
+
+    /// Where the proxy reaches the web process of an app, the platform resolves it in the
+    /// cluster
+    fn service_address(&self, container_name: &str) -> String {
+        format!("{container_name}.{}.svc", self.namespace)
+    }
+
+    /// Sends a request to the api server. None when the object doesn't exist
+    async fn request(&self, method: Method, path: &str, body: Option<(&str, Value)>) -> Result<Option<Value>> {
+        let mut request = self.client.request(method, format!("{}{path}", self.apiserver));
+        // the token of a service account rotates, the kubelet keeps the file up to date
+        if let Ok(token) = tokio::fs::read_to_string(&self.tokenfile).await {
+            request = request.bearer_auth(token.trim());
+        }
+        if let Some((content_type, body)) = body {
+            request = request
+                .header(reqwest::header::CONTENT_TYPE, content_type)
+                .body(body.to_string());
+        }
+
+        let response = request.send().await?;
+        let status = response.status();
+        if status == StatusCode::NOT_FOUND {
+            return Ok(None);
+        }
+
+        let body: Value = response.json().await?;
+        if !status.is_success() {
+            let message = body["message"].as_str().unwrap_or("no message");
+            return Err(anyhow!("Kubernetes answered {status}: {message}"));
+        }
+
+        Ok(Some(body))
+    }
+
+    async fn get(&self, path: &str) -> Result<Option<Value>> {
+        self.request(Method::GET, path, None).await
+    }
+
+    /// Creates or updates an object with server side apply. Fields an earlier apply set that
+    /// `object` leaves out are removed
+    async fn apply(&self, path: &str, object: Value) -> Result<Value> {
+        self.request(
+            Method::PATCH,
+            &format!("{path}?fieldManager={FIELD_MANAGER}&force=true"),
+            Some(("application/apply-patch+yaml", object)),
+        )
+        .await?
+        .ok_or_else(|| anyhow!("Namespace {} does not exist", self.namespace))
+    }
+
+    /// Changes the replicas of a Deployment and the annotations that go with them
+    async fn merge(&self, path: &str, patch: Value) -> Result<()> {
+        self.request(
+            Method::PATCH,
+            &format!("{path}?fieldManager={SCALE_MANAGER}"),
+            Some(("application/merge-patch+json", patch)),
+        )
+        .await?
+        .ok_or_else(|| anyhow!("Deployment {path} does not exist"))?;
+        Ok(())
+    }
+
+    async fn delete(&self, path: &str) -> Result<()> {
+        self.request(Method::DELETE, path, None).await?;
+        Ok(())
+    }
+
+    async fn get_deployment(&self, name: &str) -> Result<Value> {
+        self.get(&self.deployment_path(name))
+            .await?
+            .ok_or_else(|| anyhow!("Deployment {name} does not exist"))
+    }
+
+    /// The web and worker Deployments of an app
+    async fn app_deployments(&self, container_name: &str) -> Result<Vec<Value>> {
+        let list = self
+            .get(&format!(
+                "/apis/apps/v1/namespaces/{}/deployments?labelSelector={APP_LABEL}={container_name}",
+                self.namespace
+            ))
+            .await?;
+
+        Ok(list
+            .and_then(|list| list["items"].as_array().cloned())
+            .unwrap_or_default())
+    }
+
+    /// Reference the cluster pulls `image` by. A release in the registry is pulled as is, an
+    /// image only the platform has is pushed as the live tag of the app first and pinned to
+    /// its digest, so pods of the release keep running it once the tag moves on
+    async fn cluster_image(&self, container_name: &str, image: &str) -> Result<String> {
+        if let Some(reference) = image.strip_prefix(&format!("{}/", self.registry)) {
+            return Ok(format!("{}/{reference}", self.cluster_registry));
+        }
+
+        push_image(&self.registry, container_name, image, LIVE_TAG).await?;
+        let digest = image_digest(&self.registry, container_name, LIVE_TAG).await?;
+
+        Ok(format!("{}/{container_name}@{digest}", self.cluster_registry))
+    }
+
+    /// Stores the environment of an app in the Secret its pods read it from. The user secrets
+    /// in it are decrypted, like in the environment of a docker container
+    async fn apply_env(
+        &self,
+        container_name: &str,
+        release_config: &ReleaseConfig,
+        db_url: &str,
+        container_settings: &ContainerSettings,
+        secrets: &SecretCipher,
+    ) -> Result<()> {
+        // later variables win, like the secrets container_env puts last
+        let data = container_env(release_config, container_settings.port, db_url, secrets)?
+            .iter()
+            .filter_map(|variable| variable.split_once('='))
+            .map(|(key, value)| (key.to_string(), Value::from(BASE64.encode(value.as_bytes()))))
+            .collect::<serde_json::Map<_, _>>();
+
+        let name = format!("{container_name}-env");
+        self.apply(
+            &self.secret_path(&name),
+            json!({
+                "apiVersion": "v1",
+                "kind": "Secret",
+                "metadata": { "name": name, "labels": { APP_LABEL: container_name } },
+                "type": "Opaque",
+                "data": data,
+            }),
+        )
+        .await?;
+
+        Ok(())
+    }
+
+    /// Waits until every replica of a Deployment runs its current template and is ready, and
+    /// the pods of the one before are gone
+    async fn rollout(&self, name: &str, timeout: u64) -> Result<()> {
+        let deadline = Instant::now() + Duration::from_secs(timeout);
+        loop {
+            let deployment = self.get_deployment(name).await?;
+            let replicas = deployment["spec"]["replicas"].as_i64().unwrap_or(1);
+            let status = &deployment["status"];
+
+            if status["observedGeneration"].as_i64() >= deployment["metadata"]["generation"].as_i64()
+                && status["updatedReplicas"].as_i64().unwrap_or(0) >= replicas
+                && status["availableReplicas"].as_i64().unwrap_or(0) >= replicas
+                && status["replicas"].as_i64().unwrap_or(0) <= replicas
+            {
+                return Ok(());
+            }
+
+            if Instant::now() >= deadline {
+                return Err(anyhow!("Pods of {name} weren't ready within {timeout} seconds"));
+            }
+            tokio::time::sleep(ROLLOUT_INTERVAL).await;
+        }
+    }
+
+    /// Sets the replicas of a Deployment. A stopped one stays stopped and gets them once it
+    /// starts
+    async fn scale(&self, name: &str, replicas: i64) -> Result<()> {
+        let deployment = self.get_deployment(name).await?;
+        let patch = match stopped(&deployment) {
+            true => json!({ "metadata": { "annotations": { REPLICAS_ANNOTATION: replicas.to_string() } } }),
+            false => json!({ "spec": { "replicas": replicas } }),
+        };
+
+        self.merge(&self.deployment_path(name), patch).await
+    }
+
+    /// Scales a Deployment to zero, remembering its replicas for [`Self::start`]
+    async fn stop(&self, name: &str) -> Result<()> {
+        let deployment = self.get_deployment(name).await?;
+        if stopped(&deployment) {
+            return Ok(());
+        }
+
+        let replicas = deployment["spec"]["replicas"].as_i64().unwrap_or(1);
+        self.merge(
+            &self.deployment_path(name),
+            json!({
+                "metadata": { "annotations": { REPLICAS_ANNOTATION: replicas.to_string() } },
+                "spec": { "replicas": 0 },
+            }),
+        )
+        .await
+    }
+
+    /// Scales a Deployment [`Self::stop`] stopped back to the replicas it had
+    async fn start(&self, name: &str) -> Result<()> {
+        let deployment = self.get_deployment(name).await?;
+        if !stopped(&deployment) {
+            return Ok(());
+        }
+
+        let replicas = deployment["metadata"]["annotations"][REPLICAS_ANNOTATION]
+            .as_str()
+            .and_then(|replicas| replicas.parse::<i64>().ok())
+            .unwrap_or(1);
+        self.merge(
+            &self.deployment_path(name),
+            json!({
+                "metadata": { "annotations": { REPLICAS_ANNOTATION: null } },
+                "spec": { "replicas": replicas },
+            }),
+        )
+        .await
+    }
+}
+
+/// Whether [`KubernetesDriver::stop`] scaled a Deployment to zero
+fn stopped(deployment: &Value) -> bool {
+    deployment["spec"]["replicas"].as_i64() == Some(0)
+        && deployment["metadata"]["annotations"][REPLICAS_ANNOTATION].is_string()
+}
+
+/// Container of the pods of one process type, with the environment of the app and the limits
+/// of the release
+fn pod_container(
+    container_name: &str,
+    process: &str,
+    image: &str,
+    args: Option<&Vec<String>>,
+    release_config: &ReleaseConfig,
+    container_settings: &ContainerSettings,
+) -> Value {
+    let limits = release_config
+        .limits
+        .clone()
+        .unwrap_or_else(|| ResourceLimits::defaults(container_settings));
+
+    let mut container = json!({
+        "name": process,
+        "image": image,
+        "envFrom": [{ "secretRef": { "name": format!("{container_name}-env") } }],
+        // the same few capabilities as on docker, see crate::runtime::harden
+        "securityContext": {
+            "capabilities": { "drop": ["ALL"], "add": container_settings.capabilities },
+            "allowPrivilegeEscalation": !container_settings.nonewprivileges,
+        },
+        "resources": {
+            "limits": {
+                "memory": limits.memory_bytes().to_string(),
+                "cpu": format!("{}m", limits.nano_cpus() / 1_000_000),
+            },
+        },
+    });
+    // like the cmd of a docker container, the entrypoint of the image stays
+    if let Some(args) = args {
+        container["args"] = json!(args);
+    }
+
+    container
+}
+
+/// Deployment of one process type of an app. Replicas are left to [`SCALE_MANAGER`]
+fn deployment(
+    container_name: &str,
+    name: &str,
+    process: &str,
+    container: Value,
+    container_settings: &ContainerSettings,
+) -> Value {
+    let labels = json!({ APP_LABEL: container_name, PROCESS_LABEL: process });
+
+    json!({
+        "apiVersion": "apps/v1",
+        "kind": "Deployment",
+        "metadata": { "name": name, "labels": labels },
+        "spec": {
+            "selector": { "matchLabels": labels },
+            // the old pods serve until the new ones are ready, like the old container does
+            "strategy": { "type": "RollingUpdate", "rollingUpdate": { "maxUnavailable": 0, "maxSurge": 1 } },
+            "template": {
+                "metadata": {
+                    "labels": labels,
+                    "annotations": { RESTARTED_ANNOTATION: Utc::now().to_rfc3339() },
+                },
+                "spec": {
+                    "terminationGracePeriodSeconds": container_settings.stoptimeout,
+                    // images are built for the architecture of the host of the platform
+                    "nodeSelector": { "kubernetes.io/arch": arch::host() },
+                    "containers": [container],
+                },
+            },
+        },
+    })
+}
+
+/// NetworkPolicy letting the pods of an app connect only to `ranges`, to each other and to
+/// the dns of the cluster. Addons stay on docker, their host has to be in the ranges
+fn egress_policy(container_name: &str, ranges: &[Cidr]) -> Value {
+    let app = json!({ APP_LABEL: container_name });
+    let mut egress = vec![
+        json!({ "to": [{ "podSelector": { "matchLabels": app } }] }),
+        json!({
+            "to": [{
+                "namespaceSelector": {},
+                "podSelector": { "matchLabels": { "k8s-app": "kube-dns" } },
+            }],
+            "ports": [{ "protocol": "UDP", "port": 53 }, { "protocol": "TCP", "port": 53 }],
+        }),
+    ];
+    if !ranges.is_empty() {
+        let to = ranges
+            .iter()
+            .map(|range| json!({ "ipBlock": { "cidr": range.to_string() } }))
+            .collect::<Vec<_>>();
+        egress.push(json!({ "to": to }));
+    }
+
+    json!({
+        "apiVersion": "networking.k8s.io/v1",
+        "kind": "NetworkPolicy",
+        "metadata": { "name": format!("{container_name}-egress"), "labels": app },
+        "spec": {
+            "podSelector": { "matchLabels": app },
+            "policyTypes": ["Egress"],
+            "egress": egress,
+        },
+    })
+}
+
+#[async_trait]
+impl Driver for KubernetesDriver {
+    async fn run_web(
+        &self,
+        container_name: &str,
+        image: &str,
+        release_config: &ReleaseConfig,
+        db_url: &str,
+        healthcheck_path: Option<&str>,
+        h2c: bool,
+        container_settings: &ContainerSettings,
+        secrets: &SecretCipher,
+    ) -> Result<(String, String)> {
+        if !release_config.volumes.is_empty() {
+            return Err(anyhow!("Volumes need the docker orchestrator, detach them to run the app on kubernetes"));
+        }
+
+        let port = container_settings.port;
+        let image = self.cluster_image(container_name, image).await?;
+        self.apply_env(container_name, release_config, db_url, container_settings, secrets)
+            .await?;
+
+        let mut container = pod_container(
+            container_name,
+            "web",
+            &image,
+            release_config.cmd.as_ref(),
+            release_config,
+            container_settings,
+        );
+        container["ports"] = json!([{ "containerPort": port }]);
+        // the kubelet probes over http/1.1, an h2c app only gets its port checked
+        container["readinessProbe"] = match healthcheck_path.filter(|_| !h2c) {
+            Some(path) => json!({ "httpGet": { "path": path, "port": port }, "periodSeconds": 2 }),
+            None => json!({ "tcpSocket": { "port": port }, "periodSeconds": 2 }),
+        };
+
+        let path = self.deployment_path(container_name);
+        let previous = self.get(&path).await?;
+        self.apply(&path, deployment(container_name, container_name, "web", container, container_settings))
+            .await?;
+        // an idle app runs its new release, the idler stops it again
+        self.start(container_name).await?;
+
+        let protocol = if h2c { "kubernetes.io/h2c" } else { "http" };
+        self.apply(
+            &self.service_path(container_name),
+            json!({
+                "apiVersion": "v1",
+                "kind": "Service",
+                "metadata": { "name": container_name, "labels": { APP_LABEL: container_name } },
+                "spec": {
+                    "selector": { APP_LABEL: container_name, PROCESS_LABEL: "web" },
+                    "ports": [{
+                        "port": port,
+                        "targetPort": port,
+                        "appProtocol": protocol,
+                    }],
+                },
+            }),
+        )
+        .await?;
+
+        if let Err(err) = self.rollout(container_name, container_settings.healthtimeout).await {
+            // pods of the release before replace the ones that never became ready, they kept
+            // serving meanwhile
+            if let Some(previous) = previous {
+                let rollback = json!({
+                    "apiVersion": "apps/v1",
+                    "kind": "Deployment",
+                    "metadata": { "name": container_name, "labels": previous["metadata"]["labels"] },
+                    "spec": {
+                        "selector": previous["spec"]["selector"],
+                        "strategy": previous["spec"]["strategy"],
+                        "template": previous["spec"]["template"],
+                    },
+                });
+                if let Err(err) = self.apply(&path, rollback).await {
+                    tracing::error!(?err, "Failed to roll back deployment");
+                }
+            }
+            return Err(err);
+        }
+
+        Ok((container_name.to_string(), self.service_address(container_name)))
+    }
+
+    async fn promote_web(
+        &self,
+        _container_name: &str,
+        _container_id: &str,
+        _container_settings: &ContainerSettings,
+    ) -> Result<()> {
+        // the rollout retired the old pods already
+        Ok(())
+    }
+
+    async fn run_processes(
+        &self,
+        container_name: &str,
+        image: &str,
+        release_config: &ReleaseConfig,
+        _db_url: &str,
+        formation: &BTreeMap<String, i64>,
+        restart: bool,
+        container_settings: &ContainerSettings,
+        _secrets: &SecretCipher,
+    ) -> Result<()> {
+        self.scale(container_name, formation.get("web").copied().unwrap_or(1).max(1))
+            .await?;
+
+        // a process type without pods has no Deployment, like it has no containers on docker
+        let deployments = self.app_deployments(container_name).await?;
+        for deployment in &deployments {
+            let process = deployment["metadata"]["labels"][PROCESS_LABEL].as_str().unwrap_or_default();
+            let name = deployment["metadata"]["name"].as_str().unwrap_or_default();
+            let wanted = release_config
+                .workers
+                .contains_key(process)
+                .then(|| formation.get(process).copied().unwrap_or(1) > 0);
+            if process != "web" && wanted != Some(true) {
+                self.delete(&self.deployment_path(name)).await?;
+            }
+        }
+
+        let mut pushed = None;
+        for (process, command) in &release_config.workers {
+            let replicas = formation.get(process).copied().unwrap_or(1);
+            if replicas <= 0 {
+                continue;
+            }
+
+            let name = format!("{container_name}-{process}");
+            let exists = deployments
+                .iter()
+                .any(|deployment| deployment["metadata"]["name"] == name.as_str());
+            if restart || !exists {
+                let reference = match pushed.take() {
+                    Some(reference) => reference,
+                    None => self.cluster_image(container_name, image).await?,
+                };
+                let container = pod_container(
+                    container_name,
+                    process,
+                    &reference,
+                    Some(command),
+                    release_config,
+                    container_settings,
+                );
+                pushed = Some(reference);
+                self.apply(
+                    &self.deployment_path(&name),
+                    deployment(container_name, &name, process, container, container_settings),
+                )
+                .await?;
+            }
+
+            self.scale(&name, replicas).await?;
+        }
+
+        Ok(())
+    }
+
+    async fn address(&self, container_name: &str, _container_id: &str) -> Result<Option<String>> {
+        let deployment = self.get_deployment(container_name).await?;
+        if deployment["spec"]["replicas"].as_i64() == Some(0) {
+            return Ok(None);
+        }
+
+        Ok(Some(self.service_address(container_name)))
+    }
+
+    async fn running_since(&self, container_name: &str, _container_id: &str) -> Result<Option<DateTime<Utc>>> {
+        let deployment = self.get_deployment(container_name).await?;
+        if deployment["spec"]["replicas"].as_i64() == Some(0) {
+            return Ok(None);
+        }
+
+        // updated on every rollout and scale, a deploy or wake among them
+        let progressed = deployment["status"]["conditions"]
+            .as_array()
+            .into_iter()
+            .flatten()
+            .find(|condition| condition["type"] == "Progressing")
+            .and_then(|condition| condition["lastUpdateTime"].as_str())
+            .and_then(|time| DateTime::parse_from_rfc3339(time).ok())
+            .map(|time| time.with_timezone(&Utc))
+            .unwrap_or_else(Utc::now);
+
+        Ok(Some(progressed))
+    }
+
+    async fn exists(&self, container_name: &str, _container_id: &str) -> Result<bool> {
+        Ok(self.get(&self.deployment_path(container_name)).await?.is_some())
+    }
+
+    async fn stop_web(
+        &self,
+        container_name: &str,
+        _container_id: &str,
+        _container_settings: &ContainerSettings,
+    ) -> Result<()> {
+        self.stop(container_name).await
+    }
+
+    async fn start_web(
+        &self,
+        container_name: &str,
+        _container_id: &str,
+        _port: i32,
+        _healthcheck_path: Option<&str>,
+        _h2c: bool,
+        timeout: u64,
+    ) -> Result<String> {
+        self.start(container_name).await?;
+        self.rollout(container_name, timeout).await?;
+
+        Ok(self.service_address(container_name))
+    }
+
+    async fn suspend(&self, container_name: &str, _container_settings: &ContainerSettings) -> Result<()> {
+        for deployment in self.app_deployments(container_name).await? {
+            if let Some(name) = deployment["metadata"]["name"].as_str() {
+                self.stop(name).await?;
+            }
+        }
+
+        Ok(())
+    }
+
+    async fn resume(&self, container_name: &str) -> Result<()> {
+        for deployment in self.app_deployments(container_name).await? {
+            if let Some(name) = deployment["metadata"]["name"].as_str() {
+                self.start(name).await?;
+            }
+        }
+
+        Ok(())
+    }
+
+    async fn remove(&self, container_name: &str) -> Result<()> {
+        self.delete(&format!(
+            "/apis/apps/v1/namespaces/{}/deployments?labelSelector={APP_LABEL}={container_name}",
+            self.namespace
+        ))
+        .await?;
+        self.delete(&self.service_path(container_name)).await?;
+        self.delete(&self.network_policy_path(&format!("{container_name}-egress"))).await?;
+        self.delete(&self.secret_path(&format!("{container_name}-env"))).await
+    }
+
+    async fn apply_egress(
+        &self,
+        container_name: &str,
+        ranges: Option<&[Cidr]>,
+        _container_settings: &ContainerSettings,
+    ) -> Result<()> {
+        let path = self.network_policy_path(&format!("{container_name}-egress"));
+        match ranges {
+            Some(ranges) => self.apply(&path, egress_policy(container_name, ranges)).await.map(|_| ()),
+            None => self.delete(&path).await,
+        }
+    }
+
+    fn side_by_side(&self) -> bool {
+        false
+    }
+}
@@ -0,0 +1,153 @@
+//! Labels are key/value pairs on apps, like `course=COMP301` or `team=blue`, kept in
+//! `projects.labels`. Maintainers set them with `pmk labels`, and a [`Selector`] picks apps by
+//! them in the app lists, bulk actions and the admin changes to limits and the cleanup, so
+//! an instructor acts on every app of an assignment at once
+//!
+//! A selector is a comma separated list of requirements an app meets all of: `key=value`,
+//! `key!=value`, `key` for having the label and `!key` for not having it. In SQL it is the
+//! four parameters of [`Selector::params`], matched against `projects.labels` with
+//!
+//! ```sql
+//! projects.labels @> $1::jsonb AND NOT projects.labels @> ANY($2::jsonb[])
+//! AND projects.labels ?& $3::text[] AND NOT projects.labels ?| $4::text[]
+//! ```
+
+use std::collections::BTreeMap;
+use std::fmt;
+use std::str::FromStr;
+
+/// labels an app has at most
+pub const MAX_LABELS: usize = 32;
+/// characters of a key or a value
+const MAX_LENGTH: usize = 63;
+
+pub type Labels = BTreeMap<String, String>;
+
+/// letters, digits, `-`, `_` and `.`, starting and ending with a letter or a digit
+fn valid(text: &str) -> bool {
+    let edge = |c: Option<char>| c.is_some_and(|c| c.is_ascii_alphanumeric());
+    text.len() <= MAX_LENGTH
+        && edge(text.chars().next())
+        && edge(text.chars().last())
+        && text.chars().all(|c| c.is_ascii_alphanumeric() || matches!(c, '-' | '_' | '.'))
+}
+
+/// Why `key` can't be the key of a label, None when it can
+pub fn check_key(key: &str) -> Option<String> {
+    match valid(key) {
+        true => None,
+        false => Some(format!(
+            "Invalid label key {key:?}, it has at most {MAX_LENGTH} letters, digits, -, _ and ., and starts and ends with a letter or a digit"
+        )),
+    }
+}
+
+/// Why `value` can't be the value of a label, None when it can. A value may be empty
+pub fn check_value(key: &str, value: &str) -> Option<String> {
+    match value.is_empty() || valid(value) {
+        true => None,
+        false => Some(format!(
+            "Invalid value {value:?} of label {key}, it has at most {MAX_LENGTH} letters, digits, -, _ and ., and starts and ends with a letter or a digit"
+        )),
+    }
+}
+
+/// Which apps a list or an action is about, by their labels. The empty selector picks every
+/// app
+#[derive(Debug, Clone, Default, PartialEq, Eq)]
+pub struct Selector {
+    /// `key=value`
+    pub equal: Labels,
+    /// `key!=value`, also met by apps without the key
+    pub not_equal: Vec<(String, String)>,
+    /// `key`, whatever its value
+    pub present: Vec<String>,
+    /// `!key`
+    pub absent: Vec<String>,
+}
+
+impl Selector {
+    pub fn is_empty(&self) -> bool {
+        self.equal.is_empty() && self.not_equal.is_empty() && self.present.is_empty() && self.absent.is_empty()
+    }
+
+    pub fn matches(&self, labels: &Labels) -> bool {
+        self.equal.iter().all(|(key, value)| labels.get(key) == Some(value))
+            && self.not_equal.iter().all(|(key, value)| labels.get(key) != Some(value))
+            && self.present.iter().all(|key| labels.contains_key(key))
+            && self.absent.iter().all(|key| !labels.contains_key(key))
+    }
+
+    /// What the queries take: the labels an app has all of, the ones it has none of, the keys
+    /// it has and the keys it lacks
+    pub fn params(&self) -> (serde_json::Value, Vec<serde_json::Value>, Vec<String>, Vec<String>) {
+        let not_equal = self
+            .not_equal
+            .iter()
+            .map(|(key, value)| serde_json::json!({ key: value }))
+            .collect();
+        (
+            serde_json::json!(self.equal),
+            not_equal,
+            self.present.clone(),
+            self.absent.clone(),
+        )
+    }
+}
+
+impl FromStr for Selector {
+    type Err = String;
+
+    fn from_str(value: &str) -> Result<Self, Self::Err> {
+        let mut selector = Selector::default();
+        for requirement in value.split(',').map(str::trim).filter(|requirement| !requirement.is_empty()) {
+            if let Some((key, value)) = requirement.split_once("!=") {
+                let (key, value) = (key.trim(), value.trim());
+                if let Some(err) = check_key(key).or_else(|| check_value(key, value)) {
+                    return Err(err);
+                }
+                selector.not_equal.push((key.to_string(), value.to_string()));
+            } else if let Some((key, value)) = requirement.split_once('=') {
+                let (key, value) = (key.trim(), value.trim_start_matches('=').trim());
+                if let Some(err) = check_key(key).or_else(|| check_value(key, value)) {
+                    return Err(err);
+                }
+                if selector.equal.get(key).is_some_and(|other| other != value) {
+                    return Err(format!("The selector asks for {key} to be both {} and {value}", selector.equal[key]));
+                }
+                selector.equal.insert(key.to_string(), value.to_string());
+            } else if let Some(key) = requirement.strip_prefix('!') {
+                let key = key.trim();
+                if let Some(err) = check_key(key) {
+                    return Err(err);
+                }
+                selector.absent.push(key.to_string());
+            } else {
+                if let Some(err) = check_key(requirement) {
+                    return Err(err);
+                }
+                selector.present.push(requirement.to_string());
+            }
+        }
+        Ok(selector)
+    }
+}
+
+impl fmt::Display for Selector {
+    fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+        let requirements = self
+            .equal
+            .iter()
+            .map(|(key, value)| format!("{key}={value}"))
+            .chain(self.not_equal.iter().map(|(key, value)| format!("{key}!={value}")))
+            .chain(self.present.iter().cloned())
+            .chain(self.absent.iter().map(|key| format!("!{key}")))
+            .collect::<Vec<_>>();
+        f.write_str(&requirements.join(","))
+    }
+}
+
+/// The labels stored in `projects.labels`, an app with none has an empty object
+pub fn from_json(labels: serde_json::Value) -> Labels {
+    serde_json::from_value(labels).unwrap_or_default()
+}
@@ -0,0 +1,498 @@
+use std::collections::HashMap;
+use std::path::{Path as StdPath, PathBuf};
+use std::sync::Arc;
+
+use anyhow::Result;
+use axum::extract::{Path, State};
+use axum::Extension;
+use bytes::Bytes;
+use chrono::Utc;
+use data_encoding::{BASE64URL_NOPAD, HEXLOWER};
+use hmac::{Hmac, Mac};
+use hyper::{Body, HeaderMap, Response, StatusCode};
+use lazy_static::lazy_static;
+use s3::error::S3Error;
+use s3::{creds::Credentials, Bucket, Region};
+use secrecy::ExposeSecret;
+use serde::{Deserialize, Serialize};
+use sha2::{Digest, Sha256};
+use tokio::io::AsyncReadExt;
+
+use crate::configuration::LfsSettings;
+use crate::startup::AppState;
+
+/// how long the upload and download links of a batch work, in seconds
+const LINK_EXPIRY: u32 = 3600;
+/// objects a client may ask about in one batch, git lfs sends 100 at a time
+const MAX_BATCH: usize = 1000;
+/// the spec keeps pointers below this, bigger files are never one
+const MAX_POINTER_SIZE: u32 = 1024;
+const POINTER_VERSION: &str = "version https://git-lfs.github.com/spec/v1";
+const CONTENT_TYPE: &str = "application/vnd.git-lfs+json";
+
+lazy_static! {
+    /// signs the tokens git-lfs-authenticate hands out over ssh. They only live an hour, so a
+    /// key of the process is enough
+    static ref TOKEN_KEY: [u8; 32] = rand::random();
+}
+
+/// What the credentials of a request to the lfs api may do, put in the extensions of the
+/// request by the auth of the git routes. Requests without it come from servers without git
+/// auth, they may do anything
+#[derive(Debug, Clone, Copy)]
+pub struct LfsAccess {
+    pub write: bool,
+}
+
+/// Keeps the git lfs objects of apps in an s3 compatible bucket, under the name of the app.
+/// Clients move the objects through presigned links, the platform only fetches them for
+/// builds
+#[derive(Clone)]
+pub struct LfsStorage {
+    bucket: Option<Arc<Bucket>>,
+}
+
+impl std::fmt::Debug for LfsStorage {
+    fn fmt(&self, f: &mut std::fmt::Formatter<'_>) -> std::fmt::Result {
+        f.debug_struct("LfsStorage")
+            .field("enabled", &self.enabled())
+            .finish()
+    }
+}
+
+impl LfsStorage {
+    /// Without a bucket the lfs api is off, the pointers of a push are built as they are
+    pub fn new(settings: &LfsSettings) -> Result<Self> {
+        let bucket = match &settings.bucket {
+            Some(name) => {
+                let region = Region::Custom {
+                    region: settings.region.clone(),
+                    endpoint: settings.endpoint.clone(),
+                };
+
+                let credentials = Credentials::new(
+                    settings.accesskey.as_deref(),
+                    settings.secretkey.as_ref().map(|key| key.expose_secret().as_str()),
+                    None,
+                    None,
+                    None,
+                )
+                .map_err(|err| anyhow::anyhow!("Invalid lfs credentials: {err}"))?;
+
+                let bucket = Bucket::new(name, region, credentials)
+                    .map_err(|err| anyhow::anyhow!("Invalid lfs bucket: {err}"))?
+                    .with_path_style();
+
+                Some(Arc::new(bucket))
+            }
+            None => None,
+        };
+
+        Ok(Self { bucket })
+    }
+
+    pub fn enabled(&self) -> bool {
+        self.bucket.is_some()
+    }
+
+    fn bucket(&self) -> Result<&Bucket> {
+        self.bucket
+            .as_deref()
+            .ok_or(anyhow::anyhow!("No lfs bucket configured"))
+    }
+
+    /// The size of a stored object, None when it isn't there
+    async fn size(&self, key: &str) -> Result<Option<i64>> {
+        match self.bucket()?.head_object(key).await {
+            Ok((head, _)) => Ok(Some(head.content_length.unwrap_or_default())),
+            Err(S3Error::HttpFailWithBody(404, _)) => Ok(None),
+            Err(err) => Err(err.into()),
+        }
+    }
+
+    fn upload_url(&self, key: &str) -> Result<String> {
+        Ok(self.bucket()?.presign_put(key, LINK_EXPIRY, None)?)
+    }
+
+    fn download_url(&self, key: &str) -> Result<String> {
+        Ok(self.bucket()?.presign_get(key, LINK_EXPIRY, None)?)
+    }
+
+    /// Replaces the lfs pointers of a checkout with the files they point to, so the build
+    /// sees the real assets. Objects are kept in `.git/lfs/objects` of the checkout like git
+    /// lfs does, a checkout that is updated for the next build only fetches new ones. Returns
+    /// how many files were replaced
+    pub async fn checkout(&self, owner: &str, repo: &str, container_src: &str) -> Result<usize> {
+        if !self.enabled() {
+            return Ok(0);
+        }
+        let pointers = {
+            let container_src = container_src.to_string();
+            tokio::task::spawn_blocking(move || find_pointers(&container_src)).await??
+        };
+
+        let mut missing = Vec::new();
+        for (file, pointer) in &pointers {
+            let cached = cache_path(container_src, &pointer.oid);
+            if !cached.exists() && !self.fetch(&object_key(owner, repo, &pointer.oid), pointer, &cached).await? {
+                missing.push(file.as_str());
+            }
+        }
+        if !missing.is_empty() {
+            return Err(anyhow::anyhow!(
+                "These Git LFS objects were never uploaded, push them with git lfs push --all:\n  {}",
+                missing.join("\n  ")
+            ));
+        }
+
+        for (file, pointer) in &pointers {
+            tokio::fs::copy(cache_path(container_src, &pointer.oid), StdPath::new(container_src).join(file)).await?;
+        }
+        Ok(pointers.len())
+    }
+
+    /// Downloads an object into the cache of a checkout, false when it isn't stored. Clients
+    /// upload straight to the bucket, so what they uploaded is checked against its oid here
+    async fn fetch(&self, key: &str, pointer: &Pointer, cached: &StdPath) -> Result<bool> {
+        if let Some(dir) = cached.parent() {
+            tokio::fs::create_dir_all(dir).await?;
+        }
+        let partial = cached.with_extension("partial");
+
+        let mut file = tokio::fs::File::create(&partial).await?;
+        match self.bucket()?.get_object_to_writer(key, &mut file).await {
+            Ok(_) => {}
+            Err(S3Error::HttpFailWithBody(404, _)) => {
+                let _ = tokio::fs::remove_file(&partial).await;
+                return Ok(false);
+            }
+            Err(err) => {
+                let _ = tokio::fs::remove_file(&partial).await;
+                return Err(err.into());
+            }
+        }
+        drop(file);
+
+        if sha256_file(&partial).await? != pointer.oid {
+            let _ = tokio::fs::remove_file(&partial).await;
+            return Err(anyhow::anyhow!("The Git LFS object {} doesn't match its oid, push it again", pointer.oid));
+        }
+        tokio::fs::rename(&partial, cached).await?;
+        Ok(true)
+    }
+}
+
+fn object_key(owner: &str, repo: &str, oid: &str) -> String {
+    format!("{owner}/{repo}/{oid}")
+}
+
+fn cache_path(container_src: &str, oid: &str) -> PathBuf {
+    StdPath::new(container_src)
+        .join(".git/lfs/objects")
+        .join(&oid[0..2])
+        .join(&oid[2..4])
+        .join(oid)
+}
+
+fn valid_oid(oid: &str) -> bool {
+    oid.len() == 64 && oid.bytes().all(|b| b.is_ascii_digit() || (b'a'..=b'f').contains(&b))
+}
+
+async fn sha256_file(path: &StdPath) -> Result<String> {
+    let mut file = tokio::fs::File::open(path).await?;
+    let mut hasher = Sha256::new();
+    let mut buf = vec![0; 64 * 1024];
+    loop {
+        let read = file.read(&mut buf).await?;
+        if read == 0 {
+            break;
+        }
+        hasher.update(&buf[..read]);
+    }
+    Ok(HEXLOWER.encode(&hasher.finalize()))
+}
+
+/// What git lfs commits in place of a file
+#[derive(Debug)]
+struct Pointer {
+    oid: String,
+}
+
+fn parse_pointer(content: &str) -> Option<Pointer> {
+    let mut lines = content.lines();
+    if lines.next()? != POINTER_VERSION {
+        return None;
+    }
+    let mut oid = None;
+    let mut size = None;
+    for line in lines {
+        match line.split_once(' ') {
+            Some(("oid", value)) => oid = value.strip_prefix("sha256:").map(str::to_string),
+            Some(("size", value)) => size = value.parse::<u64>().ok(),
+            _ => {}
+        }
+    }
+    size?;
+    oid.filter(|oid| valid_oid(oid)).map(|oid| Pointer { oid })
+}
+
+/// The files of a checkout that are lfs pointers, by their path in it
+fn find_pointers(container_src: &str) -> Result<Vec<(String, Pointer)>> {
+    let repo = git2::Repository::open(container_src)?;
+    let index = repo.index()?;
+
+    let mut pointers = Vec::new();
+    for entry in index.iter() {
+        if entry.file_size >= MAX_POINTER_SIZE {
+            continue;
+        }
+        let Ok(path) = String::from_utf8(entry.path) else {
+            continue;
+        };
+        let Ok(content) = std::fs::read_to_string(StdPath::new(container_src).join(&path)) else {
+            continue;
+        };
+        if let Some(pointer) = parse_pointer(&content) {
+            pointers.push((path, pointer));
+        }
+    }
+    Ok(pointers)
+}
+
+/// A token for the lfs api of one app, what git-lfs-authenticate hands out to clients with
+/// a deploy key, see [`crate::ssh`]
+pub fn lfs_token(owner: &str, repo: &str, write: bool, expires_in: i64) -> String {
+    let payload = format!("{owner}/{repo}:{write}:{}", Utc::now().timestamp() + expires_in);
+    let mut mac = Hmac::<Sha256>::new_from_slice(TOKEN_KEY.as_slice()).unwrap();
+    mac.update(payload.as_bytes());
+    let signature = mac.finalize().into_bytes();
+    format!("{}.{}", BASE64URL_NOPAD.encode(payload.as_bytes()), BASE64URL_NOPAD.encode(&signature))
+}
+
+/// What a token of [`lfs_token`] may do, None when it isn't one for the app or expired
+pub fn check_lfs_token(token: &str, owner: &str, repo: &str) -> Option<LfsAccess> {
+    let (payload, signature) = token.split_once('.')?;
+    let payload = BASE64URL_NOPAD.decode(payload.as_bytes()).ok()?;
+    let signature = BASE64URL_NOPAD.decode(signature.as_bytes()).ok()?;
+
+    let mut mac = Hmac::<Sha256>::new_from_slice(TOKEN_KEY.as_slice()).unwrap();
+    mac.update(&payload);
+    mac.verify_slice(&signature).ok()?;
+
+    let payload = String::from_utf8(payload).ok()?;
+    let mut parts = payload.rsplitn(3, ':');
+    let expires = parts.next()?.parse::<i64>().ok()?;
+    let write = parts.next()? == "true";
+    let app = parts.next()?;
+    (app == format!("{owner}/{repo}") && expires > Utc::now().timestamp()).then_some(LfsAccess { write })
+}
+
+#[derive(Deserialize, Debug)]
+struct BatchRequest {
+    operation: String,
+    #[serde(default)]
+    transfers: Vec<String>,
+    objects: Vec<ObjectSpec>,
+}
+
+#[derive(Serialize, Deserialize, Debug, Clone)]
+struct ObjectSpec {
+    oid: String,
+    size: i64,
+}
+
+#[derive(Serialize, Debug)]
+struct BatchResponse {
+    transfer: &'static str,
+    objects: Vec<ObjectResponse>,
+    hash_algo: &'static str,
+}
+
+#[derive(Serialize, Debug)]
+struct ObjectResponse {
+    oid: String,
+    size: i64,
+    #[serde(skip_serializing_if = "Option::is_none")]
+    actions: Option<Actions>,
+    #[serde(skip_serializing_if = "Option::is_none")]
+    error: Option<ObjectError>,
+}
+
+#[derive(Serialize, Debug, Default)]
+struct Actions {
+    #[serde(skip_serializing_if = "Option::is_none")]
+    upload: Option<Action>,
+    #[serde(skip_serializing_if = "Option::is_none")]
+    verify: Option<Action>,
+    #[serde(skip_serializing_if = "Option::is_none")]
+    download: Option<Action>,
+}
+
+#[derive(Serialize, Debug)]
+struct Action {
+    href: String,
+    #[serde(skip_serializing_if = "HashMap::is_empty")]
+    header: HashMap<String, String>,
+    expires_in: i64,
+}
+
+#[derive(Serialize, Debug)]
+struct ObjectError {
+    code: u16,
+    message: String,
+}
+
+#[derive(Serialize, Debug)]
+struct ErrorResponse {
+    message: String,
+}
+
+fn lfs_response(status: StatusCode, json: String) -> Response<Body> {
+    Response::builder()
+        .status(status)
+        .header("Content-Type", CONTENT_TYPE)
+        .body(Body::from(json))
+        .unwrap()
+}
+
+fn lfs_error(status: StatusCode, message: impl Into<String>) -> Response<Body> {
+    let json = serde_json::to_string(&ErrorResponse { message: message.into() }).unwrap();
+    lfs_response(status, json)
+}
+
+/// The batch api of git lfs: which objects of a push have to be uploaded and where, or
+/// where the objects of a fetch are downloaded from. Only the basic transfer is spoken
+#[tracing::instrument(skip(lfs, domain, access, headers, body))]
+pub async fn batch(
+    Path((owner, repo)): Path<(String, String)>,
+    State(AppState { lfs, domain, secure, .. }): State<AppState>,
+    access: Option<Extension<LfsAccess>>,
+    headers: HeaderMap,
+    body: Bytes,
+) -> Response<Body> {
+    let repo = repo.trim_end_matches(".git").to_string();
+    if !lfs.enabled() {
+        return lfs_error(StatusCode::NOT_IMPLEMENTED, "Git LFS is not enabled on this server");
+    }
+
+    let BatchRequest { operation, transfers, objects } = match serde_json::from_slice(&body) {
+        Ok(req) => req,
+        Err(err) => return lfs_error(StatusCode::UNPROCESSABLE_ENTITY, format!("Invalid batch request: {err}")),
+    };
+    if !transfers.is_empty() && !transfers.iter().any(|transfer| transfer == "basic") {
+        return lfs_error(StatusCode::UNPROCESSABLE_ENTITY, "Only the basic transfer is supported");
+    }
+    if objects.len() > MAX_BATCH {
+        return lfs_error(StatusCode::UNPROCESSABLE_ENTITY, format!("A batch can ask for at most {MAX_BATCH} objects"));
+    }
+    let upload = match operation.as_str() {
+        "upload" => true,
+        "download" => false,
+        _ => return lfs_error(StatusCode::UNPROCESSABLE_ENTITY, format!("Unknown operation {operation}")),
+    };
+    if upload && !access.map_or(true, |Extension(access)| access.write) {
+        return lfs_error(StatusCode::FORBIDDEN, "These credentials can't push to this app");
+    }
+
+    let scheme = match secure {
+        true => "https",
+        false => "http",
+    };
+    // the client verifies with the credentials it sent the batch with
+    let verify_header = headers
+        .get("Authorization")
+        .and_then(|value| value.to_str().ok())
+        .map(|value| HashMap::from([("Authorization".to_string(), value.to_string())]))
+        .unwrap_or_default();
+    let action = |href: String, header: HashMap<String, String>| Action {
+        href,
+        header,
+        expires_in: LINK_EXPIRY as i64,
+    };
+
+    let mut answers = Vec::with_capacity(objects.len());
+    for ObjectSpec { oid, size } in objects {
+        let error = |code: StatusCode, message: &str| ObjectResponse {
+            oid: oid.clone(),
+            size,
+            actions: None,
+            error: Some(ObjectError { code: code.as_u16(), message: message.to_string() }),
+        };
+        if !valid_oid(&oid) || size < 0 {
+            answers.push(error(StatusCode::UNPROCESSABLE_ENTITY, "Invalid oid or size"));
+            continue;
+        }
+
+        let key = object_key(&owner, &repo, &oid);
+        let stored = match lfs.size(&key).await {
+            Ok(stored) => stored,
+            Err(err) => {
+                tracing::error!(?err, "Can't answer lfs batch: Failed to query bucket");
+                answers.push(error(StatusCode::INTERNAL_SERVER_ERROR, "Failed to query storage"));
+                continue;
+            }
+        };
+
+        let actions = match (upload, stored) {
+            // already there, nothing to upload
+            (true, Some(stored)) if stored == size => None,
+            (true, _) => lfs.upload_url(&key).map(|href| Actions {
+                upload: Some(action(href, HashMap::new())),
+                verify: Some(action(
+                    format!("{scheme}://{domain}/{owner}/{repo}.git/info/lfs/objects/verify"),
+                    verify_header.clone(),
+                )),
+                ..Default::default()
+            }).map(Some),
+            (false, Some(_)) => lfs.download_url(&key).map(|href| Actions {
+                download: Some(action(href, HashMap::new())),
+                ..Default::default()
+            }).map(Some),
+            (false, None) => {
+                answers.push(error(StatusCode::NOT_FOUND, "Object does not exist"));
+                continue;
+            }
+        };
+        match actions {
+            Ok(actions) => answers.push(ObjectResponse { oid, size, actions, error: None }),
+            Err(err) => {
+                tracing::error!(?err, "Can't answer lfs batch: Failed to presign link");
+                answers.push(error(StatusCode::INTERNAL_SERVER_ERROR, "Failed to make a link to storage"));
+            }
+        }
+    }
+
+    let json = serde_json::to_string(&BatchResponse {
+        transfer: "basic",
+        objects: answers,
+        hash_algo: "sha256",
+    }).unwrap();
+    lfs_response(StatusCode::OK, json)
+}
+
+/// Called by the client after an upload, the object has to be in the bucket at its size
+#[tracing::instrument(skip(lfs, body))]
+pub async fn verify(
+    Path((owner, repo)): Path<(String, String)>,
+    State(AppState { lfs, .. }): State<AppState>,
+    body: Bytes,
+) -> Response<Body> {
+    let repo = repo.trim_end_matches(".git").to_string();
+    let ObjectSpec { oid, size } = match serde_json::from_slice(&body) {
+        Ok(object) => object,
+        Err(err) => return lfs_error(StatusCode::UNPROCESSABLE_ENTITY, format!("Invalid object: {err}")),
+    };
+    if !valid_oid(&oid) {
+        return lfs_error(StatusCode::UNPROCESSABLE_ENTITY, "Invalid oid");
+    }
+
+    match lfs.size(&object_key(&owner, &repo, &oid)).await {
+        Ok(Some(stored)) if stored == size => lfs_response(StatusCode::OK, "{}".to_string()),
+        Ok(Some(_)) => lfs_error(StatusCode::UNPROCESSABLE_ENTITY, "The object was uploaded at another size"),
+        Ok(None) => lfs_error(StatusCode::NOT_FOUND, "The object was not uploaded"),
+        Err(err) => {
+            tracing::error!(?err, "Can't verify lfs object: Failed to query bucket");
+            lfs_error(StatusCode::INTERNAL_SERVER_ERROR, "Failed to query storage")
+        }
+    }
+}
@@ -1,10 +1,82 @@
+pub mod access_logs;
+pub mod activity;
+pub mod admin;
+pub mod agent;
+pub mod arch;
+pub mod audit;
 pub mod auth;
+pub mod autoscaler;
+pub mod backups;
+pub mod balancer;
+pub mod basic_auth;
+pub mod buildpacks;
+pub mod bundles;
+pub mod cache;
+pub mod cleanup;
+pub mod compression;
 pub mod configuration;
+pub mod container_logs;
+pub mod crashloop;
+pub mod cors;
+pub mod cron;
+pub mod deploy_branch;
+pub mod deploy_keys;
 pub mod docker;
+pub mod drains;
+pub mod egress;
+pub mod env_groups;
+pub mod error_pages;
 pub mod git;
+pub mod header_rules;
+pub mod https;
+pub mod idle;
+pub mod in_flight;
+pub mod ip_access;
+pub mod kubernetes;
+pub mod labels;
+pub mod lfs;
+pub mod limits;
+pub mod mail;
+pub mod linked_repos;
+pub mod live;
+pub mod manifest;
+pub mod metrics;
+pub mod monitoring;
+pub mod monorepo;
+pub mod nodes;
+pub mod notifications;
+pub mod orchestrator;
 pub mod owner;
+pub mod pagination;
+pub mod pipelines;
+pub mod ports;
+pub mod previews;
+pub mod push_policy;
 pub mod projects;
+pub mod proxy_signature;
+pub mod quotas;
 pub mod queue;
+pub mod rate_limits;
+pub mod request_limits;
+pub mod reconciler;
+pub mod redis;
+pub mod registry;
+pub mod releases;
+pub mod restarts;
+pub mod runtime;
+pub mod scanning;
+pub mod secrets;
+pub mod services;
+pub mod sites;
+pub mod snapshots;
+pub mod ssh;
 pub mod startup;
+pub mod storage;
+pub mod streaming;
 pub mod telemetry;
+pub mod templates;
+pub mod uploads;
+pub mod usage;
+pub mod volumes;
+pub mod websockets;
 pub mod dashboard;
@@ -0,0 +1,209 @@
+use anyhow::Result;
+use bollard::container::UpdateContainerOptions;
+use garde::Validate;
+use serde::{Deserialize, Serialize};
+use sqlx::PgPool;
+use uuid::Uuid;
+
+use crate::configuration::ContainerSettings;
+use crate::docker::project_containers;
+use crate::nodes;
+
+/// What every container of an app may use. Stored with the release, so every container of
+/// it starts with the same limits
+#[derive(Serialize, Deserialize, Debug, Clone, PartialEq)]
+pub struct ResourceLimits {
+    /// in MiB, the container gets killed when it uses more
+    pub memory: i32,
+    /// 0.5 is half of one cpu
+    pub cpus: f64,
+    /// in MiB, the sizes of the volumes of the app add up to at most this
+    pub disk: i32,
+}
+
+impl ResourceLimits {
+    /// The platform wide defaults
+    pub fn defaults(container_settings: &ContainerSettings) -> Self {
+        Self {
+            memory: container_settings.memory,
+            cpus: container_settings.cpus,
+            disk: container_settings.volumequota,
+        }
+    }
+
+    pub fn memory_bytes(&self) -> i64 {
+        self.memory as i64 * 1024 * 1024
+    }
+
+    pub fn nano_cpus(&self) -> i64 {
+        (self.cpus * 1e9) as i64
+    }
+}
+
+impl std::fmt::Display for ResourceLimits {
+    fn fmt(&self, f: &mut std::fmt::Formatter) -> std::fmt::Result {
+        write!(f, "{} MiB memory, {} cpus, {} MiB disk", self.memory, self.cpus, self.disk)
+    }
+}
+
+/// Limits set by a platform admin on an app or a user, None keeps the one below it
+#[derive(Serialize, Deserialize, Validate, Debug, Clone, Default)]
+pub struct LimitOverrides {
+    /// in MiB
+    #[garde(range(min = 16, max = 1048576))]
+    pub memory: Option<i32>,
+    #[garde(range(min = 0.01, max = 1024.0))]
+    pub cpus: Option<f64>,
+    /// in MiB
+    #[garde(range(min = 1, max = 1073741824))]
+    pub disk: Option<i32>,
+}
+
+/// Where a limit of an app comes from
+#[derive(Serialize, Debug, Clone, Copy, PartialEq, Eq)]
+#[serde(rename_all = "lowercase")]
+pub enum LimitSource {
+    /// set on the app itself
+    App,
+    /// set on a user who owns the app
+    User,
+    Default,
+}
+
+#[derive(Serialize, Debug, Clone)]
+pub struct LimitSources {
+    pub memory: LimitSource,
+    pub cpus: LimitSource,
+    pub disk: LimitSource,
+}
+
+fn pick<T: Copy>(app: Option<T>, user: Option<T>, default: T) -> (T, LimitSource) {
+    match (app, user) {
+        (Some(app), _) => (app, LimitSource::App),
+        (None, Some(user)) => (user, LimitSource::User),
+        (None, None) => (default, LimitSource::Default),
+    }
+}
+
+/// The limits of an app, where each comes from and what is set on the app itself
+#[derive(Serialize, Debug, Clone)]
+pub struct AppLimits {
+    #[serde(flatten)]
+    pub limits: ResourceLimits,
+    pub sources: LimitSources,
+    /// what a platform admin set on the app
+    pub app: LimitOverrides,
+}
+
+/// Limits of the app win over those of the users who own it, and the highest limit of those
+/// users wins over the defaults
+pub async fn resolve_limits(
+    project_id: Uuid,
+    container_settings: &ContainerSettings,
+    pool: &PgPool,
+) -> Result<AppLimits> {
+    let record = sqlx::query!(
+        r#"SELECT projects.memory_limit, projects.cpu_limit, projects.disk_limit,
+             max(users.memory_limit) AS user_memory, max(users.cpu_limit) AS user_cpus,
+             max(users.disk_limit) AS user_disk
+           FROM projects
+           LEFT JOIN users_owners ON users_owners.owner_id = projects.owner_id AND users_owners.role = 'owner'
+           LEFT JOIN users ON users.id = users_owners.user_id
+           WHERE projects.id = $1
+           GROUP BY projects.id
+        "#,
+        project_id
+    )
+    .fetch_one(pool)
+    .await?;
+
+    let defaults = ResourceLimits::defaults(container_settings);
+    let (memory, memory_source) = pick(record.memory_limit, record.user_memory, defaults.memory);
+    let (cpus, cpus_source) = pick(record.cpu_limit, record.user_cpus, defaults.cpus);
+    let (disk, disk_source) = pick(record.disk_limit, record.user_disk, defaults.disk);
+
+    Ok(AppLimits {
+        limits: ResourceLimits { memory, cpus, disk },
+        sources: LimitSources {
+            memory: memory_source,
+            cpus: cpus_source,
+            disk: disk_source,
+        },
+        app: LimitOverrides {
+            memory: record.memory_limit,
+            cpus: record.cpu_limit,
+            disk: record.disk_limit,
+        },
+    })
+}
+
+pub async fn project_limits(
+    project_id: Uuid,
+    container_settings: &ContainerSettings,
+    pool: &PgPool,
+) -> Result<ResourceLimits> {
+    Ok(resolve_limits(project_id, container_settings, pool).await?.limits)
+}
+
+/// Puts the current limits of an app on its releases and its running containers, so neither
+/// a restart nor the autoscaler brings back the old ones. A container using more memory than
+/// its new limit is left with the old one until the next deploy
+#[tracing::instrument(skip(container_settings, pool))]
+pub async fn apply_limits(project_id: Uuid, container_settings: &ContainerSettings, pool: &PgPool) -> Result<()> {
+    let limits = project_limits(project_id, container_settings, pool).await?;
+
+    let project = sqlx::query!(
+        r#"SELECT projects.name AS project, project_owners.name AS owner
+           FROM projects
+           JOIN project_owners ON projects.owner_id = project_owners.id
+           WHERE projects.id = $1
+        "#,
+        project_id
+    )
+    .fetch_one(pool)
+    .await?;
+
+    sqlx::query!(
+        "UPDATE releases SET config = jsonb_set(config, '{limits}', $2) WHERE project_id = $1",
+        project_id,
+        serde_json::to_value(&limits)?
+    )
+    .execute(pool)
+    .await?;
+
+    let container_name = format!("{}-{}", project.owner, project.project).replace('.', "-");
+    let docker = nodes::docker(&container_name)?;
+    for container in project_containers(&container_name).await? {
+        let update = UpdateContainerOptions::<String> {
+            memory: Some(limits.memory_bytes()),
+            memory_swap: Some(limits.memory_bytes()),
+            nano_cpus: Some(limits.nano_cpus()),
+            ..Default::default()
+        };
+        if let Err(err) = docker.update_container(&container.id, update).await {
+            tracing::warn!(?err, container = %container.name, "Can't apply limits: Failed to update container");
+        }
+    }
+
+    Ok(())
+}
+
+/// Applies the limits of every app a user owns, after their own limits changed
+pub async fn apply_user_limits(user_id: Uuid, container_settings: &ContainerSettings, pool: &PgPool) -> Result<()> {
+    let projects = sqlx::query!(
+        r#"SELECT projects.id
+           FROM projects
+           JOIN users_owners ON users_owners.owner_id = projects.owner_id
+           WHERE users_owners.user_id = $1 AND users_owners.role = 'owner'
+        "#,
+        user_id
+    )
+    .fetch_all(pool)
+    .await?;
+
+    for project in projects {
+        apply_limits(project.id, container_settings, pool).await?;
+    }
+
+    Ok(())
+}
@@ -0,0 +1,240 @@
+use std::path::Path;
+
+use anyhow::Result;
+use data_encoding::{BASE64, HEXLOWER};
+use hmac::{Hmac, Mac};
+use rand::RngCore;
+use sha2::Sha256;
+use sqlx::PgPool;
+use tokio::process::Command;
+use tokio::sync::mpsc::Sender;
+use uuid::Uuid;
+
+use crate::activity::record_activity;
+use crate::deploy_branch::deploy_branch;
+use crate::monorepo::push_needs_build;
+use crate::queue::BuildQueueItem;
+use crate::secrets::SecretCipher;
+use crate::telemetry::current_context;
+
+/// Random secret shared with the provider when the webhook is registered
+pub fn webhook_secret() -> String {
+    let mut secret = [0u8; 32];
+    rand::thread_rng().fill_bytes(&mut secret);
+    HEXLOWER.encode(&secret)
+}
+
+/// Checks the X-Hub-Signature-256 header github sends, an hmac of the body keyed with the
+/// webhook secret
+pub fn verify_github(secret: &str, body: &[u8], signature: &str) -> bool {
+    let signature = match signature
+        .strip_prefix("sha256=")
+        .and_then(|signature| HEXLOWER.decode(signature.as_bytes()).ok())
+    {
+        Some(signature) => signature,
+        None => return false,
+    };
+
+    let mut mac = match Hmac::<Sha256>::new_from_slice(secret.as_bytes()) {
+        Ok(mac) => mac,
+        Err(_) => return false,
+    };
+    mac.update(body);
+    mac.verify_slice(&signature).is_ok()
+}
+
+/// Checks the X-Gitlab-Token header, gitlab sends the secret itself
+pub fn verify_gitlab(secret: &str, token: &str) -> bool {
+    // compares every byte so the time taken doesn't tell how much of the token matched
+    secret.len() == token.len()
+        && secret
+            .bytes()
+            .zip(token.bytes())
+            .fold(0, |diff, (a, b)| diff | (a ^ b))
+            == 0
+}
+
+/// A push to the branch of a linked repository, as parsed from a webhook
+#[derive(Debug)]
+pub struct Push {
+    pub project_id: Uuid,
+    pub owner: String,
+    pub repo: String,
+    pub provider: String,
+    pub url: String,
+    pub branch: String,
+    /// encrypted
+    pub token: Option<String>,
+    pub commit: String,
+}
+
+/// Fetches the pushed branch into the checkout the builder reads and queues a build of it.
+/// Webhooks are answered before this runs, so failures end up in the activity log
+pub async fn deploy_push(
+    push: Push,
+    base: &str,
+    pool: &PgPool,
+    build_channel: &Sender<BuildQueueItem>,
+    secrets: &SecretCipher,
+) {
+    let repo = push.repo.trim_end_matches(".git");
+    let container_src = format!("{base}/{}/{repo}.git/master", push.owner);
+    let container_name = format!("{}-{repo}", push.owner).replace('.', "-");
+
+    let token = match push.token.as_deref().map(|token| secrets.decrypt(token)).transpose() {
+        Ok(token) => token,
+        Err(err) => {
+            tracing::error!(?err, "Can't deploy linked repository: Failed to decrypt token");
+            failed(push.project_id, &push.url, &push.branch, &err, pool).await;
+            return;
+        }
+    };
+
+    let commit = match sync_checkout(
+        &container_src,
+        &push.provider,
+        &push.url,
+        &push.branch,
+        token.as_deref(),
+    )
+    .await
+    {
+        Ok(commit) => commit,
+        Err(err) => {
+            tracing::error!(?err, "Can't deploy linked repository: Failed to fetch branch");
+            failed(push.project_id, &push.url, &push.branch, &err, pool).await;
+            return;
+        }
+    };
+
+    if commit != push.commit {
+        // a later push got fetched first, its own webhook deploys it again
+        tracing::info!(pushed = push.commit, commit, "Branch moved since the push");
+    }
+
+    if let Err(err) = sqlx::query!(
+        "UPDATE linked_repos SET last_commit = $1, last_synced_at = now() WHERE project_id = $2",
+        commit,
+        push.project_id
+    )
+    .execute(pool)
+    .await
+    {
+        tracing::error!(?err, "Can't update linked repository: Failed to query database");
+    }
+
+    if !push_needs_build(&push.owner, &push.repo, &container_src, pool).await {
+        return;
+    }
+
+    let message = format!(
+        "Push of {} to {} on {}, deploying",
+        &commit[..commit.len().min(7)],
+        push.branch,
+        push.provider
+    );
+    if let Err(err) = record_activity(push.project_id, "push", &message, pool).await {
+        tracing::error!(?err, "Can't record activity: Failed to query database");
+    }
+
+    // a linked repository deploys the branch it was linked with, whether it waits for a
+    // promote is up to the app
+    let kind = match deploy_branch(&push.owner, &push.repo, pool).await {
+        Ok(deploy) => deploy.build_kind(),
+        Err(err) => {
+            tracing::error!(?err, "Can't deploy linked repository: Failed to query database");
+            failed(push.project_id, &push.url, &push.branch, &anyhow::Error::from(err), pool).await;
+            return;
+        }
+    };
+
+    if let Err(err) = build_channel
+        .send(BuildQueueItem {
+            container_name,
+            container_src,
+            owner: push.owner,
+            repo: push.repo,
+            kind,
+            trace: current_context(),
+        })
+        .await
+    {
+        tracing::error!(?err, "Can't deploy linked repository: Failed to send to build queue");
+    }
+}
+
+async fn failed(project_id: Uuid, url: &str, branch: &str, err: &anyhow::Error, pool: &PgPool) {
+    let message = format!("Failed to fetch {branch} from {url}: {err}");
+    if let Err(err) = record_activity(project_id, "push", &message, pool).await {
+        tracing::error!(?err, "Can't record activity: Failed to query database");
+    }
+}
+
+/// Makes the checkout match `branch` of `url` and returns the commit it is at. Files the
+/// build left behind are removed, the checkout only ever holds what was pushed
+pub async fn sync_checkout(
+    checkout: &str,
+    provider: &str,
+    url: &str,
+    branch: &str,
+    token: Option<&str>,
+) -> Result<String> {
+    if !Path::new(checkout).join(".git").exists() {
+        git(checkout, &["init", "--quiet", checkout], None).await?;
+    }
+
+    // the token goes in a header through the environment, so it isn't in the process list
+    // or in FETCH_HEAD
+    let authorization = token.map(|token| {
+        let username = match provider {
+            "gitlab" => "oauth2",
+            _ => "x-access-token",
+        };
+        let credentials = BASE64.encode(format!("{username}:{token}").as_bytes());
+        format!("Authorization: Basic {credentials}")
+    });
+
+    git(
+        checkout,
+        &["fetch", "--quiet", "--depth", "1", url, &format!("refs/heads/{branch}")],
+        authorization.as_deref(),
+    )
+    .await?;
+    git(checkout, &["reset", "--quiet", "--hard", "FETCH_HEAD"], None).await?;
+    git(checkout, &["clean", "--quiet", "-ffd"], None).await?;
+
+    let commit = git(checkout, &["rev-parse", "HEAD"], None).await?;
+    Ok(commit.trim().to_string())
+}
+
+async fn git(dir: &str, args: &[&str], authorization: Option<&str>) -> Result<String> {
+    // init runs before the checkout exists
+    let cwd = match Path::new(dir).exists() {
+        true => dir,
+        false => ".",
+    };
+
+    let mut command = Command::new("git");
+    command
+        .current_dir(cwd)
+        .args(args)
+        .env("GIT_TERMINAL_PROMPT", "0");
+
+    if let Some(authorization) = authorization {
+        command
+            .env("GIT_CONFIG_COUNT", "1")
+            .env("GIT_CONFIG_KEY_0", "http.extraHeader")
+            .env("GIT_CONFIG_VALUE_0", authorization);
+    }
+
+    let output = command.output().await?;
+    if !output.status.success() {
+        return Err(anyhow::anyhow!(
+            "git {} failed: {}",
+            args[0],
+            String::from_utf8_lossy(&output.stderr).trim()
+        ));
+    }
+
+    Ok(String::from_utf8_lossy(&output.stdout).to_string())
+}
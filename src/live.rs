@@ -0,0 +1,145 @@
+//! Live updates of apps, pushed to the dashboard over server sent events instead of every
+//! open page polling the api. Whatever changes an app publishes an [`Update`] to it, and
+//! `GET /api/project/:owner/:project/live` passes them on to everyone watching: builds
+//! starting and finishing, lines of their logs, what happened to the containers and the
+//! output of the containers
+//!
+//! Updates only live in memory. A project nobody watches has no channel, publishing to it
+//! is a lookup. A subscriber reading slower than updates come skips ahead and is told to
+//! read the state again, see [`Subscription::recv`]
+
+use std::collections::HashMap;
+use std::str::FromStr;
+use std::sync::RwLock;
+
+use lazy_static::lazy_static;
+use serde::Serialize;
+use tokio::sync::broadcast::{self, error::RecvError};
+use uuid::Uuid;
+
+use crate::drains::LogLine;
+
+/// updates waiting for the slowest subscriber of a project
+const CAPACITY: usize = 256;
+
+lazy_static! {
+    static ref CHANNELS: RwLock<HashMap<Uuid, broadcast::Sender<Update>>> = RwLock::new(HashMap::new());
+}
+
+/// What a subscriber asks for, the name of the event it gets
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub enum Topic {
+    Build,
+    BuildLog,
+    Container,
+    Log,
+}
+
+impl Topic {
+    pub const ALL: [Topic; 4] = [Topic::Build, Topic::BuildLog, Topic::Container, Topic::Log];
+
+    pub fn name(&self) -> &'static str {
+        match self {
+            Topic::Build => "build",
+            Topic::BuildLog => "build_log",
+            Topic::Container => "container",
+            Topic::Log => "log",
+        }
+    }
+}
+
+impl FromStr for Topic {
+    type Err = String;
+
+    fn from_str(value: &str) -> Result<Self, Self::Err> {
+        Topic::ALL
+            .into_iter()
+            .find(|topic| topic.name() == value)
+            .ok_or(format!(
+                "Unknown event {value:?}, it is one of build, build_log, container and log"
+            ))
+    }
+}
+
+#[derive(Serialize, Debug, Clone)]
+#[serde(untagged)]
+pub enum Update {
+    /// a build was queued, started or finished, `status` like the one of the build in the api
+    Build { build_id: Uuid, status: &'static str },
+    /// output a build appended to its log, `offset` is the byte of the log it ends at
+    BuildLog { build_id: Uuid, offset: usize, log: String },
+    /// something happened to the containers, like a deploy, a crash or scaling. The kind
+    /// and message of its activity
+    Container { kind: String, message: String },
+    /// lines the containers wrote
+    Log { lines: Vec<LogLine> },
+}
+
+impl Update {
+    pub fn topic(&self) -> Topic {
+        match self {
+            Update::Build { .. } => Topic::Build,
+            Update::BuildLog { .. } => Topic::BuildLog,
+            Update::Container { .. } => Topic::Container,
+            Update::Log { .. } => Topic::Log,
+        }
+    }
+}
+
+/// Whether anyone watches the project, so updates that cost something to make can be skipped
+pub fn watched(project_id: Uuid) -> bool {
+    CHANNELS.read().unwrap().contains_key(&project_id)
+}
+
+pub fn publish(project_id: Uuid, update: Update) {
+    if let Some(sender) = CHANNELS.read().unwrap().get(&project_id) {
+        // nobody left to receive it is fine, the last one removes the channel
+        let _ = sender.send(update);
+    }
+}
+
+/// Updates of one project, from the moment it was made
+pub struct Subscription {
+    project_id: Uuid,
+    receiver: broadcast::Receiver<Update>,
+}
+
+pub fn subscribe(project_id: Uuid) -> Subscription {
+    let mut channels = CHANNELS.write().unwrap();
+    let receiver = channels
+        .entry(project_id)
+        .or_insert_with(|| broadcast::channel(CAPACITY).0)
+        .subscribe();
+    Subscription { project_id, receiver }
+}
+
+impl Subscription {
+    /// The next update. None when the subscriber fell behind and updates were skipped, what
+    /// it knows has to be read again
+    pub async fn recv(&mut self) -> Option<Update> {
+        loop {
+            match self.receiver.recv().await {
+                Ok(update) => return Some(update),
+                Err(RecvError::Lagged(skipped)) => {
+                    tracing::debug!(skipped, project_id = ?self.project_id, "Live updates skipped");
+                    return None;
+                }
+                // the sender lives in CHANNELS as long as this subscription does
+                Err(RecvError::Closed) => std::future::pending::<()>().await,
+            }
+        }
+    }
+}
+
+impl Drop for Subscription {
+    fn drop(&mut self) {
+        let mut channels = CHANNELS.write().unwrap();
+        // this receiver still counts until the drop is done
+        if channels
+            .get(&self.project_id)
+            .is_some_and(|sender| sender.receiver_count() <= 1)
+        {
+            channels.remove(&self.project_id);
+        }
+    }
+}
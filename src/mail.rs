@@ -0,0 +1,630 @@
+//! Mail addons. An app with one gets an address `{app}@{domain}` and credentials for the smtp
+//! relay of the platform, which hands its mail to the upstream of `mail`. Students don't have
+//! to put the password of their own mailbox in an app to send a verification link
+//!
+//! The relay only takes mail from the address of the app, up to a daily quota of recipients,
+//! and refuses recipients on the suppression list of the app. Recipients the upstream rejects
+//! for good are put on it, so an app that keeps mailing a dead address doesn't burn the
+//! reputation of the domain. Every message is logged without its content in `mail_messages`
+//!
+//! The relay speaks plain smtp with AUTH PLAIN and LOGIN. It is meant for the networks of
+//! the hosts, apps reach it on the address of `host`
+
+use std::sync::{Arc, RwLock};
+use std::time::Duration;
+
+use anyhow::{anyhow, Result};
+use data_encoding::BASE64;
+use lazy_static::lazy_static;
+use lettre::address::Envelope;
+use lettre::{Address, AsyncSmtpTransport, AsyncTransport, Tokio1Executor};
+use rand::Rng;
+use secrecy::ExposeSecret;
+use sha2::{Digest, Sha256};
+use sqlx::PgPool;
+use tokio::io::{AsyncBufRead, AsyncBufReadExt, AsyncReadExt, AsyncWrite, AsyncWriteExt, BufReader};
+use tokio::net::{TcpListener, TcpStream};
+use ulid::Ulid;
+use url::Url;
+use uuid::Uuid;
+
+use crate::configuration::MailSettings;
+
+/// a client saying nothing for this long is hung up on
+const COMMAND_TIMEOUT: Duration = Duration::from_secs(300);
+/// longest command line, generous for the addresses of AUTH and RCPT
+const LINE_LIMIT: u64 = 4096;
+/// how often sent mail past the retention is deleted
+const PRUNE_INTERVAL: Duration = Duration::from_secs(60 * 60);
+
+const CHARSET: &[u8] = b"ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789";
+
+lazy_static! {
+    static ref SETTINGS: RwLock<Option<MailSettings>> = RwLock::new(None);
+}
+
+/// Checks `mail` and keeps it for the addons. Called once when the platform starts, without
+/// an upstream apps can't get an address
+pub fn init(settings: &MailSettings) -> Result<()> {
+    let Some(upstream) = &settings.upstream else {
+        *SETTINGS.write().unwrap() = None;
+        return Ok(());
+    };
+
+    let url = Url::parse(upstream.expose_secret()).map_err(|err| anyhow!("Invalid mail upstream: {err}"))?;
+    if !matches!(url.scheme(), "smtp" | "smtps") || url.host_str().is_none() {
+        return Err(anyhow!("Invalid mail upstream: expected an smtp or smtps url"));
+    }
+    if settings.domain.is_empty() || settings.host.is_empty() {
+        return Err(anyhow!("Mail needs the domain apps send from and the host they connect to"));
+    }
+    if settings.dailyquota < 1 || settings.maxrecipients < 1 {
+        return Err(anyhow!("Mail dailyquota and maxrecipients have to be at least 1"));
+    }
+
+    *SETTINGS.write().unwrap() = Some(settings.clone());
+    Ok(())
+}
+
+pub fn enabled() -> bool {
+    SETTINGS.read().unwrap().is_some()
+}
+
+fn settings() -> Result<MailSettings> {
+    SETTINGS
+        .read()
+        .unwrap()
+        .clone()
+        .ok_or(anyhow!("Mail is not set up on this platform"))
+}
+
+/// Recipients an app may send to per day, its own quota or the one of the platform
+pub fn daily_quota(quota: Option<i32>) -> i64 {
+    let default = SETTINGS.read().unwrap().as_ref().map_or(0, |settings| settings.dailyquota);
+    quota.unwrap_or(default) as i64
+}
+
+/// Addresses and domains are lowercase, `Stu@Example.com` and `stu@example.com` are one
+/// recipient for the suppression list
+pub fn normalize_address(address: &str) -> Option<String> {
+    let address = address.trim().to_lowercase();
+    address.parse::<Address>().ok().map(|_| address)
+}
+
+/// Gives an app its address and credentials, nothing has to be made on the relay. Returns the
+/// address and the url of the relay with the credentials
+pub fn provision_mail(container_name: &str) -> Result<(String, String)> {
+    let settings = settings()?;
+    let username = container_name
+        .to_ascii_lowercase()
+        .chars()
+        .map(|c| if c.is_ascii_alphanumeric() { c } else { '-' })
+        .collect::<String>();
+    let address = format!("{username}@{}", settings.domain);
+
+    let mut rng = rand::thread_rng();
+    let password = (0..32)
+        .map(|_| CHARSET[rng.gen_range(0..CHARSET.len())] as char)
+        .collect::<String>();
+
+    let url = format!("smtp://{username}:{password}@{}:{}", settings.host, settings.port);
+    Ok((address, url))
+}
+
+/// Host of the relay in the url of an addon, the egress rules let the app reach it
+pub fn mail_host(url: &str) -> Option<String> {
+    Url::parse(url).ok()?.host_str().map(str::to_string)
+}
+
+/// The variables of the mail addon of a project, none without one
+pub async fn mail_environment(project_id: Uuid, pool: &PgPool) -> Result<Vec<String>> {
+    let addon = sqlx::query!(
+        "SELECT name, url FROM addons WHERE project_id = $1 AND kind = 'mail'",
+        project_id
+    )
+    .fetch_optional(pool)
+    .await?;
+    let Some(addon) = addon else {
+        return Ok(Vec::new());
+    };
+
+    let url = Url::parse(&addon.url)?;
+    Ok(vec![
+        format!("SMTP_URL={}", addon.url),
+        format!("SMTP_HOST={}", url.host_str().unwrap_or_default()),
+        format!("SMTP_PORT={}", url.port().unwrap_or(25)),
+        format!("SMTP_USERNAME={}", url.username()),
+        format!("SMTP_PASSWORD={}", url.password().unwrap_or_default()),
+        format!("MAIL_FROM={}", addon.name),
+    ])
+}
+
+/// Recipients of the mail the app sent today, in UTC
+pub async fn sent_today(project_id: Uuid, pool: &PgPool) -> Result<i64> {
+    let sent = sqlx::query!(
+        r#"SELECT COALESCE(SUM(recipients), 0)::bigint AS "sent!"
+           FROM mail_messages
+           WHERE project_id = $1 AND status = 'sent' AND created_at >= date_trunc('day', now())
+        "#,
+        project_id
+    )
+    .fetch_one(pool)
+    .await?;
+
+    Ok(sent.sent)
+}
+
+/// Puts an address on the suppression list of an app, one already on it keeps its reason
+pub async fn suppress(project_id: Uuid, address: &str, reason: &str, pool: &PgPool) -> Result<()> {
+    sqlx::query!(
+        r#"INSERT INTO mail_suppressions (project_id, address, reason)
+           VALUES ($1, $2, $3)
+           ON CONFLICT (project_id, address) DO NOTHING
+        "#,
+        project_id,
+        address,
+        reason
+    )
+    .execute(pool)
+    .await?;
+
+    Ok(())
+}
+
+/// Serves the relay until the platform stops. Every connection is a session of its own
+pub async fn run(pool: PgPool) -> Result<()> {
+    let settings = settings()?;
+    let upstream = settings
+        .upstream
+        .as_ref()
+        .map(|url| url.expose_secret().clone())
+        .unwrap_or_default();
+    let transport = Arc::new(AsyncSmtpTransport::<Tokio1Executor>::from_url(&upstream)?.build());
+    let listener = TcpListener::bind(("0.0.0.0", settings.port)).await?;
+
+    tokio::spawn(prune_messages(pool.clone(), settings.retention));
+
+    tracing::info!(port = settings.port, domain = %settings.domain, "Relaying mail of apps");
+    loop {
+        let (stream, peer) = match listener.accept().await {
+            Ok(accepted) => accepted,
+            Err(err) => {
+                // like running out of file descriptors, the next one may work
+                tracing::warn!(?err, "Can't accept mail connection");
+                tokio::time::sleep(Duration::from_millis(100)).await;
+                continue;
+            }
+        };
+
+        let session = Session {
+            pool: pool.clone(),
+            transport: transport.clone(),
+            settings: settings.clone(),
+            app: None,
+            sender: None,
+            recipients: Vec::new(),
+        };
+        tokio::spawn(async move {
+            if let Err(err) = session.serve(stream).await {
+                tracing::debug!(?err, %peer, "Mail session ended");
+            }
+        });
+    }
+}
+
+/// Deletes the log of sent mail past `retention` days, the quota only looks at today
+async fn prune_messages(pool: PgPool, retention: i32) {
+    loop {
+        if let Err(err) = sqlx::query!(
+            "DELETE FROM mail_messages WHERE created_at < now() - make_interval(days => $1)",
+            retention
+        )
+        .execute(&pool)
+        .await
+        {
+            tracing::error!(?err, "Can't prune mail messages: Failed to query database");
+        }
+        tokio::time::sleep(PRUNE_INTERVAL).await;
+    }
+}
+
+/// The app a session logged in as
+struct App {
+    project_id: Uuid,
+    address: String,
+    quota: i64,
+}
+
+struct Session {
+    pool: PgPool,
+    transport: Arc<AsyncSmtpTransport<Tokio1Executor>>,
+    settings: MailSettings,
+    app: Option<App>,
+    /// of the message being sent, set by MAIL
+    sender: Option<String>,
+    recipients: Vec<String>,
+}
+
+impl Session {
+    async fn serve(mut self, stream: TcpStream) -> Result<()> {
+        let (read, mut write) = stream.into_split();
+        let mut reader = BufReader::new(read);
+
+        reply(&mut write, &format!("220 {} ESMTP pemasak relay", self.settings.domain)).await?;
+        loop {
+            let Some(line) = read_line(&mut reader).await? else {
+                return Ok(());
+            };
+            let (verb, arg) = line.split_once(' ').unwrap_or((line.as_str(), ""));
+            let arg = arg.trim();
+
+            let response = match verb.to_ascii_uppercase().as_str() {
+                "EHLO" => {
+                    self.reset();
+                    format!(
+                        "250-{}\r\n250-AUTH PLAIN LOGIN\r\n250-SIZE {}\r\n250-8BITMIME\r\n250 ENHANCEDSTATUSCODES",
+                        self.settings.domain,
+                        self.settings.maxsize * 1024
+                    )
+                }
+                "HELO" => {
+                    self.reset();
+                    format!("250 {}", self.settings.domain)
+                }
+                "AUTH" => self.auth(arg, &mut reader, &mut write).await?,
+                "MAIL" => self.mail(arg),
+                "RCPT" => self.rcpt(arg).await,
+                "DATA" => self.data(&mut reader, &mut write).await?,
+                "RSET" => {
+                    self.reset();
+                    "250 2.0.0 OK".to_string()
+                }
+                "NOOP" => "250 2.0.0 OK".to_string(),
+                "QUIT" => {
+                    reply(&mut write, "221 2.0.0 Bye").await?;
+                    return Ok(());
+                }
+                _ => "502 5.5.2 Command not recognized".to_string(),
+            };
+            reply(&mut write, &response).await?;
+        }
+    }
+
+    fn reset(&mut self) {
+        self.sender = None;
+        self.recipients.clear();
+    }
+
+    async fn auth<R, W>(&mut self, arg: &str, reader: &mut R, write: &mut W) -> Result<String>
+    where
+        R: AsyncBufRead + Unpin,
+        W: AsyncWrite + Unpin,
+    {
+        if self.app.is_some() {
+            return Ok("503 5.5.1 Already authenticated".to_string());
+        }
+
+        let (mechanism, initial) = arg.split_once(' ').unwrap_or((arg, ""));
+        let credentials = match mechanism.to_ascii_uppercase().as_str() {
+            // authorization id, username and password separated by NUL
+            "PLAIN" => {
+                let response = match initial {
+                    "" => {
+                        reply(write, "334 ").await?;
+                        read_line(reader).await?.unwrap_or_default()
+                    }
+                    initial => initial.to_string(),
+                };
+                decode(&response).and_then(|decoded| {
+                    let mut parts = decoded.split('\0').skip(1);
+                    Some((parts.next()?.to_string(), parts.next()?.to_string()))
+                })
+            }
+            "LOGIN" => {
+                let username = match initial {
+                    "" => {
+                        reply(write, "334 VXNlcm5hbWU6").await?;
+                        read_line(reader).await?.unwrap_or_default()
+                    }
+                    initial => initial.to_string(),
+                };
+                reply(write, "334 UGFzc3dvcmQ6").await?;
+                let password = read_line(reader).await?.unwrap_or_default();
+                decode(&username).zip(decode(&password))
+            }
+            _ => return Ok("504 5.5.4 Unrecognized authentication type".to_string()),
+        };
+        let Some((username, password)) = credentials else {
+            return Ok("501 5.5.2 Invalid credentials encoding".to_string());
+        };
+
+        match self.authenticate(&username, &password).await {
+            Ok(Some(app)) => {
+                self.app = Some(app);
+                Ok("235 2.7.0 Authentication successful".to_string())
+            }
+            Ok(None) => {
+                // guessing passwords takes a second per try
+                tokio::time::sleep(Duration::from_secs(1)).await;
+                Ok("535 5.7.8 Authentication credentials invalid".to_string())
+            }
+            Err(err) => {
+                tracing::error!(?err, "Can't authenticate mail: Failed to query database");
+                Ok("454 4.7.0 Temporary authentication failure".to_string())
+            }
+        }
+    }
+
+    /// The username is the part of the address before the @, the whole address works too
+    async fn authenticate(&self, username: &str, password: &str) -> Result<Option<App>> {
+        let username = username.trim().to_lowercase();
+        let address = match username.contains('@') {
+            true => username,
+            false => format!("{username}@{}", self.settings.domain),
+        };
+
+        let addon = sqlx::query!(
+            "SELECT project_id, url, quota FROM addons WHERE kind = 'mail' AND name = $1",
+            address
+        )
+        .fetch_optional(&self.pool)
+        .await?;
+        let Some(addon) = addon else {
+            return Ok(None);
+        };
+
+        let stored = Url::parse(&addon.url)?.password().unwrap_or_default().to_string();
+        // comparing the digests takes as long wherever the passwords differ
+        if stored.is_empty() || Sha256::digest(stored.as_bytes()) != Sha256::digest(password.as_bytes()) {
+            return Ok(None);
+        }
+
+        Ok(Some(App {
+            project_id: addon.project_id,
+            address,
+            quota: daily_quota(addon.quota),
+        }))
+    }
+
+    fn mail(&mut self, arg: &str) -> String {
+        let Some(app) = &self.app else {
+            return "530 5.7.0 Authentication required".to_string();
+        };
+        let Some(sender) = path(arg, "FROM:") else {
+            return "501 5.5.4 Syntax: MAIL FROM:<address>".to_string();
+        };
+        if sender.to_lowercase() != app.address {
+            return format!("550 5.7.1 The sender has to be {}", app.address);
+        }
+
+        let address = app.address.clone();
+        self.reset();
+        self.sender = Some(address);
+        "250 2.1.0 OK".to_string()
+    }
+
+    async fn rcpt(&mut self, arg: &str) -> String {
+        let (Some(app), Some(_)) = (&self.app, &self.sender) else {
+            return "503 5.5.1 MAIL first".to_string();
+        };
+        let Some(recipient) = path(arg, "TO:").and_then(|path| normalize_address(&path)) else {
+            return "501 5.1.3 Invalid recipient address".to_string();
+        };
+        if self.recipients.len() >= self.settings.maxrecipients {
+            return format!("452 4.5.3 At most {} recipients per message", self.settings.maxrecipients);
+        }
+
+        let suppressed = sqlx::query!(
+            "SELECT address FROM mail_suppressions WHERE project_id = $1 AND address = $2",
+            app.project_id,
+            recipient
+        )
+        .fetch_optional(&self.pool)
+        .await;
+        let sent = sent_today(app.project_id, &self.pool).await;
+
+        match (suppressed, sent) {
+            (Ok(Some(_)), _) => format!("550 5.1.1 <{recipient}> is on the suppression list of the app"),
+            (Ok(None), Ok(sent)) if sent + self.recipients.len() as i64 >= app.quota => {
+                format!("452 4.5.3 The daily quota of {} recipients is reached", app.quota)
+            }
+            (Ok(None), Ok(_)) => {
+                self.recipients.push(recipient);
+                "250 2.1.5 OK".to_string()
+            }
+            (Err(err), _) => {
+                tracing::error!(?err, "Can't check recipient: Failed to query database");
+                "451 4.3.0 Try again later".to_string()
+            }
+            (_, Err(err)) => {
+                tracing::error!(?err, "Can't check recipient: Failed to query database");
+                "451 4.3.0 Try again later".to_string()
+            }
+        }
+    }
+
+    async fn data<R, W>(&mut self, reader: &mut R, write: &mut W) -> Result<String>
+    where
+        R: AsyncBufRead + Unpin,
+        W: AsyncWrite + Unpin,
+    {
+        let (Some(app), Some(sender)) = (&self.app, &self.sender) else {
+            return Ok("503 5.5.1 MAIL first".to_string());
+        };
+        if self.recipients.is_empty() {
+            return Ok("503 5.5.1 RCPT first".to_string());
+        }
+
+        reply(write, "354 End data with <CR><LF>.<CR><LF>").await?;
+        let limit = self.settings.maxsize * 1024;
+        let message = read_data(reader, limit).await?;
+
+        let response = match message {
+            None => format!("552 5.3.4 The message is larger than {} KiB", self.settings.maxsize),
+            Some(message) if !from_header(&message).is_some_and(|from| from.to_lowercase().contains(&app.address)) => {
+                format!("550 5.7.1 The From header has to be {}", app.address)
+            }
+            Some(message) => self.send(app, sender, &message).await,
+        };
+
+        self.reset();
+        Ok(response)
+    }
+
+    async fn send(&self, app: &App, sender: &str, message: &[u8]) -> String {
+        let envelope = Envelope::new(
+            sender.parse::<Address>().ok(),
+            self.recipients.iter().filter_map(|recipient| recipient.parse::<Address>().ok()).collect(),
+        );
+        let result = match envelope {
+            Ok(envelope) => self.transport.send_raw(&envelope, message).await.map_err(|err| {
+                let permanent = err.is_permanent();
+                (err.to_string(), permanent)
+            }),
+            Err(err) => Err((err.to_string(), true)),
+        };
+
+        let (status, error) = match &result {
+            Ok(_) => ("sent", None),
+            Err((error, _)) => ("failed", Some(error.clone())),
+        };
+        if let Err(err) = sqlx::query!(
+            r#"INSERT INTO mail_messages (id, project_id, sender, recipients, size, status, error)
+               VALUES ($1, $2, $3, $4, $5, $6, $7)
+            "#,
+            Uuid::from(Ulid::new()),
+            app.project_id,
+            sender,
+            self.recipients.len() as i32,
+            message.len() as i32,
+            status,
+            error
+        )
+        .execute(&self.pool)
+        .await
+        {
+            tracing::error!(?err, "Can't log mail: Failed to query database");
+        }
+
+        match result {
+            Ok(_) => "250 2.0.0 OK queued".to_string(),
+            Err((error, true)) => {
+                // with one recipient the rejection is about it, with more it can't be told
+                if let [recipient] = self.recipients.as_slice() {
+                    if let Err(err) = suppress(app.project_id, recipient, &error, &self.pool).await {
+                        tracing::error!(?err, "Can't suppress recipient: Failed to query database");
+                    }
+                }
+                tracing::info!(%error, "Upstream rejected mail");
+                format!("554 5.0.0 The upstream rejected the message: {}", error.replace(['\r', '\n'], " "))
+            }
+            Err((error, false)) => {
+                tracing::warn!(%error, "Can't relay mail: Upstream failed");
+                "451 4.4.0 The upstream is unavailable, try again later".to_string()
+            }
+        }
+    }
+}
+
+async fn reply<W: AsyncWrite + Unpin>(write: &mut W, response: &str) -> Result<()> {
+    write.write_all(response.as_bytes()).await?;
+    write.write_all(b"\r\n").await?;
+    write.flush().await?;
+    Ok(())
+}
+
+/// A command line without its line ending, None once the client hung up
+async fn read_line<R: AsyncBufRead + Unpin>(reader: &mut R) -> Result<Option<String>> {
+    let mut line = Vec::new();
+    let read = tokio::time::timeout(
+        COMMAND_TIMEOUT,
+        (&mut *reader).take(LINE_LIMIT).read_until(b'\n', &mut line),
+    )
+    .await
+    .map_err(|_| anyhow!("Timed out waiting for a command"))??;
+
+    if read == 0 {
+        return Ok(None);
+    }
+    if !line.ends_with(b"\n") {
+        return Err(anyhow!("Command longer than {LINE_LIMIT} bytes"));
+    }
+    Ok(Some(String::from_utf8_lossy(&line).trim_end_matches(['\r', '\n']).to_string()))
+}
+
+/// The message after DATA up to the line with a single dot, unstuffed. None when it is
+/// larger than `limit`, it is still read to the end so the session can go on
+async fn read_data<R: AsyncBufRead + Unpin>(reader: &mut R, limit: usize) -> Result<Option<Vec<u8>>> {
+    let mut message = Vec::new();
+    let mut too_large = false;
+    loop {
+        let mut line = Vec::new();
+        let read = tokio::time::timeout(
+            COMMAND_TIMEOUT,
+            (&mut *reader).take(limit as u64 + 2).read_until(b'\n', &mut line),
+        )
+        .await
+        .map_err(|_| anyhow!("Timed out waiting for the message"))??;
+
+        if read == 0 {
+            return Err(anyhow!("Connection closed during DATA"));
+        }
+        if line == b".\r\n" || line == b".\n" {
+            break;
+        }
+
+        let line = line.strip_prefix(b".").filter(|_| line.starts_with(b"..")).unwrap_or(&line[..]);
+        if message.len() + line.len() > limit {
+            too_large = true;
+        }
+        if !too_large {
+            message.extend_from_slice(line);
+        }
+    }
+
+    Ok((!too_large).then_some(message))
+}
+
+/// The address of `FROM:<address>` or `TO:<address>`, parameters after it are ignored
+fn path(arg: &str, keyword: &str) -> Option<String> {
+    let rest = arg
+        .get(..keyword.len())
+        .filter(|prefix| prefix.eq_ignore_ascii_case(keyword))
+        .map(|_| arg[keyword.len()..].trim_start())?;
+    let rest = rest.strip_prefix('<')?;
+    let (address, _) = rest.split_once('>')?;
+    Some(address.to_string())
+}
+
+/// The From header of a message, with folded lines joined
+fn from_header(message: &[u8]) -> Option<String> {
+    let headers = String::from_utf8_lossy(message);
+    let mut from: Option<String> = None;
+    for line in headers.lines() {
+        if line.is_empty() {
+            break;
+        }
+        match &mut from {
+            Some(value) if line.starts_with([' ', '\t']) => value.push_str(line),
+            Some(_) => break,
+            None => {
+                if let Some((name, value)) = line.split_once(':') {
+                    if name.trim().eq_ignore_ascii_case("from") {
+                        from = Some(value.trim().to_string());
+                    }
+                }
+            }
+        }
+    }
+    from
+}
+
+fn decode(value: &str) -> Option<String> {
+    if value == "*" {
+        return None;
+    }
+    BASE64
+        .decode(value.trim().as_bytes())
+        .ok()
+        .and_then(|decoded| String::from_utf8(decoded).ok())
+}
@@ -1,8 +1,37 @@
 use hyper::{client::HttpConnector, Body};
 use pemasak_infra::{
+    access_logs::{access_log_pruner, access_log_writer, AccessLogger},
+    auth::oidc::Oidc,
+    autoscaler::autoscaler,
+    backups::{backup_scheduler, BackupStorage},
+    balancer::{health_checker, Balancer},
+    basic_auth::BasicAuthCache,
+    cache::ResponseCache,
+    cleanup::sweeper,
     configuration,
+    container_logs::container_log_pruner,
+    crashloop::CrashLoops,
+    cron::cron_scheduler,
+    docker::{setup_builder, setup_emulation},
+    drains::drain_forwarder,
+    egress::{self, egress_enforcer},
+    idle::{idler, IdleTracker},
+    lfs::LfsStorage,
+    mail,
+    metrics::metrics_collector,
+    nodes::{load_placements, node_watcher},
+    notifications::{crash_watcher, Notifier},
+    orchestrator, ports,
+    previews::preview_reaper,
     queue::{build_queue_handler, BuildQueue},
-    startup, telemetry,
+    rate_limits::RateLimiter,
+    reconciler::reconciler,
+    registry::image_collector,
+    runtime, scanning,
+    secrets::SecretCipher,
+    sites,
+    snapshots::snapshot_scheduler,
+    ssh, startup, storage, telemetry,
 };
 use sqlx::postgres::PgPoolOptions;
 use std::{net::TcpListener, path::Path, process};
@@ -62,8 +91,13 @@ async fn main() {
         }
     }
 
-    // check docker permissions
-    if let Err(err) = tokio::fs::metadata("/var/run/docker.sock").await {
+    if let Err(err) = egress::check_settings(&config.container) {
+        tracing::error!(?err, "Invalid egress settings");
+        process::exit(1);
+    }
+
+    // check docker permissions, on the socket of the runtime of the host
+    if let Err(err) = runtime::use_runtime(&config.container).await {
         tracing::error!(?err, "Failed to access docker socket");
         process::exit(1);
     }
@@ -106,23 +140,370 @@ async fn main() {
         }
     }
 
-    let (build_queue, build_channel) = BuildQueue::new(config.build.max, pool.clone());
+    let secrets = match SecretCipher::new(config.application.secretkey.as_ref()) {
+        Ok(secrets) => secrets,
+        Err(err) => {
+            tracing::error!(?err, "Failed to read secret key");
+            process::exit(1);
+        }
+    };
+
+    if !secrets.enabled() {
+        tracing::warn!("No secret key configured, project secrets are disabled");
+    }
+
+    let backups = match BackupStorage::new(&config.backup) {
+        Ok(backups) => backups,
+        Err(err) => {
+            tracing::error!(?err, "Failed to read backup settings");
+            process::exit(1);
+        }
+    };
+
+    if backups.enabled() {
+        let pool = pool.clone();
+        let backups = backups.clone();
+        let schedule = config.backup.schedule.clone();
+
+        tokio::spawn(async move {
+            backup_scheduler(pool, backups, schedule).await;
+        });
+
+        let settings = config.clone();
+        let backups = backups.clone();
+        tokio::spawn(async move {
+            snapshot_scheduler(settings, backups).await;
+        });
+    } else {
+        tracing::warn!("No backup bucket configured, database backups and platform snapshots are disabled");
+    }
+
+    let lfs = match LfsStorage::new(&config.lfs) {
+        Ok(lfs) => lfs,
+        Err(err) => {
+            tracing::error!(?err, "Failed to read lfs settings");
+            process::exit(1);
+        }
+    };
+
+    if !lfs.enabled() {
+        tracing::warn!("No lfs bucket configured, Git LFS is disabled");
+    }
+
+    if let Err(err) = storage::init(&config.storage) {
+        tracing::error!(?err, "Failed to read storage settings");
+        process::exit(1);
+    }
+    if !storage::enabled() {
+        tracing::warn!("No storage endpoint configured, s3 addons are disabled");
+    }
+
+    if let Err(err) = mail::init(&config.mail) {
+        tracing::error!(?err, "Failed to read mail settings");
+        process::exit(1);
+    }
+    if !mail::enabled() {
+        tracing::warn!("No mail upstream configured, mail addons are disabled");
+    }
+
+    if let Err(err) = ports::init(&config.ports, &config.domain()) {
+        tracing::error!(?err, "Failed to read port settings");
+        process::exit(1);
+    }
+    if !ports::enabled() {
+        tracing::warn!("No port range configured, apps can't lease ports");
+    }
+
+    let notifier = match Notifier::new(&config.domain(), config.application.secure) {
+        Ok(notifier) => notifier,
+        Err(err) => {
+            tracing::error!(?err, "Failed to create notification client");
+            process::exit(1);
+        }
+    };
+
+    let oidc = match Oidc::new(&config.oidc, &config.domain(), config.application.secure) {
+        Ok(oidc) => oidc,
+        Err(err) => {
+            tracing::error!(?err, "Failed to create sso client");
+            process::exit(1);
+        }
+    };
+
+    if oidc.as_ref().is_some_and(|oidc| !oidc.passwords()) {
+        tracing::info!("Password logins are disabled, users log in with sso");
+    }
+
+    // builds for nodes of another architecture fail at their first step without it
+    if let Err(err) = setup_emulation(&config.build).await {
+        tracing::warn!(?err, "Can't build for other architectures: Failed to install emulators");
+    }
+
+    // without the builder builds run on the one of docker, only the timeout limits them
+    if let Err(err) = setup_builder(&config.build).await {
+        tracing::warn!(?err, "Can't limit cpu and memory of builds: Failed to create builder");
+    }
 
+    // images are scanned before they run once trivy is set
+    scanning::init(&config.build);
+    sites::init(&config.build);
+
+    // the web and worker processes of apps run on docker or in a cluster
+    if let Err(err) = orchestrator::init(&config) {
+        tracing::error!(?err, "Failed to set up orchestrator");
+        process::exit(1);
+    }
+
+    // containers of apps on a node are reached through its agent, see pemasak_infra::nodes
+    if let Err(err) = load_placements(&pool).await {
+        tracing::error!(?err, "Failed to read where apps run");
+        process::exit(1);
+    }
+
+    {
+        let pool = pool.clone();
+        let container_settings = config.container.clone();
+
+        tokio::spawn(async move {
+            node_watcher(pool, container_settings).await;
+        });
+    }
+
+    let (build_queue, build_channel) = BuildQueue::new(
+        config.build.max,
+        config.build.perowner,
+        std::time::Duration::from_millis(config.build.timeout),
+        pool.clone(),
+        config.container.clone(),
+        config.quota.clone(),
+        secrets.clone(),
+        notifier.clone(),
+        lfs.clone(),
+    );
+
+    let build_queue_state = build_queue.state.clone();
     tokio::spawn(async move {
         build_queue_handler(build_queue).await;
     });
 
+    // after a reboot builds, containers and the database disagree, see pemasak_infra::reconciler
+    {
+        let pool = pool.clone();
+        let build_queue = build_queue_state.clone();
+        let build_channel = build_channel.clone();
+        let base = config.git.base.clone();
+        let container_settings = config.container.clone();
+
+        tokio::spawn(async move {
+            reconciler(pool, build_queue, build_channel, base, container_settings).await;
+        });
+    }
+
+    {
+        let pool = pool.clone();
+        let container_settings = config.container.clone();
+        let secrets = secrets.clone();
+
+        tokio::spawn(async move {
+            cron_scheduler(pool, container_settings, secrets).await;
+        });
+    }
+
+    {
+        let pool = pool.clone();
+
+        tokio::spawn(async move {
+            drain_forwarder(pool).await;
+        });
+    }
+
+    {
+        let pool = pool.clone();
+        let container_settings = config.container.clone();
+
+        tokio::spawn(async move {
+            container_log_pruner(pool, container_settings).await;
+        });
+    }
+
+    {
+        let pool = pool.clone();
+        let container_settings = config.container.clone();
+
+        tokio::spawn(async move {
+            egress_enforcer(pool, container_settings).await;
+        });
+    }
+
+    {
+        let pool = pool.clone();
+
+        tokio::spawn(async move {
+            preview_reaper(pool).await;
+        });
+    }
+
+    if mail::enabled() {
+        let pool = pool.clone();
+
+        tokio::spawn(async move {
+            if let Err(err) = mail::run(pool).await {
+                tracing::error!(?err, "Mail relay stopped");
+            }
+        });
+    }
+
+    {
+        let pool = pool.clone();
+        let notifier = notifier.clone();
+        let crash_loops = CrashLoops::new(pool.clone(), &config.container);
+
+        tokio::spawn(async move {
+            crash_watcher(pool, notifier, crash_loops).await;
+        });
+    }
+
+    {
+        let pool = pool.clone();
+        let container_settings = config.container.clone();
+
+        tokio::spawn(async move {
+            metrics_collector(pool, container_settings).await;
+        });
+    }
+
+    {
+        let pool = pool.clone();
+        let container_settings = config.container.clone();
+        let secrets = secrets.clone();
+
+        tokio::spawn(async move {
+            autoscaler(pool, container_settings, secrets).await;
+        });
+    }
+
+    if config.container.registry.is_none() {
+        tracing::warn!("No registry configured, releases only live on this host");
+    }
+
+    {
+        let pool = pool.clone();
+        let container_settings = config.container.clone();
+
+        tokio::spawn(async move {
+            image_collector(pool, container_settings).await;
+        });
+    }
+
+    let (access_logs, access_log_lines) = AccessLogger::new();
+    {
+        let pool = pool.clone();
+
+        tokio::spawn(async move {
+            access_log_writer(pool, access_log_lines).await;
+        });
+    }
+
+    {
+        let pool = pool.clone();
+        let container_settings = config.container.clone();
+
+        tokio::spawn(async move {
+            access_log_pruner(pool, container_settings).await;
+        });
+    }
+
+    let balancer = Balancer::new();
+    {
+        let pool = pool.clone();
+        let balancer = balancer.clone();
+        let container_settings = config.container.clone();
+
+        tokio::spawn(async move {
+            health_checker(pool, balancer, container_settings).await;
+        });
+    }
+
+    let idle = IdleTracker::new();
+    {
+        let pool = pool.clone();
+        let idle = idle.clone();
+        let container_settings = config.container.clone();
+
+        tokio::spawn(async move {
+            idler(pool, idle, container_settings).await;
+        });
+    }
+
+    {
+        let pool = pool.clone();
+        let idle = idle.clone();
+        let notifier = notifier.clone();
+        let backups = backups.clone();
+        let base = config.git.base.clone();
+        let cleanup_settings = config.cleanup.clone();
+        let container_settings = config.container.clone();
+
+        tokio::spawn(async move {
+            sweeper(pool, idle, notifier, backups, base, cleanup_settings, container_settings).await;
+        });
+    }
+
+    if ports::enabled() {
+        let pool = pool.clone();
+        let balancer = balancer.clone();
+        let idle = idle.clone();
+
+        tokio::spawn(async move {
+            if let Err(err) = ports::run(pool, balancer, idle).await {
+                tracing::error!(?err, "Port listeners stopped");
+            }
+        });
+    }
+
     let state = startup::AppState {
         base: config.git.base.clone(),
         git_auth: config.git.auth,
         sso: config.auth.sso.clone(),
+        oidc,
         client: Client::new(),
+        h2c_client: Client::builder().http2_only(true).build_http(),
         domain: config.domain(),
         build_channel,
+        build_queue: build_queue_state,
         pool,
         secure: config.application.secure,
+        secrets,
+        notifier,
+        backups,
+        lfs,
+        balancer,
+        idle,
+        rate_limiter: RateLimiter::default(),
+        basic_auth: BasicAuthCache::default(),
+        cache: ResponseCache::new((config.container.cachesize * 1024 * 1024) as usize),
+        access_logs,
+        metrics_token: config.application.metricstoken.clone(),
+        container_settings: config.container.clone(),
+        quota_settings: config.quota.clone(),
+        git_settings: config.git.clone(),
+        auth_settings: config.auth.clone(),
+        cleanup_settings: config.cleanup.clone(),
     };
 
+    if config.git.sshport != 0 {
+        let state = state.clone();
+        let host = config.application.host.clone();
+        let git_settings = config.git.clone();
+        let body_limit = config.body_limit();
+
+        tokio::spawn(async move {
+            if let Err(err) = ssh::run(state, host, git_settings, body_limit).await {
+                tracing::error!(?err, "Failed to serve git over ssh, deploy keys won't work");
+            }
+        });
+    }
+
     let addr_string = config.address_string();
 
     let addr = match config.address() {
@@ -0,0 +1,336 @@
+use std::collections::{BTreeMap, HashSet};
+use std::fs;
+use std::path::Path;
+
+use anyhow::{anyhow, Result};
+use serde::{Deserialize, Serialize};
+use sqlx::PgPool;
+use ulid::Ulid;
+use uuid::Uuid;
+
+use crate::configuration::ContainerSettings;
+use crate::docker::{provision_postgres, remove_postgres, ReleaseConfig};
+use crate::header_rules::HeaderRules;
+use crate::mail::provision_mail;
+use crate::monorepo::repo_path_valid;
+use crate::redis::{provision_redis, remove_redis};
+use crate::sites::StaticSite;
+use crate::storage::{provision_bucket, remove_bucket};
+
+pub const MANIFEST_FILE: &str = "pemasak.toml";
+
+/// Addons a manifest can ask for, the values of the addon_kind type
+const ADDONS: [&str; 4] = ["postgres", "s3", "redis", "mail"];
+
+/// the same limit `pmk scale` has
+pub const MAX_SCALE: i64 = 10;
+
+/// Settings of an app written down next to its code in pemasak.toml. Everything is optional,
+/// what is left out stays as it was set through the api. Bundles carry one too, see
+/// [`crate::bundles`]
+#[derive(Serialize, Deserialize, Debug, Default)]
+#[serde(deny_unknown_fields)]
+pub struct Manifest {
+    /// commands by process type like a Procfile, they win over the Procfile
+    #[serde(default, skip_serializing_if = "BTreeMap::is_empty")]
+    pub processes: BTreeMap<String, String>,
+    /// path of the readiness probe
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub healthcheck: Option<String>,
+    /// variables the app reads, checked against the environment on every deploy
+    #[serde(default, skip_serializing_if = "BTreeMap::is_empty")]
+    pub env: BTreeMap<String, EnvVar>,
+    /// addons the app needs, created on deploy when missing. Addons it stops listing are
+    /// kept, removing one deletes its data
+    #[serde(default, skip_serializing_if = "Vec::is_empty")]
+    pub addons: Vec<String>,
+    /// containers per process type
+    #[serde(default, skip_serializing_if = "BTreeMap::is_empty")]
+    pub scale: BTreeMap<String, i64>,
+    /// apps built from directories of the repository and deployed together on every push,
+    /// only read from the root of the repository
+    #[serde(default, skip_serializing_if = "BTreeMap::is_empty")]
+    pub services: BTreeMap<String, Service>,
+    /// headers the proxy changes on requests and responses, they replace the ones set
+    /// through the api
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub headers: Option<HeaderRules>,
+    /// the build only makes files, the proxy serves them and no container runs
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub site: Option<StaticSite>,
+}
+
+#[derive(Serialize, Deserialize, Debug)]
+#[serde(deny_unknown_fields)]
+pub struct Service {
+    /// directory it is built from, a pemasak.toml there configures the service
+    pub source: String,
+    /// only the other services reach it, the proxy doesn't serve it
+    #[serde(default)]
+    pub internal: bool,
+}
+
+#[derive(Serialize, Deserialize, Debug, Default)]
+#[serde(deny_unknown_fields)]
+pub struct EnvVar {
+    /// the deploy fails while it isn't set
+    #[serde(default)]
+    pub required: bool,
+    /// used when it isn't set, never stored in the project
+    pub default: Option<String>,
+    pub description: Option<String>,
+}
+
+impl Manifest {
+    /// Reads pemasak.toml at the root of the source, None when there is none. A manifest
+    /// that doesn't parse or asks for something impossible fails the build
+    pub fn load(src: &Path) -> Result<Option<Self>> {
+        let content = match fs::read_to_string(src.join(MANIFEST_FILE)) {
+            Ok(content) => content,
+            Err(err) if err.kind() == std::io::ErrorKind::NotFound => return Ok(None),
+            Err(err) => return Err(err.into()),
+        };
+
+        let manifest: Manifest = toml::from_str(&content)
+            .map_err(|err| anyhow!("Invalid {MANIFEST_FILE}: {err}"))?;
+        manifest.validate()?;
+        Ok(Some(manifest))
+    }
+
+    pub fn validate(&self) -> Result<()> {
+        if let Some(path) = &self.healthcheck {
+            if !path.starts_with('/') {
+                return Err(anyhow!("Invalid {MANIFEST_FILE}: healthcheck must start with /"));
+            }
+        }
+
+        if let Some(addon) = self.addons.iter().find(|addon| !ADDONS.contains(&addon.as_str())) {
+            return Err(anyhow!(
+                "Invalid {MANIFEST_FILE}: unknown addon {addon}, expected one of {}",
+                ADDONS.join(", ")
+            ));
+        }
+
+        for (process, count) in &self.scale {
+            let min = match process.as_str() {
+                "web" => 1,
+                _ => 0,
+            };
+            if !(min..=MAX_SCALE).contains(count) {
+                return Err(anyhow!(
+                    "Invalid {MANIFEST_FILE}: scale of {process} must be between {min} and {MAX_SCALE}"
+                ));
+            }
+        }
+
+        for (name, service) in &self.services {
+            // the name becomes part of a project and container name and a hostname
+            if name.is_empty()
+                || name.len() > 30
+                || name.starts_with('-')
+                || !name.chars().all(|c| c.is_ascii_lowercase() || c.is_ascii_digit() || c == '-')
+            {
+                return Err(anyhow!(
+                    "Invalid {MANIFEST_FILE}: service name {name} can only have lowercase letters, digits and -"
+                ));
+            }
+            if !repo_path_valid(&service.source) {
+                return Err(anyhow!(
+                    "Invalid {MANIFEST_FILE}: source of service {name} must be a directory inside the repository"
+                ));
+            }
+        }
+
+        if let Some(headers) = &self.headers {
+            headers
+                .validate()
+                .map_err(|err| anyhow!("Invalid {MANIFEST_FILE}: {err}"))?;
+        }
+
+        if let Some(site) = &self.site {
+            // docker reads the archive of a path from the root of the image
+            if !site.dir.starts_with('/') || site.dir.split('/').any(|part| part == "..") {
+                return Err(anyhow!("Invalid {MANIFEST_FILE}: site dir must be an absolute path in the image, like /app/dist"));
+            }
+            if !self.processes.is_empty() || !self.scale.is_empty() {
+                return Err(anyhow!("Invalid {MANIFEST_FILE}: a site runs no processes, remove processes and scale"));
+            }
+        }
+
+        Ok(())
+    }
+
+    /// Fills in the defaults of variables the environment is missing and fails listing the
+    /// required ones nobody set. Secrets count as set
+    pub fn apply_env(&self, release_config: &mut ReleaseConfig) -> Result<()> {
+        let set = release_config
+            .env
+            .iter()
+            .filter_map(|var| var.split_once('=').map(|(name, _)| name.to_string()))
+            .chain(release_config.secrets.keys().cloned())
+            .collect::<HashSet<_>>();
+
+        let mut missing = vec![];
+        for (name, var) in &self.env {
+            if set.contains(name) {
+                continue;
+            }
+            match (&var.default, var.required) {
+                (Some(default), _) => release_config.env.push(format!("{name}={default}")),
+                (None, true) => missing.push(match &var.description {
+                    Some(description) => format!("{name} ({description})"),
+                    None => name.clone(),
+                }),
+                (None, false) => {}
+            }
+        }
+
+        match missing.is_empty() {
+            true => Ok(()),
+            false => Err(anyhow!(
+                "{MANIFEST_FILE} requires environment variables that aren't set: {}",
+                missing.join(", ")
+            )),
+        }
+    }
+
+    /// Brings the stored settings of the project to what the manifest says: health check,
+    /// header rules, addons and the formation. Process types with an autoscaler are left to it. Returns
+    /// what changed, for the build log
+    pub async fn reconcile(
+        &self,
+        project_id: Uuid,
+        container_name: &str,
+        container_settings: &ContainerSettings,
+        pool: &PgPool,
+    ) -> Result<Vec<String>> {
+        let mut changes = vec![];
+
+        let project = sqlx::query!(
+            "SELECT healthcheck_path, formation, header_rules FROM projects WHERE id = $1",
+            project_id
+        )
+        .fetch_one(pool)
+        .await?;
+
+        if let Some(path) = &self.healthcheck {
+            if project.healthcheck_path.as_ref() != Some(path) {
+                sqlx::query!(
+                    "UPDATE projects SET healthcheck_path = $1, updated_at = now() WHERE id = $2",
+                    path,
+                    project_id
+                )
+                .execute(pool)
+                .await?;
+                changes.push(format!("healthcheck {path}"));
+            }
+        }
+
+        if let Some(headers) = &self.headers {
+            let stored: HeaderRules = serde_json::from_value(project.header_rules).unwrap_or_default();
+            if &stored != headers {
+                sqlx::query!(
+                    "UPDATE projects SET header_rules = $1, updated_at = now() WHERE id = $2",
+                    serde_json::to_value(headers)?,
+                    project_id
+                )
+                .execute(pool)
+                .await?;
+                changes.push("headers".to_string());
+            }
+        }
+
+        for addon in &self.addons {
+            let exists = sqlx::query!(
+                "SELECT id FROM addons WHERE project_id = $1 AND kind::text = $2",
+                project_id,
+                addon
+            )
+            .fetch_optional(pool)
+            .await?
+            .is_some();
+            if exists {
+                continue;
+            }
+
+            let (name, url, connection_limit, quota) = match addon.as_str() {
+                "s3" => {
+                    let (name, url, quota) = provision_bucket(container_name).await?;
+                    (name, url, 0, Some(quota))
+                }
+                "redis" => {
+                    let memory = container_settings.redismemory;
+                    let (name, url) = provision_redis(container_name, memory, container_settings).await?;
+                    (name, url, 0, Some(memory))
+                }
+                "mail" => {
+                    let (address, url) = provision_mail(container_name)?;
+                    (address, url, 0, None)
+                }
+                _ => {
+                    let connection_limit = container_settings.dbconnections;
+                    let (name, url) = provision_postgres(container_name, connection_limit).await?;
+                    (name, url, connection_limit, None)
+                }
+            };
+            if let Err(err) = sqlx::query!(
+                r#"INSERT INTO addons (id, project_id, kind, name, url, connection_limit, quota)
+                   VALUES ($1, $2, $3::text::addon_kind, $4, $5, $6, $7)
+                "#,
+                Uuid::from(Ulid::new()),
+                project_id,
+                addon,
+                name,
+                url,
+                connection_limit,
+                quota
+            )
+            .execute(pool)
+            .await
+            {
+                // nothing tracks the container or bucket without its row
+                let removed = match addon.as_str() {
+                    "s3" => remove_bucket(container_name, &url).await,
+                    "redis" => remove_redis(container_name).await,
+                    "mail" => Ok(()),
+                    _ => remove_postgres(container_name).await,
+                };
+                if let Err(err) = removed {
+                    tracing::error!(?err, "Can't clean up addon: Failed to remove {addon}");
+                }
+                return Err(err.into());
+            }
+            changes.push(format!("added {addon}"));
+        }
+
+        let formation: BTreeMap<String, i64> =
+            serde_json::from_value(project.formation).unwrap_or_default();
+        let autoscaled = sqlx::query!("SELECT process FROM autoscalers WHERE project_id = $1", project_id)
+            .fetch_all(pool)
+            .await?
+            .into_iter()
+            .map(|autoscaler| autoscaler.process)
+            .collect::<HashSet<_>>();
+
+        // process types missing from the formation run one container
+        let scale = self
+            .scale
+            .iter()
+            .filter(|(process, _)| !autoscaled.contains(*process))
+            .filter(|(process, count)| formation.get(*process).copied().unwrap_or(1) != **count)
+            .collect::<BTreeMap<_, _>>();
+
+        if !scale.is_empty() {
+            sqlx::query!(
+                "UPDATE projects SET formation = formation || $1, updated_at = now() WHERE id = $2",
+                serde_json::to_value(&scale)?,
+                project_id
+            )
+            .execute(pool)
+            .await?;
+            changes.extend(scale.iter().map(|(process, count)| format!("scale {process}={count}")));
+        }
+
+        Ok(changes)
+    }
+}
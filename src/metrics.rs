@@ -0,0 +1,225 @@
+use std::collections::{HashMap, HashSet};
+use std::time::Duration;
+
+use anyhow::Result;
+use bollard::container::{InspectContainerOptions, StatsOptions};
+use bollard::Docker;
+use futures::StreamExt;
+use sqlx::PgPool;
+use tokio::time::Instant;
+use uuid::Uuid;
+
+use crate::configuration::ContainerSettings;
+use crate::docker::{project_containers, ProjectContainer};
+use crate::monitoring::{CONTAINER_OOM_KILLED, CONTAINER_RESTARTS};
+use crate::nodes;
+use crate::usage::{meter, Metered};
+
+/// containers sampled at once, every sample waits a moment for docker to measure cpu
+const CONCURRENT_SAMPLES: usize = 16;
+
+const GIB: f64 = 1024.0 * 1024.0 * 1024.0;
+
+struct Sample {
+    cpu_percent: f64,
+    memory_bytes: i64,
+    memory_limit: i64,
+    /// totals since the container started
+    rx_bytes: u64,
+    tx_bytes: u64,
+    restarts: i32,
+    oom_killed: bool,
+    taken_at: Instant,
+}
+
+/// Samples cpu, memory, network and restarts of every web and worker container on an
+/// interval, so owners can see what their app was doing before it got killed. What was used
+/// between two samples of a container is metered to its app
+pub async fn metrics_collector(pool: PgPool, container_settings: ContainerSettings) {
+    // network counters of the last sample per container id, rates are taken between samples
+    let mut previous: HashMap<String, (Instant, u64, u64)> = HashMap::new();
+
+    let mut interval = tokio::time::interval(Duration::from_secs(container_settings.metricsinterval));
+    loop {
+        interval.tick().await;
+
+        let projects = match sqlx::query!("SELECT project_id, name FROM domains")
+            .fetch_all(&pool)
+            .await
+        {
+            Ok(projects) => projects,
+            Err(err) => {
+                tracing::error!(?err, "Can't collect metrics: Failed to query database");
+                continue;
+            }
+        };
+
+        let mut containers: Vec<(Uuid, String, ProjectContainer)> = Vec::new();
+        for project in projects {
+            match project_containers(&project.name).await {
+                Ok(found) => containers.extend(
+                    found
+                        .into_iter()
+                        .map(|container| (project.project_id, project.name.clone(), container)),
+                ),
+                Err(err) => tracing::error!(?err, "Can't collect metrics: Failed to list containers"),
+            }
+        }
+
+        let samples = futures::stream::iter(containers)
+            .map(|(project_id, app, container)| {
+                async move {
+                    let sample = match nodes::docker(&app) {
+                        Ok(docker) => sample(&docker, &container.id).await,
+                        Err(err) => Err(err.into()),
+                    };
+                    (project_id, app, container, sample)
+                }
+            })
+            .buffer_unordered(CONCURRENT_SAMPLES)
+            .collect::<Vec<_>>()
+            .await;
+
+        let mut seen = HashSet::new();
+        let mut exported = Vec::new();
+        let mut metered: HashMap<Uuid, Metered> = HashMap::new();
+        for (project_id, app, container, sample) in samples {
+            let sample = match sample {
+                Ok(sample) => sample,
+                Err(err) => {
+                    // containers that stopped between listing and sampling end up here
+                    tracing::debug!(?err, container = %container.name, "Can't sample container");
+                    continue;
+                }
+            };
+            seen.insert(container.id.clone());
+            exported.push((
+                app,
+                container.name.clone(),
+                container.process.clone(),
+                sample.restarts,
+                sample.oom_killed,
+            ));
+
+            let (network_rx, network_tx) = match previous.insert(
+                container.id.clone(),
+                (sample.taken_at, sample.rx_bytes, sample.tx_bytes),
+            ) {
+                Some((taken_at, rx_bytes, tx_bytes)) => {
+                    let seconds = (sample.taken_at - taken_at).as_secs_f64();
+                    let used = metered.entry(project_id).or_default();
+                    used.cpu_seconds += sample.cpu_percent / 100.0 * seconds;
+                    used.memory_gib_hours += sample.memory_bytes as f64 / GIB * seconds / 3600.0;
+                    used.egress_bytes += sample.tx_bytes.saturating_sub(tx_bytes) as i64;
+
+                    match seconds > 0.0 {
+                        true => (
+                            sample.rx_bytes.saturating_sub(rx_bytes) as f64 / seconds,
+                            sample.tx_bytes.saturating_sub(tx_bytes) as f64 / seconds,
+                        ),
+                        false => (0.0, 0.0),
+                    }
+                }
+                None => (0.0, 0.0),
+            };
+
+            if let Err(err) = sqlx::query!(
+                r#"INSERT INTO container_metrics
+                   (project_id, container, process, cpu_percent, memory_bytes, memory_limit, network_rx, network_tx, restarts, oom_killed)
+                   VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+                "#,
+                project_id,
+                container.name,
+                container.process,
+                sample.cpu_percent,
+                sample.memory_bytes,
+                sample.memory_limit,
+                network_rx,
+                network_tx,
+                sample.restarts,
+                sample.oom_killed
+            )
+            .execute(&pool)
+            .await
+            {
+                tracing::error!(?err, "Can't store metrics: Failed to query database");
+            }
+        }
+
+        previous.retain(|id, _| seen.contains(id));
+
+        for (project_id, used) in metered {
+            if let Err(err) = meter(project_id, &used, &pool).await {
+                tracing::error!(?err, "Can't meter usage: Failed to query database");
+            }
+        }
+
+        // the exporter only shows containers of the latest sample, swapped without an await
+        // in between so a scrape never sees half of them
+        CONTAINER_RESTARTS.reset();
+        CONTAINER_OOM_KILLED.reset();
+        for (app, name, process, restarts, oom_killed) in exported {
+            let labels = [app.as_str(), name.as_str(), process.as_str()];
+            CONTAINER_RESTARTS.with_label_values(&labels).set(restarts as i64);
+            CONTAINER_OOM_KILLED.with_label_values(&labels).set(oom_killed as i64);
+        }
+
+        if let Err(err) = sqlx::query!(
+            "DELETE FROM container_metrics WHERE recorded_at < now() - make_interval(hours => $1)",
+            container_settings.metricsretention
+        )
+        .execute(&pool)
+        .await
+        {
+            tracing::error!(?err, "Can't prune metrics: Failed to query database");
+        }
+    }
+}
+
+async fn sample(docker: &Docker, id: &str) -> Result<Sample> {
+    // without one_shot docker measures twice, which gives the cpu usage in between
+    let stats = docker
+        .stats(id, Some(StatsOptions { stream: false, one_shot: false }))
+        .next()
+        .await
+        .ok_or(anyhow::anyhow!("Docker returned no stats"))??;
+    let taken_at = Instant::now();
+
+    let cpu_delta = stats
+        .cpu_stats
+        .cpu_usage
+        .total_usage
+        .saturating_sub(stats.precpu_stats.cpu_usage.total_usage) as f64;
+    let system_delta = stats
+        .cpu_stats
+        .system_cpu_usage
+        .unwrap_or_default()
+        .saturating_sub(stats.precpu_stats.system_cpu_usage.unwrap_or_default()) as f64;
+    let cpus = stats.cpu_stats.online_cpus.unwrap_or(1) as f64;
+
+    // percent of one cpu, like docker stats
+    let cpu_percent = match system_delta > 0.0 {
+        true => cpu_delta / system_delta * cpus * 100.0,
+        false => 0.0,
+    };
+
+    let networks = stats.networks.unwrap_or_default();
+    let (rx_bytes, tx_bytes) = networks
+        .values()
+        .fold((0, 0), |(rx, tx), network| (rx + network.rx_bytes, tx + network.tx_bytes));
+
+    let inspect = docker
+        .inspect_container(id, None::<InspectContainerOptions>)
+        .await?;
+
+    Ok(Sample {
+        cpu_percent,
+        memory_bytes: stats.memory_stats.usage.unwrap_or_default() as i64,
+        memory_limit: stats.memory_stats.limit.unwrap_or_default() as i64,
+        rx_bytes,
+        tx_bytes,
+        restarts: inspect.restart_count.unwrap_or_default() as i32,
+        oom_killed: inspect.state.and_then(|state| state.oom_killed).unwrap_or(false),
+        taken_at,
+    })
+}
@@ -0,0 +1,240 @@
+use axum::extract::State;
+use axum::headers::{authorization::Bearer, Authorization};
+use axum::TypedHeader;
+use hyper::{Body, Response, StatusCode};
+use lazy_static::lazy_static;
+use prometheus::core::Metric;
+use prometheus::proto::Histogram;
+use prometheus::{
+    register_histogram_vec, register_int_counter_vec, register_int_gauge,
+    register_int_gauge_vec, Encoder, HistogramVec, IntCounterVec, IntGauge, IntGaugeVec,
+    TextEncoder,
+};
+use secrecy::ExposeSecret;
+use serde::Serialize;
+
+use crate::startup::AppState;
+
+// metrics of the platform itself in the prometheus exposition format. per app metrics are
+// labelled with the app subdomain, which is also the name of its web container
+lazy_static! {
+    pub static ref BUILDS_QUEUED: IntGauge = register_int_gauge!(
+        "pemasak_builds_queued",
+        "Builds waiting for a free builder"
+    )
+    .unwrap();
+    pub static ref BUILDS_RUNNING: IntGauge = register_int_gauge!(
+        "pemasak_builds_running",
+        "Builds and deploys in progress"
+    )
+    .unwrap();
+    pub static ref DEPLOY_DURATION: HistogramVec = register_histogram_vec!(
+        "pemasak_deploy_duration_seconds",
+        "Time from a builder picking up a build until it is live or failed",
+        &["kind", "status"],
+        vec![5.0, 15.0, 30.0, 60.0, 120.0, 300.0, 600.0, 1200.0]
+    )
+    .unwrap();
+    pub static ref PROXY_REQUESTS: IntCounterVec = register_int_counter_vec!(
+        "pemasak_proxy_requests_total",
+        "Requests proxied to apps by status class",
+        &["app", "status"]
+    )
+    .unwrap();
+    pub static ref PROXY_DURATION: HistogramVec = register_histogram_vec!(
+        "pemasak_proxy_request_duration_seconds",
+        "Time apps take to answer proxied requests",
+        &["app"]
+    )
+    .unwrap();
+    pub static ref PROXY_RELEASE_REQUESTS: IntCounterVec = register_int_counter_vec!(
+        "pemasak_proxy_release_requests_total",
+        "Requests proxied to apps running a canary by release, stable or canary, and status class",
+        &["app", "release", "status"]
+    )
+    .unwrap();
+    pub static ref PROXY_RELEASE_DURATION: HistogramVec = register_histogram_vec!(
+        "pemasak_proxy_release_request_duration_seconds",
+        "Time apps running a canary take to answer proxied requests by release",
+        &["app", "release"]
+    )
+    .unwrap();
+    pub static ref CONTAINER_RESTARTS: IntGaugeVec = register_int_gauge_vec!(
+        "pemasak_container_restarts",
+        "Times docker restarted an app container, as of the last metrics sample",
+        &["app", "container", "process"]
+    )
+    .unwrap();
+    pub static ref CONTAINER_OOM_KILLED: IntGaugeVec = register_int_gauge_vec!(
+        "pemasak_container_oom_killed",
+        "1 when the last exit of an app container was an out of memory kill",
+        &["app", "container", "process"]
+    )
+    .unwrap();
+}
+
+const STATUS_CLASSES: [&str; 5] = ["1xx", "2xx", "3xx", "4xx", "5xx"];
+
+/// the releases of an app running a canary
+pub const RELEASES: [&str; 2] = ["stable", "canary"];
+
+/// Statuses are grouped into classes so the label stays small
+fn status_class(status: StatusCode) -> &'static str {
+    match status.as_u16() / 100 {
+        1 => "1xx",
+        2 => "2xx",
+        3 => "3xx",
+        4 => "4xx",
+        _ => "5xx",
+    }
+}
+
+/// Records a request the proxy answered for `app`
+pub fn record_proxy_request(app: &str, status: StatusCode, seconds: f64) {
+    PROXY_REQUESTS.with_label_values(&[app, status_class(status)]).inc();
+    PROXY_DURATION.with_label_values(&[app]).observe(seconds);
+}
+
+/// Records which release of an app running a canary answered a request
+pub fn record_release_request(app: &str, release: &str, status: StatusCode, seconds: f64) {
+    PROXY_RELEASE_REQUESTS
+        .with_label_values(&[app, release, status_class(status)])
+        .inc();
+    PROXY_RELEASE_DURATION
+        .with_label_values(&[app, release])
+        .observe(seconds);
+}
+
+/// Forgets the release metrics of `app`, so a new canary is compared from its first request
+pub fn reset_release_requests(app: &str) {
+    for release in RELEASES {
+        for class in STATUS_CLASSES {
+            let _ = PROXY_RELEASE_REQUESTS.remove_label_values(&[app, release, class]);
+        }
+        let _ = PROXY_RELEASE_DURATION.remove_label_values(&[app, release]);
+    }
+}
+
+/// Requests one release of an app answered since its canary started
+#[derive(Serialize, Debug)]
+pub struct ReleaseStats {
+    pub requests: u64,
+    /// 5xx responses, failed requests to the container included
+    pub errors: u64,
+    pub p95_ms: Option<f64>,
+}
+
+pub fn release_stats(app: &str, release: &str) -> ReleaseStats {
+    let count = |class: &str| PROXY_RELEASE_REQUESTS.with_label_values(&[app, release, class]).get();
+
+    let metric = PROXY_RELEASE_DURATION.with_label_values(&[app, release]).metric();
+    let buckets = cumulative_buckets(metric.get_histogram());
+
+    ReleaseStats {
+        requests: STATUS_CLASSES.iter().map(|class| count(class)).sum(),
+        errors: count("5xx"),
+        p95_ms: quantile(0.95, &[], &buckets).map(|seconds| seconds * 1000.0),
+    }
+}
+
+/// Cumulative counts of proxied requests of `app` per latency bucket in seconds, ending with
+/// the total count at infinity
+pub fn proxy_latency_buckets(app: &str) -> Vec<(f64, u64)> {
+    let metric = PROXY_DURATION.with_label_values(&[app]).metric();
+    cumulative_buckets(metric.get_histogram())
+}
+
+fn cumulative_buckets(histogram: &Histogram) -> Vec<(f64, u64)> {
+    let mut buckets = histogram
+        .get_bucket()
+        .iter()
+        .map(|bucket| (bucket.get_upper_bound(), bucket.get_cumulative_count()))
+        .collect::<Vec<_>>();
+    buckets.push((f64::INFINITY, histogram.get_sample_count()));
+    buckets
+}
+
+/// Quantile `q` of the requests counted between two snapshots of cumulative buckets,
+/// interpolated within the bucket it falls in like prometheus does
+pub fn quantile(q: f64, previous: &[(f64, u64)], current: &[(f64, u64)]) -> Option<f64> {
+    let counts = current
+        .iter()
+        .enumerate()
+        .map(|(index, (bound, count))| {
+            let before = previous.get(index).map(|(_, count)| *count).unwrap_or(0);
+            (*bound, count.saturating_sub(before))
+        })
+        .collect::<Vec<_>>();
+
+    let total = counts.last()?.1;
+    if total == 0 {
+        return None;
+    }
+
+    let rank = q * total as f64;
+    let (mut lower_bound, mut lower_count) = (0.0, 0);
+    for (bound, count) in counts {
+        if count as f64 >= rank {
+            // slower than the largest bucket, the largest bound is all that's known
+            if bound.is_infinite() {
+                return Some(lower_bound);
+            }
+
+            let within = (count - lower_count) as f64;
+            let position = match within > 0.0 {
+                true => (rank - lower_count as f64) / within,
+                false => 1.0,
+            };
+            return Some(lower_bound + (bound - lower_bound) * position);
+        }
+        (lower_bound, lower_count) = (bound, count);
+    }
+
+    None
+}
+
+/// Serves every metric for prometheus. Scrapes need the configured bearer token, without a
+/// token the exporter doesn't exist
+#[tracing::instrument(skip(metrics_token, authorization))]
+pub async fn export(
+    State(AppState { metrics_token, .. }): State<AppState>,
+    authorization: Option<TypedHeader<Authorization<Bearer>>>,
+) -> Response<Body> {
+    let metrics_token = match metrics_token {
+        Some(metrics_token) => metrics_token,
+        None => {
+            return Response::builder()
+                .status(StatusCode::NOT_FOUND)
+                .body(Body::empty())
+                .unwrap();
+        }
+    };
+
+    match authorization {
+        Some(TypedHeader(Authorization(bearer)))
+            if bearer.token() == metrics_token.expose_secret() => {}
+        _ => {
+            return Response::builder()
+                .status(StatusCode::UNAUTHORIZED)
+                .header("WWW-Authenticate", "Bearer")
+                .body(Body::empty())
+                .unwrap();
+        }
+    }
+
+    let encoder = TextEncoder::new();
+    let mut buffer = Vec::new();
+    if let Err(err) = encoder.encode(&prometheus::gather(), &mut buffer) {
+        tracing::error!(?err, "Can't export metrics: Failed to encode metrics");
+        return Response::builder()
+            .status(StatusCode::INTERNAL_SERVER_ERROR)
+            .body(Body::empty())
+            .unwrap();
+    }
+
+    Response::builder()
+        .status(StatusCode::OK)
+        .header("Content-Type", encoder.format_type())
+        .body(Body::from(buffer))
+        .unwrap()
+}
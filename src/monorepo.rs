@@ -0,0 +1,109 @@
+use std::path::Path;
+
+use anyhow::Result;
+use sqlx::PgPool;
+use uuid::Uuid;
+
+use crate::activity::record_activity;
+
+/// Paths of source directories and watch paths are relative to the root of the repository
+/// and stay inside it
+pub fn repo_path_valid(path: &str) -> bool {
+    !path.starts_with('/')
+        && path
+            .trim_end_matches('/')
+            .split('/')
+            .all(|part| !part.is_empty() && part != "." && part != ".." && !part.contains('\\'))
+}
+
+/// Whether a push needs a build of the project. Projects watching paths, or built from a
+/// directory, only build when the checkout changed something under them since the last
+/// successful build. Anything that can't be told builds, a wasted build beats a missed one
+pub async fn push_needs_build(owner: &str, project: &str, container_src: &str, pool: &PgPool) -> bool {
+    match watched_change(owner, project, container_src, pool).await {
+        Ok(needed) => needed,
+        Err(err) => {
+            tracing::warn!(?err, owner, project, "Can't check watch paths, building the push");
+            true
+        }
+    }
+}
+
+async fn watched_change(owner: &str, project: &str, container_src: &str, pool: &PgPool) -> Result<bool> {
+    let Some(project) = sqlx::query!(
+        r#"SELECT projects.id, projects.source_dir, projects.watch_paths
+           FROM projects
+           JOIN project_owners ON projects.owner_id = project_owners.id
+           WHERE project_owners.name = $1
+           AND projects.name = $2
+        "#,
+        owner,
+        project.trim_end_matches(".git")
+    )
+    .fetch_optional(pool)
+    .await?
+    else {
+        return Ok(true);
+    };
+
+    let watch_paths = match (project.watch_paths.is_empty(), project.source_dir) {
+        (false, _) => project.watch_paths,
+        (true, Some(dir)) => vec![dir],
+        (true, None) => return Ok(true),
+    };
+
+    let last_built = sqlx::query!(
+        r#"SELECT commit_sha FROM builds
+           WHERE project_id = $1 AND status = 'successful' AND commit_sha IS NOT NULL
+           ORDER BY created_at DESC
+           LIMIT 1
+        "#,
+        project.id
+    )
+    .fetch_optional(pool)
+    .await?
+    .and_then(|build| build.commit_sha);
+
+    let Some(last_built) = last_built else {
+        return Ok(true);
+    };
+
+    let changed = tokio::task::spawn_blocking({
+        let container_src = container_src.to_string();
+        move || changed_paths(&container_src, &last_built)
+    })
+    .await??;
+
+    let needed = changed
+        .iter()
+        .any(|path| watch_paths.iter().any(|watched| Path::new(path).starts_with(watched)));
+
+    if !needed {
+        skipped(project.id, &watch_paths, pool).await;
+    }
+    Ok(needed)
+}
+
+/// Files that differ between `commit` and the HEAD of the checkout, both sides of renames
+fn changed_paths(container_src: &str, commit: &str) -> Result<Vec<String>> {
+    let repo = git2::Repository::open(container_src)?;
+    let head = repo.head()?.peel_to_tree()?;
+    let old = repo.revparse_single(commit)?.peel_to_tree()?;
+
+    let diff = repo.diff_tree_to_tree(Some(&old), Some(&head), None)?;
+    Ok(diff
+        .deltas()
+        .flat_map(|delta| [delta.old_file().path(), delta.new_file().path()])
+        .flatten()
+        .map(|path| path.to_string_lossy().to_string())
+        .collect())
+}
+
+async fn skipped(project_id: Uuid, watch_paths: &[String], pool: &PgPool) {
+    let message = format!("Push changed nothing under {}, not deploying", watch_paths.join(", "));
+    tracing::info!(?project_id, message);
+
+    if let Err(err) = record_activity(project_id, "push", &message, pool).await {
+        tracing::error!(?err, "Can't record activity: Failed to query database");
+    }
+}
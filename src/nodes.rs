@@ -0,0 +1,544 @@
+//! Hosts besides the one of the platform that run apps. Each runs `pemasak-agent`, see
+//! [`crate::agent`], which reports what its host has left and lets the platform reach the
+//! docker of the host. New apps are placed on the least loaded node, and whatever the platform
+//! does to the containers of an app goes to the docker of the node the app is on, see
+//! [`docker`]. Builds stay on the host of the platform, the image is copied to the node
+//! before it starts
+
+use std::collections::{HashMap, HashSet};
+use std::sync::RwLock;
+use std::time::Duration;
+
+use anyhow::{anyhow, Result};
+use bollard::container::{ListContainersOptions, RemoveContainerOptions};
+use bollard::image::ImportImageOptions;
+use bollard::{Docker, API_DEFAULT_VERSION};
+use futures::StreamExt;
+use hyper::Body;
+use lazy_static::lazy_static;
+use rand::{distributions::Alphanumeric, Rng};
+use serde::{Deserialize, Serialize};
+use sqlx::PgPool;
+use uuid::Uuid;
+
+use crate::arch;
+use crate::auth::tokens::hash_token;
+use crate::configuration::ContainerSettings;
+use crate::runtime::Runtime;
+
+/// Every node token starts with it. It isn't the one of access tokens, those are checked by
+/// crate::auth::tokens::token_auth before any route
+pub const TOKEN_PREFIX: &str = "pmknode_";
+const TOKEN_LENGTH: usize = 40;
+/// in seconds, like the local defaults of bollard
+const DOCKER_TIMEOUT: u64 = 120;
+const TICK: Duration = Duration::from_secs(10);
+
+lazy_static! {
+    /// Docker of the node each app runs on by container name, None for the host of the
+    /// platform. The apps on the host are in it too, so `a-b-c` on the host isn't taken for a
+    /// container of `a-b` on a node
+    static ref PLACEMENTS: RwLock<HashMap<String, Option<String>>> = RwLock::new(HashMap::new());
+}
+
+/// What the agent of a node reports about its host on every tick
+#[derive(Serialize, Deserialize, Debug, Clone)]
+pub struct Report {
+    /// where the platform reaches the docker of the host through the agent
+    pub docker_url: String,
+    pub cpus: i32,
+    /// MiB
+    pub memory: i32,
+    pub memory_available: i32,
+    /// load average over a minute
+    pub load: f64,
+    /// running containers
+    pub containers: i32,
+    /// agents from before runtimes only ran docker
+    #[serde(default)]
+    pub runtime: Runtime,
+    /// like amd64 or arm64, see crate::arch. Agents from before report none
+    #[serde(default)]
+    pub arch: Option<String>,
+}
+
+/// A node new apps can go to
+#[derive(Debug, Clone)]
+pub struct Node {
+    pub id: Uuid,
+    pub name: String,
+    pub docker_url: String,
+}
+
+/// What draining a node did to the apps on it
+#[derive(Serialize, Debug, Default)]
+pub struct Drained {
+    pub moved: Vec<MovedApp>,
+    /// owner/project of apps with a database or volume on the node, they stay until their
+    /// data is moved by hand
+    pub staying: Vec<String>,
+    /// owner/project of apps no other host runs, there is no other node of the architecture
+    /// of the node and the host of the platform has another one
+    pub stranded: Vec<String>,
+}
+
+#[derive(Serialize, Debug)]
+pub struct MovedApp {
+    pub owner: String,
+    pub project: String,
+    /// None is the host of the platform
+    pub node: Option<String>,
+    /// only apps with a release are started again where they went
+    #[serde(skip)]
+    pub released: bool,
+}
+
+/// Docker of the node the app of the container runs on, or the one of the host of the
+/// platform
+pub fn docker(container_name: &str) -> Result<Docker, bollard::errors::Error> {
+    match docker_url(container_name) {
+        Some(url) => Docker::connect_with_http(&url, DOCKER_TIMEOUT, API_DEFAULT_VERSION),
+        None => Docker::connect_with_local_defaults(),
+    }
+}
+
+fn docker_url(container_name: &str) -> Option<String> {
+    let placements = PLACEMENTS.read().unwrap();
+    let app = owning_app(container_name, placements.keys().map(String::as_str))?;
+    placements.get(app).cloned().flatten()
+}
+
+fn set_placement(container_name: &str, docker_url: Option<String>) {
+    PLACEMENTS.write().unwrap().insert(container_name.to_string(), docker_url);
+}
+
+/// The app out of `apps` a container belongs to. Containers are named after their app, like
+/// `{app}-db` and `{app}-next`, the longest name wins so `a-b-db` is of `a-b` rather than `a`.
+/// Previews are named `{branch}--{app}`, see crate::previews::preview_name
+fn owning_app<'a>(container: &str, apps: impl Iterator<Item = &'a str> + Clone) -> Option<&'a str> {
+    let named_after = |container: &str| {
+        apps.clone()
+            .filter(|app| {
+                container == *app
+                    || container.strip_prefix(app).is_some_and(|rest| rest.starts_with('-'))
+            })
+            .max_by_key(|app| app.len())
+    };
+
+    container
+        .split_once("--")
+        .and_then(|(_, app)| named_after(app))
+        .or_else(|| named_after(container))
+}
+
+/// Reads where every app runs. The platform does this before it touches any container, and
+/// [`node_watcher`] again on every tick
+pub async fn load_placements(pool: &PgPool) -> Result<(), sqlx::Error> {
+    let apps = sqlx::query!(
+        r#"SELECT project_owners.name AS owner, projects.name AS project, nodes.docker_url AS "docker_url?"
+           FROM projects
+           JOIN project_owners ON projects.owner_id = project_owners.id
+           LEFT JOIN nodes ON projects.node_id = nodes.id
+        "#
+    )
+    .fetch_all(pool)
+    .await?;
+
+    let placements = apps
+        .into_iter()
+        .map(|app| {
+            let container_name = format!("{}-{}", app.owner, app.project.trim_end_matches(".git")).replace('.', "-");
+            (container_name, app.docker_url)
+        })
+        .collect();
+    *PLACEMENTS.write().unwrap() = placements;
+    Ok(())
+}
+
+/// Copies `image` from the docker of the platform to the node the app of the container runs
+/// on, unless the node has it already or the app is on the host
+pub async fn ship_image(container_name: &str, image: &str) -> Result<()> {
+    let Some(url) = docker_url(container_name) else {
+        return Ok(());
+    };
+    let node = Docker::connect_with_http(&url, DOCKER_TIMEOUT, API_DEFAULT_VERSION)?;
+    if node.inspect_image(image).await.is_ok() {
+        return Ok(());
+    }
+
+    // a release the host lost is pulled from the registry by the node itself
+    let local = Docker::connect_with_local_defaults()?;
+    if local.inspect_image(image).await.is_err() {
+        return Ok(());
+    }
+
+    tracing::info!(container_name, image, "Copying image to node");
+    let export = local.export_image(image);
+    let mut import = node.import_image(ImportImageOptions { quiet: true }, Body::wrap_stream(export), None);
+    while let Some(info) = import.next().await {
+        if let Some(error) = info?.error {
+            return Err(anyhow!("Failed to copy image {image} to the node: {error}"));
+        }
+    }
+
+    Ok(())
+}
+
+/// A token for a new node and the hash it is stored as. The token itself is only shown once
+pub fn generate_token() -> (String, String) {
+    let token = rand::thread_rng()
+        .sample_iter(&Alphanumeric)
+        .take(TOKEN_LENGTH)
+        .map(char::from)
+        .collect::<String>();
+    let token = format!("{TOKEN_PREFIX}{token}");
+    let hash = hash_token(&token);
+    (token, hash)
+}
+
+/// Records what the agent with `token` reported. Returns the name of its node and whether it
+/// is draining, None for a token no node has
+pub async fn record_report(token: &str, report: &Report, pool: &PgPool) -> Result<Option<(String, bool)>, sqlx::Error> {
+    let node = sqlx::query!(
+        r#"UPDATE nodes
+           SET docker_url = $2, cpus = $3, memory = $4, memory_available = $5, load = $6,
+               containers = $7, runtime = $8, arch = COALESCE($9, arch), last_seen_at = now()
+           WHERE token_hash = $1
+           RETURNING name, draining
+        "#,
+        hash_token(token),
+        report.docker_url,
+        report.cpus,
+        report.memory,
+        report.memory_available,
+        report.load,
+        report.containers,
+        report.runtime.as_str(),
+        report.arch,
+    )
+    .fetch_optional(pool)
+    .await?;
+
+    Ok(node.map(|node| (node.name, node.draining)))
+}
+
+/// The node with the most left of its cpus or memory, whichever is scarcer, out of the ones
+/// of `archs` that reported within the node timeout and aren't draining. Nodes whose agent
+/// doesn't report an architecture are taken for the one of the host
+async fn least_loaded(
+    archs: &[String],
+    container_settings: &ContainerSettings,
+    pool: &PgPool,
+) -> Result<Option<Node>, sqlx::Error> {
+    let node = sqlx::query!(
+        r#"SELECT id, name, docker_url AS "docker_url!"
+           FROM nodes
+           WHERE NOT draining AND docker_url IS NOT NULL
+           AND last_seen_at > now() - make_interval(secs => $1)
+           AND COALESCE(arch, $2) = ANY($3)
+           ORDER BY GREATEST(load / GREATEST(cpus, 1), 1 - memory_available::float8 / GREATEST(memory, 1)), containers
+           LIMIT 1
+        "#,
+        container_settings.nodetimeout as f64,
+        arch::host(),
+        archs,
+    )
+    .fetch_optional(pool)
+    .await?;
+
+    Ok(node.map(|node| Node {
+        id: node.id,
+        name: node.name,
+        docker_url: node.docker_url,
+    }))
+}
+
+/// Counts what `apps` new apps take off the node until it reports again, so the ones placed
+/// right after don't all pick it too
+async fn reserve(node_id: Uuid, apps: i32, container_settings: &ContainerSettings, pool: &PgPool) -> Result<(), sqlx::Error> {
+    sqlx::query!(
+        r#"UPDATE nodes
+           SET memory_available = GREATEST(memory_available - $2, 0), containers = containers + $3
+           WHERE id = $1
+        "#,
+        node_id,
+        container_settings.memory * apps,
+        apps,
+    )
+    .execute(pool)
+    .await?;
+    Ok(())
+}
+
+/// Places an app on a node before its first deploy. Apps that have a release, a database or a
+/// volume stay where they are, [`drain`] moves them. The apps of an owner reach each other on
+/// its private network, so they share a host: a new owner goes to the least loaded node and
+/// the next apps of the owner follow. Without a node that reported lately everything stays
+/// on the host of the platform. Only nodes of an architecture the host can build for get apps
+pub async fn place(
+    project_id: Uuid,
+    container_name: &str,
+    container_settings: &ContainerSettings,
+    pool: &PgPool,
+) -> Result<(), sqlx::Error> {
+    let project = sqlx::query!(
+        r#"SELECT projects.node_id, projects.owner_id,
+           EXISTS(SELECT 1 FROM releases WHERE releases.project_id = projects.id)
+           OR EXISTS(SELECT 1 FROM addons WHERE addons.project_id = projects.id AND addons.kind <> 's3')
+           OR EXISTS(SELECT 1 FROM volumes WHERE volumes.project_id = projects.id) AS "settled!"
+           FROM projects
+           WHERE projects.id = $1
+        "#,
+        project_id
+    )
+    .fetch_one(pool)
+    .await?;
+
+    if project.node_id.is_some() || project.settled {
+        return Ok(());
+    }
+
+    let neighbour = sqlx::query!(
+        r#"SELECT nodes.id AS "id?", nodes.name AS "name?", nodes.docker_url
+           FROM projects
+           LEFT JOIN nodes ON projects.node_id = nodes.id
+           WHERE projects.owner_id = $1 AND projects.id <> $2
+           AND (projects.node_id IS NOT NULL OR EXISTS(SELECT 1 FROM releases WHERE releases.project_id = projects.id))
+           LIMIT 1
+        "#,
+        project.owner_id,
+        project_id
+    )
+    .fetch_optional(pool)
+    .await?;
+
+    let node = match neighbour {
+        Some(neighbour) => match (neighbour.id, neighbour.name, neighbour.docker_url) {
+            (Some(id), Some(name), Some(docker_url)) => Node { id, name, docker_url },
+            // the other apps of the owner run on the host of the platform
+            _ => return Ok(()),
+        },
+        None => match least_loaded(&arch::buildable(), container_settings, pool).await? {
+            Some(node) => node,
+            None => return Ok(()),
+        },
+    };
+
+    sqlx::query!("UPDATE projects SET node_id = $1 WHERE id = $2", node.id, project_id)
+        .execute(pool)
+        .await?;
+    reserve(node.id, 1, container_settings, pool).await?;
+    set_placement(container_name, Some(node.docker_url));
+
+    tracing::info!(container_name, node = node.name, "Placed app on node");
+    Ok(())
+}
+
+/// Stops placing apps on the node and moves the apps on it to the least loaded node left of
+/// its architecture, or to the host of the platform when there is none and it has the same
+/// one. Owners move together like [`place`] puts them, an owner with a database or volume on
+/// the node stays whole. The caller starts the moved apps again, their containers on the node
+/// are removed by [`node_watcher`] once they serve from where they went. Draining again moves
+/// the apps that came since
+pub async fn drain(node_id: Uuid, container_settings: &ContainerSettings, pool: &PgPool) -> Result<Drained, sqlx::Error> {
+    let node = sqlx::query!(
+        r#"UPDATE nodes SET draining = true WHERE id = $1 RETURNING COALESCE(arch, $2) AS "arch!""#,
+        node_id,
+        arch::host(),
+    )
+    .fetch_one(pool)
+    .await?;
+    let host_fits = node.arch == arch::host();
+
+    let apps = sqlx::query!(
+        r#"SELECT projects.id, projects.owner_id, projects.name AS project, project_owners.name AS owner,
+           EXISTS(SELECT 1 FROM releases WHERE releases.project_id = projects.id) AS "released!",
+           EXISTS(SELECT 1 FROM addons WHERE addons.project_id = projects.id AND addons.kind <> 's3')
+           OR EXISTS(SELECT 1 FROM volumes WHERE volumes.project_id = projects.id) AS "stateful!"
+           FROM projects
+           JOIN project_owners ON projects.owner_id = project_owners.id
+           WHERE projects.node_id = $1
+           ORDER BY project_owners.name, projects.name
+        "#,
+        node_id
+    )
+    .fetch_all(pool)
+    .await?;
+
+    let held = apps
+        .iter()
+        .filter(|app| app.stateful)
+        .map(|app| app.owner_id)
+        .collect::<HashSet<_>>();
+
+    let mut drained = Drained::default();
+    let mut targets: HashMap<Uuid, Option<Node>> = HashMap::new();
+    for app in apps {
+        if held.contains(&app.owner_id) {
+            drained.staying.push(format!("{}/{}", app.owner, app.project));
+            continue;
+        }
+
+        // the image of the app only runs on hosts of the architecture of the node
+        let target = match targets.get(&app.owner_id) {
+            Some(target) => target.clone(),
+            None => {
+                let target = least_loaded(&[node.arch.clone()], container_settings, pool).await?;
+                targets.insert(app.owner_id, target.clone());
+                target
+            }
+        };
+        if target.is_none() && !host_fits {
+            drained.stranded.push(format!("{}/{}", app.owner, app.project));
+            continue;
+        }
+
+        sqlx::query!(
+            "UPDATE projects SET node_id = $1 WHERE id = $2",
+            target.as_ref().map(|node| node.id),
+            app.id
+        )
+        .execute(pool)
+        .await?;
+        if let Some(node) = &target {
+            reserve(node.id, 1, container_settings, pool).await?;
+        }
+
+        let container_name = format!("{}-{}", app.owner, app.project.trim_end_matches(".git")).replace('.', "-");
+        set_placement(&container_name, target.as_ref().map(|node| node.docker_url.clone()));
+
+        drained.moved.push(MovedApp {
+            owner: app.owner,
+            project: app.project,
+            node: target.map(|node| node.name),
+            released: app.released,
+        });
+    }
+
+    Ok(drained)
+}
+
+/// Keeps the placements of the platform fresh and says when a node stops reporting, then it
+/// gets no new apps. On draining nodes it removes the containers of apps that moved and
+/// serve from where they went
+pub async fn node_watcher(pool: PgPool, container_settings: ContainerSettings) {
+    let mut stale: HashSet<Uuid> = HashSet::new();
+
+    let mut interval = tokio::time::interval(TICK);
+    loop {
+        interval.tick().await;
+
+        if let Err(err) = load_placements(&pool).await {
+            tracing::error!(?err, "Can't load placements: Failed to query database");
+            continue;
+        }
+
+        let nodes = match sqlx::query!(
+            r#"SELECT id, name, docker_url, draining,
+               COALESCE(last_seen_at > now() - make_interval(secs => $1), false) AS "fresh!"
+               FROM nodes
+            "#,
+            container_settings.nodetimeout as f64,
+        )
+        .fetch_all(&pool)
+        .await
+        {
+            Ok(nodes) => nodes,
+            Err(err) => {
+                tracing::error!(?err, "Can't watch nodes: Failed to query database");
+                continue;
+            }
+        };
+
+        for node in nodes {
+            let Some(docker_url) = node.docker_url else {
+                continue;
+            };
+
+            if !node.fresh {
+                if stale.insert(node.id) {
+                    tracing::warn!(node = node.name, "Node stopped reporting, it gets no new apps");
+                }
+                continue;
+            }
+            if stale.remove(&node.id) {
+                tracing::info!(node = node.name, "Node reports again");
+            }
+
+            if node.draining {
+                match sweep(node.id, &docker_url, &pool).await {
+                    Ok(0) => {}
+                    Ok(removed) => tracing::info!(node = node.name, removed, "Removed containers of apps that moved off the node"),
+                    Err(err) => tracing::error!(?err, node = node.name, "Can't remove containers of apps that moved off the node"),
+                }
+            }
+        }
+    }
+}
+
+/// Removes the containers on the node of apps that run somewhere else now and whose live
+/// container isn't on the node anymore
+async fn sweep(node_id: Uuid, docker_url: &str, pool: &PgPool) -> Result<usize> {
+    let apps = sqlx::query!(
+        r#"SELECT project_owners.name AS owner, projects.name AS project, projects.node_id,
+           domains.container_id AS "container_id?"
+           FROM projects
+           JOIN project_owners ON projects.owner_id = project_owners.id
+           LEFT JOIN domains ON domains.project_id = projects.id
+        "#
+    )
+    .fetch_all(pool)
+    .await?
+    .into_iter()
+    .map(|app| {
+        let container_name = format!("{}-{}", app.owner, app.project.trim_end_matches(".git")).replace('.', "-");
+        (container_name, (app.node_id, app.container_id))
+    })
+    .collect::<HashMap<_, _>>();
+
+    let docker = Docker::connect_with_http(docker_url, DOCKER_TIMEOUT, API_DEFAULT_VERSION)?;
+    let containers = docker
+        .list_containers(Some(ListContainersOptions::<String> {
+            all: true,
+            ..Default::default()
+        }))
+        .await?;
+    let ids = containers
+        .iter()
+        .filter_map(|container| container.id.as_deref())
+        .collect::<HashSet<_>>();
+
+    let mut removed = 0;
+    for container in &containers {
+        let Some(name) = container
+            .names
+            .as_ref()
+            .and_then(|names| names.first())
+            .map(|name| name.trim_start_matches('/'))
+        else {
+            continue;
+        };
+        let Some((placed_on, live)) = owning_app(name, apps.keys().map(String::as_str)).and_then(|app| apps.get(app)) else {
+            continue;
+        };
+
+        // still placed here, or not started where it went yet
+        let live_here = live.as_deref().map_or(true, |id| ids.contains(id));
+        if *placed_on == Some(node_id) || live_here {
+            continue;
+        }
+
+        docker
+            .remove_container(
+                name,
+                Some(RemoveContainerOptions {
+                    force: true,
+                    ..Default::default()
+                }),
+            )
+            .await?;
+        removed += 1;
+    }
+
+    Ok(removed)
+}
@@ -0,0 +1,473 @@
+use std::collections::{HashMap, HashSet};
+use std::time::Duration;
+
+use anyhow::Result;
+use bollard::system::EventsOptions;
+use bollard::Docker;
+use chrono::{DateTime, Utc};
+use data_encoding::HEXLOWER;
+use futures::StreamExt;
+use hmac::{Hmac, Mac};
+use serde::Serialize;
+use serde_json::json;
+use sha2::Sha256;
+use sqlx::PgPool;
+use tokio::time::Instant;
+use url::Url;
+use uuid::Uuid;
+
+use crate::activity::record_activity;
+use crate::crashloop::{Crash, CrashLoops};
+use crate::docker::WORKER_LABEL;
+use crate::drains::redact as redact_password;
+use crate::restarts::{exit_signal, record_exit, Restarts};
+
+/// every notification gets this long, a slow receiver doesn't hold up the others
+const SEND_TIMEOUT: Duration = Duration::from_secs(10);
+
+/// a container that keeps crashing notifies once per this long
+const CRASH_COOLDOWN: Duration = Duration::from_secs(300);
+
+pub const KINDS: [&str; 3] = ["webhook", "slack", "discord"];
+
+#[derive(Debug, Clone, Copy)]
+pub enum Event {
+    BuildStarted,
+    BuildSucceeded,
+    BuildFailed,
+    ContainerCrashed,
+    CrashLooping,
+    AppInactive,
+    AppStopped,
+    PromotionRequested,
+}
+
+impl Event {
+    pub const ALL: [Event; 8] = [
+        Event::BuildStarted,
+        Event::BuildSucceeded,
+        Event::BuildFailed,
+        Event::ContainerCrashed,
+        Event::CrashLooping,
+        Event::AppInactive,
+        Event::AppStopped,
+        Event::PromotionRequested,
+    ];
+
+    /// name hooks subscribe to and webhooks receive
+    pub fn name(&self) -> &'static str {
+        match self {
+            Event::BuildStarted => "build.started",
+            Event::BuildSucceeded => "build.succeeded",
+            Event::BuildFailed => "build.failed",
+            Event::ContainerCrashed => "container.crashed",
+            Event::CrashLooping => "container.crash_looping",
+            Event::AppInactive => "app.inactive",
+            Event::AppStopped => "app.stopped",
+            Event::PromotionRequested => "promotion.requested",
+        }
+    }
+
+    /// the warnings of the cleanup go to every hook, also ones that subscribed before they
+    /// existed. an app shouldn't be deleted without anyone hearing about it
+    fn always(&self) -> bool {
+        matches!(self, Event::AppInactive | Event::AppStopped)
+    }
+
+    fn emoji(&self) -> &'static str {
+        match self {
+            Event::BuildStarted => "\u{1f528}",
+            Event::BuildSucceeded => "\u{2705}",
+            Event::BuildFailed => "\u{274c}",
+            Event::ContainerCrashed => "\u{1f4a5}",
+            Event::CrashLooping => "\u{1f501}",
+            Event::AppInactive => "\u{1f4a4}",
+            Event::AppStopped => "\u{26d4}",
+            Event::PromotionRequested => "\u{1f6a6}",
+        }
+    }
+}
+
+/// Body of a webhook notification, chat messages are made from it too
+#[derive(Serialize, Debug, Clone)]
+pub struct Payload {
+    pub event: &'static str,
+    /// owner/project
+    pub app: String,
+    pub message: String,
+    pub build_id: Option<Uuid>,
+    pub commit_sha: Option<String>,
+    /// set once a build is live
+    pub release_id: Option<Uuid>,
+    /// dashboard page with the build log, or the container logs for crashes
+    pub logs_url: String,
+    pub timestamp: DateTime<Utc>,
+}
+
+/// Event of login notifications, they go to the login hooks of a user instead of a project
+pub const LOGIN_EVENT: &str = "user.new_login";
+
+/// Body of a login notification, sent when a user logs in from a device they haven't
+/// logged in from before
+#[derive(Serialize, Debug, Clone)]
+pub struct LoginPayload {
+    pub event: &'static str,
+    pub username: String,
+    pub message: String,
+    /// the session to revoke if it wasn't them
+    pub session_id: Uuid,
+    pub ip: Option<String>,
+    pub user_agent: Option<String>,
+    pub timestamp: DateTime<Utc>,
+}
+
+/// Sends notifications to the hooks of a project in the background, a failing hook is
+/// logged and never fails what it reports on
+#[derive(Debug, Clone)]
+pub struct Notifier {
+    client: reqwest::Client,
+    dashboard: String,
+}
+
+impl Notifier {
+    pub fn new(domain: &str, secure: bool) -> Result<Self> {
+        let scheme = match secure {
+            true => "https",
+            false => "http",
+        };
+
+        Ok(Self {
+            client: reqwest::Client::builder().timeout(SEND_TIMEOUT).build()?,
+            dashboard: format!("{scheme}://{domain}"),
+        })
+    }
+
+    pub fn build_url(&self, owner: &str, project: &str, build_id: Uuid) -> String {
+        format!("{}/project/{owner}/{project}/build/{build_id}", self.dashboard)
+    }
+
+    pub fn project_url(&self, owner: &str, project: &str) -> String {
+        format!("{}/project/{owner}/{project}", self.dashboard)
+    }
+
+    pub fn logs_url(&self, owner: &str, project: &str) -> String {
+        format!("{}/project/{owner}/{project}/logs", self.dashboard)
+    }
+
+    /// Sends `payload` to every hook of the project subscribed to `event`
+    pub fn notify(&self, project_id: Uuid, event: Event, payload: Payload, pool: &PgPool) {
+        let client = self.client.clone();
+        let pool = pool.clone();
+
+        tokio::spawn(async move {
+            let hooks = match sqlx::query!(
+                r#"SELECT kind, url, secret FROM notification_hooks
+                   WHERE project_id = $1 AND ($2 = ANY(events) OR $3)
+                "#,
+                project_id,
+                event.name(),
+                event.always()
+            )
+            .fetch_all(&pool)
+            .await
+            {
+                Ok(hooks) => hooks,
+                Err(err) => {
+                    tracing::error!(?err, "Can't get notification hooks: Failed to query database");
+                    return;
+                }
+            };
+
+            for hook in hooks {
+                let chat = |bold: &str| chat_message(event, &payload, bold);
+                if let Err(err) = send(&client, &hook.kind, &hook.url, &hook.secret, event.name(), chat, &payload).await {
+                    tracing::warn!(?err, app = payload.app, hook = %redact(&hook.kind, &hook.url), "Can't send notification");
+                }
+            }
+        });
+    }
+
+    /// Sends `payload` to every login hook of the user
+    pub fn notify_login(&self, user_id: Uuid, payload: LoginPayload, pool: &PgPool) {
+        let client = self.client.clone();
+        let pool = pool.clone();
+
+        tokio::spawn(async move {
+            let hooks = match sqlx::query!(
+                "SELECT kind, url, secret FROM login_hooks WHERE user_id = $1",
+                user_id
+            )
+            .fetch_all(&pool)
+            .await
+            {
+                Ok(hooks) => hooks,
+                Err(err) => {
+                    tracing::error!(?err, "Can't get login hooks: Failed to query database");
+                    return;
+                }
+            };
+
+            for hook in hooks {
+                let chat = |bold: &str| format!("\u{1f511} {bold}{}{bold} {}", payload.username, payload.message);
+                if let Err(err) = send(&client, &hook.kind, &hook.url, &hook.secret, LOGIN_EVENT, chat, &payload).await {
+                    tracing::warn!(?err, username = payload.username, hook = %redact(&hook.kind, &hook.url), "Can't send login notification");
+                }
+            }
+        });
+    }
+}
+
+/// Posts `payload` to a hook. Slack and discord get the message `chat` makes with the way
+/// each marks bold text instead
+async fn send(
+    client: &reqwest::Client,
+    kind: &str,
+    url: &str,
+    secret: &str,
+    event: &str,
+    chat: impl Fn(&str) -> String,
+    payload: &impl Serialize,
+) -> Result<()> {
+    let req = match kind {
+        "slack" => client
+            .post(url)
+            .json(&json!({ "text": chat("*") })),
+        "discord" => client
+            .post(url)
+            .json(&json!({ "content": chat("**") })),
+        _ => {
+            let body = serde_json::to_vec(payload)?;
+
+            // signed like github webhooks, receivers can reuse their verification
+            let mut mac = Hmac::<Sha256>::new_from_slice(secret.as_bytes())
+                .expect("hmac takes keys of any length");
+            mac.update(&body);
+            let signature = HEXLOWER.encode(&mac.finalize().into_bytes());
+
+            client
+                .post(url)
+                .header(reqwest::header::CONTENT_TYPE, "application/json")
+                .header("X-Pemasak-Event", event)
+                .header("X-Pemasak-Signature-256", format!("sha256={signature}"))
+                .body(body)
+        }
+    };
+
+    req.send().await?.error_for_status()?;
+    Ok(())
+}
+
+/// Message for slack and discord, `bold` is how each marks bold text. Both unfurl a bare
+/// url, so the link goes on its own line instead of in their different link syntaxes
+fn chat_message(event: Event, payload: &Payload, bold: &str) -> String {
+    let mut message = format!("{} {bold}{}{bold} {}", event.emoji(), payload.app, payload.message);
+    if let Some(commit_sha) = &payload.commit_sha {
+        message.push_str(&format!(" (commit {})", &commit_sha[..commit_sha.len().min(7)]));
+    }
+    if let Some(release_id) = payload.release_id {
+        message.push_str(&format!(", release {release_id}"));
+    }
+    message.push_str(&format!("\n{}", payload.logs_url));
+    message
+}
+
+/// Hook url to show back to its owner, the path of slack and discord urls is their secret
+pub fn redact(kind: &str, value: &str) -> String {
+    match (kind, Url::parse(value)) {
+        ("slack" | "discord", Ok(url)) => {
+            format!("{}://{}/****", url.scheme(), url.host_str().unwrap_or_default())
+        }
+        _ => redact_password(value),
+    }
+}
+
+/// Notifies about web and worker containers that exit without being stopped by the platform.
+/// Docker reports a kill before every stop or removal, a die without one is a crash. A die
+/// after an oom is the kernel killing the container for going over its memory limit
+pub async fn crash_watcher(pool: PgPool, notifier: Notifier, crash_loops: CrashLoops) {
+    let docker = match Docker::connect_with_local_defaults() {
+        Ok(docker) => docker,
+        Err(err) => {
+            tracing::error!(?err, "Can't watch for crashes: Failed to connect to docker");
+            return;
+        }
+    };
+
+    if let Err(err) = crash_loops.resume().await {
+        tracing::error!(?err, "Can't resume crash loops: Failed to restart containers");
+    }
+
+    let mut killed: HashSet<String> = HashSet::new();
+    let mut out_of_memory: HashSet<String> = HashSet::new();
+    let mut notified: HashMap<String, Instant> = HashMap::new();
+
+    loop {
+        let mut events = docker.events(Some(EventsOptions::<String> {
+            filters: HashMap::from([
+                ("type".to_string(), vec!["container".to_string()]),
+                ("event".to_string(), vec!["kill".to_string(), "oom".to_string(), "die".to_string()]),
+            ]),
+            ..Default::default()
+        }));
+
+        while let Some(event) = events.next().await {
+            let event = match event {
+                Ok(event) => event,
+                Err(err) => {
+                    tracing::error!(?err, "Can't watch for crashes: Failed to read docker events");
+                    break;
+                }
+            };
+
+            let actor = event.actor.unwrap_or_default();
+            let id = actor.id.unwrap_or_default();
+            let attributes = actor.attributes.unwrap_or_default();
+
+            match event.action.as_deref() {
+                Some("kill") => {
+                    killed.insert(id);
+                    continue;
+                }
+                Some("oom") => {
+                    out_of_memory.insert(id);
+                    continue;
+                }
+                Some("die") if killed.remove(&id) => {
+                    out_of_memory.remove(&id);
+                    continue;
+                }
+                Some("die") => {}
+                _ => continue,
+            }
+
+            let container = attributes.get("name").cloned().unwrap_or_default();
+            // workers and replicas are labeled with their app, the app container is named after it
+            let app = attributes.get(WORKER_LABEL).unwrap_or(&container).clone();
+            let exit_code = attributes
+                .get("exitCode")
+                .and_then(|code| code.parse::<i32>().ok())
+                .unwrap_or_default();
+            let oom = out_of_memory.remove(&id);
+
+            // builds, one-off runs and cron jobs exit on their own, they have no domain
+            let project = match sqlx::query!(
+                r#"SELECT domains.project_id, projects.name AS project, project_owners.name AS owner,
+                   projects.restart_policy, projects.restart_retries
+                   FROM domains
+                   JOIN projects ON projects.id = domains.project_id
+                   JOIN project_owners ON projects.owner_id = project_owners.id
+                   WHERE domains.name = $1
+                "#,
+                app
+            )
+            .fetch_optional(&pool)
+            .await
+            {
+                Ok(Some(project)) => project,
+                Ok(None) => continue,
+                Err(err) => {
+                    tracing::error!(?err, "Can't get crashed app: Failed to query database");
+                    continue;
+                }
+            };
+
+            if let Err(err) = record_exit(project.project_id, exit_code, oom, &pool).await {
+                tracing::error!(?err, "Can't record exit: Failed to query database");
+            }
+
+            // a loop is told once when it starts, every crash in it only delays the next restart
+            let restarts = Restarts::new(&project.restart_policy, project.restart_retries);
+            let crash = match restarts.endless() {
+                true => crash_loops.crashed(&id, &container, project.project_id).await,
+                false => Crash::Single,
+            };
+            let (event, looping) = match crash {
+                Crash::Single => (Event::ContainerCrashed, None),
+                Crash::LoopStarted { crashes, delay } => {
+                    let looping = format!(
+                        "It is crash looping after {crashes} crashes, the next restart is in {} seconds and every crash doubles the wait",
+                        delay.as_secs()
+                    );
+                    (Event::CrashLooping, Some(looping))
+                }
+                Crash::Looping { .. } => continue,
+            };
+
+            if matches!(event, Event::ContainerCrashed)
+                && notified
+                    .get(&container)
+                    .is_some_and(|notified| notified.elapsed() < CRASH_COOLDOWN)
+            {
+                continue;
+            }
+            notified.insert(container.clone(), Instant::now());
+
+            let (kind, message) = match oom {
+                true => {
+                    let limit = docker
+                        .inspect_container(&id, None)
+                        .await
+                        .ok()
+                        .and_then(|container| container.host_config?.memory)
+                        .filter(|memory| *memory > 0);
+                    let message = match limit {
+                        Some(limit) => format!(
+                            "Container {container} killed: out of memory, its limit is {} MiB",
+                            limit / 1024 / 1024
+                        ),
+                        None => format!("Container {container} killed: out of memory"),
+                    };
+                    ("oom", message)
+                }
+                false => match exit_signal(exit_code) {
+                    Some(signal) => (
+                        "crash",
+                        format!("Container {container} crashed with exit code {exit_code}, killed by {signal}"),
+                    ),
+                    None => ("crash", format!("Container {container} crashed with exit code {exit_code}")),
+                },
+            };
+            let (kind, message) = match looping {
+                Some(looping) => ("crashloop", format!("{message}. {looping}")),
+                None => (kind, message),
+            };
+            tracing::warn!(project_id = ?project.project_id, message);
+
+            if let Err(err) = record_activity(project.project_id, kind, &message, &pool).await {
+                tracing::error!(?err, "Can't record activity: Failed to query database");
+            }
+
+            let release = sqlx::query!(
+                r#"SELECT releases.id, builds.commit_sha
+                   FROM releases
+                   JOIN builds ON builds.id = releases.build_id
+                   WHERE releases.project_id = $1
+                   ORDER BY releases.created_at DESC
+                   LIMIT 1
+                "#,
+                project.project_id
+            )
+            .fetch_optional(&pool)
+            .await
+            .ok()
+            .flatten();
+
+            let payload = Payload {
+                event: event.name(),
+                app: format!("{}/{}", project.owner, project.project),
+                message,
+                build_id: None,
+                release_id: release.as_ref().map(|release| release.id),
+                commit_sha: release.and_then(|release| release.commit_sha),
+                logs_url: notifier.logs_url(&project.owner, &project.project),
+                timestamp: Utc::now(),
+            };
+            notifier.notify(project.project_id, event, payload, &pool);
+        }
+
+        // docker restarted or the connection dropped, kills from before are stale
+        killed.clear();
+        out_of_memory.clear();
+        tokio::time::sleep(Duration::from_secs(5)).await;
+    }
+}
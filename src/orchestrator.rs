@@ -0,0 +1,303 @@
+//! Where the web and worker processes of apps run. The docker driver runs them as containers
+//! on the host of the platform or a node, see [`crate::nodes`]. The kubernetes driver runs
+//! them as Deployments behind a Service in a cluster, see [`crate::kubernetes`]. Builds,
+//! addons and everything else about an app stay on docker either way
+//!
+//! The driver is picked with `container.orchestrator` when the platform starts
+
+use std::collections::BTreeMap;
+use std::sync::{Arc, RwLock};
+
+use anyhow::{anyhow, Result};
+use async_trait::async_trait;
+use chrono::{DateTime, Utc};
+use lazy_static::lazy_static;
+
+use crate::configuration::{ContainerSettings, Settings};
+use crate::docker::{
+    promote_container, remove_workers, resume_containers, run_container, run_workers, start_web,
+    stop_web, suspend_containers, ReleaseConfig,
+};
+use crate::egress::apply_rules;
+use crate::ip_access::Cidr;
+use crate::kubernetes::KubernetesDriver;
+use crate::nodes;
+use crate::secrets::SecretCipher;
+
+lazy_static! {
+    static ref DRIVER: RwLock<Arc<dyn Driver>> = RwLock::new(Arc::new(DockerDriver));
+}
+
+/// Runs the processes of apps. `container_name` is the one of the app, `container_id` the
+/// id [`Driver::run_web`] returned for the live release
+#[async_trait]
+pub trait Driver: Send + Sync {
+    /// Starts the web process of a release next to the live one and waits until it is ready.
+    /// Returns its id and the address the proxy reaches it on; the proxy is not switched yet
+    #[allow(clippy::too_many_arguments)]
+    async fn run_web(
+        &self,
+        container_name: &str,
+        image: &str,
+        release_config: &ReleaseConfig,
+        db_url: &str,
+        healthcheck_path: Option<&str>,
+        h2c: bool,
+        container_settings: &ContainerSettings,
+        secrets: &SecretCipher,
+    ) -> Result<(String, String)>;
+
+    /// Retires the previous web process once the proxy points at the one of `container_id`
+    async fn promote_web(
+        &self,
+        container_name: &str,
+        container_id: &str,
+        container_settings: &ContainerSettings,
+    ) -> Result<()>;
+
+    /// Runs the formation of a release besides its first web process, see
+    /// [`crate::docker::run_workers`]
+    #[allow(clippy::too_many_arguments)]
+    async fn run_processes(
+        &self,
+        container_name: &str,
+        image: &str,
+        release_config: &ReleaseConfig,
+        db_url: &str,
+        formation: &BTreeMap<String, i64>,
+        restart: bool,
+        container_settings: &ContainerSettings,
+        secrets: &SecretCipher,
+    ) -> Result<()>;
+
+    /// Where the proxy reaches the web process of an app, None while it is stopped
+    async fn address(&self, container_name: &str, container_id: &str) -> Result<Option<String>>;
+
+    /// When the web process of an app last started, None while it is stopped
+    async fn running_since(&self, container_name: &str, container_id: &str) -> Result<Option<DateTime<Utc>>>;
+
+    /// Whether the web process of an app is still there, stopped or not. It is gone when the
+    /// host lost its containers, see [`crate::reconciler`]
+    async fn exists(&self, container_name: &str, container_id: &str) -> Result<bool>;
+
+    /// Stops the web process of an idle app, workers keep running
+    async fn stop_web(
+        &self,
+        container_name: &str,
+        container_id: &str,
+        container_settings: &ContainerSettings,
+    ) -> Result<()>;
+
+    /// Starts the web process [`Driver::stop_web`] stopped and returns its address once it is
+    /// ready
+    async fn start_web(
+        &self,
+        container_name: &str,
+        container_id: &str,
+        port: i32,
+        healthcheck_path: Option<&str>,
+        h2c: bool,
+        timeout: u64,
+    ) -> Result<String>;
+
+    /// Stops every process of a suspended app, keeping them for [`Driver::resume`]
+    async fn suspend(&self, container_name: &str, container_settings: &ContainerSettings) -> Result<()>;
+
+    async fn resume(&self, container_name: &str) -> Result<()>;
+
+    /// Removes what the driver runs for a deleted app. The app container on docker is removed
+    /// by the caller with the image and network of the app
+    async fn remove(&self, container_name: &str) -> Result<()>;
+
+    /// Lets the processes of an app connect only to `ranges` besides its own networks, or
+    /// anywhere without them, see [`crate::egress`]
+    async fn apply_egress(
+        &self,
+        container_name: &str,
+        ranges: Option<&[Cidr]>,
+        container_settings: &ContainerSettings,
+    ) -> Result<()>;
+
+    /// Whether a second web process of an app can run next to its live one under its own
+    /// address, like canaries and previews need
+    fn side_by_side(&self) -> bool;
+}
+
+/// Picks the driver of `container.orchestrator`. Called once before anything runs apps
+pub fn init(settings: &Settings) -> Result<()> {
+    let driver: Arc<dyn Driver> = match settings.container.orchestrator.as_str() {
+        "docker" => Arc::new(DockerDriver),
+        "kubernetes" => Arc::new(KubernetesDriver::new(&settings.k8s, &settings.container)?),
+        other => return Err(anyhow!("Unknown orchestrator {other}, it is docker or kubernetes")),
+    };
+
+    *DRIVER.write().unwrap() = driver;
+    Ok(())
+}
+
+/// The driver apps run on
+pub fn driver() -> Arc<dyn Driver> {
+    DRIVER.read().unwrap().clone()
+}
+
+/// Runs apps as containers on the docker of the host they are placed on
+pub struct DockerDriver;
+
+#[async_trait]
+impl Driver for DockerDriver {
+    async fn run_web(
+        &self,
+        container_name: &str,
+        image: &str,
+        release_config: &ReleaseConfig,
+        db_url: &str,
+        healthcheck_path: Option<&str>,
+        h2c: bool,
+        container_settings: &ContainerSettings,
+        secrets: &SecretCipher,
+    ) -> Result<(String, String)> {
+        let docker = nodes::docker(container_name).map_err(|err| {
+            tracing::error!("Failed to connect to docker: {}", err);
+            err
+        })?;
+
+        run_container(
+            &docker,
+            container_name,
+            image,
+            release_config,
+            db_url,
+            healthcheck_path,
+            h2c,
+            container_settings,
+            secrets,
+        )
+        .await
+    }
+
+    async fn promote_web(
+        &self,
+        container_name: &str,
+        container_id: &str,
+        container_settings: &ContainerSettings,
+    ) -> Result<()> {
+        promote_container(container_name, container_id, container_settings).await
+    }
+
+    async fn run_processes(
+        &self,
+        container_name: &str,
+        image: &str,
+        release_config: &ReleaseConfig,
+        db_url: &str,
+        formation: &BTreeMap<String, i64>,
+        restart: bool,
+        container_settings: &ContainerSettings,
+        secrets: &SecretCipher,
+    ) -> Result<()> {
+        run_workers(
+            container_name,
+            image,
+            release_config,
+            db_url,
+            formation,
+            restart,
+            container_settings,
+            secrets,
+        )
+        .await
+    }
+
+    async fn address(&self, container_name: &str, container_id: &str) -> Result<Option<String>> {
+        let docker = nodes::docker(container_name)?;
+        let inspect = docker.inspect_container(container_id, None).await?;
+        if inspect.state.as_ref().and_then(|state| state.running) == Some(false) {
+            return Ok(None);
+        }
+
+        inspect
+            .network_settings
+            .and_then(|network| network.networks)
+            .and_then(|networks| networks.get(&format!("{}-network", container_name)).cloned())
+            .and_then(|network| network.ip_address)
+            .filter(|ip_address| !ip_address.is_empty())
+            .map(Some)
+            .ok_or_else(|| anyhow!("Container is not on the project network"))
+    }
+
+    async fn running_since(&self, container_name: &str, container_id: &str) -> Result<Option<DateTime<Utc>>> {
+        let docker = nodes::docker(container_name)?;
+        let state = docker
+            .inspect_container(container_id, None)
+            .await?
+            .state
+            .unwrap_or_default();
+        if state.running != Some(true) {
+            return Ok(None);
+        }
+
+        Ok(Some(
+            state
+                .started_at
+                .and_then(|started_at| DateTime::parse_from_rfc3339(&started_at).ok())
+                .map(|started_at| started_at.with_timezone(&Utc))
+                .unwrap_or_else(Utc::now),
+        ))
+    }
+
+    async fn exists(&self, container_name: &str, container_id: &str) -> Result<bool> {
+        let docker = nodes::docker(container_name)?;
+        match docker.inspect_container(container_id, None).await {
+            Ok(_) => Ok(true),
+            Err(bollard::errors::Error::DockerResponseServerError { status_code: 404, .. }) => Ok(false),
+            Err(err) => Err(err.into()),
+        }
+    }
+
+    async fn stop_web(
+        &self,
+        container_name: &str,
+        container_id: &str,
+        container_settings: &ContainerSettings,
+    ) -> Result<()> {
+        stop_web(container_name, container_id, container_settings).await
+    }
+
+    async fn start_web(
+        &self,
+        container_name: &str,
+        container_id: &str,
+        port: i32,
+        healthcheck_path: Option<&str>,
+        h2c: bool,
+        timeout: u64,
+    ) -> Result<String> {
+        start_web(container_name, container_id, port, healthcheck_path, h2c, timeout).await
+    }
+
+    async fn suspend(&self, container_name: &str, container_settings: &ContainerSettings) -> Result<()> {
+        suspend_containers(container_name, container_settings).await
+    }
+
+    async fn resume(&self, container_name: &str) -> Result<()> {
+        resume_containers(container_name).await
+    }
+
+    async fn remove(&self, container_name: &str) -> Result<()> {
+        remove_workers(container_name).await
+    }
+
+    async fn apply_egress(
+        &self,
+        container_name: &str,
+        ranges: Option<&[Cidr]>,
+        container_settings: &ContainerSettings,
+    ) -> Result<()> {
+        let docker = nodes::docker(container_name)?;
+        apply_rules(&docker, container_name, ranges, container_settings).await
+    }
+
+    fn side_by_side(&self) -> bool {
+        true
+    }
+}
@@ -0,0 +1,136 @@
+use axum::extract::{Path, State};
+use axum::response::Response;
+use axum::Json;
+use garde::{Unvalidated, Validate};
+use hyper::{Body, StatusCode};
+use serde::{Deserialize, Serialize};
+use ulid::Ulid;
+use uuid::Uuid;
+
+use super::view_env_groups::EnvGroup;
+use crate::owner::{member_role, Role};
+use crate::{auth::Auth, startup::AppState};
+
+#[derive(Deserialize, Validate, Debug)]
+pub struct CreateEnvGroupRequest {
+    /// like a project name
+    #[garde(pattern("^[a-z0-9][a-z0-9-]{0,39}$"))]
+    pub name: String,
+}
+
+#[derive(Serialize, Debug)]
+struct ErrorResponse {
+    message: String,
+}
+
+/// Creates an empty env group of the owner, variables are set on it one by one
+#[tracing::instrument(skip(auth, pool))]
+pub async fn post(
+    auth: Auth,
+    State(AppState { pool, .. }): State<AppState>,
+    Path(owner): Path<String>,
+    Json(req): Json<Unvalidated<CreateEnvGroupRequest>>,
+) -> Response<Body> {
+    let user = auth.current_user.unwrap();
+
+    let CreateEnvGroupRequest { name } = match req.validate(&()) {
+        Ok(valid) => valid.into_inner(),
+        Err(err) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: err.to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    match member_role(user.id, &owner, &pool).await {
+        Ok(Some(role)) if role >= Role::Maintainer => {}
+        Ok(Some(role)) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Only a maintainer of {owner} can manage its env groups, you are a {role}")
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::FORBIDDEN)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Ok(None) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Owner does not exist".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Err(err) => {
+            tracing::error!(?err, "Can't create env group: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    }
+
+    let group = match sqlx::query!(
+        r#"INSERT INTO env_groups (id, owner_id, name)
+           SELECT $1, id, $3 FROM project_owners WHERE name = $2
+           ON CONFLICT (owner_id, name) DO NOTHING
+           RETURNING updated_at
+        "#,
+        Uuid::from(Ulid::new()),
+        owner,
+        name
+    )
+    .fetch_optional(&pool)
+    .await
+    {
+        Ok(Some(group)) => group,
+        Ok(None) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("{owner} already has an env group named {name}")
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::CONFLICT)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Err(err) => {
+            tracing::error!(?err, "Can't create env group: Failed to insert into database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Failed to insert into database".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    let json = serde_json::to_string(&EnvGroup {
+        name,
+        env: serde_json::json!({}),
+        secrets: Vec::new(),
+        apps: Vec::new(),
+        updated_at: group.updated_at,
+    }).unwrap();
+
+    Response::builder()
+        .status(StatusCode::CREATED)
+        .body(Body::from(json))
+        .unwrap()
+}
@@ -23,9 +23,9 @@ pub struct CreateProjectOwnerRequest {
     pub name: String,
 }
 
-#[tracing::instrument(skip(_auth, pool))]
+#[tracing::instrument(skip(auth, pool))]
 pub async fn post(
-    _auth: Auth,
+    auth: Auth,
     State(AppState { pool, .. }): State<AppState>,
     Form(req): Form<Unvalidated<CreateProjectOwnerRequest>>,
 ) -> Response<Body> {
@@ -146,6 +146,61 @@ pub async fn post(
             .unwrap();
     }
 
+    // whoever creates the owner owns it, they invite the rest
+    if let Err(err) = sqlx::query!(
+        r#"INSERT INTO users_owners (user_id, owner_id, role)
+        VALUES ($1, $2, 'owner')
+        "#,
+        auth.id,
+        owner_id
+    )
+    .execute(&mut *tx)
+    .await
+    {
+        tracing::error!(
+            ?err,
+            "Can't insert users_owners: Failed to insert into database"
+        );
+        if let Err(err) = tx.rollback().await {
+            tracing::error!(
+                ?err,
+                "Can't insert users_owners: Failed to rollback transaction"
+            );
+        }
+
+        let html = render_to_string(move || {
+            view! {
+                <h1> Failed to insert project owner into database </h1>
+            }
+        })
+        .into_owned();
+
+        return Response::builder()
+            .status(StatusCode::BAD_REQUEST)
+            .header("Content-Type", "text/html")
+            .body(Body::from(html))
+            .unwrap();
+    }
+
+    if let Err(err) = tx.commit().await {
+        tracing::error!(
+            ?err,
+            "Can't insert project owner: Failed to commit transaction"
+        );
+        let html = render_to_string(move || {
+            view! {
+                <h1> Failed to commit transaction {err.to_string()} </h1>
+            }
+        })
+        .into_owned();
+
+        return Response::builder()
+            .status(StatusCode::INTERNAL_SERVER_ERROR)
+            .header("Content-Type", "text/html")
+            .body(Body::from(html))
+            .unwrap();
+    }
+
     Response::builder()
         .status(StatusCode::OK)
         .header("Content-Type", "text/html")
@@ -7,6 +7,7 @@ use uuid::Uuid;
 
 use crate::{
     auth::Auth,
+    owner::Role,
     startup::AppState,
 };
 
@@ -16,6 +17,9 @@ pub struct InviteRequest {
     pub owner_id: Option<Uuid>,
     #[garde(required)]
     pub username: Option<String>,
+    /// maintainer when left out
+    #[garde(skip)]
+    pub role: Option<Role>,
 }
 
 #[tracing::instrument(skip(auth, pool))]
@@ -37,11 +41,12 @@ pub async fn post(
 
     let owner_id = validated_request.owner_id.unwrap();
     let invited_username = validated_request.username.unwrap();
+    let role = validated_request.role.unwrap_or(Role::Maintainer);
 
-    // Check if requesting user is already in owner group
+    // Check if requesting user is an owner of the owner group, only they manage members
     match sqlx::query!(
         r#"SELECT user_id, owner_id FROM users_owners
-        WHERE user_id = $1 AND owner_id = $2
+        WHERE user_id = $1 AND owner_id = $2 AND role = 'owner'
         "#,
         authed_user_id,
         owner_id,
@@ -130,10 +135,11 @@ pub async fn post(
     };
 
     if let Err(err) = sqlx::query!(
-        r#"INSERT INTO users_owners (user_id, owner_id)
-        VALUES ($1, $2)"#,
+        r#"INSERT INTO users_owners (user_id, owner_id, role)
+        VALUES ($1, $2, $3)"#,
         invited_user,
         owner_id,
+        role as Role,
     )
     .execute(&mut *tx)
     .await
@@ -1,15 +1,25 @@
-use axum::{middleware, routing::post, Router};
+use axum::{middleware, routing::{get, post}, Router};
 use axum_extra::routing::RouterExt;
 use hyper::Body;
 
-use crate::{auth::auth, configuration::Settings, startup::AppState};
+use crate::{audit::audit_trail, auth::auth, configuration::Settings, startup::AppState};
 
 mod create_project_owner;
 mod update_project_owner;
 mod invite_project_member;
 mod remove_project_member;
+mod view_owner_members;
+mod set_owner_member;
+mod remove_owner_member;
+mod set_template;
+mod remove_template;
+mod view_env_groups;
+mod create_env_group;
+mod set_env_group_var;
+mod remove_env_group_var;
+mod delete_env_group;
 
-pub async fn router(_state: AppState, _config: &Settings) -> Router<AppState, Body> {
+pub async fn router(state: AppState, _config: &Settings) -> Router<AppState, Body> {
     Router::new()
         .route_with_tsr(
             "/owner",
@@ -23,5 +33,42 @@ pub async fn router(_state: AppState, _config: &Settings) -> Router<AppState, Bo
             "/owner/:owner_id/invite",
             post(invite_project_member::post),
         )
+        .route_with_tsr(
+            "/owner/:owner_id/members/:user_id/remove",
+            post(remove_project_member::post),
+        )
+        .route_with_tsr(
+            "/api/owner/:owner/members",
+            get(view_owner_members::get).post(set_owner_member::post),
+        )
+        .route_with_tsr(
+            "/api/owner/:owner/members/:username/delete",
+            post(remove_owner_member::post),
+        )
+        .route_with_tsr(
+            "/api/owner/:owner/templates",
+            post(set_template::post),
+        )
+        .route_with_tsr(
+            "/api/owner/:owner/templates/:name/delete",
+            post(remove_template::post),
+        )
+        .route_with_tsr(
+            "/api/owner/:owner/env-groups",
+            get(view_env_groups::get).post(create_env_group::post),
+        )
+        .route_with_tsr(
+            "/api/owner/:owner/env-groups/:group/env",
+            post(set_env_group_var::post),
+        )
+        .route_with_tsr(
+            "/api/owner/:owner/env-groups/:group/env/delete",
+            post(remove_env_group_var::post),
+        )
+        .route_with_tsr(
+            "/api/owner/:owner/env-groups/:group/delete",
+            post(delete_env_group::post),
+        )
         .route_layer(middleware::from_fn(auth))
+        .route_layer(middleware::from_fn_with_state(state, audit_trail))
 }
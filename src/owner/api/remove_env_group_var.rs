@@ -0,0 +1,161 @@
+use axum::extract::{Path, State};
+use axum::response::Response;
+use axum::Json;
+use garde::{Unvalidated, Validate};
+use hyper::{Body, StatusCode};
+use serde::{Deserialize, Serialize};
+
+use crate::audit::{environ_change, with_change, AuditChange};
+use crate::owner::{member_role, Role};
+use crate::{auth::Auth, startup::AppState};
+
+#[derive(Deserialize, Validate, Debug)]
+pub struct RemoveEnvGroupVarRequest {
+    #[garde(length(min=1))]
+    pub key: String,
+}
+
+#[derive(Serialize, Debug)]
+struct ErrorResponse {
+    message: String,
+}
+
+/// Removes a variable or secret of an env group, apps attached to it lose it with their next release
+#[tracing::instrument(skip(auth, pool))]
+pub async fn post(
+    auth: Auth,
+    State(AppState { pool, .. }): State<AppState>,
+    Path((owner, group)): Path<(String, String)>,
+    Json(req): Json<Unvalidated<RemoveEnvGroupVarRequest>>,
+) -> Response<Body> {
+    let user = auth.current_user.unwrap();
+
+    let RemoveEnvGroupVarRequest { key } = match req.validate(&()) {
+        Ok(valid) => valid.into_inner(),
+        Err(err) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: err.to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    match member_role(user.id, &owner, &pool).await {
+        Ok(Some(role)) if role >= Role::Maintainer => {}
+        Ok(Some(role)) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Only a maintainer of {owner} can manage its env groups, you are a {role}")
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::FORBIDDEN)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Ok(None) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Owner does not exist".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Err(err) => {
+            tracing::error!(?err, "Can't remove env group variable: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    }
+
+    let record = match sqlx::query!(
+        r#"SELECT env_groups.id, env_groups.environs, env_groups.secrets
+           FROM env_groups
+           JOIN project_owners ON project_owners.id = env_groups.owner_id
+           WHERE project_owners.name = $1 AND env_groups.name = $2
+        "#,
+        owner,
+        group
+    )
+    .fetch_optional(&pool)
+    .await
+    {
+        Ok(Some(record)) => record,
+        Ok(None) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("{owner} has no env group named {group}")
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Err(err) => {
+            tracing::error!(?err, "Can't remove env group variable: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    let Some(before) = environ_change(&record.environs, &record.secrets, &key) else {
+        let json = serde_json::to_string(&ErrorResponse {
+            message: format!("{group} has no variable named {key}")
+        }).unwrap();
+
+        return Response::builder()
+            .status(StatusCode::BAD_REQUEST)
+            .body(Body::from(json))
+            .unwrap();
+    };
+
+    if let Err(err) = sqlx::query!(
+        r#"UPDATE env_groups
+           SET environs = env_groups.environs - $1, secrets = env_groups.secrets - $1, updated_at = now()
+           WHERE id = $2
+        "#,
+        key,
+        record.id
+    )
+    .execute(&pool)
+    .await
+    {
+        tracing::error!(?err, "Can't remove env group variable: Failed to update database");
+
+        let json = serde_json::to_string(&ErrorResponse {
+            message: "Failed to update database".to_string()
+        }).unwrap();
+
+        return Response::builder()
+            .status(StatusCode::INTERNAL_SERVER_ERROR)
+            .body(Body::from(json))
+            .unwrap();
+    }
+
+    with_change(
+        Response::builder()
+            .status(StatusCode::NO_CONTENT)
+            .body(Body::empty())
+            .unwrap(),
+        AuditChange::new(Some(before), None),
+    )
+}
@@ -0,0 +1,106 @@
+use axum::extract::{Path, State};
+use axum::response::Response;
+use hyper::{Body, StatusCode};
+use serde::Serialize;
+
+use crate::owner::{member_role, Role};
+use crate::{auth::Auth, startup::AppState};
+
+#[derive(Serialize, Debug)]
+struct ErrorResponse {
+    message: String,
+}
+
+/// Removes a member from the owner. Owners remove anyone, everybody can leave
+#[tracing::instrument(skip(auth, pool))]
+pub async fn post(
+    auth: Auth,
+    State(AppState { pool, .. }): State<AppState>,
+    Path((owner, username)): Path<(String, String)>,
+) -> Response<Body> {
+    let user = auth.current_user.unwrap();
+
+    match member_role(user.id, &owner, &pool).await {
+        Ok(Some(Role::Owner)) => {}
+        Ok(Some(_)) if user.username == username => {}
+        Ok(Some(current)) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Only an owner of {owner} can manage its members, you are a {current}")
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::FORBIDDEN)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Ok(None) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Owner does not exist".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Err(err) => {
+            tracing::error!(?err, "Can't remove member: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    }
+
+    // the last owner stays, nobody could manage the group without them
+    match sqlx::query!(
+        r#"DELETE FROM users_owners
+           USING users, project_owners
+           WHERE users_owners.user_id = users.id AND users_owners.owner_id = project_owners.id
+           AND users.username = $1 AND project_owners.name = $2
+           AND (users_owners.role <> 'owner' OR (
+             SELECT count(*) FROM users_owners owners
+             WHERE owners.owner_id = project_owners.id AND owners.role = 'owner'
+           ) > 1)
+        "#,
+        username,
+        owner
+    )
+    .execute(&pool)
+    .await
+    {
+        Ok(deleted) if deleted.rows_affected() == 0 => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("{username} is not a member of {owner} or its last owner")
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Ok(_) => {}
+        Err(err) => {
+            tracing::error!(?err, "Can't remove member: Failed to delete from database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Failed to delete from database".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    Response::builder()
+        .status(StatusCode::NO_CONTENT)
+        .body(Body::empty())
+        .unwrap()
+}
@@ -13,10 +13,10 @@ pub async fn post(
 ) -> Response<Body> {
     let authed_user_id = auth.id;
 
-    // Check if requesting user is already in owner group
+    // Check if requesting user is an owner of the owner group, only they manage members
     match sqlx::query!(
         r#"SELECT user_id, owner_id FROM users_owners
-        WHERE user_id = $1 AND owner_id = $2
+        WHERE user_id = $1 AND owner_id = $2 AND role = 'owner'
         "#,
         authed_user_id,
         owner_id
@@ -50,32 +50,51 @@ pub async fn post(
         }
     }
 
-    if let Err(err) = sqlx::query!(
+    // the last owner stays, nobody could manage the group without them
+    match sqlx::query!(
         r#"DELETE FROM users_owners
-        WHERE user_id = $1 AND owner_id = $2"#,
+        WHERE user_id = $1 AND owner_id = $2
+        AND (role <> 'owner' OR (SELECT count(*) FROM users_owners WHERE owner_id = $2 AND role = 'owner') > 1)"#,
         user_id,
         owner_id
     )
     .execute(&pool)
     .await
     {
-        tracing::error!(
-            ?err,
-            "Can't delete users_owners: Failed to insert into database"
-        );
+        Ok(deleted) if deleted.rows_affected() == 0 => {
+            let html = render_to_string(move || {
+                view! {
+                    <h1> Member not found or the last owner of the group </h1>
+                }
+            })
+            .into_owned();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .header("Content-Type", "text/html")
+                .body(Body::from(html))
+                .unwrap();
+        }
+        Ok(_) => {}
+        Err(err) => {
+            tracing::error!(
+                ?err,
+                "Can't delete users_owners: Failed to insert into database"
+            );
 
-        let html = render_to_string(move || {
-            view! {
-                <h1> Failed to remove owner group member </h1>
-            }
-        })
-        .into_owned();
+            let html = render_to_string(move || {
+                view! {
+                    <h1> Failed to remove owner group member </h1>
+                }
+            })
+            .into_owned();
 
-        return Response::builder()
-            .status(StatusCode::BAD_REQUEST)
-            .header("Content-Type", "text/html")
-            .body(Body::from(html))
-            .unwrap();
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .header("Content-Type", "text/html")
+                .body(Body::from(html))
+                .unwrap();
+        }
     };
 
     Response::builder()
@@ -0,0 +1,100 @@
+use axum::extract::{Path, State};
+use axum::response::Response;
+use hyper::{Body, StatusCode};
+use serde::Serialize;
+
+use crate::owner::{member_role, Role};
+use crate::{auth::Auth, startup::AppState};
+
+#[derive(Serialize, Debug)]
+struct ErrorResponse {
+    message: String,
+}
+
+/// Removes a template of the owner. Apps started from it keep their code
+#[tracing::instrument(skip(auth, pool))]
+pub async fn post(
+    auth: Auth,
+    State(AppState { pool, .. }): State<AppState>,
+    Path((owner, name)): Path<(String, String)>,
+) -> Response<Body> {
+    let user = auth.current_user.unwrap();
+
+    match member_role(user.id, &owner, &pool).await {
+        Ok(Some(role)) if role >= Role::Maintainer => {}
+        Ok(Some(role)) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Only a maintainer of {owner} can manage its templates, you are a {role}")
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::FORBIDDEN)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Ok(None) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Owner does not exist".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Err(err) => {
+            tracing::error!(?err, "Can't remove template: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    }
+
+    match sqlx::query!(
+        r#"DELETE FROM templates
+           USING project_owners
+           WHERE templates.owner_id = project_owners.id
+           AND project_owners.name = $1 AND templates.name = $2
+        "#,
+        owner,
+        name
+    )
+    .execute(&pool)
+    .await
+    {
+        Ok(deleted) if deleted.rows_affected() == 0 => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("{owner} has no template named {name}")
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Ok(_) => {}
+        Err(err) => {
+            tracing::error!(?err, "Can't remove template: Failed to delete from database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Failed to delete from database".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    Response::builder()
+        .status(StatusCode::NO_CONTENT)
+        .body(Body::empty())
+        .unwrap()
+}
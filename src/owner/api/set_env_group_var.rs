@@ -0,0 +1,202 @@
+use axum::extract::{Path, State};
+use axum::response::Response;
+use axum::Json;
+use garde::{Unvalidated, Validate};
+use hyper::{Body, StatusCode};
+use serde::{Deserialize, Serialize};
+
+use crate::audit::{environ_change, with_change, AuditChange, MASKED};
+use crate::owner::{member_role, Role};
+use crate::{auth::Auth, startup::AppState};
+
+#[derive(Deserialize, Validate, Debug)]
+pub struct SetEnvGroupVarRequest {
+    #[garde(length(min=1))]
+    pub key: String,
+    #[garde(length(min=1))]
+    pub value: String,
+    /// secrets are stored encrypted and their value is never shown again
+    #[serde(default)]
+    #[garde(skip)]
+    pub secret: bool,
+}
+
+#[derive(Serialize, Debug)]
+struct ErrorResponse {
+    message: String,
+}
+
+/// Sets a variable of an env group. Apps attached to it aren't released again, they see the
+/// change with their next release so a shared key doesn't restart every app at once
+#[tracing::instrument(skip(auth, pool, secrets, req))]
+pub async fn post(
+    auth: Auth,
+    State(AppState { pool, secrets, .. }): State<AppState>,
+    Path((owner, group)): Path<(String, String)>,
+    Json(req): Json<Unvalidated<SetEnvGroupVarRequest>>,
+) -> Response<Body> {
+    let user = auth.current_user.unwrap();
+
+    let SetEnvGroupVarRequest { key, value, secret } = match req.validate(&()) {
+        Ok(valid) => valid.into_inner(),
+        Err(err) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: err.to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    match member_role(user.id, &owner, &pool).await {
+        Ok(Some(role)) if role >= Role::Maintainer => {}
+        Ok(Some(role)) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Only a maintainer of {owner} can manage its env groups, you are a {role}")
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::FORBIDDEN)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Ok(None) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Owner does not exist".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Err(err) => {
+            tracing::error!(?err, "Can't set env group variable: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    }
+
+    let record = match sqlx::query!(
+        r#"SELECT env_groups.id, env_groups.environs, env_groups.secrets
+           FROM env_groups
+           JOIN project_owners ON project_owners.id = env_groups.owner_id
+           WHERE project_owners.name = $1 AND env_groups.name = $2
+        "#,
+        owner,
+        group
+    )
+    .fetch_optional(&pool)
+    .await
+    {
+        Ok(Some(record)) => record,
+        Ok(None) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("{owner} has no env group named {group}")
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Err(err) => {
+            tracing::error!(?err, "Can't set env group variable: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    let before = environ_change(&record.environs, &record.secrets, &key);
+    let after = serde_json::json!({ &key: match secret {
+        true => MASKED,
+        false => value.as_str(),
+    } });
+
+    // a key is either a plain variable or a secret, setting one removes the other
+    let query = if secret {
+        let value = match secrets.encrypt(&value) {
+            Ok(value) => value,
+            Err(err) => {
+                tracing::error!(?err, "Can't set env group secret: Failed to encrypt secret");
+
+                let json = serde_json::to_string(&ErrorResponse {
+                    message: "Secrets are not enabled on this server".to_string()
+                }).unwrap();
+
+                return Response::builder()
+                    .status(StatusCode::BAD_REQUEST)
+                    .body(Body::from(json))
+                    .unwrap();
+            }
+        };
+
+        sqlx::query!(
+            r#"UPDATE env_groups
+               SET secrets = jsonb_set(env_groups.secrets, $1, $2, true),
+                   environs = env_groups.environs - $3,
+                   updated_at = now()
+               WHERE id = $4
+            "#,
+            &[key.clone()],
+            serde_json::Value::String(value),
+            key,
+            record.id
+        )
+        .execute(&pool)
+        .await
+    } else {
+        sqlx::query!(
+            r#"UPDATE env_groups
+               SET environs = jsonb_set(env_groups.environs, $1, $2, true),
+                   secrets = env_groups.secrets - $3,
+                   updated_at = now()
+               WHERE id = $4
+            "#,
+            &[key.clone()],
+            serde_json::Value::String(value),
+            key,
+            record.id
+        )
+        .execute(&pool)
+        .await
+    };
+
+    if let Err(err) = query {
+        tracing::error!(?err, "Can't set env group variable: Failed to update database");
+
+        let json = serde_json::to_string(&ErrorResponse {
+            message: "Failed to update database".to_string()
+        }).unwrap();
+
+        return Response::builder()
+            .status(StatusCode::INTERNAL_SERVER_ERROR)
+            .body(Body::from(json))
+            .unwrap();
+    }
+
+    with_change(
+        Response::builder()
+            .status(StatusCode::NO_CONTENT)
+            .body(Body::empty())
+            .unwrap(),
+        AuditChange::new(before, Some(after)),
+    )
+}
@@ -0,0 +1,192 @@
+use axum::extract::{Path, State};
+use axum::response::Response;
+use axum::Json;
+use garde::{Unvalidated, Validate};
+use hyper::{Body, StatusCode};
+use serde::{Deserialize, Serialize};
+
+use super::view_owner_members::Member;
+use crate::owner::{member_role, owner_count, Role};
+use crate::{auth::Auth, startup::AppState};
+
+#[derive(Deserialize, Validate, Debug)]
+pub struct SetOwnerMemberRequest {
+    #[garde(length(min = 1, max = 255))]
+    pub username: String,
+    #[garde(skip)]
+    pub role: Role,
+}
+
+#[derive(Serialize, Debug)]
+struct ErrorResponse {
+    message: String,
+}
+
+/// Adds a user to the owner with a role, or changes the role of a member
+#[tracing::instrument(skip(auth, pool))]
+pub async fn post(
+    auth: Auth,
+    State(AppState { pool, .. }): State<AppState>,
+    Path(owner): Path<String>,
+    Json(req): Json<Unvalidated<SetOwnerMemberRequest>>,
+) -> Response<Body> {
+    let user = auth.current_user.unwrap();
+
+    let SetOwnerMemberRequest { username, role } = match req.validate(&()) {
+        Ok(valid) => valid.into_inner(),
+        Err(err) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: err.to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    match member_role(user.id, &owner, &pool).await {
+        Ok(Some(Role::Owner)) => {}
+        Ok(Some(current)) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Only an owner of {owner} can manage its members, you are a {current}")
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::FORBIDDEN)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Ok(None) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Owner does not exist".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Err(err) => {
+            tracing::error!(?err, "Can't set member: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    }
+
+    let member = match sqlx::query!(
+        r#"SELECT users.id AS user_id, users.name, project_owners.id AS owner_id,
+           users_owners.role AS "role?: Role"
+           FROM users
+           CROSS JOIN project_owners
+           LEFT JOIN users_owners ON users_owners.user_id = users.id AND users_owners.owner_id = project_owners.id
+           WHERE users.username = $1 AND project_owners.name = $2
+        "#,
+        username,
+        owner
+    )
+    .fetch_optional(&pool)
+    .await
+    {
+        Ok(Some(member)) => member,
+        Ok(None) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("User not found with username {username}")
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Err(err) => {
+            tracing::error!(?err, "Can't set member: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    // somebody has to be able to manage the group
+    if member.role == Some(Role::Owner) && role != Role::Owner {
+        match owner_count(member.owner_id, &pool).await {
+            Ok(count) if count > 1 => {}
+            Ok(_) => {
+                let json = serde_json::to_string(&ErrorResponse {
+                    message: format!("{username} is the last owner of {owner}, make someone else an owner first")
+                }).unwrap();
+
+                return Response::builder()
+                    .status(StatusCode::BAD_REQUEST)
+                    .body(Body::from(json))
+                    .unwrap();
+            }
+            Err(err) => {
+                tracing::error!(?err, "Can't set member: Failed to query database");
+
+                let json = serde_json::to_string(&ErrorResponse {
+                    message: format!("Failed to query database: {}", err.to_string())
+                }).unwrap();
+
+                return Response::builder()
+                    .status(StatusCode::INTERNAL_SERVER_ERROR)
+                    .body(Body::from(json))
+                    .unwrap();
+            }
+        }
+    }
+
+    let created_at = match sqlx::query!(
+        r#"INSERT INTO users_owners (user_id, owner_id, role)
+           VALUES ($1, $2, $3)
+           ON CONFLICT (user_id, owner_id) DO UPDATE SET role = $3, idp_group = NULL, updated_at = now()
+           RETURNING created_at
+        "#,
+        member.user_id,
+        member.owner_id,
+        role as Role,
+    )
+    .fetch_one(&pool)
+    .await
+    {
+        Ok(row) => row.created_at,
+        Err(err) => {
+            tracing::error!(?err, "Can't set member: Failed to insert into database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Failed to insert into database".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    let json = serde_json::to_string(&Member {
+        username,
+        name: member.name,
+        role,
+        created_at,
+    }).unwrap();
+
+    Response::builder()
+        .status(StatusCode::OK)
+        .body(Body::from(json))
+        .unwrap()
+}
@@ -0,0 +1,174 @@
+use axum::extract::{Path, State};
+use axum::response::Response;
+use axum::Json;
+use garde::{Unvalidated, Validate};
+use hyper::{Body, StatusCode};
+use serde::{Deserialize, Serialize};
+use ulid::Ulid;
+use uuid::Uuid;
+
+use crate::owner::{member_role, Role};
+use crate::{auth::Auth, startup::AppState};
+
+#[derive(Deserialize, Validate, Debug)]
+pub struct SetTemplateRequest {
+    /// used as owner/name, like a project name
+    #[garde(pattern("^[a-z0-9][a-z0-9-]{0,39}$"))]
+    pub name: String,
+    /// app of the owner whose code new apps start from
+    #[garde(length(min = 1))]
+    pub project: String,
+    #[garde(length(max = 280))]
+    #[serde(default)]
+    pub description: String,
+}
+
+#[derive(Serialize, Debug)]
+struct SetTemplateResponse {
+    name: String,
+    project: String,
+    description: String,
+}
+
+#[derive(Serialize, Debug)]
+struct ErrorResponse {
+    message: String,
+}
+
+/// Registers an app of the owner as a template, or points a template at another app. Every
+/// signed in user can start from it and so gets the code of the app, its settings and
+/// secrets stay behind
+#[tracing::instrument(skip(auth, pool))]
+pub async fn post(
+    auth: Auth,
+    State(AppState { pool, .. }): State<AppState>,
+    Path(owner): Path<String>,
+    Json(req): Json<Unvalidated<SetTemplateRequest>>,
+) -> Response<Body> {
+    let user = auth.current_user.unwrap();
+
+    let SetTemplateRequest { name, project, description } = match req.validate(&()) {
+        Ok(valid) => valid.into_inner(),
+        Err(err) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: err.to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    match member_role(user.id, &owner, &pool).await {
+        Ok(Some(role)) if role >= Role::Maintainer => {}
+        Ok(Some(role)) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Only a maintainer of {owner} can manage its templates, you are a {role}")
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::FORBIDDEN)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Ok(None) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Owner does not exist".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Err(err) => {
+            tracing::error!(?err, "Can't set template: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    }
+
+    let project_record = match sqlx::query!(
+        r#"SELECT projects.id, projects.owner_id
+           FROM projects
+           JOIN project_owners ON projects.owner_id = project_owners.id
+           WHERE project_owners.name = $1 AND projects.name = $2
+        "#,
+        owner,
+        project
+    )
+    .fetch_optional(&pool)
+    .await
+    {
+        Ok(Some(record)) => record,
+        Ok(None) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("{owner} has no app named {project}")
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Err(err) => {
+            tracing::error!(?err, "Can't set template: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    if let Err(err) = sqlx::query!(
+        r#"INSERT INTO templates (id, owner_id, project_id, name, description)
+           VALUES ($1, $2, $3, $4, $5)
+           ON CONFLICT (owner_id, name) DO UPDATE
+           SET project_id = $3, description = $5, updated_at = now()
+        "#,
+        Uuid::from(Ulid::new()),
+        project_record.owner_id,
+        project_record.id,
+        name,
+        description
+    )
+    .execute(&pool)
+    .await
+    {
+        tracing::error!(?err, "Can't set template: Failed to insert into database");
+
+        let json = serde_json::to_string(&ErrorResponse {
+            message: "Failed to insert into database".to_string()
+        }).unwrap();
+
+        return Response::builder()
+            .status(StatusCode::INTERNAL_SERVER_ERROR)
+            .body(Body::from(json))
+            .unwrap();
+    }
+
+    let json = serde_json::to_string(&SetTemplateResponse {
+        name: format!("{owner}/{name}"),
+        project: format!("{owner}/{project}"),
+        description,
+    }).unwrap();
+
+    Response::builder()
+        .status(StatusCode::OK)
+        .body(Body::from(json))
+        .unwrap()
+}
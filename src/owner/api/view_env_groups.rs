@@ -0,0 +1,124 @@
+use axum::extract::{Path, State};
+use axum::response::Response;
+use chrono::{DateTime, Utc};
+use hyper::{Body, StatusCode};
+use serde::Serialize;
+use serde_json::Value;
+
+use crate::owner::member_role;
+use crate::{auth::Auth, startup::AppState};
+
+#[derive(Serialize, Debug)]
+pub struct EnvGroup {
+    pub name: String,
+    pub env: Value,
+    /// only the names, secret values never leave the server
+    pub secrets: Vec<String>,
+    /// apps of the owner that start with the group
+    pub apps: Vec<String>,
+    pub updated_at: DateTime<Utc>,
+}
+
+#[derive(Serialize, Debug)]
+struct EnvGroupListResponse {
+    data: Vec<EnvGroup>,
+}
+
+#[derive(Serialize, Debug)]
+struct ErrorResponse {
+    message: String,
+}
+
+/// Env groups of the owner with the apps they are attached to. Every member can see them,
+/// like the variables of an app
+#[tracing::instrument(skip(auth, pool))]
+pub async fn get(
+    auth: Auth,
+    State(AppState { pool, .. }): State<AppState>,
+    Path(owner): Path<String>,
+) -> Response<Body> {
+    let user = auth.current_user.unwrap();
+
+    match member_role(user.id, &owner, &pool).await {
+        Ok(Some(_)) => {}
+        Ok(None) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Owner does not exist".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Err(err) => {
+            tracing::error!(?err, "Can't get env groups: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    }
+
+    let groups = match sqlx::query!(
+        r#"SELECT env_groups.name, env_groups.environs, env_groups.secrets, env_groups.updated_at,
+                  array_remove(array_agg(projects.name ORDER BY projects.name), NULL) AS "apps!: Vec<String>"
+           FROM env_groups
+           JOIN project_owners ON project_owners.id = env_groups.owner_id
+           LEFT JOIN project_env_groups ON project_env_groups.group_id = env_groups.id
+           LEFT JOIN projects ON projects.id = project_env_groups.project_id
+           WHERE project_owners.name = $1
+           GROUP BY env_groups.id
+           ORDER BY env_groups.name
+        "#,
+        owner
+    )
+    .fetch_all(&pool)
+    .await
+    {
+        Ok(groups) => groups,
+        Err(err) => {
+            tracing::error!(?err, "Can't get env groups: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    let data = groups
+        .into_iter()
+        .map(|group| EnvGroup {
+            name: group.name,
+            env: group.environs,
+            secrets: group
+                .secrets
+                .as_object()
+                .map(|secrets| secrets.keys().cloned().collect())
+                .unwrap_or_default(),
+            apps: group
+                .apps
+                .iter()
+                .map(|app| app.trim_end_matches(".git").to_string())
+                .collect(),
+            updated_at: group.updated_at,
+        })
+        .collect();
+
+    let json = serde_json::to_string(&EnvGroupListResponse { data }).unwrap();
+
+    Response::builder()
+        .status(StatusCode::OK)
+        .body(Body::from(json))
+        .unwrap()
+}
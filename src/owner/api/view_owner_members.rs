@@ -0,0 +1,108 @@
+use axum::extract::{Path, State};
+use axum::response::Response;
+use chrono::{DateTime, Utc};
+use hyper::{Body, StatusCode};
+use serde::Serialize;
+
+use crate::owner::{member_role, Role};
+use crate::{auth::Auth, startup::AppState};
+
+#[derive(Serialize, Debug)]
+pub struct Member {
+    pub username: String,
+    pub name: String,
+    pub role: Role,
+    /// when they joined
+    pub created_at: DateTime<Utc>,
+}
+
+#[derive(Serialize, Debug)]
+struct ViewOwnerMembersResponse {
+    data: Vec<Member>,
+}
+
+#[derive(Serialize, Debug)]
+struct ErrorResponse {
+    message: String,
+}
+
+#[tracing::instrument(skip(auth, pool))]
+pub async fn get(
+    auth: Auth,
+    State(AppState { pool, .. }): State<AppState>,
+    Path(owner): Path<String>,
+) -> Response<Body> {
+    let user = auth.current_user.unwrap();
+
+    // every member sees who else is in the group
+    match member_role(user.id, &owner, &pool).await {
+        Ok(Some(_)) => {}
+        Ok(None) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Owner does not exist".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Err(err) => {
+            tracing::error!(?err, "Can't get members: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    }
+
+    let members = match sqlx::query!(
+        r#"SELECT users.username, users.name, users_owners.role AS "role: Role", users_owners.created_at
+           FROM users_owners
+           JOIN users ON users.id = users_owners.user_id
+           JOIN project_owners ON project_owners.id = users_owners.owner_id
+           WHERE project_owners.name = $1
+           ORDER BY users_owners.role, users.username
+        "#,
+        owner
+    )
+    .fetch_all(&pool)
+    .await
+    {
+        Ok(members) => members,
+        Err(err) => {
+            tracing::error!(?err, "Can't get members: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    let data = members
+        .into_iter()
+        .map(|member| Member {
+            username: member.username,
+            name: member.name,
+            role: member.role,
+            created_at: member.created_at,
+        })
+        .collect();
+
+    let json = serde_json::to_string(&ViewOwnerMembersResponse { data }).unwrap();
+
+    Response::builder()
+        .status(StatusCode::OK)
+        .body(Body::from(json))
+        .unwrap()
+}
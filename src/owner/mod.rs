@@ -1 +1,230 @@
+use std::collections::HashMap;
+
+use axum::{
+    extract::{Path, State},
+    middleware::Next,
+    response::Response,
+};
+use bytes::Bytes;
+use http_body::combinators::UnsyncBoxBody;
+use hyper::{Body, Method, Request, StatusCode};
+use serde::{Deserialize, Serialize};
+use sqlx::PgPool;
+use uuid::Uuid;
+
+use crate::{auth::{tokens::TokenAccess, Auth}, startup::AppState};
+
 pub mod api;
+
+/// What a member can do with the apps of an owner. Every role can do everything the roles
+/// before it can: viewers read, maintainers deploy and configure, owners also manage the
+/// members and delete apps
+#[derive(Serialize, Deserialize, Debug, Clone, Copy, PartialEq, Eq, PartialOrd, Ord, sqlx::Type)]
+#[sqlx(type_name = "member_role", rename_all = "lowercase")]
+#[serde(rename_all = "lowercase")]
+pub enum Role {
+    Viewer,
+    Maintainer,
+    Owner,
+}
+
+impl std::fmt::Display for Role {
+    fn fmt(&self, f: &mut std::fmt::Formatter) -> std::fmt::Result {
+        match self {
+            Role::Viewer => write!(f, "viewer"),
+            Role::Maintainer => write!(f, "maintainer"),
+            Role::Owner => write!(f, "owner"),
+        }
+    }
+}
+
+#[derive(Serialize, Debug)]
+struct ErrorResponse {
+    message: String,
+}
+
+/// Role of the user in the owner, None when they aren't a member
+pub async fn member_role(user_id: Uuid, owner: &str, pool: &PgPool) -> Result<Option<Role>, sqlx::Error> {
+    let member = sqlx::query!(
+        r#"SELECT users_owners.role AS "role: Role"
+           FROM users_owners
+           JOIN project_owners ON project_owners.id = users_owners.owner_id
+           WHERE users_owners.user_id = $1 AND project_owners.name = $2
+        "#,
+        user_id,
+        owner
+    )
+    .fetch_optional(pool)
+    .await?;
+
+    Ok(member.map(|member| member.role))
+}
+
+/// Members of an owner that are owners, an owner can't be left without one
+pub async fn owner_count(owner_id: Uuid, pool: &PgPool) -> Result<i64, sqlx::Error> {
+    let owners = sqlx::query!(
+        r#"SELECT count(*) AS "count!" FROM users_owners WHERE owner_id = $1 AND role = 'owner'"#,
+        owner_id
+    )
+    .fetch_one(pool)
+    .await?;
+
+    Ok(owners.count)
+}
+
+async fn project_matches(project_id: Uuid, owner: &str, project: &str, pool: &PgPool) -> Result<bool, sqlx::Error> {
+    let project = sqlx::query!(
+        r#"SELECT projects.id FROM projects
+           JOIN project_owners ON projects.owner_id = project_owners.id
+           WHERE projects.id = $1 AND project_owners.name = $2 AND projects.name = $3
+        "#,
+        project_id,
+        owner,
+        project.trim_end_matches(".git")
+    )
+    .fetch_optional(pool)
+    .await?;
+
+    Ok(project.is_some())
+}
+
+/// Why the app is suspended, None when it isn't
+pub async fn suspension(owner: &str, project: &str, pool: &PgPool) -> Result<Option<String>, sqlx::Error> {
+    let project = sqlx::query!(
+        r#"SELECT COALESCE(projects.suspended_reason, 'no reason given') AS "reason!"
+           FROM projects
+           JOIN project_owners ON projects.owner_id = project_owners.id
+           WHERE project_owners.name = $1 AND projects.name = $2 AND projects.suspended_at IS NOT NULL
+        "#,
+        owner,
+        project.trim_end_matches(".git")
+    )
+    .fetch_optional(pool)
+    .await?;
+
+    Ok(project.map(|project| project.reason))
+}
+
+/// The role a request to `/api/project/:owner/:project{rest}` needs. Reading is for viewers,
+/// except what exposes the data of the app: its environment, its audit log, its export, the
+/// files of its volumes, profiles of its memory, shells into its containers and the webhook
+/// secrets of its linked repo and notification hooks. Changing the
+/// pipeline is for owners, it can turn off the approval of promotions
+fn required_role(method: &Method, rest: &str) -> Role {
+    let rest = rest.trim_end_matches('/');
+    if rest == "/delete" || (rest == "/pipeline" && *method == Method::POST) {
+        return Role::Owner;
+    }
+
+    let reads_data = rest == "/env"
+        || rest == "/audit"
+        || rest == "/export"
+        || rest == "/repo"
+        || rest == "/notifications"
+        || rest.ends_with("/ws")
+        || rest.starts_with("/debug/")
+        || (rest.starts_with("/releases/") && rest.contains("/diff/"))
+        || (rest.starts_with("/volumes/") && (rest.ends_with("/files") || rest.ends_with("/download")));
+
+    match *method {
+        Method::GET | Method::HEAD if !reads_data => Role::Viewer,
+        _ => Role::Maintainer,
+    }
+}
+
+/// Lets a request to an app through when the user is a member of its owner with a role that
+/// may make it. Runs after [`crate::auth::auth`], routes without an owner are left alone
+pub async fn authorize<B>(
+    State(AppState { pool, .. }): State<AppState>,
+    auth: Auth,
+    path: Option<Path<HashMap<String, String>>>,
+    request: Request<B>,
+    next: Next<B>,
+) -> Result<Response<UnsyncBoxBody<Bytes, axum::Error>>, Response<Body>> {
+    let (Some(user), Some(Path(params))) = (auth.current_user, path) else {
+        return Ok(next.run(request).await);
+    };
+    let (Some(owner), Some(project)) = (params.get("owner"), params.get("project")) else {
+        return Ok(next.run(request).await);
+    };
+
+    let prefix = format!("/api/project/{owner}/{project}");
+    let rest = request.uri().path().strip_prefix(&prefix).unwrap_or_default().to_string();
+    let needed = required_role(request.method(), &rest);
+
+    let error = |status: StatusCode, message: String| {
+        let json = serde_json::to_string(&ErrorResponse { message }).unwrap();
+        Response::builder().status(status).body(Body::from(json)).unwrap()
+    };
+
+    // tokens can do less than their user. One app's token doesn't reveal whether others exist
+    if let Some(access) = request.extensions().get::<TokenAccess>().cloned() {
+        if !access.scope.allows(needed, &rest) {
+            return Err(error(
+                StatusCode::FORBIDDEN,
+                format!("A {} token can't do this", access.scope),
+            ));
+        }
+
+        if let Some(project_id) = access.project_id {
+            match project_matches(project_id, owner, project, &pool).await {
+                Ok(true) => {}
+                Ok(false) => return Err(error(StatusCode::BAD_REQUEST, "Project does not exist".to_string())),
+                Err(err) => {
+                    tracing::error!(?err, "Can't authorize request: Failed to query database");
+                    return Err(error(
+                        StatusCode::INTERNAL_SERVER_ERROR,
+                        format!("Failed to query database: {err}"),
+                    ));
+                }
+            }
+        }
+    }
+
+    // anyone signed in clones an app marked cloneable, the handler asks the others for a role
+    if rest.trim_end_matches('/') == "/clone" {
+        return Ok(next.run(request).await);
+    }
+
+    match member_role(user.id, owner, &pool).await {
+        Ok(Some(role)) if role >= needed => {}
+        Ok(Some(role)) => {
+            return Err(error(
+                StatusCode::FORBIDDEN,
+                format!("Only a {needed} of {owner} can do this, you are a {role}"),
+            ))
+        }
+        // the same answer as for apps that don't exist, so apps of others can't be probed
+        Ok(None) => return Err(error(StatusCode::BAD_REQUEST, "Project does not exist".to_string())),
+        Err(err) => {
+            tracing::error!(?err, "Can't authorize request: Failed to query database");
+            return Err(error(
+                StatusCode::INTERNAL_SERVER_ERROR,
+                format!("Failed to query database: {err}"),
+            ));
+        }
+    }
+
+    // members still see a suspended app, only a platform admin brings it back. an app the
+    // cleanup stopped its members restore themselves
+    if needed > Role::Viewer && rest.trim_end_matches('/') != "/cleanup/restore" {
+        match suspension(owner, project, &pool).await {
+            Ok(Some(reason)) => {
+                return Err(error(
+                    StatusCode::FORBIDDEN,
+                    format!("{owner}/{project} is suspended: {reason}"),
+                ))
+            }
+            Ok(None) => {}
+            Err(err) => {
+                tracing::error!(?err, "Can't authorize request: Failed to query database");
+                return Err(error(
+                    StatusCode::INTERNAL_SERVER_ERROR,
+                    format!("Failed to query database: {err}"),
+                ));
+            }
+        }
+    }
+
+    Ok(next.run(request).await)
+}
@@ -0,0 +1,42 @@
+//! Cursor pagination of the list endpoints. Their rows come newest first, ordered by the time
+//! and the id of a row, and the cursor of a page is the time and id of its last row. The next
+//! page starts right after it, so rows added meanwhile don't shift the pages like an offset
+//! would
+
+use chrono::{DateTime, SecondsFormat, Utc};
+use uuid::Uuid;
+
+/// rows of a page when the request doesn't say
+pub const DEFAULT_LIMIT: i64 = 50;
+
+/// most rows one page holds
+pub const MAX_LIMIT: i64 = 200;
+
+pub const INVALID_CURSOR: &str = "Invalid cursor, pass the next_cursor of the page before";
+
+/// Rows of a page, `limit` within 1 and [`MAX_LIMIT`]
+pub fn limit(limit: Option<i64>) -> i64 {
+    limit.unwrap_or(DEFAULT_LIMIT).clamp(1, MAX_LIMIT)
+}
+
+/// The time and id a page ends with, rows of one time are ordered by id
+pub fn parse_cursor(cursor: &str) -> Option<(DateTime<Utc>, Uuid)> {
+    let (created_at, id) = cursor.split_once('_')?;
+    let created_at = DateTime::parse_from_rfc3339(created_at).ok()?.with_timezone(&Utc);
+    Some((created_at, Uuid::parse_str(id).ok()?))
+}
+
+pub fn cursor(created_at: DateTime<Utc>, id: Uuid) -> String {
+    format!("{}_{}", created_at.to_rfc3339_opts(SecondsFormat::Micros, true), id)
+}
+
+/// The cursor of the page after `rows`, None once it is the last one. A short page is the last
+pub fn next_cursor<T>(rows: &[T], limit: i64, key: impl Fn(&T) -> (DateTime<Utc>, Uuid)) -> Option<String> {
+    match rows.len() as i64 == limit {
+        true => rows.last().map(|row| {
+            let (created_at, id) = key(row);
+            cursor(created_at, id)
+        }),
+        false => None,
+    }
+}
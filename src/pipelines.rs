@@ -0,0 +1,227 @@
+//! Pipelines chain apps into stages, like dev, staging and production. An app points at the
+//! stage after it with `pipeline_next_id`, and promoting a release runs its image there
+//! without building it again, see [`BuildKind::Release`]. Every app keeps its own environment
+//! and addons, only the image moves on
+//!
+//! A stage with `promotion_approval` doesn't run promoted releases right away. They wait in
+//! `promotions` until a maintainer of it other than the one who promoted approves, so a
+//! release reaches production with two people having looked at it. Only owners of the app
+//! can turn it off, see [`crate::owner`]
+
+use anyhow::{anyhow, Result};
+use chrono::Utc;
+use serde::Serialize;
+use sqlx::PgPool;
+use tokio::sync::mpsc::Sender;
+use ulid::Ulid;
+use uuid::Uuid;
+
+use crate::notifications::{Event, Notifier, Payload};
+use crate::queue::{BuildKind, BuildQueueItem};
+use crate::telemetry::current_context;
+
+/// longest pipeline, more stages than this is a loop gone wrong
+pub const MAX_STAGES: usize = 10;
+
+#[derive(Serialize, Debug, Clone)]
+pub struct Stage {
+    #[serde(skip)]
+    pub id: Uuid,
+    /// owner/project
+    pub app: String,
+    /// the live release, None before the first deploy
+    pub release_id: Option<Uuid>,
+    pub promotion_approval: bool,
+}
+
+/// The app a release is promoted to
+#[derive(Debug)]
+pub struct Target {
+    pub id: Uuid,
+    pub owner: String,
+    pub project: String,
+    pub promotion_approval: bool,
+}
+
+#[derive(Debug)]
+pub enum Promotion {
+    /// the release runs on the target once the queue gets to it
+    Queued,
+    /// waits for an approval, the id of its row in promotions
+    Pending(Uuid),
+}
+
+async fn stage(project_id: Uuid, pool: &PgPool) -> Result<Option<(Stage, Option<Uuid>)>> {
+    let project = sqlx::query!(
+        r#"SELECT projects.id, project_owners.name AS owner, projects.name, projects.pipeline_next_id,
+                  projects.promotion_approval,
+                  (SELECT releases.id FROM releases WHERE releases.project_id = projects.id
+                   ORDER BY releases.created_at DESC LIMIT 1) AS release_id
+           FROM projects
+           JOIN project_owners ON projects.owner_id = project_owners.id
+           WHERE projects.id = $1 AND projects.deleted_at IS NULL
+        "#,
+        project_id
+    )
+    .fetch_optional(pool)
+    .await?;
+
+    Ok(project.map(|project| {
+        let stage = Stage {
+            id: project.id,
+            app: format!("{}/{}", project.owner, project.name),
+            release_id: project.release_id,
+            promotion_approval: project.promotion_approval,
+        };
+        (stage, project.pipeline_next_id)
+    }))
+}
+
+/// The app whose next stage this one is, a stage comes after at most one
+async fn previous(project_id: Uuid, pool: &PgPool) -> Result<Option<Uuid>> {
+    let previous = sqlx::query!(
+        "SELECT id FROM projects WHERE pipeline_next_id = $1 AND deleted_at IS NULL",
+        project_id
+    )
+    .fetch_optional(pool)
+    .await?;
+
+    Ok(previous.map(|previous| previous.id))
+}
+
+/// The stages of the pipeline an app is in, from the first to the last. An app outside of
+/// one is a pipeline of itself
+pub async fn stages(project_id: Uuid, pool: &PgPool) -> Result<Vec<Stage>> {
+    let mut first = project_id;
+    for _ in 0..MAX_STAGES {
+        match previous(first, pool).await? {
+            Some(id) if id != project_id => first = id,
+            _ => break,
+        }
+    }
+
+    let mut stages: Vec<Stage> = Vec::new();
+    let mut next = Some(first);
+    while let Some(id) = next {
+        if stages.len() >= MAX_STAGES || stages.iter().any(|stage| stage.id == id) {
+            break;
+        }
+        let Some((stage, after)) = stage(id, pool).await? else {
+            break;
+        };
+        stages.push(stage);
+        next = after;
+    }
+
+    Ok(stages)
+}
+
+/// Why `next` can't be the stage after the app, None when it can
+pub async fn check_next(project_id: Uuid, next_id: Uuid, pool: &PgPool) -> Result<Option<String>> {
+    if next_id == project_id {
+        return Ok(Some("An app can't be the next stage of itself".to_string()));
+    }
+    if previous(next_id, pool).await?.is_some_and(|id| id != project_id) {
+        return Ok(Some("The app already is the next stage of another one".to_string()));
+    }
+
+    let after = stages(next_id, pool).await?;
+    if after.iter().any(|stage| stage.id == project_id) {
+        return Ok(Some("The app already comes after it, pipelines can't loop".to_string()));
+    }
+    let before = stages(project_id, pool).await?;
+    let before = before.iter().take_while(|stage| stage.id != project_id).count() + 1;
+    let after = after.iter().skip_while(|stage| stage.id != next_id).count();
+    if before + after > MAX_STAGES {
+        return Ok(Some(format!("A pipeline has at most {MAX_STAGES} stages")));
+    }
+
+    Ok(None)
+}
+
+/// Queues running a release on the target, like [`promote`] once it is approved
+pub async fn queue(
+    release_id: Uuid,
+    owner: &str,
+    project: &str,
+    base: &str,
+    build_channel: &Sender<BuildQueueItem>,
+) -> Result<()> {
+    build_channel
+        .send(BuildQueueItem {
+            container_name: format!("{owner}-{project}").replace('.', "-"),
+            container_src: format!("{base}/{owner}/{project}.git/master"),
+            owner: owner.to_string(),
+            repo: project.to_string(),
+            kind: BuildKind::Release(release_id),
+            trace: current_context(),
+        })
+        .await
+        .map_err(|_| anyhow!("Build queue is closed"))
+}
+
+/// Promotes a release of `source_id` to the target. A target with promotion_approval gets a
+/// pending promotion instead, its hooks hear about it
+#[allow(clippy::too_many_arguments)]
+pub async fn promote(
+    release_id: Uuid,
+    source_id: Uuid,
+    target: &Target,
+    user_id: Uuid,
+    base: &str,
+    build_channel: &Sender<BuildQueueItem>,
+    notifier: &Notifier,
+    pool: &PgPool,
+) -> Result<Promotion> {
+    if !target.promotion_approval {
+        queue(release_id, &target.owner, &target.project, base, build_channel).await?;
+        return Ok(Promotion::Queued);
+    }
+
+    // asking twice for the same release doesn't need two approvals
+    let pending = sqlx::query!(
+        "SELECT id FROM promotions WHERE release_id = $1 AND target_id = $2 AND status = 'pending'",
+        release_id,
+        target.id
+    )
+    .fetch_optional(pool)
+    .await?;
+    if let Some(pending) = pending {
+        return Ok(Promotion::Pending(pending.id));
+    }
+
+    let id = Uuid::from(Ulid::new());
+    sqlx::query!(
+        r#"INSERT INTO promotions (id, release_id, source_id, target_id, requested_by)
+           VALUES ($1, $2, $3, $4, $5)
+        "#,
+        id,
+        release_id,
+        source_id,
+        target.id,
+        user_id
+    )
+    .execute(pool)
+    .await?;
+
+    let requester = sqlx::query!("SELECT username FROM users WHERE id = $1", user_id)
+        .fetch_one(pool)
+        .await?;
+    notifier.notify(
+        target.id,
+        Event::PromotionRequested,
+        Payload {
+            event: Event::PromotionRequested.name(),
+            app: format!("{}/{}", target.owner, target.project),
+            message: format!("{} asks to promote release {release_id}, promotion {id} waits for an approval", requester.username),
+            build_id: None,
+            commit_sha: None,
+            release_id: Some(release_id),
+            logs_url: notifier.project_url(&target.owner, &target.project),
+            timestamp: Utc::now(),
+        },
+        pool,
+    );
+
+    Ok(Promotion::Pending(id))
+}
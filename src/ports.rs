@@ -0,0 +1,455 @@
+//! Raw tcp and udp ports for apps that don't speak http, like game servers and mqtt brokers.
+//! An app leases a port of the range in `ports`, clients connect to it on the host of the
+//! platform and every connection is forwarded to `target_port` of the container serving the
+//! app, the same one the proxy sends its requests to
+//!
+//! Leases are rows of `port_leases` and count against the `ports` quota of the accounts
+//! owning the app. [`run`] listens on the ports leased by apps that aren't suspended and
+//! opens and closes listeners as leases come and go. A connection ends when either side
+//! closes it or nothing is sent either way for the idle timeout of the lease. udp has no
+//! connections, every client address gets a socket of its own to the container that is
+//! dropped the same way
+
+use std::collections::{HashMap, HashSet};
+use std::net::SocketAddr;
+use std::sync::{Arc, Mutex, RwLock, Weak};
+use std::time::Duration;
+
+use anyhow::{anyhow, Result};
+use lazy_static::lazy_static;
+use sqlx::PgPool;
+use tokio::io::{AsyncReadExt, AsyncWriteExt};
+use tokio::net::{TcpListener, TcpStream, UdpSocket};
+use tokio::sync::{Notify, Semaphore};
+use tokio::task::JoinHandle;
+use tokio::time::Instant;
+use uuid::Uuid;
+
+use crate::balancer::Balancer;
+use crate::configuration::PortSettings;
+use crate::idle::IdleTracker;
+use crate::orchestrator;
+
+pub const PROTOCOLS: [&str; 2] = ["tcp", "udp"];
+
+/// leases are read again this often without a change, for the suspensions of apps
+const SYNC_INTERVAL: Duration = Duration::from_secs(60);
+/// how long the container gets to take a tcp connection
+const CONNECT_TIMEOUT: Duration = Duration::from_secs(10);
+/// idle apps are touched at most this often while a connection is busy
+const TOUCH_INTERVAL: Duration = Duration::from_secs(30);
+/// the biggest udp datagram
+const DATAGRAM_SIZE: usize = 65535;
+
+lazy_static! {
+    static ref SETTINGS: RwLock<Option<PortSettings>> = RwLock::new(None);
+    static ref CHANGED: Notify = Notify::new();
+}
+
+/// Checks `ports` and keeps it for the leases. Called once when the platform starts, without
+/// a range apps can't lease a port
+pub fn init(settings: &PortSettings, domain: &str) -> Result<()> {
+    if settings.first == 0 {
+        *SETTINGS.write().unwrap() = None;
+        return Ok(());
+    }
+
+    if settings.last < settings.first {
+        return Err(anyhow!("Ports last has to be at least first"));
+    }
+    if settings.idletimeout < 1 || settings.maxconnections < 1 {
+        return Err(anyhow!("Ports idletimeout and maxconnections have to be at least 1"));
+    }
+
+    let mut settings = settings.clone();
+    if settings.host.is_empty() {
+        settings.host = domain.to_string();
+    }
+    *SETTINGS.write().unwrap() = Some(settings);
+    Ok(())
+}
+
+pub fn enabled() -> bool {
+    SETTINGS.read().unwrap().is_some()
+}
+
+fn settings() -> Result<PortSettings> {
+    SETTINGS
+        .read()
+        .unwrap()
+        .clone()
+        .ok_or(anyhow!("Ports are not set up on this platform"))
+}
+
+/// The host clients connect to the leased ports on
+pub fn host() -> String {
+    SETTINGS
+        .read()
+        .unwrap()
+        .as_ref()
+        .map(|settings| settings.host.clone())
+        .unwrap_or_default()
+}
+
+/// Whether `port` is one apps may lease
+pub fn leasable(port: i32) -> bool {
+    SETTINGS
+        .read()
+        .unwrap()
+        .as_ref()
+        .is_some_and(|settings| (i32::from(settings.first)..=i32::from(settings.last)).contains(&port))
+}
+
+/// Tells [`run`] a lease was made or released, so it listens right away
+pub fn changed() {
+    CHANGED.notify_one();
+}
+
+/// The seconds a connection to the lease may be quiet, never longer than the platform allows
+pub fn idle_timeout(lease: Option<i32>) -> u64 {
+    let platform = SETTINGS
+        .read()
+        .unwrap()
+        .as_ref()
+        .map(|settings| settings.idletimeout)
+        .unwrap_or(1);
+    lease.map_or(platform, |seconds| (seconds.max(1) as u64).min(platform))
+}
+
+/// The lowest port of the range nobody leased for `protocol`, None when every one is taken
+pub async fn free_port(protocol: &str, pool: &PgPool) -> Result<Option<i32>> {
+    let settings = settings()?;
+    let taken = sqlx::query!("SELECT port FROM port_leases WHERE protocol = $1", protocol)
+        .fetch_all(pool)
+        .await?
+        .into_iter()
+        .map(|lease| lease.port)
+        .collect::<HashSet<_>>();
+
+    Ok((i32::from(settings.first)..=i32::from(settings.last)).find(|port| !taken.contains(port)))
+}
+
+#[derive(Debug, Clone, PartialEq)]
+struct Lease {
+    project_id: Uuid,
+    protocol: String,
+    port: u16,
+    target_port: u16,
+    idle_timeout: Duration,
+}
+
+/// What every connection needs, whichever port it came in on
+#[derive(Clone)]
+struct Context {
+    pool: PgPool,
+    balancer: Balancer,
+    idle: IdleTracker,
+    max_connections: usize,
+}
+
+/// The leases of apps that aren't suspended, by protocol and port
+async fn leases(pool: &PgPool) -> Result<HashMap<(String, u16), Lease>> {
+    let leases = sqlx::query!(
+        r#"SELECT port_leases.project_id, port_leases.protocol, port_leases.port,
+           port_leases.target_port, port_leases.idle_timeout
+           FROM port_leases
+           JOIN projects ON projects.id = port_leases.project_id
+           WHERE projects.suspended_at IS NULL
+        "#
+    )
+    .fetch_all(pool)
+    .await?;
+
+    Ok(leases
+        .into_iter()
+        .map(|lease| {
+            let key = (lease.protocol.clone(), lease.port as u16);
+            let lease = Lease {
+                project_id: lease.project_id,
+                protocol: lease.protocol,
+                port: lease.port as u16,
+                target_port: lease.target_port as u16,
+                idle_timeout: Duration::from_secs(idle_timeout(lease.idle_timeout)),
+            };
+            (key, lease)
+        })
+        .collect())
+}
+
+/// Listens on every leased port until the platform stops. A listener that can't be opened,
+/// like when something else on the host has the port, is tried again on the next sync.
+/// Connections already open when their lease goes away run until they end on their own
+pub async fn run(pool: PgPool, balancer: Balancer, idle: IdleTracker) -> Result<()> {
+    let settings = settings()?;
+    let context = Context {
+        pool: pool.clone(),
+        balancer,
+        idle,
+        max_connections: settings.maxconnections,
+    };
+    let mut listeners: HashMap<(String, u16), (Lease, JoinHandle<()>)> = HashMap::new();
+
+    loop {
+        match leases(&pool).await {
+            Ok(leases) => {
+                let released = listeners
+                    .iter()
+                    .filter(|(key, (lease, _))| leases.get(*key) != Some(lease))
+                    .map(|(key, _)| key.clone())
+                    .collect::<Vec<_>>();
+                for key in released {
+                    if let Some((lease, listener)) = listeners.remove(&key) {
+                        // the socket is closed once the task is gone, a new lease of the port
+                        // binds it right after
+                        listener.abort();
+                        let _ = listener.await;
+                        tracing::info!(protocol = %lease.protocol, port = lease.port, "Port released");
+                    }
+                }
+
+                for (key, lease) in leases {
+                    if listeners.contains_key(&key) {
+                        continue;
+                    }
+                    let listener = match lease.protocol.as_str() {
+                        "udp" => listen_udp(&settings.bind, lease.clone(), context.clone()).await,
+                        _ => listen_tcp(&settings.bind, lease.clone(), context.clone()).await,
+                    };
+                    match listener {
+                        Ok(listener) => {
+                            tracing::info!(protocol = %lease.protocol, port = lease.port, "Listening on leased port");
+                            listeners.insert(key, (lease, listener));
+                        }
+                        Err(err) => {
+                            tracing::error!(?err, protocol = %lease.protocol, port = lease.port, "Can't listen on leased port");
+                        }
+                    }
+                }
+            }
+            Err(err) => tracing::error!(?err, "Can't sync ports: Failed to query database"),
+        }
+
+        tokio::select! {
+            _ = CHANGED.notified() => {}
+            _ = tokio::time::sleep(SYNC_INTERVAL) => {}
+        }
+    }
+}
+
+/// The address of the container a new connection to the app goes to, the app container or one
+/// of the healthy web replicas like for http. None while the app has no running container
+async fn upstream(project_id: Uuid, context: &Context) -> Result<Option<(String, String)>> {
+    let Some(domain) = sqlx::query!(
+        "SELECT name, container_id FROM domains WHERE project_id = $1",
+        project_id
+    )
+    .fetch_optional(&context.pool)
+    .await?
+    else {
+        return Ok(None);
+    };
+
+    if let Some(replica) = context.balancer.pick(&domain.name) {
+        return Ok(Some((domain.name, replica.ip)));
+    }
+    // rows from before blue-green deploys only know the container by name
+    let container = domain.container_id.unwrap_or_else(|| domain.name.clone());
+    let address = orchestrator::driver().address(&domain.name, &container).await?;
+    Ok(address.map(|address| (domain.name, address)))
+}
+
+async fn listen_tcp(bind: &str, lease: Lease, context: Context) -> Result<JoinHandle<()>> {
+    let listener = TcpListener::bind((bind, lease.port)).await?;
+
+    Ok(tokio::spawn(async move {
+        let slots = Arc::new(Semaphore::new(context.max_connections));
+        loop {
+            let (client, addr) = match listener.accept().await {
+                Ok(accepted) => accepted,
+                Err(err) => {
+                    // out of file descriptors most likely, the next accept may work again
+                    tracing::warn!(?err, port = lease.port, "Can't accept connection on leased port");
+                    tokio::time::sleep(Duration::from_millis(100)).await;
+                    continue;
+                }
+            };
+            // a full port turns new clients away instead of queueing them
+            let Ok(slot) = slots.clone().try_acquire_owned() else {
+                tracing::debug!(port = lease.port, %addr, "Leased port is full, dropping connection");
+                continue;
+            };
+
+            let lease = lease.clone();
+            let context = context.clone();
+            tokio::spawn(async move {
+                let _slot = slot;
+                if let Err(err) = forward_tcp(client, &lease, &context).await {
+                    tracing::debug!(?err, port = lease.port, %addr, "Can't forward connection on leased port");
+                }
+            });
+        }
+    }))
+}
+
+/// Copies bytes between the client and the app until one of them closes or nothing is sent
+/// either way for the idle timeout, like a websocket. The app counts as visited while bytes
+/// flow
+async fn forward_tcp(client: TcpStream, lease: &Lease, context: &Context) -> Result<()> {
+    let (subdomain, address) = upstream(lease.project_id, context)
+        .await?
+        .ok_or(anyhow!("Container is not running"))?;
+    let app = tokio::time::timeout(
+        CONNECT_TIMEOUT,
+        TcpStream::connect((address.as_str(), lease.target_port)),
+    )
+    .await
+    .map_err(|_| anyhow!("Container didn't take the connection in time"))??;
+    let _ = client.set_nodelay(true);
+    let _ = app.set_nodelay(true);
+    context.idle.touch(&subdomain);
+
+    let (mut client_read, mut client_write) = client.into_split();
+    let (mut app_read, mut app_write) = app.into_split();
+    let mut from_client = vec![0u8; 8192];
+    let mut from_app = vec![0u8; 8192];
+    let mut touched = Instant::now();
+
+    let ended = loop {
+        // reads are cancel safe, the side that lost the race reads again next time
+        let read = tokio::time::timeout(lease.idle_timeout, async {
+            tokio::select! {
+                read = client_read.read(&mut from_client) => (true, read),
+                read = app_read.read(&mut from_app) => (false, read),
+            }
+        })
+        .await;
+
+        let (to_app, read) = match read {
+            Ok(read) => read,
+            Err(_) => break "idle",
+        };
+        let written = match read {
+            Ok(0) => break "closed",
+            Ok(n) if to_app => app_write.write_all(&from_client[..n]).await,
+            Ok(n) => client_write.write_all(&from_app[..n]).await,
+            Err(_) => break "reset",
+        };
+        if written.is_err() {
+            break "reset";
+        }
+
+        if touched.elapsed() >= TOUCH_INTERVAL {
+            context.idle.touch(&subdomain);
+            touched = Instant::now();
+        }
+    };
+
+    let _ = client_write.shutdown().await;
+    let _ = app_write.shutdown().await;
+    context.idle.touch(&subdomain);
+    tracing::debug!(app = %subdomain, port = lease.port, ended, "Connection on leased port closed");
+    Ok(())
+}
+
+/// A udp client of a leased port, with the socket its datagrams go to the app on
+struct UdpClient {
+    socket: Arc<UdpSocket>,
+    seen: Arc<Mutex<Instant>>,
+}
+
+async fn listen_udp(bind: &str, lease: Lease, context: Context) -> Result<JoinHandle<()>> {
+    let listener = Arc::new(UdpSocket::bind((bind, lease.port)).await?);
+
+    Ok(tokio::spawn(async move {
+        let clients: Arc<Mutex<HashMap<SocketAddr, UdpClient>>> = Arc::default();
+        let mut datagram = vec![0u8; DATAGRAM_SIZE];
+        loop {
+            let (n, addr) = match listener.recv_from(&mut datagram).await {
+                Ok(received) => received,
+                Err(err) => {
+                    tracing::warn!(?err, port = lease.port, "Can't receive datagram on leased port");
+                    continue;
+                }
+            };
+
+            let known = clients
+                .lock()
+                .unwrap()
+                .get(&addr)
+                .map(|client| (client.socket.clone(), client.seen.clone()));
+            let socket = match known {
+                Some((socket, seen)) => {
+                    *seen.lock().unwrap() = Instant::now();
+                    socket
+                }
+                None if clients.lock().unwrap().len() >= context.max_connections => continue,
+                None => match open_udp(addr, &lease, &context, Arc::downgrade(&listener), clients.clone()).await {
+                    Ok(socket) => socket,
+                    Err(err) => {
+                        tracing::debug!(?err, port = lease.port, %addr, "Can't forward datagram on leased port");
+                        continue;
+                    }
+                },
+            };
+            let _ = socket.send(&datagram[..n]).await;
+        }
+    }))
+}
+
+/// A socket to the app for a new udp client, and a task sending what the app answers on it
+/// back to the client until neither sent anything for the idle timeout. The task ends with
+/// the listener too, it only holds on to it weakly
+async fn open_udp(
+    addr: SocketAddr,
+    lease: &Lease,
+    context: &Context,
+    listener: Weak<UdpSocket>,
+    clients: Arc<Mutex<HashMap<SocketAddr, UdpClient>>>,
+) -> Result<Arc<UdpSocket>> {
+    let (subdomain, address) = upstream(lease.project_id, context)
+        .await?
+        .ok_or(anyhow!("Container is not running"))?;
+    let socket = UdpSocket::bind("0.0.0.0:0").await?;
+    socket.connect((address.as_str(), lease.target_port)).await?;
+    let socket = Arc::new(socket);
+    let seen = Arc::new(Mutex::new(Instant::now()));
+    clients.lock().unwrap().insert(
+        addr,
+        UdpClient {
+            socket: socket.clone(),
+            seen: seen.clone(),
+        },
+    );
+    context.idle.touch(&subdomain);
+
+    let idle_timeout = lease.idle_timeout;
+    let idle = context.idle.clone();
+    let app = socket.clone();
+    tokio::spawn(async move {
+        let mut datagram = vec![0u8; DATAGRAM_SIZE];
+        let mut touched = Instant::now();
+        loop {
+            match tokio::time::timeout(idle_timeout, app.recv(&mut datagram)).await {
+                Ok(Ok(n)) => {
+                    let Some(listener) = listener.upgrade() else { break };
+                    let _ = listener.send_to(&datagram[..n], addr).await;
+                    *seen.lock().unwrap() = Instant::now();
+                }
+                // like the container not listening, the client may still get through later
+                Ok(Err(_)) => {}
+                Err(_) => {}
+            }
+
+            if listener.strong_count() == 0 || seen.lock().unwrap().elapsed() >= idle_timeout {
+                break;
+            }
+            if touched.elapsed() >= TOUCH_INTERVAL {
+                idle.touch(&subdomain);
+                touched = Instant::now();
+            }
+        }
+        clients.lock().unwrap().remove(&addr);
+    });
+
+    Ok(socket)
+}
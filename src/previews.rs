@@ -0,0 +1,272 @@
+use std::collections::HashMap;
+use std::time::Duration;
+
+use anyhow::Result;
+use bollard::container::{ListContainersOptions, RemoveContainerOptions};
+use sqlx::PgPool;
+use tokio::sync::mpsc::Sender;
+use uuid::Uuid;
+
+use crate::activity::record_activity;
+use crate::nodes;
+use crate::proxy_signature::SECRET_ENV;
+use crate::queue::{BuildKind, BuildQueueItem};
+use crate::telemetry::current_context;
+
+/// how often expired previews are looked for
+const CHECK_INTERVAL: Duration = Duration::from_secs(300);
+
+/// longest a hostname label can be, the preview name is one
+const MAX_NAME: usize = 63;
+
+/// Subdomain and container name of the preview of `branch`, `<branch>--<app>`. The branch is
+/// lowercased and everything but letters and digits becomes a single dash, so the `--` only
+/// ever separates it from the app. Long branches are cut to keep the name a valid label
+pub fn preview_name(branch: &str, container_name: &str) -> String {
+    let mut slug = String::new();
+    for c in branch.chars() {
+        match c.to_ascii_lowercase() {
+            c if c.is_ascii_alphanumeric() => slug.push(c),
+            _ if slug.ends_with('-') || slug.is_empty() => {}
+            _ => slug.push('-'),
+        }
+    }
+
+    let room = MAX_NAME.saturating_sub(container_name.len() + 2).max(1);
+    slug.truncate(room);
+    let slug = match slug.trim_end_matches('-') {
+        "" => "branch",
+        slug => slug,
+    };
+    format!("{slug}--{container_name}")
+}
+
+/// Makes a fresh checkout of `branch` of the bare repository at `repo_path` for the preview
+/// `name` to build from, and returns where it is
+pub fn checkout_branch(repo_path: &str, branch: &str, name: &str) -> Result<String> {
+    let source = format!("{repo_path}/previews/{name}");
+    // previews build what was pushed, nothing a former build left behind
+    if std::path::Path::new(&source).exists() {
+        std::fs::remove_dir_all(&source)?;
+    }
+    git2::build::RepoBuilder::new().branch(branch).clone(repo_path, std::path::Path::new(&source))?;
+    Ok(source)
+}
+
+/// The variables previews of a project get on top of the ones of the app, with PREVIEW_BRANCH
+/// telling the app which branch it runs
+pub async fn preview_environment(project_id: Uuid, branch: &str, pool: &PgPool) -> Result<Vec<String>> {
+    let project = sqlx::query!("SELECT preview_environs FROM projects WHERE id = $1", project_id)
+        .fetch_one(pool)
+        .await?;
+
+    let mut env = match project.preview_environs.as_object() {
+        Some(map) => map
+            .iter()
+            // previews are signed with the key of the app
+            .filter(|(key, _)| key.as_str() != SECRET_ENV)
+            .filter_map(|(key, value)| value.as_str().map(|value| format!("{key}={value}")))
+            .collect::<Vec<_>>(),
+        None => Vec::new(),
+    };
+    env.push(format!("PREVIEW_BRANCH={branch}"));
+    Ok(env)
+}
+
+/// Removes the containers, image and network of the preview `name`, whatever of them exists
+pub async fn remove_preview_containers(name: &str) -> Result<()> {
+    let docker = nodes::docker(name)?;
+
+    // a deploy waiting for its readiness check runs under its own name
+    for container in [name.to_string(), format!("{name}-next")] {
+        let found = docker
+            .list_containers(Some(ListContainersOptions::<String> {
+                all: true,
+                filters: HashMap::from([("name".to_string(), vec![format!("^/{container}$")])]),
+                ..Default::default()
+            }))
+            .await?;
+        if found.is_empty() {
+            continue;
+        }
+
+        docker
+            .remove_container(
+                &container,
+                Some(RemoveContainerOptions {
+                    force: true,
+                    ..Default::default()
+                }),
+            )
+            .await?;
+    }
+
+    for image in [format!("{name}:latest"), format!("{name}:old")] {
+        let _ = docker.remove_image(&image, None, None).await;
+    }
+    let _ = docker.remove_network(&format!("{name}-network")).await;
+    Ok(())
+}
+
+/// Removes a container that was started for the preview `name` but isn't going to serve it
+pub async fn discard_container(name: &str, id: &str) {
+    let removed = async {
+        let docker = nodes::docker(name)?;
+        let options = RemoveContainerOptions {
+            force: true,
+            ..Default::default()
+        };
+        docker.remove_container(id, Some(options)).await?;
+        Ok::<_, anyhow::Error>(())
+    };
+    if let Err(err) = removed.await {
+        tracing::error!(?err, container = id, "Can't discard preview container");
+    }
+}
+
+/// Tears down the preview `name` of a project and says so in its activity
+pub async fn remove_preview(project_id: Uuid, name: &str, reason: &str, pool: &PgPool) -> Result<()> {
+    let preview = sqlx::query!(
+        "DELETE FROM previews WHERE name = $1 AND project_id = $2 RETURNING branch, source",
+        name,
+        project_id
+    )
+    .fetch_optional(pool)
+    .await?;
+
+    remove_preview_containers(name).await?;
+
+    let Some(preview) = preview else {
+        return Ok(());
+    };
+    if let Err(err) = std::fs::remove_dir_all(&preview.source) {
+        tracing::debug!(?err, preview = name, "Can't remove checkout of preview");
+    }
+
+    let message = format!("Removed the preview of {}, {reason}", preview.branch);
+    if let Err(err) = record_activity(project_id, "preview", &message, pool).await {
+        tracing::error!(?err, "Can't record activity: Failed to query database");
+    }
+    Ok(())
+}
+
+/// Tears down previews nobody deployed to for `container.previewttl` seconds
+pub async fn preview_reaper(pool: PgPool) {
+    loop {
+        tokio::time::sleep(CHECK_INTERVAL).await;
+
+        let expired = match sqlx::query!("SELECT name, project_id FROM previews WHERE expires_at < now()")
+            .fetch_all(&pool)
+            .await
+        {
+            Ok(expired) => expired,
+            Err(err) => {
+                tracing::error!(?err, "Can't get expired previews: Failed to query database");
+                continue;
+            }
+        };
+
+        for preview in expired {
+            if let Err(err) = remove_preview(preview.project_id, &preview.name, "it expired", &pool).await {
+                tracing::error!(?err, preview = preview.name, "Can't remove expired preview");
+            }
+        }
+    }
+}
+
+/// Deploys or removes the previews of the branches a push to `owner/repo` updated, besides
+/// its default branch. `branches` are the new commit and the name of each branch
+pub async fn push_previews(
+    owner: &str,
+    repo: &str,
+    repo_path: &str,
+    container_name: &str,
+    branches: Vec<(String, String)>,
+    pool: &PgPool,
+    build_channel: &Sender<BuildQueueItem>,
+) {
+    let project = match sqlx::query!(
+        r#"SELECT projects.id, projects.previews
+           FROM projects
+           JOIN project_owners ON projects.owner_id = project_owners.id
+           WHERE project_owners.name = $1
+           AND projects.name = $2
+        "#,
+        owner,
+        repo.trim_end_matches(".git")
+    )
+    .fetch_optional(pool)
+    .await
+    {
+        Ok(Some(project)) => project,
+        Ok(None) => return,
+        Err(err) => {
+            tracing::error!(?err, "Can't deploy previews: Failed to query database");
+            return;
+        }
+    };
+
+    for (commit, branch) in branches {
+        let name = preview_name(&branch, container_name);
+
+        // a deleted branch takes its preview with it, even once previews are turned off
+        if commit.bytes().all(|c| c == b'0') {
+            let pool = pool.clone();
+            tokio::spawn(async move {
+                if let Err(err) = remove_preview(project.id, &name, "its branch was deleted", &pool).await {
+                    tracing::error!(?err, preview = name, "Can't remove preview");
+                }
+            });
+            continue;
+        }
+        if !project.previews {
+            continue;
+        }
+
+        // branches that only differ in dashes or case end up with the same name
+        let taken = sqlx::query!(
+            r#"SELECT EXISTS(SELECT 1 FROM domains WHERE name = $1)
+               OR EXISTS(SELECT 1 FROM previews WHERE name = $1 AND NOT (project_id = $2 AND branch = $3))
+               AS "taken!"
+            "#,
+            name,
+            project.id,
+            branch
+        )
+        .fetch_one(pool)
+        .await;
+        match taken {
+            Ok(row) if row.taken => {
+                let message = format!("Didn't deploy a preview of {branch}, {name} is taken by another app or branch");
+                if let Err(err) = record_activity(project.id, "preview", &message, pool).await {
+                    tracing::error!(?err, "Can't record activity: Failed to query database");
+                }
+                continue;
+            }
+            Ok(_) => {}
+            Err(err) => {
+                tracing::error!(?err, "Can't deploy preview: Failed to query database");
+                continue;
+            }
+        }
+
+        let source = match checkout_branch(repo_path, &branch, &name) {
+            Ok(source) => source,
+            Err(err) => {
+                tracing::error!(?err, branch, "Can't check out branch for preview");
+                continue;
+            }
+        };
+
+        let item = BuildQueueItem {
+            container_name: name,
+            container_src: source,
+            owner: owner.to_string(),
+            repo: repo.to_string(),
+            kind: BuildKind::Preview(branch),
+            trace: current_context(),
+        };
+        let build_channel = build_channel.clone();
+        tokio::spawn(async move { build_channel.send(item).await });
+    }
+}
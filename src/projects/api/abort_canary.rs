@@ -0,0 +1,124 @@
+use axum::extract::{State, Path};
+use axum::response::Response;
+use hyper::{Body, StatusCode};
+use serde::Serialize;
+
+use crate::activity::record_activity;
+use crate::docker::remove_canary;
+use crate::monitoring::reset_release_requests;
+use crate::{auth::Auth, startup::AppState};
+
+#[derive(Serialize, Debug)]
+struct ErrorResponse {
+    message: String,
+}
+
+/// Sends every request back to the live release and removes the canary container
+#[tracing::instrument(skip(auth, pool))]
+pub async fn post(
+    auth: Auth,
+    State(AppState { pool, .. }): State<AppState>,
+    Path((owner, project)): Path<(String, String)>,
+) -> Response<Body> {
+    let _user = auth.current_user.unwrap();
+
+    // check if project exist
+    let project_record = match sqlx::query!(
+        r#"SELECT projects.id AS id
+           FROM projects
+           JOIN project_owners ON projects.owner_id = project_owners.id
+           JOIN users_owners ON project_owners.id = users_owners.owner_id
+           AND projects.name = $1
+           AND project_owners.name = $2
+        "#,
+        project,
+        owner,
+    )
+    .fetch_optional(&pool)
+    .await
+    {
+        Ok(Some(record)) => record,
+        Ok(None) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Project does not exist".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Err(err) => {
+            tracing::error!(?err, "Can't get projects: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    // the proxy stops splitting before the container goes away
+    match sqlx::query!(
+        "DELETE FROM canaries WHERE project_id = $1 RETURNING build_id",
+        project_record.id
+    )
+    .fetch_optional(&pool)
+    .await
+    {
+        Ok(Some(_)) => {}
+        Ok(None) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Project has no canary".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Err(err) => {
+            tracing::error!(?err, "Can't remove canary: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    let repo = project.trim_end_matches(".git");
+    let container_name = format!("{owner}-{repo}").replace('.', "-");
+
+    if let Err(err) = remove_canary(&container_name).await {
+        tracing::error!(?err, "Can't remove canary: Failed to remove container");
+
+        let json = serde_json::to_string(&ErrorResponse {
+            message: "Failed to remove canary container".to_string()
+        }).unwrap();
+
+        return Response::builder()
+            .status(StatusCode::INTERNAL_SERVER_ERROR)
+            .body(Body::from(json))
+            .unwrap();
+    }
+
+    reset_release_requests(&container_name);
+
+    if let Err(err) = record_activity(project_record.id, "canary", "Aborted the canary", &pool).await {
+        tracing::error!(?err, "Can't record activity: Failed to query database");
+    }
+
+    Response::builder()
+        .status(StatusCode::NO_CONTENT)
+        .body(Body::empty())
+        .unwrap()
+}
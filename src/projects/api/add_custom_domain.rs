@@ -0,0 +1,227 @@
+use std::collections::HashSet;
+
+use axum::extract::{State, Path};
+use axum::response::Response;
+use axum::Json;
+use chrono::Utc;
+use garde::{Unvalidated, Validate};
+use hyper::{Body, StatusCode};
+use serde::{Deserialize, Serialize};
+use ulid::Ulid;
+use uuid::Uuid;
+
+use crate::{auth::Auth, startup::AppState};
+
+#[derive(Deserialize, Validate, Debug)]
+pub struct AddCustomDomainRequest {
+    #[garde(length(max = 253), custom(hostname_check))]
+    pub name: String,
+}
+
+#[derive(Serialize, Debug)]
+struct AddCustomDomainResponse {
+    name: String,
+    verified: bool,
+    /// what the domain has to point at with a CNAME record
+    target: String,
+}
+
+#[derive(Serialize, Debug)]
+struct ErrorResponse {
+    message: String
+}
+
+pub fn hostname_check(value: &str, _ctx: &()) -> garde::Result {
+    let valid = value.contains('.')
+        && value.split('.').all(|label| {
+            !label.is_empty()
+                && label.len() <= 63
+                && !label.starts_with('-')
+                && !label.ends_with('-')
+                && label
+                    .chars()
+                    .all(|c| c.is_ascii_lowercase() || c.is_ascii_digit() || c == '-')
+        });
+
+    match valid {
+        true => Ok(()),
+        false => Err(garde::Error::new("Domain must be a lowercase hostname like app.example.com")),
+    }
+}
+
+/// A domain counts as pointing at the platform once it resolves to one of the addresses the
+/// platform domain resolves to, which holds for a CNAME to the project subdomain as well
+async fn points_to_platform(name: &str, domain: &str) -> bool {
+    let platform = match tokio::net::lookup_host((domain, 443)).await {
+        Ok(addrs) => addrs.map(|addr| addr.ip()).collect::<HashSet<_>>(),
+        Err(err) => {
+            tracing::error!(?err, "Can't verify domain: Failed to resolve platform domain");
+            return false;
+        }
+    };
+
+    match tokio::net::lookup_host((name, 443)).await {
+        Ok(mut addrs) => addrs.any(|addr| platform.contains(&addr.ip())),
+        Err(err) => {
+            tracing::debug!(?err, "Can't verify domain: Failed to resolve {}", name);
+            false
+        }
+    }
+}
+
+/// Adds a custom domain to the project, or verifies it again if it was added before
+#[tracing::instrument(skip(auth, pool))]
+pub async fn post(
+    auth: Auth,
+    State(AppState { pool, domain, .. }): State<AppState>,
+    Path((owner, project)): Path<(String, String)>,
+    Json(req): Json<Unvalidated<AddCustomDomainRequest>>
+) -> Response<Body> {
+    let _user = auth.current_user.unwrap();
+
+    let AddCustomDomainRequest { name } = match req.validate(&()) {
+        Ok(valid) => valid.into_inner(),
+        Err(err) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: err.to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    if name == domain || name.ends_with(&format!(".{domain}")) {
+        let json = serde_json::to_string(&ErrorResponse {
+            message: "Subdomains of the platform are assigned automatically".to_string()
+        }).unwrap();
+
+        return Response::builder()
+            .status(StatusCode::BAD_REQUEST)
+            .body(Body::from(json))
+            .unwrap();
+    }
+
+    // check if project exist
+    let project_record = match sqlx::query!(
+        r#"SELECT projects.id AS id
+           FROM projects
+           JOIN project_owners ON projects.owner_id = project_owners.id
+           JOIN users_owners ON project_owners.id = users_owners.owner_id
+           AND projects.name = $1
+           AND project_owners.name = $2
+        "#,
+        project,
+        owner,
+    )
+    .fetch_optional(&pool)
+    .await
+    {
+        Ok(Some(record)) => record,
+        Ok(None) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Project does not exist".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Err(err) => {
+            tracing::error!(?err, "Can't get projects: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    match sqlx::query!(
+        r#"SELECT project_id FROM custom_domains WHERE name = $1"#,
+        name
+    )
+    .fetch_optional(&pool)
+    .await
+    {
+        Ok(Some(existing)) if existing.project_id != project_record.id => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Domain is already used by another project".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::CONFLICT)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Ok(_) => {}
+        Err(err) => {
+            tracing::error!(?err, "Can't get custom domain: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    let verified_at = match points_to_platform(&name, &domain).await {
+        true => Some(Utc::now()),
+        false => None,
+    };
+
+    // once verified a domain stays verified, a flaky lookup shouldn't take its certificate away
+    let verified = match sqlx::query!(
+        r#"INSERT INTO custom_domains (id, project_id, name, verified_at)
+           VALUES ($1, $2, $3, $4)
+           ON CONFLICT (name) DO UPDATE
+           SET verified_at = COALESCE(custom_domains.verified_at, EXCLUDED.verified_at),
+               updated_at = now()
+           RETURNING verified_at
+        "#,
+        Uuid::from(Ulid::new()),
+        project_record.id,
+        name,
+        verified_at,
+    )
+    .fetch_one(&pool)
+    .await
+    {
+        Ok(record) => record.verified_at.is_some(),
+        Err(err) => {
+            tracing::error!(?err, "Can't add custom domain: Failed to insert into database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Failed to insert into database".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    let subdomain = format!("{owner}-{project}").replace('.', "-");
+    let json = serde_json::to_string(&AddCustomDomainResponse {
+        name,
+        verified,
+        target: format!("{subdomain}.{domain}"),
+    }).unwrap();
+
+    Response::builder()
+        .status(StatusCode::OK)
+        .body(Body::from(json))
+        .unwrap()
+}
@@ -0,0 +1,179 @@
+use axum::extract::{State, Path};
+use axum::response::Response;
+use axum::Json;
+use garde::{Unvalidated, Validate};
+use hyper::{Body, StatusCode};
+use serde::{Deserialize, Serialize};
+use ulid::Ulid;
+use uuid::Uuid;
+
+use super::view_log_drains::LogDrain;
+use crate::drains::{redact, Drain};
+use crate::{auth::Auth, startup::AppState};
+
+/// keeps one project from fanning its logs out to an unbounded number of sinks
+const MAX_DRAINS: i64 = 5;
+
+#[derive(Deserialize, Validate, Debug)]
+pub struct AddLogDrainRequest {
+    #[garde(length(max = 2048), custom(drain_check))]
+    pub url: String,
+}
+
+#[derive(Serialize, Debug)]
+struct ErrorResponse {
+    message: String,
+}
+
+fn drain_check(value: &str, _ctx: &()) -> garde::Result {
+    Drain::parse(value)
+        .map(|_| ())
+        .map_err(|err| garde::Error::new(err.to_string()))
+}
+
+/// Adds a log drain to the project, the output of its containers is forwarded to it within
+/// a few seconds
+#[tracing::instrument(skip(auth, pool, req))]
+pub async fn post(
+    auth: Auth,
+    State(AppState { pool, .. }): State<AppState>,
+    Path((owner, project)): Path<(String, String)>,
+    Json(req): Json<Unvalidated<AddLogDrainRequest>>
+) -> Response<Body> {
+    let _user = auth.current_user.unwrap();
+
+    let AddLogDrainRequest { url } = match req.validate(&()) {
+        Ok(valid) => valid.into_inner(),
+        Err(err) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: err.to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    // check if project exist
+    let project_record = match sqlx::query!(
+        r#"SELECT projects.id AS id
+           FROM projects
+           JOIN project_owners ON projects.owner_id = project_owners.id
+           JOIN users_owners ON project_owners.id = users_owners.owner_id
+           AND projects.name = $1
+           AND project_owners.name = $2
+        "#,
+        project,
+        owner,
+    )
+    .fetch_optional(&pool)
+    .await
+    {
+        Ok(Some(record)) => record,
+        Ok(None) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Project does not exist".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Err(err) => {
+            tracing::error!(?err, "Can't get projects: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    match sqlx::query!(
+        r#"SELECT COUNT(*) AS "count!" FROM log_drains WHERE project_id = $1"#,
+        project_record.id
+    )
+    .fetch_one(&pool)
+    .await
+    {
+        Ok(record) if record.count >= MAX_DRAINS => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("A project can have at most {MAX_DRAINS} log drains")
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Ok(_) => {}
+        Err(err) => {
+            tracing::error!(?err, "Can't get log drains: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    }
+
+    let id = Uuid::from(Ulid::new());
+    let created_at = match sqlx::query!(
+        r#"INSERT INTO log_drains (id, project_id, url) VALUES ($1, $2, $3)
+           ON CONFLICT (project_id, url) DO NOTHING
+           RETURNING created_at
+        "#,
+        id,
+        project_record.id,
+        url
+    )
+    .fetch_optional(&pool)
+    .await
+    {
+        Ok(Some(record)) => record.created_at,
+        Ok(None) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Log drain already exists".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Err(err) => {
+            tracing::error!(?err, "Can't add log drain: Failed to insert into database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Failed to insert into database".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    let json = serde_json::to_string(&LogDrain {
+        id,
+        url: redact(&url),
+        created_at,
+    }).unwrap();
+
+    Response::builder()
+        .status(StatusCode::CREATED)
+        .body(Body::from(json))
+        .unwrap()
+}
@@ -0,0 +1,102 @@
+use axum::extract::{State, Path};
+use axum::response::Response;
+use axum::Json;
+use hyper::{Body, StatusCode};
+use serde::{Deserialize, Serialize};
+
+use crate::audit::{with_change, AuditChange};
+use crate::mail::{normalize_address, suppress};
+use crate::{auth::Auth, startup::AppState};
+
+#[derive(Deserialize, Debug)]
+pub struct MailSuppressionRequest {
+    pub address: String,
+}
+
+#[derive(Serialize, Debug)]
+struct ErrorResponse {
+    message: String,
+}
+
+/// Stops the app from sending to an address, like someone who asked not to get its mail
+#[tracing::instrument(skip(auth, pool))]
+pub async fn post(
+    auth: Auth,
+    State(AppState { pool, .. }): State<AppState>,
+    Path((owner, project)): Path<(String, String)>,
+    Json(MailSuppressionRequest { address }): Json<MailSuppressionRequest>,
+) -> Response<Body> {
+    let _user = auth.current_user.unwrap();
+
+    let Some(address) = normalize_address(&address) else {
+        let json = serde_json::to_string(&ErrorResponse {
+            message: format!("Invalid address: {address}")
+        }).unwrap();
+
+        return Response::builder()
+            .status(StatusCode::BAD_REQUEST)
+            .body(Body::from(json))
+            .unwrap();
+    };
+
+    let addon = match sqlx::query!(
+        r#"SELECT addons.project_id
+           FROM addons
+           JOIN projects ON addons.project_id = projects.id
+           JOIN project_owners ON projects.owner_id = project_owners.id
+           WHERE projects.name = $1
+           AND project_owners.name = $2
+           AND addons.kind = 'mail'
+        "#,
+        project,
+        owner,
+    )
+    .fetch_optional(&pool)
+    .await
+    {
+        Ok(Some(addon)) => addon,
+        Ok(None) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Project does not have mail".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::NOT_FOUND)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Err(err) => {
+            tracing::error!(?err, "Can't suppress address: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    if let Err(err) = suppress(addon.project_id, &address, "added by hand", &pool).await {
+        tracing::error!(?err, "Can't suppress address: Failed to insert into database");
+
+        let json = serde_json::to_string(&ErrorResponse {
+            message: "Failed to insert into database".to_string()
+        }).unwrap();
+
+        return Response::builder()
+            .status(StatusCode::INTERNAL_SERVER_ERROR)
+            .body(Body::from(json))
+            .unwrap();
+    }
+
+    with_change(
+        Response::builder()
+            .status(StatusCode::NO_CONTENT)
+            .body(Body::empty())
+            .unwrap(),
+        AuditChange::new(None, Some(serde_json::json!({ "address": address }))),
+    )
+}
@@ -0,0 +1,214 @@
+use axum::extract::{State, Path};
+use axum::response::Response;
+use axum::Json;
+use garde::{Unvalidated, Validate};
+use hyper::{Body, StatusCode};
+use serde::{Deserialize, Serialize};
+use ulid::Ulid;
+use uuid::Uuid;
+
+use super::view_notification_hooks::NotificationHook;
+use crate::linked_repos::webhook_secret;
+use crate::notifications::{redact, Event, KINDS};
+use crate::{auth::Auth, startup::AppState};
+
+/// keeps a single deploy from fanning out to an unbounded number of requests
+const MAX_HOOKS: i64 = 5;
+
+#[derive(Deserialize, Validate, Debug)]
+pub struct AddNotificationHookRequest {
+    /// webhook, slack or discord
+    #[garde(custom(kind_check))]
+    pub kind: String,
+    /// the incoming webhook url for slack and discord
+    #[garde(length(max = 2048), pattern("^https?://[^\\s]+$"))]
+    pub url: String,
+    /// every event when left out
+    #[garde(custom(events_check))]
+    pub events: Option<Vec<String>>,
+}
+
+#[derive(Serialize, Debug)]
+struct ErrorResponse {
+    message: String,
+}
+
+fn kind_check(value: &str, _ctx: &()) -> garde::Result {
+    match KINDS.contains(&value) {
+        true => Ok(()),
+        false => Err(garde::Error::new(format!("kind must be one of {}", KINDS.join(", ")))),
+    }
+}
+
+fn events_check(value: &Option<Vec<String>>, _ctx: &()) -> garde::Result {
+    let names = Event::ALL.map(|event| event.name());
+    match value {
+        Some(events) if events.is_empty() => Err(garde::Error::new("events can't be empty")),
+        Some(events) => match events.iter().find(|event| !names.contains(&event.as_str())) {
+            Some(event) => Err(garde::Error::new(format!(
+                "unknown event {event}, events are {}",
+                names.join(", ")
+            ))),
+            None => Ok(()),
+        },
+        None => Ok(()),
+    }
+}
+
+/// Adds a hook that gets notified about builds and crashes of the project
+#[tracing::instrument(skip(auth, pool, req))]
+pub async fn post(
+    auth: Auth,
+    State(AppState { pool, .. }): State<AppState>,
+    Path((owner, project)): Path<(String, String)>,
+    Json(req): Json<Unvalidated<AddNotificationHookRequest>>
+) -> Response<Body> {
+    let _user = auth.current_user.unwrap();
+
+    let AddNotificationHookRequest { kind, url, events } = match req.validate(&()) {
+        Ok(valid) => valid.into_inner(),
+        Err(err) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: err.to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    // check if project exist
+    let project_record = match sqlx::query!(
+        r#"SELECT projects.id AS id
+           FROM projects
+           JOIN project_owners ON projects.owner_id = project_owners.id
+           JOIN users_owners ON project_owners.id = users_owners.owner_id
+           AND projects.name = $1
+           AND project_owners.name = $2
+        "#,
+        project,
+        owner,
+    )
+    .fetch_optional(&pool)
+    .await
+    {
+        Ok(Some(record)) => record,
+        Ok(None) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Project does not exist".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Err(err) => {
+            tracing::error!(?err, "Can't get projects: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    match sqlx::query!(
+        r#"SELECT COUNT(*) AS "count!" FROM notification_hooks WHERE project_id = $1"#,
+        project_record.id
+    )
+    .fetch_one(&pool)
+    .await
+    {
+        Ok(record) if record.count >= MAX_HOOKS => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("A project can have at most {MAX_HOOKS} notification hooks")
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Ok(_) => {}
+        Err(err) => {
+            tracing::error!(?err, "Can't get notification hooks: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    }
+
+    let events = events.unwrap_or_else(|| {
+        Event::ALL.iter().map(|event| event.name().to_string()).collect()
+    });
+
+    let id = Uuid::from(Ulid::new());
+    let secret = webhook_secret();
+    let created_at = match sqlx::query!(
+        r#"INSERT INTO notification_hooks (id, project_id, kind, url, secret, events)
+           VALUES ($1, $2, $3, $4, $5, $6)
+           ON CONFLICT (project_id, url) DO NOTHING
+           RETURNING created_at
+        "#,
+        id,
+        project_record.id,
+        kind,
+        url,
+        secret,
+        &events
+    )
+    .fetch_optional(&pool)
+    .await
+    {
+        Ok(Some(record)) => record.created_at,
+        Ok(None) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Notification hook already exists".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Err(err) => {
+            tracing::error!(?err, "Can't add notification hook: Failed to insert into database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Failed to insert into database".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    let json = serde_json::to_string(&NotificationHook {
+        id,
+        url: redact(&kind, &url),
+        events,
+        secret: (kind == "webhook").then_some(secret),
+        kind,
+        created_at,
+    }).unwrap();
+
+    Response::builder()
+        .status(StatusCode::CREATED)
+        .body(Body::from(json))
+        .unwrap()
+}
@@ -0,0 +1,157 @@
+use axum::extract::{State, Path};
+use axum::response::Response;
+use hyper::{Body, StatusCode};
+use serde::Serialize;
+use uuid::Uuid;
+
+use crate::audit::{with_change, AuditChange};
+use crate::pipelines::queue;
+use crate::{auth::Auth, startup::AppState};
+
+#[derive(Serialize, Debug)]
+struct ApprovePromotionResponse {
+    message: String,
+}
+
+#[derive(Serialize, Debug)]
+struct ErrorResponse {
+    message: String,
+}
+
+/// Runs a release waiting to be promoted to the app. The member who promoted it can't approve
+/// it themselves, see [`crate::pipelines`]
+#[tracing::instrument(skip(auth, pool, build_channel))]
+pub async fn post(
+    auth: Auth,
+    State(AppState { pool, base, build_channel, .. }): State<AppState>,
+    Path((owner, project, promotion_id)): Path<(String, String, Uuid)>,
+) -> Response<Body> {
+    let user = auth.current_user.unwrap();
+
+    let promotion = match sqlx::query!(
+        r#"SELECT promotions.release_id, promotions.requested_by, promotions.status
+           FROM promotions
+           JOIN projects ON promotions.target_id = projects.id
+           JOIN project_owners ON projects.owner_id = project_owners.id
+           WHERE promotions.id = $1
+           AND projects.name = $2
+           AND project_owners.name = $3
+        "#,
+        promotion_id,
+        project,
+        owner,
+    )
+    .fetch_optional(&pool)
+    .await
+    {
+        Ok(Some(promotion)) => promotion,
+        Ok(None) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Promotion does not exist".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Err(err) => {
+            tracing::error!(?err, "Can't get promotion: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    if promotion.status != "pending" {
+        let json = serde_json::to_string(&ErrorResponse {
+            message: format!("Promotion was already {}", promotion.status)
+        }).unwrap();
+
+        return Response::builder()
+            .status(StatusCode::BAD_REQUEST)
+            .body(Body::from(json))
+            .unwrap();
+    }
+
+    if promotion.requested_by == user.id {
+        let json = serde_json::to_string(&ErrorResponse {
+            message: "Another member has to approve your promotion".to_string()
+        }).unwrap();
+
+        return Response::builder()
+            .status(StatusCode::FORBIDDEN)
+            .body(Body::from(json))
+            .unwrap();
+    }
+
+    // two approvals at once run the release once
+    match sqlx::query!(
+        r#"UPDATE promotions SET status = 'approved', decided_by = $1, decided_at = now()
+           WHERE id = $2 AND status = 'pending'
+        "#,
+        user.id,
+        promotion_id
+    )
+    .execute(&pool)
+    .await
+    {
+        Ok(result) if result.rows_affected() == 0 => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Promotion was already decided".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Ok(_) => {}
+        Err(err) => {
+            tracing::error!(?err, "Can't approve promotion: Failed to update database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Failed to update database".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    }
+
+    let repo = project.trim_end_matches(".git");
+    if let Err(err) = queue(promotion.release_id, &owner, repo, &base, &build_channel).await {
+        tracing::error!(?err, "Can't approve promotion: Failed to send to build queue");
+
+        let json = serde_json::to_string(&ErrorResponse {
+            message: "Failed to queue promotion".to_string()
+        }).unwrap();
+
+        return Response::builder()
+            .status(StatusCode::INTERNAL_SERVER_ERROR)
+            .body(Body::from(json))
+            .unwrap();
+    }
+
+    let json = serde_json::to_string(&ApprovePromotionResponse {
+        message: format!("Promotion of release {} to {owner}/{repo} queued", promotion.release_id),
+    }).unwrap();
+    let before = serde_json::json!({ "promotion_id": promotion_id, "status": "pending" });
+    let after = serde_json::json!({ "promotion_id": promotion_id, "status": "approved" });
+
+    with_change(
+        Response::builder()
+            .status(StatusCode::ACCEPTED)
+            .body(Body::from(json))
+            .unwrap(),
+        AuditChange::new(Some(before), Some(after)),
+    )
+}
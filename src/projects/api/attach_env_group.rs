@@ -0,0 +1,175 @@
+use axum::extract::{Path, State};
+use axum::response::Response;
+use axum::Json;
+use garde::{Unvalidated, Validate};
+use hyper::{Body, StatusCode};
+use serde::{Deserialize, Serialize};
+
+use crate::audit::{with_change, AuditChange};
+use crate::env_groups::find_group;
+use crate::telemetry::current_context;
+use crate::{auth::Auth, queue::{BuildKind, BuildQueueItem}, startup::AppState};
+
+#[derive(Deserialize, Validate, Debug)]
+pub struct AttachEnvGroupRequest {
+    /// a group of the owner of the app
+    #[garde(length(min = 1))]
+    pub group: String,
+}
+
+#[derive(Serialize, Debug)]
+struct ErrorResponse {
+    message: String,
+}
+
+/// Attaches an env group of the owner to the app and releases it again with the variables of
+/// the group. Attaching a group again moves it last, so it wins over the others
+#[tracing::instrument(skip(auth, pool, build_channel))]
+pub async fn post(
+    auth: Auth,
+    State(AppState { pool, base, build_channel, .. }): State<AppState>,
+    Path((owner, project)): Path<(String, String)>,
+    Json(req): Json<Unvalidated<AttachEnvGroupRequest>>,
+) -> Response<Body> {
+    let _user = auth.current_user.unwrap();
+
+    let AttachEnvGroupRequest { group } = match req.validate(&()) {
+        Ok(valid) => valid.into_inner(),
+        Err(err) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: err.to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    // check if project exist
+    let project_record = match sqlx::query!(
+        r#"SELECT projects.id AS id
+           FROM projects
+           JOIN project_owners ON projects.owner_id = project_owners.id
+           WHERE projects.name = $1
+           AND project_owners.name = $2
+        "#,
+        project,
+        owner,
+    )
+    .fetch_optional(&pool)
+    .await
+    {
+        Ok(Some(record)) => record,
+        Ok(None) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Project does not exist".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Err(err) => {
+            tracing::error!(?err, "Can't get projects: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    let group_id = match find_group(&owner, &group, &pool).await {
+        Ok(Some(group_id)) => group_id,
+        Ok(None) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("{owner} has no env group named {group}")
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Err(err) => {
+            tracing::error!(?err, "Can't attach env group: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    if let Err(err) = sqlx::query!(
+        r#"INSERT INTO project_env_groups (project_id, group_id) VALUES ($1, $2)
+           ON CONFLICT (project_id, group_id) DO UPDATE SET created_at = now()
+        "#,
+        project_record.id,
+        group_id
+    )
+    .execute(&pool)
+    .await
+    {
+        tracing::error!(?err, "Can't attach env group: Failed to insert into database");
+
+        let json = serde_json::to_string(&ErrorResponse {
+            message: "Failed to insert into database".to_string()
+        }).unwrap();
+
+        return Response::builder()
+            .status(StatusCode::INTERNAL_SERVER_ERROR)
+            .body(Body::from(json))
+            .unwrap();
+    }
+
+    let after = serde_json::json!({ "group": &group });
+
+    // running apps only see the change through a new release of the live image, projects
+    // that were never deployed pick it up on their first build
+    match sqlx::query!("SELECT id FROM releases WHERE project_id = $1 LIMIT 1", project_record.id)
+        .fetch_optional(&pool)
+        .await
+    {
+        Ok(Some(_)) => {
+            let repo = project.trim_end_matches(".git");
+
+            if let Err(err) = build_channel
+                .send(BuildQueueItem {
+                    container_name: format!("{owner}-{repo}").replace('.', "-"),
+                    container_src: format!("{base}/{owner}/{repo}.git/master"),
+                    owner,
+                    repo: repo.to_string(),
+                    kind: BuildKind::Reconfigure(format!("Attach env group {group}")),
+                    trace: current_context(),
+                })
+                .await
+            {
+                tracing::error!(?err, "Can't release env groups: Failed to send to build queue");
+            }
+        }
+        Ok(None) => {}
+        Err(err) => {
+            tracing::error!(?err, "Can't release env groups: Failed to query database");
+        }
+    }
+
+    with_change(
+        Response::builder()
+            .status(StatusCode::NO_CONTENT)
+            .body(Body::empty())
+            .unwrap(),
+        AuditChange::new(None, Some(after)),
+    )
+}
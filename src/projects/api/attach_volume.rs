@@ -0,0 +1,304 @@
+use axum::extract::{State, Path};
+use axum::response::Response;
+use axum::Json;
+use garde::{Unvalidated, Validate};
+use hyper::{Body, StatusCode};
+use serde::{Deserialize, Serialize};
+use ulid::Ulid;
+use uuid::Uuid;
+
+use super::view_volumes::Volume;
+use crate::limits::project_limits;
+use crate::monorepo::repo_path_valid;
+use crate::quotas::check_storage;
+use crate::volumes::create_volume;
+use crate::telemetry::current_context;
+use crate::{auth::Auth, queue::{BuildKind, BuildQueueItem}, startup::AppState};
+
+#[derive(Deserialize, Validate, Debug)]
+pub struct AttachVolumeRequest {
+    /// attaching a name the app already has changes its path or size
+    #[garde(pattern("^[a-z0-9][a-z0-9-]{0,29}$"))]
+    pub name: String,
+    #[garde(custom(mount_path_check))]
+    pub mount_path: String,
+    #[garde(range(min = 1))]
+    pub size_mb: i32,
+}
+
+#[derive(Serialize, Debug)]
+struct ErrorResponse {
+    message: String,
+}
+
+/// directories the container runtime owns, a volume over them breaks the container
+const RESERVED_PATHS: [&str; 3] = ["proc", "sys", "dev"];
+
+fn mount_path_check(value: &str, _ctx: &()) -> garde::Result {
+    let valid = value
+        .strip_prefix('/')
+        .filter(|path| repo_path_valid(path) && !path.contains(':'))
+        .filter(|path| !RESERVED_PATHS.iter().any(|reserved| std::path::Path::new(path).starts_with(reserved)));
+    match valid {
+        Some(_) => Ok(()),
+        None => Err(garde::Error::new(
+            "Mount path must be an absolute path below / like /data, outside /proc, /sys and /dev",
+        )),
+    }
+}
+
+#[tracing::instrument(skip(auth, pool, build_channel, quota_settings))]
+pub async fn post(
+    auth: Auth,
+    State(AppState { pool, base, build_channel, container_settings, quota_settings, .. }): State<AppState>,
+    Path((owner, project)): Path<(String, String)>,
+    Json(req): Json<Unvalidated<AttachVolumeRequest>>,
+) -> Response<Body> {
+    let _user = auth.current_user.unwrap();
+
+    let AttachVolumeRequest { name, mount_path, size_mb } = match req.validate(&()) {
+        Ok(valid) => valid.into_inner(),
+        Err(err) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: err.to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+    let mount_path = mount_path.trim_end_matches('/').to_string();
+
+    // check if project exist
+    let project_record = match sqlx::query!(
+        r#"SELECT projects.id AS id
+           FROM projects
+           JOIN project_owners ON projects.owner_id = project_owners.id
+           JOIN users_owners ON project_owners.id = users_owners.owner_id
+           AND projects.name = $1
+           AND project_owners.name = $2
+        "#,
+        project,
+        owner,
+    )
+    .fetch_optional(&pool)
+    .await
+    {
+        Ok(Some(record)) => record,
+        Ok(None) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Project does not exist".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Err(err) => {
+            tracing::error!(?err, "Can't get projects: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    let volumes = match sqlx::query!(
+        "SELECT name, mount_path, size_mb FROM volumes WHERE project_id = $1",
+        project_record.id
+    )
+    .fetch_all(&pool)
+    .await
+    {
+        Ok(volumes) => volumes,
+        Err(err) => {
+            tracing::error!(?err, "Can't get volumes: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    let existing = volumes.iter().find(|volume| volume.name == name);
+    let others = volumes.iter().filter(|volume| volume.name != name);
+
+    if let Some(other) = others.clone().find(|volume| volume.mount_path == mount_path) {
+        let json = serde_json::to_string(&ErrorResponse {
+            message: format!("Volume {} is already mounted at {mount_path}", other.name)
+        }).unwrap();
+
+        return Response::builder()
+            .status(StatusCode::BAD_REQUEST)
+            .body(Body::from(json))
+            .unwrap();
+    }
+
+    let disk = match project_limits(project_record.id, &container_settings, &pool).await {
+        Ok(limits) => limits.disk,
+        Err(err) => {
+            tracing::error!(?err, "Can't get limits: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    let reserved = others.map(|volume| volume.size_mb).sum::<i32>();
+    if reserved + size_mb > disk {
+        let json = serde_json::to_string(&ErrorResponse {
+            message: format!(
+                "The volumes of this app can't be bigger than {disk} MiB together, {reserved} MiB are taken by its other volumes"
+            )
+        }).unwrap();
+
+        return Response::builder()
+            .status(StatusCode::BAD_REQUEST)
+            .body(Body::from(json))
+            .unwrap();
+    }
+
+    // shrinking a volume always fits in the quotas of its owners
+    let growing = size_mb - existing.map(|volume| volume.size_mb).unwrap_or(0);
+    if growing > 0 {
+        match check_storage(project_record.id, i64::from(growing), &quota_settings, &pool).await {
+            Ok(None) => {}
+            Ok(Some(message)) => {
+                let json = serde_json::to_string(&ErrorResponse { message }).unwrap();
+
+                return Response::builder()
+                    .status(StatusCode::FORBIDDEN)
+                    .body(Body::from(json))
+                    .unwrap();
+            }
+            Err(err) => {
+                tracing::error!(?err, "Can't check storage quota: Failed to query usage");
+
+                let json = serde_json::to_string(&ErrorResponse {
+                    message: format!("Failed to check storage quota: {err}")
+                }).unwrap();
+
+                return Response::builder()
+                    .status(StatusCode::INTERNAL_SERVER_ERROR)
+                    .body(Body::from(json))
+                    .unwrap();
+            }
+        }
+    }
+
+    let container_name = format!("{owner}-{}", project.trim_end_matches(".git")).replace('.', "-");
+    // a new mount only shows up in containers started after it
+    let remount = existing.map(|volume| volume.mount_path != mount_path).unwrap_or(true);
+
+    if existing.is_none() {
+        if let Err(err) = create_volume(&container_name, &name).await {
+            tracing::error!(?err, "Can't attach volume: Failed to create volume");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to create volume: {err}")
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    }
+
+    let volume = match sqlx::query!(
+        r#"INSERT INTO volumes (id, project_id, name, mount_path, size_mb)
+           VALUES ($1, $2, $3, $4, $5)
+           ON CONFLICT (project_id, name)
+           DO UPDATE SET mount_path = EXCLUDED.mount_path, size_mb = EXCLUDED.size_mb
+           RETURNING created_at
+        "#,
+        Uuid::from(Ulid::new()),
+        project_record.id,
+        name,
+        mount_path,
+        size_mb
+    )
+    .fetch_one(&pool)
+    .await
+    {
+        Ok(volume) => volume,
+        Err(err) => {
+            tracing::error!(?err, "Can't attach volume: Failed to insert into database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    // running apps only see the change through a new release of the live image, projects
+    // that were never deployed pick it up on their first build
+    if remount {
+        match sqlx::query!("SELECT id FROM releases WHERE project_id = $1 LIMIT 1", project_record.id)
+            .fetch_optional(&pool)
+            .await
+        {
+            Ok(Some(_)) => {
+                let repo = project.trim_end_matches(".git");
+
+                if let Err(err) = build_channel
+                    .send(BuildQueueItem {
+                        container_name: container_name.clone(),
+                        container_src: format!("{base}/{owner}/{repo}.git/master"),
+                        owner,
+                        repo: repo.to_string(),
+                        kind: BuildKind::Reconfigure(format!("Attach volume {name} at {mount_path}")),
+                        trace: current_context(),
+                    })
+                    .await
+                {
+                    tracing::error!(?err, "Can't release project volumes: Failed to send to build queue");
+                }
+            }
+            Ok(None) => {}
+            Err(err) => {
+                tracing::error!(?err, "Can't release project volumes: Failed to query database");
+            }
+        }
+    }
+
+    let json = serde_json::to_string(&Volume {
+        name,
+        mount_path,
+        size_mb,
+        used_bytes: None,
+        created_at: volume.created_at,
+    }).unwrap();
+
+    Response::builder()
+        .status(match existing {
+            Some(_) => StatusCode::OK,
+            None => StatusCode::CREATED,
+        })
+        .body(Body::from(json))
+        .unwrap()
+}
@@ -0,0 +1,150 @@
+use axum::extract::{Path, Query, State};
+use axum::response::Response;
+use hyper::{Body, StatusCode};
+use serde::{Deserialize, Serialize};
+use sqlx::PgPool;
+
+use crate::monorepo::repo_path_valid;
+use crate::volumes::{list_entries, volume_name, VolumeEntry};
+use crate::{auth::Auth, startup::AppState};
+
+#[derive(Deserialize, Debug)]
+pub struct BrowseVolumeQuery {
+    /// relative to the root of the volume, missing for the root
+    pub path: Option<String>,
+}
+
+#[derive(Serialize, Debug)]
+struct BrowseVolumeResponse {
+    path: String,
+    data: Vec<VolumeEntry>,
+}
+
+#[derive(Serialize, Debug)]
+struct ErrorResponse {
+    message: String,
+}
+
+fn error_response(status: StatusCode, message: String) -> Response<Body> {
+    let json = serde_json::to_string(&ErrorResponse { message }).unwrap();
+
+    Response::builder()
+        .status(status)
+        .body(Body::from(json))
+        .unwrap()
+}
+
+/// The docker volume behind volume `name` of a project and the image of its live release,
+/// which the volume is read through. The error is the response to send
+pub async fn browse_target(
+    pool: &PgPool,
+    owner: &str,
+    project: &str,
+    name: &str,
+    path: &str,
+) -> Result<(String, String), Response<Body>> {
+    if !path.is_empty() && !repo_path_valid(path) {
+        return Err(error_response(
+            StatusCode::BAD_REQUEST,
+            "Path must be relative to the root of the volume, like uploads/2024".to_string(),
+        ));
+    }
+
+    let volume = match sqlx::query!(
+        r#"SELECT volumes.project_id
+           FROM volumes
+           JOIN projects ON projects.id = volumes.project_id
+           JOIN project_owners ON projects.owner_id = project_owners.id
+           JOIN users_owners ON project_owners.id = users_owners.owner_id
+           AND projects.name = $1
+           AND project_owners.name = $2
+           AND volumes.name = $3
+        "#,
+        project,
+        owner,
+        name,
+    )
+    .fetch_optional(pool)
+    .await
+    {
+        Ok(Some(volume)) => volume,
+        Ok(None) => {
+            return Err(error_response(
+                StatusCode::NOT_FOUND,
+                format!("Project has no volume {name}"),
+            ));
+        }
+        Err(err) => {
+            tracing::error!(?err, "Can't get volumes: Failed to query database");
+            return Err(error_response(
+                StatusCode::INTERNAL_SERVER_ERROR,
+                format!("Failed to query database: {}", err.to_string()),
+            ));
+        }
+    };
+
+    let image = match sqlx::query!(
+        "SELECT image FROM releases WHERE project_id = $1 ORDER BY created_at DESC LIMIT 1",
+        volume.project_id
+    )
+    .fetch_optional(pool)
+    .await
+    {
+        Ok(Some(release)) => release.image,
+        // nothing ever ran with the volume, it is empty
+        Ok(None) => {
+            return Err(error_response(
+                StatusCode::BAD_REQUEST,
+                "Volumes can be browsed once the app is deployed".to_string(),
+            ));
+        }
+        Err(err) => {
+            tracing::error!(?err, "Can't get releases: Failed to query database");
+            return Err(error_response(
+                StatusCode::INTERNAL_SERVER_ERROR,
+                format!("Failed to query database: {}", err.to_string()),
+            ));
+        }
+    };
+
+    let container_name = format!("{owner}-{}", project.trim_end_matches(".git")).replace('.', "-");
+    Ok((volume_name(&container_name, name), image))
+}
+
+#[tracing::instrument(skip(auth, pool))]
+pub async fn get(
+    auth: Auth,
+    State(AppState { pool, .. }): State<AppState>,
+    Path((owner, project, name)): Path<(String, String, String)>,
+    Query(BrowseVolumeQuery { path }): Query<BrowseVolumeQuery>,
+) -> Response<Body> {
+    let _user = auth.current_user.unwrap();
+
+    let path = path.unwrap_or_default().trim_matches('/').to_string();
+    let (volume, image) = match browse_target(&pool, &owner, &project, &name, &path).await {
+        Ok(target) => target,
+        Err(response) => return response,
+    };
+
+    let data = match list_entries(&volume, &image, &path).await {
+        Ok(data) => data,
+        Err(err) => {
+            // docker answers a missing path like any other failure
+            tracing::warn!(?err, "Can't browse volume: Failed to read volume");
+            return error_response(
+                StatusCode::BAD_REQUEST,
+                format!("Failed to read /{path} of volume {name}: {err}"),
+            );
+        }
+    };
+
+    let json = serde_json::to_string(&BrowseVolumeResponse {
+        path: format!("/{path}"),
+        data,
+    }).unwrap();
+
+    Response::builder()
+        .status(StatusCode::OK)
+        .body(Body::from(json))
+        .unwrap()
+}
@@ -0,0 +1,164 @@
+use axum::extract::{State, Path};
+use axum::response::Response;
+use hyper::{Body, StatusCode};
+use serde::Serialize;
+use uuid::Uuid;
+
+use super::view_build_log::BuildState;
+use crate::live::{self, Update};
+use crate::queue::Cancelled;
+use crate::{auth::Auth, startup::AppState};
+
+#[derive(Serialize, Debug)]
+struct CancelBuildResponse {
+    message: String,
+}
+
+#[derive(Serialize, Debug)]
+struct ErrorResponse {
+    message: String,
+}
+
+/// Cancels a waiting build, or stops a running one while it builds or pulls its image
+#[tracing::instrument(skip(auth, pool, build_queue))]
+pub async fn post(
+    auth: Auth,
+    State(AppState { pool, build_queue, .. }): State<AppState>,
+    Path((owner, project, build_id)): Path<(String, String, Uuid)>,
+) -> Response<Body> {
+    let user = auth.current_user.unwrap();
+
+    // check if project exist
+    let project_record = match sqlx::query!(
+        r#"SELECT projects.id AS id
+           FROM projects
+           JOIN project_owners ON projects.owner_id = project_owners.id
+           JOIN users_owners ON project_owners.id = users_owners.owner_id
+           AND projects.name = $1
+           AND project_owners.name = $2
+        "#,
+        project,
+        owner,
+    )
+    .fetch_optional(&pool)
+    .await
+    {
+        Ok(Some(record)) => record,
+        Ok(None) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Project does not exist".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Err(err) => {
+            tracing::error!(?err, "Can't get projects: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    let build = match sqlx::query!(
+        r#"SELECT status AS "status: BuildState" FROM builds WHERE id = $1 AND project_id = $2"#,
+        build_id,
+        project_record.id
+    )
+    .fetch_optional(&pool)
+    .await
+    {
+        Ok(Some(build)) => build,
+        Ok(None) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Build does not exist".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::NOT_FOUND)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Err(err) => {
+            tracing::error!(?err, "Can't get build: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    if !matches!(build.status, BuildState::PENDING | BuildState::BUILDING) {
+        let json = serde_json::to_string(&ErrorResponse {
+            message: format!("Build is already {}", build.status.to_string().to_lowercase())
+        }).unwrap();
+
+        return Response::builder()
+            .status(StatusCode::BAD_REQUEST)
+            .body(Body::from(json))
+            .unwrap();
+    }
+
+    let reason = format!("Cancelled by {}", user.username);
+    let (status, message) = match build_queue.cancel(build_id, &reason, &pool).await {
+        Cancelled::Queued => (StatusCode::OK, "Build cancelled"),
+        Cancelled::Running => (StatusCode::ACCEPTED, "Cancelling build"),
+        Cancelled::Finishing => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Build is starting the new container and can't be cancelled".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::CONFLICT)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        // the queue lives in memory, builds that were waiting or running when the server
+        // stopped never finish
+        Cancelled::NotFound => {
+            if let Err(err) = sqlx::query!(
+                "UPDATE builds SET status = 'cancelled', log = log || $1, finished_at = now() WHERE id = $2",
+                format!("{reason}\n"),
+                build_id
+            )
+            .execute(&pool)
+            .await
+            {
+                tracing::error!(?err, "Can't cancel build: Failed to query database");
+
+                let json = serde_json::to_string(&ErrorResponse {
+                    message: format!("Failed to query database: {}", err.to_string())
+                }).unwrap();
+
+                return Response::builder()
+                    .status(StatusCode::INTERNAL_SERVER_ERROR)
+                    .body(Body::from(json))
+                    .unwrap();
+            }
+            live::publish(project_record.id, Update::Build { build_id, status: "CANCELLED" });
+            (StatusCode::OK, "Build cancelled")
+        }
+    };
+
+    let json = serde_json::to_string(&CancelBuildResponse {
+        message: message.to_string(),
+    }).unwrap();
+
+    Response::builder()
+        .status(status)
+        .body(Body::from(json))
+        .unwrap()
+}
@@ -0,0 +1,39 @@
+use axum::extract::{Query, State};
+use axum::response::Response;
+use hyper::{Body, StatusCode};
+use serde::Deserialize;
+
+use crate::startup::AppState;
+
+#[derive(Deserialize, Debug)]
+pub struct CheckCustomDomainQuery {
+    domain: String,
+}
+
+/// Asked by caddy before it requests a certificate on demand. Only verified custom domains
+/// get one, anything else would let arbitrary hosts burn through the acme rate limits.
+#[tracing::instrument(skip(pool))]
+pub async fn get(
+    State(AppState { pool, .. }): State<AppState>,
+    Query(CheckCustomDomainQuery { domain }): Query<CheckCustomDomainQuery>,
+) -> Response<Body> {
+    let status = match sqlx::query!(
+        r#"SELECT id FROM custom_domains WHERE name = $1 AND verified_at IS NOT NULL"#,
+        domain
+    )
+    .fetch_optional(&pool)
+    .await
+    {
+        Ok(Some(_)) => StatusCode::OK,
+        Ok(None) => StatusCode::NOT_FOUND,
+        Err(err) => {
+            tracing::error!(?err, "Can't check custom domain: Failed to query database");
+            StatusCode::INTERNAL_SERVER_ERROR
+        }
+    };
+
+    Response::builder()
+        .status(status)
+        .body(Body::empty())
+        .unwrap()
+}
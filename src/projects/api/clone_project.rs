@@ -0,0 +1,575 @@
+use axum::extract::{State, Path};
+use axum::response::Response;
+use axum::{Extension, Json};
+use garde::{Unvalidated, Validate};
+use hyper::{Body, StatusCode};
+use serde::{Deserialize, Serialize};
+use sqlx::PgConnection;
+use ulid::Ulid;
+use uuid::Uuid;
+
+use crate::audit::{with_change, AuditChange};
+use crate::auth::tokens::TokenAccess;
+use crate::git::fork_repository;
+use crate::owner::{member_role, suspension, Role};
+use super::create_project::git_token;
+use crate::quotas::check_new_app;
+use crate::telemetry::current_context;
+use crate::{auth::Auth, queue::{BuildKind, BuildQueueItem}, startup::AppState};
+
+#[derive(Deserialize, Validate, Debug)]
+pub struct CloneProjectRequest {
+    /// where the clone goes, missing is the account of the user
+    #[garde(length(min = 1))]
+    pub owner: Option<String>,
+    /// missing keeps the name of the app
+    #[garde(alphanumeric)]
+    pub project: Option<String>,
+    /// also deploy the live release of the app, without it the clone waits for a push
+    #[serde(default)]
+    #[garde(skip)]
+    pub image: bool,
+}
+
+#[derive(Serialize, Debug)]
+struct CloneProjectResponse {
+    id: Uuid,
+    owner_name: String,
+    project_name: String,
+    domain: String,
+    git_username: String,
+    git_password: String,
+    cloned_from: String,
+    /// the live release of the app is being deployed to the clone
+    deploying: bool,
+}
+
+#[derive(Serialize, Debug)]
+struct ErrorResponse {
+    message: String
+}
+
+/// Copies an app into a new one: its settings, its environment without the secrets marked
+/// to stay behind, its cron jobs and its repository. Clones by anyone but its maintainers get
+/// no secrets at all. Add-ons, volumes, domains and the rest
+/// that holds data of the app are not copied. Members who may change the app clone it, and
+/// anyone signed in once it is cloneable, see [`crate::owner::authorize`]
+#[tracing::instrument(skip(auth, token, pool, base, domain, quota_settings, build_channel))]
+pub async fn post(
+    auth: Auth,
+    token: Option<Extension<TokenAccess>>,
+    State(AppState {
+        pool, base, domain, secure, quota_settings, build_channel, ..
+    }): State<AppState>,
+    Path((owner, project)): Path<(String, String)>,
+    Json(req): Json<Unvalidated<CloneProjectRequest>>,
+) -> Response<Body> {
+    let user = auth.current_user.unwrap();
+
+    let CloneProjectRequest { owner: target_owner, project: target_project, image } = match req.validate(&()) {
+        Ok(valid) => valid.into_inner(),
+        Err(err) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: err.to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+    let project = project.trim_end_matches(".git").to_string();
+    let target_owner = target_owner.unwrap_or_else(|| user.username.clone());
+    let target_project = target_project.unwrap_or_else(|| project.clone());
+
+    // the token of one app can't make others
+    if token.is_some_and(|Extension(token)| token.project_id.is_some()) {
+        let json = serde_json::to_string(&ErrorResponse {
+            message: "A token of one app can't clone it into another".to_string()
+        }).unwrap();
+
+        return Response::builder()
+            .status(StatusCode::FORBIDDEN)
+            .body(Body::from(json))
+            .unwrap();
+    }
+
+    // check if project exist, the user doesn't have to be a member
+    let source = match sqlx::query!(
+        r#"SELECT projects.id AS id, projects.cloneable
+           FROM projects
+           JOIN project_owners ON projects.owner_id = project_owners.id
+           WHERE projects.name = $1
+           AND project_owners.name = $2
+           AND projects.deleted_at IS NULL
+        "#,
+        project,
+        owner,
+    )
+    .fetch_optional(&pool)
+    .await
+    {
+        Ok(Some(record)) => record,
+        Ok(None) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Project does not exist".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Err(err) => {
+            tracing::error!(?err, "Can't get projects: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    // secrets only go to clones made by maintainers, anyone else would read them in their clone
+    let copy_secrets = match member_role(user.id, &owner, &pool).await {
+        Ok(Some(role)) if role >= Role::Maintainer => true,
+        Ok(_) if source.cloneable => false,
+        Ok(Some(role)) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Only a maintainer of {owner} can clone {owner}/{project}, you are a {role}")
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::FORBIDDEN)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        // the same answer as for apps that don't exist, so apps of others can't be probed
+        Ok(None) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Project does not exist".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Err(err) => {
+            tracing::error!(?err, "Can't get users_owners: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    match suspension(&owner, &project, &pool).await {
+        Ok(None) => {}
+        Ok(Some(reason)) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("{owner}/{project} is suspended: {reason}")
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::FORBIDDEN)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Err(err) => {
+            tracing::error!(?err, "Can't get suspension: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    }
+
+    // the clone is a new app of the target owner, the same as creating it there
+    match member_role(user.id, &target_owner, &pool).await {
+        Ok(Some(role)) if role >= Role::Maintainer => {}
+        Ok(Some(role)) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Only a maintainer of {target_owner} can create apps, you are a {role}")
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::FORBIDDEN)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Ok(None) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Owner does not exist".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Err(err) => {
+            tracing::error!(?err, "Can't get users_owners: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    }
+
+    let owner_id = match sqlx::query!(
+        r#"SELECT id FROM project_owners WHERE name = $1 AND deleted_at IS NULL"#,
+        target_owner,
+    )
+    .fetch_optional(&pool)
+    .await
+    {
+        Ok(Some(data)) => data.id,
+        Ok(None) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Owner does not exist".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Err(err) => {
+            tracing::error!(?err, "Can't get project_owners: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    match check_new_app(owner_id, &quota_settings, &pool).await {
+        Ok(None) => {}
+        Ok(Some(message)) => {
+            let json = serde_json::to_string(&ErrorResponse { message }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::FORBIDDEN)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Err(err) => {
+            tracing::error!(?err, "Can't check app quota: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    }
+
+    match sqlx::query!(
+        r#"SELECT id FROM projects WHERE name = $1 AND owner_id = $2"#,
+        target_project,
+        owner_id,
+    )
+    .fetch_optional(&pool)
+    .await
+    {
+        Ok(None) => {}
+        Ok(_) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Project {target_owner}/{target_project} already exists"),
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::CONFLICT)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Err(err) => {
+            tracing::error!(?err, "Can't get projects: Failed to query database");
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    }
+
+    let mut tx = match pool.begin().await {
+        Ok(tx) => tx,
+        Err(err) => {
+            tracing::error!(?err, "Can't clone project: Failed to begin transaction");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to begin transaction {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    // what holds data of the app, suspensions, limits set by an admin, maintenance, the pin
+    // and the basic auth login stay behind
+    let project_id = match sqlx::query!(
+        r#"INSERT INTO projects (
+               id, name, owner_id, cloned_from_id, environs, secrets, formation, healthcheck_path,
+               idle_timeout, source_dir, watch_paths, internal, restart_policy, restart_retries,
+               error_page, error_redirect, protocol, response_buffering, response_timeout,
+               rate_limit, ip_rate_limit, rate_burst, allowed_ips, denied_ips, previews,
+               preview_environs, sticky_sessions, compression, edge_cache, https_redirect,
+               hsts_max_age, hsts_preload, cors_origins, cors_methods, cors_headers,
+               cors_credentials, cors_max_age, header_rules, access_log_sample,
+               access_log_retention, container_log_retention, container_log_size, push_branches,
+               push_max_size, push_secret_scan, deploy_branch, deploy_promote, block_severity,
+               egress_policy, egress_allow, body_limit, body_timeout
+           )
+           SELECT $1, $2, $3, projects.id, projects.environs,
+               CASE WHEN $5 THEN projects.secrets - projects.uncopied_secrets ELSE '{}'::jsonb END,
+               projects.formation,
+               projects.healthcheck_path, projects.idle_timeout, projects.source_dir,
+               projects.watch_paths, projects.internal, projects.restart_policy,
+               projects.restart_retries, projects.error_page, projects.error_redirect,
+               projects.protocol, projects.response_buffering, projects.response_timeout,
+               projects.rate_limit, projects.ip_rate_limit, projects.rate_burst,
+               projects.allowed_ips, projects.denied_ips, projects.previews,
+               projects.preview_environs, projects.sticky_sessions, projects.compression,
+               projects.edge_cache, projects.https_redirect, projects.hsts_max_age,
+               projects.hsts_preload, projects.cors_origins, projects.cors_methods,
+               projects.cors_headers, projects.cors_credentials, projects.cors_max_age,
+               projects.header_rules, projects.access_log_sample, projects.access_log_retention,
+               projects.container_log_retention, projects.container_log_size, projects.push_branches, projects.push_max_size, projects.push_secret_scan,
+               projects.deploy_branch, projects.deploy_promote, projects.block_severity,
+               projects.egress_policy, projects.egress_allow, projects.body_limit, projects.body_timeout
+           FROM projects
+           WHERE projects.id = $4
+           RETURNING id
+        "#,
+        Uuid::from(Ulid::new()),
+        target_project,
+        owner_id,
+        source.id,
+        copy_secrets,
+    )
+    .fetch_one(&mut *tx)
+    .await
+    {
+        Ok(data) => data.id,
+        Err(err) => {
+            tracing::error!(?err, "Can't clone project: Failed to insert into database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Failed to insert into database".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    if let Err(err) = copy_cron_jobs(source.id, project_id, &mut *tx).await {
+        tracing::error!(?err, "Can't clone project: Failed to copy cron jobs");
+
+        let json = serde_json::to_string(&ErrorResponse {
+            message: "Failed to insert into database".to_string()
+        }).unwrap();
+
+        return Response::builder()
+            .status(StatusCode::INTERNAL_SERVER_ERROR)
+            .body(Body::from(json))
+            .unwrap();
+    }
+
+    let (git_password, hash) = match git_token() {
+        Ok(token) => token,
+        Err(err) => {
+            tracing::error!(?err, "Can't clone project: Failed to hash token");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to generate token {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    if let Err(err) = sqlx::query!(
+        "INSERT INTO api_token (id, project_id, token) VALUES ($1, $2, $3)",
+        Uuid::from(Ulid::new()),
+        project_id,
+        hash,
+    )
+    .execute(&mut *tx)
+    .await
+    {
+        tracing::error!(?err, "Can't insert api_token: Failed to insert into database");
+
+        let json = serde_json::to_string(&ErrorResponse {
+            message: format!("Failed to insert into database {}", err.to_string())
+        }).unwrap();
+
+        return Response::builder()
+            .status(StatusCode::INTERNAL_SERVER_ERROR)
+            .body(Body::from(json))
+            .unwrap();
+    }
+
+    let source_path = format!("{base}/{owner}/{project}.git");
+    let path = format!("{base}/{target_owner}/{target_project}.git");
+    if let Err(err) = fork_repository(&source_path, &path) {
+        tracing::error!(?err, "Can't clone project: Failed to copy repo");
+
+        // a half copied repository would keep the name taken
+        if let Err(err) = std::fs::remove_dir_all(&path) {
+            tracing::error!(?err, "Can't clone project: Failed to remove repo");
+        }
+
+        let json = serde_json::to_string(&ErrorResponse {
+            message: format!("Failed to clone project: {err}")
+        }).unwrap();
+
+        return Response::builder()
+            .status(StatusCode::INTERNAL_SERVER_ERROR)
+            .body(Body::from(json))
+            .unwrap();
+    }
+
+    if let Err(err) = tx.commit().await {
+        tracing::error!(?err, "Can't clone project: Failed to commit transaction");
+
+        let json = serde_json::to_string(&ErrorResponse {
+            message: format!("Failed to commit transaction: {}", err.to_string())
+        }).unwrap();
+
+        return Response::builder()
+            .status(StatusCode::INTERNAL_SERVER_ERROR)
+            .body(Body::from(json))
+            .unwrap();
+    }
+
+    // the image is started with the environment of the clone, like a promote
+    let mut deploying = false;
+    if image {
+        match sqlx::query!(
+            "SELECT id FROM releases WHERE project_id = $1 ORDER BY created_at DESC LIMIT 1",
+            source.id
+        )
+        .fetch_optional(&pool)
+        .await
+        {
+            Ok(Some(release)) => {
+                deploying = match build_channel
+                    .send(BuildQueueItem {
+                        container_name: format!("{target_owner}-{target_project}").replace('.', "-"),
+                        container_src: format!("{path}/master"),
+                        owner: target_owner.clone(),
+                        repo: target_project.clone(),
+                        kind: BuildKind::Release(release.id),
+                        trace: current_context(),
+                    })
+                    .await
+                {
+                    Ok(()) => true,
+                    Err(err) => {
+                        tracing::error!(?err, "Can't clone project: Failed to send to build queue");
+                        false
+                    }
+                };
+            }
+            Ok(None) => {}
+            Err(err) => {
+                tracing::error!(?err, "Can't clone project: Failed to query database");
+            }
+        }
+    }
+
+    let protocol = match secure {
+        true => "https",
+        false => "http",
+    };
+
+    let json = serde_json::to_string(&CloneProjectResponse {
+        id: project_id,
+        owner_name: target_owner.clone(),
+        project_name: target_project.clone(),
+        domain: format!("{protocol}://{domain}/{target_owner}/{target_project}"),
+        git_username: user.username,
+        git_password,
+        cloned_from: format!("{owner}/{project}"),
+        deploying,
+    }).unwrap();
+
+    with_change(
+        Response::builder()
+            .status(StatusCode::OK)
+            .body(Body::from(json))
+            .unwrap(),
+        AuditChange::new(None, Some(serde_json::json!({
+            "clone": format!("{target_owner}/{target_project}"),
+            "image": image,
+        }))),
+    )
+}
+
+async fn copy_cron_jobs(from: Uuid, to: Uuid, conn: &mut PgConnection) -> Result<(), sqlx::Error> {
+    let jobs = sqlx::query!(
+        "SELECT schedule, command, next_run_at FROM cron_jobs WHERE project_id = $1",
+        from
+    )
+    .fetch_all(&mut *conn)
+    .await?;
+
+    for job in jobs {
+        sqlx::query!(
+            r#"INSERT INTO cron_jobs (id, project_id, schedule, command, next_run_at)
+               VALUES ($1, $2, $3, $4, $5)
+            "#,
+            Uuid::from(Ulid::new()),
+            to,
+            job.schedule,
+            &job.command,
+            job.next_run_at,
+        )
+        .execute(&mut *conn)
+        .await?;
+    }
+
+    Ok(())
+}
@@ -0,0 +1,283 @@
+use axum::extract::{State, Path};
+use axum::response::Response;
+use axum::Json;
+use hyper::{Body, StatusCode};
+use serde::{Deserialize, Serialize};
+use ulid::Ulid;
+use uuid::Uuid;
+
+use super::view_addons::{Addon, AddonKind};
+use crate::docker::{provision_postgres, remove_postgres};
+use crate::mail::{self, provision_mail};
+use crate::quotas::check_database;
+use crate::redis::{provision_redis, remove_redis};
+use crate::storage::{self, provision_bucket, remove_bucket};
+use crate::telemetry::current_context;
+use crate::{auth::Auth, queue::{BuildKind, BuildQueueItem}, startup::AppState};
+
+#[derive(Deserialize, Debug)]
+pub struct CreateAddonRequest {
+    pub kind: AddonKind,
+}
+
+#[derive(Serialize, Debug)]
+struct ErrorResponse {
+    message: String,
+}
+
+#[tracing::instrument(skip(auth, pool, build_channel, quota_settings))]
+pub async fn post(
+    auth: Auth,
+    State(AppState { pool, base, build_channel, container_settings, quota_settings, .. }): State<AppState>,
+    Path((owner, project)): Path<(String, String)>,
+    Json(CreateAddonRequest { kind }): Json<CreateAddonRequest>,
+) -> Response<Body> {
+    let _user = auth.current_user.unwrap();
+
+    // check if project exist
+    let project_record = match sqlx::query!(
+        r#"SELECT projects.id AS id
+           FROM projects
+           JOIN project_owners ON projects.owner_id = project_owners.id
+           JOIN users_owners ON project_owners.id = users_owners.owner_id
+           AND projects.name = $1
+           AND project_owners.name = $2
+        "#,
+        project,
+        owner,
+    )
+    .fetch_optional(&pool)
+    .await
+    {
+        Ok(Some(record)) => record,
+        Ok(None) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Project does not exist".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Err(err) => {
+            tracing::error!(?err, "Can't get projects: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    match sqlx::query!(
+        "SELECT id FROM addons WHERE project_id = $1 AND kind = $2",
+        project_record.id,
+        kind as AddonKind
+    )
+    .fetch_optional(&pool)
+    .await
+    {
+        Ok(None) => {}
+        Ok(Some(_)) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Project already has a {kind} addon")
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Err(err) => {
+            tracing::error!(?err, "Can't get addons: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    }
+
+    if kind == AddonKind::S3 && !storage::enabled() {
+        let json = serde_json::to_string(&ErrorResponse {
+            message: "Object storage is not set up on this platform".to_string()
+        }).unwrap();
+
+        return Response::builder()
+            .status(StatusCode::BAD_REQUEST)
+            .body(Body::from(json))
+            .unwrap();
+    }
+
+    if kind == AddonKind::Mail && !mail::enabled() {
+        let json = serde_json::to_string(&ErrorResponse {
+            message: "Mail is not set up on this platform".to_string()
+        }).unwrap();
+
+        return Response::builder()
+            .status(StatusCode::BAD_REQUEST)
+            .body(Body::from(json))
+            .unwrap();
+    }
+
+    // a bucket has a quota of its own
+    let database_check = match kind {
+        AddonKind::Postgres => check_database(project_record.id, &quota_settings, &pool).await,
+        AddonKind::S3 | AddonKind::Redis | AddonKind::Mail => Ok(None),
+    };
+    match database_check {
+        Ok(None) => {}
+        Ok(Some(message)) => {
+            let json = serde_json::to_string(&ErrorResponse { message }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::FORBIDDEN)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Err(err) => {
+            tracing::error!(?err, "Can't check database quota: Failed to query usage");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to check database quota: {err}")
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    }
+
+    let container_name = format!("{owner}-{}", project.trim_end_matches(".git")).replace('.', "-");
+    // buckets, redis and the relay take any number of connections
+    let connection_limit = match kind {
+        AddonKind::Postgres => container_settings.dbconnections,
+        AddonKind::S3 | AddonKind::Redis | AddonKind::Mail => 0,
+    };
+
+    let provisioned = match kind {
+        AddonKind::Postgres => provision_postgres(&container_name, connection_limit)
+            .await
+            .map(|(name, url)| (name, url, None)),
+        AddonKind::S3 => provision_bucket(&container_name)
+            .await
+            .map(|(name, url, quota)| (name, url, Some(quota))),
+        AddonKind::Redis => {
+            let memory = container_settings.redismemory;
+            provision_redis(&container_name, memory, &container_settings)
+                .await
+                .map(|(name, url)| (name, url, Some(memory)))
+        }
+        // without a quota of its own the app gets the daily quota of the platform
+        AddonKind::Mail => provision_mail(&container_name).map(|(address, url)| (address, url, None)),
+    };
+    let (name, url, quota) = match provisioned {
+        Ok(addon) => addon,
+        Err(err) => {
+            tracing::error!(?err, "Can't create addon: Failed to provision {kind}");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to provision {kind}: {err}")
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    let addon = match sqlx::query!(
+        r#"INSERT INTO addons (id, project_id, kind, name, url, connection_limit, quota)
+           VALUES ($1, $2, $3, $4, $5, $6, $7)
+           RETURNING created_at
+        "#,
+        Uuid::from(Ulid::new()),
+        project_record.id,
+        kind as AddonKind,
+        name,
+        url,
+        connection_limit,
+        quota
+    )
+    .fetch_one(&pool)
+    .await
+    {
+        Ok(addon) => addon,
+        Err(err) => {
+            // nothing tracks the container or bucket without its row
+            let removed = match kind {
+                AddonKind::Postgres => remove_postgres(&container_name).await,
+                AddonKind::S3 => remove_bucket(&container_name, &url).await,
+                AddonKind::Redis => remove_redis(&container_name).await,
+                AddonKind::Mail => Ok(()),
+            };
+            if let Err(err) = removed {
+                tracing::error!(?err, "Can't clean up addon: Failed to remove {kind}");
+            }
+
+            tracing::error!(?err, "Can't create addon: Failed to insert into database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    // running apps only see the change through a new release of the live image, projects
+    // that were never deployed pick it up on their first build
+    match sqlx::query!("SELECT id FROM releases WHERE project_id = $1 LIMIT 1", project_record.id)
+        .fetch_optional(&pool)
+        .await
+    {
+        Ok(Some(_)) => {
+            let repo = project.trim_end_matches(".git");
+
+            if let Err(err) = build_channel
+                .send(BuildQueueItem {
+                    container_name: container_name.clone(),
+                    container_src: format!("{base}/{owner}/{repo}.git/master"),
+                    owner,
+                    repo: repo.to_string(),
+                    kind: BuildKind::Reconfigure(format!("Add {kind}")),
+                    trace: current_context(),
+                })
+                .await
+            {
+                tracing::error!(?err, "Can't release project addons: Failed to send to build queue");
+            }
+        }
+        Ok(None) => {}
+        Err(err) => {
+            tracing::error!(?err, "Can't release project addons: Failed to query database");
+        }
+    }
+
+    let json = serde_json::to_string(&Addon {
+        kind,
+        name,
+        connection_limit,
+        quota,
+        created_at: addon.created_at,
+    }).unwrap();
+
+    Response::builder()
+        .status(StatusCode::CREATED)
+        .body(Body::from(json))
+        .unwrap()
+}
@@ -0,0 +1,187 @@
+use axum::extract::{State, Path};
+use axum::response::Response;
+use hyper::{Body, StatusCode};
+use serde::Serialize;
+use uuid::Uuid;
+
+use crate::backups::{database_name, run_backup, start_backup};
+use crate::{auth::Auth, startup::AppState};
+
+#[derive(Serialize, Debug)]
+struct CreateBackupResponse {
+    id: Uuid,
+}
+
+#[derive(Serialize, Debug)]
+struct ErrorResponse {
+    message: String,
+}
+
+/// Starts a manual backup of the postgres addon of a project. The dump runs in the
+/// background, its status shows up in the backup list
+#[tracing::instrument(skip(auth, pool, backups))]
+pub async fn post(
+    auth: Auth,
+    State(AppState { pool, backups, .. }): State<AppState>,
+    Path((owner, project)): Path<(String, String)>,
+) -> Response<Body> {
+    let _user = auth.current_user.unwrap();
+
+    if !backups.enabled() {
+        let json = serde_json::to_string(&ErrorResponse {
+            message: "Backups are not configured on this platform".to_string()
+        }).unwrap();
+
+        return Response::builder()
+            .status(StatusCode::BAD_REQUEST)
+            .body(Body::from(json))
+            .unwrap();
+    }
+
+    // check if project exist
+    let project_record = match sqlx::query!(
+        r#"SELECT projects.id AS id
+           FROM projects
+           JOIN project_owners ON projects.owner_id = project_owners.id
+           JOIN users_owners ON project_owners.id = users_owners.owner_id
+           AND projects.name = $1
+           AND project_owners.name = $2
+        "#,
+        project,
+        owner,
+    )
+    .fetch_optional(&pool)
+    .await
+    {
+        Ok(Some(record)) => record,
+        Ok(None) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Project does not exist".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Err(err) => {
+            tracing::error!(?err, "Can't get projects: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    let addon = match sqlx::query!(
+        "SELECT id, name, url FROM addons WHERE project_id = $1 AND kind = 'postgres'",
+        project_record.id
+    )
+    .fetch_optional(&pool)
+    .await
+    {
+        Ok(Some(addon)) => addon,
+        Ok(None) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Project has no postgres addon".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Err(err) => {
+            tracing::error!(?err, "Can't get addons: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    let database = match database_name(&addon.url) {
+        Ok(database) => database,
+        Err(err) => {
+            tracing::error!(?err, "Can't create backup: Invalid database url");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Invalid database url: {err}")
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    match sqlx::query!(
+        "SELECT id FROM backups WHERE addon_id = $1 AND status = 'running'",
+        addon.id
+    )
+    .fetch_optional(&pool)
+    .await
+    {
+        Ok(None) => {}
+        Ok(Some(_)) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "A backup is already running".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Err(err) => {
+            tracing::error!(?err, "Can't get backups: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    }
+
+    let backup_id = match start_backup(addon.id, true, &pool).await {
+        Ok(backup_id) => backup_id,
+        Err(err) => {
+            tracing::error!(?err, "Can't create backup: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    tokio::spawn(async move {
+        run_backup(backup_id, addon.id, &addon.name, &database, &pool, &backups).await;
+    });
+
+    let json = serde_json::to_string(&CreateBackupResponse { id: backup_id }).unwrap();
+
+    Response::builder()
+        .status(StatusCode::ACCEPTED)
+        .body(Body::from(json))
+        .unwrap()
+}
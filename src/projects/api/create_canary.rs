@@ -0,0 +1,173 @@
+use axum::extract::{State, Path};
+use axum::response::Response;
+use axum::Json;
+use garde::{Unvalidated, Validate};
+use hyper::{Body, StatusCode};
+use serde::{Deserialize, Serialize};
+
+use crate::telemetry::current_context;
+use crate::{auth::Auth, queue::{BuildKind, BuildQueueItem}, startup::AppState};
+
+#[derive(Deserialize, Validate, Debug)]
+pub struct CreateCanaryRequest {
+    /// percent of requests sent to the canary
+    #[garde(range(min=1, max=99))]
+    pub weight: i32,
+}
+
+#[derive(Serialize, Debug)]
+struct CreateCanaryResponse {
+    message: String,
+}
+
+#[derive(Serialize, Debug)]
+struct ErrorResponse {
+    message: String,
+}
+
+/// Builds the checkout like a deploy, but keeps it next to the live release on a share of
+/// the requests instead of switching to it. An earlier canary is replaced
+#[tracing::instrument(skip(auth, pool, build_channel))]
+pub async fn post(
+    auth: Auth,
+    State(AppState { pool, base, build_channel, .. }): State<AppState>,
+    Path((owner, project)): Path<(String, String)>,
+    Json(req): Json<Unvalidated<CreateCanaryRequest>>
+) -> Response<Body> {
+    let _user = auth.current_user.unwrap();
+
+    let CreateCanaryRequest { weight } = match req.validate(&()) {
+        Ok(valid) => valid.into_inner(),
+        Err(err) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: err.to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    // check if project exist
+    let project_record = match sqlx::query!(
+        r#"SELECT projects.id AS id
+           FROM projects
+           JOIN project_owners ON projects.owner_id = project_owners.id
+           JOIN users_owners ON project_owners.id = users_owners.owner_id
+           AND projects.name = $1
+           AND project_owners.name = $2
+        "#,
+        project,
+        owner,
+    )
+    .fetch_optional(&pool)
+    .await
+    {
+        Ok(Some(record)) => record,
+        Ok(None) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Project does not exist".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Err(err) => {
+            tracing::error!(?err, "Can't get projects: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    // the rest of the requests need a live release to go to
+    match sqlx::query!(
+        r#"SELECT id FROM domains WHERE project_id = $1"#,
+        project_record.id,
+    )
+    .fetch_optional(&pool)
+    .await
+    {
+        Ok(Some(_)) => {}
+        Ok(None) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Deploy the project before starting a canary".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Err(err) => {
+            tracing::error!(?err, "Can't get domain: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    let repo = project.trim_end_matches(".git");
+    let container_src = format!("{base}/{owner}/{repo}.git/master");
+    let container_name = format!("{owner}-{repo}").replace('.', "-");
+
+    // the checkout only exists after the first push
+    if !std::path::Path::new(&container_src).exists() {
+        let json = serde_json::to_string(&ErrorResponse {
+            message: "Push to the repository before starting a canary".to_string()
+        }).unwrap();
+
+        return Response::builder()
+            .status(StatusCode::BAD_REQUEST)
+            .body(Body::from(json))
+            .unwrap();
+    }
+
+    if let Err(err) = build_channel
+        .send(BuildQueueItem {
+            container_name,
+            container_src,
+            owner,
+            repo: repo.to_string(),
+            kind: BuildKind::Canary(weight),
+            trace: current_context(),
+        })
+        .await
+    {
+        tracing::error!(?err, "Can't start canary: Failed to send to build queue");
+
+        let json = serde_json::to_string(&ErrorResponse {
+            message: "Failed to queue canary build".to_string()
+        }).unwrap();
+
+        return Response::builder()
+            .status(StatusCode::INTERNAL_SERVER_ERROR)
+            .body(Body::from(json))
+            .unwrap();
+    }
+
+    let json = serde_json::to_string(&CreateCanaryResponse {
+        message: "Canary build queued".to_string(),
+    }).unwrap();
+
+    Response::builder()
+        .status(StatusCode::ACCEPTED)
+        .body(Body::from(json))
+        .unwrap()
+}
@@ -0,0 +1,141 @@
+use axum::extract::{State, Path};
+use axum::response::Response;
+use axum::Json;
+use chrono::{DateTime, Utc};
+use garde::{Unvalidated, Validate};
+use hyper::{Body, StatusCode};
+use serde::{Deserialize, Serialize};
+use ulid::Ulid;
+use uuid::Uuid;
+
+use crate::cron::next_run;
+use crate::{auth::Auth, startup::AppState};
+
+#[derive(Deserialize, Validate, Debug)]
+pub struct CreateCronJobRequest {
+    /// five field cron expression, evaluated in UTC
+    #[garde(custom(schedule_check))]
+    pub schedule: String,
+    /// run without a shell, like the cmd of a Dockerfile
+    #[garde(length(min=1))]
+    pub command: Vec<String>,
+}
+
+#[derive(Serialize, Debug)]
+struct CreateCronJobResponse {
+    id: Uuid,
+    next_run_at: DateTime<Utc>,
+}
+
+#[derive(Serialize, Debug)]
+struct ErrorResponse {
+    message: String
+}
+
+fn schedule_check(value: &str, _ctx: &()) -> garde::Result {
+    next_run(value, &Utc::now())
+        .map(|_| ())
+        .map_err(|err| garde::Error::new(err.to_string()))
+}
+
+#[tracing::instrument(skip(auth, pool))]
+pub async fn post(
+    auth: Auth,
+    State(AppState { pool, .. }): State<AppState>,
+    Path((owner, project)): Path<(String, String)>,
+    Json(req): Json<Unvalidated<CreateCronJobRequest>>
+) -> Response<Body> {
+    let _user = auth.current_user.unwrap();
+
+    let CreateCronJobRequest { schedule, command } = match req.validate(&()) {
+        Ok(valid) => valid.into_inner(),
+        Err(err) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: err.to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    // check if project exist
+    let project_record = match sqlx::query!(
+        r#"SELECT projects.id AS id
+           FROM projects
+           JOIN project_owners ON projects.owner_id = project_owners.id
+           JOIN users_owners ON project_owners.id = users_owners.owner_id
+           AND projects.name = $1
+           AND project_owners.name = $2
+        "#,
+        project,
+        owner,
+    )
+    .fetch_optional(&pool)
+    .await
+    {
+        Ok(Some(record)) => record,
+        Ok(None) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Project does not exist".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Err(err) => {
+            tracing::error!(?err, "Can't get projects: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    let id = Uuid::from(Ulid::new());
+    let next_run_at = next_run(&schedule, &Utc::now()).unwrap();
+
+    if let Err(err) = sqlx::query!(
+        r#"INSERT INTO cron_jobs (id, project_id, schedule, command, next_run_at)
+           VALUES ($1, $2, $3, $4, $5)
+        "#,
+        id,
+        project_record.id,
+        schedule,
+        &command,
+        next_run_at
+    )
+    .execute(&pool)
+    .await
+    {
+        tracing::error!(?err, "Can't create cron job: Failed to insert into database");
+
+        let json = serde_json::to_string(&ErrorResponse {
+            message: "Failed to insert into database".to_string()
+        }).unwrap();
+
+        return Response::builder()
+            .status(StatusCode::INTERNAL_SERVER_ERROR)
+            .body(Body::from(json))
+            .unwrap();
+    }
+
+    let json = serde_json::to_string(&CreateCronJobResponse {
+        id,
+        next_run_at,
+    }).unwrap();
+
+    Response::builder()
+        .status(StatusCode::CREATED)
+        .body(Body::from(json))
+        .unwrap()
+}
@@ -0,0 +1,213 @@
+use axum::extract::{State, Path};
+use axum::response::Response;
+use axum::Json;
+use chrono::{DateTime, Utc};
+use garde::{Unvalidated, Validate};
+use hyper::{Body, StatusCode};
+use serde::{Deserialize, Serialize};
+use ulid::Ulid;
+use uuid::Uuid;
+
+use crate::audit::{with_change, AuditChange};
+use crate::deploy_keys::{generate_key, ssh_remote};
+use crate::{auth::Auth, startup::AppState};
+
+/// keeps a project from piling up keys nobody remembers
+const MAX_DEPLOY_KEYS: i64 = 10;
+
+#[derive(Deserialize, Validate, Debug)]
+pub struct CreateDeployKeyRequest {
+    /// what the key is for, like the name of the ci pipeline
+    #[garde(length(min = 1, max = 64), pattern("^[A-Za-z0-9._-]+$"))]
+    pub name: String,
+    /// a key that may push deploys, keys only fetch when false
+    #[serde(default)]
+    #[garde(skip)]
+    pub write: bool,
+}
+
+#[derive(Serialize, Debug)]
+pub struct NewDeployKeyResponse {
+    pub id: Uuid,
+    pub name: String,
+    pub public_key: String,
+    pub fingerprint: String,
+    pub read_only: bool,
+    /// openssh format, it can't be shown again
+    pub private_key: String,
+    pub remote: Option<String>,
+    pub created_at: DateTime<Utc>,
+}
+
+#[derive(Serialize, Debug)]
+struct ErrorResponse {
+    message: String,
+}
+
+/// Makes a deploy key for the project. Only the public half is kept, the private one is in
+/// the response and nowhere else
+#[tracing::instrument(skip(auth, pool, git_settings, req))]
+pub async fn post(
+    auth: Auth,
+    State(AppState { pool, domain, git_settings, .. }): State<AppState>,
+    Path((owner, project)): Path<(String, String)>,
+    Json(req): Json<Unvalidated<CreateDeployKeyRequest>>
+) -> Response<Body> {
+    let _user = auth.current_user.unwrap();
+
+    let CreateDeployKeyRequest { name, write } = match req.validate(&()) {
+        Ok(valid) => valid.into_inner(),
+        Err(err) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: err.to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    // check if project exist
+    let project_record = match sqlx::query!(
+        r#"SELECT projects.id AS id
+           FROM projects
+           JOIN project_owners ON projects.owner_id = project_owners.id
+           JOIN users_owners ON project_owners.id = users_owners.owner_id
+           AND projects.name = $1
+           AND project_owners.name = $2
+        "#,
+        project,
+        owner,
+    )
+    .fetch_optional(&pool)
+    .await
+    {
+        Ok(Some(record)) => record,
+        Ok(None) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Project does not exist".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Err(err) => {
+            tracing::error!(?err, "Can't get projects: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    match sqlx::query!(
+        r#"SELECT COUNT(*) AS "count!" FROM deploy_keys WHERE project_id = $1"#,
+        project_record.id
+    )
+    .fetch_one(&pool)
+    .await
+    {
+        Ok(record) if record.count >= MAX_DEPLOY_KEYS => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("A project can have at most {MAX_DEPLOY_KEYS} deploy keys")
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Ok(_) => {}
+        Err(err) => {
+            tracing::error!(?err, "Can't get deploy keys: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    }
+
+    let key = generate_key(&format!("{owner}/{project}:{name}"));
+    let read_only = !write;
+    let id = Uuid::from(Ulid::new());
+    let created_at = match sqlx::query!(
+        r#"INSERT INTO deploy_keys (id, project_id, name, public_key, fingerprint, read_only)
+           VALUES ($1, $2, $3, $4, $5, $6)
+           ON CONFLICT (project_id, name) DO NOTHING
+           RETURNING created_at
+        "#,
+        id,
+        project_record.id,
+        name,
+        key.public_key,
+        key.fingerprint,
+        read_only
+    )
+    .fetch_optional(&pool)
+    .await
+    {
+        Ok(Some(record)) => record.created_at,
+        Ok(None) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Deploy key {name} already exists")
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Err(err) => {
+            tracing::error!(?err, "Can't create deploy key: Failed to insert into database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Failed to insert into database".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    // the private key stays out of the log
+    let change = AuditChange::new(None, Some(serde_json::json!({
+        "name": name,
+        "fingerprint": key.fingerprint,
+        "read_only": read_only,
+    })));
+
+    let json = serde_json::to_string(&NewDeployKeyResponse {
+        id,
+        name,
+        public_key: key.public_key,
+        fingerprint: key.fingerprint,
+        read_only,
+        private_key: key.private_key,
+        remote: (git_settings.sshport != 0).then(|| ssh_remote(&domain, git_settings.sshport, &owner, &project)),
+        created_at,
+    }).unwrap();
+
+    with_change(
+        Response::builder()
+            .status(StatusCode::CREATED)
+            .body(Body::from(json))
+            .unwrap(),
+        change,
+    )
+}
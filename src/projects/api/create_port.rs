@@ -0,0 +1,238 @@
+use axum::extract::{State, Path};
+use axum::response::Response;
+use axum::Json;
+use garde::{Unvalidated, Validate};
+use hyper::{Body, StatusCode};
+use serde::{Deserialize, Serialize};
+use ulid::Ulid;
+use uuid::Uuid;
+
+use super::view_ports::Port;
+use crate::ports::{self, PROTOCOLS};
+use crate::quotas::check_port;
+use crate::{auth::Auth, startup::AppState};
+
+#[derive(Deserialize, Validate, Debug)]
+pub struct CreatePortRequest {
+    #[garde(custom(protocol_check))]
+    pub protocol: String,
+    /// the port of the container connections are forwarded to
+    #[garde(range(min = 1, max = 65535))]
+    pub target_port: i32,
+    /// a port of the platform range, the lowest free one when none
+    #[garde(range(min = 1, max = 65535))]
+    pub port: Option<i32>,
+    /// seconds a connection may be quiet, never longer than the platform allows
+    #[garde(range(min = 1, max = 86400))]
+    pub idle_timeout: Option<i32>,
+}
+
+#[derive(Serialize, Debug)]
+struct ErrorResponse {
+    message: String,
+}
+
+fn protocol_check(value: &str, _ctx: &()) -> garde::Result {
+    match PROTOCOLS.contains(&value) {
+        true => Ok(()),
+        false => Err(garde::Error::new("Protocol must be tcp or udp")),
+    }
+}
+
+#[tracing::instrument(skip(auth, pool, quota_settings))]
+pub async fn post(
+    auth: Auth,
+    State(AppState { pool, quota_settings, .. }): State<AppState>,
+    Path((owner, project)): Path<(String, String)>,
+    Json(req): Json<Unvalidated<CreatePortRequest>>,
+) -> Response<Body> {
+    let _user = auth.current_user.unwrap();
+
+    let CreatePortRequest { protocol, target_port, port, idle_timeout } = match req.validate(&()) {
+        Ok(valid) => valid.into_inner(),
+        Err(err) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: err.to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    if !ports::enabled() {
+        let json = serde_json::to_string(&ErrorResponse {
+            message: "Ports are not set up on this platform".to_string()
+        }).unwrap();
+
+        return Response::builder()
+            .status(StatusCode::BAD_REQUEST)
+            .body(Body::from(json))
+            .unwrap();
+    }
+
+    if let Some(port) = port.filter(|port| !ports::leasable(*port)) {
+        let json = serde_json::to_string(&ErrorResponse {
+            message: format!("Port {port} is not one apps may lease on this platform")
+        }).unwrap();
+
+        return Response::builder()
+            .status(StatusCode::BAD_REQUEST)
+            .body(Body::from(json))
+            .unwrap();
+    }
+
+    // check if project exist
+    let project_record = match sqlx::query!(
+        r#"SELECT projects.id AS id
+           FROM projects
+           JOIN project_owners ON projects.owner_id = project_owners.id
+           JOIN users_owners ON project_owners.id = users_owners.owner_id
+           AND projects.name = $1
+           AND project_owners.name = $2
+        "#,
+        project,
+        owner,
+    )
+    .fetch_optional(&pool)
+    .await
+    {
+        Ok(Some(record)) => record,
+        Ok(None) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Project does not exist".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Err(err) => {
+            tracing::error!(?err, "Can't get projects: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    match check_port(project_record.id, &quota_settings, &pool).await {
+        Ok(None) => {}
+        Ok(Some(message)) => {
+            let json = serde_json::to_string(&ErrorResponse { message }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::FORBIDDEN)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Err(err) => {
+            tracing::error!(?err, "Can't check port quota: Failed to query usage");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to check port quota: {err}")
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    }
+
+    let port = match port {
+        Some(port) => port,
+        None => match ports::free_port(&protocol, &pool).await {
+            Ok(Some(port)) => port,
+            Ok(None) => {
+                let json = serde_json::to_string(&ErrorResponse {
+                    message: format!("Every {protocol} port of this platform is leased")
+                }).unwrap();
+
+                return Response::builder()
+                    .status(StatusCode::CONFLICT)
+                    .body(Body::from(json))
+                    .unwrap();
+            }
+            Err(err) => {
+                tracing::error!(?err, "Can't get ports: Failed to query database");
+
+                let json = serde_json::to_string(&ErrorResponse {
+                    message: format!("Failed to query database: {err}")
+                }).unwrap();
+
+                return Response::builder()
+                    .status(StatusCode::INTERNAL_SERVER_ERROR)
+                    .body(Body::from(json))
+                    .unwrap();
+            }
+        },
+    };
+
+    let id = Uuid::from(Ulid::new());
+    // two leases racing for the same port, the later one gets the conflict
+    let lease = match sqlx::query!(
+        r#"INSERT INTO port_leases (id, project_id, protocol, port, target_port, idle_timeout)
+           VALUES ($1, $2, $3, $4, $5, $6)
+           ON CONFLICT (protocol, port) DO NOTHING
+           RETURNING created_at
+        "#,
+        id,
+        project_record.id,
+        protocol,
+        port,
+        target_port,
+        idle_timeout
+    )
+    .fetch_optional(&pool)
+    .await
+    {
+        Ok(Some(lease)) => lease,
+        Ok(None) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Port {port}/{protocol} is already leased")
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::CONFLICT)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Err(err) => {
+            tracing::error!(?err, "Can't lease port: Failed to insert into database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Failed to insert into database".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+    ports::changed();
+
+    let json = serde_json::to_string(&Port {
+        id,
+        protocol,
+        port,
+        target_port,
+        idle_timeout: ports::idle_timeout(idle_timeout) as i32,
+        host: ports::host(),
+        created_at: lease.created_at,
+    }).unwrap();
+
+    Response::builder()
+        .status(StatusCode::CREATED)
+        .body(Body::from(json))
+        .unwrap()
+}
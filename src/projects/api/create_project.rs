@@ -17,6 +17,8 @@ use rand::{Rng, SeedableRng};
 
 use crate::{
     auth::Auth,
+    owner::{member_role, Role},
+    quotas::check_new_app,
     startup::AppState,
 };
 
@@ -24,6 +26,21 @@ use crate::{
 const CHARSET: &[u8] = b"ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789-_";
 const TOKEN_LENGTH: usize = 32;
 
+/// The password git pushes to a new app with and its argon2 hash, only the hash is kept
+pub fn git_token() -> Result<(String, String), argon2::password_hash::Error> {
+    let mut rng = rand::rngs::StdRng::from_entropy();
+    let token = (0..TOKEN_LENGTH)
+        .map(|_| {
+            let idx = rng.gen_range(0..CHARSET.len());
+            CHARSET[idx] as char
+        })
+        .collect::<String>();
+
+    let salt = SaltString::generate(&mut OsRng);
+    let hash = Argon2::default().hash_password(token.as_bytes(), &salt)?;
+    Ok((token, hash.to_string()))
+}
+
 #[derive(Deserialize, Validate, Debug)]
 pub struct CreateProjectRequest {
     #[garde(length(min = 1))]
@@ -47,11 +64,11 @@ struct CreateProjectResponse {
     git_password: String,
 }
 
-#[tracing::instrument(skip(pool, base, domain))]
+#[tracing::instrument(skip(pool, base, domain, quota_settings))]
 pub async fn post(
     auth: Auth,
     State(AppState {
-        pool, base, domain, secure, ..
+        pool, base, domain, secure, quota_settings, ..
     }): State<AppState>,
     Json(req): Json<Unvalidated<CreateProjectRequest>>,
 ) -> Response<Body> {    
@@ -69,6 +86,43 @@ pub async fn post(
         }
     };
 
+    // viewers only look at the apps of the owner
+    match member_role(auth.id, &owner, &pool).await {
+        Ok(Some(role)) if role >= Role::Maintainer => {}
+        Ok(Some(role)) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Only a maintainer of {owner} can create apps, you are a {role}")
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::FORBIDDEN)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Ok(None) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Owner does not exist".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Err(err) => {
+            tracing::error!(?err, "Can't get users_owners: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    }
+
     let path = match project.ends_with(".git") {
         true => format!("{base}/{owner}/{project}"),
         false => format!("{base}/{owner}/{project}.git"),
@@ -107,6 +161,30 @@ pub async fn post(
         }
     };
 
+    match check_new_app(owner_id, &quota_settings, &pool).await {
+        Ok(None) => {}
+        Ok(Some(message)) => {
+            let json = serde_json::to_string(&ErrorResponse { message }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::FORBIDDEN)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Err(err) => {
+            tracing::error!(?err, "Can't check app quota: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    }
+
     // check if project already exist
     match sqlx::query!(
         r#"SELECT id FROM projects WHERE name = $1 AND owner_id = $2"#,
@@ -204,19 +282,8 @@ pub async fn post(
             .unwrap();
     }
 
-    // generate token
-    let mut rng = rand::rngs::StdRng::from_entropy();
-    let token = (0..TOKEN_LENGTH)
-        .map(|_| {
-            let idx = rng.gen_range(0..CHARSET.len());
-            CHARSET[idx] as char
-        })
-        .collect::<String>();
-
-    let salt = SaltString::generate(&mut OsRng);
-    let hasher = Argon2::default();
-    let hash = match hasher.hash_password(token.as_bytes(), &salt) {
-        Ok(hash) => hash,
+    let (token, hash) = match git_token() {
+        Ok(token) => token,
         Err(err) => {
             tracing::error!(?err, "Can't create project: Failed to hash token");
 
@@ -235,7 +302,7 @@ pub async fn post(
         "INSERT INTO api_token (id, project_id, token) VALUES ($1, $2, $3)",
         Uuid::from(Ulid::new()),
         project_id,
-        hash.to_string(),
+        hash,
     )
     .execute(&mut *tx)
     .await
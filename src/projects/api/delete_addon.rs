@@ -0,0 +1,191 @@
+use axum::extract::{State, Path};
+use axum::response::Response;
+use axum::Json;
+use hyper::{Body, StatusCode};
+use serde::{Deserialize, Serialize};
+
+use super::view_addons::AddonKind;
+use crate::docker::remove_postgres;
+use crate::redis::remove_redis;
+use crate::storage::remove_bucket;
+use crate::telemetry::current_context;
+use crate::{auth::Auth, queue::{BuildKind, BuildQueueItem}, startup::AppState};
+
+#[derive(Deserialize, Debug)]
+pub struct DeleteAddonRequest {
+    pub kind: AddonKind,
+}
+
+#[derive(Serialize, Debug)]
+struct ErrorResponse {
+    message: String,
+}
+
+#[tracing::instrument(skip(auth, pool, build_channel, backups))]
+pub async fn post(
+    auth: Auth,
+    State(AppState { pool, base, build_channel, backups, .. }): State<AppState>,
+    Path((owner, project)): Path<(String, String)>,
+    Json(DeleteAddonRequest { kind }): Json<DeleteAddonRequest>,
+) -> Response<Body> {
+    let _user = auth.current_user.unwrap();
+
+    // check if project exist
+    let project_record = match sqlx::query!(
+        r#"SELECT projects.id AS id
+           FROM projects
+           JOIN project_owners ON projects.owner_id = project_owners.id
+           JOIN users_owners ON project_owners.id = users_owners.owner_id
+           AND projects.name = $1
+           AND project_owners.name = $2
+        "#,
+        project,
+        owner,
+    )
+    .fetch_optional(&pool)
+    .await
+    {
+        Ok(Some(record)) => record,
+        Ok(None) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Project does not exist".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Err(err) => {
+            tracing::error!(?err, "Can't get projects: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    let addon = match sqlx::query!(
+        "SELECT id, url FROM addons WHERE project_id = $1 AND kind = $2",
+        project_record.id,
+        kind as AddonKind
+    )
+    .fetch_optional(&pool)
+    .await
+    {
+        Ok(Some(addon)) => addon,
+        Ok(None) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Project has no {kind} addon")
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Err(err) => {
+            tracing::error!(?err, "Can't get addons: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    let container_name = format!("{owner}-{}", project.trim_end_matches(".git")).replace('.', "-");
+
+    // the data goes first, a row without its container would only be injected again
+    let removed = match kind {
+        AddonKind::Postgres => remove_postgres(&container_name).await,
+        AddonKind::S3 => remove_bucket(&container_name, &addon.url).await,
+        AddonKind::Redis => remove_redis(&container_name).await,
+        // the credentials stop working with the row, the log and suppression list stay so a
+        // new address doesn't get a fresh quota
+        AddonKind::Mail => Ok(()),
+    };
+
+    if let Err(err) = removed {
+        tracing::error!(?err, "Can't delete addon: Failed to remove {kind}");
+
+        let json = serde_json::to_string(&ErrorResponse {
+            message: format!("Failed to remove {kind}: {err}")
+        }).unwrap();
+
+        return Response::builder()
+            .status(StatusCode::INTERNAL_SERVER_ERROR)
+            .body(Body::from(json))
+            .unwrap();
+    }
+
+    // backups can only be restored into the addon they were taken from
+    if kind == AddonKind::Postgres && backups.enabled() {
+        if let Err(err) = backups.delete_all(&format!("{container_name}-db")).await {
+            tracing::error!(?err, "Can't delete addon: Failed to delete backups");
+        }
+    }
+
+    if let Err(err) = sqlx::query!(
+        "DELETE FROM addons WHERE project_id = $1 AND kind = $2",
+        project_record.id,
+        kind as AddonKind
+    )
+    .execute(&pool)
+    .await
+    {
+        tracing::error!(?err, "Can't delete addon: Failed to query database");
+
+        let json = serde_json::to_string(&ErrorResponse {
+            message: format!("Failed to query database: {}", err.to_string())
+        }).unwrap();
+
+        return Response::builder()
+            .status(StatusCode::INTERNAL_SERVER_ERROR)
+            .body(Body::from(json))
+            .unwrap();
+    }
+
+    // running apps only see the change through a new release of the live image, projects
+    // that were never deployed pick it up on their first build
+    match sqlx::query!("SELECT id FROM releases WHERE project_id = $1 LIMIT 1", project_record.id)
+        .fetch_optional(&pool)
+        .await
+    {
+        Ok(Some(_)) => {
+            let repo = project.trim_end_matches(".git");
+
+            if let Err(err) = build_channel
+                .send(BuildQueueItem {
+                    container_name: container_name.clone(),
+                    container_src: format!("{base}/{owner}/{repo}.git/master"),
+                    owner,
+                    repo: repo.to_string(),
+                    kind: BuildKind::Reconfigure(format!("Remove {kind}")),
+                    trace: current_context(),
+                })
+                .await
+            {
+                tracing::error!(?err, "Can't release project addons: Failed to send to build queue");
+            }
+        }
+        Ok(None) => {}
+        Err(err) => {
+            tracing::error!(?err, "Can't release project addons: Failed to query database");
+        }
+    }
+
+    Response::builder()
+        .status(StatusCode::NO_CONTENT)
+        .body(Body::empty())
+        .unwrap()
+}
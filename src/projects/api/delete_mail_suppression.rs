@@ -0,0 +1,81 @@
+use axum::extract::{State, Path};
+use axum::response::Response;
+use axum::Json;
+use hyper::{Body, StatusCode};
+use serde::Serialize;
+
+use super::add_mail_suppression::MailSuppressionRequest;
+use crate::audit::{with_change, AuditChange};
+use crate::{auth::Auth, startup::AppState};
+
+#[derive(Serialize, Debug)]
+struct ErrorResponse {
+    message: String,
+}
+
+/// Lets the app send to an address again, like one that was only full for a while
+#[tracing::instrument(skip(auth, pool))]
+pub async fn post(
+    auth: Auth,
+    State(AppState { pool, .. }): State<AppState>,
+    Path((owner, project)): Path<(String, String)>,
+    Json(MailSuppressionRequest { address }): Json<MailSuppressionRequest>,
+) -> Response<Body> {
+    let _user = auth.current_user.unwrap();
+
+    let address = address.trim().to_lowercase();
+    let suppression = match sqlx::query!(
+        r#"DELETE FROM mail_suppressions
+           USING projects, project_owners
+           WHERE mail_suppressions.project_id = projects.id
+           AND projects.owner_id = project_owners.id
+           AND projects.name = $1
+           AND project_owners.name = $2
+           AND mail_suppressions.address = $3
+           RETURNING mail_suppressions.reason
+        "#,
+        project,
+        owner,
+        address
+    )
+    .fetch_optional(&pool)
+    .await
+    {
+        Ok(Some(suppression)) => suppression,
+        Ok(None) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("{address} is not suppressed")
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Err(err) => {
+            tracing::error!(?err, "Can't unsuppress address: Failed to delete from database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Failed to delete from database".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    let before = serde_json::json!({
+        "address": address,
+        "reason": suppression.reason,
+    });
+
+    with_change(
+        Response::builder()
+            .status(StatusCode::NO_CONTENT)
+            .body(Body::empty())
+            .unwrap(),
+        AuditChange::new(Some(before), None),
+    )
+}
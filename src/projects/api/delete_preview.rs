@@ -0,0 +1,92 @@
+use axum::extract::{State, Path};
+use axum::response::Response;
+use hyper::{Body, StatusCode};
+use serde::Serialize;
+
+use crate::audit::{with_change, AuditChange};
+use crate::previews::remove_preview;
+use crate::{auth::Auth, startup::AppState};
+
+#[derive(Serialize, Debug)]
+struct ErrorResponse {
+    message: String,
+}
+
+/// Tears down a preview before it expires. Pushing its branch again deploys it again
+#[tracing::instrument(skip(auth, pool))]
+pub async fn post(
+    auth: Auth,
+    State(AppState { pool, .. }): State<AppState>,
+    Path((owner, project, name)): Path<(String, String, String)>,
+) -> Response<Body> {
+    let _user = auth.current_user.unwrap();
+
+    // check if preview exist
+    let preview = match sqlx::query!(
+        r#"SELECT projects.id AS id, previews.branch
+           FROM projects
+           JOIN project_owners ON projects.owner_id = project_owners.id
+           JOIN users_owners ON project_owners.id = users_owners.owner_id
+           JOIN previews ON previews.project_id = projects.id
+           AND projects.name = $1
+           AND project_owners.name = $2
+           AND previews.name = $3
+        "#,
+        project,
+        owner,
+        name,
+    )
+    .fetch_optional(&pool)
+    .await
+    {
+        Ok(Some(record)) => record,
+        Ok(None) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Preview does not exist".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Err(err) => {
+            tracing::error!(?err, "Can't get previews: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    if let Err(err) = remove_preview(preview.id, &name, "removed by its owners", &pool).await {
+        tracing::error!(?err, preview = name, "Can't delete preview");
+
+        let json = serde_json::to_string(&ErrorResponse {
+            message: "Failed to remove the preview".to_string()
+        }).unwrap();
+
+        return Response::builder()
+            .status(StatusCode::INTERNAL_SERVER_ERROR)
+            .body(Body::from(json))
+            .unwrap();
+    }
+
+    let before = serde_json::json!({
+        "name": name,
+        "branch": preview.branch,
+    });
+
+    with_change(
+        Response::builder()
+            .status(StatusCode::NO_CONTENT)
+            .body(Body::empty())
+            .unwrap(),
+        AuditChange::new(Some(before), None),
+    )
+}
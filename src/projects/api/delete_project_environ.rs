@@ -5,11 +5,13 @@ use garde::{Unvalidated, Validate};
 use hyper::{Body, StatusCode};
 use serde::{Deserialize, Serialize};
 
-use crate::{auth::Auth, startup::AppState};
+use crate::audit::{environ_change, with_change, AuditChange};
+use crate::telemetry::current_context;
+use crate::{auth::Auth, queue::{BuildKind, BuildQueueItem}, startup::AppState};
 
 #[derive(Deserialize, Validate, Debug)]
 pub struct DeleteProjectEnvironRequest {
-    #[garde(length(min=1), alphanumeric)]
+    #[garde(length(min=1))]
     pub key: String
 }
 
@@ -18,10 +20,10 @@ struct ErrorResponse {
     message: String
 }
 
-#[tracing::instrument(skip(auth, pool))]
+#[tracing::instrument(skip(auth, pool, build_channel))]
 pub async fn post(
     auth: Auth,
-    State(AppState { pool, domain, secure, .. }): State<AppState>,
+    State(AppState { pool, base, build_channel, .. }): State<AppState>,
     Path((owner, project)): Path<(String, String)>,
     Json(req): Json<Unvalidated<DeleteProjectEnvironRequest>>
 ) -> Response<Body> {
@@ -43,7 +45,8 @@ pub async fn post(
 
     // check if project exist
     let project = match sqlx::query!(
-        r#"SELECT projects.id AS id, projects.name AS project, projects.environs AS env
+        r#"SELECT projects.id AS id, projects.name AS project, projects.environs AS env,
+           projects.secrets AS secrets
            FROM projects
            JOIN project_owners ON projects.owner_id = project_owners.id
            JOIN users_owners ON project_owners.id = users_owners.owner_id
@@ -82,9 +85,13 @@ pub async fn post(
     };
 
 
+    let before = environ_change(&project.env, &project.secrets, &key);
+
     match sqlx::query!(
         r#"UPDATE projects
-            SET environs = environs - $1
+            SET environs = environs - $1,
+                secrets = secrets - $1,
+                uncopied_secrets = array_remove(uncopied_secrets, $1)
             WHERE id = $2
         "#,
         key,
@@ -110,8 +117,40 @@ pub async fn post(
         }    
     };
 
-    Response::builder()
-        .status(StatusCode::NO_CONTENT)
-        .body(Body::empty())
-        .unwrap()
+    // running apps only see the change through a new release of the live image, projects
+    // that were never deployed pick it up on their first build
+    match sqlx::query!("SELECT id FROM releases WHERE project_id = $1 LIMIT 1", project.id)
+        .fetch_optional(&pool)
+        .await
+    {
+        Ok(Some(_)) => {
+            let repo = project.project.trim_end_matches(".git");
+
+            if let Err(err) = build_channel
+                .send(BuildQueueItem {
+                    container_name: format!("{owner}-{repo}").replace('.', "-"),
+                    container_src: format!("{base}/{owner}/{repo}.git/master"),
+                    owner,
+                    repo: repo.to_string(),
+                    kind: BuildKind::Reconfigure(format!("Remove {key}")),
+                    trace: current_context(),
+                })
+                .await
+            {
+                tracing::error!(?err, "Can't release project environs: Failed to send to build queue");
+            }
+        }
+        Ok(None) => {}
+        Err(err) => {
+            tracing::error!(?err, "Can't release project environs: Failed to query database");
+        }
+    }
+
+    with_change(
+        Response::builder()
+            .status(StatusCode::NO_CONTENT)
+            .body(Body::empty())
+            .unwrap(),
+        AuditChange::new(before, None),
+    )
 }
@@ -1,10 +1,10 @@
 use axum::extract::Path;
 use axum::response::Response;
-use bollard::Docker;
 use bollard::container::{StopContainerOptions, StartContainerOptions};
 use hyper::{Body, StatusCode};
 use serde::Serialize;
 use crate::auth::Auth;
+use crate::nodes;
 
 #[derive(Serialize)]
 struct DeleteVolumeSuccessResponse {
@@ -40,7 +40,7 @@ pub async fn post(auth: Auth, Path((owner, project)): Path<(String, String)>) ->
         None => ()
     }
 
-    let docker = match Docker::connect_with_local_defaults() {
+    let docker = match nodes::docker(&container_name) {
         Ok(docker) => docker,
         Err(err) => {
             tracing::error!(?err, "Can't delete volume: Failed to connect to docker");
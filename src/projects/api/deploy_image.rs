@@ -0,0 +1,127 @@
+use axum::extract::{State, Path};
+use axum::response::Response;
+use axum::Json;
+use garde::{Unvalidated, Validate};
+use hyper::{Body, StatusCode};
+use serde::{Deserialize, Serialize};
+
+use crate::telemetry::current_context;
+use crate::{auth::Auth, queue::{BuildKind, BuildQueueItem}, startup::AppState};
+
+#[derive(Deserialize, Validate, Debug)]
+pub struct DeployImageRequest {
+    /// image reference like ghcr.io/owner/app:tag, docker hub without a host
+    #[garde(length(min = 1, max = 512), pattern("^[A-Za-z0-9][A-Za-z0-9._/:@-]*$"))]
+    pub image: String,
+}
+
+#[derive(Serialize, Debug)]
+struct DeployImageResponse {
+    message: String,
+}
+
+#[derive(Serialize, Debug)]
+struct ErrorResponse {
+    message: String,
+}
+
+/// Queues pulling an image and deploying it like a build of the checkout
+#[tracing::instrument(skip(auth, pool, build_channel))]
+pub async fn post(
+    auth: Auth,
+    State(AppState { pool, base, build_channel, .. }): State<AppState>,
+    Path((owner, project)): Path<(String, String)>,
+    Json(req): Json<Unvalidated<DeployImageRequest>>
+) -> Response<Body> {
+    let _user = auth.current_user.unwrap();
+
+    let DeployImageRequest { image } = match req.validate(&()) {
+        Ok(valid) => valid.into_inner(),
+        Err(err) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: err.to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    // check if project exist
+    let project_record = match sqlx::query!(
+        r#"SELECT projects.id AS id
+           FROM projects
+           JOIN project_owners ON projects.owner_id = project_owners.id
+           JOIN users_owners ON project_owners.id = users_owners.owner_id
+           AND projects.name = $1
+           AND project_owners.name = $2
+        "#,
+        project,
+        owner,
+    )
+    .fetch_optional(&pool)
+    .await
+    {
+        Ok(Some(record)) => record,
+        Ok(None) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Project does not exist".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Err(err) => {
+            tracing::error!(?err, "Can't get projects: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    let repo = project.trim_end_matches(".git");
+    let container_src = format!("{base}/{owner}/{repo}.git/master");
+    let container_name = format!("{owner}-{repo}").replace('.', "-");
+
+    if let Err(err) = build_channel
+        .send(BuildQueueItem {
+            container_name,
+            container_src,
+            owner,
+            repo: repo.to_string(),
+            kind: BuildKind::Image(image),
+            trace: current_context(),
+        })
+        .await
+    {
+        tracing::error!(?err, "Can't deploy image: Failed to send to build queue");
+
+        let json = serde_json::to_string(&ErrorResponse {
+            message: "Failed to queue build".to_string()
+        }).unwrap();
+
+        return Response::builder()
+            .status(StatusCode::INTERNAL_SERVER_ERROR)
+            .body(Body::from(json))
+            .unwrap();
+    }
+
+    let json = serde_json::to_string(&DeployImageResponse {
+        message: "Image deploy queued".to_string(),
+    }).unwrap();
+
+    Response::builder()
+        .status(StatusCode::ACCEPTED)
+        .body(Body::from(json))
+        .unwrap()
+}
@@ -0,0 +1,161 @@
+use axum::extract::{Path, State};
+use axum::response::Response;
+use hyper::{Body, StatusCode};
+use serde::Serialize;
+
+use crate::audit::{with_change, AuditChange};
+use crate::env_groups::find_group;
+use crate::telemetry::current_context;
+use crate::{auth::Auth, queue::{BuildKind, BuildQueueItem}, startup::AppState};
+
+#[derive(Serialize, Debug)]
+struct ErrorResponse {
+    message: String,
+}
+
+/// Detaches an env group from the app and releases it again without the variables of the group
+#[tracing::instrument(skip(auth, pool, build_channel))]
+pub async fn post(
+    auth: Auth,
+    State(AppState { pool, base, build_channel, .. }): State<AppState>,
+    Path((owner, project, group)): Path<(String, String, String)>,
+) -> Response<Body> {
+    let _user = auth.current_user.unwrap();
+
+    // check if project exist
+    let project_record = match sqlx::query!(
+        r#"SELECT projects.id AS id
+           FROM projects
+           JOIN project_owners ON projects.owner_id = project_owners.id
+           WHERE projects.name = $1
+           AND project_owners.name = $2
+        "#,
+        project,
+        owner,
+    )
+    .fetch_optional(&pool)
+    .await
+    {
+        Ok(Some(record)) => record,
+        Ok(None) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Project does not exist".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Err(err) => {
+            tracing::error!(?err, "Can't get projects: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    let group_id = match find_group(&owner, &group, &pool).await {
+        Ok(Some(group_id)) => group_id,
+        Ok(None) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("{owner} has no env group named {group}")
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Err(err) => {
+            tracing::error!(?err, "Can't detach env group: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    match sqlx::query!(
+        "DELETE FROM project_env_groups WHERE project_id = $1 AND group_id = $2",
+        project_record.id,
+        group_id
+    )
+    .execute(&pool)
+    .await
+    {
+        Ok(deleted) if deleted.rows_affected() == 0 => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("{group} is not attached to {project}")
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Ok(_) => {}
+        Err(err) => {
+            tracing::error!(?err, "Can't detach env group: Failed to delete from database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Failed to delete from database".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    }
+
+    let before = serde_json::json!({ "group": &group });
+
+    // running apps only see the change through a new release of the live image, projects
+    // that were never deployed pick it up on their first build
+    match sqlx::query!("SELECT id FROM releases WHERE project_id = $1 LIMIT 1", project_record.id)
+        .fetch_optional(&pool)
+        .await
+    {
+        Ok(Some(_)) => {
+            let repo = project.trim_end_matches(".git");
+
+            if let Err(err) = build_channel
+                .send(BuildQueueItem {
+                    container_name: format!("{owner}-{repo}").replace('.', "-"),
+                    container_src: format!("{base}/{owner}/{repo}.git/master"),
+                    owner,
+                    repo: repo.to_string(),
+                    kind: BuildKind::Reconfigure(format!("Detach env group {group}")),
+                    trace: current_context(),
+                })
+                .await
+            {
+                tracing::error!(?err, "Can't release env groups: Failed to send to build queue");
+            }
+        }
+        Ok(None) => {}
+        Err(err) => {
+            tracing::error!(?err, "Can't release env groups: Failed to query database");
+        }
+    }
+
+    with_change(
+        Response::builder()
+            .status(StatusCode::NO_CONTENT)
+            .body(Body::empty())
+            .unwrap(),
+        AuditChange::new(Some(before), None),
+    )
+}
@@ -0,0 +1,169 @@
+use axum::extract::{State, Path};
+use axum::response::Response;
+use chrono::{DateTime, Utc};
+use hyper::{Body, StatusCode};
+use serde::Serialize;
+use uuid::Uuid;
+
+use crate::docker::ReleaseConfig;
+use crate::releases::{config_digest, diff, EnvChange, FieldChange, SecretChange};
+use crate::{auth::Auth, startup::AppState};
+
+#[derive(Serialize, Debug)]
+struct ReleaseSummary {
+    id: Uuid,
+    build_id: Uuid,
+    image: String,
+    commit_sha: Option<String>,
+    config_digest: String,
+    created_at: DateTime<Utc>,
+}
+
+#[derive(Serialize, Debug)]
+struct ReleaseDiffResponse {
+    from: ReleaseSummary,
+    to: ReleaseSummary,
+    env: Vec<EnvChange>,
+    secrets: Vec<SecretChange>,
+    config: Vec<FieldChange>,
+}
+
+#[derive(Serialize, Debug)]
+struct ErrorResponse {
+    message: String,
+}
+
+/// What changed from one release of the app to another: the image and commit, the
+/// environment, which secrets changed and the rest of the config
+#[tracing::instrument(skip(auth, pool, secrets))]
+pub async fn get(
+    auth: Auth,
+    State(AppState { pool, secrets, .. }): State<AppState>,
+    Path((owner, project, release_id, other_id)): Path<(String, String, Uuid, Uuid)>,
+) -> Response<Body> {
+    let _user = auth.current_user.unwrap();
+
+    // check if project exist
+    let project_record = match sqlx::query!(
+        r#"SELECT projects.id AS id
+           FROM projects
+           JOIN project_owners ON projects.owner_id = project_owners.id
+           JOIN users_owners ON project_owners.id = users_owners.owner_id
+           AND projects.name = $1
+           AND project_owners.name = $2
+        "#,
+        project,
+        owner,
+    )
+    .fetch_optional(&pool)
+    .await
+    {
+        Ok(Some(record)) => record,
+        Ok(None) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Project does not exist".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Err(err) => {
+            tracing::error!(?err, "Can't get projects: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    let release_records = match sqlx::query!(
+        r#"SELECT releases.id, releases.build_id, releases.image, releases.config, releases.created_at,
+            builds.commit_sha
+        FROM releases
+        JOIN builds ON builds.id = releases.build_id
+        WHERE releases.id = ANY($1) AND releases.project_id = $2"#,
+        &[release_id, other_id],
+        project_record.id
+    )
+    .fetch_all(&pool)
+    .await
+    {
+        Ok(records) => records,
+        Err(err) => {
+            tracing::error!(?err, "Can't get releases: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    let mut releases = Vec::with_capacity(2);
+    for id in [release_id, other_id] {
+        let Some(record) = release_records.iter().find(|record| record.id == id) else {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Release {id} does not exist")
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        };
+
+        let config: ReleaseConfig = match serde_json::from_value(record.config.clone()) {
+            Ok(config) => config,
+            Err(err) => {
+                tracing::error!(?err, "Can't diff releases: Failed to read release config");
+
+                let json = serde_json::to_string(&ErrorResponse {
+                    message: format!("Failed to read the config of release {id}")
+                }).unwrap();
+
+                return Response::builder()
+                    .status(StatusCode::INTERNAL_SERVER_ERROR)
+                    .body(Body::from(json))
+                    .unwrap();
+            }
+        };
+
+        let summary = ReleaseSummary {
+            id: record.id,
+            build_id: record.build_id,
+            image: record.image.clone(),
+            commit_sha: record.commit_sha.clone(),
+            config_digest: config_digest(&record.config),
+            created_at: record.created_at,
+        };
+        releases.push((summary, config));
+    }
+
+    let (to, to_config) = releases.pop().unwrap();
+    let (from, from_config) = releases.pop().unwrap();
+    let changes = diff(&from_config, &to_config, &secrets);
+
+    let json = serde_json::to_string(&ReleaseDiffResponse {
+        from,
+        to,
+        env: changes.env,
+        secrets: changes.secrets,
+        config: changes.config,
+    }).unwrap();
+
+    Response::builder()
+        .status(StatusCode::OK)
+        .body(Body::from(json))
+        .unwrap()
+}
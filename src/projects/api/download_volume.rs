@@ -0,0 +1,75 @@
+use axum::extract::{Path, Query, State};
+use axum::response::Response;
+use futures::StreamExt;
+use hyper::{header, Body, StatusCode};
+use serde::Serialize;
+
+use super::browse_volume::{browse_target, BrowseVolumeQuery};
+use crate::volumes::volume_archive;
+use crate::{auth::Auth, startup::AppState};
+
+#[derive(Serialize, Debug)]
+struct ErrorResponse {
+    message: String,
+}
+
+/// Streams a tar archive of a volume or a path inside it
+#[tracing::instrument(skip(auth, pool))]
+pub async fn get(
+    auth: Auth,
+    State(AppState { pool, .. }): State<AppState>,
+    Path((owner, project, name)): Path<(String, String, String)>,
+    Query(BrowseVolumeQuery { path }): Query<BrowseVolumeQuery>,
+) -> Response<Body> {
+    let _user = auth.current_user.unwrap();
+
+    let path = path.unwrap_or_default().trim_matches('/').to_string();
+    let (volume, image) = match browse_target(&pool, &owner, &project, &name, &path).await {
+        Ok(target) => target,
+        Err(response) => return response,
+    };
+
+    let mut archive = match volume_archive(&volume, &image, &path).await {
+        Ok(archive) => archive,
+        Err(err) => {
+            tracing::error!(?err, "Can't download volume: Failed to read volume");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to read volume {name}: {err}")
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    // a missing path only shows in the first chunk, after that the status is sent
+    let first = match archive.next().await {
+        Some(Ok(chunk)) => chunk,
+        Some(Err(err)) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to read /{path} of volume {name}: {err}")
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        None => Default::default(),
+    };
+
+    let filename = match path.rsplit('/').next() {
+        Some(last) if !last.is_empty() => format!("{name}-{last}.tar"),
+        _ => format!("{name}.tar"),
+    };
+
+    Response::builder()
+        .status(StatusCode::OK)
+        .header(header::CONTENT_TYPE, "application/x-tar")
+        .header(header::CONTENT_DISPOSITION, format!("attachment; filename=\"{filename}\""))
+        .body(Body::wrap_stream(futures::stream::iter([Ok(first)]).chain(archive)))
+        .unwrap()
+}
@@ -0,0 +1,147 @@
+use argon2::{
+    password_hash::{rand_core::OsRng, PasswordHasher, SaltString},
+    Argon2,
+};
+use axum::extract::{State, Path};
+use axum::response::Response;
+use axum::Json;
+use garde::{Unvalidated, Validate};
+use hyper::{Body, StatusCode};
+use serde::{Deserialize, Serialize};
+
+use crate::audit::{with_change, AuditChange};
+use crate::{auth::Auth, startup::AppState};
+
+#[derive(Deserialize, Validate)]
+pub struct EnableBasicAuthRequest {
+    /// a colon can't be told apart from the password in the Authorization header
+    #[garde(length(min = 1, max = 64), pattern("^[^:\\s]+$"))]
+    pub username: String,
+    #[garde(length(min = 8, max = 128))]
+    pub password: String,
+}
+
+#[derive(Serialize, Debug)]
+struct ErrorResponse {
+    message: String
+}
+
+/// Makes visitors log in with `username` and `password` before the proxy lets them through
+/// to the app. Setting it again replaces the login
+#[tracing::instrument(skip(auth, pool, req))]
+pub async fn post(
+    auth: Auth,
+    State(AppState { pool, .. }): State<AppState>,
+    Path((owner, project)): Path<(String, String)>,
+    Json(req): Json<Unvalidated<EnableBasicAuthRequest>>
+) -> Response<Body> {
+    let _user = auth.current_user.unwrap();
+
+    let EnableBasicAuthRequest { username, password } = match req.validate(&()) {
+        Ok(valid) => valid.into_inner(),
+        Err(err) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: err.to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    // check if project exist
+    let project = match sqlx::query!(
+        r#"SELECT projects.id AS id, projects.basic_auth_username
+           FROM projects
+           JOIN project_owners ON projects.owner_id = project_owners.id
+           JOIN users_owners ON project_owners.id = users_owners.owner_id
+           AND projects.name = $1
+           AND project_owners.name = $2
+        "#,
+        project,
+        owner,
+    )
+    .fetch_optional(&pool)
+    .await
+    {
+        Ok(Some(record)) => record,
+        Ok(None) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Project does not exist".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Err(err) => {
+            tracing::error!(?err, "Can't get projects: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    let hasher = Argon2::default();
+    let salt = SaltString::generate(&mut OsRng);
+    let password_hash = match hasher.hash_password(password.as_bytes(), &salt) {
+        Ok(hash) => hash.to_string(),
+        Err(err) => {
+            tracing::error!(?err, "Can't set basic auth: Failed to hash password");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Failed to hash password".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    if let Err(err) = sqlx::query!(
+        "UPDATE projects SET basic_auth_username = $1, basic_auth_password = $2, updated_at = now() WHERE id = $3",
+        username,
+        password_hash,
+        project.id
+    )
+    .execute(&pool)
+    .await
+    {
+        tracing::error!(?err, "Can't set basic auth: Failed to update database");
+
+        let json = serde_json::to_string(&ErrorResponse {
+            message: "Failed to update database".to_string()
+        }).unwrap();
+
+        return Response::builder()
+            .status(StatusCode::INTERNAL_SERVER_ERROR)
+            .body(Body::from(json))
+            .unwrap();
+    }
+
+    // the password stays out of the audit log, a new one shows up as a change regardless
+    let before = project.basic_auth_username.map(|username| serde_json::json!({ "username": username }));
+    let after = serde_json::json!({
+        "username": username,
+        "password": "changed",
+    });
+
+    with_change(
+        Response::builder()
+            .status(StatusCode::NO_CONTENT)
+            .body(Body::empty())
+            .unwrap(),
+        AuditChange::new(before, Some(after)),
+    )
+}
@@ -0,0 +1,198 @@
+use std::time::Duration;
+
+use axum::extract::ws::{WebSocket, WebSocketUpgrade};
+use axum::extract::{Path, State};
+use axum::response::{IntoResponse, Response};
+use bollard::container::InspectContainerOptions;
+use bollard::exec::{CreateExecOptions, StartExecResults};
+use bollard::models::ExecInspectResponse;
+use bollard::Docker;
+use hyper::{Body, StatusCode};
+use serde::Serialize;
+
+use super::run_command::{close, exit, read_start, stream, ServerMessage, SessionEnd, Tty};
+use crate::{auth::Auth, nodes, startup::AppState};
+
+const EXIT_POLLS: usize = 20;
+const EXIT_POLL_INTERVAL: Duration = Duration::from_millis(100);
+
+#[derive(Serialize, Debug)]
+struct ErrorResponse {
+    message: String,
+}
+
+/// Runs a command inside the running container of a project, usually a shell, streaming
+/// it over a websocket with the same messages as `run_command`.
+#[tracing::instrument(skip(auth, pool, ws))]
+pub async fn ws(
+    auth: Auth,
+    State(AppState { pool, .. }): State<AppState>,
+    Path((owner, project)): Path<(String, String)>,
+    ws: WebSocketUpgrade,
+) -> Response {
+    let _user = auth.current_user.unwrap();
+
+    // check if project exist
+    let project = match sqlx::query!(
+        r#"SELECT projects.id, domains.name AS container_name
+           FROM projects
+           JOIN project_owners ON projects.owner_id = project_owners.id
+           JOIN users_owners ON project_owners.id = users_owners.owner_id
+           JOIN domains ON domains.project_id = projects.id
+           AND projects.name = $1
+           AND project_owners.name = $2
+        "#,
+        project,
+        owner,
+    )
+    .fetch_optional(&pool)
+    .await
+    {
+        Ok(Some(record)) => record,
+        Ok(None) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Project does not exist".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap()
+                .into_response();
+        }
+        Err(err) => {
+            tracing::error!(?err, "Can't get projects: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap()
+                .into_response();
+        }
+    };
+
+    let docker = match nodes::docker(&project.container_name) {
+        Ok(docker) => docker,
+        Err(err) => {
+            tracing::error!(?err, "Can't open shell: Failed to connect to docker");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to connect to docker: {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap()
+                .into_response();
+        }
+    };
+
+    let running = match docker
+        .inspect_container(&project.container_name, None::<InspectContainerOptions>)
+        .await
+    {
+        Ok(container) => container.state.and_then(|state| state.running).unwrap_or(false),
+        Err(_) => false,
+    };
+
+    if !running {
+        let json = serde_json::to_string(&ErrorResponse {
+            message: "Project is not running".to_string()
+        }).unwrap();
+
+        return Response::builder()
+            .status(StatusCode::BAD_REQUEST)
+            .body(Body::from(json))
+            .unwrap()
+            .into_response();
+    }
+
+    ws.on_upgrade(move |socket| async move {
+        exec(socket, docker, project.container_name).await;
+    })
+}
+
+async fn exec(mut socket: WebSocket, docker: Docker, container_name: String) {
+    let Some(start) = read_start(&mut socket).await else {
+        return;
+    };
+
+    tracing::info!(%container_name, command = ?start.command, tty = start.tty, "Opening shell");
+
+    let exec = match docker
+        .create_exec(
+            &container_name,
+            CreateExecOptions {
+                attach_stdin: Some(true),
+                attach_stdout: Some(true),
+                attach_stderr: Some(true),
+                tty: Some(start.tty),
+                cmd: Some(start.command.clone()),
+                ..Default::default()
+            },
+        )
+        .await
+    {
+        Ok(exec) => exec,
+        Err(err) => {
+            tracing::error!(?err, "Can't open shell: Failed to create exec");
+            close(socket, ServerMessage::Error {
+                message: format!("Failed to start command: {err}"),
+            })
+            .await;
+            return;
+        }
+    };
+
+    let (output, input) = match docker.start_exec(&exec.id, None).await {
+        Ok(StartExecResults::Attached { output, input }) => (output, input),
+        Ok(StartExecResults::Detached) => {
+            tracing::error!("Can't open shell: Exec started detached");
+            return;
+        }
+        Err(err) => {
+            tracing::error!(?err, "Can't open shell: Failed to start exec");
+            close(socket, ServerMessage::Error {
+                message: format!("Failed to start command: {err}"),
+            })
+            .await;
+            return;
+        }
+    };
+
+    // docker can't kill an exec, dropping the connection hangs up its terminal instead, so
+    // shells don't get a deadline
+    let deadline = std::future::pending::<()>();
+    let sender = match stream(socket, output, input, &start, &docker, Tty::Exec(&exec.id), deadline).await {
+        SessionEnd::Exited(sender) | SessionEnd::TimedOut(sender) => sender,
+        SessionEnd::Disconnected => {
+            tracing::info!(%container_name, "Shell client disconnected");
+            return;
+        }
+    };
+
+    // the output can end a moment before docker marks the exec as done
+    let mut exit_code = None;
+    for _ in 0..EXIT_POLLS {
+        match docker.inspect_exec(&exec.id).await {
+            Ok(ExecInspectResponse { running: Some(true), .. }) => {
+                tokio::time::sleep(EXIT_POLL_INTERVAL).await;
+            }
+            Ok(exec) => {
+                exit_code = exec.exit_code;
+                break;
+            }
+            Err(err) => {
+                tracing::error!(?err, "Can't get exit code of shell: Failed to inspect exec");
+                break;
+            }
+        }
+    }
+
+    exit(sender, exit_code).await;
+}
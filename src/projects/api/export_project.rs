@@ -0,0 +1,111 @@
+use axum::extract::{Path, Query, State};
+use axum::response::Response;
+use hyper::{Body, StatusCode};
+use serde::{Deserialize, Serialize};
+
+use crate::bundles;
+use crate::{auth::Auth, startup::AppState};
+
+#[derive(Deserialize, Debug)]
+pub struct ExportProjectQuery {
+    /// `json` (the default) or `yaml`
+    pub format: Option<String>,
+}
+
+#[derive(Serialize, Debug)]
+struct ErrorResponse {
+    message: String,
+}
+
+/// The bundle of the app, to import it on another platform. Only names of variables go in,
+/// but it still says which secrets the app has, so it is for maintainers
+#[tracing::instrument(skip(auth, pool))]
+pub async fn get(
+    auth: Auth,
+    State(AppState { pool, .. }): State<AppState>,
+    Path((owner, project)): Path<(String, String)>,
+    Query(ExportProjectQuery { format }): Query<ExportProjectQuery>,
+) -> Response<Body> {
+    let _user = auth.current_user.unwrap();
+
+    // check if project exist
+    let project_record = match sqlx::query!(
+        r#"SELECT projects.id AS id
+           FROM projects
+           JOIN project_owners ON projects.owner_id = project_owners.id
+           JOIN users_owners ON project_owners.id = users_owners.owner_id
+           AND projects.name = $1
+           AND project_owners.name = $2
+        "#,
+        project,
+        owner,
+    )
+    .fetch_optional(&pool)
+    .await
+    {
+        Ok(Some(record)) => record,
+        Ok(None) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Project does not exist".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Err(err) => {
+            tracing::error!(?err, "Can't get projects: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    let bundle = match bundles::export(project_record.id, &format!("{owner}/{project}"), &pool).await {
+        Ok(bundle) => bundle,
+        Err(err) => {
+            tracing::error!(?err, "Can't export project: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {err}")
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    let filename = format!("{owner}-{project}");
+    match format.as_deref() {
+        Some("yaml") => Response::builder()
+            .status(StatusCode::OK)
+            .header("Content-Type", "application/yaml")
+            .header("Content-Disposition", format!("attachment; filename=\"{filename}.yaml\""))
+            .body(Body::from(serde_yaml::to_string(&bundle).unwrap()))
+            .unwrap(),
+        None | Some("json") => Response::builder()
+            .status(StatusCode::OK)
+            .header("Content-Type", "application/json")
+            .body(Body::from(serde_json::to_string(&bundle).unwrap()))
+            .unwrap(),
+        Some(other) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Can't export as {other}, use json or yaml")
+            }).unwrap();
+
+            Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap()
+        }
+    }
+}
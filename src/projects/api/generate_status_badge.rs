@@ -13,7 +13,8 @@ pub enum BuildState {
     PENDING,
     BUILDING,
     SUCCESSFUL,
-    FAILED
+    FAILED,
+    CANCELLED
 }
 
 impl fmt::Display for BuildState {
@@ -23,6 +24,7 @@ impl fmt::Display for BuildState {
             BuildState::BUILDING => write!(f, "Building"),
             BuildState::SUCCESSFUL => write!(f, "Successful"),
             BuildState::FAILED => write!(f, "Failed"),
+            BuildState::CANCELLED => write!(f, "Cancelled"),
         }
     }
 }
@@ -103,7 +105,7 @@ pub async fn get(
     let mut style = badgen::Style::flat();
     
     style.background = match &build.status {
-        BuildState::PENDING => badgen::Color::Grey,
+        BuildState::PENDING | BuildState::CANCELLED => badgen::Color::Grey,
         BuildState::FAILED => badgen::Color::Red,
         BuildState::SUCCESSFUL => badgen::Color::Green,
         BuildState::BUILDING => badgen::Color::Yellow,
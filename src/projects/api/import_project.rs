@@ -0,0 +1,196 @@
+use axum::body::Bytes;
+use axum::extract::{Path, State};
+use axum::response::Response;
+use hyper::{Body, HeaderMap, StatusCode};
+use serde::Serialize;
+
+use super::add_custom_domain::hostname_check;
+use crate::bundles::{self, Bundle};
+use crate::quotas::check_database;
+use crate::telemetry::current_context;
+use crate::{auth::Auth, queue::{BuildKind, BuildQueueItem}, startup::AppState};
+
+#[derive(Serialize, Debug)]
+struct ErrorResponse {
+    message: String,
+}
+
+/// Applies an exported bundle to the app, over what it has. The body is the bundle as json
+/// or yaml, anything not sent as json is read as yaml, which reads json too. Values of the
+/// variables it names have to be set again, the response says which are still missing
+#[tracing::instrument(skip(auth, pool, build_channel, quota_settings, headers, body))]
+pub async fn post(
+    auth: Auth,
+    State(AppState { pool, base, build_channel, container_settings, quota_settings, domain, .. }): State<AppState>,
+    Path((owner, project)): Path<(String, String)>,
+    headers: HeaderMap,
+    body: Bytes,
+) -> Response<Body> {
+    let _user = auth.current_user.unwrap();
+
+    let json = headers
+        .get("Content-Type")
+        .and_then(|value| value.to_str().ok())
+        .is_some_and(|value| value.contains("json"));
+    let bundle = match json {
+        true => serde_json::from_slice::<Bundle>(&body).map_err(|err| err.to_string()),
+        false => serde_yaml::from_slice::<Bundle>(&body).map_err(|err| err.to_string()),
+    };
+    let bundle = match bundle.and_then(|bundle| bundle.validate().map(|_| bundle).map_err(|err| err.to_string())) {
+        Ok(bundle) => bundle,
+        Err(message) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Invalid bundle: {message}")
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    for name in &bundle.domains {
+        let message = match hostname_check(name, &()) {
+            Err(err) => format!("{name}: {err}"),
+            Ok(()) if name == &domain || name.ends_with(&format!(".{domain}")) => {
+                format!("{name}: Subdomains of the platform are assigned automatically")
+            }
+            Ok(()) => continue,
+        };
+        let json = serde_json::to_string(&ErrorResponse { message }).unwrap();
+
+        return Response::builder()
+            .status(StatusCode::BAD_REQUEST)
+            .body(Body::from(json))
+            .unwrap();
+    }
+
+    // check if project exist
+    let project_record = match sqlx::query!(
+        r#"SELECT projects.id AS id
+           FROM projects
+           JOIN project_owners ON projects.owner_id = project_owners.id
+           JOIN users_owners ON project_owners.id = users_owners.owner_id
+           AND projects.name = $1
+           AND project_owners.name = $2
+        "#,
+        project,
+        owner,
+    )
+    .fetch_optional(&pool)
+    .await
+    {
+        Ok(Some(record)) => record,
+        Ok(None) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Project does not exist".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Err(err) => {
+            tracing::error!(?err, "Can't get projects: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    // a database the bundle asks for counts against the quota like one added by hand
+    if bundle.manifest.addons.iter().any(|addon| addon == "postgres") {
+        match check_database(project_record.id, &quota_settings, &pool).await {
+            Ok(None) => {}
+            Ok(Some(message)) => {
+                let json = serde_json::to_string(&ErrorResponse { message }).unwrap();
+
+                return Response::builder()
+                    .status(StatusCode::FORBIDDEN)
+                    .body(Body::from(json))
+                    .unwrap();
+            }
+            Err(err) => {
+                tracing::error!(?err, "Can't check database quota: Failed to query usage");
+
+                let json = serde_json::to_string(&ErrorResponse {
+                    message: format!("Failed to check database quota: {err}")
+                }).unwrap();
+
+                return Response::builder()
+                    .status(StatusCode::INTERNAL_SERVER_ERROR)
+                    .body(Body::from(json))
+                    .unwrap();
+            }
+        }
+    }
+
+    let repo = project.trim_end_matches(".git").to_string();
+    let container_name = format!("{owner}-{repo}").replace('.', "-");
+
+    let report = match bundles::import(
+        &bundle,
+        project_record.id,
+        &container_name,
+        &container_settings,
+        &pool,
+    )
+    .await
+    {
+        Ok(report) => report,
+        Err(err) => {
+            tracing::error!(?err, "Can't import project: Failed to apply bundle");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to apply bundle: {err}")
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    // an app that was deployed before runs with the imported settings right away, a new one
+    // picks them up on its first build
+    match sqlx::query!("SELECT id FROM releases WHERE project_id = $1 LIMIT 1", project_record.id)
+        .fetch_optional(&pool)
+        .await
+    {
+        Ok(Some(_)) if !report.changes.is_empty() => {
+            if let Err(err) = build_channel
+                .send(BuildQueueItem {
+                    container_name,
+                    container_src: format!("{base}/{owner}/{repo}.git/master"),
+                    owner,
+                    repo,
+                    kind: BuildKind::Reconfigure("Import bundle".to_string()),
+                    trace: current_context(),
+                })
+                .await
+            {
+                tracing::error!(?err, "Can't release imported project: Failed to send to build queue");
+            }
+        }
+        Ok(_) => {}
+        Err(err) => {
+            tracing::error!(?err, "Can't release imported project: Failed to query database");
+        }
+    }
+
+    let json = serde_json::to_string(&report).unwrap();
+
+    Response::builder()
+        .status(StatusCode::OK)
+        .body(Body::from(json))
+        .unwrap()
+}
@@ -0,0 +1,154 @@
+use axum::extract::{State, Path};
+use axum::response::Response;
+use axum::Json;
+use garde::{Unvalidated, Validate};
+use hyper::{Body, StatusCode};
+use serde::{Deserialize, Serialize};
+
+use crate::activity::record_activity;
+use crate::linked_repos::webhook_secret;
+use crate::{auth::Auth, startup::AppState};
+
+#[derive(Deserialize, Validate, Debug)]
+pub struct LinkRepoRequest {
+    #[garde(pattern("^(github|gitlab)$"))]
+    pub provider: String,
+    /// https clone url of the repository
+    #[garde(length(max = 2048), pattern("^https://[^\\s]+$"))]
+    pub url: String,
+    /// pushes to this branch are deployed
+    #[garde(length(min = 1, max = 255), pattern("^[A-Za-z0-9._/-]+$"))]
+    pub branch: String,
+    /// access token for private repositories, stored encrypted
+    #[garde(skip)]
+    pub token: Option<String>,
+}
+
+#[derive(Serialize, Debug)]
+struct ErrorResponse {
+    message: String,
+}
+
+/// Links the project to a repository on github or gitlab. Linking again changes the
+/// repository or branch but keeps the webhook secret, so the webhook doesn't need updating
+#[tracing::instrument(skip(auth, pool, secrets, req))]
+pub async fn post(
+    auth: Auth,
+    State(AppState { pool, secrets, .. }): State<AppState>,
+    Path((owner, project)): Path<(String, String)>,
+    Json(req): Json<Unvalidated<LinkRepoRequest>>
+) -> Response<Body> {
+    let _user = auth.current_user.unwrap();
+
+    let LinkRepoRequest { provider, url, branch, token } = match req.validate(&()) {
+        Ok(valid) => valid.into_inner(),
+        Err(err) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: err.to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    // check if project exist
+    let project_record = match sqlx::query!(
+        r#"SELECT projects.id AS id
+           FROM projects
+           JOIN project_owners ON projects.owner_id = project_owners.id
+           JOIN users_owners ON project_owners.id = users_owners.owner_id
+           AND projects.name = $1
+           AND project_owners.name = $2
+        "#,
+        project,
+        owner,
+    )
+    .fetch_optional(&pool)
+    .await
+    {
+        Ok(Some(record)) => record,
+        Ok(None) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Project does not exist".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Err(err) => {
+            tracing::error!(?err, "Can't get projects: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    let token = match token
+        .filter(|token| !token.is_empty())
+        .map(|token| secrets.encrypt(&token))
+        .transpose()
+    {
+        Ok(token) => token,
+        Err(err) => {
+            tracing::error!(?err, "Can't link repository: Failed to encrypt token");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Secrets are not enabled on this server".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    if let Err(err) = sqlx::query!(
+        r#"INSERT INTO linked_repos (project_id, provider, url, branch, token, webhook_secret)
+           VALUES ($1, $2, $3, $4, $5, $6)
+           ON CONFLICT (project_id) DO UPDATE
+           SET provider = $2, url = $3, branch = $4, token = $5
+        "#,
+        project_record.id,
+        provider,
+        url,
+        branch,
+        token,
+        webhook_secret(),
+    )
+    .execute(&pool)
+    .await
+    {
+        tracing::error!(?err, "Can't link repository: Failed to query database");
+
+        let json = serde_json::to_string(&ErrorResponse {
+            message: format!("Failed to query database: {}", err.to_string())
+        }).unwrap();
+
+        return Response::builder()
+            .status(StatusCode::INTERNAL_SERVER_ERROR)
+            .body(Body::from(json))
+            .unwrap();
+    }
+
+    let message = format!("Linked {url} on {provider}, deploying pushes to {branch}");
+    if let Err(err) = record_activity(project_record.id, "repo", &message, &pool).await {
+        tracing::error!(?err, "Can't record activity: Failed to query database");
+    }
+
+    Response::builder()
+        .status(StatusCode::NO_CONTENT)
+        .body(Body::empty())
+        .unwrap()
+}
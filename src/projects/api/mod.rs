@@ -1,32 +1,264 @@
-use axum::{middleware, Router, routing::{get, post}};
+use axum::{extract::DefaultBodyLimit, middleware, Router, routing::{get, post}};
 use axum_extra::routing::RouterExt;
 use hyper::Body;
 
-use crate::{auth::auth, startup::AppState, configuration::Settings};
+use crate::{audit::audit_trail, auth::auth, owner::authorize, startup::AppState, configuration::Settings};
 
 mod create_project;
+mod clone_project;
+mod export_project;
+mod import_project;
+mod view_templates;
+mod scaffold_project;
 mod project_dashboard;
 mod web_terminal;
+mod run_command;
+mod exec_command;
 mod delete_project;
 mod delete_volume;
 mod view_build_log;
+mod stream_build_log;
+mod stream_live_updates;
+mod cancel_build;
 mod view_container_log;
 mod view_project_environ;
 mod update_project_environ;
 mod delete_project_environ;
+mod view_project_env_groups;
+mod attach_env_group;
+mod detach_env_group;
+mod view_labels;
+mod set_labels;
+mod view_cleanup;
+mod restore_project;
 mod generate_status_badge;
+mod view_project_settings;
+mod update_project_settings;
+mod view_maintenance;
+mod start_maintenance;
+mod stop_maintenance;
+mod view_error_page;
+mod set_error_page;
+mod reset_error_page;
+mod view_ip_access;
+mod set_ip_access;
+mod view_cors;
+mod view_access_logs;
+mod view_access_log_settings;
+mod set_access_log_settings;
+mod search_container_logs;
+mod view_container_log_settings;
+mod set_container_log_settings;
+mod view_egress;
+mod set_egress;
+mod rotate_proxy_secret;
+mod set_cors;
+mod view_header_rules;
+mod set_header_rules;
+mod view_basic_auth;
+mod enable_basic_auth;
+mod disable_basic_auth;
+mod view_previews;
+mod set_previews;
+mod view_push_policy;
+mod set_push_policy;
+mod view_deploy_branch;
+mod set_deploy_branch;
+mod view_deploy_keys;
+mod create_deploy_key;
+mod rotate_deploy_key;
+mod delete_deploy_key;
+mod delete_preview;
+mod view_cache;
+mod purge_cache;
+mod trigger_build;
+mod deploy_image;
+mod upload_deploy;
+mod view_project_releases;
+mod view_release;
+mod rollback_release;
+mod diff_releases;
+mod pin_release;
+mod unpin_release;
+mod promote_release;
+mod view_pipeline;
+mod set_pipeline;
+mod promote_pipeline;
+mod approve_promotion;
+mod reject_promotion;
+mod view_canary;
+mod create_canary;
+mod promote_canary;
+mod abort_canary;
+mod view_project_processes;
+mod scale_project_process;
+mod view_autoscalers;
+mod set_autoscaler;
+mod delete_autoscaler;
+mod view_project_activity;
+mod view_project_events;
+mod view_project_metrics;
+mod view_profile;
+mod view_addons;
+mod create_addon;
+mod delete_addon;
+mod view_bucket;
+mod set_bucket;
+mod view_redis;
+mod view_mail;
+mod add_mail_suppression;
+mod delete_mail_suppression;
+mod view_backups;
+mod create_backup;
+mod restore_backup;
+mod view_volumes;
+mod attach_volume;
+mod detach_volume;
+mod browse_volume;
+mod download_volume;
+mod view_limits;
+mod view_usage;
+mod view_cron_jobs;
+mod create_cron_job;
+mod delete_cron_job;
+mod view_cron_runs;
+mod view_cron_run_log;
+mod view_ports;
+mod create_port;
+mod delete_port;
+mod view_custom_domains;
+mod add_custom_domain;
+mod delete_custom_domain;
+mod check_custom_domain;
+mod view_log_drains;
+mod add_log_drain;
+mod delete_log_drain;
+mod view_notification_hooks;
+mod add_notification_hook;
+mod delete_notification_hook;
+mod view_registry_credentials;
+mod set_registry_credential;
+mod delete_registry_credential;
+mod view_linked_repo;
+mod link_repo;
+mod unlink_repo;
+mod receive_repo_webhook;
+mod view_project_audit;
 
-pub async fn router(_state: AppState, _config: &Settings) -> Router<AppState, Body> {
+pub async fn router(state: AppState, config: &Settings) -> Router<AppState, Body> {
     Router::new()
         .route_with_tsr("/api/project/new", post(create_project::post))
+        .route_with_tsr("/api/templates", get(view_templates::get))
         .route_with_tsr("/api/project/:owner/:project/builds", get(project_dashboard::get))
         .route_with_tsr("/api/project/:owner/:project/logs", get(view_container_log::get))
+        .route_with_tsr("/api/project/:owner/:project/logs/search", get(search_container_logs::get))
+        .route_with_tsr("/api/project/:owner/:project/logs/settings", get(view_container_log_settings::get).post(set_container_log_settings::post))
+        .route_with_tsr("/api/project/:owner/:project/logs/router", get(view_access_logs::get))
+        .route_with_tsr("/api/project/:owner/:project/logs/router/settings", get(view_access_log_settings::get).post(set_access_log_settings::post))
         .route_with_tsr("/api/project/:owner/:project/env", get(view_project_environ::get).post(update_project_environ::post))
         .route_with_tsr("/api/project/:owner/:project/env/delete", post(delete_project_environ::post))
+        .route_with_tsr("/api/project/:owner/:project/env-groups", get(view_project_env_groups::get).post(attach_env_group::post))
+        .route_with_tsr("/api/project/:owner/:project/env-groups/:group/detach", post(detach_env_group::post))
+        .route_with_tsr("/api/project/:owner/:project/labels", get(view_labels::get).post(set_labels::post))
+        .route_with_tsr("/api/project/:owner/:project/cleanup", get(view_cleanup::get))
+        .route_with_tsr("/api/project/:owner/:project/cleanup/restore", post(restore_project::post))
+        .route_with_tsr("/api/project/:owner/:project/settings", get(view_project_settings::get).post(update_project_settings::post))
+        .route_with_tsr("/api/project/:owner/:project/maintenance", get(view_maintenance::get).post(start_maintenance::post))
+        .route_with_tsr("/api/project/:owner/:project/maintenance/delete", post(stop_maintenance::post))
+        .route_with_tsr("/api/project/:owner/:project/error-page", get(view_error_page::get).post(set_error_page::post))
+        .route_with_tsr("/api/project/:owner/:project/error-page/delete", post(reset_error_page::post))
+        .route_with_tsr("/api/project/:owner/:project/access", get(view_ip_access::get).post(set_ip_access::post))
+        .route_with_tsr("/api/project/:owner/:project/egress", get(view_egress::get).post(set_egress::post))
+        .route_with_tsr("/api/project/:owner/:project/proxy-secret/rotate", post(rotate_proxy_secret::post))
+        .route_with_tsr("/api/project/:owner/:project/cors", get(view_cors::get).post(set_cors::post))
+        .route_with_tsr("/api/project/:owner/:project/headers", get(view_header_rules::get).post(set_header_rules::post))
+        .route_with_tsr("/api/project/:owner/:project/basic-auth", get(view_basic_auth::get).post(enable_basic_auth::post))
+        .route_with_tsr("/api/project/:owner/:project/basic-auth/delete", post(disable_basic_auth::post))
+        .route_with_tsr("/api/project/:owner/:project/previews", get(view_previews::get).post(set_previews::post))
+        .route_with_tsr("/api/project/:owner/:project/previews/:name/delete", post(delete_preview::post))
+        .route_with_tsr("/api/project/:owner/:project/push-policy", get(view_push_policy::get).post(set_push_policy::post))
+        .route_with_tsr("/api/project/:owner/:project/deploy-branch", get(view_deploy_branch::get).post(set_deploy_branch::post))
+        .route_with_tsr("/api/project/:owner/:project/clone", post(clone_project::post))
+        .route_with_tsr("/api/project/:owner/:project/export", get(export_project::get))
+        .route_with_tsr("/api/project/:owner/:project/import", post(import_project::post))
+        .route_with_tsr("/api/project/:owner/:project/scaffold", post(scaffold_project::post))
+        .route_with_tsr("/api/project/:owner/:project/deploy-keys", get(view_deploy_keys::get).post(create_deploy_key::post))
+        .route_with_tsr("/api/project/:owner/:project/deploy-keys/:key_id/rotate", post(rotate_deploy_key::post))
+        .route_with_tsr("/api/project/:owner/:project/deploy-keys/:key_id/delete", post(delete_deploy_key::post))
+        .route_with_tsr("/api/project/:owner/:project/cache", get(view_cache::get))
+        .route_with_tsr("/api/project/:owner/:project/cache/purge", post(purge_cache::post))
+        .route_with_tsr("/api/project/:owner/:project/builds/trigger", post(trigger_build::post))
+        .route_with_tsr("/api/project/:owner/:project/builds/image", post(deploy_image::post))
+        // archives are as big as a push can be
+        .route_with_tsr("/api/project/:owner/:project/deploys", post(upload_deploy::post).layer(DefaultBodyLimit::max(config.body_limit())))
         .route_with_tsr("/api/project/:owner/:project/builds/:build_id", get(view_build_log::get))
+        .route_with_tsr("/api/project/:owner/:project/builds/:build_id/stream", get(stream_build_log::get))
+        .route_with_tsr("/api/project/:owner/:project/live", get(stream_live_updates::get))
+        .route_with_tsr("/api/project/:owner/:project/builds/:build_id/cancel", post(cancel_build::post))
+        .route_with_tsr("/api/project/:owner/:project/releases", get(view_project_releases::get))
+        .route_with_tsr("/api/project/:owner/:project/releases/:release_id", get(view_release::get))
+        .route_with_tsr("/api/project/:owner/:project/releases/:release_id/rollback", post(rollback_release::post))
+        .route_with_tsr("/api/project/:owner/:project/releases/:release_id/diff/:other_id", get(diff_releases::get))
+        .route_with_tsr("/api/project/:owner/:project/releases/:release_id/pin", post(pin_release::post))
+        .route_with_tsr("/api/project/:owner/:project/releases/:release_id/unpin", post(unpin_release::post))
+        .route_with_tsr("/api/project/:owner/:project/releases/:release_id/promote", post(promote_release::post))
+        .route_with_tsr("/api/project/:owner/:project/pipeline", get(view_pipeline::get).post(set_pipeline::post))
+        .route_with_tsr("/api/project/:owner/:project/pipeline/promote", post(promote_pipeline::post))
+        .route_with_tsr("/api/project/:owner/:project/promotions/:promotion_id/approve", post(approve_promotion::post))
+        .route_with_tsr("/api/project/:owner/:project/promotions/:promotion_id/reject", post(reject_promotion::post))
+        .route_with_tsr("/api/project/:owner/:project/canary", get(view_canary::get).post(create_canary::post))
+        .route_with_tsr("/api/project/:owner/:project/canary/promote", post(promote_canary::post))
+        .route_with_tsr("/api/project/:owner/:project/canary/abort", post(abort_canary::post))
+        .route_with_tsr("/api/project/:owner/:project/processes", get(view_project_processes::get).post(scale_project_process::post))
+        .route_with_tsr("/api/project/:owner/:project/autoscale", get(view_autoscalers::get).post(set_autoscaler::post))
+        .route_with_tsr("/api/project/:owner/:project/autoscale/:process/delete", post(delete_autoscaler::post))
+        .route_with_tsr("/api/project/:owner/:project/activity", get(view_project_activity::get))
+        .route_with_tsr("/api/project/:owner/:project/events", get(view_project_events::get))
+        .route_with_tsr("/api/project/:owner/:project/metrics", get(view_project_metrics::get))
+        .route_with_tsr("/api/project/:owner/:project/debug/:profile", get(view_profile::get))
+        .route_with_tsr("/api/project/:owner/:project/addons", get(view_addons::get).post(create_addon::post))
+        .route_with_tsr("/api/project/:owner/:project/addons/delete", post(delete_addon::post))
+        .route_with_tsr("/api/project/:owner/:project/addons/s3", get(view_bucket::get).post(set_bucket::post))
+        .route_with_tsr("/api/project/:owner/:project/addons/redis", get(view_redis::get))
+        .route_with_tsr("/api/project/:owner/:project/addons/mail", get(view_mail::get))
+        .route_with_tsr("/api/project/:owner/:project/addons/mail/suppressions", post(add_mail_suppression::post))
+        .route_with_tsr("/api/project/:owner/:project/addons/mail/suppressions/delete", post(delete_mail_suppression::post))
+        .route_with_tsr("/api/project/:owner/:project/backups", get(view_backups::get).post(create_backup::post))
+        .route_with_tsr("/api/project/:owner/:project/backups/:backup_id/restore", post(restore_backup::post))
+        .route_with_tsr("/api/project/:owner/:project/limits", get(view_limits::get))
+        .route_with_tsr("/api/project/:owner/:project/usage", get(view_usage::get))
+        .route_with_tsr("/api/project/:owner/:project/volumes", get(view_volumes::get).post(attach_volume::post))
+        .route_with_tsr("/api/project/:owner/:project/volumes/:name/delete", post(detach_volume::post))
+        .route_with_tsr("/api/project/:owner/:project/volumes/:name/files", get(browse_volume::get))
+        .route_with_tsr("/api/project/:owner/:project/volumes/:name/download", get(download_volume::get))
+        .route_with_tsr("/api/project/:owner/:project/cron", get(view_cron_jobs::get).post(create_cron_job::post))
+        .route_with_tsr("/api/project/:owner/:project/cron/:job_id/delete", post(delete_cron_job::post))
+        .route_with_tsr("/api/project/:owner/:project/cron/:job_id/runs", get(view_cron_runs::get))
+        .route_with_tsr("/api/project/:owner/:project/cron/:job_id/runs/:run_id", get(view_cron_run_log::get))
+        .route_with_tsr("/api/project/:owner/:project/ports", get(view_ports::get).post(create_port::post))
+        .route_with_tsr("/api/project/:owner/:project/ports/:lease_id/delete", post(delete_port::post))
+        .route_with_tsr("/api/project/:owner/:project/domains", get(view_custom_domains::get).post(add_custom_domain::post))
+        .route_with_tsr("/api/project/:owner/:project/domains/delete", post(delete_custom_domain::post))
+        .route_with_tsr("/api/project/:owner/:project/drains", get(view_log_drains::get).post(add_log_drain::post))
+        .route_with_tsr("/api/project/:owner/:project/drains/:drain_id/delete", post(delete_log_drain::post))
+        .route_with_tsr("/api/project/:owner/:project/notifications", get(view_notification_hooks::get).post(add_notification_hook::post))
+        .route_with_tsr("/api/project/:owner/:project/notifications/:hook_id/delete", post(delete_notification_hook::post))
+        .route_with_tsr("/api/project/:owner/:project/registries", get(view_registry_credentials::get).post(set_registry_credential::post))
+        .route_with_tsr("/api/project/:owner/:project/registries/delete", post(delete_registry_credential::post))
+        .route_with_tsr("/api/project/:owner/:project/repo", get(view_linked_repo::get).post(link_repo::post))
+        .route_with_tsr("/api/project/:owner/:project/repo/delete", post(unlink_repo::post))
         .route_with_tsr("/api/project/:owner/:project/delete", post(delete_project::post))
         .route_with_tsr("/api/project/:owner/:project/volume/delete", post(delete_volume::post))
         .route_with_tsr("/api/project/:owner/:project/terminal/ws", get(web_terminal::ws))
+        .route_with_tsr("/api/project/:owner/:project/run/ws", get(run_command::ws))
+        .route_with_tsr("/api/project/:owner/:project/exec/ws", get(exec_command::ws))
+        .route_with_tsr("/api/project/:owner/:project/audit", get(view_project_audit::get))
+        // auth wraps authorize, so only logged in users get their role checked. the audit
+        // trail wraps both and keeps refused attempts too
+        .route_layer(middleware::from_fn_with_state(state.clone(), authorize))
         .route_layer(middleware::from_fn(auth))
+        .route_layer(middleware::from_fn_with_state(state, audit_trail))
         .route_with_tsr("/api/project/:owner/:project/badge/status", get(generate_status_badge::get))
+        .route_with_tsr("/api/project/:owner/:project/webhook", post(receive_repo_webhook::post))
+        .route_with_tsr("/api/domains/check", get(check_custom_domain::get))
 }
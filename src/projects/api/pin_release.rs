@@ -0,0 +1,195 @@
+use axum::extract::{State, Path};
+use axum::response::Response;
+use hyper::{Body, StatusCode};
+use serde::Serialize;
+use uuid::Uuid;
+
+use crate::audit::{with_change, AuditChange};
+use crate::telemetry::current_context;
+use crate::{auth::Auth, queue::{BuildKind, BuildQueueItem}, startup::AppState};
+
+#[derive(Serialize, Debug)]
+struct PinReleaseResponse {
+    message: String,
+}
+
+#[derive(Serialize, Debug)]
+struct ErrorResponse {
+    message: String,
+}
+
+/// Keeps the app on a release: deploys are refused until it is unpinned, environment
+/// changes still restart it. A release that isn't running is rolled back to first
+#[tracing::instrument(skip(auth, pool, build_channel))]
+pub async fn post(
+    auth: Auth,
+    State(AppState { pool, base, build_channel, .. }): State<AppState>,
+    Path((owner, project, release_id)): Path<(String, String, Uuid)>,
+) -> Response<Body> {
+    let _user = auth.current_user.unwrap();
+
+    // check if project exist
+    let project_record = match sqlx::query!(
+        r#"SELECT projects.id AS id, projects.pinned_release_id
+           FROM projects
+           JOIN project_owners ON projects.owner_id = project_owners.id
+           JOIN users_owners ON project_owners.id = users_owners.owner_id
+           AND projects.name = $1
+           AND project_owners.name = $2
+        "#,
+        project,
+        owner,
+    )
+    .fetch_optional(&pool)
+    .await
+    {
+        Ok(Some(record)) => record,
+        Ok(None) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Project does not exist".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Err(err) => {
+            tracing::error!(?err, "Can't get projects: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    // the live release is the newest one
+    let live = match sqlx::query!(
+        r#"SELECT releases.id = $1 AS "live!"
+           FROM releases
+           WHERE releases.project_id = $2
+           ORDER BY releases.created_at DESC
+           LIMIT 1
+        "#,
+        release_id,
+        project_record.id,
+    )
+    .fetch_optional(&pool)
+    .await
+    {
+        Ok(live) => live.is_some_and(|live| live.live),
+        Err(err) => {
+            tracing::error!(?err, "Can't get releases: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    match sqlx::query!(
+        r#"UPDATE projects SET pinned_release_id = $1, updated_at = now()
+           WHERE id = $2 AND EXISTS(SELECT 1 FROM releases WHERE id = $1 AND project_id = $2)
+        "#,
+        release_id,
+        project_record.id,
+    )
+    .execute(&pool)
+    .await
+    {
+        Ok(result) if result.rows_affected() > 0 => {}
+        Ok(_) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Release does not exist".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Err(err) => {
+            tracing::error!(?err, "Can't pin release: Failed to update database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Failed to update database".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    let change = AuditChange::new(
+        Some(serde_json::json!({ "pinned_release_id": project_record.pinned_release_id })),
+        Some(serde_json::json!({ "pinned_release_id": release_id })),
+    );
+
+    if live {
+        let json = serde_json::to_string(&PinReleaseResponse {
+            message: format!("Pinned to release {release_id}"),
+        }).unwrap();
+
+        return with_change(
+            Response::builder()
+                .status(StatusCode::OK)
+                .body(Body::from(json))
+                .unwrap(),
+            change,
+        );
+    }
+
+    let repo = project.trim_end_matches(".git");
+    let container_src = format!("{base}/{owner}/{repo}.git/master");
+    let container_name = format!("{owner}-{repo}").replace('.', "-");
+
+    if let Err(err) = build_channel
+        .send(BuildQueueItem {
+            container_name,
+            container_src,
+            owner,
+            repo: repo.to_string(),
+            kind: BuildKind::Rollback(release_id),
+            trace: current_context(),
+        })
+        .await
+    {
+        tracing::error!(?err, "Can't pin release: Failed to send to build queue");
+
+        let json = serde_json::to_string(&ErrorResponse {
+            message: "Pinned, but failed to queue the rollback to the release".to_string()
+        }).unwrap();
+
+        return with_change(
+            Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap(),
+            change,
+        );
+    }
+
+    let json = serde_json::to_string(&PinReleaseResponse {
+        message: format!("Pinned to release {release_id}, rollback queued"),
+    }).unwrap();
+
+    with_change(
+        Response::builder()
+            .status(StatusCode::ACCEPTED)
+            .body(Body::from(json))
+            .unwrap(),
+        change,
+    )
+}
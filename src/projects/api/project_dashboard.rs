@@ -1,21 +1,26 @@
 use std::fmt;
 
-use axum::extract::{State, Path};
+use axum::extract::{Path, Query, State};
 use axum::response::Response;
 use chrono::{DateTime, Utc};
 use hyper::{Body, StatusCode};
 use serde::{Serialize, Deserialize};
 use uuid::Uuid;
 
+use crate::pagination::{self, INVALID_CURSOR};
 use crate::{auth::Auth, startup::AppState};
 
+/// what `status` can be, the states of [`BuildState`]
+const BUILD_STATES: [&str; 5] = ["pending", "building", "successful", "failed", "cancelled"];
+
 #[derive(Serialize, Deserialize, Debug, sqlx::Type)]
 #[sqlx(type_name = "build_state", rename_all = "lowercase")] 
 pub enum BuildState {
     PENDING,
     BUILDING,
     SUCCESSFUL,
-    FAILED
+    FAILED,
+    CANCELLED
 }
 
 impl fmt::Display for BuildState {
@@ -25,10 +30,20 @@ impl fmt::Display for BuildState {
             BuildState::BUILDING => write!(f, "Building"),
             BuildState::SUCCESSFUL => write!(f, "Successful"),
             BuildState::FAILED => write!(f, "Failed"),
+            BuildState::CANCELLED => write!(f, "Cancelled"),
         }
     }
 }
 
+#[derive(Deserialize, Debug)]
+pub struct BuildListQuery {
+    /// only builds in this state, in any case
+    status: Option<String>,
+    /// `next_cursor` of the page before
+    cursor: Option<String>,
+    limit: Option<i64>,
+}
+
 #[derive(Serialize, Debug)]
 struct ErrorResponse {
     message: String,
@@ -44,7 +59,9 @@ struct Build {
 
 #[derive(Serialize, Debug)]
 struct ProjectBuildListResponse {
-    data: Vec<Build>
+    data: Vec<Build>,
+    /// missing once there are no older builds
+    next_cursor: Option<String>,
 }
 
 #[tracing::instrument(skip(auth, pool))]
@@ -52,9 +69,38 @@ pub async fn get(
     auth: Auth,
     State(AppState { pool, domain, secure, .. }): State<AppState>,
     Path((owner, project)): Path<(String, String)>,
+    Query(BuildListQuery { status, cursor, limit }): Query<BuildListQuery>,
 ) -> Response<Body> {
     let _user = auth.current_user.unwrap();
 
+    let status = status.map(|status| status.to_lowercase());
+    if let Some(status) = status.as_deref().filter(|status| !BUILD_STATES.contains(status)) {
+        let json = serde_json::to_string(&ErrorResponse {
+            message: format!("Unknown status {status}, it must be one of {}", BUILD_STATES.join(", ")),
+        }).unwrap();
+
+        return Response::builder()
+            .status(StatusCode::BAD_REQUEST)
+            .body(Body::from(json))
+            .unwrap();
+    }
+
+    let (before, before_id) = match cursor.as_deref().map(pagination::parse_cursor) {
+        Some(Some((before, before_id))) => (Some(before), Some(before_id)),
+        Some(None) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: INVALID_CURSOR.to_string(),
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        None => (None, None),
+    };
+    let limit = pagination::limit(limit);
+
     // check if project exist
     let project_record = match sqlx::query!(
         r#"SELECT projects.id, projects.name AS project, project_owners.name AS owner
@@ -98,8 +144,15 @@ pub async fn get(
     let build_records = match sqlx::query!(
         r#"SELECT id, project_id, status AS "status: BuildState", created_at, finished_at 
         FROM builds WHERE project_id = $1
-        ORDER BY created_at DESC"#,
-        project_record.id
+        AND ($2::text IS NULL OR status::text = $2)
+        AND ($3::timestamptz IS NULL OR (created_at, id) < ($3, $4::uuid))
+        ORDER BY created_at DESC, id DESC
+        LIMIT $5"#,
+        project_record.id,
+        status,
+        before,
+        before_id,
+        limit
     )
     .fetch_all(&pool)
     .await 
@@ -117,6 +170,7 @@ pub async fn get(
         }, 
     };
 
+    let next_cursor = pagination::next_cursor(&build_records, limit, |record| (record.created_at, record.id));
     let builds = build_records.into_iter().map(|record|{ 
         Build {
             id: record.id,
@@ -127,7 +181,8 @@ pub async fn get(
     }).collect::<Vec<_>>();
 
     let json = serde_json::to_string(&ProjectBuildListResponse {
-        data: builds
+        data: builds,
+        next_cursor,
     }).unwrap();
 
     Response::builder()
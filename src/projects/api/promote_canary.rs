@@ -0,0 +1,213 @@
+use axum::extract::{State, Path};
+use axum::response::Response;
+use axum::Json;
+use garde::{Unvalidated, Validate};
+use hyper::{Body, StatusCode};
+use serde::{Deserialize, Serialize};
+
+use crate::activity::record_activity;
+use crate::telemetry::current_context;
+use crate::{auth::Auth, queue::{BuildKind, BuildQueueItem}, startup::AppState};
+
+#[derive(Deserialize, Validate, Debug)]
+pub struct PromoteCanaryRequest {
+    /// moves the canary to this percent of requests, missing sends it every request
+    #[garde(range(min=1, max=99))]
+    pub weight: Option<i32>,
+}
+
+#[derive(Serialize, Debug)]
+struct PromoteCanaryResponse {
+    message: String,
+}
+
+#[derive(Serialize, Debug)]
+struct ErrorResponse {
+    message: String,
+}
+
+/// Gives the canary a larger or smaller share of the requests, or makes it the live release.
+/// Promoting doesn't build or start anything, the canary takes over the app container like a
+/// deploy and the workers are started on its release
+#[tracing::instrument(skip(auth, pool, build_channel))]
+pub async fn post(
+    auth: Auth,
+    State(AppState { pool, base, build_channel, .. }): State<AppState>,
+    Path((owner, project)): Path<(String, String)>,
+    Json(req): Json<Unvalidated<PromoteCanaryRequest>>
+) -> Response<Body> {
+    let _user = auth.current_user.unwrap();
+
+    let PromoteCanaryRequest { weight } = match req.validate(&()) {
+        Ok(valid) => valid.into_inner(),
+        Err(err) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: err.to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    // check if project exist
+    let project_record = match sqlx::query!(
+        r#"SELECT projects.id AS id
+           FROM projects
+           JOIN project_owners ON projects.owner_id = project_owners.id
+           JOIN users_owners ON project_owners.id = users_owners.owner_id
+           AND projects.name = $1
+           AND project_owners.name = $2
+        "#,
+        project,
+        owner,
+    )
+    .fetch_optional(&pool)
+    .await
+    {
+        Ok(Some(record)) => record,
+        Ok(None) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Project does not exist".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Err(err) => {
+            tracing::error!(?err, "Can't get projects: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    if let Some(weight) = weight {
+        match sqlx::query!(
+            "UPDATE canaries SET weight = $1 WHERE project_id = $2",
+            weight,
+            project_record.id
+        )
+        .execute(&pool)
+        .await
+        {
+            Ok(res) if res.rows_affected() == 0 => {
+                let json = serde_json::to_string(&ErrorResponse {
+                    message: "Project has no canary".to_string()
+                }).unwrap();
+
+                return Response::builder()
+                    .status(StatusCode::BAD_REQUEST)
+                    .body(Body::from(json))
+                    .unwrap();
+            }
+            Ok(_) => {}
+            Err(err) => {
+                tracing::error!(?err, "Can't update canary: Failed to query database");
+
+                let json = serde_json::to_string(&ErrorResponse {
+                    message: format!("Failed to query database: {}", err.to_string())
+                }).unwrap();
+
+                return Response::builder()
+                    .status(StatusCode::INTERNAL_SERVER_ERROR)
+                    .body(Body::from(json))
+                    .unwrap();
+            }
+        };
+
+        if let Err(err) = record_activity(
+            project_record.id,
+            "canary",
+            &format!("Moved the canary to {weight}% of requests"),
+            &pool,
+        )
+        .await
+        {
+            tracing::error!(?err, "Can't record activity: Failed to query database");
+        }
+
+        return Response::builder()
+            .status(StatusCode::NO_CONTENT)
+            .body(Body::empty())
+            .unwrap();
+    }
+
+    match sqlx::query!(
+        r#"SELECT build_id FROM canaries WHERE project_id = $1"#,
+        project_record.id,
+    )
+    .fetch_optional(&pool)
+    .await
+    {
+        Ok(Some(_)) => {}
+        Ok(None) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Project has no canary".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Err(err) => {
+            tracing::error!(?err, "Can't get canary: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    let repo = project.trim_end_matches(".git");
+    let container_src = format!("{base}/{owner}/{repo}.git/master");
+    let container_name = format!("{owner}-{repo}").replace('.', "-");
+
+    if let Err(err) = build_channel
+        .send(BuildQueueItem {
+            container_name,
+            container_src,
+            owner,
+            repo: repo.to_string(),
+            kind: BuildKind::Promote,
+            trace: current_context(),
+        })
+        .await
+    {
+        tracing::error!(?err, "Can't promote canary: Failed to send to build queue");
+
+        let json = serde_json::to_string(&ErrorResponse {
+            message: "Failed to queue promotion".to_string()
+        }).unwrap();
+
+        return Response::builder()
+            .status(StatusCode::INTERNAL_SERVER_ERROR)
+            .body(Body::from(json))
+            .unwrap();
+    }
+
+    let json = serde_json::to_string(&PromoteCanaryResponse {
+        message: "Promotion queued".to_string(),
+    }).unwrap();
+
+    Response::builder()
+        .status(StatusCode::ACCEPTED)
+        .body(Body::from(json))
+        .unwrap()
+}
@@ -0,0 +1,288 @@
+use axum::extract::{State, Path};
+use axum::response::Response;
+use axum::{Extension, Json};
+use hyper::{Body, StatusCode};
+use serde::{Deserialize, Serialize};
+use uuid::Uuid;
+
+use crate::audit::{with_change, AuditChange};
+use crate::auth::tokens::TokenAccess;
+use crate::owner::{member_role, suspension, Role};
+use crate::pipelines::{promote, Promotion, Target};
+use crate::{auth::Auth, startup::AppState};
+
+#[derive(Deserialize, Debug)]
+pub struct PromotePipelineRequest {
+    /// a release of this app, the live one when None
+    pub release_id: Option<Uuid>,
+}
+
+#[derive(Serialize, Debug)]
+struct PromotePipelineResponse {
+    message: String,
+    /// owner/project the release goes to
+    target: String,
+    release_id: Uuid,
+    /// set when the next stage only runs it once another member approves
+    #[serde(skip_serializing_if = "Option::is_none")]
+    promotion_id: Option<Uuid>,
+}
+
+#[derive(Serialize, Debug)]
+struct ErrorResponse {
+    message: String,
+}
+
+/// Promotes a release of the app to the next stage of its pipeline, like
+/// [`super::promote_release`] to the app set with [`super::set_pipeline`]
+#[tracing::instrument(skip(auth, token, pool, build_channel, notifier))]
+pub async fn post(
+    auth: Auth,
+    token: Option<Extension<TokenAccess>>,
+    State(AppState { pool, base, build_channel, notifier, .. }): State<AppState>,
+    Path((owner, project)): Path<(String, String)>,
+    Json(PromotePipelineRequest { release_id }): Json<PromotePipelineRequest>,
+) -> Response<Body> {
+    let user = auth.current_user.unwrap();
+
+    // the token of one app can't reach into another
+    if token.is_some_and(|Extension(token)| token.project_id.is_some()) {
+        let json = serde_json::to_string(&ErrorResponse {
+            message: "A token of one app can't promote to another".to_string()
+        }).unwrap();
+
+        return Response::builder()
+            .status(StatusCode::FORBIDDEN)
+            .body(Body::from(json))
+            .unwrap();
+    }
+
+    // check if project exist
+    let project_record = match sqlx::query!(
+        r#"SELECT projects.id AS id, projects.pipeline_next_id
+           FROM projects
+           JOIN project_owners ON projects.owner_id = project_owners.id
+           JOIN users_owners ON project_owners.id = users_owners.owner_id
+           AND projects.name = $1
+           AND project_owners.name = $2
+        "#,
+        project,
+        owner,
+    )
+    .fetch_optional(&pool)
+    .await
+    {
+        Ok(Some(record)) => record,
+        Ok(None) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Project does not exist".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Err(err) => {
+            tracing::error!(?err, "Can't get projects: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    let Some(next_id) = project_record.pipeline_next_id else {
+        let json = serde_json::to_string(&ErrorResponse {
+            message: "The app is the last stage of its pipeline, set the next one first".to_string()
+        }).unwrap();
+
+        return Response::builder()
+            .status(StatusCode::BAD_REQUEST)
+            .body(Body::from(json))
+            .unwrap();
+    };
+
+    let release = match sqlx::query!(
+        r#"SELECT id FROM releases
+           WHERE project_id = $1 AND ($2::uuid IS NULL OR id = $2)
+           ORDER BY created_at DESC
+           LIMIT 1
+        "#,
+        project_record.id,
+        release_id
+    )
+    .fetch_optional(&pool)
+    .await
+    {
+        Ok(Some(release)) => release,
+        Ok(None) => {
+            let message = match release_id {
+                Some(_) => "Release does not exist",
+                None => "Deploy the app before promoting it",
+            };
+            let json = serde_json::to_string(&ErrorResponse {
+                message: message.to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Err(err) => {
+            tracing::error!(?err, "Can't get release: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    let next = match sqlx::query!(
+        r#"SELECT projects.id, project_owners.name AS owner, projects.name, projects.promotion_approval
+           FROM projects
+           JOIN project_owners ON projects.owner_id = project_owners.id
+           WHERE projects.id = $1
+        "#,
+        next_id
+    )
+    .fetch_one(&pool)
+    .await
+    {
+        Ok(next) => next,
+        Err(err) => {
+            tracing::error!(?err, "Can't get projects: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+    let app = format!("{}/{}", next.owner, next.name);
+
+    // the next stage is checked the way the routes of an app are
+    match member_role(user.id, &next.owner, &pool).await {
+        Ok(Some(role)) if role >= Role::Maintainer => {}
+        Ok(Some(role)) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Only a maintainer of {} can deploy {app}, you are a {role}", next.owner)
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::FORBIDDEN)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Ok(None) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Project {app} does not exist")
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Err(err) => {
+            tracing::error!(?err, "Can't get users_owners: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    }
+
+    match suspension(&next.owner, &next.name, &pool).await {
+        Ok(None) => {}
+        Ok(Some(reason)) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("{app} is suspended: {reason}")
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::FORBIDDEN)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Err(err) => {
+            tracing::error!(?err, "Can't get suspension: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    }
+
+    let target = Target {
+        id: next.id,
+        owner: next.owner,
+        project: next.name,
+        promotion_approval: next.promotion_approval,
+    };
+    let promotion_id = match promote(release.id, project_record.id, &target, user.id, &base, &build_channel, &notifier, &pool).await {
+        Ok(Promotion::Queued) => None,
+        Ok(Promotion::Pending(promotion_id)) => Some(promotion_id),
+        Err(err) => {
+            tracing::error!(?err, "Can't promote release: Failed to queue promotion");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Failed to queue promotion".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    let message = match promotion_id {
+        Some(_) => format!("Promotion of release {} to {app} waits for an approval", release.id),
+        None => format!("Promotion of release {} to {app} queued", release.id),
+    };
+    let json = serde_json::to_string(&PromotePipelineResponse {
+        message,
+        target: app.clone(),
+        release_id: release.id,
+        promotion_id,
+    }).unwrap();
+    let after = serde_json::json!({
+        "release_id": release.id,
+        "app": app,
+        "promotion_id": promotion_id,
+    });
+
+    with_change(
+        Response::builder()
+            .status(StatusCode::ACCEPTED)
+            .body(Body::from(json))
+            .unwrap(),
+        AuditChange::new(None, Some(after)),
+    )
+}
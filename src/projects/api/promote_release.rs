@@ -0,0 +1,311 @@
+use axum::extract::{State, Path};
+use axum::response::Response;
+use axum::{Extension, Json};
+use garde::{Unvalidated, Validate};
+use hyper::{Body, StatusCode};
+use serde::{Deserialize, Serialize};
+use uuid::Uuid;
+
+use crate::audit::{with_change, AuditChange};
+use crate::auth::tokens::TokenAccess;
+use crate::owner::{member_role, suspension, Role};
+use crate::pipelines::{promote, Promotion, Target};
+use crate::{auth::Auth, startup::AppState};
+
+#[derive(Deserialize, Validate, Debug)]
+pub struct PromoteReleaseRequest {
+    /// the app that runs the release next, like `owner/production`
+    #[garde(length(min = 3, max = 255), custom(app_check))]
+    pub app: String,
+}
+
+#[derive(Serialize, Debug)]
+struct PromoteReleaseResponse {
+    message: String,
+    /// set when the other app only runs it once another member approves
+    #[serde(skip_serializing_if = "Option::is_none")]
+    promotion_id: Option<Uuid>,
+}
+
+#[derive(Serialize, Debug)]
+struct ErrorResponse {
+    message: String,
+}
+
+fn app_check(value: &str, _ctx: &()) -> garde::Result {
+    match value.split_once('/') {
+        Some((owner, project)) if !owner.is_empty() && !project.is_empty() && !project.contains('/') => Ok(()),
+        _ => Err(garde::Error::new("must be owner/project")),
+    }
+}
+
+/// Starts the image of a release of this app in another one, the way a staging app hands a
+/// tested release to production. Nothing is built again, the other app runs it with its own
+/// environment. The user has to be a maintainer of both. An app with promotion_approval waits
+/// for another member to approve it, see [`crate::pipelines`]
+#[tracing::instrument(skip(auth, token, pool, build_channel, notifier))]
+pub async fn post(
+    auth: Auth,
+    token: Option<Extension<TokenAccess>>,
+    State(AppState { pool, base, build_channel, notifier, .. }): State<AppState>,
+    Path((owner, project, release_id)): Path<(String, String, Uuid)>,
+    Json(req): Json<Unvalidated<PromoteReleaseRequest>>
+) -> Response<Body> {
+    let user = auth.current_user.unwrap();
+
+    let PromoteReleaseRequest { app } = match req.validate(&()) {
+        Ok(valid) => valid.into_inner(),
+        Err(err) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: err.to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+    let (target_owner, target_project) = app.split_once('/').unwrap();
+    let target_project = target_project.trim_end_matches(".git");
+
+    // the token of one app can't reach into another
+    if token.is_some_and(|Extension(token)| token.project_id.is_some()) {
+        let json = serde_json::to_string(&ErrorResponse {
+            message: "A token of one app can't promote to another".to_string()
+        }).unwrap();
+
+        return Response::builder()
+            .status(StatusCode::FORBIDDEN)
+            .body(Body::from(json))
+            .unwrap();
+    }
+
+    // check if project exist
+    let project_record = match sqlx::query!(
+        r#"SELECT projects.id AS id
+           FROM projects
+           JOIN project_owners ON projects.owner_id = project_owners.id
+           JOIN users_owners ON project_owners.id = users_owners.owner_id
+           AND projects.name = $1
+           AND project_owners.name = $2
+        "#,
+        project,
+        owner,
+    )
+    .fetch_optional(&pool)
+    .await
+    {
+        Ok(Some(record)) => record,
+        Ok(None) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Project does not exist".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Err(err) => {
+            tracing::error!(?err, "Can't get projects: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    match sqlx::query!(
+        r#"SELECT id FROM releases WHERE id = $1 AND project_id = $2"#,
+        release_id,
+        project_record.id,
+    )
+    .fetch_optional(&pool)
+    .await
+    {
+        Ok(Some(_)) => {}
+        Ok(None) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Release does not exist".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Err(err) => {
+            tracing::error!(?err, "Can't get release: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    // the other app is checked the way the routes of an app are
+    match member_role(user.id, target_owner, &pool).await {
+        Ok(Some(role)) if role >= Role::Maintainer => {}
+        Ok(Some(role)) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Only a maintainer of {target_owner} can deploy {app}, you are a {role}")
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::FORBIDDEN)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Ok(None) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Project {app} does not exist")
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Err(err) => {
+            tracing::error!(?err, "Can't get users_owners: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    }
+
+    let target = match sqlx::query!(
+        r#"SELECT projects.id, projects.promotion_approval
+           FROM projects
+           JOIN project_owners ON projects.owner_id = project_owners.id
+           WHERE project_owners.name = $1
+           AND projects.name = $2
+        "#,
+        target_owner,
+        target_project
+    )
+    .fetch_optional(&pool)
+    .await
+    {
+        Ok(Some(target)) => target,
+        Ok(None) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Project {app} does not exist")
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Err(err) => {
+            tracing::error!(?err, "Can't get projects: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    if target.id == project_record.id {
+        let json = serde_json::to_string(&ErrorResponse {
+            message: "Promote to another app, use rollback to run a release of this one".to_string()
+        }).unwrap();
+
+        return Response::builder()
+            .status(StatusCode::BAD_REQUEST)
+            .body(Body::from(json))
+            .unwrap();
+    }
+
+    match suspension(target_owner, target_project, &pool).await {
+        Ok(None) => {}
+        Ok(Some(reason)) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("{app} is suspended: {reason}")
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::FORBIDDEN)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Err(err) => {
+            tracing::error!(?err, "Can't get suspension: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    }
+
+    let target = Target {
+        id: target.id,
+        owner: target_owner.to_string(),
+        project: target_project.to_string(),
+        promotion_approval: target.promotion_approval,
+    };
+    let message = match promote(release_id, project_record.id, &target, user.id, &base, &build_channel, &notifier, &pool).await {
+        Ok(Promotion::Queued) => PromoteReleaseResponse {
+            message: format!("Promotion of release {release_id} to {target_owner}/{target_project} queued"),
+            promotion_id: None,
+        },
+        Ok(Promotion::Pending(promotion_id)) => PromoteReleaseResponse {
+            message: format!("Promotion of release {release_id} to {target_owner}/{target_project} waits for an approval"),
+            promotion_id: Some(promotion_id),
+        },
+        Err(err) => {
+            tracing::error!(?err, "Can't promote release: Failed to queue promotion");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Failed to queue promotion".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+    let json = serde_json::to_string(&message).unwrap();
+    let after = serde_json::json!({
+        "release_id": release_id,
+        "app": app,
+        "promotion_id": message.promotion_id,
+    });
+
+    with_change(
+        Response::builder()
+            .status(StatusCode::ACCEPTED)
+            .body(Body::from(json))
+            .unwrap(),
+        AuditChange::new(None, Some(after)),
+    )
+}
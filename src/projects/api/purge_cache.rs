@@ -0,0 +1,122 @@
+use axum::extract::{State, Path};
+use axum::response::Response;
+use axum::Json;
+use garde::{Unvalidated, Validate};
+use hyper::{Body, StatusCode};
+use serde::{Deserialize, Serialize};
+
+use crate::audit::{with_change, AuditChange};
+use crate::{auth::Auth, startup::AppState};
+
+#[derive(Deserialize, Validate, Debug)]
+pub struct PurgeCacheRequest {
+    /// paths like `/index.html`, or prefixes like `/assets/*`. Empty purges everything
+    #[serde(default)]
+    #[garde(length(max = 100), custom(paths_check))]
+    pub paths: Vec<String>,
+}
+
+#[derive(Serialize, Debug)]
+struct PurgeCacheResponse {
+    purged: usize,
+}
+
+#[derive(Serialize, Debug)]
+struct ErrorResponse {
+    message: String
+}
+
+fn paths_check(value: &Vec<String>, _ctx: &()) -> garde::Result {
+    for path in value {
+        if !path.starts_with('/') {
+            return Err(garde::Error::new(format!("{path} must start with /")));
+        }
+        if path.trim_end_matches('*').contains('*') {
+            return Err(garde::Error::new(format!("{path} can only end with *, it is a prefix then")));
+        }
+    }
+    Ok(())
+}
+
+/// Drops cached responses of the app so the next requests for them reach it again
+#[tracing::instrument(skip(auth, pool, cache, req))]
+pub async fn post(
+    auth: Auth,
+    State(AppState { pool, cache, .. }): State<AppState>,
+    Path((owner, project)): Path<(String, String)>,
+    Json(req): Json<Unvalidated<PurgeCacheRequest>>
+) -> Response<Body> {
+    let _user = auth.current_user.unwrap();
+
+    let PurgeCacheRequest { paths } = match req.validate(&()) {
+        Ok(valid) => valid.into_inner(),
+        Err(err) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: err.to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    let subdomain = format!("{owner}-{project}").replace('.', "-");
+
+    // check if project exist
+    match sqlx::query!(
+        r#"SELECT projects.id AS id
+           FROM projects
+           JOIN project_owners ON projects.owner_id = project_owners.id
+           JOIN users_owners ON project_owners.id = users_owners.owner_id
+           AND projects.name = $1
+           AND project_owners.name = $2
+        "#,
+        project,
+        owner,
+    )
+    .fetch_optional(&pool)
+    .await
+    {
+        Ok(Some(_)) => {}
+        Ok(None) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Project does not exist".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Err(err) => {
+            tracing::error!(?err, "Can't get projects: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    let purged = cache.purge(&subdomain, &paths);
+    let json = serde_json::to_string(&PurgeCacheResponse { purged }).unwrap();
+
+    let after = serde_json::json!({
+        "paths": paths,
+        "purged": purged,
+    });
+
+    with_change(
+        Response::builder()
+            .status(StatusCode::OK)
+            .body(Body::from(json))
+            .unwrap(),
+        AuditChange::new(None, Some(after)),
+    )
+}
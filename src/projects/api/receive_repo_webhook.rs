@@ -0,0 +1,169 @@
+use axum::body::Bytes;
+use axum::extract::{State, Path};
+use axum::response::Response;
+use hyper::{Body, HeaderMap, StatusCode};
+use serde::{Deserialize, Serialize};
+
+use crate::linked_repos::{deploy_push, verify_github, verify_gitlab, Push};
+use crate::startup::AppState;
+
+/// The fields of a github or gitlab push event the deploy needs, both name them the same
+#[derive(Deserialize, Debug)]
+struct PushEvent {
+    #[serde(rename = "ref")]
+    git_ref: String,
+    /// commit the branch points to after the push, zeroes when it was deleted
+    after: String,
+}
+
+#[derive(Serialize, Debug)]
+struct WebhookResponse {
+    message: String,
+}
+
+#[derive(Serialize, Debug)]
+struct ErrorResponse {
+    message: String,
+}
+
+/// Receives push events from the provider of the linked repository. There is no session,
+/// the signature or token made with the webhook secret is what authenticates the request
+#[tracing::instrument(skip(pool, base, build_channel, secrets, headers, body))]
+pub async fn post(
+    State(AppState { pool, base, build_channel, secrets, .. }): State<AppState>,
+    Path((owner, project)): Path<(String, String)>,
+    headers: HeaderMap,
+    body: Bytes,
+) -> Response<Body> {
+    let linked_repo = match sqlx::query!(
+        r#"SELECT linked_repos.project_id, linked_repos.provider, linked_repos.url,
+           linked_repos.branch, linked_repos.token, linked_repos.webhook_secret
+           FROM linked_repos
+           JOIN projects ON projects.id = linked_repos.project_id
+           JOIN project_owners ON projects.owner_id = project_owners.id
+           WHERE projects.name = $1
+           AND project_owners.name = $2
+        "#,
+        project,
+        owner,
+    )
+    .fetch_optional(&pool)
+    .await
+    {
+        Ok(Some(record)) => record,
+        Ok(None) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Project has no linked repository".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::NOT_FOUND)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Err(err) => {
+            tracing::error!(?err, "Can't get linked repository: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    let header = |name: &str| {
+        headers
+            .get(name)
+            .and_then(|value| value.to_str().ok())
+            .unwrap_or_default()
+    };
+
+    let (verified, is_push) = match linked_repo.provider.as_str() {
+        "github" => (
+            verify_github(&linked_repo.webhook_secret, &body, header("X-Hub-Signature-256")),
+            header("X-GitHub-Event") == "push",
+        ),
+        _ => (
+            verify_gitlab(&linked_repo.webhook_secret, header("X-Gitlab-Token")),
+            header("X-Gitlab-Event") == "Push Hook",
+        ),
+    };
+
+    if !verified {
+        let json = serde_json::to_string(&ErrorResponse {
+            message: "Invalid webhook signature".to_string()
+        }).unwrap();
+
+        return Response::builder()
+            .status(StatusCode::UNAUTHORIZED)
+            .body(Body::from(json))
+            .unwrap();
+    }
+
+    // the ping github sends when the webhook is added lands here too
+    if !is_push {
+        return ignored("Not a push event");
+    }
+
+    let event: PushEvent = match serde_json::from_slice(&body) {
+        Ok(event) => event,
+        Err(err) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Invalid push event: {err}")
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    if event.git_ref != format!("refs/heads/{}", linked_repo.branch) {
+        return ignored("Push is not to the deploy branch");
+    }
+    if event.after.chars().all(|c| c == '0') {
+        return ignored("Deploy branch was deleted");
+    }
+
+    let push = Push {
+        project_id: linked_repo.project_id,
+        owner,
+        repo: project,
+        provider: linked_repo.provider,
+        url: linked_repo.url,
+        branch: linked_repo.branch,
+        token: linked_repo.token,
+        commit: event.after,
+    };
+
+    // providers give up on webhooks that take more than a few seconds, fetching runs after
+    // the answer
+    tokio::spawn(async move {
+        deploy_push(push, &base, &pool, &build_channel, &secrets).await;
+    });
+
+    let json = serde_json::to_string(&WebhookResponse {
+        message: "Deploying push".to_string()
+    }).unwrap();
+
+    Response::builder()
+        .status(StatusCode::ACCEPTED)
+        .body(Body::from(json))
+        .unwrap()
+}
+
+fn ignored(reason: &str) -> Response<Body> {
+    let json = serde_json::to_string(&WebhookResponse {
+        message: format!("Ignored: {reason}")
+    }).unwrap();
+
+    Response::builder()
+        .status(StatusCode::OK)
+        .body(Body::from(json))
+        .unwrap()
+}
@@ -0,0 +1,78 @@
+use axum::extract::{State, Path};
+use axum::response::Response;
+use hyper::{Body, StatusCode};
+use serde::Serialize;
+use uuid::Uuid;
+
+use crate::audit::{with_change, AuditChange};
+use crate::{auth::Auth, startup::AppState};
+
+#[derive(Serialize, Debug)]
+struct ErrorResponse {
+    message: String,
+}
+
+/// Drops a release waiting to be promoted to the app. Anyone who could approve it can, the
+/// member who promoted it too, to take it back
+#[tracing::instrument(skip(auth, pool))]
+pub async fn post(
+    auth: Auth,
+    State(AppState { pool, .. }): State<AppState>,
+    Path((owner, project, promotion_id)): Path<(String, String, Uuid)>,
+) -> Response<Body> {
+    let user = auth.current_user.unwrap();
+
+    match sqlx::query!(
+        r#"UPDATE promotions SET status = 'rejected', decided_by = $1, decided_at = now()
+           FROM projects, project_owners
+           WHERE promotions.target_id = projects.id
+           AND projects.owner_id = project_owners.id
+           AND promotions.id = $2
+           AND projects.name = $3
+           AND project_owners.name = $4
+           AND promotions.status = 'pending'
+        "#,
+        user.id,
+        promotion_id,
+        project,
+        owner,
+    )
+    .execute(&pool)
+    .await
+    {
+        Ok(result) if result.rows_affected() == 0 => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Promotion does not exist or was already decided".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Ok(_) => {}
+        Err(err) => {
+            tracing::error!(?err, "Can't reject promotion: Failed to update database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Failed to update database".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    }
+
+    let before = serde_json::json!({ "promotion_id": promotion_id, "status": "pending" });
+    let after = serde_json::json!({ "promotion_id": promotion_id, "status": "rejected" });
+
+    with_change(
+        Response::builder()
+            .status(StatusCode::NO_CONTENT)
+            .body(Body::empty())
+            .unwrap(),
+        AuditChange::new(Some(before), Some(after)),
+    )
+}
@@ -0,0 +1,192 @@
+use axum::extract::{State, Path};
+use axum::response::Response;
+use axum::Json;
+use chrono::Utc;
+use hyper::{Body, StatusCode};
+use serde::{Deserialize, Serialize};
+use uuid::Uuid;
+
+use crate::backups::{database_name, restore_backup};
+use crate::{auth::Auth, startup::AppState};
+
+#[derive(Deserialize, Debug)]
+pub struct RestoreBackupRequest {
+    /// restore next to the current data instead of replacing it
+    #[serde(default)]
+    pub new_database: bool,
+}
+
+#[derive(Serialize, Debug)]
+struct RestoreBackupResponse {
+    database: String,
+}
+
+#[derive(Serialize, Debug)]
+struct ErrorResponse {
+    message: String,
+}
+
+/// Restores a backup into the postgres addon of a project, either over the database the
+/// app uses or into a new database in the same container. Returns once the restore is done
+#[tracing::instrument(skip(auth, pool, backups))]
+pub async fn post(
+    auth: Auth,
+    State(AppState { pool, backups, .. }): State<AppState>,
+    Path((owner, project, backup_id)): Path<(String, String, Uuid)>,
+    Json(RestoreBackupRequest { new_database }): Json<RestoreBackupRequest>,
+) -> Response<Body> {
+    let _user = auth.current_user.unwrap();
+
+    if !backups.enabled() {
+        let json = serde_json::to_string(&ErrorResponse {
+            message: "Backups are not configured on this platform".to_string()
+        }).unwrap();
+
+        return Response::builder()
+            .status(StatusCode::BAD_REQUEST)
+            .body(Body::from(json))
+            .unwrap();
+    }
+
+    // check if project exist
+    let project_record = match sqlx::query!(
+        r#"SELECT projects.id AS id
+           FROM projects
+           JOIN project_owners ON projects.owner_id = project_owners.id
+           JOIN users_owners ON project_owners.id = users_owners.owner_id
+           AND projects.name = $1
+           AND project_owners.name = $2
+        "#,
+        project,
+        owner,
+    )
+    .fetch_optional(&pool)
+    .await
+    {
+        Ok(Some(record)) => record,
+        Ok(None) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Project does not exist".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Err(err) => {
+            tracing::error!(?err, "Can't get projects: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    let addon = match sqlx::query!(
+        "SELECT id, name, url FROM addons WHERE project_id = $1 AND kind = 'postgres'",
+        project_record.id
+    )
+    .fetch_optional(&pool)
+    .await
+    {
+        Ok(Some(addon)) => addon,
+        Ok(None) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Project has no postgres addon".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Err(err) => {
+            tracing::error!(?err, "Can't get addons: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    match sqlx::query!(
+        "SELECT id FROM backups WHERE id = $1 AND addon_id = $2 AND status = 'successful'",
+        backup_id,
+        addon.id
+    )
+    .fetch_optional(&pool)
+    .await
+    {
+        Ok(Some(_)) => {}
+        Ok(None) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Backup does not exist or did not succeed".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Err(err) => {
+            tracing::error!(?err, "Can't get backups: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    }
+
+    let database = match (new_database, database_name(&addon.url)) {
+        (true, _) => format!("restore_{}", Utc::now().format("%Y%m%d%H%M%S")),
+        (false, Ok(database)) => database,
+        (false, Err(err)) => {
+            tracing::error!(?err, "Can't restore backup: Invalid database url");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Invalid database url: {err}")
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    if let Err(err) = restore_backup(backup_id, &addon.name, &database, new_database, &backups).await {
+        tracing::error!(?err, "Can't restore backup");
+
+        let json = serde_json::to_string(&ErrorResponse {
+            message: format!("Failed to restore backup: {err}")
+        }).unwrap();
+
+        return Response::builder()
+            .status(StatusCode::INTERNAL_SERVER_ERROR)
+            .body(Body::from(json))
+            .unwrap();
+    }
+
+    let json = serde_json::to_string(&RestoreBackupResponse { database }).unwrap();
+
+    Response::builder()
+        .status(StatusCode::OK)
+        .body(Body::from(json))
+        .unwrap()
+}
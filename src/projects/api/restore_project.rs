@@ -0,0 +1,109 @@
+use axum::extract::{Path, State};
+use axum::response::Response;
+use hyper::{Body, StatusCode};
+use serde::Serialize;
+
+use crate::activity::record_activity;
+use crate::audit::{with_change, AuditChange};
+use crate::orchestrator;
+use crate::{auth::Auth, startup::AppState};
+
+#[derive(Serialize, Debug)]
+struct ErrorResponse {
+    message: String,
+}
+
+/// Keeps an app the cleanup flagged or stopped. A stopped app is started again, and either way
+/// it counts as used today so it gets the full time before it is flagged again. Suspensions by
+/// the platform admins stay, only they lift those
+#[tracing::instrument(skip(auth, pool))]
+pub async fn post(
+    auth: Auth,
+    State(AppState { pool, .. }): State<AppState>,
+    Path((owner, project)): Path<(String, String)>,
+) -> Response<Body> {
+    let user = auth.current_user.unwrap();
+
+    let project_record = match sqlx::query!(
+        r#"WITH cleaned AS (
+             SELECT projects.id, projects.cleanup_flagged_at, projects.cleanup_stopped_at FROM projects
+             JOIN project_owners ON projects.owner_id = project_owners.id
+             WHERE project_owners.name = $1 AND projects.name = $2
+             AND (projects.cleanup_flagged_at IS NOT NULL OR projects.cleanup_stopped_at IS NOT NULL)
+           )
+           UPDATE projects SET cleanup_flagged_at = NULL, cleanup_stopped_at = NULL, cleanup_kept_at = now(),
+             suspended_at = CASE WHEN cleaned.cleanup_stopped_at IS NULL THEN projects.suspended_at END,
+             suspended_reason = CASE WHEN cleaned.cleanup_stopped_at IS NULL THEN projects.suspended_reason END
+           FROM cleaned
+           WHERE projects.id = cleaned.id
+           RETURNING projects.id, cleaned.cleanup_flagged_at AS flagged_at, cleaned.cleanup_stopped_at AS stopped_at
+        "#,
+        owner,
+        project
+    )
+    .fetch_optional(&pool)
+    .await
+    {
+        Ok(Some(record)) => record,
+        Ok(None) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Project does not exist or isn't flagged as inactive".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Err(err) => {
+            tracing::error!(?err, "Can't restore app: Failed to update database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Failed to update database".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    let message = match project_record.stopped_at {
+        Some(_) => format!("Restored by {}", user.username),
+        None => format!("Kept by {}, no longer flagged as inactive", user.username),
+    };
+    if let Err(err) = record_activity(project_record.id, "cleanup", &message, &pool).await {
+        tracing::error!(?err, "Can't record activity: Failed to insert into database");
+    }
+
+    if project_record.stopped_at.is_some() {
+        let container_name = format!("{owner}-{project}").replace('.', "-");
+        if let Err(err) = orchestrator::driver().resume(&container_name).await {
+            tracing::error!(?err, "Can't restore app: Failed to start containers");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "App is restored, but some containers failed to start. Deploy it again".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    }
+
+    with_change(
+        Response::builder()
+            .status(StatusCode::NO_CONTENT)
+            .body(Body::empty())
+            .unwrap(),
+        AuditChange::new(
+            Some(serde_json::json!({
+                "flagged_at": project_record.flagged_at,
+                "stopped_at": project_record.stopped_at,
+            })),
+            None,
+        ),
+    )
+}
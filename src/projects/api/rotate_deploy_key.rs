@@ -0,0 +1,163 @@
+use axum::extract::{State, Path};
+use axum::response::Response;
+use hyper::{Body, StatusCode};
+use serde::Serialize;
+use uuid::Uuid;
+
+use super::create_deploy_key::NewDeployKeyResponse;
+use crate::audit::{with_change, AuditChange};
+use crate::deploy_keys::{generate_key, ssh_remote};
+use crate::{auth::Auth, startup::AppState};
+
+#[derive(Serialize, Debug)]
+struct ErrorResponse {
+    message: String,
+}
+
+/// Replaces a deploy key with a new one of the same name and access. The old key stops
+/// working right away, connections it already made finish what they are doing
+#[tracing::instrument(skip(auth, pool, git_settings))]
+pub async fn post(
+    auth: Auth,
+    State(AppState { pool, domain, git_settings, .. }): State<AppState>,
+    Path((owner, project, key_id)): Path<(String, String, Uuid)>,
+) -> Response<Body> {
+    let _user = auth.current_user.unwrap();
+
+    // check if project exist
+    let project_record = match sqlx::query!(
+        r#"SELECT projects.id AS id
+           FROM projects
+           JOIN project_owners ON projects.owner_id = project_owners.id
+           JOIN users_owners ON project_owners.id = users_owners.owner_id
+           AND projects.name = $1
+           AND project_owners.name = $2
+        "#,
+        project,
+        owner,
+    )
+    .fetch_optional(&pool)
+    .await
+    {
+        Ok(Some(record)) => record,
+        Ok(None) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Project does not exist".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Err(err) => {
+            tracing::error!(?err, "Can't get projects: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    let old = match sqlx::query!(
+        "SELECT name, fingerprint FROM deploy_keys WHERE id = $1 AND project_id = $2",
+        key_id,
+        project_record.id
+    )
+    .fetch_optional(&pool)
+    .await
+    {
+        Ok(Some(record)) => record,
+        Ok(None) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Deploy key does not exist".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Err(err) => {
+            tracing::error!(?err, "Can't get deploy key: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    let name = old.name;
+    let key = generate_key(&format!("{owner}/{project}:{name}"));
+    let rotated = match sqlx::query!(
+        r#"UPDATE deploy_keys SET public_key = $3, fingerprint = $4, rotated_at = now()
+           WHERE id = $1 AND project_id = $2
+           RETURNING read_only, created_at
+        "#,
+        key_id,
+        project_record.id,
+        key.public_key,
+        key.fingerprint
+    )
+    .fetch_optional(&pool)
+    .await
+    {
+        Ok(Some(rotated)) => rotated,
+        Ok(None) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Deploy key does not exist".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Err(err) => {
+            tracing::error!(?err, "Can't rotate deploy key: Failed to update database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Failed to update database".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    let change = AuditChange::new(
+        Some(serde_json::json!({ "name": name, "fingerprint": old.fingerprint })),
+        Some(serde_json::json!({ "name": name, "fingerprint": key.fingerprint })),
+    );
+
+    let json = serde_json::to_string(&NewDeployKeyResponse {
+        id: key_id,
+        name,
+        public_key: key.public_key,
+        fingerprint: key.fingerprint,
+        read_only: rotated.read_only,
+        private_key: key.private_key,
+        remote: (git_settings.sshport != 0).then(|| ssh_remote(&domain, git_settings.sshport, &owner, &project)),
+        created_at: rotated.created_at,
+    }).unwrap();
+
+    with_change(
+        Response::builder()
+            .status(StatusCode::OK)
+            .body(Body::from(json))
+            .unwrap(),
+        change,
+    )
+}
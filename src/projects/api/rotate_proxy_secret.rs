@@ -0,0 +1,121 @@
+use axum::extract::{Path, State};
+use axum::response::Response;
+use chrono::{DateTime, Utc};
+use hyper::{Body, StatusCode};
+use serde::Serialize;
+
+use crate::audit::{with_change, AuditChange};
+use crate::proxy_signature::{generate_secret, ROTATION_GRACE};
+use crate::telemetry::current_context;
+use crate::{auth::Auth, queue::{BuildKind, BuildQueueItem}, startup::AppState};
+
+#[derive(Serialize, Debug)]
+struct ErrorResponse {
+    message: String,
+}
+
+#[derive(Serialize, Debug)]
+pub struct RotateProxySecretResponse {
+    pub rotated_at: DateTime<Utc>,
+    /// until when the proxy still signs with the previous key too
+    pub previous_valid_until: DateTime<Utc>,
+}
+
+/// Gives the app a new key for checking that requests came through the proxy and releases it
+/// again with it. The proxy signs with both keys for a while, so the release has time to go
+/// live. The key before the previous one stops signing right away
+#[tracing::instrument(skip(auth, pool, build_channel))]
+pub async fn post(
+    auth: Auth,
+    State(AppState { pool, base, build_channel, .. }): State<AppState>,
+    Path((owner, project)): Path<(String, String)>,
+) -> Response<Body> {
+    let _user = auth.current_user.unwrap();
+
+    let rotated = match sqlx::query!(
+        r#"UPDATE projects SET proxy_secret_previous = proxy_secret, proxy_secret = $3,
+           proxy_secret_rotated_at = now(), updated_at = now()
+           FROM project_owners
+           WHERE projects.owner_id = project_owners.id
+           AND projects.name = $1
+           AND project_owners.name = $2
+           RETURNING projects.id, projects.proxy_secret_rotated_at AS "rotated_at!"
+        "#,
+        project,
+        owner,
+        generate_secret()
+    )
+    .fetch_optional(&pool)
+    .await
+    {
+        Ok(Some(rotated)) => rotated,
+        Ok(None) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Project does not exist".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Err(err) => {
+            tracing::error!(?err, "Can't rotate proxy secret: Failed to update database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Failed to update database".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    // the running containers only get the key with a new release, projects that were never
+    // deployed pick it up on their first build
+    match sqlx::query!("SELECT id FROM releases WHERE project_id = $1 LIMIT 1", rotated.id)
+        .fetch_optional(&pool)
+        .await
+    {
+        Ok(Some(_)) => {
+            let repo = project.trim_end_matches(".git");
+
+            if let Err(err) = build_channel
+                .send(BuildQueueItem {
+                    container_name: format!("{owner}-{repo}").replace('.', "-"),
+                    container_src: format!("{base}/{owner}/{repo}.git/master"),
+                    owner,
+                    repo: repo.to_string(),
+                    kind: BuildKind::Reconfigure("Rotate proxy secret".to_string()),
+                    trace: current_context(),
+                })
+                .await
+            {
+                tracing::error!(?err, "Can't release proxy secret: Failed to send to build queue");
+            }
+        }
+        Ok(None) => {}
+        Err(err) => {
+            tracing::error!(?err, "Can't release proxy secret: Failed to query database");
+        }
+    }
+
+    let response = RotateProxySecretResponse {
+        rotated_at: rotated.rotated_at,
+        // the grace is an hour, it fits
+        previous_valid_until: rotated.rotated_at + chrono::Duration::from_std(ROTATION_GRACE).unwrap(),
+    };
+    // the keys themselves stay out of the audit log
+    let after = serde_json::json!({ "rotated_at": response.rotated_at });
+    let json = serde_json::to_string(&response).unwrap();
+
+    with_change(
+        Response::builder()
+            .status(StatusCode::OK)
+            .body(Body::from(json))
+            .unwrap(),
+        AuditChange::new(None, Some(after)),
+    )
+}
@@ -0,0 +1,432 @@
+use std::{borrow::Cow, future::Future, pin::Pin, time::Duration};
+
+use axum::extract::ws::{CloseFrame, Message, WebSocket, WebSocketUpgrade};
+use axum::extract::{Path, State};
+use axum::response::{IntoResponse, Response};
+use bollard::container::{
+    AttachContainerResults, KillContainerOptions, LogOutput, ResizeContainerTtyOptions,
+    WaitContainerOptions,
+};
+use bollard::exec::ResizeExecOptions;
+use bollard::Docker;
+use futures_util::stream::{SplitSink, Stream};
+use futures_util::{SinkExt, StreamExt, TryStreamExt};
+use hyper::{Body, StatusCode};
+use serde::{Deserialize, Serialize};
+use tokio::io::{AsyncWrite, AsyncWriteExt};
+use ulid::Ulid;
+use uuid::Uuid;
+
+use crate::configuration::ContainerSettings;
+use crate::nodes;
+use crate::docker::{database_url, remove_once, start_attached, ReleaseConfig};
+use crate::secrets::SecretCipher;
+use crate::{auth::Auth, startup::AppState};
+
+/// how long the client gets to send the start message after connecting
+const START_TIMEOUT: Duration = Duration::from_secs(30);
+
+const STDOUT: u8 = 1;
+const STDERR: u8 = 2;
+
+/// Control messages from the client, sent as text. Binary messages are written to stdin
+/// as they are.
+#[derive(Deserialize, Debug)]
+#[serde(tag = "type", rename_all = "snake_case")]
+enum ClientMessage {
+    /// has to be the first message, starts the command
+    Start {
+        command: Vec<String>,
+        #[serde(default)]
+        tty: bool,
+        width: Option<u16>,
+        height: Option<u16>,
+    },
+    /// the terminal of the client changed size, only used with a tty
+    Resize { width: u16, height: u16 },
+    /// the client won't send more input, closes stdin of the command
+    Eof,
+}
+
+/// Sent as text. Output is sent as binary messages whose first byte is the stream, 1 for
+/// stdout and 2 for stderr. With a tty everything is stdout.
+#[derive(Serialize, Debug)]
+#[serde(tag = "type", rename_all = "snake_case")]
+pub(super) enum ServerMessage {
+    /// last message before the socket closes. exit_code is null when the command got killed
+    Exit { exit_code: Option<i64> },
+    Error { message: String },
+}
+
+#[derive(Serialize, Debug)]
+struct ErrorResponse {
+    message: String,
+}
+
+struct RunTarget {
+    container_name: String,
+    image: String,
+    config: ReleaseConfig,
+    db_url: String,
+}
+
+/// Runs a one-off command in a new container of the live release with the app's environment,
+/// streaming stdin and output over a websocket until it exits.
+#[tracing::instrument(skip(auth, pool, secrets, ws))]
+pub async fn ws(
+    auth: Auth,
+    State(AppState { pool, secrets, container_settings, .. }): State<AppState>,
+    Path((owner, project)): Path<(String, String)>,
+    ws: WebSocketUpgrade,
+) -> Response {
+    let _user = auth.current_user.unwrap();
+
+    // check if project exist
+    let project_record = match sqlx::query!(
+        r#"SELECT projects.id AS id
+           FROM projects
+           JOIN project_owners ON projects.owner_id = project_owners.id
+           JOIN users_owners ON project_owners.id = users_owners.owner_id
+           AND projects.name = $1
+           AND project_owners.name = $2
+        "#,
+        project,
+        owner,
+    )
+    .fetch_optional(&pool)
+    .await
+    {
+        Ok(Some(record)) => record,
+        Ok(None) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Project does not exist".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap()
+                .into_response();
+        }
+        Err(err) => {
+            tracing::error!(?err, "Can't get projects: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap()
+                .into_response();
+        }
+    };
+
+    let release = match sqlx::query!(
+        r#"SELECT image, config FROM releases WHERE project_id = $1 ORDER BY created_at DESC LIMIT 1"#,
+        project_record.id
+    )
+    .fetch_optional(&pool)
+    .await
+    {
+        Ok(Some(release)) => release,
+        Ok(None) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Deploy the project before running commands in it".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap()
+                .into_response();
+        }
+        Err(err) => {
+            tracing::error!(?err, "Can't get releases: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap()
+                .into_response();
+        }
+    };
+
+    let db_url = match database_url(project_record.id, &pool).await {
+        Ok(db_url) => db_url,
+        Err(err) => {
+            tracing::error!(?err, "Can't get addons: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap()
+                .into_response();
+        }
+    };
+
+    let target = RunTarget {
+        container_name: format!("{owner}-{}", project.trim_end_matches(".git")).replace('.', "-"),
+        image: release.image,
+        config: serde_json::from_value(release.config).unwrap_or_default(),
+        db_url,
+    };
+
+    ws.on_upgrade(move |socket| async move {
+        run(socket, target, container_settings, secrets).await;
+    })
+}
+
+async fn run(
+    mut socket: WebSocket,
+    target: RunTarget,
+    container_settings: ContainerSettings,
+    secrets: SecretCipher,
+) {
+    let Some(start) = read_start(&mut socket).await else {
+        return;
+    };
+
+    let run_name = format!("{}-run-{}", target.container_name, Uuid::from(Ulid::new()));
+    tracing::info!(%run_name, command = ?start.command, tty = start.tty, "Running one-off command");
+
+    let AttachContainerResults { output, input } = match start_attached(
+        &target.container_name,
+        &run_name,
+        &target.image,
+        &target.config,
+        &target.db_url,
+        &start.command,
+        start.tty,
+        &container_settings,
+        &secrets,
+    )
+    .await
+    {
+        Ok(attached) => attached,
+        Err(err) => {
+            close(socket, ServerMessage::Error {
+                message: format!("Failed to start command: {err}"),
+            })
+            .await;
+            return;
+        }
+    };
+
+    let docker = match nodes::docker(&run_name) {
+        Ok(docker) => docker,
+        Err(err) => {
+            tracing::error!(?err, "Can't run command: Failed to connect to docker");
+            remove_once(&run_name).await;
+            return;
+        }
+    };
+
+    let deadline = tokio::time::sleep(Duration::from_secs(container_settings.runtimeout));
+    let tty = Tty::Container(&run_name);
+    let (mut sender, killed) = match stream(socket, output, input, &start, &docker, tty, deadline).await {
+        SessionEnd::Exited(sender) => (sender, false),
+        SessionEnd::TimedOut(sender) => {
+            let _ = docker
+                .kill_container(&run_name, None::<KillContainerOptions<&str>>)
+                .await;
+            (sender, true)
+        }
+        SessionEnd::Disconnected => {
+            tracing::info!(%run_name, "Client disconnected, removing one-off container");
+            remove_once(&run_name).await;
+            return;
+        }
+    };
+
+    let exit_code = match docker
+        .wait_container(&run_name, None::<WaitContainerOptions<&str>>)
+        .try_collect::<Vec<_>>()
+        .await
+    {
+        Ok(responses) => responses.last().map(|res| res.status_code),
+        // bollard reports a non zero exit code as an error
+        Err(bollard::errors::Error::DockerContainerWaitError { code, .. }) => Some(code),
+        Err(err) => {
+            tracing::error!(?err, "Can't run command: Failed to wait for container");
+            None
+        }
+    };
+
+    remove_once(&run_name).await;
+
+    if killed {
+        let mut frame = vec![STDERR];
+        frame.extend_from_slice(
+            format!("\nKilled after {} seconds\n", container_settings.runtimeout).as_bytes(),
+        );
+        let _ = sender.send(Message::Binary(frame)).await;
+    }
+
+    let exit_code = if killed { None } else { exit_code };
+    exit(sender, exit_code).await;
+}
+
+pub(super) struct Start {
+    pub command: Vec<String>,
+    pub tty: bool,
+    size: Option<(u16, u16)>,
+}
+
+/// Waits for the start message, answering anything else with an error
+pub(super) async fn read_start(socket: &mut WebSocket) -> Option<Start> {
+    let start = match tokio::time::timeout(START_TIMEOUT, socket.recv()).await {
+        Ok(Some(Ok(Message::Text(text)))) => serde_json::from_str::<ClientMessage>(&text),
+        Ok(None) | Ok(Some(Err(_))) => return None,
+        Ok(Some(Ok(_))) | Err(_) => {
+            send_close(socket, ServerMessage::Error {
+                message: "Expected a start message".to_string(),
+            })
+            .await;
+            return None;
+        }
+    };
+
+    match start {
+        Ok(ClientMessage::Start { command, tty, width, height }) if !command.is_empty() => {
+            Some(Start { command, tty, size: width.zip(height) })
+        }
+        _ => {
+            send_close(socket, ServerMessage::Error {
+                message: "Expected a start message with a command".to_string(),
+            })
+            .await;
+            None
+        }
+    }
+}
+
+/// What a resize message resizes
+pub(super) enum Tty<'a> {
+    Container(&'a str),
+    Exec(&'a str),
+}
+
+pub(super) enum SessionEnd {
+    /// the output ended, so the command exited
+    Exited(SplitSink<WebSocket, Message>),
+    /// the deadline passed while the command was still running
+    TimedOut(SplitSink<WebSocket, Message>),
+    /// the client went away
+    Disconnected,
+}
+
+/// Pumps the websocket until the command's output ends, the client goes away or the
+/// deadline passes
+pub(super) async fn stream(
+    socket: WebSocket,
+    mut output: Pin<Box<dyn Stream<Item = Result<LogOutput, bollard::errors::Error>> + Send>>,
+    mut input: Pin<Box<dyn AsyncWrite + Send>>,
+    start: &Start,
+    docker: &Docker,
+    tty: Tty<'_>,
+    deadline: impl Future<Output = ()>,
+) -> SessionEnd {
+    if let (true, Some((width, height))) = (start.tty, start.size) {
+        resize(docker, &tty, width, height).await;
+    }
+
+    tokio::pin!(deadline);
+    let (mut sender, mut receiver) = socket.split();
+
+    loop {
+        tokio::select! {
+            log = output.next() => {
+                let (stream, message) = match log {
+                    Some(Ok(LogOutput::StdErr { message })) => (STDERR, message),
+                    Some(Ok(LogOutput::StdOut { message })) | Some(Ok(LogOutput::Console { message })) => (STDOUT, message),
+                    Some(Ok(LogOutput::StdIn { .. })) => continue,
+                    Some(Err(err)) => {
+                        tracing::error!(?err, "Can't read command output");
+                        return SessionEnd::Exited(sender);
+                    }
+                    None => return SessionEnd::Exited(sender),
+                };
+
+                let mut frame = Vec::with_capacity(message.len() + 1);
+                frame.push(stream);
+                frame.extend_from_slice(&message);
+
+                if sender.send(Message::Binary(frame)).await.is_err() {
+                    return SessionEnd::Disconnected;
+                }
+            },
+            msg = receiver.next() => match msg {
+                Some(Ok(Message::Binary(data))) => {
+                    // stdin is gone once the command closed it, there's nobody to tell
+                    let _ = input.write_all(&data).await;
+                }
+                Some(Ok(Message::Text(text))) => match serde_json::from_str::<ClientMessage>(&text) {
+                    Ok(ClientMessage::Resize { width, height }) if start.tty => {
+                        resize(docker, &tty, width, height).await;
+                    }
+                    Ok(ClientMessage::Eof) => {
+                        let _ = input.shutdown().await;
+                    }
+                    Ok(_) => {}
+                    Err(err) => tracing::debug!(?err, "Can't parse message"),
+                },
+                Some(Ok(Message::Close(_))) | Some(Err(_)) | None => return SessionEnd::Disconnected,
+                Some(Ok(_)) => {}
+            },
+            _ = &mut deadline => return SessionEnd::TimedOut(sender),
+        }
+    }
+}
+
+async fn resize(docker: &Docker, tty: &Tty<'_>, width: u16, height: u16) {
+    let res = match tty {
+        Tty::Container(name) => {
+            docker
+                .resize_container_tty(name, ResizeContainerTtyOptions { width, height })
+                .await
+        }
+        Tty::Exec(id) => docker.resize_exec(id, ResizeExecOptions { width, height }).await,
+    };
+
+    if let Err(err) = res {
+        tracing::debug!(?err, "Can't resize terminal");
+    }
+}
+
+/// Sends the exit code and closes the socket
+pub(super) async fn exit(mut sender: SplitSink<WebSocket, Message>, exit_code: Option<i64>) {
+    let json = serde_json::to_string(&ServerMessage::Exit { exit_code }).unwrap();
+    let _ = sender.send(Message::Text(json)).await;
+    let _ = sender
+        .send(Message::Close(Some(CloseFrame {
+            code: axum::extract::ws::close_code::NORMAL,
+            reason: Cow::from("Goodbye"),
+        })))
+        .await;
+}
+
+/// Sends `message` and closes the socket, for when the command never started
+pub(super) async fn close(mut socket: WebSocket, message: ServerMessage) {
+    send_close(&mut socket, message).await;
+}
+
+async fn send_close(socket: &mut WebSocket, message: ServerMessage) {
+    let json = serde_json::to_string(&message).unwrap();
+    let _ = socket.send(Message::Text(json)).await;
+    let _ = socket
+        .send(Message::Close(Some(CloseFrame {
+            code: axum::extract::ws::close_code::NORMAL,
+            reason: Cow::from("Goodbye"),
+        })))
+        .await;
+}
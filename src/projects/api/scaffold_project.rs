@@ -0,0 +1,231 @@
+use axum::extract::{State, Path};
+use axum::response::Response;
+use axum::Json;
+use hyper::{Body, StatusCode};
+use serde::{Deserialize, Serialize};
+
+use crate::activity::record_activity;
+use crate::templates::{self, builtin, Source};
+use crate::uploads::Author;
+use crate::telemetry::current_context;
+use crate::{auth::Auth, queue::{BuildKind, BuildQueueItem}, startup::AppState};
+
+#[derive(Deserialize, Debug)]
+pub struct ScaffoldProjectRequest {
+    /// `go-http`, or `owner/name` for a registered one
+    pub template: String,
+}
+
+#[derive(Serialize, Debug)]
+struct ScaffoldProjectResponse {
+    message: String,
+    template: String,
+    commit: String,
+}
+
+#[derive(Serialize, Debug)]
+struct ErrorResponse {
+    message: String,
+}
+
+/// Starts a new app from a template and deploys it. The files of the template become the
+/// first commit of its repository, so it only works before anything was pushed
+#[tracing::instrument(skip(auth, pool, base, domain, build_channel))]
+pub async fn post(
+    auth: Auth,
+    State(AppState { pool, base, domain, build_channel, .. }): State<AppState>,
+    Path((owner, project)): Path<(String, String)>,
+    Json(ScaffoldProjectRequest { template }): Json<ScaffoldProjectRequest>,
+) -> Response<Body> {
+    let user = auth.current_user.unwrap();
+
+    // check if project exist
+    let project_record = match sqlx::query!(
+        r#"SELECT projects.id AS id
+           FROM projects
+           JOIN project_owners ON projects.owner_id = project_owners.id
+           JOIN users_owners ON project_owners.id = users_owners.owner_id
+           AND projects.name = $1
+           AND project_owners.name = $2
+        "#,
+        project,
+        owner,
+    )
+    .fetch_optional(&pool)
+    .await
+    {
+        Ok(Some(record)) => record,
+        Ok(None) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Project does not exist".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Err(err) => {
+            tracing::error!(?err, "Can't get projects: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    let source = match template.split_once('/') {
+        None => builtin(&template).map(Source::Builtin),
+        Some((template_owner, name)) => match sqlx::query!(
+            r#"SELECT source_owners.name AS owner, projects.name AS project
+               FROM templates
+               JOIN project_owners ON templates.owner_id = project_owners.id
+               JOIN projects ON templates.project_id = projects.id
+               JOIN project_owners source_owners ON projects.owner_id = source_owners.id
+               WHERE project_owners.name = $1 AND templates.name = $2
+            "#,
+            template_owner,
+            name,
+        )
+        .fetch_optional(&pool)
+        .await
+        {
+            Ok(source) => source.map(|source| Source::App {
+                repo_path: format!("{base}/{}/{}.git", source.owner, source.project),
+            }),
+            Err(err) => {
+                tracing::error!(?err, "Can't get template: Failed to query database");
+
+                let json = serde_json::to_string(&ErrorResponse {
+                    message: format!("Failed to query database: {}", err.to_string())
+                }).unwrap();
+
+                return Response::builder()
+                    .status(StatusCode::INTERNAL_SERVER_ERROR)
+                    .body(Body::from(json))
+                    .unwrap();
+            }
+        },
+    };
+
+    let source = match source {
+        Some(Source::App { ref repo_path }) if templates::is_empty(repo_path).unwrap_or(true) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Template {template} has no code yet")
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Some(source) => source,
+        None => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Template {template} does not exist, see pmk templates list")
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    let repo = project.trim_end_matches(".git");
+    let repo_path = format!("{base}/{owner}/{repo}.git");
+    let container_src = format!("{repo_path}/master");
+    let container_name = format!("{owner}-{repo}").replace('.', "-");
+
+    match templates::is_empty(&repo_path) {
+        Ok(true) => {}
+        Ok(false) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "The app already has code, templates only start new apps".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::CONFLICT)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Err(err) => {
+            tracing::error!(?err, "Can't scaffold project: Failed to open repository");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to open repository: {err}")
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    }
+
+    let author = Author {
+        name: user.username.clone(),
+        email: format!("{}@{domain}", user.username),
+    };
+    let message = format!("Start from template {template}");
+
+    let commit = match templates::scaffold(source, &repo_path, &container_src, author, message).await {
+        Ok(commit) => commit,
+        Err(err) => {
+            tracing::error!(?err, "Can't scaffold project: Failed to commit template");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: err.to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    let activity = format!("Started from template {template} by {}, deploying", user.username);
+    if let Err(err) = record_activity(project_record.id, "template", &activity, &pool).await {
+        tracing::error!(?err, "Can't record activity: Failed to query database");
+    }
+
+    if let Err(err) = build_channel
+        .send(BuildQueueItem {
+            container_name,
+            container_src,
+            owner,
+            repo: repo.to_string(),
+            kind: BuildKind::Build,
+            trace: current_context(),
+        })
+        .await
+    {
+        tracing::error!(?err, "Can't deploy template: Failed to send to build queue");
+
+        let json = serde_json::to_string(&ErrorResponse {
+            message: "Failed to queue build".to_string()
+        }).unwrap();
+
+        return Response::builder()
+            .status(StatusCode::INTERNAL_SERVER_ERROR)
+            .body(Body::from(json))
+            .unwrap();
+    }
+
+    let json = serde_json::to_string(&ScaffoldProjectResponse {
+        message: "Build queued".to_string(),
+        template,
+        commit,
+    }).unwrap();
+
+    Response::builder()
+        .status(StatusCode::ACCEPTED)
+        .body(Body::from(json))
+        .unwrap()
+}
@@ -0,0 +1,279 @@
+use axum::extract::{State, Path};
+use axum::response::Response;
+use axum::Json;
+use garde::{Unvalidated, Validate};
+use hyper::{Body, StatusCode};
+use serde::{Deserialize, Serialize};
+
+use crate::activity::record_activity;
+use crate::audit::{with_change, AuditChange};
+use crate::docker::{database_url, ReleaseConfig};
+use crate::orchestrator;
+use crate::{auth::Auth, startup::AppState};
+
+#[derive(Deserialize, Validate, Debug)]
+pub struct ScaleProjectProcessRequest {
+    #[garde(length(min=1))]
+    pub name: String,
+    /// 0 stops the process without removing it from the Procfile
+    #[garde(range(min=0, max=10))]
+    pub count: i64,
+}
+
+#[derive(Serialize, Debug)]
+struct ScaleProjectProcessResponse {
+    message: String,
+}
+
+#[derive(Serialize, Debug)]
+struct ErrorResponse {
+    message: String
+}
+
+#[tracing::instrument(skip(auth, pool, secrets))]
+pub async fn post(
+    auth: Auth,
+    State(AppState { pool, secrets, container_settings, .. }): State<AppState>,
+    Path((owner, project)): Path<(String, String)>,
+    Json(req): Json<Unvalidated<ScaleProjectProcessRequest>>
+) -> Response<Body> {
+    let _user = auth.current_user.unwrap();
+
+    let ScaleProjectProcessRequest { name, count } = match req.validate(&()) {
+        Ok(valid) => valid.into_inner(),
+        Err(err) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: err.to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    // check if project exist
+    let project_record = match sqlx::query!(
+        r#"SELECT projects.id AS id
+           FROM projects
+           JOIN project_owners ON projects.owner_id = project_owners.id
+           JOIN users_owners ON project_owners.id = users_owners.owner_id
+           AND projects.name = $1
+           AND project_owners.name = $2
+        "#,
+        project,
+        owner,
+    )
+    .fetch_optional(&pool)
+    .await
+    {
+        Ok(Some(record)) => record,
+        Ok(None) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Project does not exist".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Err(err) => {
+            tracing::error!(?err, "Can't get projects: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    // only processes of the live release can be scaled
+    let release = match sqlx::query!(
+        r#"SELECT image, config FROM releases WHERE project_id = $1 ORDER BY created_at DESC LIMIT 1"#,
+        project_record.id
+    )
+    .fetch_optional(&pool)
+    .await
+    {
+        Ok(Some(release)) => release,
+        Ok(None) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Deploy the project before scaling its processes".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Err(err) => {
+            tracing::error!(?err, "Can't get releases: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    let config: ReleaseConfig = serde_json::from_value(release.config).unwrap_or_default();
+    if name != "web" && !config.workers.contains_key(&name) {
+        let json = serde_json::to_string(&ErrorResponse {
+            message: format!("Process {name} is not a worker in the Procfile"),
+        }).unwrap();
+
+        return Response::builder()
+            .status(StatusCode::BAD_REQUEST)
+            .body(Body::from(json))
+            .unwrap();
+    }
+
+    // the app container is the first web replica, it only goes away with the project
+    if name == "web" && count < 1 {
+        let json = serde_json::to_string(&ErrorResponse {
+            message: "web needs at least one container".to_string()
+        }).unwrap();
+
+        return Response::builder()
+            .status(StatusCode::BAD_REQUEST)
+            .body(Body::from(json))
+            .unwrap();
+    }
+
+    // the autoscaler would undo the change on its next tick
+    match sqlx::query!(
+        "SELECT id FROM autoscalers WHERE project_id = $1 AND process = $2",
+        project_record.id,
+        name
+    )
+    .fetch_optional(&pool)
+    .await
+    {
+        Ok(None) => {}
+        Ok(Some(_)) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Process {name} is autoscaled, remove its autoscaler to scale it by hand")
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Err(err) => {
+            tracing::error!(?err, "Can't get autoscalers: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    }
+
+    // the subquery still sees the formation from before the update
+    let (formation, before) = match sqlx::query!(
+        r#"UPDATE projects
+            SET formation = jsonb_set(projects.formation, $1, $2, true)
+            WHERE id = $3
+            RETURNING formation, (SELECT formation FROM projects WHERE id = $3) AS "before!"
+        "#,
+        &[name.clone()],
+        serde_json::Value::from(count),
+        project_record.id
+    )
+    .fetch_one(&pool)
+    .await {
+        Ok(project) => (
+            serde_json::from_value(project.formation).unwrap_or_default(),
+            project.before.get(&name).map(|count| serde_json::json!({ &name: count })),
+        ),
+        Err(err) => {
+            tracing::error!(
+                ?err,
+                "Can't update project formation: Failed to insert into database"
+            );
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Failed to insert into database".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    let db_url = match database_url(project_record.id, &pool).await {
+        Ok(db_url) => db_url,
+        Err(err) => {
+            tracing::error!(?err, "Can't get addons: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    if let Err(err) = record_activity(
+        project_record.id,
+        "scale",
+        &format!("Scaled {name} to {count}"),
+        &pool,
+    )
+    .await
+    {
+        tracing::error!(?err, "Can't record activity: Failed to query database");
+    }
+
+    // stopping workers and replicas waits for their grace period, don't hold the request for it
+    let container_name = format!("{owner}-{}", project.trim_end_matches(".git")).replace('.', "-");
+    tokio::spawn(async move {
+        if let Err(err) = orchestrator::driver()
+            .run_processes(
+                &container_name,
+                &release.image,
+                &config,
+                &db_url,
+                &formation,
+                false,
+                &container_settings,
+                &secrets,
+            )
+            .await
+        {
+            tracing::error!(?err, "Can't scale process: Failed to run workers");
+        }
+    });
+
+    let json = serde_json::to_string(&ScaleProjectProcessResponse {
+        message: format!("Scaling {name} to {count}"),
+    }).unwrap();
+
+    with_change(
+        Response::builder()
+            .status(StatusCode::ACCEPTED)
+            .body(Body::from(json))
+            .unwrap(),
+        AuditChange::new(before, Some(serde_json::json!({ &name: count }))),
+    )
+}
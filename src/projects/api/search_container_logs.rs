@@ -0,0 +1,234 @@
+use axum::extract::{Path, Query, State};
+use axum::response::Response;
+use chrono::{DateTime, Utc};
+use hyper::{Body, StatusCode};
+use serde::{Deserialize, Serialize};
+
+use crate::{auth::Auth, startup::AppState};
+
+/// most lines one request returns
+const MAX_LIMIT: i64 = 1000;
+
+#[derive(Deserialize, Debug)]
+pub struct ContainerLogQuery {
+    /// rfc 3339, or back from now like `30m`, `2h` or `7d`
+    since: Option<String>,
+    until: Option<String>,
+    /// a regular expression the line matches
+    grep: Option<String>,
+    #[serde(default)]
+    ignore_case: bool,
+    /// web or a worker of the Procfile
+    process: Option<String>,
+    /// stdout or stderr
+    stream: Option<String>,
+    /// the newest lines, 100 by default
+    limit: Option<i64>,
+    /// only lines after this id, for following the log
+    after: Option<i64>,
+    /// only lines before this id, for paging back to older ones
+    before: Option<i64>,
+}
+
+#[derive(Serialize, Debug)]
+struct ContainerLogLine {
+    id: i64,
+    recorded_at: DateTime<Utc>,
+    container: String,
+    process: String,
+    stream: String,
+    level: Option<String>,
+    message: String,
+}
+
+#[derive(Serialize, Debug)]
+struct ContainerLogResponse {
+    /// oldest first
+    lines: Vec<ContainerLogLine>,
+}
+
+#[derive(Serialize, Debug)]
+struct ErrorResponse {
+    message: String,
+}
+
+/// `value` as rfc 3339, or as a duration back from `now` like `90s`, `30m`, `2h` or `7d`
+fn parse_time(value: &str, now: DateTime<Utc>) -> Option<DateTime<Utc>> {
+    if let Ok(time) = DateTime::parse_from_rfc3339(value) {
+        return Some(time.with_timezone(&Utc));
+    }
+
+    let unit = value.chars().last()?;
+    let seconds = match unit {
+        's' => 1,
+        'm' => 60,
+        'h' => 60 * 60,
+        'd' => 24 * 60 * 60,
+        _ => return None,
+    };
+    let amount: i64 = value[..value.len() - 1].parse().ok().filter(|amount| *amount >= 0)?;
+    // a hundred years back is as far as it goes
+    let seconds = amount.checked_mul(seconds).filter(|seconds| *seconds <= 100 * 365 * 24 * 60 * 60)?;
+
+    Some(now - chrono::Duration::seconds(seconds))
+}
+
+/// Searches what the containers of the app wrote, also the ones that crashed or were replaced
+/// since, for as long as the app keeps its logs. The newest matching lines come oldest first
+#[tracing::instrument(skip(auth, pool))]
+pub async fn get(
+    auth: Auth,
+    State(AppState { pool, .. }): State<AppState>,
+    Path((owner, project)): Path<(String, String)>,
+    Query(query): Query<ContainerLogQuery>,
+) -> Response<Body> {
+    let _user = auth.current_user.unwrap();
+
+    let now = Utc::now();
+    let mut times = [None, None];
+    for (time, value) in times.iter_mut().zip([&query.since, &query.until]) {
+        let Some(value) = value else {
+            continue;
+        };
+        match parse_time(value, now) {
+            Some(parsed) => *time = Some(parsed),
+            None => {
+                let json = serde_json::to_string(&ErrorResponse {
+                    message: format!("Invalid time {value}, use rfc 3339 or a duration like 30m, 2h or 7d")
+                }).unwrap();
+
+                return Response::builder()
+                    .status(StatusCode::BAD_REQUEST)
+                    .body(Body::from(json))
+                    .unwrap();
+            }
+        }
+    }
+    let [since, until] = times;
+
+    if let Some(stream) = query.stream.as_deref().filter(|stream| !matches!(*stream, "stdout" | "stderr")) {
+        let json = serde_json::to_string(&ErrorResponse {
+            message: format!("Invalid stream {stream}, expected stdout or stderr")
+        }).unwrap();
+
+        return Response::builder()
+            .status(StatusCode::BAD_REQUEST)
+            .body(Body::from(json))
+            .unwrap();
+    }
+
+    // checked here so a typo is a bad request instead of a failed query
+    if let Some(Err(err)) = query.grep.as_deref().map(regex::Regex::new) {
+        let json = serde_json::to_string(&ErrorResponse {
+            message: format!("Invalid grep pattern: {err}")
+        }).unwrap();
+
+        return Response::builder()
+            .status(StatusCode::BAD_REQUEST)
+            .body(Body::from(json))
+            .unwrap();
+    }
+    let limit = query.limit.unwrap_or(100).clamp(1, MAX_LIMIT);
+
+    // check if project exist
+    let project = match sqlx::query!(
+        r#"SELECT projects.id AS id
+           FROM projects
+           JOIN project_owners ON projects.owner_id = project_owners.id
+           JOIN users_owners ON project_owners.id = users_owners.owner_id
+           AND projects.name = $1
+           AND project_owners.name = $2
+        "#,
+        project,
+        owner,
+    )
+    .fetch_optional(&pool)
+    .await
+    {
+        Ok(Some(record)) => record,
+        Ok(None) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Project does not exist".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Err(err) => {
+            tracing::error!(?err, "Can't get projects: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    let mut lines: Vec<ContainerLogLine> = match sqlx::query!(
+        r#"SELECT id, recorded_at, container, process, stream, level, message
+           FROM container_logs
+           WHERE project_id = $1
+           AND id > $2
+           AND ($3::timestamptz IS NULL OR recorded_at >= $3)
+           AND ($4::timestamptz IS NULL OR recorded_at < $4)
+           AND ($5::text IS NULL OR process = $5)
+           AND ($6::text IS NULL OR stream = $6)
+           AND ($7::text IS NULL OR CASE WHEN $8 THEN message ~* $7 ELSE message ~ $7 END)
+           AND ($10::bigint IS NULL OR id < $10)
+           ORDER BY id DESC
+           LIMIT $9
+        "#,
+        project.id,
+        query.after.unwrap_or(0),
+        since,
+        until,
+        query.process,
+        query.stream,
+        query.grep,
+        query.ignore_case,
+        limit,
+        query.before
+    )
+    .fetch_all(&pool)
+    .await
+    {
+        Ok(lines) => lines
+            .into_iter()
+            .map(|line| ContainerLogLine {
+                id: line.id,
+                recorded_at: line.recorded_at,
+                container: line.container,
+                process: line.process,
+                stream: line.stream,
+                level: line.level,
+                message: line.message,
+            })
+            .collect(),
+        Err(err) => {
+            tracing::error!(?err, "Can't search container logs: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+    lines.reverse();
+
+    let json = serde_json::to_string(&ContainerLogResponse { lines }).unwrap();
+
+    Response::builder()
+        .status(StatusCode::OK)
+        .body(Body::from(json))
+        .unwrap()
+}
@@ -0,0 +1,213 @@
+use axum::extract::{State, Path};
+use axum::response::Response;
+use axum::Json;
+use garde::{Unvalidated, Validate};
+use hyper::{Body, StatusCode};
+use serde::{Deserialize, Serialize};
+use ulid::Ulid;
+use uuid::Uuid;
+
+use super::view_autoscalers::Autoscaler;
+use crate::docker::ReleaseConfig;
+use crate::{auth::Auth, startup::AppState};
+
+#[derive(Deserialize, Validate, Debug)]
+pub struct SetAutoscalerRequest {
+    #[garde(length(min=1))]
+    pub process: String,
+    /// same bounds as scaling by hand
+    #[garde(range(min=0, max=10))]
+    pub min: i32,
+    #[garde(range(min=1, max=10))]
+    pub max: i32,
+    #[garde(pattern("^(cpu|latency)$"))]
+    pub metric: String,
+    /// percent of one cpu per container for cpu, milliseconds of the app p95 for latency
+    #[garde(range(min=1.0, max=60000.0))]
+    pub target: f64,
+}
+
+#[derive(Serialize, Debug)]
+struct ErrorResponse {
+    message: String,
+}
+
+/// Creates or replaces the autoscaler of a process. The autoscaler takes over the
+/// count of the process, within a tick it is scaled toward the target
+#[tracing::instrument(skip(auth, pool))]
+pub async fn post(
+    auth: Auth,
+    State(AppState { pool, .. }): State<AppState>,
+    Path((owner, project)): Path<(String, String)>,
+    Json(req): Json<Unvalidated<SetAutoscalerRequest>>
+) -> Response<Body> {
+    let _user = auth.current_user.unwrap();
+
+    let SetAutoscalerRequest { process, min, max, metric, target } = match req.validate(&()) {
+        Ok(valid) => valid.into_inner(),
+        Err(err) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: err.to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    if min > max {
+        let json = serde_json::to_string(&ErrorResponse {
+            message: "min can't be more than max".to_string()
+        }).unwrap();
+
+        return Response::builder()
+            .status(StatusCode::BAD_REQUEST)
+            .body(Body::from(json))
+            .unwrap();
+    }
+
+    // check if project exist
+    let project_record = match sqlx::query!(
+        r#"SELECT projects.id AS id
+           FROM projects
+           JOIN project_owners ON projects.owner_id = project_owners.id
+           JOIN users_owners ON project_owners.id = users_owners.owner_id
+           AND projects.name = $1
+           AND project_owners.name = $2
+        "#,
+        project,
+        owner,
+    )
+    .fetch_optional(&pool)
+    .await
+    {
+        Ok(Some(record)) => record,
+        Ok(None) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Project does not exist".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Err(err) => {
+            tracing::error!(?err, "Can't get projects: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    // only web and the workers of the live release can be scaled
+    let release = match sqlx::query!(
+        r#"SELECT image, config FROM releases WHERE project_id = $1 ORDER BY created_at DESC LIMIT 1"#,
+        project_record.id
+    )
+    .fetch_optional(&pool)
+    .await
+    {
+        Ok(Some(release)) => release,
+        Ok(None) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Deploy the project before autoscaling its processes".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Err(err) => {
+            tracing::error!(?err, "Can't get releases: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    let config: ReleaseConfig = serde_json::from_value(release.config).unwrap_or_default();
+    if process != "web" && !config.workers.contains_key(&process) {
+        let json = serde_json::to_string(&ErrorResponse {
+            message: format!("Process {process} is not a worker in the Procfile"),
+        }).unwrap();
+
+        return Response::builder()
+            .status(StatusCode::BAD_REQUEST)
+            .body(Body::from(json))
+            .unwrap();
+    }
+
+    if process == "web" && min < 1 {
+        let json = serde_json::to_string(&ErrorResponse {
+            message: "web needs at least one container".to_string()
+        }).unwrap();
+
+        return Response::builder()
+            .status(StatusCode::BAD_REQUEST)
+            .body(Body::from(json))
+            .unwrap();
+    }
+
+    let last_scaled_at = match sqlx::query!(
+        r#"INSERT INTO autoscalers (id, project_id, process, min_count, max_count, metric, target)
+           VALUES ($1, $2, $3, $4, $5, $6, $7)
+           ON CONFLICT (project_id, process) DO UPDATE
+           SET min_count = $4, max_count = $5, metric = $6, target = $7
+           RETURNING last_scaled_at
+        "#,
+        Uuid::from(Ulid::new()),
+        project_record.id,
+        process,
+        min,
+        max,
+        metric,
+        target
+    )
+    .fetch_one(&pool)
+    .await
+    {
+        Ok(record) => record.last_scaled_at,
+        Err(err) => {
+            tracing::error!(?err, "Can't set autoscaler: Failed to insert into database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Failed to insert into database".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    let json = serde_json::to_string(&Autoscaler {
+        process,
+        min,
+        max,
+        metric,
+        target,
+        last_scaled_at,
+    }).unwrap();
+
+    Response::builder()
+        .status(StatusCode::OK)
+        .body(Body::from(json))
+        .unwrap()
+}
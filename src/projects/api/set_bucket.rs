@@ -0,0 +1,190 @@
+use axum::extract::{State, Path};
+use axum::response::Response;
+use axum::Json;
+use garde::{Unvalidated, Validate};
+use hyper::{Body, StatusCode};
+use serde::{Deserialize, Serialize};
+
+use crate::audit::{with_change, AuditChange};
+use crate::storage::{check_quota, check_rules, configure_bucket, BucketUrl, LifecycleRule};
+use crate::{auth::Auth, startup::AppState};
+
+#[derive(Deserialize, Validate, Debug)]
+pub struct SetBucketRequest {
+    /// in MiB, at most the maxquota of the platform
+    #[garde(skip)]
+    pub quota: i32,
+    /// replace the rules the bucket has
+    #[garde(custom(rules_check))]
+    pub lifecycle: Vec<LifecycleRule>,
+}
+
+#[derive(Serialize, Debug)]
+struct ErrorResponse {
+    message: String
+}
+
+fn rules_check(value: &Vec<LifecycleRule>, _ctx: &()) -> garde::Result {
+    match check_rules(value) {
+        Some(message) => Err(garde::Error::new(message)),
+        None => Ok(()),
+    }
+}
+
+/// Changes the quota and the lifecycle rules of the bucket of the app. They apply to the
+/// bucket right away, the app isn't released again
+#[tracing::instrument(skip(auth, pool, req))]
+pub async fn post(
+    auth: Auth,
+    State(AppState { pool, .. }): State<AppState>,
+    Path((owner, project)): Path<(String, String)>,
+    Json(req): Json<Unvalidated<SetBucketRequest>>
+) -> Response<Body> {
+    let _user = auth.current_user.unwrap();
+
+    let SetBucketRequest { quota, lifecycle } = match req.validate(&()) {
+        Ok(valid) => valid.into_inner(),
+        Err(err) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: err.to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    match check_quota(quota) {
+        Ok(None) => {}
+        Ok(Some(message)) => {
+            let json = serde_json::to_string(&ErrorResponse { message }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Err(err) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: err.to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    }
+
+    let addon = match sqlx::query!(
+        r#"SELECT addons.id, addons.url, addons.quota, addons.lifecycle
+           FROM addons
+           JOIN projects ON addons.project_id = projects.id
+           JOIN project_owners ON projects.owner_id = project_owners.id
+           WHERE projects.name = $1
+           AND project_owners.name = $2
+           AND addons.kind = 's3'
+        "#,
+        project,
+        owner,
+    )
+    .fetch_optional(&pool)
+    .await
+    {
+        Ok(Some(addon)) => addon,
+        Ok(None) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Project does not have a bucket".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::NOT_FOUND)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Err(err) => {
+            tracing::error!(?err, "Can't set bucket: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    let bucket = match BucketUrl::parse(&addon.url) {
+        Ok(url) => url.bucket,
+        Err(err) => {
+            tracing::error!(?err, "Can't set bucket: Invalid addon url");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Failed to read bucket".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    // the bucket first, the stored settings would be wrong otherwise
+    let container_name = format!("{owner}-{}", project.trim_end_matches(".git")).replace('.', "-");
+    if let Err(err) = configure_bucket(&container_name, &bucket, quota, &lifecycle).await {
+        tracing::error!(?err, "Can't set bucket: Failed to configure storage");
+
+        let json = serde_json::to_string(&ErrorResponse {
+            message: format!("Failed to configure bucket: {err}")
+        }).unwrap();
+
+        return Response::builder()
+            .status(StatusCode::INTERNAL_SERVER_ERROR)
+            .body(Body::from(json))
+            .unwrap();
+    }
+
+    let rules = serde_json::to_value(&lifecycle).unwrap();
+    if let Err(err) = sqlx::query!(
+        "UPDATE addons SET quota = $1, lifecycle = $2 WHERE id = $3",
+        quota,
+        rules,
+        addon.id
+    )
+    .execute(&pool)
+    .await
+    {
+        tracing::error!(?err, "Can't set bucket: Failed to update database");
+
+        let json = serde_json::to_string(&ErrorResponse {
+            message: "Failed to update database".to_string()
+        }).unwrap();
+
+        return Response::builder()
+            .status(StatusCode::INTERNAL_SERVER_ERROR)
+            .body(Body::from(json))
+            .unwrap();
+    }
+
+    let before = serde_json::json!({
+        "quota": addon.quota,
+        "lifecycle": addon.lifecycle,
+    });
+    let after = serde_json::json!({
+        "quota": quota,
+        "lifecycle": rules,
+    });
+
+    with_change(
+        Response::builder()
+            .status(StatusCode::NO_CONTENT)
+            .body(Body::empty())
+            .unwrap(),
+        AuditChange::new(Some(before), Some(after)),
+    )
+}
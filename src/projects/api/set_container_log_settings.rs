@@ -0,0 +1,159 @@
+use axum::extract::{State, Path};
+use axum::response::Response;
+use axum::Json;
+use garde::{Unvalidated, Validate};
+use hyper::{Body, StatusCode};
+use serde::{Deserialize, Serialize};
+
+use crate::audit::{with_change, AuditChange};
+use crate::{auth::Auth, startup::AppState};
+
+#[derive(Deserialize, Validate, Debug)]
+pub struct SetContainerLogSettingsRequest {
+    /// days the output is kept, None for the default of the platform
+    #[garde(range(min=1))]
+    pub retention: Option<i32>,
+    /// MiB of output kept, the oldest lines go first past it. None for the default of the
+    /// platform
+    #[garde(range(min=1))]
+    pub size: Option<i32>,
+}
+
+#[derive(Serialize, Debug)]
+struct ErrorResponse {
+    message: String
+}
+
+/// Changes for how long and how much of the output of its containers the app keeps
+#[tracing::instrument(skip(auth, pool, container_settings, req))]
+pub async fn post(
+    auth: Auth,
+    State(AppState { pool, container_settings, .. }): State<AppState>,
+    Path((owner, project)): Path<(String, String)>,
+    Json(req): Json<Unvalidated<SetContainerLogSettingsRequest>>
+) -> Response<Body> {
+    let _user = auth.current_user.unwrap();
+
+    let SetContainerLogSettingsRequest { retention, size } = match req.validate(&()) {
+        Ok(valid) => valid.into_inner(),
+        Err(err) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: err.to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    if retention.is_some_and(|days| days > container_settings.containerlogmaxretention) {
+        let json = serde_json::to_string(&ErrorResponse {
+            message: format!(
+                "Container logs can be kept for at most {} days",
+                container_settings.containerlogmaxretention
+            )
+        }).unwrap();
+
+        return Response::builder()
+            .status(StatusCode::BAD_REQUEST)
+            .body(Body::from(json))
+            .unwrap();
+    }
+
+    if size.is_some_and(|size| size > container_settings.containerlogmaxsize) {
+        let json = serde_json::to_string(&ErrorResponse {
+            message: format!(
+                "An app can keep at most {} MiB of container logs",
+                container_settings.containerlogmaxsize
+            )
+        }).unwrap();
+
+        return Response::builder()
+            .status(StatusCode::BAD_REQUEST)
+            .body(Body::from(json))
+            .unwrap();
+    }
+
+    // check if project exist
+    let project = match sqlx::query!(
+        r#"SELECT projects.id AS id, projects.container_log_retention, projects.container_log_size
+           FROM projects
+           JOIN project_owners ON projects.owner_id = project_owners.id
+           JOIN users_owners ON project_owners.id = users_owners.owner_id
+           AND projects.name = $1
+           AND project_owners.name = $2
+        "#,
+        project,
+        owner,
+    )
+    .fetch_optional(&pool)
+    .await
+    {
+        Ok(Some(record)) => record,
+        Ok(None) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Project does not exist".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Err(err) => {
+            tracing::error!(?err, "Can't get projects: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    if let Err(err) = sqlx::query!(
+        r#"UPDATE projects
+            SET container_log_retention = $1, container_log_size = $2, updated_at = now()
+            WHERE id = $3
+        "#,
+        retention,
+        size,
+        project.id
+    )
+    .execute(&pool)
+    .await
+    {
+        tracing::error!(?err, "Can't set container log settings: Failed to update database");
+
+        let json = serde_json::to_string(&ErrorResponse {
+            message: "Failed to update database".to_string()
+        }).unwrap();
+
+        return Response::builder()
+            .status(StatusCode::INTERNAL_SERVER_ERROR)
+            .body(Body::from(json))
+            .unwrap();
+    }
+
+    let before = serde_json::json!({
+        "retention": project.container_log_retention,
+        "size": project.container_log_size,
+    });
+    let after = serde_json::json!({
+        "retention": retention,
+        "size": size,
+    });
+
+    with_change(
+        Response::builder()
+            .status(StatusCode::NO_CONTENT)
+            .body(Body::empty())
+            .unwrap(),
+        AuditChange::new(Some(before), Some(after)),
+    )
+}
@@ -0,0 +1,191 @@
+use axum::extract::{State, Path};
+use axum::response::Response;
+use axum::Json;
+use garde::{Unvalidated, Validate};
+use hyper::{Body, StatusCode};
+use serde::{Deserialize, Serialize};
+
+use crate::audit::{with_change, AuditChange};
+use crate::cors::{header_check, method_check, origin_check};
+use crate::{auth::Auth, startup::AppState};
+
+#[derive(Deserialize, Validate, Debug)]
+pub struct SetCorsRequest {
+    /// like `https://web.example.com`, `https://*.example.com` or `*`. Empty leaves cors to
+    /// the app
+    #[garde(length(max = 100), custom(origins_check))]
+    pub origins: Vec<String>,
+    /// empty allows GET, HEAD and POST
+    #[serde(default)]
+    #[garde(length(max = 20), custom(methods_check))]
+    pub methods: Vec<String>,
+    /// request headers besides the safelisted ones, `*` for any
+    #[serde(default)]
+    #[garde(length(max = 100), custom(headers_check))]
+    pub headers: Vec<String>,
+    /// let the browser send cookies and logins along
+    #[serde(default)]
+    #[garde(skip)]
+    pub credentials: bool,
+    /// seconds browsers keep a preflight
+    #[garde(range(min=0, max=86400))]
+    pub max_age: Option<i32>,
+}
+
+#[derive(Serialize, Debug)]
+struct ErrorResponse {
+    message: String
+}
+
+fn origins_check(value: &Vec<String>, _ctx: &()) -> garde::Result {
+    match value.iter().find_map(|origin| origin_check(origin).err()) {
+        Some(err) => Err(garde::Error::new(err)),
+        None => Ok(()),
+    }
+}
+
+fn methods_check(value: &Vec<String>, _ctx: &()) -> garde::Result {
+    match value.iter().find_map(|method| method_check(method).err()) {
+        Some(err) => Err(garde::Error::new(err)),
+        None => Ok(()),
+    }
+}
+
+fn headers_check(value: &Vec<String>, _ctx: &()) -> garde::Result {
+    match value.iter().find_map(|header| header_check(header).err()) {
+        Some(err) => Err(garde::Error::new(err)),
+        None => Ok(()),
+    }
+}
+
+/// the values how the proxy compares them, without duplicates
+fn normalize(values: Vec<String>, case: fn(&str) -> String) -> Vec<String> {
+    let mut normalized: Vec<String> = Vec::with_capacity(values.len());
+    for value in values {
+        let value = case(value.trim().trim_end_matches('/'));
+        if !normalized.contains(&value) {
+            normalized.push(value);
+        }
+    }
+    normalized
+}
+
+/// Replaces the cors policy the proxy enforces for the app
+#[tracing::instrument(skip(auth, pool, req))]
+pub async fn post(
+    auth: Auth,
+    State(AppState { pool, .. }): State<AppState>,
+    Path((owner, project)): Path<(String, String)>,
+    Json(req): Json<Unvalidated<SetCorsRequest>>
+) -> Response<Body> {
+    let _user = auth.current_user.unwrap();
+
+    let SetCorsRequest { origins, methods, headers, credentials, max_age } = match req.validate(&()) {
+        Ok(valid) => valid.into_inner(),
+        Err(err) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: err.to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+    let origins = normalize(origins, str::to_ascii_lowercase);
+    let methods = normalize(methods, str::to_ascii_uppercase);
+    let headers = normalize(headers, str::to_ascii_lowercase);
+
+    // check if project exist
+    let project = match sqlx::query!(
+        r#"SELECT projects.id AS id, projects.cors_origins, projects.cors_methods, projects.cors_headers,
+           projects.cors_credentials, projects.cors_max_age
+           FROM projects
+           JOIN project_owners ON projects.owner_id = project_owners.id
+           JOIN users_owners ON project_owners.id = users_owners.owner_id
+           AND projects.name = $1
+           AND project_owners.name = $2
+        "#,
+        project,
+        owner,
+    )
+    .fetch_optional(&pool)
+    .await
+    {
+        Ok(Some(record)) => record,
+        Ok(None) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Project does not exist".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Err(err) => {
+            tracing::error!(?err, "Can't get projects: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    if let Err(err) = sqlx::query!(
+        r#"UPDATE projects
+            SET cors_origins = $1, cors_methods = $2, cors_headers = $3, cors_credentials = $4,
+            cors_max_age = $5, updated_at = now()
+            WHERE id = $6
+        "#,
+        &origins,
+        &methods,
+        &headers,
+        credentials,
+        max_age,
+        project.id
+    )
+    .execute(&pool)
+    .await
+    {
+        tracing::error!(?err, "Can't set cors: Failed to update database");
+
+        let json = serde_json::to_string(&ErrorResponse {
+            message: "Failed to update database".to_string()
+        }).unwrap();
+
+        return Response::builder()
+            .status(StatusCode::INTERNAL_SERVER_ERROR)
+            .body(Body::from(json))
+            .unwrap();
+    }
+
+    let before = serde_json::json!({
+        "origins": project.cors_origins,
+        "methods": project.cors_methods,
+        "headers": project.cors_headers,
+        "credentials": project.cors_credentials,
+        "max_age": project.cors_max_age,
+    });
+    let after = serde_json::json!({
+        "origins": origins,
+        "methods": methods,
+        "headers": headers,
+        "credentials": credentials,
+        "max_age": max_age,
+    });
+
+    with_change(
+        Response::builder()
+            .status(StatusCode::NO_CONTENT)
+            .body(Body::empty())
+            .unwrap(),
+        AuditChange::new(Some(before), Some(after)),
+    )
+}
@@ -0,0 +1,179 @@
+use axum::extract::{State, Path};
+use axum::response::Response;
+use axum::Json;
+use garde::{Unvalidated, Validate};
+use hyper::{Body, StatusCode};
+use serde::{Deserialize, Serialize};
+
+use crate::audit::{with_change, AuditChange};
+use crate::egress::{apply_egress, check_destination, Policy};
+use crate::ip_access::Cidr;
+use crate::{auth::Auth, startup::AppState};
+
+#[derive(Deserialize, Validate, Debug)]
+pub struct SetEgressRequest {
+    /// allow, campus or deny, None for the default of the platform
+    #[garde(custom(policy_check))]
+    pub policy: Option<String>,
+    /// ranges like `10.0.0.0/8`, addresses or hostnames the containers reach besides what
+    /// the policy allows
+    #[garde(length(max = 100), custom(destinations_check))]
+    pub allow: Vec<String>,
+}
+
+#[derive(Serialize, Debug)]
+struct ErrorResponse {
+    message: String
+}
+
+fn policy_check(value: &Option<String>, _ctx: &()) -> garde::Result {
+    match value.as_deref().map(str::parse::<Policy>) {
+        Some(Err(err)) => Err(garde::Error::new(err)),
+        _ => Ok(()),
+    }
+}
+
+fn destinations_check(value: &Vec<String>, _ctx: &()) -> garde::Result {
+    match value.iter().find_map(|destination| check_destination(destination.trim()).err()) {
+        Some(err) => Err(garde::Error::new(err)),
+        None => Ok(()),
+    }
+}
+
+/// destinations how the rules match them, `10.1.2.3/8` is stored as `10.0.0.0/8`
+fn normalize(destinations: Vec<String>) -> Vec<String> {
+    let mut normalized: Vec<String> = Vec::with_capacity(destinations.len());
+    for destination in destinations {
+        let destination = match destination.trim().parse::<Cidr>() {
+            Ok(range) => range.to_string(),
+            Err(_) => destination.trim().to_lowercase(),
+        };
+        if !normalized.contains(&destination) {
+            normalized.push(destination);
+        }
+    }
+    normalized
+}
+
+/// Changes where the containers of the app may connect to. The rules are applied right
+/// away, running containers included
+#[tracing::instrument(skip(auth, pool, container_settings, req))]
+pub async fn post(
+    auth: Auth,
+    State(AppState { pool, container_settings, .. }): State<AppState>,
+    Path((owner, project)): Path<(String, String)>,
+    Json(req): Json<Unvalidated<SetEgressRequest>>
+) -> Response<Body> {
+    let _user = auth.current_user.unwrap();
+
+    let SetEgressRequest { policy, allow } = match req.validate(&()) {
+        Ok(valid) => valid.into_inner(),
+        Err(err) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: err.to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+    let allow = normalize(allow);
+
+    // check if project exist
+    let record = match sqlx::query!(
+        r#"SELECT projects.id AS id, projects.egress_policy, projects.egress_allow
+           FROM projects
+           JOIN project_owners ON projects.owner_id = project_owners.id
+           JOIN users_owners ON project_owners.id = users_owners.owner_id
+           AND projects.name = $1
+           AND project_owners.name = $2
+        "#,
+        project,
+        owner,
+    )
+    .fetch_optional(&pool)
+    .await
+    {
+        Ok(Some(record)) => record,
+        Ok(None) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Project does not exist".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Err(err) => {
+            tracing::error!(?err, "Can't get projects: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    if let Err(err) = sqlx::query!(
+        "UPDATE projects SET egress_policy = $1, egress_allow = $2, updated_at = now() WHERE id = $3",
+        policy,
+        &allow,
+        record.id
+    )
+    .execute(&pool)
+    .await
+    {
+        tracing::error!(?err, "Can't set egress policy: Failed to update database");
+
+        let json = serde_json::to_string(&ErrorResponse {
+            message: "Failed to update database".to_string()
+        }).unwrap();
+
+        return Response::builder()
+            .status(StatusCode::INTERNAL_SERVER_ERROR)
+            .body(Body::from(json))
+            .unwrap();
+    }
+
+    let before = serde_json::json!({
+        "policy": record.egress_policy,
+        "allow": record.egress_allow,
+    });
+    let after = serde_json::json!({
+        "policy": policy,
+        "allow": allow,
+    });
+
+    let container_name = format!("{owner}-{}", project.trim_end_matches(".git")).replace('.', "-");
+    if let Err(err) = apply_egress(record.id, &container_name, &container_settings, &pool).await {
+        tracing::error!(?err, "Can't set egress policy: Failed to apply rules");
+
+        // it is saved, the enforcer keeps trying
+        let json = serde_json::to_string(&ErrorResponse {
+            message: format!("The policy is saved but its rules couldn't be applied yet, they are retried every few minutes: {err}")
+        }).unwrap();
+
+        return with_change(
+            Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap(),
+            AuditChange::new(Some(before), Some(after)),
+        );
+    }
+
+    with_change(
+        Response::builder()
+            .status(StatusCode::NO_CONTENT)
+            .body(Body::empty())
+            .unwrap(),
+        AuditChange::new(Some(before), Some(after)),
+    )
+}
@@ -0,0 +1,151 @@
+use axum::extract::{State, Path};
+use axum::response::Response;
+use axum::Json;
+use garde::{Unvalidated, Validate};
+use hyper::{Body, StatusCode};
+use serde::{Deserialize, Serialize};
+use url::Url;
+
+use crate::audit::{with_change, AuditChange};
+use crate::{auth::Auth, startup::AppState};
+
+#[derive(Deserialize, Validate, Debug)]
+pub struct SetErrorPageRequest {
+    /// served with the 502, 503 or 504 of the proxy, `{{status}}` and `{{correlation_id}}` are
+    /// filled in
+    #[garde(length(min=1, max=65536))]
+    pub html: Option<String>,
+    /// visitors are sent there instead
+    #[garde(length(max=2048), custom(redirect_check))]
+    pub redirect: Option<String>,
+}
+
+#[derive(Serialize, Debug)]
+struct ErrorResponse {
+    message: String
+}
+
+fn redirect_check(value: &Option<String>, _ctx: &()) -> garde::Result {
+    match value.as_deref().map(Url::parse) {
+        Some(Ok(url)) if url.scheme() != "http" && url.scheme() != "https" => {
+            Err(garde::Error::new("Redirect must be an http or https url"))
+        }
+        Some(Err(err)) => Err(garde::Error::new(format!("Redirect must be a url: {err}"))),
+        _ => Ok(()),
+    }
+}
+
+/// Sets what visitors get when the proxy can't reach the app, the errors of the app itself
+/// are passed on
+#[tracing::instrument(skip(auth, pool, req))]
+pub async fn post(
+    auth: Auth,
+    State(AppState { pool, .. }): State<AppState>,
+    Path((owner, project)): Path<(String, String)>,
+    Json(req): Json<Unvalidated<SetErrorPageRequest>>
+) -> Response<Body> {
+    let _user = auth.current_user.unwrap();
+
+    let SetErrorPageRequest { html, redirect } = match req.validate(&()) {
+        Ok(valid) => valid.into_inner(),
+        Err(err) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: err.to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+    if html.is_some() == redirect.is_some() {
+        let json = serde_json::to_string(&ErrorResponse {
+            message: "Give either html or a redirect".to_string()
+        }).unwrap();
+
+        return Response::builder()
+            .status(StatusCode::BAD_REQUEST)
+            .body(Body::from(json))
+            .unwrap();
+    }
+
+    // check if project exist
+    let project = match sqlx::query!(
+        r#"SELECT projects.id AS id, projects.error_page, projects.error_redirect
+           FROM projects
+           JOIN project_owners ON projects.owner_id = project_owners.id
+           JOIN users_owners ON project_owners.id = users_owners.owner_id
+           AND projects.name = $1
+           AND project_owners.name = $2
+        "#,
+        project,
+        owner,
+    )
+    .fetch_optional(&pool)
+    .await
+    {
+        Ok(Some(record)) => record,
+        Ok(None) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Project does not exist".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Err(err) => {
+            tracing::error!(?err, "Can't get projects: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    if let Err(err) = sqlx::query!(
+        "UPDATE projects SET error_page = $1, error_redirect = $2, updated_at = now() WHERE id = $3",
+        html,
+        redirect,
+        project.id
+    )
+    .execute(&pool)
+    .await
+    {
+        tracing::error!(?err, "Can't set error page: Failed to update database");
+
+        let json = serde_json::to_string(&ErrorResponse {
+            message: "Failed to update database".to_string()
+        }).unwrap();
+
+        return Response::builder()
+            .status(StatusCode::INTERNAL_SERVER_ERROR)
+            .body(Body::from(json))
+            .unwrap();
+    }
+
+    // pages can be long, the audit log keeps which kind it was and where redirects go
+    let before = serde_json::json!({
+        "html": project.error_page.is_some(),
+        "redirect": project.error_redirect,
+    });
+    let after = serde_json::json!({
+        "html": html.is_some(),
+        "redirect": redirect,
+    });
+
+    with_change(
+        Response::builder()
+            .status(StatusCode::NO_CONTENT)
+            .body(Body::empty())
+            .unwrap(),
+        AuditChange::new(Some(before), Some(after)),
+    )
+}
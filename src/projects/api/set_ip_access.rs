@@ -0,0 +1,149 @@
+use axum::extract::{State, Path};
+use axum::response::Response;
+use axum::Json;
+use garde::{Unvalidated, Validate};
+use hyper::{Body, StatusCode};
+use serde::{Deserialize, Serialize};
+
+use crate::audit::{with_change, AuditChange};
+use crate::ip_access::Cidr;
+use crate::{auth::Auth, startup::AppState};
+
+#[derive(Deserialize, Validate, Debug)]
+pub struct SetIpAccessRequest {
+    /// ranges like `10.0.0.0/8` or single addresses, only they get through once there is one
+    #[garde(length(max = 100), custom(ranges_check))]
+    pub allow: Vec<String>,
+    /// ranges that never get through, even when they are allowed
+    #[garde(length(max = 100), custom(ranges_check))]
+    pub deny: Vec<String>,
+}
+
+#[derive(Serialize, Debug)]
+struct ErrorResponse {
+    message: String
+}
+
+fn ranges_check(value: &Vec<String>, _ctx: &()) -> garde::Result {
+    match value.iter().find_map(|range| range.parse::<Cidr>().err()) {
+        Some(err) => Err(garde::Error::new(err)),
+        None => Ok(()),
+    }
+}
+
+/// ranges how the proxy matches them, `10.1.2.3/8` is stored as `10.0.0.0/8`
+fn normalize(ranges: Vec<String>) -> Vec<String> {
+    let mut normalized: Vec<String> = Vec::with_capacity(ranges.len());
+    for range in ranges {
+        let range = range.parse::<Cidr>().unwrap().to_string();
+        if !normalized.contains(&range) {
+            normalized.push(range);
+        }
+    }
+    normalized
+}
+
+/// Replaces the ranges of client addresses the proxy lets through to the app and the ones it
+/// answers 403
+#[tracing::instrument(skip(auth, pool, req))]
+pub async fn post(
+    auth: Auth,
+    State(AppState { pool, .. }): State<AppState>,
+    Path((owner, project)): Path<(String, String)>,
+    Json(req): Json<Unvalidated<SetIpAccessRequest>>
+) -> Response<Body> {
+    let _user = auth.current_user.unwrap();
+
+    let SetIpAccessRequest { allow, deny } = match req.validate(&()) {
+        Ok(valid) => valid.into_inner(),
+        Err(err) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: err.to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+    let (allow, deny) = (normalize(allow), normalize(deny));
+
+    // check if project exist
+    let project = match sqlx::query!(
+        r#"SELECT projects.id AS id, projects.allowed_ips, projects.denied_ips
+           FROM projects
+           JOIN project_owners ON projects.owner_id = project_owners.id
+           JOIN users_owners ON project_owners.id = users_owners.owner_id
+           AND projects.name = $1
+           AND project_owners.name = $2
+        "#,
+        project,
+        owner,
+    )
+    .fetch_optional(&pool)
+    .await
+    {
+        Ok(Some(record)) => record,
+        Ok(None) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Project does not exist".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Err(err) => {
+            tracing::error!(?err, "Can't get projects: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    if let Err(err) = sqlx::query!(
+        "UPDATE projects SET allowed_ips = $1, denied_ips = $2, updated_at = now() WHERE id = $3",
+        &allow,
+        &deny,
+        project.id
+    )
+    .execute(&pool)
+    .await
+    {
+        tracing::error!(?err, "Can't set ip access: Failed to update database");
+
+        let json = serde_json::to_string(&ErrorResponse {
+            message: "Failed to update database".to_string()
+        }).unwrap();
+
+        return Response::builder()
+            .status(StatusCode::INTERNAL_SERVER_ERROR)
+            .body(Body::from(json))
+            .unwrap();
+    }
+
+    let before = serde_json::json!({
+        "allow": project.allowed_ips,
+        "deny": project.denied_ips,
+    });
+    let after = serde_json::json!({
+        "allow": allow,
+        "deny": deny,
+    });
+
+    with_change(
+        Response::builder()
+            .status(StatusCode::NO_CONTENT)
+            .body(Body::empty())
+            .unwrap(),
+        AuditChange::new(Some(before), Some(after)),
+    )
+}
@@ -0,0 +1,149 @@
+use axum::extract::{State, Path};
+use axum::response::Response;
+use axum::Json;
+use garde::{Unvalidated, Validate};
+use hyper::{Body, StatusCode};
+use serde::{Deserialize, Serialize};
+
+use crate::audit::{with_change, AuditChange};
+use crate::labels::{check_key, check_value, from_json, Labels, MAX_LABELS};
+use crate::{auth::Auth, startup::AppState};
+
+#[derive(Deserialize, Validate, Debug)]
+pub struct SetLabelsRequest {
+    /// labels added, or changed when the app has them
+    #[serde(default)]
+    #[garde(custom(labels_check))]
+    set: Labels,
+    /// keys of the labels taken off
+    #[serde(default)]
+    #[garde(length(max = 32))]
+    remove: Vec<String>,
+}
+
+#[derive(Serialize, Debug)]
+struct LabelsResponse {
+    labels: Labels,
+}
+
+#[derive(Serialize, Debug)]
+struct ErrorResponse {
+    message: String,
+}
+
+fn labels_check(value: &Labels, _ctx: &()) -> garde::Result {
+    match value.iter().find_map(|(key, value)| check_key(key).or_else(|| check_value(key, value))) {
+        Some(err) => Err(garde::Error::new(err)),
+        None => Ok(()),
+    }
+}
+
+/// Sets and removes labels of the app, the others stay as they are
+#[tracing::instrument(skip(auth, pool))]
+pub async fn post(
+    auth: Auth,
+    State(AppState { pool, .. }): State<AppState>,
+    Path((owner, project)): Path<(String, String)>,
+    Json(req): Json<Unvalidated<SetLabelsRequest>>,
+) -> Response<Body> {
+    let _user = auth.current_user.unwrap();
+
+    let SetLabelsRequest { set, remove } = match req.validate(&()) {
+        Ok(valid) => valid.into_inner(),
+        Err(err) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: err.to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    let record = match sqlx::query!(
+        r#"SELECT projects.id, projects.labels
+           FROM projects
+           JOIN project_owners ON projects.owner_id = project_owners.id
+           WHERE projects.name = $1 AND project_owners.name = $2
+        "#,
+        project,
+        owner,
+    )
+    .fetch_optional(&pool)
+    .await
+    {
+        Ok(Some(record)) => record,
+        Ok(None) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Project does not exist".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Err(err) => {
+            tracing::error!(?err, "Can't get labels: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    let before = from_json(record.labels);
+    let mut labels = before.clone();
+    for key in &remove {
+        labels.remove(key);
+    }
+    labels.extend(set);
+    if labels.len() > MAX_LABELS {
+        let json = serde_json::to_string(&ErrorResponse {
+            message: format!("An app has at most {MAX_LABELS} labels, remove some first")
+        }).unwrap();
+
+        return Response::builder()
+            .status(StatusCode::BAD_REQUEST)
+            .body(Body::from(json))
+            .unwrap();
+    }
+
+    if let Err(err) = sqlx::query!(
+        "UPDATE projects SET labels = $1, updated_at = now() WHERE id = $2",
+        serde_json::json!(labels),
+        record.id
+    )
+    .execute(&pool)
+    .await
+    {
+        tracing::error!(?err, "Can't set labels: Failed to update database");
+
+        let json = serde_json::to_string(&ErrorResponse {
+            message: "Failed to update database".to_string()
+        }).unwrap();
+
+        return Response::builder()
+            .status(StatusCode::INTERNAL_SERVER_ERROR)
+            .body(Body::from(json))
+            .unwrap();
+    }
+
+    let change = AuditChange::new(Some(serde_json::json!(before)), Some(serde_json::json!(labels)));
+    let json = serde_json::to_string(&LabelsResponse { labels }).unwrap();
+
+    with_change(
+        Response::builder()
+            .status(StatusCode::OK)
+            .body(Body::from(json))
+            .unwrap(),
+        change,
+    )
+}
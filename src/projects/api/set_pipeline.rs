@@ -0,0 +1,249 @@
+use axum::extract::{State, Path};
+use axum::response::Response;
+use axum::Json;
+use garde::{Unvalidated, Validate};
+use hyper::{Body, StatusCode};
+use serde::{Deserialize, Serialize};
+
+use crate::audit::{with_change, AuditChange};
+use crate::owner::{member_role, Role};
+use crate::pipelines::check_next;
+use crate::{auth::Auth, startup::AppState};
+
+#[derive(Deserialize, Validate, Debug)]
+pub struct SetPipelineRequest {
+    /// the stage after this app like `owner/production`, None makes it the last one
+    #[garde(custom(next_check))]
+    pub next: Option<String>,
+    /// releases promoted to this app wait for another member to approve them
+    #[garde(skip)]
+    pub promotion_approval: bool,
+}
+
+#[derive(Serialize, Debug)]
+struct ErrorResponse {
+    message: String,
+}
+
+fn next_check(value: &Option<String>, _ctx: &()) -> garde::Result {
+    match value.as_deref().map(|app| app.split_once('/')) {
+        None => Ok(()),
+        Some(Some((owner, project))) if !owner.is_empty() && !project.is_empty() && !project.contains('/') => Ok(()),
+        Some(_) => Err(garde::Error::new("must be owner/project")),
+    }
+}
+
+/// Sets the stage after the app and whether promotions to it need an approval. Only owners
+/// of the app may, the approval would be no check if whoever it holds up could turn it off.
+/// The user has to be a maintainer of the next stage too
+#[tracing::instrument(skip(auth, pool))]
+pub async fn post(
+    auth: Auth,
+    State(AppState { pool, .. }): State<AppState>,
+    Path((owner, project)): Path<(String, String)>,
+    Json(req): Json<Unvalidated<SetPipelineRequest>>
+) -> Response<Body> {
+    let user = auth.current_user.unwrap();
+
+    let SetPipelineRequest { next, promotion_approval } = match req.validate(&()) {
+        Ok(valid) => valid.into_inner(),
+        Err(err) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: err.to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    // check if project exist
+    let project_record = match sqlx::query!(
+        r#"SELECT projects.id AS id, projects.pipeline_next_id, projects.promotion_approval
+           FROM projects
+           JOIN project_owners ON projects.owner_id = project_owners.id
+           JOIN users_owners ON project_owners.id = users_owners.owner_id
+           AND projects.name = $1
+           AND project_owners.name = $2
+        "#,
+        project,
+        owner,
+    )
+    .fetch_optional(&pool)
+    .await
+    {
+        Ok(Some(record)) => record,
+        Ok(None) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Project does not exist".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Err(err) => {
+            tracing::error!(?err, "Can't get projects: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    let next_id = match next.as_deref().and_then(|app| app.split_once('/')) {
+        None => None,
+        Some((next_owner, next_project)) => {
+            let next_project = next_project.trim_end_matches(".git");
+            let app = format!("{next_owner}/{next_project}");
+
+            // promoting runs releases there, like pmk releases promote
+            match member_role(user.id, next_owner, &pool).await {
+                Ok(Some(role)) if role >= Role::Maintainer => {}
+                Ok(Some(role)) => {
+                    let json = serde_json::to_string(&ErrorResponse {
+                        message: format!("Only a maintainer of {next_owner} can deploy {app}, you are a {role}")
+                    }).unwrap();
+
+                    return Response::builder()
+                        .status(StatusCode::FORBIDDEN)
+                        .body(Body::from(json))
+                        .unwrap();
+                }
+                Ok(None) => {
+                    let json = serde_json::to_string(&ErrorResponse {
+                        message: format!("Project {app} does not exist")
+                    }).unwrap();
+
+                    return Response::builder()
+                        .status(StatusCode::BAD_REQUEST)
+                        .body(Body::from(json))
+                        .unwrap();
+                }
+                Err(err) => {
+                    tracing::error!(?err, "Can't get users_owners: Failed to query database");
+
+                    let json = serde_json::to_string(&ErrorResponse {
+                        message: format!("Failed to query database: {}", err.to_string())
+                    }).unwrap();
+
+                    return Response::builder()
+                        .status(StatusCode::INTERNAL_SERVER_ERROR)
+                        .body(Body::from(json))
+                        .unwrap();
+                }
+            }
+
+            let next_record = match sqlx::query!(
+                r#"SELECT projects.id
+                   FROM projects
+                   JOIN project_owners ON projects.owner_id = project_owners.id
+                   WHERE project_owners.name = $1
+                   AND projects.name = $2
+                   AND projects.deleted_at IS NULL
+                "#,
+                next_owner,
+                next_project
+            )
+            .fetch_optional(&pool)
+            .await
+            {
+                Ok(Some(record)) => record,
+                Ok(None) => {
+                    let json = serde_json::to_string(&ErrorResponse {
+                        message: format!("Project {app} does not exist")
+                    }).unwrap();
+
+                    return Response::builder()
+                        .status(StatusCode::BAD_REQUEST)
+                        .body(Body::from(json))
+                        .unwrap();
+                }
+                Err(err) => {
+                    tracing::error!(?err, "Can't get projects: Failed to query database");
+
+                    let json = serde_json::to_string(&ErrorResponse {
+                        message: format!("Failed to query database: {}", err.to_string())
+                    }).unwrap();
+
+                    return Response::builder()
+                        .status(StatusCode::INTERNAL_SERVER_ERROR)
+                        .body(Body::from(json))
+                        .unwrap();
+                }
+            };
+
+            match check_next(project_record.id, next_record.id, &pool).await {
+                Ok(None) => {}
+                Ok(Some(message)) => {
+                    let json = serde_json::to_string(&ErrorResponse { message }).unwrap();
+
+                    return Response::builder()
+                        .status(StatusCode::BAD_REQUEST)
+                        .body(Body::from(json))
+                        .unwrap();
+                }
+                Err(err) => {
+                    tracing::error!(?err, "Can't check pipeline: Failed to query database");
+
+                    let json = serde_json::to_string(&ErrorResponse {
+                        message: format!("Failed to query database: {err}")
+                    }).unwrap();
+
+                    return Response::builder()
+                        .status(StatusCode::INTERNAL_SERVER_ERROR)
+                        .body(Body::from(json))
+                        .unwrap();
+                }
+            }
+
+            Some(next_record.id)
+        }
+    };
+
+    if let Err(err) = sqlx::query!(
+        "UPDATE projects SET pipeline_next_id = $1, promotion_approval = $2, updated_at = now() WHERE id = $3",
+        next_id,
+        promotion_approval,
+        project_record.id
+    )
+    .execute(&pool)
+    .await
+    {
+        tracing::error!(?err, "Can't set pipeline: Failed to update database");
+
+        let json = serde_json::to_string(&ErrorResponse {
+            message: "Failed to update database".to_string()
+        }).unwrap();
+
+        return Response::builder()
+            .status(StatusCode::INTERNAL_SERVER_ERROR)
+            .body(Body::from(json))
+            .unwrap();
+    }
+
+    let before = serde_json::json!({
+        "next_id": project_record.pipeline_next_id,
+        "promotion_approval": project_record.promotion_approval,
+    });
+    let after = serde_json::json!({
+        "next_id": next_id,
+        "promotion_approval": promotion_approval,
+    });
+
+    with_change(
+        Response::builder()
+            .status(StatusCode::NO_CONTENT)
+            .body(Body::empty())
+            .unwrap(),
+        AuditChange::new(Some(before), Some(after)),
+    )
+}
@@ -0,0 +1,162 @@
+use std::collections::HashMap;
+
+use axum::extract::{State, Path};
+use axum::response::Response;
+use axum::Json;
+use garde::{Unvalidated, Validate};
+use hyper::{Body, StatusCode};
+use serde::{Deserialize, Serialize};
+
+use crate::audit::{with_change, AuditChange};
+use crate::previews::remove_preview;
+use crate::{auth::Auth, startup::AppState};
+
+#[derive(Deserialize, Validate, Debug)]
+pub struct SetPreviewsRequest {
+    /// whether pushes to branches besides the default one deploy previews
+    #[garde(skip)]
+    pub enabled: bool,
+    /// variables previews get on top of the ones of the app, like a database of their own
+    #[serde(default)]
+    #[garde(length(max = 100), custom(keys_check))]
+    pub env: HashMap<String, String>,
+}
+
+#[derive(Serialize, Debug)]
+struct ErrorResponse {
+    message: String
+}
+
+fn keys_check(value: &HashMap<String, String>, _ctx: &()) -> garde::Result {
+    match value.keys().find(|key| key.is_empty() || key.contains('=')) {
+        Some(key) => Err(garde::Error::new(format!("{key:?} is not a variable name"))),
+        None => Ok(()),
+    }
+}
+
+/// Turns previews of branches on or off and replaces the variables they get. Turning them off
+/// tears down the previews that are running
+#[tracing::instrument(skip(auth, pool, req))]
+pub async fn post(
+    auth: Auth,
+    State(AppState { pool, .. }): State<AppState>,
+    Path((owner, project)): Path<(String, String)>,
+    Json(req): Json<Unvalidated<SetPreviewsRequest>>
+) -> Response<Body> {
+    let _user = auth.current_user.unwrap();
+
+    let SetPreviewsRequest { enabled, env } = match req.validate(&()) {
+        Ok(valid) => valid.into_inner(),
+        Err(err) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: err.to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    // check if project exist
+    let project = match sqlx::query!(
+        r#"SELECT projects.id AS id, projects.previews, projects.preview_environs
+           FROM projects
+           JOIN project_owners ON projects.owner_id = project_owners.id
+           JOIN users_owners ON project_owners.id = users_owners.owner_id
+           AND projects.name = $1
+           AND project_owners.name = $2
+        "#,
+        project,
+        owner,
+    )
+    .fetch_optional(&pool)
+    .await
+    {
+        Ok(Some(record)) => record,
+        Ok(None) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Project does not exist".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Err(err) => {
+            tracing::error!(?err, "Can't get projects: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    let environs = serde_json::to_value(&env).unwrap();
+    if let Err(err) = sqlx::query!(
+        "UPDATE projects SET previews = $1, preview_environs = $2, updated_at = now() WHERE id = $3",
+        enabled,
+        environs,
+        project.id
+    )
+    .execute(&pool)
+    .await
+    {
+        tracing::error!(?err, "Can't set previews: Failed to update database");
+
+        let json = serde_json::to_string(&ErrorResponse {
+            message: "Failed to update database".to_string()
+        }).unwrap();
+
+        return Response::builder()
+            .status(StatusCode::INTERNAL_SERVER_ERROR)
+            .body(Body::from(json))
+            .unwrap();
+    }
+
+    if !enabled {
+        let project_id = project.id;
+        let pool = pool.clone();
+        tokio::spawn(async move {
+            let previews = match sqlx::query!("SELECT name FROM previews WHERE project_id = $1", project_id)
+                .fetch_all(&pool)
+                .await
+            {
+                Ok(previews) => previews,
+                Err(err) => {
+                    tracing::error!(?err, "Can't remove previews: Failed to query database");
+                    return;
+                }
+            };
+            for preview in previews {
+                if let Err(err) = remove_preview(project_id, &preview.name, "previews were turned off", &pool).await {
+                    tracing::error!(?err, preview = preview.name, "Can't remove preview");
+                }
+            }
+        });
+    }
+
+    let before = serde_json::json!({
+        "enabled": project.previews,
+        "env": project.preview_environs,
+    });
+    let after = serde_json::json!({
+        "enabled": enabled,
+        "env": environs,
+    });
+
+    with_change(
+        Response::builder()
+            .status(StatusCode::NO_CONTENT)
+            .body(Body::empty())
+            .unwrap(),
+        AuditChange::new(Some(before), Some(after)),
+    )
+}
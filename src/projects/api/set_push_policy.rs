@@ -0,0 +1,148 @@
+use axum::extract::{State, Path};
+use axum::response::Response;
+use axum::Json;
+use garde::{Unvalidated, Validate};
+use hyper::{Body, StatusCode};
+use serde::{Deserialize, Serialize};
+
+use crate::audit::{with_change, AuditChange};
+use crate::push_policy::branch_pattern_check;
+use crate::{auth::Auth, startup::AppState};
+
+#[derive(Deserialize, Validate, Debug)]
+pub struct SetPushPolicyRequest {
+    /// branches besides the default one that may be pushed, like `feature/*`. Empty allows
+    /// any
+    #[serde(default)]
+    #[garde(length(max = 50), custom(branches_check))]
+    pub branches: Vec<String>,
+    /// in MiB, None leaves it to the platform
+    #[garde(range(min=1, max=10240))]
+    pub max_size: Option<i32>,
+    #[serde(default)]
+    #[garde(skip)]
+    pub scan_secrets: bool,
+}
+
+#[derive(Serialize, Debug)]
+struct ErrorResponse {
+    message: String
+}
+
+fn branches_check(value: &Vec<String>, _ctx: &()) -> garde::Result {
+    match value.iter().find_map(|branch| branch_pattern_check(branch).err()) {
+        Some(err) => Err(garde::Error::new(err)),
+        None => Ok(()),
+    }
+}
+
+/// Replaces what pushes to the app have to pass before git takes them
+#[tracing::instrument(skip(auth, pool, req))]
+pub async fn post(
+    auth: Auth,
+    State(AppState { pool, .. }): State<AppState>,
+    Path((owner, project)): Path<(String, String)>,
+    Json(req): Json<Unvalidated<SetPushPolicyRequest>>
+) -> Response<Body> {
+    let _user = auth.current_user.unwrap();
+
+    let SetPushPolicyRequest { mut branches, max_size, scan_secrets } = match req.validate(&()) {
+        Ok(valid) => valid.into_inner(),
+        Err(err) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: err.to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+    branches.sort();
+    branches.dedup();
+
+    // check if project exist
+    let project = match sqlx::query!(
+        r#"SELECT projects.id AS id, projects.push_branches, projects.push_max_size, projects.push_secret_scan
+           FROM projects
+           JOIN project_owners ON projects.owner_id = project_owners.id
+           JOIN users_owners ON project_owners.id = users_owners.owner_id
+           AND projects.name = $1
+           AND project_owners.name = $2
+        "#,
+        project,
+        owner,
+    )
+    .fetch_optional(&pool)
+    .await
+    {
+        Ok(Some(record)) => record,
+        Ok(None) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Project does not exist".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Err(err) => {
+            tracing::error!(?err, "Can't get projects: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    if let Err(err) = sqlx::query!(
+        r#"UPDATE projects
+            SET push_branches = $1, push_max_size = $2, push_secret_scan = $3, updated_at = now()
+            WHERE id = $4
+        "#,
+        &branches,
+        max_size,
+        scan_secrets,
+        project.id
+    )
+    .execute(&pool)
+    .await
+    {
+        tracing::error!(?err, "Can't set push policy: Failed to update database");
+
+        let json = serde_json::to_string(&ErrorResponse {
+            message: "Failed to update database".to_string()
+        }).unwrap();
+
+        return Response::builder()
+            .status(StatusCode::INTERNAL_SERVER_ERROR)
+            .body(Body::from(json))
+            .unwrap();
+    }
+
+    let before = serde_json::json!({
+        "branches": project.push_branches,
+        "max_size": project.push_max_size,
+        "scan_secrets": project.push_secret_scan,
+    });
+    let after = serde_json::json!({
+        "branches": branches,
+        "max_size": max_size,
+        "scan_secrets": scan_secrets,
+    });
+
+    with_change(
+        Response::builder()
+            .status(StatusCode::NO_CONTENT)
+            .body(Body::empty())
+            .unwrap(),
+        AuditChange::new(Some(before), Some(after)),
+    )
+}
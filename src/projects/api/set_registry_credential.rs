@@ -0,0 +1,137 @@
+use axum::extract::{State, Path};
+use axum::response::Response;
+use axum::Json;
+use garde::{Unvalidated, Validate};
+use hyper::{Body, StatusCode};
+use serde::{Deserialize, Serialize};
+
+use crate::{auth::Auth, startup::AppState};
+
+#[derive(Deserialize, Validate, Debug)]
+pub struct SetRegistryCredentialRequest {
+    /// host as it appears in image references, docker.io for docker hub
+    #[garde(length(min = 1, max = 253), pattern("^[A-Za-z0-9.-]+(:[0-9]+)?$"))]
+    pub registry: String,
+    #[garde(length(min = 1))]
+    pub username: String,
+    /// password or access token, stored encrypted
+    #[garde(length(min = 1))]
+    pub password: String,
+}
+
+#[derive(Serialize, Debug)]
+struct ErrorResponse {
+    message: String,
+}
+
+/// Stores the login images of the project are pulled from `registry` with, replacing an
+/// earlier one
+#[tracing::instrument(skip(auth, pool, secrets, req))]
+pub async fn post(
+    auth: Auth,
+    State(AppState { pool, secrets, .. }): State<AppState>,
+    Path((owner, project)): Path<(String, String)>,
+    Json(req): Json<Unvalidated<SetRegistryCredentialRequest>>
+) -> Response<Body> {
+    let _user = auth.current_user.unwrap();
+
+    let SetRegistryCredentialRequest { registry, username, password } = match req.validate(&()) {
+        Ok(valid) => valid.into_inner(),
+        Err(err) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: err.to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    // check if project exist
+    let project_record = match sqlx::query!(
+        r#"SELECT projects.id AS id
+           FROM projects
+           JOIN project_owners ON projects.owner_id = project_owners.id
+           JOIN users_owners ON project_owners.id = users_owners.owner_id
+           AND projects.name = $1
+           AND project_owners.name = $2
+        "#,
+        project,
+        owner,
+    )
+    .fetch_optional(&pool)
+    .await
+    {
+        Ok(Some(record)) => record,
+        Ok(None) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Project does not exist".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Err(err) => {
+            tracing::error!(?err, "Can't get projects: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    let password = match secrets.encrypt(&password) {
+        Ok(password) => password,
+        Err(err) => {
+            tracing::error!(?err, "Can't set registry credentials: Failed to encrypt password");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Secrets are not enabled on this server".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    if let Err(err) = sqlx::query!(
+        r#"INSERT INTO registry_credentials (project_id, registry, username, password)
+           VALUES ($1, $2, $3, $4)
+           ON CONFLICT (project_id, registry) DO UPDATE SET username = $3, password = $4
+        "#,
+        project_record.id,
+        registry,
+        username,
+        password,
+    )
+    .execute(&pool)
+    .await
+    {
+        tracing::error!(?err, "Can't set registry credentials: Failed to query database");
+
+        let json = serde_json::to_string(&ErrorResponse {
+            message: format!("Failed to query database: {}", err.to_string())
+        }).unwrap();
+
+        return Response::builder()
+            .status(StatusCode::INTERNAL_SERVER_ERROR)
+            .body(Body::from(json))
+            .unwrap();
+    }
+
+    Response::builder()
+        .status(StatusCode::NO_CONTENT)
+        .body(Body::empty())
+        .unwrap()
+}
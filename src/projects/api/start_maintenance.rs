@@ -0,0 +1,145 @@
+use axum::extract::{State, Path};
+use axum::response::Response;
+use axum::Json;
+use garde::{Unvalidated, Validate};
+use hyper::{Body, StatusCode};
+use serde::{Deserialize, Serialize};
+
+use crate::activity::record_activity;
+use crate::audit::{with_change, AuditChange};
+use crate::{auth::Auth, startup::AppState};
+
+#[derive(Deserialize, Validate, Debug)]
+pub struct StartMaintenanceRequest {
+    /// html served instead of the app, missing serves the default page
+    #[garde(length(min=1, max=65536))]
+    pub page: Option<String>,
+    /// seconds visitors are told to come back after, missing is 300
+    #[garde(range(min=1, max=86400))]
+    pub retry_after: Option<i32>,
+}
+
+#[derive(Serialize, Debug)]
+struct ErrorResponse {
+    message: String
+}
+
+/// Makes the proxy answer 503 with the maintenance page instead of forwarding to the app.
+/// The containers keep running, so releases, one-off runs and shells still reach them
+#[tracing::instrument(skip(auth, pool, req))]
+pub async fn post(
+    auth: Auth,
+    State(AppState { pool, .. }): State<AppState>,
+    Path((owner, project)): Path<(String, String)>,
+    Json(req): Json<Unvalidated<StartMaintenanceRequest>>
+) -> Response<Body> {
+    let _user = auth.current_user.unwrap();
+
+    let StartMaintenanceRequest { page, retry_after } = match req.validate(&()) {
+        Ok(valid) => valid.into_inner(),
+        Err(err) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: err.to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+    let retry_after = retry_after.unwrap_or(300);
+
+    // check if project exist
+    let project = match sqlx::query!(
+        r#"SELECT projects.id AS id, projects.maintenance_at, projects.maintenance_page,
+           projects.maintenance_retry_after
+           FROM projects
+           JOIN project_owners ON projects.owner_id = project_owners.id
+           JOIN users_owners ON project_owners.id = users_owners.owner_id
+           AND projects.name = $1
+           AND project_owners.name = $2
+        "#,
+        project,
+        owner,
+    )
+    .fetch_optional(&pool)
+    .await
+    {
+        Ok(Some(record)) => record,
+        Ok(None) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Project does not exist".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Err(err) => {
+            tracing::error!(?err, "Can't get projects: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    // turning it on again changes the page, the maintenance keeps its start
+    if let Err(err) = sqlx::query!(
+        r#"UPDATE projects
+           SET maintenance_at = COALESCE(maintenance_at, now()), maintenance_page = $1,
+           maintenance_retry_after = $2, updated_at = now()
+           WHERE id = $3
+        "#,
+        page,
+        retry_after,
+        project.id
+    )
+    .execute(&pool)
+    .await
+    {
+        tracing::error!(?err, "Can't start maintenance: Failed to update database");
+
+        let json = serde_json::to_string(&ErrorResponse {
+            message: "Failed to update database".to_string()
+        }).unwrap();
+
+        return Response::builder()
+            .status(StatusCode::INTERNAL_SERVER_ERROR)
+            .body(Body::from(json))
+            .unwrap();
+    }
+
+    if project.maintenance_at.is_none() {
+        let message = "Started maintenance, the proxy serves the maintenance page";
+        if let Err(err) = record_activity(project.id, "maintenance", message, &pool).await {
+            tracing::error!(?err, "Can't record activity: Failed to query database");
+        }
+    }
+
+    let before = serde_json::json!({
+        "enabled": project.maintenance_at.is_some(),
+        "page": project.maintenance_page.is_some().then_some("custom"),
+        "retry_after": project.maintenance_retry_after,
+    });
+    let after = serde_json::json!({
+        "enabled": true,
+        "page": page.is_some().then_some("custom"),
+        "retry_after": retry_after,
+    });
+
+    with_change(
+        Response::builder()
+            .status(StatusCode::NO_CONTENT)
+            .body(Body::empty())
+            .unwrap(),
+        AuditChange::new(Some(before), Some(after)),
+    )
+}
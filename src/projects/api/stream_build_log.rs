@@ -0,0 +1,240 @@
+use std::convert::Infallible;
+use std::time::Duration;
+
+use axum::extract::{Path, Query, State};
+use axum::response::sse::{Event, KeepAlive, Sse};
+use axum::response::{IntoResponse, Response};
+use futures::stream::{self, Stream};
+use hyper::{Body, HeaderMap, StatusCode};
+use serde::{Deserialize, Serialize};
+use sqlx::PgPool;
+use uuid::Uuid;
+
+use super::view_build_log::BuildState;
+use crate::live::{self, Subscription, Update};
+use crate::{auth::Auth, startup::AppState};
+
+/// the log is read again this often without an update, writes nobody publishes like the
+/// reconciler failing a lost build show up this late
+const READ_INTERVAL: Duration = Duration::from_secs(10);
+
+#[derive(Deserialize, Debug)]
+pub struct StreamBuildLogQuery {
+    /// byte offset into the log to resume from
+    offset: Option<usize>,
+}
+
+#[derive(Serialize, Debug)]
+struct BuildLogChunk {
+    /// byte offset of the end of this chunk, send it back to resume
+    offset: usize,
+    log: String,
+    /// the log was rewritten and this chunk replaces everything sent so far
+    reset: bool,
+}
+
+#[derive(Serialize, Debug)]
+struct BuildLogDone {
+    status: BuildState,
+}
+
+#[derive(Serialize, Debug)]
+struct ErrorResponse {
+    message: String,
+}
+
+struct LogCursor {
+    pool: PgPool,
+    build_id: Uuid,
+    project_id: Uuid,
+    offset: usize,
+    subscription: Subscription,
+    /// the build row has to be read, the updates don't tell what is missing
+    read: bool,
+    done: bool,
+}
+
+#[tracing::instrument(skip(auth, pool))]
+pub async fn get(
+    auth: Auth,
+    State(AppState { pool, .. }): State<AppState>,
+    Path((owner, project, build_id)): Path<(String, String, Uuid)>,
+    Query(query): Query<StreamBuildLogQuery>,
+    headers: HeaderMap,
+) -> Response {
+    let _user = auth.current_user.unwrap();
+
+    // check if project exist
+    let project_record = match sqlx::query!(
+        r#"SELECT projects.id AS id
+           FROM projects
+           JOIN project_owners ON projects.owner_id = project_owners.id
+           JOIN users_owners ON project_owners.id = users_owners.owner_id
+           AND projects.name = $1
+           AND project_owners.name = $2
+        "#,
+        project,
+        owner,
+    )
+    .fetch_optional(&pool)
+    .await
+    {
+        Ok(Some(record)) => record,
+        Ok(None) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Project does not exist".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap()
+                .into_response();
+        }
+        Err(err) => {
+            tracing::error!(?err, "Can't get projects: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap()
+                .into_response();
+        }
+    };
+
+    // EventSource sends the last seen id on reconnect, which is the offset
+    let offset = headers
+        .get("Last-Event-ID")
+        .and_then(|id| id.to_str().ok())
+        .and_then(|id| id.parse::<usize>().ok())
+        .or(query.offset)
+        .unwrap_or(0);
+
+    // subscribed before the first read, so nothing is appended between the two
+    let cursor = LogCursor {
+        pool,
+        build_id,
+        project_id: project_record.id,
+        offset,
+        subscription: live::subscribe(project_record.id),
+        read: true,
+        done: false,
+    };
+
+    Sse::new(log_stream(cursor))
+        .keep_alive(KeepAlive::default())
+        .into_response()
+}
+
+/// Reads the build row once and then passes on what the build appends as it is published,
+/// ending with a `done` event once the build has finished. The row is read again when an
+/// update doesn't follow on from what was sent, the build finished or updates were skipped
+fn log_stream(cursor: LogCursor) -> impl Stream<Item = Result<Event, Infallible>> {
+    stream::unfold(cursor, |mut cursor| async move {
+        if cursor.done {
+            return None;
+        }
+
+        loop {
+            if cursor.read {
+                cursor.read = false;
+                if let Some(event) = read_build(&mut cursor).await {
+                    return Some((Ok(event), cursor));
+                }
+                continue;
+            }
+
+            let update = tokio::select! {
+                update = cursor.subscription.recv() => update,
+                _ = tokio::time::sleep(READ_INTERVAL) => None,
+            };
+            match update {
+                Some(Update::BuildLog { build_id, offset, log }) if build_id == cursor.build_id => {
+                    // already sent
+                    if offset <= cursor.offset {
+                        continue;
+                    }
+                    if offset - log.len() != cursor.offset {
+                        cursor.read = true;
+                        continue;
+                    }
+
+                    cursor.offset = offset;
+                    let event = Event::default()
+                        .event("log")
+                        .id(cursor.offset.to_string())
+                        .json_data(BuildLogChunk { offset: cursor.offset, log, reset: false })
+                        .unwrap();
+                    return Some((Ok(event), cursor));
+                }
+                Some(Update::Build { build_id, status }) if build_id == cursor.build_id => {
+                    cursor.read = matches!(status, "SUCCESSFUL" | "FAILED" | "CANCELLED");
+                }
+                Some(_) => {}
+                None => cursor.read = true,
+            }
+        }
+    })
+}
+
+/// Whatever the log has past the offset, or `done` once there is nothing left and the build
+/// finished. None when the build is still going and everything was sent
+async fn read_build(cursor: &mut LogCursor) -> Option<Event> {
+    let build = match sqlx::query!(
+        r#"SELECT status AS "status: BuildState", log
+           FROM builds
+           WHERE id = $1 AND project_id = $2
+        "#,
+        cursor.build_id,
+        cursor.project_id,
+    )
+    .fetch_optional(&cursor.pool)
+    .await
+    {
+        Ok(Some(build)) => build,
+        Ok(None) => {
+            cursor.done = true;
+            return Some(Event::default().event("error").data("Build does not exist"));
+        }
+        Err(err) => {
+            tracing::error!(?err, "Can't stream build log: Failed to query database");
+            return None;
+        }
+    };
+
+    // the log only shrinks when a failed build replaces it with the error
+    let reset = cursor.offset > build.log.len();
+    let start = if reset { 0 } else { cursor.offset };
+
+    let finished = matches!(build.status, BuildState::SUCCESSFUL | BuildState::FAILED | BuildState::CANCELLED);
+    if start < build.log.len() {
+        let log = String::from_utf8_lossy(&build.log.as_bytes()[start..]).into_owned();
+        cursor.offset = build.log.len();
+        // the next round sends done
+        cursor.read = finished;
+
+        return Some(
+            Event::default()
+                .event("log")
+                .id(cursor.offset.to_string())
+                .json_data(BuildLogChunk { offset: cursor.offset, log, reset })
+                .unwrap(),
+        );
+    }
+
+    if finished {
+        cursor.done = true;
+        return Some(
+            Event::default()
+                .event("done")
+                .json_data(BuildLogDone { status: build.status })
+                .unwrap(),
+        );
+    }
+
+    None
+}
@@ -0,0 +1,112 @@
+use std::convert::Infallible;
+
+use axum::extract::{Path, Query, State};
+use axum::response::sse::{Event, KeepAlive, Sse};
+use axum::response::{IntoResponse, Response};
+use futures::stream::{self, Stream};
+use hyper::{Body, StatusCode};
+use serde::{Deserialize, Serialize};
+
+use crate::live::{self, Subscription, Topic};
+use crate::{auth::Auth, startup::AppState};
+
+#[derive(Deserialize, Debug)]
+pub struct StreamLiveUpdatesQuery {
+    /// comma separated topics, all of them when left out
+    events: Option<String>,
+}
+
+#[derive(Serialize, Debug)]
+struct ErrorResponse {
+    message: String,
+}
+
+#[tracing::instrument(skip(auth, pool))]
+pub async fn get(
+    auth: Auth,
+    State(AppState { pool, .. }): State<AppState>,
+    Path((owner, project)): Path<(String, String)>,
+    Query(query): Query<StreamLiveUpdatesQuery>,
+) -> Response {
+    let _user = auth.current_user.unwrap();
+
+    let topics = match query.events.as_deref().map(str::trim) {
+        None | Some("") => Ok(Topic::ALL.to_vec()),
+        Some(events) => events.split(',').map(|event| event.trim().parse::<Topic>()).collect(),
+    };
+    let topics = match topics {
+        Ok(topics) => topics,
+        Err(message) => {
+            let json = serde_json::to_string(&ErrorResponse { message }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap()
+                .into_response();
+        }
+    };
+
+    let project = match sqlx::query!(
+        r#"SELECT projects.id
+           FROM projects
+           JOIN project_owners ON projects.owner_id = project_owners.id
+           WHERE projects.name = $1 AND project_owners.name = $2 AND projects.deleted_at IS NULL
+        "#,
+        project,
+        owner,
+    )
+    .fetch_optional(&pool)
+    .await
+    {
+        Ok(Some(record)) => record,
+        Ok(None) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Project does not exist".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap()
+                .into_response();
+        }
+        Err(err) => {
+            tracing::error!(?err, "Can't get projects: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap()
+                .into_response();
+        }
+    };
+
+    Sse::new(update_stream(live::subscribe(project.id), topics))
+        .keep_alive(KeepAlive::default())
+        .into_response()
+}
+
+/// Every update of the topics as an event named after its topic. A subscriber that fell
+/// behind gets `resync` and reads what it shows again
+fn update_stream(subscription: Subscription, topics: Vec<Topic>) -> impl Stream<Item = Result<Event, Infallible>> {
+    stream::unfold(subscription, move |mut subscription| {
+        let topics = topics.clone();
+        async move {
+            loop {
+                let event = match subscription.recv().await {
+                    Some(update) if topics.contains(&update.topic()) => {
+                        Event::default().event(update.topic().name()).json_data(&update).unwrap()
+                    }
+                    Some(_) => continue,
+                    None => Event::default().event("resync").data(""),
+                };
+                return Some((Ok(event), subscription));
+            }
+        }
+    })
+}
@@ -5,7 +5,9 @@ use garde::{Unvalidated, Validate};
 use hyper::{Body, StatusCode};
 use serde::{Deserialize, Serialize};
 
-use crate::{auth::Auth, startup::AppState};
+use crate::audit::{environ_change, with_change, AuditChange, MASKED};
+use crate::telemetry::current_context;
+use crate::{auth::Auth, queue::{BuildKind, BuildQueueItem}, startup::AppState};
 
 #[derive(Deserialize, Validate, Debug)]
 pub struct UpdateProjectEnvironRequest {
@@ -13,6 +15,14 @@ pub struct UpdateProjectEnvironRequest {
     pub key: String,
     #[garde(length(min=1))]
     pub value: String,
+    /// secrets are stored encrypted and their value is never shown again
+    #[serde(default)]
+    #[garde(skip)]
+    pub secret: bool,
+    /// the secret is left out when the app is cloned, only for secrets
+    #[serde(default)]
+    #[garde(skip)]
+    pub no_copy: bool,
 }
 
 #[derive(Serialize, Debug)]
@@ -20,16 +30,16 @@ struct ErrorResponse {
     message: String
 }
 
-#[tracing::instrument(skip(auth, pool))]
+#[tracing::instrument(skip(auth, pool, build_channel, secrets, req))]
 pub async fn post(
     auth: Auth,
-    State(AppState { pool, domain, secure, .. }): State<AppState>,
+    State(AppState { pool, base, build_channel, secrets, .. }): State<AppState>,
     Path((owner, project)): Path<(String, String)>,
     Json(req): Json<Unvalidated<UpdateProjectEnvironRequest>>
 ) -> Response<Body> {
     let _user = auth.current_user.unwrap();
 
-    let UpdateProjectEnvironRequest { key, value } = match req.validate(&()) {
+    let UpdateProjectEnvironRequest { key, value, secret, no_copy } = match req.validate(&()) {
         Ok(valid) => valid.into_inner(),
         Err(err) => {
             let json = serde_json::to_string(&ErrorResponse {
@@ -45,7 +55,8 @@ pub async fn post(
 
     // check if project exist
     let project = match sqlx::query!(
-        r#"SELECT projects.id AS id, projects.name AS project, projects.environs AS env
+        r#"SELECT projects.id AS id, projects.name AS project, projects.environs AS env,
+           projects.secrets AS secrets
            FROM projects
            JOIN project_owners ON projects.owner_id = project_owners.id
            JOIN users_owners ON project_owners.id = users_owners.owner_id
@@ -84,17 +95,77 @@ pub async fn post(
     };
 
 
-    match sqlx::query!(
-        r#"UPDATE projects
-            SET environs = jsonb_set(projects.environs, $1, $2, true)
-            WHERE id = $3
-        "#,
-        &[key],
-        serde_json::Value::String(value),
-        project.id
-    )
-    .execute(&pool)
-    .await {
+    if no_copy && !secret {
+        let json = serde_json::to_string(&ErrorResponse {
+            message: "Plain variables are always copied, only secrets can be left out of clones".to_string()
+        }).unwrap();
+
+        return Response::builder()
+            .status(StatusCode::BAD_REQUEST)
+            .body(Body::from(json))
+            .unwrap();
+    }
+
+    let before = environ_change(&project.env, &project.secrets, &key);
+    let after = serde_json::json!({ &key: match secret {
+        true => MASKED,
+        false => value.as_str(),
+    } });
+
+    // a key is either a plain variable or a secret, setting one removes the other
+    let query = if secret {
+        let value = match secrets.encrypt(&value) {
+            Ok(value) => value,
+            Err(err) => {
+                tracing::error!(?err, "Can't update project secrets: Failed to encrypt secret");
+
+                let json = serde_json::to_string(&ErrorResponse {
+                    message: "Secrets are not enabled on this server".to_string()
+                }).unwrap();
+
+                return Response::builder()
+                    .status(StatusCode::BAD_REQUEST)
+                    .body(Body::from(json))
+                    .unwrap();
+            }
+        };
+
+        sqlx::query!(
+            r#"UPDATE projects
+                SET secrets = jsonb_set(projects.secrets, $1, $2, true),
+                    environs = projects.environs - $3,
+                    uncopied_secrets = CASE WHEN $5
+                        THEN array_append(array_remove(projects.uncopied_secrets, $3), $3)
+                        ELSE array_remove(projects.uncopied_secrets, $3)
+                    END
+                WHERE id = $4
+            "#,
+            &[key.clone()],
+            serde_json::Value::String(value),
+            key,
+            project.id,
+            no_copy
+        )
+        .execute(&pool)
+        .await
+    } else {
+        sqlx::query!(
+            r#"UPDATE projects
+                SET environs = jsonb_set(projects.environs, $1, $2, true),
+                    secrets = projects.secrets - $3,
+                    uncopied_secrets = array_remove(projects.uncopied_secrets, $3)
+                WHERE id = $4
+            "#,
+            &[key.clone()],
+            serde_json::Value::String(value),
+            key,
+            project.id
+        )
+        .execute(&pool)
+        .await
+    };
+
+    match query {
         Ok(data) => data,
         Err(err) => {
             tracing::error!(
@@ -113,8 +184,40 @@ pub async fn post(
         }    
     };
 
-    Response::builder()
-        .status(StatusCode::NO_CONTENT)
-        .body(Body::empty())
-        .unwrap()
+    // running apps only see the change through a new release of the live image, projects
+    // that were never deployed pick it up on their first build
+    match sqlx::query!("SELECT id FROM releases WHERE project_id = $1 LIMIT 1", project.id)
+        .fetch_optional(&pool)
+        .await
+    {
+        Ok(Some(_)) => {
+            let repo = project.project.trim_end_matches(".git");
+
+            if let Err(err) = build_channel
+                .send(BuildQueueItem {
+                    container_name: format!("{owner}-{repo}").replace('.', "-"),
+                    container_src: format!("{base}/{owner}/{repo}.git/master"),
+                    owner,
+                    repo: repo.to_string(),
+                    kind: BuildKind::Reconfigure(format!("Set {key}")),
+                    trace: current_context(),
+                })
+                .await
+            {
+                tracing::error!(?err, "Can't release project environs: Failed to send to build queue");
+            }
+        }
+        Ok(None) => {}
+        Err(err) => {
+            tracing::error!(?err, "Can't release project environs: Failed to query database");
+        }
+    }
+
+    with_change(
+        Response::builder()
+            .status(StatusCode::NO_CONTENT)
+            .body(Body::empty())
+            .unwrap(),
+        AuditChange::new(before, Some(after)),
+    )
 }
@@ -0,0 +1,411 @@
+use axum::extract::{State, Path};
+use axum::response::Response;
+use axum::Json;
+use garde::{Unvalidated, Validate};
+use hyper::{Body, StatusCode};
+use serde::{Deserialize, Serialize};
+
+use crate::audit::{with_change, AuditChange};
+use crate::https::PRELOAD_MAX_AGE;
+use crate::restarts::{apply_restarts, POLICIES};
+use crate::scanning::BLOCKABLE;
+use crate::{auth::Auth, monorepo::repo_path_valid, startup::AppState};
+
+#[derive(Deserialize, Validate, Debug)]
+pub struct UpdateProjectSettingsRequest {
+    /// empty or missing means any http response counts as ready
+    #[garde(custom(healthcheck_path_check))]
+    pub healthcheck_path: Option<String>,
+    /// minutes without traffic before the app is stopped, missing never idles
+    #[garde(range(min=5, max=10080))]
+    pub idle_timeout: Option<i32>,
+    /// directory of the repository the app is built from, empty or missing is the root
+    #[garde(custom(source_dir_check))]
+    pub source_dir: Option<String>,
+    /// pushes that change nothing under these paths aren't built, missing watches
+    /// `source_dir`
+    #[garde(custom(watch_paths_check))]
+    pub watch_paths: Option<Vec<String>>,
+    /// only reachable from the other apps of the owner as `{project}.internal`, missing is
+    /// public
+    #[garde(skip)]
+    pub internal: Option<bool>,
+    /// `always`, `on-failure` or `never`, missing is `on-failure`
+    #[garde(custom(restart_policy_check))]
+    pub restart_policy: Option<String>,
+    /// restarts of a crashing container before docker gives up on it, only for `on-failure`.
+    /// Missing keeps trying
+    #[garde(range(min=1, max=100))]
+    pub restart_retries: Option<i32>,
+    /// `http1`, or `h2c` for apps speaking http/2 without tls like grpc servers, missing is
+    /// `http1`
+    #[garde(custom(protocol_check))]
+    pub protocol: Option<String>,
+    /// read whole responses before sending them on, streams excepted. Missing streams them
+    #[garde(skip)]
+    pub response_buffering: Option<bool>,
+    /// seconds the app gets to send the headers of a response, missing waits
+    #[garde(range(min=1, max=3600))]
+    pub response_timeout: Option<i32>,
+    /// requests per second the proxy lets through to the app, missing keeps the limit of the
+    /// platform. A higher one than it doesn't count
+    #[garde(range(min=1, max=100000))]
+    pub rate_limit: Option<i32>,
+    /// requests per second the proxy lets through from one client ip
+    #[garde(range(min=1, max=100000))]
+    pub ip_rate_limit: Option<i32>,
+    /// requests over the rate let through at once
+    #[garde(range(min=0, max=100000))]
+    pub rate_burst: Option<i32>,
+    /// MiB a request body may have, missing keeps the limit of the platform. A higher one
+    /// than it doesn't count
+    #[garde(range(min=1, max=1048576))]
+    pub body_limit: Option<i32>,
+    /// seconds a client gets to send the body of a request, missing keeps the timeout of the
+    /// platform
+    #[garde(range(min=1, max=86400))]
+    pub body_timeout: Option<i32>,
+    /// keep a client on the replica it first got, missing spreads every request
+    #[garde(skip)]
+    pub sticky_sessions: Option<bool>,
+    /// compress text responses for clients accepting it, missing compresses
+    #[garde(skip)]
+    pub compression: Option<bool>,
+    /// keep responses at the proxy for as long as their Cache-Control allows, missing
+    /// forwards every request
+    #[garde(skip)]
+    pub edge_cache: Option<bool>,
+    /// redirect plain http requests to https, missing serves both
+    #[garde(skip)]
+    pub https_redirect: Option<bool>,
+    /// seconds browsers only use https for the app, missing sends no Strict-Transport-Security
+    #[garde(range(min=1, max=63072000))]
+    pub hsts_max_age: Option<i32>,
+    /// ask for the hsts preload list, which needs a max-age of a year
+    #[garde(skip)]
+    pub hsts_preload: Option<bool>,
+    /// anyone signed in may clone the app into an account of their own, missing only lets
+    /// maintainers clone it
+    #[garde(skip)]
+    pub cloneable: Option<bool>,
+    /// deploys of images with a vulnerability of `critical`, `high`, `medium` or `low` or
+    /// worse fail, missing only reports them
+    #[garde(custom(block_severity_check))]
+    pub block_severity: Option<String>,
+}
+
+#[derive(Serialize, Debug)]
+struct ErrorResponse {
+    message: String
+}
+
+fn healthcheck_path_check(value: &Option<String>, _ctx: &()) -> garde::Result {
+    match value {
+        Some(path) if !path.is_empty() && !path.starts_with('/') => {
+            Err(garde::Error::new("Healthcheck path must start with /"))
+        }
+        _ => Ok(()),
+    }
+}
+
+fn source_dir_check(value: &Option<String>, _ctx: &()) -> garde::Result {
+    match value {
+        Some(dir) if !dir.is_empty() && !repo_path_valid(dir) => Err(garde::Error::new(
+            "Source directory must be a path inside the repository, like services/api",
+        )),
+        _ => Ok(()),
+    }
+}
+
+fn restart_policy_check(value: &Option<String>, _ctx: &()) -> garde::Result {
+    match value {
+        Some(policy) if !POLICIES.contains(&policy.as_str()) => Err(garde::Error::new(
+            "Restart policy must be always, on-failure or never",
+        )),
+        _ => Ok(()),
+    }
+}
+
+fn protocol_check(value: &Option<String>, _ctx: &()) -> garde::Result {
+    match value.as_deref() {
+        Some(protocol) if protocol != "http1" && protocol != "h2c" => {
+            Err(garde::Error::new("Protocol must be http1 or h2c"))
+        }
+        _ => Ok(()),
+    }
+}
+
+fn block_severity_check(value: &Option<String>, _ctx: &()) -> garde::Result {
+    match value.as_deref() {
+        Some(severity) if !BLOCKABLE.contains(&severity) => Err(garde::Error::new(
+            "Block severity must be critical, high, medium or low",
+        )),
+        _ => Ok(()),
+    }
+}
+
+fn watch_paths_check(value: &Option<Vec<String>>, _ctx: &()) -> garde::Result {
+    match value.iter().flatten().find(|path| !repo_path_valid(path)) {
+        Some(path) => Err(garde::Error::new(format!(
+            "Watch path {path} must be a path inside the repository, like libs/shared"
+        ))),
+        None => Ok(()),
+    }
+}
+
+#[tracing::instrument(skip(auth, pool, cache))]
+pub async fn post(
+    auth: Auth,
+    State(AppState { pool, cache, .. }): State<AppState>,
+    Path((owner, project)): Path<(String, String)>,
+    Json(req): Json<Unvalidated<UpdateProjectSettingsRequest>>
+) -> Response<Body> {
+    let _user = auth.current_user.unwrap();
+
+    let UpdateProjectSettingsRequest {
+        healthcheck_path,
+        idle_timeout,
+        source_dir,
+        watch_paths,
+        internal,
+        restart_policy,
+        restart_retries,
+        protocol,
+        response_buffering,
+        response_timeout,
+        rate_limit,
+        ip_rate_limit,
+        rate_burst,
+        body_limit,
+        body_timeout,
+        sticky_sessions,
+        compression,
+        edge_cache,
+        https_redirect,
+        hsts_max_age,
+        hsts_preload,
+        cloneable,
+        block_severity,
+    } = match req.validate(&()) {
+        Ok(valid) => valid.into_inner(),
+        Err(err) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: err.to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+    let healthcheck_path = healthcheck_path.filter(|path| !path.is_empty());
+    let source_dir = source_dir
+        .map(|dir| dir.trim_end_matches('/').to_string())
+        .filter(|dir| !dir.is_empty());
+    let watch_paths = watch_paths
+        .unwrap_or_default()
+        .iter()
+        .map(|path| path.trim_end_matches('/').to_string())
+        .collect::<Vec<_>>();
+    let internal = internal.unwrap_or(false);
+    let restart_policy = restart_policy.unwrap_or_else(|| "on-failure".to_string());
+    let protocol = protocol.unwrap_or_else(|| "http1".to_string());
+    let response_buffering = response_buffering.unwrap_or(false);
+    let sticky_sessions = sticky_sessions.unwrap_or(false);
+    let compression = compression.unwrap_or(true);
+    let edge_cache = edge_cache.unwrap_or(false);
+    let https_redirect = https_redirect.unwrap_or(false);
+    let hsts_preload = hsts_preload.unwrap_or(false);
+    let cloneable = cloneable.unwrap_or(false);
+    if restart_retries.is_some() && restart_policy != "on-failure" {
+        let json = serde_json::to_string(&ErrorResponse {
+            message: "Restart retries only apply to the on-failure restart policy".to_string()
+        }).unwrap();
+
+        return Response::builder()
+            .status(StatusCode::BAD_REQUEST)
+            .body(Body::from(json))
+            .unwrap();
+    }
+
+    let subdomain = format!("{owner}-{project}").replace('.', "-");
+
+    if hsts_preload && hsts_max_age.map_or(true, |max_age| max_age < PRELOAD_MAX_AGE) {
+        let json = serde_json::to_string(&ErrorResponse {
+            message: format!("HSTS preload needs a max-age of at least {PRELOAD_MAX_AGE} seconds")
+        }).unwrap();
+
+        return Response::builder()
+            .status(StatusCode::BAD_REQUEST)
+            .body(Body::from(json))
+            .unwrap();
+    }
+
+    // check if project exist
+    let project = match sqlx::query!(
+        r#"SELECT projects.id AS id, projects.healthcheck_path, projects.idle_timeout, projects.source_dir,
+           projects.watch_paths, projects.internal, projects.restart_policy, projects.restart_retries,
+           projects.protocol, projects.response_buffering, projects.response_timeout,
+           projects.rate_limit, projects.ip_rate_limit, projects.rate_burst, projects.body_limit,
+           projects.body_timeout, projects.sticky_sessions, projects.compression, projects.edge_cache, projects.https_redirect, projects.hsts_max_age,
+           projects.hsts_preload, projects.cloneable, projects.block_severity
+           FROM projects
+           JOIN project_owners ON projects.owner_id = project_owners.id
+           JOIN users_owners ON project_owners.id = users_owners.owner_id
+           AND projects.name = $1
+           AND project_owners.name = $2
+        "#,
+        project,
+        owner,
+    )
+    .fetch_optional(&pool)
+    .await
+    {
+        Ok(Some(record)) => record,
+        Ok(None) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Project does not exist".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Err(err) => {
+            tracing::error!(?err, "Can't get projects: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    let before = serde_json::json!({
+        "healthcheck_path": project.healthcheck_path,
+        "idle_timeout": project.idle_timeout,
+        "source_dir": project.source_dir,
+        "watch_paths": project.watch_paths,
+        "internal": project.internal,
+        "restart_policy": project.restart_policy,
+        "restart_retries": project.restart_retries,
+        "protocol": project.protocol,
+        "response_buffering": project.response_buffering,
+        "response_timeout": project.response_timeout,
+        "rate_limit": project.rate_limit,
+        "ip_rate_limit": project.ip_rate_limit,
+        "rate_burst": project.rate_burst,
+        "body_limit": project.body_limit,
+        "body_timeout": project.body_timeout,
+        "sticky_sessions": project.sticky_sessions,
+        "compression": project.compression,
+        "edge_cache": project.edge_cache,
+        "https_redirect": project.https_redirect,
+        "hsts_max_age": project.hsts_max_age,
+        "hsts_preload": project.hsts_preload,
+        "cloneable": project.cloneable,
+        "block_severity": project.block_severity,
+    });
+    let after = serde_json::json!({
+        "healthcheck_path": healthcheck_path,
+        "idle_timeout": idle_timeout,
+        "source_dir": source_dir,
+        "watch_paths": watch_paths,
+        "internal": internal,
+        "restart_policy": restart_policy,
+        "restart_retries": restart_retries,
+        "protocol": protocol,
+        "response_buffering": response_buffering,
+        "response_timeout": response_timeout,
+        "rate_limit": rate_limit,
+        "ip_rate_limit": ip_rate_limit,
+        "rate_burst": rate_burst,
+        "body_limit": body_limit,
+        "body_timeout": body_timeout,
+        "sticky_sessions": sticky_sessions,
+        "compression": compression,
+        "edge_cache": edge_cache,
+        "https_redirect": https_redirect,
+        "hsts_max_age": hsts_max_age,
+        "hsts_preload": hsts_preload,
+        "cloneable": cloneable,
+        "block_severity": block_severity,
+    });
+
+    if let Err(err) = sqlx::query!(
+        r#"UPDATE projects
+            SET healthcheck_path = $1, idle_timeout = $2, source_dir = $3, watch_paths = $4,
+            internal = $5, restart_policy = $6, restart_retries = $7, protocol = $8,
+            response_buffering = $9, response_timeout = $10, rate_limit = $11, ip_rate_limit = $12,
+            rate_burst = $13, body_limit = $14, body_timeout = $15, sticky_sessions = $16,
+            compression = $17, edge_cache = $18, https_redirect = $19, hsts_max_age = $20,
+            hsts_preload = $21, cloneable = $22, block_severity = $23, updated_at = now()
+            WHERE id = $24
+        "#,
+        healthcheck_path,
+        idle_timeout,
+        source_dir,
+        &watch_paths,
+        internal,
+        restart_policy,
+        restart_retries,
+        protocol,
+        response_buffering,
+        response_timeout,
+        rate_limit,
+        ip_rate_limit,
+        rate_burst,
+        body_limit,
+        body_timeout,
+        sticky_sessions,
+        compression,
+        edge_cache,
+        https_redirect,
+        hsts_max_age,
+        hsts_preload,
+        cloneable,
+        block_severity,
+        project.id
+    )
+    .execute(&pool)
+    .await
+    {
+        tracing::error!(
+            ?err,
+            "Can't update project settings: Failed to insert into database"
+        );
+
+        let json = serde_json::to_string(&ErrorResponse {
+            message: "Failed to insert into database".to_string()
+        }).unwrap();
+
+        return Response::builder()
+            .status(StatusCode::INTERNAL_SERVER_ERROR)
+            .body(Body::from(json))
+            .unwrap();
+    };
+
+    // running containers restart the new way right away, the next deploy would anyway
+    if let Err(err) = apply_restarts(project.id, &pool).await {
+        tracing::error!(?err, "Can't update project settings: Failed to apply restart policy");
+    }
+
+    // what was cached would be served again as it was once the cache is back on
+    if !edge_cache {
+        cache.purge(&subdomain, &[]);
+    }
+
+    with_change(
+        Response::builder()
+            .status(StatusCode::NO_CONTENT)
+            .body(Body::empty())
+            .unwrap(),
+        AuditChange::new(Some(before), Some(after)),
+    )
+}
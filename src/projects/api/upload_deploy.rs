@@ -0,0 +1,157 @@
+use axum::body::Bytes;
+use axum::extract::{State, Path, Query};
+use axum::response::Response;
+use hyper::{Body, StatusCode};
+use serde::{Deserialize, Serialize};
+
+use crate::activity::record_activity;
+use crate::uploads::{commit_upload, Author, UploadError};
+use crate::telemetry::current_context;
+use crate::{auth::Auth, queue::{BuildKind, BuildQueueItem}, startup::AppState};
+
+#[derive(Deserialize, Debug)]
+pub struct UploadDeployQuery {
+    /// commit message of the upload
+    pub message: Option<String>,
+}
+
+#[derive(Serialize, Debug)]
+struct UploadDeployResponse {
+    message: String,
+    commit: String,
+}
+
+#[derive(Serialize, Debug)]
+struct ErrorResponse {
+    message: String,
+}
+
+/// Deploys a .tar.gz, .tar or .zip of the source sent as the body. It is committed to the
+/// repository and built like a push of that commit
+#[tracing::instrument(skip(auth, pool, base, domain, build_channel, archive))]
+pub async fn post(
+    auth: Auth,
+    State(AppState { pool, base, domain, build_channel, .. }): State<AppState>,
+    Path((owner, project)): Path<(String, String)>,
+    Query(UploadDeployQuery { message }): Query<UploadDeployQuery>,
+    archive: Bytes,
+) -> Response<Body> {
+    let user = auth.current_user.unwrap();
+
+    // check if project exist
+    let project_record = match sqlx::query!(
+        r#"SELECT projects.id AS id
+           FROM projects
+           JOIN project_owners ON projects.owner_id = project_owners.id
+           JOIN users_owners ON project_owners.id = users_owners.owner_id
+           AND projects.name = $1
+           AND project_owners.name = $2
+        "#,
+        project,
+        owner,
+    )
+    .fetch_optional(&pool)
+    .await
+    {
+        Ok(Some(record)) => record,
+        Ok(None) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Project does not exist".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Err(err) => {
+            tracing::error!(?err, "Can't get projects: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    let repo = project.trim_end_matches(".git");
+    let repo_path = format!("{base}/{owner}/{repo}.git");
+    let container_src = format!("{repo_path}/master");
+    let container_name = format!("{owner}-{repo}").replace('.', "-");
+
+    let author = Author {
+        name: user.username.clone(),
+        email: format!("{}@{domain}", user.username),
+    };
+    let message = message
+        .filter(|message| !message.trim().is_empty())
+        .unwrap_or_else(|| format!("Upload by {}", user.username));
+
+    let commit = match commit_upload(archive, &repo_path, &container_src, author, message).await {
+        Ok(commit) => commit,
+        Err(err @ UploadError::Archive(_)) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: err.to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Err(err) => {
+            tracing::error!(?err, "Can't deploy upload: Failed to commit archive");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: err.to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    let activity = format!("Upload of {} by {}, deploying", &commit[..7], user.username);
+    if let Err(err) = record_activity(project_record.id, "upload", &activity, &pool).await {
+        tracing::error!(?err, "Can't record activity: Failed to query database");
+    }
+
+    if let Err(err) = build_channel
+        .send(BuildQueueItem {
+            container_name,
+            container_src,
+            owner,
+            repo: repo.to_string(),
+            kind: BuildKind::Build,
+            trace: current_context(),
+        })
+        .await
+    {
+        tracing::error!(?err, "Can't deploy upload: Failed to send to build queue");
+
+        let json = serde_json::to_string(&ErrorResponse {
+            message: "Failed to queue build".to_string()
+        }).unwrap();
+
+        return Response::builder()
+            .status(StatusCode::INTERNAL_SERVER_ERROR)
+            .body(Body::from(json))
+            .unwrap();
+    }
+
+    let json = serde_json::to_string(&UploadDeployResponse {
+        message: "Build queued".to_string(),
+        commit,
+    }).unwrap();
+
+    Response::builder()
+        .status(StatusCode::ACCEPTED)
+        .body(Body::from(json))
+        .unwrap()
+}
@@ -0,0 +1,181 @@
+use axum::extract::{Path, Query, State};
+use axum::response::Response;
+use chrono::{DateTime, Utc};
+use hyper::{Body, StatusCode};
+use serde::{Deserialize, Serialize};
+
+use crate::{auth::Auth, startup::AppState};
+
+/// most lines one request returns
+const MAX_LIMIT: i64 = 1000;
+
+#[derive(Deserialize, Debug)]
+pub struct AccessLogQuery {
+    /// the newest lines, 100 by default
+    limit: Option<i64>,
+    /// only lines after this id, for following the log
+    after: Option<i64>,
+    /// a status like `404` or a class like `5xx`
+    status: Option<String>,
+}
+
+#[derive(Serialize, Debug)]
+struct AccessLogLine {
+    id: i64,
+    recorded_at: DateTime<Utc>,
+    request_id: String,
+    client_ip: String,
+    method: String,
+    path: String,
+    status: i32,
+    duration_ms: i32,
+    bytes: Option<i64>,
+    upstream: String,
+}
+
+#[derive(Serialize, Debug)]
+struct AccessLogResponse {
+    /// oldest first
+    lines: Vec<AccessLogLine>,
+}
+
+#[derive(Serialize, Debug)]
+struct ErrorResponse {
+    message: String,
+}
+
+/// the statuses `status` stands for
+fn status_range(status: &str) -> Option<(i32, i32)> {
+    match status.strip_suffix("xx") {
+        Some(class) => {
+            let class: i32 = class.parse().ok().filter(|class| (1..=5).contains(class))?;
+            Some((class * 100, class * 100 + 99))
+        }
+        None => {
+            let status: i32 = status.parse().ok().filter(|status| (100..=599).contains(status))?;
+            Some((status, status))
+        }
+    }
+}
+
+/// The requests the proxy answered for the app, the newest ones oldest first
+#[tracing::instrument(skip(auth, pool))]
+pub async fn get(
+    auth: Auth,
+    State(AppState { pool, .. }): State<AppState>,
+    Path((owner, project)): Path<(String, String)>,
+    Query(query): Query<AccessLogQuery>,
+) -> Response<Body> {
+    let _user = auth.current_user.unwrap();
+
+    let (min_status, max_status) = match query.status.as_deref().map(status_range) {
+        None => (0, 999),
+        Some(Some(range)) => range,
+        Some(None) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Status must be like 404 or 5xx".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+    let limit = query.limit.unwrap_or(100).clamp(1, MAX_LIMIT);
+
+    // check if project exist
+    let project = match sqlx::query!(
+        r#"SELECT projects.id AS id
+           FROM projects
+           JOIN project_owners ON projects.owner_id = project_owners.id
+           JOIN users_owners ON project_owners.id = users_owners.owner_id
+           AND projects.name = $1
+           AND project_owners.name = $2
+        "#,
+        project,
+        owner,
+    )
+    .fetch_optional(&pool)
+    .await
+    {
+        Ok(Some(record)) => record,
+        Ok(None) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Project does not exist".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Err(err) => {
+            tracing::error!(?err, "Can't get projects: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    let mut lines: Vec<AccessLogLine> = match sqlx::query!(
+        r#"SELECT id, recorded_at, request_id, client_ip, method, path, status, duration_ms, bytes, upstream
+           FROM access_logs
+           WHERE project_id = $1
+           AND id > $2
+           AND status BETWEEN $3 AND $4
+           ORDER BY id DESC
+           LIMIT $5
+        "#,
+        project.id,
+        query.after.unwrap_or(0),
+        min_status,
+        max_status,
+        limit
+    )
+    .fetch_all(&pool)
+    .await
+    {
+        Ok(lines) => lines
+            .into_iter()
+            .map(|line| AccessLogLine {
+                id: line.id,
+                recorded_at: line.recorded_at,
+                request_id: line.request_id,
+                client_ip: line.client_ip,
+                method: line.method,
+                path: line.path,
+                status: line.status,
+                duration_ms: line.duration_ms,
+                bytes: line.bytes,
+                upstream: line.upstream,
+            })
+            .collect(),
+        Err(err) => {
+            tracing::error!(?err, "Can't get access logs: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+    lines.reverse();
+
+    let json = serde_json::to_string(&AccessLogResponse { lines }).unwrap();
+
+    Response::builder()
+        .status(StatusCode::OK)
+        .body(Body::from(json))
+        .unwrap()
+}
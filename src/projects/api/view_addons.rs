@@ -0,0 +1,146 @@
+use axum::extract::{State, Path};
+use axum::response::Response;
+use chrono::{DateTime, Utc};
+use hyper::{Body, StatusCode};
+use serde::{Deserialize, Serialize};
+
+use crate::{auth::Auth, startup::AppState};
+
+#[derive(Serialize, Deserialize, Debug, Clone, Copy, PartialEq, sqlx::Type)]
+#[sqlx(type_name = "addon_kind", rename_all = "lowercase")]
+#[serde(rename_all = "lowercase")]
+pub enum AddonKind {
+    Postgres,
+    /// a bucket on the object storage, see [`crate::storage`]
+    S3,
+    /// see [`crate::redis`]
+    Redis,
+    /// an address on the mail relay, see [`crate::mail`]
+    Mail,
+}
+
+impl std::fmt::Display for AddonKind {
+    fn fmt(&self, f: &mut std::fmt::Formatter) -> std::fmt::Result {
+        match self {
+            AddonKind::Postgres => write!(f, "postgres"),
+            AddonKind::S3 => write!(f, "s3"),
+            AddonKind::Redis => write!(f, "redis"),
+            AddonKind::Mail => write!(f, "mail"),
+        }
+    }
+}
+
+#[derive(Serialize, Debug)]
+pub struct Addon {
+    pub kind: AddonKind,
+    pub name: String,
+    pub connection_limit: i32,
+    /// in MiB of a bucket or the keys of a redis, in recipients a day of mail
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub quota: Option<i32>,
+    pub created_at: DateTime<Utc>,
+}
+
+#[derive(Serialize, Debug)]
+struct ViewAddonsResponse {
+    data: Vec<Addon>,
+}
+
+#[derive(Serialize, Debug)]
+struct ErrorResponse {
+    message: String,
+}
+
+#[tracing::instrument(skip(auth, pool))]
+pub async fn get(
+    auth: Auth,
+    State(AppState { pool, .. }): State<AppState>,
+    Path((owner, project)): Path<(String, String)>,
+) -> Response<Body> {
+    let _user = auth.current_user.unwrap();
+
+    // check if project exist
+    let project_record = match sqlx::query!(
+        r#"SELECT projects.id AS id
+           FROM projects
+           JOIN project_owners ON projects.owner_id = project_owners.id
+           JOIN users_owners ON project_owners.id = users_owners.owner_id
+           AND projects.name = $1
+           AND project_owners.name = $2
+        "#,
+        project,
+        owner,
+    )
+    .fetch_optional(&pool)
+    .await
+    {
+        Ok(Some(record)) => record,
+        Ok(None) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Project does not exist".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Err(err) => {
+            tracing::error!(?err, "Can't get projects: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    // connection urls hold the password, apps get them as environment variables only
+    let addons = match sqlx::query!(
+        r#"SELECT kind AS "kind: AddonKind", name, connection_limit, quota, created_at
+           FROM addons
+           WHERE project_id = $1
+           ORDER BY created_at
+        "#,
+        project_record.id
+    )
+    .fetch_all(&pool)
+    .await
+    {
+        Ok(addons) => addons,
+        Err(err) => {
+            tracing::error!(?err, "Can't get addons: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    let data = addons
+        .into_iter()
+        .map(|addon| Addon {
+            kind: addon.kind,
+            name: addon.name,
+            connection_limit: addon.connection_limit,
+            quota: addon.quota,
+            created_at: addon.created_at,
+        })
+        .collect();
+
+    let json = serde_json::to_string(&ViewAddonsResponse { data }).unwrap();
+
+    Response::builder()
+        .status(StatusCode::OK)
+        .body(Body::from(json))
+        .unwrap()
+}
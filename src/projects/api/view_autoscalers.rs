@@ -0,0 +1,121 @@
+use axum::extract::{State, Path};
+use axum::response::Response;
+use chrono::{DateTime, Utc};
+use hyper::{Body, StatusCode};
+use serde::Serialize;
+
+use crate::{auth::Auth, startup::AppState};
+
+#[derive(Serialize, Debug)]
+pub struct Autoscaler {
+    pub process: String,
+    pub min: i32,
+    pub max: i32,
+    /// cpu or latency
+    pub metric: String,
+    /// percent of one cpu per container, or the p95 in milliseconds
+    pub target: f64,
+    pub last_scaled_at: Option<DateTime<Utc>>,
+}
+
+#[derive(Serialize, Debug)]
+struct AutoscalerListResponse {
+    data: Vec<Autoscaler>
+}
+
+#[derive(Serialize, Debug)]
+struct ErrorResponse {
+    message: String,
+}
+
+#[tracing::instrument(skip(auth, pool))]
+pub async fn get(
+    auth: Auth,
+    State(AppState { pool, .. }): State<AppState>,
+    Path((owner, project)): Path<(String, String)>,
+) -> Response<Body> {
+    let _user = auth.current_user.unwrap();
+
+    // check if project exist
+    let project_record = match sqlx::query!(
+        r#"SELECT projects.id AS id
+           FROM projects
+           JOIN project_owners ON projects.owner_id = project_owners.id
+           JOIN users_owners ON project_owners.id = users_owners.owner_id
+           AND projects.name = $1
+           AND project_owners.name = $2
+        "#,
+        project,
+        owner,
+    )
+    .fetch_optional(&pool)
+    .await
+    {
+        Ok(Some(record)) => record,
+        Ok(None) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Project does not exist".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Err(err) => {
+            tracing::error!(?err, "Can't get projects: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    let scalers = match sqlx::query!(
+        r#"SELECT process, min_count, max_count, metric, target, last_scaled_at
+           FROM autoscalers WHERE project_id = $1 ORDER BY process
+        "#,
+        project_record.id
+    )
+    .fetch_all(&pool)
+    .await
+    {
+        Ok(scalers) => scalers,
+        Err(err) => {
+            tracing::error!(?err, "Can't get autoscalers: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    let data = scalers
+        .into_iter()
+        .map(|scaler| Autoscaler {
+            process: scaler.process,
+            min: scaler.min_count,
+            max: scaler.max_count,
+            metric: scaler.metric,
+            target: scaler.target,
+            last_scaled_at: scaler.last_scaled_at,
+        })
+        .collect();
+
+    let json = serde_json::to_string(&AutoscalerListResponse { data }).unwrap();
+
+    Response::builder()
+        .status(StatusCode::OK)
+        .body(Body::from(json))
+        .unwrap()
+}
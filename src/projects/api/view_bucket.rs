@@ -0,0 +1,118 @@
+use axum::extract::{State, Path};
+use axum::response::Response;
+use chrono::{DateTime, Utc};
+use hyper::{Body, StatusCode};
+use serde::Serialize;
+
+use crate::storage::{bucket_usage, BucketUrl, LifecycleRule};
+use crate::{auth::Auth, startup::AppState};
+
+#[derive(Serialize, Debug)]
+struct BucketResponse {
+    bucket: String,
+    endpoint: String,
+    region: String,
+    /// in MiB
+    quota: i32,
+    /// bytes the objects take, None when the storage couldn't tell
+    usage: Option<i64>,
+    lifecycle: Vec<LifecycleRule>,
+    created_at: DateTime<Utc>,
+}
+
+#[derive(Serialize, Debug)]
+struct ErrorResponse {
+    message: String,
+}
+
+/// The bucket of the app, without its credentials, those are in the variables of the
+/// containers
+#[tracing::instrument(skip(auth, pool))]
+pub async fn get(
+    auth: Auth,
+    State(AppState { pool, .. }): State<AppState>,
+    Path((owner, project)): Path<(String, String)>,
+) -> Response<Body> {
+    let _user = auth.current_user.unwrap();
+
+    let addon = match sqlx::query!(
+        r#"SELECT addons.url, addons.quota, addons.lifecycle, addons.created_at
+           FROM addons
+           JOIN projects ON addons.project_id = projects.id
+           JOIN project_owners ON projects.owner_id = project_owners.id
+           WHERE projects.name = $1
+           AND project_owners.name = $2
+           AND addons.kind = 's3'
+        "#,
+        project,
+        owner,
+    )
+    .fetch_optional(&pool)
+    .await
+    {
+        Ok(Some(addon)) => addon,
+        Ok(None) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Project does not have a bucket".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::NOT_FOUND)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Err(err) => {
+            tracing::error!(?err, "Can't get bucket: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    let url = match BucketUrl::parse(&addon.url) {
+        Ok(url) => url,
+        Err(err) => {
+            tracing::error!(?err, "Can't get bucket: Invalid addon url");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Failed to read bucket".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    let container_name = format!("{owner}-{}", project.trim_end_matches(".git")).replace('.', "-");
+    let usage = match bucket_usage(&container_name, &url.bucket).await {
+        Ok(usage) => Some(usage),
+        Err(err) => {
+            // the rest is still worth showing
+            tracing::warn!(?err, "Can't get bucket usage");
+            None
+        }
+    };
+
+    let json = serde_json::to_string(&BucketResponse {
+        bucket: url.bucket,
+        endpoint: url.endpoint,
+        region: url.region,
+        quota: addon.quota.unwrap_or_default(),
+        usage,
+        lifecycle: serde_json::from_value(addon.lifecycle).unwrap_or_default(),
+        created_at: addon.created_at,
+    }).unwrap();
+
+    Response::builder()
+        .status(StatusCode::OK)
+        .body(Body::from(json))
+        .unwrap()
+}
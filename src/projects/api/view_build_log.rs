@@ -7,6 +7,7 @@ use hyper::{Body, StatusCode};
 use serde::{Serialize, Deserialize};
 use uuid::Uuid;
 
+use crate::scanning::{build_scan, Scan};
 use crate::{auth::Auth, startup::AppState};
 
 #[derive(Serialize, Deserialize, Debug, sqlx::Type)]
@@ -15,7 +16,8 @@ pub enum BuildState {
     PENDING,
     BUILDING,
     SUCCESSFUL,
-    FAILED
+    FAILED,
+    CANCELLED
 }
 
 impl fmt::Display for BuildState {
@@ -25,6 +27,7 @@ impl fmt::Display for BuildState {
             BuildState::BUILDING => write!(f, "Building"),
             BuildState::SUCCESSFUL => write!(f, "Successful"),
             BuildState::FAILED => write!(f, "Failed"),
+            BuildState::CANCELLED => write!(f, "Cancelled"),
         }
     }
 }
@@ -35,7 +38,11 @@ struct BuildDetailResponse {
     status: BuildState,
     created_at: DateTime<Utc>,
     finished_at: Option<DateTime<Utc>>,
-    logs: String
+    /// place in the build queue while the build waits, 1 starts next
+    queue_position: Option<usize>,
+    logs: String,
+    /// vulnerabilities in the image of the build, None when it wasn't scanned
+    scan: Option<Scan>,
 }
 
 #[derive(Serialize, Debug)]
@@ -43,10 +50,10 @@ struct ErrorResponse {
     message: String,
 }
 
-#[tracing::instrument(skip(auth, pool))]
+#[tracing::instrument(skip(auth, pool, build_queue))]
 pub async fn get(
     auth: Auth,
-    State(AppState { pool, domain, secure, .. }): State<AppState>,
+    State(AppState { pool, domain, secure, build_queue, .. }): State<AppState>,
     Path((owner, project, build_id)): Path<(String, String, Uuid)>,
 ) -> Response<Body> {
     let _user = auth.current_user.unwrap();
@@ -113,12 +120,19 @@ pub async fn get(
         }, 
     };
 
+    let scan = build_scan(build.id, &pool).await.unwrap_or_else(|err| {
+        tracing::error!(?err, "Can't get image scan: Failed to query database");
+        None
+    });
+
     let json = serde_json::to_string(&BuildDetailResponse {
         id: build.id,
         status: build.status,
         created_at: build.created_at,
         finished_at: build.finished_at,
+        queue_position: build_queue.position(build.id).await,
         logs: build.log,
+        scan,
     }).unwrap();
 
     Response::builder()
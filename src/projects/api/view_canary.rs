@@ -0,0 +1,119 @@
+use axum::extract::{State, Path};
+use axum::response::Response;
+use chrono::{DateTime, Utc};
+use hyper::{Body, StatusCode};
+use serde::Serialize;
+use uuid::Uuid;
+
+use crate::monitoring::{release_stats, ReleaseStats};
+use crate::{auth::Auth, startup::AppState};
+
+#[derive(Serialize, Debug)]
+struct Canary {
+    build_id: Uuid,
+    weight: i32,
+    created_at: DateTime<Utc>,
+    /// requests answered by the live release since the canary started
+    stable: ReleaseStats,
+    canary: ReleaseStats,
+}
+
+#[derive(Serialize, Debug)]
+struct CanaryResponse {
+    /// null without a canary
+    data: Option<Canary>
+}
+
+#[derive(Serialize, Debug)]
+struct ErrorResponse {
+    message: String,
+}
+
+#[tracing::instrument(skip(auth, pool))]
+pub async fn get(
+    auth: Auth,
+    State(AppState { pool, .. }): State<AppState>,
+    Path((owner, project)): Path<(String, String)>,
+) -> Response<Body> {
+    let _user = auth.current_user.unwrap();
+
+    // check if project exist
+    let project_record = match sqlx::query!(
+        r#"SELECT projects.id AS id
+           FROM projects
+           JOIN project_owners ON projects.owner_id = project_owners.id
+           JOIN users_owners ON project_owners.id = users_owners.owner_id
+           AND projects.name = $1
+           AND project_owners.name = $2
+        "#,
+        project,
+        owner,
+    )
+    .fetch_optional(&pool)
+    .await
+    {
+        Ok(Some(record)) => record,
+        Ok(None) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Project does not exist".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Err(err) => {
+            tracing::error!(?err, "Can't get projects: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    let canary = match sqlx::query!(
+        r#"SELECT build_id, weight, created_at FROM canaries WHERE project_id = $1"#,
+        project_record.id
+    )
+    .fetch_optional(&pool)
+    .await
+    {
+        Ok(canary) => canary,
+        Err(err) => {
+            tracing::error!(?err, "Can't get canary: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    let repo = project.trim_end_matches(".git");
+    let container_name = format!("{owner}-{repo}").replace('.', "-");
+
+    let data = canary.map(|canary| Canary {
+        build_id: canary.build_id,
+        weight: canary.weight,
+        created_at: canary.created_at,
+        stable: release_stats(&container_name, "stable"),
+        canary: release_stats(&container_name, "canary"),
+    });
+
+    let json = serde_json::to_string(&CanaryResponse { data }).unwrap();
+
+    Response::builder()
+        .status(StatusCode::OK)
+        .body(Body::from(json))
+        .unwrap()
+}
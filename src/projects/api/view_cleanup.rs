@@ -0,0 +1,124 @@
+use axum::extract::{Path, State};
+use axum::response::Response;
+use chrono::{DateTime, Utc};
+use hyper::{Body, StatusCode};
+use serde::Serialize;
+
+use crate::cleanup::cleanup_apps;
+use crate::{auth::Auth, startup::AppState};
+
+#[derive(Serialize, Debug)]
+struct CleanupResponse {
+    /// whether the platform cleans up inactive apps at all
+    enabled: bool,
+    last_active_at: DateTime<Utc>,
+    exempt: bool,
+    flagged_at: Option<DateTime<Utc>>,
+    stopped_at: Option<DateTime<Utc>>,
+    /// when it is flagged if it stays unused
+    flag_at: Option<DateTime<Utc>>,
+    stop_at: Option<DateTime<Utc>>,
+    delete_at: Option<DateTime<Utc>>,
+}
+
+#[derive(Serialize, Debug)]
+struct ErrorResponse {
+    message: String,
+}
+
+/// Where the app is in the cleanup of inactive apps and when the next step happens
+#[tracing::instrument(skip(auth, pool, cleanup_settings))]
+pub async fn get(
+    auth: Auth,
+    State(AppState { pool, cleanup_settings, .. }): State<AppState>,
+    Path((owner, project)): Path<(String, String)>,
+) -> Response<Body> {
+    let _user = auth.current_user.unwrap();
+
+    // check if project exist
+    let project_record = match sqlx::query!(
+        r#"SELECT projects.id AS id
+           FROM projects
+           JOIN project_owners ON projects.owner_id = project_owners.id
+           WHERE projects.name = $1
+           AND project_owners.name = $2
+        "#,
+        project,
+        owner,
+    )
+    .fetch_optional(&pool)
+    .await
+    {
+        Ok(Some(record)) => record,
+        Ok(None) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Project does not exist".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Err(err) => {
+            tracing::error!(?err, "Can't get projects: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    let app = match cleanup_apps(Some(project_record.id), &pool).await {
+        Ok(mut apps) if !apps.is_empty() => apps.remove(0),
+        Ok(_) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Project does not exist".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Err(err) => {
+            tracing::error!(?err, "Can't get cleanup: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    let enabled = cleanup_settings.inactivedays > 0;
+    let flag_at = match enabled && !app.exempt && !app.suspended && app.flagged_at.is_none() {
+        true => Some(app.last_active_at + chrono::Duration::days(cleanup_settings.inactivedays as i64)),
+        false => None,
+    };
+
+    let json = serde_json::to_string(&CleanupResponse {
+        enabled,
+        last_active_at: app.last_active_at,
+        exempt: app.exempt,
+        flagged_at: app.flagged_at,
+        stopped_at: app.stopped_at,
+        flag_at,
+        stop_at: app.stop_at(&cleanup_settings).filter(|_| enabled && !app.exempt),
+        delete_at: app.delete_at(&cleanup_settings).filter(|_| enabled && !app.exempt),
+    }).unwrap();
+
+    Response::builder()
+        .status(StatusCode::OK)
+        .body(Body::from(json))
+        .unwrap()
+}
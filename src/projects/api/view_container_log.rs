@@ -1,13 +1,12 @@
 use axum::extract::{State, Path};
 use axum::response::Response;
 use bollard::container::{LogsOptions, LogOutput};
-use bollard::Docker;
 use futures::StreamExt;
 use hyper::{Body, StatusCode};
 use serde::Serialize;
 use uuid::Uuid;
 
-use crate::{auth::Auth, startup::AppState};
+use crate::{auth::Auth, nodes, startup::AppState};
 
 #[derive(Serialize, Debug)]
 struct LogResponse {
@@ -69,7 +68,7 @@ pub async fn get(
         }
     };
 
-    let docker = match Docker::connect_with_local_defaults().map_err(|err| {
+    let docker = match nodes::docker(&project.container_name).map_err(|err| {
         tracing::error!("Failed to connect to docker: {}", err);
         err
     }) {
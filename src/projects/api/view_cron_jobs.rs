@@ -0,0 +1,134 @@
+use axum::extract::{State, Path};
+use axum::response::Response;
+use chrono::{DateTime, Utc};
+use hyper::{Body, StatusCode};
+use serde::Serialize;
+use uuid::Uuid;
+
+use crate::cron::RunState;
+use crate::{auth::Auth, startup::AppState};
+
+#[derive(Serialize, Debug)]
+struct CronJob {
+    id: Uuid,
+    schedule: String,
+    command: Vec<String>,
+    next_run_at: DateTime<Utc>,
+    created_at: DateTime<Utc>,
+    /// status of the latest run, none before the job ran once
+    last_status: Option<RunState>,
+    last_exit_code: Option<i32>,
+}
+
+#[derive(Serialize, Debug)]
+struct CronJobListResponse {
+    data: Vec<CronJob>
+}
+
+#[derive(Serialize, Debug)]
+struct ErrorResponse {
+    message: String,
+}
+
+#[tracing::instrument(skip(auth, pool))]
+pub async fn get(
+    auth: Auth,
+    State(AppState { pool, .. }): State<AppState>,
+    Path((owner, project)): Path<(String, String)>,
+) -> Response<Body> {
+    let _user = auth.current_user.unwrap();
+
+    // check if project exist
+    let project_record = match sqlx::query!(
+        r#"SELECT projects.id AS id
+           FROM projects
+           JOIN project_owners ON projects.owner_id = project_owners.id
+           JOIN users_owners ON project_owners.id = users_owners.owner_id
+           AND projects.name = $1
+           AND project_owners.name = $2
+        "#,
+        project,
+        owner,
+    )
+    .fetch_optional(&pool)
+    .await
+    {
+        Ok(Some(record)) => record,
+        Ok(None) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Project does not exist".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Err(err) => {
+            tracing::error!(?err, "Can't get projects: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    let job_records = match sqlx::query!(
+        r#"SELECT cron_jobs.id, cron_jobs.schedule, cron_jobs.command, cron_jobs.next_run_at,
+           cron_jobs.created_at, last_run.status AS "last_status?: RunState",
+           last_run.exit_code AS "last_exit_code?"
+           FROM cron_jobs
+           LEFT JOIN LATERAL (
+             SELECT status, exit_code FROM cron_runs
+             WHERE cron_runs.job_id = cron_jobs.id
+             ORDER BY started_at DESC LIMIT 1
+           ) last_run ON true
+           WHERE cron_jobs.project_id = $1
+           ORDER BY cron_jobs.created_at
+        "#,
+        project_record.id
+    )
+    .fetch_all(&pool)
+    .await
+    {
+        Ok(records) => records,
+        Err(err) => {
+            tracing::error!(?err, "Can't get cron jobs: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    let jobs = job_records.into_iter().map(|record| {
+        CronJob {
+            id: record.id,
+            schedule: record.schedule,
+            command: record.command,
+            next_run_at: record.next_run_at,
+            created_at: record.created_at,
+            last_status: record.last_status,
+            last_exit_code: record.last_exit_code,
+        }
+    }).collect::<Vec<_>>();
+
+    let json = serde_json::to_string(&CronJobListResponse {
+        data: jobs,
+    }).unwrap();
+
+    Response::builder()
+        .status(StatusCode::OK)
+        .body(Body::from(json))
+        .unwrap()
+}
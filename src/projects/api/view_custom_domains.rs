@@ -0,0 +1,118 @@
+use axum::extract::{State, Path};
+use axum::response::Response;
+use chrono::{DateTime, Utc};
+use hyper::{Body, StatusCode};
+use serde::Serialize;
+
+use crate::{auth::Auth, startup::AppState};
+
+#[derive(Serialize, Debug)]
+struct CustomDomain {
+    name: String,
+    verified: bool,
+    created_at: DateTime<Utc>,
+}
+
+#[derive(Serialize, Debug)]
+struct CustomDomainListResponse {
+    data: Vec<CustomDomain>,
+    /// what the domains have to point at with a CNAME record
+    target: String,
+}
+
+#[derive(Serialize, Debug)]
+struct ErrorResponse {
+    message: String,
+}
+
+#[tracing::instrument(skip(auth, pool))]
+pub async fn get(
+    auth: Auth,
+    State(AppState { pool, domain, .. }): State<AppState>,
+    Path((owner, project)): Path<(String, String)>,
+) -> Response<Body> {
+    let _user = auth.current_user.unwrap();
+
+    // check if project exist
+    let project_record = match sqlx::query!(
+        r#"SELECT projects.id AS id
+           FROM projects
+           JOIN project_owners ON projects.owner_id = project_owners.id
+           JOIN users_owners ON project_owners.id = users_owners.owner_id
+           AND projects.name = $1
+           AND project_owners.name = $2
+        "#,
+        project,
+        owner,
+    )
+    .fetch_optional(&pool)
+    .await
+    {
+        Ok(Some(record)) => record,
+        Ok(None) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Project does not exist".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Err(err) => {
+            tracing::error!(?err, "Can't get projects: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    let domain_records = match sqlx::query!(
+        r#"SELECT name, verified_at, created_at
+        FROM custom_domains WHERE project_id = $1
+        ORDER BY created_at"#,
+        project_record.id
+    )
+    .fetch_all(&pool)
+    .await
+    {
+        Ok(records) => records,
+        Err(err) => {
+            tracing::error!(?err, "Can't get custom domains: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    let domains = domain_records.into_iter().map(|record| {
+        CustomDomain {
+            name: record.name,
+            verified: record.verified_at.is_some(),
+            created_at: record.created_at,
+        }
+    }).collect::<Vec<_>>();
+
+    let subdomain = format!("{owner}-{project}").replace('.', "-");
+    let json = serde_json::to_string(&CustomDomainListResponse {
+        data: domains,
+        target: format!("{subdomain}.{domain}"),
+    }).unwrap();
+
+    Response::builder()
+        .status(StatusCode::OK)
+        .body(Body::from(json))
+        .unwrap()
+}
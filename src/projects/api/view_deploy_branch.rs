@@ -0,0 +1,88 @@
+use axum::extract::{State, Path};
+use axum::response::Response;
+use git2::Repository;
+use hyper::{Body, StatusCode};
+use serde::Serialize;
+
+use crate::{auth::Auth, startup::AppState};
+
+#[derive(Serialize, Debug)]
+struct DeployBranchResponse {
+    /// None deploys the branch HEAD of the repository points at
+    branch: Option<String>,
+    /// the branch pushes deploy from now, None before the first push
+    deploys: Option<String>,
+    /// pushes to the branch only build until they are promoted
+    promote: bool,
+}
+
+#[derive(Serialize, Debug)]
+struct ErrorResponse {
+    message: String,
+}
+
+#[tracing::instrument(skip(auth, pool, base))]
+pub async fn get(
+    auth: Auth,
+    State(AppState { pool, base, .. }): State<AppState>,
+    Path((owner, project)): Path<(String, String)>,
+) -> Response<Body> {
+    let _user = auth.current_user.unwrap();
+
+    // check if project exist
+    let project_record = match sqlx::query!(
+        r#"SELECT projects.id AS id, projects.deploy_branch, projects.deploy_promote
+           FROM projects
+           JOIN project_owners ON projects.owner_id = project_owners.id
+           JOIN users_owners ON project_owners.id = users_owners.owner_id
+           AND projects.name = $1
+           AND project_owners.name = $2
+        "#,
+        project,
+        owner,
+    )
+    .fetch_optional(&pool)
+    .await
+    {
+        Ok(Some(record)) => record,
+        Ok(None) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Project does not exist".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Err(err) => {
+            tracing::error!(?err, "Can't get projects: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    let repo = project.trim_end_matches(".git");
+    let head = Repository::open_bare(format!("{base}/{owner}/{repo}.git"))
+        .ok()
+        .and_then(|repo| repo.find_reference("HEAD").ok()?.symbolic_target().map(str::to_string))
+        .and_then(|head| head.strip_prefix("refs/heads/").map(str::to_string));
+
+    let json = serde_json::to_string(&DeployBranchResponse {
+        deploys: project_record.deploy_branch.clone().or(head),
+        branch: project_record.deploy_branch,
+        promote: project_record.deploy_promote,
+    }).unwrap();
+
+    Response::builder()
+        .status(StatusCode::OK)
+        .body(Body::from(json))
+        .unwrap()
+}
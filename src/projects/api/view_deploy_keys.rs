@@ -0,0 +1,129 @@
+use axum::extract::{State, Path};
+use axum::response::Response;
+use chrono::{DateTime, Utc};
+use hyper::{Body, StatusCode};
+use serde::Serialize;
+use uuid::Uuid;
+
+use crate::deploy_keys::ssh_remote;
+use crate::{auth::Auth, startup::AppState};
+
+#[derive(Serialize, Debug)]
+pub struct DeployKey {
+    pub id: Uuid,
+    pub name: String,
+    pub public_key: String,
+    pub fingerprint: String,
+    pub read_only: bool,
+    pub created_at: DateTime<Utc>,
+    pub rotated_at: Option<DateTime<Utc>>,
+    pub last_used_at: Option<DateTime<Utc>>,
+}
+
+#[derive(Serialize, Debug)]
+struct DeployKeyListResponse {
+    /// what ci adds as its remote, None when git isn't served over ssh
+    remote: Option<String>,
+    data: Vec<DeployKey>,
+}
+
+#[derive(Serialize, Debug)]
+struct ErrorResponse {
+    message: String,
+}
+
+/// Lists the deploy keys of the project, only their public halves
+#[tracing::instrument(skip(auth, pool, git_settings))]
+pub async fn get(
+    auth: Auth,
+    State(AppState { pool, domain, git_settings, .. }): State<AppState>,
+    Path((owner, project)): Path<(String, String)>,
+) -> Response<Body> {
+    let _user = auth.current_user.unwrap();
+
+    // check if project exist
+    let project_record = match sqlx::query!(
+        r#"SELECT projects.id AS id
+           FROM projects
+           JOIN project_owners ON projects.owner_id = project_owners.id
+           JOIN users_owners ON project_owners.id = users_owners.owner_id
+           AND projects.name = $1
+           AND project_owners.name = $2
+        "#,
+        project,
+        owner,
+    )
+    .fetch_optional(&pool)
+    .await
+    {
+        Ok(Some(record)) => record,
+        Ok(None) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Project does not exist".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Err(err) => {
+            tracing::error!(?err, "Can't get projects: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    let keys = match sqlx::query!(
+        r#"SELECT id, name, public_key, fingerprint, read_only, created_at, rotated_at, last_used_at
+           FROM deploy_keys WHERE project_id = $1 ORDER BY created_at
+        "#,
+        project_record.id
+    )
+    .fetch_all(&pool)
+    .await
+    {
+        Ok(keys) => keys,
+        Err(err) => {
+            tracing::error!(?err, "Can't get deploy keys: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    let data = keys
+        .into_iter()
+        .map(|key| DeployKey {
+            id: key.id,
+            name: key.name,
+            public_key: key.public_key,
+            fingerprint: key.fingerprint,
+            read_only: key.read_only,
+            created_at: key.created_at,
+            rotated_at: key.rotated_at,
+            last_used_at: key.last_used_at,
+        })
+        .collect();
+
+    let remote = (git_settings.sshport != 0).then(|| ssh_remote(&domain, git_settings.sshport, &owner, &project));
+    let json = serde_json::to_string(&DeployKeyListResponse { remote, data }).unwrap();
+
+    Response::builder()
+        .status(StatusCode::OK)
+        .body(Body::from(json))
+        .unwrap()
+}
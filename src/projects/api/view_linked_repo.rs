@@ -0,0 +1,125 @@
+use axum::extract::{State, Path};
+use axum::response::Response;
+use chrono::{DateTime, Utc};
+use hyper::{Body, StatusCode};
+use serde::Serialize;
+
+use crate::{auth::Auth, startup::AppState};
+
+#[derive(Serialize, Debug)]
+struct LinkedRepo {
+    provider: String,
+    url: String,
+    branch: String,
+    /// where the provider sends push events, with webhook_secret as its secret
+    webhook_url: String,
+    webhook_secret: String,
+    last_commit: Option<String>,
+    last_synced_at: Option<DateTime<Utc>>,
+}
+
+#[derive(Serialize, Debug)]
+struct LinkedRepoResponse {
+    /// null without a linked repository
+    data: Option<LinkedRepo>
+}
+
+#[derive(Serialize, Debug)]
+struct ErrorResponse {
+    message: String,
+}
+
+#[tracing::instrument(skip(auth, pool))]
+pub async fn get(
+    auth: Auth,
+    State(AppState { pool, domain, secure, .. }): State<AppState>,
+    Path((owner, project)): Path<(String, String)>,
+) -> Response<Body> {
+    let _user = auth.current_user.unwrap();
+
+    // check if project exist
+    let project_record = match sqlx::query!(
+        r#"SELECT projects.id AS id
+           FROM projects
+           JOIN project_owners ON projects.owner_id = project_owners.id
+           JOIN users_owners ON project_owners.id = users_owners.owner_id
+           AND projects.name = $1
+           AND project_owners.name = $2
+        "#,
+        project,
+        owner,
+    )
+    .fetch_optional(&pool)
+    .await
+    {
+        Ok(Some(record)) => record,
+        Ok(None) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Project does not exist".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Err(err) => {
+            tracing::error!(?err, "Can't get projects: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    let linked_repo = match sqlx::query!(
+        r#"SELECT provider, url, branch, webhook_secret, last_commit, last_synced_at
+           FROM linked_repos WHERE project_id = $1
+        "#,
+        project_record.id
+    )
+    .fetch_optional(&pool)
+    .await
+    {
+        Ok(linked_repo) => linked_repo,
+        Err(err) => {
+            tracing::error!(?err, "Can't get linked repository: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    let scheme = match secure {
+        true => "https",
+        false => "http",
+    };
+
+    let data = linked_repo.map(|linked_repo| LinkedRepo {
+        provider: linked_repo.provider,
+        url: linked_repo.url,
+        branch: linked_repo.branch,
+        webhook_url: format!("{scheme}://{domain}/api/project/{owner}/{project}/webhook"),
+        webhook_secret: linked_repo.webhook_secret,
+        last_commit: linked_repo.last_commit,
+        last_synced_at: linked_repo.last_synced_at,
+    });
+
+    let json = serde_json::to_string(&LinkedRepoResponse { data }).unwrap();
+
+    Response::builder()
+        .status(StatusCode::OK)
+        .body(Body::from(json))
+        .unwrap()
+}
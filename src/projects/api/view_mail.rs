@@ -0,0 +1,169 @@
+use axum::extract::{State, Path};
+use axum::response::Response;
+use chrono::{DateTime, Utc};
+use hyper::{Body, StatusCode};
+use serde::Serialize;
+use url::Url;
+
+use crate::mail::{daily_quota, sent_today};
+use crate::{auth::Auth, startup::AppState};
+
+#[derive(Serialize, Debug)]
+struct Message {
+    sender: String,
+    recipients: i32,
+    /// in bytes
+    size: i32,
+    status: String,
+    #[serde(skip_serializing_if = "Option::is_none")]
+    error: Option<String>,
+    created_at: DateTime<Utc>,
+}
+
+#[derive(Serialize, Debug)]
+struct Suppression {
+    address: String,
+    reason: String,
+    created_at: DateTime<Utc>,
+}
+
+#[derive(Serialize, Debug)]
+struct MailResponse {
+    address: String,
+    host: String,
+    port: u16,
+    username: String,
+    /// recipients a day
+    quota: i64,
+    sent_today: i64,
+    messages: Vec<Message>,
+    suppressions: Vec<Suppression>,
+    created_at: DateTime<Utc>,
+}
+
+#[derive(Serialize, Debug)]
+struct ErrorResponse {
+    message: String,
+}
+
+/// The address and relay of the app with what it sent today, its last messages and the
+/// recipients it can't send to. The password is only in SMTP_PASSWORD of the app
+#[tracing::instrument(skip(auth, pool))]
+pub async fn get(
+    auth: Auth,
+    State(AppState { pool, .. }): State<AppState>,
+    Path((owner, project)): Path<(String, String)>,
+) -> Response<Body> {
+    let _user = auth.current_user.unwrap();
+
+    let addon = match sqlx::query!(
+        r#"SELECT addons.project_id, addons.name, addons.url, addons.quota, addons.created_at
+           FROM addons
+           JOIN projects ON addons.project_id = projects.id
+           JOIN project_owners ON projects.owner_id = project_owners.id
+           WHERE projects.name = $1
+           AND project_owners.name = $2
+           AND addons.kind = 'mail'
+        "#,
+        project,
+        owner,
+    )
+    .fetch_optional(&pool)
+    .await
+    {
+        Ok(Some(addon)) => addon,
+        Ok(None) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Project does not have mail".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::NOT_FOUND)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Err(err) => {
+            tracing::error!(?err, "Can't get mail: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    let sent = sent_today(addon.project_id, &pool).await;
+    let messages = sqlx::query_as!(
+        Message,
+        r#"SELECT sender, recipients, size, status, error, created_at
+           FROM mail_messages
+           WHERE project_id = $1
+           ORDER BY created_at DESC
+           LIMIT 20
+        "#,
+        addon.project_id
+    )
+    .fetch_all(&pool)
+    .await;
+    let suppressions = sqlx::query_as!(
+        Suppression,
+        r#"SELECT address, reason, created_at
+           FROM mail_suppressions
+           WHERE project_id = $1
+           ORDER BY created_at DESC
+        "#,
+        addon.project_id
+    )
+    .fetch_all(&pool)
+    .await;
+
+    let (sent, messages, suppressions) = match (sent, messages, suppressions) {
+        (Ok(sent), Ok(messages), Ok(suppressions)) => (sent, messages, suppressions),
+        (Err(err), _, _) => {
+            tracing::error!(?err, "Can't get mail: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {err}")
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        (_, Err(err), _) | (_, _, Err(err)) => {
+            tracing::error!(?err, "Can't get mail: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    let url = Url::parse(&addon.url).ok();
+    let json = serde_json::to_string(&MailResponse {
+        address: addon.name,
+        host: url.as_ref().and_then(Url::host_str).unwrap_or_default().to_string(),
+        port: url.as_ref().and_then(Url::port).unwrap_or(25),
+        username: url.as_ref().map(Url::username).unwrap_or_default().to_string(),
+        quota: daily_quota(addon.quota),
+        sent_today: sent,
+        messages,
+        suppressions,
+        created_at: addon.created_at,
+    }).unwrap();
+
+    Response::builder()
+        .status(StatusCode::OK)
+        .body(Body::from(json))
+        .unwrap()
+}
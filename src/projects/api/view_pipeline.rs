@@ -0,0 +1,164 @@
+use axum::extract::{State, Path};
+use axum::response::Response;
+use chrono::{DateTime, Utc};
+use hyper::{Body, StatusCode};
+use serde::Serialize;
+use uuid::Uuid;
+
+use crate::pipelines::{stages, Stage};
+use crate::{auth::Auth, startup::AppState};
+
+#[derive(Serialize, Debug)]
+struct Promotion {
+    id: Uuid,
+    release_id: Uuid,
+    /// owner/project the release comes from and goes to
+    source: String,
+    target: String,
+    requested_by: String,
+    /// pending, approved or rejected
+    status: String,
+    decided_by: Option<String>,
+    decided_at: Option<DateTime<Utc>>,
+    created_at: DateTime<Utc>,
+}
+
+#[derive(Serialize, Debug)]
+struct PipelineResponse {
+    /// from the first to the last, this app included
+    stages: Vec<Stage>,
+    /// owner/project of the stage after this app
+    next: Option<String>,
+    promotion_approval: bool,
+    /// the last ones from and to this app, newest first
+    promotions: Vec<Promotion>,
+}
+
+#[derive(Serialize, Debug)]
+struct ErrorResponse {
+    message: String,
+}
+
+#[tracing::instrument(skip(auth, pool))]
+pub async fn get(
+    auth: Auth,
+    State(AppState { pool, .. }): State<AppState>,
+    Path((owner, project)): Path<(String, String)>,
+) -> Response<Body> {
+    let _user = auth.current_user.unwrap();
+
+    // check if project exist
+    let project_record = match sqlx::query!(
+        r#"SELECT projects.id AS id, projects.promotion_approval
+           FROM projects
+           JOIN project_owners ON projects.owner_id = project_owners.id
+           JOIN users_owners ON project_owners.id = users_owners.owner_id
+           AND projects.name = $1
+           AND project_owners.name = $2
+        "#,
+        project,
+        owner,
+    )
+    .fetch_optional(&pool)
+    .await
+    {
+        Ok(Some(record)) => record,
+        Ok(None) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Project does not exist".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Err(err) => {
+            tracing::error!(?err, "Can't get projects: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    let stages = match stages(project_record.id, &pool).await {
+        Ok(stages) => stages,
+        Err(err) => {
+            tracing::error!(?err, "Can't get pipeline: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {err}")
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    let promotions = match sqlx::query_as!(
+        Promotion,
+        r#"SELECT promotions.id, promotions.release_id,
+                  source_owners.name || '/' || sources.name AS "source!",
+                  target_owners.name || '/' || targets.name AS "target!",
+                  requesters.username AS requested_by,
+                  promotions.status,
+                  deciders.username AS "decided_by?",
+                  promotions.decided_at,
+                  promotions.created_at
+           FROM promotions
+           JOIN projects sources ON promotions.source_id = sources.id
+           JOIN project_owners source_owners ON sources.owner_id = source_owners.id
+           JOIN projects targets ON promotions.target_id = targets.id
+           JOIN project_owners target_owners ON targets.owner_id = target_owners.id
+           JOIN users requesters ON promotions.requested_by = requesters.id
+           LEFT JOIN users deciders ON promotions.decided_by = deciders.id
+           WHERE promotions.source_id = $1 OR promotions.target_id = $1
+           ORDER BY promotions.created_at DESC
+           LIMIT 20
+        "#,
+        project_record.id
+    )
+    .fetch_all(&pool)
+    .await
+    {
+        Ok(promotions) => promotions,
+        Err(err) => {
+            tracing::error!(?err, "Can't get promotions: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    let next = stages
+        .iter()
+        .skip_while(|stage| stage.id != project_record.id)
+        .nth(1)
+        .map(|stage| stage.app.clone());
+
+    let json = serde_json::to_string(&PipelineResponse {
+        stages,
+        next,
+        promotion_approval: project_record.promotion_approval,
+        promotions,
+    }).unwrap();
+
+    Response::builder()
+        .status(StatusCode::OK)
+        .body(Body::from(json))
+        .unwrap()
+}
@@ -0,0 +1,133 @@
+use axum::extract::{State, Path};
+use axum::response::Response;
+use chrono::{DateTime, Utc};
+use hyper::{Body, StatusCode};
+use serde::Serialize;
+use uuid::Uuid;
+
+use crate::ports;
+use crate::{auth::Auth, startup::AppState};
+
+#[derive(Serialize, Debug)]
+pub struct Port {
+    pub id: Uuid,
+    pub protocol: String,
+    /// the port clients connect to on `host`
+    pub port: i32,
+    /// the port of the container connections are forwarded to
+    pub target_port: i32,
+    /// seconds a connection may be quiet before it is closed
+    pub idle_timeout: i32,
+    pub host: String,
+    pub created_at: DateTime<Utc>,
+}
+
+#[derive(Serialize, Debug)]
+struct ViewPortsResponse {
+    data: Vec<Port>,
+    /// false while the platform has no port range, no port can be leased then
+    enabled: bool,
+}
+
+#[derive(Serialize, Debug)]
+struct ErrorResponse {
+    message: String,
+}
+
+#[tracing::instrument(skip(auth, pool))]
+pub async fn get(
+    auth: Auth,
+    State(AppState { pool, .. }): State<AppState>,
+    Path((owner, project)): Path<(String, String)>,
+) -> Response<Body> {
+    let _user = auth.current_user.unwrap();
+
+    // check if project exist
+    let project_record = match sqlx::query!(
+        r#"SELECT projects.id AS id
+           FROM projects
+           JOIN project_owners ON projects.owner_id = project_owners.id
+           JOIN users_owners ON project_owners.id = users_owners.owner_id
+           AND projects.name = $1
+           AND project_owners.name = $2
+        "#,
+        project,
+        owner,
+    )
+    .fetch_optional(&pool)
+    .await
+    {
+        Ok(Some(record)) => record,
+        Ok(None) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Project does not exist".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Err(err) => {
+            tracing::error!(?err, "Can't get projects: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    let lease_records = match sqlx::query!(
+        r#"SELECT id, protocol, port, target_port, idle_timeout, created_at
+           FROM port_leases
+           WHERE project_id = $1
+           ORDER BY protocol, port
+        "#,
+        project_record.id
+    )
+    .fetch_all(&pool)
+    .await
+    {
+        Ok(records) => records,
+        Err(err) => {
+            tracing::error!(?err, "Can't get ports: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    let host = ports::host();
+    let leases = lease_records.into_iter().map(|record| {
+        Port {
+            id: record.id,
+            protocol: record.protocol,
+            port: record.port,
+            target_port: record.target_port,
+            idle_timeout: ports::idle_timeout(record.idle_timeout) as i32,
+            host: host.clone(),
+            created_at: record.created_at,
+        }
+    }).collect::<Vec<_>>();
+
+    let json = serde_json::to_string(&ViewPortsResponse {
+        data: leases,
+        enabled: ports::enabled(),
+    }).unwrap();
+
+    Response::builder()
+        .status(StatusCode::OK)
+        .body(Body::from(json))
+        .unwrap()
+}
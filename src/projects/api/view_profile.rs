@@ -0,0 +1,215 @@
+use std::time::Duration;
+
+use axum::extract::{Path, Query, State};
+use axum::response::Response;
+use hyper::header::CONTENT_TYPE;
+use hyper::{Body, Request, StatusCode, Uri};
+use serde::{Deserialize, Serialize};
+
+use crate::orchestrator;
+use crate::{auth::Auth, startup::AppState};
+
+/// The profiles of net/http/pprof that can be fetched, the index and cmdline are left out
+const PROFILES: [&str; 8] = ["profile", "trace", "heap", "allocs", "goroutine", "block", "mutex", "threadcreate"];
+
+/// longest cpu profile or trace, the container is slower while it runs
+const MAX_SECONDS: u64 = 120;
+
+#[derive(Deserialize, Debug)]
+pub struct ViewProfileQuery {
+    /// how long a cpu profile or trace records, 30 by default like pprof
+    seconds: Option<u64>,
+    /// 1 or 2 for text instead of the protobuf format
+    debug: Option<u8>,
+    /// 1 runs the garbage collector before a heap profile
+    gc: Option<u8>,
+}
+
+#[derive(Serialize, Debug)]
+struct ErrorResponse {
+    message: String,
+}
+
+/// Fetches a profile from `/debug/pprof/{profile}` of the web container of an app on behalf
+/// of its maintainers. The proxy never forwards `/debug/pprof/` from visitors, so an app can
+/// serve pprof on its public port and only its members reach it
+#[tracing::instrument(skip(auth, pool, client, h2c_client))]
+pub async fn get(
+    auth: Auth,
+    State(AppState { pool, client, h2c_client, .. }): State<AppState>,
+    Path((owner, project, profile)): Path<(String, String, String)>,
+    Query(ViewProfileQuery { seconds, debug, gc }): Query<ViewProfileQuery>,
+) -> Response<Body> {
+    let _user = auth.current_user.unwrap();
+
+    if !PROFILES.contains(&profile.as_str()) {
+        let json = serde_json::to_string(&ErrorResponse {
+            message: format!("Profile must be one of {}", PROFILES.join(", "))
+        }).unwrap();
+
+        return Response::builder()
+            .status(StatusCode::BAD_REQUEST)
+            .body(Body::from(json))
+            .unwrap();
+    }
+
+    let seconds = seconds.unwrap_or(30);
+    if seconds == 0 || seconds > MAX_SECONDS {
+        let json = serde_json::to_string(&ErrorResponse {
+            message: format!("Seconds must be between 1 and {MAX_SECONDS}")
+        }).unwrap();
+
+        return Response::builder()
+            .status(StatusCode::BAD_REQUEST)
+            .body(Body::from(json))
+            .unwrap();
+    }
+
+    let app = match sqlx::query!(
+        r#"SELECT domains.container_id, domains.port, projects.protocol
+           FROM projects
+           JOIN project_owners ON projects.owner_id = project_owners.id
+           JOIN domains ON domains.project_id = projects.id
+           WHERE projects.name = $1
+           AND project_owners.name = $2
+        "#,
+        project,
+        owner,
+    )
+    .fetch_optional(&pool)
+    .await
+    {
+        Ok(Some(record)) => record,
+        Ok(None) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Project does not exist or isn't deployed yet".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Err(err) => {
+            tracing::error!(?err, "Can't get projects: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    let container_name = format!("{owner}-{}", project.trim_end_matches(".git")).replace('.', "-");
+    // rows from before blue-green deploys only know the container by name
+    let container_id = app.container_id.unwrap_or_else(|| container_name.clone());
+
+    // an idle app isn't woken, a profile of a container that just started tells nothing
+    let address = match orchestrator::driver().address(&container_name, &container_id).await {
+        Ok(Some(address)) => address,
+        Ok(None) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "The app isn't running, it can only be profiled while it serves requests".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::CONFLICT)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Err(err) => {
+            tracing::error!(?err, "Can't profile app: Failed to find container");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to find container: {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    let mut query = format!("seconds={seconds}");
+    if let Some(debug) = debug {
+        query.push_str(&format!("&debug={debug}"));
+    }
+    if let Some(gc) = gc {
+        query.push_str(&format!("&gc={gc}"));
+    }
+    let uri = format!("http://{address}:{}/debug/pprof/{profile}?{query}", app.port);
+    let req = Request::get(Uri::try_from(uri).unwrap()).body(Body::empty()).unwrap();
+    let client = match app.protocol.as_str() {
+        "h2c" => &h2c_client,
+        _ => &client,
+    };
+
+    // the app answers once it has recorded for `seconds`
+    let timeout = Duration::from_secs(seconds + 30);
+    let res = match tokio::time::timeout(timeout, client.request(req)).await {
+        Ok(Ok(res)) => res,
+        Ok(Err(err)) => {
+            tracing::error!(?err, "Can't profile app: Failed request to container");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed request to container: {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_GATEWAY)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Err(_) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Container didn't answer in {} seconds", timeout.as_secs())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::GATEWAY_TIMEOUT)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    if res.status() == StatusCode::NOT_FOUND {
+        let json = serde_json::to_string(&ErrorResponse {
+            message: "The app doesn't serve /debug/pprof/, import net/http/pprof and set PPROF=1 to turn it on".to_string()
+        }).unwrap();
+
+        return Response::builder()
+            .status(StatusCode::NOT_FOUND)
+            .body(Body::from(json))
+            .unwrap();
+    }
+
+    if !res.status().is_success() {
+        let (parts, body) = res.into_parts();
+        let body = hyper::body::to_bytes(body).await.unwrap_or_default();
+        let json = serde_json::to_string(&ErrorResponse {
+            message: format!("The app answered {}: {}", parts.status, String::from_utf8_lossy(&body).trim())
+        }).unwrap();
+
+        return Response::builder()
+            .status(StatusCode::BAD_GATEWAY)
+            .body(Body::from(json))
+            .unwrap();
+    }
+
+    let content_type = res
+        .headers()
+        .get(CONTENT_TYPE)
+        .cloned()
+        .unwrap_or_else(|| "application/octet-stream".parse().unwrap());
+
+    Response::builder()
+        .status(StatusCode::OK)
+        .header(CONTENT_TYPE, content_type)
+        .body(res.into_body())
+        .unwrap()
+}
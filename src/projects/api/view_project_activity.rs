@@ -0,0 +1,120 @@
+use axum::extract::{State, Path};
+use axum::response::Response;
+use chrono::{DateTime, Utc};
+use hyper::{Body, StatusCode};
+use serde::Serialize;
+use uuid::Uuid;
+
+use crate::{auth::Auth, startup::AppState};
+
+/// the log is only read from the top, older entries stay in the database
+const ACTIVITY_LIMIT: i64 = 100;
+
+#[derive(Serialize, Debug)]
+struct Activity {
+    id: Uuid,
+    kind: String,
+    message: String,
+    created_at: DateTime<Utc>,
+}
+
+#[derive(Serialize, Debug)]
+struct ActivityListResponse {
+    data: Vec<Activity>
+}
+
+#[derive(Serialize, Debug)]
+struct ErrorResponse {
+    message: String,
+}
+
+#[tracing::instrument(skip(auth, pool))]
+pub async fn get(
+    auth: Auth,
+    State(AppState { pool, .. }): State<AppState>,
+    Path((owner, project)): Path<(String, String)>,
+) -> Response<Body> {
+    let _user = auth.current_user.unwrap();
+
+    // check if project exist
+    let project_record = match sqlx::query!(
+        r#"SELECT projects.id AS id
+           FROM projects
+           JOIN project_owners ON projects.owner_id = project_owners.id
+           JOIN users_owners ON project_owners.id = users_owners.owner_id
+           AND projects.name = $1
+           AND project_owners.name = $2
+        "#,
+        project,
+        owner,
+    )
+    .fetch_optional(&pool)
+    .await
+    {
+        Ok(Some(record)) => record,
+        Ok(None) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Project does not exist".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Err(err) => {
+            tracing::error!(?err, "Can't get projects: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    let activities = match sqlx::query!(
+        r#"SELECT id, kind, message, created_at FROM activities
+           WHERE project_id = $1 ORDER BY created_at DESC LIMIT $2
+        "#,
+        project_record.id,
+        ACTIVITY_LIMIT
+    )
+    .fetch_all(&pool)
+    .await
+    {
+        Ok(activities) => activities,
+        Err(err) => {
+            tracing::error!(?err, "Can't get activity: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    let data = activities
+        .into_iter()
+        .map(|activity| Activity {
+            id: activity.id,
+            kind: activity.kind,
+            message: activity.message,
+            created_at: activity.created_at,
+        })
+        .collect();
+
+    let json = serde_json::to_string(&ActivityListResponse { data }).unwrap();
+
+    Response::builder()
+        .status(StatusCode::OK)
+        .body(Body::from(json))
+        .unwrap()
+}
@@ -0,0 +1,141 @@
+use axum::extract::{Path, State};
+use axum::response::Response;
+use chrono::{DateTime, Utc};
+use hyper::{Body, StatusCode};
+use serde::Serialize;
+
+use crate::{auth::Auth, startup::AppState};
+
+#[derive(Serialize, Debug)]
+struct AttachedEnvGroup {
+    name: String,
+    /// the names the group sets, its values are listed with the groups of the owner
+    env: Vec<String>,
+    secrets: Vec<String>,
+    /// names the app sets itself, the value of the app wins
+    overridden: Vec<String>,
+    attached_at: DateTime<Utc>,
+}
+
+#[derive(Serialize, Debug)]
+struct AttachedEnvGroupListResponse {
+    data: Vec<AttachedEnvGroup>,
+}
+
+#[derive(Serialize, Debug)]
+struct ErrorResponse {
+    message: String,
+}
+
+/// Env groups the app starts with, in the order they apply. A later group wins over an
+/// earlier one and the variables of the app win over all of them
+#[tracing::instrument(skip(auth, pool))]
+pub async fn get(
+    auth: Auth,
+    State(AppState { pool, .. }): State<AppState>,
+    Path((owner, project)): Path<(String, String)>,
+) -> Response<Body> {
+    let _user = auth.current_user.unwrap();
+
+    // check if project exist
+    let project_record = match sqlx::query!(
+        r#"SELECT projects.id AS id
+           FROM projects
+           JOIN project_owners ON projects.owner_id = project_owners.id
+           WHERE projects.name = $1
+           AND project_owners.name = $2
+        "#,
+        project,
+        owner,
+    )
+    .fetch_optional(&pool)
+    .await
+    {
+        Ok(Some(record)) => record,
+        Ok(None) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Project does not exist".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Err(err) => {
+            tracing::error!(?err, "Can't get projects: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    let groups = match sqlx::query!(
+        r#"SELECT env_groups.name, env_groups.environs, env_groups.secrets,
+                  projects.environs AS own_environs, projects.secrets AS own_secrets,
+                  project_env_groups.created_at AS attached_at
+           FROM project_env_groups
+           JOIN env_groups ON env_groups.id = project_env_groups.group_id
+           JOIN projects ON projects.id = project_env_groups.project_id
+           WHERE project_env_groups.project_id = $1
+           ORDER BY project_env_groups.created_at
+        "#,
+        project_record.id
+    )
+    .fetch_all(&pool)
+    .await
+    {
+        Ok(groups) => groups,
+        Err(err) => {
+            tracing::error!(?err, "Can't get env groups: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    let names = |value: &serde_json::Value| -> Vec<String> {
+        value.as_object().map(|map| map.keys().cloned().collect()).unwrap_or_default()
+    };
+
+    let data = groups
+        .into_iter()
+        .map(|group| {
+            let env = names(&group.environs);
+            let secrets = names(&group.secrets);
+            let overridden = env
+                .iter()
+                .chain(&secrets)
+                .filter(|key| group.own_environs.get(key.as_str()).is_some() || group.own_secrets.get(key.as_str()).is_some())
+                .cloned()
+                .collect();
+
+            AttachedEnvGroup {
+                name: group.name,
+                env,
+                secrets,
+                overridden,
+                attached_at: group.attached_at,
+            }
+        })
+        .collect();
+
+    let json = serde_json::to_string(&AttachedEnvGroupListResponse { data }).unwrap();
+
+    Response::builder()
+        .status(StatusCode::OK)
+        .body(Body::from(json))
+        .unwrap()
+}
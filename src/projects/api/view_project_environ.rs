@@ -11,6 +11,10 @@ use crate::{auth::Auth, startup::AppState};
 struct EnvironResponse {
     id: Uuid,
     env: Value,
+    /// only the names, secret values never leave the server
+    secrets: Vec<String>,
+    /// secrets left out when the app is cloned
+    uncopied_secrets: Vec<String>,
 }
 
 #[derive(Serialize, Debug)]
@@ -28,7 +32,8 @@ pub async fn get(
 
     // check if project exist
     let project = match sqlx::query!(
-        r#"SELECT projects.id AS id, projects.name AS project, projects.environs AS env
+        r#"SELECT projects.id AS id, projects.name AS project, projects.environs AS env,
+           projects.secrets AS secrets, projects.uncopied_secrets
            FROM projects
            JOIN project_owners ON projects.owner_id = project_owners.id
            JOIN users_owners ON project_owners.id = users_owners.owner_id
@@ -69,6 +74,16 @@ pub async fn get(
     let json = serde_json::to_string(&EnvironResponse {
         id: project.id,
         env: project.env,
+        secrets: project
+            .secrets
+            .as_object()
+            .map(|secrets| secrets.keys().cloned().collect())
+            .unwrap_or_default(),
+        uncopied_secrets: project
+            .uncopied_secrets
+            .into_iter()
+            .filter(|name| project.secrets.get(name).is_some())
+            .collect(),
     }).unwrap();
 
     Response::builder()
@@ -0,0 +1,240 @@
+use axum::extract::{Path, Query, State};
+use axum::response::Response;
+use chrono::{DateTime, Utc};
+use hyper::{Body, StatusCode};
+use serde::{Deserialize, Serialize};
+use uuid::Uuid;
+
+use crate::pagination::{self, INVALID_CURSOR};
+use crate::{auth::Auth, startup::AppState};
+
+/// what the feed can be narrowed down to with `type`
+const EVENT_TYPES: [&str; 6] = ["build", "deploy", "scale", "crash", "config", "addon"];
+
+#[derive(Deserialize, Debug)]
+pub struct ViewProjectEventsQuery {
+    /// types separated by commas, like `deploy,crash`. missing is every type
+    #[serde(rename = "type")]
+    types: Option<String>,
+    /// `next_cursor` of the page before, events older than it come next
+    cursor: Option<String>,
+    limit: Option<i64>,
+}
+
+#[derive(Serialize, Debug)]
+struct Event {
+    id: Uuid,
+    #[serde(rename = "type")]
+    event_type: String,
+    /// what happened within the type, like the status of a build or the action of a change
+    kind: String,
+    message: String,
+    /// who made a change, events of the platform have none
+    actor: Option<String>,
+    created_at: DateTime<Utc>,
+}
+
+#[derive(Serialize, Debug)]
+struct EventListResponse {
+    data: Vec<Event>,
+    /// missing once there are no older events
+    next_cursor: Option<String>,
+}
+
+#[derive(Serialize, Debug)]
+struct ErrorResponse {
+    message: String,
+}
+
+/// Everything that happened to the app in one feed, newest first: builds, releases, scaling,
+/// crashes, changes of its settings and its addons. Changes only name what was changed, the
+/// values are in the audit log for maintainers
+#[tracing::instrument(skip(auth, pool))]
+pub async fn get(
+    auth: Auth,
+    State(AppState { pool, .. }): State<AppState>,
+    Path((owner, project)): Path<(String, String)>,
+    Query(ViewProjectEventsQuery { types, cursor, limit }): Query<ViewProjectEventsQuery>,
+) -> Response<Body> {
+    let _user = auth.current_user.unwrap();
+
+    let types = types.map(|types| {
+        types
+            .split(',')
+            .map(|event_type| event_type.trim().to_string())
+            .filter(|event_type| !event_type.is_empty())
+            .collect::<Vec<_>>()
+    });
+    if let Some(unknown) = types
+        .iter()
+        .flatten()
+        .find(|event_type| !EVENT_TYPES.contains(&event_type.as_str()))
+    {
+        let json = serde_json::to_string(&ErrorResponse {
+            message: format!("Unknown event type {unknown}, it must be one of {}", EVENT_TYPES.join(", "))
+        }).unwrap();
+
+        return Response::builder()
+            .status(StatusCode::BAD_REQUEST)
+            .body(Body::from(json))
+            .unwrap();
+    }
+
+    let (before, before_id) = match cursor.as_deref().map(pagination::parse_cursor) {
+        Some(Some((before, before_id))) => (Some(before), Some(before_id)),
+        Some(None) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: INVALID_CURSOR.to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        None => (None, None),
+    };
+    let limit = pagination::limit(limit);
+
+    // check if project exist
+    let project_record = match sqlx::query!(
+        r#"SELECT projects.id AS id
+           FROM projects
+           JOIN project_owners ON projects.owner_id = project_owners.id
+           WHERE projects.name = $1
+           AND project_owners.name = $2
+        "#,
+        project,
+        owner,
+    )
+    .fetch_optional(&pool)
+    .await
+    {
+        Ok(Some(record)) => record,
+        Ok(None) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Project does not exist".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Err(err) => {
+            tracing::error!(?err, "Can't get projects: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    // the activity log and the audit log overlap, scaling by hand shows up in both. the audit
+    // log only adds the changes nothing else records, and only the ones that went through
+    let events = match sqlx::query!(
+        r#"SELECT id AS "id!", event_type AS "event_type!", kind AS "kind!", message AS "message!",
+                  actor, created_at AS "created_at!"
+           FROM (
+             SELECT id, 'build' AS event_type, status::text AS kind,
+                    'Build ' || status::text || COALESCE(' of ' || left(commit_sha, 7), '') AS message,
+                    NULL::text AS actor, created_at
+             FROM builds WHERE project_id = $1
+             UNION ALL
+             SELECT id, 'deploy', 'release', COALESCE(NULLIF(description, ''), 'Released build ' || build_id::text),
+                    NULL, created_at
+             FROM releases WHERE project_id = $1
+             UNION ALL
+             SELECT id, 'crash', CASE WHEN oom_killed THEN 'oom' ELSE 'exit' END,
+                    CASE WHEN oom_killed THEN 'Killed for running out of memory'
+                         ELSE 'Exited with code ' || COALESCE(exit_code::text, 'unknown')
+                              || COALESCE(' (' || exit_signal || ')', '')
+                    END,
+                    NULL, exited_at
+             FROM releases WHERE project_id = $1 AND exited_at IS NOT NULL
+             UNION ALL
+             SELECT id,
+                    CASE WHEN kind IN ('scale', 'autoscale', 'idle', 'restart', 'stop', 'start') THEN 'scale'
+                         WHEN kind = 'crashloop' THEN 'crash'
+                         WHEN kind IN ('canary', 'preview', 'push', 'upload', 'template', 'reconcile') THEN 'deploy'
+                         ELSE 'config'
+                    END,
+                    kind, message, NULL, created_at
+             FROM activities WHERE project_id = $1
+             UNION ALL
+             SELECT id, 'addon', 'added', 'Added ' || kind::text, NULL, created_at
+             FROM addons WHERE project_id = $1
+             UNION ALL
+             SELECT backups.id, 'addon', 'backup', 'Backup of ' || addons.kind::text || ' ' || backups.status::text,
+                    NULL, backups.started_at
+             FROM backups JOIN addons ON backups.addon_id = addons.id
+             WHERE addons.project_id = $1
+             UNION ALL
+             SELECT id,
+                    CASE WHEN split_part(action, '.', 1) = 'autoscale' THEN 'scale'
+                         WHEN action IN ('addons.delete', 'backups.restore', 'volume.delete') THEN 'addon'
+                         ELSE 'config'
+                    END,
+                    action, action, actor, created_at
+             FROM audit_log
+             WHERE project_id = $1 AND status < 400
+             AND (action IN ('addons.delete', 'backups.restore', 'volume.delete')
+                  OR split_part(action, '.', 1) IN ('autoscale', 'env', 'env-groups', 'access', 'basic-auth', 'cors',
+                    'deploy-branch', 'deploy-keys', 'domains', 'drains', 'error-page', 'headers', 'logs',
+                    'notifications', 'cron', 'previews', 'push-policy', 'registries', 'settings', 'volumes'))
+           ) AS events
+           WHERE ($2::text[] IS NULL OR event_type = ANY($2))
+           AND ($3::timestamptz IS NULL OR (created_at, id) < ($3, $4::uuid))
+           ORDER BY created_at DESC, id DESC
+           LIMIT $5
+        "#,
+        project_record.id,
+        types.as_deref(),
+        before,
+        before_id,
+        limit
+    )
+    .fetch_all(&pool)
+    .await
+    {
+        Ok(events) => events,
+        Err(err) => {
+            tracing::error!(?err, "Can't get events: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    let next_cursor = pagination::next_cursor(&events, limit, |event| (event.created_at, event.id));
+
+    let data = events
+        .into_iter()
+        .map(|event| Event {
+            id: event.id,
+            event_type: event.event_type,
+            kind: event.kind,
+            message: event.message,
+            actor: event.actor,
+            created_at: event.created_at,
+        })
+        .collect();
+
+    let json = serde_json::to_string(&EventListResponse { data, next_cursor }).unwrap();
+
+    Response::builder()
+        .status(StatusCode::OK)
+        .body(Body::from(json))
+        .unwrap()
+}
@@ -0,0 +1,191 @@
+use axum::extract::{Path, Query, State};
+use axum::response::Response;
+use chrono::{DateTime, Utc};
+use hyper::{Body, StatusCode};
+use serde::{Deserialize, Serialize};
+
+use crate::{auth::Auth, startup::AppState};
+
+/// points per series, enough for a graph without sending every sample of a week
+const POINTS: u64 = 120;
+
+const RANGES: [(&str, u64); 5] = [
+    ("15m", 15 * 60),
+    ("1h", 60 * 60),
+    ("6h", 6 * 60 * 60),
+    ("24h", 24 * 60 * 60),
+    ("7d", 7 * 24 * 60 * 60),
+];
+
+#[derive(Deserialize, Debug)]
+pub struct ViewMetricsQuery {
+    range: Option<String>,
+}
+
+#[derive(Serialize, Debug)]
+struct MetricsPoint {
+    timestamp: DateTime<Utc>,
+    /// average over the step, 100 is one full cpu
+    cpu_percent: f64,
+    /// peak over the step
+    memory_bytes: i64,
+    memory_limit: i64,
+    /// bytes per second
+    network_rx: f64,
+    network_tx: f64,
+    restarts: i32,
+    oom_killed: bool,
+}
+
+#[derive(Serialize, Debug)]
+struct MetricsSeries {
+    container: String,
+    process: String,
+    points: Vec<MetricsPoint>,
+}
+
+#[derive(Serialize, Debug)]
+struct ViewMetricsResponse {
+    range: String,
+    /// seconds between points
+    step: u64,
+    data: Vec<MetricsSeries>,
+}
+
+#[derive(Serialize, Debug)]
+struct ErrorResponse {
+    message: String,
+}
+
+/// Resource usage of the web and worker containers of a project over `range`, one series
+/// per container in steps suitable for graphing
+#[tracing::instrument(skip(auth, pool))]
+pub async fn get(
+    auth: Auth,
+    State(AppState { pool, container_settings, .. }): State<AppState>,
+    Path((owner, project)): Path<(String, String)>,
+    Query(ViewMetricsQuery { range }): Query<ViewMetricsQuery>,
+) -> Response<Body> {
+    let _user = auth.current_user.unwrap();
+
+    let range = range.unwrap_or_else(|| "1h".to_string());
+    let Some((_, seconds)) = RANGES.iter().find(|(name, _)| *name == range) else {
+        let json = serde_json::to_string(&ErrorResponse {
+            message: format!(
+                "Range must be one of {}",
+                RANGES.map(|(name, _)| name).join(", ")
+            )
+        }).unwrap();
+
+        return Response::builder()
+            .status(StatusCode::BAD_REQUEST)
+            .body(Body::from(json))
+            .unwrap();
+    };
+
+    let step = (seconds / POINTS).max(container_settings.metricsinterval);
+
+    // check if project exist
+    let project_record = match sqlx::query!(
+        r#"SELECT projects.id AS id
+           FROM projects
+           JOIN project_owners ON projects.owner_id = project_owners.id
+           JOIN users_owners ON project_owners.id = users_owners.owner_id
+           AND projects.name = $1
+           AND project_owners.name = $2
+        "#,
+        project,
+        owner,
+    )
+    .fetch_optional(&pool)
+    .await
+    {
+        Ok(Some(record)) => record,
+        Ok(None) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Project does not exist".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Err(err) => {
+            tracing::error!(?err, "Can't get projects: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    let rows = match sqlx::query!(
+        r#"SELECT container, process,
+           to_timestamp(floor(extract(epoch FROM recorded_at) / $3) * $3) AS "timestamp!",
+           avg(cpu_percent) AS "cpu_percent!", max(memory_bytes) AS "memory_bytes!",
+           max(memory_limit) AS "memory_limit!", avg(network_rx) AS "network_rx!",
+           avg(network_tx) AS "network_tx!", max(restarts) AS "restarts!",
+           bool_or(oom_killed) AS "oom_killed!"
+           FROM container_metrics
+           WHERE project_id = $1 AND recorded_at > now() - make_interval(secs => $2)
+           GROUP BY container, process, 3
+           ORDER BY container, 3
+        "#,
+        project_record.id,
+        *seconds as f64,
+        step as f64
+    )
+    .fetch_all(&pool)
+    .await
+    {
+        Ok(rows) => rows,
+        Err(err) => {
+            tracing::error!(?err, "Can't get metrics: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    let mut data: Vec<MetricsSeries> = Vec::new();
+    for row in rows {
+        let point = MetricsPoint {
+            timestamp: row.timestamp,
+            cpu_percent: row.cpu_percent,
+            memory_bytes: row.memory_bytes,
+            memory_limit: row.memory_limit,
+            network_rx: row.network_rx,
+            network_tx: row.network_tx,
+            restarts: row.restarts,
+            oom_killed: row.oom_killed,
+        };
+
+        match data.last_mut() {
+            Some(series) if series.container == row.container => series.points.push(point),
+            _ => data.push(MetricsSeries {
+                container: row.container,
+                process: row.process,
+                points: vec![point],
+            }),
+        }
+    }
+
+    let json = serde_json::to_string(&ViewMetricsResponse { range, step, data }).unwrap();
+
+    Response::builder()
+        .status(StatusCode::OK)
+        .body(Body::from(json))
+        .unwrap()
+}
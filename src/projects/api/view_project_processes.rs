@@ -0,0 +1,135 @@
+use std::collections::BTreeMap;
+
+use axum::extract::{State, Path};
+use axum::response::Response;
+use hyper::{Body, StatusCode};
+use serde::Serialize;
+
+use crate::docker::{running_processes, ReleaseConfig};
+use crate::{auth::Auth, startup::AppState};
+
+#[derive(Serialize, Debug)]
+struct Process {
+    name: String,
+    command: String,
+    /// containers the process should run
+    count: i64,
+    /// containers that are running right now
+    running: i64,
+}
+
+#[derive(Serialize, Debug)]
+struct ProjectProcessListResponse {
+    data: Vec<Process>
+}
+
+#[derive(Serialize, Debug)]
+struct ErrorResponse {
+    message: String,
+}
+
+#[tracing::instrument(skip(auth, pool))]
+pub async fn get(
+    auth: Auth,
+    State(AppState { pool, .. }): State<AppState>,
+    Path((owner, project)): Path<(String, String)>,
+) -> Response<Body> {
+    let _user = auth.current_user.unwrap();
+
+    // check if project exist
+    let project_record = match sqlx::query!(
+        r#"SELECT projects.id AS id, projects.formation AS formation
+           FROM projects
+           JOIN project_owners ON projects.owner_id = project_owners.id
+           JOIN users_owners ON project_owners.id = users_owners.owner_id
+           AND projects.name = $1
+           AND project_owners.name = $2
+        "#,
+        project,
+        owner,
+    )
+    .fetch_optional(&pool)
+    .await
+    {
+        Ok(Some(record)) => record,
+        Ok(None) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Project does not exist".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Err(err) => {
+            tracing::error!(?err, "Can't get projects: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    // the live release knows which processes the Procfile declared
+    let config = match sqlx::query!(
+        r#"SELECT config FROM releases WHERE project_id = $1 ORDER BY created_at DESC LIMIT 1"#,
+        project_record.id
+    )
+    .fetch_optional(&pool)
+    .await
+    {
+        Ok(Some(release)) => serde_json::from_value::<ReleaseConfig>(release.config).unwrap_or_default(),
+        Ok(None) => ReleaseConfig::default(),
+        Err(err) => {
+            tracing::error!(?err, "Can't get releases: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    let formation: BTreeMap<String, i64> =
+        serde_json::from_value(project_record.formation).unwrap_or_default();
+
+    let container_name = format!("{owner}-{}", project.trim_end_matches(".git")).replace('.', "-");
+    let running = match running_processes(&container_name).await {
+        Ok(running) => running,
+        Err(err) => {
+            tracing::error!(?err, "Can't get processes: Failed to list containers");
+            Default::default()
+        }
+    };
+
+    // web runs the image command unless the Procfile has one
+    let web = ("web".to_string(), config.cmd.unwrap_or_default());
+
+    let processes = std::iter::once(web).chain(config.workers).map(|(name, command)| {
+        Process {
+            command: command.join(" "),
+            count: formation.get(&name).copied().unwrap_or(1),
+            running: running.get(&name).copied().unwrap_or(0),
+            name,
+        }
+    }).collect::<Vec<_>>();
+
+    let json = serde_json::to_string(&ProjectProcessListResponse {
+        data: processes,
+    }).unwrap();
+
+    Response::builder()
+        .status(StatusCode::OK)
+        .body(Body::from(json))
+        .unwrap()
+}
@@ -0,0 +1,181 @@
+use axum::extract::{Path, Query, State};
+use axum::response::Response;
+use chrono::{DateTime, Utc};
+use hyper::{Body, StatusCode};
+use serde::{Deserialize, Serialize};
+use uuid::Uuid;
+
+use crate::pagination::{self, INVALID_CURSOR};
+use crate::releases::config_digest;
+use crate::{auth::Auth, startup::AppState};
+
+#[derive(Deserialize, Debug)]
+pub struct ReleaseListQuery {
+    /// `next_cursor` of the page before
+    cursor: Option<String>,
+    limit: Option<i64>,
+}
+
+#[derive(Serialize, Debug)]
+struct Release {
+    id: Uuid,
+    build_id: Uuid,
+    image: String,
+    /// of the build the image was made from, None for images pulled from a registry
+    commit_sha: Option<String>,
+    /// releases with the same digest start their containers the same way
+    config_digest: String,
+    description: String,
+    created_at: DateTime<Utc>,
+    /// the newest release is the one running
+    live: bool,
+    /// deploys are refused until the app is unpinned
+    pinned: bool,
+    /// the last time a container of the release exited on its own
+    exit_code: Option<i32>,
+    exit_signal: Option<String>,
+    oom_killed: bool,
+    exited_at: Option<DateTime<Utc>>,
+}
+
+#[derive(Serialize, Debug)]
+struct ProjectReleaseListResponse {
+    data: Vec<Release>,
+    /// missing once there are no older releases
+    next_cursor: Option<String>,
+}
+
+#[derive(Serialize, Debug)]
+struct ErrorResponse {
+    message: String,
+}
+
+#[tracing::instrument(skip(auth, pool))]
+pub async fn get(
+    auth: Auth,
+    State(AppState { pool, .. }): State<AppState>,
+    Path((owner, project)): Path<(String, String)>,
+    Query(ReleaseListQuery { cursor, limit }): Query<ReleaseListQuery>,
+) -> Response<Body> {
+    let _user = auth.current_user.unwrap();
+
+    let (before, before_id) = match cursor.as_deref().map(pagination::parse_cursor) {
+        Some(Some((before, before_id))) => (Some(before), Some(before_id)),
+        Some(None) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: INVALID_CURSOR.to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        None => (None, None),
+    };
+    let limit = pagination::limit(limit);
+
+    // check if project exist
+    let project_record = match sqlx::query!(
+        r#"SELECT projects.id AS id, projects.pinned_release_id,
+                  (SELECT releases.id FROM releases WHERE releases.project_id = projects.id
+                   ORDER BY releases.created_at DESC LIMIT 1) AS live_release_id
+           FROM projects
+           JOIN project_owners ON projects.owner_id = project_owners.id
+           JOIN users_owners ON project_owners.id = users_owners.owner_id
+           AND projects.name = $1
+           AND project_owners.name = $2
+        "#,
+        project,
+        owner,
+    )
+    .fetch_optional(&pool)
+    .await
+    {
+        Ok(Some(record)) => record,
+        Ok(None) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Project does not exist".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Err(err) => {
+            tracing::error!(?err, "Can't get projects: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    let release_records = match sqlx::query!(
+        r#"SELECT releases.id, releases.build_id, releases.image, releases.config, releases.description,
+            releases.created_at, releases.exit_code, releases.exit_signal, releases.oom_killed, releases.exited_at,
+            builds.commit_sha
+        FROM releases
+        JOIN builds ON builds.id = releases.build_id
+        WHERE releases.project_id = $1
+        AND ($2::timestamptz IS NULL OR (releases.created_at, releases.id) < ($2, $3::uuid))
+        ORDER BY releases.created_at DESC, releases.id DESC
+        LIMIT $4"#,
+        project_record.id,
+        before,
+        before_id,
+        limit
+    )
+    .fetch_all(&pool)
+    .await
+    {
+        Ok(records) => records,
+        Err(err) => {
+            tracing::error!(?err, "Can't get releases: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    let next_cursor = pagination::next_cursor(&release_records, limit, |record| (record.created_at, record.id));
+    let releases = release_records.into_iter().map(|record| {
+        Release {
+            id: record.id,
+            build_id: record.build_id,
+            image: record.image,
+            commit_sha: record.commit_sha,
+            config_digest: config_digest(&record.config),
+            description: record.description,
+            created_at: record.created_at,
+            live: project_record.live_release_id == Some(record.id),
+            pinned: project_record.pinned_release_id == Some(record.id),
+            exit_code: record.exit_code,
+            exit_signal: record.exit_signal,
+            oom_killed: record.oom_killed,
+            exited_at: record.exited_at,
+        }
+    }).collect::<Vec<_>>();
+
+    let json = serde_json::to_string(&ProjectReleaseListResponse {
+        data: releases,
+        next_cursor,
+    }).unwrap();
+
+    Response::builder()
+        .status(StatusCode::OK)
+        .body(Body::from(json))
+        .unwrap()
+}
@@ -0,0 +1,127 @@
+use axum::extract::{State, Path};
+use axum::response::Response;
+use hyper::{Body, StatusCode};
+use serde::Serialize;
+use uuid::Uuid;
+
+use crate::{auth::Auth, startup::AppState};
+
+#[derive(Serialize, Debug)]
+struct ProjectSettingsResponse {
+    id: Uuid,
+    healthcheck_path: Option<String>,
+    idle_timeout: Option<i32>,
+    source_dir: Option<String>,
+    watch_paths: Vec<String>,
+    internal: bool,
+    restart_policy: String,
+    restart_retries: Option<i32>,
+    protocol: String,
+    response_buffering: bool,
+    response_timeout: Option<i32>,
+    rate_limit: Option<i32>,
+    ip_rate_limit: Option<i32>,
+    rate_burst: Option<i32>,
+    body_limit: Option<i32>,
+    body_timeout: Option<i32>,
+    sticky_sessions: bool,
+    compression: bool,
+    edge_cache: bool,
+    https_redirect: bool,
+    hsts_max_age: Option<i32>,
+    hsts_preload: bool,
+    cloneable: bool,
+    block_severity: Option<String>,
+}
+
+#[derive(Serialize, Debug)]
+struct ErrorResponse {
+    message: String,
+}
+
+#[tracing::instrument(skip(auth, pool))]
+pub async fn get(
+    auth: Auth,
+    State(AppState { pool, .. }): State<AppState>,
+    Path((owner, project)): Path<(String, String)>,
+) -> Response<Body> {
+    let _user = auth.current_user.unwrap();
+
+    // check if project exist
+    let project = match sqlx::query!(
+        r#"SELECT projects.id AS id, projects.healthcheck_path, projects.idle_timeout,
+           projects.source_dir, projects.watch_paths, projects.internal, projects.restart_policy,
+           projects.restart_retries, projects.protocol, projects.response_buffering,
+           projects.response_timeout, projects.rate_limit, projects.ip_rate_limit, projects.rate_burst,
+           projects.body_limit, projects.body_timeout, projects.sticky_sessions, projects.compression, projects.edge_cache,
+           projects.https_redirect, projects.hsts_max_age, projects.hsts_preload, projects.cloneable,
+           projects.block_severity
+           FROM projects
+           JOIN project_owners ON projects.owner_id = project_owners.id
+           JOIN users_owners ON project_owners.id = users_owners.owner_id
+           AND projects.name = $1
+           AND project_owners.name = $2
+        "#,
+        project,
+        owner,
+    )
+    .fetch_optional(&pool)
+    .await
+    {
+        Ok(Some(record)) => record,
+        Ok(None) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Project does not exist".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Err(err) => {
+            tracing::error!(?err, "Can't get projects: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    let json = serde_json::to_string(&ProjectSettingsResponse {
+        id: project.id,
+        healthcheck_path: project.healthcheck_path,
+        idle_timeout: project.idle_timeout,
+        source_dir: project.source_dir,
+        watch_paths: project.watch_paths,
+        internal: project.internal,
+        restart_policy: project.restart_policy,
+        restart_retries: project.restart_retries,
+        protocol: project.protocol,
+        response_buffering: project.response_buffering,
+        response_timeout: project.response_timeout,
+        rate_limit: project.rate_limit,
+        ip_rate_limit: project.ip_rate_limit,
+        rate_burst: project.rate_burst,
+        body_limit: project.body_limit,
+        body_timeout: project.body_timeout,
+        sticky_sessions: project.sticky_sessions,
+        compression: project.compression,
+        edge_cache: project.edge_cache,
+        https_redirect: project.https_redirect,
+        hsts_max_age: project.hsts_max_age,
+        hsts_preload: project.hsts_preload,
+        cloneable: project.cloneable,
+        block_severity: project.block_severity,
+    }).unwrap();
+
+    Response::builder()
+        .status(StatusCode::OK)
+        .body(Body::from(json))
+        .unwrap()
+}
@@ -0,0 +1,86 @@
+use axum::extract::{State, Path};
+use axum::response::Response;
+use hyper::{Body, StatusCode};
+use serde::Serialize;
+
+use crate::{auth::Auth, startup::AppState};
+
+#[derive(Serialize, Debug)]
+struct PushPolicyResponse {
+    /// branches besides the default one that may be pushed, empty allows any
+    branches: Vec<String>,
+    /// in MiB, None leaves it to the platform
+    max_size: Option<i32>,
+    scan_secrets: bool,
+    /// in MiB, None when the platform has no limit of its own
+    platform_max_size: Option<i64>,
+    /// the platform scans every push
+    platform_scan_secrets: bool,
+}
+
+#[derive(Serialize, Debug)]
+struct ErrorResponse {
+    message: String,
+}
+
+#[tracing::instrument(skip(auth, pool, git_settings))]
+pub async fn get(
+    auth: Auth,
+    State(AppState { pool, git_settings, .. }): State<AppState>,
+    Path((owner, project)): Path<(String, String)>,
+) -> Response<Body> {
+    let _user = auth.current_user.unwrap();
+
+    // check if project exist
+    let project = match sqlx::query!(
+        r#"SELECT projects.id AS id, projects.push_branches, projects.push_max_size, projects.push_secret_scan
+           FROM projects
+           JOIN project_owners ON projects.owner_id = project_owners.id
+           JOIN users_owners ON project_owners.id = users_owners.owner_id
+           AND projects.name = $1
+           AND project_owners.name = $2
+        "#,
+        project,
+        owner,
+    )
+    .fetch_optional(&pool)
+    .await
+    {
+        Ok(Some(record)) => record,
+        Ok(None) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Project does not exist".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Err(err) => {
+            tracing::error!(?err, "Can't get projects: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    let json = serde_json::to_string(&PushPolicyResponse {
+        branches: project.push_branches,
+        max_size: project.push_max_size,
+        scan_secrets: project.push_secret_scan,
+        platform_max_size: (git_settings.maxpushsize > 0).then_some(git_settings.maxpushsize),
+        platform_scan_secrets: git_settings.secretscan,
+    }).unwrap();
+
+    Response::builder()
+        .status(StatusCode::OK)
+        .body(Body::from(json))
+        .unwrap()
+}
@@ -0,0 +1,95 @@
+use axum::extract::{State, Path};
+use axum::response::Response;
+use chrono::{DateTime, Utc};
+use hyper::{Body, StatusCode};
+use serde::Serialize;
+
+use crate::redis::{redis_stats, RedisStats};
+use crate::{auth::Auth, startup::AppState};
+
+#[derive(Serialize, Debug)]
+struct RedisResponse {
+    name: String,
+    /// in MiB, past it the least recently used keys are evicted
+    memory: i32,
+    /// None when the server didn't answer
+    stats: Option<RedisStats>,
+    created_at: DateTime<Utc>,
+}
+
+#[derive(Serialize, Debug)]
+struct ErrorResponse {
+    message: String,
+}
+
+/// The redis of the app with what its server says about memory, load and keys right now
+#[tracing::instrument(skip(auth, pool))]
+pub async fn get(
+    auth: Auth,
+    State(AppState { pool, .. }): State<AppState>,
+    Path((owner, project)): Path<(String, String)>,
+) -> Response<Body> {
+    let _user = auth.current_user.unwrap();
+
+    let addon = match sqlx::query!(
+        r#"SELECT addons.name, addons.quota, addons.created_at
+           FROM addons
+           JOIN projects ON addons.project_id = projects.id
+           JOIN project_owners ON projects.owner_id = project_owners.id
+           WHERE projects.name = $1
+           AND project_owners.name = $2
+           AND addons.kind = 'redis'
+        "#,
+        project,
+        owner,
+    )
+    .fetch_optional(&pool)
+    .await
+    {
+        Ok(Some(addon)) => addon,
+        Ok(None) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Project does not have a redis".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::NOT_FOUND)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Err(err) => {
+            tracing::error!(?err, "Can't get redis: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    let container_name = format!("{owner}-{}", project.trim_end_matches(".git")).replace('.', "-");
+    let stats = match redis_stats(&container_name).await {
+        Ok(stats) => Some(stats),
+        Err(err) => {
+            // a restarting server is still worth showing
+            tracing::warn!(?err, "Can't get redis stats");
+            None
+        }
+    };
+
+    let json = serde_json::to_string(&RedisResponse {
+        name: addon.name,
+        memory: addon.quota.unwrap_or_default(),
+        stats,
+        created_at: addon.created_at,
+    }).unwrap();
+
+    Response::builder()
+        .status(StatusCode::OK)
+        .body(Body::from(json))
+        .unwrap()
+}
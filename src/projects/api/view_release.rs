@@ -0,0 +1,165 @@
+use axum::extract::{State, Path};
+use axum::response::Response;
+use chrono::{DateTime, Utc};
+use hyper::{Body, StatusCode};
+use serde::Serialize;
+use uuid::Uuid;
+
+use crate::releases::config_digest;
+use crate::scanning::{release_scan, Scan};
+use crate::{auth::Auth, startup::AppState};
+
+#[derive(Serialize, Debug)]
+struct ReleaseDetailResponse {
+    id: Uuid,
+    build_id: Uuid,
+    image: String,
+    /// of the build the image was made from, None for images pulled from a registry
+    commit_sha: Option<String>,
+    config_digest: String,
+    description: String,
+    created_at: DateTime<Utc>,
+    live: bool,
+    pinned: bool,
+    exit_code: Option<i32>,
+    exit_signal: Option<String>,
+    oom_killed: bool,
+    exited_at: Option<DateTime<Utc>>,
+    /// vulnerabilities in the image, None when it wasn't scanned
+    scan: Option<Scan>,
+}
+
+#[derive(Serialize, Debug)]
+struct ErrorResponse {
+    message: String,
+}
+
+/// One release of the app with the vulnerabilities found in its image
+#[tracing::instrument(skip(auth, pool))]
+pub async fn get(
+    auth: Auth,
+    State(AppState { pool, .. }): State<AppState>,
+    Path((owner, project, release_id)): Path<(String, String, Uuid)>,
+) -> Response<Body> {
+    let _user = auth.current_user.unwrap();
+
+    // check if project exist
+    let project_record = match sqlx::query!(
+        r#"SELECT projects.id AS id, projects.pinned_release_id
+           FROM projects
+           JOIN project_owners ON projects.owner_id = project_owners.id
+           JOIN users_owners ON project_owners.id = users_owners.owner_id
+           AND projects.name = $1
+           AND project_owners.name = $2
+        "#,
+        project,
+        owner,
+    )
+    .fetch_optional(&pool)
+    .await
+    {
+        Ok(Some(record)) => record,
+        Ok(None) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Project does not exist".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Err(err) => {
+            tracing::error!(?err, "Can't get projects: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    let release = match sqlx::query!(
+        r#"SELECT releases.id, releases.build_id, releases.image, releases.config, releases.description,
+            releases.created_at, releases.exit_code, releases.exit_signal, releases.oom_killed, releases.exited_at,
+            builds.commit_sha,
+            NOT EXISTS(
+                SELECT 1 FROM releases AS newer
+                WHERE newer.project_id = releases.project_id AND newer.created_at > releases.created_at
+            ) AS "live!"
+        FROM releases
+        JOIN builds ON builds.id = releases.build_id
+        WHERE releases.id = $1 AND releases.project_id = $2"#,
+        release_id,
+        project_record.id
+    )
+    .fetch_optional(&pool)
+    .await
+    {
+        Ok(Some(record)) => record,
+        Ok(None) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Release {release_id} does not exist")
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::NOT_FOUND)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Err(err) => {
+            tracing::error!(?err, "Can't get release: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    let scan = match release_scan(&release.image, project_record.id, &pool).await {
+        Ok(scan) => scan,
+        Err(err) => {
+            tracing::error!(?err, "Can't get image scan: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    let json = serde_json::to_string(&ReleaseDetailResponse {
+        id: release.id,
+        build_id: release.build_id,
+        image: release.image,
+        commit_sha: release.commit_sha,
+        config_digest: config_digest(&release.config),
+        description: release.description,
+        created_at: release.created_at,
+        live: release.live,
+        pinned: project_record.pinned_release_id == Some(release.id),
+        exit_code: release.exit_code,
+        exit_signal: release.exit_signal,
+        oom_killed: release.oom_killed,
+        exited_at: release.exited_at,
+        scan,
+    }).unwrap();
+
+    Response::builder()
+        .status(StatusCode::OK)
+        .body(Body::from(json))
+        .unwrap()
+}
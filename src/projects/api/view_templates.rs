@@ -0,0 +1,85 @@
+use axum::extract::State;
+use axum::response::Response;
+use chrono::{DateTime, Utc};
+use hyper::{Body, StatusCode};
+use serde::Serialize;
+
+use crate::templates::BUILTINS;
+use crate::{auth::Auth, startup::AppState};
+
+#[derive(Serialize, Debug)]
+pub struct Template {
+    /// `go-http` for the ones the platform ships with, `owner/name` for registered ones
+    pub name: String,
+    pub description: String,
+    pub builtin: bool,
+    pub updated_at: Option<DateTime<Utc>>,
+}
+
+#[derive(Serialize, Debug)]
+struct ViewTemplatesResponse {
+    data: Vec<Template>,
+}
+
+#[derive(Serialize, Debug)]
+struct ErrorResponse {
+    message: String,
+}
+
+/// Templates new apps can start from, the built in ones first. Anyone signed in sees every
+/// template, so students find the ones of their course
+#[tracing::instrument(skip(auth, pool))]
+pub async fn get(
+    auth: Auth,
+    State(AppState { pool, .. }): State<AppState>,
+) -> Response<Body> {
+    let _user = auth.current_user.unwrap();
+
+    let registered = match sqlx::query!(
+        r#"SELECT project_owners.name AS owner, templates.name, templates.description, templates.updated_at
+           FROM templates
+           JOIN project_owners ON templates.owner_id = project_owners.id
+           ORDER BY project_owners.name, templates.name
+        "#
+    )
+    .fetch_all(&pool)
+    .await
+    {
+        Ok(templates) => templates,
+        Err(err) => {
+            tracing::error!(?err, "Can't get templates: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    let data = BUILTINS
+        .iter()
+        .map(|template| Template {
+            name: template.name.to_string(),
+            description: template.description.to_string(),
+            builtin: true,
+            updated_at: None,
+        })
+        .chain(registered.into_iter().map(|template| Template {
+            name: format!("{}/{}", template.owner, template.name),
+            description: template.description,
+            builtin: false,
+            updated_at: Some(template.updated_at),
+        }))
+        .collect();
+
+    let json = serde_json::to_string(&ViewTemplatesResponse { data }).unwrap();
+
+    Response::builder()
+        .status(StatusCode::OK)
+        .body(Body::from(json))
+        .unwrap()
+}
@@ -0,0 +1,153 @@
+use axum::extract::{State, Path};
+use axum::response::Response;
+use chrono::{DateTime, Utc};
+use hyper::{Body, StatusCode};
+use serde::Serialize;
+
+use crate::limits::project_limits;
+use crate::volumes::{volume_name, volume_usage};
+use crate::{auth::Auth, startup::AppState};
+
+#[derive(Serialize, Debug)]
+pub struct Volume {
+    pub name: String,
+    pub mount_path: String,
+    pub size_mb: i32,
+    /// none when docker couldn't tell
+    pub used_bytes: Option<i64>,
+    pub created_at: DateTime<Utc>,
+}
+
+#[derive(Serialize, Debug)]
+struct ViewVolumesResponse {
+    data: Vec<Volume>,
+    /// the sizes of all volumes of the app add up to at most this, its disk limit
+    quota_mb: i32,
+}
+
+#[derive(Serialize, Debug)]
+struct ErrorResponse {
+    message: String,
+}
+
+#[tracing::instrument(skip(auth, pool))]
+pub async fn get(
+    auth: Auth,
+    State(AppState { pool, container_settings, .. }): State<AppState>,
+    Path((owner, project)): Path<(String, String)>,
+) -> Response<Body> {
+    let _user = auth.current_user.unwrap();
+
+    // check if project exist
+    let project_record = match sqlx::query!(
+        r#"SELECT projects.id AS id
+           FROM projects
+           JOIN project_owners ON projects.owner_id = project_owners.id
+           JOIN users_owners ON project_owners.id = users_owners.owner_id
+           AND projects.name = $1
+           AND project_owners.name = $2
+        "#,
+        project,
+        owner,
+    )
+    .fetch_optional(&pool)
+    .await
+    {
+        Ok(Some(record)) => record,
+        Ok(None) => {
+            let json = serde_json::to_string(&ErrorResponse {
+                message: "Project does not exist".to_string()
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::BAD_REQUEST)
+                .body(Body::from(json))
+                .unwrap();
+        }
+        Err(err) => {
+            tracing::error!(?err, "Can't get projects: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    let volumes = match sqlx::query!(
+        r#"SELECT name, mount_path, size_mb, created_at
+           FROM volumes
+           WHERE project_id = $1
+           ORDER BY name
+        "#,
+        project_record.id
+    )
+    .fetch_all(&pool)
+    .await
+    {
+        Ok(volumes) => volumes,
+        Err(err) => {
+            tracing::error!(?err, "Can't get volumes: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    let quota_mb = match project_limits(project_record.id, &container_settings, &pool).await {
+        Ok(limits) => limits.disk,
+        Err(err) => {
+            tracing::error!(?err, "Can't get limits: Failed to query database");
+
+            let json = serde_json::to_string(&ErrorResponse {
+                message: format!("Failed to query database: {}", err.to_string())
+            }).unwrap();
+
+            return Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::from(json))
+                .unwrap();
+        }
+    };
+
+    let container_name = format!("{owner}-{}", project.trim_end_matches(".git")).replace('.', "-");
+    // the listing is still useful without the usage
+    let usage = match volumes.is_empty() {
+        true => Default::default(),
+        false => volume_usage(&container_name).await.unwrap_or_else(|err| {
+            tracing::error!(?err, "Can't get volume usage: Failed to query docker");
+            Default::default()
+        }),
+    };
+
+    let data = volumes
+        .into_iter()
+        .map(|volume| Volume {
+            used_bytes: usage.get(&volume_name(&container_name, &volume.name)).copied(),
+            name: volume.name,
+            mount_path: volume.mount_path,
+            size_mb: volume.size_mb,
+            created_at: volume.created_at,
+        })
+        .collect();
+
+    let json = serde_json::to_string(&ViewVolumesResponse {
+        data,
+        quota_mb,
+    }).unwrap();
+
+    Response::builder()
+        .status(StatusCode::OK)
+        .body(Body::from(json))
+        .unwrap()
+}
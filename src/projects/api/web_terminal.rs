@@ -1,11 +1,13 @@
 use std::{net::SocketAddr, time::Duration, borrow::Cow};
 
 use axum::{extract::{WebSocketUpgrade, Path, ConnectInfo, ws::{Message, CloseFrame}}, TypedHeader, headers, response::IntoResponse};
-use bollard::{Docker, exec::{CreateExecOptions, StartExecResults}};
+use bollard::exec::{CreateExecOptions, StartExecResults};
 use futures_util::{StreamExt, SinkExt};
 use tokio::io::AsyncWriteExt;
 use serde::{Deserialize, Serialize};
 
+use crate::nodes;
+
 #[derive(Default, Debug, Clone, PartialEq, Serialize, Deserialize)]
 #[serde(rename_all = "camelCase")]
 pub struct WsRequest {
@@ -60,7 +62,8 @@ pub async fn ws(
                 }
             }
 
-            let docker = match Docker::connect_with_local_defaults() {
+            let container_name = format!("{owner}-{}", project.trim_end_matches(".git")).replace('.', "-");
+            let docker = match nodes::docker(&container_name) {
                 Ok(docker) => docker,
                 Err(err) => {
                     tracing::error!(?err, "Can't start terminal: Failed to connect to docker");
@@ -68,7 +71,6 @@ pub async fn ws(
                 }
             };
 
-            let container_name = format!("{owner}-{}", project.trim_end_matches(".git")).replace('.', "-");
             let exec = match docker
                 .create_exec(
                     &container_name,
@@ -1 +1,372 @@
+use std::collections::HashMap;
+use std::fs::File;
+
+use bollard::container::{RemoveContainerOptions, StopContainerOptions};
+use bollard::network::InspectNetworkOptions;
+use sqlx::PgPool;
+
+use crate::backups::BackupStorage;
+use crate::docker::remove_canary;
+use crate::nodes;
+use crate::orchestrator;
+use crate::ports;
+use crate::previews::remove_preview_containers;
+use crate::redis::remove_redis;
+use crate::sites;
+use crate::storage::remove_bucket;
+use crate::volumes::prune_volumes;
+
 pub mod api;
+
+/// What the app lists say an app is doing and filter it by. Stopped is by a member or by the
+/// cleanup, suspended by the platform admins, new is before its first release
+pub const APP_STATUSES: [&str; 6] = ["running", "new", "stopped", "suspended", "crashing", "maintenance"];
+
+/// Deletes an app with everything it has: its rows, repository, containers, image, database,
+/// backups, bucket, redis, volumes and network. Tells what happened to each, like "successfully deleted"
+pub async fn remove_project(
+    owner: &str,
+    project: &str,
+    base: &str,
+    backups: &BackupStorage,
+    pool: &PgPool,
+) -> HashMap<&'static str, &'static str> {
+    let path = match project.ends_with(".git") {
+        true => format!("{base}/{owner}/{project}"),
+        false => format!("{base}/{owner}/{project}.git"),
+    };
+
+    //TODO: better error log
+    let mut status: HashMap<&'static str, &'static str> = HashMap::new();
+    let mut previews = Vec::new();
+    let mut bucket = None;
+    let mut redis = false;
+
+    // check if owner exist
+    match sqlx::query!(
+        r#"SELECT id FROM project_owners WHERE name = $1 AND deleted_at IS NULL"#,
+        owner,
+    )
+    .fetch_optional(pool)
+    .await
+    {
+        Ok(Some(data)) => {
+            // check if project exist
+            match sqlx::query!(
+                r#"SELECT id FROM projects WHERE name = $1 AND owner_id = $2"#,
+                project,
+                data.id,
+            )
+            .fetch_optional(pool)
+            .await
+            {
+                Ok(Some(project)) => {
+                    // the rows go with the project, their containers are removed below
+                    match sqlx::query!("SELECT name FROM previews WHERE project_id = $1", project.id)
+                        .fetch_all(pool)
+                        .await
+                    {
+                        Ok(rows) => previews = rows.into_iter().map(|row| row.name).collect(),
+                        Err(err) => {
+                            tracing::error!(?err, "Can't delete project: Failed to query previews");
+                        }
+                    }
+
+                    // the bucket is on the storage, nothing else knows about it once the row is gone
+                    match sqlx::query!(
+                        "SELECT url FROM addons WHERE project_id = $1 AND kind = 's3'",
+                        project.id
+                    )
+                    .fetch_optional(pool)
+                    .await
+                    {
+                        Ok(addon) => bucket = addon.map(|addon| addon.url),
+                        Err(err) => {
+                            tracing::error!(?err, "Can't delete project: Failed to query addons");
+                        }
+                    }
+
+                    match sqlx::query!(
+                        "SELECT id FROM addons WHERE project_id = $1 AND kind = 'redis'",
+                        project.id
+                    )
+                    .fetch_optional(pool)
+                    .await
+                    {
+                        Ok(addon) => redis = addon.is_some(),
+                        // its name is known, removing one that isn't there does no harm
+                        Err(err) => {
+                            tracing::error!(?err, "Can't delete project: Failed to query addons");
+                            redis = true;
+                        }
+                    }
+
+                    match sqlx::query!(
+                        "DELETE FROM projects WHERE name = $1 AND owner_id = $2",
+                        project,
+                        data.id
+                    )
+                    .execute(pool)
+                    .await
+                    {
+                        Ok(_) => {
+                            status.insert("project", "successfully deleted");
+                            // the leases went with it, their listeners close now
+                            ports::changed();
+                        }
+                        Err(err) => {
+                            tracing::error!(?err, "Can't delete project: Failed to delete project");
+                            status.insert("project", "failed to delete: database error");
+                        }
+                    }
+                }
+                Err(err) => {
+                    tracing::error!(?err, "Can't delete project: Failed to query database");
+                    status.insert("project", "failed to delete: database error");
+                }
+                _ => {
+                    status.insert("project", "failed to delete: project does not exist");
+                }
+            };
+        }
+        Ok(None) => {
+            tracing::debug!("Can't delete project: Owner does not exist");
+        }
+        Err(err) => {
+            tracing::error!(?err, "Can't get project_owners: Failed to query database");
+        }
+    }
+
+    // check if repo exists
+    match File::open(&path) {
+        Err(err) => {
+            tracing::debug!(?err, "Can't delete project: Repo does not exist");
+            status.insert("repo", "failed to delete: repo does not exist");
+        }
+        Ok(_) => match std::fs::remove_dir_all(&path) {
+            Ok(_) => {
+                status.insert("repo", "successfully deleted");
+            }
+            Err(err) => {
+                tracing::error!(?err, "Can't delete project: Failed to delete repo");
+                status.insert("repo", "failed to delete: repo error");
+            }
+        },
+    };
+
+    let container_name = format!("{owner}-{}", project.trim_end_matches(".git")).replace('.', "-");
+    let db_name = format!("{}-db", container_name);
+    let network_name = format!("{}-network", container_name);
+    let volume_name = format!("{}-volume", container_name);
+
+    let docker = match nodes::docker(&container_name) {
+        Err(err) => {
+            tracing::error!(?err, "Can't delete project: Failed to connect to docker");
+            status.insert("container", "failed to delete: docker error");
+            return status;
+        }
+        Ok(docker) => docker,
+    };
+
+    // remove container
+    match docker.inspect_container(&container_name, None).await {
+        Ok(_) => {
+            match docker
+                .stop_container(&container_name, None::<StopContainerOptions>)
+                .await
+            {
+                Ok(_) => {
+                    match docker
+                        .remove_container(&container_name, None::<RemoveContainerOptions>)
+                        .await
+                    {
+                        Ok(_) => {
+                            status.insert("container", "successfully deleted");
+                        }
+                        Err(err) => {
+                            tracing::error!(
+                                ?err,
+                                "Can't delete project: Failed to delete container"
+                            );
+                            status.insert("container", "failed to delete: container error");
+                        }
+                    }
+                }
+                Err(err) => {
+                    tracing::error!(?err, "Can't delete project: Failed to stop container");
+                    status.insert("container", "failed to delete: container error");
+                }
+            };
+        }
+        Err(err) => {
+            tracing::debug!(?err, "Can't delete project: Container does not exist");
+            status.insert("container", "failed to delete: container does not exist");
+        }
+    };
+
+    // a deploy still waiting for its readiness check runs under a separate name
+    let _ = docker
+        .remove_container(
+            &format!("{}-next", container_name),
+            Some(RemoveContainerOptions {
+                force: true,
+                ..Default::default()
+            }),
+        )
+        .await;
+
+    if let Err(err) = orchestrator::driver().remove(&container_name).await {
+        tracing::error!(?err, "Can't delete project: Failed to delete workers");
+    }
+
+    if let Err(err) = remove_canary(&container_name).await {
+        tracing::error!(?err, "Can't delete project: Failed to delete canary");
+    }
+
+    if let Err(err) = sites::remove(&container_name).await {
+        tracing::error!(?err, "Can't delete project: Failed to delete static site");
+    }
+
+    for preview in &previews {
+        if let Err(err) = remove_preview_containers(preview).await {
+            tracing::error!(?err, preview, "Can't delete project: Failed to delete preview");
+        }
+    }
+
+    // remove image
+    match docker.inspect_image(&container_name).await {
+        Ok(_) => match docker.remove_image(&container_name, None, None).await {
+            Ok(_) => {
+                status.insert("image", "successfully deleted");
+            }
+            Err(err) => {
+                tracing::error!(?err, "Can't delete project: Failed to delete image");
+                status.insert("image", "failed to delete: image error");
+            }
+        },
+        Err(err) => {
+            tracing::debug!(?err, "Can't delete project: Image does not exist");
+            status.insert("image", "failed to delete: image does not exist");
+        }
+    };
+
+    // remove database
+    match docker.inspect_container(&db_name, None).await {
+        Ok(_) => {
+            match docker
+                .stop_container(&db_name, None::<StopContainerOptions>)
+                .await
+            {
+                Ok(_) => {
+                    match docker
+                        .remove_container(&db_name, None::<RemoveContainerOptions>)
+                        .await
+                    {
+                        Ok(_) => {
+                            status.insert("db", "successfully deleted");
+                        }
+                        Err(err) => {
+                            tracing::error!(?err, "Can't delete project: Failed to delete db");
+                            status.insert("db", "failed to delete: container error");
+                        }
+                    }
+                }
+                Err(err) => {
+                    tracing::error!(?err, "Can't delete project: Failed to stop db");
+                    status.insert("db", "failed to delete: container error");
+                }
+            };
+        }
+        Err(err) => {
+            tracing::debug!(?err, "Can't delete project: db does not exist");
+            status.insert("db", "failed to delete: container does not exist");
+        }
+    };
+
+    // delete backups
+    if backups.enabled() {
+        match backups.delete_all(&db_name).await {
+            Ok(_) => {
+                status.insert("backups", "successfully deleted");
+            }
+            Err(err) => {
+                tracing::error!(?err, "Can't delete project: Failed to delete backups");
+                status.insert("backups", "failed to delete: storage error");
+            }
+        }
+    }
+
+    // delete bucket
+    if let Some(url) = bucket {
+        match remove_bucket(&container_name, &url).await {
+            Ok(_) => {
+                status.insert("bucket", "successfully deleted");
+            }
+            Err(err) => {
+                tracing::error!(?err, "Can't delete project: Failed to delete bucket");
+                status.insert("bucket", "failed to delete: storage error");
+            }
+        }
+    }
+
+    // remove redis
+    if redis {
+        match remove_redis(&container_name).await {
+            Ok(_) => {
+                status.insert("redis", "successfully deleted");
+            }
+            Err(err) => {
+                tracing::error!(?err, "Can't delete project: Failed to delete redis");
+                status.insert("redis", "failed to delete: container error");
+            }
+        }
+    }
+
+    // delete volume
+    match docker.inspect_volume(&volume_name).await {
+        Ok(_) => match docker.remove_volume(&volume_name, None).await {
+            Ok(_) => {
+                status.insert("volume", "successfully deleted");
+            }
+            Err(err) => {
+                tracing::error!(?err, "Can't delete project: Failed to delete volume");
+                status.insert("volume", "failed to delete: volume error");
+            }
+        },
+        Err(err) => {
+            tracing::debug!(?err, "Can't delete project: volume does not exist");
+            status.insert("volume", "failed to delete: volume does not exist");
+        }
+    };
+
+    // delete app volumes, their containers are gone already
+    prune_volumes(&container_name, &[]).await;
+
+    // remove network
+    match docker
+        .inspect_network(
+            &network_name,
+            Some(InspectNetworkOptions::<&str> {
+                verbose: true,
+                ..Default::default()
+            }),
+        )
+        .await
+    {
+        Ok(_) => match docker.remove_network(&network_name).await {
+            Ok(_) => {
+                status.insert("network", "successfully deleted");
+            }
+            Err(err) => {
+                tracing::error!(?err, "Can't delete project: Failed to delete network");
+                status.insert("network", "failed to delete: network error");
+            }
+        },
+        Err(err) => {
+            tracing::debug!(?err, "Can't delete project: network does not exist");
+            status.insert("network", "failed to delete: network does not exist");
+        }
+    };
+
+    status
+}
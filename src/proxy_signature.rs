@@ -0,0 +1,101 @@
+//! Lets an app tell the requests that came through the proxy from ones that reached its
+//! container some other way, like from another container on a shared network. The proxy
+//! signs every request with a key of the app, which the app gets as
+//! [`SECRET_ENV`]. The signature covers when the request was forwarded, its method, host,
+//! path, id and client address as the app gets them, so an app that checks it can trust the
+//! X-Forwarded-For, X-Forwarded-Host and X-Request-Id it reads. The body isn't signed, it is
+//! streamed on as it comes
+//!
+//! A rotated key keeps signing next to the new one for [`ROTATION_GRACE`], the app gets the
+//! new one with the release the rotation starts
+
+use std::time::Duration;
+
+use chrono::{DateTime, Utc};
+use data_encoding::HEXLOWER;
+use hmac::{Hmac, Mac};
+use hyper::header::{HeaderMap, HeaderValue};
+use rand::RngCore;
+use sha2::Sha256;
+
+use crate::header_rules::Vars;
+
+/// variable the app gets its key in
+pub const SECRET_ENV: &str = "PEMASAK_PROXY_SECRET";
+/// unix seconds when the proxy forwarded the request
+pub const TIMESTAMP_HEADER: &str = "x-pemasak-proxy-timestamp";
+/// `sha256=` and the hex hmac, two of them separated by a comma while a rotated key still
+/// signs
+pub const SIGNATURE_HEADER: &str = "x-pemasak-proxy-signature";
+
+/// how long the previous key of an app keeps signing after a rotation, the release with the
+/// new one is live well before
+pub const ROTATION_GRACE: Duration = Duration::from_secs(60 * 60);
+
+/// A new key for an app
+pub fn generate_secret() -> String {
+    let mut secret = [0u8; 32];
+    rand::thread_rng().fill_bytes(&mut secret);
+    HEXLOWER.encode(&secret)
+}
+
+/// The keys the proxy signs the requests to an app with, the previous one only until its
+/// grace is over
+pub fn signing_secrets(
+    secret: String,
+    previous: Option<String>,
+    rotated_at: Option<DateTime<Utc>>,
+) -> Vec<String> {
+    let mut secrets = vec![secret];
+    if let (Some(previous), Some(rotated_at)) = (previous, rotated_at) {
+        // a clock that went back counts as within it
+        if (Utc::now() - rotated_at).to_std().map_or(true, |age| age < ROTATION_GRACE) {
+            secrets.push(previous);
+        }
+    }
+    secrets
+}
+
+/// What is signed, one field per line: timestamp, method, host, path with the query, request
+/// id and client address. They are read from the headers going to the app, the way the app
+/// reads them, after the header rules of the owners changed them
+fn payload(timestamp: i64, method: &str, path: &str, headers: &HeaderMap, vars: &Vars) -> String {
+    let header = |name: &str| headers.get(name).and_then(|value| value.to_str().ok()).unwrap_or("");
+    // the app falls back to the host it was asked for too, over http/2 it isn't a header
+    let host = match header("x-forwarded-host") {
+        "" => vars.host,
+        host => host,
+    };
+    format!(
+        "{timestamp}\n{method}\n{host}\n{path}\n{}\n{}",
+        header("x-request-id"),
+        header("x-forwarded-for")
+    )
+}
+
+/// Signs a request on its way to the app, replacing whatever the client sent in the signature
+/// headers. Without keys, like for a static site, the headers are only removed
+pub fn sign(headers: &mut HeaderMap, secrets: &[String], method: &str, path: &str, vars: &Vars) {
+    headers.remove(TIMESTAMP_HEADER);
+    headers.remove(SIGNATURE_HEADER);
+    if secrets.is_empty() {
+        return;
+    }
+
+    let timestamp = Utc::now().timestamp();
+    let payload = payload(timestamp, method, path, headers, vars);
+    let signatures = secrets
+        .iter()
+        .map(|secret| {
+            let mut mac = Hmac::<Sha256>::new_from_slice(secret.as_bytes())
+                .expect("hmac takes keys of any length");
+            mac.update(payload.as_bytes());
+            format!("sha256={}", HEXLOWER.encode(&mac.finalize().into_bytes()))
+        })
+        .collect::<Vec<_>>()
+        .join(", ");
+
+    headers.insert(TIMESTAMP_HEADER, HeaderValue::from(timestamp));
+    // hex and digits are always a valid header value
+    headers.insert(SIGNATURE_HEADER, HeaderValue::from_str(&signatures).unwrap());
+}
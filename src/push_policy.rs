@@ -0,0 +1,419 @@
+use std::io::Read;
+use std::process::Stdio;
+
+use anyhow::{bail, Result};
+use hyper::{Body, HeaderMap, Response, StatusCode};
+use lazy_static::lazy_static;
+use regex::Regex;
+use sqlx::PgPool;
+use tokio::{io::AsyncWriteExt, process::Command};
+use ulid::Ulid;
+
+use crate::auth::tokens::TOKEN_PREFIX;
+use crate::configuration::GitSettings;
+
+/// the new commit of a ref a push deletes
+const ZERO: &str = "0000000000000000000000000000000000000000";
+/// a line with this in it isn't scanned, for values that only look like a credential
+pub const ALLOW_MARKER: &str = "pmk:allow-secret";
+/// findings listed when a push is rejected, the rest are only counted
+const MAX_FINDINGS: usize = 10;
+/// a side-band packet of plain side-band, side-band-64k clients take those too
+const BAND_SIZE: usize = 995;
+
+lazy_static! {
+    static ref SECRETS: Vec<(&'static str, Regex)> = vec![
+        ("private key", Regex::new(r"-----BEGIN ([A-Z]+ )?PRIVATE KEY-----").unwrap()),
+        ("AWS access key", Regex::new(r"\b(AKIA|ASIA)[0-9A-Z]{16}\b").unwrap()),
+        ("GitHub token", Regex::new(r"\b(gh[pousr]_[A-Za-z0-9]{36}|github_pat_[A-Za-z0-9_]{82})\b").unwrap()),
+        ("GitLab token", Regex::new(r"\bglpat-[A-Za-z0-9_-]{20}\b").unwrap()),
+        ("Slack token", Regex::new(r"\bxox[abprs]-[A-Za-z0-9-]{10,}").unwrap()),
+        ("Google API key", Regex::new(r"\bAIza[0-9A-Za-z_-]{35}\b").unwrap()),
+        ("Stripe key", Regex::new(r"\b[sr]k_live_[0-9A-Za-z]{24,}\b").unwrap()),
+        ("pemasak API token", Regex::new(&format!(r"\b{TOKEN_PREFIX}[A-Za-z0-9_-]{{40}}")).unwrap()),
+    ];
+}
+
+/// What an app lets through a push before git takes it, set with `pmk push-policy`
+#[derive(Debug, Clone, Default)]
+pub struct PushPolicy {
+    /// branches besides the default one that may be pushed, like `feature/*`. Empty allows
+    /// any
+    pub branches: Vec<String>,
+    /// in MiB, the app or the platform may set one, the lower counts
+    pub max_size: Option<i64>,
+    /// added lines are scanned for credentials, also when the platform scans every app
+    pub scan_secrets: bool,
+}
+
+/// One ref a push updates. The commands come in pkt-lines before the pack
+#[derive(Debug, Clone)]
+pub struct RefUpdate {
+    pub old: String,
+    pub new: String,
+    pub name: String,
+}
+
+impl RefUpdate {
+    fn deletes(&self) -> bool {
+        self.new == ZERO
+    }
+}
+
+/// A receive-pack request taken apart
+#[derive(Debug, Default)]
+pub struct Push {
+    pub commands: Vec<RefUpdate>,
+    /// sent after a nul on the first command
+    pub capabilities: Vec<String>,
+    pub pack: Vec<u8>,
+}
+
+impl Push {
+    /// The refs the push updates, as the new commit and the ref name
+    pub fn refs(&self) -> Vec<(String, String)> {
+        self.commands.iter().map(|command| (command.new.clone(), command.name.clone())).collect()
+    }
+}
+
+/// A push the policy refused, with a short reason for each ref and the lines telling the
+/// pusher why and what to do
+#[derive(Debug)]
+pub struct Rejection {
+    pub reason: &'static str,
+    pub details: Vec<String>,
+}
+
+/// Takes the body of a receive-pack request apart. A body that can't be read comes back
+/// empty, git tells the client what is wrong with it
+pub fn read_push(headers: &HeaderMap, body: &[u8]) -> Push {
+    let decoded;
+    let body = match headers.get("Content-Encoding").and_then(|enc| enc.to_str().ok()) {
+        Some("gzip") => {
+            let mut buf = Vec::new();
+            if flate2::read::GzDecoder::new(body).read_to_end(&mut buf).is_err() {
+                return Push::default();
+            }
+            decoded = buf;
+            decoded.as_slice()
+        }
+        _ => body,
+    };
+
+    let mut push = Push::default();
+    let mut rest = body;
+    while rest.len() >= 4 {
+        let len = match std::str::from_utf8(&rest[..4]).ok().and_then(|len| usize::from_str_radix(len, 16).ok()) {
+            Some(len) if len >= 4 && len <= rest.len() => len,
+            // a flush ends the commands, the pack follows
+            Some(0) => {
+                rest = &rest[4..];
+                break;
+            }
+            _ => break,
+        };
+        let line = String::from_utf8_lossy(&rest[4..len]);
+        let mut parts = line.trim_end().split('\0');
+        let command = parts.next().unwrap_or("");
+        if let Some(capabilities) = parts.next() {
+            push.capabilities = capabilities.split(' ').map(str::to_string).collect();
+        }
+        if let [old, new, name] = command.split(' ').collect::<Vec<_>>()[..] {
+            push.commands.push(RefUpdate {
+                old: old.to_string(),
+                new: new.to_string(),
+                name: name.to_string(),
+            });
+        }
+        rest = &rest[len..];
+    }
+    push.pack = rest.to_vec();
+    push
+}
+
+/// The policy of `owner/project` with the limits of the platform on top
+pub async fn push_policy(owner: &str, project: &str, git: &GitSettings, pool: &PgPool) -> Result<PushPolicy, sqlx::Error> {
+    let project = sqlx::query!(
+        r#"SELECT projects.push_branches, projects.push_max_size, projects.push_secret_scan
+           FROM projects
+           JOIN project_owners ON projects.owner_id = project_owners.id
+           WHERE project_owners.name = $1 AND projects.name = $2
+        "#,
+        owner,
+        project.trim_end_matches(".git")
+    )
+    .fetch_optional(pool)
+    .await?;
+
+    let platform = (git.maxpushsize > 0).then_some(git.maxpushsize);
+    let policy = match project {
+        Some(project) => PushPolicy {
+            branches: project.push_branches,
+            max_size: match (project.push_max_size.map(i64::from), platform) {
+                (Some(app), Some(platform)) => Some(app.min(platform)),
+                (app, platform) => app.or(platform),
+            },
+            scan_secrets: project.push_secret_scan || git.secretscan,
+        },
+        None => PushPolicy {
+            max_size: platform,
+            scan_secrets: git.secretscan,
+            ..Default::default()
+        },
+    };
+    Ok(policy)
+}
+
+/// Checks a push against the policy before git sees it. `default_branch` is the branch the
+/// app deploys from, None while the repository has no branches, the first push may name any.
+/// A scan that fails lets the push through, it is logged
+pub async fn check(policy: &PushPolicy, path: &str, default_branch: Option<&str>, push: &Push) -> Result<(), Rejection> {
+    if let Some(max_size) = policy.max_size {
+        let size = push.pack.len() as f64 / 1024.0 / 1024.0;
+        if size > max_size as f64 {
+            return Err(Rejection {
+                reason: "push is too big",
+                details: vec![
+                    format!("This push is {size:.1} MiB, pushes to this app can be at most {max_size} MiB."),
+                    "Keep build outputs, media and datasets out of git, a volume or object storage fits them better.".to_string(),
+                    "Push fewer commits at a time if the history itself is that big.".to_string(),
+                ],
+            });
+        }
+    }
+
+    if let Some(default_branch) = default_branch.filter(|_| !policy.branches.is_empty()) {
+        let refused = push
+            .commands
+            .iter()
+            .filter(|command| !command.deletes())
+            .filter_map(|command| command.name.strip_prefix("refs/heads/"))
+            .filter(|branch| *branch != default_branch && !policy.branches.iter().any(|pattern| glob_matches(pattern, branch)))
+            .collect::<Vec<_>>();
+        if !refused.is_empty() {
+            return Err(Rejection {
+                reason: "branch is not allowed",
+                details: vec![
+                    format!("Only {default_branch} and branches matching {} can be pushed to this app.", policy.branches.join(", ")),
+                    format!("Refused: {}", refused.join(", ")),
+                    "Push the work to an allowed branch, or ask a maintainer to change `pmk push-policy --branches`.".to_string(),
+                ],
+            });
+        }
+    }
+
+    if !policy.scan_secrets {
+        return Ok(());
+    }
+    let findings = match scan(path, push).await {
+        Ok(findings) => findings,
+        Err(err) => {
+            tracing::warn!(?err, path, "Can't scan push for credentials, letting it through");
+            return Ok(());
+        }
+    };
+    if findings.is_empty() {
+        return Ok(());
+    }
+
+    let mut details = vec!["This push looks like it adds credentials:".to_string()];
+    details.extend(findings.iter().take(MAX_FINDINGS).map(|finding| finding.to_string()));
+    if findings.len() > MAX_FINDINGS {
+        details.push(format!("  and {} more", findings.len() - MAX_FINDINGS));
+    }
+    details.extend([
+        "Take them out of the commits, like with git commit --amend or git rebase -i, and keep them in pmk env instead.".to_string(),
+        "Rotate them if they were pushed anywhere else already.".to_string(),
+        format!("If a line only looks like a credential, add {ALLOW_MARKER} to it in a comment."),
+    ]);
+    Err(Rejection {
+        reason: "push adds credentials",
+        details,
+    })
+}
+
+/// `*` matches any run of characters, slashes too
+fn glob_matches(pattern: &str, name: &str) -> bool {
+    let mut parts = pattern.split('*');
+    let first = parts.next().unwrap_or("");
+    let Some(mut rest) = name.strip_prefix(first) else {
+        return false;
+    };
+    let parts = parts.collect::<Vec<_>>();
+    let Some((last, middle)) = parts.split_last() else {
+        return rest.is_empty();
+    };
+    for part in middle {
+        match rest.find(part) {
+            Some(at) => rest = &rest[at + part.len()..],
+            None => return false,
+        }
+    }
+    rest.ends_with(last)
+}
+
+/// Checks a branch pattern owners allow, like `feature/*`
+pub fn branch_pattern_check(pattern: &str) -> Result<(), String> {
+    let valid = !pattern.is_empty()
+        && !pattern.starts_with('/')
+        && !pattern.ends_with('/')
+        && !pattern.contains("..")
+        && pattern.chars().all(|c| c.is_ascii_alphanumeric() || "-_./*".contains(c));
+    match valid {
+        true => Ok(()),
+        false => Err(format!("{pattern} is not a branch name or pattern like feature/*")),
+    }
+}
+
+#[derive(Debug)]
+struct Finding {
+    commit: String,
+    file: String,
+    kind: &'static str,
+}
+
+impl std::fmt::Display for Finding {
+    fn fmt(&self, f: &mut std::fmt::Formatter<'_>) -> std::fmt::Result {
+        write!(f, "  {} {}: {}", self.commit, self.file, self.kind)
+    }
+}
+
+/// Directory a push is indexed into for the scan. It is removed when the scan is done, also
+/// when the client hung up halfway through and the scan was dropped
+struct Quarantine(String);
+
+impl Drop for Quarantine {
+    fn drop(&mut self) {
+        if let Err(err) = std::fs::remove_dir_all(&self.0) {
+            tracing::warn!(?err, quarantine = self.0, "Can't remove quarantined push");
+        }
+    }
+}
+
+/// Scans the lines the new commits of the push add. The pack is indexed into a quarantine
+/// next to the objects of the repository, like git does for its own hooks, and removed
+/// again, git stores it for real if the push gets through
+async fn scan(path: &str, push: &Push) -> Result<Vec<Finding>> {
+    let updates = push.commands.iter().filter(|command| !command.deletes()).collect::<Vec<_>>();
+    if updates.is_empty() || push.pack.is_empty() {
+        return Ok(Vec::new());
+    }
+
+    let objects = format!("{path}/objects");
+    let quarantine = Quarantine(format!("{objects}/incoming-policy-{}", Ulid::new()));
+    tokio::fs::create_dir_all(format!("{}/pack", quarantine.0)).await?;
+    scan_quarantined(path, &objects, &quarantine.0, &updates, &push.pack).await
+}
+
+async fn scan_quarantined(
+    path: &str,
+    objects: &str,
+    quarantine: &str,
+    updates: &[&RefUpdate],
+    pack: &[u8],
+) -> Result<Vec<Finding>> {
+    let envs = [("GIT_OBJECT_DIRECTORY", quarantine), ("GIT_ALTERNATE_OBJECT_DIRECTORIES", objects)];
+
+    // a push the client gave up on stops here, git is killed with the scan
+
+    let mut index = Command::new("git")
+        .current_dir(path)
+        .args(["index-pack", "--stdin", "--fix-thin"])
+        .envs(envs)
+        .stdin(Stdio::piped())
+        .stdout(Stdio::null())
+        .stderr(Stdio::piped())
+        .kill_on_drop(true)
+        .spawn()?;
+    let mut stdin = index.stdin.take().unwrap();
+    stdin.write_all(pack).await?;
+    drop(stdin);
+    let indexed = index.wait_with_output().await?;
+    if !indexed.status.success() {
+        bail!("git index-pack failed: {}", String::from_utf8_lossy(&indexed.stderr));
+    }
+
+    // what the push adds on top of what the repository has
+    let log = Command::new("git")
+        .current_dir(path)
+        .args(["log", "--patch", "--no-color", "--no-merges", "--no-ext-diff", "--unified=0", "--format=commit %h"])
+        .args(updates.iter().map(|update| update.new.as_str()))
+        .args(["--not", "--all", "--"])
+        .envs(envs)
+        .kill_on_drop(true)
+        .output()
+        .await?;
+    if !log.status.success() {
+        bail!("git log failed: {}", String::from_utf8_lossy(&log.stderr));
+    }
+
+    let mut findings = Vec::new();
+    let (mut commit, mut file) = (String::new(), String::new());
+    for line in String::from_utf8_lossy(&log.stdout).lines() {
+        if let Some(hash) = line.strip_prefix("commit ") {
+            commit = hash.to_string();
+        } else if let Some(name) = line.strip_prefix("+++ ") {
+            file = name.strip_prefix("b/").unwrap_or(name).to_string();
+        } else if let Some(added) = line.strip_prefix('+') {
+            if added.contains(ALLOW_MARKER) {
+                continue;
+            }
+            let found = SECRETS.iter().find(|(_, secret)| secret.is_match(added));
+            if let Some((kind, _)) = found {
+                findings.push(Finding {
+                    commit: commit.clone(),
+                    file: file.clone(),
+                    kind: *kind,
+                });
+            }
+        }
+    }
+    Ok(findings)
+}
+
+/// The answer git would give to a push its pre-receive hook refused: every ref rejected, the
+/// details shown as `remote:` lines on clients that take side-band messages
+pub fn rejection(push: &Push, rejection: &Rejection) -> Response<Body> {
+    let mut report = pkt_line(b"unpack ok\n");
+    for command in &push.commands {
+        report.extend(pkt_line(format!("ng {} {}\n", command.name, rejection.reason).as_bytes()));
+    }
+    report.extend(b"0000");
+
+    let side_band = push.capabilities.iter().any(|capability| capability == "side-band" || capability == "side-band-64k");
+    let body = match side_band {
+        true => {
+            let mut body = Vec::new();
+            for line in &rejection.details {
+                for chunk in format!("{line}\n").as_bytes().chunks(BAND_SIZE) {
+                    body.extend(band(2, chunk));
+                }
+            }
+            for chunk in report.chunks(BAND_SIZE) {
+                body.extend(band(1, chunk));
+            }
+            body.extend(b"0000");
+            body
+        }
+        false => report,
+    };
+
+    Response::builder()
+        .status(StatusCode::OK)
+        .header("Content-Type", "application/x-git-receive-pack-result")
+        .header("Cache-Control", "no-cache, max-age=0, must-revalidate")
+        .body(Body::from(body))
+        .unwrap()
+}
+
+fn pkt_line(data: &[u8]) -> Vec<u8> {
+    let mut line = format!("{:04x}", data.len() + 4).into_bytes();
+    line.extend(data);
+    line
+}
+
+fn band(band: u8, data: &[u8]) -> Vec<u8> {
+    let mut packet = vec![band];
+    packet.extend(data);
+    pkt_line(&packet)
+}
@@ -1,21 +1,51 @@
 use std::{
-    collections::{HashSet, VecDeque},
+    collections::{BTreeMap, HashMap, VecDeque},
     hash::Hash,
+    path::Path,
     sync::{
-        atomic::{AtomicUsize, Ordering},
+        atomic::{AtomicBool, AtomicUsize, Ordering},
         Arc,
     },
+    time::Duration,
 };
 
 use anyhow::Result;
+use chrono::Utc;
 use sqlx::PgPool;
 use thiserror::Error;
 use tokio::sync::mpsc::{self, Receiver, Sender};
 use tokio::sync::Mutex;
+use tokio_util::sync::CancellationToken;
+use tracing::Instrument;
+use tracing_opentelemetry::OpenTelemetrySpanExt;
 use ulid::Ulid;
 use uuid::Uuid;
 
-use crate::docker::{build_docker, DockerContainer};
+use crate::activity::record_activity;
+use crate::configuration::{ContainerSettings, QuotaSettings};
+use crate::docker::{
+    build_docker, database_url, BuildCancelled, BuildTimedOut, image_docker, image_registry, keep_canary, project_environment,
+    promote_container, rollback_docker, tag_release_image, untag_release_image,
+    DockerContainer, RegistryCredentials, ReleaseConfig,
+};
+use crate::monitoring::{reset_release_requests, BUILDS_QUEUED, BUILDS_RUNNING, DEPLOY_DURATION};
+use crate::nodes;
+use crate::orchestrator;
+use crate::notifications::{Event, Notifier, Payload};
+use crate::lfs::LfsStorage;
+use crate::live::{self, Update};
+use crate::previews::discard_container;
+use crate::releases::pin_refusal;
+use crate::manifest::{Manifest, Service};
+use crate::registry::push_release_image;
+use crate::secrets::SecretCipher;
+use crate::services::{private_network, service_network, sync_services};
+use crate::sites;
+use crate::usage::meter_build;
+use crate::limits::project_limits;
+use crate::restarts::project_restarts;
+use crate::quotas::{build_accounts, check_storage};
+use crate::volumes::{project_mounts, prune_volumes};
 
 type ConcurrentMutex<T> = Arc<Mutex<T>>;
 
@@ -25,12 +55,103 @@ pub struct BuildError {
     message: String,
     inner_error: Option<Box<dyn std::error::Error>>,
 }
+/// What a queued build deploys. Every kind but a canary ends up as a new release
+#[derive(Debug, Clone)]
+pub enum BuildKind {
+    /// build the checkout
+    Build,
+    /// start an earlier release again
+    Rollback(Uuid),
+    /// start the live release again with the current environment, the string says what changed
+    Reconfigure(String),
+    /// build the checkout and run it next to the live release on this percent of requests.
+    /// On none it waits to be promoted, see [`crate::deploy_branch`]
+    Canary(i32),
+    /// send every request to the canary, which becomes the live release
+    Promote,
+    /// pull an image from a registry and run it instead of building the checkout
+    Image(String),
+    /// build the checkout of this branch as a preview next to the app, see [`crate::previews`]
+    Preview(String),
+    /// start the image of a release of another app with the environment of this one, the way
+    /// a staging app hands its release to production
+    Release(Uuid),
+}
+
+impl BuildKind {
+    fn description(&self) -> String {
+        match self {
+            BuildKind::Build => "Build".to_string(),
+            BuildKind::Rollback(release_id) => format!("Rollback to {release_id}"),
+            BuildKind::Reconfigure(change) => change.clone(),
+            BuildKind::Canary(0) => "Build waiting to be promoted".to_string(),
+            BuildKind::Canary(weight) => format!("Canary on {weight}% of requests"),
+            BuildKind::Promote => "Promote canary".to_string(),
+            BuildKind::Image(image) => format!("Image {image}"),
+            BuildKind::Preview(branch) => format!("Preview of {branch}"),
+            BuildKind::Release(release_id) => format!("Promoted release {release_id}"),
+        }
+    }
+
+    /// name of the kind for metric labels
+    fn label(&self) -> &'static str {
+        match self {
+            BuildKind::Build => "build",
+            BuildKind::Rollback(_) => "rollback",
+            BuildKind::Reconfigure(_) => "reconfigure",
+            BuildKind::Canary(_) => "canary",
+            BuildKind::Promote => "promote",
+            BuildKind::Image(_) => "image",
+            BuildKind::Preview(_) => "preview",
+            BuildKind::Release(_) => "release",
+        }
+    }
+
+    /// Whether a waiting build of this kind already deploys what `new` would. Builds read
+    /// the checkout and every kind reads the environment when it starts, not when queued
+    fn covers(&self, new: &BuildKind) -> bool {
+        match (self, new) {
+            (BuildKind::Build, BuildKind::Build) => true,
+            (BuildKind::Preview(_), BuildKind::Preview(_)) => true,
+            (BuildKind::Canary(_) | BuildKind::Promote, BuildKind::Reconfigure(_)) => false,
+            (_, BuildKind::Reconfigure(_)) => true,
+            _ => false,
+        }
+    }
+
+    /// Whether this kind makes a build of `old` for the same app obsolete, what ends up live
+    /// is whatever was asked for last. Canaries run next to the live release and are left alone
+    fn supersedes(&self, old: &BuildKind) -> bool {
+        if let (BuildKind::Preview(_), BuildKind::Preview(_)) = (self, old) {
+            return true;
+        }
+        matches!(self, BuildKind::Build | BuildKind::Image(_) | BuildKind::Rollback(_) | BuildKind::Release(_))
+            && matches!(
+                old,
+                BuildKind::Build
+                    | BuildKind::Image(_)
+                    | BuildKind::Rollback(_)
+                    | BuildKind::Release(_)
+                    | BuildKind::Reconfigure(_)
+            )
+    }
+
+    /// Kinds that spend their time building or pulling, they stop there when cancelled. The
+    /// others only swap containers
+    fn cancellable(&self) -> bool {
+        matches!(self, BuildKind::Build | BuildKind::Canary(_) | BuildKind::Image(_) | BuildKind::Preview(_))
+    }
+}
+
 #[derive(Debug)]
 pub struct BuildQueueItem {
     pub container_name: String,
     pub container_src: String,
     pub owner: String,
     pub repo: String,
+    pub kind: BuildKind,
+    /// what queued the build, usually [`crate::telemetry::current_context`], its spans go under it
+    pub trace: opentelemetry::Context,
 }
 
 #[derive(Debug)]
@@ -40,6 +161,11 @@ pub struct BuildItem {
     pub container_src: String,
     pub owner: String,
     pub repo: String,
+    pub kind: BuildKind,
+    pub trace: opentelemetry::Context,
+    /// users the build counts against with how many builds each may run at once, see
+    /// [`crate::quotas`]
+    pub accounts: Vec<(Uuid, usize)>,
 }
 
 impl Hash for BuildItem {
@@ -58,29 +184,281 @@ impl Eq for BuildItem {}
 
 pub struct BuildQueue {
     pub build_count: Arc<AtomicUsize>,
-    pub waiting_queue: ConcurrentMutex<VecDeque<BuildItem>>,
-    pub waiting_set: ConcurrentMutex<HashSet<String>>,
+    /// builds one owner can run at the same time, the others wait for their turn
+    pub owner_limit: usize,
+    /// builds still going after this get killed
+    pub build_timeout: Duration,
+    pub state: BuildQueueState,
     pub receive_channel: Receiver<BuildQueueItem>,
     pub pg_pool: PgPool,
+    pub container_settings: ContainerSettings,
+    pub quota_settings: QuotaSettings,
+    pub secrets: SecretCipher,
+    pub notifier: Notifier,
+    pub lfs: LfsStorage,
 }
 
 impl BuildQueue {
-    pub fn new(build_count: usize, pg_pool: PgPool) -> (Self, Sender<BuildQueueItem>) {
+    pub fn new(
+        build_count: usize,
+        owner_limit: usize,
+        build_timeout: Duration,
+        pg_pool: PgPool,
+        container_settings: ContainerSettings,
+        quota_settings: QuotaSettings,
+        secrets: SecretCipher,
+        notifier: Notifier,
+        lfs: LfsStorage,
+    ) -> (Self, Sender<BuildQueueItem>) {
         let (tx, rx) = mpsc::channel(32);
 
         (
             Self {
                 build_count: Arc::new(AtomicUsize::new(build_count)),
-                waiting_queue: Arc::new(Mutex::new(VecDeque::new())),
-                waiting_set: Arc::new(Mutex::new(HashSet::new())),
+                owner_limit,
+                build_timeout,
+                state: BuildQueueState::default(),
                 receive_channel: rx,
                 pg_pool,
+                container_settings,
+                quota_settings,
+                secrets,
+                notifier,
+                lfs,
             },
             tx,
         )
     }
 }
 
+#[derive(Debug)]
+struct RunningBuild {
+    owner: String,
+    accounts: Vec<Uuid>,
+    container_name: String,
+    kind: BuildKind,
+    cancel: CancellationToken,
+}
+
+/// Builds waiting for a slot and the ones running. The api reads queue positions from it
+/// and cancels builds through it
+#[derive(Debug, Clone, Default)]
+pub struct BuildQueueState {
+    waiting: ConcurrentMutex<VecDeque<BuildItem>>,
+    running: ConcurrentMutex<HashMap<Uuid, RunningBuild>>,
+    /// no build starts while the host drains, queued ones wait for it to resume
+    draining: Arc<AtomicBool>,
+}
+
+/// What cancelling a build did
+#[derive(Debug, PartialEq, Eq)]
+pub enum Cancelled {
+    /// it was waiting and never runs
+    Queued,
+    /// it stops building, unless it is already starting the new container
+    Running,
+    /// it only swaps containers, stopping halfway would leave the app without one
+    Finishing,
+    NotFound,
+}
+
+impl BuildQueueState {
+    /// Place of a waiting build in the queue, 1 for the next one to start. Owners at their
+    /// build limit and accounts at their build quota are skipped over, so builds can start
+    /// before their position comes up
+    pub async fn position(&self, build_id: Uuid) -> Option<usize> {
+        let waiting = self.waiting.lock().await;
+        waiting
+            .iter()
+            .position(|item| item.build_id == build_id)
+            .map(|position| position + 1)
+    }
+
+    /// Stops or resumes starting builds, for maintenance of the host. Running builds finish
+    pub fn drain(&self, draining: bool) {
+        self.draining.store(draining, Ordering::SeqCst);
+    }
+
+    pub fn draining(&self) -> bool {
+        self.draining.load(Ordering::SeqCst)
+    }
+
+    /// Builds waiting and running, the host is drained once nothing runs
+    pub async fn counts(&self) -> (usize, usize) {
+        let waiting = self.waiting.lock().await.len();
+        let running = self.running.lock().await.len();
+        (waiting, running)
+    }
+
+    /// Builds waiting and running with the container name of their app. Builds of the
+    /// database that aren't here were lost with the platform, see [`crate::reconciler`]
+    pub async fn tracked(&self) -> HashMap<Uuid, String> {
+        let mut tracked = self
+            .waiting
+            .lock()
+            .await
+            .iter()
+            .map(|item| (item.build_id, item.container_name.clone()))
+            .collect::<HashMap<_, _>>();
+        tracked.extend(
+            self.running
+                .lock()
+                .await
+                .iter()
+                .map(|(build_id, build)| (*build_id, build.container_name.clone())),
+        );
+        tracked
+    }
+
+    /// Takes a build out of the queue or stops it while it builds. `reason` ends up in the
+    /// build log
+    pub async fn cancel(&self, build_id: Uuid, reason: &str, pool: &PgPool) -> Cancelled {
+        {
+            let mut waiting = self.waiting.lock().await;
+            if let Some(position) = waiting.iter().position(|item| item.build_id == build_id) {
+                waiting.remove(position);
+                BUILDS_QUEUED.set(waiting.len() as i64);
+                drop(waiting);
+
+                cancel_queued(build_id, reason, pool).await;
+                return Cancelled::Queued;
+            }
+        }
+
+        let running = self.running.lock().await;
+        match running.get(&build_id) {
+            Some(build) if build.kind.cancellable() => {
+                build.cancel.cancel();
+                append_log(build_id, &format!("\n{reason}\n"), pool).await;
+                Cancelled::Running
+            }
+            Some(_) => Cancelled::Finishing,
+            None => Cancelled::NotFound,
+        }
+    }
+
+    /// Takes the first waiting build that can start: its owner is below `owner_limit` and
+    /// the app has no build running, builds of one app never run side by side
+    async fn next(&self, owner_limit: usize) -> Option<(BuildItem, CancellationToken)> {
+        let mut waiting = self.waiting.lock().await;
+        let mut running = self.running.lock().await;
+
+        let position = waiting.iter().position(|item| {
+            let owner_running = running.values().filter(|build| build.owner == item.owner).count();
+            let accounts_free = item.accounts.iter().all(|(user_id, quota)| {
+                running.values().filter(|build| build.accounts.contains(user_id)).count() < *quota
+            });
+            owner_running < owner_limit
+                && accounts_free
+                && !running.values().any(|build| build.container_name == item.container_name)
+        })?;
+        let item = waiting.remove(position)?;
+        BUILDS_QUEUED.set(waiting.len() as i64);
+
+        let cancel = CancellationToken::new();
+        running.insert(
+            item.build_id,
+            RunningBuild {
+                owner: item.owner.clone(),
+                accounts: item.accounts.iter().map(|(user_id, _)| *user_id).collect(),
+                container_name: item.container_name.clone(),
+                kind: item.kind.clone(),
+                cancel: cancel.clone(),
+            },
+        );
+
+        Some((item, cancel))
+    }
+
+    async fn finish(&self, build_id: Uuid) {
+        self.running.lock().await.remove(&build_id);
+    }
+
+    /// Queues a build and cancels the waiting and running builds of the app it makes obsolete
+    async fn enqueue(&self, item: BuildItem, pool: &PgPool) {
+        let superseded = {
+            let mut waiting = self.waiting.lock().await;
+
+            let (superseded, kept) = waiting.drain(..).partition::<VecDeque<_>, _>(|queued| {
+                queued.container_name == item.container_name && item.kind.supersedes(&queued.kind)
+            });
+            *waiting = kept;
+            superseded
+        };
+
+        let reason = format!("Superseded by build {}", item.build_id);
+        for queued in superseded {
+            cancel_queued(queued.build_id, &reason, pool).await;
+        }
+
+        {
+            let running = self.running.lock().await;
+            for (build_id, build) in running.iter() {
+                if build.container_name == item.container_name
+                    && build.kind.cancellable()
+                    && item.kind.supersedes(&build.kind)
+                {
+                    build.cancel.cancel();
+                    append_log(*build_id, &format!("\n{reason}\n"), pool).await;
+                }
+            }
+        }
+
+        let mut waiting = self.waiting.lock().await;
+        waiting.push_back(item);
+        BUILDS_QUEUED.set(waiting.len() as i64);
+    }
+
+    /// whether a waiting build of the app already deploys what `kind` would
+    async fn covered(&self, container_name: &str, kind: &BuildKind) -> bool {
+        let waiting = self.waiting.lock().await;
+        waiting
+            .iter()
+            .any(|queued| queued.container_name == container_name && queued.kind.covers(kind))
+    }
+}
+
+async fn cancel_queued(build_id: Uuid, reason: &str, pool: &PgPool) {
+    match sqlx::query!(
+        r#"UPDATE builds SET status = 'cancelled', log = log || $1, finished_at = now() WHERE id = $2
+           RETURNING project_id
+        "#,
+        format!("{reason}\n"),
+        build_id
+    )
+    .fetch_optional(pool)
+    .await
+    {
+        Ok(Some(build)) => live::publish(build.project_id, Update::Build { build_id, status: "CANCELLED" }),
+        Ok(None) => {}
+        Err(err) => tracing::error!(?err, "Can't cancel build: Failed to query database"),
+    }
+}
+
+async fn append_log(build_id: Uuid, chunk: &str, pool: &PgPool) {
+    match sqlx::query!(
+        r#"UPDATE builds SET log = log || $1 WHERE id = $2
+           RETURNING project_id, octet_length(log) AS "offset!"
+        "#,
+        chunk,
+        build_id
+    )
+    .fetch_optional(pool)
+    .await
+    {
+        Ok(Some(build)) => live::publish(
+            build.project_id,
+            Update::BuildLog {
+                build_id,
+                offset: build.offset as usize,
+                log: chunk.to_string(),
+            },
+        ),
+        Ok(None) => {}
+        Err(err) => tracing::error!(?err, "Can't append build log: Failed to query database"),
+    }
+}
+
 pub async fn trigger_build(
     BuildItem {
         build_id,
@@ -88,8 +466,17 @@ pub async fn trigger_build(
         repo,
         container_src,
         container_name,
+        kind,
+        ..
     }: BuildItem,
     pool: PgPool,
+    container_settings: ContainerSettings,
+    quota_settings: QuotaSettings,
+    secrets: SecretCipher,
+    notifier: Notifier,
+    lfs: LfsStorage,
+    cancel: CancellationToken,
+    build_timeout: Duration,
 ) -> Result<String, BuildError> {
     // TODO: need to emmit error somewhere
     let project = match sqlx::query!(
@@ -139,9 +526,12 @@ pub async fn trigger_build(
         }),
     }?;
 
+    let commit_sha = build_commit(&kind, project.id, &container_src, &pool).await;
+
     if let Err(err) = sqlx::query!(
-        "UPDATE builds set status = 'building' where id = $1",
-        build_id
+        "UPDATE builds set status = 'building', commit_sha = $2 where id = $1",
+        build_id,
+        commit_sha
     )
     .execute(&pool)
     .await
@@ -151,18 +541,263 @@ pub async fn trigger_build(
             inner_error: Some(err.into()),
         });
     }
+    live::publish(project.id, Update::Build { build_id, status: "BUILDING" });
+
+    let payload = |event: Event, message: String, release_id: Option<Uuid>| Payload {
+        event: event.name(),
+        app: format!("{owner}/{repo}"),
+        message,
+        build_id: Some(build_id),
+        commit_sha: commit_sha.clone(),
+        release_id,
+        logs_url: notifier.build_url(&owner, &repo, build_id),
+        timestamp: Utc::now(),
+    };
+
+    let description = kind.description();
+    notifier.notify(
+        project.id,
+        Event::BuildStarted,
+        payload(Event::BuildStarted, format!("Started: {description}"), None),
+        &pool,
+    );
+
+    // the first deploy of an app decides where it runs, later ones stay on that host
+    if matches!(kind, BuildKind::Build | BuildKind::Image(_)) {
+        if let Err(err) = nodes::place(project.id, &container_name, &container_settings, &pool).await {
+            tracing::error!(?err, "Can't place app: Failed to query database, it runs on this host");
+        }
+    }
+
+    let deployed = deploy(
+        build_id,
+        project.id,
+        &owner,
+        &repo,
+        &container_src,
+        &container_name,
+        &kind,
+        &pool,
+        &container_settings,
+        &quota_settings,
+        &secrets,
+        &lfs,
+        &cancel,
+        build_timeout,
+    )
+    .await;
+
+    match &deployed {
+        Ok((_, release_id)) => {
+            live::publish(project.id, Update::Build { build_id, status: "SUCCESSFUL" });
+            notifier.notify(
+                project.id,
+                Event::BuildSucceeded,
+                payload(Event::BuildSucceeded, format!("Succeeded: {description}"), *release_id),
+                &pool,
+            )
+        }
+        // cancelling was asked for, nobody needs telling
+        Err(_) if cancel.is_cancelled() => {
+            live::publish(project.id, Update::Build { build_id, status: "CANCELLED" });
+        }
+        Err(err) => {
+            live::publish(project.id, Update::Build { build_id, status: "FAILED" });
+            notifier.notify(
+                project.id,
+                Event::BuildFailed,
+                payload(Event::BuildFailed, format!("Failed: {description}: {}", err.message), None),
+                &pool,
+            )
+        }
+    }
+
+    deployed.map(|(subdomain, _)| subdomain)
+}
+
+/// Builds or restarts what `kind` deploys and makes it live, returning the subdomain it is
+/// served on and the release it became. Canaries don't become a release
+#[tracing::instrument(name = "deploy", skip_all, fields(kind = kind.label()))]
+async fn deploy(
+    build_id: Uuid,
+    project_id: Uuid,
+    owner: &str,
+    repo: &str,
+    container_src: &str,
+    container_name: &str,
+    kind: &BuildKind,
+    pool: &PgPool,
+    container_settings: &ContainerSettings,
+    quota_settings: &QuotaSettings,
+    secrets: &SecretCipher,
+    lfs: &LfsStorage,
+    cancel: &CancellationToken,
+    build_timeout: Duration,
+) -> Result<(String, Option<Uuid>), BuildError> {
+    // an account over its quota gets no new images until it frees space. Restarts and
+    // rollbacks reuse images it has already
+    let over_quota = match kind {
+        BuildKind::Build | BuildKind::Canary(_) | BuildKind::Image(_) | BuildKind::Preview(_) => {
+            check_storage(project_id, 0, quota_settings, pool)
+                .await
+                .unwrap_or_else(|err| {
+                    tracing::error!(?err, "Can't check storage quota: Failed to query usage");
+                    None
+                })
+        }
+        _ => None,
+    };
+    // a pinned app keeps its release, see crate::releases
+    let pinned = pin_refusal(project_id, kind, pool).await.unwrap_or_else(|err| {
+        tracing::error!(?err, "Can't check pinned release: Failed to query database");
+        None
+    });
+    // a canary or preview runs next to the live release under its own address, a driver
+    // that rolls releases out in place has none
+    let side_by_side = match kind {
+        BuildKind::Canary(_) | BuildKind::Preview(_) if !orchestrator::driver().side_by_side() => Some(
+            "Canaries and previews need the docker orchestrator, push to the deploy branch instead".to_string(),
+        ),
+        _ => None,
+    };
+    if let Some(message) = pinned.or(over_quota).or(side_by_side) {
+        if let Err(err) = sqlx::query!(
+            "UPDATE builds SET status = 'failed', log = $1 WHERE id = $2",
+            format!("{message}\n"),
+            build_id
+        )
+        .execute(pool)
+        .await
+        {
+            tracing::error!(?err, "Can't fail build: Failed to query database");
+        }
+
+        return Err(BuildError {
+            message,
+            inner_error: None,
+        });
+    }
+
+    // the pointers git lfs committed are swapped for the assets before anything sees the
+    // checkout
+    if let BuildKind::Build | BuildKind::Canary(_) | BuildKind::Preview(_) = kind {
+        match lfs.checkout(owner, repo, container_src).await {
+            Ok(0) => {}
+            Ok(files) => tracing::info!(files, "Fetched Git LFS objects"),
+            Err(err) => {
+                let message = format!("Can't fetch Git LFS objects: {err}");
+                if let Err(err) = sqlx::query!(
+                    "UPDATE builds SET status = 'failed', log = $1 WHERE id = $2",
+                    format!("{message}\n"),
+                    build_id
+                )
+                .execute(pool)
+                .await
+                {
+                    tracing::error!(?err, "Can't fail build: Failed to query database");
+                }
+
+                return Err(BuildError {
+                    message,
+                    inner_error: None,
+                });
+            }
+        }
+    }
 
     // TODO: Differentiate types of errors returned by build_docker (ex: ImageBuildError, NetworkCreateError, ContainerAttachError)
+    let deploy = match kind {
+        BuildKind::Build | BuildKind::Canary(_) | BuildKind::Preview(_) => {
+            let preview = match kind {
+                BuildKind::Preview(branch) => Some(branch.as_str()),
+                _ => None,
+            };
+            build_docker(
+                project_id,
+                owner,
+                repo,
+                container_name,
+                container_src,
+                build_id,
+                preview,
+                pool.clone(),
+                container_settings,
+                secrets,
+                cancel,
+                build_timeout,
+            )
+            .await
+        }
+        BuildKind::Rollback(release_id) => {
+            rollback_release(
+                *release_id,
+                project_id,
+                owner,
+                repo,
+                container_name,
+                pool,
+                container_settings,
+                secrets,
+            )
+            .await
+        }
+        BuildKind::Reconfigure(_) => {
+            reconfigure_release(
+                project_id,
+                owner,
+                repo,
+                container_name,
+                pool,
+                container_settings,
+                secrets,
+            )
+            .await
+        }
+        BuildKind::Promote => canary_release(project_id, pool).await,
+        BuildKind::Release(release_id) => {
+            promoted_release(
+                *release_id,
+                project_id,
+                owner,
+                repo,
+                container_name,
+                pool,
+                container_settings,
+                secrets,
+            )
+            .await
+        }
+        BuildKind::Image(image) => match registry_credentials(project_id, image, pool, secrets).await {
+            Ok(credentials) => {
+                image_docker(
+                    project_id,
+                    owner,
+                    repo,
+                    container_name,
+                    image,
+                    build_id,
+                    credentials,
+                    pool.clone(),
+                    container_settings,
+                    secrets,
+                    cancel,
+                )
+                .await
+            }
+            Err(err) => Err(err),
+        },
+    };
+
     let DockerContainer {
-        ip, port, db_url, ..
-    } = match build_docker(&owner, &repo, &container_name, &container_src, pool.clone()).await {
+        id: container_id, ip, port, db_url, image, config, site, ..
+    } = match deploy {
         Ok(result) => {
             if let Err(err) = sqlx::query!(
                 "UPDATE builds SET status = 'successful', log = $1 WHERE id = $2",
                 result.build_log,
                 build_id
             )
-            .execute(&pool)
+            .execute(pool)
             .await
             {
                 return Err(BuildError {
@@ -173,13 +808,49 @@ pub async fn trigger_build(
 
             Ok(result)
         }
+        Err(err) if err.is::<BuildCancelled>() => {
+            // the log streamed in while building, it is kept as it was
+            if let Err(err) = sqlx::query!(
+                "UPDATE builds SET status = 'cancelled', log = log || $1, finished_at = now() WHERE id = $2",
+                "Build cancelled\n",
+                build_id
+            )
+            .execute(pool)
+            .await
+            {
+                tracing::error!(?err, "Can't cancel build: Failed to query database");
+            }
+
+            return Err(BuildError {
+                message: format!("Build of repository {repo} was cancelled"),
+                inner_error: None,
+            });
+        }
+        Err(err) if err.is::<BuildTimedOut>() => {
+            // like a cancel the log streamed in already, the reason goes at its end
+            if let Err(err) = sqlx::query!(
+                "UPDATE builds SET status = 'failed', log = log || $1 WHERE id = $2",
+                format!("{err}\n"),
+                build_id
+            )
+            .execute(pool)
+            .await
+            {
+                tracing::error!(?err, "Can't fail build: Failed to query database");
+            }
+
+            return Err(BuildError {
+                message: err.to_string(),
+                inner_error: None,
+            });
+        }
         Err(err) => {
             if let Err(err) = sqlx::query!(
                 "UPDATE builds SET status = 'failed', log = $1 WHERE id = $2",
                 err.to_string(),
                 build_id
             )
-            .execute(&pool)
+            .execute(pool)
             .await
             {
                 return Err(BuildError {
@@ -197,36 +868,124 @@ pub async fn trigger_build(
         }
     }?;
 
+    // the files of a site replace the live ones at once, there is nothing to run next to them
+    if site.is_some() && matches!(kind, BuildKind::Canary(_) | BuildKind::Preview(_)) {
+        let message = "Canaries and previews of static sites aren't supported, push to the deploy branch instead".to_string();
+        if let Err(err) = sqlx::query!(
+            "UPDATE builds SET status = 'failed', log = log || $1 WHERE id = $2",
+            format!("{message}\n"),
+            build_id
+        )
+        .execute(pool)
+        .await
+        {
+            tracing::error!(?err, "Can't fail build: Failed to query database");
+        }
+
+        return Err(BuildError {
+            message,
+            inner_error: None,
+        });
+    }
+
+    // the proxy and the workers stay on the live release while the canary runs
+    if let BuildKind::Canary(weight) = kind {
+        return start_canary(
+            build_id,
+            project_id,
+            container_name,
+            &container_id,
+            &ip,
+            port,
+            &image,
+            &config,
+            *weight,
+            pool,
+        )
+        .await
+        .map(|subdomain| (subdomain, None));
+    }
+
+    // a preview is served on its own name, the app and its releases stay as they are
+    if let BuildKind::Preview(branch) = kind {
+        return start_preview(
+            build_id,
+            project_id,
+            branch,
+            container_name,
+            container_src,
+            &container_id,
+            port,
+            pool,
+            container_settings,
+        )
+        .await
+        .map(|subdomain| (subdomain, None));
+    }
+
+    // a static site has no container, the proxy serves its directory instead
+    let live_container = match site {
+        Some(_) => None,
+        None => Some(container_id.clone()),
+    };
+    let spa = config.site.as_ref().is_some_and(|site| site.spa);
+
     // TODO: check why why need this
     let subdomain = match sqlx::query!(
         r#"SELECT domains.name
            FROM domains
            WHERE domains.project_id = $1
         "#,
-        project.id
+        project_id
     )
-    .fetch_optional(&pool)
+    .fetch_optional(pool)
     .await
     {
-        Ok(Some(subdomain)) => Ok(subdomain.name),
+        Ok(Some(subdomain)) => {
+            // this is the switch: the proxy reads the upstream from this row on every request,
+            // so traffic moves to the new container in one update
+            match sqlx::query!(
+                r#"UPDATE domains SET port = $1, docker_ip = $2, container_id = $3, site_root = $4,
+                   site_spa = $5, updated_at = now()
+                   WHERE project_id = $6
+                "#,
+                port,
+                ip,
+                live_container,
+                site,
+                spa,
+                project_id
+            )
+            .execute(pool)
+            .await
+            {
+                Ok(_) => Ok(subdomain.name),
+                Err(err) => Err(BuildError {
+                    message: "Can't update domain: Failed to query database".to_string(),
+                    inner_error: Some(err.into()),
+                }),
+            }
+        }
         Ok(None) => {
             let id = Uuid::from(Ulid::new());
             let subdomain = sqlx::query!(
-                r#"INSERT INTO domains (id, project_id, name, port, docker_ip, db_url)
-                   VALUES ($1, $2, $3, $4, $5, $6)
+                r#"INSERT INTO domains (id, project_id, name, port, docker_ip, container_id, site_root, site_spa)
+                   VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
                 "#,
                 id,
-                project.id,
+                project_id,
                 container_name,
                 port,
                 ip,
-                db_url
+                live_container,
+                site,
+                spa
             )
-            .execute(&pool)
+            .execute(pool)
             .await;
 
             match subdomain {
-                Ok(_) => Ok(container_name),
+                Ok(_) => Ok(container_name.to_string()),
                 Err(err) => Err(BuildError {
                     inner_error: Some(err.into()),
                     message: "Can't insert domain: Failed to query database".to_string(),
@@ -239,54 +998,678 @@ pub async fn trigger_build(
         }),
     }?;
 
-    Ok(subdomain)
+    // the new container is live, whatever kept crashing is being retired
+    if let Err(err) = sqlx::query!("UPDATE projects SET crash_looping_at = NULL WHERE id = $1", project_id)
+        .execute(pool)
+        .await
+    {
+        tracing::error!(?err, "Can't end crash loop: Failed to query database");
+    }
+
+    // the canary is the live container now, the proxy stops splitting before the old one
+    // is retired
+    if let BuildKind::Promote = kind {
+        if let Err(err) = sqlx::query!("DELETE FROM canaries WHERE project_id = $1", project_id)
+            .execute(pool)
+            .await
+        {
+            return Err(BuildError {
+                message: "Can't remove canary: Failed to query database".to_string(),
+                inner_error: Some(err.into()),
+            });
+        }
+
+        if let Err(err) = record_activity(project_id, "canary", "Promoted the canary", pool).await {
+            tracing::error!(?err, "Can't record activity: Failed to query database");
+        }
+    }
+
+    // a site needs no container, whatever the app ran before it became one is removed
+    if site.is_some() {
+        if let Err(err) = sites::retire(container_name, container_settings).await {
+            tracing::error!(?err, "Can't remove containers of static site: {repo}");
+        }
+    } else {
+        if let Err(err) = orchestrator::driver()
+            .promote_web(container_name, &container_id, &container_settings)
+            .await
+        {
+            return Err(BuildError {
+                message: format!("Failed to retire previous container of repository: {repo}"),
+                inner_error: Some(err.into()),
+            });
+        }
+
+        // workers run the new release too, the web process is live already so a failure here
+        // doesn't fail the deploy but ends up in the build log
+        let formation = match sqlx::query!("SELECT formation FROM projects WHERE id = $1", project_id)
+            .fetch_one(pool)
+            .await
+        {
+            Ok(project) => serde_json::from_value(project.formation).unwrap_or_default(),
+            Err(err) => {
+                tracing::error!(?err, "Can't get formation: Failed to query database");
+                Default::default()
+            }
+        };
+
+        if let Err(err) = orchestrator::driver()
+            .run_processes(
+                container_name,
+                &image,
+                &config,
+                &db_url,
+                &formation,
+                true,
+                container_settings,
+                secrets,
+            )
+            .await
+        {
+            tracing::error!(?err, "Can't start workers of repository: {repo}");
+
+            let _ = sqlx::query!(
+                "UPDATE builds SET log = log || $1 WHERE id = $2",
+                format!("\nFailed to start workers: {err}\n"),
+                build_id
+            )
+            .execute(pool)
+            .await;
+        }
+    }
+
+    // volumes detached since the last release aren't used by any container of this one
+    prune_volumes(container_name, &config.volumes).await;
+
+    // rollbacks and environment changes are releases too, so the newest release is always
+    // the live one and the history shows every change
+    let release_id = record_release(
+        build_id,
+        project_id,
+        container_name,
+        &image,
+        &config,
+        &kind.description(),
+        pool,
+        container_settings,
+    )
+    .await;
+
+    Ok((subdomain, release_id))
+}
+
+async fn rollback_release(
+    release_id: Uuid,
+    project_id: Uuid,
+    owner: &str,
+    repo: &str,
+    container_name: &str,
+    pool: &PgPool,
+    container_settings: &ContainerSettings,
+    secrets: &SecretCipher,
+) -> Result<DockerContainer> {
+    let release = sqlx::query!(
+        r#"SELECT image, config
+           FROM releases
+           WHERE id = $1 AND project_id = $2
+        "#,
+        release_id,
+        project_id
+    )
+    .fetch_optional(pool)
+    .await?
+    .ok_or(anyhow::anyhow!("Release {release_id} not found"))?;
+
+    let config: ReleaseConfig = serde_json::from_value(release.config)?;
+
+    rollback_docker(
+        project_id,
+        owner,
+        repo,
+        container_name,
+        &release.image,
+        &config,
+        pool.clone(),
+        container_settings,
+        secrets,
+    )
+    .await
+}
+
+/// The image of a release of another app started with the environment, network, volumes and
+/// limits of this one. The command and the workers come from the release, they belong to
+/// the image
+async fn promoted_release(
+    release_id: Uuid,
+    project_id: Uuid,
+    owner: &str,
+    repo: &str,
+    container_name: &str,
+    pool: &PgPool,
+    container_settings: &ContainerSettings,
+    secrets: &SecretCipher,
+) -> Result<DockerContainer> {
+    let release = sqlx::query!(
+        r#"SELECT image, config
+           FROM releases
+           WHERE id = $1
+        "#,
+        release_id
+    )
+    .fetch_optional(pool)
+    .await?
+    .ok_or(anyhow::anyhow!("Release {release_id} not found"))?;
+
+    let source: ReleaseConfig = serde_json::from_value(release.config)?;
+    let (env, project_secrets) = project_environment(owner, repo, pool).await?;
+    let (service, service_env) = service_network(project_id, container_settings.port, pool).await?;
+    let config = ReleaseConfig {
+        env: [service_env, env].concat(),
+        secrets: project_secrets,
+        cmd: source.cmd,
+        workers: source.workers,
+        service,
+        private: Some(private_network(owner, repo)),
+        volumes: project_mounts(project_id, container_name, pool).await?,
+        limits: Some(project_limits(project_id, container_settings, pool).await?),
+        restarts: Some(project_restarts(project_id, pool).await?),
+        site: source.site,
+    };
+
+    rollback_docker(
+        project_id,
+        owner,
+        repo,
+        container_name,
+        &release.image,
+        &config,
+        pool.clone(),
+        container_settings,
+        secrets,
+    )
+    .await
+}
+
+/// The running canary as a deploy, promoting it starts nothing. Its release is recorded
+/// once the proxy points at it
+async fn canary_release(project_id: Uuid, pool: &PgPool) -> Result<DockerContainer> {
+    let canary = sqlx::query!(
+        r#"SELECT build_id, container_id, ip, port, image, config
+           FROM canaries
+           WHERE project_id = $1
+        "#,
+        project_id
+    )
+    .fetch_optional(pool)
+    .await?
+    .ok_or(anyhow::anyhow!("Project has no canary to promote"))?;
+
+    let db_url = database_url(project_id, pool).await?;
+
+    Ok(DockerContainer {
+        id: canary.container_id,
+        ip: canary.ip,
+        port: canary.port,
+        build_log: format!("Promoted the canary of build {}\n", canary.build_id),
+        db_url,
+        image: canary.image,
+        config: serde_json::from_value(canary.config)?,
+        site: None,
+    })
+}
+
+/// Keeps the container of a canary build running next to the live one and tells the proxy
+/// to send it `weight` percent of the requests. Its request metrics start from zero
+async fn start_canary(
+    build_id: Uuid,
+    project_id: Uuid,
+    container_name: &str,
+    container_id: &str,
+    ip: &str,
+    port: i32,
+    image: &str,
+    config: &ReleaseConfig,
+    weight: i32,
+    pool: &PgPool,
+) -> Result<String, BuildError> {
+    if let Err(err) = keep_canary(container_name, container_id).await {
+        return Err(BuildError {
+            message: format!("Failed to keep canary of {container_name}"),
+            inner_error: Some(err.into()),
+        });
+    }
+
+    reset_release_requests(container_name);
+
+    if let Err(err) = sqlx::query!(
+        r#"INSERT INTO canaries (project_id, build_id, container_id, ip, port, image, config, weight)
+           VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+           ON CONFLICT (project_id) DO UPDATE
+           SET build_id = $2, container_id = $3, ip = $4, port = $5, image = $6, config = $7,
+               weight = $8, created_at = now()
+        "#,
+        project_id,
+        build_id,
+        container_id,
+        ip,
+        port,
+        image,
+        serde_json::to_value(config).unwrap(),
+        weight,
+    )
+    .execute(pool)
+    .await
+    {
+        return Err(BuildError {
+            message: "Can't store canary: Failed to query database".to_string(),
+            inner_error: Some(err.into()),
+        });
+    }
+
+    let message = match weight {
+        0 => format!("Built {build_id}, it goes live once it is promoted"),
+        weight => format!("Started build {build_id} as a canary on {weight}% of requests"),
+    };
+    if let Err(err) = record_activity(project_id, "canary", &message, pool).await {
+        tracing::error!(?err, "Can't record activity: Failed to query database");
+    }
+
+    Ok(container_name.to_string())
+}
+
+/// Retires the former container of a preview and tells the proxy about the new one. Every
+/// deploy of the branch keeps the preview around for another `container.previewttl`
+async fn start_preview(
+    build_id: Uuid,
+    project_id: Uuid,
+    branch: &str,
+    name: &str,
+    source: &str,
+    container_id: &str,
+    port: i32,
+    pool: &PgPool,
+    container_settings: &ContainerSettings,
+) -> Result<String, BuildError> {
+    // two branches can come down to the same name, the first one keeps it
+    let stored = sqlx::query!(
+        r#"INSERT INTO previews (name, project_id, branch, source, build_id, container_id, port, expires_at)
+           VALUES ($1, $2, $3, $4, $5, $6, $7, now() + make_interval(secs => $8))
+           ON CONFLICT (name) DO UPDATE
+           SET source = $4, build_id = $5, container_id = $6, port = $7,
+               expires_at = now() + make_interval(secs => $8), updated_at = now()
+           WHERE previews.project_id = $2 AND previews.branch = $3
+        "#,
+        name,
+        project_id,
+        branch,
+        source,
+        build_id,
+        container_id,
+        port,
+        container_settings.previewttl as f64,
+    )
+    .execute(pool)
+    .await;
+    match stored {
+        Ok(stored) if stored.rows_affected() == 0 => {
+            discard_container(name, container_id).await;
+            return Err(BuildError {
+                message: format!("Preview {name} already belongs to another branch, rename {branch} to deploy it"),
+                inner_error: None,
+            });
+        }
+        Ok(_) => {}
+        Err(err) => {
+            return Err(BuildError {
+                message: "Can't store preview: Failed to query database".to_string(),
+                inner_error: Some(err.into()),
+            });
+        }
+    }
+
+    if let Err(err) = promote_container(name, container_id, container_settings).await {
+        return Err(BuildError {
+            message: format!("Failed to retire previous container of preview {name}"),
+            inner_error: Some(err.into()),
+        });
+    }
+
+    let message = format!("Deployed build {build_id} of {branch} as a preview at {name}");
+    if let Err(err) = record_activity(project_id, "preview", &message, pool).await {
+        tracing::error!(?err, "Can't record activity: Failed to query database");
+    }
+
+    Ok(name.to_string())
+}
+
+async fn reconfigure_release(
+    project_id: Uuid,
+    owner: &str,
+    repo: &str,
+    container_name: &str,
+    pool: &PgPool,
+    container_settings: &ContainerSettings,
+    secrets: &SecretCipher,
+) -> Result<DockerContainer> {
+    let release = sqlx::query!(
+        r#"SELECT image, config
+           FROM releases
+           WHERE project_id = $1
+           ORDER BY created_at DESC
+           LIMIT 1
+        "#,
+        project_id
+    )
+    .fetch_optional(pool)
+    .await?
+    .ok_or(anyhow::anyhow!("Project {owner}/{repo} has no release to reconfigure"))?;
+
+    let mut config: ReleaseConfig = serde_json::from_value(release.config)?;
+    let (env, project_secrets) = project_environment(owner, repo, pool).await?;
+    let (service, service_env) = service_network(project_id, container_settings.port, pool).await?;
+    config.env = [service_env, env].concat();
+    config.secrets = project_secrets;
+    config.service = service;
+    config.private = Some(private_network(owner, repo));
+    config.volumes = project_mounts(project_id, container_name, pool).await?;
+    config.limits = Some(project_limits(project_id, container_settings, pool).await?);
+    config.restarts = Some(project_restarts(project_id, pool).await?);
+
+    rollback_docker(
+        project_id,
+        owner,
+        repo,
+        container_name,
+        &release.image,
+        &config,
+        pool.clone(),
+        container_settings,
+        secrets,
+    )
+    .await
+}
+
+/// Login for the registry an image is pulled from, none when the project has no credentials
+/// for that registry and the image is pulled anonymously
+async fn registry_credentials(
+    project_id: Uuid,
+    image: &str,
+    pool: &PgPool,
+    secrets: &SecretCipher,
+) -> Result<Option<RegistryCredentials>> {
+    let registry = image_registry(image);
+    let credentials = sqlx::query!(
+        "SELECT username, password FROM registry_credentials WHERE project_id = $1 AND registry = $2",
+        project_id,
+        registry
+    )
+    .fetch_optional(pool)
+    .await?;
+
+    match credentials {
+        Some(credentials) => Ok(Some(RegistryCredentials {
+            registry: registry.to_string(),
+            username: credentials.username,
+            password: secrets.decrypt(&credentials.password)?,
+        })),
+        None => Ok(None),
+    }
+}
+
+/// Commit a build deploys. Builds read the checkout, everything else restarts an image that
+/// was built before and takes the commit of that build
+async fn build_commit(
+    kind: &BuildKind,
+    project_id: Uuid,
+    container_src: &str,
+    pool: &PgPool,
+) -> Option<String> {
+    let commit_sha = match kind {
+        BuildKind::Build | BuildKind::Canary(_) | BuildKind::Preview(_) => {
+            let repo = git2::Repository::open(container_src).ok()?;
+            let commit = repo.head().ok()?.peel_to_commit().ok()?;
+            return Some(commit.id().to_string());
+        }
+        // nothing was pushed, the registry knows what the image was built from
+        BuildKind::Image(_) => return None,
+        // a promoted release was built from the same commit wherever it runs
+        BuildKind::Rollback(release_id) | BuildKind::Release(release_id) => sqlx::query!(
+            r#"SELECT builds.commit_sha
+               FROM releases
+               JOIN builds ON builds.id = releases.build_id
+               WHERE releases.id = $1
+            "#,
+            release_id
+        )
+        .fetch_optional(pool)
+        .await
+        .map(|build| build.and_then(|build| build.commit_sha)),
+        BuildKind::Reconfigure(_) => sqlx::query!(
+            r#"SELECT builds.commit_sha
+               FROM releases
+               JOIN builds ON builds.id = releases.build_id
+               WHERE releases.project_id = $1
+               ORDER BY releases.created_at DESC
+               LIMIT 1
+            "#,
+            project_id
+        )
+        .fetch_optional(pool)
+        .await
+        .map(|build| build.and_then(|build| build.commit_sha)),
+        BuildKind::Promote => sqlx::query!(
+            r#"SELECT builds.commit_sha
+               FROM canaries
+               JOIN builds ON builds.id = canaries.build_id
+               WHERE canaries.project_id = $1
+            "#,
+            project_id
+        )
+        .fetch_optional(pool)
+        .await
+        .map(|build| build.and_then(|build| build.commit_sha)),
+    };
+
+    match commit_sha {
+        Ok(commit_sha) => commit_sha,
+        Err(err) => {
+            tracing::error!(?err, "Can't get commit of build: Failed to query database");
+            None
+        }
+    }
+}
+
+/// Keeps the image of a successful build around for rollbacks and forgets releases beyond
+/// the configured history. The deploy is already live at this point, so failures are only
+/// logged and give no release id.
+async fn record_release(
+    build_id: Uuid,
+    project_id: Uuid,
+    container_name: &str,
+    image: &str,
+    config: &ReleaseConfig,
+    description: &str,
+    pool: &PgPool,
+    container_settings: &ContainerSettings,
+) -> Option<Uuid> {
+    if let Err(err) = tag_release_image(container_name, image, build_id).await {
+        tracing::error!(?err, "Can't record release: Failed to tag image");
+        return None;
+    }
+
+    // without the registry the release is stored with the image id, it is only around as
+    // long as the host keeps it
+    let image = match &container_settings.registry {
+        Some(registry) => match push_release_image(registry, container_name, build_id).await {
+            Ok(reference) => reference,
+            Err(err) => {
+                tracing::error!(?err, "Can't push release: Failed to push image to the registry");
+                image.to_string()
+            }
+        },
+        None => image.to_string(),
+    };
+
+    let release_id = Uuid::from(Ulid::new());
+    if let Err(err) = sqlx::query!(
+        r#"INSERT INTO releases (id, project_id, build_id, image, config, description)
+           VALUES ($1, $2, $3, $4, $5, $6)
+        "#,
+        release_id,
+        project_id,
+        build_id,
+        image,
+        serde_json::to_value(config).unwrap(),
+        description,
+    )
+    .execute(pool)
+    .await
+    {
+        tracing::error!(?err, "Can't record release: Failed to query database");
+        return None;
+    }
+
+    let expired = match sqlx::query!(
+        r#"SELECT id, build_id
+           FROM releases
+           WHERE project_id = $1
+           AND id IS DISTINCT FROM (SELECT pinned_release_id FROM projects WHERE id = $1)
+           ORDER BY created_at DESC
+           OFFSET $2
+        "#,
+        project_id,
+        container_settings.releases,
+    )
+    .fetch_all(pool)
+    .await
+    {
+        Ok(expired) => expired,
+        Err(err) => {
+            tracing::error!(?err, "Can't prune releases: Failed to query database");
+            return Some(release_id);
+        }
+    };
+
+    for release in expired {
+        if let Err(err) = untag_release_image(container_name, release.build_id).await {
+            tracing::debug!(?err, "Can't prune release: Failed to untag image");
+        }
+        // the registry keeps its copy until the image collector runs
+        if let Some(registry) = &container_settings.registry {
+            let repo = format!("{registry}/{container_name}");
+            if let Err(err) = untag_release_image(&repo, release.build_id).await {
+                tracing::debug!(?err, "Can't prune release: Failed to untag image");
+            }
+        }
+
+        if let Err(err) = sqlx::query!("DELETE FROM releases WHERE id = $1", release.id)
+            .execute(pool)
+            .await
+        {
+            tracing::error!(?err, "Can't prune release: Failed to query database");
+        }
+    }
+
+    Some(release_id)
 }
 
 pub async fn process_task_poll(
-    waiting_queue: ConcurrentMutex<VecDeque<BuildItem>>,
-    waiting_set: ConcurrentMutex<HashSet<String>>,
+    state: BuildQueueState,
     build_count: Arc<AtomicUsize>,
+    owner_limit: usize,
+    build_timeout: Duration,
     pool: PgPool,
+    container_settings: ContainerSettings,
+    quota_settings: QuotaSettings,
+    secrets: SecretCipher,
+    notifier: Notifier,
+    lfs: LfsStorage,
 ) {
     loop {
-        let mut waiting_queue = waiting_queue.lock().await;
-        let mut waiting_set = waiting_set.lock().await;
+        let next = match build_count.load(Ordering::SeqCst) > 0 && !state.draining() {
+            true => state.next(owner_limit).await,
+            false => None,
+        };
 
-        let build_count = Arc::clone(&build_count);
+        if let Some((build_item, cancel)) = next {
+            let build_count = Arc::clone(&build_count);
+            let state = state.clone();
+            let pool = pool.clone();
+            let container_settings = container_settings.clone();
+            let quota_settings = quota_settings.clone();
+            let secrets = secrets.clone();
+            let notifier = notifier.clone();
+            let lfs = lfs.clone();
 
-        if build_count.load(Ordering::SeqCst) > 0 && waiting_queue.len() > 0 {
-            let build_item = match waiting_queue.pop_front() {
-                Some(build_item) => build_item,
-                None => continue,
-            };
-            waiting_set.remove(&build_item.container_name);
+            build_count.fetch_sub(1, Ordering::SeqCst);
+            BUILDS_RUNNING.inc();
+            // the whole build is one span under whatever queued it, the docker steps and
+            // the deploy go under it
+            let span = tracing::info_span!(
+                "build",
+                build_id = %build_item.build_id,
+                app = %build_item.container_name,
+                kind = build_item.kind.label(),
+                status = tracing::field::Empty,
+            );
+            span.set_parent(build_item.trace.clone());
+            let build = async move {
+                let build_id = build_item.build_id;
+                let kind = build_item.kind.label();
+                let started = std::time::Instant::now();
 
-            {
-                let build_count = Arc::clone(&build_count);
-                let pool = pool.clone();
-
-                build_count.fetch_sub(1, Ordering::SeqCst);
-                tokio::spawn(async move {
-                    match trigger_build(build_item, pool).await {
-                        Ok(subdomain) => tracing::info!("Project deployed at {subdomain}"),
-                        Err(BuildError {
-                            message,
-                            inner_error,
-                        }) => tracing::error!(?inner_error, message),
-                    };
-
-                    build_count.fetch_add(1, Ordering::SeqCst);
-                });
-            }
+                let status = match trigger_build(
+                    build_item,
+                    pool.clone(),
+                    container_settings,
+                    quota_settings,
+                    secrets,
+                    notifier,
+                    lfs,
+                    cancel.clone(),
+                    build_timeout,
+                )
+                .await
+                {
+                    Ok(subdomain) => {
+                        tracing::info!("Project deployed at {subdomain}");
+                        "successful"
+                    }
+                    Err(BuildError { message, .. }) if cancel.is_cancelled() => {
+                        tracing::info!(message);
+                        "cancelled"
+                    }
+                    Err(BuildError {
+                        message,
+                        inner_error,
+                    }) => {
+                        tracing::error!(?inner_error, message);
+                        "failed"
+                    }
+                };
+                tracing::Span::current().record("status", status);
+
+                DEPLOY_DURATION
+                    .with_label_values(&[kind, status])
+                    .observe(started.elapsed().as_secs_f64());
+                if let Err(err) = meter_build(build_id, started.elapsed(), &pool).await {
+                    tracing::error!(?err, "Can't meter build: Failed to query database");
+                }
+                BUILDS_RUNNING.dec();
+                state.finish(build_id).await;
+                build_count.fetch_add(1, Ordering::SeqCst);
+            };
+            tokio::spawn(build.instrument(span));
+            continue;
         }
-        std::thread::sleep(std::time::Duration::from_millis(5));
+        tokio::time::sleep(std::time::Duration::from_millis(5)).await;
     }
 }
 
 pub async fn process_task_enqueue(
-    waiting_queue: ConcurrentMutex<VecDeque<BuildItem>>,
-    waiting_set: ConcurrentMutex<HashSet<String>>,
+    state: BuildQueueState,
     pool: PgPool,
+    quota_settings: QuotaSettings,
     mut receive_channel: Receiver<BuildQueueItem>,
 ) {
     while let Some(message) = receive_channel.recv().await {
@@ -295,12 +1678,12 @@ pub async fn process_task_enqueue(
             container_src,
             owner,
             repo,
+            kind,
+            trace,
         } = message;
-        let mut waiting_queue = waiting_queue.lock().await;
-        let mut waiting_set = waiting_set.lock().await;
 
         let project = match sqlx::query!(
-            r#"SELECT projects.id
+            r#"SELECT projects.id, projects.service, projects.suspended_at IS NOT NULL AS "suspended!"
                FROM projects
                JOIN project_owners ON projects.owner_id = project_owners.id
                WHERE project_owners.name = $1
@@ -325,64 +1708,174 @@ pub async fn process_task_enqueue(
             }
         };
 
-        if waiting_set.contains(&container_name) {
+        if project.suspended {
+            tracing::warn!(%owner, %repo, "Not building suspended project");
             continue;
         }
 
-        let build_id = Uuid::from(Ulid::new());
-        match sqlx::query!(
-            r#"INSERT INTO builds (id, project_id)
-               VALUES ($1, $2)
-            "#,
-            build_id,
-            project.id,
-        )
-        .fetch_optional(&pool)
-        .await
-        {
-            Ok(build_details) => build_details,
-            Err(err) => {
-                tracing::error!(%err, "Can't create build: Failed to query database");
-                continue;
-            }
+        // an app declaring services deploys them instead of building itself. A manifest that
+        // doesn't parse is left to the build, its log says what is wrong. Services read the
+        // same checkout and must not deploy themselves again
+        let services = match (&kind, &project.service) {
+            (BuildKind::Build, None) => Manifest::load(Path::new(&container_src))
+                .ok()
+                .flatten()
+                .map(|manifest| manifest.services)
+                .filter(|services| !services.is_empty()),
+            _ => None,
         };
+        if let Some(services) = services {
+            deploy_services(&state, &pool, &quota_settings, project.id, &owner, &repo, &container_src, &services, &trace).await;
+            continue;
+        }
 
-        let build_item = BuildItem {
-            build_id,
+        let build_item = BuildQueueItem {
             container_name,
             container_src,
             owner,
             repo,
+            kind,
+            trace,
         };
+        queue_build(&state, &pool, &quota_settings, project.id, build_item).await;
+    }
+}
+
+async fn queue_build(
+    state: &BuildQueueState,
+    pool: &PgPool,
+    quota_settings: &QuotaSettings,
+    project_id: Uuid,
+    item: BuildQueueItem,
+) {
+    let BuildQueueItem {
+        container_name,
+        container_src,
+        owner,
+        repo,
+        kind,
+        trace,
+    } = item;
 
-        waiting_set.insert(build_item.container_name.clone());
-        waiting_queue.push_back(build_item);
+    if state.covered(&container_name, &kind).await {
+        return;
+    }
+
+    let build_id = Uuid::from(Ulid::new());
+    if let Err(err) = sqlx::query!(
+        r#"INSERT INTO builds (id, project_id)
+           VALUES ($1, $2)
+        "#,
+        build_id,
+        project_id,
+    )
+    .fetch_optional(pool)
+    .await
+    {
+        tracing::error!(%err, "Can't create build: Failed to query database");
+        return;
+    };
+    live::publish(project_id, Update::Build { build_id, status: "PENDING" });
+
+    // without the accounts the build waits on nobody's quota, better than not building
+    let accounts = build_accounts(project_id, quota_settings, pool)
+        .await
+        .unwrap_or_else(|err| {
+            tracing::error!(?err, "Can't get build quotas: Failed to query database");
+            Vec::new()
+        });
+
+    let build_item = BuildItem {
+        build_id,
+        container_name,
+        container_src,
+        owner,
+        repo,
+        kind,
+        trace,
+        accounts,
+    };
+
+    state.enqueue(build_item, pool).await;
+}
+
+/// Queues a build of every service of an app for a push to it, they all build from the
+/// same checkout
+async fn deploy_services(
+    state: &BuildQueueState,
+    pool: &PgPool,
+    quota_settings: &QuotaSettings,
+    app_id: Uuid,
+    owner: &str,
+    repo: &str,
+    container_src: &str,
+    services: &BTreeMap<String, Service>,
+    trace: &opentelemetry::Context,
+) {
+    let builds = match sync_services(app_id, owner, repo, container_src, services, pool).await {
+        Ok(builds) => builds,
+        Err(err) => {
+            tracing::error!(?err, "Can't deploy services: Failed to create service projects");
+            let message = format!("Failed to deploy services: {err}");
+            if let Err(err) = record_activity(app_id, "push", &message, pool).await {
+                tracing::error!(?err, "Can't record activity: Failed to query database");
+            }
+            return;
+        }
+    };
+
+    let names = services.keys().cloned().collect::<Vec<_>>().join(", ");
+    let message = format!("Deploying services {names}");
+    if let Err(err) = record_activity(app_id, "push", &message, pool).await {
+        tracing::error!(?err, "Can't record activity: Failed to query database");
+    }
+
+    for build in builds {
+        let item = BuildQueueItem {
+            container_name: build.container_name,
+            container_src: build.container_src,
+            owner: owner.to_string(),
+            repo: build.repo,
+            kind: BuildKind::Build,
+            trace: trace.clone(),
+        };
+        queue_build(state, pool, quota_settings, build.project_id, item).await;
     }
 }
 
 pub async fn build_queue_handler(build_queue: BuildQueue) {
     {
-        let waiting_queue = Arc::clone(&build_queue.waiting_queue);
-        let waiting_set = Arc::clone(&build_queue.waiting_set);
+        let state = build_queue.state.clone();
         let pool = build_queue.pg_pool.clone();
+        let container_settings = build_queue.container_settings.clone();
+        let quota_settings = build_queue.quota_settings.clone();
+        let secrets = build_queue.secrets.clone();
+        let notifier = build_queue.notifier.clone();
+        let lfs = build_queue.lfs.clone();
 
         tokio::spawn(async move {
-            process_task_poll(waiting_queue, waiting_set, build_queue.build_count, pool).await;
+            process_task_poll(
+                state,
+                build_queue.build_count,
+                build_queue.owner_limit,
+                build_queue.build_timeout,
+                pool,
+                container_settings,
+                quota_settings,
+                secrets,
+                notifier,
+                lfs,
+            )
+            .await;
         });
     }
     {
-        let waiting_queue = Arc::clone(&build_queue.waiting_queue);
-        let waiting_set = Arc::clone(&build_queue.waiting_set);
+        let state = build_queue.state.clone();
         let pool = build_queue.pg_pool.clone();
+        let quota_settings = build_queue.quota_settings.clone();
 
         tokio::spawn(async move {
-            process_task_enqueue(
-                waiting_queue,
-                waiting_set,
-                pool,
-                build_queue.receive_channel,
-            )
-            .await;
+            process_task_enqueue(state, pool, quota_settings, build_queue.receive_channel).await;
         });
     }
 }
@@ -0,0 +1,338 @@
+use std::collections::{HashMap, HashSet};
+
+use anyhow::Result;
+use bollard::Docker;
+use garde::Validate;
+use serde::{Deserialize, Serialize};
+use sqlx::PgPool;
+use uuid::Uuid;
+
+use crate::configuration::QuotaSettings;
+
+/// What one account may have
+#[derive(Serialize, Debug, Clone)]
+pub struct Quotas {
+    pub apps: i32,
+    /// builds running at the same time
+    pub builds: i32,
+    /// in MiB, release images and volumes
+    pub storage: i32,
+    /// in MiB, the data of postgres addons
+    pub database: i32,
+    /// tcp and udp ports leased to the apps
+    pub ports: i32,
+}
+
+/// Quotas set by a platform admin on a user, None keeps the one of the platform
+#[derive(Serialize, Deserialize, Validate, Debug, Clone, Default)]
+pub struct QuotaOverrides {
+    #[garde(range(min = 0, max = 100000))]
+    pub apps: Option<i32>,
+    #[garde(range(min = 1, max = 1000))]
+    pub builds: Option<i32>,
+    /// in MiB
+    #[garde(range(min = 0, max = 1073741824))]
+    pub storage: Option<i32>,
+    /// in MiB
+    #[garde(range(min = 0, max = 1073741824))]
+    pub database: Option<i32>,
+    #[garde(range(min = 0, max = 1000))]
+    pub ports: Option<i32>,
+}
+
+/// What an account has right now, storage and database in MiB
+#[derive(Serialize, Debug, Clone, Default)]
+pub struct Usage {
+    pub apps: i64,
+    pub builds: i64,
+    /// images of releases, what docker can tell
+    pub images: i64,
+    /// sizes of the volumes, whether they hold that much or not
+    pub volumes: i64,
+    pub storage: i64,
+    pub database: i64,
+    pub ports: i64,
+}
+
+/// The quotas of an account next to what it uses
+#[derive(Serialize, Debug, Clone)]
+pub struct AccountQuotas {
+    pub username: String,
+    pub quotas: Quotas,
+    pub usage: Usage,
+    /// what a platform admin set on the user
+    pub overrides: QuotaOverrides,
+}
+
+const MIB: i64 = 1024 * 1024;
+
+pub async fn user_quotas(user_id: Uuid, settings: &QuotaSettings, pool: &PgPool) -> Result<(String, Quotas, QuotaOverrides)> {
+    let user = sqlx::query!(
+        "SELECT username, app_quota, build_quota, storage_quota, database_quota, port_quota FROM users WHERE id = $1",
+        user_id
+    )
+    .fetch_one(pool)
+    .await?;
+
+    let quotas = Quotas {
+        apps: user.app_quota.unwrap_or(settings.apps),
+        builds: user.build_quota.unwrap_or(settings.builds),
+        storage: user.storage_quota.unwrap_or(settings.storage),
+        database: user.database_quota.unwrap_or(settings.database),
+        ports: user.port_quota.unwrap_or(settings.ports),
+    };
+    let overrides = QuotaOverrides {
+        apps: user.app_quota,
+        builds: user.build_quota,
+        storage: user.storage_quota,
+        database: user.database_quota,
+        ports: user.port_quota,
+    };
+    Ok((user.username, quotas, overrides))
+}
+
+async fn account_apps(user_id: Uuid, pool: &PgPool) -> Result<Vec<String>> {
+    let projects = sqlx::query!(
+        r#"SELECT projects.name AS project, project_owners.name AS owner
+           FROM projects
+           JOIN project_owners ON projects.owner_id = project_owners.id
+           JOIN users_owners ON users_owners.owner_id = project_owners.id
+           WHERE users_owners.user_id = $1 AND users_owners.role = 'owner'
+        "#,
+        user_id
+    )
+    .fetch_all(pool)
+    .await?;
+
+    Ok(projects
+        .into_iter()
+        .map(|project| format!("{}-{}", project.owner, project.project).replace('.', "-"))
+        .collect())
+}
+
+/// What the apps of an account use. Images shared between its apps count once, layers
+/// shared with other images don't count. Docker walks the volumes to tell, so this is slow
+pub async fn usage(user_id: Uuid, pool: &PgPool) -> Result<Usage> {
+    let counts = sqlx::query!(
+        r#"SELECT count(DISTINCT projects.id) FILTER (WHERE projects.service IS NULL) AS "apps!",
+             count(DISTINCT builds.id) AS "builds!",
+             (SELECT COALESCE(sum(volumes.size_mb), 0) FROM volumes
+              JOIN projects ON projects.id = volumes.project_id
+              JOIN users_owners ON users_owners.owner_id = projects.owner_id
+              WHERE users_owners.user_id = $1 AND users_owners.role = 'owner') AS "volumes!",
+             (SELECT count(*) FROM port_leases
+              JOIN projects ON projects.id = port_leases.project_id
+              JOIN users_owners ON users_owners.owner_id = projects.owner_id
+              WHERE users_owners.user_id = $1 AND users_owners.role = 'owner') AS "ports!"
+           FROM users_owners
+           JOIN projects ON projects.owner_id = users_owners.owner_id
+           LEFT JOIN builds ON builds.project_id = projects.id AND builds.status = 'building'
+           WHERE users_owners.user_id = $1 AND users_owners.role = 'owner'
+        "#,
+        user_id
+    )
+    .fetch_one(pool)
+    .await?;
+
+    let apps = account_apps(user_id, pool).await?;
+    let (images, database) = match apps.is_empty() {
+        true => (0, 0),
+        false => disk_usage(&apps).await?,
+    };
+    let volumes = counts.volumes;
+
+    Ok(Usage {
+        apps: counts.apps,
+        builds: counts.builds,
+        images,
+        volumes,
+        storage: images + volumes,
+        database,
+        ports: counts.ports,
+    })
+}
+
+/// MiB used by the release images and the postgres data of containers `apps`
+async fn disk_usage(apps: &[String]) -> Result<(i64, i64)> {
+    let docker = Docker::connect_with_local_defaults()?;
+    let usage = docker.df().await?;
+
+    let apps = apps.iter().map(String::as_str).collect::<HashSet<_>>();
+    let ours = |tag: &str| {
+        let repo = tag.rsplit_once(':').map(|(repo, _)| repo).unwrap_or(tag);
+        apps.contains(repo.rsplit('/').next().unwrap_or(repo))
+    };
+
+    let images = usage
+        .images
+        .unwrap_or_default()
+        .into_iter()
+        .filter(|image| image.repo_tags.iter().any(|tag| ours(tag)))
+        .map(|image| (image.id, image.size - image.shared_size.max(0)))
+        .collect::<HashMap<_, _>>()
+        .values()
+        .sum::<i64>();
+
+    let database = usage
+        .volumes
+        .unwrap_or_default()
+        .into_iter()
+        .filter(|volume| {
+            volume
+                .name
+                .strip_suffix("-volume")
+                .is_some_and(|app| apps.contains(app))
+        })
+        .filter_map(|volume| volume.usage_data.map(|usage| usage.size.max(0)))
+        .sum::<i64>();
+
+    Ok((images / MIB, database / MIB))
+}
+
+pub async fn account_quotas(user_id: Uuid, settings: &QuotaSettings, pool: &PgPool) -> Result<AccountQuotas> {
+    let (username, quotas, overrides) = user_quotas(user_id, settings, pool).await?;
+    let usage = usage(user_id, pool).await?;
+    Ok(AccountQuotas {
+        username,
+        quotas,
+        usage,
+        overrides,
+    })
+}
+
+/// Users an app of the owner counts against, the users who own it
+async fn owner_accounts(owner_id: Uuid, pool: &PgPool) -> Result<Vec<Uuid>> {
+    let users = sqlx::query!(
+        "SELECT user_id FROM users_owners WHERE owner_id = $1 AND role = 'owner'",
+        owner_id
+    )
+    .fetch_all(pool)
+    .await?;
+
+    Ok(users.into_iter().map(|user| user.user_id).collect())
+}
+
+async fn project_owner(project_id: Uuid, pool: &PgPool) -> Result<Uuid> {
+    let project = sqlx::query!("SELECT owner_id FROM projects WHERE id = $1", project_id)
+        .fetch_one(pool)
+        .await?;
+    Ok(project.owner_id)
+}
+
+/// Why a new app can't be created in the owner, None when every account it counts against
+/// has room for it
+pub async fn check_new_app(owner_id: Uuid, settings: &QuotaSettings, pool: &PgPool) -> Result<Option<String>> {
+    for user_id in owner_accounts(owner_id, pool).await? {
+        let (username, quotas, _) = user_quotas(user_id, settings, pool).await?;
+        let apps = sqlx::query!(
+            r#"SELECT count(*) AS "count!"
+               FROM projects
+               JOIN users_owners ON users_owners.owner_id = projects.owner_id
+               WHERE users_owners.user_id = $1 AND users_owners.role = 'owner' AND projects.service IS NULL
+            "#,
+            user_id
+        )
+        .fetch_one(pool)
+        .await?;
+
+        if apps.count >= i64::from(quotas.apps) {
+            return Ok(Some(format!(
+                "{username} has {} of {} apps, the most their account may have. Delete an app or ask the platform admins for a higher quota",
+                apps.count, quotas.apps
+            )));
+        }
+    }
+    Ok(None)
+}
+
+/// Why the app can't take `adding` more MiB of storage, None when every account it counts
+/// against has room. With nothing added it says why the app can't deploy: an account over
+/// its storage or database quota doesn't get new releases until it frees space
+pub async fn check_storage(
+    project_id: Uuid,
+    adding: i64,
+    settings: &QuotaSettings,
+    pool: &PgPool,
+) -> Result<Option<String>> {
+    let owner_id = project_owner(project_id, pool).await?;
+    for user_id in owner_accounts(owner_id, pool).await? {
+        let (username, quotas, _) = user_quotas(user_id, settings, pool).await?;
+        let usage = usage(user_id, pool).await?;
+
+        if usage.storage + adding > i64::from(quotas.storage) {
+            let needs = match adding {
+                0 => String::new(),
+                adding => format!(", this needs {adding} MiB more"),
+            };
+            return Ok(Some(format!(
+                "{username} uses {} of {} MiB storage, {} MiB for images and {} MiB for volumes{needs}. Delete apps or volumes, or ask the platform admins for a higher quota",
+                usage.storage, quotas.storage, usage.images, usage.volumes
+            )));
+        }
+        if usage.database > i64::from(quotas.database) {
+            return Ok(Some(format!(
+                "The databases of {username} hold {} MiB, more than their quota of {} MiB. Delete data or ask the platform admins for a higher quota",
+                usage.database, quotas.database
+            )));
+        }
+    }
+    Ok(None)
+}
+
+/// Why the app can't get a new database, None when every account it counts against has
+/// room for one
+pub async fn check_database(project_id: Uuid, settings: &QuotaSettings, pool: &PgPool) -> Result<Option<String>> {
+    let owner_id = project_owner(project_id, pool).await?;
+    for user_id in owner_accounts(owner_id, pool).await? {
+        let (username, quotas, _) = user_quotas(user_id, settings, pool).await?;
+        let usage = usage(user_id, pool).await?;
+
+        if usage.database >= i64::from(quotas.database) {
+            return Ok(Some(format!(
+                "The databases of {username} hold {} MiB of their {} MiB quota. Delete data or ask the platform admins for a higher quota",
+                usage.database, quotas.database
+            )));
+        }
+    }
+    Ok(None)
+}
+
+/// Why the app can't lease another port, None when every account it counts against has
+/// room for one
+pub async fn check_port(project_id: Uuid, settings: &QuotaSettings, pool: &PgPool) -> Result<Option<String>> {
+    let owner_id = project_owner(project_id, pool).await?;
+    for user_id in owner_accounts(owner_id, pool).await? {
+        let (username, quotas, _) = user_quotas(user_id, settings, pool).await?;
+        let ports = sqlx::query!(
+            r#"SELECT count(*) AS "count!"
+               FROM port_leases
+               JOIN projects ON projects.id = port_leases.project_id
+               JOIN users_owners ON users_owners.owner_id = projects.owner_id
+               WHERE users_owners.user_id = $1 AND users_owners.role = 'owner'
+            "#,
+            user_id
+        )
+        .fetch_one(pool)
+        .await?;
+
+        if ports.count >= i64::from(quotas.ports) {
+            return Ok(Some(format!(
+                "{username} has {} of {} ports, the most their account may lease. Release a port or ask the platform admins for a higher quota",
+                ports.count, quotas.ports
+            )));
+        }
+    }
+    Ok(None)
+}
+
+/// Accounts a build of the app counts against with how many builds each may run at once,
+/// see [`crate::queue::BuildQueueState`]
+pub async fn build_accounts(project_id: Uuid, settings: &QuotaSettings, pool: &PgPool) -> Result<Vec<(Uuid, usize)>> {
+    let owner_id = project_owner(project_id, pool).await?;
+    let mut accounts = Vec::new();
+    for user_id in owner_accounts(owner_id, pool).await? {
+        let (_, quotas, _) = user_quotas(user_id, settings, pool).await?;
+        accounts.push((user_id, quotas.builds.max(1) as usize));
+    }
+    Ok(accounts)
+}
@@ -0,0 +1,102 @@
+use std::collections::HashMap;
+use std::sync::{Arc, Mutex};
+use std::time::Duration;
+
+use tokio::time::Instant;
+
+use crate::configuration::ContainerSettings;
+
+/// buckets kept before the ones nobody used for a minute are dropped
+const MAX_BUCKETS: usize = 100_000;
+
+/// Requests per second an app takes, in total and from one client, None doesn't limit
+#[derive(Debug, Clone, Copy, Default, PartialEq)]
+pub struct RateLimits {
+    pub app: Option<u32>,
+    pub ip: Option<u32>,
+    /// requests over the rate let through at once, after a quiet while
+    pub burst: u32,
+}
+
+impl RateLimits {
+    /// The limits set on an app, never above the ones of the platform. The platform doesn't
+    /// limit where the configuration says 0
+    pub fn new(app: Option<i32>, ip: Option<i32>, burst: Option<i32>, container_settings: &ContainerSettings) -> Self {
+        let clamp = |rate: Option<i32>, platform: u32| {
+            let rate = rate.map(|rate| rate.max(1) as u32);
+            match (rate, platform) {
+                (Some(rate), 0) => Some(rate),
+                (Some(rate), platform) => Some(rate.min(platform)),
+                (None, 0) => None,
+                (None, platform) => Some(platform),
+            }
+        };
+
+        Self {
+            app: clamp(app, container_settings.ratelimit),
+            ip: clamp(ip, container_settings.ipratelimit),
+            burst: burst.map(|burst| burst.max(0) as u32).unwrap_or(container_settings.rateburst),
+        }
+    }
+}
+
+#[derive(Debug)]
+struct Bucket {
+    tokens: f64,
+    updated: Instant,
+}
+
+/// Token buckets of every app and of every client of an app. They are only kept in memory, a
+/// restart of the platform fills them up again
+#[derive(Debug, Clone, Default)]
+pub struct RateLimiter {
+    /// by app, and client ip for the buckets of one client
+    buckets: Arc<Mutex<HashMap<(String, Option<String>), Bucket>>>,
+}
+
+impl RateLimiter {
+    /// Takes a request of `ip` to `app` from the buckets it counts against. Err is how long
+    /// until the next request is let through, nothing is taken then
+    pub fn check(&self, app: &str, ip: &str, limits: &RateLimits) -> Result<(), Duration> {
+        let now = Instant::now();
+        let mut buckets = self.buckets.lock().unwrap();
+        if buckets.len() > MAX_BUCKETS {
+            buckets.retain(|_, bucket| now.duration_since(bucket.updated) < Duration::from_secs(60));
+        }
+
+        let limited = [
+            (limits.app, (app.to_string(), None)),
+            (limits.ip, (app.to_string(), Some(ip.to_string()))),
+        ];
+        let limited = limited
+            .into_iter()
+            .filter_map(|(rate, key)| rate.map(|rate| (f64::from(rate), key)))
+            .collect::<Vec<_>>();
+
+        let mut wait = Duration::ZERO;
+        for (rate, key) in &limited {
+            let capacity = rate + f64::from(limits.burst);
+            let bucket = buckets.entry(key.clone()).or_insert(Bucket {
+                tokens: capacity,
+                updated: now,
+            });
+            let elapsed = now.duration_since(bucket.updated).as_secs_f64();
+            bucket.tokens = (bucket.tokens + elapsed * rate).min(capacity);
+            bucket.updated = now;
+
+            if bucket.tokens < 1.0 {
+                wait = wait.max(Duration::from_secs_f64((1.0 - bucket.tokens) / rate));
+            }
+        }
+        if !wait.is_zero() {
+            return Err(wait);
+        }
+
+        for (_, key) in &limited {
+            if let Some(bucket) = buckets.get_mut(key) {
+                bucket.tokens -= 1.0;
+            }
+        }
+        Ok(())
+    }
+}
@@ -0,0 +1,311 @@
+//! Brings the database and docker back together after they drifted apart, usually when the
+//! host rebooted or the platform was killed halfway through something. Builds the platform
+//! lost track of are failed, containers no app owns anymore are removed and apps whose
+//! container is gone are deployed again. What holds data is only reported, an admin decides
+//!
+//! It runs when the platform starts, every `container.reconcileinterval` seconds and with
+//! `pmk admin reconcile`
+
+use std::collections::{HashMap, HashSet};
+use std::time::Duration;
+
+use anyhow::Result;
+use bollard::container::{ListContainersOptions, RemoveContainerOptions};
+use bollard::service::ContainerSummary;
+use bollard::Docker;
+use chrono::{DateTime, Utc};
+use lazy_static::lazy_static;
+use serde::Serialize;
+use sqlx::PgPool;
+use tokio::sync::mpsc::Sender;
+use tokio::sync::Mutex;
+use uuid::Uuid;
+
+use crate::activity::record_activity;
+use crate::configuration::ContainerSettings;
+use crate::docker::WORKER_LABEL;
+use crate::orchestrator;
+use crate::queue::{BuildKind, BuildQueueItem, BuildQueueState};
+use crate::telemetry::current_context;
+
+/// a build is written to the database just before it is queued
+const BUILD_GRACE: Duration = Duration::from_secs(60);
+/// containers are created before anything in the database points at them
+const CONTAINER_GRACE: Duration = Duration::from_secs(600);
+
+lazy_static! {
+    /// the loop and `pmk admin reconcile` take turns
+    static ref RECONCILING: Mutex<()> = Mutex::new(());
+}
+
+/// What a pass found and did about it
+#[derive(Serialize, Debug, Default)]
+pub struct Reconciled {
+    /// builds pending or building that no queue holds anymore, marked failed
+    pub failed_builds: Vec<Uuid>,
+    /// containers of deleted apps and leftovers of interrupted deploys, removed
+    pub removed_containers: Vec<String>,
+    /// containers of deleted apps with volumes, left for an admin to remove
+    pub kept_containers: Vec<String>,
+    /// apps whose web container was gone, deployed again with their live release
+    pub restored_apps: Vec<String>,
+    pub finished_at: DateTime<Utc>,
+}
+
+impl Reconciled {
+    fn is_empty(&self) -> bool {
+        self.failed_builds.is_empty()
+            && self.removed_containers.is_empty()
+            && self.kept_containers.is_empty()
+            && self.restored_apps.is_empty()
+    }
+}
+
+/// Reconciles when the platform starts and then every `container.reconcileinterval` seconds
+pub async fn reconciler(
+    pool: PgPool,
+    build_queue: BuildQueueState,
+    build_channel: Sender<BuildQueueItem>,
+    base: String,
+    container_settings: ContainerSettings,
+) {
+    if container_settings.reconcileinterval == 0 {
+        return;
+    }
+
+    let mut interval = tokio::time::interval(Duration::from_secs(container_settings.reconcileinterval));
+    loop {
+        interval.tick().await;
+
+        match reconcile(&pool, &build_queue, &build_channel, &base).await {
+            Ok(reconciled) if reconciled.is_empty() => {}
+            Ok(reconciled) => tracing::warn!(
+                failed_builds = reconciled.failed_builds.len(),
+                removed_containers = ?reconciled.removed_containers,
+                kept_containers = ?reconciled.kept_containers,
+                restored_apps = ?reconciled.restored_apps,
+                "Reconciled builds and containers with the database"
+            ),
+            Err(err) => tracing::error!(?err, "Can't reconcile builds and containers"),
+        }
+    }
+}
+
+/// One pass over builds, containers and apps
+pub async fn reconcile(
+    pool: &PgPool,
+    build_queue: &BuildQueueState,
+    build_channel: &Sender<BuildQueueItem>,
+    base: &str,
+) -> Result<Reconciled> {
+    let _turn = RECONCILING.lock().await;
+
+    let tracked = build_queue.tracked().await;
+    let building = tracked.values().cloned().collect::<HashSet<_>>();
+
+    let failed_builds = fail_lost_builds(&tracked, pool).await?;
+    let (removed_containers, kept_containers) = remove_orphans(&building, pool).await?;
+    let restored_apps = restore_vanished(&building, build_channel, base, pool).await?;
+
+    Ok(Reconciled {
+        failed_builds,
+        removed_containers,
+        kept_containers,
+        restored_apps,
+        finished_at: Utc::now(),
+    })
+}
+
+/// The queue only lives in memory, the builds it held when the platform stopped never finish.
+/// They would keep image collection waiting forever, see crate::registry
+async fn fail_lost_builds(tracked: &HashMap<Uuid, String>, pool: &PgPool) -> Result<Vec<Uuid>> {
+    let tracked = tracked.keys().copied().collect::<Vec<_>>();
+
+    let failed = sqlx::query!(
+        r#"UPDATE builds
+           SET status = 'failed', log = log || $1, finished_at = now(), updated_at = now()
+           WHERE status IN ('pending', 'building')
+           AND created_at < now() - make_interval(secs => $2)
+           AND NOT (id = ANY($3))
+           RETURNING id, project_id
+        "#,
+        "\nBuild lost: the platform stopped before it finished, push again to deploy\n",
+        BUILD_GRACE.as_secs_f64(),
+        &tracked[..],
+    )
+    .fetch_all(pool)
+    .await?;
+
+    for build in &failed {
+        let message = format!("Build {} was lost when the platform stopped, push again to deploy", build.id);
+        if let Err(err) = record_activity(build.project_id, "reconcile", &message, pool).await {
+            tracing::error!(?err, "Can't record activity: Failed to query database");
+        }
+    }
+
+    Ok(failed.into_iter().map(|build| build.id).collect())
+}
+
+/// Removes containers of apps and previews that no longer exist, and `{app}-next` containers
+/// of deploys that stopped halfway. Only the host of the platform is looked at, draining
+/// nodes are swept by crate::nodes
+async fn remove_orphans(building: &HashSet<String>, pool: &PgPool) -> Result<(Vec<String>, Vec<String>)> {
+    let mut apps = sqlx::query!(
+        r#"SELECT project_owners.name AS owner, projects.name AS project
+           FROM projects
+           JOIN project_owners ON projects.owner_id = project_owners.id
+        "#
+    )
+    .fetch_all(pool)
+    .await?
+    .into_iter()
+    .map(|app| format!("{}-{}", app.owner, app.project.trim_end_matches(".git")).replace('.', "-"))
+    .collect::<HashSet<_>>();
+    apps.extend(
+        sqlx::query!("SELECT name FROM previews")
+            .fetch_all(pool)
+            .await?
+            .into_iter()
+            .map(|preview| preview.name),
+    );
+
+    let docker = Docker::connect_with_local_defaults()?;
+    let containers = docker
+        .list_containers(Some(ListContainersOptions::<String> {
+            all: true,
+            ..Default::default()
+        }))
+        .await?;
+
+    let created_before = Utc::now().timestamp() - CONTAINER_GRACE.as_secs() as i64;
+    let mut removed = Vec::new();
+    let mut kept = Vec::new();
+    for container in &containers {
+        let Some(name) = container
+            .names
+            .as_ref()
+            .and_then(|names| names.first())
+            .map(|name| name.trim_start_matches('/'))
+        else {
+            continue;
+        };
+        if container.created.unwrap_or(i64::MAX) > created_before {
+            continue;
+        }
+        let Some(app) = owning_app(name, container) else {
+            continue;
+        };
+
+        let interrupted = name == format!("{app}-next") && !building.contains(&app);
+        if apps.contains(&app) && !interrupted {
+            continue;
+        }
+
+        // databases and volumes of deleted apps may still be wanted, like with a backup
+        if container.mounts.as_ref().is_some_and(|mounts| !mounts.is_empty()) {
+            kept.push(name.to_string());
+            continue;
+        }
+
+        docker
+            .remove_container(
+                name,
+                Some(RemoveContainerOptions {
+                    force: true,
+                    ..Default::default()
+                }),
+            )
+            .await?;
+        removed.push(name.to_string());
+    }
+
+    Ok((removed, kept))
+}
+
+/// The app a container of the platform belongs to. Workers carry its name in a label, every
+/// other container of an app is on the `{app}-network` and named after it. Containers of
+/// anything else, like the registry or the builder, have neither
+fn owning_app(name: &str, container: &ContainerSummary) -> Option<String> {
+    if let Some(app) = container.labels.as_ref().and_then(|labels| labels.get(WORKER_LABEL)) {
+        return Some(app.clone());
+    }
+
+    container
+        .network_settings
+        .as_ref()?
+        .networks
+        .as_ref()?
+        .keys()
+        .filter_map(|network| network.strip_suffix("-network"))
+        .find(|app| name == *app || name.starts_with(&format!("{app}-")))
+        .map(str::to_string)
+}
+
+/// Deploys the live release again for apps whose web container is gone, like after docker
+/// lost its containers. Stopped containers are left alone, idle and crash looping apps are
+/// stopped on purpose
+async fn restore_vanished(
+    building: &HashSet<String>,
+    build_channel: &Sender<BuildQueueItem>,
+    base: &str,
+    pool: &PgPool,
+) -> Result<Vec<String>> {
+    let apps = sqlx::query!(
+        r#"SELECT DISTINCT projects.id, project_owners.name AS owner, projects.name AS project,
+           domains.container_id AS "container_id!"
+           FROM projects
+           JOIN project_owners ON projects.owner_id = project_owners.id
+           JOIN domains ON domains.project_id = projects.id
+           WHERE domains.container_id IS NOT NULL
+           AND domains.deleted_at IS NULL
+           AND projects.suspended_at IS NULL
+           AND EXISTS(SELECT 1 FROM releases WHERE releases.project_id = projects.id)
+        "#
+    )
+    .fetch_all(pool)
+    .await?;
+
+    let driver = orchestrator::driver();
+    let mut restored = Vec::new();
+    for app in apps {
+        let repo = app.project.trim_end_matches(".git");
+        let container_name = format!("{}-{repo}", app.owner).replace('.', "-");
+        // a deploy swaps the container, the next pass sees what it left
+        if building.contains(&container_name) {
+            continue;
+        }
+
+        match driver.exists(&container_name, &app.container_id).await {
+            Ok(true) => continue,
+            Ok(false) => {}
+            Err(err) => {
+                tracing::error!(?err, app = container_name, "Can't check container: Failed to reach docker");
+                continue;
+            }
+        }
+
+        tracing::warn!(app = container_name, "Container of the live release is gone, deploying it again");
+        if let Err(err) = build_channel
+            .send(BuildQueueItem {
+                container_name: container_name.clone(),
+                container_src: format!("{base}/{}/{repo}.git/master", app.owner),
+                owner: app.owner.clone(),
+                repo: repo.to_string(),
+                kind: BuildKind::Reconfigure("Restore missing container".to_string()),
+                trace: current_context(),
+            })
+            .await
+        {
+            tracing::error!(?err, app = container_name, "Can't restore app: Failed to send to build queue");
+            continue;
+        }
+
+        let message = "The container of the live release was gone, it is deployed again";
+        if let Err(err) = record_activity(app.id, "reconcile", message, pool).await {
+            tracing::error!(?err, "Can't record activity: Failed to query database");
+        }
+        restored.push(format!("{}/{repo}", app.owner));
+    }
+
+    Ok(restored)
+}
@@ -0,0 +1,260 @@
+//! Redis addons. An app with one gets a redis of its own on its project network, like the
+//! postgres addon, with its data in a volume of its own and its url as REDIS_URL. The memory
+//! of the keys is capped with maxmemory, past it the least recently used keys are evicted,
+//! and the container is capped a little above it for the overhead of the server
+//!
+//! The stats come from INFO of the server, they are read when asked for and not kept
+
+use std::collections::HashMap;
+
+use anyhow::{anyhow, Result};
+use bollard::container::{
+    Config, CreateContainerOptions, LogOutput, RemoveContainerOptions, StartContainerOptions,
+};
+use bollard::exec::{CreateExecOptions, StartExecResults};
+use bollard::models::{HostConfig, RestartPolicy, RestartPolicyNameEnum};
+use bollard::volume::CreateVolumeOptions;
+use bollard::Docker;
+use futures::StreamExt;
+use rand::Rng;
+use serde::Serialize;
+use sqlx::PgPool;
+use tokio::time::Instant;
+use uuid::Uuid;
+
+use crate::configuration::ContainerSettings;
+use crate::docker::ensure_network;
+use crate::nodes;
+use crate::registry::pull_release_image;
+
+const CHARSET: &[u8] = b"ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789";
+
+/// in MiB. memory the container gets on top of maxmemory, for the server, clients and
+/// the buffers of a save
+const OVERHEAD: i64 = 32;
+
+const READY_TIMEOUT: std::time::Duration = std::time::Duration::from_secs(30);
+
+/// What the server says about itself
+#[derive(Serialize, Debug, Default)]
+pub struct RedisStats {
+    /// bytes the keys take
+    pub used_memory: i64,
+    /// bytes they may take before keys are evicted
+    pub max_memory: i64,
+    pub ops_per_sec: i64,
+    pub connected_clients: i64,
+    pub keys: i64,
+    /// keys dropped to stay under max_memory since the server started
+    pub evicted_keys: i64,
+    pub uptime_seconds: i64,
+}
+
+/// Starts a redis container for a project on its network holding at most `memory` MiB of
+/// keys. Returns the container name and the url for REDIS_URL
+#[tracing::instrument(skip(container_settings))]
+pub async fn provision_redis(
+    container_name: &str,
+    memory: i32,
+    container_settings: &ContainerSettings,
+) -> Result<(String, String)> {
+    let network_name = format!("{container_name}-network");
+    let redis_name = format!("{container_name}-redis");
+    let volume_name = format!("{container_name}-redis-volume");
+
+    let docker = nodes::docker(container_name)?;
+    ensure_network(&docker, &network_name, false).await?;
+
+    docker
+        .create_volume(CreateVolumeOptions {
+            name: volume_name.clone(),
+            ..Default::default()
+        })
+        .await?;
+
+    let mut rng = rand::thread_rng();
+    let password = (0..32)
+        .map(|_| CHARSET[rng.gen_range(0..CHARSET.len())] as char)
+        .collect::<String>();
+
+    pull_release_image(&docker, &container_settings.redisimage).await?;
+    let config = Config {
+        image: Some(container_settings.redisimage.clone()),
+        volumes: Some(HashMap::from([(format!("{volume_name}:/data"), HashMap::new())])),
+        // redis-cli in the container reads it, INFO doesn't need the password on the command
+        // line
+        env: Some(vec![format!("REDISCLI_AUTH={password}")]),
+        cmd: Some(vec![
+            "redis-server".to_string(),
+            "--requirepass".to_string(),
+            password.clone(),
+            "--maxmemory".to_string(),
+            format!("{memory}mb"),
+            "--maxmemory-policy".to_string(),
+            "allkeys-lru".to_string(),
+            "--appendonly".to_string(),
+            "yes".to_string(),
+        ]),
+        host_config: Some(HostConfig {
+            restart_policy: Some(RestartPolicy {
+                name: Some(RestartPolicyNameEnum::ON_FAILURE),
+                ..Default::default()
+            }),
+            memory: Some((memory as i64 + OVERHEAD) * 1024 * 1024),
+            network_mode: Some(network_name),
+            ..Default::default()
+        }),
+        ..Default::default()
+    };
+
+    docker
+        .create_container(
+            Some(CreateContainerOptions {
+                name: redis_name.clone(),
+                platform: None,
+            }),
+            config,
+        )
+        .await?;
+
+    if let Err(err) = docker
+        .start_container(&redis_name, None::<StartContainerOptions<&str>>)
+        .await
+    {
+        let _ = remove_redis(container_name).await;
+        return Err(err.into());
+    }
+
+    if let Err(err) = wait_for_redis(&docker, &redis_name).await {
+        let _ = remove_redis(container_name).await;
+        return Err(err);
+    }
+
+    Ok((redis_name.clone(), format!("redis://default:{password}@{redis_name}:6379/0")))
+}
+
+/// Polls PING inside the container until the server answers
+async fn wait_for_redis(docker: &Docker, redis_name: &str) -> Result<()> {
+    let deadline = Instant::now() + READY_TIMEOUT;
+
+    while Instant::now() < deadline {
+        if let Ok(output) = redis_cli(docker, redis_name, "PING").await {
+            if output.trim() == "PONG" {
+                return Ok(());
+            }
+        }
+        tokio::time::sleep(std::time::Duration::from_secs(1)).await;
+    }
+
+    Err(anyhow!(
+        "Redis did not become ready within {} seconds",
+        READY_TIMEOUT.as_secs()
+    ))
+}
+
+/// Removes the redis container of a project together with its data
+#[tracing::instrument]
+pub async fn remove_redis(container_name: &str) -> Result<()> {
+    let redis_name = format!("{container_name}-redis");
+    let volume_name = format!("{container_name}-redis-volume");
+
+    let docker = nodes::docker(container_name)?;
+
+    match docker
+        .remove_container(
+            &redis_name,
+            Some(RemoveContainerOptions {
+                force: true,
+                ..Default::default()
+            }),
+        )
+        .await
+    {
+        Ok(_) | Err(bollard::errors::Error::DockerResponseServerError { status_code: 404, .. }) => {}
+        Err(err) => return Err(err.into()),
+    }
+
+    match docker.remove_volume(&volume_name, None).await {
+        Ok(_) | Err(bollard::errors::Error::DockerResponseServerError { status_code: 404, .. }) => {}
+        Err(err) => return Err(err.into()),
+    }
+
+    Ok(())
+}
+
+/// REDIS_URL of the project's redis addon, none without one
+pub async fn redis_environment(project_id: Uuid, pool: &PgPool) -> Result<Vec<String>> {
+    let addon = sqlx::query!(
+        "SELECT url FROM addons WHERE project_id = $1 AND kind = 'redis'",
+        project_id
+    )
+    .fetch_optional(pool)
+    .await?;
+
+    Ok(addon.into_iter().map(|addon| format!("REDIS_URL={}", addon.url)).collect())
+}
+
+/// Memory, load and keys of the redis of a project, from INFO
+#[tracing::instrument]
+pub async fn redis_stats(container_name: &str) -> Result<RedisStats> {
+    let redis_name = format!("{container_name}-redis");
+    let docker = nodes::docker(container_name)?;
+    let info = redis_cli(&docker, &redis_name, "INFO").await?;
+
+    Ok(parse_info(&info))
+}
+
+/// `key:value` lines, sections start with `#`. The keys of every database are added up, they
+/// are listed like `db0:keys=12,expires=0,avg_ttl=0`
+fn parse_info(info: &str) -> RedisStats {
+    let mut stats = RedisStats::default();
+    for (key, value) in info.lines().filter_map(|line| line.trim().split_once(':')) {
+        let number = || value.parse::<i64>().unwrap_or_default();
+        match key {
+            "used_memory" => stats.used_memory = number(),
+            "maxmemory" => stats.max_memory = number(),
+            "instantaneous_ops_per_sec" => stats.ops_per_sec = number(),
+            "connected_clients" => stats.connected_clients = number(),
+            "evicted_keys" => stats.evicted_keys = number(),
+            "uptime_in_seconds" => stats.uptime_seconds = number(),
+            _ if key.starts_with("db") => {
+                stats.keys += value
+                    .split(',')
+                    .find_map(|field| field.strip_prefix("keys="))
+                    .and_then(|keys| keys.parse::<i64>().ok())
+                    .unwrap_or_default();
+            }
+            _ => {}
+        }
+    }
+    stats
+}
+
+/// Runs a redis-cli command in the redis container and returns what it printed
+async fn redis_cli(docker: &Docker, redis_name: &str, command: &str) -> Result<String> {
+    let exec = docker
+        .create_exec(
+            redis_name,
+            CreateExecOptions {
+                attach_stdout: Some(true),
+                attach_stderr: Some(true),
+                cmd: Some(vec!["redis-cli", command]),
+                ..Default::default()
+            },
+        )
+        .await?;
+
+    let mut output = String::new();
+    if let StartExecResults::Attached { output: mut stream, .. } = docker.start_exec(&exec.id, None).await? {
+        while let Some(msg) = stream.next().await {
+            if let LogOutput::StdOut { message } | LogOutput::StdErr { message } = msg? {
+                output.push_str(&String::from_utf8_lossy(&message));
+            }
+        }
+    }
+
+    match docker.inspect_exec(&exec.id).await?.exit_code {
+        Some(0) | None => Ok(output),
+        Some(code) => Err(anyhow!("redis-cli exited with {code}: {}", output.trim())),
+    }
+}
@@ -0,0 +1,331 @@
+use std::collections::{HashMap, HashSet};
+
+use anyhow::{anyhow, Result};
+use bollard::{
+    exec::{CreateExecOptions, StartExecResults},
+    image::{CreateImageOptions, ListImagesOptions, PruneImagesOptions, PushImageOptions, TagImageOptions},
+    Docker,
+};
+use chrono::Utc;
+use futures::StreamExt;
+use serde::Deserialize;
+use sqlx::PgPool;
+use uuid::Uuid;
+
+use crate::configuration::ContainerSettings;
+use crate::cron::next_run;
+use crate::docker::image_tag;
+use crate::nodes;
+
+/// Manifests the registry may store for a tag, it only answers with a digest for the kinds
+/// the request accepts
+const MANIFEST_TYPES: &str = "application/vnd.docker.distribution.manifest.v2+json, \
+    application/vnd.docker.distribution.manifest.list.v2+json, \
+    application/vnd.oci.image.manifest.v1+json, \
+    application/vnd.oci.image.index.v1+json";
+
+/// Pushes the release image tagged `{container_name}:{build_id}` to the registry as
+/// `{registry}/{container_name}:{build_id}` and returns that reference, so the release can be
+/// pulled again once the host lost it
+pub async fn push_release_image(registry: &str, container_name: &str, build_id: Uuid) -> Result<String> {
+    let tag = build_id.to_string();
+    push_image(registry, container_name, &format!("{container_name}:{tag}"), &tag).await
+}
+
+/// Pushes `image` to the registry as `{registry}/{container_name}:{tag}` and returns that
+/// reference. Tags that aren't build ids are kept by the image collector
+pub async fn push_image(registry: &str, container_name: &str, image: &str, tag: &str) -> Result<String> {
+    let docker = nodes::docker(container_name).map_err(|err| {
+        tracing::error!("Failed to connect to docker: {}", err);
+        err
+    })?;
+
+    let repo = format!("{registry}/{container_name}");
+
+    docker
+        .tag_image(
+            image,
+            Some(TagImageOptions {
+                repo: repo.clone(),
+                tag: tag.to_string(),
+            }),
+        )
+        .await?;
+
+    let mut push = docker.push_image(&repo, Some(PushImageOptions { tag }), None);
+    while let Some(info) = push.next().await {
+        if let Some(error) = info?.error {
+            return Err(anyhow!("Failed to push image: {error}"));
+        }
+    }
+
+    Ok(format!("{repo}:{tag}"))
+}
+
+/// Digest of the manifest `{repo}:{tag}` in the registry points at, it pins the tag to what it
+/// is now
+pub async fn image_digest(registry: &str, repo: &str, tag: &str) -> Result<String> {
+    let response = reqwest::Client::new()
+        .head(format!("http://{registry}/v2/{repo}/manifests/{tag}"))
+        .header("Accept", MANIFEST_TYPES)
+        .send()
+        .await?
+        .error_for_status()?;
+
+    let digest = response
+        .headers()
+        .get("Docker-Content-Digest")
+        .ok_or_else(|| anyhow!("Registry has no digest for {repo}:{tag}"))?;
+    Ok(digest.to_str()?.to_string())
+}
+
+/// Pulls the image of a release from the registry when the host doesn't have it anymore.
+/// Releases from before the registry only know the id of their image, those are gone for good
+pub async fn pull_release_image(docker: &Docker, image: &str) -> Result<()> {
+    if docker.inspect_image(image).await.is_ok() {
+        return Ok(());
+    }
+    if image.starts_with("sha256:") {
+        return Err(anyhow!("Image {image} of the release is gone from the host"));
+    }
+
+    let (name, tag) = image_tag(image);
+    let mut pull = docker.create_image(
+        Some(CreateImageOptions {
+            from_image: name,
+            tag,
+            ..Default::default()
+        }),
+        None,
+        None,
+    );
+    while let Some(info) = pull.next().await {
+        info.map_err(|err| anyhow!("Failed to pull image {image}: {err}"))?;
+    }
+
+    Ok(())
+}
+
+/// Prunes images nothing runs or rolls back to on the configured schedule: release tags of
+/// deleted apps and of releases beyond the history, on the host and in the registry, then
+/// the layers only they used
+pub async fn image_collector(pool: PgPool, container_settings: ContainerSettings) {
+    loop {
+        let next = match next_run(&container_settings.gcschedule, &Utc::now()) {
+            Ok(next) => next,
+            Err(err) => {
+                tracing::error!(?err, "Can't schedule image collection, images are kept");
+                return;
+            }
+        };
+
+        let wait = (next - Utc::now()).to_std().unwrap_or_default();
+        tokio::time::sleep(wait).await;
+
+        if let Err(err) = collect_images(&pool, &container_settings).await {
+            tracing::error!(?err, "Can't collect images");
+        }
+    }
+}
+
+async fn collect_images(pool: &PgPool, container_settings: &ContainerSettings) -> Result<()> {
+    // a build tags and pushes its release before the release row exists, collecting then
+    // would take the image from under it
+    let building = sqlx::query!(
+        r#"SELECT EXISTS(SELECT 1 FROM builds WHERE status = 'building') AS "building!""#
+    )
+    .fetch_one(pool)
+    .await?
+    .building;
+    if building {
+        tracing::info!("Builds are running, image collection waits for the next run");
+        return Ok(());
+    }
+
+    let live = sqlx::query!(
+        r#"SELECT releases.build_id, projects.name AS project, project_owners.name AS owner
+           FROM releases
+           JOIN projects ON projects.id = releases.project_id
+           JOIN project_owners ON projects.owner_id = project_owners.id
+        "#
+    )
+    .fetch_all(pool)
+    .await?
+    .into_iter()
+    .map(|release| {
+        let container_name = format!("{}-{}", release.owner, release.project).replace('.', "-");
+        (container_name, release.build_id.to_string())
+    })
+    .collect::<HashSet<_>>();
+
+    let docker = Docker::connect_with_local_defaults().map_err(|err| {
+        tracing::error!("Failed to connect to docker: {}", err);
+        err
+    })?;
+
+    let removed = collect_host_images(&docker, &live, container_settings.registry.as_deref()).await?;
+    let pruned = docker
+        .prune_images(Some(PruneImagesOptions {
+            filters: HashMap::from([("dangling", vec!["true"])]),
+        }))
+        .await?;
+    tracing::info!(
+        removed,
+        reclaimed = pruned.space_reclaimed.unwrap_or_default(),
+        "Collected images of the host"
+    );
+
+    if let Some(registry) = &container_settings.registry {
+        let deleted = collect_registry_images(registry, &live).await?;
+        garbage_collect_registry(&docker, &container_settings.registrycontainer).await?;
+        tracing::info!(deleted, "Collected images of the registry");
+    }
+
+    Ok(())
+}
+
+/// Removes release tags no release points at. Release tags are the ones named after a build,
+/// other images of the host are left alone
+async fn collect_host_images(
+    docker: &Docker,
+    live: &HashSet<(String, String)>,
+    registry: Option<&str>,
+) -> Result<usize> {
+    let prefix = registry.map(|registry| format!("{registry}/"));
+    let images = docker
+        .list_images(Some(ListImagesOptions::<String> {
+            all: false,
+            ..Default::default()
+        }))
+        .await?;
+
+    let mut removed = 0;
+    for reference in images.iter().flat_map(|image| &image.repo_tags) {
+        let Some((repo, tag)) = reference.rsplit_once(':') else {
+            continue;
+        };
+        if Uuid::parse_str(tag).is_err() {
+            continue;
+        }
+
+        let container_name = prefix
+            .as_deref()
+            .and_then(|prefix| repo.strip_prefix(prefix))
+            .unwrap_or(repo);
+        if live.contains(&(container_name.to_string(), tag.to_string())) {
+            continue;
+        }
+
+        // images a container still runs can't lose their last tag, the next run gets them
+        match docker.remove_image(reference, None, None).await {
+            Ok(_) => removed += 1,
+            Err(err) => tracing::debug!(?err, reference, "Can't collect image: Failed to remove image"),
+        }
+    }
+
+    Ok(removed)
+}
+
+#[derive(Deserialize)]
+struct Catalog {
+    #[serde(default)]
+    repositories: Vec<String>,
+}
+
+#[derive(Deserialize)]
+struct TagList {
+    /// null once every tag of the repository is deleted
+    tags: Option<Vec<String>>,
+}
+
+/// Deletes the manifests of release tags no release points at. The registry deletes by
+/// digest, which takes every tag of the manifest with it, and a rollback shares the image of
+/// the release it went back to, so digests any kept tag uses stay
+async fn collect_registry_images(registry: &str, live: &HashSet<(String, String)>) -> Result<usize> {
+    let client = reqwest::Client::new();
+    let base = format!("http://{registry}/v2");
+
+    let catalog: Catalog = client
+        .get(format!("{base}/_catalog?n=10000"))
+        .send()
+        .await?
+        .error_for_status()?
+        .json()
+        .await?;
+
+    let mut deleted = 0;
+    for repo in catalog.repositories {
+        let tags: TagList = client
+            .get(format!("{base}/{repo}/tags/list"))
+            .send()
+            .await?
+            .error_for_status()?
+            .json()
+            .await?;
+
+        let mut digests = vec![];
+        for tag in tags.tags.unwrap_or_default() {
+            let response = client
+                .head(format!("{base}/{repo}/manifests/{tag}"))
+                .header("Accept", MANIFEST_TYPES)
+                .send()
+                .await?
+                .error_for_status()?;
+            if let Some(digest) = response.headers().get("Docker-Content-Digest") {
+                digests.push((tag, digest.to_str()?.to_string()));
+            }
+        }
+
+        let kept = digests
+            .iter()
+            .filter(|(tag, _)| Uuid::parse_str(tag).is_err() || live.contains(&(repo.clone(), tag.clone())))
+            .map(|(_, digest)| digest.clone())
+            .collect::<HashSet<_>>();
+        let expired = digests
+            .into_iter()
+            .map(|(_, digest)| digest)
+            .filter(|digest| !kept.contains(digest))
+            .collect::<HashSet<_>>();
+
+        for digest in expired {
+            client
+                .delete(format!("{base}/{repo}/manifests/{digest}"))
+                .send()
+                .await?
+                .error_for_status()?;
+            deleted += 1;
+        }
+    }
+
+    Ok(deleted)
+}
+
+/// Deleting a manifest leaves its layers on disk, the garbage collector of the registry
+/// removes the ones no manifest references anymore
+async fn garbage_collect_registry(docker: &Docker, registry_container: &str) -> Result<()> {
+    let exec = docker
+        .create_exec(
+            registry_container,
+            CreateExecOptions {
+                attach_stdout: Some(true),
+                attach_stderr: Some(true),
+                cmd: Some(vec![
+                    "registry",
+                    "garbage-collect",
+                    "--delete-untagged",
+                    "/etc/docker/registry/config.yml",
+                ]),
+                ..Default::default()
+            },
+        )
+        .await?;
+
+    if let StartExecResults::Attached { mut output, .. } = docker.start_exec(&exec.id, None).await? {
+        while output.next().await.is_some() {}
+    }
+
+    match docker.inspect_exec(&exec.id).await?.exit_code {
+        Some(0) => Ok(()),
+        code => Err(anyhow!("Registry garbage collection exited with {code:?}")),
+    }
+}
@@ -0,0 +1,133 @@
+use std::collections::{BTreeMap, BTreeSet};
+
+use data_encoding::HEXLOWER;
+use serde::Serialize;
+use sha2::{Digest, Sha256};
+use sqlx::PgPool;
+use uuid::Uuid;
+
+use crate::docker::ReleaseConfig;
+use crate::queue::BuildKind;
+use crate::secrets::SecretCipher;
+
+/// parts of the config the platform wires up on every start, they differ between any two
+/// apps and say nothing about the release
+const PLUMBING: [&str; 4] = ["env", "secrets", "service", "private"];
+
+/// The sha256 of the config of a release. Two releases with the same digest start their
+/// containers the same way, jsonb keeps the keys sorted so the digest is stable
+pub fn config_digest(config: &serde_json::Value) -> String {
+    format!("sha256:{}", HEXLOWER.encode(&Sha256::digest(config.to_string().as_bytes())))
+}
+
+/// An environment variable that differs between two releases, None where it isn't set
+#[derive(Serialize, Debug)]
+pub struct EnvChange {
+    pub name: String,
+    pub from: Option<String>,
+    pub to: Option<String>,
+}
+
+/// A secret that differs, its values are never shown
+#[derive(Serialize, Debug)]
+pub struct SecretChange {
+    pub name: String,
+    /// added, removed or changed
+    pub change: &'static str,
+}
+
+/// Any other part of the config that differs, like the command or the limits
+#[derive(Serialize, Debug)]
+pub struct FieldChange {
+    pub field: String,
+    pub from: serde_json::Value,
+    pub to: serde_json::Value,
+}
+
+/// What changed from one release to another, see [`diff`]
+#[derive(Serialize, Debug, Default)]
+pub struct ConfigDiff {
+    pub env: Vec<EnvChange>,
+    pub secrets: Vec<SecretChange>,
+    pub config: Vec<FieldChange>,
+}
+
+fn env_map(env: &[String]) -> BTreeMap<&str, &str> {
+    env.iter()
+        .map(|var| var.split_once('=').unwrap_or((var.as_str(), "")))
+        .collect()
+}
+
+/// Compares the config of two releases. Secrets are decrypted to compare them, since the
+/// same value is encrypted differently every time it is set
+pub fn diff(from: &ReleaseConfig, to: &ReleaseConfig, secrets: &SecretCipher) -> ConfigDiff {
+    let mut changes = ConfigDiff::default();
+
+    let (from_env, to_env) = (env_map(&from.env), env_map(&to.env));
+    let names = from_env.keys().chain(to_env.keys()).collect::<BTreeSet<_>>();
+    for name in names {
+        let (before, after) = (from_env.get(name), to_env.get(name));
+        if before != after {
+            changes.env.push(EnvChange {
+                name: name.to_string(),
+                from: before.map(|value| value.to_string()),
+                to: after.map(|value| value.to_string()),
+            });
+        }
+    }
+
+    let names = from.secrets.keys().chain(to.secrets.keys()).collect::<BTreeSet<_>>();
+    for name in names {
+        let change = match (from.secrets.get(name), to.secrets.get(name)) {
+            (None, Some(_)) => "added",
+            (Some(_), None) => "removed",
+            (Some(before), Some(after)) if before != after => {
+                match (secrets.decrypt(before), secrets.decrypt(after)) {
+                    (Ok(before), Ok(after)) if before == after => continue,
+                    _ => "changed",
+                }
+            }
+            _ => continue,
+        };
+        changes.secrets.push(SecretChange { name: name.to_string(), change });
+    }
+
+    let (from_fields, to_fields) = match (serde_json::to_value(from), serde_json::to_value(to)) {
+        (Ok(serde_json::Value::Object(before)), Ok(serde_json::Value::Object(after))) => (before, after),
+        _ => return changes,
+    };
+    let fields = from_fields
+        .keys()
+        .chain(to_fields.keys())
+        .filter(|field| !PLUMBING.contains(&field.as_str()))
+        .collect::<BTreeSet<_>>();
+    for field in fields {
+        let before = from_fields.get(field).cloned().unwrap_or_default();
+        let after = to_fields.get(field).cloned().unwrap_or_default();
+        if before != after {
+            changes.config.push(FieldChange { field: field.to_string(), from: before, to: after });
+        }
+    }
+
+    changes
+}
+
+/// Why a deploy of `kind` is refused while the app is pinned to a release, None when it may
+/// go ahead. A rollback to the pinned release itself, environment changes and previews go
+/// through, everything else would replace the release
+pub async fn pin_refusal(project_id: Uuid, kind: &BuildKind, pool: &PgPool) -> Result<Option<String>, sqlx::Error> {
+    if let BuildKind::Reconfigure(_) | BuildKind::Preview(_) = kind {
+        return Ok(None);
+    }
+
+    let project = sqlx::query!("SELECT pinned_release_id FROM projects WHERE id = $1", project_id)
+        .fetch_one(pool)
+        .await?;
+
+    Ok(match project.pinned_release_id {
+        Some(pinned) if !matches!(kind, BuildKind::Rollback(release_id) if *release_id == pinned) => Some(format!(
+            "The app is pinned to release {pinned}, unpin it with pmk releases unpin to deploy again"
+        )),
+        _ => None,
+    })
+}
@@ -0,0 +1,135 @@
+use std::sync::{Arc, OnceLock};
+use std::time::Duration;
+
+use futures::stream;
+use hyper::body::HttpBody;
+use hyper::header::{HeaderMap, CONTENT_LENGTH};
+use hyper::{Body, Request, Response, StatusCode};
+use tokio::time::Instant;
+
+use crate::configuration::ContainerSettings;
+
+const MIB: u64 = 1024 * 1024;
+
+/// How much a client may send to an app and how slowly, None doesn't limit
+#[derive(Debug, Clone, Copy, Default, PartialEq)]
+pub struct RequestLimits {
+    /// bytes of a request body
+    pub body: Option<u64>,
+    /// from when the proxy forwards the request until the client sent the whole body
+    pub body_timeout: Option<Duration>,
+}
+
+impl RequestLimits {
+    /// The limits set on an app in MiB and seconds, never above the ones of the platform. The
+    /// platform doesn't limit where the configuration says 0
+    pub fn new(body: Option<i32>, body_timeout: Option<i32>, container_settings: &ContainerSettings) -> Self {
+        let clamp = |value: Option<i32>, platform: u32| {
+            let value = value.map(|value| value.max(1) as u64);
+            match (value, platform as u64) {
+                (Some(value), 0) => Some(value),
+                (Some(value), platform) => Some(value.min(platform)),
+                (None, 0) => None,
+                (None, platform) => Some(platform),
+            }
+        };
+
+        Self {
+            body: clamp(body, container_settings.bodylimit).map(|mib| mib * MIB),
+            body_timeout: clamp(body_timeout, container_settings.bodytimeout).map(Duration::from_secs),
+        }
+    }
+
+    /// A 413 for a request saying its body is bigger than the app takes, before any of it is
+    /// read. Bodies without a length are counted as they come, see [`limit_body`]
+    pub fn check_length(&self, headers: &HeaderMap) -> Result<(), Response<Body>> {
+        let length = headers
+            .get(CONTENT_LENGTH)
+            .and_then(|length| length.to_str().ok())
+            .and_then(|length| length.parse::<u64>().ok());
+        match (length, self.body) {
+            (Some(length), Some(limit)) if length > limit => Err(too_large(limit)),
+            _ => Ok(()),
+        }
+    }
+}
+
+/// Which limit a body broke, known once the request to the app failed because of it
+#[derive(Debug, Clone, Default)]
+pub struct Breach(Arc<OnceLock<StatusCode>>);
+
+impl Breach {
+    /// The answer for the client instead of the error of the app, None when no limit was broken
+    pub fn response(&self, limits: &RequestLimits) -> Option<Response<Body>> {
+        match self.0.get().copied()? {
+            StatusCode::PAYLOAD_TOO_LARGE => Some(too_large(limits.body.unwrap_or_default())),
+            _ => Some(
+                Response::builder()
+                    .status(StatusCode::REQUEST_TIMEOUT)
+                    .header("Content-Type", "text/plain; charset=utf-8")
+                    .body(Body::from("The request body took too long to arrive"))
+                    .unwrap(),
+            ),
+        }
+    }
+}
+
+/// Has the body of `req` end in an error once it goes over the limits, which fails the
+/// request to the app. `stream` leaves out the timeout for requests that are streams for as
+/// long as the call goes on, like grpc. Requests without a body are left alone, an upgrade
+/// or a GET would be sent on chunked otherwise
+pub fn limit_body(req: &mut Request<Body>, limits: &RequestLimits, stream: bool) -> Breach {
+    let breach = Breach::default();
+    let timeout = limits.body_timeout.filter(|_| !stream);
+    if req.body().is_end_stream() || (limits.body.is_none() && timeout.is_none()) {
+        return breach;
+    }
+
+    let limit = limits.body;
+    let deadline = timeout.map(|timeout| Instant::now() + timeout);
+    let body = std::mem::take(req.body_mut());
+    let broken = breach.clone();
+    let chunks = stream::unfold(Some((body, 0u64)), move |state| {
+        let broken = broken.clone();
+        async move {
+            let (mut body, read) = state?;
+            let chunk = match deadline {
+                Some(deadline) => match tokio::time::timeout_at(deadline, body.data()).await {
+                    Ok(chunk) => chunk,
+                    Err(_) => {
+                        let _ = broken.0.set(StatusCode::REQUEST_TIMEOUT);
+                        return Some((Err::<_, BodyError>("request body took too long".into()), None));
+                    }
+                },
+                None => body.data().await,
+            };
+
+            match chunk? {
+                Ok(bytes) => {
+                    let read = read + bytes.len() as u64;
+                    if limit.is_some_and(|limit| read > limit) {
+                        let _ = broken.0.set(StatusCode::PAYLOAD_TOO_LARGE);
+                        return Some((Err("request body too large".into()), None));
+                    }
+                    Some((Ok(bytes), Some((body, read))))
+                }
+                Err(err) => Some((Err(err.into()), None)),
+            }
+        }
+    });
+    *req.body_mut() = Body::wrap_stream(chunks);
+    breach
+}
+
+type BodyError = Box<dyn std::error::Error + Send + Sync>;
+
+fn too_large(limit: u64) -> Response<Body> {
+    Response::builder()
+        .status(StatusCode::PAYLOAD_TOO_LARGE)
+        .header("Content-Type", "text/plain; charset=utf-8")
+        .body(Body::from(format!(
+            "The request body is bigger than the {} MiB this app takes",
+            limit / MIB
+        )))
+        .unwrap()
+}
@@ -0,0 +1,153 @@
+use anyhow::Result;
+use bollard::container::UpdateContainerOptions;
+use bollard::service::{RestartPolicy, RestartPolicyNameEnum};
+use serde::{Deserialize, Serialize};
+use sqlx::PgPool;
+use uuid::Uuid;
+
+use crate::docker::project_containers;
+use crate::nodes;
+
+/// When docker starts a container of an app again after it exits. Stored with the release
+/// like its limits, so every container of it restarts the same way
+#[derive(Serialize, Deserialize, Debug, Clone, PartialEq)]
+pub struct Restarts {
+    /// `always`, `on-failure` or `never`
+    pub policy: String,
+    /// restarts docker tries before it gives up on a container, only for `on-failure`. None
+    /// keeps trying
+    pub max_retries: Option<i32>,
+}
+
+pub const POLICIES: [&str; 3] = ["always", "on-failure", "never"];
+
+impl Default for Restarts {
+    fn default() -> Self {
+        Self::new("on-failure", None)
+    }
+}
+
+impl Restarts {
+    pub fn new(policy: &str, max_retries: Option<i32>) -> Self {
+        Self {
+            policy: policy.to_string(),
+            max_retries: max_retries.filter(|_| policy == "on-failure"),
+        }
+    }
+
+    /// The policy docker is given
+    pub fn docker(&self) -> RestartPolicy {
+        let name = match self.policy.as_str() {
+            "always" => RestartPolicyNameEnum::ALWAYS,
+            "never" => RestartPolicyNameEnum::NO,
+            _ => RestartPolicyNameEnum::ON_FAILURE,
+        };
+        RestartPolicy {
+            name: Some(name),
+            maximum_retry_count: self.max_retries.map(i64::from),
+        }
+    }
+
+    /// Whether docker keeps restarting a crashing container, only then a crash loop is backed
+    /// off. Apps that restart never or a few times are left to docker
+    pub fn endless(&self) -> bool {
+        self.policy != "never" && self.max_retries.is_none()
+    }
+}
+
+pub async fn project_restarts(project_id: Uuid, pool: &PgPool) -> Result<Restarts> {
+    let project = sqlx::query!(
+        "SELECT restart_policy, restart_retries FROM projects WHERE id = $1",
+        project_id
+    )
+    .fetch_one(pool)
+    .await?;
+
+    Ok(Restarts::new(&project.restart_policy, project.restart_retries))
+}
+
+/// Puts the restart policy of an app on its releases and its running containers. A crash
+/// looping app keeps docker from restarting it, it gets the policy when the loop ends
+#[tracing::instrument(skip(pool))]
+pub async fn apply_restarts(project_id: Uuid, pool: &PgPool) -> Result<()> {
+    let restarts = project_restarts(project_id, pool).await?;
+
+    let project = sqlx::query!(
+        r#"SELECT projects.name AS project, project_owners.name AS owner,
+           projects.crash_looping_at IS NOT NULL AS "crash_looping!"
+           FROM projects
+           JOIN project_owners ON projects.owner_id = project_owners.id
+           WHERE projects.id = $1
+        "#,
+        project_id
+    )
+    .fetch_one(pool)
+    .await?;
+
+    sqlx::query!(
+        "UPDATE releases SET config = jsonb_set(config, '{restarts}', $2) WHERE project_id = $1",
+        project_id,
+        serde_json::to_value(&restarts)?
+    )
+    .execute(pool)
+    .await?;
+
+    if project.crash_looping {
+        return Ok(());
+    }
+
+    let container_name = format!("{}-{}", project.owner, project.project).replace('.', "-");
+    let docker = nodes::docker(&container_name)?;
+    for container in project_containers(&container_name).await? {
+        let update = UpdateContainerOptions::<String> {
+            restart_policy: Some(restarts.docker()),
+            ..Default::default()
+        };
+        if let Err(err) = docker.update_container(&container.id, update).await {
+            tracing::warn!(?err, container = %container.name, "Can't apply restart policy: Failed to update container");
+        }
+    }
+
+    Ok(())
+}
+
+/// Name of the signal that ended a process exiting with `exit_code`, shells exit with 128
+/// and the number of the signal
+pub fn exit_signal(exit_code: i32) -> Option<&'static str> {
+    let signal = match exit_code.checked_sub(128)? {
+        1 => "SIGHUP",
+        2 => "SIGINT",
+        3 => "SIGQUIT",
+        4 => "SIGILL",
+        5 => "SIGTRAP",
+        6 => "SIGABRT",
+        7 => "SIGBUS",
+        8 => "SIGFPE",
+        9 => "SIGKILL",
+        11 => "SIGSEGV",
+        13 => "SIGPIPE",
+        14 => "SIGALRM",
+        15 => "SIGTERM",
+        _ => return None,
+    };
+    Some(signal)
+}
+
+/// Keeps the last exit of a container of the app on its current release, for the release
+/// history
+pub async fn record_exit(project_id: Uuid, exit_code: i32, oom_killed: bool, pool: &PgPool) -> Result<()> {
+    sqlx::query!(
+        r#"UPDATE releases
+           SET exit_code = $2, exit_signal = $3, oom_killed = $4, exited_at = now()
+           WHERE id = (SELECT id FROM releases WHERE project_id = $1 ORDER BY created_at DESC LIMIT 1)
+        "#,
+        project_id,
+        exit_code,
+        exit_signal(exit_code),
+        oom_killed
+    )
+    .execute(pool)
+    .await?;
+
+    Ok(())
+}
@@ -0,0 +1,89 @@
+//! Container runtimes a host runs apps with, picked per host: `container.runtime` for the host
+//! of the platform, `AGENT_RUNTIME` for a node. They all speak the docker api, only on other
+//! sockets. Rootless docker runs the daemon and every container as an unprivileged user, so a
+//! container escape lands in an account that owns nothing but the containers. Podman runs
+//! containers without a daemon of its own
+//!
+//! Whatever the runtime, app containers keep only a few capabilities, see [`harden`]
+
+use anyhow::{anyhow, Result};
+use bollard::service::HostConfig;
+use serde::{Deserialize, Serialize};
+
+use crate::configuration::ContainerSettings;
+
+#[derive(Serialize, Deserialize, Debug, Clone, Copy, PartialEq, Eq, Default)]
+#[serde(rename_all = "lowercase")]
+pub enum Runtime {
+    #[default]
+    Docker,
+    Rootless,
+    Podman,
+}
+
+impl Runtime {
+    pub fn as_str(&self) -> &'static str {
+        match self {
+            Runtime::Docker => "docker",
+            Runtime::Rootless => "rootless",
+            Runtime::Podman => "podman",
+        }
+    }
+
+    /// Where the runtime listens when nothing else is set
+    fn default_socket(&self) -> Result<String> {
+        match self {
+            Runtime::Docker => Ok("/var/run/docker.sock".to_string()),
+            // dockerd-rootless.sh puts it in the runtime directory of its user
+            Runtime::Rootless => std::env::var("XDG_RUNTIME_DIR")
+                .map(|dir| format!("{dir}/docker.sock"))
+                .map_err(|_| anyhow!("XDG_RUNTIME_DIR is not set, set the socket of rootless docker")),
+            // podman.socket of systemd, the rootless one has to be set
+            Runtime::Podman => Ok("/run/podman/podman.sock".to_string()),
+        }
+    }
+}
+
+/// Path of the socket of `runtime`, `socket` when it is set. It may be a `unix://` url like
+/// the ones of DOCKER_HOST
+pub fn socket_path(runtime: Runtime, socket: Option<&str>) -> Result<String> {
+    match socket {
+        Some(socket) => Ok(socket.trim_start_matches("unix://").to_string()),
+        None => runtime.default_socket(),
+    }
+}
+
+/// Points everything on the host of the platform at its runtime, bollard, the docker cli of
+/// builds and nixpacks all read DOCKER_HOST. Builds need docker with buildkit, so podman only
+/// runs apps on nodes
+pub async fn use_runtime(container_settings: &ContainerSettings) -> Result<()> {
+    if container_settings.runtime == Runtime::Podman {
+        return Err(anyhow!("Builds need docker, run podman on nodes instead"));
+    }
+    // one set by hand wins over the default socket
+    if container_settings.socket.is_none() && std::env::var_os("DOCKER_HOST").is_some() {
+        return Ok(());
+    }
+
+    let path = socket_path(container_settings.runtime, container_settings.socket.as_deref())?;
+    tokio::fs::metadata(&path)
+        .await
+        .map_err(|err| anyhow!("Failed to access {} socket {path}: {err}", container_settings.runtime.as_str()))?;
+
+    std::env::set_var("DOCKER_HOST", format!("unix://{path}"));
+    Ok(())
+}
+
+/// Drops every capability of an app container but `container.capabilities` and keeps setuid
+/// binaries from gaining privileges, so root in the container can do less to the host if it
+/// gets out
+pub fn harden(container_settings: &ContainerSettings, host_config: HostConfig) -> HostConfig {
+    HostConfig {
+        cap_drop: Some(vec!["ALL".to_string()]),
+        cap_add: Some(container_settings.capabilities.clone()),
+        security_opt: container_settings
+            .nonewprivileges
+            .then(|| vec!["no-new-privileges".to_string()]),
+        ..host_config
+    }
+}
@@ -0,0 +1,342 @@
+//! Vulnerability scans of images with trivy. The image of every build and of every
+//! `pmk deploy --image` is scanned before anything of it runs, the findings are kept with the
+//! build and every release of the image shows them, rollbacks too. An app can block deploys of images with
+//! vulnerabilities of a severity or worse, others only get them in the build log
+//!
+//! Scanning is off until `build.trivy` points at the trivy binary
+
+use std::fmt;
+use std::sync::RwLock;
+
+use anyhow::{anyhow, bail, Result};
+use chrono::{DateTime, Utc};
+use lazy_static::lazy_static;
+use serde::{Deserialize, Serialize};
+use sqlx::PgPool;
+use tokio::process::Command;
+use uuid::Uuid;
+
+use crate::configuration::BuilderSettings;
+use crate::docker::RegistryCredentials;
+
+/// vulnerabilities of high or worse listed in the build log, the rest are only counted
+const MAX_LOGGED: usize = 10;
+/// vulnerabilities kept for a build, an old base image can have thousands
+const MAX_KEPT: usize = 1000;
+
+lazy_static! {
+    static ref TRIVY: RwLock<Option<Trivy>> = RwLock::new(None);
+}
+
+#[derive(Debug, Clone)]
+struct Trivy {
+    path: String,
+    /// in seconds
+    timeout: u64,
+}
+
+/// Set with `pmk scan-policy`, an image with a vulnerability of it or worse isn't deployed
+#[derive(Serialize, Deserialize, Debug, Clone, Copy, PartialEq, Eq, PartialOrd, Ord)]
+#[serde(rename_all = "lowercase")]
+pub enum Severity {
+    Unknown,
+    Low,
+    Medium,
+    High,
+    Critical,
+}
+
+/// Severities an app can block on, the worst first
+pub const BLOCKABLE: [&str; 4] = ["critical", "high", "medium", "low"];
+
+impl Severity {
+    pub fn parse(severity: &str) -> Option<Self> {
+        match severity.to_lowercase().as_str() {
+            "critical" => Some(Severity::Critical),
+            "high" => Some(Severity::High),
+            "medium" => Some(Severity::Medium),
+            "low" => Some(Severity::Low),
+            "unknown" => Some(Severity::Unknown),
+            _ => None,
+        }
+    }
+}
+
+impl fmt::Display for Severity {
+    fn fmt(&self, f: &mut fmt::Formatter) -> fmt::Result {
+        match self {
+            Severity::Unknown => write!(f, "unknown"),
+            Severity::Low => write!(f, "low"),
+            Severity::Medium => write!(f, "medium"),
+            Severity::High => write!(f, "high"),
+            Severity::Critical => write!(f, "critical"),
+        }
+    }
+}
+
+/// A vulnerability of a package in an image
+#[derive(Serialize, Deserialize, Debug, Clone)]
+pub struct Finding {
+    /// like CVE-2024-3094
+    pub id: String,
+    pub package: String,
+    pub installed: String,
+    /// None while there is no fixed version
+    pub fixed: Option<String>,
+    pub severity: Severity,
+    pub title: Option<String>,
+}
+
+/// Vulnerabilities of each severity in an image
+#[derive(Serialize, Debug, Clone, Copy, Default)]
+pub struct Counts {
+    pub critical: i32,
+    pub high: i32,
+    pub medium: i32,
+    pub low: i32,
+    pub unknown: i32,
+}
+
+impl Counts {
+    fn of(findings: &[Finding]) -> Self {
+        let count = |severity| findings.iter().filter(|finding| finding.severity == severity).count() as i32;
+        Counts {
+            critical: count(Severity::Critical),
+            high: count(Severity::High),
+            medium: count(Severity::Medium),
+            low: count(Severity::Low),
+            unknown: count(Severity::Unknown),
+        }
+    }
+
+    pub fn total(&self) -> i32 {
+        self.critical + self.high + self.medium + self.low + self.unknown
+    }
+}
+
+/// What the scan of the image of a build found
+#[derive(Serialize, Debug)]
+pub struct Scan {
+    #[serde(flatten)]
+    pub counts: Counts,
+    pub findings: Vec<Finding>,
+    /// why the scan didn't finish
+    pub error: Option<String>,
+    pub scanned_at: DateTime<Utc>,
+}
+
+/// Where trivy reads the image from
+pub enum Source<'a> {
+    /// built on the host of the platform, read from its docker
+    Local(&'a str),
+    /// pulled from a registry, trivy pulls it again with the login of the app
+    Remote(&'a str, Option<&'a RegistryCredentials>),
+}
+
+#[derive(Deserialize)]
+#[serde(rename_all = "PascalCase")]
+struct Report {
+    results: Option<Vec<ReportResult>>,
+}
+
+#[derive(Deserialize)]
+#[serde(rename_all = "PascalCase")]
+struct ReportResult {
+    vulnerabilities: Option<Vec<Vulnerability>>,
+}
+
+#[derive(Deserialize)]
+#[serde(rename_all = "PascalCase")]
+struct Vulnerability {
+    #[serde(rename = "VulnerabilityID")]
+    vulnerability_id: String,
+    pkg_name: String,
+    installed_version: String,
+    fixed_version: Option<String>,
+    severity: String,
+    title: Option<String>,
+}
+
+/// Turns scanning on when `build.trivy` is set. Called once before any build runs
+pub fn init(settings: &BuilderSettings) {
+    *TRIVY.write().unwrap() = settings.trivy.clone().map(|path| Trivy {
+        path,
+        timeout: settings.scantimeout,
+    });
+}
+
+/// Scans the image of a build and keeps what it found. Returns the lines for the build log,
+/// or an error with them when the policy of the app blocks the image. An app that blocks
+/// doesn't deploy an image that couldn't be scanned either
+pub async fn check_image(project_id: Uuid, build_id: Uuid, source: Source<'_>, pool: &PgPool) -> Result<String> {
+    let Some(trivy) = TRIVY.read().unwrap().clone() else {
+        return Ok(String::new());
+    };
+
+    let block = sqlx::query!("SELECT block_severity FROM projects WHERE id = $1", project_id)
+        .fetch_one(pool)
+        .await?
+        .block_severity
+        .and_then(|severity| Severity::parse(&severity));
+
+    let (findings, error) = match scan(&trivy, source).await {
+        Ok(findings) => (findings, None),
+        Err(err) => (Vec::new(), Some(err.to_string())),
+    };
+    let counts = Counts::of(&findings);
+
+    sqlx::query!(
+        r#"INSERT INTO image_scans (build_id, critical, high, medium, low, unknown, findings, error)
+           VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+           ON CONFLICT (build_id) DO UPDATE
+           SET critical = $2, high = $3, medium = $4, low = $5, unknown = $6, findings = $7,
+               error = $8, created_at = now()
+        "#,
+        build_id,
+        counts.critical,
+        counts.high,
+        counts.medium,
+        counts.low,
+        counts.unknown,
+        serde_json::to_value(&findings[..findings.len().min(MAX_KEPT)])?,
+        error,
+    )
+    .execute(pool)
+    .await?;
+
+    if let Some(error) = error {
+        let log = format!("Can't scan image: {error}\n");
+        if let Some(block) = block {
+            bail!("{log}Deploy blocked: the app blocks {block} vulnerabilities and the image couldn't be scanned");
+        }
+        return Ok(log);
+    }
+
+    let mut log = format!(
+        "Scanned image: {} critical, {} high, {} medium, {} low and {} unknown vulnerabilities\n",
+        counts.critical, counts.high, counts.medium, counts.low, counts.unknown
+    );
+    for finding in findings
+        .iter()
+        .filter(|finding| finding.severity >= Severity::High)
+        .take(MAX_LOGGED)
+    {
+        let fixed = match &finding.fixed {
+            Some(fixed) => format!("fixed in {fixed}"),
+            None => "no fix yet".to_string(),
+        };
+        log.push_str(&format!(
+            "  {} {} in {} {}, {fixed}\n",
+            finding.id, finding.severity, finding.package, finding.installed
+        ));
+    }
+
+    if let Some(block) = block {
+        let blocking = findings.iter().filter(|finding| finding.severity >= block).count();
+        if blocking > 0 {
+            bail!("{log}Deploy blocked: {blocking} vulnerabilities are {block} or worse, update the affected packages or change pmk scan-policy");
+        }
+    }
+
+    Ok(log)
+}
+
+/// The scan of the image of a build, None when it wasn't scanned
+pub async fn build_scan(build_id: Uuid, pool: &PgPool) -> Result<Option<Scan>, sqlx::Error> {
+    let scan = sqlx::query!(
+        r#"SELECT critical, high, medium, low, unknown, findings, error, created_at
+           FROM image_scans
+           WHERE build_id = $1
+        "#,
+        build_id
+    )
+    .fetch_optional(pool)
+    .await?;
+
+    Ok(scan.map(|scan| Scan {
+        counts: Counts {
+            critical: scan.critical,
+            high: scan.high,
+            medium: scan.medium,
+            low: scan.low,
+            unknown: scan.unknown,
+        },
+        findings: serde_json::from_value(scan.findings).unwrap_or_default(),
+        error: scan.error,
+        scanned_at: scan.created_at,
+    }))
+}
+
+/// The scan of the image a release runs, the newest one of the builds that released it. A
+/// rollback or an environment change starts the image of an earlier build
+pub async fn release_scan(image: &str, project_id: Uuid, pool: &PgPool) -> Result<Option<Scan>, sqlx::Error> {
+    let build = sqlx::query!(
+        r#"SELECT image_scans.build_id
+           FROM image_scans
+           JOIN releases ON releases.build_id = image_scans.build_id
+           WHERE releases.image = $1 AND releases.project_id = $2
+           ORDER BY image_scans.created_at DESC
+           LIMIT 1
+        "#,
+        image,
+        project_id
+    )
+    .fetch_optional(pool)
+    .await?;
+
+    match build {
+        Some(build) => build_scan(build.build_id, pool).await,
+        None => Ok(None),
+    }
+}
+
+/// Runs trivy on the image, the findings come the most severe first
+async fn scan(trivy: &Trivy, source: Source<'_>) -> Result<Vec<Finding>> {
+    let mut cmd = Command::new(&trivy.path);
+    cmd.args([
+        "image",
+        "--quiet",
+        "--format",
+        "json",
+        "--scanners",
+        "vuln",
+        "--timeout",
+        &format!("{}s", trivy.timeout),
+    ]);
+    match source {
+        // trivy reads DOCKER_HOST like the builds, see crate::runtime
+        Source::Local(image) => cmd.args(["--image-src", "docker", image]),
+        Source::Remote(image, credentials) => {
+            if let Some(credentials) = credentials {
+                cmd.env("TRIVY_USERNAME", &credentials.username)
+                    .env("TRIVY_PASSWORD", &credentials.password);
+            }
+            cmd.args(["--image-src", "remote", image])
+        }
+    };
+    let output = cmd.kill_on_drop(true).output().await?;
+
+    if !output.status.success() {
+        let stderr = String::from_utf8_lossy(&output.stderr);
+        return Err(anyhow!("trivy failed: {}", stderr.trim()));
+    }
+
+    let report = serde_json::from_slice::<Report>(&output.stdout)?;
+    let mut findings = report
+        .results
+        .into_iter()
+        .flatten()
+        .flat_map(|result| result.vulnerabilities.into_iter().flatten())
+        .map(|vulnerability| Finding {
+            id: vulnerability.vulnerability_id,
+            package: vulnerability.pkg_name,
+            installed: vulnerability.installed_version,
+            fixed: vulnerability.fixed_version.filter(|fixed| !fixed.is_empty()),
+            severity: Severity::parse(&vulnerability.severity).unwrap_or(Severity::Unknown),
+            title: vulnerability.title,
+        })
+        .collect::<Vec<_>>();
+    findings.sort_by(|a, b| b.severity.cmp(&a.severity));
+
+    Ok(findings)
+}
@@ -0,0 +1,92 @@
+use std::sync::Arc;
+
+use aes_gcm::{
+    aead::{Aead, KeyInit},
+    Aes256Gcm, Nonce,
+};
+use anyhow::Result;
+use data_encoding::BASE64;
+use rand::RngCore;
+use secrecy::{ExposeSecret, Secret};
+
+const NONCE_LEN: usize = 12;
+
+/// Encrypts project secrets before they are stored. Values are only decrypted when a
+/// container is started with them, they are never sent back to the api.
+#[derive(Clone)]
+pub struct SecretCipher {
+    cipher: Option<Arc<Aes256Gcm>>,
+}
+
+impl std::fmt::Debug for SecretCipher {
+    fn fmt(&self, f: &mut std::fmt::Formatter<'_>) -> std::fmt::Result {
+        f.debug_struct("SecretCipher")
+            .field("enabled", &self.enabled())
+            .finish()
+    }
+}
+
+impl SecretCipher {
+    /// `key` is 32 bytes encoded as base64, for example from `openssl rand -base64 32`.
+    /// Without a key secrets can't be set, plain environment variables still work
+    pub fn new(key: Option<&Secret<String>>) -> Result<Self> {
+        let cipher = match key {
+            Some(key) => {
+                let key = BASE64
+                    .decode(key.expose_secret().trim().as_bytes())
+                    .map_err(|err| anyhow::anyhow!("Secret key is not valid base64: {err}"))?;
+
+                let cipher = Aes256Gcm::new_from_slice(&key)
+                    .map_err(|_| anyhow::anyhow!("Secret key has to be 32 bytes long"))?;
+
+                Some(Arc::new(cipher))
+            }
+            None => None,
+        };
+
+        Ok(Self { cipher })
+    }
+
+    pub fn enabled(&self) -> bool {
+        self.cipher.is_some()
+    }
+
+    /// Returns base64 of the random nonce followed by the ciphertext
+    pub fn encrypt(&self, value: &str) -> Result<String> {
+        let cipher = self
+            .cipher
+            .as_ref()
+            .ok_or(anyhow::anyhow!("No secret key configured"))?;
+
+        let mut nonce = [0u8; NONCE_LEN];
+        rand::thread_rng().fill_bytes(&mut nonce);
+
+        let ciphertext = cipher
+            .encrypt(Nonce::from_slice(&nonce), value.as_bytes())
+            .map_err(|_| anyhow::anyhow!("Failed to encrypt secret"))?;
+
+        Ok(BASE64.encode(&[nonce.as_slice(), ciphertext.as_slice()].concat()))
+    }
+
+    pub fn decrypt(&self, value: &str) -> Result<String> {
+        let cipher = self
+            .cipher
+            .as_ref()
+            .ok_or(anyhow::anyhow!("No secret key configured"))?;
+
+        let bytes = BASE64
+            .decode(value.as_bytes())
+            .map_err(|err| anyhow::anyhow!("Secret is not valid base64: {err}"))?;
+
+        if bytes.len() < NONCE_LEN {
+            return Err(anyhow::anyhow!("Secret is too short"));
+        }
+        let (nonce, ciphertext) = bytes.split_at(NONCE_LEN);
+
+        let plaintext = cipher
+            .decrypt(Nonce::from_slice(nonce), ciphertext)
+            .map_err(|_| anyhow::anyhow!("Failed to decrypt secret, was the secret key changed?"))?;
+
+        Ok(String::from_utf8(plaintext)?)
+    }
+}
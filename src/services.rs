@@ -0,0 +1,170 @@
+use std::collections::BTreeMap;
+use std::path::Path;
+
+use anyhow::{anyhow, Result};
+use sqlx::PgPool;
+use ulid::Ulid;
+use uuid::Uuid;
+
+use crate::docker::ServiceNetwork;
+use crate::manifest::Service;
+
+/// A service project to build for a push to its app
+#[derive(Debug, Clone)]
+pub struct ServiceBuild {
+    pub project_id: Uuid,
+    pub repo: String,
+    pub container_name: String,
+    pub container_src: String,
+}
+
+/// Brings the service projects of an app in line with the services its pemasak.toml
+/// declares. Every service is a project of the same owner named `{app}-{service}`, built
+/// from its directory of the checkout of the app, which it reads through a link in place of
+/// a checkout of its own. Services that are no longer declared are left alone, deleting a
+/// project deletes its data. Returns the builds to queue
+pub async fn sync_services(
+    app_id: Uuid,
+    owner: &str,
+    repo: &str,
+    container_src: &str,
+    services: &BTreeMap<String, Service>,
+    pool: &PgPool,
+) -> Result<Vec<ServiceBuild>> {
+    let app = sqlx::query!("SELECT owner_id FROM projects WHERE id = $1", app_id)
+        .fetch_one(pool)
+        .await?;
+
+    // checkouts are at {base}/{owner}/{repo}.git/master
+    let checkout = std::fs::canonicalize(container_src)?;
+    let owner_dir = Path::new(container_src)
+        .parent()
+        .and_then(|repo_dir| repo_dir.parent())
+        .ok_or_else(|| anyhow!("No repository directory above {container_src}"))?;
+
+    let mut builds = vec![];
+    for (service, Service { source, internal }) in services {
+        let name = format!("{repo}-{service}");
+
+        let existing = sqlx::query!(
+            "SELECT id, parent_id, service FROM projects WHERE owner_id = $1 AND name = $2",
+            app.owner_id,
+            name
+        )
+        .fetch_optional(pool)
+        .await?;
+
+        let source_dir = source.trim_end_matches('/');
+        let project_id = match existing {
+            Some(project) if project.parent_id == Some(app_id) && project.service.as_deref() == Some(service) => {
+                sqlx::query!(
+                    "UPDATE projects SET source_dir = $1, internal = $2, updated_at = now() WHERE id = $3",
+                    source_dir,
+                    internal,
+                    project.id
+                )
+                .execute(pool)
+                .await?;
+                project.id
+            }
+            Some(_) => {
+                return Err(anyhow!(
+                    "Service {service} needs the project {name}, which already exists. Rename the service or delete the project"
+                ));
+            }
+            None => {
+                let id = Uuid::from(Ulid::new());
+                sqlx::query!(
+                    r#"INSERT INTO projects (id, name, owner_id, parent_id, service, source_dir, internal)
+                       VALUES ($1, $2, $3, $4, $5, $6, $7)
+                    "#,
+                    id,
+                    name,
+                    app.owner_id,
+                    app_id,
+                    service,
+                    source_dir,
+                    internal
+                )
+                .execute(pool)
+                .await?;
+                id
+            }
+        };
+
+        let service_dir = owner_dir.join(format!("{name}.git"));
+        let service_src = service_dir.join("master");
+        std::fs::create_dir_all(&service_dir)?;
+        if service_src.symlink_metadata().is_err() {
+            std::os::unix::fs::symlink(&checkout, &service_src)?;
+        }
+
+        builds.push(ServiceBuild {
+            project_id,
+            container_name: format!("{owner}-{name}").replace('.', "-"),
+            container_src: service_src.display().to_string(),
+            repo: name,
+        });
+    }
+
+    Ok(builds)
+}
+
+/// The network a service shares with the other services of its app, and a `{SERVICE}_URL`
+/// variable with the internal address of each of them, like `API_URL=http://api:80`.
+/// Projects that aren't services get neither
+pub async fn service_network(
+    project_id: Uuid,
+    port: i32,
+    pool: &PgPool,
+) -> Result<(Option<ServiceNetwork>, Vec<String>)> {
+    let Some(project) = sqlx::query!(
+        r#"SELECT projects.parent_id AS "parent_id!", projects.service AS "service!",
+           parents.name AS parent, project_owners.name AS owner
+           FROM projects
+           JOIN projects parents ON parents.id = projects.parent_id
+           JOIN project_owners ON projects.owner_id = project_owners.id
+           WHERE projects.id = $1 AND projects.service IS NOT NULL
+        "#,
+        project_id
+    )
+    .fetch_optional(pool)
+    .await?
+    else {
+        return Ok((None, vec![]));
+    };
+
+    let services = sqlx::query!(
+        r#"SELECT service AS "service!" FROM projects
+           WHERE parent_id = $1 AND service IS NOT NULL
+           ORDER BY service
+        "#,
+        project.parent_id
+    )
+    .fetch_all(pool)
+    .await?;
+
+    let env = services
+        .iter()
+        .map(|sibling| {
+            let var = sibling.service.to_uppercase().replace('-', "_");
+            format!("{var}_URL=http://{}:{port}", sibling.service)
+        })
+        .collect();
+
+    let network = ServiceNetwork {
+        network: format!("{}-{}-services", project.owner, project.parent).replace('.', "-"),
+        alias: project.service,
+    };
+    Ok((Some(network), env))
+}
+
+/// The private network of every app of an owner, where the web containers of an app answer
+/// to `{app}.internal`. Requests on it don't go through the proxy, so apps that are internal
+/// only are reached there too
+pub fn private_network(owner: &str, project: &str) -> ServiceNetwork {
+    ServiceNetwork {
+        network: format!("{owner}-private").replace('.', "-"),
+        alias: format!("{}.internal", project.trim_end_matches(".git")),
+    }
+}
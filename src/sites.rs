@@ -0,0 +1,388 @@
+//! Static sites, apps whose build only makes files. The directory `[site]` of pemasak.toml
+//! points at is copied out of the image once it is built and the proxy serves it from disk,
+//! no container of the app runs. Every image gets its own directory, so a rollback switches
+//! back without copying anything again
+//!
+//! Sites are kept under `build.sitesdir`
+
+use std::io::Cursor;
+use std::path::{Component, Path, PathBuf};
+use std::sync::RwLock;
+use std::time::UNIX_EPOCH;
+
+use anyhow::{anyhow, Result};
+use bollard::container::{
+    Config, CreateContainerOptions, DownloadFromContainerOptions, RemoveContainerOptions, StopContainerOptions,
+};
+use bollard::Docker;
+use futures::StreamExt;
+use hyper::header::{CACHE_CONTROL, CONTENT_LENGTH, CONTENT_TYPE, ETAG, IF_NONE_MATCH};
+use hyper::{Body, Method, Request, Response, StatusCode};
+use lazy_static::lazy_static;
+use serde::{Deserialize, Serialize};
+
+use crate::configuration::{BuilderSettings, ContainerSettings};
+use crate::nodes;
+use crate::orchestrator;
+use crate::registry::pull_release_image;
+
+/// what a site can take on disk, like a build output with every image of a gallery
+const MAX_SITE_SIZE: u64 = 512 * 1024 * 1024;
+
+/// directories of earlier images kept per app for rollbacks, besides the live one
+const KEPT_IMAGES: usize = 5;
+
+lazy_static! {
+    static ref SITES_DIR: RwLock<PathBuf> = RwLock::new(PathBuf::from("./sites"));
+}
+
+/// The `[site]` of pemasak.toml, kept with every release
+#[derive(Serialize, Deserialize, Debug, Clone, PartialEq, Eq)]
+#[serde(deny_unknown_fields)]
+pub struct StaticSite {
+    /// directory of the build output in the image, like /app/dist
+    pub dir: String,
+    /// paths that aren't a file get index.html, for apps that route in the browser
+    #[serde(default)]
+    pub spa: bool,
+}
+
+/// Where the proxy serves a site from, read with the upstream of the app
+#[derive(Debug, Clone)]
+pub struct SiteRoot {
+    pub root: PathBuf,
+    pub spa: bool,
+}
+
+/// Sets where sites are kept. Called once before any build runs
+pub fn init(settings: &BuilderSettings) {
+    *SITES_DIR.write().unwrap() = PathBuf::from(&settings.sitesdir);
+}
+
+fn app_dir(container_name: &str) -> PathBuf {
+    SITES_DIR.read().unwrap().join(container_name)
+}
+
+/// Copies the site out of `image` for the app and returns the directory the proxy serves it
+/// from. An image that was published before, like the one of a rollback, is only looked up
+pub async fn publish(container_name: &str, image: &str, site: &StaticSite) -> Result<String> {
+    // images are built on the host of the platform, a site never goes to a node
+    let docker = Docker::connect_with_local_defaults()?;
+
+    // sites are kept by image id. A release with a registry names its image by the pushed
+    // `{registry}/{app}:{build_id}`, which is looked up, pulled first when the host lost it
+    let id = match image.strip_prefix("sha256:") {
+        Some(id) => id.to_string(),
+        None => {
+            pull_release_image(&docker, image).await?;
+            let id = docker
+                .inspect_image(image)
+                .await?
+                .id
+                .ok_or_else(|| anyhow!("No image id found for {image}"))?;
+            id.trim_start_matches("sha256:").to_string()
+        }
+    };
+    let root = app_dir(container_name).join(&id[..id.len().min(12)]);
+    if root.is_dir() {
+        return Ok(root.display().to_string());
+    }
+
+    let name = format!("{container_name}-site");
+    let _ = docker
+        .remove_container(&name, Some(RemoveContainerOptions { force: true, ..Default::default() }))
+        .await;
+    // created but never started, docker hands out the files of a container either way
+    docker
+        .create_container(
+            Some(CreateContainerOptions { name: name.as_str(), platform: None }),
+            Config {
+                image: Some(image.to_string()),
+                network_disabled: Some(true),
+                ..Default::default()
+            },
+        )
+        .await?;
+
+    let mut archive = Vec::new();
+    let mut chunks = docker.download_from_container(&name, Some(DownloadFromContainerOptions { path: site.dir.as_str() }));
+    let read = async {
+        while let Some(chunk) = chunks.next().await {
+            archive.extend_from_slice(&chunk?);
+            if archive.len() as u64 > MAX_SITE_SIZE {
+                return Err(anyhow!("The site in {} is bigger than {} MiB", site.dir, MAX_SITE_SIZE / 1024 / 1024));
+            }
+        }
+        Ok(())
+    }
+    .await;
+    drop(chunks);
+    let _ = docker.remove_container(&name, None).await;
+    read.map_err(|err| match err.downcast_ref::<bollard::errors::Error>() {
+        Some(bollard::errors::Error::DockerResponseServerError { status_code: 404, .. }) => {
+            anyhow!("The image has no {}, point [site] dir at where the build writes the site", site.dir)
+        }
+        _ => err,
+    })?;
+
+    // unpacked next to the root and moved in one rename, the proxy never sees half a site
+    let partial = root.with_extension("partial");
+    let _ = tokio::fs::remove_dir_all(&partial).await;
+    let unpacked = {
+        let partial = partial.clone();
+        tokio::task::spawn_blocking(move || unpack(&archive, &partial)).await?
+    };
+    if let Err(err) = unpacked {
+        let _ = tokio::fs::remove_dir_all(&partial).await;
+        return Err(err);
+    }
+    tokio::fs::rename(&partial, &root).await?;
+
+    if let Err(err) = prune(container_name, &root).await {
+        tracing::error!(?err, container_name, "Can't prune site: Failed to remove directory");
+    }
+
+    Ok(root.display().to_string())
+}
+
+/// Docker archives a directory as one entry named after it, its content is the site. Only
+/// files and directories are unpacked, a link could serve files outside the site
+fn unpack(archive: &[u8], dest: &Path) -> Result<()> {
+    std::fs::create_dir_all(dest)?;
+    let mut archive = tar::Archive::new(Cursor::new(archive));
+
+    for entry in archive.entries()? {
+        let mut entry = entry?;
+        let path = entry.path()?.into_owned();
+        let path = path.components().skip(1).collect::<PathBuf>();
+        if path.as_os_str().is_empty() {
+            continue;
+        }
+
+        match entry.header().entry_type() {
+            tar::EntryType::Regular | tar::EntryType::Continuous | tar::EntryType::Directory => {}
+            // a symlink of the build output, like a node_modules bin, isn't served
+            _ => continue,
+        }
+        if !inside(&path) {
+            return Err(anyhow!("{} is outside the site", path.display()));
+        }
+
+        let target = dest.join(&path);
+        if let Some(parent) = target.parent() {
+            std::fs::create_dir_all(parent)?;
+        }
+        entry.unpack(&target)?;
+    }
+
+    Ok(())
+}
+
+/// Whether a relative path stays inside the directory it is joined to
+fn inside(path: &Path) -> bool {
+    path.components().all(|component| matches!(component, Component::Normal(_)))
+}
+
+/// Removes the directories of all but the newest images and the live one
+async fn prune(container_name: &str, live: &Path) -> Result<()> {
+    let mut published = Vec::new();
+    let mut dirs = tokio::fs::read_dir(app_dir(container_name)).await?;
+    while let Some(dir) = dirs.next_entry().await? {
+        let path = dir.path();
+        if path == live || path.extension().is_some() {
+            continue;
+        }
+        let modified = dir.metadata().await?.modified()?;
+        published.push((modified, path));
+    }
+
+    published.sort_by(|a, b| b.0.cmp(&a.0));
+    for (_, path) in published.into_iter().skip(KEPT_IMAGES) {
+        tokio::fs::remove_dir_all(path).await?;
+    }
+
+    Ok(())
+}
+
+/// Removes the web and worker containers an app ran before it became a static site
+pub async fn retire(container_name: &str, container_settings: &ContainerSettings) -> Result<()> {
+    orchestrator::driver().remove(container_name).await?;
+
+    let docker = nodes::docker(container_name)?;
+    let stopped = docker
+        .stop_container(container_name, Some(StopContainerOptions { t: container_settings.stoptimeout }))
+        .await;
+    match stopped {
+        // stopped already, or the app never ran a container
+        Ok(_) | Err(bollard::errors::Error::DockerResponseServerError { status_code: 304 | 404, .. }) => {}
+        Err(err) => return Err(err.into()),
+    }
+    match docker.remove_container(container_name, None).await {
+        Ok(_) | Err(bollard::errors::Error::DockerResponseServerError { status_code: 404, .. }) => Ok(()),
+        Err(err) => Err(err.into()),
+    }
+}
+
+/// Removes every site of a deleted app
+pub async fn remove(container_name: &str) -> Result<()> {
+    match tokio::fs::remove_dir_all(app_dir(container_name)).await {
+        Err(err) if err.kind() != std::io::ErrorKind::NotFound => Err(err.into()),
+        _ => Ok(()),
+    }
+}
+
+/// Answers a request for a site from its directory. `/about` is `/about/index.html` or
+/// `/about.html`, what isn't a file is the index.html of a single page app or the 404.html
+/// of the site
+pub async fn serve<B>(site: &SiteRoot, req: &Request<B>) -> Response<Body> {
+    if req.method() != Method::GET && req.method() != Method::HEAD {
+        return Response::builder()
+            .status(StatusCode::METHOD_NOT_ALLOWED)
+            .header("Allow", "GET, HEAD")
+            .body(Body::empty())
+            .unwrap();
+    }
+
+    let decoded = percent_decode(req.uri().path());
+    let path = Path::new(decoded.trim_start_matches('/'));
+    if !inside(path) && !path.as_os_str().is_empty() {
+        return not_found(site, req).await;
+    }
+
+    let file = site.root.join(path);
+    let mut candidates = vec![file.clone(), file.join("index.html")];
+    if path.extension().is_none() && !path.as_os_str().is_empty() {
+        candidates.push(file.with_extension("html"));
+    }
+    for candidate in &candidates {
+        if candidate.is_file() {
+            return file_response(candidate, StatusCode::OK, req).await;
+        }
+    }
+
+    // a path with an extension is a missing asset, a browser route has none
+    let route = path.extension().is_none();
+    if site.spa && route {
+        return file_response(&site.root.join("index.html"), StatusCode::OK, req).await;
+    }
+    not_found(site, req).await
+}
+
+async fn not_found<B>(site: &SiteRoot, req: &Request<B>) -> Response<Body> {
+    let page = site.root.join("404.html");
+    if page.is_file() {
+        return file_response(&page, StatusCode::NOT_FOUND, req).await;
+    }
+
+    Response::builder()
+        .status(StatusCode::NOT_FOUND)
+        .header(CONTENT_TYPE, "text/plain; charset=utf-8")
+        .body(Body::from("Not found"))
+        .unwrap()
+}
+
+async fn file_response<B>(path: &Path, status: StatusCode, req: &Request<B>) -> Response<Body> {
+    let metadata = match tokio::fs::metadata(path).await {
+        Ok(metadata) => metadata,
+        Err(err) => {
+            tracing::error!(?err, path = %path.display(), "Can't serve site: Failed to read file");
+            return Response::builder()
+                .status(StatusCode::NOT_FOUND)
+                .body(Body::empty())
+                .unwrap();
+        }
+    };
+    let modified = metadata
+        .modified()
+        .ok()
+        .and_then(|modified| modified.duration_since(UNIX_EPOCH).ok())
+        .map(|modified| modified.as_secs())
+        .unwrap_or_default();
+    let etag = format!("\"{:x}-{:x}\"", metadata.len(), modified);
+    let content_type = content_type(path);
+    // pages point at the assets of the release, they are asked for again on every visit
+    let cache_control = match content_type.starts_with("text/html") {
+        true => "no-cache",
+        false => "public, max-age=300",
+    };
+
+    let builder = Response::builder()
+        .header(CONTENT_TYPE, content_type)
+        .header(ETAG, &etag)
+        .header(CACHE_CONTROL, cache_control);
+
+    let fresh = req
+        .headers()
+        .get(IF_NONE_MATCH)
+        .and_then(|tags| tags.to_str().ok())
+        .is_some_and(|tags| tags.split(',').any(|tag| tag.trim() == etag || tag.trim() == "*"));
+    if fresh && status == StatusCode::OK {
+        return builder.status(StatusCode::NOT_MODIFIED).body(Body::empty()).unwrap();
+    }
+
+    let builder = builder.status(status).header(CONTENT_LENGTH, metadata.len());
+    if req.method() == Method::HEAD {
+        return builder.body(Body::empty()).unwrap();
+    }
+    match tokio::fs::read(path).await {
+        Ok(content) => builder.body(Body::from(content)).unwrap(),
+        Err(err) => {
+            tracing::error!(?err, path = %path.display(), "Can't serve site: Failed to read file");
+            Response::builder()
+                .status(StatusCode::INTERNAL_SERVER_ERROR)
+                .body(Body::empty())
+                .unwrap()
+        }
+    }
+}
+
+fn content_type(path: &Path) -> &'static str {
+    let extension = path.extension().and_then(|extension| extension.to_str()).unwrap_or_default();
+    match extension.to_ascii_lowercase().as_str() {
+        "html" | "htm" => "text/html; charset=utf-8",
+        "css" => "text/css; charset=utf-8",
+        "js" | "mjs" => "application/javascript; charset=utf-8",
+        "json" | "map" => "application/json",
+        "webmanifest" => "application/manifest+json",
+        "txt" => "text/plain; charset=utf-8",
+        "xml" => "application/xml",
+        "svg" => "image/svg+xml",
+        "png" => "image/png",
+        "jpg" | "jpeg" => "image/jpeg",
+        "gif" => "image/gif",
+        "webp" => "image/webp",
+        "avif" => "image/avif",
+        "ico" => "image/x-icon",
+        "woff" => "font/woff",
+        "woff2" => "font/woff2",
+        "ttf" => "font/ttf",
+        "otf" => "font/otf",
+        "wasm" => "application/wasm",
+        "pdf" => "application/pdf",
+        "mp4" => "video/mp4",
+        "webm" => "video/webm",
+        "mp3" => "audio/mpeg",
+        _ => "application/octet-stream",
+    }
+}
+
+/// `%20` and the like in a path, invalid escapes are kept as they are
+pub(crate) fn percent_decode(path: &str) -> String {
+    let bytes = path.as_bytes();
+    let mut decoded = Vec::with_capacity(bytes.len());
+    let mut i = 0;
+    while i < bytes.len() {
+        let hex = bytes.get(i + 1..i + 3).and_then(|hex| std::str::from_utf8(hex).ok());
+        match (bytes[i], hex.and_then(|hex| u8::from_str_radix(hex, 16).ok())) {
+            (b'%', Some(byte)) => {
+                decoded.push(byte);
+                i += 3;
+            }
+            (byte, _) => {
+                decoded.push(byte);
+                i += 1;
+            }
+        }
+    }
+    String::from_utf8_lossy(&decoded).into_owned()
+}
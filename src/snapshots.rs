@@ -0,0 +1,439 @@
+//! Snapshots of the whole platform, so a host that dies takes nothing with it but the time to
+//! set up another. A snapshot goes to the backup bucket under `platform/<id>/` and holds
+//!
+//! - `database.dump`, pg_dump of the platform database: users, apps, their environments,
+//!   domains and the metadata of builds and releases
+//! - `git.tar.gz`, the repos under `git.base`
+//! - `volumes/<name>.tar.gz`, every app volume on the host and the volumes of the registry, so
+//!   live releases start again without building
+//! - `manifest.json`, written last. A snapshot without one didn't finish
+//!
+//! Everything is read from the bucket, restoring on a new host needs nothing but the
+//! configuration. The secret key in it isn't in the snapshot, project secrets can't be read
+//! without it. Databases of addons have their own backups, see [`crate::backups`], and apps on
+//! nodes keep their volumes on the node
+//!
+//! `pemasak-backup` takes and restores them, the platform takes one on
+//! `backup.platformschedule`
+
+use std::collections::{BTreeMap, HashMap};
+use std::process::Stdio;
+
+use anyhow::{anyhow, Result};
+use bollard::service::MountPointTypeEnum;
+use bollard::volume::{CreateVolumeOptions, ListVolumesOptions};
+use bollard::Docker;
+use chrono::{DateTime, Utc};
+use serde::{Deserialize, Serialize};
+use tokio::process::Command;
+use ulid::Ulid;
+
+use crate::backups::BackupStorage;
+use crate::configuration::Settings;
+use crate::cron::next_run;
+use crate::volumes::VOLUME_LABEL;
+
+const PREFIX: &str = "platform/";
+/// tars the volumes, any image with tar does
+const HELPER_IMAGE: &str = "alpine:3.18";
+
+#[derive(Serialize, Deserialize, Debug, Clone, Copy, PartialEq)]
+#[serde(rename_all = "lowercase")]
+pub enum PartKind {
+    Database,
+    Git,
+    Volume,
+}
+
+#[derive(Serialize, Deserialize, Debug, Clone)]
+pub struct Part {
+    pub kind: PartKind,
+    /// object of the part in the bucket
+    pub key: String,
+    /// docker volume a volume part is restored into, with the labels it had
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub volume: Option<String>,
+    #[serde(default, skip_serializing_if = "HashMap::is_empty")]
+    pub labels: HashMap<String, String>,
+}
+
+#[derive(Serialize, Deserialize, Debug, Clone)]
+pub struct Manifest {
+    pub id: String,
+    pub created_at: DateTime<Utc>,
+    pub parts: Vec<Part>,
+    /// what couldn't be captured, the rest of the snapshot still is
+    pub errors: Vec<String>,
+}
+
+/// A snapshot as it is in the bucket
+#[derive(Serialize, Debug)]
+pub struct Snapshot {
+    pub id: String,
+    /// bytes of all its parts
+    pub size: u64,
+    /// None for a snapshot that didn't finish
+    pub manifest: Option<Manifest>,
+}
+
+fn key(id: &str, part: &str) -> String {
+    format!("{PREFIX}{id}/{part}")
+}
+
+/// Streams what `command` writes to stdout into `key`, failing when it exits with anything but 0
+async fn upload_output(storage: &BackupStorage, key: &str, mut command: Command) -> Result<()> {
+    let mut child = command
+        .stdin(Stdio::null())
+        .stdout(Stdio::piped())
+        .stderr(Stdio::piped())
+        .kill_on_drop(true)
+        .spawn()?;
+
+    let mut stdout = child.stdout.take().ok_or(anyhow!("Command has no output"))?;
+    let upload = storage.bucket()?.put_object_stream(&mut stdout, key).await;
+    // a failed upload leaves the command blocked on a full pipe otherwise
+    drop(stdout);
+
+    let output = child.wait_with_output().await?;
+    if !output.status.success() {
+        return Err(anyhow!("{}", String::from_utf8_lossy(&output.stderr).trim()));
+    }
+    upload?;
+    Ok(())
+}
+
+/// Streams `key` into the stdin of `command`, failing when it exits with anything but 0
+async fn download_input(storage: &BackupStorage, key: &str, mut command: Command) -> Result<()> {
+    let mut child = command
+        .stdin(Stdio::piped())
+        .stdout(Stdio::null())
+        .stderr(Stdio::piped())
+        .kill_on_drop(true)
+        .spawn()?;
+
+    let mut stdin = child.stdin.take().ok_or(anyhow!("Command has no input"))?;
+    let download = storage.bucket()?.get_object_to_writer(key, &mut stdin).await;
+    // the command only finishes once its input ends
+    drop(stdin);
+
+    let output = child.wait_with_output().await?;
+    download?;
+    if !output.status.success() {
+        return Err(anyhow!("{}", String::from_utf8_lossy(&output.stderr).trim()));
+    }
+    Ok(())
+}
+
+/// pg_dump and pg_restore run in a container of `backup.pgimage` on the network of the host,
+/// the host of the platform needs no postgres tools of the right version
+fn postgres_command(settings: &Settings, tool: &str, args: &[&str]) -> Command {
+    let database = &settings.database;
+    let mut command = Command::new("docker");
+    command
+        .args(["run", "--rm", "-i", "--network", "host", "--env", "PGPASSWORD"])
+        .arg(&settings.backup.pgimage)
+        .arg(tool)
+        .args(["--host", &database.host, "--port", &database.port.to_string()])
+        .args(["--username", &database.user, "--dbname", &database.name])
+        .args(args)
+        .env("PGPASSWORD", &database.password);
+    command
+}
+
+fn volume_command(volume: &str, read_only: bool, args: &[&str]) -> Command {
+    let mode = if read_only { ":ro" } else { "" };
+    let mut command = Command::new("docker");
+    command
+        .args(["run", "--rm", "-i", "--network", "none", "--volume"])
+        .arg(format!("{volume}:/volume{mode}"))
+        .arg(HELPER_IMAGE)
+        .args(args);
+    command
+}
+
+/// The volumes a snapshot holds with their labels: the app volumes on the host and, with
+/// `images`, the ones of the registry
+async fn host_volumes(
+    docker: &Docker,
+    settings: &Settings,
+    images: bool,
+) -> Result<BTreeMap<String, HashMap<String, String>>> {
+    let mut volumes: BTreeMap<String, HashMap<String, String>> = docker
+        .list_volumes(Some(ListVolumesOptions {
+            filters: HashMap::from([("label".to_string(), vec![VOLUME_LABEL.to_string()])]),
+        }))
+        .await?
+        .volumes
+        .unwrap_or_default()
+        .into_iter()
+        .map(|volume| (volume.name, volume.labels))
+        .collect();
+
+    if images {
+        let registry = docker
+            .inspect_container(&settings.container.registrycontainer, None)
+            .await?;
+        for mount in registry.mounts.unwrap_or_default() {
+            if let (Some(MountPointTypeEnum::VOLUME), Some(name)) = (mount.typ, mount.name) {
+                volumes.entry(name).or_default();
+            }
+        }
+    }
+
+    Ok(volumes)
+}
+
+/// Takes a snapshot. Parts that fail are listed in the manifest and the rest is still taken,
+/// a snapshot missing one volume beats none at all
+#[tracing::instrument(skip(settings, storage))]
+pub async fn create_snapshot(settings: &Settings, storage: &BackupStorage, images: bool) -> Result<Manifest> {
+    storage.bucket()?;
+    let id = Ulid::new().to_string().to_lowercase();
+    let created_at = Utc::now();
+    let mut parts = Vec::new();
+    let mut errors = Vec::new();
+
+    tracing::info!(id, "Taking platform snapshot");
+
+    let database_key = key(&id, "database.dump");
+    let dump = postgres_command(settings, "pg_dump", &["--format=custom"]);
+    match upload_output(storage, &database_key, dump).await {
+        Ok(()) => parts.push(Part {
+            kind: PartKind::Database,
+            key: database_key,
+            volume: None,
+            labels: HashMap::new(),
+        }),
+        // everything else means little without the database
+        Err(err) => return Err(anyhow!("Can't dump the platform database: {err}")),
+    }
+
+    let git_key = key(&id, "git.tar.gz");
+    let mut tar = Command::new("tar");
+    tar.args([
+        "--create",
+        "--gzip",
+        "--file",
+        "-",
+        "--directory",
+        &settings.git.base,
+        ".",
+    ]);
+    match upload_output(storage, &git_key, tar).await {
+        Ok(()) => parts.push(Part {
+            kind: PartKind::Git,
+            key: git_key,
+            volume: None,
+            labels: HashMap::new(),
+        }),
+        Err(err) => {
+            tracing::error!(?err, "Can't snapshot git repos");
+            errors.push(format!("git repos: {err}"));
+        }
+    }
+
+    let docker = Docker::connect_with_local_defaults()?;
+    let volumes = match host_volumes(&docker, settings, images).await {
+        Ok(volumes) => volumes,
+        Err(err) => {
+            tracing::error!(?err, "Can't list volumes");
+            errors.push(format!("volumes: {err}"));
+            BTreeMap::new()
+        }
+    };
+    for (volume, labels) in volumes {
+        let volume_key = key(&id, &format!("volumes/{volume}.tar.gz"));
+        let tar = volume_command(&volume, true, &["tar", "-czf", "-", "-C", "/volume", "."]);
+        match upload_output(storage, &volume_key, tar).await {
+            Ok(()) => parts.push(Part {
+                kind: PartKind::Volume,
+                key: volume_key,
+                volume: Some(volume),
+                labels,
+            }),
+            Err(err) => {
+                tracing::error!(?err, volume, "Can't snapshot volume");
+                errors.push(format!("volume {volume}: {err}"));
+            }
+        }
+    }
+
+    let manifest = Manifest {
+        id: id.clone(),
+        created_at,
+        parts,
+        errors,
+    };
+    storage
+        .bucket()?
+        .put_object_with_content_type(
+            key(&id, "manifest.json"),
+            &serde_json::to_vec_pretty(&manifest)?,
+            "application/json",
+        )
+        .await?;
+
+    tracing::info!(id, errors = manifest.errors.len(), "Took platform snapshot");
+    Ok(manifest)
+}
+
+/// Every snapshot in the bucket, the newest first
+pub async fn list_snapshots(storage: &BackupStorage) -> Result<Vec<Snapshot>> {
+    let bucket = storage.bucket()?;
+    let mut sizes: BTreeMap<String, u64> = BTreeMap::new();
+    let mut finished = Vec::new();
+    for page in bucket.list(PREFIX.to_string(), None).await? {
+        for object in page.contents {
+            let Some((id, part)) = object.key.trim_start_matches(PREFIX).split_once('/') else {
+                continue;
+            };
+            *sizes.entry(id.to_string()).or_default() += object.size;
+            if part == "manifest.json" {
+                finished.push(object.key.clone());
+            }
+        }
+    }
+
+    let mut manifests = HashMap::new();
+    for key in finished {
+        let manifest: Manifest = serde_json::from_slice(bucket.get_object(&key).await?.bytes())?;
+        manifests.insert(manifest.id.clone(), manifest);
+    }
+
+    // ulids sort by the time they were made
+    Ok(sizes
+        .into_iter()
+        .rev()
+        .map(|(id, size)| Snapshot {
+            manifest: manifests.remove(&id),
+            id,
+            size,
+        })
+        .collect())
+}
+
+/// Deletes every object of a snapshot
+pub async fn delete_snapshot(storage: &BackupStorage, id: &str) -> Result<()> {
+    let bucket = storage.bucket()?;
+    for page in bucket.list(key(id, ""), None).await? {
+        for object in page.contents {
+            bucket.delete_object(&object.key).await?;
+        }
+    }
+    Ok(())
+}
+
+/// Deletes finished snapshots past `retention` and unfinished ones older than the newest
+/// finished one, those will never finish. Returns the ids it deleted
+pub async fn prune_snapshots(storage: &BackupStorage, retention: usize) -> Result<Vec<String>> {
+    let snapshots = list_snapshots(storage).await?;
+    let newest = snapshots
+        .iter()
+        .find(|snapshot| snapshot.manifest.is_some())
+        .map(|snapshot| snapshot.id.clone());
+
+    let mut kept = 0;
+    let mut deleted = Vec::new();
+    for snapshot in snapshots {
+        let expired = match snapshot.manifest {
+            Some(_) => {
+                kept += 1;
+                kept > retention
+            }
+            None => newest.as_ref().is_some_and(|newest| snapshot.id < *newest),
+        };
+        if expired {
+            delete_snapshot(storage, &snapshot.id).await?;
+            deleted.push(snapshot.id);
+        }
+    }
+    Ok(deleted)
+}
+
+/// Restores a snapshot onto this host: the database is replaced, the repos are unpacked into
+/// `git.base` and the volumes are created again and filled. The platform has to be stopped,
+/// once it starts the reconciler deploys the live releases, see [`crate::reconciler`]
+#[tracing::instrument(skip(settings, storage))]
+pub async fn restore_snapshot(settings: &Settings, storage: &BackupStorage, id: &str) -> Result<Manifest> {
+    let manifest: Manifest = serde_json::from_slice(
+        storage
+            .bucket()?
+            .get_object(key(id, "manifest.json"))
+            .await
+            .map_err(|err| anyhow!("Snapshot {id} doesn't exist or didn't finish: {err}"))?
+            .bytes(),
+    )?;
+    let docker = Docker::connect_with_local_defaults()?;
+
+    for part in &manifest.parts {
+        match part.kind {
+            PartKind::Database => {
+                tracing::info!("Restoring platform database");
+                let restore = postgres_command(
+                    settings,
+                    "pg_restore",
+                    &["--clean", "--if-exists", "--no-owner", "--single-transaction"],
+                );
+                download_input(storage, &part.key, restore)
+                    .await
+                    .map_err(|err| anyhow!("Can't restore the platform database: {err}"))?;
+            }
+            PartKind::Git => {
+                tracing::info!(base = settings.git.base, "Restoring git repos");
+                tokio::fs::create_dir_all(&settings.git.base).await?;
+                let mut tar = Command::new("tar");
+                tar.args(["--extract", "--gzip", "--file", "-", "--directory", &settings.git.base]);
+                download_input(storage, &part.key, tar)
+                    .await
+                    .map_err(|err| anyhow!("Can't restore git repos: {err}"))?;
+            }
+            PartKind::Volume => {
+                let volume = part
+                    .volume
+                    .as_deref()
+                    .ok_or(anyhow!("Volume part {} has no volume", part.key))?;
+                tracing::info!(volume, "Restoring volume");
+                docker
+                    .create_volume(CreateVolumeOptions {
+                        name: volume.to_string(),
+                        labels: part.labels.clone(),
+                        ..Default::default()
+                    })
+                    .await?;
+                let tar = volume_command(volume, false, &["tar", "-xzf", "-", "-C", "/volume"]);
+                download_input(storage, &part.key, tar)
+                    .await
+                    .map_err(|err| anyhow!("Can't restore volume {volume}: {err}"))?;
+            }
+        }
+    }
+
+    Ok(manifest)
+}
+
+/// Takes a snapshot on `backup.platformschedule` and prunes the old ones after it
+pub async fn snapshot_scheduler(settings: Settings, storage: BackupStorage) {
+    loop {
+        let next = match next_run(&settings.backup.platformschedule, &Utc::now()) {
+            Ok(next) => next,
+            Err(err) => {
+                tracing::error!(?err, "Can't schedule platform snapshots, they are disabled");
+                return;
+            }
+        };
+
+        let wait = (next - Utc::now()).to_std().unwrap_or_default();
+        tokio::time::sleep(wait).await;
+
+        if let Err(err) = create_snapshot(&settings, &storage, true).await {
+            tracing::error!(?err, "Can't take platform snapshot");
+            continue;
+        }
+
+        match prune_snapshots(&storage, settings.backup.platformretention).await {
+            Ok(deleted) if !deleted.is_empty() => tracing::info!(?deleted, "Deleted expired platform snapshots"),
+            Ok(_) => {}
+            Err(err) => tracing::error!(?err, "Can't prune platform snapshots"),
+        }
+    }
+}
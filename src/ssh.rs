@@ -0,0 +1,489 @@
+use std::collections::HashMap;
+use std::net::SocketAddr;
+use std::os::unix::fs::PermissionsExt;
+use std::process::Stdio;
+use std::sync::Arc;
+use std::time::Duration;
+
+use anyhow::Result;
+use async_trait::async_trait;
+use bytes::Bytes;
+use hyper::{HeaderMap, StatusCode};
+use russh::server::{Auth, Config, Handle, Handler, Msg, Server, Session};
+use russh::{Channel, ChannelId, CryptoVec, MethodSet};
+use russh_keys::key::PublicKey;
+use russh_keys::PublicKeyBase64;
+use sha1::{Digest, Sha1};
+use tokio::io::{AsyncRead, AsyncReadExt, AsyncWriteExt};
+use tokio::process::{Child, ChildStdin, Command};
+
+use crate::audit::{record, NewAuditEntry};
+use crate::configuration::GitSettings;
+use crate::deploy_keys::{deploy_key, fingerprint, generate_key, DeployKey};
+use crate::git::{git_command, receive_pack};
+use crate::lfs::lfs_token;
+use crate::startup::AppState;
+
+/// sessions doing nothing for this long are closed
+const INACTIVITY_TIMEOUT: Duration = Duration::from_secs(600);
+/// seconds the tokens of git-lfs-authenticate work for
+const LFS_TOKEN_EXPIRY: i64 = 3600;
+
+/// Serves git over ssh next to the http remote, for the deploy keys of apps. Fetches talk to
+/// git directly, pushes are read whole and go through [`receive_pack`] like the ones over
+/// http, so they meet the same push policy and deploy the same way
+pub async fn run(state: AppState, host: String, settings: GitSettings, body_limit: usize) -> Result<()> {
+    let config = Config {
+        methods: MethodSet::PUBLICKEY,
+        auth_rejection_time: Duration::from_secs(1),
+        inactivity_timeout: Some(INACTIVITY_TIMEOUT),
+        keys: vec![host_key(&settings.sshhostkey).await?],
+        ..Default::default()
+    };
+    let server = GitServer { state, body_limit };
+
+    tracing::info!(port = settings.sshport, "Serving git over ssh");
+    russh::server::run(Arc::new(config), (host, settings.sshport), server).await?;
+    Ok(())
+}
+
+/// The host key ci pins in its known hosts, made once and kept in `path`
+async fn host_key(path: &str) -> Result<russh_keys::key::KeyPair> {
+    let pem = match tokio::fs::read_to_string(path).await {
+        Ok(pem) => pem,
+        Err(err) if err.kind() == std::io::ErrorKind::NotFound => {
+            tracing::info!(path, "No ssh host key, making one");
+            let pem = generate_key("pemasak").private_key;
+            tokio::fs::write(path, &pem).await?;
+            tokio::fs::set_permissions(path, std::fs::Permissions::from_mode(0o600)).await?;
+            pem
+        }
+        Err(err) => return Err(err.into()),
+    };
+    Ok(russh_keys::decode_secret_key(&pem, None)?)
+}
+
+#[derive(Clone)]
+struct GitServer {
+    state: AppState,
+    body_limit: usize,
+}
+
+impl Server for GitServer {
+    type Handler = GitSession;
+
+    fn new_client(&mut self, peer: Option<SocketAddr>) -> GitSession {
+        GitSession {
+            state: self.state.clone(),
+            body_limit: self.body_limit,
+            peer,
+            key: None,
+            protocols: HashMap::new(),
+            exchanges: HashMap::new(),
+        }
+    }
+}
+
+/// One ssh connection, authenticated with a deploy key
+struct GitSession {
+    state: AppState,
+    body_limit: usize,
+    peer: Option<SocketAddr>,
+    key: Option<DeployKey>,
+    /// the `GIT_PROTOCOL` the client asked for on each channel, for protocol v2
+    protocols: HashMap<ChannelId, String>,
+    exchanges: HashMap<ChannelId, Exchange>,
+}
+
+enum Exchange {
+    /// what the client sends goes to git as it comes
+    Fetch(ChildStdin),
+    Push(Push),
+}
+
+struct Push {
+    owner: String,
+    repo: String,
+    key: DeployKey,
+    request: PushRequest,
+}
+
+#[async_trait]
+impl Handler for GitSession {
+    type Error = anyhow::Error;
+
+    async fn auth_publickey(&mut self, user: &str, public_key: &PublicKey) -> Result<Auth, Self::Error> {
+        let reject = Auth::Reject { proceed_with_methods: None };
+        // like the remotes of github and gitlab, the key says who it is
+        if user != "git" {
+            return Ok(reject);
+        }
+
+        match deploy_key(&fingerprint(&public_key.public_key_bytes()), &self.state.pool).await {
+            Ok(Some(key)) => {
+                self.key = Some(key);
+                Ok(Auth::Accept)
+            }
+            Ok(None) => Ok(reject),
+            Err(err) => {
+                tracing::error!(?err, "Can't authenticate deploy key: Failed to query database");
+                Ok(reject)
+            }
+        }
+    }
+
+    async fn channel_open_session(&mut self, _channel: Channel<Msg>, _session: &mut Session) -> Result<bool, Self::Error> {
+        Ok(true)
+    }
+
+    async fn env_request(
+        &mut self,
+        channel: ChannelId,
+        variable_name: &str,
+        variable_value: &str,
+        _session: &mut Session,
+    ) -> Result<(), Self::Error> {
+        if variable_name == "GIT_PROTOCOL" {
+            self.protocols.insert(channel, variable_value.to_string());
+        }
+        Ok(())
+    }
+
+    async fn exec_request(&mut self, channel: ChannelId, data: &[u8], session: &mut Session) -> Result<(), Self::Error> {
+        session.channel_success(channel);
+
+        let command = String::from_utf8_lossy(data).to_string();
+        match self.start(&command, channel, session).await {
+            Ok(Some(exchange)) => {
+                self.exchanges.insert(channel, exchange);
+            }
+            Ok(None) => {
+                session.exit_status_request(channel, 0);
+                session.eof(channel);
+                session.close(channel);
+            }
+            Err(message) => {
+                session.extended_data(channel, 1, CryptoVec::from_slice(format!("{message}\n").as_bytes()));
+                session.exit_status_request(channel, 1);
+                session.eof(channel);
+                session.close(channel);
+            }
+        }
+        Ok(())
+    }
+
+    async fn data(&mut self, channel: ChannelId, data: &[u8], session: &mut Session) -> Result<(), Self::Error> {
+        match self.exchanges.get_mut(&channel) {
+            Some(Exchange::Fetch(stdin)) => {
+                // git went away, it says why itself
+                if stdin.write_all(data).await.is_err() {
+                    self.exchanges.remove(&channel);
+                }
+            }
+            Some(Exchange::Push(push)) => {
+                push.request.data.extend_from_slice(data);
+                if push.request.data.len() > self.body_limit {
+                    self.exchanges.remove(&channel);
+                    let message = format!("The push is bigger than the {} bytes the server takes\n", self.body_limit);
+                    session.extended_data(channel, 1, CryptoVec::from_slice(message.as_bytes()));
+                    session.exit_status_request(channel, 1);
+                    session.eof(channel);
+                    session.close(channel);
+                } else if push.request.complete() {
+                    if let Some(Exchange::Push(push)) = self.exchanges.remove(&channel) {
+                        let ip = self.peer.map(|peer| peer.ip().to_string());
+                        tokio::spawn(receive(self.state.clone(), push, ip, channel, session.handle()));
+                    }
+                }
+            }
+            None => {}
+        }
+        Ok(())
+    }
+
+    async fn channel_eof(&mut self, channel: ChannelId, session: &mut Session) -> Result<(), Self::Error> {
+        // dropping stdin of a fetch lets git know there is nothing more
+        match self.exchanges.remove(&channel) {
+            Some(Exchange::Push(_)) => {
+                session.extended_data(channel, 1, CryptoVec::from_slice(b"The push ended before its pack did\n"));
+                session.exit_status_request(channel, 1);
+                session.close(channel);
+            }
+            Some(Exchange::Fetch(_)) | None => {}
+        }
+        Ok(())
+    }
+
+    async fn channel_close(&mut self, channel: ChannelId, _session: &mut Session) -> Result<(), Self::Error> {
+        self.exchanges.remove(&channel);
+        self.protocols.remove(&channel);
+        Ok(())
+    }
+}
+
+impl GitSession {
+    /// Checks the command against the key and starts git for it, the error is shown to the
+    /// client. None when the command was answered right away
+    async fn start(&mut self, command: &str, channel: ChannelId, session: &mut Session) -> Result<Option<Exchange>, String> {
+        let Some((service, owner, repo)) = parse_command(command) else {
+            return Err(format!("Only git can be run here, not {command}"));
+        };
+        let Some(key) = self.key.clone() else {
+            return Err("Not logged in".to_string());
+        };
+        if owner != key.owner || repo != key.project {
+            return Err(format!("The deploy key {} can't access {owner}/{repo}", key.name));
+        }
+        let path = format!("{}/{owner}/{repo}.git", self.state.base);
+
+        match service {
+            Service::Fetch => {
+                let mut child = Command::new("git")
+                    .args(["upload-pack", &path])
+                    .envs(self.protocols.get(&channel).map(|protocol| ("GIT_PROTOCOL", protocol.clone())))
+                    .stdin(Stdio::piped())
+                    .stdout(Stdio::piped())
+                    .stderr(Stdio::piped())
+                    .kill_on_drop(true)
+                    .spawn()
+                    .map_err(|err| {
+                        tracing::error!(?err, "Can't serve fetch: Failed to spawn git");
+                        "Can't read the repository".to_string()
+                    })?;
+                let stdin = child.stdin.take().unwrap();
+                tokio::spawn(pump(child, channel, session.handle()));
+                Ok(Some(Exchange::Fetch(stdin)))
+            }
+            Service::Push if key.read_only => Err(format!("The deploy key {} is read-only, it can't push", key.name)),
+            Service::Push => {
+                let refs = git_command(
+                    &path,
+                    ["receive-pack", "--stateless-rpc", "--advertise-refs", "."],
+                    std::iter::empty::<(String, String)>(),
+                )
+                .await
+                .ok()
+                .filter(|out| out.status.success())
+                .ok_or_else(|| "Can't read the repository".to_string())?;
+                session.data(channel, CryptoVec::from_slice(&refs.stdout));
+
+                Ok(Some(Exchange::Push(Push {
+                    owner,
+                    repo,
+                    key,
+                    request: PushRequest::default(),
+                })))
+            }
+            Service::LfsAuthenticate(true) if key.read_only => {
+                Err(format!("The deploy key {} is read-only, it can't upload Git LFS objects", key.name))
+            }
+            Service::LfsAuthenticate(write) => {
+                let scheme = match self.state.secure {
+                    true => "https",
+                    false => "http",
+                };
+                let auth = serde_json::json!({
+                    "href": format!("{scheme}://{}/{owner}/{repo}.git/info/lfs", self.state.domain),
+                    "header": { "Authorization": format!("Bearer {}", lfs_token(&owner, &repo, write, LFS_TOKEN_EXPIRY)) },
+                    "expires_in": LFS_TOKEN_EXPIRY,
+                });
+                session.data(channel, CryptoVec::from_slice(format!("{auth}\n").as_bytes()));
+                Ok(None)
+            }
+        }
+    }
+}
+
+enum Service {
+    Fetch,
+    Push,
+    /// asks for a token of the lfs api over http, uploading when true
+    LfsAuthenticate(bool),
+}
+
+/// What git runs on the remote, like `git-upload-pack '/owner/repo.git'` or
+/// `git-lfs-authenticate '/owner/repo.git' upload`
+fn parse_command(command: &str) -> Option<(Service, String, String)> {
+    let command = command.trim();
+    let rest = command.strip_prefix("git-").or_else(|| command.strip_prefix("git "))?;
+    let (service, path) = rest.split_once(' ')?;
+    let (service, path) = match service {
+        "upload-pack" => (Service::Fetch, path),
+        "receive-pack" => (Service::Push, path),
+        "lfs-authenticate" => match path.trim().rsplit_once(' ')? {
+            (path, "upload") => (Service::LfsAuthenticate(true), path),
+            (path, "download") => (Service::LfsAuthenticate(false), path),
+            _ => return None,
+        },
+        _ => return None,
+    };
+
+    let path = path.trim().trim_matches('\'').trim_matches('/');
+    let (owner, repo) = path.split_once('/')?;
+    let repo = repo.strip_suffix(".git").unwrap_or(repo);
+    Some((service, owner.to_string(), repo.to_string()))
+}
+
+/// Copies the output of git to the channel until it exits, then its exit status
+async fn pump(mut child: Child, channel: ChannelId, handle: Handle) {
+    let stdout = child.stdout.take().unwrap();
+    let stderr = child.stderr.take().unwrap();
+    tokio::join!(copy(stdout, &handle, channel, None), copy(stderr, &handle, channel, Some(1)));
+
+    let status = child.wait().await.ok().and_then(|status| status.code()).unwrap_or(1);
+    finish(&handle, channel, status as u32).await;
+}
+
+/// `ext` 1 is stderr
+async fn copy(mut from: impl AsyncRead + Unpin, handle: &Handle, channel: ChannelId, ext: Option<u32>) {
+    let mut buf = vec![0; 32 * 1024];
+    loop {
+        let read = match from.read(&mut buf).await {
+            Ok(0) | Err(_) => return,
+            Ok(read) => read,
+        };
+        let data = CryptoVec::from_slice(&buf[..read]);
+        let sent = match ext {
+            Some(ext) => handle.extended_data(channel, ext, data).await,
+            None => handle.data(channel, data).await,
+        };
+        if sent.is_err() {
+            return;
+        }
+    }
+}
+
+async fn finish(handle: &Handle, channel: ChannelId, status: u32) {
+    let _ = handle.exit_status_request(channel, status).await;
+    let _ = handle.eof(channel).await;
+    let _ = handle.close(channel).await;
+}
+
+/// Hands a push read whole to [`receive_pack`] and sends its answer back
+async fn receive(state: AppState, push: Push, ip: Option<String>, channel: ChannelId, handle: Handle) {
+    let Push { owner, repo, key, request } = push;
+    let pool = state.pool.clone();
+
+    let res = receive_pack(owner.clone(), repo.clone(), state, HeaderMap::new(), Bytes::from(request.data)).await;
+    let status = res.status();
+    let body = hyper::body::to_bytes(res.into_body()).await.unwrap_or_default();
+
+    // the key acted, not a user, so the audit log names it
+    let entry = NewAuditEntry {
+        actor_id: None,
+        actor: format!("deploy-key:{}", key.name),
+        token_id: None,
+        owner: Some(owner.clone()),
+        project: Some(repo.clone()),
+        action: "git.push".to_string(),
+        method: "SSH".to_string(),
+        path: format!("/{owner}/{repo}/git-receive-pack"),
+        status: status.as_u16() as i32,
+        ip,
+        change: None,
+    };
+    if let Err(err) = record(entry, &pool).await {
+        tracing::error!(?err, "Can't record audit entry: Failed to insert into database");
+    }
+
+    if status != StatusCode::OK {
+        let message = match body.is_empty() {
+            true => "The push failed".to_string(),
+            false => String::from_utf8_lossy(&body).to_string(),
+        };
+        let _ = handle.extended_data(channel, 1, CryptoVec::from_slice(format!("{message}\n").as_bytes())).await;
+        finish(&handle, channel, 1).await;
+        return;
+    }
+    if !body.is_empty() {
+        let _ = handle.data(channel, CryptoVec::from_slice(&body)).await;
+    }
+    finish(&handle, channel, 0).await;
+}
+
+/// What a client sends to push: the commands, then a pack unless it only deletes. Over http it
+/// comes as one request, over ssh it is read until the sha1 every pack ends with matches
+#[derive(Default)]
+struct PushRequest {
+    data: Vec<u8>,
+    /// where the pack starts, once all the commands are there
+    pack: Option<usize>,
+    wants_pack: bool,
+    /// of the pack but the last 20 bytes, which could be its checksum
+    hasher: Sha1,
+    hashed: usize,
+}
+
+impl PushRequest {
+    fn complete(&mut self) -> bool {
+        let start = match self.pack {
+            Some(start) => start,
+            None => match commands_end(&self.data) {
+                Some((end, wants_pack)) => {
+                    self.pack = Some(end);
+                    self.wants_pack = wants_pack;
+                    end
+                }
+                None => return false,
+            },
+        };
+        if !self.wants_pack {
+            return true;
+        }
+
+        // a pack is at least its 12 byte header and the checksum
+        let Some(end) = self.data.len().checked_sub(20) else {
+            return false;
+        };
+        if end < start + 12 {
+            return false;
+        }
+        self.hasher.update(&self.data[start + self.hashed..end]);
+        self.hashed = end - start;
+        self.hasher.clone().finalize().as_slice() == &self.data[end..]
+    }
+}
+
+/// Where the commands of a push end and whether a pack comes after them, None until they are
+/// all there. A request git won't understand is handed to it whole for it to refuse
+fn commands_end(data: &[u8]) -> Option<(usize, bool)> {
+    let mut at = 0;
+    let mut wants_pack = false;
+    // the push options come in a second list after the commands
+    let mut options = false;
+    let mut first = true;
+
+    loop {
+        let head = data.get(at..at + 4)?;
+        let Some(len) = std::str::from_utf8(head).ok().and_then(|head| usize::from_str_radix(head, 16).ok()) else {
+            return Some((data.len(), false));
+        };
+        if len == 0 {
+            at += 4;
+            if options {
+                options = false;
+                continue;
+            }
+            return Some((at, wants_pack));
+        }
+        if len < 4 {
+            return Some((data.len(), false));
+        }
+        let line = data.get(at + 4..at + len)?;
+        at += len;
+
+        let line = String::from_utf8_lossy(line);
+        let (command, capabilities) = line.split_once('\0').unwrap_or((&line, ""));
+        if first && !command.starts_with("shallow ") {
+            first = false;
+            options = capabilities.split_whitespace().any(|capability| capability == "push-options");
+        }
+        // deletes push to the zero id, they need no objects
+        let mut parts = command.split_whitespace();
+        if let (Some(_old), Some(new), Some(_name)) = (parts.next(), parts.next(), parts.next()) {
+            if !new.bytes().all(|b| b == b'0') {
+                wants_pack = true;
+            }
+        }
+    }
+}
@@ -1,38 +1,91 @@
-use axum::extract::{Host, State};
+use axum::extract::{ConnectInfo, Host, State};
 use axum::middleware::Next;
 use axum::response::Redirect;
 use axum::{middleware, routing, Router};
 
 use axum_session::{SessionLayer, SessionPgPool};
 use axum_session_auth::AuthSessionLayer;
-use bollard::Docker;
 use bytes::Bytes;
+use chrono::Utc;
 use http_body::combinators::UnsyncBoxBody;
-use hyper::{Body, Method, Request, Response, StatusCode, Uri};
+use hyper::header::{HeaderMap, HeaderValue, ACCEPT_ENCODING, AUTHORIZATION, HOST, ORIGIN, SET_COOKIE, STRICT_TRANSPORT_SECURITY};
+use hyper::{Body, Method, Request, Response, StatusCode, Uri, Version};
+use rand::Rng;
 
+use secrecy::Secret;
 use sqlx::PgPool;
 use tokio::sync::mpsc::Sender;
 use tower_http::cors::CorsLayer;
 use tower_http::services::{ServeDir, ServeFile};
+use ulid::Ulid;
 use uuid::Uuid;
 
 use std::net::{SocketAddr, TcpListener};
+use std::time::{Duration, Instant};
 
+use crate::access_logs::{AccessLog, AccessLogger, Upstream};
+use crate::audit::client_ip;
+use crate::auth::oidc::Oidc;
 use crate::auth::User;
-use crate::configuration::Settings;
-use crate::queue::BuildQueueItem;
-use crate::{auth, dashboard, git, owner, projects, telemetry};
+use crate::backups::BackupStorage;
+use crate::lfs::LfsStorage;
+use crate::notifications::Notifier;
+use crate::balancer::{affinity_cookie, affinity_set_cookie, strip_affinity_cookie, Balancer};
+use crate::basic_auth::{challenge, BasicAuth, BasicAuthCache};
+use crate::cache::{cache_key, Lookup, ResponseCache};
+use crate::compression::compress;
+use crate::configuration::{AuthSettings, CleanupSettings, ContainerSettings, GitSettings, QuotaSettings, Settings};
+use crate::cors::CorsPolicy;
+use crate::error_pages::{grpc_unavailable, is_grpc, ErrorPage};
+use crate::header_rules::{set_forwarded, HeaderRules, Vars};
+use crate::https::{forwarded_https, redirect, HttpsPolicy};
+use crate::idle::IdleTracker;
+use crate::in_flight;
+use crate::ip_access::IpAccess;
+use crate::proxy_signature::{self, signing_secrets};
+use crate::queue::{BuildQueueItem, BuildQueueState};
+use crate::rate_limits::{RateLimiter, RateLimits};
+use crate::request_limits::{limit_body, RequestLimits};
+use crate::secrets::SecretCipher;
+use crate::sites::{self, SiteRoot};
+use crate::{streaming, websockets};
+use crate::{admin, auth, dashboard, git, monitoring, orchestrator, owner, projects, telemetry};
 
 #[derive(Clone)]
 pub struct AppState {
     pub base: String,
     pub git_auth: bool,
     pub sso: bool,
+    /// openid connect login, None when no issuer is configured
+    pub oidc: Option<Oidc>,
     pub domain: String,
     pub client: hyper::client::Client<hyper::client::HttpConnector, hyper::Body>,
+    /// http/2 without tls, for apps that set their protocol to h2c
+    pub h2c_client: hyper::client::Client<hyper::client::HttpConnector, hyper::Body>,
     pub pool: PgPool,
     pub build_channel: Sender<BuildQueueItem>,
+    pub build_queue: BuildQueueState,
     pub secure: bool,
+    pub secrets: SecretCipher,
+    pub notifier: Notifier,
+    pub backups: BackupStorage,
+    /// where the git lfs objects of pushes are kept
+    pub lfs: LfsStorage,
+    pub balancer: Balancer,
+    pub rate_limiter: RateLimiter,
+    /// logins of apps behind `pmk basic-auth` checked lately
+    pub basic_auth: BasicAuthCache,
+    /// responses of apps with `pmk cache on`
+    pub cache: ResponseCache,
+    /// requests the proxy answered, for `pmk logs --source router`
+    pub access_logs: AccessLogger,
+    pub idle: IdleTracker,
+    pub metrics_token: Option<Secret<String>>,
+    pub container_settings: ContainerSettings,
+    pub quota_settings: QuotaSettings,
+    pub git_settings: GitSettings,
+    pub auth_settings: AuthSettings,
+    pub cleanup_settings: CleanupSettings,
 }
 
 pub async fn run(listener: TcpListener, state: AppState, config: Settings) -> Result<(), String> {
@@ -56,15 +109,24 @@ pub async fn run(listener: TcpListener, state: AppState, config: Settings) -> Re
     let dashboard_router: Router<AppState> = dashboard::api::router(state.clone(), &config).await;
     let project_router = projects::api::router(state.clone(), &config).await;
     let owners_router = owner::api::router(state.clone(), &config).await;
+    let admin_router = admin::api::router(state.clone(), &config).await;
 
     let app = Router::new()
         .route("/", routing::any(|| async { Redirect::permanent("/web") }))
+        .route("/metrics", routing::get(monitoring::export))
         .merge(git_router)
         .merge(auth_router)
         .merge(dashboard_router)
         .merge(project_router)
         .merge(owners_router)
+        .merge(admin_router)
         .layer(http_trace)
+        // inside token_auth, token requests aren't about the session
+        .layer(middleware::from_fn(admin::read_only_impersonation))
+        // revoked sessions are logged out before anything else sees them
+        .layer(middleware::from_fn_with_state(state.clone(), auth::sessions::track))
+        // inside the session layer, it fills in the user the session didn't have
+        .layer(middleware::from_fn_with_state(state.clone(), auth::tokens::token_auth))
         // TODO: rethink if we need this here. since it makes all routes under this query the
         // session even if they don't need it
         .layer(
@@ -80,8 +142,9 @@ pub async fn run(listener: TcpListener, state: AppState, config: Settings) -> Re
         )
         .fallback(fallback)
         .with_state(state.clone())
-        .route_layer(middleware::from_fn_with_state(state, fallback_middleware))
-        .layer(cors);
+        .layer(cors)
+        // outside the cors of the platform, apps answer their own preflights
+        .layer(middleware::from_fn_with_state(state, fallback_middleware));
 
     let addr = listener
         .local_addr()
@@ -89,27 +152,73 @@ pub async fn run(listener: TcpListener, state: AppState, config: Settings) -> Re
 
     tracing::info!("listening on {}", addr);
 
-    axum::Server::from_tcp(listener)
-        .map_err(|err| format!("Failed to make server from tcp: {}", err))?
+    let mut server = axum::Server::from_tcp(listener)
+        .map_err(|err| format!("Failed to make server from tcp: {}", err))?;
+    // clients holding connections open with headers that never finish, before any app is known
+    if config.container.headertimeout > 0 {
+        server = server.http1_header_read_timeout(Duration::from_secs(config.container.headertimeout));
+    }
+    let server = server
         .serve(app.into_make_service_with_connect_info::<SocketAddr>())
-        .await
-        .map_err(|err| format!("failed to start server: {}", err))
+        .with_graceful_shutdown(shutdown_signal());
+    tokio::pin!(server);
+
+    // on shutdown the server stops taking connections and lets the requests it is proxying
+    // finish, for as long as a deploy lets them
+    tokio::select! {
+        res = &mut server => return res.map_err(|err| format!("failed to start server: {}", err)),
+        _ = shutdown_signal() => tracing::info!("Shutting down, waiting for requests in flight"),
+    }
+    let deadline = Duration::from_secs(config.container.drainperiod);
+    match tokio::time::timeout(deadline, server).await {
+        Ok(res) => res.map_err(|err| format!("failed to shut down server: {}", err)),
+        Err(_) => {
+            tracing::warn!("Requests still in flight after the drain period, shutting down anyway");
+            Ok(())
+        }
+    }
+}
+
+/// Resolves on SIGTERM, which docker and systemd stop the platform with, or on ctrl-c
+async fn shutdown_signal() {
+    let terminate = async {
+        match tokio::signal::unix::signal(tokio::signal::unix::SignalKind::terminate()) {
+            Ok(mut terminate) => {
+                terminate.recv().await;
+            }
+            Err(err) => {
+                tracing::error!(?err, "Can't shut down gracefully: Failed to listen for SIGTERM");
+                std::future::pending::<()>().await;
+            }
+        }
+    };
+
+    tokio::select! {
+        _ = tokio::signal::ctrl_c() => {}
+        _ = terminate => {}
+    }
 }
 
 pub async fn fallback(
     State(AppState {
         pool,
         client,
+        h2c_client,
         domain,
+        balancer,
+        idle,
+        rate_limiter,
+        basic_auth,
+        cache,
+        access_logs,
+        container_settings,
         ..
     }): State<AppState>,
     Host(hostname): Host,
     uri: axum::http::Uri,
-    mut req: Request<Body>,
+    req: Request<Body>,
 ) -> Response<Body> {
-    let subdomain = hostname
-        .trim_end_matches(domain.as_str())
-        .trim_end_matches('.');
+    let subdomain = project_subdomain(&pool, &hostname, &domain).await;
 
     if subdomain.is_empty() {
         return Response::builder()
@@ -122,95 +231,31 @@ pub async fn fallback(
     tracing::debug!(domain, "domain {}", domain);
     tracing::debug!(?subdomain, "subdomain {} is accessed", subdomain);
 
-    let ip_address = match Docker::connect_with_local_defaults() {
-        Ok(docker) => match docker.inspect_container(subdomain, None).await {
-            Ok(res) => {
-                let network = match res.network_settings {
-                    Some(network) => network,
-                    None => {
-                        return Response::builder()
-                            .status(StatusCode::BAD_REQUEST)
-                            .body(Body::empty())
-                            .unwrap();
-                    }
-                };
-
-                let networks = match network.networks {
-                    Some(networks) => networks,
-                    None => {
-                        return Response::builder()
-                            .status(StatusCode::BAD_REQUEST)
-                            .body(Body::empty())
-                            .unwrap();
-                    }
-                };
-
-                let project_network = networks.get(&format!("{}-network", subdomain));
-                if let Some(project_network) = project_network {
-                    match &project_network.ip_address {
-                        Some(ip_address) => Ok(ip_address.clone()),
-                        None => {
-                            return Response::builder()
-                            .status(StatusCode::BAD_REQUEST)
-                            .body(Body::empty())
-                            .unwrap();
-                        }
-                    }
-                } else {
-                    return Response::builder()
-                        .status(StatusCode::BAD_REQUEST)
-                        .body(Body::empty())
-                        .unwrap();
-                }
-            }
-            Err(_) => Err(Response::builder()
-                .status(StatusCode::BAD_REQUEST)
-                .body(Body::empty())
-                .unwrap()),
-        },
-        Err(_) => Err(Response::builder()
-            .status(StatusCode::BAD_REQUEST)
-            .body(Body::empty())
-            .unwrap()),
-    };
-
-    if let Ok(ip_address) = ip_address {
-        let uri = format!("http://{}:{}{}", ip_address, 80, uri);
-        *req.uri_mut() = Uri::try_from(uri).unwrap();
-        match client.request(req).await {
-            Ok(res) => res,
-            Err(err) => {
-                tracing::error!(?err, "Can't access container: Failed request to container");
-    
-                return Response::builder()
-                .status(StatusCode::BAD_REQUEST)
-                .body(Body::empty())
-                .unwrap();
-            }
-        }
-    } else {
-        Response::builder()
-            .status(StatusCode::BAD_REQUEST)
-            .body(Body::empty())
-            .unwrap()
-    }
+    let clients = (&client, &h2c_client);
+    proxy(&pool, clients, &balancer, &idle, &rate_limiter, &basic_auth, &cache, &access_logs, &container_settings, &subdomain, uri, req).await
 }
 
 pub async fn fallback_middleware(
     State(AppState {
         pool,
         client,
+        h2c_client,
         domain,
+        balancer,
+        idle,
+        rate_limiter,
+        basic_auth,
+        cache,
+        access_logs,
+        container_settings,
         ..
     }): State<AppState>,
     Host(hostname): Host,
     uri: axum::http::Uri,
-    mut req: Request<Body>,
+    req: Request<Body>,
     next: Next<Body>,
 ) -> Result<Response<UnsyncBoxBody<Bytes, axum::Error>>, Response<Body>> {
-    let subdomain = hostname
-        .trim_end_matches(domain.as_str())
-        .trim_end_matches('.');
+    let subdomain = project_subdomain(&pool, &hostname, &domain).await;
 
     tracing::debug!(hostname, "hostname {}", hostname);
     tracing::debug!(domain, "domain {}", domain);
@@ -220,78 +265,819 @@ pub async fn fallback_middleware(
         return Ok(next.run(req).await);
     }
 
-    tracing::debug!(?subdomain, "subdomain {} is accessed", subdomain);
+    let clients = (&client, &h2c_client);
+    Err(proxy(&pool, clients, &balancer, &idle, &rate_limiter, &basic_auth, &cache, &access_logs, &container_settings, &subdomain, uri, req).await)
+}
 
-    let ip_address = match Docker::connect_with_local_defaults() {
-        Ok(docker) => match docker.inspect_container(subdomain, None).await {
-            Ok(res) => {
-                let network = match res.network_settings {
-                    Some(network) => network,
-                    None => {
-                        return Err(Response::builder()
-                            .status(StatusCode::BAD_REQUEST)
-                            .body(Body::empty())
-                            .unwrap());
-                    }
-                };
-
-                let networks = match network.networks {
-                    Some(networks) => networks,
-                    None => {
-                        return Err(Response::builder()
-                            .status(StatusCode::BAD_REQUEST)
-                            .body(Body::empty())
-                            .unwrap());
-                    }
-                };
-
-                let project_network = networks.get(&format!("{}-network", subdomain));
-                if let Some(project_network) = project_network {
-                    match &project_network.ip_address {
-                        Some(ip_address) => Ok(ip_address.clone()),
-                        None => {
-                            return Err(Response::builder()
-                            .status(StatusCode::BAD_REQUEST)
-                            .body(Body::empty())
-                            .unwrap());
-                        }
-                    }
-                } else {
-                    return Err(Response::builder()
-                        .status(StatusCode::BAD_REQUEST)
-                        .body(Body::empty())
-                        .unwrap());
-                }
-            }
-            Err(_) => Err(Response::builder()
-                .status(StatusCode::BAD_REQUEST)
-                .body(Body::empty())
-                .unwrap()),
+/// The http/1.1 and the h2c client of the proxy
+type Clients<'a> = (
+    &'a hyper::client::Client<hyper::client::HttpConnector, hyper::Body>,
+    &'a hyper::client::Client<hyper::client::HttpConnector, hyper::Body>,
+);
+
+/// Gives a request for `subdomain` its request id, sent to the app and back to the client in
+/// the X-Request-Id header, and logs it once it is answered. The access log of the app gets
+/// the path without the query, which may carry tokens
+#[tracing::instrument(
+    name = "proxy",
+    skip_all,
+    fields(
+        otel.kind = "server",
+        app = subdomain,
+        http.method = %req.method(),
+        http.target = uri.path(),
+        http.status_code = tracing::field::Empty,
+        request_id = tracing::field::Empty,
+    )
+)]
+async fn proxy(
+    pool: &PgPool,
+    clients: Clients<'_>,
+    balancer: &Balancer,
+    idle: &IdleTracker,
+    rate_limiter: &RateLimiter,
+    basic_auth: &BasicAuthCache,
+    cache: &ResponseCache,
+    access_logs: &AccessLogger,
+    container_settings: &ContainerSettings,
+    subdomain: &str,
+    uri: axum::http::Uri,
+    mut req: Request<Body>,
+) -> Response<Body> {
+    let request_id = request_id(req.headers());
+    let span = tracing::Span::current();
+    span.record("request_id", request_id.as_str());
+    // a proxy in front or the client may have started the trace already
+    telemetry::continue_trace(&span, req.headers());
+    let (ip, https) = match req.extensions().get::<ConnectInfo<SocketAddr>>() {
+        Some(ConnectInfo(addr)) => (client_ip(addr, req.headers()), forwarded_https(addr, req.headers())),
+        None => (String::new(), None),
+    };
+    let header = HeaderValue::from_str(&request_id).unwrap();
+    req.headers_mut().insert("X-Request-Id", header.clone());
+
+    let method = req.method().clone();
+    let path = uri.path().to_string();
+    let received_at = Utc::now();
+    let started = Instant::now();
+    idle.touch(subdomain);
+    let upstream = match upstream(pool, subdomain, container_settings).await {
+        Ok(upstream) => upstream,
+        Err(mut res) => {
+            res.headers_mut().insert("X-Request-Id", header);
+            span.record("http.status_code", res.status().as_u16());
+            return res;
+        }
+    };
+    let access_log = upstream.project_id.map(|project_id| (project_id, upstream.access_log_sample));
+    // counting the body would lose the trailers grpc sends its status in
+    let grpc = is_grpc(req.headers());
+    // every answer of the app over https tells the browser to stay on it, also the ones the
+    // proxy gives for it
+    let hsts = upstream.https.hsts(https);
+    let cors = upstream.cors.clone();
+    let origin = req.headers().get(ORIGIN).cloned();
+    let response_rules = upstream.header_rules.response.clone();
+    let host = req
+        .headers()
+        .get(HOST)
+        .and_then(|host| host.to_str().ok())
+        .or_else(|| uri.authority().map(|authority| authority.as_str()))
+        .unwrap_or("")
+        .to_string();
+    let vars = Vars {
+        client_ip: &ip,
+        request_id: &request_id,
+        host: &host,
+        scheme: match https {
+            Some(true) => "https",
+            Some(false) => "http",
+            None => "",
         },
-        Err(_) => Err(Response::builder()
-            .status(StatusCode::BAD_REQUEST)
-            .body(Body::empty())
-            .unwrap()),
     };
+    let mut res = serve(
+        clients,
+        balancer,
+        idle,
+        rate_limiter,
+        basic_auth,
+        cache,
+        container_settings,
+        subdomain,
+        upstream,
+        &vars,
+        https,
+        uri,
+        req,
+    )
+    .await;
+    res.headers_mut().insert("X-Request-Id", header);
+    if let Some(hsts) = hsts {
+        res.headers_mut().insert(STRICT_TRANSPORT_SECURITY, hsts);
+    }
+    cors.apply(origin.as_ref(), res.headers_mut());
+    response_rules.apply(res.headers_mut(), &vars);
 
-    if let Ok(ip_address) = ip_address {
-        let uri = format!("http://{}:{}{}", ip_address, 80, uri);
-        *req.uri_mut() = Uri::try_from(uri).unwrap();
-        match client.request(req).await {
-            Ok(res) => Err(res),
-            Err(err) => {
-                tracing::error!(?err, "Can't access container: Failed request to container");
-    
-                Err(Response::builder()
-                .status(StatusCode::BAD_REQUEST)
-                .body(Body::empty())
-                .unwrap())
+    let status = res.status();
+    let ms = started.elapsed().as_millis() as u64;
+    span.record("http.status_code", status.as_u16());
+    tracing::info!(
+        app = subdomain,
+        request_id,
+        ip,
+        %method,
+        path,
+        status = status.as_u16(),
+        ms,
+        "Proxied request"
+    );
+
+    match access_log {
+        Some((project_id, sample)) if AccessLogger::sampled(sample, status) => {
+            let upstream = res.extensions().get::<Upstream>().map(|upstream| upstream.0.clone());
+            let entry = AccessLog {
+                project_id,
+                recorded_at: received_at,
+                request_id,
+                client_ip: ip,
+                method: method.to_string(),
+                path,
+                status: status.as_u16() as i32,
+                duration_ms: ms.min(i32::MAX as u64) as i32,
+                bytes: None,
+                upstream: upstream.unwrap_or_default(),
+            };
+            access_logs.record(entry, res, grpc)
+        }
+        _ => res,
+    }
+}
+
+/// The X-Request-Id sent by the client or a proxy in front of the platform when it is safe to
+/// log, so a request keeps one id all the way, a new one otherwise
+fn request_id(headers: &HeaderMap) -> String {
+    headers
+        .get("X-Request-Id")
+        .and_then(|id| id.to_str().ok())
+        .filter(|id| {
+            !id.is_empty()
+                && id.len() <= 128
+                && id.chars().all(|c| c.is_ascii_alphanumeric() || "-_.:".contains(c))
+        })
+        .map(str::to_string)
+        .unwrap_or_else(|| Ulid::new().to_string())
+}
+
+/// Whether a request is for the profiles of a Go app. The path is matched the way the app will
+/// see it, decoded and with `//`, `.` and `..` resolved, or `/debug/%70prof/heap` and
+/// `/debug%2Fpprof/cmdline` would get past
+fn is_profile_path(path: &str) -> bool {
+    let decoded = sites::percent_decode(path);
+    let mut segments: Vec<&str> = Vec::new();
+    for segment in decoded.split('/') {
+        match segment {
+            "" | "." => {}
+            ".." => {
+                segments.pop();
             }
+            segment => segments.push(segment),
         }
-    } else {
-        Err(Response::builder()
-            .status(StatusCode::BAD_REQUEST)
+    }
+    format!("/{}", segments.join("/")).starts_with("/debug/pprof")
+}
+
+/// Forwards a request to one of the containers serving `subdomain` and records it for the
+/// metrics exporter. `https` is whether the client came over https, None when that isn't
+/// known
+async fn serve(
+    clients: Clients<'_>,
+    balancer: &Balancer,
+    idle: &IdleTracker,
+    rate_limiter: &RateLimiter,
+    basic_auth: &BasicAuthCache,
+    cache: &ResponseCache,
+    container_settings: &ContainerSettings,
+    subdomain: &str,
+    upstream: AppUpstream,
+    vars: &Vars<'_>,
+    https: Option<bool>,
+    uri: axum::http::Uri,
+    mut req: Request<Body>,
+) -> Response<Body> {
+    let (request_id, ip) = (vars.request_id, vars.client_ip);
+
+    // internal apps are only reachable on the private network of their owner
+    if upstream.internal {
+        return Response::builder()
+            .status(StatusCode::NOT_FOUND)
             .body(Body::empty())
-            .unwrap())
+            .unwrap();
+    }
+
+    // profiles show what is in the memory of the app, its members fetch them with `pmk pprof`
+    // through the api of the platform instead
+    if is_profile_path(uri.path()) {
+        return Response::builder()
+            .status(StatusCode::NOT_FOUND)
+            .body(Body::empty())
+            .unwrap();
+    }
+
+    // blocked clients don't learn anything about the app, not even that it is suspended
+    if !upstream.ip_access.allows(ip) {
+        monitoring::record_proxy_request(subdomain, StatusCode::FORBIDDEN, 0.0);
+        return Response::builder()
+            .status(StatusCode::FORBIDDEN)
+            .header("Content-Type", "text/plain; charset=utf-8")
+            .body(Body::from("Your network isn't allowed to reach this app"))
+            .unwrap();
+    }
+
+    // only a request known to be plain http is redirected, one that came around the proxy in
+    // front can't be sent anywhere better
+    if upstream.https.redirect && https == Some(false) {
+        return redirect(req.headers(), &uri);
+    }
+
+    if upstream.suspended {
+        return Response::builder()
+            .status(StatusCode::SERVICE_UNAVAILABLE)
+            .header("Content-Type", "text/plain; charset=utf-8")
+            .body(Body::from("This app is suspended"))
+            .unwrap();
+    }
+
+    // the app keeps running for whoever migrates it, visitors get the page
+    if let Some(maintenance) = upstream.maintenance {
+        return Response::builder()
+            .status(StatusCode::SERVICE_UNAVAILABLE)
+            .header("Content-Type", "text/html; charset=utf-8")
+            .header("Retry-After", maintenance.retry_after.to_string())
+            .body(Body::from(maintenance.page.unwrap_or_else(|| MAINTENANCE_PAGE.to_string())))
+            .unwrap();
+    }
+
+    // between crashes the container may be up, but visitors are better off knowing
+    if upstream.crash_looping {
+        return Response::builder()
+            .status(StatusCode::SERVICE_UNAVAILABLE)
+            .header("Content-Type", "text/html; charset=utf-8")
+            .header("Retry-After", "60")
+            .body(Body::from(CRASH_LOOPING_PAGE))
+            .unwrap();
+    }
+
+    // the app is spared the requests over its limits, they count for the metrics still
+    if let Err(wait) = rate_limiter.check(subdomain, ip, &upstream.rate_limits) {
+        monitoring::record_proxy_request(subdomain, StatusCode::TOO_MANY_REQUESTS, 0.0);
+        return Response::builder()
+            .status(StatusCode::TOO_MANY_REQUESTS)
+            .header("Content-Type", "text/plain; charset=utf-8")
+            .header("Retry-After", wait.as_secs_f64().ceil().max(1.0).to_string())
+            .body(Body::from("Too many requests to this app, try again in a moment"))
+            .unwrap();
+    }
+
+    // a body saying it is too big isn't read at all, the others are counted on the way
+    if let Err(res) = upstream.request_limits.check_length(req.headers()) {
+        monitoring::record_proxy_request(subdomain, res.status(), 0.0);
+        return res;
+    }
+
+    // browsers send preflights without the login, the app doesn't have to know about them
+    if let Some(res) = upstream.cors.preflight(req.method(), req.headers()) {
+        monitoring::record_proxy_request(subdomain, res.status(), 0.0);
+        return res;
+    }
+
+    // after the rate limits, guessing the password is as slow as any other request. The
+    // login is meant for the proxy, the app doesn't get it
+    if let Some(auth) = &upstream.basic_auth {
+        if !basic_auth.check(auth, req.headers()).await {
+            monitoring::record_proxy_request(subdomain, StatusCode::UNAUTHORIZED, 0.0);
+            return challenge(subdomain);
+        }
+        req.headers_mut().remove(AUTHORIZATION);
+    }
+
+    // the rules of the owners come last, they may want a header the platform sets otherwise
+    set_forwarded(req.headers_mut(), vars);
+    upstream.header_rules.request.apply(req.headers_mut(), vars);
+    // signed last from the headers the app gets, what the rules set is what is signed
+    let path = req.uri().path_and_query().map_or("/", |path| path.as_str()).to_string();
+    let method = req.method().clone();
+    proxy_signature::sign(req.headers_mut(), &upstream.proxy_secrets, method.as_str(), &path, vars);
+
+    // a static site is answered from its files, it needs neither the cache nor a container
+    if let Some(site) = &upstream.site {
+        let started = Instant::now();
+        let mut res = sites::serve(site, &req).await;
+        if upstream.compression {
+            res = compress(req.headers().get(ACCEPT_ENCODING), req.method(), res);
+        }
+        monitoring::record_proxy_request(subdomain, res.status(), started.elapsed().as_secs_f64());
+        res.extensions_mut().insert(Upstream("site".to_string()));
+        return res;
+    }
+
+    // a canary would get cached responses of the live release and the other way around, the
+    // cache waits until it is promoted or rolled back
+    let key = match upstream.edge_cache && upstream.canary.is_none() {
+        true => cache_key(&req, &uri),
+        false => None,
+    };
+    let cached = key.as_ref().map(|key| (key.clone(), req.headers().clone(), uri.path().to_string(), upstream.container.clone()));
+    let mut revalidating = false;
+    if let Some((key, headers, _, container)) = &cached {
+        match cache.lookup(subdomain, container, key, headers) {
+            Lookup::Hit(mut res) => {
+                monitoring::record_proxy_request(subdomain, res.status(), 0.0);
+                res.extensions_mut().insert(Upstream("cache".to_string()));
+                return res;
+            }
+            Lookup::Stale(validators) => revalidating = validators.apply(req.headers_mut()),
+            Lookup::Miss => {}
+        }
+    }
+
+    // a canary gets its share of the requests, the rest go to the live release
+    let release = upstream.canary.as_ref().map(|canary| {
+        match rand::thread_rng().gen_range(0..100) < canary.weight {
+            true => "canary",
+            false => "stable",
+        }
+    });
+
+    let error_page = upstream.error_page.clone();
+    let grpc = is_grpc(req.headers());
+    let started = Instant::now();
+    let res = forward(
+        clients,
+        balancer,
+        idle,
+        container_settings,
+        subdomain,
+        upstream,
+        release == Some("canary"),
+        uri,
+        req,
+    )
+    .await;
+
+    // a redirect to the error page of the owners still counts as the 5xx it stands for
+    let res = match (res, cached) {
+        (Ok(res), Some((key, headers, path, container))) => {
+            let revalidated = match revalidating && res.status() == StatusCode::NOT_MODIFIED {
+                true => cache.revalidated(subdomain, &container, &key, &headers, &res),
+                false => None,
+            };
+            match revalidated {
+                Some(res) => Ok(res),
+                None => Ok(cache.store(subdomain, &container, &key, &path, &headers, res).await),
+            }
+        }
+        (res, _) => res,
+    };
+
+    let (status, res) = match res {
+        Ok(res) => (res.status(), res),
+        Err((status, cause)) if grpc => (status, grpc_unavailable(status, subdomain, request_id, &cause)),
+        Err((status, cause)) => (status, error_page.render(status, subdomain, request_id, &cause)),
+    };
+
+    let seconds = started.elapsed().as_secs_f64();
+    monitoring::record_proxy_request(subdomain, status, seconds);
+    if let Some(release) = release {
+        monitoring::record_release_request(subdomain, release, status, seconds);
+    }
+    res
+}
+
+/// Err is the proxy failing to reach the app, with the status and the cause, errors of the
+/// app itself are Ok
+#[tracing::instrument(
+    name = "forward",
+    skip_all,
+    fields(otel.kind = "client", app = subdomain, canary = to_canary, upstream = tracing::field::Empty)
+)]
+async fn forward(
+    clients: Clients<'_>,
+    balancer: &Balancer,
+    idle: &IdleTracker,
+    container_settings: &ContainerSettings,
+    subdomain: &str,
+    upstream: AppUpstream,
+    to_canary: bool,
+    uri: axum::http::Uri,
+    mut req: Request<Body>,
+) -> Result<Response<Body>, (StatusCode, String)> {
+    let AppUpstream {
+        container,
+        port,
+        idles,
+        healthcheck_path,
+        canary,
+        h2c,
+        buffering,
+        response_timeout,
+        sticky,
+        compression,
+        request_limits,
+        ..
+    } = upstream;
+
+    let (container, port, replica, pin) = match canary {
+        Some(canary) if to_canary => (canary.container, canary.port, None, None),
+        _ if sticky => {
+            let (replica, pin) = balancer.pick_pinned(subdomain, &container, affinity_cookie(req.headers()).as_deref());
+            (container, port, replica, pin)
+        }
+        // replicas listen on the same port as the app container
+        _ => (container, port, balancer.pick(subdomain), None),
+    };
+    if sticky {
+        strip_affinity_cookie(req.headers_mut());
+    }
+    let idles = idles && !to_canary;
+    // a deploy retiring the container waits for the request until it is answered
+    let mut guard = Some(in_flight::track(replica.as_ref().map(|replica| replica.id.as_str()).unwrap_or(&container)));
+    let grpc = is_grpc(req.headers());
+
+    let ip_address = match &replica {
+        Some(replica) => replica.ip.clone(),
+        None => {
+            let address = orchestrator::driver()
+                .address(subdomain, &container)
+                .await
+                .map_err(|err| (StatusCode::BAD_GATEWAY, format!("Failed to find container: {err}")))?;
+
+            // an idle app was stopped, the request waits until it is started again
+            match address {
+                Some(address) => address,
+                None if idles => idle
+                    .wake(
+                        subdomain,
+                        &container,
+                        port,
+                        healthcheck_path.as_deref(),
+                        h2c,
+                        container_settings.healthtimeout,
+                    )
+                    .await
+                    .map_err(|err| (StatusCode::SERVICE_UNAVAILABLE, format!("Failed to start container: {err}")))?,
+                None => return Err((StatusCode::BAD_GATEWAY, "Container is not running".to_string())),
+            }
+        }
+    };
+
+    // the client side of a websocket is taken before the request moves on to the app. an
+    // h2c app can't be upgraded to, it only speaks http/2
+    let upgrade = (!h2c && websockets::is_upgrade(req.headers())).then(|| hyper::upgrade::on(&mut req));
+    let breach = limit_body(&mut req, &request_limits, grpc);
+
+    // caddy may talk to the platform in either version, the app gets the one it speaks
+    let (client, version) = match h2c {
+        true => (clients.1, Version::HTTP_2),
+        false => (clients.0, Version::HTTP_11),
+    };
+    // http/2 requests carry the host in the uri instead of a Host header
+    if let Some(authority) = uri.authority().filter(|_| !req.headers().contains_key(HOST)) {
+        let host = HeaderValue::from_str(authority.as_str()).unwrap();
+        req.headers_mut().insert(HOST, host);
+    }
+    let path = uri.path_and_query().map(|path| path.as_str()).unwrap_or("/");
+    let span = tracing::Span::current();
+    span.record("upstream", format!("{ip_address}:{port}").as_str());
+    telemetry::propagate_trace(&span, req.headers_mut());
+    let uri = format!("http://{}:{}{}", ip_address, port, path);
+    *req.uri_mut() = Uri::try_from(uri).unwrap();
+    *req.version_mut() = version;
+    let method = req.method().clone();
+    let accept_encoding = req.headers().get(ACCEPT_ENCODING).cloned();
+    let response = match response_timeout {
+        // only the headers have to come in time, a stream may go on for as long as it likes
+        Some(timeout) => tokio::time::timeout(timeout, client.request(req))
+            .await
+            .map_err(|_| {
+                let cause = format!("Container didn't answer in {} seconds", timeout.as_secs());
+                (StatusCode::GATEWAY_TIMEOUT, cause)
+            })?,
+        None => client.request(req).await,
+    };
+    match response {
+        Ok(mut res) => {
+            res.extensions_mut().insert(Upstream(format!("{ip_address}:{port}")));
+            if let Some(upgrade) = upgrade {
+                if res.status() == StatusCode::SWITCHING_PROTOCOLS {
+                    let timeout = Duration::from_secs(container_settings.upgradetimeout);
+                    let upgraded = hyper::upgrade::on(&mut res);
+                    let guard = guard.take().unwrap();
+                    websockets::tunnel(upgrade, upgraded, idle.clone(), guard, subdomain, timeout);
+                }
+            }
+
+            if let Some(pin) = &pin {
+                res.headers_mut().append(SET_COOKIE, affinity_set_cookie(pin));
+            }
+
+            if streaming::is_streaming(res.headers()) {
+                streaming::mark_streaming(&mut res);
+            } else if buffering && streaming::bufferable(&method, &res) {
+                res = streaming::buffer(res).await;
+            }
+            if compression {
+                res = compress(accept_encoding.as_ref(), &method, res);
+            }
+            match guard {
+                Some(guard) => Ok(in_flight::hold(res, guard, grpc)),
+                None => Ok(res),
+            }
+        }
+        Err(err) => {
+            // the client went over a limit, the container is fine
+            if let Some(res) = breach.response(&request_limits) {
+                return Ok(res);
+            }
+            if let Some(replica) = &replica {
+                balancer.mark_unhealthy(subdomain, &replica.id);
+            }
+
+            // a 5xx so the failure counts against the release in the error rate
+            Err((StatusCode::BAD_GATEWAY, format!("Failed request to container: {err}")))
+        }
+    }
+}
+
+/// Maps the request host to the project subdomain it serves, or an empty string for the
+/// platform itself. Hosts outside the platform domain are only routed to a project when they
+/// are a verified custom domain; any other host, like caddy asking about certificates on
+/// localhost, is handled by the platform
+async fn project_subdomain(pool: &PgPool, hostname: &str, domain: &str) -> String {
+    if hostname == domain || hostname.ends_with(&format!(".{domain}")) {
+        return hostname
+            .trim_end_matches(domain)
+            .trim_end_matches('.')
+            .to_string();
+    }
+
+    match sqlx::query!(
+        r#"SELECT domains.name
+           FROM custom_domains
+           JOIN domains ON domains.project_id = custom_domains.project_id
+           WHERE custom_domains.name = $1 AND custom_domains.verified_at IS NOT NULL
+        "#,
+        hostname
+    )
+    .fetch_optional(pool)
+    .await
+    {
+        Ok(Some(domain)) => domain.name,
+        Ok(None) => String::new(),
+        Err(err) => {
+            tracing::error!(?err, "Can't get custom domain: Failed to query database");
+            String::new()
+        }
+    }
+}
+
+const CRASH_LOOPING_PAGE: &str = r#"<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>This app keeps crashing</title></head>
+<body style="font-family: sans-serif; max-width: 40em; margin: 4em auto; padding: 0 1em">
+<h1>This app keeps crashing</h1>
+<p>It crashed several times in a row, so it is restarted with a growing delay. Its owners have been told.</p>
+<p>If it is yours, <code>pmk logs</code> and <code>pmk activity</code> show why it crashes. It is served again once it stays up for a few minutes, or right after the next deploy.</p>
+</body>
+</html>
+"#;
+
+const MAINTENANCE_PAGE: &str = r#"<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Down for maintenance</title></head>
+<body style="font-family: sans-serif; max-width: 40em; margin: 4em auto; padding: 0 1em">
+<h1>Down for maintenance</h1>
+<p>This app is being worked on and will be back shortly. Please try again in a few minutes.</p>
+</body>
+</html>
+"#;
+
+/// Where the proxy sends requests for an app when the balancer picks the app container
+struct AppUpstream {
+    container: String,
+    /// the port the app was told to listen on through $PORT
+    port: i32,
+    /// whether the app is stopped without traffic and woken by the next request
+    idles: bool,
+    healthcheck_path: Option<String>,
+    canary: Option<CanaryUpstream>,
+    internal: bool,
+    /// suspended by a platform admin, nothing is forwarded
+    suspended: bool,
+    /// keeps crashing, see [`crate::crashloop::CrashLoops`]
+    crash_looping: bool,
+    /// set with `pmk maintenance on`, nothing is forwarded but the containers keep running
+    maintenance: Option<Maintenance>,
+    error_page: ErrorPage,
+    /// talks http/2 without tls, like grpc servers
+    h2c: bool,
+    /// responses are read whole before they are sent on, see [`streaming::buffer`]
+    buffering: bool,
+    /// how long the app gets to send the headers of a response, None waits
+    response_timeout: Option<Duration>,
+    rate_limits: RateLimits,
+    /// how big request bodies may be and how long clients get to send them
+    request_limits: RequestLimits,
+    ip_access: IpAccess,
+    /// set with `pmk basic-auth on`, visitors log in before anything is forwarded
+    basic_auth: Option<BasicAuth>,
+    /// clients are kept on one replica with a cookie, see [`Balancer::pick_pinned`]
+    sticky: bool,
+    /// text responses are compressed for clients accepting it, see [`compress`]
+    compression: bool,
+    /// responses are kept by their Cache-Control, see [`ResponseCache`]
+    edge_cache: bool,
+    https: HttpsPolicy,
+    cors: CorsPolicy,
+    header_rules: HeaderRules,
+    /// None for apps without a domain yet, their requests aren't logged
+    project_id: Option<Uuid>,
+    /// percent of requests kept in the access log, see [`AccessLogger::sampled`]
+    access_log_sample: i32,
+    /// the files of a static site, answered without a container
+    site: Option<SiteRoot>,
+    /// what requests to the app are signed with, see [`proxy_signature::sign`]
+    proxy_secrets: Vec<String>,
+}
+
+struct Maintenance {
+    /// html of the owners, None serves [`MAINTENANCE_PAGE`]
+    page: Option<String>,
+    /// seconds
+    retry_after: i32,
+}
+
+/// A build running next to the live release on a share of the requests
+struct CanaryUpstream {
+    container: String,
+    port: i32,
+    /// percent of requests
+    weight: i32,
+}
+
+/// The container serving the subdomain and how to reach it. Deploys swap the container in
+/// the domains row once the new one is ready, which is what makes the switch atomic for the
+/// proxy. Without its row nothing is forwarded, the policies of the app are in it
+async fn upstream(
+    pool: &PgPool,
+    subdomain: &str,
+    container_settings: &ContainerSettings,
+) -> Result<AppUpstream, Response<Body>> {
+    match sqlx::query!(
+        r#"SELECT apps.port AS "port!", apps.container_id, apps.preview AS "preview!", projects.idle_timeout, projects.healthcheck_path,
+           projects.internal, canaries.container_id AS "canary_container_id?", canaries.port AS "canary_port?",
+           canaries.weight AS "canary_weight?", projects.suspended_at IS NOT NULL AS "suspended!",
+           projects.crash_looping_at IS NOT NULL AS "crash_looping!",
+           projects.maintenance_at IS NOT NULL AS "maintenance!", projects.maintenance_page,
+           projects.maintenance_retry_after, projects.error_page, projects.error_redirect, projects.protocol,
+           projects.response_buffering, projects.response_timeout, projects.rate_limit,
+           projects.ip_rate_limit, projects.rate_burst, projects.body_limit, projects.body_timeout,
+           projects.allowed_ips, projects.denied_ips, projects.basic_auth_username,
+           projects.basic_auth_password, projects.sticky_sessions,
+           projects.compression, projects.edge_cache, projects.https_redirect, projects.hsts_max_age,
+           projects.hsts_preload, projects.cors_origins, projects.cors_methods, projects.cors_headers,
+           projects.cors_credentials, projects.cors_max_age, projects.header_rules, projects.id AS project_id,
+           projects.access_log_sample, apps.site_root, apps.site_spa AS "site_spa!",
+           projects.proxy_secret, projects.proxy_secret_previous, projects.proxy_secret_rotated_at
+           FROM (
+               SELECT name, project_id, port, container_id, false AS preview, site_root, site_spa FROM domains
+               UNION ALL
+               SELECT name, project_id, port, container_id, true AS preview, NULL, false FROM previews
+           ) AS apps
+           JOIN projects ON projects.id = apps.project_id
+           LEFT JOIN canaries ON canaries.project_id = apps.project_id AND NOT apps.preview
+           WHERE apps.name = $1
+        "#,
+        subdomain
+    )
+    .fetch_optional(pool)
+    .await
+    {
+        Ok(Some(domain)) => Ok(AppUpstream {
+            // rows from before blue-green deploys only know the container by name
+            container: domain.container_id.unwrap_or_else(|| subdomain.to_string()),
+            port: domain.port,
+            // previews are torn down by their ttl, they don't idle
+            idles: domain.idle_timeout.is_some() && !domain.preview,
+            healthcheck_path: domain.healthcheck_path,
+            canary: match (domain.canary_container_id, domain.canary_port, domain.canary_weight) {
+                (Some(container), Some(port), Some(weight)) => Some(CanaryUpstream {
+                    container,
+                    port,
+                    weight,
+                }),
+                _ => None,
+            },
+            internal: domain.internal,
+            suspended: domain.suspended,
+            crash_looping: domain.crash_looping,
+            maintenance: domain.maintenance.then_some(Maintenance {
+                page: domain.maintenance_page,
+                retry_after: domain.maintenance_retry_after,
+            }),
+            error_page: ErrorPage {
+                html: domain.error_page,
+                redirect: domain.error_redirect,
+            },
+            h2c: domain.protocol == "h2c",
+            buffering: domain.response_buffering,
+            response_timeout: domain.response_timeout.map(|seconds| Duration::from_secs(seconds as u64)),
+            rate_limits: RateLimits::new(domain.rate_limit, domain.ip_rate_limit, domain.rate_burst, container_settings),
+            request_limits: RequestLimits::new(domain.body_limit, domain.body_timeout, container_settings),
+            ip_access: IpAccess::new(&domain.allowed_ips, &domain.denied_ips),
+            basic_auth: match (domain.basic_auth_username, domain.basic_auth_password) {
+                (Some(username), Some(password_hash)) => Some(BasicAuth {
+                    username,
+                    password_hash,
+                }),
+                _ => None,
+            },
+            sticky: domain.sticky_sessions,
+            compression: domain.compression,
+            edge_cache: domain.edge_cache,
+            https: HttpsPolicy {
+                redirect: domain.https_redirect,
+                hsts_max_age: domain.hsts_max_age,
+                hsts_preload: domain.hsts_preload,
+            },
+            cors: CorsPolicy {
+                origins: domain.cors_origins,
+                methods: domain.cors_methods,
+                headers: domain.cors_headers,
+                credentials: domain.cors_credentials,
+                max_age: domain.cors_max_age,
+            },
+            // checked when they were stored
+            header_rules: serde_json::from_value(domain.header_rules).unwrap_or_default(),
+            project_id: Some(domain.project_id),
+            access_log_sample: domain.access_log_sample,
+            site: domain.site_root.map(|root| SiteRoot {
+                root: root.into(),
+                spa: domain.site_spa,
+            }),
+            proxy_secrets: signing_secrets(
+                domain.proxy_secret,
+                domain.proxy_secret_previous,
+                domain.proxy_secret_rotated_at,
+            ),
+        }),
+        // the domain is recorded right after the first deploy finishes
+        Ok(None) => Err(Response::builder()
+            .status(StatusCode::NOT_FOUND)
+            .header("Content-Type", "text/plain; charset=utf-8")
+            .body(Body::from("This app doesn't exist or wasn't deployed yet"))
+            .unwrap()),
+        // forwarding without the row would skip its basic auth, ip rules and suspension
+        Err(err) => {
+            tracing::error!(?err, "Can't get domain upstream: Failed to query database");
+            Err(Response::builder()
+                .status(StatusCode::SERVICE_UNAVAILABLE)
+                .header("Content-Type", "text/plain; charset=utf-8")
+                .header("Retry-After", "5")
+                .body(Body::from("The platform can't reach this app right now, try again in a moment"))
+                .unwrap())
+        }
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::is_profile_path;
+
+    #[test]
+    fn profile_paths_are_matched_decoded_and_normalized() {
+        for path in [
+            "/debug/pprof",
+            "/debug/pprof/",
+            "/debug/pprof/heap",
+            "/debug/%70prof/heap",
+            "/debug/%70%70rof/heap",
+            "/debug%2Fpprof/cmdline",
+            "/debug%2fpprof/cmdline",
+            "/%64ebug/pprof/goroutine",
+            "//debug//pprof/heap",
+            "/./debug/./pprof/heap",
+            "/static/../debug/pprof/heap",
+            "/static/%2E%2E/debug/pprof/heap",
+            "/../debug/pprof/heap",
+        ] {
+            assert!(is_profile_path(path), "{path} should be blocked");
+        }
+    }
+
+    #[test]
+    fn other_paths_are_left_alone() {
+        for path in ["/", "/debug", "/debug/vars", "/api/debug/pprof-guide", "/debug/pprof/../vars", "/%zz/pprof"] {
+            assert!(!is_profile_path(path), "{path} should be passed to the app");
+        }
     }
 }
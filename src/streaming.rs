@@ -0,0 +1,73 @@
+use bytes::Bytes;
+use futures::StreamExt;
+use hyper::body::HttpBody;
+use hyper::header::{HeaderMap, HeaderValue, CACHE_CONTROL, CONTENT_LENGTH, CONTENT_TYPE, TRANSFER_ENCODING};
+use hyper::{Body, Method, Response, StatusCode};
+
+/// most of a buffered response held in memory, the rest of a bigger one streams
+const BUFFER_LIMIT: usize = 8 * 1024 * 1024;
+
+/// responses sent as a stream of events. They are never buffered, and the proxies in front
+/// are asked to pass on every chunk as it comes
+const STREAMING_TYPES: [&str; 4] = [
+    "text/event-stream",
+    "application/grpc",
+    "application/x-ndjson",
+    "application/stream+json",
+];
+
+pub fn is_streaming(headers: &HeaderMap) -> bool {
+    headers
+        .get(CONTENT_TYPE)
+        .and_then(|value| value.to_str().ok())
+        .is_some_and(|value| STREAMING_TYPES.iter().any(|streaming| value.starts_with(streaming)))
+}
+
+/// Whether a response to a `method` request can be read whole before it is sent on. Streams,
+/// responses without a body and switched protocols can't
+pub fn bufferable(method: &Method, res: &Response<Body>) -> bool {
+    let no_body = matches!(
+        res.status(),
+        StatusCode::SWITCHING_PROTOCOLS | StatusCode::NO_CONTENT | StatusCode::NOT_MODIFIED
+    );
+    *method != Method::HEAD && !no_body && !is_streaming(res.headers())
+}
+
+/// Keeps proxies and caches in front of the platform from holding on to a stream
+pub fn mark_streaming(res: &mut Response<Body>) {
+    let headers = res.headers_mut();
+    headers.insert("X-Accel-Buffering", HeaderValue::from_static("no"));
+    if !headers.contains_key(CACHE_CONTROL) {
+        headers.insert(CACHE_CONTROL, HeaderValue::from_static("no-cache"));
+    }
+}
+
+/// Reads the response of the app before it is sent on, so a slow client doesn't keep the app
+/// busy. A response bigger than [`BUFFER_LIMIT`] sends what was read and streams the rest
+pub async fn buffer(res: Response<Body>) -> Response<Body> {
+    let (mut parts, mut body) = res.into_parts();
+
+    let mut buffered = Vec::new();
+    while let Some(chunk) = body.data().await {
+        match chunk {
+            Ok(chunk) => buffered.extend_from_slice(&chunk),
+            Err(err) => {
+                // the client gets the response cut off like it would without buffering
+                tracing::warn!(?err, "Can't buffer response: Failed to read body");
+                let chunks = [Ok(Bytes::from(buffered)), Err(err)];
+                return Response::from_parts(parts, Body::wrap_stream(futures::stream::iter(chunks)));
+            }
+        }
+
+        if buffered.len() > BUFFER_LIMIT {
+            let head = futures::stream::once(async move { Ok(Bytes::from(buffered)) });
+            return Response::from_parts(parts, Body::wrap_stream(head.chain(body)));
+        }
+    }
+
+    if !parts.headers.contains_key(CONTENT_LENGTH) {
+        parts.headers.remove(TRANSFER_ENCODING);
+        parts.headers.insert(CONTENT_LENGTH, HeaderValue::from(buffered.len()));
+    }
+    Response::from_parts(parts, Body::from(buffered))
+}
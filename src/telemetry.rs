@@ -1,7 +1,17 @@
 use std::io::{self, Empty, Stderr, StderrLock, Stdout, StdoutLock};
 
 use config::Config;
-use tracing::{Level, Metadata};
+use hyper::HeaderMap;
+use opentelemetry::propagation::TextMapPropagator;
+use opentelemetry::trace::TraceError;
+use opentelemetry::{global, KeyValue};
+use opentelemetry_http::{HeaderExtractor, HeaderInjector};
+use opentelemetry_otlp::WithExportConfig;
+use opentelemetry_sdk::propagation::TraceContextPropagator;
+use opentelemetry_sdk::trace::{self as sdktrace, Sampler};
+use opentelemetry_sdk::{runtime, Resource};
+use tracing::{Level, Metadata, Span};
+use tracing_opentelemetry::OpenTelemetrySpanExt;
 
 use tower_http::{
     classify::{ServerErrorsAsFailures, SharedClassifier},
@@ -10,7 +20,7 @@ use tower_http::{
 use tracing_subscriber::{
     filter::LevelFilter,
     fmt::{writer::MakeWriterExt, MakeWriter},
-    EnvFilter,
+    EnvFilter, Layer,
 };
 use tracing_subscriber::{layer::SubscriberExt, util::SubscriberInitExt};
 
@@ -86,13 +96,38 @@ impl<'a> MakeWriter<'a> for LogRecorder {
     }
 }
 pub fn init_tracing() {
-    let log_dev = Config::builder()
+    let config = Config::builder()
         .add_source(config::File::with_name("configuration"))
         .add_source(config::Environment::default().separator("_"))
         .build()
+        .ok();
+    let log_dev = config
+        .as_ref()
         .map(|c| c.get_bool("log.dev").unwrap_or(false))
         .unwrap_or(false);
 
+    // spans are exported to an otlp collector like tempo or jaeger once it is configured, the
+    // debug ones stay in the logs
+    let otel = config.as_ref().and_then(|c| {
+        let endpoint = c.get_string("otel.endpoint").ok().filter(|endpoint| !endpoint.is_empty())?;
+        let service = c
+            .get_string("otel.servicename")
+            .unwrap_or_else(|_| "pemasak-infra".to_string());
+        let ratio = c.get_float("otel.sampleratio").unwrap_or(1.0);
+        match otlp_tracer(&endpoint, &service, ratio) {
+            Ok(tracer) => Some(
+                tracing_opentelemetry::layer()
+                    .with_tracer(tracer)
+                    .with_filter(LevelFilter::INFO),
+            ),
+            Err(err) => {
+                // nothing logs yet
+                eprintln!("Failed to create otlp exporter for {endpoint}: {err}");
+                None
+            }
+        }
+    });
+
     let filter = EnvFilter::try_from_default_env()
         .unwrap_or_else(|_| "debug".into())
         .max_level_hint();
@@ -109,14 +144,18 @@ pub fn init_tracing() {
     if let Some(level) = level {
         match log_dev {
             true => {
-                tracing_subscriber::fmt()
-                    .pretty()
-                    .with_max_level(level)
-                    .with_writer(LogRecorder::new())
+                tracing_subscriber::registry()
+                    .with(otel)
+                    .with(
+                        tracing_subscriber::fmt::layer()
+                            .pretty()
+                            .with_writer(LogRecorder::new().with_max_level(level)),
+                    )
                     .init();
             }
             false => {
                 tracing_subscriber::registry()
+                    .with(otel)
                     .with(LevelFilter::TRACE)
                     // .with(tracing_bunyan_formatter::JsonStorageLayer)
                     // .with(
@@ -141,6 +180,45 @@ pub fn init_tracing() {
     }
 }
 
+/// Exports spans over otlp/http to `endpoint`, like `http://localhost:4318`. A trace that came
+/// in sampled stays sampled, the others are kept at `ratio`
+fn otlp_tracer(endpoint: &str, service: &str, ratio: f64) -> Result<sdktrace::Tracer, TraceError> {
+    global::set_text_map_propagator(TraceContextPropagator::new());
+
+    opentelemetry_otlp::new_pipeline()
+        .tracing()
+        .with_exporter(opentelemetry_otlp::new_exporter().http().with_endpoint(endpoint))
+        .with_trace_config(
+            sdktrace::config()
+                .with_sampler(Sampler::ParentBased(Box::new(Sampler::TraceIdRatioBased(ratio))))
+                .with_resource(Resource::new([KeyValue::new("service.name", service.to_string())])),
+        )
+        .install_batch(runtime::Tokio)
+}
+
+/// Makes `span` part of the trace the `traceparent` in `headers` belongs to, a request without
+/// one starts a new trace. Nothing happens without an exporter
+pub fn continue_trace(span: &Span, headers: &HeaderMap) {
+    let parent = global::get_text_map_propagator(|propagator| propagator.extract(&HeaderExtractor(headers)));
+    span.set_parent(parent);
+}
+
+/// Sends the trace of `span` along in the `traceparent` of `headers`, so the spans of an app
+/// end up under the platform's. Without an exporter the headers are left as they are, a
+/// `traceparent` of the client reaches the app untouched
+pub fn propagate_trace(span: &Span, headers: &mut HeaderMap) {
+    let context = span.context();
+    global::get_text_map_propagator(|propagator| {
+        propagator.inject_context(&context, &mut HeaderInjector(headers))
+    });
+}
+
+/// The trace of whatever is running, to carry over to a task that runs later, like a queued
+/// build
+pub fn current_context() -> opentelemetry::Context {
+    Span::current().context()
+}
+
 pub fn http_trace_layer() -> TraceLayer<SharedClassifier<ServerErrorsAsFailures>> {
     TraceLayer::new_for_http()
         .make_span_with(DefaultMakeSpan::new().level(Level::INFO))
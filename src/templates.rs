@@ -0,0 +1,507 @@
+use std::fs;
+use std::path::{Path, PathBuf};
+
+use anyhow::{anyhow, Result};
+use ulid::Ulid;
+
+use crate::uploads::{commit_files, Author, UploadError};
+
+/// A starter app the platform ships with. Every one builds with a buildpack, listens on
+/// $PORT and answers /healthz, which its pemasak.toml checks
+#[derive(Debug)]
+pub struct Builtin {
+    pub name: &'static str,
+    pub description: &'static str,
+    pub files: &'static [(&'static str, &'static str)],
+}
+
+pub const BUILTINS: [Builtin; 5] = [
+    Builtin {
+        name: "go-http",
+        description: "Go HTTP server with net/http",
+        files: &[
+            ("go.mod", GO_MOD),
+            ("main.go", GO_MAIN),
+            ("pemasak.toml", MANIFEST),
+        ],
+    },
+    Builtin {
+        name: "go-postgres",
+        description: "Go HTTP server with a pgx pool and migrations on postgres",
+        files: &[
+            ("go.mod", GO_PG_MOD),
+            ("go.sum", GO_PG_SUM),
+            ("main.go", GO_PG_MAIN),
+            ("migrations/001_create_visits.sql", GO_PG_MIGRATION),
+            ("pemasak.toml", POSTGRES_MANIFEST),
+        ],
+    },
+    Builtin {
+        name: "nextjs",
+        description: "Next.js app with the pages router",
+        files: &[
+            ("package.json", NEXT_PACKAGE),
+            ("pages/index.js", NEXT_INDEX),
+            ("pages/api/healthz.js", NEXT_HEALTHZ),
+            ("pemasak.toml", MANIFEST),
+            (".gitignore", "node_modules/\n.next/\n"),
+        ],
+    },
+    Builtin {
+        name: "flask",
+        description: "Python Flask app served by gunicorn",
+        files: &[
+            ("requirements.txt", "flask==3.0.0\ngunicorn==21.2.0\n"),
+            ("app.py", FLASK_APP),
+            ("pemasak.toml", MANIFEST),
+            (".gitignore", "__pycache__/\n.venv/\n"),
+        ],
+    },
+    Builtin {
+        name: "rust-axum",
+        description: "Rust HTTP server with axum and tokio",
+        files: &[
+            ("Cargo.toml", AXUM_CARGO),
+            ("src/main.rs", AXUM_MAIN),
+            ("pemasak.toml", MANIFEST),
+            (".gitignore", "/target\n"),
+        ],
+    },
+];
+
+const MANIFEST: &str = r#"healthcheck = "/healthz"
+"#;
+
+/// the addon comes with the first deploy, see crate::manifest
+const POSTGRES_MANIFEST: &str = r#"healthcheck = "/healthz"
+addons = ["postgres"]
+"#;
+
+const GO_MOD: &str = r#"module app
+
+go 1.21
+"#;
+
+const GO_MAIN: &str = r#"package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+)
+
+func main() {
+	port := os.Getenv("PORT")
+	if port == "" {
+		port = "8080"
+	}
+
+	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "Hello from pemasak!")
+	})
+	http.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	log.Printf("listening on :%s", port)
+	log.Fatal(http.ListenAndServe(":"+port, nil))
+}
+"#;
+
+/// the database is opened once on boot and shared, students tend to open one per request
+const GO_PG_MAIN: &str = r#"package main
+
+import (
+	"context"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"os/signal"
+	"sort"
+	"syscall"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+//go:embed migrations/*.sql
+var migrations embed.FS
+
+func main() {
+	port := os.Getenv("PORT")
+	if port == "" {
+		port = "8080"
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+
+	// one pool for the whole app, made once on boot and shared by every handler
+	config, err := pgxpool.ParseConfig(os.Getenv("DATABASE_URL"))
+	if err != nil {
+		log.Fatalf("invalid DATABASE_URL: %v", err)
+	}
+	// stay well under the connection limit of the addon, every replica has its own pool
+	config.MaxConns = 5
+	config.MaxConnIdleTime = 5 * time.Minute
+	config.HealthCheckPeriod = 30 * time.Second
+
+	pool, err := connect(ctx, config)
+	if err != nil {
+		log.Fatalf("can't reach the database: %v", err)
+	}
+	defer pool.Close()
+
+	if err := migrate(ctx, pool); err != nil {
+		log.Fatalf("can't migrate the database: %v", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		// the request context cancels the query when the visitor goes away
+		if _, err := pool.Exec(r.Context(), "INSERT INTO visits (path) VALUES ($1)", r.URL.Path); err != nil {
+			log.Printf("can't record visit: %v", err)
+			http.Error(w, "database unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		var visits int64
+		if err := pool.QueryRow(r.Context(), "SELECT count(*) FROM visits").Scan(&visits); err != nil {
+			log.Printf("can't count visits: %v", err)
+			http.Error(w, "database unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintf(w, "Hello from pemasak! %d visits so far\n", visits)
+	})
+	// the app itself is up, the platform restarts it when this fails
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	// whether the database answers, without taking the app down when it doesn't
+	mux.HandleFunc("/db-check", func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), 2*time.Second)
+		defer cancel()
+		if err := pool.Ping(ctx); err != nil {
+			http.Error(w, "database unavailable: "+err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		stat := pool.Stat()
+		fmt.Fprintf(w, "ok, %d of %d connections in use\n", stat.AcquiredConns(), stat.MaxConns())
+	})
+	// profiles for `pmk pprof`, the platform never lets visitors reach them
+	if os.Getenv("PPROF") == "1" {
+		mux.HandleFunc("/debug/pprof/", pprof.Index)
+		mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+		mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	}
+
+	server := &http.Server{Addr: ":" + port, Handler: mux}
+	go func() {
+		<-ctx.Done()
+		// finish the requests in flight before the pool closes
+		shutdown, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		server.Shutdown(shutdown)
+	}()
+
+	log.Printf("listening on :%s", port)
+	if err := server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		log.Fatal(err)
+	}
+}
+
+// connect retries for a while, the database may still be starting next to the app
+func connect(ctx context.Context, config *pgxpool.Config) (*pgxpool.Pool, error) {
+	pool, err := pgxpool.NewWithConfig(ctx, config)
+	if err != nil {
+		return nil, err
+	}
+	for attempt := 1; ; attempt++ {
+		err = pool.Ping(ctx)
+		if err == nil || attempt == 10 {
+			break
+		}
+		log.Printf("database not ready, retrying: %v", err)
+		time.Sleep(time.Duration(attempt) * time.Second)
+	}
+	if err != nil {
+		pool.Close()
+		return nil, err
+	}
+	return pool, nil
+}
+
+// migrate applies the files in migrations/ that haven't run yet, in name order. The lock
+// keeps replicas starting at the same time from running them twice
+func migrate(ctx context.Context, pool *pgxpool.Pool) error {
+	conn, err := pool.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Release()
+
+	if _, err := conn.Exec(ctx, "SELECT pg_advisory_lock(7270)"); err != nil {
+		return err
+	}
+	defer conn.Exec(context.Background(), "SELECT pg_advisory_unlock(7270)")
+
+	_, err = conn.Exec(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		name TEXT PRIMARY KEY,
+		applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	)`)
+	if err != nil {
+		return err
+	}
+
+	names, err := fs.Glob(migrations, "migrations/*.sql")
+	if err != nil {
+		return err
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		var applied bool
+		err := conn.QueryRow(ctx, "SELECT EXISTS(SELECT 1 FROM schema_migrations WHERE name = $1)", name).Scan(&applied)
+		if err != nil {
+			return err
+		}
+		if applied {
+			continue
+		}
+
+		sql, err := migrations.ReadFile(name)
+		if err != nil {
+			return err
+		}
+		tx, err := conn.Begin(ctx)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(ctx, string(sql)); err != nil {
+			tx.Rollback(ctx)
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		if _, err := tx.Exec(ctx, "INSERT INTO schema_migrations (name) VALUES ($1)", name); err != nil {
+			tx.Rollback(ctx)
+			return err
+		}
+		if err := tx.Commit(ctx); err != nil {
+			return err
+		}
+		log.Printf("applied %s", name)
+	}
+	return nil
+}
+"#;
+
+const GO_PG_MOD: &str = r#"module app
+
+go 1.21
+
+require github.com/jackc/pgx/v5 v5.5.0
+
+require (
+	github.com/jackc/pgpassfile v1.0.0 // indirect
+	github.com/jackc/pgservicefile v0.0.0-20221227161230-091c0ba34f0a // indirect
+	github.com/jackc/puddle/v2 v2.2.1 // indirect
+	golang.org/x/crypto v0.9.0 // indirect
+	golang.org/x/sync v0.1.0 // indirect
+	golang.org/x/text v0.9.0 // indirect
+)
+"#;
+
+const GO_PG_SUM: &str = r#"github.com/davecgh/go-spew v1.1.0/go.mod h1:J7Y8YcW2NihsgmVo/mv3lAwl/skON4iLHjSsI+c5H38=
+github.com/davecgh/go-spew v1.1.1 h1:vj9j/u1bqnvCEfJOwUhtlOARqs3+rkHYY13jYWTU97c=
+github.com/davecgh/go-spew v1.1.1/go.mod h1:J7Y8YcW2NihsgmVo/mv3lAwl/skON4iLHjSsI+c5H38=
+github.com/jackc/pgpassfile v1.0.0 h1:/6Hmqy13Ss2zCq62VdNG8tM1wchn8zjSGOBJ6icpsIM=
+github.com/jackc/pgpassfile v1.0.0/go.mod h1:CEx0iS5ambNFdcRtxPj5JhEz+xB6uRky5eyVu/W2HEg=
+github.com/jackc/pgservicefile v0.0.0-20221227161230-091c0ba34f0a h1:bbPeKD0xmW/Y25WS6cokEszi5g+S0QxI/d45PkRi7Nk=
+github.com/jackc/pgservicefile v0.0.0-20221227161230-091c0ba34f0a/go.mod h1:5TJZWKEWniPve33vlWYSoGYefn3gLQRzjfDlhSJ9ZKM=
+github.com/jackc/pgx/v5 v5.5.0 h1:NxstgwndsTRy7eq9/kqYc/BZh5w2hHJV86wjvO+1xPw=
+github.com/jackc/pgx/v5 v5.5.0/go.mod h1:Ig06C2Vu0t5qXC60W8sqIthScaEnFvojjj9dSljmHRA=
+github.com/jackc/puddle/v2 v2.2.1 h1:RhxXJtFG022u4ibrCSMSiu5aOq1i77R3OHKNJj77OAk=
+github.com/jackc/puddle/v2 v2.2.1/go.mod h1:vriiEXHvEE654aYKXXjOvZM39qJ0q+azkZFrfEOc3H4=
+github.com/pmezard/go-difflib v1.0.0 h1:4DBwDE0NGyQoBHbLQYPwSUPoCMWR5BEzIk/f1lZbAQM=
+github.com/pmezard/go-difflib v1.0.0/go.mod h1:iKH77koFhYxTK1pcRnkKkqfTogsbg7gZNVY4sRDYZ/4=
+github.com/stretchr/objx v0.1.0/go.mod h1:HFkY916IF+rwdDfMAkV7OtwuqBVzrE8GR6GFx+wExME=
+github.com/stretchr/testify v1.3.0/go.mod h1:M5WIy9Dh21IEIfnGCwXGc5bZfKNJtfHm1UVUgZn+9EI=
+github.com/stretchr/testify v1.7.0/go.mod h1:6Fq8oRcR53rry900zMqJjRRixrwX3KX962/h/Wwjteg=
+github.com/stretchr/testify v1.8.1 h1:w7B6lhMri9wdJUVmEZPGGhZzrYTPvgJArz7wNPgYKsk=
+github.com/stretchr/testify v1.8.1/go.mod h1:w2LPCIKwWwSfY2zedu0+kehJoqGctiVI29o6fzry7u4=
+golang.org/x/crypto v0.9.0 h1:LF6fAI+IutBocDJ2OT0Q1g8plpYljMZ4+lty+dsqw3g=
+golang.org/x/crypto v0.9.0/go.mod h1:yrmDGqONDYtNj3tH8X9dzUun2m2lzPa9ngI6/RUPGR0=
+golang.org/x/sync v0.1.0 h1:wsuoTGHzEhffawBOhz5CYhcrV4IdKZbEyZjBMuTp12o=
+golang.org/x/sync v0.1.0/go.mod h1:RxMgew5VJxzue5/jJTE5uejpjVlOe/izrB70Jof72aM=
+golang.org/x/text v0.9.0 h1:2sjJmO8cDvYveuX97RDLsxlyUxLl+GHoLxBiRdHllBE=
+golang.org/x/text v0.9.0/go.mod h1:e1OnstbJyHTd6l/uOt8jFFHp6TRDWZR/bV3emEE/zU8=
+gopkg.in/check.v1 v0.0.0-20161208181325-20d25e280405/go.mod h1:Co6ibVJAznAaIkqp8huTwlJQCZ016jof/cbN4VW5Yz0=
+gopkg.in/yaml.v3 v3.0.0-20200313102051-9f266ea9e77c/go.mod h1:K4uyk7z7BCEPqu6E+C64Yfv1cQ7kz7rIZviUmN+EgEM=
+gopkg.in/yaml.v3 v3.0.1 h1:fxVm/GzAzEWqLHuvctI91KS9hhNmmWOoWu0XTYJS7CA=
+gopkg.in/yaml.v3 v3.0.1/go.mod h1:K4uyk7z7BCEPqu6E+C64Yfv1cQ7kz7rIZviUmN+EgEM=
+"#;
+
+const GO_PG_MIGRATION: &str = r#"CREATE TABLE visits (
+  id BIGSERIAL PRIMARY KEY,
+  path TEXT NOT NULL,
+  visited_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+"#;
+
+const NEXT_PACKAGE: &str = r#"{
+  "name": "app",
+  "private": true,
+  "scripts": {
+    "dev": "next dev",
+    "build": "next build",
+    "start": "next start"
+  },
+  "dependencies": {
+    "next": "14.0.3",
+    "react": "18.2.0",
+    "react-dom": "18.2.0"
+  },
+  "engines": {
+    "node": ">=20"
+  }
+}
+"#;
+
+const NEXT_INDEX: &str = r#"export default function Home() {
+  return <h1>Hello from pemasak!</h1>;
+}
+"#;
+
+const NEXT_HEALTHZ: &str = r#"export default function handler(req, res) {
+  res.status(200).end();
+}
+"#;
+
+const FLASK_APP: &str = r#"from flask import Flask
+
+app = Flask(__name__)
+
+
+@app.get("/")
+def index():
+    return "Hello from pemasak!"
+
+
+@app.get("/healthz")
+def healthz():
+    return "", 200
+
+
+if __name__ == "__main__":
+    import os
+
+    app.run(host="0.0.0.0", port=int(os.environ.get("PORT", 8080)))
+"#;
+
+const AXUM_CARGO: &str = r#"[package]
+name = "app"
+version = "0.1.0"
+edition = "2021"
+
+[dependencies]
+axum = "0.7"
+tokio = { version = "1", features = ["full"] }
+"#;
+
+const AXUM_MAIN: &str = r#"use axum::{routing::get, Router};
+
+#[tokio::main]
+async fn main() {
+    let port = std::env::var("PORT").unwrap_or_else(|_| "8080".to_string());
+
+    let app = Router::new()
+        .route("/", get(|| async { "Hello from pemasak!" }))
+        .route("/healthz", get(|| async { "" }));
+
+    let listener = tokio::net::TcpListener::bind(format!("0.0.0.0:{port}")).await.unwrap();
+    println!("listening on :{port}");
+    axum::serve(listener, app).await.unwrap();
+}
+"#;
+
+pub fn builtin(name: &str) -> Option<&'static Builtin> {
+    BUILTINS.iter().find(|template| template.name == name)
+}
+
+/// Where the files of a template come from
+#[derive(Debug)]
+pub enum Source {
+    Builtin(&'static Builtin),
+    /// a template an owner registered, the files where the branch HEAD of the app points
+    App { repo_path: String },
+}
+
+/// Writes the files of the template into `dest`. Only the files of an app go, not its
+/// history
+fn write(source: &Source, dest: &Path) -> Result<()> {
+    fs::create_dir_all(dest)?;
+
+    match source {
+        Source::Builtin(template) => {
+            for (path, contents) in template.files {
+                let path = dest.join(path);
+                if let Some(parent) = path.parent() {
+                    fs::create_dir_all(parent)?;
+                }
+                fs::write(path, contents)?;
+            }
+        }
+        Source::App { repo_path } => {
+            let repo = git2::Repository::open_bare(repo_path)?;
+            let tree = repo
+                .head()
+                .and_then(|head| head.peel_to_tree())
+                .map_err(|_| anyhow!("The app of the template was never pushed to"))?;
+
+            let mut checkout = git2::build::CheckoutBuilder::new();
+            checkout.target_dir(dest).update_index(false).force();
+            repo.checkout_tree(tree.as_object(), Some(&mut checkout))?;
+        }
+    }
+
+    Ok(())
+}
+
+/// Whether nothing was pushed to the repository yet, templates only start new apps
+pub fn is_empty(repo_path: &str) -> Result<bool, git2::Error> {
+    git2::Repository::open_bare(repo_path)?.is_empty()
+}
+
+/// Commits the files of the template as the first commit of the repository and brings the
+/// checkout the builder reads to it, after that it builds like a push. Returns the commit
+pub async fn scaffold(
+    source: Source,
+    repo_path: &str,
+    container_src: &str,
+    author: Author,
+    message: String,
+) -> Result<String, UploadError> {
+    let staging = PathBuf::from(format!("{repo_path}/template-{}", Ulid::new()));
+
+    let written = tokio::task::spawn_blocking({
+        let staging = staging.clone();
+        move || write(&source, &staging)
+    })
+    .await;
+
+    let result = match written {
+        Ok(Ok(())) => commit_files(staging.clone(), repo_path, container_src, author, message).await,
+        Ok(Err(err)) => Err(UploadError::Commit(err)),
+        Err(err) => Err(UploadError::Commit(err.into())),
+    };
+
+    if let Err(err) = fs::remove_dir_all(&staging) {
+        tracing::warn!(?err, ?staging, "Can't remove scaffolded template");
+    }
+
+    result
+}
@@ -0,0 +1,220 @@
+use std::fs::{self, File};
+use std::io::{self, Cursor, Read};
+use std::path::{Path, PathBuf};
+
+use anyhow::{anyhow, Result};
+use bytes::Bytes;
+use flate2::read::GzDecoder;
+use thiserror::Error;
+use ulid::Ulid;
+
+use crate::linked_repos::sync_checkout;
+
+/// unpacking stops past this many bytes, a small archive can expand to a lot
+const MAX_UNPACKED_SIZE: u64 = 2 * 1024 * 1024 * 1024;
+
+#[derive(Error, Debug)]
+pub enum UploadError {
+    /// the upload itself is wrong, the user can fix it
+    #[error("Invalid archive: {0}")]
+    Archive(anyhow::Error),
+    #[error("Failed to commit upload: {0}")]
+    Commit(anyhow::Error),
+    #[error("Failed to update checkout: {0}")]
+    Checkout(anyhow::Error),
+}
+
+/// The author of an upload commit
+#[derive(Debug, Clone)]
+pub struct Author {
+    pub name: String,
+    pub email: String,
+}
+
+/// Commits the files of an uploaded archive to the deploy branch of the repository and
+/// brings the checkout the builder reads to it, after that the upload builds like a push.
+/// Returns the commit
+pub async fn commit_upload(
+    archive: Bytes,
+    repo_path: &str,
+    container_src: &str,
+    author: Author,
+    message: String,
+) -> Result<String, UploadError> {
+    let staging = format!("{repo_path}/upload-{}", Ulid::new());
+
+    let result = tokio::task::spawn_blocking({
+        let repo_path = repo_path.to_string();
+        let staging = staging.clone();
+
+        move || {
+            let root = unpack(&archive, Path::new(&staging)).map_err(UploadError::Archive)?;
+            commit(&repo_path, &root, &author, &message).map_err(UploadError::Commit)
+        }
+    })
+    .await;
+
+    if let Err(err) = fs::remove_dir_all(&staging) {
+        tracing::warn!(?err, staging, "Can't remove unpacked upload");
+    }
+
+    let (branch, commit) = match result {
+        Ok(result) => result?,
+        Err(err) => return Err(UploadError::Commit(err.into())),
+    };
+
+    // fetched from the repository like a pushed commit, so a later push continues from it
+    sync_checkout(container_src, "upload", repo_path, &branch, None)
+        .await
+        .map_err(UploadError::Checkout)?;
+
+    Ok(commit)
+}
+
+/// Commits everything under `root` the same way, for sources the platform writes itself like
+/// templates. `root` is left for the caller to remove
+pub async fn commit_files(
+    root: PathBuf,
+    repo_path: &str,
+    container_src: &str,
+    author: Author,
+    message: String,
+) -> Result<String, UploadError> {
+    let result = tokio::task::spawn_blocking({
+        let repo_path = repo_path.to_string();
+        move || commit(&repo_path, &root, &author, &message).map_err(UploadError::Commit)
+    })
+    .await;
+
+    let (branch, commit) = match result {
+        Ok(result) => result?,
+        Err(err) => return Err(UploadError::Commit(err.into())),
+    };
+
+    sync_checkout(container_src, "upload", repo_path, &branch, None)
+        .await
+        .map_err(UploadError::Checkout)?;
+
+    Ok(commit)
+}
+
+/// Unpacks a .tar.gz, .tar or .zip, told apart by their first bytes, into `dest` and
+/// returns the directory the source is in. Archives made of a folder have everything in one
+/// directory, that directory is the source then
+fn unpack(archive: &[u8], dest: &Path) -> Result<PathBuf> {
+    fs::create_dir_all(dest)?;
+
+    match archive {
+        [0x1f, 0x8b, ..] => unpack_tar(GzDecoder::new(archive), dest)?,
+        [b'P', b'K', 0x03, 0x04, ..] | [b'P', b'K', 0x05, 0x06, ..] => unpack_zip(archive, dest)?,
+        _ if archive.get(257..262) == Some(b"ustar".as_slice()) => unpack_tar(archive, dest)?,
+        _ => return Err(anyhow!("Upload a .tar.gz, .tar or .zip archive")),
+    }
+
+    let mut entries = fs::read_dir(dest)?.collect::<io::Result<Vec<_>>>()?;
+    match entries.as_slice() {
+        [] => Err(anyhow!("Archive is empty")),
+        [entry] if entry.file_type()?.is_dir() => Ok(entries.remove(0).path()),
+        _ => Ok(dest.to_path_buf()),
+    }
+}
+
+/// Only files and directories are unpacked, a link could point the build at files outside
+/// the archive
+fn unpack_tar(reader: impl Read, dest: &Path) -> Result<()> {
+    let mut archive = tar::Archive::new(reader);
+    let mut unpacked = 0;
+
+    for entry in archive.entries()? {
+        let mut entry = entry?;
+        let path = entry.path()?.display().to_string();
+
+        match entry.header().entry_type() {
+            tar::EntryType::Regular | tar::EntryType::Continuous | tar::EntryType::Directory => {}
+            // pax headers of the whole archive, like the one git archive writes
+            tar::EntryType::XGlobalHeader => continue,
+            _ => return Err(anyhow!("{path} is not a file or directory")),
+        }
+
+        unpacked += entry.size();
+        if unpacked > MAX_UNPACKED_SIZE {
+            return Err(anyhow!("Archive unpacks to more than {MAX_UNPACKED_SIZE} bytes"));
+        }
+
+        if !entry.unpack_in(dest)? {
+            return Err(anyhow!("{path} is outside the archive"));
+        }
+    }
+
+    Ok(())
+}
+
+fn unpack_zip(archive: &[u8], dest: &Path) -> Result<()> {
+    let mut archive = zip::ZipArchive::new(Cursor::new(archive))?;
+    let mut unpacked = 0;
+
+    for i in 0..archive.len() {
+        let mut file = archive.by_index(i)?;
+        let path = match file.enclosed_name() {
+            Some(path) => dest.join(path),
+            None => return Err(anyhow!("{} is outside the archive", file.name())),
+        };
+
+        if file.is_dir() {
+            fs::create_dir_all(&path)?;
+            continue;
+        }
+
+        let mode = file.unix_mode();
+        if mode.is_some_and(|mode| mode & 0o170000 == 0o120000) {
+            return Err(anyhow!("{} is not a file or directory", file.name()));
+        }
+
+        unpacked += file.size();
+        if unpacked > MAX_UNPACKED_SIZE {
+            return Err(anyhow!("Archive unpacks to more than {MAX_UNPACKED_SIZE} bytes"));
+        }
+
+        if let Some(parent) = path.parent() {
+            fs::create_dir_all(parent)?;
+        }
+        io::copy(&mut file, &mut File::create(&path)?)?;
+
+        // keeps scripts executable
+        #[cfg(unix)]
+        if let Some(mode) = mode {
+            use std::os::unix::fs::PermissionsExt;
+            fs::set_permissions(&path, fs::Permissions::from_mode(mode & 0o755))?;
+        }
+    }
+
+    Ok(())
+}
+
+/// Commits everything under `root`, minus what its .gitignore leaves out, on top of the
+/// branch HEAD of the repository points to. Returns the branch and the commit
+fn commit(repo_path: &str, root: &Path, author: &Author, message: &str) -> Result<(String, String)> {
+    let repo = git2::Repository::open_bare(repo_path)?;
+    repo.set_workdir(root, false)?;
+
+    let head = repo.find_reference("HEAD")?;
+    let branch_ref = head.symbolic_target().unwrap_or("refs/heads/master").to_string();
+    let branch = branch_ref.trim_start_matches("refs/heads/").to_string();
+
+    // a fresh index, the repository has no working tree of its own to keep one for
+    let mut index = repo.index()?;
+    index.clear()?;
+    index.add_all(["*"].iter(), git2::IndexAddOption::DEFAULT, None)?;
+    let tree = repo.find_tree(index.write_tree()?)?;
+
+    let parent = repo
+        .find_reference(&branch_ref)
+        .ok()
+        .and_then(|reference| reference.peel_to_commit().ok());
+    let parents = parent.iter().collect::<Vec<_>>();
+
+    let signature = git2::Signature::now(&author.name, &author.email)?;
+    let commit = repo.commit(Some(&branch_ref), &signature, &signature, message, &tree, &parents)?;
+
+    Ok((branch, commit.to_string()))
+}
@@ -0,0 +1,231 @@
+use std::collections::BTreeMap;
+use std::time::Duration;
+
+use anyhow::Result;
+use chrono::{Datelike, NaiveDate, Utc};
+use serde::{Deserialize, Serialize};
+use sqlx::PgPool;
+use ulid::Ulid;
+use uuid::Uuid;
+
+use crate::audit::csv_field;
+
+/// What an app used, added up over a month
+#[derive(Serialize, Debug, Clone, Default)]
+pub struct Metered {
+    /// seconds of one cpu, two cpus busy for a second are two
+    pub cpu_seconds: f64,
+    /// GiB of memory held for an hour
+    pub memory_gib_hours: f64,
+    /// bytes the containers sent, to visitors and to anything else
+    pub egress_bytes: i64,
+    pub build_minutes: f64,
+}
+
+impl Metered {
+    fn add(&mut self, other: &Metered) {
+        self.cpu_seconds += other.cpu_seconds;
+        self.memory_gib_hours += other.memory_gib_hours;
+        self.egress_bytes += other.egress_bytes;
+        self.build_minutes += other.build_minutes;
+    }
+}
+
+/// Adds to what the app used this month. The row keeps the names of the app, so reports
+/// still show it after it is deleted
+pub async fn meter(project_id: Uuid, used: &Metered, pool: &PgPool) -> Result<()> {
+    sqlx::query!(
+        r#"INSERT INTO app_usage (id, project_id, owner, project, month, cpu_seconds, memory_gib_hours, egress_bytes, build_minutes)
+           SELECT $1, projects.id, project_owners.name, projects.name, date_trunc('month', now())::date, $3, $4, $5, $6
+           FROM projects
+           JOIN project_owners ON projects.owner_id = project_owners.id
+           WHERE projects.id = $2
+           ON CONFLICT (project_id, month) DO UPDATE SET
+             cpu_seconds = app_usage.cpu_seconds + EXCLUDED.cpu_seconds,
+             memory_gib_hours = app_usage.memory_gib_hours + EXCLUDED.memory_gib_hours,
+             egress_bytes = app_usage.egress_bytes + EXCLUDED.egress_bytes,
+             build_minutes = app_usage.build_minutes + EXCLUDED.build_minutes,
+             updated_at = now()
+        "#,
+        Uuid::from(Ulid::new()),
+        project_id,
+        used.cpu_seconds,
+        used.memory_gib_hours,
+        used.egress_bytes,
+        used.build_minutes
+    )
+    .execute(pool)
+    .await?;
+
+    Ok(())
+}
+
+/// Adds a build to the build minutes of its app, cancelled and failed ones too
+pub async fn meter_build(build_id: Uuid, took: Duration, pool: &PgPool) -> Result<()> {
+    let build = sqlx::query!("SELECT project_id FROM builds WHERE id = $1", build_id)
+        .fetch_one(pool)
+        .await?;
+
+    let used = Metered {
+        build_minutes: took.as_secs_f64() / 60.0,
+        ..Default::default()
+    };
+    meter(build.project_id, &used, pool).await
+}
+
+/// Which month to report and how, `month` is like 2026-10 and defaults to this one
+#[derive(Deserialize, Debug, Default)]
+pub struct UsageFilter {
+    pub month: Option<String>,
+    pub owner: Option<String>,
+    /// `app` (the default) or `owner`, which adds up the apps of every owner
+    pub by: Option<String>,
+    /// `json` (the default) or `csv`
+    pub format: Option<String>,
+}
+
+impl UsageFilter {
+    /// The first day of the month asked for, None when it isn't like 2026-10
+    pub fn month(&self) -> Option<NaiveDate> {
+        match &self.month {
+            Some(month) => NaiveDate::parse_from_str(&format!("{month}-01"), "%Y-%m-%d").ok(),
+            None => {
+                let today = Utc::now().date_naive();
+                NaiveDate::from_ymd_opt(today.year(), today.month(), 1)
+            }
+        }
+    }
+}
+
+/// What one app, or every app of one owner, used in a month
+#[derive(Serialize, Debug, Clone)]
+pub struct UsageRow {
+    pub owner: String,
+    /// None when adding up an owner
+    pub project: Option<String>,
+    pub month: NaiveDate,
+    #[serde(flatten)]
+    pub used: Metered,
+}
+
+/// What the apps used in the month, the most cpu first
+pub async fn report(month: NaiveDate, owner: Option<&str>, by_owner: bool, pool: &PgPool) -> Result<Vec<UsageRow>> {
+    let rows = sqlx::query!(
+        r#"SELECT owner, project, month, cpu_seconds, memory_gib_hours, egress_bytes, build_minutes
+           FROM app_usage
+           WHERE month = $1 AND ($2::text IS NULL OR owner = $2)
+           ORDER BY cpu_seconds DESC
+        "#,
+        month,
+        owner
+    )
+    .fetch_all(pool)
+    .await?;
+
+    let rows = rows.into_iter().map(|row| UsageRow {
+        owner: row.owner,
+        project: Some(row.project),
+        month: row.month,
+        used: Metered {
+            cpu_seconds: row.cpu_seconds,
+            memory_gib_hours: row.memory_gib_hours,
+            egress_bytes: row.egress_bytes,
+            build_minutes: row.build_minutes,
+        },
+    });
+
+    if !by_owner {
+        return Ok(rows.collect());
+    }
+
+    let mut owners: BTreeMap<String, UsageRow> = BTreeMap::new();
+    for row in rows {
+        owners
+            .entry(row.owner.clone())
+            .or_insert_with(|| UsageRow {
+                owner: row.owner.clone(),
+                project: None,
+                month,
+                used: Metered::default(),
+            })
+            .used
+            .add(&row.used);
+    }
+    let mut owners = owners.into_values().collect::<Vec<_>>();
+    owners.sort_by(|a, b| b.used.cpu_seconds.total_cmp(&a.used.cpu_seconds));
+    Ok(owners)
+}
+
+/// What the app used in each of its last 12 months, the latest first
+pub async fn project_report(project_id: Uuid, pool: &PgPool) -> Result<Vec<UsageRow>> {
+    let rows = sqlx::query!(
+        r#"SELECT owner, project, month, cpu_seconds, memory_gib_hours, egress_bytes, build_minutes
+           FROM app_usage
+           WHERE project_id = $1
+           ORDER BY month DESC
+           LIMIT 12
+        "#,
+        project_id
+    )
+    .fetch_all(pool)
+    .await?;
+
+    Ok(rows
+        .into_iter()
+        .map(|row| UsageRow {
+            owner: row.owner,
+            project: Some(row.project),
+            month: row.month,
+            used: Metered {
+                cpu_seconds: row.cpu_seconds,
+                memory_gib_hours: row.memory_gib_hours,
+                egress_bytes: row.egress_bytes,
+                build_minutes: row.build_minutes,
+            },
+        })
+        .collect())
+}
+
+/// Rows as csv with a header row, month like 2026-10
+pub fn to_csv(rows: &[UsageRow]) -> String {
+    let mut csv = String::from("month,owner,project,cpu_seconds,memory_gib_hours,egress_bytes,build_minutes\n");
+
+    for row in rows {
+        let fields = [
+            row.month.format("%Y-%m").to_string(),
+            row.owner.clone(),
+            row.project.clone().unwrap_or_default(),
+            format!("{:.1}", row.used.cpu_seconds),
+            format!("{:.3}", row.used.memory_gib_hours),
+            row.used.egress_bytes.to_string(),
+            format!("{:.1}", row.used.build_minutes),
+        ];
+
+        csv.push_str(&fields.iter().map(|field| csv_field(field)).collect::<Vec<_>>().join(","));
+        csv.push('\n');
+    }
+
+    csv
+}
+
+#[derive(Serialize, Debug)]
+struct UsageResponse<'a> {
+    data: &'a [UsageRow],
+}
+
+/// Rows as `{"data": [...]}`, or as a csv download for `format=csv`
+pub fn export(rows: &[UsageRow], format: Option<&str>, filename: &str) -> hyper::Response<hyper::Body> {
+    match format {
+        Some("csv") => hyper::Response::builder()
+            .status(hyper::StatusCode::OK)
+            .header("Content-Type", "text/csv; charset=utf-8")
+            .header("Content-Disposition", format!("attachment; filename=\"{filename}.csv\""))
+            .body(hyper::Body::from(to_csv(rows)))
+            .unwrap(),
+        _ => hyper::Response::builder()
+            .status(hyper::StatusCode::OK)
+            .header("Content-Type", "application/json")
+            .body(hyper::Body::from(serde_json::to_string(&UsageResponse { data: rows }).unwrap()))
+            .unwrap(),
+    }
+}
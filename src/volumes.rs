@@ -0,0 +1,385 @@
+use std::collections::{BTreeMap, HashMap};
+
+use anyhow::{anyhow, Result};
+use bollard::{
+    container::{Config, CreateContainerOptions, DownloadFromContainerOptions, RemoveContainerOptions},
+    service::HostConfig,
+    volume::{CreateVolumeOptions, ListVolumesOptions, RemoveVolumeOptions},
+    Docker,
+};
+use bytes::Bytes;
+use chrono::{DateTime, TimeZone, Utc};
+use futures::{SinkExt, Stream, StreamExt};
+use serde::Serialize;
+use sqlx::PgPool;
+use ulid::Ulid;
+use uuid::Uuid;
+
+use crate::docker::VolumeMount;
+use crate::nodes;
+
+/// Label every app volume has, its value the container name of the project
+pub const VOLUME_LABEL: &str = "pemasak.volume";
+
+/// Where the volume is mounted in the containers that read it for the api
+const BROWSE_MOUNT: &str = "/volume";
+
+/// Name of the docker volume behind volume `name` of a project
+pub fn volume_name(container_name: &str, name: &str) -> String {
+    format!("{container_name}-data-{name}")
+}
+
+/// The volumes attached to a project, mounted into every container of its release
+pub async fn project_mounts(project_id: Uuid, container_name: &str, pool: &PgPool) -> Result<Vec<VolumeMount>> {
+    let volumes = sqlx::query!(
+        "SELECT name, mount_path FROM volumes WHERE project_id = $1 ORDER BY name",
+        project_id
+    )
+    .fetch_all(pool)
+    .await?;
+
+    Ok(volumes
+        .into_iter()
+        .map(|volume| VolumeMount {
+            volume: volume_name(container_name, &volume.name),
+            path: volume.mount_path,
+        })
+        .collect())
+}
+
+pub async fn create_volume(container_name: &str, name: &str) -> Result<String> {
+    let docker = nodes::docker(container_name)?;
+    let volume = volume_name(container_name, name);
+
+    docker
+        .create_volume(CreateVolumeOptions {
+            name: volume.clone(),
+            labels: HashMap::from([(VOLUME_LABEL.to_string(), container_name.to_string())]),
+            ..Default::default()
+        })
+        .await
+        .map_err(|err| {
+            tracing::error!("Failed to create volume: {}", err);
+            err
+        })?;
+    Ok(volume)
+}
+
+/// Removes the volumes of a project that aren't in `keep`. Volumes a container still uses
+/// can't be removed, the next deploy tries again once the container is gone
+pub async fn prune_volumes(container_name: &str, keep: &[VolumeMount]) {
+    let docker = match nodes::docker(container_name) {
+        Ok(docker) => docker,
+        Err(err) => {
+            tracing::error!(?err, "Can't prune volumes: Failed to connect to docker");
+            return;
+        }
+    };
+
+    let volumes = match docker
+        .list_volumes(Some(ListVolumesOptions {
+            filters: HashMap::from([(
+                "label".to_string(),
+                vec![format!("{VOLUME_LABEL}={container_name}")],
+            )]),
+        }))
+        .await
+    {
+        Ok(volumes) => volumes.volumes.unwrap_or_default(),
+        Err(err) => {
+            tracing::error!(?err, "Can't prune volumes: Failed to list volumes");
+            return;
+        }
+    };
+
+    for volume in volumes {
+        if keep.iter().any(|mount| mount.volume == volume.name) {
+            continue;
+        }
+        match docker.remove_volume(&volume.name, None::<RemoveVolumeOptions>).await {
+            Ok(_) => tracing::info!(volume = volume.name, "Removed detached volume"),
+            Err(err) => tracing::warn!(?err, volume = volume.name, "Can't remove detached volume yet"),
+        }
+    }
+}
+
+/// Bytes used by each volume of a project, by volume name. Docker has to walk the volumes
+/// to tell, so this is slow on big ones
+pub async fn volume_usage(container_name: &str) -> Result<HashMap<String, i64>> {
+    let docker = nodes::docker(container_name)?;
+    let usage = docker.df().await?;
+
+    let prefix = volume_name(container_name, "");
+    Ok(usage
+        .volumes
+        .unwrap_or_default()
+        .into_iter()
+        .filter(|volume| volume.name.starts_with(&prefix))
+        .filter_map(|volume| Some((volume.name, volume.usage_data?.size)))
+        .collect())
+}
+
+/// Fails while a volume holds more than its size. The volume driver can't cap a volume, so
+/// an app that outgrew one doesn't get new code until it frees space or the size is raised
+pub async fn check_volumes(project_id: Uuid, container_name: &str, pool: &PgPool) -> Result<()> {
+    let volumes = sqlx::query!("SELECT name, size_mb FROM volumes WHERE project_id = $1", project_id)
+        .fetch_all(pool)
+        .await?;
+    if volumes.is_empty() {
+        return Ok(());
+    }
+
+    let usage = volume_usage(container_name).await?;
+    for volume in volumes {
+        let used = usage
+            .get(&volume_name(container_name, &volume.name))
+            .copied()
+            .unwrap_or_default();
+        let size = i64::from(volume.size_mb) * 1024 * 1024;
+        if used > size {
+            return Err(anyhow!(
+                "Volume {} holds {} MiB, more than its size of {} MiB. Delete files with pmk shell or make it bigger",
+                volume.name,
+                used / 1024 / 1024,
+                volume.size_mb
+            ));
+        }
+    }
+    Ok(())
+}
+
+/// A container that is never started, it only has the volume mounted for docker to copy
+/// files out of. It is removed when dropped
+struct BrowseContainer {
+    name: String,
+}
+
+impl Drop for BrowseContainer {
+    fn drop(&mut self) {
+        let name = std::mem::take(&mut self.name);
+        tokio::spawn(async move {
+            let Ok(docker) = nodes::docker(&name) else {
+                return;
+            };
+            if let Err(err) = docker
+                .remove_container(
+                    &name,
+                    Some(RemoveContainerOptions {
+                        force: true,
+                        ..Default::default()
+                    }),
+                )
+                .await
+            {
+                tracing::error!(?err, name, "Can't remove volume browse container");
+            }
+        });
+    }
+}
+
+/// Tar archive of `path` inside a volume, read through a container of `image` that never
+/// runs. `path` is relative to the root of the volume, empty for all of it
+pub async fn volume_archive(
+    volume: &str,
+    image: &str,
+    path: &str,
+) -> Result<impl Stream<Item = Result<Bytes>> + Unpin> {
+    let docker = nodes::docker(volume)?;
+    let name = format!("{volume}-browse-{}", Ulid::new().to_string().to_lowercase());
+
+    docker
+        .create_container(
+            Some(CreateContainerOptions {
+                name: name.as_str(),
+                platform: None,
+            }),
+            Config {
+                image: Some(image.to_string()),
+                // never started, any command will do
+                entrypoint: Some(vec!["true".to_string()]),
+                network_disabled: Some(true),
+                host_config: Some(HostConfig {
+                    binds: Some(vec![format!("{volume}:{BROWSE_MOUNT}:ro")]),
+                    ..Default::default()
+                }),
+                ..Default::default()
+            },
+        )
+        .await
+        .map_err(|err| {
+            tracing::error!("Failed to create container: {}", err);
+            err
+        })?;
+    let container = BrowseContainer { name };
+
+    let path = match path.trim_matches('/') {
+        "" => BROWSE_MOUNT.to_string(),
+        path => format!("{BROWSE_MOUNT}/{path}"),
+    };
+    // docker can only be read while it lives, the task owns it and the container until the
+    // archive is read or whoever reads it goes away
+    let (mut sender, receiver) = futures::channel::mpsc::channel(8);
+    tokio::spawn(async move {
+        let mut archive = Box::pin(
+            docker.download_from_container(&container.name, Some(DownloadFromContainerOptions { path })),
+        );
+        while let Some(chunk) = archive.next().await {
+            if sender.send(chunk.map_err(anyhow::Error::from)).await.is_err() {
+                break;
+            }
+        }
+    });
+    Ok(receiver)
+}
+
+#[derive(Serialize, Debug, Clone, Copy, PartialEq)]
+#[serde(rename_all = "lowercase")]
+pub enum EntryKind {
+    File,
+    Dir,
+    Link,
+}
+
+/// A file or directory of a volume, directories with the size of everything in them
+#[derive(Serialize, Debug)]
+pub struct VolumeEntry {
+    pub name: String,
+    pub kind: EntryKind,
+    pub size: u64,
+    pub modified: Option<DateTime<Utc>>,
+}
+
+/// What is directly inside `path` of a volume, or the file at `path`. Only the headers of the
+/// archive are kept, file contents are skipped as they stream past
+pub async fn list_entries(volume: &str, image: &str, path: &str) -> Result<Vec<VolumeEntry>> {
+    let mut archive = TarStream::new(volume_archive(volume, image, path).await?);
+    let mut entries: BTreeMap<String, VolumeEntry> = BTreeMap::new();
+
+    while let Some((entry_path, header)) = archive.next_entry().await? {
+        let size = header.size()?;
+        let kind = match header.entry_type() {
+            tar::EntryType::Directory => EntryKind::Dir,
+            tar::EntryType::Symlink | tar::EntryType::Link => EntryKind::Link,
+            _ => EntryKind::File,
+        };
+        let modified = header
+            .mtime()
+            .ok()
+            .and_then(|mtime| Utc.timestamp_opt(mtime as i64, 0).single());
+
+        // the archive starts with the requested directory or file itself
+        let mut parts = entry_path.trim_end_matches('/').split('/').skip(1);
+        let Some(child) = parts.next() else {
+            if kind != EntryKind::Dir {
+                entries.insert(entry_path.clone(), VolumeEntry { name: entry_path, kind, size, modified });
+            }
+            continue;
+        };
+
+        let nested = parts.next().is_some();
+        let entry = entries.entry(child.to_string()).or_insert_with(|| VolumeEntry {
+            name: child.to_string(),
+            kind: EntryKind::Dir,
+            size: 0,
+            modified: None,
+        });
+        entry.size += size;
+        if !nested {
+            entry.kind = kind;
+            entry.modified = modified;
+        }
+    }
+
+    Ok(entries.into_values().collect())
+}
+
+/// Reads tar headers off a stream of archive chunks without holding on to the contents
+struct TarStream<S> {
+    stream: S,
+    buffer: Vec<u8>,
+}
+
+impl<S: Stream<Item = Result<Bytes>> + Unpin> TarStream<S> {
+    fn new(stream: S) -> Self {
+        Self { stream, buffer: vec![] }
+    }
+
+    /// Buffers `len` bytes, false when the archive ends first
+    async fn fill(&mut self, len: usize) -> Result<bool> {
+        while self.buffer.len() < len {
+            match self.stream.next().await {
+                Some(chunk) => self.buffer.extend_from_slice(&chunk?),
+                None => return Ok(false),
+            }
+        }
+        Ok(true)
+    }
+
+    async fn take(&mut self, len: usize) -> Result<Vec<u8>> {
+        if !self.fill(len).await? {
+            return Err(anyhow!("Archive ended in the middle of an entry"));
+        }
+        Ok(self.buffer.drain(..len).collect())
+    }
+
+    async fn skip(&mut self, mut len: usize) -> Result<()> {
+        while len > 0 {
+            if self.buffer.is_empty() && !self.fill(1).await? {
+                return Err(anyhow!("Archive ended in the middle of an entry"));
+            }
+            let skipped = len.min(self.buffer.len());
+            self.buffer.drain(..skipped);
+            len -= skipped;
+        }
+        Ok(())
+    }
+
+    /// The next entry with its full path, long names come in entries of their own before it
+    async fn next_entry(&mut self) -> Result<Option<(String, tar::Header)>> {
+        let mut long_name = None;
+        loop {
+            if !self.fill(512).await? {
+                return Ok(None);
+            }
+            let block = self.take(512).await?;
+            // two zero blocks end the archive
+            if block.iter().all(|byte| *byte == 0) {
+                return Ok(None);
+            }
+
+            let header = tar::Header::from_byte_slice(&block).clone();
+            let size = header.entry_size()? as usize;
+            let padded = (size + 511) / 512 * 512;
+
+            match header.entry_type() {
+                tar::EntryType::GNULongName => {
+                    let name = self.take(size).await?;
+                    self.skip(padded - size).await?;
+                    long_name = Some(String::from_utf8_lossy(&name).trim_end_matches('\0').to_string());
+                }
+                tar::EntryType::XHeader => {
+                    let records = self.take(size).await?;
+                    self.skip(padded - size).await?;
+                    long_name = pax_path(&records).or(long_name);
+                }
+                tar::EntryType::XGlobalHeader => self.skip(padded).await?,
+                _ => {
+                    self.skip(padded).await?;
+                    let path = match long_name.take() {
+                        Some(name) => name,
+                        None => String::from_utf8_lossy(&header.path_bytes()).to_string(),
+                    };
+                    return Ok(Some((path, header)));
+                }
+            }
+        }
+    }
+}
+
+/// The path record of pax extended headers, lines of `LEN key=value`
+fn pax_path(records: &[u8]) -> Option<String> {
+    String::from_utf8_lossy(records).lines().find_map(|record| {
+        let (_, field) = record.split_once(' ')?;
+        field.strip_prefix("path=").map(str::to_string)
+    })
+}
@@ -0,0 +1,90 @@
+use std::time::Duration;
+
+use hyper::header::{HeaderMap, CONNECTION, UPGRADE};
+use hyper::upgrade::OnUpgrade;
+use tokio::io::{AsyncReadExt, AsyncWriteExt};
+use tokio::time::Instant;
+
+use crate::idle::IdleTracker;
+use crate::in_flight::Guard;
+
+/// idle apps are touched at most this often while a connection is busy
+const TOUCH_INTERVAL: Duration = Duration::from_secs(30);
+
+/// Whether the request asks to switch to another protocol, like a websocket handshake
+pub fn is_upgrade(headers: &HeaderMap) -> bool {
+    let connection = headers
+        .get_all(CONNECTION)
+        .iter()
+        .filter_map(|value| value.to_str().ok())
+        .flat_map(|value| value.split(','))
+        .any(|token| token.trim().eq_ignore_ascii_case("upgrade"));
+    connection && headers.contains_key(UPGRADE)
+}
+
+/// Once both sides switched protocols, copies bytes between the client and the app until one
+/// of them closes or nothing is sent either way for `timeout`. The app counts as visited
+/// while bytes flow, so the idler doesn't stop it under an open connection, and the
+/// connection counts as in flight to the container until it closes
+pub fn tunnel(
+    client: OnUpgrade,
+    app: OnUpgrade,
+    idle: IdleTracker,
+    in_flight: Guard,
+    subdomain: &str,
+    timeout: Duration,
+) {
+    let subdomain = subdomain.to_string();
+
+    tokio::spawn(async move {
+        let _in_flight = in_flight;
+        let (client, app) = match tokio::try_join!(client, app) {
+            Ok(upgraded) => upgraded,
+            Err(err) => {
+                tracing::warn!(?err, app = subdomain, "Can't proxy websocket: Failed to upgrade connection");
+                return;
+            }
+        };
+
+        let (mut client_read, mut client_write) = tokio::io::split(client);
+        let (mut app_read, mut app_write) = tokio::io::split(app);
+        let mut from_client = vec![0u8; 8192];
+        let mut from_app = vec![0u8; 8192];
+        let mut touched = Instant::now();
+
+        let ended = loop {
+            // reads are cancel safe, the side that lost the race reads again next time
+            let read = tokio::time::timeout(timeout, async {
+                tokio::select! {
+                    read = client_read.read(&mut from_client) => (true, read),
+                    read = app_read.read(&mut from_app) => (false, read),
+                }
+            })
+            .await;
+
+            let (to_app, read) = match read {
+                Ok(read) => read,
+                Err(_) => break "idle",
+            };
+            let written = match read {
+                Ok(0) => break "closed",
+                Ok(n) if to_app => app_write.write_all(&from_client[..n]).await,
+                Ok(n) => client_write.write_all(&from_app[..n]).await,
+                Err(_) => break "reset",
+            };
+            if written.is_err() {
+                break "reset";
+            }
+
+            if touched.elapsed() >= TOUCH_INTERVAL {
+                idle.touch(&subdomain);
+                touched = Instant::now();
+            }
+        };
+
+        let _ = client_write.shutdown().await;
+        let _ = app_write.shutdown().await;
+        idle.touch(&subdomain);
+        tracing::debug!(app = subdomain, ended, "Websocket closed");
+    });
+}
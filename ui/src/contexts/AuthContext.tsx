@@ -9,7 +9,7 @@ export const AuthContext = createContext({
     },
     authenticated: false,
     handlers: {
-        login: (_username: string, _password: string) => { },
+        login: (_username: string, _password: string, _code?: string) => { },
         refreshAuthState: () => { }
     }
 })
@@ -37,7 +37,7 @@ export default function AuthProvider({ children }: AuthProviderProps): ReactElem
         authenticated: false,
         initializing: true,
         handlers: {
-            login: (_username: string, _password: string) => { },
+            login: (_username: string, _password: string, _code?: string) => { },
             refreshAuthState: () => { }
         }
     })
@@ -51,7 +51,7 @@ export default function AuthProvider({ children }: AuthProviderProps): ReactElem
 
     const { location } = router.state
 
-    async function login(username: string, password: string) {
+    async function login(username: string, password: string, code?: string) {
         const request = await fetch(`${import.meta.env.VITE_API_URL}/login`, {
             method: "POST",
             credentials: "include",
@@ -61,6 +61,7 @@ export default function AuthProvider({ children }: AuthProviderProps): ReactElem
             body: JSON.stringify({
                 username: username,
                 password: password,
+                code: code || undefined,
             })
         })
 
@@ -0,0 +1,35 @@
+import { useEffect, useRef } from "react"
+
+export type LiveTopic = "build" | "build_log" | "container" | "log"
+
+// `resync` means updates were skipped, whatever the page shows has to be fetched again
+export type LiveHandler = (topic: LiveTopic | "resync", data: any) => void
+
+// Keeps one EventSource open on the live updates of an app while the page is shown, instead
+// of asking the api again every few seconds. EventSource reconnects on its own, a reconnect
+// counts as a resync since updates may have been missed in between
+export function useLiveUpdates(owner: string, project: string, topics: LiveTopic[], handler: LiveHandler) {
+  const handlerRef = useRef(handler)
+  handlerRef.current = handler
+  const events = topics.join(",")
+
+  useEffect(() => {
+    const source = new EventSource(
+      `${import.meta.env.VITE_API_URL}/project/${owner}/${project}/live?events=${events}`,
+      { withCredentials: true }
+    )
+
+    let opened = false
+    source.onopen = () => {
+      if (opened) handlerRef.current("resync", null)
+      opened = true
+    }
+
+    for (const topic of events.split(",") as LiveTopic[]) {
+      source.addEventListener(topic, (event) => handlerRef.current(topic, JSON.parse(event.data)))
+    }
+    source.addEventListener("resync", () => handlerRef.current("resync", null))
+
+    return () => source.close()
+  }, [owner, project, events])
+}
@@ -36,6 +36,9 @@ const ProjectOwnerProjectSettingsLazyImport = createFileRoute(
 const ProjectOwnerProjectLogsLazyImport = createFileRoute(
   '/project/$owner/$project/logs',
 )()
+const ProjectOwnerProjectEventsLazyImport = createFileRoute(
+  '/project/$owner/$project/events',
+)()
 const ProjectOwnerProjectEnvLazyImport = createFileRoute(
   '/project/$owner/$project/env',
 )()
@@ -115,6 +118,16 @@ const ProjectOwnerProjectLogsLazyRoute =
     import('./routes/project/$owner/$project/logs.lazy').then((d) => d.Route),
   )
 
+const ProjectOwnerProjectEventsLazyRoute =
+  ProjectOwnerProjectEventsLazyImport.update({
+    path: '/events',
+    getParentRoute: () => ProjectOwnerProjectLazyRoute,
+  } as any).lazy(() =>
+    import('./routes/project/$owner/$project/events.lazy').then(
+      (d) => d.Route,
+    ),
+  )
+
 const ProjectOwnerProjectEnvLazyRoute = ProjectOwnerProjectEnvLazyImport.update(
   {
     path: '/env',
@@ -166,6 +179,10 @@ declare module '@tanstack/react-router' {
       preLoaderRoute: typeof ProjectOwnerProjectEnvLazyImport
       parentRoute: typeof ProjectOwnerProjectLazyImport
     }
+    '/project/$owner/$project/events': {
+      preLoaderRoute: typeof ProjectOwnerProjectEventsLazyImport
+      parentRoute: typeof ProjectOwnerProjectLazyImport
+    }
     '/project/$owner/$project/logs': {
       preLoaderRoute: typeof ProjectOwnerProjectLogsLazyImport
       parentRoute: typeof ProjectOwnerProjectLazyImport
@@ -199,6 +216,7 @@ export const routeTree = rootRoute.addChildren([
   RegisterLazyRoute,
   ProjectOwnerProjectLazyRoute.addChildren([
     ProjectOwnerProjectEnvLazyRoute,
+    ProjectOwnerProjectEventsLazyRoute,
     ProjectOwnerProjectLogsLazyRoute,
     ProjectOwnerProjectSettingsLazyRoute,
     ProjectOwnerProjectTerminalLazyRoute,
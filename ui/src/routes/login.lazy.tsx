@@ -1,4 +1,4 @@
-import { Link, createLazyFileRoute } from '@tanstack/react-router';
+import { Link, createLazyFileRoute, useSearch } from '@tanstack/react-router';
 import {
     Card,
     CardContent,
@@ -12,7 +12,7 @@ import { Button } from '@/components/ui/button';
 import { useForm } from 'react-hook-form';
 import { Alert, AlertDescription, AlertTitle } from '@/components/ui/alert';
 import { ExclamationTriangleIcon } from '@radix-ui/react-icons';
-import { useState } from 'react';
+import { useEffect, useState } from 'react';
 import { useAuth } from '@/contexts/AuthContext';
 import Spinner from '@/components/ui/spinner';
 
@@ -24,12 +24,26 @@ function Login() {
     const { handlers: { login } } = useAuth()
     const { register, handleSubmit, formState: { isSubmitting } } = useForm()
 
-    const [error, setError] = useState({ message: "", error_type: "" })
+    const search: any = useSearch({ strict: false })
+    const [error, setError] = useState({ message: search?.error || "", error_type: "" })
+    const [sso, setSso] = useState({ name: "", passwords: true })
+    // asked for once the password was right and the account has two-factor authentication
+    const [needsCode, setNeedsCode] = useState(false)
+
+    useEffect(() => {
+        fetch(`${import.meta.env.VITE_API_URL}/oidc`)
+            .then((res) => res.ok ? res.json() : null)
+            .then((data) => data && setSso(data))
+            .catch(() => { })
+    }, [])
 
     async function submitHandler(data: any) {
         try {
-            await login(data.username, data.password)
+            await login(data.username, data.password, data.code)
         } catch (e: any) {
+            if (e.error_type === "TwoFactorRequired") {
+                setNeedsCode(true)
+            }
             setError(e)
         }
     }
@@ -51,6 +65,7 @@ function Login() {
                 <CardHeader>
                     <CardTitle className="text-center text-3xl">Login</CardTitle>
                 </CardHeader>
+                {sso.passwords && (
                 <CardContent className="gap-4 flex flex-col items-center justify-center space-y-2">
                     <div className="grid w-full max-w-sm items-center gap-1.5">
                         <Label className="text-md" htmlFor="username">Username</Label>
@@ -60,9 +75,16 @@ function Login() {
                         <Label className="text-md" htmlFor="password">Password</Label>
                         <Input type="password" placeholder="password" id="password" {...register("password")} />
                     </div>
+                    {needsCode && (
+                    <div className="grid w-full max-w-sm items-center gap-1.5">
+                        <Label className="text-md" htmlFor="code">Authentication Code</Label>
+                        <Input autoFocus autoComplete="one-time-code" inputMode="numeric" placeholder="123456 or a recovery code" id="code" {...register("code")} />
+                    </div>
+                    )}
                 </CardContent>
+                )}
                 <CardFooter className="flex flex-col items-center justify-center space-y-4 pt-4">
-                    {!isSubmitting ? (
+                    {sso.passwords && (!isSubmitting ? (
                         <Button size="lg" className="text-foreground w-2/3">
                             Login
                         </Button>
@@ -70,7 +92,15 @@ function Login() {
                         <Button disabled size="lg" className="text-foreground w-2/3">
                             <Spinner className="mr-2" /> Logging In
                         </Button>
+                    ))}
+                    {sso.name && (
+                        <a href={`${import.meta.env.VITE_API_URL}/oidc/login${search?.redirect ? `?redirect=${encodeURIComponent(search.redirect)}` : ""}`} className="w-2/3">
+                            <Button type="button" variant="outline" size="lg" className="text-foreground w-full">
+                                Login with {sso.name}
+                            </Button>
+                        </a>
                     )}
+                    {sso.passwords && (
                     <div className="text-center">
                         <p>
                             Don't have an account?
@@ -81,6 +111,7 @@ function Login() {
                             </Button>
                         </Link>
                     </div>
+                    )}
                 </CardFooter>
             </Card>
         </form>
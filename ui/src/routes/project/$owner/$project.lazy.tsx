@@ -1,8 +1,9 @@
 import { Badge } from "@/components/ui/badge";
 import { Button } from "@/components/ui/button";
-import { FileTextIcon } from "@radix-ui/react-icons";
+import { ActivityLogIcon, FileTextIcon } from "@radix-ui/react-icons";
 import { Link, Outlet, createLazyFileRoute, useParams } from "@tanstack/react-router";
 import useSWR from "swr";
+import { useLiveUpdates } from "@/lib/live";
 
 export const Route = createLazyFileRoute('/project/$owner/$project')({
     component: ProjectDashboard,
@@ -28,7 +29,9 @@ function ProjectDashboard() {
     const domain = import.meta.env.VITE_API_URL.match(/((.*):\/\/(.*)\/)/)?.[0].replace(/^https?:\/\//, "")
     console.log(domain)
 
-    const { data: builds, isLoading } = useSWR(`${import.meta.env.VITE_API_URL}/project/${owner}/${project}/builds/`, apiFetcher)
+    const { data: builds, isLoading, mutate } = useSWR(`${import.meta.env.VITE_API_URL}/project/${owner}/${project}/builds/`, apiFetcher)
+    // the pages below share this list, one stream keeps it current for all of them
+    useLiveUpdates(owner, project, ["build", "container"], () => mutate())
 
     return (
         <div className="w-full relative min-h-screen">
@@ -111,6 +114,17 @@ function ProjectDashboard() {
                                 </svg>
                                 Logs
                             </Link>
+                            <Link
+                                to="/project/$owner/$project/events"
+                                params={{ owner, project }}
+                                className="flex px-4 py-2 rounded-lg items-center hover:bg-slate-900 transition-all"
+                                activeProps={{
+                                    className: "bg-slate-900"
+                                }}
+                            >
+                                <ActivityLogIcon width="20" height="20" className="mr-1.5" />
+                                Events
+                            </Link>
                             <Link
                                 to="/project/$owner/$project/env"
                                 params={{ owner, project }}
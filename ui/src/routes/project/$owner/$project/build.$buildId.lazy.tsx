@@ -1,4 +1,5 @@
 import { createLazyFileRoute, useParams } from '@tanstack/react-router'
+import { useEffect, useState } from 'react'
 import useSWR from 'swr'
 
 export const Route = createLazyFileRoute('/project/$owner/$project/build/$buildId')({
@@ -23,7 +24,28 @@ function ProjectViewBuildLog() {
   // @ts-ignore
   const { owner, project, buildId } = useParams({ strict: false })
 
-  const { data: build, isLoading } = useSWR(`${import.meta.env.VITE_API_URL}/project/${owner}/${project}/builds/${buildId}`, apiFetcher)
+  const { data: build, isLoading, mutate } = useSWR(`${import.meta.env.VITE_API_URL}/project/${owner}/${project}/builds/${buildId}`, apiFetcher)
+  const [logs, setLogs] = useState("")
+
+  // EventSource reconnects on its own and resumes from the last event id
+  useEffect(() => {
+    const source = new EventSource(
+      `${import.meta.env.VITE_API_URL}/project/${owner}/${project}/builds/${buildId}/stream`,
+      { withCredentials: true }
+    )
+
+    source.addEventListener("log", (event) => {
+      const chunk = JSON.parse(event.data)
+      setLogs(prev => chunk.reset ? chunk.log : prev + chunk.log)
+    })
+
+    source.addEventListener("done", () => {
+      source.close()
+      mutate()
+    })
+
+    return () => source.close()
+  }, [owner, project, buildId, mutate])
 
   console.log(
     build, isLoading
@@ -34,12 +56,51 @@ function ProjectViewBuildLog() {
       <div className="text-sm space-y-1">
         <h1 className="text-xl font-medium">Build Logs</h1>
         <p>Build ID: {build?.id}</p>
+        <p>Status: {build?.status}</p>
       </div>
       <div className="w-full p-8 bg-slate-900 rounded-lg max-h-96 overflow-y-auto overflow-x-hidden">
         <pre className="w-full space-x-4 whitespace-pre-wrap">
-          {build?.logs}
+          {logs || build?.logs}
         </pre>
       </div>
+      {build?.scan && (
+        <div className="text-sm space-y-2">
+          <h2 className="text-lg font-medium">Vulnerabilities</h2>
+          {build.scan.error ? (
+            <p>Scan failed: {build.scan.error}</p>
+          ) : (
+            <p>
+              {build.scan.critical} critical, {build.scan.high} high, {build.scan.medium} medium, {build.scan.low} low, {build.scan.unknown} unknown
+            </p>
+          )}
+          {build.scan.findings.length > 0 && (
+            <div className="max-h-96 overflow-y-auto">
+              <table className="w-full text-left">
+                <thead>
+                  <tr>
+                    <th>ID</th>
+                    <th>Severity</th>
+                    <th>Package</th>
+                    <th>Installed</th>
+                    <th>Fixed</th>
+                  </tr>
+                </thead>
+                <tbody>
+                  {build.scan.findings.map((finding: any) => (
+                    <tr key={`${finding.id}-${finding.package}`}>
+                      <td>{finding.id}</td>
+                      <td>{finding.severity}</td>
+                      <td>{finding.package}</td>
+                      <td>{finding.installed}</td>
+                      <td>{finding.fixed ?? "-"}</td>
+                    </tr>
+                  ))}
+                </tbody>
+              </table>
+            </div>
+          )}
+        </div>
+      )}
     </div>
   )
 }
\ No newline at end of file
@@ -12,7 +12,7 @@ export const Route = createLazyFileRoute('/project/$owner/$project/env')({
   component: ProjectDashboardEnv
 })
 
-function EnvironmentVariable({ envKey, envValue, owner, project }: { envKey: string, envValue: string, owner: string, project: string }) {
+function EnvironmentVariable({ envKey, envValue, secret, owner, project }: { envKey: string, envValue?: string, secret?: boolean, owner: string, project: string }) {
   const { mutate } = useSWRConfig()
 
   async function deleteEnv() {
@@ -37,10 +37,10 @@ function EnvironmentVariable({ envKey, envValue, owner, project }: { envKey: str
         <pre>{envKey}</pre>
       </div>
       <div>
-        {envValue}
+        {secret ? <span className="text-slate-400">Secret, hidden</span> : envValue}
       </div>
       <div className="flex justify-end space-x-4">
-        <ModifyEnvironDialog envKey={envKey} envValue={envValue} owner={owner} project={project}>
+        <ModifyEnvironDialog envKey={envKey} envValue={envValue} secret={secret} owner={owner} project={project}>
           <Button variant="outline" size="lg" className="border-primary bg-transparent text-primary hover:bg-primary">
             <Pencil1Icon className="w-5 h-5" />
           </Button>
@@ -67,7 +67,7 @@ const apiFetcher = (input: URL | RequestInfo, options?: RequestInit) => {
   ).then(res => res.json())
 }
 
-function ModifyEnvironDialog({ owner, project, envKey, envValue, children }: { owner: string, project: string, envKey?: string, envValue?: string, children: React.ReactNode }) {
+function ModifyEnvironDialog({ owner, project, envKey, envValue, secret, children }: { owner: string, project: string, envKey?: string, envValue?: string, secret?: boolean, children: React.ReactNode }) {
   const {
     handleSubmit,
     register,
@@ -81,7 +81,8 @@ function ModifyEnvironDialog({ owner, project, envKey, envValue, children }: { o
   useEffect(() => {
     setValue("key", envKey)
     setValue("value", envValue)
-  }, [envKey, envValue])
+    setValue("secret", secret ?? false)
+  }, [envKey, envValue, secret])
 
   async function submitHandler(data: any) {
     await fetch(`${import.meta.env.VITE_API_URL}/project/${owner}/${project}/env`, {
@@ -93,6 +94,7 @@ function ModifyEnvironDialog({ owner, project, envKey, envValue, children }: { o
       body: JSON.stringify({
         key: data.key,
         value: data.value,
+        secret: data.secret,
       })
     })
       .then(() => setOpen(false))
@@ -117,7 +119,11 @@ function ModifyEnvironDialog({ owner, project, envKey, envValue, children }: { o
           </div>
           <div className="space-y-2">
             <label>Value</label>
-            <Input className="bg-slate-900 border-slate-600 bg-opacity-90" {...register("value")} />
+            <Input type={secret ? "password" : "text"} placeholder={secret ? "Enter a new value" : undefined} className="bg-slate-900 border-slate-600 bg-opacity-90" {...register("value")} />
+          </div>
+          <div className="flex items-center space-x-2">
+            <input type="checkbox" id="secret" {...register("secret")} />
+            <label htmlFor="secret">Secret, stored encrypted and never shown again</label>
           </div>
           <DialogFooter>
             <DialogClose>
@@ -145,7 +151,7 @@ function ProjectDashboardEnv() {
       <div className="flex justify-between">
         <div className="text-sm space-y-1">
           <h1 className="text-xl font-semibold">Project Environment Variables</h1>
-          <p className="text-sm">Set environment variables for your application here.</p>
+          <p className="text-sm">Set environment variables for your application here. Changes are applied to the running app as a new release.</p>
         </div>
         <ModifyEnvironDialog owner={owner} project={project}>
           <Button size="lg" className="text-foreground">
@@ -162,6 +168,13 @@ function ProjectDashboardEnv() {
             )
           })
         )}
+        {!isLoading && (
+          (data?.secrets ?? []).map((key: string) => {
+            return (
+              <EnvironmentVariable project={project} owner={owner} envKey={key} secret />
+            )
+          })
+        )}
       </div>
     </div>
   )
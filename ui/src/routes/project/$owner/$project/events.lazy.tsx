@@ -0,0 +1,122 @@
+import { Badge } from '@/components/ui/badge'
+import { createLazyFileRoute, useParams } from '@tanstack/react-router'
+import { useState } from 'react'
+import useSWR from 'swr'
+
+const apiFetcher = (input: URL | RequestInfo, options?: RequestInit) => {
+  return fetch(
+    input,
+    {
+      ...options,
+      redirect: "follow",
+      credentials: "include",
+      headers: {
+        "Content-Type": "application/json"
+      },
+    }
+  ).then(res => res.json())
+}
+
+const EVENT_TYPES = ["build", "deploy", "scale", "crash", "config", "addon"]
+
+interface Event {
+  id: string
+  type: string
+  kind: string
+  message: string
+  actor: string | null
+  created_at: string
+}
+
+function EventBadge({ type }: { type: string }) {
+  function getVariant() {
+    if (type === "crash") return "bg-red-700"
+    if (type === "deploy") return "bg-green-700"
+    if (type === "scale") return "bg-blue-700"
+    if (type === "build") return "bg-yellow-700"
+    return "bg-slate-700"
+  }
+
+  return (
+    <Badge className={`${getVariant()} text-white rounded-full font-medium`}>
+      {type.charAt(0).toUpperCase() + type.slice(1)}
+    </Badge>
+  )
+}
+
+function EventPage({ url, last, onMore }: { url: string, last: boolean, onMore: (cursor: string) => void }) {
+  const { data, isLoading } = useSWR(url, apiFetcher)
+
+  if (isLoading) {
+    return <div className="bg-slate-900 p-8 animate-pulse rounded-lg" />
+  }
+
+  return (
+    <>
+      {data?.data?.map((event: Event) => (
+        <div key={`${event.type}-${event.id}`} className="bg-slate-900 border px-6 py-4 rounded-lg border-slate-500 flex items-center gap-4">
+          <EventBadge type={event.type} />
+          <div className="space-y-1">
+            <p>{event.message}</p>
+            <p className="text-sm text-slate-400">
+              {new Date(event.created_at).toLocaleString()}{event.actor ? ` by ${event.actor}` : ""}
+            </p>
+          </div>
+        </div>
+      ))}
+      {last && data?.next_cursor && (
+        <button onClick={() => onMore(data.next_cursor)} className="text-sm text-blue-400 hover:underline">
+          Load older events
+        </button>
+      )}
+    </>
+  )
+}
+
+function Events() {
+  // @ts-ignore
+  const { owner, project } = useParams({ strict: false })
+  const [type, setType] = useState("")
+  const [cursors, setCursors] = useState<string[]>([""])
+
+  const url = (cursor: string) => {
+    const query = new URLSearchParams()
+    if (type) query.set("type", type)
+    if (cursor) query.set("cursor", cursor)
+    return `${import.meta.env.VITE_API_URL}/project/${owner}/${project}/events?${query}`
+  }
+
+  return (
+    <div className="space-y-4">
+      <div className="text-sm space-y-1">
+        <h1 className="text-xl font-semibold">Project Events</h1>
+        <p className="text-sm">Builds, releases, scaling, crashes and changes of your project, newest first</p>
+      </div>
+      <div className="flex gap-2">
+        {["", ...EVENT_TYPES].map((option) => (
+          <button
+            key={option}
+            onClick={() => { setType(option); setCursors([""]) }}
+            className={`px-3 py-1 rounded-lg text-sm ${type === option ? "bg-slate-900" : "bg-slate-800 hover:bg-slate-900"}`}
+          >
+            {option ? option.charAt(0).toUpperCase() + option.slice(1) : "All"}
+          </button>
+        ))}
+      </div>
+      <div className="w-full flex flex-col gap-2">
+        {cursors.map((cursor, i) => (
+          <EventPage
+            key={cursor}
+            url={url(cursor)}
+            last={i === cursors.length - 1}
+            onMore={(next) => setCursors([...cursors, next])}
+          />
+        ))}
+      </div>
+    </div>
+  )
+}
+
+export const Route = createLazyFileRoute('/project/$owner/$project/events')({
+  component: () => <Events />
+})
@@ -25,6 +25,7 @@ function BuildBadge({ text }: { text: string }) {
     if (text === "SUCCESSFUL") return "bg-green-700"
     if (text === "FAILED") return "bg-red-700"
     if (text === "BUILDING") return "bg-yellow-700"
+    if (text === "CANCELLED") return "bg-slate-500"
     return "bg-slate-700"
   }
 
@@ -35,6 +36,63 @@ function BuildBadge({ text }: { text: string }) {
   )
 }
 
+type RedisStats = {
+  used_memory: number
+  max_memory: number
+  ops_per_sec: number
+  connected_clients: number
+  keys: number
+  evicted_keys: number
+}
+
+function formatMiB(bytes: number) {
+  return `${(bytes / 1024 / 1024).toFixed(1)} MiB`
+}
+
+function RedisCard({ owner, project }: { owner: string, project: string }) {
+  // the server is asked again every few seconds, nothing is kept in between
+  const { data: redis } = useSWR(
+    `${import.meta.env.VITE_API_URL}/project/${owner}/${project}/addons/redis/`,
+    apiFetcher,
+    { refreshInterval: 5000 }
+  )
+
+  if (!redis?.name) return null
+
+  const stats: RedisStats | null = redis.stats
+  return (
+    <div className="bg-slate-900 border p-8 rounded-lg space-y-4 border-slate-500">
+      <div className="space-y-1">
+        <h1 className="text-lg font-semibold">Redis</h1>
+        <h2 className="text-sm text-slate-400">{redis.name}, holds {redis.memory} MiB of keys</h2>
+      </div>
+
+      {stats ? (
+        <div className="grid grid-cols-2 md:grid-cols-4 gap-4 text-sm">
+          <div>
+            <p className="text-slate-400">Memory</p>
+            <p className="font-medium">{formatMiB(stats.used_memory)} of {formatMiB(stats.max_memory)}</p>
+          </div>
+          <div>
+            <p className="text-slate-400">Ops/sec</p>
+            <p className="font-medium">{stats.ops_per_sec}</p>
+          </div>
+          <div>
+            <p className="text-slate-400">Keys</p>
+            <p className="font-medium">{stats.keys} ({stats.evicted_keys} evicted)</p>
+          </div>
+          <div>
+            <p className="text-slate-400">Clients</p>
+            <p className="font-medium">{stats.connected_clients}</p>
+          </div>
+        </div>
+      ) : (
+        <p className="text-sm text-slate-400">The server isn't answering right now</p>
+      )}
+    </div>
+  )
+}
+
 function ProjectDashboardIndex() {
   // @ts-ignore
   const { owner, project } = useParams({ strict: false })
@@ -44,6 +102,8 @@ function ProjectDashboardIndex() {
 
   return (
     <div className="space-y-4">
+      <RedisCard owner={owner} project={project} />
+
       <div className="text-sm space-y-1">
         <h1 className="text-xl font-medium">Project Builds</h1>
         <p>List of all build logs of this project</p>
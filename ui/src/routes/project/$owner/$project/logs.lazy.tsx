@@ -1,5 +1,7 @@
 import { createLazyFileRoute, useParams } from '@tanstack/react-router'
+import { useState } from 'react'
 import useSWR from 'swr'
+import { useLiveUpdates } from '@/lib/live'
 
 const apiFetcher = (input: URL | RequestInfo, options?: RequestInit) => {
   return fetch(
@@ -18,17 +20,29 @@ const apiFetcher = (input: URL | RequestInfo, options?: RequestInit) => {
 function Logs() {
   // @ts-ignore
   const { owner, project } = useParams({ strict: false })
-  const { data } = useSWR(`${import.meta.env.VITE_API_URL}/project/${owner}/${project}/logs`, apiFetcher)
+  const { data, mutate } = useSWR(`${import.meta.env.VITE_API_URL}/project/${owner}/${project}/logs`, apiFetcher)
+  const [lines, setLines] = useState("")
+
+  // new output is appended as the containers write it, a resync starts over from the api
+  useLiveUpdates(owner, project, ["log"], (topic, update) => {
+    if (topic === "resync") {
+      setLines("")
+      mutate()
+      return
+    }
+    setLines(prev => prev + update.lines.map((line: { message: string }) => line.message + "\n").join(""))
+  })
 
   return (
     <div className="space-y-4">
       <div className="text-sm space-y-1">
         <h1 className="text-xl font-semibold">Project Logs</h1>
-        <p className="text-sm">Last 100 lines from your deployed project container</p>
+        <p className="text-sm">Last 100 lines from your deployed project container, new ones show up as they are written</p>
       </div>
       <div className="w-full p-8 bg-slate-900 rounded-lg max-h-96 overflow-y-auto overflow-x-hidden">
         <pre className="w-full space-x-4 whitespace-pre-wrap">
           {data?.logs}
+          {lines}
         </pre>
       </div>
     </div>